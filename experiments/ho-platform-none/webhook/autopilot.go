@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// autopilotGeneration names the Autopilot constraints of a range of GKE
+// versions
+type autopilotGeneration string
+
+const (
+	// generationClassic is Autopilot before pod bursting: requests are
+	// raised to 250m CPU and 512Mi memory, 500m CPU with pod anti-affinity,
+	// and limits are set to the requests
+	generationClassic autopilotGeneration = "classic"
+	// generationBurstable is Autopilot with pod bursting, from GKE 1.30:
+	// requests as low as 50m CPU and 52Mi memory, limits above requests
+	generationBurstable autopilotGeneration = "burstable"
+)
+
+// gkeVersion is the major.minor version of a GKE control plane or node
+type gkeVersion struct {
+	major, minor int
+}
+
+func (v gkeVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+func (v gkeVersion) less(o gkeVersion) bool {
+	return v.major < o.major || v.major == o.major && v.minor < o.minor
+}
+
+// versionPattern matches the major and minor of a Kubernetes version such as
+// v1.30.5-gke.1014001
+var versionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+func parseGKEVersion(s string) (gkeVersion, error) {
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return gkeVersion{}, fmt.Errorf("invalid version %q", s)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return gkeVersion{major: major, minor: minor}, nil
+}
+
+// autopilotConstraints are the resource constraints Autopilot enforces on the
+// pods of a generation. Resource patches are bounded by them, so Autopilot
+// does not rewrite the requests itself and start a mutation loop with
+// HyperShift.
+type autopilotConstraints struct {
+	generation autopilotGeneration
+	// since is the first GKE version of the generation
+	since               gkeVersion
+	minCPU              resource.Quantity
+	minMemory           resource.Quantity
+	minEphemeralStorage resource.Quantity
+	maxEphemeralStorage resource.Quantity
+	// antiAffinityMinCPU is the minimum CPU of the containers of pods with
+	// pod anti-affinity
+	antiAffinityMinCPU resource.Quantity
+	// cpuIncrement is the step CPU requests are rounded up to
+	cpuIncrement resource.Quantity
+	// minMemoryPerCPU and maxMemoryPerCPU bound the memory:CPU ratio of the
+	// requests, per vCPU
+	minMemoryPerCPU resource.Quantity
+	maxMemoryPerCPU resource.Quantity
+	// burstable generations honor limits above the requests
+	burstable bool
+}
+
+// autopilotConstraintTables are the constraints of every generation, oldest
+// first
+var autopilotConstraintTables = []autopilotConstraints{
+	{
+		generation:          generationClassic,
+		minCPU:              resource.MustParse("250m"),
+		minMemory:           resource.MustParse("512Mi"),
+		minEphemeralStorage: resource.MustParse("10Mi"),
+		maxEphemeralStorage: resource.MustParse("10Gi"),
+		antiAffinityMinCPU:  resource.MustParse("500m"),
+		cpuIncrement:        resource.MustParse("250m"),
+		minMemoryPerCPU:     resource.MustParse("1Gi"),
+		maxMemoryPerCPU:     resource.MustParse("6656Mi"),
+	},
+	{
+		generation:          generationBurstable,
+		since:               gkeVersion{major: 1, minor: 30},
+		minCPU:              resource.MustParse("50m"),
+		minMemory:           resource.MustParse("52Mi"),
+		minEphemeralStorage: resource.MustParse("10Mi"),
+		maxEphemeralStorage: resource.MustParse("10Gi"),
+		antiAffinityMinCPU:  resource.MustParse("50m"),
+		cpuIncrement:        resource.MustParse("50m"),
+		minMemoryPerCPU:     resource.MustParse("1Gi"),
+		maxMemoryPerCPU:     resource.MustParse("6656Mi"),
+		burstable:           true,
+	},
+}
+
+// constraintsForVersion returns the constraints of the generation of a GKE version
+func constraintsForVersion(v gkeVersion) autopilotConstraints {
+	selected := autopilotConstraintTables[0]
+	for _, table := range autopilotConstraintTables {
+		if !v.less(table.since) {
+			selected = table
+		}
+	}
+	return selected
+}
+
+// constraintsForGeneration returns the constraints of a generation
+func constraintsForGeneration(generation autopilotGeneration) (autopilotConstraints, bool) {
+	for _, table := range autopilotConstraintTables {
+		if table.generation == generation {
+			return table, true
+		}
+	}
+	return autopilotConstraints{}, false
+}
+
+// Bound raises the requests of the resources patches below the minimums of
+// the generation, and caps ephemeral storage at its maximum. CPU requests
+// are then rounded up to the increment of the generation, and the smaller of
+// CPU and memory is raised until their ratio is within the generation's,
+// both as Autopilot would otherwise do silently; every such adjustment is
+// logged. Limits below the bounded requests are
+// raised with them, and in generations without bursting every limit is set
+// to its request, as Autopilot would.
+func (c autopilotConstraints) Bound(kind, name string, patches []patchOperation, hasAntiAffinity bool) []patchOperation {
+	minCPU := c.minCPU
+	if hasAntiAffinity && c.antiAffinityMinCPU.Cmp(minCPU) > 0 {
+		minCPU = c.antiAffinityMinCPU
+	}
+
+	for i, patch := range patches {
+		if !resourcesPatchPath.MatchString(patch.Path) {
+			continue
+		}
+		resources, ok := patch.Value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		requests := copyResourceMap(resources["requests"])
+		limits := copyResourceMap(resources["limits"])
+
+		bound := func(name corev1.ResourceName, min, max *resource.Quantity) {
+			q, err := quantityOf(requests[string(name)])
+			if err != nil {
+				return
+			}
+			switch {
+			case min != nil && q.Cmp(*min) < 0:
+				requests[string(name)] = min.String()
+			case max != nil && q.Cmp(*max) > 0:
+				requests[string(name)] = max.String()
+			}
+			if limit, err := quantityOf(limits[string(name)]); err == nil && max != nil && limit.Cmp(*max) > 0 {
+				limits[string(name)] = max.String()
+			}
+		}
+		bound(corev1.ResourceCPU, &minCPU, nil)
+		bound(corev1.ResourceMemory, &c.minMemory, nil)
+		bound(corev1.ResourceEphemeralStorage, &c.minEphemeralStorage, &c.maxEphemeralStorage)
+		for _, adjustment := range c.roundRequests(requests) {
+			log.Printf("%s %s: %s: %s for %s Autopilot", kind, name, patch.Path, adjustment, c.generation)
+		}
+
+		for name, value := range requests {
+			request, err := quantityOf(value)
+			if err != nil {
+				continue
+			}
+			limit, err := quantityOf(limits[name])
+			if err != nil {
+				continue
+			}
+			if !c.burstable || limit.Cmp(request) < 0 {
+				limits[name] = request.String()
+			}
+		}
+
+		bounded := map[string]interface{}{}
+		for key, value := range resources {
+			bounded[key] = value
+		}
+		bounded["requests"] = requests
+		if len(limits) > 0 {
+			bounded["limits"] = limits
+		}
+		patches[i].Value = bounded
+	}
+	return patches
+}
+
+// Reasons of the request adjustments of roundRequests
+const (
+	adjustmentIncrement = "increment"
+	adjustmentRatio     = "ratio"
+)
+
+// roundRequests rounds the CPU request up to the increment of the generation
+// and raises the smaller of the CPU and memory requests until their ratio is
+// within the generation's, as Autopilot does. It returns the adjustments
+// made, for logging; requests without both CPU and memory are only rounded.
+func (c autopilotConstraints) roundRequests(requests map[string]interface{}) []string {
+	cpu, err := quantityOf(requests[string(corev1.ResourceCPU)])
+	if err != nil {
+		return nil
+	}
+	memory, err := quantityOf(requests[string(corev1.ResourceMemory)])
+	hasMemory := err == nil && !c.minMemoryPerCPU.IsZero() && !c.maxMemoryPerCPU.IsZero()
+
+	var adjustments []string
+	raise := func(name corev1.ResourceName, from, to *resource.Quantity, reason string) {
+		explanation := fmt.Sprintf("rounded up to %s increments", c.cpuIncrement.String())
+		if reason == adjustmentRatio {
+			explanation = fmt.Sprintf("keeping memory between %s and %s per vCPU", c.minMemoryPerCPU.String(), c.maxMemoryPerCPU.String())
+		}
+		adjustments = append(adjustments, fmt.Sprintf("raised the %s request from %s to %s, %s", name, from.String(), to.String(), explanation))
+		autopilotAdjustmentsTotal.WithLabelValues(string(name), reason).Inc()
+		requests[string(name)] = to.String()
+	}
+	// roundCPU rounds milli CPUs up to the increment
+	roundCPU := func(milli int64) int64 {
+		if increment := c.cpuIncrement.MilliValue(); increment > 0 {
+			return (milli + increment - 1) / increment * increment
+		}
+		return milli
+	}
+
+	if rounded := roundCPU(cpu.MilliValue()); rounded > cpu.MilliValue() {
+		q := resource.NewMilliQuantity(rounded, resource.DecimalSI)
+		raise(corev1.ResourceCPU, &cpu, q, adjustmentIncrement)
+		cpu = *q
+	}
+	if !hasMemory {
+		return adjustments
+	}
+
+	// Too much memory for the CPU: Autopilot raises the CPU
+	if maxPerCPU := c.maxMemoryPerCPU.Value(); memory.Value() > cpu.MilliValue()*maxPerCPU/1000 {
+		if milli := roundCPU((memory.Value()*1000 + maxPerCPU - 1) / maxPerCPU); milli > cpu.MilliValue() {
+			q := resource.NewMilliQuantity(milli, resource.DecimalSI)
+			raise(corev1.ResourceCPU, &cpu, q, adjustmentRatio)
+			cpu = *q
+		}
+	}
+	// Too little memory for the CPU: Autopilot raises the memory, here in
+	// whole Mi
+	const mi = 1 << 20
+	if minimum := (cpu.MilliValue()*c.minMemoryPerCPU.Value()/1000 + mi - 1) / mi * mi; memory.Value() < minimum {
+		raise(corev1.ResourceMemory, &memory, resource.NewQuantity(minimum, resource.BinarySI), adjustmentRatio)
+	}
+	return adjustments
+}
+
+// quantityOf parses a resource quantity of a resources patch, an error when
+// it is missing
+func quantityOf(value interface{}) (resource.Quantity, error) {
+	switch v := value.(type) {
+	case string:
+		return resource.ParseQuantity(v)
+	case resource.Quantity:
+		return v, nil
+	}
+	return resource.Quantity{}, fmt.Errorf("not a quantity: %v", value)
+}
+
+const defaultAutopilotVersionResync = 10 * time.Minute
+
+// autopilotVersions selects the constraint table of the GKE version the
+// cluster runs. While GKE rolls out an upgrade, the control plane and the
+// nodes run different versions; the oldest one is used until every node is
+// upgraded, so pods stay valid wherever they land.
+type autopilotVersions struct {
+	// target is the generation the webhook profile was written for
+	target autopilotConstraints
+	// client is nil when the version is not detected
+	client kubernetes.Interface
+	resync time.Duration
+
+	mu       sync.RWMutex
+	detected *autopilotConstraints
+	version  gkeVersion
+}
+
+// newAutopilotVersionsFromEnv builds the detector from AUTOPILOT_GENERATION,
+// the generation of the webhook profile (default burstable), and
+// AUTOPILOT_VERSION_RESYNC. Detection is off when AUTOPILOT_VERSION_DETECTION
+// is "false" or when not running inside a cluster, in which case the
+// constraints of AUTOPILOT_GENERATION are used.
+func newAutopilotVersionsFromEnv() (*autopilotVersions, error) {
+	generation := autopilotGeneration(envString("AUTOPILOT_GENERATION", string(generationBurstable)))
+	target, ok := constraintsForGeneration(generation)
+	if !ok {
+		return nil, fmt.Errorf("invalid AUTOPILOT_GENERATION %q: must be %s or %s", generation, generationClassic, generationBurstable)
+	}
+	resync, err := envDuration("AUTOPILOT_VERSION_RESYNC", defaultAutopilotVersionResync)
+	if err != nil {
+		return nil, err
+	}
+	if resync <= 0 {
+		return nil, fmt.Errorf("AUTOPILOT_VERSION_RESYNC must be positive")
+	}
+
+	versions := &autopilotVersions{target: target, resync: resync}
+	if os.Getenv("AUTOPILOT_VERSION_DETECTION") == "false" {
+		return versions, nil
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("GKE version detection disabled: %v", err)
+		return versions, nil
+	}
+	if versions.client, err = kubernetes.NewForConfig(config); err != nil {
+		return nil, fmt.Errorf("could not create client: %v", err)
+	}
+	return versions, nil
+}
+
+func (v *autopilotVersions) String() string {
+	if v.client == nil {
+		return fmt.Sprintf("%s constraints, GKE version not detected", v.target.generation)
+	}
+	return fmt.Sprintf("profile targets %s, detecting the GKE version every %s", v.target.generation, v.resync)
+}
+
+// Run detects the GKE version every resync interval until ctx is done
+func (v *autopilotVersions) Run(ctx context.Context) {
+	if v.client == nil {
+		return
+	}
+	ticker := time.NewTicker(v.resync)
+	defer ticker.Stop()
+
+	for {
+		if err := v.detect(ctx); err != nil {
+			log.Printf("Could not detect the GKE version: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// detect reads the version of the control plane and of the nodes, and
+// selects the constraints of the oldest
+func (v *autopilotVersions) detect(ctx context.Context) error {
+	info, err := v.client.Discovery().ServerVersion()
+	if err != nil {
+		return err
+	}
+	version, err := parseGKEVersion(info.GitVersion)
+	if err != nil {
+		return fmt.Errorf("control plane: %v", err)
+	}
+	source := "control plane " + info.GitVersion
+
+	// Autopilot nodes are upgraded after the control plane
+	nodes, err := v.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Could not list nodes, using the control plane version: %v", err)
+	} else {
+		for _, node := range nodes.Items {
+			nodeVersion, err := parseGKEVersion(node.Status.NodeInfo.KubeletVersion)
+			if err == nil && nodeVersion.less(version) {
+				version = nodeVersion
+				source = "node " + node.Name + " " + node.Status.NodeInfo.KubeletVersion
+			}
+		}
+	}
+
+	constraints := constraintsForVersion(version)
+	v.mu.Lock()
+	changed := v.detected == nil || v.detected.generation != constraints.generation || v.version != version
+	v.detected = &constraints
+	v.version = version
+	v.mu.Unlock()
+
+	for _, table := range autopilotConstraintTables {
+		value := 0.0
+		if table.generation == constraints.generation {
+			value = 1
+		}
+		autopilotGenerationInfo.WithLabelValues(string(table.generation)).Set(value)
+	}
+	mismatch := constraints.generation != v.target.generation
+	if mismatch {
+		autopilotGenerationMismatch.Set(1)
+	} else {
+		autopilotGenerationMismatch.Set(0)
+	}
+
+	if changed {
+		log.Printf("GKE version %s (oldest is %s): using %s Autopilot constraints", version, source, constraints.generation)
+		if mismatch {
+			log.Printf("WARNING: the webhook profile targets %s Autopilot but the cluster runs %s, resource patches are bounded by the %s constraints; review the static requests and COMPONENT_OVERRIDES_FILE for this generation",
+				v.target.generation, constraints.generation, constraints.generation)
+		}
+	}
+	return nil
+}
+
+// Constraints returns the constraints of the detected GKE version, or of the
+// profile's generation until it is detected
+func (v *autopilotVersions) Constraints() autopilotConstraints {
+	if v == nil {
+		constraints, _ := constraintsForGeneration(generationBurstable)
+		return constraints
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if v.detected != nil {
+		return *v.detected
+	}
+	return v.target
+}
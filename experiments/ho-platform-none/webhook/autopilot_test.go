@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConstraintsForVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    autopilotGeneration
+	}{
+		{"v1.27.16-gke.1287000", generationClassic},
+		{"v1.29.8-gke.1211000", generationClassic},
+		{"v1.30.5-gke.1014001", generationBurstable},
+		{"1.31.1-gke.1146000", generationBurstable},
+		{"v2.0.0", generationBurstable},
+	} {
+		v, err := parseGKEVersion(tc.version)
+		if err != nil {
+			t.Fatalf("parseGKEVersion(%q): %v", tc.version, err)
+		}
+		if got := constraintsForVersion(v).generation; got != tc.want {
+			t.Errorf("constraintsForVersion(%s) = %s, want %s", tc.version, got, tc.want)
+		}
+	}
+
+	if _, err := parseGKEVersion("latest"); err == nil {
+		t.Error("parseGKEVersion(latest) succeeded")
+	}
+}
+
+func TestAutopilotConstraints_Bound(t *testing.T) {
+	resources := func() []patchOperation {
+		return []patchOperation{
+			{Op: "add", Path: "/spec/template/spec/securityContext", Value: map[string]interface{}{"runAsNonRoot": true}},
+			{Op: "replace", Path: "/spec/template/spec/containers/0/resources", Value: map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "50m", "memory": "300Mi", "ephemeral-storage": "20Gi"},
+				"limits":   map[string]interface{}{"memory": "1Gi", "ephemeral-storage": "20Gi"},
+			}},
+		}
+	}
+	request := func(patches []patchOperation, kind, name string) interface{} {
+		return patches[1].Value.(map[string]interface{})[kind].(map[string]interface{})[name]
+	}
+
+	classic, _ := constraintsForGeneration(generationClassic)
+	burstable, _ := constraintsForGeneration(generationBurstable)
+	for _, tc := range []struct {
+		name         string
+		constraints  autopilotConstraints
+		antiAffinity bool
+		wantCPU      string
+		wantMemory   string
+		wantLimit    string
+	}{
+		{"burstable keeps small requests", burstable, false, "50m", "300Mi", "1Gi"},
+		{"classic raises requests", classic, false, "250m", "512Mi", "512Mi"},
+		{"classic anti-affinity minimum", classic, true, "500m", "512Mi", "512Mi"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			patches := tc.constraints.Bound("Deployment", "test", resources(), tc.antiAffinity)
+			if got := request(patches, "requests", "cpu"); got != tc.wantCPU {
+				t.Errorf("cpu request = %v, want %s", got, tc.wantCPU)
+			}
+			if got := request(patches, "requests", "memory"); got != tc.wantMemory {
+				t.Errorf("memory request = %v, want %s", got, tc.wantMemory)
+			}
+			if got := request(patches, "limits", "memory"); got != tc.wantLimit {
+				t.Errorf("memory limit = %v, want %s", got, tc.wantLimit)
+			}
+			if got := request(patches, "requests", "ephemeral-storage"); got != "10Gi" {
+				t.Errorf("ephemeral-storage request = %v, want the 10Gi maximum", got)
+			}
+			if got := request(patches, "limits", "ephemeral-storage"); got != "10Gi" {
+				t.Errorf("ephemeral-storage limit = %v, want it lowered with the request", got)
+			}
+			if _, ok := patches[0].Value.(map[string]interface{})["requests"]; ok {
+				t.Error("bounded a patch that is not a resources patch")
+			}
+		})
+	}
+}
+
+func TestAutopilotConstraints_BoundRatios(t *testing.T) {
+	classic, _ := constraintsForGeneration(generationClassic)
+	burstable, _ := constraintsForGeneration(generationBurstable)
+	for _, tc := range []struct {
+		name        string
+		constraints autopilotConstraints
+		requests    map[string]interface{}
+		limits      map[string]interface{}
+		wantCPU     string
+		wantMemory  string
+		// wantCPULimit is the CPU limit after bounding, empty without one
+		wantCPULimit string
+		wantAdjusted map[string]float64
+	}{
+		{
+			name:        "burstable rounds cpu to 50m",
+			constraints: burstable,
+			requests:    map[string]interface{}{"cpu": "130m", "memory": "256Mi"},
+			wantCPU:     "150m", wantMemory: "256Mi",
+			wantAdjusted: map[string]float64{"cpu/increment": 1},
+		},
+		{
+			name:        "classic rounds cpu to 250m",
+			constraints: classic,
+			requests:    map[string]interface{}{"cpu": "300m", "memory": "1Gi"},
+			limits:      map[string]interface{}{"cpu": "300m"},
+			wantCPU:     "500m", wantMemory: "1Gi", wantCPULimit: "500m",
+			wantAdjusted: map[string]float64{"cpu/increment": 1},
+		},
+		{
+			name:        "memory raised to 1Gi per vCPU",
+			constraints: burstable,
+			requests:    map[string]interface{}{"cpu": "2", "memory": "1Gi"},
+			limits:      map[string]interface{}{"cpu": "4"},
+			wantCPU:     "2", wantMemory: "2Gi", wantCPULimit: "4",
+			wantAdjusted: map[string]float64{"memory/ratio": 1},
+		},
+		{
+			name:        "cpu raised to 6.5Gi per vCPU",
+			constraints: burstable,
+			requests:    map[string]interface{}{"cpu": "100m", "memory": "4Gi"},
+			limits:      map[string]interface{}{"cpu": "200m"},
+			wantCPU:     "650m", wantMemory: "4Gi", wantCPULimit: "650m",
+			wantAdjusted: map[string]float64{"cpu/ratio": 1},
+		},
+		{
+			name:        "rounding then ratio",
+			constraints: classic,
+			requests:    map[string]interface{}{"cpu": "900m", "memory": "768Mi"},
+			wantCPU:     "1", wantMemory: "1Gi",
+			wantAdjusted: map[string]float64{"cpu/increment": 1, "memory/ratio": 1},
+		},
+		{
+			name:        "within the ratio",
+			constraints: burstable,
+			requests:    map[string]interface{}{"cpu": "500m", "memory": "2Gi"},
+			wantCPU:     "500m", wantMemory: "2Gi",
+		},
+		{
+			name:         "cpu only",
+			constraints:  burstable,
+			requests:     map[string]interface{}{"cpu": "120m"},
+			wantCPU:      "150m",
+			wantAdjusted: map[string]float64{"cpu/increment": 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			autopilotAdjustmentsTotal.Reset()
+			resources := map[string]interface{}{"requests": tc.requests}
+			if tc.limits != nil {
+				resources["limits"] = tc.limits
+			}
+			patches := tc.constraints.Bound("Deployment", "test", []patchOperation{
+				{Op: "replace", Path: "/spec/template/spec/containers/0/resources", Value: resources},
+			}, false)
+
+			bounded := patches[0].Value.(map[string]interface{})
+			requests := bounded["requests"].(map[string]interface{})
+			if got := requests["cpu"]; got != tc.wantCPU {
+				t.Errorf("cpu request = %v, want %s", got, tc.wantCPU)
+			}
+			if tc.wantMemory != "" && requests["memory"] != tc.wantMemory {
+				t.Errorf("memory request = %v, want %s", requests["memory"], tc.wantMemory)
+			}
+			if tc.wantCPULimit != "" {
+				if got := bounded["limits"].(map[string]interface{})["cpu"]; got != tc.wantCPULimit {
+					t.Errorf("cpu limit = %v, want %s", got, tc.wantCPULimit)
+				}
+			}
+			for _, series := range []string{"cpu/increment", "cpu/ratio", "memory/ratio"} {
+				resourceName, reason, _ := strings.Cut(series, "/")
+				if got := testutil.ToFloat64(autopilotAdjustmentsTotal.WithLabelValues(resourceName, reason)); got != tc.wantAdjusted[series] {
+					t.Errorf("adjustments{%s} = %v, want %v", series, got, tc.wantAdjusted[series])
+				}
+			}
+		})
+	}
+}
+
+func TestAutopilotVersions_Detect(t *testing.T) {
+	node := func(name, kubelet string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: kubelet}},
+		}
+	}
+	burstable, _ := constraintsForGeneration(generationBurstable)
+
+	for _, tc := range []struct {
+		name         string
+		controlPlane string
+		nodes        []*corev1.Node
+		want         autopilotGeneration
+		wantMismatch float64
+	}{
+		{"upgraded", "v1.30.5-gke.1014001", []*corev1.Node{node("a", "v1.30.5-gke.1014001")}, generationBurstable, 0},
+		{"nodes still upgrading", "v1.30.5-gke.1014001", []*corev1.Node{node("a", "v1.30.5-gke.1014001"), node("b", "v1.29.8-gke.1211000")}, generationClassic, 1},
+		{"older cluster", "v1.29.8-gke.1211000", nil, generationClassic, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			for _, n := range tc.nodes {
+				client.Tracker().Add(n)
+			}
+			client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: tc.controlPlane}
+
+			versions := &autopilotVersions{target: burstable, client: client}
+			if got := versions.Constraints().generation; got != generationBurstable {
+				t.Errorf("before detection: %s, want the profile's %s", got, generationBurstable)
+			}
+			if err := versions.detect(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := versions.Constraints().generation; got != tc.want {
+				t.Errorf("detected %s, want %s", got, tc.want)
+			}
+			if got := testutil.ToFloat64(autopilotGenerationMismatch); got != tc.wantMismatch {
+				t.Errorf("mismatch gauge = %v, want %v", got, tc.wantMismatch)
+			}
+		})
+	}
+}
+
+func TestAutopilotVersions_Nil(t *testing.T) {
+	var versions *autopilotVersions
+	if got := versions.Constraints().generation; got != generationBurstable {
+		t.Errorf("Constraints() = %s, want %s", got, generationBurstable)
+	}
+}
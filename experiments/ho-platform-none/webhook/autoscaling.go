@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	autoscalingv2ac "k8s.io/client-go/applyconfigurations/autoscaling/v2"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+	policyv1ac "k8s.io/client-go/applyconfigurations/policy/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultAutoscalingResync = 5 * time.Minute
+
+	// autoscalingRetry is how soon a Deployment admitted on creation is
+	// looked up again when it is not stored yet
+	autoscalingRetry = 10 * time.Second
+
+	defaultTargetCPUUtilization = 70
+)
+
+// managedByLabel marks the HPAs and PDBs the webhook created, the only ones
+// it updates or deletes
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+// scalingPolicy is the HorizontalPodAutoscaler and PodDisruptionBudget the
+// webhook maintains for a Deployment it mutates, so right-sized control
+// plane components also scale out under load
+type scalingPolicy struct {
+	MinReplicas int32 `json:"minReplicas"`
+	MaxReplicas int32 `json:"maxReplicas"`
+	// TargetCPUUtilization is the average CPU usage the HPA scales to, in
+	// percent of the requests the webhook sets (default 70)
+	TargetCPUUtilization int32 `json:"targetCPUUtilization,omitempty"`
+	// MaxUnavailable is the number or percentage of pods the PDB lets
+	// Autopilot evict at once (default 1). A PDB allowing no disruption
+	// blocks node upgrades, so 0 is rejected.
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// scalingPolicies maps a component (Deployment name) to its scaling policy
+type scalingPolicies map[string]scalingPolicy
+
+// validate checks the bounds of every policy
+func (p scalingPolicies) validate() error {
+	for component, policy := range p {
+		if policy.MinReplicas < 1 {
+			return fmt.Errorf("%s: minReplicas must be at least 1", component)
+		}
+		if policy.MaxReplicas < policy.MinReplicas {
+			return fmt.Errorf("%s: maxReplicas %d is below minReplicas %d", component, policy.MaxReplicas, policy.MinReplicas)
+		}
+		if policy.TargetCPUUtilization < 0 || policy.TargetCPUUtilization > 100 {
+			return fmt.Errorf("%s: targetCPUUtilization must be between 1 and 100", component)
+		}
+		if mu := policy.MaxUnavailable; mu != nil {
+			n, percent := mu.IntValue(), false
+			if mu.Type == intstr.String {
+				value, found := strings.CutSuffix(mu.StrVal, "%")
+				var err error
+				if n, err = strconv.Atoi(value); !found || err != nil {
+					return fmt.Errorf("%s: maxUnavailable %q must be a number or a percentage", component, mu.StrVal)
+				}
+				percent = true
+			}
+			if n < 1 || (percent && n > 100) {
+				return fmt.Errorf("%s: maxUnavailable %s must allow at least one disruption, or Autopilot cannot drain nodes", component, mu)
+			}
+		}
+	}
+	return nil
+}
+
+// merge adds the policies of other, replacing those of the same components
+func (p scalingPolicies) merge(other scalingPolicies) {
+	maps.Copy(p, other)
+}
+
+// String lists the components with a policy, for the startup log
+func (p scalingPolicies) String() string {
+	var entries []string
+	for component, policy := range p {
+		entries = append(entries, fmt.Sprintf("%s=%d-%d", component, policy.MinReplicas, policy.MaxReplicas))
+	}
+	if len(entries) == 0 {
+		return "none"
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ", ")
+}
+
+func (p scalingPolicy) targetCPUUtilization() int32 {
+	if p.TargetCPUUtilization == 0 {
+		return defaultTargetCPUUtilization
+	}
+	return p.TargetCPUUtilization
+}
+
+func (p scalingPolicy) maxUnavailable() intstr.IntOrString {
+	if p.MaxUnavailable == nil {
+		return intstr.FromInt32(1)
+	}
+	return *p.MaxUnavailable
+}
+
+// parseScalingPolicies reads policies from YAML or JSON, rejecting unknown
+// fields like parseComponentOverrides
+func parseScalingPolicies(data []byte) (scalingPolicies, error) {
+	var policies scalingPolicies
+	if err := yaml.UnmarshalStrict(data, &policies); err != nil {
+		return nil, err
+	}
+	if err := policies.validate(); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// scalingTarget is a Deployment admitted with a scaling policy
+type scalingTarget struct {
+	policy   scalingPolicy
+	admitted time.Time
+}
+
+// autoscalingManager maintains an HPA and a PDB for the Deployments the
+// webhook admitted with a scaling policy. Admissions only record the
+// Deployment; the objects are server-side applied from Run, owned by the
+// Deployment so they go away with it.
+type autoscalingManager struct {
+	client kubernetes.Interface
+	// defaults are the policies of AUTOSCALING_FILE, which
+	// AutopilotMutationProfiles add to
+	defaults scalingPolicies
+	resync   time.Duration
+
+	mu      sync.Mutex
+	targets map[types.NamespacedName]scalingTarget
+	// removed are Deployments admitted without a policy, whose HPA and PDB
+	// are deleted if the webhook created them
+	removed map[types.NamespacedName]bool
+	changed chan struct{}
+}
+
+// newAutoscalingManagerFromEnv builds the manager from AUTOSCALING_FILE, a
+// YAML or JSON file of component policies:
+//
+//	kube-apiserver:
+//	  minReplicas: 3
+//	  maxReplicas: 6
+//	  targetCPUUtilization: 70
+//	  maxUnavailable: 1
+//
+// and AUTOSCALING_RESYNC. It returns nil when AUTOSCALING is "false" or when
+// not running inside a cluster.
+func newAutoscalingManagerFromEnv() (*autoscalingManager, error) {
+	if os.Getenv("AUTOSCALING") == "false" {
+		return nil, nil
+	}
+	defaults := scalingPolicies{}
+	if path := os.Getenv("AUTOSCALING_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read AUTOSCALING_FILE: %v", err)
+		}
+		fromFile, err := parseScalingPolicies(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTOSCALING_FILE %s: %v", path, err)
+		}
+		defaults.merge(fromFile)
+	}
+	resync, err := envDuration("AUTOSCALING_RESYNC", defaultAutoscalingResync)
+	if err != nil {
+		return nil, err
+	}
+	if resync <= 0 {
+		return nil, fmt.Errorf("AUTOSCALING_RESYNC must be positive")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("HPA and PDB generation disabled: %v", err)
+		return nil, nil
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create client: %v", err)
+	}
+	return newAutoscalingManager(clientset, defaults, resync), nil
+}
+
+func newAutoscalingManager(client kubernetes.Interface, defaults scalingPolicies, resync time.Duration) *autoscalingManager {
+	return &autoscalingManager{
+		client:   client,
+		defaults: defaults,
+		resync:   resync,
+		targets:  map[types.NamespacedName]scalingTarget{},
+		removed:  map[types.NamespacedName]bool{},
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// Policies returns the policies of the webhook configuration
+func (m *autoscalingManager) Policies() scalingPolicies {
+	if m == nil {
+		return nil
+	}
+	return m.defaults
+}
+
+// Admit records a Deployment under admission for the next ensure and
+// returns the patches keeping its replicas within the bounds of its policy.
+// On updates the replicas of the stored Deployment are kept, so HyperShift
+// reconciling its own replica count does not undo the scaling of the HPA.
+func (m *autoscalingManager) Admit(req *admissionv1.AdmissionRequest, deployment *appsv1.Deployment, policies scalingPolicies) []patchOperation {
+	if m == nil || deployment.Name == "" {
+		return nil
+	}
+	key := types.NamespacedName{Namespace: req.Namespace, Name: deployment.Name}
+	policy, ok := policies[deployment.Name]
+	if !ok || deployment.Spec.Selector == nil {
+		m.forget(key)
+		return nil
+	}
+	m.track(key, policy)
+
+	current := int32(1)
+	if deployment.Spec.Replicas != nil {
+		current = *deployment.Spec.Replicas
+	}
+	replicas := current
+	if req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		var old appsv1.Deployment
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err == nil && old.Spec.Replicas != nil {
+			replicas = *old.Spec.Replicas
+		}
+	}
+	replicas = max(policy.MinReplicas, min(replicas, policy.MaxReplicas))
+	if deployment.Spec.Replicas != nil && replicas == current {
+		return nil
+	}
+	return []patchOperation{{Op: "add", Path: "/spec/replicas", Value: replicas}}
+}
+
+func (m *autoscalingManager) track(key types.NamespacedName, policy scalingPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.removed, key)
+	if existing, ok := m.targets[key]; ok && equalScalingPolicies(existing.policy, policy) {
+		return
+	}
+	m.targets[key] = scalingTarget{policy: policy, admitted: time.Now()}
+	autoscaledDeployments.Set(float64(len(m.targets)))
+	m.notify()
+}
+
+func (m *autoscalingManager) forget(key types.NamespacedName) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.targets, key)
+	autoscaledDeployments.Set(float64(len(m.targets)))
+	m.removed[key] = true
+	m.notify()
+}
+
+// notify wakes Run up without blocking the admission; m.mu must be held
+func (m *autoscalingManager) notify() {
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+func equalScalingPolicies(a, b scalingPolicy) bool {
+	return a.MinReplicas == b.MinReplicas && a.MaxReplicas == b.MaxReplicas &&
+		a.targetCPUUtilization() == b.targetCPUUtilization() && a.maxUnavailable() == b.maxUnavailable()
+}
+
+// Run applies the HPAs and PDBs of the recorded Deployments after every
+// admission changing them and every resync interval, so deleted or edited
+// objects come back, until ctx is done
+func (m *autoscalingManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.resync)
+	defer ticker.Stop()
+
+	for {
+		var retry <-chan time.Time
+		if pending := m.ensure(ctx); pending {
+			retry = time.After(autoscalingRetry)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.changed:
+		case <-retry:
+		}
+	}
+}
+
+// ensure applies the objects of every recorded Deployment and deletes those
+// of the forgotten ones. It reports whether a Deployment admitted recently
+// was not found, to be retried shortly.
+func (m *autoscalingManager) ensure(ctx context.Context) (pending bool) {
+	m.mu.Lock()
+	targets := maps.Clone(m.targets)
+	removed := m.removed
+	m.removed = map[types.NamespacedName]bool{}
+	m.mu.Unlock()
+
+	for key := range removed {
+		m.remove(ctx, key)
+	}
+
+	for key, target := range targets {
+		deployment, err := m.client.AppsV1().Deployments(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// A Deployment admitted on creation is stored after the admission
+			if time.Since(target.admitted) < m.resync {
+				pending = true
+				continue
+			}
+			m.mu.Lock()
+			if current, ok := m.targets[key]; ok && current.admitted.Equal(target.admitted) {
+				delete(m.targets, key)
+				autoscaledDeployments.Set(float64(len(m.targets)))
+			}
+			m.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			log.Printf("Could not read Deployment %s for its HPA and PDB: %v", key, err)
+			continue
+		}
+		if err := m.applyHPA(ctx, deployment, target.policy); err != nil {
+			log.Printf("Could not apply the HorizontalPodAutoscaler of %s: %v", key, err)
+		}
+		if err := m.applyPDB(ctx, deployment, target.policy); err != nil {
+			log.Printf("Could not apply the PodDisruptionBudget of %s: %v", key, err)
+		}
+	}
+	return pending
+}
+
+// managedObjectMeta returns the labels and owner of the objects of a
+// Deployment
+func managedObjectMeta(deployment *appsv1.Deployment) (map[string]string, *metav1ac.OwnerReferenceApplyConfiguration) {
+	owner := metav1ac.OwnerReference().
+		WithAPIVersion("apps/v1").
+		WithKind("Deployment").
+		WithName(deployment.Name).
+		WithUID(deployment.UID)
+	return map[string]string{managedByLabel: eventComponent}, owner
+}
+
+// applyHPA scales the Deployment on the CPU utilization of its pods. An HPA
+// of the same name the webhook did not create is left alone.
+func (m *autoscalingManager) applyHPA(ctx context.Context, deployment *appsv1.Deployment, policy scalingPolicy) error {
+	hpas := m.client.AutoscalingV2().HorizontalPodAutoscalers(deployment.Namespace)
+	existing, err := hpas.Get(ctx, deployment.Name, metav1.GetOptions{})
+	if err == nil && existing.Labels[managedByLabel] != eventComponent {
+		log.Printf("HorizontalPodAutoscaler %s/%s is not managed by the webhook, leaving it", deployment.Namespace, deployment.Name)
+		return nil
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	objectLabels, owner := managedObjectMeta(deployment)
+	hpa := autoscalingv2ac.HorizontalPodAutoscaler(deployment.Name, deployment.Namespace).
+		WithLabels(objectLabels).
+		WithOwnerReferences(owner).
+		WithSpec(autoscalingv2ac.HorizontalPodAutoscalerSpec().
+			WithScaleTargetRef(autoscalingv2ac.CrossVersionObjectReference().
+				WithAPIVersion("apps/v1").
+				WithKind("Deployment").
+				WithName(deployment.Name)).
+			WithMinReplicas(policy.MinReplicas).
+			WithMaxReplicas(policy.MaxReplicas).
+			WithMetrics(autoscalingv2ac.MetricSpec().
+				WithType(autoscalingv2.ResourceMetricSourceType).
+				WithResource(autoscalingv2ac.ResourceMetricSource().
+					WithName(corev1.ResourceCPU).
+					WithTarget(autoscalingv2ac.MetricTarget().
+						WithType(autoscalingv2.UtilizationMetricType).
+						WithAverageUtilization(policy.targetCPUUtilization())))))
+	_, err = hpas.Apply(ctx, hpa, metav1.ApplyOptions{FieldManager: eventComponent, Force: true})
+	return err
+}
+
+// applyPDB limits the pods of the Deployment Autopilot evicts at once. The
+// budget always allows a disruption and lets unhealthy pods be evicted, so
+// it never blocks a node upgrade. Pods covered by another PDB, e.g. one
+// HyperShift creates, get none: evictions fail for pods with several.
+func (m *autoscalingManager) applyPDB(ctx context.Context, deployment *appsv1.Deployment, policy scalingPolicy) error {
+	pdbs := m.client.PolicyV1().PodDisruptionBudgets(deployment.Namespace)
+	list, err := pdbs.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	podLabels := labels.Set(deployment.Spec.Template.Labels)
+	for _, pdb := range list.Items {
+		if pdb.Labels[managedByLabel] == eventComponent && pdb.Name == deployment.Name {
+			continue
+		}
+		if pdb.Name == deployment.Name || selects(&pdb, podLabels) {
+			log.Printf("Pods of Deployment %s/%s are covered by PodDisruptionBudget %s, not creating one", deployment.Namespace, deployment.Name, pdb.Name)
+			return nil
+		}
+	}
+
+	selector := metav1ac.LabelSelector().WithMatchLabels(deployment.Spec.Selector.MatchLabels)
+	for _, r := range deployment.Spec.Selector.MatchExpressions {
+		selector.WithMatchExpressions(metav1ac.LabelSelectorRequirement().
+			WithKey(r.Key).
+			WithOperator(r.Operator).
+			WithValues(r.Values...))
+	}
+	objectLabels, owner := managedObjectMeta(deployment)
+	pdb := policyv1ac.PodDisruptionBudget(deployment.Name, deployment.Namespace).
+		WithLabels(objectLabels).
+		WithOwnerReferences(owner).
+		WithSpec(policyv1ac.PodDisruptionBudgetSpec().
+			WithSelector(selector).
+			WithMaxUnavailable(policy.maxUnavailable()).
+			WithUnhealthyPodEvictionPolicy(policyv1.AlwaysAllow))
+	_, err = pdbs.Apply(ctx, pdb, metav1.ApplyOptions{FieldManager: eventComponent, Force: true})
+	return err
+}
+
+// selects tells whether a PDB covers pods with the labels
+func selects(pdb *policyv1.PodDisruptionBudget, podLabels labels.Set) bool {
+	if pdb.Spec.Selector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	return err == nil && !selector.Empty() && selector.Matches(podLabels)
+}
+
+// remove deletes the HPA and PDB of a Deployment if the webhook created them
+func (m *autoscalingManager) remove(ctx context.Context, key types.NamespacedName) {
+	hpas := m.client.AutoscalingV2().HorizontalPodAutoscalers(key.Namespace)
+	if hpa, err := hpas.Get(ctx, key.Name, metav1.GetOptions{}); err == nil && hpa.Labels[managedByLabel] == eventComponent {
+		if err := hpas.Delete(ctx, key.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Could not delete the HorizontalPodAutoscaler of %s: %v", key, err)
+		} else {
+			log.Printf("Deleted the HorizontalPodAutoscaler of %s, which has no scaling policy anymore", key)
+		}
+	}
+	pdbs := m.client.PolicyV1().PodDisruptionBudgets(key.Namespace)
+	if pdb, err := pdbs.Get(ctx, key.Name, metav1.GetOptions{}); err == nil && pdb.Labels[managedByLabel] == eventComponent {
+		if err := pdbs.Delete(ctx, key.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Could not delete the PodDisruptionBudget of %s: %v", key, err)
+		} else {
+			log.Printf("Deleted the PodDisruptionBudget of %s, which has no scaling policy anymore", key)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseScalingPolicies(t *testing.T) {
+	policies, err := parseScalingPolicies([]byte(`
+kube-apiserver:
+  minReplicas: 3
+  maxReplicas: 6
+  maxUnavailable: 34%
+openshift-apiserver:
+  minReplicas: 2
+  maxReplicas: 4
+  targetCPUUtilization: 80
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := policies.String(); got != "kube-apiserver=3-6, openshift-apiserver=2-4" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := policies["kube-apiserver"].maxUnavailable(); got != intstr.FromString("34%") {
+		t.Errorf("kube-apiserver maxUnavailable = %s, want 34%%", got.String())
+	}
+	if got := policies["openshift-apiserver"].maxUnavailable(); got != intstr.FromInt32(1) {
+		t.Errorf("openshift-apiserver maxUnavailable = %s, want the default 1", got.String())
+	}
+	if got := policies["kube-apiserver"].targetCPUUtilization(); got != defaultTargetCPUUtilization {
+		t.Errorf("kube-apiserver targetCPUUtilization = %d, want the default", got)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "no replicas", data: "etcd: {maxReplicas: 3}", wantErr: "minReplicas must be at least 1"},
+		{name: "max below min", data: "etcd: {minReplicas: 3, maxReplicas: 2}", wantErr: "below minReplicas"},
+		{name: "target above 100", data: "etcd: {minReplicas: 1, maxReplicas: 2, targetCPUUtilization: 120}", wantErr: "between 1 and 100"},
+		{name: "no disruption", data: "etcd: {minReplicas: 1, maxReplicas: 2, maxUnavailable: 0}", wantErr: "at least one disruption"},
+		{name: "no disruption in percent", data: `etcd: {minReplicas: 1, maxReplicas: 2, maxUnavailable: "0%"}`, wantErr: "at least one disruption"},
+		{name: "not a percentage", data: "etcd: {minReplicas: 1, maxReplicas: 2, maxUnavailable: half}", wantErr: "number or a percentage"},
+		{name: "unknown field", data: "etcd: {minReplicas: 1, maxReplicas: 2, minAvailable: 1}", wantErr: "unknown field"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseScalingPolicies([]byte(tc.data)); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// admissionOf returns the admission request of a Deployment, an update of
+// old when it is not nil
+func admissionOf(t *testing.T, deployment, old *appsv1.Deployment) *admissionv1.AdmissionRequest {
+	t.Helper()
+	req := &admissionv1.AdmissionRequest{Namespace: "clusters-test", Operation: admissionv1.Create}
+	raw, err := json.Marshal(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Object = runtime.RawExtension{Raw: raw}
+	if old != nil {
+		if req.OldObject.Raw, err = json.Marshal(old); err != nil {
+			t.Fatal(err)
+		}
+		req.Operation = admissionv1.Update
+	}
+	return req
+}
+
+func TestAutoscalingManager_Admit(t *testing.T) {
+	policies := scalingPolicies{"kube-apiserver": {MinReplicas: 3, MaxReplicas: 6}}
+	withReplicas := func(replicas int32) *appsv1.Deployment {
+		d := kubeAPIServerDeployment()
+		d.Spec.Replicas = int32Ptr(replicas)
+		return d
+	}
+
+	for _, tc := range []struct {
+		name       string
+		deployment *appsv1.Deployment
+		old        *appsv1.Deployment
+		want       int32
+	}{
+		{name: "within bounds", deployment: withReplicas(3)},
+		{name: "below the minimum", deployment: withReplicas(2), want: 3},
+		{name: "above the maximum", deployment: withReplicas(8), want: 6},
+		{name: "update keeps the replicas of the HPA", deployment: withReplicas(3), old: withReplicas(5), want: 5},
+		{name: "update within the bounds", deployment: withReplicas(3), old: withReplicas(9), want: 6},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newAutoscalingManager(fake.NewClientset(), nil, time.Minute)
+			patches := m.Admit(admissionOf(t, tc.deployment, tc.old), tc.deployment, policies)
+			if tc.want == 0 {
+				if len(patches) != 0 {
+					t.Errorf("patches = %+v, want none", patches)
+				}
+			} else if len(patches) != 1 || patches[0].Path != "/spec/replicas" || patches[0].Value != tc.want {
+				t.Errorf("patches = %+v, want replicas set to %d", patches, tc.want)
+			}
+			if _, ok := m.targets[types.NamespacedName{Namespace: "clusters-test", Name: "kube-apiserver"}]; !ok {
+				t.Error("kube-apiserver not recorded for its HPA and PDB")
+			}
+		})
+	}
+
+	// Without a policy the Deployment is left alone and its objects removed
+	m := newAutoscalingManager(fake.NewClientset(), nil, time.Minute)
+	deployment := kubeAPIServerDeployment()
+	if patches := m.Admit(admissionOf(t, deployment, nil), deployment, nil); len(patches) != 0 {
+		t.Errorf("patches = %+v, want none without a policy", patches)
+	}
+	if !m.removed[types.NamespacedName{Namespace: "clusters-test", Name: "kube-apiserver"}] || len(m.targets) != 0 {
+		t.Errorf("targets = %v, removed = %v, want kube-apiserver removed", m.targets, m.removed)
+	}
+}
+
+func TestAutoscalingManager_Ensure(t *testing.T) {
+	deployment := kubeAPIServerDeployment()
+	deployment.UID = "kas-uid"
+	// HyperShift's own budget covers openshift-apiserver
+	oas := kubeAPIServerDeployment()
+	oas.Name = "openshift-apiserver"
+	oas.Spec.Template.Labels = map[string]string{"app": "openshift-apiserver"}
+	hypershiftPDB := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "openshift-apiserver-pdb", Namespace: "clusters-test"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "openshift-apiserver"}},
+		},
+	}
+	client := fake.NewClientset(deployment, oas, hypershiftPDB)
+	m := newAutoscalingManager(client, nil, time.Minute)
+	policy := scalingPolicy{MinReplicas: 3, MaxReplicas: 6, TargetCPUUtilization: 80}
+	m.track(types.NamespacedName{Namespace: "clusters-test", Name: "kube-apiserver"}, policy)
+	m.track(types.NamespacedName{Namespace: "clusters-test", Name: "openshift-apiserver"}, policy)
+	// Admitted on creation and not stored yet
+	m.track(types.NamespacedName{Namespace: "clusters-test", Name: "kube-scheduler"}, policy)
+
+	ctx := context.Background()
+	if pending := m.ensure(ctx); !pending {
+		t.Error("ensure() = false, want kube-scheduler retried")
+	}
+
+	hpa, err := client.AutoscalingV2().HorizontalPodAutoscalers("clusters-test").Get(ctx, "kube-apiserver", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *hpa.Spec.MinReplicas != 3 || hpa.Spec.MaxReplicas != 6 || hpa.Spec.ScaleTargetRef.Name != "kube-apiserver" {
+		t.Errorf("HPA spec = %+v", hpa.Spec)
+	}
+	if got := *hpa.Spec.Metrics[0].Resource.Target.AverageUtilization; got != 80 {
+		t.Errorf("HPA target utilization = %d, want 80", got)
+	}
+	if len(hpa.OwnerReferences) != 1 || hpa.OwnerReferences[0].UID != "kas-uid" {
+		t.Errorf("HPA owners = %+v, want the Deployment", hpa.OwnerReferences)
+	}
+
+	pdb, err := client.PolicyV1().PodDisruptionBudgets("clusters-test").Get(ctx, "kube-apiserver", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *pdb.Spec.MaxUnavailable != intstr.FromInt32(1) || *pdb.Spec.UnhealthyPodEvictionPolicy != policyv1.AlwaysAllow {
+		t.Errorf("PDB spec = %+v, want one disruption and unhealthy pods evictable", pdb.Spec)
+	}
+	if pdb.Labels[managedByLabel] != eventComponent {
+		t.Errorf("PDB labels = %v, want managed by the webhook", pdb.Labels)
+	}
+
+	if _, err := client.PolicyV1().PodDisruptionBudgets("clusters-test").Get(ctx, "openshift-apiserver", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("openshift-apiserver PDB error = %v, want none next to HyperShift's", err)
+	}
+	if _, err := client.AutoscalingV2().HorizontalPodAutoscalers("clusters-test").Get(ctx, "openshift-apiserver", metav1.GetOptions{}); err != nil {
+		t.Errorf("openshift-apiserver HPA: %v", err)
+	}
+
+	// A policy dropped from the profile removes the objects
+	m.forget(types.NamespacedName{Namespace: "clusters-test", Name: "kube-apiserver"})
+	m.ensure(ctx)
+	if _, err := client.AutoscalingV2().HorizontalPodAutoscalers("clusters-test").Get(ctx, "kube-apiserver", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("HPA error after removal = %v, want not found", err)
+	}
+	if _, err := client.PolicyV1().PodDisruptionBudgets("clusters-test").Get(ctx, "kube-apiserver", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("PDB error after removal = %v, want not found", err)
+	}
+	if _, err := client.PolicyV1().PodDisruptionBudgets("clusters-test").Get(ctx, "openshift-apiserver-pdb", metav1.GetOptions{}); err != nil {
+		t.Errorf("HyperShift's PDB: %v, want it kept", err)
+	}
+}
+
+func TestMutationProfile_Autoscaling(t *testing.T) {
+	defaults := scalingPolicies{
+		"kube-apiserver":      {MinReplicas: 3, MaxReplicas: 6},
+		"openshift-apiserver": {MinReplicas: 2, MaxReplicas: 3},
+	}
+	tenant := mutationProfileOf("clusters-test", "tenant", AutopilotMutationProfileSpec{
+		Autoscaling: scalingPolicies{"kube-apiserver": {MinReplicas: 4, MaxReplicas: 8}},
+	})
+	ws := &WebhookServer{
+		autoscaling: newAutoscalingManager(fake.NewClientset(), defaults, time.Minute),
+		profiles:    profileResolver(profileNamespace("tenant"), tenant),
+	}
+	profile := ws.profile("clusters-test")
+	if got := profile.scaling["kube-apiserver"].MaxReplicas; got != 8 {
+		t.Errorf("kube-apiserver maxReplicas = %d, want the 8 of the profile", got)
+	}
+	if got := profile.scaling["openshift-apiserver"].MaxReplicas; got != 3 {
+		t.Errorf("openshift-apiserver maxReplicas = %d, want the 3 of the webhook", got)
+	}
+	if got := defaults["kube-apiserver"].MaxReplicas; got != 6 {
+		t.Errorf("default kube-apiserver maxReplicas = %d, the profile changed the defaults", got)
+	}
+
+	tenant.Spec.OptOut.Mutations = []string{mutationAutoscaling}
+	ws.profiles = profileResolver(profileNamespace("tenant"), tenant)
+	if profile := ws.profile("clusters-test"); profile.scaling != nil {
+		t.Errorf("scaling = %v, want none when opted out", profile.scaling)
+	}
+}
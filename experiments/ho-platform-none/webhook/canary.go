@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Mutation tracks: the stable profile, and the next profile being canaried
+const (
+	trackStable = "stable"
+	trackNext   = "next"
+)
+
+// mutationTrackLabel is stamped on the pod template of mutated workloads with
+// their track while a canary is configured, so pod metrics (restarts, OOM
+// kills, evictions) can be compared by track
+const mutationTrackLabel = eventComponent + "/mutation-track"
+
+// mutationProfile is what the resource-sizing mutations of a track depend on
+type mutationProfile struct {
+	track      string
+	overrides  componentOverrides
+	rightSizer *rightSizer
+	// scaling are the scaling policies of the Deployments
+	scaling scalingPolicies
+	// imageRewrites move the images of the workloads to mirrors
+	imageRewrites imageRewriteRules
+	// custom is the AutopilotMutationProfile of the namespace, nil without
+	custom *AutopilotMutationProfile
+}
+
+// mutationCanary applies the next profile to a percentage of the hosted
+// control plane namespaces and the stable profile to the others. A namespace
+// is assigned by a hash of its name, so all components of a hosted control
+// plane are on the same track, every replica of the webhook agrees on it,
+// and raising the percentage only moves namespaces from stable to next.
+type mutationCanary struct {
+	percent int
+	stable  mutationProfile
+	next    mutationProfile
+	// overridesFile is the file of the next overrides, for the startup log
+	overridesFile string
+}
+
+// newMutationCanaryFromEnv builds the canary from CANARY_* environment
+// variables. The next profile is the stable one with the overrides of
+// CANARY_COMPONENT_OVERRIDES_FILE merged in, like COMPONENT_OVERRIDES_FILE,
+// and CANARY_RIGHTSIZING_HEADROOM as right-sizing headroom. CANARY_PERCENT
+// (0-100) of the namespaces get it. It returns nil when neither is set.
+func newMutationCanaryFromEnv(overrides componentOverrides, sizer *rightSizer) (*mutationCanary, error) {
+	percent, err := envInt("CANARY_PERCENT", 0)
+	if err != nil {
+		return nil, err
+	}
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("CANARY_PERCENT must be between 0 and 100")
+	}
+
+	path := os.Getenv("CANARY_COMPONENT_OVERRIDES_FILE")
+	headroom := os.Getenv("CANARY_RIGHTSIZING_HEADROOM")
+	if path == "" && headroom == "" {
+		if percent > 0 {
+			return nil, fmt.Errorf("CANARY_PERCENT needs CANARY_COMPONENT_OVERRIDES_FILE or CANARY_RIGHTSIZING_HEADROOM")
+		}
+		return nil, nil
+	}
+
+	next := mutationProfile{track: trackNext, overrides: componentOverrides{}, rightSizer: sizer}
+	next.overrides.merge(overrides)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CANARY_COMPONENT_OVERRIDES_FILE: %v", err)
+		}
+		fromFile, err := parseComponentOverrides(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CANARY_COMPONENT_OVERRIDES_FILE %s: %v", path, err)
+		}
+		next.overrides.merge(fromFile)
+	}
+	if headroom != "" {
+		if sizer == nil {
+			return nil, fmt.Errorf("CANARY_RIGHTSIZING_HEADROOM needs RIGHTSIZING_SOURCE")
+		}
+		h, err := envInt("CANARY_RIGHTSIZING_HEADROOM", 0)
+		if err != nil {
+			return nil, err
+		}
+		if h < 0 {
+			return nil, fmt.Errorf("CANARY_RIGHTSIZING_HEADROOM must not be negative")
+		}
+		next.rightSizer = &rightSizer{source: sizer.source, headroom: h}
+	}
+
+	return &mutationCanary{
+		percent:       percent,
+		stable:        mutationProfile{track: trackStable, overrides: overrides, rightSizer: sizer},
+		next:          next,
+		overridesFile: path,
+	}, nil
+}
+
+// Profile returns the profile of the workloads of namespace
+func (c *mutationCanary) Profile(namespace string) mutationProfile {
+	if canaryBucket(namespace) < c.percent {
+		return c.next
+	}
+	return c.stable
+}
+
+// canaryBucket places a namespace in one of 100 buckets
+func canaryBucket(namespace string) int {
+	h := fnv.New32a()
+	h.Write([]byte(namespace))
+	return int(h.Sum32() % 100)
+}
+
+// String describes the canary for the startup log
+func (c *mutationCanary) String() string {
+	var changes []string
+	if c.overridesFile != "" {
+		changes = append(changes, "overrides from "+c.overridesFile)
+	}
+	if c.next.rightSizer != c.stable.rightSizer {
+		changes = append(changes, fmt.Sprintf("%d%% right-sizing headroom", c.next.rightSizer.headroom))
+	}
+	return fmt.Sprintf("%d%% of namespaces on the next profile (%s)", c.percent, strings.Join(changes, ", "))
+}
+
+// profile returns the mutation profile of the workloads of namespace: the
+// stable one unless a canary puts the namespace on the next one, adjusted by
+// the AutopilotMutationProfile the namespace refers to
+func (ws *WebhookServer) profile(namespace string) mutationProfile {
+	profile := mutationProfile{track: trackStable, overrides: ws.overrides, rightSizer: ws.rightSizer}
+	if ws.canary != nil {
+		profile = ws.canary.Profile(namespace)
+	}
+	profile.scaling = ws.autoscaling.Policies()
+	profile.imageRewrites = ws.imageRewrites
+	return ws.withCustomProfile(namespace, profile)
+}
+
+// trackPatches stamps the track of a canary on a pod template and counts the
+// mutation in its track. Without a canary the template is left alone, so
+// enabling the feature is what rolls the workloads out.
+func (ws *WebhookServer) trackPatches(kind string, template *corev1.PodTemplateSpec, track string) []patchOperation {
+	if ws.canary == nil {
+		return nil
+	}
+	canaryMutationsTotal.WithLabelValues(kind, track).Inc()
+
+	if template.Labels == nil {
+		return []patchOperation{{Op: "add", Path: "/spec/template/metadata/labels", Value: map[string]string{mutationTrackLabel: track}}}
+	}
+	key := strings.ReplaceAll(strings.ReplaceAll(mutationTrackLabel, "~", "~0"), "/", "~1")
+	return []patchOperation{{Op: "add", Path: "/spec/template/metadata/labels/" + key, Value: track}}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestMutationCanary_FromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "next.yaml")
+	if err := os.WriteFile(path, []byte(`
+kube-apiserver:
+  kube-apiserver:
+    resources:
+      requests: {memory: 1Gi}
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CANARY_PERCENT", "10")
+	t.Setenv("CANARY_COMPONENT_OVERRIDES_FILE", path)
+
+	canary, err := newMutationCanaryFromEnv(defaultComponentOverrides, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canary.percent != 10 {
+		t.Errorf("percent = %d, want 10", canary.percent)
+	}
+	if got := canary.next.overrides["kube-apiserver"]["kube-apiserver"].Resources.Requests; got.Memory().Cmp(resource.MustParse("1Gi")) != 0 {
+		t.Errorf("next kube-apiserver memory = %s, want the 1Gi of the file", got.Memory())
+	}
+	if got := canary.stable.overrides["kube-apiserver"]["kube-apiserver"].Resources.Requests; got.Memory().Cmp(resource.MustParse("512Mi")) != 0 {
+		t.Errorf("stable kube-apiserver memory = %s, want the default 512Mi", got.Memory())
+	}
+	if _, ok := canary.next.overrides["ignition-server"]; !ok {
+		t.Error("next profile lost the stable overrides of the other components")
+	}
+}
+
+func TestMutationCanary_FromEnvInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+	}{
+		{"percent above 100", map[string]string{"CANARY_PERCENT": "101", "CANARY_RIGHTSIZING_HEADROOM": "10"}},
+		{"negative percent", map[string]string{"CANARY_PERCENT": "-1", "CANARY_RIGHTSIZING_HEADROOM": "10"}},
+		{"percent without next profile", map[string]string{"CANARY_PERCENT": "5"}},
+		{"headroom without right-sizing", map[string]string{"CANARY_PERCENT": "5", "CANARY_RIGHTSIZING_HEADROOM": "10"}},
+		{"missing overrides file", map[string]string{"CANARY_PERCENT": "5", "CANARY_COMPONENT_OVERRIDES_FILE": "/nonexistent"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			if _, err := newMutationCanaryFromEnv(defaultComponentOverrides, nil); err == nil {
+				t.Error("want an error")
+			}
+		})
+	}
+}
+
+func TestMutationCanary_Disabled(t *testing.T) {
+	canary, err := newMutationCanaryFromEnv(defaultComponentOverrides, nil)
+	if err != nil || canary != nil {
+		t.Fatalf("newMutationCanaryFromEnv() = %v, %v, want no canary", canary, err)
+	}
+
+	template := admitTemplate(t, &WebhookServer{overrides: defaultComponentOverrides}, kubeAPIServerDeployment(), "Deployment")
+	if _, ok := template.Labels[mutationTrackLabel]; ok {
+		t.Errorf("labels = %v, want no track without a canary", template.Labels)
+	}
+}
+
+func TestMutationCanary_Tracks(t *testing.T) {
+	var namespaces []string
+	for i := 0; i < 1000; i++ {
+		namespaces = append(namespaces, fmt.Sprintf("clusters-hcp-%d", i))
+	}
+	onNext := func(percent int) map[string]bool {
+		c := &mutationCanary{percent: percent, stable: mutationProfile{track: trackStable}, next: mutationProfile{track: trackNext}}
+		next := map[string]bool{}
+		for _, ns := range namespaces {
+			if c.Profile(ns).track == trackNext {
+				next[ns] = true
+			}
+		}
+		return next
+	}
+
+	if n := len(onNext(0)); n != 0 {
+		t.Errorf("0%%: %d namespaces on next, want none", n)
+	}
+	if n := len(onNext(100)); n != len(namespaces) {
+		t.Errorf("100%%: %d namespaces on next, want all %d", n, len(namespaces))
+	}
+	ten := onNext(10)
+	if n := len(ten); n < 50 || n > 150 {
+		t.Errorf("10%%: %d of %d namespaces on next, want about 100", n, len(namespaces))
+	}
+	// Raising the percentage keeps the namespaces already on next
+	for ns := range ten {
+		if !onNext(20)[ns] {
+			t.Errorf("%s moved back to stable when raising the canary from 10%% to 20%%", ns)
+		}
+	}
+}
+
+func TestMutationCanary_Admit(t *testing.T) {
+	next := componentOverrides{}
+	next.merge(defaultComponentOverrides)
+	next.merge(componentOverrides{"kube-apiserver": {"kube-apiserver": {Resources: &corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+	}}}})
+
+	for _, tc := range []struct {
+		percent int
+		track   string
+		memory  string
+	}{
+		{0, trackStable, "512Mi"},
+		{100, trackNext, "1Gi"},
+	} {
+		ws := &WebhookServer{overrides: defaultComponentOverrides, canary: &mutationCanary{
+			percent: tc.percent,
+			stable:  mutationProfile{track: trackStable, overrides: defaultComponentOverrides},
+			next:    mutationProfile{track: trackNext, overrides: next},
+		}}
+		template := admitTemplate(t, ws, kubeAPIServerDeployment(), "Deployment")
+
+		if got := template.Labels[mutationTrackLabel]; got != tc.track {
+			t.Errorf("%d%%: track label = %q, want %q", tc.percent, got, tc.track)
+		}
+		if got := template.Labels["app"]; got != "kube-apiserver" {
+			t.Errorf("%d%%: app label = %q, want the labels of the template kept", tc.percent, got)
+		}
+		for _, c := range template.Spec.Containers {
+			if c.Name != "kube-apiserver" {
+				continue
+			}
+			if got := c.Resources.Requests[corev1.ResourceMemory]; got.Cmp(resource.MustParse(tc.memory)) != 0 {
+				t.Errorf("%d%%: kube-apiserver memory request = %s, want %s", tc.percent, got.String(), tc.memory)
+			}
+		}
+	}
+}
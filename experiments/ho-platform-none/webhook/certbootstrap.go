@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+)
+
+// Defaults for the certificate bootstrap mode. They match the names used in
+// webhook-deployment.yaml.
+const (
+	defaultBootstrapServiceName = "hypershift-autopilot-webhook"
+	defaultBootstrapSecretName  = "hypershift-autopilot-webhook-certs"
+	defaultBootstrapWebhookName = "hypershift-gke-autopilot-webhook"
+	defaultBootstrapResync      = time.Minute
+
+	// Lifetimes of the generated certificates. The serving certificate is
+	// regenerated once less than bootstrapRenewBefore is left.
+	bootstrapCAValidity      = 10 * 365 * 24 * time.Hour
+	bootstrapServingValidity = 365 * 24 * time.Hour
+	bootstrapRenewBefore     = 30 * 24 * time.Hour
+
+	// caCertKey is the Secret key holding the CA that signed tls.crt
+	caCertKey = "ca.crt"
+
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// certBootstrapper generates a self-signed CA and serving certificate for the
+// webhook, keeps them in a Secret shared by all replicas and patches the CA
+// into the MutatingWebhookConfiguration, so no external certificate tooling
+// is needed on dev clusters. The Secret is the source of truth: every replica
+// re-reads it each resync, serves its certificate and patches its CA, so
+// replicas converge on the same certificate once one of them renews it.
+type certBootstrapper struct {
+	client      kubernetes.Interface
+	namespace   string
+	serviceName string
+	secretName  string
+	webhookName string
+	resync      time.Duration
+	now         func() time.Time
+
+	mu sync.RWMutex
+	// current is the certificate served, the one last read from the Secret
+	current *tls.Certificate
+}
+
+// newCertBootstrapperFromEnv builds the bootstrapper from CERT_BOOTSTRAP_*
+// environment variables. It returns nil when CERT_BOOTSTRAP is not "true", in
+// which case the certificate is read from /etc/certs.
+func newCertBootstrapperFromEnv() (*certBootstrapper, error) {
+	if os.Getenv("CERT_BOOTSTRAP") != "true" {
+		return nil, nil
+	}
+
+	resync, err := envDuration("CERT_BOOTSTRAP_RESYNC", defaultBootstrapResync)
+	if err != nil {
+		return nil, err
+	}
+	if resync <= 0 {
+		return nil, fmt.Errorf("CERT_BOOTSTRAP_RESYNC must be positive")
+	}
+
+	namespace, err := podNamespace()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("certificate bootstrap needs to run inside a cluster: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create client: %v", err)
+	}
+
+	return &certBootstrapper{
+		client:      clientset,
+		namespace:   namespace,
+		serviceName: envString("CERT_BOOTSTRAP_SERVICE", defaultBootstrapServiceName),
+		secretName:  envString("CERT_BOOTSTRAP_SECRET", defaultBootstrapSecretName),
+		webhookName: envString("CERT_BOOTSTRAP_WEBHOOK_CONFIG", defaultBootstrapWebhookName),
+		resync:      resync,
+		now:         time.Now,
+	}, nil
+}
+
+// podNamespace returns the namespace the webhook runs in, from POD_NAMESPACE
+// or the mounted service account
+func podNamespace() (string, error) {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace, nil
+	}
+	data, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("could not determine the webhook namespace, set POD_NAMESPACE: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Certificate returns the serving certificate and its CA, generating them
+// and storing them in the Secret if the Secret holds no usable certificate,
+// and serves the certificate from then on. Replicas renewing at the same time
+// agree on whichever certificate is stored first.
+func (b *certBootstrapper) Certificate(ctx context.Context) (tls.Certificate, []byte, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		secret, err := b.client.CoreV1().Secrets(b.namespace).Get(ctx, b.secretName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return tls.Certificate{}, nil, fmt.Errorf("could not read Secret %s/%s: %v", b.namespace, b.secretName, err)
+		}
+
+		exists := err == nil
+		if exists {
+			cert, caPEM, reason := b.usableCertificate(secret)
+			if reason == "" {
+				if b.serve(cert) {
+					log.Printf("Using serving certificate from Secret %s/%s", b.namespace, b.secretName)
+				}
+				return cert, caPEM, nil
+			}
+			log.Printf("Regenerating serving certificate in Secret %s/%s: %s", b.namespace, b.secretName, reason)
+		}
+
+		data, err := b.generate()
+		if err != nil {
+			return tls.Certificate{}, nil, err
+		}
+
+		if exists {
+			secret.Data = data
+			_, err = b.client.CoreV1().Secrets(b.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		} else {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: b.secretName, Namespace: b.namespace},
+				Type:       corev1.SecretTypeTLS,
+				Data:       data,
+			}
+			_, err = b.client.CoreV1().Secrets(b.namespace).Create(ctx, secret, metav1.CreateOptions{})
+		}
+		if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+			// Another replica stored a certificate first: use that one
+			continue
+		}
+		if err != nil {
+			return tls.Certificate{}, nil, fmt.Errorf("could not store Secret %s/%s: %v", b.namespace, b.secretName, err)
+		}
+
+		cert, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return tls.Certificate{}, nil, err
+		}
+		log.Printf("Stored new serving certificate for %s in Secret %s/%s", b.dnsNames()[0], b.namespace, b.secretName)
+		b.serve(cert)
+		return cert, data[caCertKey], nil
+	}
+	return tls.Certificate{}, nil, fmt.Errorf("could not store Secret %s/%s: too many conflicts", b.namespace, b.secretName)
+}
+
+// serve makes cert the served certificate, reporting whether it changed
+func (b *certBootstrapper) serve(cert tls.Certificate) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current != nil && bytes.Equal(b.current.Certificate[0], cert.Certificate[0]) {
+		return false
+	}
+	b.current = &cert
+	return true
+}
+
+// GetCertificate returns the served certificate, for tls.Config, so a
+// certificate renewed in the Secret is served without a restart
+func (b *certBootstrapper) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.current == nil {
+		return nil, fmt.Errorf("no serving certificate loaded from Secret %s/%s", b.namespace, b.secretName)
+	}
+	return b.current, nil
+}
+
+// usableCertificate returns the certificate of the Secret, or the reason it
+// cannot be used
+func (b *certBootstrapper) usableCertificate(secret *corev1.Secret) (tls.Certificate, []byte, string) {
+	caPEM := secret.Data[caCertKey]
+	if len(secret.Data[corev1.TLSCertKey]) == 0 || len(caPEM) == 0 {
+		return tls.Certificate{}, nil, "no certificate"
+	}
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Sprintf("invalid key pair: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Sprintf("invalid certificate: %v", err)
+	}
+
+	if b.now().Add(bootstrapRenewBefore).After(leaf.NotAfter) {
+		return tls.Certificate{}, nil, fmt.Sprintf("expires on %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	if err := leaf.VerifyHostname(b.dnsNames()[0]); err != nil {
+		return tls.Certificate{}, nil, fmt.Sprintf("not valid for the service: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return tls.Certificate{}, nil, "invalid CA"
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: b.now()}); err != nil {
+		return tls.Certificate{}, nil, fmt.Sprintf("not signed by %s: %v", caCertKey, err)
+	}
+	return cert, caPEM, ""
+}
+
+// dnsNames are the names the API server may use to reach the webhook service
+func (b *certBootstrapper) dnsNames() []string {
+	return []string{
+		fmt.Sprintf("%s.%s.svc", b.serviceName, b.namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", b.serviceName, b.namespace),
+		fmt.Sprintf("%s.%s", b.serviceName, b.namespace),
+		b.serviceName,
+	}
+}
+
+// generate creates a self-signed CA and a serving certificate it signs, as
+// the data of a kubernetes.io/tls Secret
+func (b *certBootstrapper) generate() (map[string][]byte, error) {
+	now := b.now()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: eventComponent + "-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(bootstrapCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not create CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate serving key: %v", err)
+	}
+	dnsNames := b.dnsNames()
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(bootstrapServingValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not create serving certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		caCertKey:               pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		corev1.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return serial
+}
+
+// EnsureCABundle sets caBundle of every webhook of the
+// MutatingWebhookConfiguration to the CA. It reports whether anything changed.
+func (b *certBootstrapper) EnsureCABundle(ctx context.Context, caPEM []byte) (bool, error) {
+	changed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configs := b.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+		config, err := configs.Get(ctx, b.webhookName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		changed = false
+		for i := range config.Webhooks {
+			if !bytes.Equal(config.Webhooks[i].ClientConfig.CABundle, caPEM) {
+				config.Webhooks[i].ClientConfig.CABundle = caPEM
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		_, err = configs.Update(ctx, config, metav1.UpdateOptions{})
+		return err
+	})
+	return changed, err
+}
+
+// Run reloads the certificate from the Secret, renewing it when it expires
+// soon, and keeps the caBundle of the MutatingWebhookConfiguration in sync
+// with its CA until ctx is done. The CA is re-read every resync rather than
+// kept from startup, so replicas never patch back the CA of a certificate
+// another replica renewed, and the configuration may be created or re-applied
+// after the webhook has started.
+func (b *certBootstrapper) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.resync)
+	defer ticker.Stop()
+
+	for {
+		b.sync(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync is one resync of Run
+func (b *certBootstrapper) sync(ctx context.Context) {
+	_, caPEM, err := b.Certificate(ctx)
+	if err != nil {
+		// Keep serving the certificate loaded last
+		log.Printf("Could not reload serving certificate: %v", err)
+		return
+	}
+
+	changed, err := b.EnsureCABundle(ctx, caPEM)
+	switch {
+	case apierrors.IsNotFound(err):
+		log.Printf("MutatingWebhookConfiguration %s not found, will retry in %s", b.webhookName, b.resync)
+	case err != nil:
+		log.Printf("Could not patch caBundle of MutatingWebhookConfiguration %s: %v", b.webhookName, err)
+	case changed:
+		log.Printf("Patched caBundle of MutatingWebhookConfiguration %s", b.webhookName)
+	}
+}
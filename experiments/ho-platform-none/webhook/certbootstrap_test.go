@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var bootstrapNow = time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+func newTestBootstrapper(client *fake.Clientset) *certBootstrapper {
+	return &certBootstrapper{
+		client:      client,
+		namespace:   "hypershift",
+		serviceName: defaultBootstrapServiceName,
+		secretName:  defaultBootstrapSecretName,
+		webhookName: defaultBootstrapWebhookName,
+		resync:      time.Minute,
+		now:         func() time.Time { return bootstrapNow },
+	}
+}
+
+func bootstrapSecret(t *testing.T, client *fake.Clientset) *corev1.Secret {
+	t.Helper()
+	secret, err := client.CoreV1().Secrets("hypershift").Get(context.Background(), defaultBootstrapSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// served returns the leaf of the certificate b serves
+func served(t *testing.T, b *certBootstrapper) *x509.Certificate {
+	t.Helper()
+	cert, err := b.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+func TestCertBootstrap_Create(t *testing.T) {
+	quietLogs(t)
+	client := fake.NewSimpleClientset()
+	b := newTestBootstrapper(client)
+	if _, err := b.GetCertificate(nil); err == nil {
+		t.Error("GetCertificate() succeeded before a certificate was loaded")
+	}
+
+	_, caPEM, err := b.Certificate(context.Background())
+	if err != nil {
+		t.Fatalf("Certificate() error = %v", err)
+	}
+	secret := bootstrapSecret(t, client)
+	if secret.Type != corev1.SecretTypeTLS || !bytes.Equal(secret.Data[caCertKey], caPEM) {
+		t.Errorf("Secret = %s with CA %q, want a TLS Secret with the returned CA", secret.Type, secret.Data[caCertKey])
+	}
+	leaf := served(t, b)
+	if err := leaf.VerifyHostname("hypershift-autopilot-webhook.hypershift.svc"); err != nil {
+		t.Error(err)
+	}
+	if want := bootstrapNow.Add(bootstrapServingValidity); !leaf.NotAfter.Equal(want) {
+		t.Errorf("NotAfter = %s, want %s", leaf.NotAfter, want)
+	}
+
+	// Another replica reuses the stored certificate
+	other := newTestBootstrapper(client)
+	if _, _, err := other.Certificate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !served(t, other).Equal(leaf) {
+		t.Error("second replica generated its own certificate")
+	}
+}
+
+func TestCertBootstrap_CreateConflict(t *testing.T) {
+	quietLogs(t)
+	client := fake.NewSimpleClientset()
+	// Another replica stores its certificate between our read and create.
+	// Reactors run under the lock of the fake, so it goes to the tracker.
+	data, err := newTestBootstrapper(client).generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raced := false
+	client.PrependReactor("create", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if raced {
+			return false, nil, nil
+		}
+		raced = true
+		stored := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: defaultBootstrapSecretName, Namespace: "hypershift"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		}
+		if err := client.Tracker().Add(stored); err != nil {
+			t.Error(err)
+		}
+		return true, nil, apierrors.NewAlreadyExists(corev1.Resource("secrets"), defaultBootstrapSecretName)
+	})
+
+	b := newTestBootstrapper(client)
+	if _, _, err := b.Certificate(context.Background()); err != nil {
+		t.Fatalf("Certificate() error = %v", err)
+	}
+	if !bytes.Equal(bootstrapSecret(t, client).Data[corev1.TLSCertKey], data[corev1.TLSCertKey]) {
+		t.Error("replica losing the race replaced the stored certificate")
+	}
+	if cert, _ := b.GetCertificate(nil); !bytes.Equal(pemCertificate(cert.Certificate[0]), data[corev1.TLSCertKey]) {
+		t.Error("replica losing the race serves its own certificate, want the stored one")
+	}
+}
+
+func TestCertBootstrap_Regenerate(t *testing.T) {
+	quietLogs(t)
+	client := fake.NewSimpleClientset()
+	b := newTestBootstrapper(client)
+	// Stored 340 days ago, less than bootstrapRenewBefore left
+	b.now = func() time.Time { return bootstrapNow.Add(-340 * 24 * time.Hour) }
+	_, oldCA, err := b.Certificate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := served(t, b)
+
+	b.now = func() time.Time { return bootstrapNow }
+	_, newCA, err := b.Certificate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if served(t, b).Equal(old) || bytes.Equal(oldCA, newCA) {
+		t.Error("expiring certificate was not regenerated")
+	}
+	if !bytes.Equal(bootstrapSecret(t, client).Data[caCertKey], newCA) {
+		t.Error("regenerated certificate not stored in the Secret")
+	}
+
+	// A conflicting update reads the certificate of the replica that won
+	b.now = func() time.Time { return bootstrapNow.Add(340 * 24 * time.Hour) }
+	other := newTestBootstrapper(client)
+	other.now = b.now
+	winner, err := other.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicted := false
+	client.PrependReactor("update", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if conflicted {
+			return false, nil, nil
+		}
+		conflicted = true
+		stored := bootstrapSecretFrom(t, client)
+		stored.Data = winner
+		if err := client.Tracker().Update(corev1.SchemeGroupVersion.WithResource("secrets"), stored, "hypershift"); err != nil {
+			t.Error(err)
+		}
+		return true, nil, apierrors.NewConflict(corev1.Resource("secrets"), defaultBootstrapSecretName, nil)
+	})
+	if _, _, err := b.Certificate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ := b.GetCertificate(nil); !bytes.Equal(pemCertificate(cert.Certificate[0]), winner[corev1.TLSCertKey]) {
+		t.Error("replica losing the renewal serves its own certificate, want the stored one")
+	}
+}
+
+// bootstrapSecretFrom reads the Secret from the tracker, for reactors
+func bootstrapSecretFrom(t *testing.T, client *fake.Clientset) *corev1.Secret {
+	obj, err := client.Tracker().Get(corev1.SchemeGroupVersion.WithResource("secrets"), "hypershift", defaultBootstrapSecretName)
+	if err != nil {
+		t.Error(err)
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: defaultBootstrapSecretName, Namespace: "hypershift"}}
+	}
+	return obj.(*corev1.Secret).DeepCopy()
+}
+
+func pemCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCertBootstrap_SyncCABundle(t *testing.T) {
+	quietLogs(t)
+	config := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultBootstrapWebhookName},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "mutate.autopilot.hypershift.openshift.io"},
+			{Name: "routes.autopilot.hypershift.openshift.io", ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: []byte("stale")}},
+		},
+	}
+	client := fake.NewSimpleClientset(config)
+	caBundles := func() [][]byte {
+		config, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), defaultBootstrapWebhookName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bundles [][]byte
+		for _, w := range config.Webhooks {
+			bundles = append(bundles, w.ClientConfig.CABundle)
+		}
+		return bundles
+	}
+
+	a, b := newTestBootstrapper(client), newTestBootstrapper(client)
+	a.sync(context.Background())
+	b.sync(context.Background())
+	ca := bootstrapSecret(t, client).Data[caCertKey]
+	for i, bundle := range caBundles() {
+		if !bytes.Equal(bundle, ca) {
+			t.Errorf("webhook %d caBundle = %q, want the CA of the Secret", i, bundle)
+		}
+	}
+
+	// b renews the certificate: a serves it and patches its CA on its next
+	// resync instead of the CA it started with
+	b.now = func() time.Time { return bootstrapNow.Add(340 * 24 * time.Hour) }
+	a.now = b.now
+	b.sync(context.Background())
+	renewed := bootstrapSecret(t, client).Data[caCertKey]
+	if bytes.Equal(renewed, ca) {
+		t.Fatal("certificate was not renewed")
+	}
+	for i := 0; i < 2; i++ {
+		a.sync(context.Background())
+		b.sync(context.Background())
+		for _, bundle := range caBundles() {
+			if !bytes.Equal(bundle, renewed) {
+				t.Fatalf("resync %d: caBundle is not the renewed CA, replicas disagree", i)
+			}
+		}
+	}
+	if !served(t, a).Equal(served(t, b)) {
+		t.Error("replicas serve different certificates after the renewal")
+	}
+}
@@ -241,7 +241,28 @@ webhooks:
     apiVersions: ["v1"]
     resources: ["pods"]
   admissionReviewVersions: ["v1", "v1beta1"]
-  sideEffects: None
+  # Host port remapping creates Services, skipped for dry-run requests
+  sideEffects: NoneOnDryRun
+  failurePolicy: Ignore
+  namespaceSelector:
+    matchLabels:
+      hypershift.openshift.io/hosted-control-plane: "true"
+- name: hypershift-autopilot-routes.example.com
+  clientConfig:
+    service:
+      name: hypershift-autopilot-webhook
+      namespace: hypershift-webhooks
+      path: "/mutate"
+    caBundle: $CA_BUNDLE
+  rules:
+  - operations: ["CREATE", "UPDATE", "DELETE"]
+    apiGroups: ["route.openshift.io"]
+    apiVersions: ["v1"]
+    resources: ["routes"]
+  admissionReviewVersions: ["v1", "v1beta1"]
+  # Routes are recorded and translated into Gateway API objects in the
+  # background, skipped for dry-run requests
+  sideEffects: NoneOnDryRun
   failurePolicy: Ignore
   namespaceSelector:
     matchLabels:
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+const eventComponent = "hypershift-autopilot-webhook"
+
+// newEventRecorder returns a recorder that posts Events using the in-cluster
+// service account. It returns nil when not running inside a cluster so the
+// webhook still works (without Events) when started locally.
+func newEventRecorder() record.EventRecorder {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("Kubernetes Events disabled: %v", err)
+		return nil
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Printf("Kubernetes Events disabled: could not create client: %v", err)
+		return nil
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(clientgoscheme.Scheme, corev1.EventSource{Component: eventComponent})
+}
+
+// admissionObjectReference builds a reference to the object under admission so
+// Events show up in `kubectl describe` for that object, which selects them by
+// UID. On CREATE the object has no UID yet: those Events are only listed by
+// `kubectl get events --field-selector involvedObject.name=<name>`.
+func admissionObjectReference(req *admissionv1.AdmissionRequest) *corev1.ObjectReference {
+	apiVersion := req.Kind.Version
+	if req.Kind.Group != "" {
+		apiVersion = req.Kind.Group + "/" + req.Kind.Version
+	}
+	return &corev1.ObjectReference{
+		APIVersion: apiVersion,
+		Kind:       req.Kind.Kind,
+		Namespace:  req.Namespace,
+		Name:       req.Name,
+		UID:        admissionObjectUID(req),
+	}
+}
+
+// admissionObjectUID returns the UID of the object under admission, read from
+// the old object for DELETE
+func admissionObjectUID(req *admissionv1.AdmissionRequest) types.UID {
+	for _, raw := range [][]byte{req.Object.Raw, req.OldObject.Raw} {
+		var obj struct {
+			Metadata struct {
+				UID types.UID `json:"uid"`
+			} `json:"metadata"`
+		}
+		if len(raw) > 0 && json.Unmarshal(raw, &obj) == nil && obj.Metadata.UID != "" {
+			return obj.Metadata.UID
+		}
+	}
+	return ""
+}
+
+// containerPatchPath matches patches of a container field, e.g.
+// /spec/template/spec/initContainers/0/resources
+var containerPatchPath = regexp.MustCompile(`/(containers|initContainers)/(\d+)/(resources|securityContext|image)$`)
+
+// summarizePatches describes what a set of patches changes, e.g. "adjusted
+// resources on 3 containers, converted anti-affinity"
+func summarizePatches(patches []patchOperation) string {
+	containers := map[string]map[string]bool{}
+	var changes []string
+	seen := map[string]bool{}
+	addChange := func(change string) {
+		if !seen[change] {
+			seen[change] = true
+			changes = append(changes, change)
+		}
+	}
+
+	for _, patch := range patches {
+		if m := containerPatchPath.FindStringSubmatch(patch.Path); m != nil {
+			field := m[3]
+			if containers[field] == nil {
+				containers[field] = map[string]bool{}
+			}
+			containers[field][m[1]+"/"+m[2]] = true
+			continue
+		}
+		switch {
+		case strings.HasSuffix(patch.Path, "/spec/securityContext"):
+			addChange("set pod security context")
+		case strings.HasSuffix(patch.Path, "/affinity"), strings.HasSuffix(patch.Path, "/podAntiAffinity"):
+			addChange("converted anti-affinity")
+		case strings.HasSuffix(patch.Path, "/topologySpreadConstraints"):
+			addChange("spread over zones")
+		case strings.HasSuffix(patch.Path, "/priorityClassName"):
+			addChange("set priority class " + fmt.Sprint(patch.Value))
+		case strings.HasSuffix(patch.Path, "/priority"):
+			// Part of setting the priority class
+		case patch.Path == "/spec/volumeClaimTemplates":
+			addChange("replaced volumeClaimTemplates with emptyDir")
+		case strings.Contains(patch.Path, "/volumes"):
+			addChange("added volumes")
+		case strings.Contains(patch.Path, "/volumeMounts"):
+			addChange("changed volume mounts")
+		default:
+			addChange("changed " + patch.Path)
+		}
+	}
+
+	var summary []string
+	if n := len(containers["resources"]); n > 0 {
+		summary = append(summary, fmt.Sprintf("adjusted resources on %d %s", n, plural(n, "container")))
+	}
+	if n := len(containers["securityContext"]); n > 0 {
+		summary = append(summary, fmt.Sprintf("set security context on %d %s", n, plural(n, "container")))
+	}
+	if n := len(containers["image"]); n > 0 {
+		summary = append(summary, fmt.Sprintf("rewrote the image of %d %s to a mirror", n, plural(n, "container")))
+	}
+	return strings.Join(append(summary, changes...), ", ")
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return noun
+	}
+	return noun + "s"
+}
+
+// recordMutation posts an Event on a mutated Deployment or StatefulSet
+// describing the patches, so `kubectl describe` shows what the webhook did
+func (ws *WebhookServer) recordMutation(req *admissionv1.AdmissionRequest, patches []patchOperation) {
+	if ws.recorder == nil || len(patches) == 0 || req.Name == "" {
+		return
+	}
+	if req.Kind.Kind != "Deployment" && req.Kind.Kind != "StatefulSet" {
+		return
+	}
+	if req.DryRun != nil && *req.DryRun {
+		return
+	}
+	ws.recorder.Eventf(admissionObjectReference(req), corev1.EventTypeNormal, "AutopilotMutationApplied",
+		"%s", summarizePatches(patches))
+}
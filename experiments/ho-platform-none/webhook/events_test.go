@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestSummarizePatches(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		patches []patchOperation
+		want    string
+	}{
+		{
+			name: "containers counted once per field",
+			patches: []patchOperation{
+				{Op: "replace", Path: "/spec/template/spec/containers/0/resources"},
+				{Op: "replace", Path: "/spec/template/spec/containers/1/resources"},
+				{Op: "replace", Path: "/spec/template/spec/initContainers/0/resources"},
+				{Op: "replace", Path: "/spec/template/spec/containers/0/resources"},
+				{Op: "replace", Path: "/spec/template/spec/affinity"},
+				{Op: "add", Path: "/spec/template/spec/affinity/podAntiAffinity"},
+			},
+			want: "adjusted resources on 3 containers, converted anti-affinity",
+		},
+		{
+			name: "single container",
+			patches: []patchOperation{
+				{Op: "add", Path: "/spec/template/spec/containers/0/securityContext"},
+				{Op: "replace", Path: "/spec/template/spec/initContainers/1/image"},
+				{Op: "add", Path: "/spec/template/spec/securityContext"},
+			},
+			want: "set security context on 1 container, rewrote the image of 1 container to a mirror, set pod security context",
+		},
+		{
+			name: "pod spec changes in order",
+			patches: []patchOperation{
+				{Op: "add", Path: "/spec/template/spec/priorityClassName", Value: "hcp-critical"},
+				{Op: "add", Path: "/spec/template/spec/priority", Value: 1000000},
+				{Op: "add", Path: "/spec/template/spec/topologySpreadConstraints"},
+				{Op: "remove", Path: "/spec/volumeClaimTemplates"},
+				{Op: "add", Path: "/spec/template/spec/volumes/-"},
+				{Op: "add", Path: "/spec/template/spec/volumes/-"},
+				{Op: "replace", Path: "/spec/template/spec/containers/0/volumeMounts/1"},
+				{Op: "replace", Path: "/spec/template/spec/dnsPolicy"},
+			},
+			want: "set priority class hcp-critical, spread over zones, replaced volumeClaimTemplates with emptyDir, added volumes, changed volume mounts, changed /spec/template/spec/dnsPolicy",
+		},
+		{name: "no patches", want: ""},
+	} {
+		if got := summarizePatches(tc.patches); got != tc.want {
+			t.Errorf("%s: summarizePatches() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAdmissionObjectReference(t *testing.T) {
+	object := []byte(`{"metadata": {"name": "etcd", "uid": "new-uid"}}`)
+	old := []byte(`{"metadata": {"name": "etcd", "uid": "old-uid"}}`)
+	for _, tc := range []struct {
+		name      string
+		operation admissionv1.Operation
+		object    []byte
+		oldObject []byte
+		want      types.UID
+	}{
+		{name: "update", operation: admissionv1.Update, object: object, oldObject: old, want: "new-uid"},
+		{name: "delete", operation: admissionv1.Delete, oldObject: old, want: "old-uid"},
+		// Not assigned until the object is stored
+		{name: "create", operation: admissionv1.Create, object: []byte(`{"metadata": {"name": "etcd"}}`)},
+		{name: "invalid object", operation: admissionv1.Update, object: []byte(`not json`), oldObject: old, want: "old-uid"},
+	} {
+		ref := admissionObjectReference(&admissionv1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+			Name:      "etcd",
+			Namespace: "clusters-test",
+			Operation: tc.operation,
+			Object:    runtime.RawExtension{Raw: tc.object},
+			OldObject: runtime.RawExtension{Raw: tc.oldObject},
+		})
+		want := corev1.ObjectReference{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: "clusters-test", Name: "etcd", UID: tc.want}
+		if *ref != want {
+			t.Errorf("%s: admissionObjectReference() = %+v, want %+v", tc.name, *ref, want)
+		}
+	}
+}
+
+func TestRecordMutation(t *testing.T) {
+	dryRun := true
+	patches := []patchOperation{{Op: "replace", Path: "/spec/template/spec/containers/0/resources"}}
+	for _, tc := range []struct {
+		name    string
+		kind    string
+		reqName string
+		dryRun  *bool
+		patches []patchOperation
+		want    string
+	}{
+		{name: "deployment", kind: "Deployment", reqName: "kube-apiserver", patches: patches, want: "Normal AutopilotMutationApplied adjusted resources on 1 container"},
+		{name: "statefulset", kind: "StatefulSet", reqName: "etcd", patches: patches, want: "Normal AutopilotMutationApplied adjusted resources on 1 container"},
+		{name: "dry run", kind: "Deployment", reqName: "kube-apiserver", dryRun: &dryRun, patches: patches},
+		{name: "not a workload", kind: "Pod", reqName: "kube-apiserver-0", patches: patches},
+		{name: "no patches", kind: "Deployment", reqName: "kube-apiserver"},
+		// generateName objects have no name to reference
+		{name: "no name", kind: "Deployment", patches: patches},
+	} {
+		recorder := record.NewFakeRecorder(10)
+		ws := &WebhookServer{recorder: recorder}
+		ws.recordMutation(&admissionv1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: tc.kind},
+			Name:      tc.reqName,
+			Namespace: "clusters-test",
+			DryRun:    tc.dryRun,
+		}, tc.patches)
+
+		var got string
+		if len(recorder.Events) > 0 {
+			got = <-recorder.Events
+		}
+		if got != tc.want || len(recorder.Events) != 0 {
+			t.Errorf("%s: Event = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// Without a recorder, nothing is recorded
+	(&WebhookServer{}).recordMutation(&admissionv1.AdmissionRequest{
+		Kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Name: "kube-apiserver",
+	}, patches)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// A panic in mutate fails every admission of the management cluster, as the
+// webhook fails closed. The fuzz tests feed it malformed reviews and odd
+// workloads and check it always answers with a 400 or a valid
+// AdmissionReview. Run them with e.g.
+//
+//	go test -run '^$' -fuzz FuzzMutate -fuzztime 1m .
+
+// fuzzServer is a webhook with the features that patch workloads enabled
+func fuzzServer(t testing.TB) *WebhookServer {
+	t.Helper()
+	topology, err := parseTopologySpreadPolicy(defaultTopologySpread, corev1.ScheduleAnyway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &WebhookServer{
+		overrides: defaultComponentOverrides,
+		topology:  topology,
+		violations: violationPolicy{
+			violationHostPath:       violationDeny,
+			violationPrivileged:     violationWarn,
+			violationHostNamespaces: violationAdmit,
+			violationHostPorts:      violationWarn,
+		},
+		hostAccess:    &hostAccessConverter{emptyDirPaths: []string{"/tmp"}},
+		imageRewrites: imageRewriteRules{{From: "registry.redhat.io", To: "mirror.example.com/redhat"}},
+	}
+}
+
+// quietLogs discards the log lines of every admission while fuzzing
+func quietLogs(t testing.TB) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// checkAdmission sends body to mutate and checks the webhook answers a
+// decodable review with a 400, or with an AdmissionResponse of the request
+// whose patch, if any, is a valid JSON patch. It returns the response, nil on
+// a 400.
+func checkAdmission(t *testing.T, ws *WebhookServer, body []byte) *admissionv1.AdmissionResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	ws.mutate(w, httptest.NewRequest("POST", "/mutate", bytes.NewReader(body)))
+
+	var request admissionv1.AdmissionReview
+	decodable := json.Unmarshal(body, &request) == nil && request.Request != nil
+	switch {
+	case w.Code == http.StatusBadRequest && !decodable:
+		return nil
+	case w.Code != http.StatusOK:
+		t.Fatalf("status = %d (%s), want 200 for a decodable review and 400 otherwise", w.Code, w.Body.String())
+	case !decodable:
+		t.Fatalf("status = 200 for the undecodable review %q", body)
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+		t.Fatalf("could not decode response %q: %v", w.Body.String(), err)
+	}
+	response := review.Response
+	if response == nil {
+		t.Fatalf("review %s has no response", w.Body.String())
+	}
+	if response.UID != request.Request.UID {
+		t.Errorf("response UID = %q, want %q", response.UID, request.Request.UID)
+	}
+	if !response.Allowed && (response.Result == nil || response.Result.Message == "") {
+		t.Errorf("denied without a message: %+v", response)
+	}
+	if len(response.Patch) > 0 {
+		if response.PatchType == nil || *response.PatchType != admissionv1.PatchTypeJSONPatch {
+			t.Errorf("patch type = %v, want JSONPatch", response.PatchType)
+		}
+		if _, err := jsonpatch.DecodePatch(response.Patch); err != nil {
+			t.Errorf("invalid patch %s: %v", response.Patch, err)
+		}
+	}
+	return response
+}
+
+// reviewOf wraps the raw object of a kind in an AdmissionReview
+func reviewOf(kind, namespace string, raw []byte) []byte {
+	body, _ := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "fuzz",
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: kind},
+			Name:      "fuzz",
+			Namespace: namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	return body
+}
+
+func FuzzMutate(f *testing.F) {
+	deployment, _ := json.Marshal(kubeAPIServerDeployment())
+	statefulSet, _ := json.Marshal(etcdStatefulSet())
+	for _, seed := range [][]byte{
+		reviewOf("Deployment", "clusters-test", deployment),
+		reviewOf("StatefulSet", "clusters-test", statefulSet),
+		reviewOf("Deployment", "default", deployment),
+		// Missing, null and mistyped objects
+		reviewOf("Deployment", "clusters-test", nil),
+		reviewOf("StatefulSet", "clusters-test", []byte(`null`)),
+		reviewOf("Pod", "clusters-test", []byte(`[]`)),
+		reviewOf("Route", "clusters-test", []byte(`{"spec":{"host":1}}`)),
+		// Empty containers, nil affinity and selector
+		reviewOf("Deployment", "clusters-test", []byte(`{"metadata":{"name":"kube-apiserver"},"spec":{"template":{"spec":{"containers":[],"affinity":null}}}}`)),
+		reviewOf("StatefulSet", "clusters-test", []byte(`{"metadata":{"name":"etcd"},"spec":{"selector":null,"template":{"spec":{"affinity":{"podAntiAffinity":null}}}}}`)),
+		reviewOf("Deployment", "clusters-test", []byte(`{"spec":{"template":{"spec":{"volumes":[{"name":"host","hostPath":{"path":"/"}}],"containers":[{"name":"c","securityContext":{"privileged":true}}]}}}}`)),
+		reviewOf("Pod", "clusters-test", []byte(`{"spec":{"initContainers":[{"image":"registry.redhat.io/ubi9@sha256:0"}],"containers":[{"image":"registry.redhat.io:5000"},{"image":"@"}]}}`)),
+		reviewOf("Pod", "clusters-test", []byte(`{"spec":{"hostNetwork":true,"volumes":[{"name":"scratch","hostPath":{"path":"/tmp/x"}}],"containers":[{"name":"c","ports":[{"containerPort":80,"hostPort":80}]}]}}`)),
+		// Missing request and bodies that are not reviews
+		[]byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`),
+		[]byte(`{"request":null}`),
+		[]byte(`{"request":{"uid":"fuzz","namespace":"clusters-test","operation":"CREATE","kind":{"kind":"Deployment"}}}`),
+		[]byte(`{"request":{"uid":"fuzz","namespace":"clusters-test","operation":"UPDATE","kind":{"kind":"Pod"},"object":{"metadata":{"labels":{"hypershift.openshift.io/hosted-control-plane":"x"}}}}}`),
+		[]byte(`null`),
+		[]byte(`[`),
+		{},
+	} {
+		f.Add(seed)
+	}
+
+	ws := fuzzServer(f)
+	f.Fuzz(func(t *testing.T, body []byte) {
+		quietLogs(t)
+		checkAdmission(t, ws, body)
+	})
+}
+
+// FuzzMutateWorkload builds workloads from fuzzed container specs, so the
+// fuzzer explores the patches rather than the JSON decoder, and checks the
+// patches of a Deployment apply to the object they were generated for
+func FuzzMutateWorkload(f *testing.F) {
+	f.Add(uint8(0), "kube-apiserver", "kube-apiserver", uint8(3), uint8(0), uint8(2), "500m", "2Gi")
+	f.Add(uint8(1), "etcd", "etcd", uint8(3), uint8(2), uint8(2), "", "")
+	f.Add(uint8(0), "haproxy", "", uint8(0), uint8(0), uint8(0), "", "")
+	f.Add(uint8(1), "openshift-apiserver", "openshift-apiserver", uint8(1), uint8(1), uint8(1), "0", "0")
+	f.Add(uint8(2), "", "", uint8(0), uint8(0), uint8(0), "", "")
+
+	ws := fuzzServer(f)
+	f.Fuzz(func(t *testing.T, kind uint8, name, container string, containers, initContainers, affinity uint8, cpu, memory string) {
+		quietLogs(t)
+		var requests corev1.ResourceList
+		for resourceName, value := range map[corev1.ResourceName]string{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory} {
+			if value == "" {
+				continue
+			}
+			quantity, err := resource.ParseQuantity(value)
+			if err != nil {
+				t.Skip()
+			}
+			if requests == nil {
+				requests = corev1.ResourceList{}
+			}
+			requests[resourceName] = quantity
+		}
+
+		labels := map[string]string{"app": name, "hypershift.openshift.io/control-plane-component": name}
+		spec := corev1.PodSpec{}
+		switch affinity % 4 {
+		case 1:
+			spec.Affinity = &corev1.Affinity{}
+		case 2:
+			spec.Affinity = &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: labels},
+					TopologyKey:   "kubernetes.io/hostname",
+				}},
+			}}
+		case 3:
+			spec.Affinity = &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{}}
+		}
+		for i := 0; i < int(containers%5); i++ {
+			spec.Containers = append(spec.Containers, corev1.Container{
+				Name:      fmt.Sprintf("%s-%d", container, i),
+				Resources: corev1.ResourceRequirements{Requests: requests},
+			})
+		}
+		if len(spec.Containers) > 0 {
+			// Let the overrides of the component match its main container
+			spec.Containers[0].Name = container
+		}
+		for i := 0; i < int(initContainers%3); i++ {
+			spec.InitContainers = append(spec.InitContainers, corev1.Container{Name: fmt.Sprintf("init-%d", i)})
+		}
+
+		template := corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}, Spec: spec}
+		meta := metav1.ObjectMeta{Name: name, Namespace: "clusters-test", Labels: labels}
+		var obj runtime.Object
+		switch kind % 3 {
+		case 0:
+			obj = &appsv1.Deployment{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				ObjectMeta: meta,
+				Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}, Template: template},
+			}
+		case 1:
+			obj = &appsv1.StatefulSet{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+				ObjectMeta: meta,
+				Spec:       appsv1.StatefulSetSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}, Template: template},
+			}
+		default:
+			obj = &corev1.Pod{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}, ObjectMeta: meta, Spec: spec}
+		}
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			t.Skip()
+		}
+
+		kindName := obj.GetObjectKind().GroupVersionKind().Kind
+		response := checkAdmission(t, ws, reviewOf(kindName, "clusters-test", raw))
+		// The etcd fixes patch the volumes of the StatefulSet HyperShift
+		// creates, so only the patches of Deployments apply to any object
+		if response == nil || len(response.Patch) == 0 || kindName != "Deployment" {
+			return
+		}
+		patch, _ := jsonpatch.DecodePatch(response.Patch)
+		if _, err := patch.Apply(raw); err != nil {
+			t.Errorf("patch %s does not apply to %s: %v", response.Patch, raw, err)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+var hostedControlPlaneResource = schema.GroupVersionResource{
+	Group:    "hypershift.openshift.io",
+	Version:  "v1beta1",
+	Resource: "hostedcontrolplanes",
+}
+
+const (
+	defaultHCPCacheResync = 10 * time.Minute
+
+	// singleReplica is the controllerAvailabilityPolicy of control planes
+	// running one replica of every component, the HyperShift default
+	singleReplica = "SingleReplica"
+)
+
+// hostedControlPlane is what admission decisions need to know about the
+// HostedControlPlane owning a clusters-* namespace
+type hostedControlPlane struct {
+	Name      string
+	Namespace string
+	// ControllerAvailabilityPolicy is SingleReplica or HighlyAvailable
+	ControllerAvailabilityPolicy string
+}
+
+func (h *hostedControlPlane) String() string {
+	return fmt.Sprintf("HostedControlPlane %s (%s)", h.Name, h.ControllerAvailabilityPolicy)
+}
+
+// hostedControlPlaneCache keeps the HostedControlPlane of every namespace up
+// to date from an informer, so pods of a hosted cluster are admitted with the
+// same context without reading it on every request
+type hostedControlPlaneCache struct {
+	mu       sync.RWMutex
+	byNS     map[string]*hostedControlPlane
+	informer cache.SharedIndexInformer
+}
+
+// newHostedControlPlaneCacheFromEnv builds the cache, resyncing every
+// HCP_CACHE_RESYNC. It returns nil when HCP_CACHE is "false" or when not
+// running inside a cluster, in which case admissions get no owner context.
+func newHostedControlPlaneCacheFromEnv() (*hostedControlPlaneCache, error) {
+	if os.Getenv("HCP_CACHE") == "false" {
+		return nil, nil
+	}
+	resync, err := envDuration("HCP_CACHE_RESYNC", defaultHCPCacheResync)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("HostedControlPlane cache disabled: %v", err)
+		return nil, nil
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create dynamic client: %v", err)
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, resync)
+	return newHostedControlPlaneCache(factory.ForResource(hostedControlPlaneResource).Informer())
+}
+
+func newHostedControlPlaneCache(informer cache.SharedIndexInformer) (*hostedControlPlaneCache, error) {
+	c := &hostedControlPlaneCache{
+		byNS:     make(map[string]*hostedControlPlane),
+		informer: informer,
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.set,
+		UpdateFunc: func(_, obj interface{}) { c.set(obj) },
+		DeleteFunc: c.delete,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not watch HostedControlPlanes: %v", err)
+	}
+	return c, nil
+}
+
+// Run watches HostedControlPlanes until ctx is done
+func (c *hostedControlPlaneCache) Run(ctx context.Context) {
+	go c.informer.Run(ctx.Done())
+	if cache.WaitForCacheSync(ctx.Done(), c.informer.HasSynced) {
+		log.Printf("HostedControlPlane cache synced with %d control planes", c.Len())
+	}
+}
+
+// Get returns the HostedControlPlane of a namespace
+func (c *hostedControlPlaneCache) Get(namespace string) (*hostedControlPlane, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	hcp, ok := c.byNS[namespace]
+	return hcp, ok
+}
+
+// Len returns the number of cached HostedControlPlanes
+func (c *hostedControlPlaneCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.byNS)
+}
+
+// highlyAvailable tells whether the control plane of namespace runs several
+// replicas of its components. Namespaces without a cached HostedControlPlane
+// are assumed to, so they are mutated as without the cache.
+func (ws *WebhookServer) highlyAvailable(namespace string) bool {
+	hcp, ok := ws.hcps.Get(namespace)
+	return !ok || hcp.ControllerAvailabilityPolicy != singleReplica
+}
+
+func (c *hostedControlPlaneCache) set(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	hcp := hostedControlPlaneFrom(u)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byNS[hcp.Namespace] = hcp
+	hostedControlPlanesCached.Set(float64(len(c.byNS)))
+}
+
+func (c *hostedControlPlaneCache) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A namespace has a single HostedControlPlane; only forget the one deleted
+	if hcp, ok := c.byNS[u.GetNamespace()]; ok && hcp.Name == u.GetName() {
+		delete(c.byNS, u.GetNamespace())
+	}
+	hostedControlPlanesCached.Set(float64(len(c.byNS)))
+}
+
+// hostedControlPlaneFrom extracts the fields admission decisions use
+func hostedControlPlaneFrom(u *unstructured.Unstructured) *hostedControlPlane {
+	availability, _, _ := unstructured.NestedString(u.Object, "spec", "controllerAvailabilityPolicy")
+	if availability == "" {
+		availability = singleReplica
+	}
+	return &hostedControlPlane{
+		Name:                         u.GetName(),
+		Namespace:                    u.GetNamespace(),
+		ControllerAvailabilityPolicy: availability,
+	}
+}
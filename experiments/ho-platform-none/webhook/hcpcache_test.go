@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// hostedControlPlaneObject returns a HostedControlPlane with the given
+// controllerAvailabilityPolicy, none when empty
+func hostedControlPlaneObject(namespace, name, availability string) *unstructured.Unstructured {
+	spec := map[string]interface{}{"platform": map[string]interface{}{"type": "None"}}
+	if availability != "" {
+		spec["controllerAvailabilityPolicy"] = availability
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "hypershift.openshift.io/v1beta1",
+		"kind":       "HostedControlPlane",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec":       spec,
+	}}
+}
+
+func TestHostedControlPlaneFrom(t *testing.T) {
+	for _, tc := range []struct {
+		availability string
+		want         string
+	}{
+		// HyperShift defaults to a single replica
+		{"", "SingleReplica"},
+		{"SingleReplica", "SingleReplica"},
+		{"HighlyAvailable", "HighlyAvailable"},
+	} {
+		got := hostedControlPlaneFrom(hostedControlPlaneObject("clusters-a", "a", tc.availability))
+		want := &hostedControlPlane{Name: "a", Namespace: "clusters-a", ControllerAvailabilityPolicy: tc.want}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("controllerAvailabilityPolicy %q: hostedControlPlaneFrom() = %+v, want %+v", tc.availability, got, want)
+		}
+	}
+}
+
+func TestHostedControlPlaneCache_SetAndDelete(t *testing.T) {
+	c := &hostedControlPlaneCache{byNS: map[string]*hostedControlPlane{}}
+	c.set(hostedControlPlaneObject("clusters-a", "a", "HighlyAvailable"))
+	c.set(hostedControlPlaneObject("clusters-b", "b", ""))
+	// Objects that are not HostedControlPlanes are ignored
+	c.set("not a HostedControlPlane")
+	c.delete(nil)
+	if c.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", c.Len())
+	}
+
+	// Updates replace the cached control plane
+	c.set(hostedControlPlaneObject("clusters-a", "a", "SingleReplica"))
+	if hcp, ok := c.Get("clusters-a"); !ok || hcp.ControllerAvailabilityPolicy != "SingleReplica" {
+		t.Errorf("Get(clusters-a) = %+v, %t after the update", hcp, ok)
+	}
+
+	// A deleted control plane of another name leaves the cached one alone
+	c.delete(hostedControlPlaneObject("clusters-a", "old", ""))
+	if _, ok := c.Get("clusters-a"); !ok {
+		t.Error("clusters-a forgotten on the deletion of another control plane")
+	}
+	c.delete(cache.DeletedFinalStateUnknown{Key: "clusters-a/a", Obj: hostedControlPlaneObject("clusters-a", "a", "")})
+	if _, ok := c.Get("clusters-a"); ok {
+		t.Error("clusters-a still cached after a tombstone")
+	}
+	c.delete(hostedControlPlaneObject("clusters-b", "b", ""))
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d after deleting every control plane", c.Len())
+	}
+
+	var disabled *hostedControlPlaneCache
+	if _, ok := disabled.Get("clusters-a"); ok {
+		t.Error("disabled cache returned a control plane")
+	}
+}
+
+func TestHostedControlPlaneCache_Informer(t *testing.T) {
+	quietLogs(t)
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{hostedControlPlaneResource: "HostedControlPlaneList"},
+		hostedControlPlaneObject("clusters-a", "a", ""))
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	c, err := newHostedControlPlaneCache(factory.ForResource(hostedControlPlaneResource).Informer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Run(ctx)
+
+	if hcp, ok := c.Get("clusters-a"); !ok || hcp.ControllerAvailabilityPolicy != "SingleReplica" {
+		t.Fatalf("Get(clusters-a) = %+v, %t after the sync", hcp, ok)
+	}
+
+	hcps := client.Resource(hostedControlPlaneResource).Namespace("clusters-a")
+	if _, err := hcps.Update(ctx, hostedControlPlaneObject("clusters-a", "a", "HighlyAvailable"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the update", func() bool {
+		hcp, ok := c.Get("clusters-a")
+		return ok && hcp.ControllerAvailabilityPolicy == "HighlyAvailable"
+	})
+
+	if err := hcps.Delete(ctx, "a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the deletion", func() bool {
+		_, ok := c.Get("clusters-a")
+		return !ok
+	})
+}
+
+// waitFor polls condition until it holds or a few seconds have passed
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatalf("cache did not reflect %s", what)
+}
+
+func TestTopologySpread_ControllerAvailability(t *testing.T) {
+	policy, err := parseTopologySpreadPolicy(defaultTopologySpread, corev1.ScheduleAnyway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		hcp    *hostedControlPlane
+		spread bool
+	}{
+		{name: "single replica", hcp: &hostedControlPlane{Name: "test", Namespace: "clusters-test", ControllerAvailabilityPolicy: "SingleReplica"}},
+		{name: "highly available", hcp: &hostedControlPlane{Name: "test", Namespace: "clusters-test", ControllerAvailabilityPolicy: "HighlyAvailable"}, spread: true},
+		// Spread as without the cache
+		{name: "not cached", spread: true},
+	} {
+		hcps := &hostedControlPlaneCache{byNS: map[string]*hostedControlPlane{}}
+		if tc.hcp != nil {
+			hcps.byNS[tc.hcp.Namespace] = tc.hcp
+		}
+		spec := admit(t, &WebhookServer{topology: policy, hcps: hcps}, etcdStatefulSet(), "StatefulSet")
+		if spread := len(spec.TopologySpreadConstraints) > 0; spread != tc.spread {
+			t.Errorf("%s: topologySpreadConstraints = %+v, want spread %t", tc.name, spec.TopologySpreadConstraints, tc.spread)
+		}
+	}
+}
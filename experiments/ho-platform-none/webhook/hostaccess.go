@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// defaultEmptyDirPaths are the node directories holding scratch data
+	// only, whose hostPath volumes can become emptyDirs
+	defaultEmptyDirPaths = "/tmp,/var/tmp"
+
+	defaultHostPortsResync = 5 * time.Minute
+
+	// hostPortsRetry is how soon a workload admitted on creation is looked
+	// up again when it is not stored yet
+	hostPortsRetry = 10 * time.Second
+
+	// hostPortsServiceSuffix names the Service taking over the host ports of
+	// a workload, <workload>-host-ports
+	hostPortsServiceSuffix = "-host-ports"
+)
+
+// hostAccessConverter replaces the host access Autopilot rejects with
+// equivalents it admits, where they keep the workload working: hostPath
+// volumes of scratch directories become emptyDirs, and the host network and
+// host ports of a Deployment or StatefulSet become a ClusterIP Service on
+// the same ports. Everything else is denied with the reason it was kept.
+type hostAccessConverter struct {
+	// emptyDirPaths are the cleaned directories whose hostPath volumes,
+	// and those of their subdirectories, are replaced with emptyDirs
+	emptyDirPaths []string
+	// services applies the Services of the converted host ports, nil when
+	// not running inside a cluster, where host ports are not converted
+	services *hostPortServices
+}
+
+// newHostAccessConverterFromEnv builds the converter from
+// HOST_PATH_EMPTYDIR_PATHS, a comma-separated list of node directories
+// (default /tmp,/var/tmp). It returns nil when HOST_ACCESS_CONVERSION is
+// "false".
+func newHostAccessConverterFromEnv() (*hostAccessConverter, error) {
+	if os.Getenv("HOST_ACCESS_CONVERSION") == "false" {
+		return nil, nil
+	}
+	var paths []string
+	for _, p := range strings.Split(envString("HOST_PATH_EMPTYDIR_PATHS", defaultEmptyDirPaths), ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !path.IsAbs(p) || path.Clean(p) == "/" {
+			return nil, fmt.Errorf("invalid HOST_PATH_EMPTYDIR_PATHS: %q must be an absolute path below /", p)
+		}
+		paths = append(paths, path.Clean(p))
+	}
+	converter := &hostAccessConverter{emptyDirPaths: paths}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("Host port conversion disabled: %v", err)
+		return converter, nil
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create client: %v", err)
+	}
+	converter.services = newHostPortServices(clientset, defaultHostPortsResync)
+	return converter, nil
+}
+
+// String describes the conversions, for the startup log
+func (c *hostAccessConverter) String() string {
+	hostPorts := "host ports to Services"
+	if c.services == nil {
+		hostPorts = "host ports denied"
+	}
+	return fmt.Sprintf("hostPath under %s to emptyDir, %s", strings.Join(c.emptyDirPaths, ", "), hostPorts)
+}
+
+// hostAccessPlan is how the host access of an object under admission is
+// converted
+type hostAccessPlan struct {
+	Patches []patchOperation
+	// Converted are the violating fields the patches make Autopilot-safe
+	Converted map[string]bool
+	// Unconverted are the hostPath, hostNetwork and hostPort fields no safe
+	// equivalent was found for, with the reason
+	Unconverted map[string]string
+	// Warnings tell the client what was converted
+	Warnings []string
+	// Service takes over the host ports of a Deployment or StatefulSet
+	Service *hostPortService
+}
+
+// hostPortService is the Service of the converted host ports of a workload
+type hostPortService struct {
+	Name      string
+	Namespace string
+	// Kind and Workload name the Deployment or StatefulSet owning the
+	// Service, whose selector it uses
+	Kind     string
+	Workload string
+	Ports    []corev1.ServicePort
+}
+
+// Resolve drops the violations the plan converts and returns those it
+// cannot convert separately, with the reason appended
+func (p hostAccessPlan) Resolve(violations []violation) (remaining, unconverted []violation) {
+	for _, v := range violations {
+		reason, ok := p.Unconverted[v.Field]
+		switch {
+		case p.Converted[v.Field]:
+			hostAccessConversionsTotal.WithLabelValues(string(v.Class), "converted").Inc()
+		case ok:
+			v.Reason += "; " + reason
+			hostAccessConversionsTotal.WithLabelValues(string(v.Class), "denied").Inc()
+			unconverted = append(unconverted, v)
+		default:
+			remaining = append(remaining, v)
+		}
+	}
+	return remaining, unconverted
+}
+
+// hostAccessPlan plans the conversions of the object under admission. The
+// plan is empty when the conversion is disabled, or when the profile of the
+// namespace opts out of it or the component out of all mutations.
+func (ws *WebhookServer) hostAccessPlan(req *admissionv1.AdmissionRequest) hostAccessPlan {
+	if ws.hostAccess == nil || (req.Operation != admissionv1.Create && req.Operation != admissionv1.Update) {
+		return hostAccessPlan{}
+	}
+	// checkViolations logs objects that cannot be decoded
+	workload, err := admissionWorkloadOf(req)
+	if err != nil || workload == nil {
+		return hostAccessPlan{}
+	}
+	plan := ws.hostAccess.Plan(req, workload)
+	if len(plan.Converted) == 0 && len(plan.Unconverted) == 0 {
+		return plan
+	}
+	profile := ws.profile(req.Namespace)
+	if profile.skips(mutationHostAccess) || profile.skipsComponent(workload.Component) {
+		log.Printf("%s %s opted out of host access conversion by %s", req.Kind.Kind, workload.Name, profile)
+		return hostAccessPlan{}
+	}
+	return plan
+}
+
+// Plan converts the hostPath volumes, host network and host ports of a
+// workload
+func (c *hostAccessConverter) Plan(req *admissionv1.AdmissionRequest, workload *admissionWorkload) hostAccessPlan {
+	plan := hostAccessPlan{Converted: map[string]bool{}, Unconverted: map[string]string{}}
+	spec := workload.Spec
+	base := "/" + strings.ReplaceAll(workload.Field, ".", "/")
+
+	for i, volume := range spec.Volumes {
+		if volume.HostPath == nil {
+			continue
+		}
+		field := fmt.Sprintf("%s.volumes[%d].hostPath", workload.Field, i)
+		if reason := c.emptyDirReason(volume.HostPath); reason != "" {
+			plan.Unconverted[field] = reason
+			continue
+		}
+		plan.Patches = append(plan.Patches, patchOperation{
+			Op:   "replace",
+			Path: fmt.Sprintf("%s/volumes/%d", base, i),
+			Value: corev1.Volume{
+				Name:         volume.Name,
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			},
+		})
+		plan.Converted[field] = true
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("volume %q: hostPath %s replaced with an emptyDir, not shared with the node", volume.Name, volume.HostPath.Path))
+	}
+
+	// Under the host network every declared port is reachable on the node
+	var fields []string
+	var patches []patchOperation
+	var ports []corev1.ServicePort
+	for _, list := range []struct {
+		field      string
+		containers []corev1.Container
+	}{
+		{"initContainers", spec.InitContainers},
+		{"containers", spec.Containers},
+	} {
+		for i, container := range list.containers {
+			for j, port := range container.Ports {
+				if port.HostPort == 0 && !spec.HostNetwork {
+					continue
+				}
+				portPath := fmt.Sprintf("%s/%s/%d/ports/%d", base, list.field, i, j)
+				if port.HostPort != 0 {
+					fields = append(fields, fmt.Sprintf("%s.%s[%d].ports[%d].hostPort", workload.Field, list.field, i, j))
+					patches = append(patches, patchOperation{Op: "remove", Path: portPath + "/hostPort"})
+				}
+				if port.HostIP != "" {
+					patches = append(patches, patchOperation{Op: "remove", Path: portPath + "/hostIP"})
+				}
+				ports = appendServicePort(ports, port)
+			}
+		}
+	}
+	if spec.HostNetwork {
+		fields = append(fields, workload.Field+".hostNetwork")
+		patches = append(patches, patchOperation{Op: "remove", Path: base + "/hostNetwork"})
+		if spec.DNSPolicy == corev1.DNSClusterFirstWithHostNet {
+			patches = append(patches, patchOperation{Op: "replace", Path: base + "/dnsPolicy", Value: corev1.DNSClusterFirst})
+		}
+	}
+	if len(fields) == 0 {
+		return plan
+	}
+
+	if reason := c.hostPortsReason(req, workload, len(ports)); reason != "" {
+		for _, field := range fields {
+			plan.Unconverted[field] = reason
+		}
+		return plan
+	}
+	plan.Patches = append(plan.Patches, patches...)
+	for _, field := range fields {
+		plan.Converted[field] = true
+	}
+	plan.Service = &hostPortService{
+		Name:      hostPortServiceName(workload.Name),
+		Namespace: req.Namespace,
+		Kind:      req.Kind.Kind,
+		Workload:  workload.Name,
+		Ports:     ports,
+	}
+	var exposed []string
+	for _, port := range ports {
+		exposed = append(exposed, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+	}
+	plan.Warnings = append(plan.Warnings, fmt.Sprintf("host network and host ports removed, ports %s are served by Service %s instead of the node",
+		strings.Join(exposed, ", "), plan.Service.Name))
+	return plan
+}
+
+// emptyDirReason tells why a hostPath volume cannot become an emptyDir,
+// empty if it can: only directories under emptyDirPaths hold nothing the
+// node or other pods rely on
+func (c *hostAccessConverter) emptyDirReason(source *corev1.HostPathVolumeSource) string {
+	if t := source.Type; t != nil && *t != corev1.HostPathUnset && *t != corev1.HostPathDirectory && *t != corev1.HostPathDirectoryOrCreate {
+		return fmt.Sprintf("a %s of the node cannot be replaced with an emptyDir", *t)
+	}
+	p := path.Clean(source.Path)
+	for _, dir := range c.emptyDirPaths {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return ""
+		}
+	}
+	if len(c.emptyDirPaths) == 0 {
+		return "no hostPath is replaced with an emptyDir"
+	}
+	return fmt.Sprintf("only hostPaths under %s are replaced with an emptyDir", strings.Join(c.emptyDirPaths, ", "))
+}
+
+// hostPortsReason tells why the host ports of a workload cannot move to a
+// Service, empty if they can
+func (c *hostAccessConverter) hostPortsReason(req *admissionv1.AdmissionRequest, workload *admissionWorkload, ports int) string {
+	switch {
+	case c.services == nil:
+		return "the webhook cannot create a Service for the ports outside a cluster"
+	case req.Kind.Kind != "Deployment" && req.Kind.Kind != "StatefulSet":
+		return "only the ports of Deployments and StatefulSets are moved to a Service"
+	case workload.Selector == nil || len(workload.Selector.MatchLabels) == 0 || len(workload.Selector.MatchExpressions) > 0:
+		return "a Service can only select the pods of a workload with a matchLabels selector"
+	case ports == 0:
+		return "the workload declares no container ports a Service could serve"
+	}
+	return ""
+}
+
+// appendServicePort adds the Service port of a container port, served on
+// its host port and forwarded to the container port, once per port and
+// protocol
+func appendServicePort(ports []corev1.ServicePort, port corev1.ContainerPort) []corev1.ServicePort {
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	servicePort := port.HostPort
+	if servicePort == 0 {
+		servicePort = port.ContainerPort
+	}
+	for _, existing := range ports {
+		if existing.Port == servicePort && existing.Protocol == protocol {
+			return ports
+		}
+	}
+	name := port.Name
+	if name == "" || slices.ContainsFunc(ports, func(p corev1.ServicePort) bool { return p.Name == name }) {
+		name = fmt.Sprintf("%s-%d", strings.ToLower(string(protocol)), servicePort)
+	}
+	return append(ports, corev1.ServicePort{
+		Name:       name,
+		Protocol:   protocol,
+		Port:       servicePort,
+		TargetPort: intstr.FromInt32(port.ContainerPort),
+	})
+}
+
+// hostPortServiceName returns the Service name of a workload, a DNS label of
+// at most 63 characters
+func hostPortServiceName(workload string) string {
+	name := strings.ReplaceAll(workload, ".", "-")
+	if name == "" || name[0] < 'a' || name[0] > 'z' {
+		name = "w-" + name
+	}
+	if len(name) > 63-len(hostPortsServiceSuffix) {
+		name = strings.TrimRight(name[:63-len(hostPortsServiceSuffix)], "-")
+	}
+	return name + hostPortsServiceSuffix
+}
+
+// Expose records the Service of the converted host ports of an admitted
+// workload, unless the admission is a dry run
+func (c *hostAccessConverter) Expose(req *admissionv1.AdmissionRequest, plan hostAccessPlan) {
+	if c == nil || c.services == nil || plan.Service == nil || (req.DryRun != nil && *req.DryRun) {
+		return
+	}
+	c.services.track(*plan.Service)
+}
+
+// hostPortTarget is the Service of a workload admitted with host ports
+type hostPortTarget struct {
+	service  hostPortService
+	admitted time.Time
+}
+
+// hostPortServices maintains the Services of the workloads admitted with
+// converted host ports, like autoscalingManager its HPAs: admissions record
+// the Service, which Run server-side applies, owned by the workload so it
+// goes away with it.
+type hostPortServices struct {
+	client kubernetes.Interface
+	resync time.Duration
+
+	mu      sync.Mutex
+	targets map[types.NamespacedName]hostPortTarget
+	changed chan struct{}
+}
+
+func newHostPortServices(client kubernetes.Interface, resync time.Duration) *hostPortServices {
+	return &hostPortServices{
+		client:  client,
+		resync:  resync,
+		targets: map[types.NamespacedName]hostPortTarget{},
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (s *hostPortServices) track(service hostPortService) {
+	key := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.targets[key]; ok && existing.service.Kind == service.Kind &&
+		existing.service.Workload == service.Workload && slices.Equal(existing.service.Ports, service.Ports) {
+		return
+	}
+	s.targets[key] = hostPortTarget{service: service, admitted: time.Now()}
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run applies the recorded Services after every admission changing them and
+// every resync interval, so deleted or edited Services come back, until ctx
+// is done
+func (s *hostPortServices) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.resync)
+	defer ticker.Stop()
+
+	for {
+		var retry <-chan time.Time
+		if pending := s.ensure(ctx); pending {
+			retry = time.After(hostPortsRetry)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.changed:
+		case <-retry:
+		}
+	}
+}
+
+// ensure applies the Service of every recorded workload. It reports whether
+// a workload admitted recently was not found, to be retried shortly.
+func (s *hostPortServices) ensure(ctx context.Context) (pending bool) {
+	s.mu.Lock()
+	targets := maps.Clone(s.targets)
+	s.mu.Unlock()
+
+	for key, target := range targets {
+		uid, selector, err := s.workload(ctx, target.service)
+		if apierrors.IsNotFound(err) {
+			// A workload admitted on creation is stored after the admission
+			if time.Since(target.admitted) < s.resync {
+				pending = true
+				continue
+			}
+			s.mu.Lock()
+			if current, ok := s.targets[key]; ok && current.admitted.Equal(target.admitted) {
+				delete(s.targets, key)
+			}
+			s.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			log.Printf("Could not read %s %s/%s for its host ports Service: %v", target.service.Kind, key.Namespace, target.service.Workload, err)
+			continue
+		}
+		if err := s.apply(ctx, target.service, uid, selector); err != nil {
+			log.Printf("Could not apply Service %s: %v", key, err)
+		}
+	}
+	return pending
+}
+
+// workload returns the UID and pod labels selector of the stored workload
+// of a Service
+func (s *hostPortServices) workload(ctx context.Context, service hostPortService) (types.UID, map[string]string, error) {
+	var meta metav1.ObjectMeta
+	var selector *metav1.LabelSelector
+	switch service.Kind {
+	case "Deployment":
+		deployment, err := s.client.AppsV1().Deployments(service.Namespace).Get(ctx, service.Workload, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+		meta, selector = deployment.ObjectMeta, deployment.Spec.Selector
+	case "StatefulSet":
+		statefulSet, err := s.client.AppsV1().StatefulSets(service.Namespace).Get(ctx, service.Workload, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+		meta, selector = statefulSet.ObjectMeta, statefulSet.Spec.Selector
+	default:
+		return "", nil, fmt.Errorf("unsupported kind %s", service.Kind)
+	}
+	if selector == nil || len(selector.MatchLabels) == 0 || len(selector.MatchExpressions) > 0 {
+		return "", nil, fmt.Errorf("a Service cannot select the pods of %s %s, which has no matchLabels selector", service.Kind, service.Workload)
+	}
+	return meta.UID, selector.MatchLabels, nil
+}
+
+// apply creates or updates the ClusterIP Service of a workload. A Service of
+// the same name the webhook did not create is left alone.
+func (s *hostPortServices) apply(ctx context.Context, service hostPortService, uid types.UID, selector map[string]string) error {
+	services := s.client.CoreV1().Services(service.Namespace)
+	existing, err := services.Get(ctx, service.Name, metav1.GetOptions{})
+	if err == nil && existing.Labels[managedByLabel] != eventComponent {
+		log.Printf("Service %s/%s is not managed by the webhook, leaving it", service.Namespace, service.Name)
+		return nil
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	owner := metav1ac.OwnerReference().
+		WithAPIVersion("apps/v1").
+		WithKind(service.Kind).
+		WithName(service.Workload).
+		WithUID(uid)
+	spec := corev1ac.ServiceSpec().
+		WithType(corev1.ServiceTypeClusterIP).
+		WithSelector(selector)
+	for _, port := range service.Ports {
+		spec.WithPorts(corev1ac.ServicePort().
+			WithName(port.Name).
+			WithProtocol(port.Protocol).
+			WithPort(port.Port).
+			WithTargetPort(port.TargetPort))
+	}
+	svc := corev1ac.Service(service.Name, service.Namespace).
+		WithLabels(map[string]string{managedByLabel: eventComponent}).
+		WithOwnerReferences(owner).
+		WithSpec(spec)
+	_, err = services.Apply(ctx, svc, metav1.ApplyOptions{FieldManager: eventComponent, Force: true})
+	return err
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// hostAccessDeployment is a Deployment of the given pod spec selecting the
+// pods by app label
+func hostAccessDeployment(spec corev1.PodSpec) *appsv1.Deployment {
+	labels := map[string]string{"app": "konnectivity-agent"}
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "konnectivity-agent", Namespace: "clusters-test"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}, Spec: spec},
+		},
+	}
+}
+
+func hostPathVolume(name, path string, hostPathType corev1.HostPathType) corev1.Volume {
+	return corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: path, Type: &hostPathType}}}
+}
+
+func admissionRequestOf(t *testing.T, obj runtime.Object) *admissionv1.AdmissionRequest {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Kind: obj.GetObjectKind().GroupVersionKind().Kind},
+		Name:      "konnectivity-agent",
+		Namespace: "clusters-test",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestHostAccessConverter_Plan(t *testing.T) {
+	withServices := &hostAccessConverter{emptyDirPaths: []string{"/tmp", "/var/tmp"}, services: newHostPortServices(fake.NewClientset(), time.Minute)}
+	withoutServices := &hostAccessConverter{emptyDirPaths: []string{"/tmp"}}
+	hostNetwork := corev1.PodSpec{
+		HostNetwork: true,
+		DNSPolicy:   corev1.DNSClusterFirstWithHostNet,
+		Containers: []corev1.Container{{
+			Name: "agent",
+			Ports: []corev1.ContainerPort{
+				{Name: "health", ContainerPort: 2041, HostPort: 2041},
+				{ContainerPort: 8134},
+			},
+		}},
+	}
+
+	for _, tc := range []struct {
+		name        string
+		converter   *hostAccessConverter
+		obj         runtime.Object
+		converted   []string
+		unconverted map[string]string
+		patches     []string
+		ports       []corev1.ServicePort
+	}{
+		{
+			name:      "scratch hostPath",
+			converter: withServices,
+			obj: hostAccessDeployment(corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				hostPathVolume("cache", "/var/tmp/../tmp/cache", corev1.HostPathDirectoryOrCreate),
+			}}),
+			converted: []string{"spec.template.spec.volumes[1].hostPath"},
+			patches:   []string{"replace /spec/template/spec/volumes/1"},
+		},
+		{
+			name:      "node data and sockets",
+			converter: withServices,
+			obj: hostAccessDeployment(corev1.PodSpec{Volumes: []corev1.Volume{
+				hostPathVolume("logs", "/var/log", corev1.HostPathUnset),
+				hostPathVolume("socket", "/tmp/agent.sock", corev1.HostPathSocket),
+				hostPathVolume("prefix", "/tmpfs", corev1.HostPathDirectory),
+			}}),
+			unconverted: map[string]string{
+				"spec.template.spec.volumes[0].hostPath": "only hostPaths under /tmp, /var/tmp",
+				"spec.template.spec.volumes[1].hostPath": "a Socket of the node",
+				"spec.template.spec.volumes[2].hostPath": "only hostPaths under",
+			},
+		},
+		{
+			name:      "host network",
+			converter: withServices,
+			obj:       hostAccessDeployment(hostNetwork),
+			converted: []string{
+				"spec.template.spec.containers[0].ports[0].hostPort",
+				"spec.template.spec.hostNetwork",
+			},
+			patches: []string{
+				"remove /spec/template/spec/containers/0/ports/0/hostPort",
+				"remove /spec/template/spec/hostNetwork",
+				"replace /spec/template/spec/dnsPolicy",
+			},
+			ports: []corev1.ServicePort{
+				{Name: "health", Protocol: corev1.ProtocolTCP, Port: 2041, TargetPort: intstr.FromInt32(2041)},
+				{Name: "tcp-8134", Protocol: corev1.ProtocolTCP, Port: 8134, TargetPort: intstr.FromInt32(8134)},
+			},
+		},
+		{
+			name:      "host port remapped",
+			converter: withServices,
+			obj: hostAccessDeployment(corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "agent",
+				Ports: []corev1.ContainerPort{{ContainerPort: 8080, HostPort: 80, HostIP: "0.0.0.0", Protocol: corev1.ProtocolUDP}},
+			}}}),
+			converted: []string{"spec.template.spec.containers[0].ports[0].hostPort"},
+			patches: []string{
+				"remove /spec/template/spec/containers/0/ports/0/hostPort",
+				"remove /spec/template/spec/containers/0/ports/0/hostIP",
+			},
+			ports: []corev1.ServicePort{{Name: "udp-80", Protocol: corev1.ProtocolUDP, Port: 80, TargetPort: intstr.FromInt32(8080)}},
+		},
+		{
+			name:      "host network outside a cluster",
+			converter: withoutServices,
+			obj:       hostAccessDeployment(hostNetwork),
+			unconverted: map[string]string{
+				"spec.template.spec.containers[0].ports[0].hostPort": "outside a cluster",
+				"spec.template.spec.hostNetwork":                     "outside a cluster",
+			},
+		},
+		{
+			name:      "host network without ports",
+			converter: withServices,
+			obj:       hostAccessDeployment(corev1.PodSpec{HostNetwork: true, Containers: []corev1.Container{{Name: "agent"}}}),
+			unconverted: map[string]string{
+				"spec.template.spec.hostNetwork": "declares no container ports",
+			},
+		},
+		{
+			name:      "pod host port",
+			converter: withServices,
+			obj: &corev1.Pod{
+				TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:  "agent",
+					Ports: []corev1.ContainerPort{{ContainerPort: 80, HostPort: 80}},
+				}}},
+			},
+			unconverted: map[string]string{
+				"spec.containers[0].ports[0].hostPort": "only the ports of Deployments and StatefulSets",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := admissionRequestOf(t, tc.obj)
+			workload, err := admissionWorkloadOf(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan := tc.converter.Plan(req, workload)
+
+			if len(plan.Converted) != len(tc.converted) {
+				t.Errorf("converted = %v, want %v", plan.Converted, tc.converted)
+			}
+			for _, field := range tc.converted {
+				if !plan.Converted[field] {
+					t.Errorf("%s not converted", field)
+				}
+			}
+			if len(plan.Unconverted) != len(tc.unconverted) {
+				t.Errorf("unconverted = %v, want %v", plan.Unconverted, tc.unconverted)
+			}
+			for field, reason := range tc.unconverted {
+				if !strings.Contains(plan.Unconverted[field], reason) {
+					t.Errorf("%s unconverted for %q, want %q", field, plan.Unconverted[field], reason)
+				}
+			}
+
+			var patches []string
+			for _, p := range plan.Patches {
+				patches = append(patches, p.Op+" "+p.Path)
+			}
+			if strings.Join(patches, ", ") != strings.Join(tc.patches, ", ") {
+				t.Errorf("patches = %v, want %v", patches, tc.patches)
+			}
+			if len(plan.Patches) > 0 {
+				// The patches apply to the object they were planned for
+				data, _ := json.Marshal(plan.Patches)
+				patch, _ := jsonpatch.DecodePatch(data)
+				if _, err := patch.Apply(req.Object.Raw); err != nil {
+					t.Errorf("patches %s do not apply: %v", data, err)
+				}
+			}
+
+			if tc.ports == nil {
+				if plan.Service != nil {
+					t.Errorf("Service = %+v, want none", plan.Service)
+				}
+				return
+			}
+			if plan.Service == nil {
+				t.Fatal("no Service for the host ports")
+			}
+			if plan.Service.Name != "konnectivity-agent-host-ports" || plan.Service.Kind != "Deployment" {
+				t.Errorf("Service = %+v", plan.Service)
+			}
+			if len(plan.Service.Ports) != len(tc.ports) {
+				t.Fatalf("Service ports = %+v, want %+v", plan.Service.Ports, tc.ports)
+			}
+			for i, port := range tc.ports {
+				if plan.Service.Ports[i] != port {
+					t.Errorf("Service port %d = %+v, want %+v", i, plan.Service.Ports[i], port)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckViolations_HostAccess(t *testing.T) {
+	quietLogs(t)
+	ws := &WebhookServer{
+		violations: violationPolicy{
+			violationHostPath:       violationAdmit,
+			violationPrivileged:     violationAdmit,
+			violationHostNamespaces: violationAdmit,
+			violationHostPorts:      violationAdmit,
+		},
+		hostAccess: &hostAccessConverter{emptyDirPaths: []string{"/tmp"}},
+	}
+
+	// A scratch directory is converted and the object admitted with a warning
+	scratch := admissionRequestOf(t, hostAccessDeployment(corev1.PodSpec{
+		Volumes: []corev1.Volume{hostPathVolume("cache", "/tmp/cache", corev1.HostPathUnset)},
+	}))
+	denied, warnings := ws.checkViolations(scratch, ws.hostAccessPlan(scratch))
+	if denied != "" || len(warnings) != 1 || !strings.Contains(warnings[0], "replaced with an emptyDir") {
+		t.Errorf("checkViolations() = %q, %v, want a conversion warning", denied, warnings)
+	}
+
+	// The rest is denied whatever the policy, with the reason
+	nodeData := admissionRequestOf(t, hostAccessDeployment(corev1.PodSpec{
+		Volumes:    []corev1.Volume{hostPathVolume("cache", "/tmp/cache", corev1.HostPathUnset), hostPathVolume("logs", "/var/log", corev1.HostPathUnset)},
+		Containers: []corev1.Container{{Name: "agent", Ports: []corev1.ContainerPort{{ContainerPort: 80, HostPort: 80}}}},
+	}))
+	denied, _ = ws.checkViolations(nodeData, ws.hostAccessPlan(nodeData))
+	for _, want := range []string{
+		"spec.template.spec.volumes[1].hostPath (volume \"logs\" mounts /var/log from the node; only hostPaths under /tmp",
+		"spec.template.spec.containers[0].ports[0].hostPort",
+		"outside a cluster",
+	} {
+		if !strings.Contains(denied, want) {
+			t.Errorf("denial %q does not contain %q", denied, want)
+		}
+	}
+	if strings.Contains(denied, "volumes[0]") {
+		t.Errorf("denial %q lists the converted volume", denied)
+	}
+
+	// Violations the converter does not handle keep their policy
+	hostPID := admissionRequestOf(t, hostAccessDeployment(corev1.PodSpec{HostPID: true}))
+	if denied, warnings := ws.checkViolations(hostPID, ws.hostAccessPlan(hostPID)); denied != "" || len(warnings) != 0 {
+		t.Errorf("checkViolations() = %q, %v, want hostPID admitted", denied, warnings)
+	}
+
+	// Without the converter the policy applies to all
+	ws.hostAccess = nil
+	if denied, _ := ws.checkViolations(nodeData, ws.hostAccessPlan(nodeData)); denied != "" {
+		t.Errorf("checkViolations() = %q without conversion, want the admit policy", denied)
+	}
+}
+
+func TestMutate_HostAccess(t *testing.T) {
+	quietLogs(t)
+	ws := fuzzServer(t)
+	deployment := hostAccessDeployment(corev1.PodSpec{
+		Volumes:    []corev1.Volume{hostPathVolume("tmp", "/tmp", corev1.HostPathDirectory)},
+		Containers: []corev1.Container{{Name: "agent", VolumeMounts: []corev1.VolumeMount{{Name: "tmp", MountPath: "/tmp"}}}},
+	})
+	raw, _ := json.Marshal(deployment)
+	response := checkAdmission(t, ws, reviewOf("Deployment", "clusters-test", raw))
+	if !response.Allowed {
+		t.Fatalf("denied: %s", response.Result.Message)
+	}
+	patch, _ := jsonpatch.DecodePatch(response.Patch)
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatalf("patch %s does not apply: %v", response.Patch, err)
+	}
+	var got appsv1.Deployment
+	if err := json.Unmarshal(patched, &got); err != nil {
+		t.Fatal(err)
+	}
+	if volume := got.Spec.Template.Spec.Volumes[0]; volume.HostPath != nil || volume.EmptyDir == nil || volume.Name != "tmp" {
+		t.Errorf("volume = %+v, want an emptyDir", volume)
+	}
+	if len(response.Warnings) == 0 {
+		t.Error("no warning about the conversion")
+	}
+}
+
+func TestMutationProfile_HostAccessOptOut(t *testing.T) {
+	quietLogs(t)
+	ws := fuzzServer(t)
+	tenant := mutationProfileOf("clusters-test", "tenant", AutopilotMutationProfileSpec{
+		OptOut: MutationOptOut{Mutations: []string{mutationHostAccess}},
+	})
+	ws.profiles = profileResolver(profileNamespace("tenant"), tenant)
+	req := admissionRequestOf(t, hostAccessDeployment(corev1.PodSpec{
+		Volumes: []corev1.Volume{hostPathVolume("tmp", "/tmp", corev1.HostPathDirectory)},
+	}))
+	if plan := ws.hostAccessPlan(req); len(plan.Patches) != 0 || len(plan.Converted) != 0 {
+		t.Errorf("hostAccessPlan() = %+v, want none in an opted out namespace", plan)
+	}
+}
+
+func TestHostPortServiceName(t *testing.T) {
+	for workload, want := range map[string]string{
+		"konnectivity-agent":           "konnectivity-agent-host-ports",
+		"agent.v2":                     "agent-v2-host-ports",
+		"1-agent":                      "w-1-agent-host-ports",
+		strings.Repeat("a", 60) + "-b": strings.Repeat("a", 52) + "-host-ports",
+	} {
+		if got := hostPortServiceName(workload); got != want || len(got) > 63 {
+			t.Errorf("hostPortServiceName(%q) = %q, want %q", workload, got, want)
+		}
+	}
+}
+
+func TestHostPortServices_Ensure(t *testing.T) {
+	deployment := hostAccessDeployment(corev1.PodSpec{})
+	deployment.UID = "agent-uid"
+	// A Service of the same name created by someone else
+	foreign := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "router-host-ports", Namespace: "clusters-test"}}
+	router := hostAccessDeployment(corev1.PodSpec{})
+	router.Name = "router"
+	client := fake.NewClientset(deployment, router, foreign)
+	s := newHostPortServices(client, time.Minute)
+
+	ports := []corev1.ServicePort{{Name: "health", Protocol: corev1.ProtocolTCP, Port: 2041, TargetPort: intstr.FromInt32(2041)}}
+	for _, workload := range []string{"konnectivity-agent", "router", "kube-scheduler"} {
+		s.track(hostPortService{Name: hostPortServiceName(workload), Namespace: "clusters-test", Kind: "Deployment", Workload: workload, Ports: ports})
+	}
+
+	ctx := context.Background()
+	// kube-scheduler was admitted on creation and is not stored yet
+	if pending := s.ensure(ctx); !pending {
+		t.Error("ensure() = false, want kube-scheduler retried")
+	}
+
+	service, err := client.CoreV1().Services("clusters-test").Get(ctx, "konnectivity-agent-host-ports", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.Spec.Type != corev1.ServiceTypeClusterIP || service.Spec.Selector["app"] != "konnectivity-agent" {
+		t.Errorf("Service spec = %+v", service.Spec)
+	}
+	if len(service.Spec.Ports) != 1 || service.Spec.Ports[0].Port != 2041 || service.Spec.Ports[0].TargetPort != intstr.FromInt32(2041) {
+		t.Errorf("Service ports = %+v", service.Spec.Ports)
+	}
+	if len(service.OwnerReferences) != 1 || service.OwnerReferences[0].UID != "agent-uid" || service.Labels[managedByLabel] != eventComponent {
+		t.Errorf("Service metadata = %+v, want owned by the Deployment and managed by the webhook", service.ObjectMeta)
+	}
+
+	if existing, _ := client.CoreV1().Services("clusters-test").Get(ctx, "router-host-ports", metav1.GetOptions{}); len(existing.Spec.Ports) != 0 {
+		t.Errorf("foreign Service = %+v, want it left alone", existing.Spec)
+	}
+	if _, ok := s.targets[types.NamespacedName{Namespace: "clusters-test", Name: "kube-scheduler-host-ports"}]; !ok {
+		t.Error("kube-scheduler dropped before the resync interval")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// imageRewriteRule moves the images of a registry or repository to a mirror,
+// e.g. registry.redhat.io to a regional Artifact Registry repository, so
+// hosted control planes run where the upstream registries are unreachable
+type imageRewriteRule struct {
+	// From is a registry (registry.redhat.io) or a repository prefix
+	// (quay.io/openshift-release-dev), matched on whole path segments
+	From string `json:"from"`
+	// To replaces From, keeping the rest of the repository, the tag and the
+	// digest, e.g. us-central1-docker.pkg.dev/my-project/redhat
+	To string `json:"to"`
+	// DigestOnly rewrites only references pinned by digest, for mirrors
+	// populated by digest like those of oc-mirror, where tags may be missing
+	DigestOnly bool `json:"digestOnly,omitempty"`
+}
+
+// imageRewriteRules are the rules of the webhook or of a namespace. The rule
+// with the longest From matching an image applies.
+type imageRewriteRules []imageRewriteRule
+
+// newImageRewriteRulesFromEnv reads the rules of IMAGE_REWRITE_FILE, a YAML or
+// JSON list:
+//
+//   - from: registry.redhat.io
+//     to: us-central1-docker.pkg.dev/my-project/redhat
+//   - from: quay.io/openshift-release-dev
+//     to: us-central1-docker.pkg.dev/my-project/ocp-release
+//     digestOnly: true
+//
+// It returns no rules when the variable is unset, leaving images alone.
+func newImageRewriteRulesFromEnv() (imageRewriteRules, error) {
+	path := os.Getenv("IMAGE_REWRITE_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read IMAGE_REWRITE_FILE: %v", err)
+	}
+	rules, err := parseImageRewriteRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMAGE_REWRITE_FILE %s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// parseImageRewriteRules reads rules from YAML or JSON, rejecting unknown
+// fields like parseComponentOverrides
+func parseImageRewriteRules(data []byte) (imageRewriteRules, error) {
+	var rules imageRewriteRules
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, err
+	}
+	if err := rules.validate(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// validate checks that every rule maps a repository to a repository, once
+func (r imageRewriteRules) validate() error {
+	seen := map[string]bool{}
+	for i, rule := range r {
+		for field, repository := range map[string]string{"from": rule.From, "to": rule.To} {
+			switch {
+			case repository == "":
+				return fmt.Errorf("rule %d: %s is required", i, field)
+			case strings.Contains(repository, "://"):
+				return fmt.Errorf("rule %d: %s %q must be a registry or repository, not a URL", i, field, repository)
+			case strings.HasSuffix(repository, "/"):
+				return fmt.Errorf("rule %d: %s %q must not end with /", i, field, repository)
+			case hasTagOrDigest(repository):
+				return fmt.Errorf("rule %d: %s %q must not have a tag or digest, they are kept from the image", i, field, repository)
+			}
+		}
+		if seen[rule.From] {
+			return fmt.Errorf("rule %d: more than one rule for %s", i, rule.From)
+		}
+		seen[rule.From] = true
+	}
+	return nil
+}
+
+// merge returns the rules with those of other, which replace the rules of the
+// same From
+func (r imageRewriteRules) merge(other imageRewriteRules) imageRewriteRules {
+	if len(other) == 0 {
+		return r
+	}
+	replaced := map[string]bool{}
+	for _, rule := range other {
+		replaced[rule.From] = true
+	}
+	var merged imageRewriteRules
+	for _, rule := range r {
+		if !replaced[rule.From] {
+			merged = append(merged, rule)
+		}
+	}
+	return append(merged, other...)
+}
+
+// String lists the rules, for the startup log
+func (r imageRewriteRules) String() string {
+	if len(r) == 0 {
+		return "none"
+	}
+	var entries []string
+	for _, rule := range r {
+		entry := rule.From + "=" + rule.To
+		if rule.DigestOnly {
+			entry += " (digests only)"
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, ", ")
+}
+
+// Rewrite returns the image on the mirror of the rule matching it, keeping
+// its tag and digest, and the rule; ok is false when no rule applies
+func (r imageRewriteRules) Rewrite(image string) (rewritten string, rule imageRewriteRule, ok bool) {
+	name, reference := splitImageReference(image)
+	digest := strings.Contains(reference, "@")
+	for _, candidate := range r {
+		if candidate.DigestOnly && !digest {
+			continue
+		}
+		if name != candidate.From && !strings.HasPrefix(name, candidate.From+"/") {
+			continue
+		}
+		if !ok || len(candidate.From) > len(rule.From) {
+			rule, ok = candidate, true
+		}
+	}
+	if !ok {
+		return image, rule, false
+	}
+	return rule.To + strings.TrimPrefix(name, rule.From) + reference, rule, true
+}
+
+// Patches rewrites the images of the containers and init containers of a pod
+// spec at field, e.g. spec.template.spec
+func (r imageRewriteRules) Patches(spec *corev1.PodSpec, field string) []patchOperation {
+	if len(r) == 0 {
+		return nil
+	}
+	base := "/" + strings.ReplaceAll(field, ".", "/")
+
+	var patches []patchOperation
+	for _, list := range []struct {
+		field      string
+		containers []corev1.Container
+	}{
+		{"initContainers", spec.InitContainers},
+		{"containers", spec.Containers},
+	} {
+		for i, c := range list.containers {
+			image, rule, ok := r.Rewrite(c.Image)
+			if !ok || image == c.Image {
+				continue
+			}
+			patches = append(patches, patchOperation{Op: "replace", Path: fmt.Sprintf("%s/%s/%d/image", base, list.field, i), Value: image})
+			imageRewritesTotal.WithLabelValues(rule.From).Inc()
+		}
+	}
+	return patches
+}
+
+// imageRewritePatches rewrites the images of the Deployment, StatefulSet or
+// Pod under admission with the rules of the namespace. Images are rewritten
+// for every workload in scope, not only HyperShift's, as a pod pulling from
+// an unreachable registry never starts.
+func (ws *WebhookServer) imageRewritePatches(req *admissionv1.AdmissionRequest) []patchOperation {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return nil
+	}
+	// checkViolations logs objects that cannot be decoded
+	workload, err := admissionWorkloadOf(req)
+	if err != nil || workload == nil {
+		return nil
+	}
+	profile := ws.profile(req.Namespace)
+	if len(profile.imageRewrites) == 0 {
+		return nil
+	}
+	if profile.skipsComponent(workload.Component) {
+		log.Printf("%s %s opted out of image rewrites by %s", req.Kind.Kind, workload.Name, profile)
+		return nil
+	}
+	return profile.imageRewrites.Patches(workload.Spec, workload.Field)
+}
+
+// splitImageReference splits an image into its repository and the tag and
+// digest following it, e.g. ":4.16@sha256:..."
+func splitImageReference(image string) (name, reference string) {
+	name = image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i:]
+	}
+	// A colon after the last slash starts the tag, one before is a port
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, reference = name[:i], name[i:]+reference
+	}
+	return name, reference
+}
+
+// hasTagOrDigest tells whether a repository of a rule has a tag or digest. A
+// registry alone, without a slash, may have a port.
+func hasTagOrDigest(repository string) bool {
+	if strings.Contains(repository, "@") {
+		return true
+	}
+	i := strings.LastIndex(repository, "/")
+	return i >= 0 && strings.Contains(repository[i+1:], ":")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const testDigest = "@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+var testImageRewrites = imageRewriteRules{
+	{From: "registry.redhat.io", To: "us-central1-docker.pkg.dev/my-project/redhat"},
+	{From: "quay.io/openshift-release-dev", To: "us-central1-docker.pkg.dev/my-project/ocp-release", DigestOnly: true},
+	{From: "quay.io/openshift-release-dev/ocp-v4.0-art-dev", To: "mirror.example.com:5000/art"},
+}
+
+func TestImageRewriteRules_Rewrite(t *testing.T) {
+	for _, tc := range []struct {
+		image, want string
+	}{
+		// The tag and digest are kept
+		{"registry.redhat.io/rhel9/etcd:4.16", "us-central1-docker.pkg.dev/my-project/redhat/rhel9/etcd:4.16"},
+		{"registry.redhat.io/rhel9/etcd" + testDigest, "us-central1-docker.pkg.dev/my-project/redhat/rhel9/etcd" + testDigest},
+		{"registry.redhat.io/rhel9/etcd:4.16" + testDigest, "us-central1-docker.pkg.dev/my-project/redhat/rhel9/etcd:4.16" + testDigest},
+		{"registry.redhat.io/ubi9", "us-central1-docker.pkg.dev/my-project/redhat/ubi9"},
+		// Digest-only rules leave tags alone
+		{"quay.io/openshift-release-dev/ocp-release" + testDigest, "us-central1-docker.pkg.dev/my-project/ocp-release/ocp-release" + testDigest},
+		{"quay.io/openshift-release-dev/ocp-release:4.16.0-x86_64", "quay.io/openshift-release-dev/ocp-release:4.16.0-x86_64"},
+		// The longest from wins, matching the whole repository
+		{"quay.io/openshift-release-dev/ocp-v4.0-art-dev" + testDigest, "mirror.example.com:5000/art" + testDigest},
+		{"quay.io/openshift-release-dev/ocp-v4.0-art-dev:tag", "mirror.example.com:5000/art:tag"},
+		// Whole path segments only
+		{"registry.redhat.io.example.com/ubi9:latest", "registry.redhat.io.example.com/ubi9:latest"},
+		{"quay.io/openshift-release-dev-fork/ocp-release" + testDigest, "quay.io/openshift-release-dev-fork/ocp-release" + testDigest},
+		{"nginx:latest", "nginx:latest"},
+		{"", ""},
+	} {
+		got, _, _ := testImageRewrites.Rewrite(tc.image)
+		if got != tc.want {
+			t.Errorf("Rewrite(%q) = %q, want %q", tc.image, got, tc.want)
+		}
+	}
+}
+
+func TestParseImageRewriteRules(t *testing.T) {
+	rules, err := parseImageRewriteRules([]byte(`
+- from: registry.redhat.io
+  to: us-central1-docker.pkg.dev/my-project/redhat
+- from: localhost:5000/openshift
+  to: mirror.example.com
+  digestOnly: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || !rules[1].DigestOnly || rules[1].From != "localhost:5000/openshift" {
+		t.Errorf("rules = %+v", rules)
+	}
+
+	for name, data := range map[string]string{
+		"unknown field":  "- {from: registry.redhat.io, to: mirror.example.com, digest: true}\n",
+		"missing to":     "- {from: registry.redhat.io}\n",
+		"missing from":   "- {to: mirror.example.com}\n",
+		"tag":            "- {from: registry.redhat.io/ubi9:latest, to: mirror.example.com/ubi9}\n",
+		"digest":         "- {from: registry.redhat.io, to: mirror.example.com/redhat" + testDigest + "}\n",
+		"URL":            "- {from: https://registry.redhat.io, to: mirror.example.com}\n",
+		"trailing slash": "- {from: registry.redhat.io/, to: mirror.example.com}\n",
+		"duplicate from": "- {from: registry.redhat.io, to: a.example.com}\n- {from: registry.redhat.io, to: b.example.com}\n",
+		"not a list":     "registry.redhat.io: mirror.example.com\n",
+	} {
+		if _, err := parseImageRewriteRules([]byte(data)); err == nil {
+			t.Errorf("%s: parseImageRewriteRules() succeeded", name)
+		}
+	}
+}
+
+func TestImageRewriteRules_Merge(t *testing.T) {
+	tenant := imageRewriteRules{
+		{From: "registry.redhat.io", To: "europe-west1-docker.pkg.dev/my-project/redhat"},
+		{From: "registry.access.redhat.com", To: "europe-west1-docker.pkg.dev/my-project/redhat-access"},
+	}
+	merged := testImageRewrites.merge(tenant)
+	if len(merged) != 4 {
+		t.Fatalf("merge() = %v, want the 3 rules with registry.redhat.io replaced and 1 added", merged)
+	}
+	if got, _, _ := merged.Rewrite("registry.redhat.io/ubi9:latest"); got != "europe-west1-docker.pkg.dev/my-project/redhat/ubi9:latest" {
+		t.Errorf("Rewrite() = %q, want the mirror of the tenant", got)
+	}
+	if got, _, _ := testImageRewrites.Rewrite("registry.redhat.io/ubi9:latest"); got != "us-central1-docker.pkg.dev/my-project/redhat/ubi9:latest" {
+		t.Errorf("Rewrite() = %q, the merge changed the rules of the webhook", got)
+	}
+}
+
+func TestMutate_ImageRewrite(t *testing.T) {
+	quietLogs(t)
+	deployment := kubeAPIServerDeployment()
+	spec := &deployment.Spec.Template.Spec
+	spec.InitContainers = []corev1.Container{{Name: "init", Image: "registry.redhat.io/ubi9" + testDigest}}
+	spec.Containers[0].Image = "registry.redhat.io/openshift4/ose-cli:v4.16"
+	spec.Containers[1].Image = "quay.io/openshift-release-dev/ocp-v4.0-art-dev" + testDigest
+	spec.Containers[2].Image = "gcr.io/my-project/konnectivity:latest"
+
+	got := admit(t, &WebhookServer{overrides: defaultComponentOverrides, imageRewrites: testImageRewrites}, deployment, "Deployment")
+	for name, want := range map[string]string{
+		"init":                "us-central1-docker.pkg.dev/my-project/redhat/ubi9" + testDigest,
+		"apply-bootstrap":     "us-central1-docker.pkg.dev/my-project/redhat/openshift4/ose-cli:v4.16",
+		"kube-apiserver":      "mirror.example.com:5000/art" + testDigest,
+		"konnectivity-server": "gcr.io/my-project/konnectivity:latest",
+	} {
+		var image string
+		for _, c := range append(got.InitContainers, got.Containers...) {
+			if c.Name == name {
+				image = c.Image
+			}
+		}
+		if image != want {
+			t.Errorf("%s image = %q, want %q", name, image, want)
+		}
+	}
+	// The other mutations still apply
+	if got.Containers[1].Resources.Requests == nil {
+		t.Error("kube-apiserver lost its resources")
+	}
+
+	// Without rules images are left alone
+	got = admit(t, &WebhookServer{overrides: defaultComponentOverrides}, deployment, "Deployment")
+	if got.Containers[0].Image != "registry.redhat.io/openshift4/ose-cli:v4.16" {
+		t.Errorf("image = %q without rules, want it unchanged", got.Containers[0].Image)
+	}
+}
+
+func TestImageRewritePatches_Pod(t *testing.T) {
+	quietLogs(t)
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "clusters-test"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "debug", Image: "registry.redhat.io/ubi9:latest"}}},
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Namespace: "clusters-test",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+	ws := &WebhookServer{imageRewrites: testImageRewrites}
+	patches := ws.imageRewritePatches(req)
+	if len(patches) != 1 || patches[0].Path != "/spec/containers/0/image" || patches[0].Value != "us-central1-docker.pkg.dev/my-project/redhat/ubi9:latest" {
+		t.Errorf("patches = %+v, want the image of the pod rewritten", patches)
+	}
+	if summary := summarizePatches(patches); summary != "rewrote the image of 1 container to a mirror" {
+		t.Errorf("summarizePatches() = %q", summary)
+	}
+
+	req.Operation = admissionv1.Delete
+	if patches := ws.imageRewritePatches(req); len(patches) != 0 {
+		t.Errorf("patches = %+v on delete, want none", patches)
+	}
+}
+
+func TestMutationProfile_ImageRewrites(t *testing.T) {
+	quietLogs(t)
+	deployment := kubeAPIServerDeployment()
+	deployment.Spec.Template.Spec.Containers[0].Image = "registry.redhat.io/ubi9:latest"
+
+	tenant := mutationProfileOf("clusters-test", "tenant", AutopilotMutationProfileSpec{
+		ImageRewrites: imageRewriteRules{{From: "registry.redhat.io", To: "europe-west1-docker.pkg.dev/my-project/redhat"}},
+	})
+	ws := &WebhookServer{imageRewrites: testImageRewrites, profiles: profileResolver(profileNamespace("tenant"), tenant)}
+	if got := admit(t, ws, deployment, "Deployment").Containers[0].Image; got != "europe-west1-docker.pkg.dev/my-project/redhat/ubi9:latest" {
+		t.Errorf("image = %q, want the mirror of the profile", got)
+	}
+
+	// A namespace can add rules without any in the webhook
+	ws.imageRewrites = nil
+	if got := admit(t, ws, deployment, "Deployment").Containers[0].Image; !strings.HasPrefix(got, "europe-west1-docker.pkg.dev/") {
+		t.Errorf("image = %q, want the mirror of the profile without webhook rules", got)
+	}
+
+	optOut := mutationProfileOf("clusters-test", "tenant", AutopilotMutationProfileSpec{
+		OptOut: MutationOptOut{Mutations: []string{mutationImageRewrite}},
+	})
+	ws = &WebhookServer{imageRewrites: testImageRewrites, profiles: profileResolver(profileNamespace("tenant"), optOut)}
+	if got := admit(t, ws, deployment, "Deployment").Containers[0].Image; got != "registry.redhat.io/ubi9:latest" {
+		t.Errorf("image = %q, want it unchanged when opted out", got)
+	}
+
+	invalid := AutopilotMutationProfileSpec{ImageRewrites: imageRewriteRules{{From: "registry.redhat.io"}}}
+	if err := invalid.validate(); err == nil || !strings.Contains(err.Error(), "imageRewrites") {
+		t.Errorf("validate() = %v, want the invalid rule rejected", err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -8,13 +9,21 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	admissionv1 "k8s.io/api/admission/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -23,7 +32,22 @@ var (
 )
 
 type WebhookServer struct {
-	server *http.Server
+	server        *http.Server
+	rateGuard     *mutationRateGuard
+	recorder      record.EventRecorder
+	routes        *routeTranslator
+	hcps          *hostedControlPlaneCache
+	violations    violationPolicy
+	rightSizer    *rightSizer
+	topology      *topologySpreadPolicy
+	priorities    *priorityClassManager
+	overrides     componentOverrides
+	canary        *mutationCanary
+	autopilot     *autopilotVersions
+	profiles      *mutationProfileResolver
+	autoscaling   *autoscalingManager
+	hostAccess    *hostAccessConverter
+	imageRewrites imageRewriteRules
 }
 
 type patchOperation struct {
@@ -36,25 +60,208 @@ func main() {
 	certPath := "/etc/certs/tls.crt"
 	keyPath := "/etc/certs/tls.key"
 
-	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	bootstrapper, err := newCertBootstrapperFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid certificate bootstrap configuration: %v", err)
+	}
+
+	tlsConfig := &tls.Config{}
+	if bootstrapper == nil {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			log.Fatalf("Failed to load key pair: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else {
+		// Generate or reuse a self-signed certificate instead of mounting one,
+		// served from the Secret so renewals apply without a restart
+		if _, _, err := bootstrapper.Certificate(context.Background()); err != nil {
+			log.Fatalf("Failed to bootstrap serving certificate: %v", err)
+		}
+		tlsConfig.GetCertificate = bootstrapper.GetCertificate
+		go bootstrapper.Run(context.Background())
+	}
+
+	rateGuard, err := newMutationRateGuardFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid rate guard configuration: %v", err)
+	}
+	if rateGuard == nil {
+		log.Println("Mutation rate guard disabled")
+	} else {
+		log.Printf("Mutation rate guard: max %d admissions per object per %s, cool-down %s",
+			rateGuard.maxAdmissions, rateGuard.window, rateGuard.cooldown)
+	}
+
+	routes, err := newRouteTranslatorFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid route translation configuration: %v", err)
+	}
+	if routes == nil {
+		log.Println("Route translation disabled")
+	} else {
+		log.Printf("Translating Routes onto Gateway %s/%s", routes.gatewayNamespace, routes.gatewayName)
+	}
+
+	violations, err := newViolationPolicyFromEnv()
 	if err != nil {
-		log.Fatalf("Failed to load key pair: %v", err)
+		log.Fatalf("Invalid violation policy: %v", err)
+	}
+	log.Printf("Violation policy: %s", violations)
+
+	topology, err := newTopologySpreadPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid topology spread configuration: %v", err)
+	}
+	log.Printf("Zone topology spread: %s", topology)
+
+	priorities, err := newPriorityClassManagerFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid PriorityClass configuration: %v", err)
+	}
+	if priorities != nil {
+		log.Printf("PriorityClass tiers: %s", priorities)
+		go priorities.Run(context.Background())
+	}
+
+	overrides, err := newComponentOverridesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid component overrides: %v", err)
+	}
+	log.Printf("Component overrides: %s", overrides)
+
+	rightSizer, err := newRightSizerFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid right-sizing configuration: %v", err)
+	}
+	if rightSizer == nil {
+		log.Println("Right-sizing disabled, using static resource requests")
+	} else {
+		log.Printf("Right-sizing from %s usage with %d%% headroom", rightSizer.source.Name(), rightSizer.headroom)
+		go rightSizer.source.Run(context.Background())
+	}
+
+	canary, err := newMutationCanaryFromEnv(overrides, rightSizer)
+	if err != nil {
+		log.Fatalf("Invalid canary configuration: %v", err)
+	}
+	if canary == nil {
+		log.Println("Mutation canary disabled, all namespaces on the stable profile")
+	} else {
+		log.Printf("Mutation canary: %s", canary)
+		canaryPercent.Set(float64(canary.percent))
+	}
+
+	autopilot, err := newAutopilotVersionsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid Autopilot version configuration: %v", err)
+	}
+	log.Printf("Autopilot constraints: %s", autopilot)
+	go autopilot.Run(context.Background())
+
+	hcps, err := newHostedControlPlaneCacheFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid HostedControlPlane cache configuration: %v", err)
+	}
+	if hcps != nil {
+		go hcps.Run(context.Background())
+	}
+
+	autoscaling, err := newAutoscalingManagerFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid autoscaling configuration: %v", err)
+	}
+	if autoscaling != nil {
+		log.Printf("HPA and PDB generation: %s", autoscaling.defaults)
+		go autoscaling.Run(context.Background())
+	}
+
+	hostAccess, err := newHostAccessConverterFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid host access conversion configuration: %v", err)
+	}
+	if hostAccess == nil {
+		log.Println("Host access conversion disabled")
+	} else {
+		log.Printf("Host access conversion: %s", hostAccess)
+		if hostAccess.services != nil {
+			go hostAccess.services.Run(context.Background())
+		}
+	}
+
+	imageRewrites, err := newImageRewriteRulesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid image rewrite configuration: %v", err)
+	}
+	log.Printf("Image rewrites: %s", imageRewrites)
+
+	profiles, err := newMutationProfileResolverFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid AutopilotMutationProfile configuration: %v", err)
+	}
+	if profiles != nil {
+		go profiles.Run(context.Background())
+	}
+
+	shutdownTracing, err := setupTracingFromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Invalid tracing configuration: %v", err)
+	}
+	if shutdownTracing == nil {
+		log.Println("Tracing disabled")
+	} else {
+		log.Println("Exporting admission traces over OTLP")
 	}
 
 	server := &WebhookServer{
 		server: &http.Server{
 			Addr:      ":8443",
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+			TLSConfig: tlsConfig,
 		},
+		rateGuard:     rateGuard,
+		recorder:      newEventRecorder(),
+		routes:        routes,
+		hcps:          hcps,
+		violations:    violations,
+		rightSizer:    rightSizer,
+		topology:      topology,
+		priorities:    priorities,
+		overrides:     overrides,
+		canary:        canary,
+		autopilot:     autopilot,
+		profiles:      profiles,
+		autoscaling:   autoscaling,
+		hostAccess:    hostAccess,
+		imageRewrites: imageRewrites,
+	}
+
+	if routes != nil {
+		routes.recorder = server.recorder
+		go routes.Run(context.Background())
+	}
+
+	remutation, err := newRemutationControllerFromEnv(server.inScope, server.recorder, rightSizer != nil)
+	if err != nil {
+		log.Fatalf("Invalid re-mutation configuration: %v", err)
+	}
+	if remutation == nil {
+		log.Println("Re-mutation of running workloads disabled")
+	} else {
+		log.Printf("Re-mutation of running workloads: %s", remutation)
+		go remutation.Run(context.Background())
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/mutate", server.mutate)
 	mux.HandleFunc("/health", server.health)
+	mux.Handle("/metrics", promhttp.Handler())
 	server.server.Handler = mux
 
 	log.Println("Starting HyperShift GKE Autopilot webhook server on :8443")
 	if err := server.server.ListenAndServeTLS("", ""); err != nil {
+		if shutdownTracing != nil {
+			shutdownTracing(context.Background())
+		}
 		log.Fatalf("Failed to start webhook server: %v", err)
 	}
 }
@@ -65,6 +272,12 @@ func (ws *WebhookServer) health(w http.ResponseWriter, r *http.Request) {
 }
 
 func (ws *WebhookServer) mutate(w http.ResponseWriter, r *http.Request) {
+	// Join the trace kube-apiserver propagates, if any
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, "admission", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	decode := startPhase(ctx, phaseDecode)
 	var body []byte
 	if r.Body != nil {
 		if data, err := io.ReadAll(r.Body); err == nil {
@@ -74,6 +287,7 @@ func (ws *WebhookServer) mutate(w http.ResponseWriter, r *http.Request) {
 
 	if len(body) == 0 {
 		log.Println("Empty request body")
+		endWithError(decode, span, fmt.Errorf("empty request body"))
 		http.Error(w, "Empty request body", http.StatusBadRequest)
 		return
 	}
@@ -81,23 +295,74 @@ func (ws *WebhookServer) mutate(w http.ResponseWriter, r *http.Request) {
 	var admissionReview admissionv1.AdmissionReview
 	if err := json.Unmarshal(body, &admissionReview); err != nil {
 		log.Printf("Could not decode admission review: %v", err)
+		endWithError(decode, span, err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if admissionReview.Request == nil {
+		log.Println("Admission review without a request")
+		endWithError(decode, span, fmt.Errorf("admission review without a request"))
+		http.Error(w, "Admission review without a request", http.StatusBadRequest)
+		return
+	}
+	decode.End()
 
 	req := admissionReview.Request
+	span.SetAttributes(admissionAttributes(req)...)
 	var patches []patchOperation
 
-	// Check if this is a HyperShift control plane namespace
+	classify := startPhase(ctx, phaseClassify)
+
+	// Check if this is a HyperShift control plane namespace, by name or by
+	// the HostedControlPlane it holds
 	namespace := req.Namespace
-	if !isHyperShiftControlPlane(namespace) {
+	hcp, hasHCP := ws.hcps.Get(namespace)
+	if !isHyperShiftControlPlane(namespace) && !hasHCP {
 		log.Printf("Skipping non-HyperShift namespace: %s", namespace)
-		ws.sendResponse(w, &admissionReview, patches)
+		endWithResult(classify, span, resultSkipped)
+		ws.sendTraced(ctx, w, &admissionReview, patches, nil)
+		return
+	}
+
+	if hasHCP {
+		log.Printf("Processing %s %s in namespace %s for %s", req.Kind.Kind, req.Name, namespace, hcp)
+		span.SetAttributes(attrHCP.String(hcp.String()))
+	} else {
+		log.Printf("Processing %s %s in namespace %s", req.Kind.Kind, req.Name, namespace)
+	}
+
+	// Convert host access to Autopilot-safe equivalents where it is safe, and
+	// reject objects with violations that cannot be patched away, if configured
+	hostAccess := ws.hostAccessPlan(req)
+	denied, warnings := ws.checkViolations(req, hostAccess)
+	if denied != "" {
+		log.Printf("Denying %s %s: %s", req.Kind.Kind, req.Name, denied)
+		endWithResult(classify, span, resultDenied)
+		marshal := startPhase(ctx, phaseMarshal)
+		ws.sendDenied(w, &admissionReview, denied)
+		marshal.End()
+		return
+	}
+
+	// Images are moved to the mirrors whatever else is patched
+	images := ws.imageRewritePatches(req)
+
+	// Stop patching objects that are stuck in a mutation loop with HyperShift.
+	// Host access conversions and image rewrites still apply: Autopilot
+	// rejects the object without the former, pods never start without the
+	// latter.
+	if !ws.checkRateGuard(req) {
+		endWithResult(classify, span, resultThrottled)
+		ws.sendTraced(ctx, w, &admissionReview, append(append(patches, hostAccess.Patches...), images...), warnings)
 		return
 	}
+	classify.End()
 
-	log.Printf("Processing %s %s in namespace %s", req.Kind.Kind, req.Name, namespace)
+	if ws.canary != nil {
+		span.SetAttributes(attrTrack.String(ws.canary.Profile(namespace).track))
+	}
 
+	build := startPhase(ctx, phasePatches)
 	switch req.Kind.Kind {
 	case "Deployment":
 		patches = ws.mutateDeployment(req, patches)
@@ -105,10 +370,29 @@ func (ws *WebhookServer) mutate(w http.ResponseWriter, r *http.Request) {
 		patches = ws.mutateStatefulSet(req, patches)
 	case "Pod":
 		patches = ws.mutatePod(req, patches)
+	case "Route":
+		ws.mutateRoute(req)
 	}
+	// Last, so the indices of the other patches still refer to the volumes
+	// and ports of the object
+	patches = append(patches, hostAccess.Patches...)
+	patches = append(patches, images...)
+	ws.hostAccess.Expose(req, hostAccess)
+	build.SetAttributes(attrPatches.Int(len(patches)))
+	build.End()
+	span.SetAttributes(attrResult.String(resultPatched), attrPatches.Int(len(patches)))
+
+	log.Printf("Applied %d patches to %s %s (uid %s)%s", len(patches), req.Kind.Kind, req.Name, req.UID, traceSuffix(span))
+	ws.recordMutation(req, patches)
+	ws.sendTraced(ctx, w, &admissionReview, patches, warnings)
+}
 
-	log.Printf("Applied %d patches to %s %s", len(patches), req.Kind.Kind, req.Name)
-	ws.sendResponse(w, &admissionReview, patches)
+// sendTraced admits the object like sendResponseWithWarnings, in the marshal
+// span of the admission
+func (ws *WebhookServer) sendTraced(ctx context.Context, w http.ResponseWriter, admissionReview *admissionv1.AdmissionReview, patches []patchOperation, warnings []string) {
+	marshal := startPhase(ctx, phaseMarshal)
+	defer marshal.End()
+	ws.sendResponseWithWarnings(w, admissionReview, patches, warnings)
 }
 
 func (ws *WebhookServer) mutateDeployment(req *admissionv1.AdmissionRequest, patches []patchOperation) []patchOperation {
@@ -118,6 +402,14 @@ func (ws *WebhookServer) mutateDeployment(req *admissionv1.AdmissionRequest, pat
 		return patches
 	}
 
+	// The profile of the track of the namespace, adjusted by its
+	// AutopilotMutationProfile, may opt the component out
+	profile := ws.profile(req.Namespace)
+	if profile.skipsComponent(deployment.Name) {
+		log.Printf("Deployment %s opted out of mutations by %s", deployment.Name, profile)
+		return patches
+	}
+
 	// Apply generic GKE Autopilot fixes to all HyperShift control plane deployments
 	log.Printf("Applying generic GKE Autopilot fixes for deployment %s", deployment.Name)
 	
@@ -125,19 +417,36 @@ func (ws *WebhookServer) mutateDeployment(req *admissionv1.AdmissionRequest, pat
 	hasAntiAffinity := ws.hasAntiAffinityRules(&deployment)
 	
 	// Apply generic fixes based on deployment characteristics
-	patches = append(patches, ws.fixGenericDeploymentForGKEAutopilot(&deployment, hasAntiAffinity)...)
+	patches = append(patches, profile.generic(ws.fixGenericDeploymentForGKEAutopilot(&deployment, hasAntiAffinity))...)
 	
-	// Apply specific fixes for known components that need special handling
-	switch deployment.Name {
-	case "kube-apiserver":
-		log.Println("Applying additional kube-apiserver specific fixes")
-		patches = append(patches, ws.fixKubeAPIServerSpecificPatches()...)
-	case "etcd":
-		// etcd is handled as StatefulSet, not Deployment
-	default:
-		// All other deployments get generic treatment only
+	// Apply the overrides of known components that need special handling
+	patches = append(patches, profile.overrides.Patches(deployment.Name, &deployment.Spec.Template.Spec)...)
+
+	// Spread HA components over zones, as Autopilot picks the nodes. A
+	// SingleReplica control plane has nothing to spread.
+	if deployment.Spec.Selector != nil && !profile.skips(mutationTopology) && ws.highlyAvailable(req.Namespace) {
+		patches = append(patches, ws.topology.Patches(deployment.Name, &deployment.Spec.Template.Spec,
+			deployment.Spec.Selector.MatchLabels, hasAntiAffinity)...)
 	}
 
+	// Let Autopilot evict less important components first
+	if !profile.skips(mutationPriority) {
+		patches = append(patches, ws.priorities.Patches(deployment.Name, &deployment.Spec.Template.Spec)...)
+	}
+
+	// Scale the component with an HPA and protect it with a PDB, if it has
+	// a scaling policy
+	patches = append(patches, ws.autoscaling.Admit(req, &deployment, profile.scaling)...)
+
+	// Replace static requests with requests from usage data, if configured
+	patches = profile.rightSizer.Apply(req.Namespace, "Deployment", deployment.Name, &deployment.Spec.Template.Spec, patches)
+
+	// Keep the requests within the constraints of the cluster's Autopilot generation
+	patches = ws.autopilot.Constraints().Bound("Deployment", deployment.Name, patches, hasAntiAffinity)
+
+	// Label the pods with the track when canarying mutation changes
+	patches = append(patches, ws.trackPatches("Deployment", &deployment.Spec.Template, profile.track)...)
+
 	return patches
 }
 
@@ -148,12 +457,39 @@ func (ws *WebhookServer) mutateStatefulSet(req *admissionv1.AdmissionRequest, pa
 		return patches
 	}
 
+	profile := ws.profile(req.Namespace)
+	if profile.skipsComponent(statefulSet.Name) {
+		log.Printf("StatefulSet %s opted out of mutations by %s", statefulSet.Name, profile)
+		return patches
+	}
+
+	hasAntiAffinity := statefulSet.Spec.Template.Spec.Affinity != nil && statefulSet.Spec.Template.Spec.Affinity.PodAntiAffinity != nil
+
 	// Fix etcd StatefulSet
 	if statefulSet.Name == "etcd" {
 		log.Println("Applying etcd fixes for GKE Autopilot")
 		patches = append(patches, ws.fixEtcdResources()...)
+		// The etcd fixes replace the affinity with a preferred anti-affinity
+		hasAntiAffinity = true
 	}
 
+	patches = append(patches, profile.overrides.Patches(statefulSet.Name, &statefulSet.Spec.Template.Spec)...)
+
+	if statefulSet.Spec.Selector != nil && !profile.skips(mutationTopology) && ws.highlyAvailable(req.Namespace) {
+		patches = append(patches, ws.topology.Patches(statefulSet.Name, &statefulSet.Spec.Template.Spec,
+			statefulSet.Spec.Selector.MatchLabels, hasAntiAffinity)...)
+	}
+
+	if !profile.skips(mutationPriority) {
+		patches = append(patches, ws.priorities.Patches(statefulSet.Name, &statefulSet.Spec.Template.Spec)...)
+	}
+
+	patches = profile.rightSizer.Apply(req.Namespace, "StatefulSet", statefulSet.Name, &statefulSet.Spec.Template.Spec, patches)
+
+	patches = ws.autopilot.Constraints().Bound("StatefulSet", statefulSet.Name, patches, hasAntiAffinity)
+
+	patches = append(patches, ws.trackPatches("StatefulSet", &statefulSet.Spec.Template, profile.track)...)
+
 	return patches
 }
 
@@ -166,8 +502,13 @@ func (ws *WebhookServer) mutatePod(req *admissionv1.AdmissionRequest, patches []
 
 	// Apply general security context fixes for all HyperShift pods
 	if hasHyperShiftLabels(pod.Labels) {
+		profile := ws.profile(req.Namespace)
+		if profile.skipsComponent(pod.Labels[controlPlaneComponentLabel]) {
+			log.Printf("Pod %s opted out of mutations by %s", pod.Name, profile)
+			return patches
+		}
 		log.Printf("Applying general security context fixes for pod %s", pod.Name)
-		patches = append(patches, ws.fixPodSecurityContext()...)
+		patches = append(patches, profile.generic(ws.fixPodSecurityContext())...)
 	}
 
 	return patches
@@ -406,194 +747,6 @@ func (ws *WebhookServer) fixEtcdResources() []patchOperation {
 	}
 }
 
-func (ws *WebhookServer) fixKubeAPIServerResources() []patchOperation {
-	// Fix CPU resources for containers that have pod anti-affinity
-	// GKE Autopilot requires minimum 500m CPU for pods with anti-affinity
-	resourcesSpec := map[string]interface{}{
-		"requests": map[string]interface{}{
-			"cpu":               "500m",
-			"memory":            "2Gi",
-			"ephemeral-storage": "1Gi",
-		},
-		"limits": map[string]interface{}{
-			"ephemeral-storage": "1Gi",
-		},
-	}
-
-	initContainerResourcesSpec := map[string]interface{}{
-		"requests": map[string]interface{}{
-			"cpu":               "500m",
-			"memory":            "2118Mi",
-			"ephemeral-storage": "4Gi",
-		},
-		"limits": map[string]interface{}{
-			"ephemeral-storage": "4Gi",
-		},
-	}
-
-	// Security context for all containers
-	securityContextSpec := map[string]interface{}{
-		"allowPrivilegeEscalation": false,
-		"capabilities": map[string]interface{}{
-			"drop": []string{"ALL"},
-		},
-		"readOnlyRootFilesystem": false, // kube-apiserver needs write access
-		"runAsNonRoot":           true,
-		"runAsUser":              1001,
-		"seccompProfile": map[string]interface{}{
-			"type": "RuntimeDefault",
-		},
-	}
-
-	// Pod security context
-	podSecurityContextSpec := map[string]interface{}{
-		"runAsNonRoot": true,
-		"runAsUser":    1001,
-		"seccompProfile": map[string]interface{}{
-			"type": "RuntimeDefault",
-		},
-	}
-
-	return []patchOperation{
-		// Add pod security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/securityContext",
-			Value: podSecurityContextSpec,
-		},
-		// Fix wait-for-etcd init container resources
-		{
-			Op:   "replace",
-			Path: "/spec/template/spec/initContainers/1/resources",
-			Value: initContainerResourcesSpec,
-		},
-		// Fix wait-for-etcd init container security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/initContainers/1/securityContext",
-			Value: securityContextSpec,
-		},
-		// Fix init-bootstrap init container security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/initContainers/0/securityContext",
-			Value: securityContextSpec,
-		},
-		// Fix kube-apiserver container resources
-		{
-			Op:   "replace",
-			Path: "/spec/template/spec/containers/1/resources",
-			Value: resourcesSpec,
-		},
-		// Fix kube-apiserver container security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/containers/1/securityContext",
-			Value: securityContextSpec,
-		},
-		// Fix apply-bootstrap container security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/containers/0/securityContext",
-			Value: securityContextSpec,
-		},
-		// Fix konnectivity-server container security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/containers/2/securityContext",
-			Value: securityContextSpec,
-		},
-		// Fix audit-logs container security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/containers/3/securityContext",
-			Value: securityContextSpec,
-		},
-	}
-}
-
-func (ws *WebhookServer) fixKubeControllerManagerSecurityContext() []patchOperation {
-	// Fix CPU resources for containers that have pod anti-affinity
-	// GKE Autopilot requires minimum 500m CPU for pods with anti-affinity
-	resourcesSpec := map[string]interface{}{
-		"requests": map[string]interface{}{
-			"cpu":               "500m",
-			"memory":            "400Mi",
-			"ephemeral-storage": "1Gi",
-		},
-		"limits": map[string]interface{}{
-			"ephemeral-storage": "1Gi",
-		},
-	}
-
-	initContainerResourcesSpec := map[string]interface{}{
-		"requests": map[string]interface{}{
-			"cpu":               "500m",
-			"memory":            "400Mi",
-			"ephemeral-storage": "1Gi",
-		},
-		"limits": map[string]interface{}{
-			"ephemeral-storage": "1Gi",
-		},
-	}
-
-	// Security context for all containers in kube-controller-manager
-	securityContextSpec := map[string]interface{}{
-		"allowPrivilegeEscalation": false,
-		"capabilities": map[string]interface{}{
-			"drop": []string{"ALL"},
-		},
-		"readOnlyRootFilesystem": false, // kube-controller-manager needs write access
-		"runAsNonRoot":           true,
-		"runAsUser":              1001,
-		"seccompProfile": map[string]interface{}{
-			"type": "RuntimeDefault",
-		},
-	}
-
-	// Pod security context
-	podSecurityContextSpec := map[string]interface{}{
-		"runAsNonRoot": true,
-		"runAsUser":    1001,
-		"seccompProfile": map[string]interface{}{
-			"type": "RuntimeDefault",
-		},
-	}
-
-	return []patchOperation{
-		// Add pod security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/securityContext",
-			Value: podSecurityContextSpec,
-		},
-		// Fix availability-prober init container resources
-		{
-			Op:   "replace",
-			Path: "/spec/template/spec/initContainers/0/resources",
-			Value: initContainerResourcesSpec,
-		},
-		// Fix availability-prober init container security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/initContainers/0/securityContext",
-			Value: securityContextSpec,
-		},
-		// Fix kube-controller-manager container resources
-		{
-			Op:   "replace",
-			Path: "/spec/template/spec/containers/0/resources",
-			Value: resourcesSpec,
-		},
-		// Fix kube-controller-manager container security context
-		{
-			Op:   "add",
-			Path: "/spec/template/spec/containers/0/securityContext",
-			Value: securityContextSpec,
-		},
-	}
-}
-
 func (ws *WebhookServer) fixPodSecurityContext() []patchOperation {
 	return []patchOperation{
 		{
@@ -611,6 +764,12 @@ func (ws *WebhookServer) fixPodSecurityContext() []patchOperation {
 }
 
 func (ws *WebhookServer) sendResponse(w http.ResponseWriter, admissionReview *admissionv1.AdmissionReview, patches []patchOperation) {
+	ws.sendResponseWithWarnings(w, admissionReview, patches, nil)
+}
+
+// sendResponseWithWarnings admits the object with patches, returning warnings
+// to the client (e.g. shown by kubectl)
+func (ws *WebhookServer) sendResponseWithWarnings(w http.ResponseWriter, admissionReview *admissionv1.AdmissionReview, patches []patchOperation, warnings []string) {
 	var patchBytes []byte
 	var err error
 
@@ -624,8 +783,9 @@ func (ws *WebhookServer) sendResponse(w http.ResponseWriter, admissionReview *ad
 	}
 
 	admissionResponse := &admissionv1.AdmissionResponse{
-		UID:     admissionReview.Request.UID,
-		Allowed: true,
+		UID:      admissionReview.Request.UID,
+		Allowed:  true,
+		Warnings: warnings,
 	}
 
 	if len(patchBytes) > 0 {
@@ -635,6 +795,25 @@ func (ws *WebhookServer) sendResponse(w http.ResponseWriter, admissionReview *ad
 	}
 
 	admissionReview.Response = admissionResponse
+	ws.writeReview(w, admissionReview)
+}
+
+// sendDenied rejects the object under admission with a message
+func (ws *WebhookServer) sendDenied(w http.ResponseWriter, admissionReview *admissionv1.AdmissionReview, message string) {
+	admissionReview.Response = &admissionv1.AdmissionResponse{
+		UID:     admissionReview.Request.UID,
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: message,
+		},
+	}
+	ws.writeReview(w, admissionReview)
+}
+
+func (ws *WebhookServer) writeReview(w http.ResponseWriter, admissionReview *admissionv1.AdmissionReview) {
 	respBytes, err := json.Marshal(admissionReview)
 	if err != nil {
 		log.Printf("Could not marshal response: %v", err)
@@ -646,6 +825,39 @@ func (ws *WebhookServer) sendResponse(w http.ResponseWriter, admissionReview *ad
 	w.Write(respBytes)
 }
 
+// checkRateGuard records the admission and returns false when the object has
+// been admitted too often and should be passed through without patches
+func (ws *WebhookServer) checkRateGuard(req *admissionv1.AdmissionRequest) bool {
+	// Pods created from a generateName have no name yet and are new objects every time
+	if ws.rateGuard == nil || req.Name == "" {
+		return true
+	}
+
+	decision := ws.rateGuard.Record(rateGuardKey(req.Kind.Kind, req.Namespace, req.Name))
+	defer rateGuardThrottledObjects.Set(float64(ws.rateGuard.Throttled()))
+
+	if decision.Allowed {
+		return true
+	}
+
+	if decision.Tripped {
+		log.Printf("Rate guard tripped for %s %s/%s: %d admissions in %s, passing through unpatched until %s",
+			req.Kind.Kind, req.Namespace, req.Name, decision.Count, ws.rateGuard.window, decision.Until.Format(time.RFC3339))
+		rateGuardTrippedTotal.WithLabelValues(req.Kind.Kind, req.Namespace).Inc()
+		if ws.recorder != nil {
+			ws.recorder.Eventf(admissionObjectReference(req), corev1.EventTypeWarning, "MutationLoopDetected",
+				"Admitted %d times in %s; GKE Autopilot patches suspended until %s",
+				decision.Count, ws.rateGuard.window, decision.Until.Format(time.RFC3339))
+		}
+		return false
+	}
+
+	log.Printf("Rate guard active for %s %s/%s until %s, skipping patches",
+		req.Kind.Kind, req.Namespace, req.Name, decision.Until.Format(time.RFC3339))
+	rateGuardSkippedTotal.WithLabelValues(req.Kind.Kind, req.Namespace).Inc()
+	return false
+}
+
 func isHyperShiftControlPlane(namespace string) bool {
 	// Check if this is a HyperShift control plane namespace
 	return strings.HasPrefix(namespace, "clusters-") || namespace == "hypershift"
@@ -798,34 +1010,6 @@ func (ws *WebhookServer) fixGenericDeploymentForGKEAutopilot(deployment *appsv1.
 	return patches
 }
 
-// fixKubeAPIServerSpecificPatches handles kube-apiserver specific requirements beyond generic fixes
-func (ws *WebhookServer) fixKubeAPIServerSpecificPatches() []patchOperation {
-	// kube-apiserver has some specific resource requirements that differ from generic
-	// For now, the generic fixes handle most cases, but we can add specific overrides here
-	var patches []patchOperation
-	
-	// Example: kube-apiserver might need higher memory limits
-	kubeAPIServerResourcesSpec := map[string]interface{}{
-		"requests": map[string]interface{}{
-			"cpu":               "100m",
-			"memory":            "512Mi", // Further reduced for demo cluster
-			"ephemeral-storage": "1Gi",
-		},
-		"limits": map[string]interface{}{
-			"ephemeral-storage": "1Gi",
-		},
-	}
-
-	// Update main kube-apiserver container (index 1) with higher resources
-	patches = append(patches, patchOperation{
-		Op:   "replace",
-		Path: "/spec/template/spec/containers/1/resources",
-		Value: kubeAPIServerResourcesSpec,
-	})
-
-	return patches
-}
-
 // needsNetworkCapabilities checks if a deployment needs network capabilities like NET_BIND_SERVICE
 func (ws *WebhookServer) needsNetworkCapabilities(deployment *appsv1.Deployment) bool {
 	// Check deployment name patterns
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rateGuardTrippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_rate_guard_tripped_total",
+			Help: "Number of times an object exceeded the mutation rate threshold and was passed through unpatched.",
+		},
+		[]string{"kind", "namespace"},
+	)
+
+	rateGuardSkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_rate_guard_skipped_total",
+			Help: "Number of admissions passed through unpatched because the object was cooling down.",
+		},
+		[]string{"kind", "namespace"},
+	)
+
+	rateGuardThrottledObjects = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autopilot_webhook_rate_guard_throttled_objects",
+			Help: "Number of objects currently in mutation cool-down. Alert when this is above zero.",
+		},
+	)
+
+	violationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_violations_total",
+			Help: "Number of un-mutatable Autopilot violations found in admitted objects, by class and policy action.",
+		},
+		[]string{"class", "action"},
+	)
+
+	rightSizedContainersTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_rightsized_containers_total",
+			Help: "Number of container resource patches computed from usage data instead of static requests, by source.",
+		},
+		[]string{"source"},
+	)
+
+	hostedControlPlanesCached = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autopilot_webhook_hosted_control_planes_cached",
+			Help: "Number of HostedControlPlanes in the admission context cache.",
+		},
+	)
+
+	canaryMutationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_track_mutations_total",
+			Help: "Number of workloads mutated while canarying mutation changes, by kind and track (stable or next).",
+		},
+		[]string{"kind", "track"},
+	)
+
+	canaryPercent = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autopilot_webhook_canary_percent",
+			Help: "Percentage of hosted control plane namespaces on the next mutation profile.",
+		},
+	)
+
+	autopilotGenerationInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "autopilot_webhook_autopilot_generation",
+			Help: "Autopilot generation whose constraints bound the resource patches, detected from the oldest GKE version of the control plane and nodes (1 for the selected one).",
+		},
+		[]string{"generation"},
+	)
+
+	mutationProfileResolutionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_mutation_profile_resolutions_total",
+			Help: "Number of admissions in namespaces referring to an AutopilotMutationProfile, by result (applied, or failed when the profile is missing or invalid and the webhook configuration is used).",
+		},
+		[]string{"result"},
+	)
+
+	autoscaledDeployments = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autopilot_webhook_autoscaled_deployments",
+			Help: "Number of Deployments admitted with a scaling policy, whose HorizontalPodAutoscaler and PodDisruptionBudget the webhook maintains.",
+		},
+	)
+
+	hostAccessConversionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_host_access_conversions_total",
+			Help: "Number of hostPath, hostNetwork and hostPort fields found in admitted objects, by class and outcome (converted to an Autopilot-safe equivalent, or denied).",
+		},
+		[]string{"class", "outcome"},
+	)
+
+	imageRewritesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_image_rewrites_total",
+			Help: "Number of container images rewritten to a mirror, by the registry or repository of the rule.",
+		},
+		[]string{"from"},
+	)
+
+	autopilotGenerationMismatch = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autopilot_webhook_autopilot_generation_mismatch",
+			Help: "Whether the detected Autopilot generation differs from AUTOPILOT_GENERATION, the one the webhook profile targets (1) or not (0).",
+		},
+	)
+
+	autopilotAdjustmentsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_request_adjustments_total",
+			Help: "Number of container requests raised to the CPU increment (increment) or memory:CPU ratio (ratio) of the Autopilot generation, by resource and reason.",
+		},
+		[]string{"resource", "reason"},
+	)
+
+	remutationStaleWorkloads = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "autopilot_webhook_remutation_stale_workloads",
+			Help: "Number of control plane Deployments and StatefulSets whose pod template differs from the one the webhook admits now, left to roll out in the current re-mutation pass.",
+		},
+	)
+
+	remutationRolloutsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "autopilot_webhook_remutation_rollouts_total",
+			Help: "Number of workloads rolled out by the re-mutation controller to apply the current mutations, by kind and result (completed, failed to update, or timeout).",
+		},
+		[]string{"kind", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rateGuardTrippedTotal, rateGuardSkippedTotal, rateGuardThrottledObjects, violationsTotal, rightSizedContainersTotal, hostedControlPlanesCached,
+		canaryMutationsTotal, canaryPercent, autopilotGenerationInfo, autopilotGenerationMismatch, mutationProfileResolutionsTotal,
+		autoscaledDeployments, hostAccessConversionsTotal, autopilotAdjustmentsTotal, remutationStaleWorkloads, remutationRolloutsTotal,
+		imageRewritesTotal)
+}
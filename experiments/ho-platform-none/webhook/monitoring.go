@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	defaultMonitoringWindow  = 7 * 24 * time.Hour
+	defaultMonitoringRefresh = 15 * time.Minute
+
+	monitoringEndpoint = "https://monitoring.googleapis.com/v3"
+	metadataEndpoint   = "http://metadata.google.internal/computeMetadata/v1"
+)
+
+// monitoringMetrics are the GKE system metrics usage is read from, with the
+// aligner turning their points into usage and an extra filter
+var monitoringMetrics = []struct {
+	resource corev1.ResourceName
+	metric   string
+	aligner  string
+	filter   string
+}{
+	{corev1.ResourceCPU, "kubernetes.io/container/cpu/core_usage_time", "ALIGN_RATE", ""},
+	{corev1.ResourceMemory, "kubernetes.io/container/memory/used_bytes", "ALIGN_MAX", `metric.labels.memory_type = "non-evictable"`},
+	{corev1.ResourceEphemeralStorage, "kubernetes.io/container/ephemeral_storage/used_bytes", "ALIGN_MAX", ""},
+}
+
+// monitoringSource serves the peak usage of HyperShift component containers
+// over a window, read from Cloud Monitoring with the credentials of the node
+// or Workload Identity, which need roles/monitoring.viewer. Usage is
+// refreshed in the background so admissions never wait for Cloud Monitoring.
+type monitoringSource struct {
+	project     string
+	clusterName string
+	window      time.Duration
+	refresh     time.Duration
+	httpClient  *http.Client
+
+	mu    sync.RWMutex
+	usage map[string]corev1.ResourceList
+}
+
+// newMonitoringSourceFromEnv reads RIGHTSIZING_CLUSTER_NAME (required),
+// RIGHTSIZING_PROJECT (defaults to the project of the metadata server),
+// RIGHTSIZING_WINDOW and RIGHTSIZING_REFRESH
+func newMonitoringSourceFromEnv() (*monitoringSource, error) {
+	clusterName := os.Getenv("RIGHTSIZING_CLUSTER_NAME")
+	if clusterName == "" {
+		return nil, fmt.Errorf("RIGHTSIZING_CLUSTER_NAME must be set with RIGHTSIZING_SOURCE=monitoring")
+	}
+	window, err := envDuration("RIGHTSIZING_WINDOW", defaultMonitoringWindow)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := envDuration("RIGHTSIZING_REFRESH", defaultMonitoringRefresh)
+	if err != nil {
+		return nil, err
+	}
+	if window < time.Hour || refresh <= 0 {
+		return nil, fmt.Errorf("RIGHTSIZING_WINDOW must be at least 1h and RIGHTSIZING_REFRESH positive")
+	}
+
+	s := &monitoringSource{
+		project:     os.Getenv("RIGHTSIZING_PROJECT"),
+		clusterName: clusterName,
+		window:      window,
+		refresh:     refresh,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		usage:       make(map[string]corev1.ResourceList),
+	}
+	if s.project == "" {
+		project, err := s.metadata(context.Background(), "/project/project-id")
+		if err != nil {
+			return nil, fmt.Errorf("could not determine the project, set RIGHTSIZING_PROJECT: %v", err)
+		}
+		s.project = project
+	}
+	return s, nil
+}
+
+func (s *monitoringSource) Name() string {
+	return "monitoring"
+}
+
+func (s *monitoringSource) Usage(namespace, kind, name, container string) (corev1.ResourceList, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	usage, ok := s.usage[usageKey(namespace, kind, name, container)]
+	return usage, ok
+}
+
+// Run refreshes usage every refresh interval until ctx is done. Failed
+// refreshes keep the previous usage.
+func (s *monitoringSource) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.refresh)
+	defer ticker.Stop()
+
+	for {
+		usage, err := s.query(ctx)
+		if err != nil {
+			log.Printf("Could not read usage from Cloud Monitoring: %v", err)
+		} else {
+			s.mu.Lock()
+			s.usage = usage
+			s.mu.Unlock()
+			log.Printf("Read usage of %d containers from Cloud Monitoring over %s", len(usage), s.window)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// timeSeriesList is the part of a Cloud Monitoring timeSeries.list response
+// the source reads
+type timeSeriesList struct {
+	TimeSeries []struct {
+		Resource struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"resource"`
+		Metadata struct {
+			SystemLabels map[string]interface{} `json:"systemLabels"`
+		} `json:"metadata"`
+		Points []struct {
+			Value struct {
+				DoubleValue *float64 `json:"doubleValue"`
+				Int64Value  *string  `json:"int64Value"`
+			} `json:"value"`
+		} `json:"points"`
+	} `json:"timeSeries"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// query reads the peak hourly usage of every container in the control plane
+// namespaces of the cluster, per owning Deployment or StatefulSet
+func (s *monitoringSource) query(ctx context.Context) (map[string]corev1.ResourceList, error) {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	end := time.Now()
+	usage := map[string]corev1.ResourceList{}
+	for _, m := range monitoringMetrics {
+		filter := fmt.Sprintf(`metric.type = %q AND resource.type = "k8s_container" AND resource.labels.cluster_name = %q AND resource.labels.namespace_name = starts_with("clusters-")`,
+			m.metric, s.clusterName)
+		if m.filter != "" {
+			filter += " AND " + m.filter
+		}
+
+		params := url.Values{
+			"filter":                         {filter},
+			"interval.startTime":             {end.Add(-s.window).UTC().Format(time.RFC3339)},
+			"interval.endTime":               {end.UTC().Format(time.RFC3339)},
+			"aggregation.alignmentPeriod":    {"3600s"},
+			"aggregation.perSeriesAligner":   {m.aligner},
+			"aggregation.crossSeriesReducer": {"REDUCE_MAX"},
+			"aggregation.groupByFields": {
+				"resource.label.namespace_name",
+				"resource.label.container_name",
+				"metadata.system_labels.top_level_controller_type",
+				"metadata.system_labels.top_level_controller_name",
+			},
+		}
+
+		for {
+			var page timeSeriesList
+			if err := s.get(ctx, token, fmt.Sprintf("%s/projects/%s/timeSeries?%s", monitoringEndpoint, s.project, params.Encode()), &page); err != nil {
+				return nil, fmt.Errorf("%s: %v", m.metric, err)
+			}
+			for _, ts := range page.TimeSeries {
+				kind, _ := ts.Metadata.SystemLabels["top_level_controller_type"].(string)
+				name, _ := ts.Metadata.SystemLabels["top_level_controller_name"].(string)
+				if kind == "" || name == "" {
+					continue
+				}
+				peak := 0.0
+				for _, p := range ts.Points {
+					value := 0.0
+					if p.Value.DoubleValue != nil {
+						value = *p.Value.DoubleValue
+					} else if p.Value.Int64Value != nil {
+						value, _ = strconv.ParseFloat(*p.Value.Int64Value, 64)
+					}
+					if value > peak {
+						peak = value
+					}
+				}
+
+				key := usageKey(ts.Resource.Labels["namespace_name"], kind, name, ts.Resource.Labels["container_name"])
+				if usage[key] == nil {
+					usage[key] = corev1.ResourceList{}
+				}
+				if m.resource == corev1.ResourceCPU {
+					usage[key][m.resource] = *resource.NewMilliQuantity(int64(peak*1000), resource.DecimalSI)
+				} else {
+					usage[key][m.resource] = *resource.NewQuantity(int64(peak), resource.BinarySI)
+				}
+			}
+			if page.NextPageToken == "" {
+				break
+			}
+			params.Set("pageToken", page.NextPageToken)
+		}
+	}
+	return usage, nil
+}
+
+func (s *monitoringSource) get(ctx context.Context, token, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Cloud Monitoring returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
+
+// accessToken returns an access token of the service account of the pod
+func (s *monitoringSource) accessToken(ctx context.Context) (string, error) {
+	data, err := s.metadata(ctx, "/instance/service-accounts/default/token")
+	if err != nil {
+		return "", fmt.Errorf("could not get an access token: %v", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return "", fmt.Errorf("could not parse access token: %v", err)
+	}
+	return token.AccessToken, nil
+}
+
+// metadata reads a value from the GCE metadata server
+func (s *monitoringSource) metadata(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataEndpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d", resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
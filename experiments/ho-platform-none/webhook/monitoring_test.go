@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// redirectTransport sends the requests of a client to a test server,
+// whatever their host
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Canned timeSeries.list pages, by metric
+var testTimeSeries = map[string][]string{
+	"kubernetes.io/container/cpu/core_usage_time": {`{
+  "timeSeries": [{
+    "resource": {"labels": {"namespace_name": "clusters-a", "container_name": "kube-apiserver"}},
+    "metadata": {"systemLabels": {"top_level_controller_type": "Deployment", "top_level_controller_name": "kube-apiserver"}},
+    "points": [{"value": {"doubleValue": 0.25}}, {"value": {"doubleValue": 0.4123}}, {"value": {"doubleValue": 0.1}}]
+  }, {
+    "resource": {"labels": {"namespace_name": "clusters-a", "container_name": "debug"}},
+    "metadata": {"systemLabels": {}},
+    "points": [{"value": {"doubleValue": 3}}]
+  }],
+  "nextPageToken": "page-2"
+}`, `{
+  "timeSeries": [{
+    "resource": {"labels": {"namespace_name": "clusters-a", "container_name": "etcd"}},
+    "metadata": {"systemLabels": {"top_level_controller_type": "StatefulSet", "top_level_controller_name": "etcd"}},
+    "points": [{"value": {"doubleValue": 0.05}}]
+  }]
+}`},
+	"kubernetes.io/container/memory/used_bytes": {`{
+  "timeSeries": [{
+    "resource": {"labels": {"namespace_name": "clusters-a", "container_name": "kube-apiserver"}},
+    "metadata": {"systemLabels": {"top_level_controller_type": "Deployment", "top_level_controller_name": "kube-apiserver"}},
+    "points": [{"value": {"int64Value": "1073741824"}}, {"value": {"int64Value": "536870912"}}]
+  }]
+}`},
+	"kubernetes.io/container/ephemeral_storage/used_bytes": {`{}`},
+}
+
+// newTestMonitoringSource returns a source reading from a test server that
+// plays the metadata server and Cloud Monitoring. The server fails the
+// requests of metrics missing from pages.
+func newTestMonitoringSource(t *testing.T, pages map[string][]string) *monitoringSource {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"access_token": "test-token", "expires_in": 3599, "token_type": "Bearer"}`))
+		case r.URL.Path == "/computeMetadata/v1/project/project-id":
+			w.Write([]byte("metadata-project\n"))
+		case r.URL.Path == "/v3/projects/test-project/timeSeries":
+			if r.Header.Get("Authorization") != "Bearer test-token" {
+				http.Error(w, "unauthenticated", http.StatusUnauthorized)
+				return
+			}
+			query := r.URL.Query()
+			filter := query.Get("filter")
+			if !strings.Contains(filter, `resource.labels.cluster_name = "mgmt"`) {
+				t.Errorf("filter %q does not select the cluster", filter)
+			}
+			metric := strings.TrimSuffix(strings.TrimPrefix(strings.Fields(filter)[2], `"`), `"`)
+			metricPages, ok := pages[metric]
+			if !ok {
+				http.Error(w, `{"error": {"code": 403, "message": "Permission monitoring.timeSeries.list denied"}}`, http.StatusForbidden)
+				return
+			}
+			page := metricPages[0]
+			if query.Get("pageToken") == "page-2" {
+				page = metricPages[1]
+			}
+			w.Write([]byte(page))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	target, _ := url.Parse(server.URL)
+
+	return &monitoringSource{
+		project:     "test-project",
+		clusterName: "mgmt",
+		window:      defaultMonitoringWindow,
+		refresh:     defaultMonitoringRefresh,
+		httpClient:  &http.Client{Transport: redirectTransport{target: target}},
+		usage:       make(map[string]corev1.ResourceList),
+	}
+}
+
+func TestMonitoringSource_Query(t *testing.T) {
+	s := newTestMonitoringSource(t, testTimeSeries)
+	usage, err := s.query(context.Background())
+	if err != nil {
+		t.Fatalf("query() error = %v", err)
+	}
+
+	for _, tc := range []struct {
+		key  string
+		want corev1.ResourceList
+	}{
+		// The peak of the points, CPU in millicores
+		{usageKey("clusters-a", "Deployment", "kube-apiserver", "kube-apiserver"), corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("412m"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		}},
+		// Read from the second page
+		{usageKey("clusters-a", "StatefulSet", "etcd", "etcd"), corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("50m"),
+		}},
+	} {
+		got := usage[tc.key]
+		if len(got) != len(tc.want) {
+			t.Errorf("usage[%s] = %v, want %v", tc.key, got, tc.want)
+			continue
+		}
+		for name, want := range tc.want {
+			if q := got[name]; q.Cmp(want) != 0 {
+				t.Errorf("usage[%s][%s] = %s, want %s", tc.key, name, q.String(), want.String())
+			}
+		}
+	}
+	// Series without an owning controller are skipped
+	if len(usage) != 2 {
+		t.Errorf("query() returned usage of %d containers, want 2: %v", len(usage), usage)
+	}
+}
+
+func TestMonitoringSource_QueryError(t *testing.T) {
+	pages := map[string][]string{}
+	for metric, p := range testTimeSeries {
+		pages[metric] = p
+	}
+	delete(pages, "kubernetes.io/container/memory/used_bytes")
+	s := newTestMonitoringSource(t, pages)
+	_, err := s.query(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "memory/used_bytes") || !strings.Contains(err.Error(), "status 403") {
+		t.Errorf("query() error = %v, want the status of the failed metric", err)
+	}
+
+	// Failed refreshes keep the previous usage
+	quietLogs(t)
+	previous := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+	s.usage[usageKey("clusters-a", "Deployment", "kube-apiserver", "kube-apiserver")] = previous
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Run(ctx)
+	if got, ok := s.Usage("clusters-a", "Deployment", "kube-apiserver", "kube-apiserver"); !ok || got.Cpu().Cmp(previous[corev1.ResourceCPU]) != 0 {
+		t.Errorf("Usage() = %v after a failed refresh, want the previous usage", got)
+	}
+}
+
+func TestNewMonitoringSourceFromEnv(t *testing.T) {
+	t.Setenv("RIGHTSIZING_PROJECT", "test-project")
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"cluster name required", map[string]string{}, "RIGHTSIZING_CLUSTER_NAME"},
+		{"short window", map[string]string{"RIGHTSIZING_CLUSTER_NAME": "mgmt", "RIGHTSIZING_WINDOW": "30m"}, "at least 1h"},
+		{"invalid refresh", map[string]string{"RIGHTSIZING_CLUSTER_NAME": "mgmt", "RIGHTSIZING_REFRESH": "often"}, "RIGHTSIZING_REFRESH"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range []string{"RIGHTSIZING_CLUSTER_NAME", "RIGHTSIZING_WINDOW", "RIGHTSIZING_REFRESH"} {
+				t.Setenv(name, tc.env[name])
+			}
+			_, err := newMonitoringSourceFromEnv()
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("newMonitoringSourceFromEnv() error = %v, want %s", err, tc.wantErr)
+			}
+		})
+	}
+
+	t.Setenv("RIGHTSIZING_CLUSTER_NAME", "mgmt")
+	t.Setenv("RIGHTSIZING_WINDOW", "72h")
+	t.Setenv("RIGHTSIZING_REFRESH", "")
+	s, err := newMonitoringSourceFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.project != "test-project" || s.window != 72*time.Hour || s.refresh != defaultMonitoringRefresh {
+		t.Errorf("source = project %s, window %s, refresh %s", s.project, s.window, s.refresh)
+	}
+}
+
+func TestMonitoringSource_ProjectFromMetadata(t *testing.T) {
+	s := newTestMonitoringSource(t, testTimeSeries)
+	project, err := s.metadata(context.Background(), "/project/project-id")
+	if err != nil || project != "metadata-project" {
+		t.Errorf("metadata() = %q, %v, want metadata-project", project, err)
+	}
+	if _, err := s.metadata(context.Background(), "/instance/missing"); err == nil {
+		t.Error("metadata() succeeded for a missing path")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// containerOverride is what one container of a known component needs beyond
+// the generic fixes. Set fields replace what the generic fixes patched; Env
+// adds or replaces variables by name.
+type containerOverride struct {
+	Resources       *corev1.ResourceRequirements `json:"resources,omitempty"`
+	SecurityContext *corev1.SecurityContext      `json:"securityContext,omitempty"`
+	Env             []corev1.EnvVar              `json:"env,omitempty"`
+}
+
+// componentOverrides maps a component (Deployment or StatefulSet name) to
+// the overrides of its containers, by container name. Containers are matched
+// by name rather than position, so a container HyperShift adds or reorders
+// does not receive another container's settings.
+type componentOverrides map[string]map[string]containerOverride
+
+// defaultComponentOverrides are the overrides of the components whose
+// generic requests are known not to fit
+var defaultComponentOverrides = componentOverrides{
+	"kube-apiserver": {
+		"kube-apiserver": {Resources: overrideResources("100m", "512Mi", "1Gi")},
+	},
+	// The ignition-server extracts the release payload into its emptyDir
+	// cache: GKE Autopilot evicts pods using more ephemeral storage than they
+	// request, and the generic 1Gi is far too little for a payload
+	"ignition-server": {
+		"ignition-server": {Resources: overrideResources("100m", "1Gi", "8Gi")},
+	},
+}
+
+// overrideResources returns requests of cpu, memory and ephemeral storage,
+// with the ephemeral storage as limit too, as the generic fixes set them
+func overrideResources(cpu, memory, ephemeralStorage string) *corev1.ResourceRequirements {
+	return &corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:              resource.MustParse(cpu),
+			corev1.ResourceMemory:           resource.MustParse(memory),
+			corev1.ResourceEphemeralStorage: resource.MustParse(ephemeralStorage),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceEphemeralStorage: resource.MustParse(ephemeralStorage),
+		},
+	}
+}
+
+// newComponentOverridesFromEnv returns the default overrides merged with
+// those of COMPONENT_OVERRIDES_FILE, a YAML or JSON file of the same shape:
+//
+//	kube-apiserver:
+//	  kube-apiserver:
+//	    resources:
+//	      requests: {cpu: 200m, memory: 1Gi}
+//	    env:
+//	    - {name: GOMAXPROCS, value: "2"}
+//
+// A container in the file replaces the default override of that container.
+func newComponentOverridesFromEnv() (componentOverrides, error) {
+	overrides := componentOverrides{}
+	overrides.merge(defaultComponentOverrides)
+
+	path := os.Getenv("COMPONENT_OVERRIDES_FILE")
+	if path == "" {
+		return overrides, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read COMPONENT_OVERRIDES_FILE: %v", err)
+	}
+	fromFile, err := parseComponentOverrides(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid COMPONENT_OVERRIDES_FILE %s: %v", path, err)
+	}
+	overrides.merge(fromFile)
+	return overrides, nil
+}
+
+// parseComponentOverrides reads overrides from YAML or JSON, rejecting
+// unknown fields so a misspelled key is not silently ignored
+func parseComponentOverrides(data []byte) (componentOverrides, error) {
+	var overrides componentOverrides
+	if err := yaml.UnmarshalStrict(data, &overrides); err != nil {
+		return nil, err
+	}
+	if err := overrides.validate(); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// validate checks what the decoder cannot
+func (o componentOverrides) validate() error {
+	for component, containers := range o {
+		for container, override := range containers {
+			for _, env := range override.Env {
+				if env.Name == "" {
+					return fmt.Errorf("%s/%s: env variable without a name", component, container)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// merge adds the overrides of other, replacing those of the same containers
+func (o componentOverrides) merge(other componentOverrides) {
+	for component, containers := range other {
+		if o[component] == nil {
+			o[component] = map[string]containerOverride{}
+		}
+		for container, override := range containers {
+			o[component][container] = override
+		}
+	}
+}
+
+// String lists the containers with overrides, for the startup log
+func (o componentOverrides) String() string {
+	var names []string
+	for component, containers := range o {
+		for container := range containers {
+			names = append(names, component+"/"+container)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Patches returns the patches applying the overrides of component to the
+// containers and init containers of spec, to be appended after the generic
+// fixes
+func (o componentOverrides) Patches(component string, spec *corev1.PodSpec) []patchOperation {
+	containers := o[component]
+	if len(containers) == 0 {
+		return nil
+	}
+
+	var patches []patchOperation
+	for _, list := range []struct {
+		field      string
+		containers []corev1.Container
+	}{
+		{"initContainers", spec.InitContainers},
+		{"containers", spec.Containers},
+	} {
+		for i, c := range list.containers {
+			override, ok := containers[c.Name]
+			if !ok {
+				continue
+			}
+			path := fmt.Sprintf("/spec/template/spec/%s/%d", list.field, i)
+			if override.Resources != nil {
+				// "add" replaces the resources of the generic fixes, and
+				// works whether or not the container had any
+				patches = append(patches, patchOperation{Op: "add", Path: path + "/resources", Value: resourcesValue(override.Resources)})
+			}
+			if override.SecurityContext != nil {
+				patches = append(patches, patchOperation{Op: "add", Path: path + "/securityContext", Value: override.SecurityContext})
+			}
+			patches = append(patches, envPatches(path, c.Env, override.Env)...)
+		}
+	}
+	return patches
+}
+
+// resourcesValue returns resources in the form of the patches of the generic
+// fixes, which the right-sizer rewrites
+func resourcesValue(r *corev1.ResourceRequirements) map[string]interface{} {
+	value := map[string]interface{}{}
+	for key, list := range map[string]corev1.ResourceList{"requests": r.Requests, "limits": r.Limits} {
+		if len(list) == 0 {
+			continue
+		}
+		m := map[string]interface{}{}
+		for name, q := range list {
+			m[string(name)] = q.String()
+		}
+		value[key] = m
+	}
+	return value
+}
+
+// envPatches sets the variables of env on a container, replacing those of
+// the same name and appending the others
+func envPatches(containerPath string, current, env []corev1.EnvVar) []patchOperation {
+	if len(env) == 0 {
+		return nil
+	}
+	if len(current) == 0 {
+		return []patchOperation{{Op: "add", Path: containerPath + "/env", Value: env}}
+	}
+
+	var patches []patchOperation
+	for _, e := range env {
+		op := patchOperation{Op: "add", Path: containerPath + "/env/-", Value: e}
+		for i, c := range current {
+			if c.Name == e.Name {
+				op = patchOperation{Op: "replace", Path: fmt.Sprintf("%s/env/%d", containerPath, i), Value: e}
+				break
+			}
+		}
+		patches = append(patches, op)
+	}
+	return patches
+}
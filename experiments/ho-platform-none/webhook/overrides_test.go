@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestComponentOverrides_KubeAPIServer(t *testing.T) {
+	spec := admit(t, &WebhookServer{overrides: defaultComponentOverrides}, kubeAPIServerDeployment(), "Deployment")
+
+	byName := map[string]corev1.Container{}
+	for _, c := range spec.Containers {
+		byName[c.Name] = c
+	}
+	if got := byName["kube-apiserver"].Resources.Requests[corev1.ResourceMemory]; got.Cmp(resource.MustParse("512Mi")) != 0 {
+		t.Errorf("kube-apiserver memory request = %s, want the 512Mi of the override", got.String())
+	}
+	// The other containers keep the generic fixes
+	if got := byName["konnectivity-server"].Resources.Requests[corev1.ResourceCPU]; got.Cmp(resource.MustParse("100m")) != 0 {
+		t.Errorf("konnectivity-server cpu request = %s, want the generic 100m", got.String())
+	}
+	if byName["kube-apiserver"].SecurityContext == nil {
+		t.Error("kube-apiserver lost the security context of the generic fixes")
+	}
+}
+
+func TestComponentOverrides_FromFile(t *testing.T) {
+	overrides, err := parseComponentOverrides([]byte(`
+kube-apiserver:
+  kube-apiserver:
+    securityContext:
+      readOnlyRootFilesystem: true
+    env:
+    - {name: GOMAXPROCS, value: "2"}
+  konnectivity-server:
+    resources:
+      requests: {cpu: 250m}
+    env:
+    - {name: LOG_LEVEL, value: debug}
+    - {name: EXTRA, value: "1"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := componentOverrides{}
+	merged.merge(defaultComponentOverrides)
+	merged.merge(overrides)
+
+	deployment := kubeAPIServerDeployment()
+	deployment.Spec.Template.Spec.Containers[2].Env = []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}}
+	spec := admit(t, &WebhookServer{overrides: merged}, deployment, "Deployment")
+
+	apiserver, konnectivity := spec.Containers[1], spec.Containers[2]
+	// The file replaces the default override of the container
+	if sc := apiserver.SecurityContext; sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+		t.Errorf("kube-apiserver securityContext = %+v, want the override", sc)
+	}
+	if want := []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}}; !reflect.DeepEqual(apiserver.Env, want) {
+		t.Errorf("kube-apiserver env = %+v, want %+v", apiserver.Env, want)
+	}
+	if got := konnectivity.Resources.Requests.Cpu(); got.Cmp(resource.MustParse("250m")) != 0 {
+		t.Errorf("konnectivity-server cpu request = %s, want 250m", got)
+	}
+	want := []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}, {Name: "EXTRA", Value: "1"}}
+	if !reflect.DeepEqual(konnectivity.Env, want) {
+		t.Errorf("konnectivity-server env = %+v, want %+v", konnectivity.Env, want)
+	}
+}
+
+func TestComponentOverrides_NoneForOtherComponents(t *testing.T) {
+	spec := &corev1.PodSpec{Containers: []corev1.Container{{Name: "kube-apiserver"}}}
+	if patches := defaultComponentOverrides.Patches("openshift-apiserver", spec); len(patches) != 0 {
+		t.Errorf("Patches() = %+v, want none for a component without overrides", patches)
+	}
+	var none componentOverrides
+	if patches := none.Patches("kube-apiserver", spec); len(patches) != 0 {
+		t.Errorf("Patches() without overrides = %+v, want none", patches)
+	}
+}
+
+func TestParseComponentOverrides_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"unknown field":    "kube-apiserver:\n  kube-apiserver:\n    resource: {}\n",
+		"env without name": "kube-apiserver:\n  kube-apiserver:\n    env:\n    - {value: x}\n",
+		"not a map":        "- kube-apiserver\n",
+		"invalid quantity": "kube-apiserver:\n  kube-apiserver:\n    resources:\n      requests: {cpu: lots}\n",
+	} {
+		if _, err := parseComponentOverrides([]byte(data)); err == nil {
+			t.Errorf("%s: parseComponentOverrides() succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// priorityTier ranks control plane components by how much the hosted cluster
+// suffers when they are evicted
+type priorityTier string
+
+const (
+	priorityCritical priorityTier = "critical"
+	priorityHigh     priorityTier = "high"
+	priorityDefault  priorityTier = "default"
+)
+
+// priorityClasses are the PriorityClasses the webhook manages, one per tier.
+// They stay below the system-* classes Autopilot reserves for its own pods.
+var priorityClasses = []struct {
+	tier        priorityTier
+	name        string
+	value       int32
+	description string
+}{
+	{priorityCritical, "hcp-critical", 1000000, "HyperShift control plane components without which the hosted cluster API is down"},
+	{priorityHigh, "hcp-high", 100000, "HyperShift control plane components the hosted cluster degrades without"},
+	{priorityDefault, "hcp-default", 10000, "Other HyperShift control plane components, evicted first under pressure"},
+}
+
+// defaultPriorityTiers assigns the components HyperShift creates to tiers.
+// Components not listed are in the default tier.
+const defaultPriorityTiers = "etcd=critical,kube-apiserver=critical,konnectivity-agent=critical," +
+	"kube-controller-manager=high,kube-scheduler=high,openshift-apiserver=high,openshift-oauth-apiserver=high," +
+	"oauth-openshift=high,control-plane-operator=high,ignition-server=high"
+
+const defaultPriorityClassResync = 5 * time.Minute
+
+// priorityClassManager creates the hcp-* PriorityClasses and sets the class
+// of their tier on the pod template of control plane workloads, so Autopilot
+// evicts less important components first
+type priorityClassManager struct {
+	client kubernetes.Interface
+	tiers  map[string]priorityTier
+	resync time.Duration
+
+	mu sync.RWMutex
+	// ready holds the classes known to exist; pods referencing a missing
+	// class are rejected, so only these are injected
+	ready map[string]bool
+}
+
+// newPriorityClassManagerFromEnv builds the manager from PRIORITY_CLASSES
+// ("false" disables it), PRIORITY_TIERS (component=tier, comma-separated)
+// and PRIORITY_CLASS_RESYNC. It returns nil when disabled or not running
+// inside a cluster.
+func newPriorityClassManagerFromEnv() (*priorityClassManager, error) {
+	if os.Getenv("PRIORITY_CLASSES") == "false" {
+		return nil, nil
+	}
+	tiers, err := parsePriorityTiers(envString("PRIORITY_TIERS", defaultPriorityTiers))
+	if err != nil {
+		return nil, err
+	}
+	resync, err := envDuration("PRIORITY_CLASS_RESYNC", defaultPriorityClassResync)
+	if err != nil {
+		return nil, err
+	}
+	if resync <= 0 {
+		return nil, fmt.Errorf("PRIORITY_CLASS_RESYNC must be positive")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("PriorityClass injection disabled: %v", err)
+		return nil, nil
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create client: %v", err)
+	}
+
+	return &priorityClassManager{
+		client: clientset,
+		tiers:  tiers,
+		resync: resync,
+		ready:  map[string]bool{},
+	}, nil
+}
+
+func parsePriorityTiers(value string) (map[string]priorityTier, error) {
+	tiers := map[string]priorityTier{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		component, tier, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid PRIORITY_TIERS entry %q: must be component=tier", entry)
+		}
+		switch priorityTier(tier) {
+		case priorityCritical, priorityHigh, priorityDefault:
+			tiers[strings.TrimSpace(component)] = priorityTier(tier)
+		default:
+			return nil, fmt.Errorf("invalid PRIORITY_TIERS tier %q for %s: must be critical, high or default", tier, component)
+		}
+	}
+	return tiers, nil
+}
+
+func (m *priorityClassManager) String() string {
+	var entries []string
+	for component, tier := range m.tiers {
+		entries = append(entries, fmt.Sprintf("%s=%s", component, tier))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// Run creates missing PriorityClasses every resync interval until ctx is
+// done, so deleted classes come back
+func (m *priorityClassManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.resync)
+	defer ticker.Stop()
+
+	for {
+		m.ensure(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ensure creates the PriorityClasses that do not exist and records which
+// ones can be injected
+func (m *priorityClassManager) ensure(ctx context.Context) {
+	classes := m.client.SchedulingV1().PriorityClasses()
+	for _, pc := range priorityClasses {
+		existing, err := classes.Get(ctx, pc.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = classes.Create(ctx, &schedulingv1.PriorityClass{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   pc.name,
+					Labels: map[string]string{"app.kubernetes.io/managed-by": eventComponent},
+				},
+				Value:       pc.value,
+				Description: pc.description,
+			}, metav1.CreateOptions{})
+			if err == nil || apierrors.IsAlreadyExists(err) {
+				log.Printf("Created PriorityClass %s (value %d)", pc.name, pc.value)
+				err = nil
+			}
+		} else if err == nil && existing.Value != pc.value {
+			// The value of a PriorityClass is immutable; keep using the existing one
+			log.Printf("PriorityClass %s has value %d instead of %d, delete it to have it recreated",
+				pc.name, existing.Value, pc.value)
+		}
+
+		m.mu.Lock()
+		m.ready[pc.name] = err == nil
+		m.mu.Unlock()
+		if err != nil {
+			log.Printf("Could not ensure PriorityClass %s: %v", pc.name, err)
+		}
+	}
+}
+
+// className returns the PriorityClass of a component's tier
+func (m *priorityClassManager) className(component string) string {
+	tier, ok := m.tiers[component]
+	if !ok {
+		tier = priorityDefault
+	}
+	for _, pc := range priorityClasses {
+		if pc.tier == tier {
+			return pc.name
+		}
+	}
+	return ""
+}
+
+// Patches sets the PriorityClass of a component's tier on its pod template
+func (m *priorityClassManager) Patches(component string, spec *corev1.PodSpec) []patchOperation {
+	if m == nil {
+		return nil
+	}
+	name := m.className(component)
+	if name == "" || spec.PriorityClassName == name {
+		return nil
+	}
+
+	m.mu.RLock()
+	ready := m.ready[name]
+	m.mu.RUnlock()
+	if !ready {
+		log.Printf("PriorityClass %s does not exist yet, not setting it on %s", name, component)
+		return nil
+	}
+
+	patches := []patchOperation{
+		{
+			Op:    "add",
+			Path:  "/spec/template/spec/priorityClassName",
+			Value: name,
+		},
+	}
+	// The priority is resolved from the class; a stale value would be rejected
+	if spec.Priority != nil {
+		patches = append(patches, patchOperation{Op: "remove", Path: "/spec/template/spec/priority"})
+	}
+	return patches
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestParsePriorityTiers(t *testing.T) {
+	tiers, err := parsePriorityTiers(" etcd=critical,, kube-scheduler=high ,ignition-server=default,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]priorityTier{
+		"etcd":            priorityCritical,
+		"kube-scheduler":  priorityHigh,
+		"ignition-server": priorityDefault,
+	}
+	if !reflect.DeepEqual(tiers, want) {
+		t.Errorf("tiers = %v, want %v", tiers, want)
+	}
+
+	for _, tc := range []struct {
+		value   string
+		wantErr string
+	}{
+		{"etcd=urgent", `tier "urgent" for etcd`},
+		{"etcd", `entry "etcd": must be component=tier`},
+		{"etcd=critical,kube-scheduler", `entry "kube-scheduler"`},
+	} {
+		if _, err := parsePriorityTiers(tc.value); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("parsePriorityTiers(%q) error = %v, want %s", tc.value, err, tc.wantErr)
+		}
+	}
+
+	if tiers, err := parsePriorityTiers(defaultPriorityTiers); err != nil || len(tiers) != 10 {
+		t.Errorf("parsePriorityTiers(defaultPriorityTiers) = %v, %v", tiers, err)
+	}
+}
+
+func TestPriorityClassManager_ClassName(t *testing.T) {
+	m := &priorityClassManager{tiers: map[string]priorityTier{"etcd": priorityCritical, "kube-scheduler": priorityHigh}}
+	for component, want := range map[string]string{
+		"etcd":           "hcp-critical",
+		"kube-scheduler": "hcp-high",
+		// Components not listed are in the default tier
+		"cluster-version-operator": "hcp-default",
+		"":                         "hcp-default",
+	} {
+		if got := m.className(component); got != want {
+			t.Errorf("className(%q) = %q, want %q", component, got, want)
+		}
+	}
+}
+
+func TestPriorityClassManager_Patches(t *testing.T) {
+	quietLogs(t)
+	priority := int32(2000)
+	m := &priorityClassManager{
+		tiers: map[string]priorityTier{"etcd": priorityCritical},
+		ready: map[string]bool{"hcp-critical": true, "hcp-default": false},
+	}
+
+	for _, tc := range []struct {
+		name      string
+		component string
+		spec      corev1.PodSpec
+		want      []patchOperation
+	}{
+		{
+			name:      "class set",
+			component: "etcd",
+			want:      []patchOperation{{Op: "add", Path: "/spec/template/spec/priorityClassName", Value: "hcp-critical"}},
+		},
+		{
+			name:      "stale priority removed",
+			component: "etcd",
+			spec:      corev1.PodSpec{PriorityClassName: "system-cluster-critical", Priority: &priority},
+			want: []patchOperation{
+				{Op: "add", Path: "/spec/template/spec/priorityClassName", Value: "hcp-critical"},
+				{Op: "remove", Path: "/spec/template/spec/priority"},
+			},
+		},
+		{
+			name:      "class already set",
+			component: "etcd",
+			spec:      corev1.PodSpec{PriorityClassName: "hcp-critical", Priority: &priority},
+		},
+		{
+			// Pods referencing a missing class would be rejected
+			name:      "class not ready",
+			component: "cluster-version-operator",
+		},
+	} {
+		if got := m.Patches(tc.component, &tc.spec); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: Patches() = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	var disabled *priorityClassManager
+	if got := disabled.Patches("etcd", &corev1.PodSpec{}); got != nil {
+		t.Errorf("disabled manager returned patches %+v", got)
+	}
+}
+
+func TestPriorityClassManager_Ensure(t *testing.T) {
+	quietLogs(t)
+	// hcp-high has a value set by someone else, which cannot be changed
+	mismatched := &schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "hcp-high"}, Value: 5}
+	client := fake.NewSimpleClientset(mismatched)
+	client.PrependReactor("create", "priorityclasses", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		pc := action.(clienttesting.CreateAction).GetObject().(*schedulingv1.PriorityClass)
+		switch pc.Name {
+		case "hcp-default":
+			// Created by another replica of the webhook since the Get
+			return true, nil, apierrors.NewAlreadyExists(schedulingv1.Resource("priorityclasses"), pc.Name)
+		case "hcp-critical":
+			return false, nil, nil
+		}
+		return true, nil, fmt.Errorf("unexpected creation of %s", pc.Name)
+	})
+	m := &priorityClassManager{client: client, tiers: map[string]priorityTier{}, ready: map[string]bool{}}
+
+	ctx := context.Background()
+	m.ensure(ctx)
+	want := map[string]bool{"hcp-critical": true, "hcp-high": true, "hcp-default": true}
+	if !reflect.DeepEqual(m.ready, want) {
+		t.Errorf("ready = %v, want %v", m.ready, want)
+	}
+
+	created, err := client.SchedulingV1().PriorityClasses().Get(ctx, "hcp-critical", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Value != 1000000 || created.Labels[managedByLabel] != eventComponent || created.Description == "" {
+		t.Errorf("created PriorityClass = %+v", created)
+	}
+	if existing, _ := client.SchedulingV1().PriorityClasses().Get(ctx, "hcp-high", metav1.GetOptions{}); existing.Value != 5 {
+		t.Errorf("hcp-high value = %d, want the existing value kept", existing.Value)
+	}
+
+	// Classes that cannot be created are not injected
+	failing := fake.NewSimpleClientset()
+	failing.PrependReactor("create", "priorityclasses", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schedulingv1.Resource("priorityclasses"), "", fmt.Errorf("denied"))
+	})
+	m = &priorityClassManager{client: failing, tiers: map[string]priorityTier{}, ready: map[string]bool{"hcp-critical": true}}
+	m.ensure(ctx)
+	if m.ready["hcp-critical"] || m.ready["hcp-high"] || m.ready["hcp-default"] {
+		t.Errorf("ready = %v after failed creations, want none", m.ready)
+	}
+}
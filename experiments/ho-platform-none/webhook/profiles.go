@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var mutationProfileGroupVersion = schema.GroupVersion{
+	Group:   "autopilot.hypershift.openshift.io",
+	Version: "v1alpha1",
+}
+
+const (
+	defaultMutationProfileResync = 10 * time.Minute
+
+	// mutationProfileAnnotation on a namespace selects the
+	// AutopilotMutationProfile of its workloads: the name of a profile in the
+	// namespace, or namespace/name of a profile shared by several tenants
+	mutationProfileAnnotation = "autopilot.hypershift.openshift.io/mutation-profile"
+
+	// controlPlaneComponentLabel names the component of a HyperShift pod
+	controlPlaneComponentLabel = "hypershift.openshift.io/control-plane-component"
+)
+
+// Mutations a profile can opt out of
+const (
+	// mutationSecurityContext is the securityContext of the generic fixes
+	mutationSecurityContext = "securityContext"
+	// mutationResources is the static resources of the generic fixes
+	mutationResources  = "resources"
+	mutationTopology   = "topologySpread"
+	mutationPriority   = "priorityClass"
+	mutationRightSizer = "rightSizing"
+	// mutationAutoscaling is the HPA and PDB of the scaling policies
+	mutationAutoscaling = "autoscaling"
+	// mutationHostAccess is the conversion of hostPath volumes, host network
+	// and host ports
+	mutationHostAccess = "hostAccess"
+	// mutationImageRewrite is the rewrite of images to mirrors
+	mutationImageRewrite = "imageRewrite"
+)
+
+var profileMutations = []string{mutationSecurityContext, mutationResources, mutationTopology, mutationPriority, mutationRightSizer, mutationAutoscaling, mutationHostAccess,
+	mutationImageRewrite}
+
+// AutopilotMutationProfile adjusts the mutations of the workloads of the
+// namespaces referring to it with the mutationProfileAnnotation, so a tenant's
+// sizing and security settings are reviewed and changed as objects rather
+// than webhook flags
+type AutopilotMutationProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec AutopilotMutationProfileSpec `json:"spec"`
+}
+
+// AutopilotMutationProfileList is a list of AutopilotMutationProfiles
+type AutopilotMutationProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []AutopilotMutationProfile `json:"items"`
+}
+
+// AutopilotMutationProfileSpec is the sizing table, security context
+// templates and opt-outs of a profile
+type AutopilotMutationProfileSpec struct {
+	// Sizing is merged over the component overrides of the webhook, in the
+	// format of COMPONENT_OVERRIDES_FILE: a container listed replaces the
+	// override of the webhook for that container
+	Sizing componentOverrides `json:"sizing,omitempty"`
+	// SecurityContexts replace the security contexts of the generic fixes
+	SecurityContexts *SecurityContextTemplates `json:"securityContexts,omitempty"`
+	// Autoscaling is merged over the scaling policies of the webhook, in the
+	// format of AUTOSCALING_FILE: a component listed replaces the policy of
+	// the webhook for that component
+	Autoscaling scalingPolicies `json:"autoscaling,omitempty"`
+	// ImageRewrites are merged over the image rewrite rules of the webhook,
+	// in the format of IMAGE_REWRITE_FILE: a rule replaces the rule of the
+	// webhook with the same from, e.g. to pull from the mirror of the
+	// region of the tenant
+	ImageRewrites imageRewriteRules `json:"imageRewrites,omitempty"`
+	OptOut        MutationOptOut    `json:"optOut,omitempty"`
+}
+
+// SecurityContextTemplates replace the security contexts the generic fixes
+// set on pods and containers as a whole. The overrides of Sizing still apply
+// on top of them.
+type SecurityContextTemplates struct {
+	Pod       *corev1.PodSecurityContext `json:"pod,omitempty"`
+	Container *corev1.SecurityContext    `json:"container,omitempty"`
+}
+
+// MutationOptOut lists what the webhook leaves alone
+type MutationOptOut struct {
+	// Components are not mutated at all, by Deployment or StatefulSet name
+	// and by the control-plane-component label of pods
+	Components []string `json:"components,omitempty"`
+	// Mutations are not applied to any component, from profileMutations
+	Mutations []string `json:"mutations,omitempty"`
+}
+
+// mutationProfileScheme holds the kinds the profile resolver reads
+var mutationProfileScheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(corev1.AddToScheme(mutationProfileScheme))
+	mutationProfileScheme.AddKnownTypes(mutationProfileGroupVersion, &AutopilotMutationProfile{}, &AutopilotMutationProfileList{})
+	metav1.AddToGroupVersion(mutationProfileScheme, mutationProfileGroupVersion)
+}
+
+// validate checks what the CRD schema does not
+func (s *AutopilotMutationProfileSpec) validate() error {
+	for _, m := range s.OptOut.Mutations {
+		if !slices.Contains(profileMutations, m) {
+			return fmt.Errorf("unknown mutation %q to opt out of (available: %s)", m, strings.Join(profileMutations, ", "))
+		}
+	}
+	if err := s.Sizing.validate(); err != nil {
+		return fmt.Errorf("sizing: %v", err)
+	}
+	if err := s.Autoscaling.validate(); err != nil {
+		return fmt.Errorf("autoscaling: %v", err)
+	}
+	if err := s.ImageRewrites.validate(); err != nil {
+		return fmt.Errorf("imageRewrites: %v", err)
+	}
+	return nil
+}
+
+// mutationProfileResolver finds the AutopilotMutationProfile of a namespace
+// in a controller-runtime cache of Namespaces and profiles, so admissions do
+// not read them from the API server
+type mutationProfileResolver struct {
+	reader client.Reader
+	// cache is what Run starts, nil when reader is not a cache
+	cache cache.Cache
+	// synced is set once the cache has synced; until then namespaces get the
+	// mutations of the webhook configuration
+	synced atomic.Bool
+}
+
+// newMutationProfileResolverFromEnv builds the resolver, resyncing every
+// MUTATION_PROFILES_RESYNC. It returns nil when MUTATION_PROFILES is "false"
+// or when not running inside a cluster, in which case every namespace gets
+// the mutations of the webhook configuration.
+func newMutationProfileResolverFromEnv() (*mutationProfileResolver, error) {
+	if os.Getenv("MUTATION_PROFILES") == "false" {
+		return nil, nil
+	}
+	resync, err := envDuration("MUTATION_PROFILES_RESYNC", defaultMutationProfileResync)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("AutopilotMutationProfiles disabled: %v", err)
+		return nil, nil
+	}
+	c, err := cache.New(config, cache.Options{
+		Scheme:     mutationProfileScheme,
+		SyncPeriod: &resync,
+		// Admissions must not wait for an informer to be created
+		ReaderFailOnMissingInformer: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the AutopilotMutationProfile cache: %v", err)
+	}
+	r := newMutationProfileResolver(c)
+	r.cache = c
+	return r, nil
+}
+
+func newMutationProfileResolver(reader client.Reader) *mutationProfileResolver {
+	return &mutationProfileResolver{reader: reader}
+}
+
+// Run watches Namespaces and AutopilotMutationProfiles until ctx is done.
+// Without the CRD installed, profiles stay disabled.
+func (r *mutationProfileResolver) Run(ctx context.Context) {
+	for _, obj := range []client.Object{&corev1.Namespace{}, &AutopilotMutationProfile{}} {
+		if _, err := r.cache.GetInformer(ctx, obj); err != nil {
+			log.Printf("AutopilotMutationProfiles disabled, could not watch %T: %v", obj, err)
+			return
+		}
+	}
+	go func() {
+		if err := r.cache.Start(ctx); err != nil {
+			log.Printf("AutopilotMutationProfile cache stopped: %v", err)
+		}
+	}()
+	if r.cache.WaitForCacheSync(ctx) {
+		r.synced.Store(true)
+		log.Println("AutopilotMutationProfile cache synced")
+	}
+}
+
+// Get returns the AutopilotMutationProfile the namespace refers to, nil when
+// it refers to none. A reference to a missing or invalid profile is an error.
+func (r *mutationProfileResolver) Get(ctx context.Context, namespace string) (*AutopilotMutationProfile, error) {
+	if r == nil || (r.cache != nil && !r.synced.Load()) {
+		return nil, nil
+	}
+
+	var ns corev1.Namespace
+	if err := r.reader.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read namespace %s: %v", namespace, err)
+	}
+	ref := ns.Annotations[mutationProfileAnnotation]
+	if ref == "" {
+		return nil, nil
+	}
+
+	key := client.ObjectKey{Namespace: namespace, Name: ref}
+	if profileNamespace, name, ok := strings.Cut(ref, "/"); ok {
+		key = client.ObjectKey{Namespace: profileNamespace, Name: name}
+	}
+	var profile AutopilotMutationProfile
+	if err := r.reader.Get(ctx, key, &profile); err != nil {
+		return nil, fmt.Errorf("AutopilotMutationProfile %s of namespace %s: %v", key, namespace, err)
+	}
+	if err := profile.Spec.validate(); err != nil {
+		return nil, fmt.Errorf("invalid AutopilotMutationProfile %s: %v", key, err)
+	}
+	return &profile, nil
+}
+
+// withCustomProfile adjusts profile with the AutopilotMutationProfile of
+// namespace, if any. A profile that cannot be resolved is logged and ignored,
+// so a broken reference does not block the control plane.
+func (ws *WebhookServer) withCustomProfile(namespace string, profile mutationProfile) mutationProfile {
+	if ws.profiles == nil {
+		return profile
+	}
+	// Once synced, reads from the cache do not block
+	custom, err := ws.profiles.Get(context.Background(), namespace)
+	if err != nil {
+		log.Printf("Ignoring the mutation profile of namespace %s: %v", namespace, err)
+		mutationProfileResolutionsTotal.WithLabelValues("failed").Inc()
+		return profile
+	}
+	if custom == nil {
+		return profile
+	}
+	mutationProfileResolutionsTotal.WithLabelValues("applied").Inc()
+
+	overrides := componentOverrides{}
+	overrides.merge(profile.overrides)
+	overrides.merge(custom.Spec.Sizing)
+	profile.overrides = overrides
+	profile.custom = custom
+	if profile.skips(mutationRightSizer) {
+		profile.rightSizer = nil
+	}
+	scaling := scalingPolicies{}
+	scaling.merge(profile.scaling)
+	scaling.merge(custom.Spec.Autoscaling)
+	profile.scaling = scaling
+	if profile.skips(mutationAutoscaling) {
+		profile.scaling = nil
+	}
+	profile.imageRewrites = profile.imageRewrites.merge(custom.Spec.ImageRewrites)
+	if profile.skips(mutationImageRewrite) {
+		profile.imageRewrites = nil
+	}
+	return profile
+}
+
+// skips tells whether the profile opts out of a mutation
+func (p mutationProfile) skips(mutation string) bool {
+	return p.custom != nil && slices.Contains(p.custom.Spec.OptOut.Mutations, mutation)
+}
+
+// skipsComponent tells whether the profile opts a component out of all
+// mutations
+func (p mutationProfile) skipsComponent(component string) bool {
+	return p.custom != nil && component != "" && slices.Contains(p.custom.Spec.OptOut.Components, component)
+}
+
+// String names the AutopilotMutationProfile of the profile, for the logs
+func (p mutationProfile) String() string {
+	if p.custom == nil {
+		return "no AutopilotMutationProfile"
+	}
+	return fmt.Sprintf("AutopilotMutationProfile %s/%s", p.custom.Namespace, p.custom.Name)
+}
+
+var (
+	podSecurityContextPatchPath       = regexp.MustCompile(`^/spec(/template/spec)?/securityContext$`)
+	containerSecurityContextPatchPath = regexp.MustCompile(`^/spec/template/spec/(containers|initContainers)/\d+/securityContext$`)
+)
+
+// generic applies the opt-outs and security context templates of the
+// profile to the patches of the generic fixes
+func (p mutationProfile) generic(patches []patchOperation) []patchOperation {
+	if p.custom == nil {
+		return patches
+	}
+	templates := p.custom.Spec.SecurityContexts
+	if templates == nil {
+		templates = &SecurityContextTemplates{}
+	}
+
+	var kept []patchOperation
+	for _, patch := range patches {
+		switch {
+		case resourcesPatchPath.MatchString(patch.Path):
+			if p.skips(mutationResources) {
+				continue
+			}
+		case podSecurityContextPatchPath.MatchString(patch.Path):
+			if p.skips(mutationSecurityContext) {
+				continue
+			}
+			if templates.Pod != nil {
+				patch.Value = templates.Pod
+			}
+		case containerSecurityContextPatchPath.MatchString(patch.Path):
+			if p.skips(mutationSecurityContext) {
+				continue
+			}
+			if templates.Container != nil {
+				patch.Value = templates.Container
+			}
+		}
+		kept = append(kept, patch)
+	}
+	return kept
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *AutopilotMutationProfile) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := &AutopilotMutationProfile{TypeMeta: in.TypeMeta}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.deepCopyInto(&out.Spec)
+	return out
+}
+
+// DeepCopyObject implements runtime.Object
+func (in *AutopilotMutationProfileList) DeepCopyObject() runtime.Object {
+	if in == nil {
+		return nil
+	}
+	out := &AutopilotMutationProfileList{TypeMeta: in.TypeMeta}
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]AutopilotMutationProfile, len(in.Items))
+		for i := range in.Items {
+			out.Items[i] = *in.Items[i].DeepCopyObject().(*AutopilotMutationProfile)
+		}
+	}
+	return out
+}
+
+func (in *AutopilotMutationProfileSpec) deepCopyInto(out *AutopilotMutationProfileSpec) {
+	if in.Sizing != nil {
+		out.Sizing = make(componentOverrides, len(in.Sizing))
+		for component, containers := range in.Sizing {
+			out.Sizing[component] = make(map[string]containerOverride, len(containers))
+			for container, o := range containers {
+				out.Sizing[component][container] = o.deepCopy()
+			}
+		}
+	}
+	if in.SecurityContexts != nil {
+		out.SecurityContexts = &SecurityContextTemplates{
+			Pod:       in.SecurityContexts.Pod.DeepCopy(),
+			Container: in.SecurityContexts.Container.DeepCopy(),
+		}
+	}
+	if in.Autoscaling != nil {
+		out.Autoscaling = make(scalingPolicies, len(in.Autoscaling))
+		for component, policy := range in.Autoscaling {
+			if policy.MaxUnavailable != nil {
+				maxUnavailable := *policy.MaxUnavailable
+				policy.MaxUnavailable = &maxUnavailable
+			}
+			out.Autoscaling[component] = policy
+		}
+	}
+	out.ImageRewrites = slices.Clone(in.ImageRewrites)
+	out.OptOut = MutationOptOut{
+		Components: slices.Clone(in.OptOut.Components),
+		Mutations:  slices.Clone(in.OptOut.Mutations),
+	}
+}
+
+func (o containerOverride) deepCopy() containerOverride {
+	out := containerOverride{
+		Resources:       o.Resources.DeepCopy(),
+		SecurityContext: o.SecurityContext.DeepCopy(),
+	}
+	if o.Env != nil {
+		out.Env = make([]corev1.EnvVar, len(o.Env))
+		for i := range o.Env {
+			o.Env[i].DeepCopyInto(&out.Env[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// profileNamespace is the namespace of admit, referring to profile
+func profileNamespace(profile string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "clusters-test",
+		Annotations: map[string]string{mutationProfileAnnotation: profile},
+	}}
+}
+
+func mutationProfileOf(namespace, name string, spec AutopilotMutationProfileSpec) *AutopilotMutationProfile {
+	return &AutopilotMutationProfile{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Spec: spec}
+}
+
+func profileResolver(objs ...client.Object) *mutationProfileResolver {
+	return newMutationProfileResolver(fake.NewClientBuilder().WithScheme(mutationProfileScheme).WithObjects(objs...).Build())
+}
+
+func TestMutationProfileResolver_Get(t *testing.T) {
+	tenant := mutationProfileOf("clusters-test", "tenant", AutopilotMutationProfileSpec{})
+	shared := mutationProfileOf("hypershift-webhooks", "small", AutopilotMutationProfileSpec{})
+	invalid := mutationProfileOf("clusters-test", "invalid", AutopilotMutationProfileSpec{
+		OptOut: MutationOptOut{Mutations: []string{"affinity"}},
+	})
+
+	for _, tc := range []struct {
+		name      string
+		namespace *corev1.Namespace
+		want      string
+		wantErr   string
+	}{
+		{name: "profile in the namespace", namespace: profileNamespace("tenant"), want: "clusters-test/tenant"},
+		{name: "shared profile", namespace: profileNamespace("hypershift-webhooks/small"), want: "hypershift-webhooks/small"},
+		{name: "no reference", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "clusters-test"}}},
+		{name: "missing profile", namespace: profileNamespace("missing"), wantErr: "not found"},
+		{name: "invalid profile", namespace: profileNamespace("invalid"), wantErr: `unknown mutation "affinity"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := profileResolver(tc.namespace, tenant, shared, invalid)
+			profile, err := r.Get(context.Background(), "clusters-test")
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("Get() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if profile != nil {
+				got = profile.Namespace + "/" + profile.Name
+			}
+			if got != tc.want {
+				t.Errorf("Get() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if profile, err := profileResolver().Get(context.Background(), "clusters-unknown"); profile != nil || err != nil {
+		t.Errorf("Get() of a missing namespace = %v, %v, want nothing", profile, err)
+	}
+}
+
+func TestMutationProfile_Admit(t *testing.T) {
+	readOnly := true
+	profile := mutationProfileOf("clusters-test", "tenant", AutopilotMutationProfileSpec{
+		Sizing: componentOverrides{
+			"kube-apiserver": {"kube-apiserver": {Resources: overrideResources("200m", "2Gi", "1Gi")}},
+		},
+		SecurityContexts: &SecurityContextTemplates{
+			Container: &corev1.SecurityContext{ReadOnlyRootFilesystem: &readOnly},
+		},
+		OptOut: MutationOptOut{Mutations: []string{mutationTopology}},
+	})
+	topology, err := parseTopologySpreadPolicy(defaultTopologySpread, corev1.ScheduleAnyway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := &WebhookServer{
+		overrides: defaultComponentOverrides,
+		topology:  topology,
+		profiles:  profileResolver(profileNamespace("tenant"), profile),
+	}
+
+	spec := admit(t, ws, kubeAPIServerDeployment(), "Deployment")
+	byName := map[string]corev1.Container{}
+	for _, c := range spec.Containers {
+		byName[c.Name] = c
+	}
+	if got := byName["kube-apiserver"].Resources.Requests[corev1.ResourceMemory]; got.Cmp(resource.MustParse("2Gi")) != 0 {
+		t.Errorf("kube-apiserver memory request = %s, want the 2Gi of the profile", got.String())
+	}
+	if got := byName["konnectivity-server"].Resources.Requests[corev1.ResourceCPU]; got.Cmp(resource.MustParse("100m")) != 0 {
+		t.Errorf("konnectivity-server cpu request = %s, want the generic 100m", got.String())
+	}
+	for _, c := range spec.Containers {
+		if sc := c.SecurityContext; sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem || sc.RunAsUser != nil {
+			t.Errorf("%s securityContext = %+v, want the template of the profile", c.Name, sc)
+		}
+	}
+	if want := kubeAPIServerDeployment().Spec.Template.Spec.TopologySpreadConstraints; !reflect.DeepEqual(spec.TopologySpreadConstraints, want) {
+		t.Errorf("topologySpreadConstraints = %+v, want them unchanged when opted out", spec.TopologySpreadConstraints)
+	}
+	// Other namespaces keep the webhook configuration
+	if got := defaultComponentOverrides["kube-apiserver"]["kube-apiserver"].Resources.Requests[corev1.ResourceMemory]; got.Cmp(resource.MustParse("512Mi")) != 0 {
+		t.Errorf("default kube-apiserver memory = %s, the profile changed the defaults", got.String())
+	}
+}
+
+func TestMutationProfile_OptOutComponent(t *testing.T) {
+	profile := mutationProfileOf("clusters-test", "tenant", AutopilotMutationProfileSpec{
+		OptOut: MutationOptOut{Components: []string{"kube-apiserver"}, Mutations: []string{mutationSecurityContext}},
+	})
+	ws := &WebhookServer{
+		overrides: defaultComponentOverrides,
+		profiles:  profileResolver(profileNamespace("tenant"), profile),
+	}
+
+	deployment := kubeAPIServerDeployment()
+	raw, err := json.Marshal(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &admissionv1.AdmissionRequest{Namespace: "clusters-test", Object: runtime.RawExtension{Raw: raw}}
+	if patches := ws.mutateDeployment(req, nil); len(patches) != 0 {
+		t.Errorf("patches = %+v, want kube-apiserver left alone", patches)
+	}
+
+	deployment.Name = "openshift-apiserver"
+	spec := admit(t, ws, deployment, "Deployment")
+	if spec.SecurityContext != nil || spec.Containers[0].SecurityContext != nil {
+		t.Errorf("securityContext = %+v, want none when opted out", spec.SecurityContext)
+	}
+	if spec.Containers[0].Resources.Requests == nil {
+		t.Error("openshift-apiserver lost the generic resources")
+	}
+}
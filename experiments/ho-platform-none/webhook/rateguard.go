@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Defaults for the mutation rate guard. An object that is admitted more than
+// maxAdmissions times within window is considered to be in a reconcile loop
+// with HyperShift and is passed through unpatched until cooldown has elapsed.
+const (
+	defaultRateGuardMaxAdmissions = 20
+	defaultRateGuardWindow        = time.Minute
+	defaultRateGuardCooldown      = 5 * time.Minute
+)
+
+// rateGuardDecision is the outcome of recording one admission for an object
+type rateGuardDecision struct {
+	// Allowed is false while the object is cooling down and must not be patched
+	Allowed bool
+	// Tripped is true only for the admission that pushed the object over the threshold
+	Tripped bool
+	// Count is the number of admissions seen for the object within the window
+	Count int
+	// Until is when patching resumes for a throttled object
+	Until time.Time
+}
+
+// objectAdmissions tracks recent admissions for a single object
+type objectAdmissions struct {
+	seen          []time.Time
+	throttledTill time.Time
+}
+
+// mutationRateGuard counts admissions per object over a sliding window and
+// stops patching objects that are admitted too often
+type mutationRateGuard struct {
+	mu            sync.Mutex
+	maxAdmissions int
+	window        time.Duration
+	cooldown      time.Duration
+	objects       map[string]*objectAdmissions
+	lastSweep     time.Time
+	now           func() time.Time
+}
+
+func newMutationRateGuard(maxAdmissions int, window, cooldown time.Duration) *mutationRateGuard {
+	return &mutationRateGuard{
+		maxAdmissions: maxAdmissions,
+		window:        window,
+		cooldown:      cooldown,
+		objects:       make(map[string]*objectAdmissions),
+		now:           time.Now,
+	}
+}
+
+// newMutationRateGuardFromEnv builds the guard from RATE_GUARD_* environment
+// variables. Setting RATE_GUARD_MAX_ADMISSIONS to 0 disables the guard.
+func newMutationRateGuardFromEnv() (*mutationRateGuard, error) {
+	maxAdmissions, err := envInt("RATE_GUARD_MAX_ADMISSIONS", defaultRateGuardMaxAdmissions)
+	if err != nil {
+		return nil, err
+	}
+	window, err := envDuration("RATE_GUARD_WINDOW", defaultRateGuardWindow)
+	if err != nil {
+		return nil, err
+	}
+	cooldown, err := envDuration("RATE_GUARD_COOLDOWN", defaultRateGuardCooldown)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxAdmissions <= 0 {
+		return nil, nil
+	}
+	if window <= 0 || cooldown <= 0 {
+		return nil, fmt.Errorf("RATE_GUARD_WINDOW and RATE_GUARD_COOLDOWN must be positive")
+	}
+	return newMutationRateGuard(maxAdmissions, window, cooldown), nil
+}
+
+// Record registers an admission for key and reports whether the object may be patched
+func (g *mutationRateGuard) Record(key string) rateGuardDecision {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+
+	obj, ok := g.objects[key]
+	if !ok {
+		obj = &objectAdmissions{}
+		g.objects[key] = obj
+	}
+
+	if !obj.throttledTill.IsZero() {
+		if now.Before(obj.throttledTill) {
+			return rateGuardDecision{Allowed: false, Count: len(obj.seen), Until: obj.throttledTill}
+		}
+		// Cool-down is over: start counting from scratch
+		obj.throttledTill = time.Time{}
+		obj.seen = nil
+	}
+
+	obj.seen = append(pruneBefore(obj.seen, now.Add(-g.window)), now)
+
+	if len(obj.seen) > g.maxAdmissions {
+		obj.throttledTill = now.Add(g.cooldown)
+		return rateGuardDecision{Allowed: false, Tripped: true, Count: len(obj.seen), Until: obj.throttledTill}
+	}
+
+	return rateGuardDecision{Allowed: true, Count: len(obj.seen)}
+}
+
+// Throttled returns the number of objects currently in cool-down
+func (g *mutationRateGuard) Throttled() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	count := 0
+	for _, obj := range g.objects {
+		if now.Before(obj.throttledTill) {
+			count++
+		}
+	}
+	return count
+}
+
+// sweep drops idle objects so the map does not grow with every object ever admitted
+func (g *mutationRateGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < g.window {
+		return
+	}
+	g.lastSweep = now
+
+	cutoff := now.Add(-g.window)
+	for key, obj := range g.objects {
+		if now.Before(obj.throttledTill) {
+			continue
+		}
+		if len(obj.seen) == 0 || obj.seen[len(obj.seen)-1].Before(cutoff) {
+			delete(g.objects, key)
+		}
+	}
+}
+
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// rateGuardKey identifies an object across admissions
+func rateGuardKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
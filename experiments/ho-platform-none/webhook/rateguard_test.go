@@ -0,0 +1,163 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRateGuard returns a guard allowing 3 admissions a minute with a 5
+// minute cool-down, and the function advancing its clock
+func newTestRateGuard() (*mutationRateGuard, func(time.Duration)) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	g := newMutationRateGuard(3, time.Minute, 5*time.Minute)
+	g.now = func() time.Time { return now }
+	return g, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestMutationRateGuard_Threshold(t *testing.T) {
+	g, advance := newTestRateGuard()
+	start := g.now()
+	for i := 1; i <= 3; i++ {
+		if d := g.Record("Deployment/clusters-a/etcd"); !d.Allowed || d.Tripped || d.Count != i {
+			t.Fatalf("admission %d = %+v, want allowed with count %d", i, d, i)
+		}
+		advance(10 * time.Second)
+	}
+
+	d := g.Record("Deployment/clusters-a/etcd")
+	if d.Allowed || !d.Tripped || d.Count != 4 || !d.Until.Equal(start.Add(30*time.Second+5*time.Minute)) {
+		t.Errorf("admission over the threshold = %+v, want tripped until 5m later", d)
+	}
+	// Only the admission crossing the threshold trips the guard
+	advance(time.Second)
+	if d := g.Record("Deployment/clusters-a/etcd"); d.Allowed || d.Tripped {
+		t.Errorf("admission during cool-down = %+v, want blocked without tripping again", d)
+	}
+	// Other objects are counted separately
+	if d := g.Record("Deployment/clusters-b/etcd"); !d.Allowed || d.Count != 1 {
+		t.Errorf("admission of another object = %+v, want allowed", d)
+	}
+	if n := g.Throttled(); n != 1 {
+		t.Errorf("Throttled() = %d, want 1", n)
+	}
+}
+
+func TestMutationRateGuard_SlidingWindow(t *testing.T) {
+	g, advance := newTestRateGuard()
+	// One admission every 25s stays within 3 a minute forever
+	for i := 0; i < 20; i++ {
+		d := g.Record("Deployment/clusters-a/etcd")
+		if !d.Allowed || d.Count > 3 {
+			t.Fatalf("admission %d = %+v, want allowed with at most 3 in the window", i, d)
+		}
+		advance(25 * time.Second)
+	}
+}
+
+func TestMutationRateGuard_CooldownExpiry(t *testing.T) {
+	g, advance := newTestRateGuard()
+	var tripped rateGuardDecision
+	for i := 0; i < 4; i++ {
+		tripped = g.Record("Deployment/clusters-a/etcd")
+	}
+	if !tripped.Tripped {
+		t.Fatalf("4th admission = %+v, want tripped", tripped)
+	}
+
+	advance(5*time.Minute - time.Second)
+	if d := g.Record("Deployment/clusters-a/etcd"); d.Allowed || !d.Until.Equal(tripped.Until) {
+		t.Errorf("admission 1s before the end of the cool-down = %+v, want blocked until %s", d, tripped.Until)
+	}
+	if n := g.Throttled(); n != 1 {
+		t.Errorf("Throttled() = %d during the cool-down, want 1", n)
+	}
+
+	// Patching resumes and counting starts from scratch, so the admissions
+	// during the cool-down do not trip the guard again
+	advance(time.Second)
+	for i := 1; i <= 3; i++ {
+		if d := g.Record("Deployment/clusters-a/etcd"); !d.Allowed || d.Count != i {
+			t.Errorf("admission %d after the cool-down = %+v, want allowed with count %d", i, d, i)
+		}
+	}
+	if n := g.Throttled(); n != 0 {
+		t.Errorf("Throttled() = %d after the cool-down, want 0", n)
+	}
+	if d := g.Record("Deployment/clusters-a/etcd"); !d.Tripped {
+		t.Errorf("4th admission after the cool-down = %+v, want tripped again", d)
+	}
+}
+
+func TestMutationRateGuard_Sweep(t *testing.T) {
+	g, advance := newTestRateGuard()
+	g.Record("Deployment/clusters-a/idle")
+	for i := 0; i < 4; i++ {
+		g.Record("Deployment/clusters-a/looping")
+	}
+	advance(30 * time.Second)
+	g.Record("Deployment/clusters-a/recent")
+
+	// Sweeps run at most once a window
+	advance(20 * time.Second)
+	g.Record("Deployment/clusters-a/other")
+	if len(g.objects) != 4 {
+		t.Errorf("%d objects tracked before a window has passed, want 4", len(g.objects))
+	}
+
+	// Idle objects are evicted, objects in cool-down and with recent
+	// admissions are kept
+	advance(11 * time.Second)
+	g.Record("Deployment/clusters-a/other")
+	for key, want := range map[string]bool{
+		"Deployment/clusters-a/idle":    false,
+		"Deployment/clusters-a/looping": true,
+		"Deployment/clusters-a/recent":  true,
+		"Deployment/clusters-a/other":   true,
+	} {
+		if _, ok := g.objects[key]; ok != want {
+			t.Errorf("%s tracked = %t, want %t", key, ok, want)
+		}
+	}
+
+	// Once its cool-down is over, the looping object is evicted too
+	advance(5 * time.Minute)
+	g.Record("Deployment/clusters-a/other")
+	if _, ok := g.objects["Deployment/clusters-a/looping"]; ok || len(g.objects) != 1 {
+		t.Errorf("objects tracked after the cool-down = %d, want only the object just admitted", len(g.objects))
+	}
+}
+
+func TestNewMutationRateGuardFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		env      map[string]string
+		disabled bool
+		wantErr  string
+	}{
+		{name: "defaults", env: map[string]string{}},
+		{name: "disabled", env: map[string]string{"RATE_GUARD_MAX_ADMISSIONS": "0"}, disabled: true},
+		{name: "invalid max", env: map[string]string{"RATE_GUARD_MAX_ADMISSIONS": "many"}, wantErr: "RATE_GUARD_MAX_ADMISSIONS"},
+		{name: "negative window", env: map[string]string{"RATE_GUARD_WINDOW": "-1m"}, wantErr: "must be positive"},
+		{name: "invalid cooldown", env: map[string]string{"RATE_GUARD_COOLDOWN": "soon"}, wantErr: "RATE_GUARD_COOLDOWN"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range []string{"RATE_GUARD_MAX_ADMISSIONS", "RATE_GUARD_WINDOW", "RATE_GUARD_COOLDOWN"} {
+				t.Setenv(name, tc.env[name])
+			}
+			g, err := newMutationRateGuardFromEnv()
+			switch {
+			case tc.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("error = %v, want %s", err, tc.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case tc.disabled != (g == nil):
+				t.Errorf("guard = %+v, want disabled %t", g, tc.disabled)
+			case g != nil && (g.maxAdmissions != defaultRateGuardMaxAdmissions || g.window != defaultRateGuardWindow || g.cooldown != defaultRateGuardCooldown):
+				t.Errorf("guard = %d admissions per %s, cool-down %s, want the defaults", g.maxAdmissions, g.window, g.cooldown)
+			}
+		})
+	}
+}
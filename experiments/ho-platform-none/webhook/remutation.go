@@ -0,0 +1,545 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	defaultRemutationInterval     = 30 * time.Minute
+	defaultRemutationBatchSize    = 5
+	defaultRemutationBatchTimeout = 10 * time.Minute
+	// defaultRemutationResourceTolerance is the change of a resource, in
+	// percent, below which right-sized requests do not make a workload stale
+	defaultRemutationResourceTolerance = 10
+
+	// remutationStartDelay leaves the caches of the webhook time to sync
+	// before the first pass, so the dry runs see the profiles of namespaces
+	remutationStartDelay = time.Minute
+	// remutationPoll is how often the rollouts of a batch are checked
+	remutationPoll = 5 * time.Second
+
+	// remutationLease is the Lease electing the webhook replica that rolls
+	// workloads out, so replicas do not restart the same pods twice
+	remutationLease = "hypershift-autopilot-webhook-remutation"
+)
+
+// Results of re-mutation rollouts, for remutationRolloutsTotal
+const (
+	remutationCompleted = "completed"
+	remutationFailed    = "failed"
+	remutationTimedOut  = "timeout"
+)
+
+// remutationTarget is a Deployment or StatefulSet whose pod template differs
+// from the one the webhook admits now
+type remutationTarget struct {
+	deployment  *appsv1.Deployment
+	statefulSet *appsv1.StatefulSet
+}
+
+func (t remutationTarget) object() metav1.Object {
+	if t.deployment != nil {
+		return t.deployment
+	}
+	return t.statefulSet
+}
+
+func (t remutationTarget) kind() string {
+	if t.deployment != nil {
+		return "Deployment"
+	}
+	return "StatefulSet"
+}
+
+func (t remutationTarget) String() string {
+	return fmt.Sprintf("%s %s/%s", t.kind(), t.object().GetNamespace(), t.object().GetName())
+}
+
+func (t remutationTarget) reference() *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "apps/v1",
+		Kind:       t.kind(),
+		Namespace:  t.object().GetNamespace(),
+		Name:       t.object().GetName(),
+		UID:        t.object().GetUID(),
+	}
+}
+
+// remutationController rolls out the control plane workloads admitted before
+// the mutations of the webhook changed, e.g. with a new
+// AutopilotMutationProfile or component override. Mutations only apply at
+// admission, so it compares every in-scope workload with a server-side dry
+// run of an update, which goes through the webhook like any update, and
+// updates the workloads whose pod template would change in batches, waiting
+// for each batch to roll out before the next.
+type remutationController struct {
+	client kubernetes.Interface
+	// inScope tells whether the webhook mutates the workloads of a namespace
+	inScope  func(namespace string) bool
+	recorder record.EventRecorder
+
+	interval     time.Duration
+	batchSize    int
+	batchTimeout time.Duration
+	poll         time.Duration
+	// dryRun only reports the stale workloads
+	dryRun bool
+	// resourceTolerance is the change of a container's requests or limits,
+	// in percent, ignored when comparing templates. Right-sized requests
+	// follow usage, which a rollout changes in turn: without a tolerance
+	// every pass would restart every right-sized component.
+	resourceTolerance int
+
+	// namespace and identity are the Lease of the leader election and the
+	// name of the replica in it
+	namespace string
+	identity  string
+}
+
+// newRemutationControllerFromEnv builds the controller from
+// REMUTATION_INTERVAL, REMUTATION_BATCH_SIZE, REMUTATION_BATCH_TIMEOUT and
+// REMUTATION_DRY_RUN and REMUTATION_RESOURCE_TOLERANCE, which defaults to
+// defaultRemutationResourceTolerance with rightSizing and 0 without. It rolls
+// pods out, so unlike the other features it is only enabled when REMUTATION
+// is "true"; it returns nil otherwise or when not running inside a cluster.
+func newRemutationControllerFromEnv(inScope func(namespace string) bool, recorder record.EventRecorder, rightSizing bool) (*remutationController, error) {
+	if os.Getenv("REMUTATION") != "true" {
+		return nil, nil
+	}
+	interval, err := envDuration("REMUTATION_INTERVAL", defaultRemutationInterval)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("REMUTATION_INTERVAL must be positive")
+	}
+	batchSize, err := envInt("REMUTATION_BATCH_SIZE", defaultRemutationBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("REMUTATION_BATCH_SIZE must be at least 1")
+	}
+	batchTimeout, err := envDuration("REMUTATION_BATCH_TIMEOUT", defaultRemutationBatchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if batchTimeout <= 0 {
+		return nil, fmt.Errorf("REMUTATION_BATCH_TIMEOUT must be positive")
+	}
+	defaultTolerance := 0
+	if rightSizing {
+		defaultTolerance = defaultRemutationResourceTolerance
+	}
+	resourceTolerance, err := envInt("REMUTATION_RESOURCE_TOLERANCE", defaultTolerance)
+	if err != nil {
+		return nil, err
+	}
+	if resourceTolerance < 0 {
+		return nil, fmt.Errorf("REMUTATION_RESOURCE_TOLERANCE must not be negative")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("Re-mutation of running workloads disabled: %v", err)
+		return nil, nil
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create client: %v", err)
+	}
+	namespace, err := podNamespace()
+	if err != nil {
+		return nil, err
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the replica name: %v", err)
+	}
+
+	c := newRemutationController(clientset, inScope, recorder, interval, batchSize, batchTimeout)
+	c.dryRun = os.Getenv("REMUTATION_DRY_RUN") == "true"
+	c.resourceTolerance = resourceTolerance
+	c.namespace = namespace
+	c.identity = identity
+	return c, nil
+}
+
+func newRemutationController(client kubernetes.Interface, inScope func(namespace string) bool, recorder record.EventRecorder,
+	interval time.Duration, batchSize int, batchTimeout time.Duration) *remutationController {
+	return &remutationController{
+		client:       client,
+		inScope:      inScope,
+		recorder:     recorder,
+		interval:     interval,
+		batchSize:    batchSize,
+		batchTimeout: batchTimeout,
+		poll:         remutationPoll,
+	}
+}
+
+// String describes the configuration, for the startup log
+func (c *remutationController) String() string {
+	mode := "rolling out"
+	if c.dryRun {
+		mode = "dry run"
+	}
+	return fmt.Sprintf("every %s, batches of %d with a %s timeout, resource changes under %d%% ignored, %s",
+		c.interval, c.batchSize, c.batchTimeout, c.resourceTolerance, mode)
+}
+
+// Run re-mutates the stale workloads every interval while the replica holds
+// the Lease, until ctx is done
+func (c *remutationController) Run(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: c.namespace, Name: remutationLease},
+		Client:     c.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.identity},
+	}
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   30 * time.Second,
+			RenewDeadline:   20 * time.Second,
+			RetryPeriod:     5 * time.Second,
+			ReleaseOnCancel: true,
+			Name:            remutationLease,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: c.lead,
+				OnStoppedLeading: func() {
+					log.Printf("Re-mutation: %s stopped leading", c.identity)
+				},
+			},
+		})
+	}
+}
+
+// lead runs a pass after remutationStartDelay and every interval, until ctx
+// is done or the replica loses the Lease
+func (c *remutationController) lead(ctx context.Context) {
+	log.Printf("Re-mutation: %s is leading, first pass in %s", c.identity, remutationStartDelay)
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(remutationStartDelay):
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if err := c.pass(ctx); err != nil {
+			log.Printf("Re-mutation pass failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pass finds the stale workloads and rolls them out batch by batch. A batch
+// not rolled out within batchTimeout ends the pass, so a mutation breaking
+// the pods does not spread to the next batches; the next pass resumes with
+// the remaining workloads.
+func (c *remutationController) pass(ctx context.Context) error {
+	start := time.Now()
+	stale, err := c.stale(ctx)
+	if err != nil {
+		return err
+	}
+	remutationStaleWorkloads.Set(float64(len(stale)))
+	if len(stale) == 0 {
+		log.Println("Re-mutation: all workloads in scope have the current mutations")
+		return nil
+	}
+	if c.dryRun {
+		for _, target := range stale {
+			log.Printf("Re-mutation (dry run): %s would be rolled out", target)
+		}
+		return nil
+	}
+
+	batches := remutationBatches(stale, c.batchSize)
+	log.Printf("Re-mutation: rolling out %d stale workloads in %d batches", len(stale), len(batches))
+	remaining := len(stale)
+	for i, batch := range batches {
+		progress := fmt.Sprintf("batch %d/%d", i+1, len(batches))
+		completed, err := c.rollOut(ctx, batch, progress)
+		remaining -= completed
+		remutationStaleWorkloads.Set(float64(remaining))
+		if err != nil {
+			return fmt.Errorf("%s: %v, %d workloads left for the next pass", progress, err, remaining)
+		}
+	}
+	log.Printf("Re-mutation: pass done in %s, %d of %d workloads rolled out", time.Since(start).Round(time.Second), len(stale)-remaining, len(stale))
+	return nil
+}
+
+// stale returns the in-scope workloads whose pod template a dry-run update
+// changes beyond resourceTolerance, sorted by namespace, kind and name. Paused Deployments would not
+// roll out and are left alone.
+func (c *remutationController) stale(ctx context.Context) ([]remutationTarget, error) {
+	dryRun := metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}, FieldManager: eventComponent}
+	var stale []remutationTarget
+
+	deployments, err := c.client.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list Deployments: %v", err)
+	}
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		if !c.inScope(d.Namespace) || d.DeletionTimestamp != nil || d.Spec.Paused {
+			continue
+		}
+		admitted, err := c.client.AppsV1().Deployments(d.Namespace).Update(ctx, d.DeepCopy(), dryRun)
+		if err != nil {
+			log.Printf("Re-mutation: could not dry-run an update of Deployment %s/%s: %v", d.Namespace, d.Name, err)
+			continue
+		}
+		if c.changed(&d.Spec.Template, &admitted.Spec.Template) {
+			stale = append(stale, remutationTarget{deployment: d})
+		}
+	}
+
+	statefulSets, err := c.client.AppsV1().StatefulSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list StatefulSets: %v", err)
+	}
+	for i := range statefulSets.Items {
+		s := &statefulSets.Items[i]
+		if !c.inScope(s.Namespace) || s.DeletionTimestamp != nil {
+			continue
+		}
+		admitted, err := c.client.AppsV1().StatefulSets(s.Namespace).Update(ctx, s.DeepCopy(), dryRun)
+		if err != nil {
+			log.Printf("Re-mutation: could not dry-run an update of StatefulSet %s/%s: %v", s.Namespace, s.Name, err)
+			continue
+		}
+		if c.changed(&s.Spec.Template, &admitted.Spec.Template) {
+			stale = append(stale, remutationTarget{statefulSet: s})
+		}
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		a, b := stale[i].object(), stale[j].object()
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		if stale[i].kind() != stale[j].kind() {
+			return stale[i].kind() < stale[j].kind()
+		}
+		return a.GetName() < b.GetName()
+	})
+	return stale, nil
+}
+
+// changed tells whether the admitted pod template differs from the current
+// one, ignoring the resources of containers that changed by less than
+// resourceTolerance
+func (c *remutationController) changed(current, admitted *corev1.PodTemplateSpec) bool {
+	if c.resourceTolerance > 0 {
+		admitted = admitted.DeepCopy()
+		for _, lists := range [][2][]corev1.Container{
+			{current.Spec.InitContainers, admitted.Spec.InitContainers},
+			{current.Spec.Containers, admitted.Spec.Containers},
+		} {
+			for i := range lists[1] {
+				if i < len(lists[0]) && lists[0][i].Name == lists[1][i].Name &&
+					resourcesWithin(lists[0][i].Resources, lists[1][i].Resources, c.resourceTolerance) {
+					lists[1][i].Resources = lists[0][i].Resources
+				}
+			}
+		}
+	}
+	return !equality.Semantic.DeepEqual(*current, *admitted)
+}
+
+// resourcesWithin tells whether two resource requirements set the same
+// resources, each within percent of the other
+func resourcesWithin(a, b corev1.ResourceRequirements, percent int) bool {
+	for _, lists := range [][2]corev1.ResourceList{{a.Requests, b.Requests}, {a.Limits, b.Limits}} {
+		if len(lists[0]) != len(lists[1]) {
+			return false
+		}
+		for name, x := range lists[0] {
+			y, ok := lists[1][name]
+			if !ok {
+				return false
+			}
+			vx, vy := x.AsApproximateFloat64(), y.AsApproximateFloat64()
+			if math.Abs(vx-vy)*100 > math.Max(vx, vy)*float64(percent) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// remutationBatches splits the targets into batches of at most size, with
+// at most one workload per namespace in a batch, so the components of a
+// hosted control plane restart one after the other
+func remutationBatches(targets []remutationTarget, size int) [][]remutationTarget {
+	var batches [][]remutationTarget
+	pending := targets
+	for len(pending) > 0 {
+		var batch, next []remutationTarget
+		namespaces := map[string]bool{}
+		for _, target := range pending {
+			namespace := target.object().GetNamespace()
+			if len(batch) == size || namespaces[namespace] {
+				next = append(next, target)
+				continue
+			}
+			namespaces[namespace] = true
+			batch = append(batch, target)
+		}
+		batches = append(batches, batch)
+		pending = next
+	}
+	return batches
+}
+
+// rollOut updates the workloads of a batch, so the webhook mutates them, and
+// waits for them to roll out. It returns how many did, and an error when the
+// batch did not roll out within batchTimeout.
+func (c *remutationController) rollOut(ctx context.Context, batch []remutationTarget, progress string) (int, error) {
+	generations := map[int]int64{}
+	for i, target := range batch {
+		log.Printf("Re-mutation %s: rolling out %s", progress, target)
+		generation, err := c.update(ctx, target)
+		if err != nil {
+			// Changed since the dry run, or rejected: the next pass retries
+			log.Printf("Re-mutation %s: could not update %s: %v", progress, target, err)
+			remutationRolloutsTotal.WithLabelValues(target.kind(), remutationFailed).Inc()
+			continue
+		}
+		generations[i] = generation
+		c.event(target, corev1.EventTypeNormal, "AutopilotRemutationStarted",
+			fmt.Sprintf("Rolling out to apply the current Autopilot mutations (%s)", progress))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.batchTimeout)
+	defer cancel()
+	completed := 0
+	for len(generations) > 0 {
+		for i, generation := range generations {
+			done, err := c.rolledOut(ctx, batch[i], generation)
+			if err != nil {
+				log.Printf("Re-mutation %s: could not read %s: %v", progress, batch[i], err)
+				continue
+			}
+			if done {
+				log.Printf("Re-mutation %s: %s rolled out", progress, batch[i])
+				remutationRolloutsTotal.WithLabelValues(batch[i].kind(), remutationCompleted).Inc()
+				delete(generations, i)
+				completed++
+			}
+		}
+		if len(generations) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			for i := range generations {
+				log.Printf("Re-mutation %s: %s not rolled out after %s", progress, batch[i], c.batchTimeout)
+				remutationRolloutsTotal.WithLabelValues(batch[i].kind(), remutationTimedOut).Inc()
+				c.event(batch[i], corev1.EventTypeWarning, "AutopilotRemutationStalled",
+					fmt.Sprintf("Not rolled out after %s, re-mutation of the other workloads paused until the next pass", c.batchTimeout))
+			}
+			return completed, fmt.Errorf("%d workloads not rolled out after %s", len(generations), c.batchTimeout)
+		case <-time.After(c.poll):
+		}
+	}
+	return completed, nil
+}
+
+// update stores the workload unchanged, which has the webhook mutate it, and
+// returns its generation once updated
+func (c *remutationController) update(ctx context.Context, target remutationTarget) (int64, error) {
+	options := metav1.UpdateOptions{FieldManager: eventComponent}
+	if target.deployment != nil {
+		updated, err := c.client.AppsV1().Deployments(target.deployment.Namespace).Update(ctx, target.deployment.DeepCopy(), options)
+		if err != nil {
+			return 0, err
+		}
+		return updated.Generation, nil
+	}
+	updated, err := c.client.AppsV1().StatefulSets(target.statefulSet.Namespace).Update(ctx, target.statefulSet.DeepCopy(), options)
+	if err != nil {
+		return 0, err
+	}
+	return updated.Generation, nil
+}
+
+// rolledOut tells whether every replica of the workload runs the template of
+// generation, as kubectl rollout status does. StatefulSets updated OnDelete
+// are done once the controller saw the update: their pods get the new
+// template when they are deleted.
+func (c *remutationController) rolledOut(ctx context.Context, target remutationTarget, generation int64) (bool, error) {
+	if target.deployment != nil {
+		d, err := c.client.AppsV1().Deployments(target.deployment.Namespace).Get(ctx, target.deployment.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		return d.Status.ObservedGeneration >= generation && d.Status.UpdatedReplicas == replicas &&
+			d.Status.Replicas == replicas && d.Status.AvailableReplicas == replicas, nil
+	}
+
+	s, err := c.client.AppsV1().StatefulSets(target.statefulSet.Namespace).Get(ctx, target.statefulSet.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if s.Status.ObservedGeneration < generation {
+		return false, nil
+	}
+	if s.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return true, nil
+	}
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	return s.Status.UpdatedReplicas == replicas && s.Status.ReadyReplicas == replicas &&
+		s.Status.CurrentRevision == s.Status.UpdateRevision, nil
+}
+
+// event posts an Event on the workload, if Events are enabled
+func (c *remutationController) event(target remutationTarget, eventType, reason, message string) {
+	if c.recorder == nil {
+		return
+	}
+	c.recorder.Event(target.reference(), eventType, reason, message)
+}
+
+// inScope tells whether the webhook mutates the workloads of a namespace,
+// as mutate decides
+func (ws *WebhookServer) inScope(namespace string) bool {
+	_, hasHCP := ws.hcps.Get(namespace)
+	return isHyperShiftControlPlane(namespace) || hasHCP
+}
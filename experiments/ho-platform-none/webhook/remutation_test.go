@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// fakeRemutationWebhook has updates of Deployments and StatefulSets set the
+// priority class, as the webhook would after a change of PRIORITY_TIERS.
+// Dry runs are answered without storing; real updates roll out at once,
+// unless rollOut is false.
+func fakeRemutationWebhook(client *fake.Clientset, rollOut bool) {
+	for _, resource := range []string{"deployments", "statefulsets"} {
+		client.PrependReactor("update", resource, func(action clienttesting.Action) (bool, runtime.Object, error) {
+			update := action.(clienttesting.UpdateActionImpl)
+			obj := update.GetObject().DeepCopyObject()
+			switch o := obj.(type) {
+			case *appsv1.Deployment:
+				o.Spec.Template.Spec.PriorityClassName = "hcp-critical"
+				if !slices.Contains(update.UpdateOptions.DryRun, metav1.DryRunAll) && rollOut {
+					o.Generation++
+					o.Status = appsv1.DeploymentStatus{ObservedGeneration: o.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+				}
+			case *appsv1.StatefulSet:
+				o.Spec.Template.Spec.PriorityClassName = "hcp-critical"
+				if !slices.Contains(update.UpdateOptions.DryRun, metav1.DryRunAll) && rollOut {
+					o.Generation++
+					o.Status = appsv1.StatefulSetStatus{ObservedGeneration: o.Generation, Replicas: 1, UpdatedReplicas: 1, ReadyReplicas: 1}
+				}
+			}
+			if slices.Contains(update.UpdateOptions.DryRun, metav1.DryRunAll) {
+				return true, obj, nil
+			}
+			err := client.Tracker().Update(update.GetResource(), obj, update.GetNamespace())
+			return true, obj, err
+		})
+	}
+}
+
+func remutationDeployment(namespace, name, priorityClass string) *appsv1.Deployment {
+	d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Generation: 1}}
+	replicas := int32(1)
+	d.Spec.Replicas = &replicas
+	d.Spec.Template.Spec.PriorityClassName = priorityClass
+	return d
+}
+
+func newTestRemutationController(client *fake.Clientset) *remutationController {
+	c := newRemutationController(client, isHyperShiftControlPlane, nil, time.Hour, 2, time.Second)
+	c.poll = 10 * time.Millisecond
+	return c
+}
+
+func TestRemutationStale(t *testing.T) {
+	etcd := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "clusters-b", Name: "etcd"}}
+	paused := remutationDeployment("clusters-b", "paused", "")
+	paused.Spec.Paused = true
+	client := fake.NewClientset(
+		remutationDeployment("clusters-a", "kube-apiserver", ""),
+		remutationDeployment("clusters-a", "kube-scheduler", "hcp-critical"),
+		remutationDeployment("default", "unrelated", ""),
+		paused,
+		etcd,
+	)
+	fakeRemutationWebhook(client, true)
+
+	stale, err := newTestRemutationController(client).stale(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, target := range stale {
+		got = append(got, target.String())
+	}
+	want := []string{"Deployment clusters-a/kube-apiserver", "StatefulSet clusters-b/etcd"}
+	if !slices.Equal(got, want) {
+		t.Errorf("stale = %v, want %v", got, want)
+	}
+
+	// Dry runs are not stored
+	d, err := client.AppsV1().Deployments("clusters-a").Get(context.Background(), "kube-apiserver", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Spec.Template.Spec.PriorityClassName != "" {
+		t.Errorf("dry run stored priority class %q", d.Spec.Template.Spec.PriorityClassName)
+	}
+}
+
+// fakeRightSizingWebhook has dry-run updates of Deployments set the memory
+// request of their container to memory, as right-sizing from new usage would
+func fakeRightSizingWebhook(client *fake.Clientset, memory string) {
+	client.PrependReactor("update", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		d := action.(clienttesting.UpdateActionImpl).GetObject().DeepCopyObject().(*appsv1.Deployment)
+		d.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceMemory] = resource.MustParse(memory)
+		return true, d, nil
+	})
+}
+
+func TestRemutationStale_RightSizedResources(t *testing.T) {
+	rightSized := func() *appsv1.Deployment {
+		d := remutationDeployment("clusters-a", "kube-apiserver", "")
+		d.Spec.Template.Spec.Containers = []corev1.Container{{
+			Name: "kube-apiserver",
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("500m"),
+				corev1.ResourceMemory: resource.MustParse("2000Mi"),
+			}},
+		}}
+		return d
+	}
+
+	for _, tc := range []struct {
+		memory    string
+		tolerance int
+		stale     bool
+	}{
+		// Usage refreshed by a few Mi: not worth a restart
+		{"2001Mi", defaultRemutationResourceTolerance, false},
+		{"2150Mi", defaultRemutationResourceTolerance, false},
+		// Past the threshold either way
+		{"2500Mi", defaultRemutationResourceTolerance, true},
+		{"1500Mi", defaultRemutationResourceTolerance, true},
+		// Without right-sizing any change counts
+		{"2001Mi", 0, true},
+	} {
+		client := fake.NewClientset(rightSized())
+		fakeRightSizingWebhook(client, tc.memory)
+		c := newTestRemutationController(client)
+		c.resourceTolerance = tc.tolerance
+
+		stale, err := c.stale(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(stale) == 1; got != tc.stale {
+			t.Errorf("memory 2000Mi -> %s with %d%% tolerance: stale = %v, want %v", tc.memory, tc.tolerance, got, tc.stale)
+		}
+	}
+
+	// Other changes of a right-sized workload still count
+	client := fake.NewClientset(rightSized())
+	fakeRightSizingWebhook(client, "2001Mi")
+	fakeRemutationWebhook(client, true)
+	c := newTestRemutationController(client)
+	c.resourceTolerance = defaultRemutationResourceTolerance
+	if stale, err := c.stale(context.Background()); err != nil || len(stale) != 1 {
+		t.Errorf("stale = %v, %v, want the priority class change to count", stale, err)
+	}
+}
+
+func TestResourcesWithin(t *testing.T) {
+	requests := func(cpu string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}}
+	}
+	for _, tc := range []struct {
+		a, b corev1.ResourceRequirements
+		want bool
+	}{
+		{requests("1"), requests("1050m"), true},
+		{requests("1"), requests("1200m"), false},
+		{requests("1"), corev1.ResourceRequirements{}, false},
+		{requests("1"), corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1")}}, false},
+		{corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}}, requests("2"), false},
+	} {
+		if got := resourcesWithin(tc.a, tc.b, 10); got != tc.want {
+			t.Errorf("resourcesWithin(%v, %v, 10) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestRemutationBatches(t *testing.T) {
+	targets := []remutationTarget{
+		{deployment: remutationDeployment("clusters-a", "kube-apiserver", "")},
+		{deployment: remutationDeployment("clusters-a", "kube-scheduler", "")},
+		{deployment: remutationDeployment("clusters-b", "kube-apiserver", "")},
+		{deployment: remutationDeployment("clusters-c", "kube-apiserver", "")},
+		{deployment: remutationDeployment("clusters-d", "kube-apiserver", "")},
+	}
+
+	var got []string
+	for _, batch := range remutationBatches(targets, 3) {
+		var names []string
+		for _, target := range batch {
+			names = append(names, target.object().GetNamespace()+"/"+target.object().GetName())
+		}
+		got = append(got, strings.Join(names, ","))
+	}
+	// One component per hosted control plane and batch
+	want := []string{
+		"clusters-a/kube-apiserver,clusters-b/kube-apiserver,clusters-c/kube-apiserver",
+		"clusters-a/kube-scheduler,clusters-d/kube-apiserver",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestRemutationPass(t *testing.T) {
+	client := fake.NewClientset(
+		remutationDeployment("clusters-a", "kube-apiserver", ""),
+		remutationDeployment("clusters-a", "kube-scheduler", ""),
+		remutationDeployment("clusters-b", "kube-apiserver", ""),
+	)
+	fakeRemutationWebhook(client, true)
+
+	c := newTestRemutationController(client)
+	if err := c.pass(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range [][2]string{{"clusters-a", "kube-apiserver"}, {"clusters-a", "kube-scheduler"}, {"clusters-b", "kube-apiserver"}} {
+		d, err := client.AppsV1().Deployments(key[0]).Get(context.Background(), key[1], metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Spec.Template.Spec.PriorityClassName != "hcp-critical" {
+			t.Errorf("%s/%s was not re-mutated", key[0], key[1])
+		}
+	}
+
+	stale, err := c.stale(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 0 {
+		t.Errorf("%d workloads still stale after the pass", len(stale))
+	}
+}
+
+func TestRemutationDryRun(t *testing.T) {
+	client := fake.NewClientset(remutationDeployment("clusters-a", "kube-apiserver", ""))
+	fakeRemutationWebhook(client, true)
+
+	c := newTestRemutationController(client)
+	c.dryRun = true
+	if err := c.pass(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	d, err := client.AppsV1().Deployments("clusters-a").Get(context.Background(), "kube-apiserver", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Spec.Template.Spec.PriorityClassName != "" {
+		t.Error("dry run rolled the Deployment out")
+	}
+}
+
+func TestRemutationStopsAtStalledBatch(t *testing.T) {
+	client := fake.NewClientset(
+		remutationDeployment("clusters-a", "kube-apiserver", ""),
+		remutationDeployment("clusters-a", "kube-scheduler", ""),
+	)
+	fakeRemutationWebhook(client, false)
+
+	c := newTestRemutationController(client)
+	c.batchTimeout = 50 * time.Millisecond
+	err := c.pass(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "batch 1/2") {
+		t.Fatalf("pass error = %v, want the first batch to stall", err)
+	}
+
+	// The second batch was not started
+	d, err := client.AppsV1().Deployments("clusters-a").Get(context.Background(), "kube-scheduler", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Spec.Template.Spec.PriorityClassName != "" {
+		t.Error("kube-scheduler rolled out after kube-apiserver stalled")
+	}
+}
+
+func TestRemutationRolledOut(t *testing.T) {
+	c := newTestRemutationController(fake.NewClientset())
+	onDelete := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: "clusters-a", Name: "etcd", Generation: 2}}
+	replicas := int32(3)
+	onDelete.Spec.Replicas = &replicas
+	onDelete.Spec.UpdateStrategy.Type = appsv1.OnDeleteStatefulSetStrategyType
+	onDelete.Status = appsv1.StatefulSetStatus{ObservedGeneration: 2, UpdatedReplicas: 0, ReadyReplicas: 3}
+	rolling := onDelete.DeepCopy()
+	rolling.Name = "etcd-rolling"
+	rolling.Spec.UpdateStrategy.Type = appsv1.RollingUpdateStatefulSetStrategyType
+	rolling.Status.CurrentRevision, rolling.Status.UpdateRevision = "etcd-1", "etcd-2"
+	for _, s := range []*appsv1.StatefulSet{onDelete, rolling} {
+		if _, err := c.client.AppsV1().StatefulSets("clusters-a").Create(context.Background(), s, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if done, err := c.rolledOut(context.Background(), remutationTarget{statefulSet: onDelete}, 2); err != nil || !done {
+		t.Errorf("OnDelete StatefulSet rolled out = %v, %v, want true once observed", done, err)
+	}
+	if done, err := c.rolledOut(context.Background(), remutationTarget{statefulSet: rolling}, 2); err != nil || done {
+		t.Errorf("RollingUpdate StatefulSet rolled out = %v, %v, want false while pods are updated", done, err)
+	}
+	gone := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "clusters-a", Name: "deleted"}}
+	if done, err := c.rolledOut(context.Background(), remutationTarget{deployment: gone}, 1); err != nil || !done {
+		t.Errorf("deleted Deployment rolled out = %v, %v, want true", done, err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const defaultRightSizingHeadroom = 20 // percent
+
+// usageSource provides the observed or recommended resources of a container
+// of a HyperShift component
+type usageSource interface {
+	// Name identifies the source in logs and metrics
+	Name() string
+	// Usage returns the resources of a container of the Deployment or
+	// StatefulSet kind/name in namespace
+	Usage(namespace, kind, name, container string) (corev1.ResourceList, bool)
+	// Run keeps the source up to date until ctx is done
+	Run(ctx context.Context)
+}
+
+// rightSizer replaces the static resource requests of the webhook with
+// requests computed from usage data. Like every resource patch, they are
+// then bounded by the Autopilot constraints, see autopilotConstraints.Bound.
+type rightSizer struct {
+	source   usageSource
+	headroom int
+}
+
+// newRightSizerFromEnv builds the right-sizer from RIGHTSIZING_* environment
+// variables. RIGHTSIZING_SOURCE is "vpa" for VerticalPodAutoscaler
+// recommendations or "monitoring" for Cloud Monitoring usage; it returns nil
+// when unset, in which case the static requests are used.
+func newRightSizerFromEnv() (*rightSizer, error) {
+	headroom, err := envInt("RIGHTSIZING_HEADROOM", defaultRightSizingHeadroom)
+	if err != nil {
+		return nil, err
+	}
+	if headroom < 0 {
+		return nil, fmt.Errorf("RIGHTSIZING_HEADROOM must not be negative")
+	}
+
+	var source usageSource
+	switch name := os.Getenv("RIGHTSIZING_SOURCE"); name {
+	case "":
+		return nil, nil
+	case "vpa":
+		source, err = newVPASource()
+	case "monitoring":
+		source, err = newMonitoringSourceFromEnv()
+	default:
+		return nil, fmt.Errorf("invalid RIGHTSIZING_SOURCE %q: must be vpa or monitoring", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rightSizer{source: source, headroom: headroom}, nil
+}
+
+// resourcesPatchPath matches the patches setting the resources of a container
+var resourcesPatchPath = regexp.MustCompile(`^/spec/template/spec/(containers|initContainers)/(\d+)/resources$`)
+
+// Apply rewrites the resource patches of a workload's containers with
+// requests computed from usage. Containers without usage data keep the static
+// requests.
+func (rs *rightSizer) Apply(namespace, kind, name string, spec *corev1.PodSpec, patches []patchOperation) []patchOperation {
+	if rs == nil {
+		return patches
+	}
+
+	for i, patch := range patches {
+		m := resourcesPatchPath.FindStringSubmatch(patch.Path)
+		if m == nil {
+			continue
+		}
+		containers := spec.Containers
+		if m[1] == "initContainers" {
+			containers = spec.InitContainers
+		}
+		index, _ := strconv.Atoi(m[2])
+		if index >= len(containers) {
+			continue
+		}
+		container := containers[index].Name
+
+		usage, ok := rs.source.Usage(namespace, kind, name, container)
+		if !ok {
+			continue
+		}
+		resources, ok := patch.Value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		patches[i].Value = rs.resize(resources, usage)
+		log.Printf("Right-sized %s %s/%s container %s from %s: %v",
+			kind, namespace, name, container, rs.source.Name(), patches[i].Value.(map[string]interface{})["requests"])
+		rightSizedContainersTotal.WithLabelValues(rs.source.Name()).Inc()
+	}
+	return patches
+}
+
+// resize returns a copy of a resources patch value with the requests of the
+// resources in usage replaced. Limits set for those resources are raised to
+// the new requests where needed, so requests never exceed limits.
+func (rs *rightSizer) resize(resources map[string]interface{}, usage corev1.ResourceList) map[string]interface{} {
+	requests := copyResourceMap(resources["requests"])
+	limits := copyResourceMap(resources["limits"])
+
+	for name, used := range usage {
+		request, ok := rs.request(name, used)
+		if !ok {
+			continue
+		}
+		requests[string(name)] = request.String()
+		if limit, ok := limits[string(name)].(string); ok {
+			if q, err := resource.ParseQuantity(limit); err == nil && q.Cmp(request) < 0 {
+				limits[string(name)] = request.String()
+			}
+		}
+	}
+
+	resized := map[string]interface{}{}
+	for key, value := range resources {
+		resized[key] = value
+	}
+	resized["requests"] = requests
+	if len(limits) > 0 {
+		resized["limits"] = limits
+	}
+	return resized
+}
+
+// request computes the request of a resource from its usage: the usage plus
+// headroom, rounded up
+func (rs *rightSizer) request(name corev1.ResourceName, used resource.Quantity) (resource.Quantity, bool) {
+	scaled := used.AsApproximateFloat64() * float64(100+rs.headroom) / 100
+
+	var request resource.Quantity
+	switch name {
+	case corev1.ResourceCPU:
+		request = *resource.NewMilliQuantity(int64(math.Ceil(scaled*1000)), resource.DecimalSI)
+	case corev1.ResourceMemory, corev1.ResourceEphemeralStorage:
+		request = mebibytes(scaled)
+	default:
+		return resource.Quantity{}, false
+	}
+	return request, true
+}
+
+// mebibytes rounds a number of bytes up to whole Mi
+func mebibytes(bytes float64) resource.Quantity {
+	return resource.MustParse(fmt.Sprintf("%dMi", int64(math.Ceil(bytes/(1<<20)))))
+}
+
+func copyResourceMap(value interface{}) map[string]interface{} {
+	copied := map[string]interface{}{}
+	if m, ok := value.(map[string]interface{}); ok {
+		for key, v := range m {
+			copied[key] = v
+		}
+	}
+	return copied
+}
+
+// usageKey identifies a container of a workload in usage sources
+func usageKey(namespace, kind, name, container string) string {
+	return strings.Join([]string{namespace, kind, name, container}, "/")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// stubUsageSource serves fixed usage, keyed like the real sources
+type stubUsageSource map[string]corev1.ResourceList
+
+func (s stubUsageSource) Name() string { return "stub" }
+
+func (s stubUsageSource) Usage(namespace, kind, name, container string) (corev1.ResourceList, bool) {
+	usage, ok := s[usageKey(namespace, kind, name, container)]
+	return usage, ok
+}
+
+func (s stubUsageSource) Run(ctx context.Context) {}
+
+func TestRightSizer_Apply(t *testing.T) {
+	quietLogs(t)
+	source := stubUsageSource{
+		usageKey("clusters-a", "Deployment", "kube-apiserver", "kube-apiserver"): {
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("1000Mi"),
+		},
+		usageKey("clusters-a", "Deployment", "kube-apiserver", "init-bootstrap"): {
+			corev1.ResourceCPU: resource.MustParse("10m"),
+		},
+	}
+	spec := &corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init-bootstrap"}},
+		Containers:     []corev1.Container{{Name: "kube-apiserver"}, {Name: "konnectivity-agent"}},
+	}
+	// The webhook shares one value between the resource patches
+	static := map[string]interface{}{
+		"requests": map[string]interface{}{"cpu": "50m", "memory": "100Mi", "ephemeral-storage": "1Gi"},
+	}
+	patches := func() []patchOperation {
+		return []patchOperation{
+			{Op: "add", Path: "/spec/template/spec/initContainers/0/securityContext", Value: map[string]interface{}{"runAsNonRoot": true}},
+			{Op: "replace", Path: "/spec/template/spec/initContainers/0/resources", Value: static},
+			{Op: "replace", Path: "/spec/template/spec/containers/0/resources", Value: static},
+			{Op: "replace", Path: "/spec/template/spec/containers/1/resources", Value: static},
+			{Op: "replace", Path: "/spec/template/spec/containers/2/resources", Value: static},
+			{Op: "replace", Path: "/spec/template/spec/containers/0/resources/requests", Value: static},
+		}
+	}
+	requests := func(patch patchOperation) map[string]interface{} {
+		return patch.Value.(map[string]interface{})["requests"].(map[string]interface{})
+	}
+
+	rs := &rightSizer{source: source, headroom: 20}
+	got := rs.Apply("clusters-a", "Deployment", "kube-apiserver", spec, patches())
+	for i, want := range []map[string]interface{}{
+		nil,
+		// Init containers are matched by the index in initContainers
+		{"cpu": "12m", "memory": "100Mi", "ephemeral-storage": "1Gi"},
+		{"cpu": "600m", "memory": "1200Mi", "ephemeral-storage": "1Gi"},
+		// No usage data
+		static["requests"].(map[string]interface{}),
+		// Out of range index
+		static["requests"].(map[string]interface{}),
+		// Not a resources patch
+		static["requests"].(map[string]interface{}),
+	} {
+		if want == nil {
+			continue
+		}
+		if r := requests(got[i]); !reflect.DeepEqual(r, want) {
+			t.Errorf("patch %d (%s) requests = %v, want %v", i, got[i].Path, r, want)
+		}
+	}
+	if r := static["requests"].(map[string]interface{}); r["cpu"] != "50m" || r["memory"] != "100Mi" {
+		t.Errorf("shared static resources modified: %v", r)
+	}
+
+	// Other workloads keep the static requests, and a nil right-sizer is a
+	// no-op
+	for _, tc := range []struct {
+		name string
+		rs   *rightSizer
+		kind string
+	}{
+		{"other kind", rs, "StatefulSet"},
+		{"disabled", nil, "Deployment"},
+	} {
+		got := tc.rs.Apply("clusters-a", tc.kind, "kube-apiserver", spec, patches())
+		if !reflect.DeepEqual(got, patches()) {
+			t.Errorf("%s: Apply() changed the patches: %+v", tc.name, got)
+		}
+	}
+}
+
+func TestRightSizer_Resize(t *testing.T) {
+	rs := &rightSizer{headroom: 0}
+	for _, tc := range []struct {
+		name      string
+		resources map[string]interface{}
+		usage     corev1.ResourceList
+		want      map[string]interface{}
+	}{
+		{
+			name: "limits raised to the requests",
+			resources: map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "50m", "memory": "100Mi"},
+				"limits":   map[string]interface{}{"cpu": "200m", "memory": "512Mi"},
+			},
+			usage: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("300m"), corev1.ResourceMemory: resource.MustParse("256Mi")},
+			want: map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "300m", "memory": "256Mi"},
+				"limits":   map[string]interface{}{"cpu": "300m", "memory": "512Mi"},
+			},
+		},
+		{
+			name:      "no limits",
+			resources: map[string]interface{}{"requests": map[string]interface{}{"memory": "100Mi"}},
+			usage:     corev1.ResourceList{corev1.ResourceEphemeralStorage: resource.MustParse("2Gi")},
+			want:      map[string]interface{}{"requests": map[string]interface{}{"memory": "100Mi", "ephemeral-storage": "2Gi"}},
+		},
+		{
+			name: "unsupported resources and other keys kept",
+			resources: map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "50m"},
+				"claims":   []interface{}{map[string]interface{}{"name": "gpu"}},
+			},
+			usage: corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")},
+			want: map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "50m"},
+				"claims":   []interface{}{map[string]interface{}{"name": "gpu"}},
+			},
+		},
+		{
+			name:      "no requests",
+			resources: map[string]interface{}{},
+			usage:     corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			want:      map[string]interface{}{"requests": map[string]interface{}{"cpu": "1"}},
+		},
+	} {
+		if got := rs.resize(tc.resources, tc.usage); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: resize() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRightSizer_Request(t *testing.T) {
+	for _, tc := range []struct {
+		name     corev1.ResourceName
+		used     string
+		headroom int
+		want     string
+		ok       bool
+	}{
+		{corev1.ResourceCPU, "100m", 20, "120m", true},
+		// CPU is rounded up to whole millicores
+		{corev1.ResourceCPU, "1001u", 0, "2m", true},
+		{corev1.ResourceCPU, "2", 50, "3", true},
+		// Memory and ephemeral storage are rounded up to whole Mi
+		{corev1.ResourceMemory, "100Mi", 20, "120Mi", true},
+		{corev1.ResourceMemory, "1000000", 0, "1Mi", true},
+		{corev1.ResourceMemory, "1048577", 0, "2Mi", true},
+		{corev1.ResourceEphemeralStorage, "1Gi", 0, "1Gi", true},
+		{"nvidia.com/gpu", "1", 20, "", false},
+	} {
+		rs := &rightSizer{headroom: tc.headroom}
+		got, ok := rs.request(tc.name, resource.MustParse(tc.used))
+		if ok != tc.ok || (ok && got.String() != tc.want) {
+			t.Errorf("request(%s, %s) with %d%% headroom = %s, %t, want %s, %t", tc.name, tc.used, tc.headroom, got.String(), ok, tc.want, tc.ok)
+		}
+	}
+}
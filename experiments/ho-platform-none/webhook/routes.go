@@ -0,0 +1,545 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+var (
+	routeResource     = schema.GroupVersionResource{Group: "route.openshift.io", Version: "v1", Resource: "routes"}
+	httpRouteResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+	serviceResource   = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+)
+
+const (
+	defaultRouteResync = 5 * time.Minute
+
+	// routeRetry is how soon a Route admitted on creation is looked up again
+	// when it is not stored yet
+	routeRetry = 10 * time.Second
+
+	// routeLabel is set on the objects a Route was translated into, to the
+	// name of the Route
+	routeLabel = "hypershift-autopilot-webhook/route"
+
+	// passthroughServiceSuffix names the LoadBalancer Service created for a
+	// passthrough Route
+	passthroughServiceSuffix = "-passthrough"
+
+	// appProtocolsAnnotation tells the GKE Gateway controller which Service
+	// ports speak HTTPS, so re-encrypt Routes keep TLS to the backend
+	appProtocolsAnnotation = "cloud.google.com/app-protocols"
+)
+
+// openshiftRoute holds the fields of a route.openshift.io/v1 Route the webhook
+// translates. The OpenShift API is not vendored, as GKE does not serve it.
+type openshiftRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Host string `json:"host,omitempty"`
+		Path string `json:"path,omitempty"`
+		To   struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"to"`
+		Port *struct {
+			TargetPort intstr.IntOrString `json:"targetPort"`
+		} `json:"port,omitempty"`
+		TLS *struct {
+			Termination string `json:"termination"`
+		} `json:"tls,omitempty"`
+	} `json:"spec"`
+}
+
+// termination returns the TLS termination of the Route, "" for plain HTTP
+func (r *openshiftRoute) termination() string {
+	if r.Spec.TLS == nil {
+		return ""
+	}
+	return r.Spec.TLS.Termination
+}
+
+// routeTranslator serves the OpenShift Routes HyperShift creates for a
+// platform-none HostedCluster (ignition-server, oauth, ...) on GKE, which has
+// no router: HTTP, edge and re-encrypt Routes become Gateway API HTTPRoutes
+// attached to a GKE Gateway, passthrough Routes become LoadBalancer Services
+// as the Gateway cannot pass TLS through to the backend.
+//
+// Like hostPortServices, admissions only record the Route. Run translates the
+// stored Route, whose UID the objects it is translated into are owned by, so
+// they are garbage collected with the Route even when its deletion is not
+// admitted by the webhook.
+type routeTranslator struct {
+	client           kubernetes.Interface
+	dynamic          dynamic.Interface
+	recorder         record.EventRecorder
+	gatewayName      string
+	gatewayNamespace string
+	gatewaySection   string
+	resync           time.Duration
+
+	mu      sync.Mutex
+	targets map[types.NamespacedName]routeTarget
+	changed chan struct{}
+}
+
+// routeTarget is a Route recorded by an admission
+type routeTarget struct {
+	admitted time.Time
+	// reported is the resourceVersion of the Route the last Event was
+	// recorded for, so resyncs do not repeat it
+	reported string
+}
+
+func newRouteTranslator(client kubernetes.Interface, dynamicClient dynamic.Interface, gatewayNamespace, gatewayName, gatewaySection string, resync time.Duration) *routeTranslator {
+	return &routeTranslator{
+		client:           client,
+		dynamic:          dynamicClient,
+		gatewayName:      gatewayName,
+		gatewayNamespace: gatewayNamespace,
+		gatewaySection:   gatewaySection,
+		resync:           resync,
+		targets:          map[types.NamespacedName]routeTarget{},
+		changed:          make(chan struct{}, 1),
+	}
+}
+
+// newRouteTranslatorFromEnv builds the translator from ROUTE_GATEWAY_*
+// environment variables. It returns nil when ROUTE_GATEWAY_NAME is unset, in
+// which case Routes are admitted unchanged.
+func newRouteTranslatorFromEnv() (*routeTranslator, error) {
+	gatewayName := os.Getenv("ROUTE_GATEWAY_NAME")
+	if gatewayName == "" {
+		return nil, nil
+	}
+	gatewayNamespace := os.Getenv("ROUTE_GATEWAY_NAMESPACE")
+	if gatewayNamespace == "" {
+		return nil, fmt.Errorf("ROUTE_GATEWAY_NAMESPACE must be set with ROUTE_GATEWAY_NAME")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("route translation needs to run inside a cluster: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create client: %v", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create dynamic client: %v", err)
+	}
+
+	return newRouteTranslator(clientset, dynamicClient, gatewayNamespace, gatewayName,
+		os.Getenv("ROUTE_GATEWAY_SECTION"), defaultRouteResync), nil
+}
+
+// track records an admitted Route for Run to translate
+func (t *routeTranslator) track(namespace, name string) {
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	t.mu.Lock()
+	target := t.targets[key]
+	target.admitted = time.Now()
+	t.targets[key] = target
+	t.mu.Unlock()
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+// forget stops translating a deleted Route. The objects it was translated
+// into are owned by it and garbage collected.
+func (t *routeTranslator) forget(namespace, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.targets, types.NamespacedName{Namespace: namespace, Name: name})
+}
+
+// Run translates the recorded Routes after every admission and every resync
+// interval, so deleted or edited objects come back, until ctx is done
+func (t *routeTranslator) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.resync)
+	defer ticker.Stop()
+
+	for {
+		var retry <-chan time.Time
+		if pending := t.ensure(ctx); pending {
+			retry = time.After(routeRetry)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-t.changed:
+		case <-retry:
+		}
+	}
+}
+
+// ensure translates every recorded Route. It reports whether a Route
+// admitted recently was not found, to be retried shortly.
+func (t *routeTranslator) ensure(ctx context.Context) (pending bool) {
+	t.mu.Lock()
+	targets := maps.Clone(t.targets)
+	t.mu.Unlock()
+
+	for key, target := range targets {
+		route, err := t.route(ctx, key)
+		if apierrors.IsNotFound(err) {
+			// A Route admitted on creation is stored after the admission
+			if time.Since(target.admitted) < t.resync {
+				pending = true
+				continue
+			}
+			t.mu.Lock()
+			if current, ok := t.targets[key]; ok && current.admitted.Equal(target.admitted) {
+				delete(t.targets, key)
+			}
+			t.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			log.Printf("Could not read Route %s: %v", key, err)
+			continue
+		}
+
+		translated, err := t.Translate(ctx, route)
+		if err != nil {
+			log.Printf("Could not translate Route %s: %v", key, err)
+		} else {
+			log.Printf("Translated Route %s into %s", key, translated)
+		}
+		if target.reported == route.ResourceVersion {
+			continue
+		}
+		t.mu.Lock()
+		if current, ok := t.targets[key]; ok {
+			current.reported = route.ResourceVersion
+			t.targets[key] = current
+		}
+		t.mu.Unlock()
+		if t.recorder == nil {
+			continue
+		}
+		if err != nil {
+			t.recorder.Eventf(route.objectReference(), corev1.EventTypeWarning, "RouteTranslationFailed",
+				"Could not serve Route on GKE: %v", err)
+		} else {
+			t.recorder.Eventf(route.objectReference(), corev1.EventTypeNormal, "RouteTranslated",
+				"Served on GKE by %s", translated)
+		}
+	}
+	return pending
+}
+
+// route reads a stored Route
+func (t *routeTranslator) route(ctx context.Context, key types.NamespacedName) (*openshiftRoute, error) {
+	obj, err := t.dynamic.Resource(routeResource).Namespace(key.Namespace).Get(ctx, key.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var route openshiftRoute
+	if err := json.Unmarshal(data, &route); err != nil {
+		return nil, fmt.Errorf("invalid Route: %v", err)
+	}
+	return &route, nil
+}
+
+// objectReference returns the reference of the Route for Events, with its
+// UID so they are listed by kubectl describe
+func (r *openshiftRoute) objectReference() *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion:      routeResource.GroupVersion().String(),
+		Kind:            "Route",
+		Namespace:       r.Namespace,
+		Name:            r.Name,
+		UID:             r.UID,
+		ResourceVersion: r.ResourceVersion,
+	}
+}
+
+// Translate creates or updates the objects serving the Route and removes the
+// ones left from a previous termination. It returns a description of what
+// the Route was translated into.
+func (t *routeTranslator) Translate(ctx context.Context, route *openshiftRoute) (string, error) {
+	if route.Spec.To.Kind != "" && route.Spec.To.Kind != "Service" {
+		return "", fmt.Errorf("unsupported backend kind %q", route.Spec.To.Kind)
+	}
+	service, err := t.client.CoreV1().Services(route.Namespace).Get(ctx, route.Spec.To.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not read backend Service %s: %v", route.Spec.To.Name, err)
+	}
+	port, err := routeServicePort(route, service)
+	if err != nil {
+		return "", err
+	}
+
+	if route.termination() == "passthrough" {
+		if err := t.applyPassthroughService(ctx, route, service, port); err != nil {
+			return "", err
+		}
+		if err := t.delete(ctx, httpRouteResource, route.Namespace, route.Name); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("LoadBalancer Service %s%s", route.Name, passthroughServiceSuffix), nil
+	}
+
+	if route.termination() == "reencrypt" {
+		if err := t.annotateHTTPSBackend(ctx, service, port); err != nil {
+			return "", err
+		}
+	}
+	if err := t.applyHTTPRoute(ctx, route, port); err != nil {
+		return "", err
+	}
+	if err := t.delete(ctx, serviceResource, route.Namespace, route.Name+passthroughServiceSuffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("HTTPRoute %s on Gateway %s/%s", route.Name, t.gatewayNamespace, t.gatewayName), nil
+}
+
+// routeServicePort finds the port of the backend Service the Route targets:
+// spec.port.targetPort names a Service port or target port by name or
+// number, and the first port is used when it is not set
+func routeServicePort(route *openshiftRoute, service *corev1.Service) (corev1.ServicePort, error) {
+	if len(service.Spec.Ports) == 0 {
+		return corev1.ServicePort{}, fmt.Errorf("backend Service %s has no ports", service.Name)
+	}
+	if route.Spec.Port == nil {
+		return service.Spec.Ports[0], nil
+	}
+
+	target := route.Spec.Port.TargetPort
+	for _, port := range service.Spec.Ports {
+		if target.Type == intstr.String && (port.Name == target.StrVal || port.TargetPort.StrVal == target.StrVal) {
+			return port, nil
+		}
+		if target.Type == intstr.Int && (port.Port == target.IntVal || port.TargetPort.IntValue() == int(target.IntVal)) {
+			return port, nil
+		}
+	}
+	return corev1.ServicePort{}, fmt.Errorf("backend Service %s has no port %s", service.Name, target.String())
+}
+
+// applyHTTPRoute creates or updates the HTTPRoute of the Route
+func (t *routeTranslator) applyHTTPRoute(ctx context.Context, route *openshiftRoute, port corev1.ServicePort) error {
+	parentRef := map[string]interface{}{
+		"name":      t.gatewayName,
+		"namespace": t.gatewayNamespace,
+	}
+	if t.gatewaySection != "" {
+		parentRef["sectionName"] = t.gatewaySection
+	}
+	path := route.Spec.Path
+	if path == "" {
+		path = "/"
+	}
+
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{
+						"path": map[string]interface{}{"type": "PathPrefix", "value": path},
+					},
+				},
+				"backendRefs": []interface{}{
+					map[string]interface{}{"name": route.Spec.To.Name, "port": int64(port.Port)},
+				},
+			},
+		},
+	}
+	if route.Spec.Host != "" {
+		spec["hostnames"] = []interface{}{route.Spec.Host}
+	}
+
+	obj := translatedObject(httpRouteResource.GroupVersion().String(), "HTTPRoute", route)
+	obj.Object["spec"] = spec
+	return t.apply(ctx, httpRouteResource, obj)
+}
+
+// applyPassthroughService creates or updates a LoadBalancer Service selecting
+// the same pods as the backend Service, so TLS reaches them unterminated
+func (t *routeTranslator) applyPassthroughService(ctx context.Context, route *openshiftRoute, service *corev1.Service, port corev1.ServicePort) error {
+	selector := map[string]interface{}{}
+	for key, value := range service.Spec.Selector {
+		selector[key] = value
+	}
+	var targetPort interface{} = int64(port.Port)
+	switch {
+	case port.TargetPort.Type == intstr.String:
+		targetPort = port.TargetPort.StrVal
+	case port.TargetPort.IntVal != 0:
+		targetPort = int64(port.TargetPort.IntVal)
+	}
+
+	obj := translatedObject("v1", "Service", route)
+	obj.SetName(route.Name + passthroughServiceSuffix)
+	annotations := map[string]string{
+		// Regional backend service based external passthrough load balancer
+		"cloud.google.com/l4-rbs": "enabled",
+	}
+	if route.Spec.Host != "" {
+		annotations["external-dns.alpha.kubernetes.io/hostname"] = route.Spec.Host
+	}
+	obj.SetAnnotations(annotations)
+	obj.Object["spec"] = map[string]interface{}{
+		"type":     "LoadBalancer",
+		"selector": selector,
+		"ports": []interface{}{
+			map[string]interface{}{
+				"name":       "https",
+				"protocol":   "TCP",
+				"port":       int64(port.Port),
+				"targetPort": targetPort,
+			},
+		},
+	}
+	return t.apply(ctx, serviceResource, obj)
+}
+
+// annotateHTTPSBackend marks the port of a re-encrypt Route's backend Service
+// as HTTPS for the GKE Gateway controller
+func (t *routeTranslator) annotateHTTPSBackend(ctx context.Context, service *corev1.Service, port corev1.ServicePort) error {
+	if port.Name == "" {
+		return fmt.Errorf("backend Service %s port %d must be named for re-encryption", service.Name, port.Port)
+	}
+
+	protocols := map[string]string{}
+	if existing := service.Annotations[appProtocolsAnnotation]; existing != "" {
+		if err := json.Unmarshal([]byte(existing), &protocols); err != nil {
+			return fmt.Errorf("invalid %s annotation on Service %s: %v", appProtocolsAnnotation, service.Name, err)
+		}
+	}
+	if protocols[port.Name] == "HTTPS" {
+		return nil
+	}
+	protocols[port.Name] = "HTTPS"
+
+	value, err := json.Marshal(protocols)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{appProtocolsAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = t.client.CoreV1().Services(service.Namespace).Patch(ctx, service.Name, "application/merge-patch+json", patch, metav1.PatchOptions{FieldManager: eventComponent})
+	if err != nil {
+		return fmt.Errorf("could not annotate Service %s: %v", service.Name, err)
+	}
+	return nil
+}
+
+// translatedObject returns an object named and labelled after the Route it
+// is translated from, and owned by it
+func translatedObject(apiVersion, kind string, route *openshiftRoute) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName(route.Name)
+	obj.SetNamespace(route.Namespace)
+	obj.SetLabels(map[string]string{
+		routeLabel:     route.Name,
+		managedByLabel: eventComponent,
+	})
+	controller := true
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: routeResource.GroupVersion().String(),
+		Kind:       "Route",
+		Name:       route.Name,
+		UID:        route.UID,
+		Controller: &controller,
+	}})
+	return obj
+}
+
+// apply creates or updates an object with server-side apply
+func (t *routeTranslator) apply(ctx context.Context, resource schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	_, err := t.dynamic.Resource(resource).Namespace(obj.GetNamespace()).Apply(ctx, obj.GetName(), obj,
+		metav1.ApplyOptions{FieldManager: eventComponent, Force: true})
+	if err != nil {
+		return fmt.Errorf("could not apply %s %s: %v", obj.GetKind(), obj.GetName(), err)
+	}
+	return nil
+}
+
+// delete removes an object created for a Route, if there is one
+func (t *routeTranslator) delete(ctx context.Context, resource schema.GroupVersionResource, namespace, name string) error {
+	client := t.dynamic.Resource(resource).Namespace(namespace)
+	obj, err := client.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read %s %s: %v", resource.Resource, name, err)
+	}
+	// Leave objects alone that were not created by the webhook
+	if _, ok := obj.GetLabels()[routeLabel]; !ok {
+		return nil
+	}
+	if err := client.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete %s %s: %v", resource.Resource, name, err)
+	}
+	return nil
+}
+
+// mutateRoute records a Route for the translator, which serves it on GKE in
+// the background. The Route itself is admitted unchanged, so HyperShift does
+// not see a diff to reconcile.
+func (ws *WebhookServer) mutateRoute(req *admissionv1.AdmissionRequest) {
+	if ws.routes == nil {
+		log.Printf("Route translation disabled, admitting Route %s unchanged", req.Name)
+		return
+	}
+	if req.DryRun != nil && *req.DryRun {
+		log.Printf("Dry run, not translating Route %s", req.Name)
+		return
+	}
+	if req.Operation == admissionv1.Delete {
+		ws.routes.forget(req.Namespace, req.Name)
+		return
+	}
+
+	var route openshiftRoute
+	if err := json.Unmarshal(req.Object.Raw, &route); err != nil {
+		log.Printf("Could not unmarshal route: %v", err)
+		return
+	}
+	if route.Name == "" {
+		log.Printf("Route in %s has no name yet, not translating it", req.Namespace)
+		return
+	}
+	ws.routes.track(req.Namespace, route.Name)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// testRoute returns a stored Route of the ignition-server Service with the
+// given TLS termination
+func testRoute(termination string) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"host": "ignition.example.com",
+		"to":   map[string]interface{}{"kind": "Service", "name": "ignition-server"},
+	}
+	if termination != "" {
+		spec["tls"] = map[string]interface{}{"termination": termination}
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "route.openshift.io/v1",
+		"kind":       "Route",
+		"metadata": map[string]interface{}{
+			"name": "ignition-server", "namespace": "clusters-test", "uid": "route-uid", "resourceVersion": "1",
+		},
+		"spec": spec,
+	}}
+}
+
+// translatedLeftover is an object a previous translation of the Route left,
+// labelled by the webhook
+func translatedLeftover(apiVersion, kind, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName(name)
+	obj.SetNamespace("clusters-test")
+	obj.SetLabels(map[string]string{routeLabel: "ignition-server"})
+	return obj
+}
+
+// newTestRouteTranslator returns a translator onto the hcp/hcp-gateway
+// Gateway. The dynamic client serves the server-side applies the webhook
+// does, which the fake tracker only supports for existing objects.
+func newTestRouteTranslator(objs ...runtime.Object) (*routeTranslator, *fake.Clientset, *dynamicfake.FakeDynamicClient) {
+	backend := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "ignition-server", Namespace: "clusters-test"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "ignition-server"},
+			Ports:    []corev1.ServicePort{{Name: "https", Port: 443, TargetPort: intstr.FromInt32(9090)}},
+		},
+	}
+	client := fake.NewClientset(backend)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			routeResource:     "RouteList",
+			httpRouteResource: "HTTPRouteList",
+			serviceResource:   "ServiceList",
+		}, objs...)
+	dynamicClient.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		tracker := dynamicClient.Tracker()
+		_, err := tracker.Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+		switch {
+		case apierrors.IsNotFound(err):
+			err = tracker.Create(patch.GetResource(), obj, patch.GetNamespace())
+		case err == nil:
+			err = tracker.Update(patch.GetResource(), obj, patch.GetNamespace())
+		}
+		return true, obj, err
+	})
+
+	t := newRouteTranslator(client, dynamicClient, "hcp", "hcp-gateway", "https", time.Minute)
+	return t, client, dynamicClient
+}
+
+func TestRouteTranslator_Ensure(t *testing.T) {
+	quietLogs(t)
+	for _, tc := range []struct {
+		name        string
+		termination string
+		// httpRoute and passthrough are whether the objects exist after
+		// the translation
+		httpRoute   bool
+		passthrough bool
+		protocols   string
+	}{
+		{name: "edge", termination: "edge", httpRoute: true},
+		{name: "reencrypt", termination: "reencrypt", httpRoute: true, protocols: `{"https":"HTTPS"}`},
+		{name: "passthrough", termination: "passthrough", passthrough: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Both objects are left from a previous translation, one of them
+			// goes away with the new termination
+			rt, client, dynamicClient := newTestRouteTranslator(testRoute(tc.termination),
+				translatedLeftover("gateway.networking.k8s.io/v1", "HTTPRoute", "ignition-server"),
+				translatedLeftover("v1", "Service", "ignition-server-passthrough"))
+			recorder := record.NewFakeRecorder(10)
+			rt.recorder = recorder
+			rt.track("clusters-test", "ignition-server")
+
+			ctx := context.Background()
+			if pending := rt.ensure(ctx); pending {
+				t.Error("ensure() = true, want the stored Route translated")
+			}
+
+			httpRoute, err := dynamicClient.Resource(httpRouteResource).Namespace("clusters-test").Get(ctx, "ignition-server", metav1.GetOptions{})
+			if tc.httpRoute != (err == nil) {
+				t.Fatalf("HTTPRoute exists = %t (%v), want %t", err == nil, err, tc.httpRoute)
+			}
+			if tc.httpRoute {
+				assertOwnedByRoute(t, httpRoute)
+				spec := httpRoute.Object["spec"].(map[string]interface{})
+				want := map[string]interface{}{
+					"parentRefs": []interface{}{map[string]interface{}{"name": "hcp-gateway", "namespace": "hcp", "sectionName": "https"}},
+					"hostnames":  []interface{}{"ignition.example.com"},
+					"rules": []interface{}{map[string]interface{}{
+						"matches":     []interface{}{map[string]interface{}{"path": map[string]interface{}{"type": "PathPrefix", "value": "/"}}},
+						"backendRefs": []interface{}{map[string]interface{}{"name": "ignition-server", "port": int64(443)}},
+					}},
+				}
+				if !reflect.DeepEqual(spec, want) {
+					t.Errorf("HTTPRoute spec = %v, want %v", spec, want)
+				}
+			}
+
+			service, err := dynamicClient.Resource(serviceResource).Namespace("clusters-test").Get(ctx, "ignition-server-passthrough", metav1.GetOptions{})
+			if tc.passthrough != (err == nil) {
+				t.Fatalf("passthrough Service exists = %t (%v), want %t", err == nil, err, tc.passthrough)
+			}
+			if tc.passthrough {
+				assertOwnedByRoute(t, service)
+				if service.GetAnnotations()["external-dns.alpha.kubernetes.io/hostname"] != "ignition.example.com" {
+					t.Errorf("passthrough Service annotations = %v", service.GetAnnotations())
+				}
+				serviceType, _, _ := unstructured.NestedString(service.Object, "spec", "type")
+				selector, _, _ := unstructured.NestedStringMap(service.Object, "spec", "selector")
+				ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+				wantPorts := []interface{}{map[string]interface{}{"name": "https", "protocol": "TCP", "port": int64(443), "targetPort": int64(9090)}}
+				if serviceType != "LoadBalancer" || selector["app"] != "ignition-server" || !reflect.DeepEqual(ports, wantPorts) {
+					t.Errorf("passthrough Service spec = %v", service.Object["spec"])
+				}
+			}
+
+			backend, err := client.CoreV1().Services("clusters-test").Get(ctx, "ignition-server", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := backend.Annotations[appProtocolsAnnotation]; got != tc.protocols {
+				t.Errorf("backend %s annotation = %q, want %q", appProtocolsAnnotation, got, tc.protocols)
+			}
+
+			// The Event is recorded once per version of the Route
+			rt.ensure(ctx)
+			if len(recorder.Events) != 1 {
+				t.Fatalf("%d Events recorded, want 1", len(recorder.Events))
+			}
+			if event := <-recorder.Events; !strings.HasPrefix(event, "Normal RouteTranslated Served on GKE by") {
+				t.Errorf("Event = %q", event)
+			}
+		})
+	}
+}
+
+func assertOwnedByRoute(t *testing.T, obj *unstructured.Unstructured) {
+	t.Helper()
+	owners := obj.GetOwnerReferences()
+	if len(owners) != 1 || owners[0].Kind != "Route" || owners[0].APIVersion != "route.openshift.io/v1" ||
+		owners[0].Name != "ignition-server" || owners[0].UID != "route-uid" || owners[0].Controller == nil || !*owners[0].Controller {
+		t.Errorf("%s owners = %+v, want the Route", obj.GetKind(), owners)
+	}
+	if obj.GetLabels()[routeLabel] != "ignition-server" || obj.GetLabels()[managedByLabel] != eventComponent {
+		t.Errorf("%s labels = %v", obj.GetKind(), obj.GetLabels())
+	}
+}
+
+func TestRouteTranslator_EnsureFailure(t *testing.T) {
+	quietLogs(t)
+	route := testRoute("edge")
+	if err := unstructured.SetNestedField(route.Object, "missing", "spec", "to", "name"); err != nil {
+		t.Fatal(err)
+	}
+	rt, _, _ := newTestRouteTranslator(route)
+	recorder := record.NewFakeRecorder(10)
+	rt.recorder = recorder
+	rt.track("clusters-test", "ignition-server")
+	rt.ensure(context.Background())
+
+	if len(recorder.Events) != 1 {
+		t.Fatalf("%d Events recorded, want 1", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning RouteTranslationFailed") || !strings.Contains(event, "backend Service missing") {
+		t.Errorf("Event = %q", event)
+	}
+}
+
+func TestRouteTranslator_EnsureNotFound(t *testing.T) {
+	rt, _, _ := newTestRouteTranslator()
+	key := types.NamespacedName{Namespace: "clusters-test", Name: "oauth"}
+	rt.track(key.Namespace, key.Name)
+
+	// A Route admitted on creation is not stored yet
+	ctx := context.Background()
+	if pending := rt.ensure(ctx); !pending {
+		t.Error("ensure() = false, want the Route retried")
+	}
+	if _, ok := rt.targets[key]; !ok {
+		t.Fatal("Route dropped before the resync interval")
+	}
+
+	// A Route gone for longer was deleted without the webhook seeing it
+	rt.targets[key] = routeTarget{admitted: time.Now().Add(-2 * rt.resync)}
+	if pending := rt.ensure(ctx); pending {
+		t.Error("ensure() = true for a deleted Route")
+	}
+	if _, ok := rt.targets[key]; ok {
+		t.Error("deleted Route still tracked")
+	}
+}
+
+func TestMutateRoute(t *testing.T) {
+	quietLogs(t)
+	raw, err := testRoute("edge").MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dryRun := true
+	key := types.NamespacedName{Namespace: "clusters-test", Name: "ignition-server"}
+
+	for _, tc := range []struct {
+		name      string
+		operation admissionv1.Operation
+		dryRun    *bool
+		tracked   bool
+	}{
+		{name: "create", operation: admissionv1.Create, tracked: true},
+		{name: "update", operation: admissionv1.Update, tracked: true},
+		{name: "dry run", operation: admissionv1.Create, dryRun: &dryRun},
+		// The translated objects are garbage collected with the Route
+		{name: "delete", operation: admissionv1.Delete},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rt, client, dynamicClient := newTestRouteTranslator()
+			if tc.operation == admissionv1.Delete {
+				rt.targets[key] = routeTarget{admitted: time.Now()}
+			}
+			ws := &WebhookServer{routes: rt}
+			req := &admissionv1.AdmissionRequest{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Operation: tc.operation,
+				DryRun:    tc.dryRun,
+				Object:    runtime.RawExtension{Raw: raw},
+			}
+			if tc.operation == admissionv1.Delete {
+				req.Object = runtime.RawExtension{}
+				req.OldObject = runtime.RawExtension{Raw: raw}
+			}
+
+			ws.mutateRoute(req)
+			if _, ok := rt.targets[key]; ok != tc.tracked {
+				t.Errorf("Route tracked = %t, want %t", ok, tc.tracked)
+			}
+			// Admissions never wait on the API server
+			if n := len(client.Actions()) + len(dynamicClient.Actions()); n != 0 {
+				t.Errorf("%d API calls during the admission, want none", n)
+			}
+		})
+	}
+
+	// Without a Gateway, Routes are admitted unchanged
+	(&WebhookServer{}).mutateRoute(&admissionv1.AdmissionRequest{Operation: admissionv1.Create})
+}
@@ -95,7 +95,8 @@ echo "Deploying webhook..."
 # Update webhook deployment with CA bundle
 sed "s/caBundle: \"\"/caBundle: $CA_BUNDLE/" webhook-deployment.yaml > webhook-deployment-configured.yaml
 
-# Apply the webhook deployment
+# Apply the AutopilotMutationProfile CRD and the webhook deployment
+kubectl apply -f autopilotmutationprofile-crd.yaml
 kubectl apply -f webhook-deployment-configured.yaml
 
 echo "Waiting for webhook deployment to be ready..."
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// zoneTopologyKey is the node label Autopilot sets to the zone of a node
+const zoneTopologyKey = "topology.kubernetes.io/zone"
+
+// defaultTopologySpread spreads the members of etcd and the kube-apiservers
+// over zones, keeping their anti-affinity
+const defaultTopologySpread = "etcd=augment,kube-apiserver=augment"
+
+// topologySpreadMode is how a component is spread over zones
+type topologySpreadMode string
+
+const (
+	// topologySpreadOff leaves the scheduling constraints of the component alone
+	topologySpreadOff topologySpreadMode = "off"
+	// topologySpreadAugment adds a zone spread constraint next to the
+	// anti-affinity of the component
+	topologySpreadAugment topologySpreadMode = "augment"
+	// topologySpreadReplace adds a zone spread constraint and drops the pod
+	// anti-affinity, which Autopilot charges a higher CPU minimum for
+	topologySpreadReplace topologySpreadMode = "replace"
+)
+
+// topologySpreadPolicy maps component names (Deployment or StatefulSet names)
+// to how they are spread. Autopilot provisions nodes itself, so HyperShift's
+// assumption that anti-affinity alone lands replicas in different zones does
+// not hold; a zone topology spread constraint makes the scheduler ask for
+// nodes in each zone.
+type topologySpreadPolicy struct {
+	modes             map[string]topologySpreadMode
+	whenUnsatisfiable corev1.UnsatisfiableConstraintAction
+}
+
+// newTopologySpreadPolicyFromEnv reads TOPOLOGY_SPREAD, a comma-separated list
+// of component=mode, and TOPOLOGY_SPREAD_WHEN_UNSATISFIABLE
+func newTopologySpreadPolicyFromEnv() (*topologySpreadPolicy, error) {
+	whenUnsatisfiable := corev1.UnsatisfiableConstraintAction(envString("TOPOLOGY_SPREAD_WHEN_UNSATISFIABLE", string(corev1.ScheduleAnyway)))
+	if whenUnsatisfiable != corev1.ScheduleAnyway && whenUnsatisfiable != corev1.DoNotSchedule {
+		return nil, fmt.Errorf("invalid TOPOLOGY_SPREAD_WHEN_UNSATISFIABLE %q: must be ScheduleAnyway or DoNotSchedule", whenUnsatisfiable)
+	}
+	value, ok := os.LookupEnv("TOPOLOGY_SPREAD")
+	if !ok {
+		value = defaultTopologySpread
+	}
+	return parseTopologySpreadPolicy(value, whenUnsatisfiable)
+}
+
+func parseTopologySpreadPolicy(value string, whenUnsatisfiable corev1.UnsatisfiableConstraintAction) (*topologySpreadPolicy, error) {
+	policy := &topologySpreadPolicy{
+		modes:             map[string]topologySpreadMode{},
+		whenUnsatisfiable: whenUnsatisfiable,
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		component, mode, found := strings.Cut(entry, "=")
+		if !found {
+			mode = string(topologySpreadAugment)
+		}
+		switch topologySpreadMode(mode) {
+		case topologySpreadOff, topologySpreadAugment, topologySpreadReplace:
+			policy.modes[strings.TrimSpace(component)] = topologySpreadMode(mode)
+		default:
+			return nil, fmt.Errorf("invalid TOPOLOGY_SPREAD mode %q for %s: must be off, augment or replace", mode, component)
+		}
+	}
+	return policy, nil
+}
+
+func (p *topologySpreadPolicy) String() string {
+	var entries []string
+	for component, mode := range p.modes {
+		entries = append(entries, fmt.Sprintf("%s=%s", component, mode))
+	}
+	sort.Strings(entries)
+	if len(entries) == 0 {
+		return "off"
+	}
+	return strings.Join(entries, ",") + " (" + string(p.whenUnsatisfiable) + ")"
+}
+
+// Patches returns the patches spreading a component over zones. selector is
+// the label selector of the workload, hasAntiAffinity whether its pod spec
+// has pod anti-affinity once the earlier patches are applied.
+func (p *topologySpreadPolicy) Patches(component string, spec *corev1.PodSpec, selector map[string]string, hasAntiAffinity bool) []patchOperation {
+	if p == nil || len(selector) == 0 {
+		return nil
+	}
+	mode := p.modes[component]
+	if mode != topologySpreadAugment && mode != topologySpreadReplace {
+		return nil
+	}
+
+	matchLabels := map[string]interface{}{}
+	for key, value := range selector {
+		matchLabels[key] = value
+	}
+
+	// Keep constraints on other topology keys, e.g. the hostname
+	var constraints []interface{}
+	for _, c := range spec.TopologySpreadConstraints {
+		if c.TopologyKey != zoneTopologyKey {
+			constraints = append(constraints, c)
+		}
+	}
+	constraints = append(constraints, map[string]interface{}{
+		"maxSkew":           1,
+		"topologyKey":       zoneTopologyKey,
+		"whenUnsatisfiable": string(p.whenUnsatisfiable),
+		"labelSelector": map[string]interface{}{
+			"matchLabels": matchLabels,
+		},
+	})
+
+	patches := []patchOperation{
+		{
+			Op:    "add",
+			Path:  "/spec/template/spec/topologySpreadConstraints",
+			Value: constraints,
+		},
+	}
+	if mode == topologySpreadReplace && hasAntiAffinity {
+		patches = append(patches, patchOperation{
+			Op:   "remove",
+			Path: "/spec/template/spec/affinity/podAntiAffinity",
+		})
+	}
+	return patches
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// etcdStatefulSet is the etcd StatefulSet as HyperShift creates it, with the
+// containers and volumes the etcd fixes patch
+func etcdStatefulSet() *appsv1.StatefulSet {
+	labels := map[string]string{"app": "etcd"}
+	return &appsv1.StatefulSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "clusters-test"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: int32Ptr(3),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{},
+					Affinity: &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+							LabelSelector: &metav1.LabelSelector{MatchLabels: labels},
+							TopologyKey:   "kubernetes.io/hostname",
+						}},
+					}},
+					InitContainers: []corev1.Container{
+						{Name: "ensure-dns", SecurityContext: &corev1.SecurityContext{}},
+						{Name: "reset-member", SecurityContext: &corev1.SecurityContext{}},
+					},
+					Containers: []corev1.Container{
+						{Name: "etcd", SecurityContext: &corev1.SecurityContext{}},
+						{Name: "etcd-metrics", SecurityContext: &corev1.SecurityContext{}},
+						{Name: "healthz", SecurityContext: &corev1.SecurityContext{}},
+					},
+					Volumes: []corev1.Volume{{Name: "peer-tls"}},
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}},
+		},
+	}
+}
+
+// kubeAPIServerDeployment is a kube-apiserver Deployment with a hostname
+// anti-affinity and spread constraint
+func kubeAPIServerDeployment() *appsv1.Deployment {
+	labels := map[string]string{"app": "kube-apiserver", "hypershift.openshift.io/control-plane-component": "kube-apiserver"}
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-apiserver", Namespace: "clusters-test"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(3),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Affinity: &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+							LabelSelector: &metav1.LabelSelector{MatchLabels: labels},
+							TopologyKey:   "kubernetes.io/hostname",
+						}},
+					}},
+					TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+						{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: corev1.DoNotSchedule,
+							LabelSelector: &metav1.LabelSelector{MatchLabels: labels}},
+						{MaxSkew: 2, TopologyKey: zoneTopologyKey, WhenUnsatisfiable: corev1.ScheduleAnyway,
+							LabelSelector: &metav1.LabelSelector{MatchLabels: labels}},
+					},
+					Containers: []corev1.Container{{Name: "apply-bootstrap"}, {Name: "kube-apiserver"}, {Name: "konnectivity-server"}},
+				},
+			},
+		},
+	}
+}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+// admit sends obj through the webhook and returns the pod spec of the object
+// with the patches of the response applied
+func admit(t *testing.T, ws *WebhookServer, obj runtime.Object, kind string) corev1.PodSpec {
+	t.Helper()
+	return admitTemplate(t, ws, obj, kind).Spec
+}
+
+// admitTemplate admits a workload like admit and returns its patched pod
+// template
+func admitTemplate(t *testing.T, ws *WebhookServer, obj runtime.Object, kind string) corev1.PodTemplateSpec {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "test",
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: kind},
+			Namespace: "clusters-test",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	ws.mutate(w, httptest.NewRequest("POST", "/mutate", strings.NewReader(string(body))))
+
+	var response admissionv1.AdmissionReview
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("could not decode response %q: %v", w.Body.String(), err)
+	}
+	if response.Response == nil || !response.Response.Allowed {
+		t.Fatalf("response = %+v, want allowed", response.Response)
+	}
+
+	patch, err := jsonpatch.DecodePatch(response.Response.Patch)
+	if err != nil {
+		t.Fatalf("could not decode patch: %v", err)
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatalf("could not apply patch: %v", err)
+	}
+
+	var out struct {
+		Spec struct {
+			Template corev1.PodTemplateSpec `json:"template"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(patched, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Spec.Template
+}
+
+func zoneConstraint(labels map[string]string) corev1.TopologySpreadConstraint {
+	return corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       zoneTopologyKey,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+	}
+}
+
+func TestTopologySpread_EtcdAugment(t *testing.T) {
+	policy, err := parseTopologySpreadPolicy(defaultTopologySpread, corev1.ScheduleAnyway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := admit(t, &WebhookServer{topology: policy}, etcdStatefulSet(), "StatefulSet")
+
+	want := []corev1.TopologySpreadConstraint{zoneConstraint(map[string]string{"app": "etcd"})}
+	if !reflect.DeepEqual(spec.TopologySpreadConstraints, want) {
+		t.Errorf("topologySpreadConstraints = %+v, want %+v", spec.TopologySpreadConstraints, want)
+	}
+	// The etcd fixes turn the anti-affinity into a preferred one, which is kept
+	if spec.Affinity == nil || spec.Affinity.PodAntiAffinity == nil ||
+		len(spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Errorf("affinity = %+v, want the preferred anti-affinity of the etcd fixes", spec.Affinity)
+	}
+}
+
+func TestTopologySpread_EtcdReplace(t *testing.T) {
+	policy, err := parseTopologySpreadPolicy("etcd=replace", corev1.DoNotSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := admit(t, &WebhookServer{topology: policy}, etcdStatefulSet(), "StatefulSet")
+
+	want := zoneConstraint(map[string]string{"app": "etcd"})
+	want.WhenUnsatisfiable = corev1.DoNotSchedule
+	if !reflect.DeepEqual(spec.TopologySpreadConstraints, []corev1.TopologySpreadConstraint{want}) {
+		t.Errorf("topologySpreadConstraints = %+v, want %+v", spec.TopologySpreadConstraints, want)
+	}
+	if spec.Affinity != nil && spec.Affinity.PodAntiAffinity != nil {
+		t.Errorf("podAntiAffinity = %+v, want it replaced", spec.Affinity.PodAntiAffinity)
+	}
+}
+
+func TestTopologySpread_KubeAPIServerKeepsOtherConstraints(t *testing.T) {
+	policy, err := parseTopologySpreadPolicy("kube-apiserver=augment", corev1.ScheduleAnyway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment := kubeAPIServerDeployment()
+	spec := admit(t, &WebhookServer{topology: policy}, deployment, "Deployment")
+
+	labels := deployment.Spec.Selector.MatchLabels
+	want := []corev1.TopologySpreadConstraint{
+		// The hostname constraint is kept, the zone constraint of HyperShift replaced
+		deployment.Spec.Template.Spec.TopologySpreadConstraints[0],
+		zoneConstraint(labels),
+	}
+	if !reflect.DeepEqual(spec.TopologySpreadConstraints, want) {
+		t.Errorf("topologySpreadConstraints = %+v, want %+v", spec.TopologySpreadConstraints, want)
+	}
+	if !reflect.DeepEqual(spec.Affinity, deployment.Spec.Template.Spec.Affinity) {
+		t.Errorf("affinity = %+v, want it unchanged", spec.Affinity)
+	}
+}
+
+func TestTopologySpread_Off(t *testing.T) {
+	for _, value := range []string{"", "kube-apiserver=off", "etcd=replace"} {
+		policy, err := parseTopologySpreadPolicy(value, corev1.ScheduleAnyway)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deployment := kubeAPIServerDeployment()
+		spec := admit(t, &WebhookServer{topology: policy}, deployment, "Deployment")
+		if !reflect.DeepEqual(spec.TopologySpreadConstraints, deployment.Spec.Template.Spec.TopologySpreadConstraints) {
+			t.Errorf("TOPOLOGY_SPREAD=%q: topologySpreadConstraints = %+v, want them unchanged", value, spec.TopologySpreadConstraints)
+		}
+	}
+}
+
+func TestParseTopologySpreadPolicy(t *testing.T) {
+	policy, err := parseTopologySpreadPolicy(" etcd=replace, kube-apiserver ,openshift-apiserver=off", corev1.ScheduleAnyway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]topologySpreadMode{
+		"etcd":                topologySpreadReplace,
+		"kube-apiserver":      topologySpreadAugment,
+		"openshift-apiserver": topologySpreadOff,
+	}
+	if !reflect.DeepEqual(policy.modes, want) {
+		t.Errorf("modes = %v, want %v", policy.modes, want)
+	}
+
+	if _, err := parseTopologySpreadPolicy("etcd=always", corev1.ScheduleAnyway); err == nil {
+		t.Error("parseTopologySpreadPolicy() with an invalid mode succeeded")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// tracerName names the tracer of the webhook and its default service name
+const tracerName = "hypershift-gke-autopilot-webhook"
+
+// Phases of an admission, each traced as a child span of the admission
+const (
+	phaseDecode   = "decode"
+	phaseClassify = "classify"
+	phasePatches  = "build patches"
+	phaseMarshal  = "marshal"
+)
+
+// Span attributes of an admission. The UID is the one kube-apiserver logs
+// and audits the request with.
+const (
+	attrAdmissionUID = attribute.Key("k8s.admission.uid")
+	attrOperation    = attribute.Key("k8s.admission.operation")
+	attrKind         = attribute.Key("k8s.admission.kind")
+	attrNamespace    = attribute.Key("k8s.namespace.name")
+	attrName         = attribute.Key("k8s.admission.name")
+	attrResult       = attribute.Key("k8s.admission.result")
+	attrPatches      = attribute.Key("k8s.admission.patches")
+	attrHCP          = attribute.Key("hypershift.hosted_control_plane")
+	attrTrack        = attribute.Key("hypershift.autopilot.mutation_track")
+)
+
+// Results of an admission, recorded on the classify span when it ends early
+// and on the admission span
+const (
+	resultSkipped   = "skipped"
+	resultDenied    = "denied"
+	resultThrottled = "throttled"
+	resultPatched   = "patched"
+)
+
+// setupTracingFromEnv exports the spans of admissions over OTLP/HTTP when
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set.
+// The exporter, sampler (OTEL_TRACES_SAMPLER) and service name
+// (OTEL_SERVICE_NAME) follow the standard OTEL_* variables. It returns a
+// function flushing the pending spans, or nil when tracing is disabled.
+func setupTracingFromEnv(ctx context.Context) (func(context.Context) error, error) {
+	// kube-apiserver propagates the trace of the request to webhooks with
+	// the APIServerTracing feature, so admissions join the trace of the
+	// create or update that triggered them
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" ||
+		(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "") {
+		return nil, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not create OTLP exporter: %v", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(envString("OTEL_SERVICE_NAME", tracerName))))
+	if err != nil {
+		return nil, fmt.Errorf("could not create trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracer returns the tracer of the webhook from the global provider, a no-op
+// one when tracing is disabled
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// startPhase starts the span of a phase of the admission in ctx
+func startPhase(ctx context.Context, phase string) trace.Span {
+	_, span := tracer().Start(ctx, phase)
+	return span
+}
+
+// admissionAttributes identify the request of an admission on its spans
+func admissionAttributes(req *admissionv1.AdmissionRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attrAdmissionUID.String(string(req.UID)),
+		attrOperation.String(string(req.Operation)),
+		attrKind.String(req.Kind.Kind),
+		attrNamespace.String(req.Namespace),
+		attrName.String(req.Name),
+	}
+}
+
+// endWithResult ends a span with the result of the admission, also recorded
+// on the admission span
+func endWithResult(span, admission trace.Span, result string) {
+	span.SetAttributes(attrResult.String(result))
+	admission.SetAttributes(attrResult.String(result))
+	span.End()
+}
+
+// endWithError records err on a span and the admission span, and ends the
+// span
+func endWithError(span, admission trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	admission.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+// traceSuffix returns the trace ID of a span for log lines, so a slow
+// admission found in the logs can be looked up in the tracing backend
+func traceSuffix(span trace.Span) string {
+	sc := span.SpanContext()
+	if !sc.IsValid() || !sc.IsSampled() {
+		return ""
+	}
+	return " trace=" + sc.TraceID().String()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	testTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testTraceparent = "00-" + testTraceID + "-00f067aa0ba902b7-01"
+)
+
+// recordSpans records the spans of the test, restoring the global tracer
+// provider and propagator after it
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+// admitTraced sends an admission of obj in namespace, in the trace of
+// testTraceparent
+func admitTraced(t *testing.T, ws *WebhookServer, obj runtime.Object, kind, namespace string) {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "traced",
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: kind},
+			Namespace: namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/mutate", strings.NewReader(string(body)))
+	r.Header.Set("traceparent", testTraceparent)
+	w := httptest.NewRecorder()
+	ws.mutate(w, r)
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+}
+
+// spansByName returns the ended spans of recorder by name
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	return spans
+}
+
+func attributeValue(s sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracing_Patched(t *testing.T) {
+	recorder := recordSpans(t)
+	admitTraced(t, &WebhookServer{overrides: defaultComponentOverrides}, kubeAPIServerDeployment(), "Deployment", "clusters-test")
+
+	spans := spansByName(recorder)
+	admission, ok := spans["admission"]
+	if !ok {
+		t.Fatalf("no admission span in %v", spans)
+	}
+	for _, phase := range []string{phaseDecode, phaseClassify, phasePatches, phaseMarshal} {
+		s, ok := spans[phase]
+		if !ok {
+			t.Errorf("no %s span", phase)
+			continue
+		}
+		if s.Parent().SpanID() != admission.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of the admission span", phase)
+		}
+	}
+
+	// The admission joins the trace of kube-apiserver
+	if got := admission.SpanContext().TraceID().String(); got != testTraceID {
+		t.Errorf("trace ID = %s, want the propagated %s", got, testTraceID)
+	}
+	if v, _ := attributeValue(admission, attrAdmissionUID); v.AsString() != "traced" {
+		t.Errorf("uid attribute = %q, want traced", v.AsString())
+	}
+	if v, _ := attributeValue(admission, attrResult); v.AsString() != resultPatched {
+		t.Errorf("result attribute = %q, want %s", v.AsString(), resultPatched)
+	}
+	if v, ok := attributeValue(admission, attrPatches); !ok || v.AsInt64() == 0 {
+		t.Errorf("patches attribute = %v, want the number of patches", v.AsInt64())
+	}
+}
+
+func TestTracing_Skipped(t *testing.T) {
+	recorder := recordSpans(t)
+	admitTraced(t, &WebhookServer{}, kubeAPIServerDeployment(), "Deployment", "default")
+
+	spans := spansByName(recorder)
+	if _, ok := spans[phasePatches]; ok {
+		t.Error("skipped admission has a build patches span")
+	}
+	for _, name := range []string{"admission", phaseClassify} {
+		s, ok := spans[name]
+		if !ok {
+			t.Fatalf("no %s span", name)
+		}
+		if v, _ := attributeValue(s, attrResult); v.AsString() != resultSkipped {
+			t.Errorf("%s result attribute = %q, want %s", name, v.AsString(), resultSkipped)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// violationClass groups Autopilot violations the webhook cannot patch away
+// without changing what the workload does
+type violationClass string
+
+const (
+	violationHostPath       violationClass = "hostPath"
+	violationPrivileged     violationClass = "privileged"
+	violationHostNamespaces violationClass = "hostNamespaces"
+	violationHostPorts      violationClass = "hostPorts"
+)
+
+// violationAction is what the webhook does with an object having violations
+// of a class
+type violationAction string
+
+const (
+	// violationAdmit admits the object and only logs the violations
+	violationAdmit violationAction = "admit"
+	// violationWarn admits the object with admission warnings and an Event
+	violationWarn violationAction = "warn"
+	// violationDeny rejects the object, listing the violating fields
+	violationDeny violationAction = "deny"
+)
+
+// violationPolicyEnv are the environment variables setting the action of each class
+var violationPolicyEnv = map[violationClass]string{
+	violationHostPath:       "VIOLATION_POLICY_HOSTPATH",
+	violationPrivileged:     "VIOLATION_POLICY_PRIVILEGED",
+	violationHostNamespaces: "VIOLATION_POLICY_HOST_NAMESPACES",
+	violationHostPorts:      "VIOLATION_POLICY_HOST_PORTS",
+}
+
+// violation is a field of an object Autopilot rejects
+type violation struct {
+	Class violationClass
+	// Field is the path of the violating field, e.g. spec.volumes[0].hostPath
+	Field  string
+	Reason string
+}
+
+func (v violation) String() string {
+	return fmt.Sprintf("%s (%s)", v.Field, v.Reason)
+}
+
+// violationPolicy maps each violation class to an action
+type violationPolicy map[violationClass]violationAction
+
+// newViolationPolicyFromEnv reads the action of each class from
+// VIOLATION_POLICY_* environment variables, defaulting to admit
+func newViolationPolicyFromEnv() (violationPolicy, error) {
+	policy := violationPolicy{}
+	for class, name := range violationPolicyEnv {
+		action := violationAction(envString(name, string(violationAdmit)))
+		switch action {
+		case violationAdmit, violationWarn, violationDeny:
+			policy[class] = action
+		default:
+			return nil, fmt.Errorf("invalid %s %q: must be admit, warn or deny", name, action)
+		}
+	}
+	return policy, nil
+}
+
+func (p violationPolicy) String() string {
+	var parts []string
+	for _, class := range []violationClass{violationHostPath, violationPrivileged, violationHostNamespaces, violationHostPorts} {
+		parts = append(parts, fmt.Sprintf("%s=%s", class, p[class]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// podSpecViolations lists the violations of a pod spec found at prefix
+func podSpecViolations(spec *corev1.PodSpec, prefix string) []violation {
+	var violations []violation
+
+	for i, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			violations = append(violations, violation{
+				Class:  violationHostPath,
+				Field:  fmt.Sprintf("%s.volumes[%d].hostPath", prefix, i),
+				Reason: fmt.Sprintf("volume %q mounts %s from the node", volume.Name, volume.HostPath.Path),
+			})
+		}
+	}
+
+	containerViolations := func(containers []corev1.Container, field string) {
+		for i, container := range containers {
+			if sc := container.SecurityContext; sc != nil && sc.Privileged != nil && *sc.Privileged {
+				violations = append(violations, violation{
+					Class:  violationPrivileged,
+					Field:  fmt.Sprintf("%s.%s[%d].securityContext.privileged", prefix, field, i),
+					Reason: fmt.Sprintf("container %q is privileged", container.Name),
+				})
+			}
+			for j, port := range container.Ports {
+				if port.HostPort != 0 {
+					violations = append(violations, violation{
+						Class:  violationHostPorts,
+						Field:  fmt.Sprintf("%s.%s[%d].ports[%d].hostPort", prefix, field, i, j),
+						Reason: fmt.Sprintf("container %q binds port %d of the node", container.Name, port.HostPort),
+					})
+				}
+			}
+		}
+	}
+	containerViolations(spec.InitContainers, "initContainers")
+	containerViolations(spec.Containers, "containers")
+
+	hostNamespaces := []struct {
+		field string
+		set   bool
+	}{
+		{"hostNetwork", spec.HostNetwork},
+		{"hostPID", spec.HostPID},
+		{"hostIPC", spec.HostIPC},
+	}
+	for _, ns := range hostNamespaces {
+		if ns.set {
+			violations = append(violations, violation{
+				Class:  violationHostNamespaces,
+				Field:  prefix + "." + ns.field,
+				Reason: "shares a namespace of the node",
+			})
+		}
+	}
+
+	return violations
+}
+
+// admissionWorkload is the pod spec of the Deployment, StatefulSet or Pod
+// under admission
+type admissionWorkload struct {
+	Name string
+	// Component is the name of a Deployment or StatefulSet, and the
+	// control-plane-component label of a Pod, as profiles opt them out
+	Component string
+	Spec      *corev1.PodSpec
+	// Selector selects the pods of a Deployment or StatefulSet, nil for a Pod
+	Selector *metav1.LabelSelector
+	// Field is the path of the pod spec, e.g. spec.template.spec
+	Field string
+}
+
+// admissionWorkloadOf decodes the object under admission, nil for other kinds
+func admissionWorkloadOf(req *admissionv1.AdmissionRequest) (*admissionWorkload, error) {
+	switch req.Kind.Kind {
+	case "Deployment":
+		var deployment appsv1.Deployment
+		if err := json.Unmarshal(req.Object.Raw, &deployment); err != nil {
+			return nil, err
+		}
+		return &admissionWorkload{Name: deployment.Name, Component: deployment.Name, Spec: &deployment.Spec.Template.Spec,
+			Selector: deployment.Spec.Selector, Field: "spec.template.spec"}, nil
+	case "StatefulSet":
+		var statefulSet appsv1.StatefulSet
+		if err := json.Unmarshal(req.Object.Raw, &statefulSet); err != nil {
+			return nil, err
+		}
+		return &admissionWorkload{Name: statefulSet.Name, Component: statefulSet.Name, Spec: &statefulSet.Spec.Template.Spec,
+			Selector: statefulSet.Spec.Selector, Field: "spec.template.spec"}, nil
+	case "Pod":
+		var pod corev1.Pod
+		if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+			return nil, err
+		}
+		return &admissionWorkload{Name: pod.Name, Component: pod.Labels[controlPlaneComponentLabel], Spec: &pod.Spec, Field: "spec"}, nil
+	}
+	return nil, nil
+}
+
+// admissionViolations lists the violations of the Deployment, StatefulSet or
+// Pod under admission
+func admissionViolations(req *admissionv1.AdmissionRequest) ([]violation, error) {
+	workload, err := admissionWorkloadOf(req)
+	if err != nil || workload == nil {
+		return nil, err
+	}
+	return podSpecViolations(workload.Spec, workload.Field), nil
+}
+
+// violationDecision is the outcome of applying the policy to an object
+type violationDecision struct {
+	// Denied lists the violations the object is rejected for
+	Denied []violation
+	// Warned lists the violations reported back to the client
+	Warned []violation
+}
+
+// Decide splits violations by the action of their class
+func (p violationPolicy) Decide(violations []violation) violationDecision {
+	var decision violationDecision
+	for _, v := range violations {
+		switch p[v.Class] {
+		case violationDeny:
+			decision.Denied = append(decision.Denied, v)
+		case violationWarn:
+			decision.Warned = append(decision.Warned, v)
+		}
+	}
+	return decision
+}
+
+// denialMessage describes every violation an object is rejected for
+func denialMessage(req *admissionv1.AdmissionRequest, violations []violation) string {
+	var fields []string
+	for _, v := range violations {
+		fields = append(fields, v.String())
+	}
+	return fmt.Sprintf("%s %s/%s cannot run on GKE Autopilot and cannot be fixed automatically: %s",
+		req.Kind.Kind, req.Namespace, req.Name, strings.Join(fields, "; "))
+}
+
+// checkViolations applies the violation policy to the object under admission.
+// Violations the host access plan converts are dropped, and those it cannot
+// convert are denied whatever the policy, with the reason. It returns a
+// denial message when the object must be rejected, and the warnings to admit
+// it with otherwise.
+func (ws *WebhookServer) checkViolations(req *admissionv1.AdmissionRequest, plan hostAccessPlan) (string, []string) {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return "", nil
+	}
+	violations, err := admissionViolations(req)
+	if err != nil {
+		log.Printf("Could not check %s %s for violations: %v", req.Kind.Kind, req.Name, err)
+		return "", nil
+	}
+	violations, unconverted := plan.Resolve(violations)
+	if len(violations) == 0 && len(unconverted) == 0 && len(plan.Warnings) == 0 {
+		return "", nil
+	}
+
+	decision := ws.violations.Decide(violations)
+	for _, v := range violations {
+		action := ws.violations[v.Class]
+		log.Printf("Autopilot violation in %s %s/%s (%s): %s", req.Kind.Kind, req.Namespace, req.Name, action, v)
+		violationsTotal.WithLabelValues(string(v.Class), string(action)).Inc()
+	}
+	for _, v := range unconverted {
+		log.Printf("Autopilot violation in %s %s/%s (%s): %s", req.Kind.Kind, req.Namespace, req.Name, violationDeny, v)
+		violationsTotal.WithLabelValues(string(v.Class), string(violationDeny)).Inc()
+	}
+	decision.Denied = append(unconverted, decision.Denied...)
+
+	if len(decision.Denied) > 0 {
+		message := denialMessage(req, decision.Denied)
+		if ws.recorder != nil && req.Name != "" {
+			ws.recorder.Event(admissionObjectReference(req), corev1.EventTypeWarning, "AutopilotViolationDenied", message)
+		}
+		return message, nil
+	}
+
+	var warnings []string
+	for _, v := range decision.Warned {
+		warnings = append(warnings, "GKE Autopilot does not allow "+v.String())
+	}
+	if len(warnings) > 0 && ws.recorder != nil && req.Name != "" {
+		ws.recorder.Event(admissionObjectReference(req), corev1.EventTypeWarning, "AutopilotViolation", strings.Join(warnings, "; "))
+	}
+	if len(plan.Warnings) > 0 && ws.recorder != nil && req.Name != "" {
+		ws.recorder.Event(admissionObjectReference(req), corev1.EventTypeNormal, "AutopilotHostAccessConverted", strings.Join(plan.Warnings, "; "))
+	}
+	return "", append(warnings, plan.Warnings...)
+}
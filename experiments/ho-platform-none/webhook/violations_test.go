@@ -0,0 +1,164 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// violatingSpec has a violation of every class
+func violatingSpec() corev1.PodSpec {
+	privileged := true
+	return corev1.PodSpec{
+		HostNetwork: true,
+		HostPID:     true,
+		Volumes: []corev1.Volume{
+			{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			hostPathVolume("logs", "/var/log", corev1.HostPathUnset),
+		},
+		InitContainers: []corev1.Container{{Name: "setup", SecurityContext: &corev1.SecurityContext{Privileged: &privileged}}},
+		Containers: []corev1.Container{
+			{Name: "agent", Ports: []corev1.ContainerPort{{ContainerPort: 8080}, {ContainerPort: 2041, HostPort: 2041}}},
+		},
+	}
+}
+
+func TestPodSpecViolations(t *testing.T) {
+	spec := violatingSpec()
+	want := []violation{
+		{Class: violationHostPath, Field: "spec.template.spec.volumes[1].hostPath", Reason: `volume "logs" mounts /var/log from the node`},
+		{Class: violationPrivileged, Field: "spec.template.spec.initContainers[0].securityContext.privileged", Reason: `container "setup" is privileged`},
+		{Class: violationHostPorts, Field: "spec.template.spec.containers[0].ports[1].hostPort", Reason: `container "agent" binds port 2041 of the node`},
+		{Class: violationHostNamespaces, Field: "spec.template.spec.hostNetwork", Reason: "shares a namespace of the node"},
+		{Class: violationHostNamespaces, Field: "spec.template.spec.hostPID", Reason: "shares a namespace of the node"},
+	}
+	if got := podSpecViolations(&spec, "spec.template.spec"); !reflect.DeepEqual(got, want) {
+		t.Errorf("podSpecViolations() = %+v, want %+v", got, want)
+	}
+
+	notPrivileged := false
+	clean := corev1.PodSpec{Containers: []corev1.Container{{
+		Name:            "agent",
+		SecurityContext: &corev1.SecurityContext{Privileged: &notPrivileged},
+		Ports:           []corev1.ContainerPort{{ContainerPort: 8080}},
+	}}}
+	if got := podSpecViolations(&clean, "spec"); len(got) != 0 {
+		t.Errorf("podSpecViolations() = %+v for a clean spec", got)
+	}
+}
+
+func TestCheckViolations(t *testing.T) {
+	quietLogs(t)
+	const (
+		hostPath   = "spec.template.spec.volumes[1].hostPath (volume \"logs\" mounts /var/log from the node)"
+		privileged = "spec.template.spec.initContainers[0].securityContext.privileged (container \"setup\" is privileged)"
+		hostPort   = "spec.template.spec.containers[0].ports[1].hostPort (container \"agent\" binds port 2041 of the node)"
+		hostNet    = "spec.template.spec.hostNetwork (shares a namespace of the node)"
+		hostPID    = "spec.template.spec.hostPID (shares a namespace of the node)"
+	)
+	admitAll := violationPolicy{
+		violationHostPath:       violationAdmit,
+		violationPrivileged:     violationAdmit,
+		violationHostNamespaces: violationAdmit,
+		violationHostPorts:      violationAdmit,
+	}
+	with := func(actions violationPolicy) violationPolicy {
+		policy := violationPolicy{}
+		for class, action := range admitAll {
+			policy[class] = action
+		}
+		for class, action := range actions {
+			policy[class] = action
+		}
+		return policy
+	}
+
+	for _, tc := range []struct {
+		name     string
+		policy   violationPolicy
+		denied   string
+		warnings []string
+		event    string
+	}{
+		{name: "admit", policy: admitAll},
+		{
+			name:     "warn",
+			policy:   with(violationPolicy{violationHostPorts: violationWarn, violationHostNamespaces: violationWarn}),
+			warnings: []string{"GKE Autopilot does not allow " + hostPort, "GKE Autopilot does not allow " + hostNet, "GKE Autopilot does not allow " + hostPID},
+			event:    "Warning AutopilotViolation GKE Autopilot does not allow " + hostPort + "; GKE Autopilot does not allow " + hostNet + "; GKE Autopilot does not allow " + hostPID,
+		},
+		{
+			// Warnings are dropped when the object is denied
+			name:   "deny",
+			policy: with(violationPolicy{violationHostPath: violationDeny, violationPrivileged: violationDeny, violationHostPorts: violationWarn}),
+			denied: "Deployment clusters-test/konnectivity-agent cannot run on GKE Autopilot and cannot be fixed automatically: " +
+				hostPath + "; " + privileged,
+			event: "Warning AutopilotViolationDenied Deployment clusters-test/konnectivity-agent cannot run on GKE Autopilot and cannot be fixed automatically: " +
+				hostPath + "; " + privileged,
+		},
+		{
+			name:   "deny all",
+			policy: with(violationPolicy{violationHostPath: violationDeny, violationPrivileged: violationDeny, violationHostPorts: violationDeny, violationHostNamespaces: violationDeny}),
+			denied: "Deployment clusters-test/konnectivity-agent cannot run on GKE Autopilot and cannot be fixed automatically: " +
+				hostPath + "; " + privileged + "; " + hostPort + "; " + hostNet + "; " + hostPID,
+			event: "Warning AutopilotViolationDenied",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			ws := &WebhookServer{violations: tc.policy, recorder: recorder}
+			req := admissionRequestOf(t, hostAccessDeployment(violatingSpec()))
+
+			denied, warnings := ws.checkViolations(req, ws.hostAccessPlan(req))
+			if denied != tc.denied {
+				t.Errorf("denial = %q, want %q", denied, tc.denied)
+			}
+			if !reflect.DeepEqual(warnings, tc.warnings) {
+				t.Errorf("warnings = %q, want %q", warnings, tc.warnings)
+			}
+			var event string
+			if len(recorder.Events) > 0 {
+				event = <-recorder.Events
+			}
+			if !strings.HasPrefix(event, tc.event) || (tc.event == "") != (event == "") || len(recorder.Events) != 0 {
+				t.Errorf("Event = %q, want %q", event, tc.event)
+			}
+		})
+	}
+
+	// Deletions and objects other than workloads are not checked
+	ws := &WebhookServer{violations: with(violationPolicy{violationHostNamespaces: violationDeny})}
+	req := admissionRequestOf(t, hostAccessDeployment(violatingSpec()))
+	req.Operation = admissionv1.Delete
+	if denied, warnings := ws.checkViolations(req, hostAccessPlan{}); denied != "" || warnings != nil {
+		t.Errorf("checkViolations() = %q, %v on DELETE", denied, warnings)
+	}
+	req.Operation = admissionv1.Create
+	req.Kind.Kind = "Service"
+	if denied, warnings := ws.checkViolations(req, hostAccessPlan{}); denied != "" || warnings != nil {
+		t.Errorf("checkViolations() = %q, %v for a Service", denied, warnings)
+	}
+}
+
+func TestNewViolationPolicyFromEnv(t *testing.T) {
+	for _, name := range violationPolicyEnv {
+		t.Setenv(name, "")
+	}
+	t.Setenv("VIOLATION_POLICY_PRIVILEGED", "deny")
+	policy, err := newViolationPolicyFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := policy.String(), "hostPath=admit, privileged=deny, hostNamespaces=admit, hostPorts=admit"; got != want {
+		t.Errorf("policy = %s, want %s", got, want)
+	}
+
+	t.Setenv("VIOLATION_POLICY_HOST_PORTS", "block")
+	if _, err := newViolationPolicyFromEnv(); err == nil || !strings.Contains(err.Error(), "VIOLATION_POLICY_HOST_PORTS") {
+		t.Errorf("newViolationPolicyFromEnv() error = %v, want the invalid variable", err)
+	}
+}
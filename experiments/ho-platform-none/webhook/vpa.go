@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+var verticalPodAutoscalerResource = schema.GroupVersionResource{
+	Group:    "autoscaling.k8s.io",
+	Version:  "v1",
+	Resource: "verticalpodautoscalers",
+}
+
+// vpaSource serves the target recommendations of VerticalPodAutoscalers
+// targeting HyperShift components, typically in updateMode Off so the VPA
+// only recommends. VPA recommends CPU and memory, not ephemeral storage.
+type vpaSource struct {
+	mu       sync.RWMutex
+	usage    map[string]corev1.ResourceList
+	byVPA    map[string][]string
+	informer cache.SharedIndexInformer
+}
+
+func newVPASource() (*vpaSource, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("VPA right-sizing needs to run inside a cluster: %v", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create dynamic client: %v", err)
+	}
+
+	s := &vpaSource{
+		usage:    make(map[string]corev1.ResourceList),
+		byVPA:    make(map[string][]string),
+		informer: dynamicinformer.NewDynamicSharedInformerFactory(client, 0).ForResource(verticalPodAutoscalerResource).Informer(),
+	}
+	_, err = s.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    s.set,
+		UpdateFunc: func(_, obj interface{}) { s.set(obj) },
+		DeleteFunc: s.delete,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not watch VerticalPodAutoscalers: %v", err)
+	}
+	return s, nil
+}
+
+func (s *vpaSource) Name() string {
+	return "vpa"
+}
+
+// Run watches VerticalPodAutoscalers until ctx is done
+func (s *vpaSource) Run(ctx context.Context) {
+	go s.informer.Run(ctx.Done())
+	if cache.WaitForCacheSync(ctx.Done(), s.informer.HasSynced) {
+		s.mu.RLock()
+		log.Printf("VerticalPodAutoscaler recommendations synced for %d containers", len(s.usage))
+		s.mu.RUnlock()
+	}
+}
+
+func (s *vpaSource) Usage(namespace, kind, name, container string) (corev1.ResourceList, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	usage, ok := s.usage[usageKey(namespace, kind, name, container)]
+	return usage, ok
+}
+
+func (s *vpaSource) set(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	kind, _, _ := unstructured.NestedString(u.Object, "spec", "targetRef", "kind")
+	name, _, _ := unstructured.NestedString(u.Object, "spec", "targetRef", "name")
+	recommendations, _, _ := unstructured.NestedSlice(u.Object, "status", "recommendation", "containerRecommendations")
+
+	usage := map[string]corev1.ResourceList{}
+	for _, r := range recommendations {
+		rec, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		container, _, _ := unstructured.NestedString(rec, "containerName")
+		target, _, _ := unstructured.NestedStringMap(rec, "target")
+		list := corev1.ResourceList{}
+		for resourceName, value := range target {
+			if q, err := resource.ParseQuantity(value); err == nil {
+				list[corev1.ResourceName(resourceName)] = q
+			}
+		}
+		if container != "" && len(list) > 0 {
+			usage[usageKey(u.GetNamespace(), kind, name, container)] = list
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(u)
+	vpaKey := u.GetNamespace() + "/" + u.GetName()
+	for key, list := range usage {
+		s.usage[key] = list
+		s.byVPA[vpaKey] = append(s.byVPA[vpaKey], key)
+	}
+}
+
+func (s *vpaSource) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(u)
+}
+
+// forget drops the recommendations of a VPA, as its target or containers may
+// have changed
+func (s *vpaSource) forget(u *unstructured.Unstructured) {
+	vpaKey := u.GetNamespace() + "/" + u.GetName()
+	for _, key := range s.byVPA[vpaKey] {
+		delete(s.usage, key)
+	}
+	delete(s.byVPA, vpaKey)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+func verticalPodAutoscaler(t *testing.T, manifest string) *unstructured.Unstructured {
+	t.Helper()
+	u := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(manifest), &u.Object); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+const testVPA = `
+apiVersion: autoscaling.k8s.io/v1
+kind: VerticalPodAutoscaler
+metadata:
+  name: kube-apiserver
+  namespace: clusters-a
+spec:
+  targetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: kube-apiserver
+  updatePolicy:
+    updateMode: "Off"
+status:
+  recommendation:
+    containerRecommendations:
+    - containerName: kube-apiserver
+      lowerBound: {cpu: 100m, memory: 500Mi}
+      target: {cpu: 350m, memory: "1153433600"}
+      upperBound: {cpu: "2", memory: 4Gi}
+    - containerName: konnectivity-agent
+      target: {cpu: 12m, memory: 32Mi, example.com/unknown: not-a-quantity}
+    - containerName: no-target
+    - target: {cpu: 1m}
+`
+
+func TestVPASource_Set(t *testing.T) {
+	s := &vpaSource{usage: map[string]corev1.ResourceList{}, byVPA: map[string][]string{}}
+	s.set(verticalPodAutoscaler(t, testVPA))
+
+	for _, tc := range []struct {
+		container string
+		want      corev1.ResourceList
+	}{
+		// The target is used, not the bounds
+		{"kube-apiserver", corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("350m"),
+			corev1.ResourceMemory: resource.MustParse("1100Mi"),
+		}},
+		// Invalid quantities are skipped
+		{"konnectivity-agent", corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("12m"),
+			corev1.ResourceMemory: resource.MustParse("32Mi"),
+		}},
+		{"no-target", nil},
+		{"", nil},
+	} {
+		got, ok := s.Usage("clusters-a", "Deployment", "kube-apiserver", tc.container)
+		if ok != (tc.want != nil) {
+			t.Errorf("Usage(%q) found = %t, want %t", tc.container, ok, tc.want != nil)
+			continue
+		}
+		for name, want := range tc.want {
+			if q := got[name]; q.Cmp(want) != 0 {
+				t.Errorf("Usage(%q)[%s] = %s, want %s", tc.container, name, q.String(), want.String())
+			}
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("Usage(%q) = %v, want %v", tc.container, got, tc.want)
+		}
+	}
+	if _, ok := s.Usage("clusters-a", "StatefulSet", "kube-apiserver", "kube-apiserver"); ok {
+		t.Error("usage found for a workload of another kind")
+	}
+}
+
+func TestVPASource_UpdateAndDelete(t *testing.T) {
+	s := &vpaSource{usage: map[string]corev1.ResourceList{}, byVPA: map[string][]string{}}
+	vpa := verticalPodAutoscaler(t, testVPA)
+	s.set(vpa)
+
+	// The update drops the recommendation of a container and retargets the
+	// VPA
+	updated := verticalPodAutoscaler(t, testVPA)
+	if err := unstructured.SetNestedField(updated.Object, "StatefulSet", "spec", "targetRef", "kind"); err != nil {
+		t.Fatal(err)
+	}
+	recommendations, _, _ := unstructured.NestedSlice(updated.Object, "status", "recommendation", "containerRecommendations")
+	if err := unstructured.SetNestedSlice(updated.Object, recommendations[:1], "status", "recommendation", "containerRecommendations"); err != nil {
+		t.Fatal(err)
+	}
+	s.set(updated)
+	want := []string{usageKey("clusters-a", "StatefulSet", "kube-apiserver", "kube-apiserver")}
+	if got := usageKeys(s.usage); !reflect.DeepEqual(got, want) {
+		t.Errorf("usage after update = %v, want %v", got, want)
+	}
+
+	// Another VPA in the namespace is kept when this one is deleted, also
+	// through a tombstone
+	other := verticalPodAutoscaler(t, testVPA)
+	other.SetName("etcd")
+	if err := unstructured.SetNestedField(other.Object, "etcd", "spec", "targetRef", "name"); err != nil {
+		t.Fatal(err)
+	}
+	s.set(other)
+	s.delete(cache.DeletedFinalStateUnknown{Key: "clusters-a/kube-apiserver", Obj: updated})
+	if _, ok := s.Usage("clusters-a", "StatefulSet", "kube-apiserver", "kube-apiserver"); ok {
+		t.Error("usage left after the VPA was deleted")
+	}
+	if _, ok := s.Usage("clusters-a", "Deployment", "etcd", "konnectivity-agent"); !ok || len(s.usage) != 2 || len(s.byVPA) != 1 {
+		t.Errorf("usage after delete = %v, want the 2 containers of the other VPA", usageKeys(s.usage))
+	}
+
+	// Objects that are not VPAs are ignored
+	s.set("not a VPA")
+	s.delete(nil)
+	if len(s.usage) != 2 {
+		t.Errorf("usage = %v after invalid events", usageKeys(s.usage))
+	}
+}
+
+func usageKeys(usage map[string]corev1.ResourceList) []string {
+	var keys []string
+	for key := range usage {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,146 @@
+package gcpctl
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	approveTask    string
+	approveReject  bool
+	approveMessage string
+)
+
+// approveCmd represents the approve command
+var approveCmd = &cobra.Command{
+	Use:   "approve <pipelinerun>",
+	Short: "Approve a manual approval gate of a pipeline run",
+	Long: `Approve, or with --reject reject, a manual approval gate a pipeline run
+waits on. Gates are ApprovalTask custom tasks of the OpenShift Pipelines
+manual approval gate; the run proceeds once the gate has the approvals it
+requires, and fails when an approver rejects it.
+
+The answer is recorded for the user the cluster authenticates gcpctl as, who
+must be an approver of the gate by name or a member of an approver group.
+--task selects the gate by its pipeline task, and may be left out when the
+run waits on a single gate.
+
+'gcpctl status' lists the gates of a pipeline run and their approvers.`,
+	Example: `  gcpctl approve gcp-region-provision-jf8v5
+  gcpctl approve gcp-region-provision-jf8v5 --task approve-production --message "change window CHG-1234"
+  gcpctl approve gcp-region-provision-jf8v5 --task approve-production --reject --message "quota not raised yet"`,
+	Args: cobra.ExactArgs(1),
+	RunE: runApprove,
+}
+
+func init() {
+	rootCmd.AddCommand(approveCmd)
+
+	approveCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline run")
+	approveCmd.Flags().StringVar(&approveTask, "task", "", "pipeline task of the approval gate")
+	approveCmd.Flags().BoolVar(&approveReject, "reject", false, "reject the gate, failing the pipeline run")
+	approveCmd.Flags().StringVarP(&approveMessage, "message", "m", "", "message recorded with the answer")
+}
+
+func runApprove(cmd *cobra.Command, args []string) error {
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+
+	logVerbose("Answering the approval gate of pipeline run %s in namespace %s", args[0], namespace)
+
+	result, err := client.AnswerApproval(cmd.Context(), statusClient, namespace, args[0], client.ApprovalOptions{
+		Task:    approveTask,
+		Reject:  approveReject,
+		Message: approveMessage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to answer approval: %w", err)
+	}
+
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), result)
+	}
+	printApproval(cmd.OutOrStdout(), result)
+	return nil
+}
+
+// printApproval prints the answer recorded by 'approve'
+func printApproval(w io.Writer, result *api.ApprovalResult) {
+	approver := result.Approver
+	if result.Group != "" {
+		approver += fmt.Sprintf(" (group %s)", result.Group)
+	}
+	if result.Response == api.ApprovalRejected {
+		fmt.Fprintf(w, "✗ Rejected %s of pipeline run %s as %s\n\n", result.Task, result.PipelineRun, approver)
+	} else {
+		fmt.Fprintf(w, "✓ Approved %s of pipeline run %s as %s\n\n", result.Task, result.PipelineRun, approver)
+	}
+	fmt.Fprintf(w, "  Pipeline Run: %s\n", runLink(w, result.Namespace, result.PipelineRun))
+	fmt.Fprintf(w, "  Namespace:    %s\n", result.Namespace)
+	if result.Message != "" {
+		fmt.Fprintf(w, "  Message:      %s\n", result.Message)
+	}
+	fmt.Fprintf(w, "  Approvals:    %d/%d\n", result.Approvals, result.Required)
+
+	switch {
+	case result.Response == api.ApprovalRejected:
+		fmt.Fprintln(w, "\n  The pipeline run fails once the gate records the rejection.")
+	case result.Approvals < result.Required:
+		fmt.Fprintf(w, "\n  The gate waits for %d more approval(s).\n", result.Required-result.Approvals)
+	default:
+		fmt.Fprintln(w, "\n  The pipeline run proceeds once the gate records the approval.")
+	}
+	fmt.Fprintln(w)
+}
+
+// printApprovals prints the approval gates of a pipeline run in 'region
+// status', with the command answering each pending one
+func printApprovals(w io.Writer, status *api.PipelineRunStatus) {
+	pending := len(status.PendingApprovals())
+	if pending > 0 {
+		fmt.Fprintf(w, "\nApprovals (%d pending):\n", pending)
+	} else {
+		fmt.Fprintf(w, "\nApprovals (%d):\n", len(status.Approvals))
+	}
+	for _, a := range status.Approvals {
+		fmt.Fprintf(w, "  %s %s (%s, %d/%d approvals)\n", approvalEmoji(a.State), a.Task, a.State, a.Approvals, a.Required)
+		if a.Description != "" {
+			fmt.Fprintf(w, "      %s\n", a.Description)
+		}
+		for _, r := range a.Responses {
+			line := fmt.Sprintf("      %s by %s", r.Response, r.Name)
+			if r.Message != "" {
+				line += ": " + r.Message
+			}
+			fmt.Fprintln(w, line)
+		}
+		if a.State != api.ApprovalPending {
+			continue
+		}
+		fmt.Fprintf(w, "      approvers: %s\n", strings.Join(a.Approvers, ", "))
+		approve := fmt.Sprintf("gcpctl approve %s --task %s", status.Name, a.Task)
+		if status.Namespace != "" && status.Namespace != "default" {
+			approve += " --namespace " + status.Namespace
+		}
+		fmt.Fprintf(w, "      approve: %s\n", approve)
+	}
+}
+
+// approvalEmoji returns an emoji for the state of an approval gate
+func approvalEmoji(state string) string {
+	switch state {
+	case api.ApprovalApproved:
+		return client.GetStatusEmoji("succeeded")
+	case api.ApprovalRejected:
+		return client.GetStatusEmoji("failed")
+	default:
+		return client.GetStatusEmoji("pending")
+	}
+}
@@ -0,0 +1,320 @@
+package gcpctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/catalog"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/events"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/history"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+// defaultConcurrency is how many requests of a file are submitted at once
+const defaultConcurrency = 4
+
+var (
+	requestsFile string
+	concurrency  int
+)
+
+// runBulkOperation submits the requests of a file. Every request is checked
+// before any is submitted, so a typo in one entry does not leave the others
+// half-applied. Field flags given on the command line apply to every request
+// that does not set the field, extra pipeline parameters to every request.
+func runBulkOperation(cmd *cobra.Command, op *operations.Operation, path string) error {
+	if concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1, got %d", concurrency)
+	}
+
+	requests, err := readRequests(cmd.InOrStdin(), op, path)
+	if err != nil {
+		return err
+	}
+	fillDefaults(requests, operationValues(cmd, op))
+
+	if err := checkRequests(cmd, op, requests); err != nil {
+		return err
+	}
+	params, err := requestParams(op)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return runDryRun(cmd, op, requests, params)
+	}
+	if err := checkNotify(); err != nil {
+		return err
+	}
+	if err := checkEvents(); err != nil {
+		return err
+	}
+
+	if op.Confirm != nil && !assumeYes {
+		for _, v := range requests {
+			fmt.Fprintf(cmd.ErrOrStderr(), "  %s\n", op.Confirm(v))
+		}
+		confirmed, err := confirm(cmd.InOrStdin(), cmd.ErrOrStderr(), fmt.Sprintf("Submit these %d requests?", len(requests)))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Fprintln(cmd.ErrOrStderr(), "Aborted.")
+			return nil
+		}
+	}
+
+	tektonClient, err := newTektonClient()
+	if err != nil {
+		return err
+	}
+	var statusClient client.ClusterClient
+	if wait {
+		if statusClient, err = newStatusClient(); err != nil {
+			return err
+		}
+	}
+
+	l := ledger()
+	w := progressWriter(cmd)
+	fmt.Fprintf(w, "Submitting %d %s requests, %d at a time...\n", len(requests), op.Name(), concurrency)
+
+	result := &api.BulkResult{Items: make([]api.BulkItem, len(requests))}
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, concurrency)
+	)
+	for i, v := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			item, waitErr := submitRequest(cmd.Context(), op, tektonClient, statusClient, l, cmd.ErrOrStderr(), v, params)
+			result.Items[i] = item
+			var notifyErr error
+			if wait && item.Event != nil {
+				notifyErr = notifyCompletion(cmd.Context(), op, v, item.PipelineRun, waitErr)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			printBulkProgress(w, op, v, item, time.Now())
+			if notifyErr != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: failed to send notifications for %s: %v\n", op.Describe(v), notifyErr)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, item := range result.Items {
+		if item.Error == "" {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	}
+
+	if structuredOutput() {
+		if err := printStructured(cmd.OutOrStdout(), result); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(cmd.OutOrStdout())
+		printBulkResult(cmd.OutOrStdout(), op, result)
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d of %d requests failed", result.Failed, len(result.Items))
+	}
+	return nil
+}
+
+// readRequests reads the requests of an operation from a file, or from in
+// for "-"
+func readRequests(in io.Reader, op *operations.Operation, path string) ([]operations.Values, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(in)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read requests: %w", err)
+	}
+
+	requests, err := op.ParseRequests(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid requests file %s: %w", path, err)
+	}
+	return requests, nil
+}
+
+// fillDefaults sets the fields requests leave empty to their default value
+func fillDefaults(requests []operations.Values, defaults operations.Values) {
+	for _, v := range requests {
+		for name, value := range defaults {
+			if v[name] == "" {
+				v[name] = value
+			}
+		}
+	}
+}
+
+// checkRequests validates every request of a file against the operation and
+// the catalog, and rejects duplicates. All problems are printed before
+// failing, so the file can be fixed in one go.
+func checkRequests(cmd *cobra.Command, op *operations.Operation, requests []operations.Values) error {
+	var c *catalog.Catalog
+	if !skipCatalog && usesCatalog(op) {
+		c = loadCatalog(cmd.Context(), cmd.ErrOrStderr())
+	}
+
+	var problems []string
+	seen := map[string]int{}
+	for i, v := range requests {
+		err := op.Check(v)
+		if err == nil && c != nil {
+			if err = checkCatalogFields(c, op, v); err != nil {
+				err = fmt.Errorf("%w (use --skip-catalog to send it anyway)", err)
+			}
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("request %d: %v", i+1, err))
+			continue
+		}
+
+		key := op.Describe(v)
+		if first, ok := seen[key]; ok {
+			problems = append(problems, fmt.Sprintf("request %d: same as request %d (%s)", i+1, first, key))
+			continue
+		}
+		seen[key] = i + 1
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Fprintf(cmd.ErrOrStderr(), "  %s\n", p)
+	}
+	return fmt.Errorf("invalid requests: %d of %d requests are invalid, none was submitted", len(problems), len(requests))
+}
+
+// submitRequest posts one request of a file to the webhook, records it in the
+// history and, with --wait, follows its pipeline run until it finishes. The
+// error ending the wait early, if any, is returned besides the item.
+func submitRequest(ctx context.Context, op *operations.Operation, tektonClient *client.TektonClient, statusClient client.ClusterClient, l *history.Ledger, errOut io.Writer, v operations.Values, params operations.Params) (api.BulkItem, error) {
+	item := api.BulkItem{Request: v}
+
+	payload, err := op.BuildPayloadWithParams(v, params)
+	if err != nil {
+		item.Error = err.Error()
+		return item, nil
+	}
+	triggerCtx, cancel := context.WithTimeout(ctx, timeout)
+	resp, err := tektonClient.Trigger(triggerCtx, op.Route, payload)
+	cancel()
+	if err != nil {
+		item.Error = err.Error()
+		return item, nil
+	}
+	item.Event = resp
+	recordSubmission(l, errOut, op, v, params, resp)
+	publishEvent(ctx, errOut, events.TypeSubmissionCreated, op, v, resp, nil)
+	if !wait {
+		return item, nil
+	}
+
+	if resp.EventID == "" {
+		err := errors.New("cannot wait for the pipeline run: the webhook response has no event ID")
+		item.Error = err.Error()
+		return item, err
+	}
+	ns := resp.Namespace
+	if ns == "" {
+		ns = "default"
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+	final, waitErr := client.FollowPipelineRun(waitCtx, statusClient, ns, resp.EventID, pollInterval, runEvents(ctx, errOut, op, v, resp))
+	if final != nil {
+		setDashboardURL(final)
+		item.PipelineRun = final
+		item.State = op.DescribeState(v, final)
+	}
+	err = waitErr
+	if err == nil {
+		err = pipelineRunError(final)
+	}
+	if err != nil {
+		item.Error = err.Error()
+	}
+	return item, waitErr
+}
+
+// printBulkProgress prints the outcome of a request of a file as soon as it
+// is known
+func printBulkProgress(w io.Writer, op *operations.Operation, v operations.Values, item api.BulkItem, now time.Time) {
+	stamp := now.Format(clockLayout)
+	switch {
+	case item.Error != "":
+		fmt.Fprintf(w, "[%s] ✗ %s: %s\n", stamp, op.Describe(v), item.Error)
+	case item.PipelineRun != nil:
+		fmt.Fprintf(w, "[%s] ✓ %s: %s\n", stamp, op.Describe(v), item.State)
+	default:
+		fmt.Fprintf(w, "[%s] ✓ %s: triggered, event %s\n", stamp, op.Describe(v), orDash(item.Event.EventID))
+	}
+}
+
+// printBulkResult prints the outcome of every request of a file as a table,
+// followed by the number of requests that succeeded
+func printBulkResult(w io.Writer, op *operations.Operation, result *api.BulkResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := make([]string, 0, len(op.Fields)+3)
+	for _, f := range op.Fields {
+		header = append(header, strings.ToUpper(f.Name))
+	}
+	header = append(header, "EVENT ID")
+	if wait {
+		header = append(header, "STATE")
+	}
+	header = append(header, "RESULT")
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, item := range result.Items {
+		row := make([]string, 0, len(header))
+		for _, f := range op.Fields {
+			row = append(row, orDash(item.Request[f.Name]))
+		}
+		eventID := ""
+		if item.Event != nil {
+			eventID = item.Event.EventID
+		}
+		row = append(row, orDash(eventID))
+		if wait {
+			row = append(row, orDash(item.State))
+		}
+		if item.Error != "" {
+			row = append(row, "✗ "+item.Error)
+		} else {
+			row = append(row, "✓ OK")
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d of %d requests succeeded\n", result.Succeeded, len(result.Items))
+}
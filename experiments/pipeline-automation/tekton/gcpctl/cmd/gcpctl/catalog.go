@@ -0,0 +1,142 @@
+package gcpctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/catalog"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+// catalogColumns is the number of columns regions are printed in
+const catalogColumns = 4
+
+var skipCatalog bool
+
+// catalogCmd represents the catalog command
+var catalogCmd = &cobra.Command{
+	Use:   "catalog",
+	Short: "List the environments, sectors and regions gcpctl accepts",
+	Long: `List the environments, sectors and GCP regions that operations such as
+'region add' and 'region delete' accept, unless --skip-catalog is given.
+
+The catalog is built into gcpctl. Set catalog_url in the config file or
+GCPCTL_CATALOG_URL to read it from a URL or file instead; if that fails, the
+built-in catalog is used.`,
+	Example: `  gcpctl catalog
+  gcpctl catalog -o json`,
+	Args: cobra.NoArgs,
+	RunE: runCatalog,
+}
+
+func init() {
+	rootCmd.AddCommand(catalogCmd)
+}
+
+func runCatalog(cmd *cobra.Command, args []string) error {
+	c := loadCatalog(cmd.Context(), cmd.ErrOrStderr())
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), c)
+	}
+	printCatalog(cmd.OutOrStdout(), c)
+	return nil
+}
+
+// loadCatalog returns the catalog of the configured catalog URL, or the
+// built-in one if none is configured or it cannot be read
+func loadCatalog(ctx context.Context, errOut io.Writer) *catalog.Catalog {
+	url := config.GetCatalogURL()
+	if url == "" {
+		return catalog.Default()
+	}
+
+	logVerbose("Reading catalog from %s", url)
+	hc, err := httpClient()
+	if err != nil {
+		fmt.Fprintf(errOut, "Warning: %v, using the built-in catalog\n", err)
+		return catalog.Default()
+	}
+	c, err := catalog.Load(ctx, hc, url)
+	if err != nil {
+		fmt.Fprintf(errOut, "Warning: %v, using the built-in catalog\n", err)
+		return catalog.Default()
+	}
+	return c
+}
+
+// checkCatalog checks the catalog fields of an operation request against the
+// catalog, unless --skip-catalog is given
+func checkCatalog(cmd *cobra.Command, op *operations.Operation, values operations.Values) error {
+	if skipCatalog || !usesCatalog(op) {
+		return nil
+	}
+	if err := checkCatalogFields(loadCatalog(cmd.Context(), cmd.ErrOrStderr()), op, values); err != nil {
+		return fmt.Errorf("invalid request: %w (use --skip-catalog to send it anyway)", err)
+	}
+	return nil
+}
+
+// checkCatalogFields checks the catalog fields of an operation request
+// against a catalog
+func checkCatalogFields(c *catalog.Catalog, op *operations.Operation, values operations.Values) error {
+	for _, f := range op.Fields {
+		if !f.Catalog {
+			continue
+		}
+		if err := c.Check(f.Name, values[f.Name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usesCatalog reports whether an operation has fields checked against the
+// catalog
+func usesCatalog(op *operations.Operation) bool {
+	for _, f := range op.Fields {
+		if f.Catalog {
+			return true
+		}
+	}
+	return false
+}
+
+// printCatalog prints the catalog in the format of 'gcpctl catalog'
+func printCatalog(w io.Writer, c *catalog.Catalog) {
+	fmt.Fprintf(w, "Source:       %s\n\n", c.Source)
+	fmt.Fprintf(w, "Environments: %s\n", joinOrAny(c.Environments))
+	fmt.Fprintf(w, "Sectors:      %s\n", joinOrAny(c.Sectors))
+
+	if len(c.Regions) == 0 {
+		fmt.Fprintf(w, "Regions:      %s\n", joinOrAny(nil))
+		return
+	}
+
+	width := 0
+	for _, r := range c.Regions {
+		width = max(width, len(r))
+	}
+	fmt.Fprintf(w, "\nRegions (%d):\n", len(c.Regions))
+	for i, r := range c.Regions {
+		if i%catalogColumns == 0 {
+			fmt.Fprint(w, "  ")
+		}
+		if i%catalogColumns == catalogColumns-1 || i == len(c.Regions)-1 {
+			fmt.Fprintln(w, r)
+		} else {
+			fmt.Fprintf(w, "%-*s  ", width, r)
+		}
+	}
+}
+
+// joinOrAny lists values, or "any" for an empty list
+func joinOrAny(values []string) string {
+	if len(values) == 0 {
+		return "any"
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,186 @@
+package gcpctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage configuration profiles",
+	Long: `Manage the profiles of the config file. A profile holds the webhook, API and
+dashboard URLs and the credentials of one management cluster, e.g. one per
+environment, like the contexts of a kubeconfig.
+
+The profile is selected with --profile, GCPCTL_PROFILE or current_profile of
+the config file, in that order.`,
+	// An unknown current profile must not prevent switching to another one
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		err := config.Load(cfgFile, profile)
+		if errors.Is(err, config.ErrUnknownProfile) {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %v\n", err)
+		} else if err != nil {
+			return err
+		}
+		return applyGlobalFlags(cmd)
+	},
+}
+
+// configUseProfileCmd represents the config use-profile command
+var configUseProfileCmd = &cobra.Command{
+	Use:   "use-profile <name>",
+	Short: "Set the current profile in the config file",
+	Example: `  gcpctl config use-profile stage
+  gcpctl config use-profile prod --config ~/ops/gcpctl.yaml`,
+	Args: cobra.ExactArgs(1),
+	RunE: runConfigUseProfile,
+}
+
+// configGetProfilesCmd represents the config get-profiles command
+var configGetProfilesCmd = &cobra.Command{
+	Use:     "get-profiles",
+	Aliases: []string{"profiles"},
+	Short:   "List the profiles of the config file",
+	Args:    cobra.NoArgs,
+	RunE:    runConfigGetProfiles,
+}
+
+// configCurrentProfileCmd represents the config current-profile command
+var configCurrentProfileCmd = &cobra.Command{
+	Use:   "current-profile",
+	Short: "Print the name of the active profile",
+	Args:  cobra.NoArgs,
+	RunE:  runConfigCurrentProfile,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configUseProfileCmd, configGetProfilesCmd, configCurrentProfileCmd)
+}
+
+// redacted replaces secrets in the output of 'config get-profiles'
+const redacted = "REDACTED"
+
+// profileInfo is a profile in the output of 'config get-profiles'
+type profileInfo struct {
+	Name     string         `json:"name"`
+	Current  bool           `json:"current"`
+	Settings map[string]any `json:"settings,omitempty"`
+}
+
+func runConfigUseProfile(cmd *cobra.Command, args []string) error {
+	path, err := config.UseProfile(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Switched to profile %q (%s)\n", strings.ToLower(args[0]), path)
+	return nil
+}
+
+func runConfigGetProfiles(cmd *cobra.Command, args []string) error {
+	current := config.GetProfile()
+
+	var profiles []profileInfo
+	for _, name := range config.Profiles() {
+		settings, err := config.GetProfileSettings(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := settings["webhook_secret"]; ok {
+			settings["webhook_secret"] = redacted
+		}
+		if targets, ok := settings["notify"].([]any); ok {
+			settings["notify"] = redactNotifyURLs(targets)
+		}
+		if sink, ok := settings["events_sink"].(string); ok {
+			settings["events_sink"] = redactURL(sink)
+		}
+		if proxy, ok := settings["proxy"].(string); ok {
+			settings["proxy"] = redactURL(proxy)
+		}
+		if headers, ok := settings["headers"].(map[string]any); ok {
+			settings["headers"] = redactHeaders(headers)
+		}
+		profiles = append(profiles, profileInfo{Name: name, Current: name == current, Settings: settings})
+	}
+
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), profiles)
+	}
+	if len(profiles) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "No profiles found in the config file")
+		return nil
+	}
+	printProfiles(cmd.OutOrStdout(), profiles)
+	return nil
+}
+
+func runConfigCurrentProfile(cmd *cobra.Command, args []string) error {
+	current := config.GetProfile()
+	if current == "" {
+		return errors.New("no profile is set")
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), current)
+	return nil
+}
+
+// printProfiles prints the profiles of 'config get-profiles' as a table, the
+// current one marked with a *
+func printProfiles(w io.Writer, profiles []profileInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CURRENT\tNAME\tTEKTON URL\tTEKTON API URL")
+	for _, p := range profiles {
+		mark := ""
+		if p.Current {
+			mark = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", mark, p.Name, settingOrDash(p.Settings, "tekton_url"), settingOrDash(p.Settings, "tekton_api_url"))
+	}
+	tw.Flush()
+}
+
+// redactNotifyURLs returns notification targets with their URLs redacted,
+// since chat webhook URLs are secrets. The targets of the config are not
+// modified.
+func redactNotifyURLs(targets []any) []any {
+	out := make([]any, 0, len(targets))
+	for _, t := range targets {
+		if m, ok := t.(map[string]any); ok {
+			m = maps.Clone(m)
+			if _, ok := m["url"]; ok {
+				m["url"] = redacted
+			}
+			t = m
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// redactHeaders returns headers with their values redacted, since they
+// usually carry credentials
+func redactHeaders(headers map[string]any) map[string]any {
+	out := make(map[string]any, len(headers))
+	for name := range headers {
+		out[name] = redacted
+	}
+	return out
+}
+
+// settingOrDash returns a setting of a profile, or "-" if the profile does
+// not set it
+func settingOrDash(settings map[string]any, key string) string {
+	value, ok := settings[key]
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,193 @@
+package gcpctl
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var printURL bool
+
+// openCmd represents the open command
+var openCmd = &cobra.Command{
+	Use:   "open [pipeline-run|event-id]",
+	Short: "Open a pipeline run in the Tekton dashboard",
+	Long: `Open a pipeline run in the Tekton dashboard with the default browser. The run
+is given by name or by the event ID returned by the webhook, by default the
+latest submission in the history.
+
+Requires tekton_dashboard_url to be configured.`,
+	Example: `  gcpctl open gcp-region-provision-jf8v5
+  gcpctl open 63950e1f-7ffe-4d14-bc0e-121cee88942e --namespace production
+  gcpctl open gcp-region-provision-jf8v5 --print
+  gcpctl open`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runOpen,
+}
+
+func init() {
+	rootCmd.AddCommand(openCmd)
+
+	openCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline run")
+	openCmd.Flags().BoolVar(&printURL, "print", false, "print the dashboard URL instead of opening it")
+}
+
+func runOpen(cmd *cobra.Command, args []string) error {
+	if config.GetTektonDashboardURL() == "" {
+		return fmt.Errorf("no Tekton dashboard configured, set tekton_dashboard_url or GCPCTL_TEKTON_DASHBOARD_URL")
+	}
+
+	var name string
+	if len(args) == 1 {
+		name = args[0]
+	} else {
+		latest, err := latestSubmission(cmd)
+		if err != nil {
+			return err
+		}
+		name = latest
+	}
+	if isEventID(name) {
+		statusClient, err := newStatusClient()
+		if err != nil {
+			return err
+		}
+		status, err := statusClient.GetPipelineRunsByEventID(cmd.Context(), namespace, name)
+		if err != nil {
+			return fmt.Errorf("failed to find the pipeline run of event %s: %w", name, err)
+		}
+		logVerbose("Event %s triggered pipeline run %s", name, status.Name)
+		name = status.Name
+	}
+
+	link := dashboardURL(namespace, name)
+	if printURL {
+		fmt.Fprintln(cmd.OutOrStdout(), link)
+		return nil
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Opening %s\n", link)
+	return openBrowser(link)
+}
+
+// isEventID reports whether s is an event ID, a UUID, rather than the name of
+// a pipeline run
+func isEventID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// dashboardURL returns the page of a pipeline run in the Tekton dashboard, or
+// "" if no dashboard is configured
+func dashboardURL(namespace, name string) string {
+	dashboard := config.GetTektonDashboardURL()
+	if dashboard == "" || name == "" {
+		return ""
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	return fmt.Sprintf("%s/#/namespaces/%s/pipelineruns/%s",
+		strings.TrimSuffix(dashboard, "/"), url.PathEscape(namespace), url.PathEscape(name))
+}
+
+// openBrowser opens a URL with the default browser of the platform
+func openBrowser(link string) error {
+	var c *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		c = exec.Command("open", link)
+	case "windows":
+		c = exec.Command("rundll32", "url.dll,FileProtocolHandler", link)
+	default:
+		c = exec.Command("xdg-open", link)
+	}
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("failed to open the browser, open %s manually: %w", link, err)
+	}
+	return c.Process.Release()
+}
+
+// linksEnabled reports whether pipeline run names written to w can link to
+// the dashboard: a dashboard is configured and w is a terminal, which renders
+// OSC 8 hyperlinks or ignores them
+func linksEnabled(w io.Writer) bool {
+	if config.GetTektonDashboardURL() == "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(w)
+}
+
+// isTerminal reports whether w is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// hyperlink wraps text in an OSC 8 terminal hyperlink to target
+func hyperlink(target, text string) string {
+	return "\x1b]8;;" + target + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
+
+// runLink returns the name of a pipeline run, as a link to its dashboard page
+// when w supports it
+func runLink(w io.Writer, namespace, name string) string {
+	if !linksEnabled(w) || name == "" {
+		return name
+	}
+	return hyperlink(dashboardURL(namespace, name), name)
+}
+
+// runRef identifies the pipeline run shown in a row of a table
+type runRef struct {
+	namespace string
+	name      string
+}
+
+// writeLinkedTable writes a table rendered by a tabwriter to w, making the
+// pipeline run of every row a link to the dashboard when w supports it. The
+// links are added after rendering since the tabwriter would count their
+// escape sequences as text. runs holds the pipeline run of every row after
+// the header.
+func writeLinkedTable(w io.Writer, table string, runs []runRef) {
+	if !linksEnabled(w) {
+		io.WriteString(w, table)
+		return
+	}
+	rows := strings.SplitAfter(table, "\n")
+	for i, run := range runs {
+		if i+1 >= len(rows) || run.name == "" {
+			continue
+		}
+		rows[i+1] = strings.Replace(rows[i+1], run.name, hyperlink(dashboardURL(run.namespace, run.name), run.name), 1)
+	}
+	io.WriteString(w, strings.Join(rows, ""))
+}
+
+// setDashboardURL links a pipeline run status to its dashboard page in the
+// JSON and YAML output
+func setDashboardURL(status *api.PipelineRunStatus) {
+	status.DashboardURL = dashboardURL(status.Namespace, status.Name)
+}
@@ -0,0 +1,255 @@
+package gcpctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/history"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/version"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// diffFromHistory is the --diff-request value comparing a run with its
+// submission in the history
+const diffFromHistory = "history"
+
+var diffRequest string
+
+// runsDescribeCmd represents the runs describe command
+var runsDescribeCmd = &cobra.Command{
+	Use:   "describe <pipelinerun>",
+	Short: "Show a pipeline run and the parameters it got",
+	Long: `Show a pipeline run with its pipeline, bundle version, event ID and parameters.
+
+With --diff-request, the run is compared with the request that started it,
+to find values the event listener or the trigger template rewrote or
+defaulted. --diff-request history reads the request from the submission of
+the same event ID in the history; otherwise it is a YAML or JSON file of a
+submission, like an entry of 'gcpctl history -o json':
+
+  operation: region add
+  request:
+    environment: production
+    region: us-central1
+    sector: main
+  params:
+    machine_cidr: 10.0.0.0/16
+
+Without operation, the operation is the one of the pipeline of the run, if
+only one operation starts it. The command fails if a requested parameter has
+another value or is missing, if the run is of another pipeline than the one
+of the operation, or if this gcpctl does not work with the bundle version of
+the run. Parameters the request did not set are listed as defaulted.`,
+	Example: `  gcpctl runs describe gcp-region-provision-jf8v5
+  gcpctl runs describe gcp-region-provision-jf8v5 --diff-request history
+  gcpctl runs describe gcp-region-provision-jf8v5 --diff-request request.yaml -o json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRunsDescribe,
+}
+
+func init() {
+	runsCmd.AddCommand(runsDescribeCmd)
+
+	runsDescribeCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline run")
+	runsDescribeCmd.Flags().StringVar(&diffRequest, "diff-request", "", `compare the run with a request file, or "history" for its submission in the history`)
+}
+
+func runRunsDescribe(cmd *cobra.Command, args []string) error {
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+
+	obj, err := statusClient.GetPipelineRunObject(cmd.Context(), namespace, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to get pipeline run: %w", err)
+	}
+	run, err := client.PipelineRunFromObject(obj)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	desc := client.DescribeRun(run, now)
+	if diffRequest != "" {
+		if desc.Diff, err = diffRun(run, diffRequest); err != nil {
+			return err
+		}
+	}
+
+	if structuredOutput() {
+		if err := printStructured(cmd.OutOrStdout(), desc); err != nil {
+			return err
+		}
+	} else {
+		printDescription(cmd.OutOrStdout(), desc, now)
+	}
+	if desc.Diff != nil && desc.Diff.Drift > 0 {
+		return fmt.Errorf("pipeline run %s drifted from the request: %d differences", desc.Name, desc.Diff.Drift)
+	}
+	return nil
+}
+
+// diffRun compares a run with the request read from source, a file or
+// diffFromHistory
+func diffRun(run *client.TektonPipelineRun, source string) (*api.RunDiff, error) {
+	entry, err := readDiffRequest(run, source)
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := diffOperation(run, entry.Operation)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := op.DiffRun(entry.Request, entry.Params, run)
+	if err != nil {
+		return nil, err
+	}
+	diff.Source = source
+
+	if bundle := run.Metadata.Labels[client.BundleVersionLabel]; bundle != "" {
+		warnings, err := version.DefaultMatrix().Check(version.Get().Version, bundle)
+		if err != nil {
+			logVerbose("Skipping the compatibility check: %v", err)
+		}
+		diff.VersionWarnings = warnings
+		diff.Drift += len(warnings)
+	}
+	return diff, nil
+}
+
+// readDiffRequest returns the submission of a run in the history, or the
+// request of a file
+func readDiffRequest(run *client.TektonPipelineRun, source string) (*history.Entry, error) {
+	if source != diffFromHistory {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request: %w", err)
+		}
+		var entry history.Entry
+		if err := yaml.UnmarshalStrict(data, &entry); err != nil {
+			return nil, fmt.Errorf("invalid request file %s: %w", source, err)
+		}
+		return &entry, nil
+	}
+
+	eventID := run.Metadata.Labels[client.EventIDLabel]
+	if eventID == "" {
+		return nil, fmt.Errorf("pipeline run %s was not started by an event, give a request file", run.Metadata.Name)
+	}
+	l := ledger()
+	if l == nil {
+		return nil, errors.New("the history is disabled, give a request file")
+	}
+	entries, err := l.List(history.Filter{AnyProfile: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the history: %w", err)
+	}
+	for _, e := range entries {
+		if e.EventID == eventID {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("event %s of pipeline run %s is not in the history, give a request file", eventID, run.Metadata.Name)
+}
+
+// diffOperation returns the operation of a request, or the only operation
+// starting the pipeline of the run if the request names none
+func diffOperation(run *client.TektonPipelineRun, name string) (*operations.Operation, error) {
+	if name != "" {
+		op, ok := operations.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %q, see 'gcpctl operations'", name)
+		}
+		return op, nil
+	}
+
+	var candidates []*operations.Operation
+	var names []string
+	for _, op := range operations.All() {
+		if op.Pipeline == run.Pipeline() {
+			candidates = append(candidates, op)
+			names = append(names, op.Name())
+		}
+	}
+	switch len(candidates) {
+	case 1:
+		return candidates[0], nil
+	case 0:
+		return nil, fmt.Errorf("no operation starts pipeline %s, set the operation of the request", run.Pipeline())
+	default:
+		return nil, fmt.Errorf("pipeline %s is started by %s, set the operation of the request", run.Pipeline(), strings.Join(names, " and "))
+	}
+}
+
+// printDescription prints a pipeline run and, with --diff-request, how it
+// differs from the request
+func printDescription(w io.Writer, desc *api.RunDescription, now time.Time) {
+	fmt.Fprintf(w, "Pipeline Run: %s\n", runLink(w, desc.Namespace, desc.Name))
+	fmt.Fprintf(w, "Namespace:    %s\n", desc.Namespace)
+	fmt.Fprintf(w, "Pipeline:     %s\n", orDash(desc.Pipeline))
+	fmt.Fprintf(w, "Bundle:       %s\n", orDash(desc.BundleVersion))
+	fmt.Fprintf(w, "Event ID:     %s\n", orDash(desc.EventID))
+	fmt.Fprintf(w, "Status:       %s %s\n", client.GetStatusEmoji(desc.Status), desc.Status)
+	if start, err := time.Parse(time.RFC3339, desc.StartTime); err == nil {
+		fmt.Fprintf(w, "Started:      %s ago\n", client.FormatDuration(now.Sub(start)))
+		fmt.Fprintf(w, "Duration:     %s\n", client.FormatDuration(time.Duration(desc.DurationSeconds)*time.Second))
+	}
+
+	if desc.Diff == nil {
+		if len(desc.Params) > 0 {
+			names := make([]string, 0, len(desc.Params))
+			for name := range desc.Params {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintln(w, "Params:")
+			for _, name := range names {
+				fmt.Fprintf(w, "  %s: %s\n", name, desc.Params[name])
+			}
+		}
+		return
+	}
+
+	diff := desc.Diff
+	fmt.Fprintf(w, "\nRequest: %s (%s)\n\n", diff.Operation, diff.Source)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PARAM\tREQUESTED\tAPPLIED\tSTATUS")
+	if desc.Pipeline != diff.ExpectedPipeline {
+		fmt.Fprintf(tw, "(pipeline)\t%s\t%s\t✗ %s\n", diff.ExpectedPipeline, orDash(desc.Pipeline), api.ParamChanged)
+	}
+	for _, p := range diff.Params {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Name, orDash(p.Requested), orDash(p.Applied), paramOutcome(p.Status))
+	}
+	tw.Flush()
+
+	for _, warning := range diff.VersionWarnings {
+		fmt.Fprintf(w, "\n✗ Bundle %s does not work with this gcpctl: %s\n", desc.BundleVersion, warning)
+	}
+	if diff.Drift == 0 {
+		fmt.Fprintln(w, "\n✓ The run has the requested parameters")
+	}
+}
+
+// paramOutcome marks the statuses of a RunDiff that are drift
+func paramOutcome(status string) string {
+	switch status {
+	case api.ParamChanged, api.ParamMissing:
+		return "✗ " + status
+	case api.ParamMatch:
+		return "✓ " + status
+	default:
+		return status
+	}
+}
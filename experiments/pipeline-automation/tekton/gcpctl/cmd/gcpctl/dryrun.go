@@ -0,0 +1,95 @@
+package gcpctl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var dryRun bool
+
+// runDryRun prints the webhook requests of validated operation requests
+// instead of sending them: method, URL, headers and the exact body, signed
+// like a real submission. The values of the headers of the config file are
+// redacted. A single request is printed as an object in JSON
+// and YAML, the requests of --file as a list. params are the extra pipeline
+// parameters of every request.
+func runDryRun(cmd *cobra.Command, op *operations.Operation, requests []operations.Values, params operations.Params) error {
+	tektonClient, err := newTektonClient()
+	if err != nil {
+		return err
+	}
+
+	prepared := make([]*api.WebhookRequest, 0, len(requests))
+	for _, v := range requests {
+		req, err := prepareWebhookRequest(tektonClient, op, v, params)
+		if err != nil {
+			return err
+		}
+		prepared = append(prepared, req)
+	}
+
+	if structuredOutput() {
+		if requestsFile == "" {
+			return printStructured(cmd.OutOrStdout(), prepared[0])
+		}
+		return printStructured(cmd.OutOrStdout(), prepared)
+	}
+	for i, req := range prepared {
+		if i > 0 {
+			fmt.Fprintln(cmd.OutOrStdout())
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "# %s %s\n", op.Name(), op.Describe(requests[i]))
+		printWebhookRequest(cmd.OutOrStdout(), req)
+	}
+	fmt.Fprintln(cmd.ErrOrStderr(), "Dry run: nothing was sent")
+	return nil
+}
+
+// prepareWebhookRequest returns the webhook request of an operation request
+// as it would be sent, with the headers of the config file redacted
+func prepareWebhookRequest(tektonClient *client.TektonClient, op *operations.Operation, v operations.Values, params operations.Params) (*api.WebhookRequest, error) {
+	payload, err := op.BuildPayloadWithParams(v, params)
+	if err != nil {
+		return nil, err
+	}
+	req, err := tektonClient.Prepare(op.Route, payload)
+	if err != nil {
+		return nil, err
+	}
+	redactCustomHeaders(req)
+	return req, nil
+}
+
+// redactCustomHeaders redacts the values of the headers of the config file in
+// a request, since they usually carry credentials
+func redactCustomHeaders(req *api.WebhookRequest) {
+	for name := range config.GetHeaders() {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := req.Headers[name]; ok {
+			req.Headers[name] = redacted
+		}
+	}
+}
+
+// printWebhookRequest prints a webhook request like an HTTP message, headers
+// sorted by name
+func printWebhookRequest(w io.Writer, req *api.WebhookRequest) {
+	fmt.Fprintf(w, "%s %s\n", req.Method, req.URL)
+	names := make([]string, 0, len(req.Headers))
+	for name := range req.Headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %s\n", name, req.Headers[name])
+	}
+	fmt.Fprintf(w, "\n%s\n", req.Body)
+}
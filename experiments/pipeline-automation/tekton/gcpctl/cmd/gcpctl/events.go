@@ -0,0 +1,82 @@
+package gcpctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/events"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+var (
+	noEvents bool
+	// publisher sends the events of the command to the sink of the profile,
+	// nil if none is configured; set by checkEvents
+	publisher *events.Publisher
+)
+
+// checkEvents validates the events sink of the profile before a request is
+// sent, and sets up the publisher of its events. Without a sink or with
+// --no-events nothing is published.
+func checkEvents() error {
+	publisher = nil
+	sink := config.GetEventsSink()
+	if noEvents || sink == "" {
+		return nil
+	}
+	hc, err := httpClient()
+	if err != nil {
+		return err
+	}
+	publisher, err = events.NewPublisher(sink, config.GetTektonURL(), hc)
+	return err
+}
+
+// publishEvent sends an event about a request the webhook accepted. run is
+// the pipeline run of the request, if it was seen. A failure only prints a
+// warning, as the request was sent.
+func publishEvent(ctx context.Context, errOut io.Writer, typ string, op *operations.Operation, values operations.Values, resp *api.TektonResponse, run *api.PipelineRunStatus) {
+	if publisher == nil {
+		return
+	}
+
+	data := events.Data{Operation: op.Name(), Request: values, Profile: config.GetProfile()}
+	if resp != nil {
+		data.EventID = resp.EventID
+		data.Namespace = resp.Namespace
+	}
+	if run != nil {
+		setDashboardURL(run)
+		data.SetRun(run)
+		if run.IsDone() {
+			data.State = op.DescribeState(values, run)
+		}
+	}
+
+	logVerbose("Publishing %s for %s", typ, op.Describe(values))
+	e := events.New(typ, publisher.Source, op.Describe(values), data, time.Now())
+	if err := publisher.Publish(ctx, e); err != nil {
+		fmt.Fprintf(errOut, "Warning: failed to publish an event for %s: %v\n", op.Describe(values), err)
+	}
+}
+
+// runEvents returns the FollowPipelineRun callback publishing when the
+// pipeline run of a request is first seen and when it finished, nil without
+// an events sink
+func runEvents(ctx context.Context, errOut io.Writer, op *operations.Operation, values operations.Values, resp *api.TektonResponse) func(prev, cur *api.PipelineRunStatus) {
+	if publisher == nil {
+		return nil
+	}
+	return func(prev, cur *api.PipelineRunStatus) {
+		if prev == nil {
+			publishEvent(ctx, errOut, events.TypeRunStarted, op, values, resp, cur)
+		}
+		if cur.IsDone() {
+			publishEvent(ctx, errOut, events.TypeRunFinished, op, values, resp, cur)
+		}
+	}
+}
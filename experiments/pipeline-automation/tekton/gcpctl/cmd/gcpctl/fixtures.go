@@ -0,0 +1,76 @@
+package gcpctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/vcr"
+	"github.com/spf13/cobra"
+)
+
+// replayAPIURL is the Tekton API URL of --replay-fixtures without a
+// configured one; the recorder never dials it
+const replayAPIURL = "http://tekton.vcr.invalid"
+
+var (
+	recordFixtures string
+	replayFixtures string
+
+	// fixtures records or replays the requests of the Tekton API backend,
+	// nil without --record-fixtures and --replay-fixtures
+	fixtures *vcr.Recorder
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&recordFixtures, "record-fixtures", "", "record the Tekton API requests of the command and their responses to this cassette file, e.g. to add a test fixture (implies --backend api)")
+	rootCmd.PersistentFlags().StringVar(&replayFixtures, "replay-fixtures", "", "answer the Tekton API requests of the command from this cassette file instead of the cluster (implies --backend api)")
+	rootCmd.MarkFlagsMutuallyExclusive("record-fixtures", "replay-fixtures")
+}
+
+// setupFixtures creates the recorder of --record-fixtures or
+// --replay-fixtures. Both only apply to the Tekton API backend, which they
+// select.
+func setupFixtures(cmd *cobra.Command) error {
+	path, mode := recordFixtures, vcr.ModeRecord
+	if replayFixtures != "" {
+		path, mode = replayFixtures, vcr.ModeReplay
+	}
+	if path == "" {
+		return nil
+	}
+
+	if cmd.Flags().Changed("backend") && backend != client.BackendAPI {
+		return fmt.Errorf("--record-fixtures and --replay-fixtures need --backend %s, not %s", client.BackendAPI, backend)
+	}
+	config.SetBackend(client.BackendAPI)
+	if mode == vcr.ModeRecord && config.GetTektonAPIURL() == "" {
+		return fmt.Errorf("--record-fixtures needs a Tekton API URL (tekton_api_url), e.g. of kubectl proxy")
+	}
+	if mode == vcr.ModeReplay && config.GetTektonAPIURL() == "" {
+		config.SetTektonAPIURL(replayAPIURL)
+	}
+
+	rec, err := vcr.New(path, mode)
+	if err != nil {
+		return err
+	}
+	fixtures = rec
+	logVerbose("Tekton API fixtures: %s, %s mode", path, mode)
+	return nil
+}
+
+// saveFixtures writes the interactions recorded with --record-fixtures, also
+// when the command failed, as its error responses are fixtures too
+func saveFixtures() {
+	if fixtures == nil || fixtures.Mode() != vcr.ModeRecord {
+		return
+	}
+	if err := fixtures.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Recorded %d Tekton API interactions to %s, review them before committing: they hold the raw API payloads\n",
+		fixtures.Len(), recordFixtures)
+}
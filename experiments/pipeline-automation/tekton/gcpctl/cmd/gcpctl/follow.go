@@ -0,0 +1,94 @@
+package gcpctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+const clockLayout = "15:04:05"
+
+var (
+	waitTimeout  time.Duration
+	pollInterval time.Duration
+)
+
+// addFollowFlags adds the flags controlling how long and how often a pipeline run is polled
+func addFollowFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&waitTimeout, "wait-timeout", 30*time.Minute, "how long to wait for the pipeline run to finish")
+	cmd.Flags().DurationVar(&pollInterval, "poll-interval", client.DefaultPollInterval, "delay between two status queries")
+}
+
+// followPipelineRun streams the progress of the pipeline run of an event to
+// the progress writer until it finishes, and returns its final status. With
+// several namespaces, every poll looks for the run in all of them. onUpdate,
+// if set, is called after every poll as well.
+func followPipelineRun(cmd *cobra.Command, namespaces []string, eventID string, onUpdate func(prev, cur *api.PipelineRunStatus)) (*api.PipelineRunStatus, error) {
+	ctx, cancel := context.WithTimeout(cmd.Context(), waitTimeout)
+	defer cancel()
+
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return nil, err
+	}
+	var getter client.EventStatusGetter = statusClient
+	if len(namespaces) > 1 {
+		getter = &client.FanOutGetter{Getter: statusClient, Namespaces: namespaces}
+	}
+
+	w := progressWriter(cmd)
+	fmt.Fprintf(w, "Waiting for the pipeline run of event %s (timeout %s)...\n", eventID, waitTimeout)
+
+	final, err := client.FollowPipelineRun(ctx, getter, namespaces[0], eventID, pollInterval,
+		func(prev, cur *api.PipelineRunStatus) {
+			printTransitions(w, prev, cur, time.Now())
+			if onUpdate != nil {
+				onUpdate(prev, cur)
+			}
+		})
+	if final != nil {
+		setDashboardURL(final)
+	}
+	return final, err
+}
+
+// pipelineRunError returns an error unless the pipeline run succeeded, so the
+// command exits non-zero
+func pipelineRunError(final *api.PipelineRunStatus) error {
+	if final.Status == "Succeeded" {
+		return nil
+	}
+	if final.Message != "" {
+		return fmt.Errorf("pipeline run %s %s: %s", final.Name, strings.ToLower(final.Status), final.Message)
+	}
+	return fmt.Errorf("pipeline run %s %s", final.Name, strings.ToLower(final.Status))
+}
+
+// printTransitions prints the pipeline and task status changes between two polls
+func printTransitions(w io.Writer, prev, cur *api.PipelineRunStatus, now time.Time) {
+	stamp := now.Format(clockLayout)
+
+	if prev == nil {
+		fmt.Fprintf(w, "[%s] Pipeline run %s (namespace %s)\n", stamp, runLink(w, cur.Namespace, cur.Name), cur.Namespace)
+	}
+	for _, t := range client.DiffTasks(prev, cur) {
+		line := fmt.Sprintf("[%s]   %s %s %s", stamp, client.GetStatusEmoji(t.To), t.Task, t.To)
+		if t.Duration != "" {
+			line += fmt.Sprintf(" (%s)", t.Duration)
+		}
+		fmt.Fprintln(w, line)
+	}
+	if prev == nil || prev.Status != cur.Status {
+		line := fmt.Sprintf("[%s] %s %s", stamp, client.GetStatusEmoji(cur.Status), cur.Status)
+		if cur.IsDone() {
+			line += fmt.Sprintf(" (took %s)", client.CalculateDuration(cur.StartTime, cur.CompletionTime))
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,154 @@
+package gcpctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/history"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyLimit       int
+	historyOperation   string
+	historyAllProfiles bool
+	historyClear       bool
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the requests submitted from this machine",
+	Long: `List the requests gcpctl submitted and the webhook accepted, newest first,
+with their event IDs. Only submissions of the active profile are listed
+unless --all-profiles is given.
+
+Submissions are recorded in ~/.gcpctl/history.jsonl, see history_file. 'gcpctl
+status' without an event ID shows the latest one.`,
+	Example: `  gcpctl history
+  gcpctl history --operation "region add" --limit 5
+  gcpctl history --all-profiles -o json
+  gcpctl history --clear`,
+	Args: cobra.NoArgs,
+	RunE: runHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "l", 20, "number of submissions to list, 0 for all")
+	historyCmd.Flags().StringVar(&historyOperation, "operation", "", `only list submissions of this operation, e.g. "region add"`)
+	historyCmd.Flags().BoolVar(&historyAllProfiles, "all-profiles", false, "list the submissions of every profile")
+	historyCmd.Flags().BoolVar(&historyClear, "clear", false, "remove every submission from the history")
+}
+
+// ledger returns the history of submissions, nil if it is disabled
+func ledger() *history.Ledger {
+	path := config.GetHistoryFile()
+	if path == "" {
+		return nil
+	}
+	return history.Open(path)
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	l := ledger()
+	if l == nil {
+		return errors.New("the history is disabled, set history: true in the config file")
+	}
+
+	if historyClear {
+		if err := l.Clear(); err != nil {
+			return fmt.Errorf("failed to clear the history: %w", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Cleared %s\n", l.Path())
+		return nil
+	}
+
+	entries, err := l.List(history.Filter{
+		Profile:    config.GetProfile(),
+		AnyProfile: historyAllProfiles,
+		Operation:  historyOperation,
+		Limit:      historyLimit,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read the history: %w", err)
+	}
+
+	if structuredOutput() {
+		if entries == nil {
+			entries = []history.Entry{}
+		}
+		return printStructured(cmd.OutOrStdout(), entries)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "No submissions found")
+		return nil
+	}
+	printHistory(cmd.OutOrStdout(), entries, time.Now())
+	return nil
+}
+
+// printHistory prints submissions as a table
+func printHistory(w io.Writer, entries []history.Entry, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SUBMITTED\tPROFILE\tOPERATION\tREQUEST\tEVENT ID\tNAMESPACE")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s ago\t%s\t%s\t%s\t%s\t%s\n",
+			client.FormatDuration(now.Sub(e.Time)), orDash(e.Profile), e.Operation, orDash(e.Subject), orDash(e.EventID), orDash(e.Namespace))
+	}
+	tw.Flush()
+}
+
+// recordSubmission adds a request the webhook accepted to the history. A
+// failure only prints a warning, as the request was sent.
+func recordSubmission(l *history.Ledger, errOut io.Writer, op *operations.Operation, values operations.Values, params operations.Params, resp *api.TektonResponse) {
+	if l == nil {
+		return
+	}
+	err := l.Append(history.Entry{
+		Time:          time.Now().UTC(),
+		Operation:     op.Name(),
+		Subject:       op.Describe(values),
+		Request:       values,
+		Params:        params,
+		Profile:       config.GetProfile(),
+		EventID:       resp.EventID,
+		Namespace:     resp.Namespace,
+		EventListener: resp.EventListener,
+	})
+	if err != nil {
+		fmt.Fprintf(errOut, "Warning: failed to record the submission in the history: %v\n", err)
+	}
+}
+
+// latestSubmission returns the event ID of the newest submission of the
+// active profile, for commands given no event ID, and sets the namespace to
+// the one of the submission unless --namespace is given
+func latestSubmission(cmd *cobra.Command) (string, error) {
+	l := ledger()
+	if l == nil {
+		return "", errors.New("an event ID is required when the history is disabled")
+	}
+	e, err := l.Latest(history.Filter{Profile: config.GetProfile()})
+	if errors.Is(err, history.ErrNoEntries) {
+		return "", errors.New("no submission with an event ID in the history, give the event ID")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the history: %w", err)
+	}
+
+	if e.Namespace != "" && !cmd.Flags().Changed("namespace") {
+		namespace = e.Namespace
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Latest submission: %s %s, event %s (%s ago)\n",
+		e.Operation, e.Subject, e.EventID, client.FormatDuration(time.Since(e.Time)))
+	return e.EventID, nil
+}
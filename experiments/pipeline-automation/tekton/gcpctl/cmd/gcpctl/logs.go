@@ -0,0 +1,62 @@
+package gcpctl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var logsTask string
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs <pipelinerun>",
+	Short: "Print the logs of a pipeline run",
+	Long: `Print the logs of every step of a pipeline run, task by task in the order
+they started.
+
+With --follow, logs are streamed as they are written and tasks that have not
+started yet are waited for, until the pipeline run finishes or --wait-timeout
+expires. The pipeline run name is shown by 'gcpctl region status'.`,
+	Example: `  gcpctl logs gcp-region-provision-jf8v5
+  gcpctl logs gcp-region-provision-jf8v5 --task terraform-apply
+  gcpctl logs gcp-region-provision-jf8v5 --follow`,
+	Args: cobra.ExactArgs(1),
+	RunE: runLogs,
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline run")
+	logsCmd.Flags().StringVarP(&logsTask, "task", "t", "", "only print the logs of this pipeline task")
+	logsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "stream logs until the pipeline run finishes")
+	addFollowFlags(logsCmd)
+}
+
+func runLogs(cmd *cobra.Command, args []string) error {
+	if structuredOutput() {
+		return fmt.Errorf("logs prints plain text, --output %s is not supported", config.GetOutput())
+	}
+
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	if follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitTimeout)
+		defer cancel()
+	}
+
+	return client.StreamPipelineRunLogs(ctx, statusClient, namespace, args[0], client.LogOptions{
+		Task:     logsTask,
+		Follow:   follow,
+		Interval: pollInterval,
+	}, cmd.OutOrStdout())
+}
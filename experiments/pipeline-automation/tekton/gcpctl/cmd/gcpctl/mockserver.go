@@ -0,0 +1,149 @@
+package gcpctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/mockserver"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+// mockShutdownTimeout bounds the requests still served when the mock server stops
+const mockShutdownTimeout = 5 * time.Second
+
+var (
+	mockAddr          string
+	mockScript        string
+	mockNamespace     string
+	mockAPIVersion    string
+	mockWebhookSecret string
+	mockDeny          []string
+)
+
+// mockServerCmd represents the mock-server command
+var mockServerCmd = &cobra.Command{
+	Use:   "mock-server",
+	Short: "Serve a fake Tekton webhook and API for demos and tests",
+	Long: `Serve a fake Tekton event listener and Tekton API on one address, so
+gcpctl can be demoed and tested without a cluster.
+
+Requests posted to the event listener create pipeline runs of the pipeline of
+their operation, with the fields of the request as parameters. The runs wait,
+then run their tasks one after the other, as scripted. The Tekton API serves
+them, their TaskRuns and the logs of their tasks, so status, --wait, logs,
+runs list, runs watch, runs retry and runs prune work as against a cluster.
+Access reviews allow every action but those of --deny, for 'gcpctl preflight'.
+
+Without --script, runs succeed after about 15 seconds, except region
+deletions in the test sector, which fail. A script lists scenarios, each
+matching runs by parameter value; runs matching none follow the last one:
+
+  bundleVersion: 1.4.0
+  scenarios:
+    - name: slow-apply
+      match: {region: europe-west1}
+      pending: 5s
+      tasks:
+        - {name: validate, duration: 5s}
+        - {name: terraform-apply, duration: 2m, logs: [Applying, Done]}
+    - name: broken
+      tasks:
+        - {name: terraform-apply, duration: 10s, fail: true}
+
+The pipeline runs are kept in memory until the server stops.`,
+	Example: `  gcpctl mock-server
+  gcpctl mock-server --addr 127.0.0.1:9090 --script demo.yaml
+
+  # In another shell
+  export GCPCTL_TEKTON_URL=http://127.0.0.1:8080
+  export GCPCTL_TEKTON_API_URL=http://127.0.0.1:8080
+  export GCPCTL_BACKEND=api
+  gcpctl region add -e dev -s main -r us-central1 --wait`,
+	Args: cobra.NoArgs,
+	RunE: runMockServer,
+}
+
+func init() {
+	rootCmd.AddCommand(mockServerCmd)
+
+	mockServerCmd.Flags().StringVar(&mockAddr, "addr", "127.0.0.1:8080", "address to listen on")
+	mockServerCmd.Flags().StringVar(&mockScript, "script", "", "YAML or JSON file scripting the pipeline runs (default: runs succeed, region deletions in the test sector fail)")
+	mockServerCmd.Flags().StringVarP(&mockNamespace, "namespace", "n", mockserver.DefaultNamespace, "namespace of the event listener and its pipeline runs")
+	mockServerCmd.Flags().StringVar(&mockAPIVersion, "api-version", "v1", "tekton.dev version to serve: v1 or v1beta1")
+	mockServerCmd.Flags().StringVar(&mockWebhookSecret, "webhook-secret", "", "reject payloads not signed with this secret")
+	mockServerCmd.Flags().StringArrayVar(&mockDeny, "deny", nil, `action the access reviews of 'gcpctl preflight' deny, e.g. "delete pipelineruns"; repeatable`)
+}
+
+func runMockServer(cmd *cobra.Command, args []string) error {
+	opts := mockserver.Options{
+		Namespace:     mockNamespace,
+		APIVersion:    mockAPIVersion,
+		WebhookSecret: mockWebhookSecret,
+		Denied:        mockDeny,
+		Pipelines:     operationPipelines(),
+		Log:           cmd.OutOrStdout(),
+	}
+	if mockScript != "" {
+		script, err := mockserver.LoadScript(mockScript)
+		if err != nil {
+			return err
+		}
+		opts.Script = script
+	}
+	handler, err := mockserver.New(opts)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", mockAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", mockAddr, err)
+	}
+	url := "http://" + listener.Addr().String()
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Mock Tekton event listener and API listening on %s\n", url)
+	fmt.Fprintln(out, "Point gcpctl at it with:")
+	fmt.Fprintf(out, "  export GCPCTL_TEKTON_URL=%s\n", url)
+	fmt.Fprintf(out, "  export GCPCTL_TEKTON_API_URL=%s\n", url)
+	fmt.Fprintln(out, "  export GCPCTL_BACKEND=api")
+	fmt.Fprintln(out, "Press Ctrl-C to stop.")
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), mockShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("mock server failed: %w", err)
+	}
+	return nil
+}
+
+// operationPipelines returns the pipeline of every webhook route of the
+// registered operations
+func operationPipelines() map[string]string {
+	pipelines := make(map[string]string)
+	for _, op := range operations.All() {
+		route := strings.Trim(op.Route, "/")
+		if _, ok := pipelines[route]; !ok {
+			pipelines[route] = op.Pipeline
+		}
+	}
+	return pipelines
+}
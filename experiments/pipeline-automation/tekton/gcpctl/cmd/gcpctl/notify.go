@@ -0,0 +1,59 @@
+package gcpctl
+
+import (
+	"context"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/notify"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+var noNotify bool
+
+// notifyTargets returns the notification targets of the profile, none with
+// --no-notify
+func notifyTargets() []notify.Target {
+	if noNotify {
+		return nil
+	}
+	var targets []notify.Target
+	for _, t := range config.GetNotify() {
+		targets = append(targets, notify.Target{Type: t.Type, URL: t.URL})
+	}
+	return targets
+}
+
+// checkNotify validates the notification targets before an operation is
+// triggered with --wait, so a typo is not found only after the pipeline ran
+func checkNotify() error {
+	if !wait {
+		return nil
+	}
+	return notify.Validate(notifyTargets())
+}
+
+// notifyCompletion posts the outcome of a pipeline run followed with --wait
+// to the notification targets of the profile. run is the last status seen,
+// waitErr why the wait ended before the run finished, if it did.
+func notifyCompletion(ctx context.Context, op *operations.Operation, values operations.Values, run *api.PipelineRunStatus, waitErr error) error {
+	targets := notifyTargets()
+	if len(targets) == 0 {
+		return nil
+	}
+
+	state := ""
+	if run != nil && run.IsDone() {
+		state = op.DescribeState(values, run)
+	}
+	event := notify.NewEvent(op.Name(), values, run, state, waitErr)
+	event.Subject = op.Describe(values)
+	event.Profile = config.GetProfile()
+
+	hc, err := httpClient()
+	if err != nil {
+		return err
+	}
+	logVerbose("Posting the outcome of %s to %d notification targets", op.Name(), len(targets))
+	return notify.Send(ctx, hc, targets, event)
+}
@@ -0,0 +1,333 @@
+package gcpctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/events"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+var (
+	paramArgs  []string
+	paramsFile string
+)
+
+// commandExtensions add flags to the command of an operation, by operation
+// name, and may wrap how it runs, e.g. 'sector add --regions'
+var commandExtensions = map[string]func(cmd *cobra.Command, op *operations.Operation){}
+
+// operationsCmd lists the registered operations
+var operationsCmd = &cobra.Command{
+	Use:     "operations",
+	Aliases: []string{"ops"},
+	Short:   "List the pipeline-backed operations gcpctl can trigger",
+	Long: `List the operations gcpctl can trigger, with the webhook route their payload
+is posted to, the pipeline they start and their fields.
+
+Every operation is a command, e.g. 'gcpctl region add'. Operations are
+registered in the internal/operations package.`,
+	Args: cobra.NoArgs,
+	RunE: runOperations,
+}
+
+func init() {
+	rootCmd.AddCommand(operationsCmd)
+}
+
+// operationInfo is an operation in the output of 'gcpctl operations'
+type operationInfo struct {
+	Name     string      `json:"name"`
+	Route    string      `json:"route"`
+	Pipeline string      `json:"pipeline"`
+	Fields   []fieldInfo `json:"fields"`
+	// Params are the extra parameters the pipeline accepts, see --param
+	Params []fieldInfo `json:"params,omitempty"`
+}
+
+type fieldInfo struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Allowed  []string `json:"allowed,omitempty"`
+}
+
+func runOperations(cmd *cobra.Command, args []string) error {
+	var infos []operationInfo
+	for _, op := range operations.All() {
+		info := operationInfo{Name: op.Name(), Route: "/" + op.Route, Pipeline: op.Pipeline}
+		for _, f := range op.Fields {
+			typ := f.Type
+			if typ == "" {
+				typ = operations.TypeString
+			}
+			info.Fields = append(info.Fields, fieldInfo{Name: f.Name, Type: typ, Required: f.Required, Allowed: f.Allowed})
+		}
+		if schema, ok := op.ParamSchema(); ok {
+			for _, name := range schema.Names() {
+				p := schema.Properties[name]
+				var allowed []string
+				for _, e := range p.Enum {
+					allowed = append(allowed, fmt.Sprint(e))
+				}
+				info.Params = append(info.Params, fieldInfo{
+					Name: name, Type: p.Type, Required: slices.Contains(schema.Required, name), Allowed: allowed,
+				})
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), infos)
+	}
+	printOperations(cmd.OutOrStdout(), infos)
+	return nil
+}
+
+// printOperations prints the operations of 'gcpctl operations' as a table;
+// required fields and parameters are marked with a *
+func printOperations(w io.Writer, infos []operationInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tROUTE\tPIPELINE\tFIELDS\tPARAMS")
+	for _, info := range infos {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", info.Name, info.Route, info.Pipeline,
+			fieldNames(info.Fields), orDash(fieldNames(info.Params)))
+	}
+	tw.Flush()
+}
+
+// fieldNames joins the names of fields, marking required ones with a *
+func fieldNames(fields []fieldInfo) string {
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Required {
+			names = append(names, f.Name+"*")
+		} else {
+			names = append(names, f.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// addOperationCommands adds a command for every registered operation under
+// the command of its group, creating the group command if there is none
+func addOperationCommands() {
+	for _, op := range operations.All() {
+		var group *cobra.Command
+		for _, c := range rootCmd.Commands() {
+			if c.Name() == op.Group {
+				group = c
+				break
+			}
+		}
+		if group == nil {
+			group = &cobra.Command{
+				Use:   op.Group,
+				Short: fmt.Sprintf("Manage %ss", op.Group),
+			}
+			rootCmd.AddCommand(group)
+		}
+		group.AddCommand(newOperationCommand(op))
+	}
+}
+
+// newOperationCommand builds the command of an operation, with a flag for
+// every field, --file to submit a list of requests and --dry-run to print
+// them instead
+func newOperationCommand(op *operations.Operation) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     op.Verb,
+		Short:   op.Short,
+		Long:    op.Long,
+		Example: op.Example,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if requestsFile != "" {
+				return runBulkOperation(cmd, op, requestsFile)
+			}
+			if err := checkRequiredFlags(cmd, op); err != nil {
+				return err
+			}
+			return runOperation(cmd, op, operationValues(cmd, op))
+		},
+	}
+
+	for _, f := range op.Fields {
+		usage := f.Description
+		if len(f.Allowed) > 0 {
+			usage += ": " + strings.Join(f.Allowed, ", ")
+		}
+		if f.Required {
+			usage += " (required)"
+		}
+
+		switch f.Type {
+		case operations.TypeInt:
+			def, _ := strconv.Atoi(f.Default)
+			cmd.Flags().IntP(f.Name, f.Shorthand, def, usage)
+		default:
+			cmd.Flags().StringP(f.Name, f.Shorthand, f.Default, usage)
+		}
+	}
+
+	if schema, ok := op.ParamSchema(); ok {
+		cmd.Flags().StringArrayVar(&paramArgs, "param", nil, "extra pipeline parameter as key=value, repeatable: "+strings.Join(schema.Names(), ", "))
+		cmd.Flags().StringVar(&paramsFile, "params-file", "", "YAML or JSON map of extra pipeline parameters; --param overrides its values")
+	}
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "webhook request timeout")
+	cmd.Flags().BoolVar(&wait, "wait", false, "wait for the pipeline run to finish, exiting non-zero if it fails")
+	addFollowFlags(cmd)
+	cmd.Flags().BoolVar(&noNotify, "no-notify", false, "do not post the outcome of --wait to the notification targets of the profile")
+	cmd.Flags().BoolVar(&noEvents, "no-events", false, "do not publish CloudEvents to the events sink of the profile")
+	cmd.Flags().StringVarP(&requestsFile, "file", "f", "", "submit the requests of a YAML or JSON file, '-' for stdin")
+	cmd.Flags().IntVar(&concurrency, "concurrency", defaultConcurrency, "requests of --file submitted at once")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the webhook requests, with their URL, headers and body, instead of sending them")
+	if usesCatalog(op) {
+		cmd.Flags().BoolVar(&skipCatalog, "skip-catalog", false, "do not validate the request against the catalog, see 'gcpctl catalog'")
+	}
+	if op.Confirm != nil {
+		cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "do not ask for confirmation")
+	}
+	if extend, ok := commandExtensions[op.Name()]; ok {
+		extend(cmd, op)
+	}
+
+	return cmd
+}
+
+// operationValues reads the field values of an operation from the flags of
+// its command. Flags that were not given are empty unless their field has a
+// default.
+func operationValues(cmd *cobra.Command, op *operations.Operation) operations.Values {
+	values := operations.Values{}
+	for _, f := range op.Fields {
+		flag := cmd.Flags().Lookup(f.Name)
+		if flag.Changed || f.Default != "" {
+			values[f.Name] = flag.Value.String()
+		} else {
+			values[f.Name] = ""
+		}
+	}
+	return values
+}
+
+// checkRequiredFlags fails like cobra does for required flags that were not
+// given. The flags are not marked required since --file replaces them.
+func checkRequiredFlags(cmd *cobra.Command, op *operations.Operation) error {
+	var missing []string
+	for _, f := range op.Fields {
+		if f.Required && f.Default == "" && !cmd.Flags().Changed(f.Name) {
+			missing = append(missing, strconv.Quote(f.Name))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("required flag(s) %s not set", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// runOperation validates an operation request, asks for confirmation if the
+// operation wants it, and posts the request to the webhook route of the
+// operation, or prints it with --dry-run
+func runOperation(cmd *cobra.Command, op *operations.Operation, values operations.Values) error {
+	if err := op.Check(values); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	if err := checkCatalog(cmd, op, values); err != nil {
+		return err
+	}
+	params, err := requestParams(op)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return runDryRun(cmd, op, []operations.Values{values}, params)
+	}
+	if err := checkNotify(); err != nil {
+		return err
+	}
+	if err := checkEvents(); err != nil {
+		return err
+	}
+
+	if op.Confirm != nil && !assumeYes {
+		confirmed, err := confirm(cmd.InOrStdin(), cmd.ErrOrStderr(), op.Confirm(values))
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Fprintln(cmd.ErrOrStderr(), "Aborted.")
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	defer cancel()
+
+	payload, err := op.BuildPayloadWithParams(values, params)
+	if err != nil {
+		return err
+	}
+	logVerbose("Sending %s request: %+v", op.Name(), payload)
+
+	tektonClient, err := newTektonClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := tektonClient.Trigger(ctx, op.Route, payload)
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", op.Verb, op.Group, err)
+	}
+	recordSubmission(ledger(), cmd.ErrOrStderr(), op, values, params, resp)
+	publishEvent(cmd.Context(), cmd.ErrOrStderr(), events.TypeSubmissionCreated, op, values, resp, nil)
+
+	return reportTriggered(cmd, op, values, resp)
+}
+
+// requestParams reads the extra pipeline parameters of --params-file and
+// --param, which take precedence, and checks them against the parameter
+// schema of the pipeline of the operation
+func requestParams(op *operations.Operation) (operations.Params, error) {
+	schema, ok := op.ParamSchema()
+	if !ok {
+		return nil, nil
+	}
+
+	raw := map[string]any{}
+	if paramsFile != "" {
+		data, err := os.ReadFile(paramsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read parameters: %w", err)
+		}
+		set, err := operations.ParseParamSet(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameters file %s: %w", paramsFile, err)
+		}
+		maps.Copy(raw, set)
+	}
+	args, err := operations.ParseParamArgs(paramArgs)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(raw, args)
+
+	params, err := schema.Check(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters: %w", err)
+	}
+	if len(params) > 0 {
+		logVerbose("Forwarding parameters %v to %s", params, op.Pipeline)
+	}
+	return params, nil
+}
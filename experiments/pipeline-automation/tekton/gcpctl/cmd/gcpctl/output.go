@@ -0,0 +1,62 @@
+package gcpctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats selectable with --output
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var outputFormat string
+
+// validateOutput checks the configured output format
+func validateOutput() error {
+	switch config.GetOutput() {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, must be %s, %s or %s", config.GetOutput(), outputTable, outputJSON, outputYAML)
+	}
+}
+
+// structuredOutput reports whether the output is machine-readable
+func structuredOutput() bool {
+	return config.GetOutput() != outputTable
+}
+
+// progressWriter is where messages meant for humans go: stdout for table
+// output, stderr when stdout carries JSON or YAML
+func progressWriter(cmd *cobra.Command) io.Writer {
+	if structuredOutput() {
+		return cmd.ErrOrStderr()
+	}
+	return cmd.OutOrStdout()
+}
+
+// printStructured writes v to w as JSON or YAML, following the json tags of the api types
+func printStructured(w io.Writer, v any) error {
+	var data []byte
+	var err error
+	switch config.GetOutput() {
+	case outputYAML:
+		data, err = yaml.Marshal(v)
+	default:
+		data, err = json.MarshalIndent(v, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
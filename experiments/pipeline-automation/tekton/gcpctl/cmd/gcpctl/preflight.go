@@ -0,0 +1,136 @@
+package gcpctl
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/preflight"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+// preflightCmd represents the preflight command
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check the access of the active profile before a rollout",
+	Long: `Check that you have the access gcpctl needs with the active profile,
+before attempting a rollout:
+
+- the actions gcpctl takes on pipeline runs, TaskRuns and pod logs in the
+  namespace, checked with SelfSubjectAccessReviews with the configured
+  backend, as 'kubectl auth can-i' does;
+- the webhook route of every operation, checked with a HEAD request through
+  the configured proxy and with the configured headers. The request has no
+  payload, so it does not start a pipeline.
+
+Getting and listing pipeline runs and the webhook routes are required: the
+command exits non-zero if any of them is denied or could not be checked. The
+other actions are only needed by the commands listed with them.`,
+	Example: `  gcpctl preflight
+  gcpctl preflight --profile production -n gcp-regions
+  gcpctl preflight -o json`,
+	Args: cobra.NoArgs,
+	RunE: runPreflight,
+}
+
+func init() {
+	rootCmd.AddCommand(preflightCmd)
+
+	preflightCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline runs")
+	preflightCmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "webhook request timeout")
+}
+
+func runPreflight(cmd *cobra.Command, args []string) error {
+	webhook, err := newTektonClient()
+	if err != nil {
+		return err
+	}
+	checker := &preflight.Checker{
+		Namespace:  namespace,
+		Webhook:    webhook,
+		WebhookURL: config.GetTektonURL(),
+		Routes:     preflight.Routes(operations.All()),
+	}
+	report := &api.PreflightReport{Profile: config.GetProfile()}
+	reviewer, backend, err := newClusterClient()
+	if err != nil {
+		checker.ReviewerErr = err
+	} else {
+		checker.Reviewer = reviewer
+		report.Backend = backend
+		logVerbose("Reviewing access with the %s backend", backend)
+	}
+	report.Checks = checker.Run(cmd.Context())
+
+	if structuredOutput() {
+		if err := printStructured(cmd.OutOrStdout(), report); err != nil {
+			return err
+		}
+	} else {
+		printPreflight(cmd.OutOrStdout(), report)
+	}
+
+	var failed int
+	for _, c := range report.Checks {
+		if c.Required && c.Status != api.PreflightAllowed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d required checks did not pass, rollouts with this profile will fail", failed)
+	}
+	return nil
+}
+
+// printPreflight prints the checks of a report as a table, the reasons of
+// the checks that did not pass below it
+func printPreflight(w io.Writer, report *api.PreflightReport) {
+	profile := report.Profile
+	if profile == "" {
+		profile = "(none)"
+	}
+	backend := report.Backend
+	if backend == "" {
+		backend = "none available"
+	}
+	fmt.Fprintf(w, "Profile: %s\nBackend: %s\n\n", profile, backend)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCESS\tREQUIRED\tSTATUS\tUSED BY")
+	for _, c := range report.Checks {
+		required := "no"
+		if c.Required {
+			required = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s %s\t%s\n", c.Access, required, preflightMark(c.Status), c.Status, c.UsedBy)
+	}
+	tw.Flush()
+
+	first := true
+	for _, c := range report.Checks {
+		if c.Status == api.PreflightAllowed {
+			continue
+		}
+		if first {
+			fmt.Fprintln(w)
+			first = false
+		}
+		fmt.Fprintf(w, "%s %s: %s\n", preflightMark(c.Status), c.Access, c.Reason)
+	}
+}
+
+// preflightMark returns the symbol of a check status
+func preflightMark(status string) string {
+	switch status {
+	case api.PreflightAllowed:
+		return "✓"
+	case api.PreflightDenied:
+		return "✗"
+	default:
+		return "?"
+	}
+}
@@ -0,0 +1,520 @@
+package gcpctl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+var (
+	environment string
+	region      string
+	sector      string
+	timeout     time.Duration
+	namespace   string
+	assumeYes   bool
+	listAll     bool
+	wait        bool
+	follow      bool
+
+	// fanOutNamespaces and namespaceSelector select the namespaces the
+	// region commands query concurrently instead of --namespace
+	fanOutNamespaces  []string
+	namespaceSelector string
+)
+
+// regionCmd represents the region command
+var regionCmd = &cobra.Command{
+	Use:   "region",
+	Short: "Manage GCP regions",
+	Long:  `Provision, delete and inspect GCP regions through the region provisioning pipeline.`,
+}
+
+// regionStatusCmd represents the region status command
+var regionStatusCmd = &cobra.Command{
+	Use:   "status [event-id]",
+	Short: "Show the status of a region pipeline",
+	Long: `Show the status of the pipeline run triggered by a 'region add' or 'region delete' event.
+
+Without an event ID, the latest submission of the active profile in the
+history is shown, see 'gcpctl history'.
+
+The pipeline run is looked for in --namespace, or concurrently in every
+namespace of --namespaces and --namespace-selector, by default the
+region_namespaces and region_namespace_selector settings.`,
+	Example: `  gcpctl region status 63950e1f-7ffe-4d14-bc0e-121cee88942e
+  gcpctl region status <event-id> --namespace production
+  gcpctl region status <event-id> --namespaces sector-main,sector-canary
+  gcpctl region status`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runRegionStatus,
+}
+
+// regionListCmd represents the region list command
+var regionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List regions and the state of their latest pipeline run",
+	Long: `List every region the provisioning pipeline has run for, with the state of
+its latest run. Deleted regions are only listed with --all.
+
+The pipeline runs of --namespace are listed, or those of every namespace of
+--namespaces and --namespace-selector queried concurrently, by default the
+region_namespaces and region_namespace_selector settings, as region pipelines
+of different sectors run in different namespaces.`,
+	Example: `  gcpctl region list
+  gcpctl region list --environment production --sector main
+  gcpctl region list --namespace-selector gcp-hcp/pipelines=region
+  gcpctl region list --all`,
+	Args: cobra.NoArgs,
+	RunE: runRegionList,
+}
+
+func init() {
+	rootCmd.AddCommand(regionCmd)
+	regionCmd.AddCommand(regionStatusCmd, regionListCmd)
+
+	regionStatusCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline runs")
+	addNamespaceFanOutFlags(regionStatusCmd)
+	regionStatusCmd.Flags().BoolVarP(&follow, "follow", "f", false, "poll until the pipeline run finishes, exiting non-zero if it fails")
+	addFollowFlags(regionStatusCmd)
+
+	regionListCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline runs")
+	addNamespaceFanOutFlags(regionListCmd)
+	regionListCmd.Flags().StringVarP(&environment, "environment", "e", "", "only list regions of this environment")
+	regionListCmd.Flags().StringVarP(&sector, "sector", "s", "", "only list regions of this sector")
+	regionListCmd.Flags().BoolVar(&listAll, "all", false, "include deleted regions")
+}
+
+func runRegionStatus(cmd *cobra.Command, args []string) error {
+	var eventID string
+	if len(args) == 1 {
+		eventID = args[0]
+	} else {
+		latest, err := latestSubmission(cmd)
+		if err != nil {
+			return err
+		}
+		eventID = latest
+	}
+
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+	namespaces, err := regionNamespaces(cmd, statusClient)
+	if err != nil {
+		return err
+	}
+
+	if follow {
+		final, err := followPipelineRun(cmd, namespaces, eventID, nil)
+		if err != nil {
+			return err
+		}
+		if structuredOutput() {
+			if err := printStructured(cmd.OutOrStdout(), final); err != nil {
+				return err
+			}
+		}
+		return pipelineRunError(final)
+	}
+
+	var status *api.PipelineRunStatus
+	if len(namespaces) == 1 {
+		status, err = statusClient.GetPipelineRunsByEventID(cmd.Context(), namespaces[0], eventID)
+	} else {
+		status, err = client.GetPipelineRunsByEventIDInNamespaces(cmd.Context(), statusClient, namespaces, eventID, client.DefaultFanOutConcurrency)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get pipeline status: %w", err)
+	}
+	if err := client.AddTaskRunDetails(cmd.Context(), statusClient, status); err != nil {
+		logVerbose("Could not read the TaskRuns of %s: %v", status.Name, err)
+	}
+	if err := client.AddApprovals(cmd.Context(), statusClient, status); err != nil {
+		// Clusters without the manual approval gate have no ApprovalTasks
+		logVerbose("Could not read the approval gates of %s: %v", status.Name, err)
+	}
+	setDashboardURL(status)
+
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), status)
+	}
+	printPipelineRunStatus(cmd.OutOrStdout(), status, time.Now())
+	return nil
+}
+
+func runRegionList(cmd *cobra.Command, args []string) error {
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+	namespaces, err := regionNamespaces(cmd, statusClient)
+	if err != nil {
+		return err
+	}
+
+	runs, nsErrs, err := client.ListPipelineRunsInNamespaces(cmd.Context(), statusClient, namespaces, client.RegionPipelineSelector, client.DefaultFanOutConcurrency)
+	if err != nil {
+		return fmt.Errorf("failed to list pipeline runs: %w", err)
+	}
+	for ns, nsErr := range nsErrs {
+		// The regions of the other namespaces are still listed
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: failed to list the pipeline runs of namespace %s: %v\n", ns, nsErr)
+	}
+
+	regions := client.SummarizeRegions(runs, api.RegionListOptions{
+		Environment:    environment,
+		Sector:         sector,
+		IncludeDeleted: listAll,
+	})
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), regions)
+	}
+	if len(regions) == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "No regions found in namespace %s\n", strings.Join(namespaces, ", "))
+		return nil
+	}
+
+	printRegions(cmd.OutOrStdout(), len(namespaces) > 1, regions, time.Now())
+	return nil
+}
+
+// reportTriggered prints the webhook response of an operation request and,
+// with --wait, follows the pipeline run it started and posts its outcome to
+// the notification targets
+func reportTriggered(cmd *cobra.Command, op *operations.Operation, values operations.Values, resp *api.TektonResponse) error {
+	result := &api.TriggerResult{Event: resp}
+	if !structuredOutput() {
+		printTriggered(cmd.OutOrStdout(), op.TriggeredMessage(), resp)
+	}
+
+	var runErr error
+	if wait {
+		if resp.EventID == "" {
+			return fmt.Errorf("cannot wait for the pipeline run: the webhook response has no event ID")
+		}
+		ns := resp.Namespace
+		if ns == "" {
+			ns = "default"
+		}
+		final, err := followPipelineRun(cmd, []string{ns}, resp.EventID, runEvents(cmd.Context(), cmd.ErrOrStderr(), op, values, resp))
+		if notifyErr := notifyCompletion(cmd.Context(), op, values, final, err); notifyErr != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: failed to send notifications: %v\n", notifyErr)
+		}
+		if err != nil {
+			return err
+		}
+		result.PipelineRun = final
+		result.State = op.DescribeState(values, final)
+		if !structuredOutput() {
+			fmt.Fprintf(cmd.OutOrStdout(), "\nState: %s\n", result.State)
+		}
+		runErr = pipelineRunError(final)
+	} else if !structuredOutput() && resp.EventID != "" {
+		fmt.Fprintln(cmd.OutOrStdout(), "Note: Pipeline execution may take 10-15 minutes to complete.")
+	}
+
+	if structuredOutput() {
+		if err := printStructured(cmd.OutOrStdout(), result); err != nil {
+			return err
+		}
+	}
+	return runErr
+}
+
+// newTektonClient returns a webhook client for the configured Tekton URL,
+// going through the configured proxy with the configured headers and signing
+// payloads if a webhook secret is configured
+func newTektonClient() (*client.TektonClient, error) {
+	c := client.NewTektonClientWithTimeout(config.GetTektonURL(), timeout)
+	c.SetRetryPolicy(retryPolicy())
+	rt, err := httpTransport()
+	if err != nil {
+		return nil, err
+	}
+	c.SetTransport(rt)
+	c.SetHeaders(config.GetHeaders())
+
+	secret, err := config.GetWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" {
+		logVerbose("Signing the payload with the webhook secret")
+		c.SetWebhookSecret(secret)
+	}
+	return c, nil
+}
+
+// addNamespaceFanOutFlags adds the flags selecting several namespaces to
+// query concurrently instead of --namespace
+func addNamespaceFanOutFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&fanOutNamespaces, "namespaces", nil, "namespaces to query concurrently, comma-separated or repeated (default the region_namespaces setting)")
+	cmd.Flags().StringVar(&namespaceSelector, "namespace-selector", "", "also query the namespaces matching this label selector (default the region_namespace_selector setting)")
+	cmd.MarkFlagsMutuallyExclusive("namespace", "namespaces")
+	cmd.MarkFlagsMutuallyExclusive("namespace", "namespace-selector")
+}
+
+// regionNamespaces returns the namespaces a region command queries: the one
+// of --namespace, or those of --namespaces and --namespace-selector, or of
+// the region_namespaces and region_namespace_selector settings, or default
+func regionNamespaces(cmd *cobra.Command, lister client.NamespaceLister) ([]string, error) {
+	if cmd.Flags().Changed("namespace") {
+		return []string{namespace}, nil
+	}
+	namespaces, selector := fanOutNamespaces, namespaceSelector
+	if len(namespaces) == 0 && selector == "" {
+		namespaces, selector = config.GetRegionNamespaces()
+	}
+	if len(namespaces) == 0 && selector == "" {
+		return []string{namespace}, nil
+	}
+
+	resolved, err := client.ResolveNamespaces(cmd.Context(), lister, namespaces, selector)
+	if err != nil {
+		return nil, err
+	}
+	logVerbose("Querying namespaces %s", strings.Join(resolved, ", "))
+	return resolved, nil
+}
+
+// newStatusClient returns a client of the configured backend
+func newStatusClient() (client.ClusterClient, error) {
+	c, name, err := newClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	logVerbose("Reading pipeline runs with the %s backend", name)
+	return c, nil
+}
+
+// newClusterClient returns the client of the configured backend and its name
+func newClusterClient() (client.ClusterClient, string, error) {
+	policy := retryPolicy()
+	proxy, noProxy := config.GetProxy()
+	return client.NewClusterClient(client.BackendOptions{
+		Backend:    config.GetBackend(),
+		Kubeconfig: config.GetKubeconfig(),
+		Context:    config.GetKubeContext(),
+		APIURL:     config.GetTektonAPIURL(),
+		Retry:      &policy,
+		Proxy:      proxy,
+		NoProxy:    noProxy,
+		Headers:    config.GetHeaders(),
+		Fixtures:   fixtures,
+	})
+}
+
+// httpTransport returns the transport of the HTTP clients, going through the
+// configured proxy or the one of HTTPS_PROXY
+func httpTransport() (http.RoundTripper, error) {
+	return client.NewTransport(config.GetProxy())
+}
+
+// httpClient returns an HTTP client of httpTransport for other servers than
+// the webhook and the Tekton API, e.g. notification targets. The configured
+// headers are not sent to them.
+func httpClient() (*http.Client, error) {
+	rt, err := httpTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+// redactURL hides the password of a URL, e.g. of a proxy
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return redacted
+	}
+	return u.Redacted()
+}
+
+// retryPolicy returns the configured retry policy of the HTTP clients,
+// logging every retry in verbose mode
+func retryPolicy() client.RetryPolicy {
+	policy := client.DefaultRetryPolicy()
+	policy.MaxAttempts, policy.InitialBackoff, policy.MaxBackoff = config.GetRetry()
+	policy.OnRetry = func(a client.RetryAttempt) {
+		reason := fmt.Sprintf("status %d", a.StatusCode)
+		if a.Err != nil {
+			reason = a.Err.Error()
+		}
+		logVerbose("Attempt %d/%d failed (%s), retrying in %s", a.Attempt, a.MaxAttempts, reason, a.Delay.Round(time.Millisecond))
+	}
+	return policy
+}
+
+// confirm asks a yes/no question on out and reads the answer from in
+func confirm(in io.Reader, out io.Writer, question string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// printTriggered prints the webhook response of a triggered pipeline
+func printTriggered(w io.Writer, title string, resp *api.TektonResponse) {
+	fmt.Fprintf(w, "✓ %s\n\n", title)
+	if resp.EventID == "" {
+		if resp.Message != "" {
+			fmt.Fprintf(w, "  %s\n\n", resp.Message)
+		}
+		return
+	}
+
+	fmt.Fprintf(w, "  Event ID:       %s\n", resp.EventID)
+	if resp.Namespace != "" {
+		fmt.Fprintf(w, "  Namespace:      %s\n", resp.Namespace)
+	}
+	if resp.EventListener != "" {
+		fmt.Fprintf(w, "  Event Listener: %s\n", resp.EventListener)
+	}
+	fmt.Fprintf(w, "\n  Check status:\n    gcpctl region status %s", resp.EventID)
+	if resp.Namespace != "" && resp.Namespace != "default" {
+		fmt.Fprintf(w, " --namespace %s", resp.Namespace)
+	}
+	fmt.Fprint(w, "\n\n")
+}
+
+// printPipelineRunStatus prints a pipeline run in the format of 'region status'
+func printPipelineRunStatus(w io.Writer, status *api.PipelineRunStatus, now time.Time) {
+	fmt.Fprintf(w, "Pipeline Run: %s\n", runLink(w, status.Namespace, status.Name))
+	fmt.Fprintf(w, "Namespace:    %s\n", status.Namespace)
+	if status.Action != "" {
+		fmt.Fprintf(w, "Action:       %s\n", status.Action)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "Status:       %s %s\n", client.GetStatusEmoji(status.Status), status.Status)
+	if start, err := time.Parse(time.RFC3339, status.StartTime); err == nil {
+		fmt.Fprintf(w, "Started:      %s (%s ago)\n", start.Local().Format(timeLayout), client.FormatDuration(now.Sub(start)))
+		if end, err := time.Parse(time.RFC3339, status.CompletionTime); err == nil {
+			fmt.Fprintf(w, "Completed:    %s (took %s)\n", end.Local().Format(timeLayout), client.FormatDuration(end.Sub(start)))
+		} else {
+			fmt.Fprintf(w, "Duration:     %s (running)\n", client.FormatDuration(now.Sub(start)))
+		}
+	}
+	if status.Message != "" {
+		fmt.Fprintf(w, "Message:      %s\n", status.Message)
+	}
+
+	if len(status.Tasks) > 0 {
+		tasks := append([]api.TaskRunStatus(nil), status.Tasks...)
+		sortTasks(tasks)
+
+		completed := 0
+		fmt.Fprintf(w, "\nTasks (%d):\n", len(tasks))
+		for _, task := range tasks {
+			line := fmt.Sprintf("  %s %s", client.GetStatusEmoji(task.Status), task.Name)
+			if task.CompletionTime != "" {
+				completed++
+				line += fmt.Sprintf(" (%s)", client.CalculateDuration(task.StartTime, task.CompletionTime))
+			}
+			fmt.Fprintln(w, line)
+			for _, step := range task.Steps {
+				fmt.Fprintf(w, "      %s %s%s\n", client.GetStatusEmoji(step.Status), step.Name, stepDetail(step, now))
+			}
+		}
+		fmt.Fprintf(w, "\nProgress:     %d/%d tasks completed\n", completed, len(tasks))
+	}
+
+	if len(status.Approvals) > 0 {
+		printApprovals(w, status)
+	}
+
+	if link := dashboardURL(status.Namespace, status.Name); link != "" {
+		fmt.Fprintf(w, "\nDashboard:    %s\n", link)
+	}
+}
+
+// stepDetail describes how long a step ran and why it failed or waits, e.g.
+// " (1m50s, exit code 1)"
+func stepDetail(step api.StepStatus, now time.Time) string {
+	var details []string
+	switch step.Status {
+	case "Succeeded", "Failed":
+		details = append(details, client.FormatDuration(time.Duration(step.DurationSeconds)*time.Second))
+	case "Running":
+		if start, err := time.Parse(time.RFC3339, step.StartTime); err == nil {
+			details = append(details, "running for "+client.FormatDuration(now.Sub(start)))
+		}
+	case "Skipped":
+		details = append(details, "skipped")
+	}
+	if step.Status == "Failed" && step.ExitCode != nil {
+		details = append(details, fmt.Sprintf("exit code %d", *step.ExitCode))
+	}
+	if step.Reason != "" && step.Reason != "Error" {
+		details = append(details, step.Reason)
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(details, ", ") + ")"
+}
+
+// sortTasks orders tasks by start time, tasks that have not started last
+func sortTasks(tasks []api.TaskRunStatus) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if (a.StartTime == "") != (b.StartTime == "") {
+			return b.StartTime == ""
+		}
+		if a.StartTime != b.StartTime {
+			return a.StartTime < b.StartTime
+		}
+		return a.Name < b.Name
+	})
+}
+
+// printRegions prints the regions of 'region list' as a table, with the
+// namespace of their pipeline run when several namespaces were listed
+func printRegions(w io.Writer, showNamespace bool, regions []api.RegionStatus, now time.Time) {
+	var table strings.Builder
+	tw := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	runs := make([]runRef, 0, len(regions))
+	if showNamespace {
+		fmt.Fprintln(tw, "ENVIRONMENT\tSECTOR\tREGION\tSTATE\tNAMESPACE\tPIPELINE RUN\tAGE")
+	} else {
+		fmt.Fprintln(tw, "ENVIRONMENT\tSECTOR\tREGION\tSTATE\tPIPELINE RUN\tAGE")
+	}
+	for _, r := range regions {
+		age := "N/A"
+		if start, err := time.Parse(time.RFC3339, r.StartTime); err == nil {
+			age = client.FormatDuration(now.Sub(start))
+		}
+		run := r.PipelineRun
+		if showNamespace {
+			run = r.Namespace + "\t" + run
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s %s\t%s\t%s\n",
+			r.Environment, r.Sector, r.Region, client.GetStatusEmoji(r.Status), r.State(), run, age)
+		runs = append(runs, runRef{namespace: r.Namespace, name: r.PipelineRun})
+	}
+	tw.Flush()
+	writeLinkedTable(w, table.String(), runs)
+}
@@ -0,0 +1,116 @@
+package gcpctl
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cfgFile     string
+	profile     string
+	tektonURL   string
+	verbose     bool
+	backend     string
+	kubeconfig  string
+	kubeContext string
+	retries     int
+)
+
+// rootCmd represents the base command when called without any subcommands
+var rootCmd = &cobra.Command{
+	Use:   "gcpctl",
+	Short: "Manage GCP resources through Tekton pipelines",
+	Long: `gcpctl triggers Tekton pipelines that manage GCP resources and reports
+on their progress.
+
+Pipelines are triggered through the Tekton webhook (EventListener). Their
+status is read from the cluster of your kubeconfig, with kubectl, or from the
+Tekton API, see --backend.`,
+	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return initConfig(cmd)
+	},
+}
+
+// Execute runs the root command
+func Execute() error {
+	addOperationCommands()
+	defer saveFixtures()
+	return rootCmd.Execute()
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gcpctl/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "profile of the config file to use (overrides GCPCTL_PROFILE and current_profile)")
+	rootCmd.PersistentFlags().StringVar(&tektonURL, "tekton-url", "", "Tekton webhook URL (overrides config)")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "", "output format: table, json or yaml (overrides config, default table)")
+	rootCmd.PersistentFlags().StringVar(&backend, "backend", "", "how to read pipeline runs: auto, kubeconfig, kubectl or api (overrides config)")
+	rootCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig of the kubeconfig backend (default $KUBECONFIG or ~/.kube/config)")
+	rootCmd.PersistentFlags().StringVar(&kubeContext, "context", "", "kubeconfig context of the kubeconfig backend (default the current context)")
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", 0, "attempts of HTTP requests that fail with a transient error, 1 to not retry (overrides config, default 4)")
+}
+
+// initConfig loads the configuration and applies the global flags on top of it
+func initConfig(cmd *cobra.Command) error {
+	if err := config.Load(cfgFile, profile); err != nil {
+		return err
+	}
+	return applyGlobalFlags(cmd)
+}
+
+// applyGlobalFlags applies the global flags on top of the loaded configuration
+func applyGlobalFlags(cmd *cobra.Command) error {
+	if cmd.Flags().Changed("tekton-url") {
+		config.SetTektonURL(tektonURL)
+	}
+	if cmd.Flags().Changed("verbose") {
+		config.SetVerbose(verbose)
+	}
+	if cmd.Flags().Changed("backend") {
+		config.SetBackend(backend)
+	}
+	if cmd.Flags().Changed("kubeconfig") {
+		config.SetKubeconfig(kubeconfig)
+	}
+	if cmd.Flags().Changed("context") {
+		config.SetKubeContext(kubeContext)
+	}
+	if cmd.Flags().Changed("output") {
+		config.SetOutput(outputFormat)
+	}
+	if cmd.Flags().Changed("retries") {
+		config.SetRetryAttempts(retries)
+	}
+	if err := validateOutput(); err != nil {
+		return err
+	}
+	if err := setupFixtures(cmd); err != nil {
+		return err
+	}
+	if proxy, _ := config.GetProxy(); proxy != "" {
+		if err := client.ValidateProxy(proxy); err != nil {
+			return err
+		}
+		logVerbose("Proxy: %s", redactURL(proxy))
+	}
+
+	if p := config.GetProfile(); p != "" {
+		logVerbose("Profile: %s", p)
+	}
+	logVerbose("Tekton webhook URL: %s", config.GetTektonURL())
+	logVerbose("Tekton API URL: %s", config.GetTektonAPIURL())
+
+	return nil
+}
+
+// logVerbose prints a diagnostic line to stderr in verbose mode
+func logVerbose(format string, args ...any) {
+	if config.IsVerbose() {
+		fmt.Fprintf(os.Stderr, "[verbose] "+format+"\n", args...)
+	}
+}
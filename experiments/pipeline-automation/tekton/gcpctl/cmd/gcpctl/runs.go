@@ -0,0 +1,413 @@
+package gcpctl
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runsPipeline string
+	runsStatus   string
+	runsSince    time.Duration
+	runsSortBy   string
+	runsReverse  bool
+	runsLimit    int
+	runsPage     int
+	fromTask     string
+
+	pruneOlderThan string
+	pruneArchive   string
+	pruneLimit     int
+)
+
+// runsCmd represents the runs command
+var runsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "Inspect pipeline runs",
+	Long:  `Review the pipeline runs of the cluster, e.g. recent rollout activity.`,
+}
+
+// runsListCmd represents the runs list command
+var runsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List pipeline runs",
+	Long: `List pipeline runs, newest first, filtered by pipeline, region parameters,
+status and age.
+
+Runs are listed a page at a time; use --page to see older runs and --limit 0
+to list all of them. --sort-by orders the runs by start time (newest first),
+duration (longest first) or status.`,
+	Example: `  gcpctl runs list
+  gcpctl runs list --pipeline gcp-region-provisioning-pipeline --since 24h
+  gcpctl runs list --environment production --region us-central1 --status failed
+  gcpctl runs list --sort-by duration --limit 10 --page 2`,
+	Args: cobra.NoArgs,
+	RunE: runRunsList,
+}
+
+// runsRetryCmd represents the runs retry command
+var runsRetryCmd = &cobra.Command{
+	Use:   "retry <pipelinerun>",
+	Short: "Re-submit a failed pipeline run",
+	Long: `Re-submit a failed or cancelled pipeline run as a new run with the same
+pipeline, parameters and workspaces.
+
+The new run is labelled with the run it retries (gcpctl.openshift.io/retry-of)
+and the original run with its retry (gcpctl.openshift.io/retried-by).
+
+With --from-task, the new run starts at the given task. Only pipelines with a
+start-from-task parameter support this, such as the region provisioning
+pipeline.`,
+	Example: `  gcpctl runs retry gcp-region-provision-jf8v5
+  gcpctl runs retry gcp-region-provision-jf8v5 --from-task terraform-init`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRunsRetry,
+}
+
+// runsPruneCmd represents the runs prune command
+var runsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old completed pipeline runs",
+	Long: `Delete completed pipeline runs older than --older-than, so historical runs
+do not fill the etcd of the management cluster. Runs that are pending or
+running are never pruned.
+
+Runs are pruned oldest first, at most --limit per invocation; run the command
+again to prune the next batch. With --archive, each run is uploaded as JSON to
+<archive>/<namespace>/<name>.json with gcloud storage before it is deleted,
+and a run that could not be archived is kept.
+
+Use --dry-run to list the runs that would be pruned.`,
+	Example: `  gcpctl runs prune --older-than 30d --dry-run
+  gcpctl runs prune --older-than 30d --status succeeded
+  gcpctl runs prune --older-than 14d --pipeline gcp-region-e2e --limit 50 --yes
+  gcpctl runs prune --older-than 90d --archive gs://my-bucket/pipelineruns`,
+	Args: cobra.NoArgs,
+	RunE: runRunsPrune,
+}
+
+func init() {
+	rootCmd.AddCommand(runsCmd)
+	runsCmd.AddCommand(runsListCmd, runsRetryCmd, runsPruneCmd)
+
+	runsListCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline runs")
+	runsListCmd.Flags().StringVarP(&runsPipeline, "pipeline", "p", "", "only list runs of this pipeline")
+	runsListCmd.Flags().StringVarP(&environment, "environment", "e", "", "only list runs with this environment parameter")
+	runsListCmd.Flags().StringVarP(&sector, "sector", "s", "", "only list runs with this sector parameter")
+	runsListCmd.Flags().StringVarP(&region, "region", "r", "", "only list runs with this region parameter")
+	runsListCmd.Flags().StringVar(&runsStatus, "status", "", "only list runs in this status: pending, running, succeeded, failed or cancelled")
+	runsListCmd.Flags().DurationVar(&runsSince, "since", 0, "only list runs started within this duration, e.g. 24h")
+	runsListCmd.Flags().StringVar(&runsSortBy, "sort-by", api.RunSortStart, "sort by start, duration or status")
+	runsListCmd.Flags().BoolVar(&runsReverse, "reverse", false, "reverse the sort order")
+	runsListCmd.Flags().IntVar(&runsLimit, "limit", 20, "runs per page, 0 for all")
+	runsListCmd.Flags().IntVar(&runsPage, "page", 1, "page to list, starting at 1")
+
+	runsRetryCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline run")
+	runsRetryCmd.Flags().StringVar(&fromTask, "from-task", "", "start the new run at this pipeline task")
+
+	runsPruneCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline runs")
+	runsPruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "", "only prune runs completed longer ago than this, e.g. 30d or 12h")
+	runsPruneCmd.Flags().StringVarP(&runsPipeline, "pipeline", "p", "", "only prune runs of this pipeline")
+	runsPruneCmd.Flags().StringVarP(&environment, "environment", "e", "", "only prune runs with this environment parameter")
+	runsPruneCmd.Flags().StringVarP(&sector, "sector", "s", "", "only prune runs with this sector parameter")
+	runsPruneCmd.Flags().StringVarP(&region, "region", "r", "", "only prune runs with this region parameter")
+	runsPruneCmd.Flags().StringVar(&runsStatus, "status", "", "only prune runs in this status: succeeded, failed or cancelled")
+	runsPruneCmd.Flags().IntVar(&pruneLimit, "limit", 100, "most runs pruned per invocation, oldest first, 0 for all")
+	runsPruneCmd.Flags().StringVar(&pruneArchive, "archive", "", "archive each run as JSON to this Cloud Storage URL before deleting it, e.g. gs://bucket/pipelineruns")
+	runsPruneCmd.Flags().BoolVar(&dryRun, "dry-run", false, "list the runs that would be pruned without deleting them")
+	runsPruneCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "do not ask for confirmation")
+	runsPruneCmd.MarkFlagRequired("older-than")
+}
+
+func runRunsList(cmd *cobra.Command, args []string) error {
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+
+	runs, err := statusClient.ListPipelineRuns(cmd.Context(), namespace, client.PipelineSelector(runsPipeline))
+	if err != nil {
+		return fmt.Errorf("failed to list pipeline runs: %w", err)
+	}
+
+	now := time.Now()
+	list, err := client.ListRuns(runs, api.RunListOptions{
+		Pipeline:    runsPipeline,
+		Environment: environment,
+		Sector:      sector,
+		Region:      region,
+		Status:      runsStatus,
+		Since:       runsSince,
+		SortBy:      runsSortBy,
+		Reverse:     runsReverse,
+		Limit:       runsLimit,
+		Page:        runsPage,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), list)
+	}
+	if list.Total == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "No pipeline runs found in namespace %s\n", namespace)
+		return nil
+	}
+
+	printRuns(cmd.OutOrStdout(), list, now)
+	return nil
+}
+
+func runRunsRetry(cmd *cobra.Command, args []string) error {
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+
+	logVerbose("Retrying pipeline run %s in namespace %s", args[0], namespace)
+
+	result, err := client.RetryPipelineRun(cmd.Context(), statusClient, namespace, args[0], client.RetryOptions{FromTask: fromTask})
+	if result == nil {
+		return fmt.Errorf("failed to retry pipeline run: %w", err)
+	}
+	if err != nil {
+		// The retry was created, only linking it failed
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %v\n", err)
+	}
+
+	result.DashboardURL = dashboardURL(result.Namespace, result.PipelineRun)
+
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), result)
+	}
+	printRetry(cmd.OutOrStdout(), result)
+	return nil
+}
+
+func runRunsPrune(cmd *cobra.Command, args []string) error {
+	olderThan, err := client.ParseAge(pruneOlderThan)
+	if err != nil {
+		return err
+	}
+	var archiver *client.GCSArchiver
+	if pruneArchive != "" {
+		if archiver, err = client.NewGCSArchiver(pruneArchive); err != nil {
+			return err
+		}
+	}
+
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+
+	runs, err := statusClient.ListPipelineRuns(cmd.Context(), namespace, client.PipelineSelector(runsPipeline))
+	if err != nil {
+		return fmt.Errorf("failed to list pipeline runs: %w", err)
+	}
+
+	now := time.Now()
+	selected, matched, err := client.SelectPrunable(runs, api.PruneOptions{
+		Pipeline:    runsPipeline,
+		Environment: environment,
+		Sector:      sector,
+		Region:      region,
+		Status:      runsStatus,
+		OlderThan:   olderThan,
+		Limit:       pruneLimit,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	if len(selected) == 0 || dryRun {
+		result := &api.PruneResult{Namespace: namespace, DryRun: dryRun, Matched: matched, Items: make([]api.PrunedRun, 0, len(selected))}
+		for _, run := range selected {
+			pruned := api.PrunedRun{PipelineRunSummary: run}
+			if archiver != nil {
+				pruned.Archive = archiver.Object(namespace, run.Name)
+			}
+			result.Items = append(result.Items, pruned)
+		}
+		if structuredOutput() {
+			return printStructured(cmd.OutOrStdout(), result)
+		}
+		if len(selected) == 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "No completed pipeline runs older than %s in namespace %s\n", pruneOlderThan, namespace)
+			return nil
+		}
+		printPrune(cmd.OutOrStdout(), result, now)
+		fmt.Fprintln(cmd.ErrOrStderr(), "Dry run: nothing was deleted")
+		return nil
+	}
+
+	if !assumeYes {
+		question := fmt.Sprintf("Delete %d pipeline runs from namespace %s?", len(selected), namespace)
+		if archiver != nil {
+			question = fmt.Sprintf("Archive to %s and delete %d pipeline runs from namespace %s?", archiver.URL, len(selected), namespace)
+		}
+		confirmed, err := confirm(cmd.InOrStdin(), cmd.ErrOrStderr(), question)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Fprintln(cmd.ErrOrStderr(), "Aborted.")
+			return nil
+		}
+	}
+
+	logVerbose("Pruning %d of %d pipeline runs in namespace %s", len(selected), matched, namespace)
+
+	var runArchiver client.Archiver
+	if archiver != nil {
+		runArchiver = archiver
+	}
+	result := client.PruneRuns(cmd.Context(), statusClient, namespace, selected, runArchiver)
+	result.Matched = matched
+
+	if structuredOutput() {
+		if err := printStructured(cmd.OutOrStdout(), result); err != nil {
+			return err
+		}
+	} else {
+		printPrune(cmd.OutOrStdout(), result, now)
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("failed to prune %d of %d pipeline runs", result.Failed, len(result.Items))
+	}
+	return nil
+}
+
+// printPrune prints the runs of 'runs prune' as a table, followed by the
+// number of runs left for the next invocation
+func printPrune(w io.Writer, result *api.PruneResult, now time.Time) {
+	var table strings.Builder
+	tw := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	runs := make([]runRef, 0, len(result.Items))
+	fmt.Fprintln(tw, "NAME\tPIPELINE\tENVIRONMENT\tSECTOR\tREGION\tSTATUS\tCOMPLETED\tRESULT")
+	for _, r := range result.Items {
+		completed := "N/A"
+		if completion, err := time.Parse(time.RFC3339, r.CompletionTime); err == nil {
+			completed = client.FormatDuration(now.Sub(completion)) + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s %s\t%s\t%s\n",
+			r.Name, orDash(r.Pipeline), orDash(r.Environment), orDash(r.Sector), orDash(r.Region),
+			client.GetStatusEmoji(r.Status), r.Status, completed, pruneOutcome(result.DryRun, r))
+		runs = append(runs, runRef{namespace: result.Namespace, name: r.Name})
+	}
+	tw.Flush()
+	writeLinkedTable(w, table.String(), runs)
+
+	fmt.Fprintln(w)
+	if result.DryRun {
+		fmt.Fprintf(w, "%d pipeline runs would be deleted", len(result.Items))
+	} else {
+		fmt.Fprintf(w, "Deleted %d pipeline runs", result.Deleted)
+		if result.Failed > 0 {
+			fmt.Fprintf(w, ", %d failed", result.Failed)
+		}
+	}
+	if left := result.Matched - len(result.Items); left > 0 {
+		fmt.Fprintf(w, ", %d more match: run again to prune them", left)
+	}
+	fmt.Fprintln(w)
+}
+
+// pruneOutcome describes what happened, or would happen, to a pruned run
+func pruneOutcome(dryRun bool, r api.PrunedRun) string {
+	switch {
+	case r.Error != "":
+		return "✗ " + strings.ReplaceAll(strings.TrimSpace(r.Error), "\n", " ")
+	case dryRun && r.Archive != "":
+		return "archive to " + r.Archive
+	case dryRun:
+		return "delete"
+	case r.Archive != "":
+		return "archived to " + r.Archive + ", deleted"
+	default:
+		return "deleted"
+	}
+}
+
+// printRetry prints the pipeline run created by 'runs retry'
+func printRetry(w io.Writer, result *api.RetryResult) {
+	fmt.Fprintf(w, "✓ Pipeline run %s retried\n\n", result.Original)
+	fmt.Fprintf(w, "  Pipeline Run: %s\n", runLink(w, result.Namespace, result.PipelineRun))
+	fmt.Fprintf(w, "  Namespace:    %s\n", result.Namespace)
+	if result.FromTask != "" {
+		fmt.Fprintf(w, "  From Task:    %s\n", result.FromTask)
+	}
+	if len(result.Params) > 0 {
+		names := make([]string, 0, len(result.Params))
+		for name := range result.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "  Params:")
+		for _, name := range names {
+			fmt.Fprintf(w, "    %s: %s\n", name, result.Params[name])
+		}
+	}
+	if result.DashboardURL != "" {
+		fmt.Fprintf(w, "  Dashboard:    %s\n", result.DashboardURL)
+	}
+	fmt.Fprintf(w, "\n  Follow logs:\n    gcpctl logs %s --follow", result.PipelineRun)
+	if result.Namespace != "default" {
+		fmt.Fprintf(w, " --namespace %s", result.Namespace)
+	}
+	fmt.Fprint(w, "\n\n")
+}
+
+// printRuns prints a page of 'runs list' as a table, followed by where it
+// is in the listing
+func printRuns(w io.Writer, list *api.RunList, now time.Time) {
+	var table strings.Builder
+	tw := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	runs := make([]runRef, 0, len(list.Items))
+	fmt.Fprintln(tw, "NAME\tPIPELINE\tENVIRONMENT\tSECTOR\tREGION\tACTION\tSTATUS\tSTARTED\tDURATION")
+	for _, r := range list.Items {
+		started, duration := "N/A", "N/A"
+		if start, err := time.Parse(time.RFC3339, r.StartTime); err == nil {
+			started = client.FormatDuration(now.Sub(start)) + " ago"
+			duration = client.FormatDuration(time.Duration(r.DurationSeconds) * time.Second)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s %s\t%s\t%s\n",
+			r.Name, orDash(r.Pipeline), orDash(r.Environment), orDash(r.Sector), orDash(r.Region), orDash(r.Action),
+			client.GetStatusEmoji(r.Status), r.Status, started, duration)
+		runs = append(runs, runRef{namespace: r.Namespace, name: r.Name})
+	}
+	tw.Flush()
+	writeLinkedTable(w, table.String(), runs)
+
+	if list.Limit == 0 || list.Total <= list.Limit && list.Page == 1 {
+		return
+	}
+	from := (list.Page-1)*list.Limit + 1
+	to := from + len(list.Items) - 1
+	if len(list.Items) == 0 {
+		fmt.Fprintf(w, "\nPage %d is past the last of %d runs\n", list.Page, list.Total)
+		return
+	}
+	fmt.Fprintf(w, "\nShowing %d-%d of %d runs", from, to, list.Total)
+	if to < list.Total {
+		fmt.Fprintf(w, ", next page: --page %d", list.Page+1)
+	}
+	fmt.Fprintln(w)
+}
+
+// orDash returns s, or "-" if it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
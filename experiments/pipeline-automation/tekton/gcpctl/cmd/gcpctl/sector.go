@@ -0,0 +1,342 @@
+package gcpctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/catalog"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/rollout"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rolloutRegions bool
+	existingSector bool
+)
+
+func init() {
+	commandExtensions["sector add"] = addSectorRolloutFlags
+}
+
+// addSectorRolloutFlags lets 'sector add' go on with the regions of the
+// sector definition of the catalog
+func addSectorRolloutFlags(cmd *cobra.Command, op *operations.Operation) {
+	cmd.Flags().BoolVar(&rolloutRegions, "regions", false, "then provision the regions of the sector definition of the catalog in dependency order, waiting for every pipeline run")
+	cmd.Flags().BoolVar(&existingSector, "existing", false, "with --regions, only provision the regions of a sector that exists already")
+	cmd.Flags().Lookup("concurrency").Usage = "requests of --file, or regions of --regions, submitted at once"
+
+	runSingle := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if !rolloutRegions {
+			if existingSector {
+				return fmt.Errorf("--existing requires --regions")
+			}
+			return runSingle(cmd, args)
+		}
+		if requestsFile != "" {
+			return fmt.Errorf("--regions cannot be combined with --file")
+		}
+		if err := checkRequiredFlags(cmd, op); err != nil {
+			return err
+		}
+		return runSectorRollout(cmd, op, operationValues(cmd, op))
+	}
+}
+
+// runSectorRollout creates a sector, unless --existing is given, then
+// provisions the regions of its definition in the catalog: every region is
+// submitted once the regions it comes after are provisioned, and skipped if
+// one of them failed. Every request is checked before the first is sent.
+func runSectorRollout(cmd *cobra.Command, op *operations.Operation, values operations.Values) error {
+	if concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1, got %d", concurrency)
+	}
+	if err := op.Check(values); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	if err := checkCatalog(cmd, op, values); err != nil {
+		return err
+	}
+	regionOp, ok := operations.Lookup("region add")
+	if !ok {
+		return fmt.Errorf("the region add operation is not registered")
+	}
+
+	c := loadCatalog(cmd.Context(), cmd.ErrOrStderr())
+	definition, err := c.Sector(values["environment"], values["sector"])
+	if err != nil {
+		return err
+	}
+	requests, err := regionRequests(c, regionOp, definition)
+	if err != nil {
+		return err
+	}
+	// Extra parameters are those of the sector pipeline
+	params, err := requestParams(op)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return runSectorRolloutDryRun(cmd, op, values, params, regionOp, definition, requests)
+	}
+
+	// The regions are only submitted once the pipeline runs they depend on
+	// succeeded
+	wait = true
+	if err := checkNotify(); err != nil {
+		return err
+	}
+	if err := checkEvents(); err != nil {
+		return err
+	}
+	tektonClient, err := newTektonClient()
+	if err != nil {
+		return err
+	}
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+	l := ledger()
+	w := progressWriter(cmd)
+
+	result := &api.SectorRollout{Environment: definition.Environment, Sector: definition.Sector}
+	if !existingSector {
+		fmt.Fprintf(w, "Creating sector %s...\n", op.Describe(values))
+		item, waitErr := submitRequest(cmd.Context(), op, tektonClient, statusClient, l, cmd.ErrOrStderr(), values, params)
+		if item.Event != nil {
+			warnNotify(cmd.ErrOrStderr(), op, values, notifyCompletion(cmd.Context(), op, values, item.PipelineRun, waitErr))
+		}
+		printBulkProgress(w, op, values, item, time.Now())
+		result.SectorItem = &item
+	}
+
+	if item := result.SectorItem; item != nil && item.Error != "" {
+		for _, r := range definition.Regions {
+			result.Regions = append(result.Regions, api.RolloutItem{
+				Region: r.Region, After: r.After, Progress: rollout.StateSkipped,
+				BulkItem: api.BulkItem{Request: requests[r.Region], Error: "sector creation failed"},
+			})
+		}
+	} else {
+		fmt.Fprintf(w, "Provisioning %d regions of sector %s, %d at a time...\n", len(definition.Regions), op.Describe(values), concurrency)
+		result.Regions = provisionRegions(cmd, regionOp, tektonClient, statusClient, definition, requests)
+	}
+
+	for _, r := range result.Regions {
+		switch r.Progress {
+		case rollout.StateSucceeded:
+			result.Succeeded++
+		case rollout.StateFailed:
+			result.Failed++
+		default:
+			result.Skipped++
+		}
+	}
+
+	if structuredOutput() {
+		if err := printStructured(cmd.OutOrStdout(), result); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(cmd.OutOrStdout())
+		printSectorRollout(cmd.OutOrStdout(), result)
+	}
+	if item := result.SectorItem; item != nil && item.Error != "" {
+		return fmt.Errorf("sector %s was not created: %s", op.Describe(values), item.Error)
+	}
+	if result.Failed+result.Skipped > 0 {
+		return fmt.Errorf("%d of %d regions were not provisioned", result.Failed+result.Skipped, len(result.Regions))
+	}
+	return nil
+}
+
+// regionRequests returns the region add requests of the regions of a sector
+// definition, by region, checked like the requests of 'region add'
+func regionRequests(c *catalog.Catalog, regionOp *operations.Operation, definition *catalog.SectorDefinition) (map[string]operations.Values, error) {
+	requests := make(map[string]operations.Values, len(definition.Regions))
+	for _, r := range definition.Regions {
+		v := operations.Values{"environment": definition.Environment, "region": r.Region, "sector": definition.Sector}
+		err := regionOp.Check(v)
+		if err == nil && !skipCatalog {
+			if err = checkCatalogFields(c, regionOp, v); err != nil {
+				err = fmt.Errorf("%w (use --skip-catalog to send it anyway)", err)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid request for region %s: %w", r.Region, err)
+		}
+		requests[r.Region] = v
+	}
+	return requests, nil
+}
+
+// provisionRegions submits the region requests of a sector definition in
+// dependency order and follows their pipeline runs, printing every change
+func provisionRegions(cmd *cobra.Command, regionOp *operations.Operation, tektonClient *client.TektonClient, statusClient client.ClusterClient, definition *catalog.SectorDefinition, requests map[string]operations.Values) []api.RolloutItem {
+	l := ledger()
+	w := progressWriter(cmd)
+
+	var mu sync.Mutex
+	items := make(map[string]api.BulkItem, len(requests))
+	runner := &rollout.Runner{
+		Concurrency: concurrency,
+		Provision: func(ctx context.Context, region string) error {
+			v := requests[region]
+			item, waitErr := submitRequest(ctx, regionOp, tektonClient, statusClient, l, cmd.ErrOrStderr(), v, nil)
+			if item.Event != nil {
+				warnNotify(cmd.ErrOrStderr(), regionOp, v, notifyCompletion(ctx, regionOp, v, item.PipelineRun, waitErr))
+			}
+			mu.Lock()
+			items[region] = item
+			mu.Unlock()
+			if item.Error != "" {
+				return errors.New(item.Error)
+			}
+			return nil
+		},
+		OnChange: func(r rollout.Result) {
+			mu.Lock()
+			item, ok := items[r.Region]
+			mu.Unlock()
+			if !ok {
+				item = api.BulkItem{Request: requests[r.Region]}
+			}
+			printRolloutProgress(w, regionOp, r, item, time.Now())
+		},
+	}
+
+	results := runner.Run(cmd.Context(), definition.Regions)
+	regions := make([]api.RolloutItem, len(results))
+	for i, r := range results {
+		item, ok := items[r.Region]
+		if !ok {
+			item = api.BulkItem{Request: requests[r.Region]}
+		}
+		if r.Err != nil && item.Error == "" {
+			item.Error = r.Err.Error()
+		}
+		regions[i] = api.RolloutItem{Region: r.Region, After: r.After, Progress: r.State, BulkItem: item}
+	}
+	return regions
+}
+
+// warnNotify reports notifications that could not be sent without failing
+func warnNotify(errOut io.Writer, op *operations.Operation, v operations.Values, err error) {
+	if err != nil {
+		fmt.Fprintf(errOut, "Warning: failed to send notifications for %s: %v\n", op.Describe(v), err)
+	}
+}
+
+// printRolloutProgress prints a state change of a region of a rollout as soon
+// as it happens
+func printRolloutProgress(w io.Writer, regionOp *operations.Operation, r rollout.Result, item api.BulkItem, now time.Time) {
+	stamp := now.Format(clockLayout)
+	subject := regionOp.Describe(item.Request)
+	switch r.State {
+	case rollout.StateWaiting:
+		fmt.Fprintf(w, "[%s] ⏸ %s: waiting for %s\n", stamp, subject, strings.Join(r.After, ", "))
+	case rollout.StateRunning:
+		fmt.Fprintf(w, "[%s] ▶ %s: submitting\n", stamp, subject)
+	case rollout.StateSucceeded:
+		fmt.Fprintf(w, "[%s] ✓ %s: %s\n", stamp, subject, item.State)
+	case rollout.StateFailed:
+		fmt.Fprintf(w, "[%s] ✗ %s: %v\n", stamp, subject, r.Err)
+	case rollout.StateSkipped:
+		fmt.Fprintf(w, "[%s] ⊘ %s: skipped, %v\n", stamp, subject, r.Err)
+	}
+}
+
+// printSectorRollout prints the consolidated view of a rollout: the sector
+// request, then a table of its regions, followed by how many were provisioned
+func printSectorRollout(w io.Writer, result *api.SectorRollout) {
+	subject := result.Environment + "/" + result.Sector
+	switch item := result.SectorItem; {
+	case item == nil:
+		fmt.Fprintf(w, "Sector %s: existing\n\n", subject)
+	case item.Error != "":
+		fmt.Fprintf(w, "Sector %s: ✗ %s\n\n", subject, item.Error)
+	default:
+		fmt.Fprintf(w, "Sector %s: ✓ %s (event %s)\n\n", subject, orDash(item.State), orDash(item.Event.EventID))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGION\tAFTER\tEVENT ID\tSTATE\tDURATION\tRESULT")
+	for _, r := range result.Regions {
+		eventID, duration := "", ""
+		if r.Event != nil {
+			eventID = r.Event.EventID
+		}
+		if run := r.PipelineRun; run != nil && run.StartTime != "" {
+			duration = client.CalculateDuration(run.StartTime, run.CompletionTime)
+		}
+		outcome := "✓ OK"
+		switch r.Progress {
+		case rollout.StateFailed:
+			outcome = "✗ " + r.Error
+		case rollout.StateSkipped:
+			outcome = "⊘ skipped: " + r.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Region, orDash(strings.Join(r.After, ",")),
+			orDash(eventID), orDash(r.State), orDash(duration), outcome)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d of %d regions provisioned, %d failed, %d skipped\n",
+		result.Succeeded, len(result.Regions), result.Failed, result.Skipped)
+}
+
+// runSectorRolloutDryRun prints the webhook requests of a rollout instead of
+// sending them, the regions in the order of the definition with the regions
+// they would wait for
+func runSectorRolloutDryRun(cmd *cobra.Command, op *operations.Operation, values operations.Values, params operations.Params, regionOp *operations.Operation, definition *catalog.SectorDefinition, requests map[string]operations.Values) error {
+	tektonClient, err := newTektonClient()
+	if err != nil {
+		return err
+	}
+
+	var titles []string
+	var prepared []*api.WebhookRequest
+	if !existingSector {
+		req, err := prepareWebhookRequest(tektonClient, op, values, params)
+		if err != nil {
+			return err
+		}
+		titles = append(titles, fmt.Sprintf("%s %s", op.Name(), op.Describe(values)))
+		prepared = append(prepared, req)
+	}
+	for _, r := range definition.Regions {
+		req, err := prepareWebhookRequest(tektonClient, regionOp, requests[r.Region], nil)
+		if err != nil {
+			return err
+		}
+		title := fmt.Sprintf("%s %s", regionOp.Name(), regionOp.Describe(requests[r.Region]))
+		if len(r.After) > 0 {
+			title += ", after " + strings.Join(r.After, ", ")
+		}
+		titles = append(titles, title)
+		prepared = append(prepared, req)
+	}
+
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), prepared)
+	}
+	for i, req := range prepared {
+		if i > 0 {
+			fmt.Fprintln(cmd.OutOrStdout())
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "# %s\n", titles[i])
+		printWebhookRequest(cmd.OutOrStdout(), req)
+	}
+	fmt.Fprintln(cmd.ErrOrStderr(), "Dry run: nothing was sent")
+	return nil
+}
@@ -0,0 +1,32 @@
+package gcpctl
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// statusCmd is a shortcut for 'region status'
+var statusCmd = &cobra.Command{
+	Use:   "status [event-id]",
+	Short: "Show the status of a pipeline run by event ID",
+	Long: `Show the status of the pipeline run triggered by an event, as 'region status' does.
+
+With --follow, poll until the pipeline run finishes, printing task transitions
+as they happen. The command exits non-zero if the pipeline run fails, is
+cancelled or does not finish within --wait-timeout.
+
+Without an event ID, the latest submission of the active profile in the
+history is shown, see 'gcpctl history'.`,
+	Example: `  gcpctl status 63950e1f-7ffe-4d14-bc0e-121cee88942e
+  gcpctl status <event-id> --follow --wait-timeout 20m
+  gcpctl status --follow`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runRegionStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline runs")
+	statusCmd.Flags().BoolVarP(&follow, "follow", "f", false, "poll until the pipeline run finishes, exiting non-zero if it fails")
+	addFollowFlags(statusCmd)
+}
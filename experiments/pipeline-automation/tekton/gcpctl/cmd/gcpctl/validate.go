@@ -0,0 +1,76 @@
+package gcpctl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+var validateOperation string
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check a file of requests without submitting it",
+	Long: `Check the requests of a file given to --file of an operation, e.g. 'gcpctl
+region add --file', without submitting any: unknown fields, missing or invalid
+values, values outside the catalog and duplicate requests are reported, and
+the command exits non-zero if any request is invalid.
+
+Run it in CI on files of requests reviewed in pull requests, and
+'gcpctl <operation> --file <file> --dry-run' to see the exact webhook requests.`,
+	Example: `  gcpctl validate -f regions.yaml
+  gcpctl validate -f sectors.yaml --operation "sector add"
+  gcpctl validate -f regions.yaml -o json`,
+	Args: cobra.NoArgs,
+	RunE: runValidate,
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVarP(&requestsFile, "file", "f", "", "YAML or JSON file of requests, '-' for stdin (required)")
+	validateCmd.Flags().StringVar(&validateOperation, "operation", "region add", "operation the requests are for, see 'gcpctl operations'")
+	validateCmd.Flags().BoolVar(&skipCatalog, "skip-catalog", false, "do not validate the requests against the catalog")
+	validateCmd.MarkFlagRequired("file")
+}
+
+// validateResult is the output of 'gcpctl validate' for valid requests
+type validateResult struct {
+	File      string              `json:"file"`
+	Operation string              `json:"operation"`
+	Requests  []operations.Values `json:"requests"`
+}
+
+func runValidate(cmd *cobra.Command, args []string) error {
+	op, ok := operations.Lookup(validateOperation)
+	if !ok {
+		var names []string
+		for _, o := range operations.All() {
+			names = append(names, o.Name())
+		}
+		return fmt.Errorf("unknown operation %q, must be one of %s", validateOperation, strings.Join(names, ", "))
+	}
+
+	requests, err := readRequests(cmd.InOrStdin(), op, requestsFile)
+	if err != nil {
+		return err
+	}
+	defaults := operations.Values{}
+	for _, f := range op.Fields {
+		defaults[f.Name] = f.Default
+	}
+	fillDefaults(requests, defaults)
+
+	if err := checkRequests(cmd, op, requests); err != nil {
+		return err
+	}
+
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), validateResult{File: requestsFile, Operation: op.Name(), Requests: requests})
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "✓ %d %s requests in %s are valid\n", len(requests), op.Name(), requestsFile)
+	return nil
+}
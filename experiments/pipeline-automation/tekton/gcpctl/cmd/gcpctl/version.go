@@ -0,0 +1,134 @@
+package gcpctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/version"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var versionClientOnly bool
+
+// versionCmd represents the version command
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show the gcpctl and pipeline bundle versions",
+	Long: `Show the version of gcpctl and of the pipeline bundle of the management
+cluster, and warn when the two are known not to work together.
+
+The bundle version is read from version_url in the config file, or
+GCPCTL_VERSION_URL, an endpoint returning {"version": "..."} or the bare
+version. Without it, it is the app.kubernetes.io/version label of the most
+recent run of the region provisioning pipeline, read with the configured
+backend.`,
+	Example: `  gcpctl version
+  gcpctl version --client
+  gcpctl version -o json`,
+	Args: cobra.NoArgs,
+	RunE: runVersion,
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+
+	versionCmd.Flags().BoolVar(&versionClientOnly, "client", false, "only show the gcpctl version")
+	versionCmd.Flags().StringVarP(&namespace, "namespace", "n", "default", "namespace of the pipeline runs")
+}
+
+func runVersion(cmd *cobra.Command, args []string) error {
+	build := version.Get()
+	info := &api.VersionInfo{Client: api.ClientVersion{
+		Version:   build.Version,
+		Commit:    build.Commit,
+		BuildDate: build.BuildDate,
+		GoVersion: build.GoVersion,
+		Platform:  build.Platform,
+	}}
+
+	if !versionClientOnly {
+		bundle, err := bundleVersion(cmd.Context())
+		if err != nil {
+			info.BundleError = err.Error()
+		} else {
+			info.Bundle = bundle
+			reasons, err := version.DefaultMatrix().Check(build.Version, bundle.Version)
+			if err != nil {
+				logVerbose("Skipping the compatibility check: %v", err)
+			}
+			info.Warnings = reasons
+		}
+	}
+
+	if structuredOutput() {
+		return printStructured(cmd.OutOrStdout(), info)
+	}
+	printVersion(cmd.OutOrStdout(), info, versionClientOnly)
+	for _, warning := range info.Warnings {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: gcpctl %s does not work with bundle %s: %s\n",
+			info.Client.Version, info.Bundle.Version, warning)
+	}
+	return nil
+}
+
+// bundleVersion reads the bundle version from the version endpoint, or from
+// the latest region pipeline run if none is configured
+func bundleVersion(ctx context.Context) (*api.BundleVersion, error) {
+	if url := config.GetVersionURL(); url != "" {
+		logVerbose("Reading the bundle version from %s", url)
+		hc, err := httpClient()
+		if err != nil {
+			return nil, err
+		}
+		// The endpoint is served by the management cluster, behind the same
+		// proxy as the webhook
+		v, err := client.FetchBundleVersion(ctx, hc, url, config.GetHeaders())
+		if err != nil {
+			return nil, err
+		}
+		return &api.BundleVersion{Version: v, Source: url}, nil
+	}
+
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return nil, err
+	}
+	runs, err := statusClient.ListPipelineRuns(ctx, namespace, client.RegionPipelineSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pipeline runs: %w", err)
+	}
+	v, run := client.LatestBundleVersion(runs)
+	if v == "" {
+		return nil, fmt.Errorf("no run of %s in namespace %s has a %s label; set version_url or update the pipeline",
+			client.RegionPipelineName, namespace, client.BundleVersionLabel)
+	}
+	return &api.BundleVersion{Version: v, Source: "pipeline run " + namespace + "/" + run}, nil
+}
+
+// printVersion prints the versions in the format of 'gcpctl version'
+func printVersion(w io.Writer, info *api.VersionInfo, clientOnly bool) {
+	c := info.Client
+	fmt.Fprintf(w, "Client: %s\n", c.Version)
+	if c.Commit != "" {
+		fmt.Fprintf(w, "  Commit:     %s\n", c.Commit)
+	}
+	if c.BuildDate != "" {
+		fmt.Fprintf(w, "  Built:      %s\n", c.BuildDate)
+	}
+	fmt.Fprintf(w, "  Go version: %s\n", c.GoVersion)
+	fmt.Fprintf(w, "  Platform:   %s\n", c.Platform)
+
+	if clientOnly {
+		return
+	}
+	if info.Bundle == nil {
+		fmt.Fprintf(w, "Bundle: unknown (%s)\n", info.BundleError)
+		return
+	}
+	fmt.Fprintf(w, "Bundle: %s\n", info.Bundle.Version)
+	fmt.Fprintf(w, "  Source:     %s\n", info.Bundle.Source)
+}
@@ -0,0 +1,230 @@
+package gcpctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/config"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// ANSI sequences of the full-screen view of 'runs watch'
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l" // alternate screen, hidden cursor
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	clearScreen = "\x1b[H\x1b[2J"
+)
+
+var (
+	watchNamespaces []string
+	watchInterval   time.Duration
+	watchOnce       bool
+)
+
+// runsWatchCmd represents the runs watch command
+var runsWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch in-flight pipeline runs",
+	Long: `Show a live table of the pipeline runs that have not finished, with their
+status, duration and current task, refreshed every --interval. Press Ctrl-C
+to quit.
+
+The namespaces watched are given with --namespace, or watch_namespaces in the
+config file, "default" if neither is set.
+
+When the output is not a terminal, or with -o json|yaml, every refresh is
+printed after the previous one instead.`,
+	Example: `  gcpctl runs watch
+  gcpctl runs watch -n default -n production --interval 10s
+  gcpctl runs watch --pipeline gcp-region-provisioning-pipeline
+  gcpctl runs watch --once -o json`,
+	Args: cobra.NoArgs,
+	RunE: runRunsWatch,
+}
+
+func init() {
+	runsCmd.AddCommand(runsWatchCmd)
+
+	runsWatchCmd.Flags().StringSliceVarP(&watchNamespaces, "namespace", "n", nil, "namespace to watch, repeatable (overrides config, default the watch_namespaces setting)")
+	runsWatchCmd.Flags().StringVarP(&runsPipeline, "pipeline", "p", "", "only watch runs of this pipeline")
+	runsWatchCmd.Flags().DurationVar(&watchInterval, "interval", 5*time.Second, "delay between two refreshes")
+	runsWatchCmd.Flags().BoolVar(&watchOnce, "once", false, "show the in-flight runs once and exit")
+}
+
+func runRunsWatch(cmd *cobra.Command, args []string) error {
+	if watchInterval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	namespaces := watchNamespaces
+	if len(namespaces) == 0 {
+		namespaces = config.GetWatchNamespaces()
+	}
+	if len(namespaces) == 0 {
+		return fmt.Errorf("no namespace to watch, set --namespace or watch_namespaces")
+	}
+
+	statusClient, err := newStatusClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := cmd.OutOrStdout()
+	fullScreen := !watchOnce && !structuredOutput() && isTerminal(w)
+	if fullScreen {
+		fmt.Fprint(w, enterScreen)
+		defer fmt.Fprint(w, leaveScreen)
+	}
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for refresh := 0; ; refresh++ {
+		watch := watchRuns(ctx, statusClient, namespaces)
+		if ctx.Err() != nil {
+			// Interrupted while reading the runs
+			return nil
+		}
+
+		switch {
+		case structuredOutput():
+			if refresh > 0 && config.GetOutput() == outputYAML {
+				fmt.Fprintln(w, "---")
+			}
+			if err := printStructured(w, watch); err != nil {
+				return err
+			}
+		case fullScreen:
+			width, height, err := term.GetSize(int(w.(*os.File).Fd()))
+			if err != nil {
+				width, height = 0, 0
+			}
+			fmt.Fprint(w, clearScreen)
+			printWatch(w, watch, width, height)
+		default:
+			if refresh > 0 {
+				fmt.Fprintln(w)
+			}
+			printWatch(w, watch, 0, 0)
+		}
+
+		if watchOnce {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchRuns reads the in-flight runs of every namespace. Namespaces that
+// cannot be read are reported in the errors of the refresh, so one failing
+// namespace does not hide the others.
+func watchRuns(ctx context.Context, c client.ClusterClient, namespaces []string) *api.RunWatch {
+	now := time.Now()
+	watch := &api.RunWatch{
+		Time:       now.UTC().Format(time.RFC3339),
+		Namespaces: namespaces,
+		Items:      []api.InFlightRun{},
+	}
+	for _, ns := range namespaces {
+		runs, err := c.ListPipelineRuns(ctx, ns, client.PipelineSelector(runsPipeline))
+		if err == nil {
+			var items []api.InFlightRun
+			items, err = client.InFlightRuns(ctx, c, runs, now)
+			watch.Items = append(watch.Items, items...)
+		}
+		if err != nil {
+			logVerbose("Failed to read the pipeline runs of namespace %s: %v", ns, err)
+			if watch.Errors == nil {
+				watch.Errors = make(map[string]string)
+			}
+			watch.Errors[ns] = err.Error()
+		}
+	}
+	sort.SliceStable(watch.Items, func(i, j int) bool {
+		return watch.Items[i].StartTime > watch.Items[j].StartTime
+	})
+	return watch
+}
+
+// printWatch prints a refresh of 'runs watch'. With a width and height, the
+// lines are cut to fit the terminal.
+func printWatch(w io.Writer, watch *api.RunWatch, width, height int) {
+	stamp := time.Now().Format(clockLayout)
+	if t, err := time.Parse(time.RFC3339, watch.Time); err == nil {
+		stamp = t.Local().Format(clockLayout)
+	}
+	header := []string{
+		fmt.Sprintf("Every %s: %d in-flight pipeline runs in %s    %s",
+			watchInterval, len(watch.Items), strings.Join(watch.Namespaces, ", "), stamp),
+	}
+	for _, ns := range watch.Namespaces {
+		if msg, ok := watch.Errors[ns]; ok {
+			header = append(header, fmt.Sprintf("✗ namespace %s: %s", ns, msg))
+		}
+	}
+	header = append(header, "")
+
+	var table strings.Builder
+	runs := make([]runRef, 0, len(watch.Items))
+	if len(watch.Items) == 0 {
+		table.WriteString("No pipeline runs in flight\n")
+	} else {
+		tw := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tNAMESPACE\tPIPELINE\tREGION\tACTION\tSTATUS\tCURRENT TASK\tDONE\tDURATION")
+		for _, r := range watch.Items {
+			duration := "N/A"
+			if r.StartTime != "" {
+				duration = client.FormatDuration(time.Duration(r.DurationSeconds) * time.Second)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s %s\t%s\t%d\t%s\n",
+				r.Name, r.Namespace, orDash(r.Pipeline), orDash(r.Region), orDash(r.Action),
+				client.GetStatusEmoji(r.Status), r.Status, orDash(strings.Join(r.CurrentTasks, ", ")),
+				r.CompletedTasks, duration)
+			runs = append(runs, runRef{namespace: r.Namespace, name: r.Name})
+		}
+		tw.Flush()
+	}
+
+	rows := strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")
+	if maxRows := height - 1 - len(header); height > 0 && maxRows > 1 && len(rows) > maxRows {
+		// Keep a line for the runs that do not fit
+		hidden := len(rows) - (maxRows - 1)
+		rows = append(rows[:maxRows-1], fmt.Sprintf("... %d more", hidden))
+	}
+	if width > 0 {
+		for i := range header {
+			header[i] = cutLine(header[i], width-1)
+		}
+		for i := range rows {
+			rows[i] = cutLine(rows[i], width-1)
+		}
+	}
+
+	io.WriteString(w, strings.Join(header, "\n")+"\n")
+	writeLinkedTable(w, strings.Join(rows, "\n")+"\n", runs)
+}
+
+// cutLine shortens a line to at most width runes
+func cutLine(line string, width int) string {
+	r := []rune(line)
+	if width < 1 || len(r) <= width {
+		return line
+	}
+	return string(r[:width])
+}
@@ -0,0 +1,41 @@
+// Command payloadgen generates the webhook payload types of pkg/api and the
+// payload documentation of the Tekton resources from the JSON schemas in
+// pkg/api/schemas. It runs from go generate in pkg/api:
+//
+//	make generate
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/payloadgen"
+)
+
+func main() {
+	var opts payloadgen.Options
+	flag.StringVar(&opts.SchemaDir, "schemas", "schemas", "directory of the payload schemas")
+	flag.StringVar(&opts.GoFile, "go", "payloads_gen.go", "generated Go file")
+	flag.StringVar(&opts.Package, "package", "api", "package of the generated Go file")
+	flag.StringVar(&opts.DocsFile, "docs", "", "generated Markdown documentation")
+	flag.StringVar(&opts.Root, "root", "", "root of the Tekton resources, binding paths are relative to it")
+	flag.Parse()
+
+	if opts.DocsFile == "" || opts.Root == "" {
+		fmt.Fprintln(os.Stderr, "payloadgen: -docs and -root are required")
+		os.Exit(2)
+	}
+
+	files, err := payloadgen.Generate(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "payloadgen: %v\n", err)
+		os.Exit(1)
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "payloadgen: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
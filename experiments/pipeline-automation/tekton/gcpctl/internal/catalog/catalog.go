@@ -0,0 +1,327 @@
+package catalog
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"sigs.k8s.io/yaml"
+)
+
+// maxSectorLength is the longest sector the pipeline accepts
+const maxSectorLength = 40
+
+// fetchTimeout bounds fetching a catalog from a URL
+const fetchTimeout = 10 * time.Second
+
+//go:embed catalog.yaml
+var embedded []byte
+
+// Catalog lists the environments, sectors and GCP regions regions can be
+// managed in. An empty list accepts any value.
+type Catalog struct {
+	Environments []string `json:"environments"`
+	Sectors      []string `json:"sectors"`
+	Regions      []string `json:"regions"`
+	// SectorDefinitions list the regions of the sectors, see SectorDefinition
+	SectorDefinitions []SectorDefinition `json:"sectorDefinitions,omitempty"`
+	// Source is where the catalog was read from
+	Source string `json:"source,omitempty"`
+}
+
+// SectorDefinition lists the regions 'gcpctl sector add --regions' provisions
+// in a sector of an environment, or of every environment if Environment is
+// empty
+type SectorDefinition struct {
+	Environment string `json:"environment,omitempty"`
+	Sector      string `json:"sector"`
+	// Sequential submits every region once the one before it succeeded
+	Sequential bool           `json:"sequential,omitempty"`
+	Regions    []SectorRegion `json:"regions"`
+}
+
+// SectorRegion is a region of a sector definition
+type SectorRegion struct {
+	Region string `json:"region"`
+	// After lists regions of the definition whose provisioning must have
+	// succeeded before this region is submitted
+	After []string `json:"after,omitempty"`
+}
+
+// Default returns the catalog built into gcpctl
+func Default() *Catalog {
+	c, err := Parse(embedded)
+	if err != nil {
+		panic(fmt.Sprintf("embedded catalog is invalid: %v", err))
+	}
+	c.Source = "embedded"
+	return c
+}
+
+// Parse reads a catalog in YAML or JSON
+func Parse(data []byte) (*Catalog, error) {
+	var c Catalog
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog: %w", err)
+	}
+	return &c, nil
+}
+
+// Load reads a catalog from an http(s) URL, with hc or http.DefaultClient if
+// it is nil, or from a file path
+func Load(ctx context.Context, hc *http.Client, location string) (*Catalog, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		data, err = fetch(ctx, hc, location)
+	} else {
+		data, err = os.ReadFile(location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog %s: %w", location, err)
+	}
+
+	c, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	c.Source = location
+	return c, nil
+}
+
+func fetch(ctx context.Context, hc *http.Client, url string) ([]byte, error) {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/yaml, application/json")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// Validate checks the environment, sector and region of a request against the
+// catalog. Unknown values are reported with the closest known value, if one
+// is close enough to be a typo.
+func (c *Catalog) Validate(req *api.RegionRequest) error {
+	for _, f := range []struct{ field, value string }{
+		{"environment", req.Environment},
+		{"sector", req.Sector},
+		{"region", req.Region},
+	} {
+		if err := c.Check(f.field, f.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check checks a single environment, sector or region against the catalog.
+// Empty values and other fields are not checked.
+func (c *Catalog) Check(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch field {
+	case "environment":
+		return check(field, value, c.Environments)
+	case "sector":
+		if len(value) > maxSectorLength {
+			return &api.ValidationError{
+				Field:   "sector",
+				Message: fmt.Sprintf("sector %q is longer than %d characters", value, maxSectorLength),
+			}
+		}
+		return check(field, value, c.Sectors)
+	case "region":
+		return check(field, value, c.Regions)
+	default:
+		return nil
+	}
+}
+
+func check(field, value string, known []string) error {
+	if len(known) == 0 {
+		return nil
+	}
+	for _, k := range known {
+		if value == k {
+			return nil
+		}
+	}
+
+	msg := fmt.Sprintf("unknown %s %q", field, value)
+	if suggestion := Suggest(value, known); suggestion != "" {
+		msg += fmt.Sprintf(", did you mean %s?", suggestion)
+	} else {
+		msg += fmt.Sprintf(", run 'gcpctl catalog' to list the known %ss", field)
+	}
+	return &api.ValidationError{Field: field, Message: msg}
+}
+
+// Suggest returns the known value closest to value, or "" if none is within a
+// few edits. Case differences are not counted.
+func Suggest(value string, known []string) string {
+	value = strings.ToLower(value)
+	// Allow roughly one typo per four characters, at least two
+	maxDistance := max(2, len(value)/4)
+
+	best, bestDistance := "", maxDistance+1
+	for _, k := range known {
+		if d := distance(value, strings.ToLower(k)); d < bestDistance {
+			best, bestDistance = k, d
+		}
+	}
+	return best
+}
+
+// distance is the Damerau-Levenshtein (optimal string alignment) distance,
+// so swapped neighbouring characters count as one edit
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// Sector returns the definition of a sector in an environment: the one of
+// the environment, else the one of every environment. The dependencies of a
+// sequential definition are made explicit in the returned copy. The regions
+// are checked against the catalog, and their dependencies for unknown regions
+// and cycles.
+func (c *Catalog) Sector(environment, sector string) (*SectorDefinition, error) {
+	var found *SectorDefinition
+	for i, d := range c.SectorDefinitions {
+		if d.Sector != sector {
+			continue
+		}
+		if d.Environment == environment {
+			found = &c.SectorDefinitions[i]
+			break
+		}
+		if d.Environment == "" && found == nil {
+			found = &c.SectorDefinitions[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("the catalog (%s) has no definition of sector %q in environment %q", c.Source, sector, environment)
+	}
+
+	d := &SectorDefinition{Environment: environment, Sector: sector, Sequential: found.Sequential}
+	for i, r := range found.Regions {
+		after := slices.Clone(r.After)
+		if found.Sequential && len(after) == 0 && i > 0 {
+			after = []string{found.Regions[i-1].Region}
+		}
+		d.Regions = append(d.Regions, SectorRegion{Region: r.Region, After: after})
+	}
+	if err := d.check(c); err != nil {
+		return nil, fmt.Errorf("invalid definition of sector %q in environment %q: %w", sector, environment, err)
+	}
+	return d, nil
+}
+
+// check validates the regions of a definition and their dependencies
+func (d *SectorDefinition) check(c *Catalog) error {
+	if len(d.Regions) == 0 {
+		return fmt.Errorf("no regions")
+	}
+	index := map[string]int{}
+	for i, r := range d.Regions {
+		if r.Region == "" {
+			return fmt.Errorf("region %d has no name", i+1)
+		}
+		if err := c.Check("region", r.Region); err != nil {
+			return err
+		}
+		if _, ok := index[r.Region]; ok {
+			return fmt.Errorf("region %s listed twice", r.Region)
+		}
+		index[r.Region] = i
+	}
+	for _, r := range d.Regions {
+		for _, after := range r.After {
+			if _, ok := index[after]; !ok {
+				return fmt.Errorf("region %s comes after %s, which is not a region of the sector", r.Region, after)
+			}
+		}
+	}
+
+	// Visit the regions depth-first: a region met again while its own
+	// dependencies are being visited is part of a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(d.Regions))
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		path = append(path, d.Regions[i].Region)
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("dependency cycle %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, after := range d.Regions[i].After {
+			if err := visit(index[after], path); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range d.Regions {
+		if err := visit(i, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+func TestDefault(t *testing.T) {
+	c := Default()
+	if c.Source != "embedded" {
+		t.Errorf("Source = %v, want embedded", c.Source)
+	}
+	for _, want := range [][]string{c.Environments, c.Sectors, c.Regions} {
+		if len(want) == 0 {
+			t.Errorf("embedded catalog = %+v, want every list filled", c)
+		}
+	}
+	if err := c.Validate(&api.RegionRequest{Environment: "integration", Sector: "main", Region: "us-central1"}); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	for _, d := range c.SectorDefinitions {
+		if _, err := c.Sector(d.Environment, d.Sector); err != nil {
+			t.Errorf("embedded sector definition: %v", err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := &Catalog{
+		Environments: []string{"integration", "staging", "production"},
+		Sectors:      []string{"main", "canary"},
+		Regions:      []string{"us-central1", "us-east1", "europe-west1"},
+	}
+
+	tests := []struct {
+		name    string
+		req     api.RegionRequest
+		field   string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			req:  api.RegionRequest{Environment: "staging", Sector: "canary", Region: "europe-west1"},
+		},
+		{
+			name:    "region typo",
+			req:     api.RegionRequest{Environment: "staging", Sector: "main", Region: "us-centrl1"},
+			field:   "region",
+			wantErr: `unknown region "us-centrl1", did you mean us-central1?`,
+		},
+		{
+			name:    "swapped characters",
+			req:     api.RegionRequest{Environment: "prodcution", Sector: "main", Region: "us-east1"},
+			field:   "environment",
+			wantErr: "did you mean production?",
+		},
+		{
+			name:    "case",
+			req:     api.RegionRequest{Environment: "staging", Sector: "Main", Region: "us-east1"},
+			field:   "sector",
+			wantErr: "did you mean main?",
+		},
+		{
+			name:    "no close match",
+			req:     api.RegionRequest{Environment: "staging", Sector: "main", Region: "mars-north1"},
+			field:   "region",
+			wantErr: `unknown region "mars-north1", run 'gcpctl catalog' to list the known regions`,
+		},
+		{
+			name:    "sector too long",
+			req:     api.RegionRequest{Environment: "staging", Sector: strings.Repeat("a", 41), Region: "us-east1"},
+			field:   "sector",
+			wantErr: "longer than 40 characters",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.Validate(&tt.req)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+
+			var validationErr *api.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() error = %v, want a ValidationError", err)
+			}
+			if validationErr.Field != tt.field || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v (field %s), want %q (field %s)", err, validationErr.Field, tt.wantErr, tt.field)
+			}
+		})
+	}
+}
+
+func TestValidate_EmptyListAcceptsAny(t *testing.T) {
+	c := &Catalog{Regions: []string{"us-central1"}}
+	if err := c.Validate(&api.RegionRequest{Environment: "dev", Sector: "anything", Region: "us-central1"}); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	known := []string{"us-central1", "us-east1", "us-east4", "asia-east1"}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"us-central", "us-central1"},
+		{"us-eats1", "us-east1"},
+		{"US-EAST4", "us-east4"},
+		{"europe-west1", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Suggest(tt.value, known); got != tt.want {
+			t.Errorf("Suggest(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	const data = "environments: [integration]\nsectors: []\nregions: [us-central1]\n"
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "catalog.yaml")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := Load(context.Background(), nil, path)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if c.Source != path || len(c.Environments) != 1 || len(c.Sectors) != 0 {
+			t.Errorf("Load() = %+v", c)
+		}
+	})
+
+	t.Run("url", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"environments":["integration"],"regions":["us-central1"]}`))
+		}))
+		defer server.Close()
+
+		c, err := Load(context.Background(), nil, server.URL)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if c.Source != server.URL || len(c.Regions) != 1 || c.Regions[0] != "us-central1" {
+			t.Errorf("Load() = %+v", c)
+		}
+	})
+
+	t.Run("http error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not found", http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		if _, err := Load(context.Background(), nil, server.URL); err == nil || !strings.Contains(err.Error(), "404") {
+			t.Errorf("Load() error = %v, want status code 404", err)
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "catalog.yaml")
+		if err := os.WriteFile(path, []byte("zones: [us-central1-a]\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Load(context.Background(), nil, path); err == nil {
+			t.Error("Load() should return error for an unknown field")
+		}
+	})
+}
+
+func TestSector(t *testing.T) {
+	c := &Catalog{
+		Regions: []string{"us-central1", "us-east1", "europe-west1"},
+		SectorDefinitions: []SectorDefinition{
+			{Sector: "main", Regions: []SectorRegion{
+				{Region: "us-central1"},
+				{Region: "us-east1", After: []string{"us-central1"}},
+				{Region: "europe-west1", After: []string{"us-central1"}},
+			}},
+			{Environment: "production", Sector: "main", Sequential: true, Regions: []SectorRegion{
+				{Region: "us-central1"},
+				{Region: "us-east1"},
+				{Region: "europe-west1", After: []string{"us-central1"}},
+			}},
+		},
+	}
+
+	// describe lists the regions of a definition with their dependencies
+	describe := func(d *SectorDefinition) string {
+		var parts []string
+		for _, r := range d.Regions {
+			parts = append(parts, r.Region+"<"+strings.Join(r.After, "+"))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	d, err := c.Sector("integration", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := describe(d), "us-central1< us-east1<us-central1 europe-west1<us-central1"; got != want {
+		t.Errorf("Sector(integration) = %s, want %s", got, want)
+	}
+
+	// The definition of the environment wins, explicit dependencies stay
+	d, err = c.Sector("production", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := describe(d), "us-central1< us-east1<us-central1 europe-west1<us-central1"; got != want || d.Environment != "production" {
+		t.Errorf("Sector(production) = %s, want %s", got, want)
+	}
+	if after := c.SectorDefinitions[1].Regions[1].After; after != nil {
+		t.Errorf("Sector() changed the catalog: %v", after)
+	}
+
+	if _, err := c.Sector("production", "canary"); err == nil || !strings.Contains(err.Error(), `no definition of sector "canary"`) {
+		t.Errorf("Sector(canary) error = %v, want no definition", err)
+	}
+}
+
+func TestSector_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		regions []SectorRegion
+		wantErr string
+	}{
+		{"empty", nil, "no regions"},
+		{"unknown region", []SectorRegion{{Region: "us-centrl1"}}, "did you mean us-central1?"},
+		{"duplicate", []SectorRegion{{Region: "us-east1"}, {Region: "us-east1"}}, "us-east1 listed twice"},
+		{"unknown dependency", []SectorRegion{{Region: "us-east1", After: []string{"us-central1"}}}, "us-central1, which is not a region of the sector"},
+		{"cycle", []SectorRegion{
+			{Region: "us-central1", After: []string{"europe-west1"}},
+			{Region: "us-east1", After: []string{"us-central1"}},
+			{Region: "europe-west1", After: []string{"us-east1"}},
+		}, "dependency cycle us-central1 -> europe-west1 -> us-east1 -> us-central1"},
+		{"self", []SectorRegion{{Region: "us-east1", After: []string{"us-east1"}}}, "dependency cycle us-east1 -> us-east1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Catalog{
+				Regions:           []string{"us-central1", "us-east1", "europe-west1"},
+				SectorDefinitions: []SectorDefinition{{Sector: "main", Regions: tt.regions}},
+			}
+			_, err := c.Sector("integration", "main")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Sector() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AccessCheck is an action on a resource, checked with a
+// SelfSubjectAccessReview
+type AccessCheck struct {
+	Verb        string `json:"verb"`
+	Group       string `json:"group,omitempty"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+}
+
+// String describes the check as kubectl auth can-i does, e.g.
+// "list pipelineruns.tekton.dev -n default"
+func (a AccessCheck) String() string {
+	resource := a.Resource
+	if a.Group != "" {
+		resource += "." + a.Group
+	}
+	if a.Subresource != "" {
+		resource += "/" + a.Subresource
+	}
+	s := a.Verb + " " + resource
+	if a.Namespace != "" {
+		s += " -n " + a.Namespace
+	}
+	return s
+}
+
+// AccessReview is the answer of the API server to an AccessCheck. Reason is
+// why the action is allowed or denied, if the authorizer says.
+type AccessReview struct {
+	Allowed bool
+	Reason  string
+}
+
+// AccessReviewer checks what the current user may do in the cluster
+type AccessReviewer interface {
+	ReviewAccess(ctx context.Context, check AccessCheck) (*AccessReview, error)
+}
+
+// selfSubjectAccessReview returns the SelfSubjectAccessReview of a check
+func selfSubjectAccessReview(check AccessCheck) *authorizationv1.SelfSubjectAccessReview {
+	return &authorizationv1.SelfSubjectAccessReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "authorization.k8s.io/v1", Kind: "SelfSubjectAccessReview"},
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   check.Namespace,
+				Verb:        check.Verb,
+				Group:       check.Group,
+				Resource:    check.Resource,
+				Subresource: check.Subresource,
+			},
+		},
+	}
+}
+
+// accessReview returns the answer of a reviewed SelfSubjectAccessReview
+func accessReview(status authorizationv1.SubjectAccessReviewStatus) *AccessReview {
+	reason := status.Reason
+	if reason == "" {
+		reason = status.EvaluationError
+	}
+	return &AccessReview{Allowed: status.Allowed && !status.Denied, Reason: reason}
+}
+
+// ReviewAccess checks an action with a SelfSubjectAccessReview
+func (c *KubeconfigClient) ReviewAccess(ctx context.Context, check AccessCheck) (*AccessReview, error) {
+	review, err := c.core.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, selfSubjectAccessReview(check), metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to review access: %w", err)
+	}
+	return accessReview(review.Status), nil
+}
+
+// ReviewAccess checks an action with a SelfSubjectAccessReview created with
+// kubectl. Unlike kubectl auth can-i, it tells the reason of the authorizer.
+func (c *KubectlClient) ReviewAccess(ctx context.Context, check AccessCheck) (*AccessReview, error) {
+	body, err := json.Marshal(selfSubjectAccessReview(check))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode access review: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, "kubectl", "create", "-f", "-", "-o", "json")
+	cmd.Stdin = bytes.NewReader(body)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("kubectl command failed: %s", string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("failed to execute kubectl: %w", err)
+	}
+
+	var review authorizationv1.SelfSubjectAccessReview
+	if err := json.Unmarshal(output, &review); err != nil {
+		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
+	}
+	return accessReview(review.Status), nil
+}
+
+// ReviewAccess checks an action with a SelfSubjectAccessReview posted to the
+// Kubernetes API behind the Tekton API URL, e.g. a kubectl proxy
+func (c *TektonAPIClient) ReviewAccess(ctx context.Context, check AccessCheck) (*AccessReview, error) {
+	body, err := json.Marshal(selfSubjectAccessReview(check))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode access review: %w", err)
+	}
+
+	var review authorizationv1.SelfSubjectAccessReview
+	endpoint := c.baseURL + "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews"
+	if err := c.do(ctx, http.MethodPost, endpoint, contentType, body, &review); err != nil {
+		return nil, fmt.Errorf("failed to review access: %w", err)
+	}
+	return accessReview(review.Status), nil
+}
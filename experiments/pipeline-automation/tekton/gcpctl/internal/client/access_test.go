@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestAccessCheck_String(t *testing.T) {
+	tests := []struct {
+		check AccessCheck
+		want  string
+	}{
+		{AccessCheck{Verb: "list", Group: "tekton.dev", Resource: "pipelineruns", Namespace: "default"}, "list pipelineruns.tekton.dev -n default"},
+		{AccessCheck{Verb: "get", Resource: "pods", Subresource: "log", Namespace: "ci"}, "get pods/log -n ci"},
+		{AccessCheck{Verb: "list", Resource: "namespaces"}, "list namespaces"},
+	}
+	for _, tt := range tests {
+		if got := tt.check.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestTektonAPIClient_ReviewAccess(t *testing.T) {
+	var got authorizationv1.SelfSubjectAccessReview
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews" {
+			t.Errorf("request = %s %s, want a SelfSubjectAccessReview", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding review: %v", err)
+		}
+		got.Status = authorizationv1.SubjectAccessReviewStatus{Reason: `RBAC: no role binds "delete"`}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(got)
+	}))
+	defer server.Close()
+
+	check := AccessCheck{Verb: "delete", Group: "tekton.dev", Resource: "pipelineruns", Namespace: "default"}
+	review, err := NewTektonAPIClient(server.URL).ReviewAccess(context.Background(), check)
+	if err != nil {
+		t.Fatalf("ReviewAccess() error = %v", err)
+	}
+	if review.Allowed || review.Reason != `RBAC: no role binds "delete"` {
+		t.Errorf("ReviewAccess() = %+v, want denied with the authorizer's reason", review)
+	}
+	attrs := got.Spec.ResourceAttributes
+	if attrs == nil || attrs.Verb != "delete" || attrs.Group != "tekton.dev" || attrs.Resource != "pipelineruns" || attrs.Namespace != "default" {
+		t.Errorf("reviewed attributes = %+v, want those of the check", attrs)
+	}
+}
+
+func TestKubeconfigClient_ReviewAccess(t *testing.T) {
+	core := kubefake.NewClientset()
+	core.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Verb == "get"
+		return true, review, nil
+	})
+	c := newKubeconfigClient(nil, core)
+
+	for verb, want := range map[string]bool{"get": true, "delete": false} {
+		review, err := c.ReviewAccess(context.Background(), AccessCheck{Verb: verb, Group: "tekton.dev", Resource: "pipelineruns", Namespace: "default"})
+		if err != nil {
+			t.Fatalf("ReviewAccess(%s) error = %v", verb, err)
+		}
+		if review.Allowed != want {
+			t.Errorf("ReviewAccess(%s) allowed = %v, want %v", verb, review.Allowed, want)
+		}
+	}
+}
+
+func TestTektonClient_ProbeRoute(t *testing.T) {
+	var method, path, header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, header = r.Method, r.URL.Path, r.Header.Get("X-Proxy-Token")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+
+	c := NewTektonClient(server.URL)
+	c.SetHeaders(map[string]string{"X-Proxy-Token": "t0ken"})
+	code, err := c.ProbeRoute(context.Background(), "sector")
+	if err != nil {
+		t.Fatalf("ProbeRoute() error = %v", err)
+	}
+	if code != http.StatusMethodNotAllowed || method != http.MethodHead || path != "/sector" || header != "t0ken" {
+		t.Errorf("ProbeRoute() = %d for %s %s with header %q, want 405 for HEAD /sector with the custom header", code, method, path, header)
+	}
+}
@@ -0,0 +1,289 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Versions of the tekton.dev API group, in order of preference
+const (
+	TektonV1      = "v1"
+	TektonV1beta1 = "v1beta1"
+)
+
+// tektonGroup is the API group of PipelineRuns and TaskRuns
+const tektonGroup = "tekton.dev"
+
+// tektonVersions are the versions of tekton.dev gcpctl understands, preferred
+// first
+var tektonVersions = []string{TektonV1, TektonV1beta1}
+
+// ErrTektonAPINotServed is returned when the cluster serves none of the
+// tekton.dev versions gcpctl understands
+var ErrTektonAPINotServed = errors.New("the cluster serves neither tekton.dev/v1 nor tekton.dev/v1beta1")
+
+// StatusError is an unexpected status code of the Tekton API
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Tekton API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// missingObject reports whether a 404 is about a pipeline run or TaskRun that
+// does not exist, rather than an API version the cluster does not serve: the
+// API server names the missing object in the details of its Status
+func (e *StatusError) missingObject() bool {
+	var status struct {
+		Details struct {
+			Name string `json:"name"`
+		} `json:"details"`
+	}
+	return json.Unmarshal([]byte(e.Body), &status) == nil && status.Details.Name != ""
+}
+
+// tektonResourcePath returns the API path of tekton.dev resources of a
+// namespace, or of one of them if name is set
+func tektonResourcePath(version, namespace, resource, name string) string {
+	path := fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", tektonGroup, version, namespace, resource)
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+// apiVersion returns the tekton.dev version requests are sent to: v1 until a
+// request showed that the cluster does not serve it
+func (c *TektonAPIClient) apiVersion() string {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	if c.version == "" {
+		return TektonV1
+	}
+	return c.version
+}
+
+// negotiateVersion finds the preferred tekton.dev version the cluster serves
+// with API discovery. The result is kept for the following requests.
+func (c *TektonAPIClient) negotiateVersion(ctx context.Context) (string, error) {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	if c.negotiated {
+		return c.version, nil
+	}
+
+	var group struct {
+		Versions []struct {
+			Version string `json:"version"`
+		} `json:"versions"`
+	}
+	err := c.do(ctx, http.MethodGet, c.baseURL+"/apis/"+tektonGroup, "", nil, &group)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w: the tekton.dev API group is not found at %s, check that Tekton Pipelines is installed and tekton_api_url points to the Kubernetes API server",
+			ErrTektonAPINotServed, c.baseURL)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to discover the tekton.dev API versions: %w", err)
+	}
+
+	var served []string
+	for _, v := range group.Versions {
+		served = append(served, v.Version)
+	}
+	for _, v := range tektonVersions {
+		if slices.Contains(served, v) {
+			c.version, c.negotiated = v, true
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("%w, it serves %s", ErrTektonAPINotServed, strings.Join(served, ", "))
+}
+
+// doTekton sends a request about tekton.dev resources, see do. Objects are
+// sent and returned in v1. If the cluster does not serve v1, the request is
+// sent again with the version found by API discovery, converting objects
+// between v1 and that version.
+func (c *TektonAPIClient) doTekton(ctx context.Context, method, namespace, resource, name, query, contentType string, body map[string]any, out any) error {
+	version := c.apiVersion()
+	err := c.doTektonVersion(ctx, version, method, namespace, resource, name, query, contentType, body, out)
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound || statusErr.missingObject() {
+		return err
+	}
+	negotiated, nerr := c.negotiateVersion(ctx)
+	if nerr != nil {
+		return nerr
+	}
+	if negotiated == version {
+		return err
+	}
+	return c.doTektonVersion(ctx, negotiated, method, namespace, resource, name, query, contentType, body, out)
+}
+
+// doTektonVersion sends a request to a version of the tekton.dev API,
+// converting objects from v1 to that version and back
+func (c *TektonAPIClient) doTektonVersion(ctx context.Context, version, method, namespace, resource, name, query, contentType string, body map[string]any, out any) error {
+	url := c.baseURL + tektonResourcePath(version, namespace, resource, name)
+	if query != "" {
+		url += "?" + query
+	}
+
+	var data []byte
+	if body != nil {
+		obj := body
+		// Patches have no apiVersion and are the same in every version
+		if version != TektonV1 && stringField(body, "apiVersion") != "" {
+			obj = convertFromV1(deepCopy(body), version)
+		}
+		var err error
+		if data, err = json.Marshal(obj); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", resource, err)
+		}
+	}
+
+	if out == nil || version == TektonV1 {
+		return c.do(ctx, method, url, contentType, data, out)
+	}
+
+	var raw map[string]any
+	if err := c.do(ctx, method, url, contentType, data, &raw); err != nil {
+		return err
+	}
+	convertToV1(raw)
+	converted, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s: %w", resource, err)
+	}
+	if err := json.Unmarshal(converted, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// convertToV1 converts a v1beta1 PipelineRun, TaskRun or list of them to v1
+// in place. Only the fields that moved between the versions are converted.
+// The embedded task statuses of v1beta1 pipeline runs are kept, as gcpctl
+// reads task statuses from them.
+func convertToV1(obj map[string]any) {
+	if items, ok := obj["items"].([]any); ok {
+		for _, item := range items {
+			if m, ok := item.(map[string]any); ok {
+				convertToV1(m)
+			}
+		}
+	}
+	if !strings.HasPrefix(stringField(obj, "apiVersion"), tektonGroup+"/") {
+		return
+	}
+	obj["apiVersion"] = tektonGroup + "/" + TektonV1
+
+	switch stringField(obj, "kind") {
+	case "PipelineRun":
+		spec, _, _ := unstructured.NestedMap(obj, "spec")
+		if spec == nil {
+			return
+		}
+		moveField(spec, "serviceAccountName", "taskRunTemplate", "serviceAccountName")
+		moveField(spec, "podTemplate", "taskRunTemplate", "podTemplate")
+		if _, ok := spec["timeouts"]; !ok {
+			moveField(spec, "timeout", "timeouts", "pipeline")
+		}
+		delete(spec, "timeout")
+		renameTaskRunSpecFields(spec, map[string]string{
+			"taskServiceAccountName": "serviceAccountName",
+			"taskPodTemplate":        "podTemplate",
+		})
+		obj["spec"] = spec
+	case "TaskRun":
+		status, _, _ := unstructured.NestedMap(obj, "status")
+		if status == nil {
+			return
+		}
+		moveField(status, "taskResults", "results")
+		obj["status"] = status
+	}
+}
+
+// convertFromV1 converts a v1 PipelineRun to version, for creating it, and
+// returns it
+func convertFromV1(obj map[string]any, version string) map[string]any {
+	obj["apiVersion"] = tektonGroup + "/" + version
+	if version != TektonV1beta1 || stringField(obj, "kind") != "PipelineRun" {
+		return obj
+	}
+
+	spec, _, _ := unstructured.NestedMap(obj, "spec")
+	if spec == nil {
+		return obj
+	}
+	if template, ok := spec["taskRunTemplate"].(map[string]any); ok {
+		for _, field := range []string{"serviceAccountName", "podTemplate"} {
+			if v, ok := template[field]; ok {
+				spec[field] = v
+			}
+		}
+		delete(spec, "taskRunTemplate")
+	}
+	renameTaskRunSpecFields(spec, map[string]string{
+		"serviceAccountName": "taskServiceAccountName",
+		"podTemplate":        "taskPodTemplate",
+	})
+	obj["spec"] = spec
+	return obj
+}
+
+// moveField moves the value of a field of obj to the nested path, creating
+// the maps on the way. Nothing is done if the field is not set.
+func moveField(obj map[string]any, field string, path ...string) {
+	v, ok := obj[field]
+	if !ok {
+		return
+	}
+	delete(obj, field)
+	m := obj
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = v
+}
+
+// renameTaskRunSpecFields renames fields of the spec.taskRunSpecs of a
+// pipeline run
+func renameTaskRunSpecFields(spec map[string]any, names map[string]string) {
+	specs, _ := spec["taskRunSpecs"].([]any)
+	for _, s := range specs {
+		m, ok := s.(map[string]any)
+		if !ok {
+			continue
+		}
+		for from, to := range names {
+			moveField(m, from, to)
+		}
+	}
+}
+
+func stringField(obj map[string]any, field string) string {
+	s, _ := obj[field].(string)
+	return s
+}
+
+// deepCopy copies a JSON object, so converting it leaves the original intact
+func deepCopy(obj map[string]any) map[string]any {
+	return (&unstructured.Unstructured{Object: obj}).DeepCopy().Object
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// v1beta1Run is a failed pipeline run as served by a cluster with Tekton
+// v1beta1 only
+func v1beta1Run() map[string]any {
+	obj := failedRun("Failed").Object
+	obj["apiVersion"] = "tekton.dev/v1beta1"
+	spec := obj["spec"].(map[string]any)
+	spec["serviceAccountName"] = "pipeline"
+	spec["timeout"] = "1h0m0s"
+	spec["taskRunSpecs"] = []any{
+		map[string]any{"pipelineTaskName": "terraform-apply", "taskServiceAccountName": "terraform"},
+	}
+	return obj
+}
+
+// notServed is the Status of the API server for an API version it does not serve
+const notServed = `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"the server could not find the requested resource","reason":"NotFound","details":{},"code":404}`
+
+// v1beta1Server serves pipeline runs in tekton.dev/v1beta1 only, and records
+// the paths it was asked for and the pipeline run created
+func v1beta1Server(t *testing.T, paths *[]string, created *map[string]any) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*paths = append(*paths, r.Method+" "+r.URL.Path)
+		const runs = "/apis/tekton.dev/v1beta1/namespaces/default/pipelineruns"
+		switch {
+		case r.URL.Path == "/apis/tekton.dev":
+			w.Write([]byte(`{"kind":"APIGroup","name":"tekton.dev","versions":[{"groupVersion":"tekton.dev/v1beta1","version":"v1beta1"},{"groupVersion":"tekton.dev/v1alpha1","version":"v1alpha1"}]}`))
+		case r.Method == http.MethodGet && r.URL.Path == runs:
+			json.NewEncoder(w).Encode(map[string]any{
+				"apiVersion": "tekton.dev/v1beta1",
+				"kind":       "PipelineRunList",
+				"items":      []any{v1beta1Run()},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == runs+"/gcp-region-provision-jf8v5":
+			json.NewEncoder(w).Encode(v1beta1Run())
+		case r.Method == http.MethodPost && r.URL.Path == runs:
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, created)
+			(*created)["metadata"].(map[string]any)["name"] = "gcp-region-provision-k2m9x"
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(*created)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(notServed))
+		}
+	}))
+}
+
+func TestTektonAPIClient_NegotiatesV1beta1(t *testing.T) {
+	var paths []string
+	var created map[string]any
+	server := v1beta1Server(t, &paths, &created)
+	defer server.Close()
+
+	c := NewTektonAPIClient(server.URL)
+	status, err := c.GetPipelineRunsByEventID(context.Background(), "default", "63950e1f-7ffe-4d14-bc0e-121cee88942e")
+	if err != nil {
+		t.Fatalf("GetPipelineRunsByEventID() error = %v", err)
+	}
+	if status.Name != "gcp-region-provision-jf8v5" || status.Status != "Failed" {
+		t.Errorf("status = %+v", status)
+	}
+
+	want := []string{
+		"GET /apis/tekton.dev/v1/namespaces/default/pipelineruns",
+		"GET /apis/tekton.dev",
+		"GET /apis/tekton.dev/v1beta1/namespaces/default/pipelineruns",
+	}
+	if strings.Join(paths, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %v, want %v", paths, want)
+	}
+
+	// The negotiated version is used from then on
+	paths = nil
+	obj, err := c.GetPipelineRunObject(context.Background(), "default", "gcp-region-provision-jf8v5")
+	if err != nil {
+		t.Fatalf("GetPipelineRunObject() error = %v", err)
+	}
+	if len(paths) != 1 || !strings.Contains(paths[0], "/v1beta1/") {
+		t.Errorf("requests = %v, want one v1beta1 request", paths)
+	}
+	if obj.GetAPIVersion() != "tekton.dev/v1" {
+		t.Errorf("apiVersion = %q, want the object converted to v1", obj.GetAPIVersion())
+	}
+	if sa, _, _ := unstructured.NestedString(obj.Object, "spec", "taskRunTemplate", "serviceAccountName"); sa != "pipeline" {
+		t.Errorf("spec.taskRunTemplate.serviceAccountName = %q, want pipeline", sa)
+	}
+}
+
+func TestTektonAPIClient_RetryOnV1beta1(t *testing.T) {
+	var paths []string
+	var created map[string]any
+	server := v1beta1Server(t, &paths, &created)
+	defer server.Close()
+
+	result, err := RetryPipelineRun(context.Background(), NewTektonAPIClient(server.URL), "default", "gcp-region-provision-jf8v5", RetryOptions{})
+	// Labelling is not served by the test server
+	if result == nil || result.PipelineRun != "gcp-region-provision-k2m9x" {
+		t.Fatalf("RetryPipelineRun() = %+v, %v", result, err)
+	}
+
+	if created["apiVersion"] != "tekton.dev/v1beta1" {
+		t.Errorf("created apiVersion = %v, want tekton.dev/v1beta1", created["apiVersion"])
+	}
+	spec := created["spec"].(map[string]any)
+	if spec["serviceAccountName"] != "pipeline" || spec["taskRunTemplate"] != nil {
+		t.Errorf("created spec = %v, want the v1beta1 service account field", spec)
+	}
+	taskSpec := spec["taskRunSpecs"].([]any)[0].(map[string]any)
+	if taskSpec["taskServiceAccountName"] != "terraform" {
+		t.Errorf("created taskRunSpecs = %v, want taskServiceAccountName", taskSpec)
+	}
+}
+
+func TestTektonAPIClient_NoTektonAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(notServed))
+	}))
+	defer server.Close()
+
+	_, err := NewTektonAPIClient(server.URL).GetPipelineRun(context.Background(), "default", "gcp-region-provision-jf8v5")
+	if !errors.Is(err, ErrTektonAPINotServed) || !strings.Contains(err.Error(), "Tekton Pipelines is installed") {
+		t.Errorf("GetPipelineRun() error = %v, want ErrTektonAPINotServed", err)
+	}
+}
+
+func TestTektonAPIClient_UnsupportedVersions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/apis/tekton.dev" {
+			w.Write([]byte(`{"versions":[{"version":"v1alpha1"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(notServed))
+	}))
+	defer server.Close()
+
+	_, err := NewTektonAPIClient(server.URL).ListPipelineRuns(context.Background(), "default", "")
+	if !errors.Is(err, ErrTektonAPINotServed) || !strings.Contains(err.Error(), "it serves v1alpha1") {
+		t.Errorf("ListPipelineRuns() error = %v, want ErrTektonAPINotServed", err)
+	}
+}
+
+func TestTektonAPIClient_MissingRunSkipsDiscovery(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"kind":"Status","reason":"NotFound","details":{"name":"missing","group":"tekton.dev","kind":"pipelineruns"},"code":404}`))
+	}))
+	defer server.Close()
+
+	_, err := NewTektonAPIClient(server.URL).GetPipelineRun(context.Background(), "default", "missing")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("GetPipelineRun() error = %v, want a 404 StatusError", err)
+	}
+	if len(paths) != 1 {
+		t.Errorf("requests = %v, want no API discovery for a missing run", paths)
+	}
+}
+
+func TestConvertToV1(t *testing.T) {
+	obj := v1beta1Run()
+	obj["spec"].(map[string]any)["podTemplate"] = map[string]any{"nodeSelector": map[string]any{"pool": "tekton"}}
+	convertToV1(obj)
+
+	spec := obj["spec"].(map[string]any)
+	if obj["apiVersion"] != "tekton.dev/v1" || spec["serviceAccountName"] != nil || spec["timeout"] != nil {
+		t.Errorf("convertToV1() = %v", obj)
+	}
+	if timeout, _, _ := unstructured.NestedString(obj, "spec", "timeouts", "pipeline"); timeout != "1h0m0s" {
+		t.Errorf("spec.timeouts.pipeline = %q, want 1h0m0s", timeout)
+	}
+	if pool, _, _ := unstructured.NestedString(obj, "spec", "taskRunTemplate", "podTemplate", "nodeSelector", "pool"); pool != "tekton" {
+		t.Errorf("spec.taskRunTemplate.podTemplate = %v", spec["taskRunTemplate"])
+	}
+
+	taskRun := map[string]any{
+		"apiVersion": "tekton.dev/v1beta1",
+		"kind":       "TaskRun",
+		"status":     map[string]any{"taskResults": []any{map[string]any{"name": "plan"}}},
+	}
+	convertToV1(taskRun)
+	if results, _, _ := unstructured.NestedSlice(taskRun, "status", "results"); len(results) != 1 {
+		t.Errorf("convertToV1() TaskRun = %v, want status.results", taskRun)
+	}
+}
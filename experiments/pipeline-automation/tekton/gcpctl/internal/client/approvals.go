@@ -0,0 +1,522 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// approvalTasksResource is the ApprovalTask of the OpenShift Pipelines manual
+// approval gate, a custom task that holds a pipeline run until approvers
+// answer. Its controller creates one per gate, labelled like the TaskRuns of
+// the pipeline run.
+var approvalTasksResource = schema.GroupVersionResource{Group: "openshift-pipelines.org", Version: "v1alpha1", Resource: "approvaltasks"}
+
+// Inputs of an approver of an ApprovalTask
+const (
+	approverInputPending = "pending"
+	approverInputApprove = "approve"
+	approverInputReject  = "reject"
+)
+
+// approverGroup is the type of approvers naming a group; the others are users
+const approverGroup = "Group"
+
+// ErrNoPendingApproval is returned when a pipeline run waits on no approval
+var ErrNoPendingApproval = errors.New("no pending approval")
+
+// ApprovalTask is an ApprovalTask custom task from the API
+type ApprovalTask struct {
+	Metadata struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		Approvers                 []ApprovalApprover `json:"approvers"`
+		NumberOfApprovalsRequired int                `json:"numberOfApprovalsRequired"`
+		Description               string             `json:"description,omitempty"`
+	} `json:"spec"`
+	Status struct {
+		State             string `json:"state,omitempty"`
+		StartTime         string `json:"startTime,omitempty"`
+		ApproversResponse []struct {
+			Name         string `json:"name"`
+			Type         string `json:"type,omitempty"`
+			Response     string `json:"response"`
+			Message      string `json:"message,omitempty"`
+			GroupMembers []struct {
+				Name     string `json:"name"`
+				Response string `json:"response"`
+				Message  string `json:"message,omitempty"`
+			} `json:"groupMembers,omitempty"`
+		} `json:"approversResponse,omitempty"`
+	} `json:"status"`
+}
+
+// ApprovalApprover is a user or group that may answer an ApprovalTask, with
+// its input: pending, approve or reject. The members of a group answer in
+// Users.
+type ApprovalApprover struct {
+	Name    string         `json:"name"`
+	Type    string         `json:"type,omitempty"`
+	Input   string         `json:"input"`
+	Message string         `json:"message,omitempty"`
+	Users   []ApprovalUser `json:"users,omitempty"`
+}
+
+// ApprovalUser is the input of a member of an approver group
+type ApprovalUser struct {
+	Name  string `json:"name"`
+	Input string `json:"input"`
+}
+
+// ApprovalTaskList represents a list of ApprovalTasks
+type ApprovalTaskList struct {
+	Items []ApprovalTask `json:"items"`
+}
+
+// UserInfo is the user the API server authenticates the client as
+type UserInfo struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// ApprovalClient reads the approval gates of pipeline runs, records the
+// answers of approvers and tells who the current user is
+type ApprovalClient interface {
+	ListApprovalTasks(ctx context.Context, namespace, pipelineRun string) ([]ApprovalTask, error)
+	// PatchApprovalTask applies a JSON patch to an ApprovalTask
+	PatchApprovalTask(ctx context.Context, namespace, name string, patch []byte) error
+	WhoAmI(ctx context.Context) (*UserInfo, error)
+}
+
+// ApprovalOptions configure AnswerApproval
+type ApprovalOptions struct {
+	// Task is the pipeline task of the gate, optional when the run waits on
+	// a single one
+	Task string
+	// Reject rejects the gate instead of approving it, which fails the run
+	Reject  bool
+	Message string
+}
+
+// PipelineTask returns the name of the pipeline task of the gate
+func (t *ApprovalTask) PipelineTask() string {
+	if name := t.Metadata.Labels["tekton.dev/pipelineTask"]; name != "" {
+		return name
+	}
+	return t.Metadata.Name
+}
+
+// State returns the state of the gate: pending, approved or rejected
+func (t *ApprovalTask) State() string {
+	if t.Status.State == "" {
+		return api.ApprovalPending
+	}
+	return t.Status.State
+}
+
+// Required returns the number of approvals that open the gate
+func (t *ApprovalTask) Required() int {
+	return max(t.Spec.NumberOfApprovalsRequired, 1)
+}
+
+// Responses returns the answers recorded by the controller, one per user
+func (t *ApprovalTask) Responses() []api.ApprovalResponse {
+	var responses []api.ApprovalResponse
+	for _, r := range t.Status.ApproversResponse {
+		if r.Type != approverGroup {
+			responses = append(responses, api.ApprovalResponse{Name: r.Name, Response: r.Response, Message: r.Message})
+			continue
+		}
+		for _, m := range r.GroupMembers {
+			responses = append(responses, api.ApprovalResponse{Name: m.Name, Response: m.Response, Message: m.Message})
+		}
+	}
+	return responses
+}
+
+// ApprovalStatus converts the ApprovalTask into the status of its gate
+func (t *ApprovalTask) ApprovalStatus() api.ApprovalStatus {
+	status := api.ApprovalStatus{
+		Task:        t.PipelineTask(),
+		Name:        t.Metadata.Name,
+		State:       t.State(),
+		Description: t.Spec.Description,
+		Required:    t.Required(),
+		Responses:   t.Responses(),
+		StartTime:   t.Status.StartTime,
+	}
+	for _, a := range t.Spec.Approvers {
+		status.Approvers = append(status.Approvers, approverName(a))
+	}
+	for _, r := range status.Responses {
+		if r.Response == api.ApprovalApproved {
+			status.Approvals++
+		}
+	}
+	return status
+}
+
+// approverName names a user approver by its name and a group approver as
+// group:<name>
+func approverName(a ApprovalApprover) string {
+	if a.Type == approverGroup && !strings.HasPrefix(a.Name, "group:") {
+		return "group:" + a.Name
+	}
+	return a.Name
+}
+
+// AddApprovals adds the approval gates of a pipeline run to its status,
+// ordered by the time they were reached
+func AddApprovals(ctx context.Context, c ApprovalClient, status *api.PipelineRunStatus) error {
+	tasks, err := c.ListApprovalTasks(ctx, status.Namespace, status.Name)
+	if err != nil {
+		return err
+	}
+	status.Approvals = nil
+	for i := range tasks {
+		status.Approvals = append(status.Approvals, tasks[i].ApprovalStatus())
+	}
+	sort.SliceStable(status.Approvals, func(i, j int) bool {
+		a, b := status.Approvals[i], status.Approvals[j]
+		if a.StartTime != b.StartTime {
+			return a.StartTime < b.StartTime
+		}
+		return a.Task < b.Task
+	})
+	return nil
+}
+
+// AnswerApproval approves or rejects a pending gate of a pipeline run as the
+// current user. The user answers as a named approver, or else as a member of
+// an approver group; the admission webhook of the approval gate checks that
+// the authenticated user is the one answering.
+func AnswerApproval(ctx context.Context, c ApprovalClient, namespace, pipelineRun string, opts ApprovalOptions) (*api.ApprovalResult, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	tasks, err := c.ListApprovalTasks(ctx, namespace, pipelineRun)
+	if err != nil {
+		return nil, err
+	}
+	task, err := selectApprovalTask(tasks, pipelineRun, opts.Task)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.WhoAmI(ctx)
+	if err != nil {
+		return nil, err
+	}
+	input := approverInputApprove
+	if opts.Reject {
+		input = approverInputReject
+	}
+	patch, group, err := approvalPatch(task, user, input, opts.Message)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.PatchApprovalTask(ctx, namespace, task.Metadata.Name, patch); err != nil {
+		return nil, err
+	}
+
+	status := task.ApprovalStatus()
+	result := &api.ApprovalResult{
+		PipelineRun:  pipelineRun,
+		Namespace:    namespace,
+		Task:         status.Task,
+		ApprovalTask: status.Name,
+		Approver:     user.Username,
+		Group:        group,
+		Response:     api.ApprovalApproved,
+		Message:      opts.Message,
+		Approvals:    status.Approvals,
+		Required:     status.Required,
+	}
+	if opts.Reject {
+		result.Response = api.ApprovalRejected
+	} else {
+		result.Approvals++
+	}
+	return result, nil
+}
+
+// selectApprovalTask returns the gate of a pipeline task, which must be
+// pending, or the only pending gate if task is empty
+func selectApprovalTask(tasks []ApprovalTask, pipelineRun, task string) (*ApprovalTask, error) {
+	if task != "" {
+		for i := range tasks {
+			if tasks[i].PipelineTask() != task {
+				continue
+			}
+			if state := tasks[i].State(); state != api.ApprovalPending {
+				return nil, fmt.Errorf("approval %s of pipeline run %s is already %s", task, pipelineRun, state)
+			}
+			return &tasks[i], nil
+		}
+		return nil, fmt.Errorf("pipeline run %s has not reached an approval task %q", pipelineRun, task)
+	}
+
+	var pending []*ApprovalTask
+	for i := range tasks {
+		if tasks[i].State() == api.ApprovalPending {
+			pending = append(pending, &tasks[i])
+		}
+	}
+	switch len(pending) {
+	case 0:
+		return nil, fmt.Errorf("pipeline run %s: %w", pipelineRun, ErrNoPendingApproval)
+	case 1:
+		return pending[0], nil
+	default:
+		names := make([]string, len(pending))
+		for i, t := range pending {
+			names[i] = t.PipelineTask()
+		}
+		return nil, fmt.Errorf("pipeline run %s waits on several approvals, choose one with --task: %s", pipelineRun, strings.Join(names, ", "))
+	}
+}
+
+// approvalPatch returns the JSON patch recording the input of a user in a
+// gate, and the approver group they answer for, if any. The patch tests the
+// approver it changes, so it fails if the approvers changed meanwhile.
+func approvalPatch(task *ApprovalTask, user *UserInfo, input, message string) ([]byte, string, error) {
+	type op struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	}
+
+	for i, a := range task.Spec.Approvers {
+		if a.Type == approverGroup || a.Name != user.Username {
+			continue
+		}
+		if a.Input != "" && a.Input != approverInputPending {
+			return nil, "", fmt.Errorf("%s already answered %s to approval %s", user.Username, a.Input, task.PipelineTask())
+		}
+		path := fmt.Sprintf("/spec/approvers/%d", i)
+		ops := []op{
+			{"test", path + "/name", a.Name},
+			{"replace", path + "/input", input},
+		}
+		if message != "" {
+			ops = append(ops, op{"add", path + "/message", message})
+		}
+		patch, err := json.Marshal(ops)
+		return patch, "", err
+	}
+
+	for i, a := range task.Spec.Approvers {
+		group := strings.TrimPrefix(a.Name, "group:")
+		if a.Type != approverGroup || !slices.Contains(user.Groups, group) {
+			continue
+		}
+		path := fmt.Sprintf("/spec/approvers/%d", i)
+		ops := []op{{"test", path + "/name", a.Name}}
+		member := ApprovalUser{Name: user.Username, Input: input}
+		switch j := slices.IndexFunc(a.Users, func(u ApprovalUser) bool { return u.Name == user.Username }); {
+		case j >= 0 && a.Users[j].Input != "" && a.Users[j].Input != approverInputPending:
+			return nil, "", fmt.Errorf("%s already answered %s to approval %s", user.Username, a.Users[j].Input, task.PipelineTask())
+		case j >= 0:
+			ops = append(ops, op{"replace", fmt.Sprintf("%s/users/%d/input", path, j), input})
+		case a.Users == nil:
+			ops = append(ops, op{"add", path + "/users", []ApprovalUser{member}})
+		default:
+			ops = append(ops, op{"add", path + "/users/-", member})
+		}
+		if message != "" {
+			ops = append(ops, op{"add", path + "/message", message})
+		}
+		patch, err := json.Marshal(ops)
+		return patch, group, err
+	}
+
+	approvers := make([]string, len(task.Spec.Approvers))
+	for i, a := range task.Spec.Approvers {
+		approvers[i] = approverName(a)
+	}
+	return nil, "", fmt.Errorf("%s is not an approver of %s, approvers: %s", user.Username, task.PipelineTask(), strings.Join(approvers, ", "))
+}
+
+// selfSubjectReview returns an empty SelfSubjectReview, which the API server
+// answers with the user it authenticated
+func selfSubjectReview() *authenticationv1.SelfSubjectReview {
+	return &authenticationv1.SelfSubjectReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "authentication.k8s.io/v1", Kind: "SelfSubjectReview"},
+	}
+}
+
+// userInfo returns the user of a reviewed SelfSubjectReview
+func userInfo(review *authenticationv1.SelfSubjectReview) (*UserInfo, error) {
+	user := review.Status.UserInfo
+	if user.Username == "" {
+		return nil, errors.New("the API server did not tell who the user is")
+	}
+	return &UserInfo{Username: user.Username, Groups: user.Groups}, nil
+}
+
+// pipelineRunSelector is the label selector of the objects of a pipeline run
+func pipelineRunSelector(pipelineRun string) string {
+	return "tekton.dev/pipelineRun=" + pipelineRun
+}
+
+// ListApprovalTasks queries for the ApprovalTasks of a pipeline run
+func (c *KubeconfigClient) ListApprovalTasks(ctx context.Context, namespace, pipelineRun string) ([]ApprovalTask, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	list, err := c.dynamic.Resource(approvalTasksResource).Namespace(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: pipelineRunSelector(pipelineRun),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list approval tasks: %w", err)
+	}
+
+	tasks := make([]ApprovalTask, len(list.Items))
+	for i := range list.Items {
+		if err := decodeUnstructured(&list.Items[i], &tasks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// PatchApprovalTask applies a JSON patch to an ApprovalTask
+func (c *KubeconfigClient) PatchApprovalTask(ctx context.Context, namespace, name string, patch []byte) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	if _, err := c.dynamic.Resource(approvalTasksResource).Namespace(namespace).Patch(ctx, name, types.JSONPatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to answer approval task: %w", err)
+	}
+	return nil
+}
+
+// WhoAmI returns the user of the kubeconfig credentials with a SelfSubjectReview
+func (c *KubeconfigClient) WhoAmI(ctx context.Context) (*UserInfo, error) {
+	review, err := c.core.AuthenticationV1().SelfSubjectReviews().Create(ctx, selfSubjectReview(), metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to review the user: %w", err)
+	}
+	return userInfo(review)
+}
+
+// ListApprovalTasks queries for the ApprovalTasks of a pipeline run using kubectl
+func (c *KubectlClient) ListApprovalTasks(ctx context.Context, namespace, pipelineRun string) ([]ApprovalTask, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	output, err := runKubectl(ctx, nil, "get", approvalTasksResource.Resource+"."+approvalTasksResource.Group,
+		"-n", namespace, "-l", pipelineRunSelector(pipelineRun), "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var list ApprovalTaskList
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
+	}
+	return list.Items, nil
+}
+
+// PatchApprovalTask applies a JSON patch to an ApprovalTask using kubectl
+func (c *KubectlClient) PatchApprovalTask(ctx context.Context, namespace, name string, patch []byte) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := runKubectl(ctx, nil, "patch", approvalTasksResource.Resource+"."+approvalTasksResource.Group, name,
+		"-n", namespace, "--type", "json", "-p", string(patch))
+	return err
+}
+
+// WhoAmI returns the user of kubectl with a SelfSubjectReview, as kubectl auth
+// whoami does
+func (c *KubectlClient) WhoAmI(ctx context.Context) (*UserInfo, error) {
+	body, err := json.Marshal(selfSubjectReview())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode user review: %w", err)
+	}
+
+	output, err := runKubectl(ctx, body, "create", "-f", "-", "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var review authenticationv1.SelfSubjectReview
+	if err := json.Unmarshal(output, &review); err != nil {
+		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
+	}
+	return userInfo(&review)
+}
+
+// approvalTasksPath returns the API path of the ApprovalTasks of a namespace,
+// or of one of them if name is set
+func approvalTasksPath(namespace, name string) string {
+	path := fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", approvalTasksResource.Group, approvalTasksResource.Version, namespace, approvalTasksResource.Resource)
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+// ListApprovalTasks queries the Kubernetes API behind the Tekton API URL for
+// the ApprovalTasks of a pipeline run
+func (c *TektonAPIClient) ListApprovalTasks(ctx context.Context, namespace, pipelineRun string) ([]ApprovalTask, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	endpoint := c.baseURL + approvalTasksPath(namespace, "") + "?" + url.Values{"labelSelector": {pipelineRunSelector(pipelineRun)}}.Encode()
+	var list ApprovalTaskList
+	if err := c.do(ctx, http.MethodGet, endpoint, "", nil, &list); err != nil {
+		return nil, fmt.Errorf("failed to list approval tasks: %w", err)
+	}
+	return list.Items, nil
+}
+
+// PatchApprovalTask applies a JSON patch to an ApprovalTask
+func (c *TektonAPIClient) PatchApprovalTask(ctx context.Context, namespace, name string, patch []byte) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	if err := c.do(ctx, http.MethodPatch, c.baseURL+approvalTasksPath(namespace, name), "application/json-patch+json", patch, nil); err != nil {
+		return fmt.Errorf("failed to answer approval task: %w", err)
+	}
+	return nil
+}
+
+// WhoAmI returns the user the Kubernetes API behind the Tekton API URL
+// authenticates, e.g. that of a kubectl proxy, with a SelfSubjectReview
+func (c *TektonAPIClient) WhoAmI(ctx context.Context) (*UserInfo, error) {
+	body, err := json.Marshal(selfSubjectReview())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode user review: %w", err)
+	}
+
+	var review authenticationv1.SelfSubjectReview
+	endpoint := c.baseURL + "/apis/authentication.k8s.io/v1/selfsubjectreviews"
+	if err := c.do(ctx, http.MethodPost, endpoint, contentType, body, &review); err != nil {
+		return nil, fmt.Errorf("failed to review the user: %w", err)
+	}
+	return userInfo(&review)
+}
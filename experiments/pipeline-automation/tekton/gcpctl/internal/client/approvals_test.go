@@ -0,0 +1,292 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const gatedRun = "gcp-region-provision-jf8v5"
+
+// approvalTaskObject builds an ApprovalTask of gatedRun for a pipeline task
+func approvalTaskObject(task, state string, required int, approvers ...any) map[string]any {
+	return map[string]any{
+		"apiVersion": "openshift-pipelines.org/v1alpha1",
+		"kind":       "ApprovalTask",
+		"metadata": map[string]any{
+			"name":      gatedRun + "-" + task,
+			"namespace": "default",
+			"labels": map[string]any{
+				"tekton.dev/pipelineRun":  gatedRun,
+				"tekton.dev/pipelineTask": task,
+			},
+		},
+		"spec": map[string]any{
+			"approvers":                 approvers,
+			"numberOfApprovalsRequired": int64(required),
+		},
+		"status": map[string]any{"state": state},
+	}
+}
+
+func approver(name, typ, input string) map[string]any {
+	return map[string]any{"name": name, "type": typ, "input": input}
+}
+
+// fakeApprovalClient serves ApprovalTasks and records the patches applied to them
+type fakeApprovalClient struct {
+	tasks   []map[string]any
+	user    UserInfo
+	patched map[string]string
+}
+
+func (f *fakeApprovalClient) ListApprovalTasks(_ context.Context, _, pipelineRun string) ([]ApprovalTask, error) {
+	data, _ := json.Marshal(map[string]any{"items": f.tasks})
+	var list ApprovalTaskList
+	err := json.Unmarshal(data, &list)
+	return list.Items, err
+}
+
+func (f *fakeApprovalClient) PatchApprovalTask(_ context.Context, _, name string, patch []byte) error {
+	if f.patched == nil {
+		f.patched = map[string]string{}
+	}
+	f.patched[name] = string(patch)
+	return nil
+}
+
+func (f *fakeApprovalClient) WhoAmI(context.Context) (*UserInfo, error) {
+	return &f.user, nil
+}
+
+func TestAnswerApproval(t *testing.T) {
+	production := approvalTaskObject("approve-production", "pending", 2,
+		approver("alice", "User", "pending"),
+		approver("group:sre", "Group", "pending"),
+	)
+	staging := approvalTaskObject("approve-staging", "approved", 1, approver("alice", "User", "approve"))
+	sreAnswered := approvalTaskObject("approve-production", "pending", 2, map[string]any{
+		"name": "sre", "type": "Group", "input": "pending",
+		"users": []any{map[string]any{"name": "carol", "input": "approve"}},
+	})
+
+	tests := []struct {
+		name      string
+		tasks     []map[string]any
+		user      UserInfo
+		opts      ApprovalOptions
+		wantPatch string
+		wantErr   string
+		want      api.ApprovalResult
+	}{
+		{
+			name:      "named approver",
+			tasks:     []map[string]any{staging, production},
+			user:      UserInfo{Username: "alice", Groups: []string{"sre"}},
+			opts:      ApprovalOptions{Message: "CHG-1234"},
+			wantPatch: `[{"op":"test","path":"/spec/approvers/0/name","value":"alice"},{"op":"replace","path":"/spec/approvers/0/input","value":"approve"},{"op":"add","path":"/spec/approvers/0/message","value":"CHG-1234"}]`,
+			want:      api.ApprovalResult{Task: "approve-production", Approver: "alice", Response: "approved", Message: "CHG-1234", Approvals: 1, Required: 2},
+		},
+		{
+			name:      "group member",
+			tasks:     []map[string]any{production},
+			user:      UserInfo{Username: "bob", Groups: []string{"system:authenticated", "sre"}},
+			opts:      ApprovalOptions{Task: "approve-production"},
+			wantPatch: `[{"op":"test","path":"/spec/approvers/1/name","value":"group:sre"},{"op":"add","path":"/spec/approvers/1/users","value":[{"name":"bob","input":"approve"}]}]`,
+			want:      api.ApprovalResult{Task: "approve-production", Approver: "bob", Group: "sre", Response: "approved", Approvals: 1, Required: 2},
+		},
+		{
+			name:      "second group member",
+			tasks:     []map[string]any{sreAnswered},
+			user:      UserInfo{Username: "bob", Groups: []string{"sre"}},
+			opts:      ApprovalOptions{Reject: true},
+			wantPatch: `[{"op":"test","path":"/spec/approvers/0/name","value":"sre"},{"op":"add","path":"/spec/approvers/0/users/-","value":{"name":"bob","input":"reject"}}]`,
+			want:      api.ApprovalResult{Task: "approve-production", Approver: "bob", Group: "sre", Response: "rejected", Required: 2},
+		},
+		{name: "already answered", tasks: []map[string]any{sreAnswered}, user: UserInfo{Username: "carol", Groups: []string{"sre"}}, wantErr: "carol already answered approve"},
+		{name: "not an approver", tasks: []map[string]any{production}, user: UserInfo{Username: "mallory"}, wantErr: "mallory is not an approver of approve-production, approvers: alice, group:sre"},
+		{name: "gate already approved", tasks: []map[string]any{staging}, user: UserInfo{Username: "alice"}, opts: ApprovalOptions{Task: "approve-staging"}, wantErr: "is already approved"},
+		{name: "gate not reached", tasks: []map[string]any{staging}, user: UserInfo{Username: "alice"}, opts: ApprovalOptions{Task: "approve-production"}, wantErr: `has not reached an approval task "approve-production"`},
+		{
+			name:    "several pending gates",
+			tasks:   []map[string]any{production, approvalTaskObject("approve-dns", "pending", 1, approver("alice", "User", "pending"))},
+			user:    UserInfo{Username: "alice"},
+			wantErr: "choose one with --task: approve-production, approve-dns",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeApprovalClient{tasks: tt.tasks, user: tt.user}
+			result, err := AnswerApproval(context.Background(), c, "", gatedRun, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("AnswerApproval() error = %v, want %q", err, tt.wantErr)
+				}
+				if len(c.patched) > 0 {
+					t.Errorf("AnswerApproval() patched %v despite the error", c.patched)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AnswerApproval() error = %v", err)
+			}
+
+			tt.want.PipelineRun, tt.want.Namespace, tt.want.ApprovalTask = gatedRun, "default", gatedRun+"-"+tt.want.Task
+			if *result != tt.want {
+				t.Errorf("AnswerApproval() = %+v, want %+v", *result, tt.want)
+			}
+			if got := c.patched[result.ApprovalTask]; got != tt.wantPatch {
+				t.Errorf("patch = %s\nwant    %s", got, tt.wantPatch)
+			}
+		})
+	}
+
+	c := &fakeApprovalClient{tasks: []map[string]any{staging}}
+	if _, err := AnswerApproval(context.Background(), c, "", gatedRun, ApprovalOptions{}); !errors.Is(err, ErrNoPendingApproval) {
+		t.Errorf("AnswerApproval() error = %v, want ErrNoPendingApproval", err)
+	}
+}
+
+func TestAddApprovals(t *testing.T) {
+	approved := approvalTaskObject("approve-staging", "approved", 2, approver("alice", "User", "approve"), approver("sre", "Group", "pending"))
+	approved["status"] = map[string]any{
+		"state":     "approved",
+		"startTime": "2025-10-15T18:10:00Z",
+		"approversResponse": []any{
+			map[string]any{"name": "alice", "type": "User", "response": "approved", "message": "LGTM"},
+			map[string]any{"name": "sre", "type": "Group", "response": "approved", "groupMembers": []any{
+				map[string]any{"name": "bob", "response": "approved"},
+			}},
+		},
+	}
+	pending := approvalTaskObject("approve-production", "", 0, approver("alice", "User", "pending"))
+	pending["status"].(map[string]any)["startTime"] = "2025-10-15T18:30:00Z"
+
+	status := &api.PipelineRunStatus{Name: gatedRun, Namespace: "default"}
+	c := &fakeApprovalClient{tasks: []map[string]any{pending, approved}}
+	if err := AddApprovals(context.Background(), c, status); err != nil {
+		t.Fatalf("AddApprovals() error = %v", err)
+	}
+
+	if len(status.Approvals) != 2 {
+		t.Fatalf("AddApprovals() = %+v, want 2 gates", status.Approvals)
+	}
+	staging, production := status.Approvals[0], status.Approvals[1]
+	if staging.Task != "approve-staging" || staging.Approvals != 2 || staging.Required != 2 || len(staging.Responses) != 2 || staging.Responses[1].Name != "bob" {
+		t.Errorf("first gate = %+v, want approve-staging approved by alice and bob", staging)
+	}
+	if strings.Join(staging.Approvers, ",") != "alice,group:sre" {
+		t.Errorf("approvers = %v, want alice and group:sre", staging.Approvers)
+	}
+	if production.State != api.ApprovalPending || production.Required != 1 {
+		t.Errorf("second gate = %+v, want approve-production pending one approval", production)
+	}
+	if got := status.PendingApprovals(); len(got) != 1 || got[0].Task != "approve-production" {
+		t.Errorf("PendingApprovals() = %+v, want approve-production", got)
+	}
+}
+
+func TestKubeconfigClient_AnswerApproval(t *testing.T) {
+	// Only the gates of the run are listed
+	other := &unstructured.Unstructured{Object: approvalTaskObject("other", "pending", 1)}
+	other.SetLabels(map[string]string{"tekton.dev/pipelineRun": "another-run"})
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{approvalTasksResource: "ApprovalTaskList"},
+		&unstructured.Unstructured{Object: approvalTaskObject("approve-production", "pending", 1,
+			approver("alice", "User", "pending"), approver("sre", "Group", "pending"))},
+		other,
+	)
+
+	core := kubefake.NewClientset()
+	core.PrependReactor("create", "selfsubjectreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.SelfSubjectReview)
+		review.Status.UserInfo = authenticationv1.UserInfo{Username: "bob", Groups: []string{"sre"}}
+		return true, review, nil
+	})
+	c := newKubeconfigClient(dyn, core)
+
+	if _, err := AnswerApproval(context.Background(), c, "default", gatedRun, ApprovalOptions{Message: "ok"}); err != nil {
+		t.Fatalf("AnswerApproval() error = %v", err)
+	}
+
+	obj, err := dyn.Resource(approvalTasksResource).Namespace("default").Get(context.Background(), gatedRun+"-approve-production", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var task ApprovalTask
+	if err := decodeUnstructured(obj, &task); err != nil {
+		t.Fatal(err)
+	}
+	sre := task.Spec.Approvers[1]
+	if len(sre.Users) != 1 || sre.Users[0] != (ApprovalUser{Name: "bob", Input: "approve"}) || sre.Message != "ok" {
+		t.Errorf("group approver after the patch = %+v, want bob's approval", sre)
+	}
+	if task.Spec.Approvers[0].Input != "pending" {
+		t.Errorf("approver alice = %+v, want it untouched", task.Spec.Approvers[0])
+	}
+}
+
+func TestTektonAPIClient_Approvals(t *testing.T) {
+	var patch, patchType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/openshift-pipelines.org/v1alpha1/namespaces/sector-main/approvaltasks":
+			if got := r.URL.Query().Get("labelSelector"); got != "tekton.dev/pipelineRun="+gatedRun {
+				t.Errorf("labelSelector = %q, want the gates of the run", got)
+			}
+			json.NewEncoder(w).Encode(map[string]any{"items": []any{approvalTaskObject("approve-production", "pending", 1, approver("alice", "User", "pending"))}})
+		case r.Method == http.MethodPost && r.URL.Path == "/apis/authentication.k8s.io/v1/selfsubjectreviews":
+			json.NewEncoder(w).Encode(map[string]any{"status": map[string]any{"userInfo": map[string]any{"username": "alice"}}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/apis/openshift-pipelines.org/v1alpha1/namespaces/sector-main/approvaltasks/"+gatedRun+"-approve-production":
+			body, _ := io.ReadAll(r.Body)
+			patch, patchType = string(body), r.Header.Get("Content-Type")
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := NewTektonAPIClient(server.URL)
+	c.SetRetryPolicy(NoRetry)
+	result, err := AnswerApproval(context.Background(), c, "sector-main", gatedRun, ApprovalOptions{Task: "approve-production", Reject: true})
+	if err != nil {
+		t.Fatalf("AnswerApproval() error = %v", err)
+	}
+	if result.Response != api.ApprovalRejected || result.Approver != "alice" {
+		t.Errorf("AnswerApproval() = %+v, want rejected by alice", result)
+	}
+	if patchType != "application/json-patch+json" || !strings.Contains(patch, `"value":"reject"`) {
+		t.Errorf("patch = %s (%s), want a JSON patch rejecting the gate", patch, patchType)
+	}
+}
+
+func TestWhoAmI_NoUser(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":{}}`))
+	}))
+	defer server.Close()
+
+	if _, err := NewTektonAPIClient(server.URL).WhoAmI(context.Background()); err == nil {
+		t.Error("WhoAmI() succeeded without a user in the review")
+	}
+}
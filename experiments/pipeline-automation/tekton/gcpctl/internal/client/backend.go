@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/vcr"
+)
+
+// Backends reading Tekton resources from the cluster
+const (
+	// BackendAuto uses the first available backend of BackendKubeconfig,
+	// BackendKubectl and BackendAPI
+	BackendAuto = "auto"
+	// BackendKubeconfig talks to the cluster directly using the user's kubeconfig
+	BackendKubeconfig = "kubeconfig"
+	// BackendKubectl shells out to kubectl
+	BackendKubectl = "kubectl"
+	// BackendAPI queries the Tekton API URL, e.g. a kubectl proxy
+	BackendAPI = "api"
+)
+
+// Backends lists the backends accepted by NewClusterClient
+var Backends = []string{BackendAuto, BackendKubeconfig, BackendKubectl, BackendAPI}
+
+// ClusterClient reads pipeline runs, TaskRuns, pod logs and namespaces,
+// retries and deletes pipeline runs, answers approval gates and reviews the
+// access of the user. It is implemented by KubeconfigClient, KubectlClient
+// and TektonAPIClient.
+type ClusterClient interface {
+	LogSource
+	EventStatusGetter
+	PipelineRunWriter
+	NamespaceLister
+	AccessReviewer
+	ApprovalClient
+	DeletePipelineRun(ctx context.Context, namespace, name string) error
+	ListPipelineRuns(ctx context.Context, namespace, labelSelector string) ([]TektonPipelineRun, error)
+}
+
+// BackendOptions configure the backends of NewClusterClient
+type BackendOptions struct {
+	// Backend is one of Backends, BackendAuto if empty
+	Backend string
+	// Kubeconfig and Context select the cluster of BackendKubeconfig
+	Kubeconfig string
+	Context    string
+	// APIURL is the Tekton API URL of BackendAPI
+	APIURL string
+	// Retry is the retry policy of BackendAPI, DefaultRetryPolicy if nil
+	Retry *RetryPolicy
+	// Proxy and NoProxy are the proxy of BackendAPI and BackendKubeconfig
+	// and the hosts reached without it, see NewTransport. The kubeconfig
+	// backend uses the proxy-url of the kubeconfig cluster over Proxy.
+	Proxy   string
+	NoProxy string
+	// Headers are sent with every request of BackendAPI
+	Headers map[string]string
+	// Fixtures, if set, records the requests of BackendAPI and their
+	// responses, or replays them from a cassette instead of querying APIURL
+	Fixtures *vcr.Recorder
+}
+
+// backendFactories create the client of each backend, or return why it is not
+// available. Tests replace them.
+var backendFactories = map[string]func(opts BackendOptions) (ClusterClient, error){
+	BackendKubeconfig: func(opts BackendOptions) (ClusterClient, error) {
+		return NewKubeconfigClient(opts.Kubeconfig, opts.Context, opts.Proxy, opts.NoProxy)
+	},
+	BackendKubectl: func(opts BackendOptions) (ClusterClient, error) {
+		if !IsKubectlAvailable() {
+			return nil, errors.New("kubectl not found")
+		}
+		return NewKubectlClient(), nil
+	},
+	BackendAPI: func(opts BackendOptions) (ClusterClient, error) {
+		if opts.APIURL == "" {
+			return nil, errors.New("no Tekton API URL configured")
+		}
+		c := NewTektonAPIClient(opts.APIURL)
+		if opts.Retry != nil {
+			c.SetRetryPolicy(*opts.Retry)
+		}
+		var rt http.RoundTripper = opts.Fixtures
+		if opts.Fixtures == nil || opts.Fixtures.Mode() == vcr.ModeRecord {
+			var err error
+			if rt, err = NewTransport(opts.Proxy, opts.NoProxy); err != nil {
+				return nil, err
+			}
+			if opts.Fixtures != nil {
+				opts.Fixtures.SetTransport(rt)
+				rt = opts.Fixtures
+			}
+		}
+		c.SetTransport(rt)
+		c.SetHeaders(opts.Headers)
+		return c, nil
+	},
+}
+
+// NewClusterClient returns the client of the selected backend and its name.
+// With BackendAuto the backends are tried in the order kubeconfig, kubectl,
+// api, falling back to the next when one is not available, e.g. without a
+// kubeconfig. An explicitly selected backend is not replaced by another.
+func NewClusterClient(opts BackendOptions) (ClusterClient, string, error) {
+	backend := opts.Backend
+	if backend == "" {
+		backend = BackendAuto
+	}
+
+	if backend != BackendAuto {
+		factory, ok := backendFactories[backend]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown backend %q, must be one of %v", backend, Backends)
+		}
+		c, err := factory(opts)
+		if err != nil {
+			return nil, "", fmt.Errorf("backend %s is not available: %w", backend, err)
+		}
+		return c, backend, nil
+	}
+
+	var errs []error
+	for _, name := range []string{BackendKubeconfig, BackendKubectl, BackendAPI} {
+		c, err := backendFactories[name](opts)
+		if err == nil {
+			return c, name, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	return nil, "", fmt.Errorf("no backend available: %w", errors.Join(errs...))
+}
+
+// Ensure every backend implements ClusterClient
+var (
+	_ ClusterClient = (*KubeconfigClient)(nil)
+	_ ClusterClient = (*KubectlClient)(nil)
+	_ ClusterClient = (*TektonAPIClient)(nil)
+)
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// stubBackends replaces the backend factories for a test; backends listed in
+// available succeed, the others fail
+func stubBackends(t *testing.T, available ...string) {
+	t.Helper()
+	saved := backendFactories
+	t.Cleanup(func() { backendFactories = saved })
+
+	backendFactories = map[string]func(BackendOptions) (ClusterClient, error){}
+	for _, name := range []string{BackendKubeconfig, BackendKubectl, BackendAPI} {
+		ok := false
+		for _, a := range available {
+			ok = ok || a == name
+		}
+		backendFactories[name] = func(opts BackendOptions) (ClusterClient, error) {
+			if !ok {
+				return nil, errors.New(name + " unavailable")
+			}
+			return NewTektonAPIClient("http://" + name), nil
+		}
+	}
+}
+
+func TestNewClusterClient_Auto(t *testing.T) {
+	tests := []struct {
+		name      string
+		available []string
+		want      string
+	}{
+		{"kubeconfig first", []string{BackendKubeconfig, BackendKubectl, BackendAPI}, BackendKubeconfig},
+		{"falls back to kubectl", []string{BackendKubectl, BackendAPI}, BackendKubectl},
+		{"falls back to api", []string{BackendAPI}, BackendAPI},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubBackends(t, tt.available...)
+
+			_, got, err := NewClusterClient(BackendOptions{})
+			if err != nil {
+				t.Fatalf("NewClusterClient() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("backend = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClusterClient_NoneAvailable(t *testing.T) {
+	stubBackends(t)
+
+	_, _, err := NewClusterClient(BackendOptions{Backend: BackendAuto})
+	if err == nil {
+		t.Fatal("NewClusterClient() should return error when no backend is available")
+	}
+	for _, name := range []string{"kubeconfig unavailable", "kubectl unavailable", "api unavailable"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not mention %q", err, name)
+		}
+	}
+}
+
+func TestNewClusterClient_Explicit(t *testing.T) {
+	stubBackends(t, BackendKubectl)
+
+	if _, got, err := NewClusterClient(BackendOptions{Backend: BackendKubectl}); err != nil || got != BackendKubectl {
+		t.Errorf("NewClusterClient(kubectl) = %v, %v", got, err)
+	}
+
+	// An explicit backend is not replaced by an available one
+	if _, _, err := NewClusterClient(BackendOptions{Backend: BackendKubeconfig}); err == nil {
+		t.Error("NewClusterClient(kubeconfig) should return error when kubeconfig is unavailable")
+	}
+
+	if _, _, err := NewClusterClient(BackendOptions{Backend: "grpc"}); err == nil || !strings.Contains(err.Error(), "unknown backend") {
+		t.Errorf("NewClusterClient(grpc) error = %v", err)
+	}
+}
+
+func TestNewClusterClient_APIRequiresURL(t *testing.T) {
+	if _, _, err := NewClusterClient(BackendOptions{Backend: BackendAPI}); err == nil {
+		t.Error("NewClusterClient(api) should return error without an API URL")
+	}
+	if _, got, err := NewClusterClient(BackendOptions{Backend: BackendAPI, APIURL: "http://localhost:8001"}); err != nil || got != BackendAPI {
+		t.Errorf("NewClusterClient(api) = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Retry defaults of DefaultRetryPolicy
+const (
+	DefaultRetryAttempts       = 4
+	DefaultRetryInitialBackoff = 500 * time.Millisecond
+	DefaultRetryMaxBackoff     = 10 * time.Second
+)
+
+// RetryPolicy configures how the HTTP clients retry requests that failed
+// with a transient error: a refused or reset connection, 429 Too Many
+// Requests or a 5xx status. POST and PATCH requests, which are not
+// idempotent, are only retried when they provably did not reach the server,
+// see isTransient. The delay before retry n is InitialBackoff *
+// Multiplier^(n-1), capped at MaxBackoff, minus a random share of up to
+// Jitter of it. A Retry-After header of a 429 or 503 response replaces the
+// delay, also capped at MaxBackoff.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one; 1 or
+	// less does not retry
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Multiplier grows the delay between attempts, 2 if 0
+	Multiplier float64
+	// Jitter is the share of the delay, between 0 and 1, that is randomly
+	// removed so clients do not retry in lockstep
+	Jitter float64
+	// OnRetry is called before waiting for a retry, e.g. to log it; optional
+	OnRetry func(RetryAttempt)
+}
+
+// RetryAttempt describes a failed attempt that is retried
+type RetryAttempt struct {
+	// Attempt is the number of the failed attempt, starting at 1
+	Attempt     int
+	MaxAttempts int
+	// Err is the error of the attempt, or nil if it got a StatusCode
+	Err        error
+	StatusCode int
+	// Delay is the wait before the next attempt
+	Delay time.Duration
+}
+
+// RetryStats counts the requests of a client and their attempts
+type RetryStats struct {
+	// Requests is the number of requests sent
+	Requests int64
+	// Retries is the number of attempts beyond the first of each request
+	Retries int64
+	// Exhausted is the number of requests that still failed with a
+	// transient error after their last attempt
+	Exhausted int64
+}
+
+// DefaultRetryPolicy returns the policy the clients use unless another one
+// is set
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    DefaultRetryAttempts,
+		InitialBackoff: DefaultRetryInitialBackoff,
+		MaxBackoff:     DefaultRetryMaxBackoff,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+// NoRetry is a policy sending every request once
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// backoff returns the delay before the attempt after the given failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay -= delay * min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// retryStats are the counters behind RetryStats, safe for concurrent requests
+type retryStats struct {
+	requests, retries, exhausted atomic.Int64
+}
+
+func (s *retryStats) snapshot() RetryStats {
+	return RetryStats{
+		Requests:  s.requests.Load(),
+		Retries:   s.retries.Load(),
+		Exhausted: s.exhausted.Load(),
+	}
+}
+
+// doWithRetry sends the request built by newRequest, building a new one for
+// every attempt, until it gets a response that is not transient, the policy
+// runs out of attempts, or the next attempt would start after the deadline of
+// ctx. It returns the last response or error; the bodies of the responses of
+// retried attempts are drained and closed.
+func doWithRetry(ctx context.Context, hc *http.Client, p RetryPolicy, stats *retryStats, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	stats.requests.Add(1)
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := hc.Do(req)
+		if !isTransient(req.Method, resp, err) {
+			return resp, err
+		}
+		if attempt >= p.MaxAttempts {
+			stats.exhausted.Add(1)
+			return resp, err
+		}
+
+		delay := p.backoff(attempt)
+		if after, ok := retryAfter(resp); ok {
+			delay = after
+			if p.MaxBackoff > 0 {
+				delay = min(delay, p.MaxBackoff)
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			stats.exhausted.Add(1)
+			return resp, err
+		}
+
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(RetryAttempt{Attempt: attempt, MaxAttempts: p.MaxAttempts, Err: err, StatusCode: status, Delay: delay})
+		}
+		stats.retries.Add(1)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isTransient reports whether a request failed in a way a later attempt may
+// not: the connection was refused or reset, or the server answered 429 or 5xx.
+// A POST or PATCH may have taken effect before a reset, a 502 or a 504 of a
+// proxy, e.g. an EventListener creating a PipelineRun, so retrying it could
+// apply it twice: they are only retried when the server did not process
+// them, that is a refused connection, 429, or 503 with Retry-After.
+func isTransient(method string, resp *http.Response, err error) bool {
+	if method == http.MethodPost || method == http.MethodPatch {
+		if err != nil {
+			return errors.Is(err, syscall.ECONNREFUSED)
+		}
+		_, after := retryAfter(resp)
+		return resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode == http.StatusServiceUnavailable && after)
+	}
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryAfter returns the delay of the Retry-After header of a 429 or 503
+// response, in seconds
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
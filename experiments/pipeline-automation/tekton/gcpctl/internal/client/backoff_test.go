@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// fastRetry retries without noticeable delays
+func fastRetry(attempts int) RetryPolicy {
+	return RetryPolicy{MaxAttempts: attempts, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+}
+
+// flakyServer answers with the given status codes in turn, then 202
+func flakyServer(t *testing.T, codes ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n <= len(codes) {
+			w.WriteHeader(codes[n-1])
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+var testRegionRequest = &api.RegionRequest{Environment: "integration", Region: "us-central1", Sector: "main"}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(3); got < 200*time.Millisecond || got > 400*time.Millisecond {
+			t.Fatalf("backoff(3) with jitter = %v, want between 200ms and 400ms", got)
+		}
+	}
+}
+
+func TestTektonClient_RetriesTransientStatus(t *testing.T) {
+	server, calls := flakyServer(t, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests)
+
+	var retried []RetryAttempt
+	policy := fastRetry(4)
+	policy.OnRetry = func(a RetryAttempt) { retried = append(retried, a) }
+
+	client := NewTektonClient(server.URL)
+	client.SetRetryPolicy(policy)
+	if _, err := client.AddRegion(context.Background(), testRegionRequest); err != nil {
+		t.Fatalf("AddRegion() error = %v", err)
+	}
+
+	if calls.Load() != 4 {
+		t.Errorf("calls = %d, want 4", calls.Load())
+	}
+	if len(retried) != 3 || retried[0].StatusCode != http.StatusTooManyRequests || retried[2].Attempt != 3 {
+		t.Errorf("OnRetry calls = %+v", retried)
+	}
+	if got, want := client.RetryStats(), (RetryStats{Requests: 1, Retries: 3}); got != want {
+		t.Errorf("RetryStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTektonClient_GivesUpAfterMaxAttempts(t *testing.T) {
+	server, calls := flakyServer(t, 429, 429, 429, 429)
+
+	client := NewTektonClient(server.URL)
+	client.SetRetryPolicy(fastRetry(3))
+	_, err := client.AddRegion(context.Background(), testRegionRequest)
+	if err == nil {
+		t.Fatal("AddRegion() should return the error of the last attempt")
+	}
+	if calls.Load() != 3 {
+		t.Errorf("calls = %d, want 3", calls.Load())
+	}
+	if got := client.RetryStats(); got.Exhausted != 1 {
+		t.Errorf("RetryStats() = %+v, want 1 exhausted request", got)
+	}
+}
+
+func TestTektonClient_DoesNotRetryClientErrors(t *testing.T) {
+	server, calls := flakyServer(t, http.StatusBadRequest)
+
+	client := NewTektonClient(server.URL)
+	client.SetRetryPolicy(fastRetry(4))
+	if _, err := client.AddRegion(context.Background(), testRegionRequest); err == nil {
+		t.Fatal("AddRegion() should fail on 400")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestTektonClient_TriggerNotReplayed(t *testing.T) {
+	// A trigger the EventListener may have processed is not sent again, it
+	// could create a second pipeline run
+	for _, tc := range []struct {
+		status     int
+		retryAfter string
+		calls      int32
+	}{
+		{http.StatusBadGateway, "", 1},
+		{http.StatusGatewayTimeout, "", 1},
+		{http.StatusInternalServerError, "", 1},
+		{http.StatusServiceUnavailable, "", 1},
+		// Not processed: the server asks to come back later
+		{http.StatusServiceUnavailable, "0", 2},
+		{http.StatusTooManyRequests, "", 2},
+	} {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.status)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}))
+
+		client := NewTektonClient(server.URL)
+		client.SetRetryPolicy(fastRetry(4))
+		client.AddRegion(context.Background(), testRegionRequest)
+		server.Close()
+		if calls.Load() != tc.calls {
+			t.Errorf("status %d, Retry-After %q: calls = %d, want %d", tc.status, tc.retryAfter, calls.Load(), tc.calls)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	reset := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	refused := &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	badGateway := &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}}
+	for _, tc := range []struct {
+		method string
+		resp   *http.Response
+		err    error
+		want   bool
+	}{
+		{http.MethodGet, badGateway, nil, true},
+		{http.MethodGet, nil, reset, true},
+		{http.MethodDelete, badGateway, nil, true},
+		{http.MethodPost, badGateway, nil, false},
+		{http.MethodPost, nil, reset, false},
+		{http.MethodPost, nil, refused, true},
+		{http.MethodPatch, badGateway, nil, false},
+	} {
+		if got := isTransient(tc.method, tc.resp, tc.err); got != tc.want {
+			t.Errorf("isTransient(%s, %v, %v) = %v, want %v", tc.method, tc.resp, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestTektonClient_NoRetry(t *testing.T) {
+	server, calls := flakyServer(t, http.StatusServiceUnavailable)
+
+	client := NewTektonClient(server.URL)
+	client.SetRetryPolicy(NoRetry)
+	if _, err := client.AddRegion(context.Background(), testRegionRequest); err == nil {
+		t.Fatal("AddRegion() should fail without retries")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestTektonClient_RetriesConnectionRefused(t *testing.T) {
+	// Reserve a port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var retried []RetryAttempt
+	policy := fastRetry(3)
+	policy.OnRetry = func(a RetryAttempt) { retried = append(retried, a) }
+
+	client := NewTektonClient("http://" + addr)
+	client.SetRetryPolicy(policy)
+	_, err = client.AddRegion(context.Background(), testRegionRequest)
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("AddRegion() error = %v, want connection refused", err)
+	}
+	if len(retried) != 2 || retried[0].Err == nil {
+		t.Errorf("OnRetry calls = %+v, want 2 with the error", retried)
+	}
+}
+
+func TestTektonClient_RetryHonorsDeadline(t *testing.T) {
+	server, calls := flakyServer(t, 429, 429, 429)
+
+	client := NewTektonClient(server.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Second, MaxBackoff: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := client.AddRegion(ctx, testRegionRequest); err == nil {
+		t.Fatal("AddRegion() should fail when the next attempt is past the deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("AddRegion() took %v, want it to give up without waiting", elapsed)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestTektonClient_RetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	var delay time.Duration
+	policy := RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	policy.OnRetry = func(a RetryAttempt) { delay = a.Delay }
+
+	client := NewTektonClient(server.URL)
+	client.SetRetryPolicy(policy)
+	if _, err := client.AddRegion(context.Background(), testRegionRequest); err != nil {
+		t.Fatalf("AddRegion() error = %v", err)
+	}
+	// Retry-After replaces the backoff, capped at MaxBackoff
+	if delay != 20*time.Millisecond {
+		t.Errorf("delay = %v, want 20ms", delay)
+	}
+}
+
+func TestTektonAPIClient_RetriesTransientStatus(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"items": []}`))
+	}))
+	defer server.Close()
+
+	client := NewTektonAPIClient(server.URL)
+	client.SetRetryPolicy(fastRetry(4))
+	runs, err := client.ListPipelineRuns(context.Background(), "default", "")
+	if err != nil {
+		t.Fatalf("ListPipelineRuns() error = %v", err)
+	}
+	if len(runs) != 0 || calls.Load() != 3 {
+		t.Errorf("runs = %v, calls = %d, want none after 3 calls", runs, calls.Load())
+	}
+	if got := client.RetryStats(); got.Retries != 2 {
+		t.Errorf("RetryStats() = %+v, want 2 retries", got)
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BundleVersionLabel is the label of the region pipeline carrying the version
+// of the pipeline bundle. Tekton copies the labels of a pipeline to its runs.
+const BundleVersionLabel = "app.kubernetes.io/version"
+
+// versionTimeout bounds a request to a version endpoint
+const versionTimeout = 10 * time.Second
+
+// FetchBundleVersion reads the bundle version from a version endpoint, which
+// returns either {"version": "1.2.0"} or the bare version. headers are sent
+// with the request, e.g. the credentials of the proxy in front of the
+// management cluster.
+func FetchBundleVersion(ctx context.Context, hc *http.Client, url string, headers map[string]string) (string, error) {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json, text/plain")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to query version endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", fmt.Errorf("failed to read version endpoint response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("version endpoint returned status %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		Version string `json:"version"`
+	}
+	text := strings.TrimSpace(string(body))
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return "", fmt.Errorf("invalid version endpoint response: %w", err)
+		}
+		text = strings.TrimSpace(payload.Version)
+	}
+	if text == "" || strings.ContainsAny(text, " \n") {
+		return "", fmt.Errorf("version endpoint returned no version")
+	}
+	return text, nil
+}
+
+// LatestBundleVersion returns the bundle version label of the most recently
+// created run that has one, and the name of that run
+func LatestBundleVersion(runs []TektonPipelineRun) (version, run string) {
+	created := ""
+	for _, pr := range runs {
+		v := pr.Metadata.Labels[BundleVersionLabel]
+		if v == "" || pr.Metadata.CreationTimestamp < created {
+			continue
+		}
+		version, run, created = v, pr.Metadata.Name, pr.Metadata.CreationTimestamp
+	}
+	return version, run
+}
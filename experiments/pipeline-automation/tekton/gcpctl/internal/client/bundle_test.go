@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchBundleVersion(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		"json":         {status: http.StatusOK, body: `{"version": "1.2.0", "commit": "abc"}`, want: "1.2.0"},
+		"plain text":   {status: http.StatusOK, body: "v1.1.0\n", want: "v1.1.0"},
+		"empty":        {status: http.StatusOK, body: `{"commit": "abc"}`, wantErr: true},
+		"html":         {status: http.StatusOK, body: "<html>\n<body>login</body></html>", wantErr: true},
+		"not found":    {status: http.StatusNotFound, body: "not found", wantErr: true},
+		"invalid json": {status: http.StatusOK, body: `{"version": 1`, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Proxy-Authorization"); got != "Bearer token" {
+					t.Errorf("Proxy-Authorization = %q, want the configured header", got)
+				}
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			got, err := FetchBundleVersion(context.Background(), srv.Client(), srv.URL+"/version",
+				map[string]string{"Proxy-Authorization": "Bearer token"})
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("FetchBundleVersion() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("FetchBundleVersion() = %q, %v, want %q", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLatestBundleVersion(t *testing.T) {
+	run := func(name, created, version string) TektonPipelineRun {
+		var pr TektonPipelineRun
+		pr.Metadata.Name = name
+		pr.Metadata.CreationTimestamp = created
+		if version != "" {
+			pr.Metadata.Labels = map[string]string{BundleVersionLabel: version}
+		}
+		return pr
+	}
+
+	runs := []TektonPipelineRun{
+		run("old", "2025-01-01T10:00:00Z", "1.1.0"),
+		run("newest-unlabeled", "2025-01-03T10:00:00Z", ""),
+		run("new", "2025-01-02T10:00:00Z", "1.2.0"),
+	}
+	if version, name := LatestBundleVersion(runs); version != "1.2.0" || name != "new" {
+		t.Errorf("LatestBundleVersion() = %q, %q, want 1.2.0 of run new", version, name)
+	}
+
+	if version, name := LatestBundleVersion(runs[1:2]); version != "" || name != "" {
+		t.Errorf("LatestBundleVersion() of unlabeled runs = %q, %q, want none", version, name)
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// ErrPipelineRunNotFound is returned when no pipeline run matches an event ID
+var ErrPipelineRunNotFound = errors.New("no pipeline runs found")
+
+const (
+	// DefaultPollInterval is the delay between two status queries when following a pipeline run
+	DefaultPollInterval = 5 * time.Second
+
+	// maxConsecutiveErrors is how many status queries in a row may fail before
+	// following a pipeline run gives up
+	maxConsecutiveErrors = 5
+)
+
+// EventStatusGetter looks up the pipeline run created for a trigger event
+type EventStatusGetter interface {
+	GetPipelineRunsByEventID(ctx context.Context, namespace, eventID string) (*api.PipelineRunStatus, error)
+}
+
+// TaskTransition describes a task whose status changed between two polls
+type TaskTransition struct {
+	Task string
+	// From is empty when the task was not seen before
+	From string
+	To   string
+	// Duration is set once the task completed
+	Duration string
+}
+
+// FollowPipelineRun polls the pipeline run of an event until it reaches a
+// terminal state, calling onUpdate with the previous and current status after
+// every poll (the previous status is nil on the first one). The pipeline run
+// not existing yet, as right after triggering, is not an error; the caller
+// bounds the wait with ctx. The final status is returned.
+func FollowPipelineRun(ctx context.Context, getter EventStatusGetter, namespace, eventID string, interval time.Duration, onUpdate func(prev, cur *api.PipelineRunStatus)) (*api.PipelineRunStatus, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	var prev *api.PipelineRunStatus
+	failures := 0
+	for {
+		cur, err := getter.GetPipelineRunsByEventID(ctx, namespace, eventID)
+		switch {
+		case err == nil:
+			failures = 0
+			if onUpdate != nil {
+				onUpdate(prev, cur)
+			}
+			if cur.IsDone() {
+				return cur, nil
+			}
+			prev = cur
+		case errors.Is(err, ErrPipelineRunNotFound):
+			failures = 0
+		case ctx.Err() == nil:
+			failures++
+			if failures >= maxConsecutiveErrors {
+				return prev, fmt.Errorf("failed to get pipeline status %d times in a row: %w", failures, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if prev == nil {
+				return nil, fmt.Errorf("timed out waiting for the pipeline run of event %s: %w", eventID, ctx.Err())
+			}
+			return prev, fmt.Errorf("timed out waiting for pipeline run %s: %w", prev.Name, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// DiffTasks returns the tasks of cur whose status differs from prev, in the
+// order of cur. All tasks are returned when prev is nil.
+func DiffTasks(prev, cur *api.PipelineRunStatus) []TaskTransition {
+	before := make(map[string]string)
+	if prev != nil {
+		for _, task := range prev.Tasks {
+			before[task.Name] = task.Status
+		}
+	}
+
+	var transitions []TaskTransition
+	for _, task := range cur.Tasks {
+		if from, ok := before[task.Name]; ok && from == task.Status {
+			continue
+		}
+		transition := TaskTransition{Task: task.Name, From: before[task.Name], To: task.Status}
+		if task.CompletionTime != "" {
+			transition.Duration = CalculateDuration(task.StartTime, task.CompletionTime)
+		}
+		transitions = append(transitions, transition)
+	}
+	return transitions
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// fakeGetter returns one scripted result per call, repeating the last one
+type fakeGetter struct {
+	results []fakeResult
+	calls   int
+}
+
+type fakeResult struct {
+	status *api.PipelineRunStatus
+	err    error
+}
+
+func (f *fakeGetter) GetPipelineRunsByEventID(ctx context.Context, namespace, eventID string) (*api.PipelineRunStatus, error) {
+	r := f.results[min(f.calls, len(f.results)-1)]
+	f.calls++
+	return r.status, r.err
+}
+
+func runStatus(status string, tasks ...api.TaskRunStatus) *api.PipelineRunStatus {
+	return &api.PipelineRunStatus{Name: "gcp-region-provision-jf8v5", Namespace: "default", Status: status, Tasks: tasks}
+}
+
+func TestFollowPipelineRun_Succeeded(t *testing.T) {
+	notFound := fmt.Errorf("%w for event ID: %s", ErrPipelineRunNotFound, "63950e1f")
+	getter := &fakeGetter{results: []fakeResult{
+		{err: notFound},
+		{status: runStatus("Running", api.TaskRunStatus{Name: "validate-inputs", Status: "Running"})},
+		{err: errors.New("kubectl command failed: connection refused")},
+		{status: runStatus("Succeeded", api.TaskRunStatus{Name: "validate-inputs", Status: "Succeeded"})},
+	}}
+
+	var updates int
+	var firstPrev *api.PipelineRunStatus
+	final, err := FollowPipelineRun(context.Background(), getter, "default", "63950e1f", time.Millisecond,
+		func(prev, cur *api.PipelineRunStatus) {
+			if updates == 0 {
+				firstPrev = prev
+			}
+			updates++
+		})
+	if err != nil {
+		t.Fatalf("FollowPipelineRun() error = %v", err)
+	}
+
+	if final.Status != "Succeeded" {
+		t.Errorf("Status = %v, want %v", final.Status, "Succeeded")
+	}
+	if getter.calls != 4 {
+		t.Errorf("calls = %d, want 4", getter.calls)
+	}
+	if updates != 2 || firstPrev != nil {
+		t.Errorf("updates = %d with first prev %+v, want 2 starting from nil", updates, firstPrev)
+	}
+}
+
+func TestFollowPipelineRun_Failed(t *testing.T) {
+	failed := runStatus("Failed")
+	failed.Message = "Tasks Completed: 2 (Failed: 1, Cancelled 0), Skipped: 3"
+	getter := &fakeGetter{results: []fakeResult{{status: failed}}}
+
+	final, err := FollowPipelineRun(context.Background(), getter, "default", "63950e1f", time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("FollowPipelineRun() error = %v", err)
+	}
+	if final.Status != "Failed" || final.Message != failed.Message {
+		t.Errorf("final = %+v, want the failed run", final)
+	}
+}
+
+func TestFollowPipelineRun_Errors(t *testing.T) {
+	getter := &fakeGetter{results: []fakeResult{{err: errors.New("kubectl command failed")}}}
+
+	_, err := FollowPipelineRun(context.Background(), getter, "default", "63950e1f", time.Millisecond, nil)
+	if err == nil {
+		t.Fatal("FollowPipelineRun() should give up after repeated errors")
+	}
+	if getter.calls != maxConsecutiveErrors {
+		t.Errorf("calls = %d, want %d", getter.calls, maxConsecutiveErrors)
+	}
+}
+
+func TestFollowPipelineRun_Timeout(t *testing.T) {
+	getter := &fakeGetter{results: []fakeResult{{status: runStatus("Running")}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	last, err := FollowPipelineRun(ctx, getter, "default", "63950e1f", time.Millisecond, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("FollowPipelineRun() error = %v, want a deadline error", err)
+	}
+	if last == nil || last.Status != "Running" {
+		t.Errorf("last = %+v, want the last running status", last)
+	}
+}
+
+func TestDiffTasks(t *testing.T) {
+	prev := runStatus("Running",
+		api.TaskRunStatus{Name: "validate-inputs", Status: "Succeeded"},
+		api.TaskRunStatus{Name: "terraform-plan", Status: "Running", StartTime: "2025-10-15T18:08:31Z"},
+	)
+	cur := runStatus("Running",
+		api.TaskRunStatus{Name: "validate-inputs", Status: "Succeeded"},
+		api.TaskRunStatus{Name: "terraform-plan", Status: "Succeeded", StartTime: "2025-10-15T18:08:31Z", CompletionTime: "2025-10-15T18:09:13Z"},
+		api.TaskRunStatus{Name: "terraform-apply", Status: "Running"},
+	)
+
+	got := DiffTasks(prev, cur)
+	want := []TaskTransition{
+		{Task: "terraform-plan", From: "Running", To: "Succeeded", Duration: "42s"},
+		{Task: "terraform-apply", To: "Running"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("DiffTasks() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("DiffTasks()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if all := DiffTasks(nil, cur); len(all) != 3 {
+		t.Errorf("DiffTasks(nil) = %+v, want all 3 tasks", all)
+	}
+}
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	pipelineRunsResource = schema.GroupVersionResource{Group: "tekton.dev", Version: "v1", Resource: "pipelineruns"}
+	taskRunsResource     = schema.GroupVersionResource{Group: "tekton.dev", Version: "v1", Resource: "taskruns"}
+)
+
+// KubeconfigClient reads Tekton resources directly from the cluster of the
+// user's kubeconfig, without kubectl. PipelineRuns and TaskRuns are read with
+// the dynamic client and decoded into the same types as the other clients.
+type KubeconfigClient struct {
+	dynamic dynamic.Interface
+	core    kubernetes.Interface
+}
+
+// NewKubeconfigClient creates a client for a kubeconfig context. An empty
+// kubeconfig path uses $KUBECONFIG or ~/.kube/config, an empty context the
+// current one. proxy and noProxy are used as by NewTransport, unless the
+// cluster of the context sets a proxy-url.
+func NewKubeconfigClient(kubeconfig, kubeContext, proxy, noProxy string) (*KubeconfigClient, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		rules.ExplicitPath = kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	if proxy != "" && restConfig.Proxy == nil {
+		rt, err := NewTransport(proxy, noProxy)
+		if err != nil {
+			return nil, err
+		}
+		restConfig.Proxy = rt.(*http.Transport).Proxy
+	}
+
+	dyn, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	core, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	return newKubeconfigClient(dyn, core), nil
+}
+
+func newKubeconfigClient(dyn dynamic.Interface, core kubernetes.Interface) *KubeconfigClient {
+	return &KubeconfigClient{dynamic: dyn, core: core}
+}
+
+// GetPipelineRunsByEventID queries for pipeline runs matching an event ID
+func (c *KubeconfigClient) GetPipelineRunsByEventID(ctx context.Context, namespace, eventID string) (*api.PipelineRunStatus, error) {
+	runs, err := c.ListPipelineRuns(ctx, namespace, "triggers.tekton.dev/triggers-eventid="+eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%w for event ID: %s", ErrPipelineRunNotFound, eventID)
+	}
+
+	// Get the most recent pipeline run
+	pr := runs[0]
+
+	status := convertPipelineRunToStatus(&pr)
+
+	return status, nil
+}
+
+// GetPipelineRun queries for a specific pipeline run by name
+func (c *KubeconfigClient) GetPipelineRun(ctx context.Context, namespace, name string) (*api.PipelineRunStatus, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	obj, err := c.dynamic.Resource(pipelineRunsResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pipeline run: %w", err)
+	}
+
+	var pr TektonPipelineRun
+	if err := decodeUnstructured(obj, &pr); err != nil {
+		return nil, err
+	}
+
+	status := convertPipelineRunToStatus(&pr)
+
+	return status, nil
+}
+
+// ListPipelineRuns queries for the pipeline runs matching a label selector
+func (c *KubeconfigClient) ListPipelineRuns(ctx context.Context, namespace, labelSelector string) ([]TektonPipelineRun, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	list, err := c.dynamic.Resource(pipelineRunsResource).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pipeline runs: %w", err)
+	}
+
+	runs := make([]TektonPipelineRun, len(list.Items))
+	for i := range list.Items {
+		if err := decodeUnstructured(&list.Items[i], &runs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return runs, nil
+}
+
+// ListTaskRuns queries for the TaskRuns of a pipeline run
+func (c *KubeconfigClient) ListTaskRuns(ctx context.Context, namespace, pipelineRun string) ([]TektonTaskRun, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	list, err := c.dynamic.Resource(taskRunsResource).Namespace(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "tekton.dev/pipelineRun=" + pipelineRun,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task runs: %w", err)
+	}
+
+	taskRuns := make([]TektonTaskRun, len(list.Items))
+	for i := range list.Items {
+		if err := decodeUnstructured(&list.Items[i], &taskRuns[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return taskRuns, nil
+}
+
+// StreamPodLogs copies the logs of a pod container to w
+func (c *KubeconfigClient) StreamPodLogs(ctx context.Context, namespace, pod, container string, follow bool, w io.Writer) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	stream, err := c.core.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container: container,
+		Follow:    follow,
+	}).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get logs: %w", err)
+	}
+	defer stream.Close()
+
+	if _, err := io.Copy(w, stream); err != nil {
+		return fmt.Errorf("failed to read logs: %w", err)
+	}
+	return nil
+}
+
+// GetPipelineRunObject queries for a pipeline run as a raw object
+func (c *KubeconfigClient) GetPipelineRunObject(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	obj, err := c.dynamic.Resource(pipelineRunsResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pipeline run: %w", err)
+	}
+	return obj, nil
+}
+
+// CreatePipelineRun creates a pipeline run and returns it as created
+func (c *KubeconfigClient) CreatePipelineRun(ctx context.Context, namespace string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	created, err := c.dynamic.Resource(pipelineRunsResource).Namespace(namespace).Create(ctx, obj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipeline run: %w", err)
+	}
+	return created, nil
+}
+
+// LabelPipelineRun adds labels to a pipeline run
+func (c *KubeconfigClient) LabelPipelineRun(ctx context.Context, namespace, name string, labels map[string]string) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	patch, err := labelPatch(labels)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.dynamic.Resource(pipelineRunsResource).Namespace(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to label pipeline run: %w", err)
+	}
+	return nil
+}
+
+// DeletePipelineRun deletes a pipeline run; its TaskRuns and pods are
+// deleted in the background
+func (c *KubeconfigClient) DeletePipelineRun(ctx context.Context, namespace, name string) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	if err := c.dynamic.Resource(pipelineRunsResource).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete pipeline run: %w", err)
+	}
+	return nil
+}
+
+// decodeUnstructured converts an object of the dynamic client into one of our types
+func decodeUnstructured(obj *unstructured.Unstructured, out any) error {
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	return nil
+}
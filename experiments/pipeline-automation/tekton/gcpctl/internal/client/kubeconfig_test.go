@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// tektonObject builds an unstructured Tekton resource
+func tektonObject(kind, name string, labels map[string]any, spec, status map[string]any) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "tekton.dev/v1",
+		"kind":       kind,
+		"metadata": map[string]any{
+			"name":              name,
+			"namespace":         "default",
+			"creationTimestamp": "2025-10-15T18:08:31Z",
+			"labels":            labels,
+		},
+		"spec":   spec,
+		"status": status,
+	}}
+}
+
+func newFakeKubeconfigClient() *KubeconfigClient {
+	running := tektonObject("PipelineRun", "gcp-region-provision-jf8v5",
+		map[string]any{
+			"triggers.tekton.dev/triggers-eventid": "63950e1f-7ffe-4d14-bc0e-121cee88942e",
+			"tekton.dev/pipeline":                  RegionPipelineName,
+		},
+		map[string]any{
+			"params": []any{
+				map[string]any{"name": "environment", "value": "integration"},
+				map[string]any{"name": "region", "value": "us-central1"},
+				map[string]any{"name": "sector", "value": "main"},
+			},
+		},
+		map[string]any{
+			"startTime": "2025-10-15T18:08:31Z",
+			"conditions": []any{
+				map[string]any{"type": "Succeeded", "status": "Unknown", "reason": "Running"},
+			},
+		})
+	other := tektonObject("PipelineRun", "nightly-e2e-x2x9k",
+		map[string]any{"tekton.dev/pipeline": "gcp-region-e2e"}, map[string]any{}, map[string]any{})
+	taskRun := tektonObject("TaskRun", "gcp-region-provision-jf8v5-validate-inputs",
+		map[string]any{
+			"tekton.dev/pipelineRun":  "gcp-region-provision-jf8v5",
+			"tekton.dev/pipelineTask": "validate-inputs",
+		},
+		map[string]any{},
+		map[string]any{
+			"podName": "gcp-region-provision-jf8v5-validate-inputs-pod",
+			"steps":   []any{map[string]any{"name": "validate", "container": "step-validate"}},
+		})
+
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		pipelineRunsResource: "PipelineRunList",
+		taskRunsResource:     "TaskRunList",
+	}, running, other, taskRun)
+	return newKubeconfigClient(dyn, kubefake.NewClientset())
+}
+
+func TestKubeconfigClient_GetPipelineRunsByEventID(t *testing.T) {
+	c := newFakeKubeconfigClient()
+
+	status, err := c.GetPipelineRunsByEventID(context.Background(), "default", "63950e1f-7ffe-4d14-bc0e-121cee88942e")
+	if err != nil {
+		t.Fatalf("GetPipelineRunsByEventID() error = %v", err)
+	}
+	if status.Name != "gcp-region-provision-jf8v5" || status.Status != "Running" {
+		t.Errorf("status = %+v, want running gcp-region-provision-jf8v5", status)
+	}
+
+	_, err = c.GetPipelineRunsByEventID(context.Background(), "default", "does-not-exist")
+	if !errors.Is(err, ErrPipelineRunNotFound) {
+		t.Errorf("GetPipelineRunsByEventID() for an unknown event error = %v, want ErrPipelineRunNotFound", err)
+	}
+}
+
+func TestKubeconfigClient_GetPipelineRun(t *testing.T) {
+	c := newFakeKubeconfigClient()
+
+	status, err := c.GetPipelineRun(context.Background(), "", "gcp-region-provision-jf8v5")
+	if err != nil {
+		t.Fatalf("GetPipelineRun() error = %v", err)
+	}
+	if status.StartTime != "2025-10-15T18:08:31Z" {
+		t.Errorf("StartTime = %v, want %v", status.StartTime, "2025-10-15T18:08:31Z")
+	}
+
+	if _, err := c.GetPipelineRun(context.Background(), "default", "missing"); err == nil {
+		t.Error("GetPipelineRun() should return error for a missing pipeline run")
+	}
+}
+
+func TestKubeconfigClient_ListPipelineRuns(t *testing.T) {
+	c := newFakeKubeconfigClient()
+
+	runs, err := c.ListPipelineRuns(context.Background(), "default", RegionPipelineSelector)
+	if err != nil {
+		t.Fatalf("ListPipelineRuns() error = %v", err)
+	}
+	if len(runs) != 1 || runs[0].Param("region") != "us-central1" {
+		t.Errorf("ListPipelineRuns() = %+v, want the region pipeline run", runs)
+	}
+}
+
+func TestKubeconfigClient_Logs(t *testing.T) {
+	c := newFakeKubeconfigClient()
+
+	var out strings.Builder
+	err := StreamPipelineRunLogs(context.Background(), c, "default", "gcp-region-provision-jf8v5", LogOptions{}, &out)
+	if err != nil {
+		t.Fatalf("StreamPipelineRunLogs() error = %v", err)
+	}
+	// The fake clientset answers every log request with "fake logs"
+	if want := "==> validate-inputs [validate] <==\nfake logs\n"; out.String() != want {
+		t.Errorf("logs = %q, want %q", out.String(), want)
+	}
+}
+
+func TestKubeconfigClient_DeletePipelineRun(t *testing.T) {
+	c := newFakeKubeconfigClient()
+
+	if err := c.DeletePipelineRun(context.Background(), "", "nightly-e2e-x2x9k"); err != nil {
+		t.Fatalf("DeletePipelineRun() error = %v", err)
+	}
+	runs, err := c.ListPipelineRuns(context.Background(), "default", "")
+	if err != nil {
+		t.Fatalf("ListPipelineRuns() error = %v", err)
+	}
+	if len(runs) != 1 || runs[0].Metadata.Name != "gcp-region-provision-jf8v5" {
+		t.Errorf("ListPipelineRuns() = %+v, want only the region pipeline run", runs)
+	}
+
+	if err := c.DeletePipelineRun(context.Background(), "default", "missing"); err == nil {
+		t.Error("DeletePipelineRun() should return error for a missing pipeline run")
+	}
+}
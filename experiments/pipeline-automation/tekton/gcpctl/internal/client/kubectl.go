@@ -19,18 +19,39 @@ func NewKubectlClient() *KubectlClient {
 
 // GetPipelineRunsByEventID queries for pipeline runs using kubectl
 func (c *KubectlClient) GetPipelineRunsByEventID(ctx context.Context, namespace, eventID string) (*api.PipelineRunStatus, error) {
+	labelSelector := fmt.Sprintf("triggers.tekton.dev/triggers-eventid=%s", eventID)
+	runs, err := c.ListPipelineRuns(ctx, namespace, labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%w for event ID: %s", ErrPipelineRunNotFound, eventID)
+	}
+
+	// Get the most recent pipeline run
+	pr := runs[0]
+
+	status := convertPipelineRunToStatus(&pr)
+
+	return status, nil
+}
+
+// ListPipelineRuns queries for the pipeline runs matching a label selector using kubectl
+func (c *KubectlClient) ListPipelineRuns(ctx context.Context, namespace, labelSelector string) ([]TektonPipelineRun, error) {
 	if namespace == "" {
 		namespace = "default"
 	}
 
 	// Build kubectl command
-	labelSelector := fmt.Sprintf("triggers.tekton.dev/triggers-eventid=%s", eventID)
 	args := []string{
 		"get", "pipelineruns",
 		"-n", namespace,
-		"-l", labelSelector,
 		"-o", "json",
 	}
+	if labelSelector != "" {
+		args = append(args, "-l", labelSelector)
+	}
 
 	cmd := exec.CommandContext(ctx, "kubectl", args...)
 	output, err := cmd.Output()
@@ -47,18 +68,7 @@ func (c *KubectlClient) GetPipelineRunsByEventID(ctx context.Context, namespace,
 		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
 	}
 
-	if len(pipelineList.Items) == 0 {
-		return nil, fmt.Errorf("no pipeline runs found for event ID: %s", eventID)
-	}
-
-	// Get the most recent pipeline run
-	pr := pipelineList.Items[0]
-
-	// Create a temporary API client just to reuse the conversion function
-	apiClient := &TektonAPIClient{}
-	status := apiClient.convertPipelineRunToStatus(&pr)
-
-	return status, nil
+	return pipelineList.Items, nil
 }
 
 // GetPipelineRun queries for a specific pipeline run by name
@@ -88,8 +98,7 @@ func (c *KubectlClient) GetPipelineRun(ctx context.Context, namespace, name stri
 		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
 	}
 
-	apiClient := &TektonAPIClient{}
-	status := apiClient.convertPipelineRunToStatus(&pr)
+	status := convertPipelineRunToStatus(&pr)
 
 	return status, nil
 }
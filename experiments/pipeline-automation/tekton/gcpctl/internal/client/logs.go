@@ -0,0 +1,282 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// TektonTaskRun represents a Tekton TaskRun from the API
+type TektonTaskRun struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name              string            `json:"name"`
+		Namespace         string            `json:"namespace"`
+		CreationTimestamp string            `json:"creationTimestamp"`
+		Labels            map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Status struct {
+		Conditions     tektonConditions `json:"conditions,omitempty"`
+		PodName        string           `json:"podName,omitempty"`
+		StartTime      string           `json:"startTime,omitempty"`
+		CompletionTime string           `json:"completionTime,omitempty"`
+		Steps          []TektonStep     `json:"steps,omitempty"`
+	} `json:"status"`
+}
+
+// TektonTaskRunList represents a list of TaskRuns
+type TektonTaskRunList struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Items      []TektonTaskRun `json:"items"`
+}
+
+// PipelineTask returns the name of the pipeline task the TaskRun runs, or the
+// TaskRun name if it was not created by a pipeline
+func (tr *TektonTaskRun) PipelineTask() string {
+	if task := tr.Metadata.Labels["tekton.dev/pipelineTask"]; task != "" {
+		return task
+	}
+	return tr.Metadata.Name
+}
+
+// LogSource reads TaskRuns of a pipeline run and the logs of their pods
+type LogSource interface {
+	GetPipelineRun(ctx context.Context, namespace, name string) (*api.PipelineRunStatus, error)
+	ListTaskRuns(ctx context.Context, namespace, pipelineRun string) ([]TektonTaskRun, error)
+	StreamPodLogs(ctx context.Context, namespace, pod, container string, follow bool, w io.Writer) error
+}
+
+// LogOptions selects the logs printed by StreamPipelineRunLogs
+type LogOptions struct {
+	// Task limits the logs to one pipeline task
+	Task string
+	// Follow streams logs as they are written and waits for tasks that have
+	// not started yet, until the pipeline run finishes
+	Follow bool
+	// Interval is the delay between two looks for new TaskRuns when following
+	Interval time.Duration
+}
+
+// StreamPipelineRunLogs writes the logs of every step of the TaskRuns of a
+// pipeline run to w, task by task in the order they started. Each step is
+// preceded by a "==> task [step] <==" header.
+func StreamPipelineRunLogs(ctx context.Context, src LogSource, namespace, pipelineRun string, opts LogOptions, w io.Writer) error {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultPollInterval
+	}
+
+	// Steps whose logs were printed, by pod and container
+	printed := make(map[string]bool)
+	found := false
+	for {
+		// Look at the pipeline run before its TaskRuns, so TaskRuns created
+		// before it finished are all seen in the final pass
+		done := true
+		if opts.Follow {
+			status, err := src.GetPipelineRun(ctx, namespace, pipelineRun)
+			if err != nil {
+				return fmt.Errorf("failed to get pipeline run: %w", err)
+			}
+			done = status.IsDone()
+		}
+
+		taskRuns, err := src.ListTaskRuns(ctx, namespace, pipelineRun)
+		if err != nil {
+			return fmt.Errorf("failed to list task runs: %w", err)
+		}
+		sortTaskRuns(taskRuns)
+
+		for _, tr := range taskRuns {
+			if opts.Task != "" && tr.PipelineTask() != opts.Task {
+				continue
+			}
+			found = true
+			for _, step := range tr.Status.Steps {
+				key := tr.Status.PodName + "/" + step.Container
+				if tr.Status.PodName == "" || printed[key] {
+					continue
+				}
+
+				out := &headerWriter{w: w, header: fmt.Sprintf("==> %s [%s] <==\n", tr.PipelineTask(), step.Name)}
+				err := src.StreamPodLogs(ctx, namespace, tr.Status.PodName, step.Container, opts.Follow, out)
+				if err != nil {
+					// A container that has not started yet is retried on
+					// the next pass, unless it printed something already
+					if opts.Follow && !out.started && ctx.Err() == nil {
+						continue
+					}
+					return fmt.Errorf("failed to get logs of %s/%s: %w", tr.Status.PodName, step.Container, err)
+				}
+				// Print the header of steps that logged nothing
+				out.Write(nil)
+				fmt.Fprintln(w)
+				printed[key] = true
+			}
+		}
+
+		if done {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.Interval):
+		}
+	}
+
+	if !found {
+		if opts.Task != "" {
+			return fmt.Errorf("no task %q in pipeline run %s", opts.Task, pipelineRun)
+		}
+		return fmt.Errorf("no task runs found for pipeline run %s", pipelineRun)
+	}
+	return nil
+}
+
+// sortTaskRuns orders TaskRuns by start time, those that have not started last
+func sortTaskRuns(taskRuns []TektonTaskRun) {
+	sort.SliceStable(taskRuns, func(i, j int) bool {
+		a, b := taskRuns[i].Status.StartTime, taskRuns[j].Status.StartTime
+		if (a == "") != (b == "") {
+			return b == ""
+		}
+		if a != b {
+			return a < b
+		}
+		return taskRuns[i].Metadata.Name < taskRuns[j].Metadata.Name
+	})
+}
+
+// headerWriter writes a header before the first write through it
+type headerWriter struct {
+	w       io.Writer
+	header  string
+	started bool
+}
+
+func (h *headerWriter) Write(p []byte) (int, error) {
+	if !h.started {
+		h.started = true
+		if _, err := io.WriteString(h.w, h.header); err != nil {
+			return 0, err
+		}
+	}
+	return h.w.Write(p)
+}
+
+// ListTaskRuns queries Tekton API for the TaskRuns of a pipeline run
+func (c *TektonAPIClient) ListTaskRuns(ctx context.Context, namespace, pipelineRun string) ([]TektonTaskRun, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	var taskRunList TektonTaskRunList
+	if err := c.doTekton(ctx, http.MethodGet, namespace, "taskruns", "", url.Values{"labelSelector": {pipelineRunSelector(pipelineRun)}}.Encode(), "", nil, &taskRunList); err != nil {
+		return nil, err
+	}
+
+	return taskRunList.Items, nil
+}
+
+// StreamPodLogs copies the logs of a pod container from the Kubernetes API to w.
+// With follow the request stays open until the container terminates.
+func (c *TektonAPIClient) StreamPodLogs(ctx context.Context, namespace, pod, container string, follow bool, w io.Writer) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	query := url.Values{"container": {container}}
+	if follow {
+		query.Set("follow", "true")
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/log?%s", c.baseURL, namespace, pod, query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	setHeaders(req.Header, c.customHeaders)
+
+	// The client timeout would cut off followed logs; ctx bounds the request instead
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query Kubernetes API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Kubernetes API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to read logs: %w", err)
+	}
+	return nil
+}
+
+// ListTaskRuns queries for the TaskRuns of a pipeline run using kubectl
+func (c *KubectlClient) ListTaskRuns(ctx context.Context, namespace, pipelineRun string) ([]TektonTaskRun, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	args := []string{
+		"get", "taskruns",
+		"-n", namespace,
+		"-l", "tekton.dev/pipelineRun=" + pipelineRun,
+		"-o", "json",
+	}
+
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("kubectl command failed: %s", string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("failed to execute kubectl: %w", err)
+	}
+
+	var taskRunList TektonTaskRunList
+	if err := json.Unmarshal(output, &taskRunList); err != nil {
+		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
+	}
+
+	return taskRunList.Items, nil
+}
+
+// StreamPodLogs copies the logs of a pod container to w using kubectl logs
+func (c *KubectlClient) StreamPodLogs(ctx context.Context, namespace, pod, container string, follow bool, w io.Writer) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	args := []string{"logs", pod, "-n", namespace, "-c", container}
+	if follow {
+		args = append(args, "-f")
+	}
+
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	cmd.Stdout = w
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("kubectl command failed: %s", stderr.String())
+		}
+		return fmt.Errorf("failed to execute kubectl: %w", err)
+	}
+	return nil
+}
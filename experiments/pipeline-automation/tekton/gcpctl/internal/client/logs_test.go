@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// taskRun builds a TaskRun of a pipeline task with the given steps
+func taskRun(task, pod, start string, steps ...string) TektonTaskRun {
+	var tr TektonTaskRun
+	tr.Metadata.Name = "gcp-region-provision-jf8v5-" + task
+	tr.Metadata.Labels = map[string]string{"tekton.dev/pipelineTask": task}
+	tr.Status.PodName = pod
+	tr.Status.StartTime = start
+	for _, step := range steps {
+		tr.Status.Steps = append(tr.Status.Steps, TektonStep{Name: step, Container: "step-" + step})
+	}
+	return tr
+}
+
+// fakeLogServer serves TaskRuns, pod logs and a pipeline run condition the
+// test can change
+type fakeLogServer struct {
+	mu        sync.Mutex
+	taskRuns  []TektonTaskRun
+	logs      map[string]string // by pod/container; missing means not started
+	condition string
+	follows   int
+}
+
+func (f *fakeLogServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/taskruns"):
+		if got := r.URL.Query().Get("labelSelector"); got != "tekton.dev/pipelineRun=gcp-region-provision-jf8v5" {
+			http.Error(w, "unexpected labelSelector "+got, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(TektonTaskRunList{Items: f.taskRuns})
+	case strings.HasSuffix(r.URL.Path, "/log"):
+		pod := strings.Split(r.URL.Path, "/")[6]
+		if r.URL.Query().Get("follow") == "true" {
+			f.follows++
+		}
+		logs, ok := f.logs[pod+"/"+r.URL.Query().Get("container")]
+		if !ok {
+			http.Error(w, `container "step-terraform" in pod is waiting to start: ContainerCreating`, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(logs))
+	case strings.Contains(r.URL.Path, "/pipelineruns/"):
+		fmt.Fprintf(w, `{"metadata":{"name":"gcp-region-provision-jf8v5"},"status":{"conditions":[{"type":"Succeeded","status":%q,"reason":"x"}]}}`, f.condition)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeLogServer() *fakeLogServer {
+	return &fakeLogServer{
+		taskRuns: []TektonTaskRun{
+			taskRun("terraform-init", "init-pod", "2025-10-15T18:09:40Z", "terraform"),
+			taskRun("validate-inputs", "validate-pod", "2025-10-15T18:08:31Z", "validate"),
+		},
+		logs: map[string]string{
+			"validate-pod/step-validate": "=== Validating Input Parameters ===\n",
+			"init-pod/step-terraform":    "Terraform has been successfully initialized!\n",
+		},
+		condition: "True",
+	}
+}
+
+func TestStreamPipelineRunLogs(t *testing.T) {
+	server := httptest.NewServer(newFakeLogServer())
+	defer server.Close()
+
+	var out strings.Builder
+	err := StreamPipelineRunLogs(context.Background(), NewTektonAPIClient(server.URL), "default", "gcp-region-provision-jf8v5", LogOptions{}, &out)
+	if err != nil {
+		t.Fatalf("StreamPipelineRunLogs() error = %v", err)
+	}
+
+	want := "==> validate-inputs [validate] <==\n=== Validating Input Parameters ===\n\n" +
+		"==> terraform-init [terraform] <==\nTerraform has been successfully initialized!\n\n"
+	if out.String() != want {
+		t.Errorf("logs = %q, want %q", out.String(), want)
+	}
+}
+
+func TestStreamPipelineRunLogs_Task(t *testing.T) {
+	server := httptest.NewServer(newFakeLogServer())
+	defer server.Close()
+	client := NewTektonAPIClient(server.URL)
+
+	var out strings.Builder
+	err := StreamPipelineRunLogs(context.Background(), client, "default", "gcp-region-provision-jf8v5", LogOptions{Task: "terraform-init"}, &out)
+	if err != nil {
+		t.Fatalf("StreamPipelineRunLogs() error = %v", err)
+	}
+	if strings.Contains(out.String(), "validate-inputs") || !strings.Contains(out.String(), "successfully initialized") {
+		t.Errorf("logs = %q, want only terraform-init", out.String())
+	}
+
+	err = StreamPipelineRunLogs(context.Background(), client, "default", "gcp-region-provision-jf8v5", LogOptions{Task: "terraform-apply"}, &out)
+	if err == nil || !strings.Contains(err.Error(), `no task "terraform-apply"`) {
+		t.Errorf("StreamPipelineRunLogs() for an unknown task error = %v", err)
+	}
+}
+
+func TestStreamPipelineRunLogs_Follow(t *testing.T) {
+	fake := newFakeLogServer()
+	// terraform-init is still starting and the pipeline run is running
+	delete(fake.logs, "init-pod/step-terraform")
+	fake.condition = "Unknown"
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// Let the container start and the pipeline run finish after a few polls
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.logs["init-pod/step-terraform"] = "Terraform has been successfully initialized!\n"
+		fake.condition = "True"
+	}()
+
+	var out strings.Builder
+	opts := LogOptions{Follow: true, Interval: 5 * time.Millisecond}
+	err := StreamPipelineRunLogs(context.Background(), NewTektonAPIClient(server.URL), "default", "gcp-region-provision-jf8v5", opts, &out)
+	if err != nil {
+		t.Fatalf("StreamPipelineRunLogs() error = %v", err)
+	}
+
+	if strings.Count(out.String(), "==> ") != 2 {
+		t.Errorf("logs = %q, want each step once", out.String())
+	}
+	if !strings.HasSuffix(out.String(), "Terraform has been successfully initialized!\n\n") {
+		t.Errorf("logs = %q, want the late step at the end", out.String())
+	}
+	if fake.follows < 3 {
+		t.Errorf("%d followed log requests, want retries of the starting container", fake.follows)
+	}
+}
@@ -0,0 +1,284 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultFanOutConcurrency is how many namespaces are queried at once by the
+// functions querying several namespaces
+const DefaultFanOutConcurrency = 8
+
+// NamespaceLister lists the namespaces matching a label selector
+type NamespaceLister interface {
+	ListNamespaces(ctx context.Context, labelSelector string) ([]string, error)
+}
+
+// PipelineRunLister lists the pipeline runs of a namespace matching a label selector
+type PipelineRunLister interface {
+	ListPipelineRuns(ctx context.Context, namespace, labelSelector string) ([]TektonPipelineRun, error)
+}
+
+// NamespaceErrors are the namespaces a fan-out query failed in, by namespace
+type NamespaceErrors map[string]error
+
+func (e NamespaceErrors) Error() string {
+	namespaces := make([]string, 0, len(e))
+	for ns := range e {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	msgs := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		msgs[i] = fmt.Sprintf("namespace %s: %v", ns, e[ns])
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the error of every namespace, for errors.Is and errors.As
+func (e NamespaceErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ResolveNamespaces returns the given namespaces and those matching selector,
+// sorted and without duplicates. The lister is only used with a selector.
+func ResolveNamespaces(ctx context.Context, lister NamespaceLister, namespaces []string, selector string) ([]string, error) {
+	seen := make(map[string]bool)
+	var resolved []string
+	add := func(ns string) {
+		if ns = strings.TrimSpace(ns); ns != "" && !seen[ns] {
+			seen[ns] = true
+			resolved = append(resolved, ns)
+		}
+	}
+	for _, ns := range namespaces {
+		add(ns)
+	}
+	if selector != "" {
+		selected, err := lister.ListNamespaces(ctx, selector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list namespaces matching %s: %w", selector, err)
+		}
+		for _, ns := range selected {
+			add(ns)
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, fmt.Errorf("no namespace to query")
+	}
+	sort.Strings(resolved)
+	return resolved, nil
+}
+
+// forEachNamespace runs query in every namespace, at most concurrency at
+// once, and returns the namespaces it failed in, nil if none
+func forEachNamespace(ctx context.Context, namespaces []string, concurrency int, query func(ctx context.Context, namespace string) error) NamespaceErrors {
+	if concurrency < 1 {
+		concurrency = DefaultFanOutConcurrency
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs NamespaceErrors
+		sem  = make(chan struct{}, concurrency)
+	)
+	for _, ns := range namespaces {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ns string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := query(ctx, ns); err != nil {
+				mu.Lock()
+				if errs == nil {
+					errs = make(NamespaceErrors)
+				}
+				errs[ns] = err
+				mu.Unlock()
+			}
+		}(ns)
+	}
+	wg.Wait()
+	return errs
+}
+
+// ListPipelineRunsInNamespaces lists the pipeline runs matching a label
+// selector in every namespace concurrently and merges them, newest first.
+// Namespaces that could not be read are returned in the NamespaceErrors with
+// the runs of the others, so one failing namespace does not hide the rest;
+// the error is only returned alone when every namespace failed.
+func ListPipelineRunsInNamespaces(ctx context.Context, lister PipelineRunLister, namespaces []string, labelSelector string, concurrency int) ([]TektonPipelineRun, NamespaceErrors, error) {
+	var (
+		mu   sync.Mutex
+		runs []TektonPipelineRun
+	)
+	errs := forEachNamespace(ctx, namespaces, concurrency, func(ctx context.Context, ns string) error {
+		found, err := lister.ListPipelineRuns(ctx, ns, labelSelector)
+		if err != nil {
+			return err
+		}
+		for i := range found {
+			// Not every backend fills the namespace of listed items
+			if found[i].Metadata.Namespace == "" {
+				found[i].Metadata.Namespace = ns
+			}
+		}
+		mu.Lock()
+		runs = append(runs, found...)
+		mu.Unlock()
+		return nil
+	})
+	if len(errs) > 0 && len(errs) == len(namespaces) {
+		return nil, nil, errs
+	}
+
+	// Creation timestamps are RFC 3339 in UTC, so they sort as strings
+	sort.SliceStable(runs, func(i, j int) bool {
+		a, b := runs[i].Metadata, runs[j].Metadata
+		if a.CreationTimestamp != b.CreationTimestamp {
+			return a.CreationTimestamp > b.CreationTimestamp
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return runs, errs, nil
+}
+
+// GetPipelineRunsByEventIDInNamespaces looks for the pipeline run of an event
+// in every namespace concurrently. An event triggers one pipeline run, so the
+// most recently started match is returned if several namespaces have one.
+// The error wraps ErrPipelineRunNotFound when no namespace has the run and
+// every namespace could be read; otherwise it lists the namespaces that failed.
+func GetPipelineRunsByEventIDInNamespaces(ctx context.Context, getter EventStatusGetter, namespaces []string, eventID string, concurrency int) (*api.PipelineRunStatus, error) {
+	var (
+		mu     sync.Mutex
+		latest *api.PipelineRunStatus
+	)
+	errs := forEachNamespace(ctx, namespaces, concurrency, func(ctx context.Context, ns string) error {
+		status, err := getter.GetPipelineRunsByEventID(ctx, ns, eventID)
+		if errors.Is(err, ErrPipelineRunNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if status.Namespace == "" {
+			status.Namespace = ns
+		}
+		mu.Lock()
+		if latest == nil || status.StartTime > latest.StartTime {
+			latest = status
+		}
+		mu.Unlock()
+		return nil
+	})
+
+	if latest != nil {
+		return latest, nil
+	}
+	if errs != nil {
+		return nil, fmt.Errorf("pipeline run of event %s not found in the namespaces that could be read: %w", eventID, errs)
+	}
+	return nil, fmt.Errorf("%w for event ID %s in namespaces %s", ErrPipelineRunNotFound, eventID, strings.Join(namespaces, ", "))
+}
+
+// FanOutGetter looks up the pipeline run of an event in several namespaces
+// concurrently with GetPipelineRunsByEventIDInNamespaces, e.g. to follow a
+// run whose namespace is not known. The namespace argument is ignored.
+type FanOutGetter struct {
+	Getter      EventStatusGetter
+	Namespaces  []string
+	Concurrency int
+}
+
+// GetPipelineRunsByEventID queries every namespace of the getter
+func (g *FanOutGetter) GetPipelineRunsByEventID(ctx context.Context, _, eventID string) (*api.PipelineRunStatus, error) {
+	return GetPipelineRunsByEventIDInNamespaces(ctx, g.Getter, g.Namespaces, eventID, g.Concurrency)
+}
+
+// ListNamespaces returns the names of the namespaces matching a label selector
+func (c *KubeconfigClient) ListNamespaces(ctx context.Context, labelSelector string) ([]string, error) {
+	list, err := c.core.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	names := make([]string, len(list.Items))
+	for i, ns := range list.Items {
+		names[i] = ns.Name
+	}
+	return names, nil
+}
+
+// ListNamespaces returns the names of the namespaces matching a label selector using kubectl
+func (c *KubectlClient) ListNamespaces(ctx context.Context, labelSelector string) ([]string, error) {
+	args := []string{"get", "namespaces", "-o", "json"}
+	if labelSelector != "" {
+		args = append(args, "-l", labelSelector)
+	}
+
+	output, err := exec.CommandContext(ctx, "kubectl", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("kubectl command failed: %s", string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("failed to execute kubectl: %w", err)
+	}
+	return parseNamespaceList(output)
+}
+
+// ListNamespaces returns the names of the namespaces matching a label
+// selector, read from the Kubernetes API behind the Tekton API URL, e.g. a
+// kubectl proxy
+func (c *TektonAPIClient) ListNamespaces(ctx context.Context, labelSelector string) ([]string, error) {
+	endpoint := c.baseURL + "/api/v1/namespaces"
+	if labelSelector != "" {
+		endpoint += "?" + url.Values{"labelSelector": {labelSelector}}.Encode()
+	}
+
+	var list json.RawMessage
+	if err := c.do(ctx, http.MethodGet, endpoint, "", nil, &list); err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	return parseNamespaceList(list)
+}
+
+// parseNamespaceList returns the names of a NamespaceList
+func parseNamespaceList(data []byte) ([]string, error) {
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse namespace list: %w", err)
+	}
+
+	names := make([]string, len(list.Items))
+	for i, item := range list.Items {
+		names[i] = item.Metadata.Name
+	}
+	return names, nil
+}
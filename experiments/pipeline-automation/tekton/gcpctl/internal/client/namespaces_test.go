@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeNamespaces serves pipeline runs and event lookups by namespace,
+// recording how many queries ran at once
+type fakeNamespaces struct {
+	runs   map[string][]TektonPipelineRun
+	errs   map[string]error
+	delay  time.Duration
+	active atomic.Int32
+
+	mu          sync.Mutex
+	maxInFlight int32
+}
+
+func (f *fakeNamespaces) enter() func() {
+	n := f.active.Add(1)
+	f.mu.Lock()
+	f.maxInFlight = max(f.maxInFlight, n)
+	f.mu.Unlock()
+	time.Sleep(f.delay)
+	return func() { f.active.Add(-1) }
+}
+
+func (f *fakeNamespaces) ListPipelineRuns(ctx context.Context, namespace, labelSelector string) ([]TektonPipelineRun, error) {
+	defer f.enter()()
+	if err := f.errs[namespace]; err != nil {
+		return nil, err
+	}
+	return append([]TektonPipelineRun(nil), f.runs[namespace]...), nil
+}
+
+func (f *fakeNamespaces) GetPipelineRunsByEventID(ctx context.Context, namespace, eventID string) (*api.PipelineRunStatus, error) {
+	defer f.enter()()
+	if err := f.errs[namespace]; err != nil {
+		return nil, err
+	}
+	for i := range f.runs[namespace] {
+		pr := &f.runs[namespace][i]
+		if pr.Metadata.Labels["triggers.tekton.dev/triggers-eventid"] == eventID {
+			return convertPipelineRunToStatus(pr), nil
+		}
+	}
+	return nil, fmt.Errorf("%w for event ID: %s", ErrPipelineRunNotFound, eventID)
+}
+
+// sectorRun is a region run of a sector namespace triggered by an event
+func sectorRun(namespace, name, created, sector, eventID string) TektonPipelineRun {
+	pr := regionRun(name, created, "production", sector, "us-central1", "add", "Running")
+	pr.Metadata.Namespace = namespace
+	pr.Metadata.Labels = map[string]string{"triggers.tekton.dev/triggers-eventid": eventID}
+	return pr
+}
+
+func TestListPipelineRunsInNamespaces(t *testing.T) {
+	f := &fakeNamespaces{
+		runs: map[string][]TektonPipelineRun{
+			"sector-main":   {sectorRun("sector-main", "gcp-region-provision-aaaaa", "2025-10-15T18:00:00Z", "main", "e1")},
+			"sector-canary": {sectorRun("sector-canary", "gcp-region-provision-bbbbb", "2025-10-15T18:05:00Z", "canary", "e2")},
+		},
+		errs:  map[string]error{"sector-broken": errors.New("forbidden")},
+		delay: 20 * time.Millisecond,
+	}
+
+	runs, nsErrs, err := ListPipelineRunsInNamespaces(context.Background(), f,
+		[]string{"sector-main", "sector-canary", "sector-broken", "sector-empty"}, RegionPipelineSelector, 2)
+	if err != nil {
+		t.Fatalf("ListPipelineRunsInNamespaces() error = %v", err)
+	}
+	if len(runs) != 2 || runs[0].Metadata.Name != "gcp-region-provision-bbbbb" || runs[1].Metadata.Name != "gcp-region-provision-aaaaa" {
+		t.Errorf("runs = %+v, want the runs of both sectors, newest first", runs)
+	}
+	if len(nsErrs) != 1 || nsErrs["sector-broken"] == nil {
+		t.Errorf("namespace errors = %v, want sector-broken only", nsErrs)
+	}
+	if f.maxInFlight != 2 {
+		t.Errorf("%d namespaces queried at once, want the concurrency of 2", f.maxInFlight)
+	}
+
+	regions := SummarizeRegions(runs, api.RegionListOptions{})
+	if len(regions) != 2 || regions[0].Namespace != "sector-canary" || regions[1].Namespace != "sector-main" {
+		t.Errorf("SummarizeRegions() = %+v, want one region per sector with its namespace", regions)
+	}
+
+	_, _, err = ListPipelineRunsInNamespaces(context.Background(), f, []string{"sector-broken"}, RegionPipelineSelector, 0)
+	if err == nil || !strings.Contains(err.Error(), "namespace sector-broken: forbidden") {
+		t.Errorf("ListPipelineRunsInNamespaces() with every namespace failing error = %v", err)
+	}
+}
+
+func TestGetPipelineRunsByEventIDInNamespaces(t *testing.T) {
+	f := &fakeNamespaces{
+		runs: map[string][]TektonPipelineRun{
+			"sector-main":   {sectorRun("sector-main", "gcp-region-provision-aaaaa", "2025-10-15T18:00:00Z", "main", "e1")},
+			"sector-canary": {sectorRun("sector-canary", "gcp-region-provision-bbbbb", "2025-10-15T18:05:00Z", "canary", "e2")},
+		},
+		errs: map[string]error{"sector-broken": errors.New("forbidden")},
+	}
+	ctx := context.Background()
+
+	status, err := GetPipelineRunsByEventIDInNamespaces(ctx, f, []string{"sector-main", "sector-canary", "sector-broken"}, "e2", 0)
+	if err != nil {
+		t.Fatalf("GetPipelineRunsByEventIDInNamespaces() error = %v", err)
+	}
+	if status.Name != "gcp-region-provision-bbbbb" || status.Namespace != "sector-canary" {
+		t.Errorf("status = %s in %s, want gcp-region-provision-bbbbb in sector-canary", status.Name, status.Namespace)
+	}
+
+	_, err = GetPipelineRunsByEventIDInNamespaces(ctx, f, []string{"sector-main", "sector-canary"}, "e3", 0)
+	if !errors.Is(err, ErrPipelineRunNotFound) {
+		t.Errorf("unknown event error = %v, want ErrPipelineRunNotFound", err)
+	}
+
+	// A namespace that could not be read may hold the run
+	_, err = GetPipelineRunsByEventIDInNamespaces(ctx, f, []string{"sector-main", "sector-broken"}, "e3", 0)
+	if err == nil || errors.Is(err, ErrPipelineRunNotFound) || !strings.Contains(err.Error(), "sector-broken") {
+		t.Errorf("unknown event with a failing namespace error = %v, want the namespace error", err)
+	}
+
+	getter := &FanOutGetter{Getter: f, Namespaces: []string{"sector-main", "sector-canary"}}
+	if status, err := getter.GetPipelineRunsByEventID(ctx, "ignored", "e1"); err != nil || status.Namespace != "sector-main" {
+		t.Errorf("FanOutGetter = %+v, %v, want the run of sector-main", status, err)
+	}
+}
+
+func TestResolveNamespaces(t *testing.T) {
+	core := kubefake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sector-canary", Labels: map[string]string{"gcp-hcp/pipelines": "region"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sector-main", Labels: map[string]string{"gcp-hcp/pipelines": "region"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+	)
+	c := newKubeconfigClient(nil, core)
+
+	got, err := ResolveNamespaces(context.Background(), c, []string{"default", "sector-main"}, "gcp-hcp/pipelines=region")
+	if err != nil {
+		t.Fatalf("ResolveNamespaces() error = %v", err)
+	}
+	if want := "default,sector-canary,sector-main"; strings.Join(got, ",") != want {
+		t.Errorf("ResolveNamespaces() = %v, want %s", got, want)
+	}
+
+	if _, err := ResolveNamespaces(context.Background(), c, nil, "gcp-hcp/pipelines=none"); err == nil {
+		t.Error("ResolveNamespaces() should return error when no namespace matches")
+	}
+}
+
+func TestParseNamespaceList(t *testing.T) {
+	names, err := parseNamespaceList([]byte(`{"kind":"NamespaceList","items":[{"metadata":{"name":"sector-main"}},{"metadata":{"name":"sector-canary"}}]}`))
+	if err != nil || strings.Join(names, ",") != "sector-main,sector-canary" {
+		t.Errorf("parseNamespaceList() = %v, %v", names, err)
+	}
+}
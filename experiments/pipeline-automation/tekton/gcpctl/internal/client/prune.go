@@ -0,0 +1,245 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// prunableStatuses are the statuses of completed runs, the only ones pruned
+var prunableStatuses = []string{"Succeeded", "Failed", "Cancelled"}
+
+// PipelineRunPruner reads pipeline runs as raw objects, to archive them, and
+// deletes them, for pruning pipeline runs
+type PipelineRunPruner interface {
+	GetPipelineRunObject(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error)
+	DeletePipelineRun(ctx context.Context, namespace, name string) error
+}
+
+// Archiver stores a pipeline run, as JSON, before it is pruned
+type Archiver interface {
+	// Archive stores the run and returns where
+	Archive(ctx context.Context, namespace, name string, data []byte) (string, error)
+}
+
+// ParseAge parses the age of 'runs prune --older-than': a number of days
+// such as 30d, or a Go duration such as 12h
+func ParseAge(s string) (time.Duration, error) {
+	var age time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: want a number of days, e.g. 30d, or a duration, e.g. 12h", s)
+		}
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: want a number of days, e.g. 30d, or a duration, e.g. 12h", s)
+		}
+		age = d
+	}
+	if age <= 0 {
+		return 0, fmt.Errorf("age %q must be positive", s)
+	}
+	return age, nil
+}
+
+// SelectPrunable returns the completed pipeline runs matching opts that
+// completed before now minus opts.OlderThan, oldest first and at most
+// opts.Limit, and the number of runs matching without the limit. Pending
+// and running runs are never selected.
+func SelectPrunable(runs []TektonPipelineRun, opts api.PruneOptions, now time.Time) ([]api.PipelineRunSummary, int, error) {
+	if opts.OlderThan <= 0 {
+		return nil, 0, fmt.Errorf("the age of the runs to prune must be positive")
+	}
+	if opts.Limit < 0 {
+		return nil, 0, fmt.Errorf("limit must not be negative")
+	}
+	if opts.Status != "" && !containsFold(prunableStatuses, opts.Status) {
+		return nil, 0, fmt.Errorf("only completed runs can be pruned, status must be one of %s",
+			strings.Join(prunableStatuses, ", "))
+	}
+
+	var items []api.PipelineRunSummary
+	completed := map[string]time.Time{}
+	for i := range runs {
+		pr := &runs[i]
+		if opts.Pipeline != "" && pr.Pipeline() != opts.Pipeline {
+			continue
+		}
+		if opts.Environment != "" && pr.Param("environment") != opts.Environment {
+			continue
+		}
+		if opts.Sector != "" && pr.Param("sector") != opts.Sector {
+			continue
+		}
+		if opts.Region != "" && pr.Param("region") != opts.Region {
+			continue
+		}
+
+		status := convertPipelineRunToStatus(pr)
+		if !containsFold(prunableStatuses, status.Status) {
+			continue
+		}
+		if opts.Status != "" && !strings.EqualFold(status.Status, opts.Status) {
+			continue
+		}
+
+		completion, err := time.Parse(time.RFC3339, status.CompletionTime)
+		if err != nil || now.Sub(completion) < opts.OlderThan {
+			continue
+		}
+
+		items = append(items, runSummary(pr, status, now))
+		completed[status.Name] = completion
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := completed[items[i].Name], completed[items[j].Name]
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return items[i].Name < items[j].Name
+	})
+
+	matched := len(items)
+	if opts.Limit > 0 && len(items) > opts.Limit {
+		items = items[:opts.Limit]
+	}
+	return items, matched, nil
+}
+
+// PruneRuns deletes pipeline runs one at a time, archiving each first when
+// archiver is not nil. A run that could not be archived is not deleted.
+// Failures are recorded on the run and do not stop the others.
+func PruneRuns(ctx context.Context, c PipelineRunPruner, namespace string, runs []api.PipelineRunSummary, archiver Archiver) *api.PruneResult {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	result := &api.PruneResult{Namespace: namespace, Matched: len(runs), Items: make([]api.PrunedRun, 0, len(runs))}
+	for _, run := range runs {
+		pruned := api.PrunedRun{PipelineRunSummary: run}
+		if err := pruneRun(ctx, c, namespace, &pruned, archiver); err != nil {
+			pruned.Error = err.Error()
+			result.Failed++
+		} else {
+			result.Deleted++
+		}
+		result.Items = append(result.Items, pruned)
+	}
+	return result
+}
+
+// pruneRun archives and deletes a single run
+func pruneRun(ctx context.Context, c PipelineRunPruner, namespace string, run *api.PrunedRun, archiver Archiver) error {
+	if archiver != nil {
+		obj, err := c.GetPipelineRunObject(ctx, namespace, run.Name)
+		if err != nil {
+			return err
+		}
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("failed to encode pipeline run: %w", err)
+		}
+		if run.Archive, err = archiver.Archive(ctx, namespace, run.Name, data); err != nil {
+			return err
+		}
+	}
+
+	if err := c.DeletePipelineRun(ctx, namespace, run.Name); err != nil {
+		return err
+	}
+	run.Deleted = true
+	return nil
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// GCSArchiver archives pipeline runs to a Cloud Storage bucket with
+// gcloud storage, as <URL>/<namespace>/<name>.json
+type GCSArchiver struct {
+	// URL is the bucket and optional prefix, e.g. gs://bucket/pipelineruns
+	URL string
+
+	// upload writes data to a gs:// object. Tests replace it.
+	upload func(ctx context.Context, object string, data []byte) error
+}
+
+// NewGCSArchiver returns an archiver to a gs:// bucket URL
+func NewGCSArchiver(url string) (*GCSArchiver, error) {
+	bucket, ok := strings.CutPrefix(url, "gs://")
+	if !ok || strings.Trim(bucket, "/") == "" {
+		return nil, fmt.Errorf("invalid archive %q: want a Cloud Storage URL, e.g. gs://bucket/pipelineruns", url)
+	}
+	return &GCSArchiver{URL: strings.TrimRight(url, "/"), upload: gcloudUpload}, nil
+}
+
+// Object returns the object a pipeline run is archived to
+func (a *GCSArchiver) Object(namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s.json", a.URL, namespace, name)
+}
+
+// Archive uploads a pipeline run to its object
+func (a *GCSArchiver) Archive(ctx context.Context, namespace, name string, data []byte) (string, error) {
+	object := a.Object(namespace, name)
+	if err := a.upload(ctx, object, data); err != nil {
+		return "", fmt.Errorf("failed to archive pipeline run to %s: %w", object, err)
+	}
+	return object, nil
+}
+
+// gcloudUpload writes data to a gs:// object with gcloud storage cp
+func gcloudUpload(ctx context.Context, object string, data []byte) error {
+	cmd := exec.CommandContext(ctx, "gcloud", "storage", "cp", "-", object)
+	cmd.Stdin = bytes.NewReader(data)
+	if _, err := cmd.Output(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("gcloud command failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return fmt.Errorf("failed to execute gcloud: %w", err)
+	}
+	return nil
+}
+
+// DeletePipelineRun deletes a pipeline run; Tekton deletes its TaskRuns and
+// pods with it
+func (c *TektonAPIClient) DeletePipelineRun(ctx context.Context, namespace, name string) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	if err := c.doTekton(ctx, http.MethodDelete, namespace, "pipelineruns", name, "", "", nil, nil); err != nil {
+		return fmt.Errorf("failed to delete pipeline run: %w", err)
+	}
+	return nil
+}
+
+// DeletePipelineRun deletes a pipeline run using kubectl, without waiting
+// for its TaskRuns and pods to be deleted
+func (c *KubectlClient) DeletePipelineRun(ctx context.Context, namespace, name string) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err := runKubectl(ctx, nil, "delete", "pipelinerun", name, "-n", namespace, "--wait=false")
+	return err
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"30d", 30 * 24 * time.Hour},
+		{"1d", 24 * time.Hour},
+		{"12h", 12 * time.Hour},
+		{"90m", 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := ParseAge(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseAge(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "d", "thirty days", "0d", "-1h", "1.5d"} {
+		if _, err := ParseAge(in); err == nil {
+			t.Errorf("ParseAge(%q) should return error", in)
+		}
+	}
+}
+
+func pruneNames(runs []api.PipelineRunSummary) string {
+	var names []string
+	for _, r := range runs {
+		names = append(names, strings.TrimPrefix(strings.TrimPrefix(r.Name, "gcp-region-provision-"), "gcp-region-e2e-"))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestSelectPrunable(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        api.PruneOptions
+		want        string
+		wantMatched int
+	}{
+		{"oldest first", api.PruneOptions{OlderThan: time.Hour}, "aaaaa,bbbbb,ccccc", 3},
+		{"completed runs only", api.PruneOptions{OlderThan: time.Second}, "aaaaa,bbbbb,ccccc,zzzzz,ddddd", 5},
+		{"status", api.PruneOptions{OlderThan: time.Hour, Status: "succeeded"}, "aaaaa,bbbbb", 2},
+		{"pipeline", api.PruneOptions{OlderThan: time.Minute, Pipeline: "gcp-region-e2e"}, "zzzzz", 1},
+		{"params", api.PruneOptions{OlderThan: time.Second, Environment: "production", Region: "us-central1"}, "ccccc,ddddd", 2},
+		{"limit", api.PruneOptions{OlderThan: time.Hour, Limit: 2}, "aaaaa,bbbbb", 3},
+		{"too recent", api.PruneOptions{OlderThan: 48 * time.Hour}, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs, matched, err := SelectPrunable(testRuns(), tt.opts, runsNow)
+			if err != nil {
+				t.Fatalf("SelectPrunable() error = %v", err)
+			}
+			if got := pruneNames(runs); got != tt.want || matched != tt.wantMatched {
+				t.Errorf("SelectPrunable() = %v, %d matched, want %v, %d matched", got, matched, tt.want, tt.wantMatched)
+			}
+		})
+	}
+}
+
+func TestSelectPrunable_InvalidOptions(t *testing.T) {
+	for _, opts := range []api.PruneOptions{
+		{},
+		{OlderThan: time.Hour, Limit: -1},
+		{OlderThan: time.Hour, Status: "running"},
+	} {
+		if _, _, err := SelectPrunable(testRuns(), opts, runsNow); err == nil {
+			t.Errorf("SelectPrunable(%+v) should return error", opts)
+		}
+	}
+}
+
+// fakeArchiver records archived runs and fails for the names in fail
+type fakeArchiver struct {
+	archived map[string][]byte
+	fail     map[string]bool
+}
+
+func (a *fakeArchiver) Archive(ctx context.Context, namespace, name string, data []byte) (string, error) {
+	if a.fail[name] {
+		return "", errors.New("bucket not found")
+	}
+	a.archived[name] = data
+	return "gs://archive/" + namespace + "/" + name + ".json", nil
+}
+
+func TestPruneRuns(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const runs = "/apis/tekton.dev/v1/namespaces/default/pipelineruns/"
+		name := strings.TrimPrefix(r.URL.Path, runs)
+		switch {
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"apiVersion":"tekton.dev/v1","kind":"PipelineRun","metadata":{"name":"` + name + `"}}`))
+		case r.Method == http.MethodDelete && name == "gcp-region-provision-ccccc":
+			http.Error(w, "forbidden", http.StatusForbidden)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, name)
+			w.Write([]byte(`{}`))
+		default:
+			http.Error(w, r.Method+" "+r.URL.Path, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	selected, _, err := SelectPrunable(testRuns(), api.PruneOptions{OlderThan: time.Hour}, runsNow)
+	if err != nil {
+		t.Fatalf("SelectPrunable() error = %v", err)
+	}
+	archiver := &fakeArchiver{
+		archived: map[string][]byte{},
+		fail:     map[string]bool{"gcp-region-provision-bbbbb": true},
+	}
+
+	result := PruneRuns(context.Background(), NewTektonAPIClient(server.URL), "", selected, archiver)
+
+	if result.Namespace != "default" || result.Deleted != 1 || result.Failed != 2 || len(result.Items) != 3 {
+		t.Errorf("result = %+v, want 1 deleted and 2 failed in default", result)
+	}
+	if strings.Join(deleted, ",") != "gcp-region-provision-aaaaa" {
+		t.Errorf("deleted = %v, want only aaaaa: bbbbb was not archived", deleted)
+	}
+	if a := result.Items[0]; !a.Deleted || a.Archive != "gs://archive/default/gcp-region-provision-aaaaa.json" {
+		t.Errorf("Items[0] = %+v, want archived and deleted", a)
+	}
+	if !strings.Contains(string(archiver.archived["gcp-region-provision-aaaaa"]), `"kind":"PipelineRun"`) {
+		t.Errorf("archived %s, want the pipeline run", archiver.archived["gcp-region-provision-aaaaa"])
+	}
+	if b := result.Items[1]; b.Deleted || !strings.Contains(b.Error, "bucket not found") {
+		t.Errorf("Items[1] = %+v, want an archive error", b)
+	}
+	if c := result.Items[2]; c.Deleted || c.Archive == "" || !strings.Contains(c.Error, "failed to delete") {
+		t.Errorf("Items[2] = %+v, want archived and a delete error", c)
+	}
+}
+
+func TestGCSArchiver(t *testing.T) {
+	a, err := NewGCSArchiver("gs://bucket/pipelineruns/")
+	if err != nil {
+		t.Fatalf("NewGCSArchiver() error = %v", err)
+	}
+	var uploaded string
+	a.upload = func(ctx context.Context, object string, data []byte) error {
+		uploaded = object
+		return nil
+	}
+
+	object, err := a.Archive(context.Background(), "default", "gcp-region-provision-jf8v5", []byte(`{}`))
+	if err != nil {
+		t.Fatalf("Archive() error = %v", err)
+	}
+	want := "gs://bucket/pipelineruns/default/gcp-region-provision-jf8v5.json"
+	if object != want || uploaded != want {
+		t.Errorf("Archive() = %v, uploaded %v, want %v", object, uploaded, want)
+	}
+
+	for _, url := range []string{"bucket", "s3://bucket", "gs://", "gs:///"} {
+		if _, err := NewGCSArchiver(url); err == nil {
+			t.Errorf("NewGCSArchiver(%q) should return error", url)
+		}
+	}
+}
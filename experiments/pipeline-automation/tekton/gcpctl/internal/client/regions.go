@@ -0,0 +1,76 @@
+package client
+
+import (
+	"sort"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// RegionPipelineName is the pipeline started by the region provisioning listener
+const RegionPipelineName = "gcp-region-provisioning-pipeline"
+
+// RegionPipelineSelector selects the runs of RegionPipelineName; Tekton labels
+// every pipeline run with the name of its pipeline
+const RegionPipelineSelector = "tekton.dev/pipeline=" + RegionPipelineName
+
+// SummarizeRegions reduces pipeline runs of the region pipeline to the latest
+// run of every environment, sector and region, sorted by those keys. Runs
+// without the region parameters are ignored.
+func SummarizeRegions(runs []TektonPipelineRun, opts api.RegionListOptions) []api.RegionStatus {
+	type regionKey struct{ environment, sector, region string }
+
+	latest := make(map[regionKey]*TektonPipelineRun)
+	for i := range runs {
+		pr := &runs[i]
+		key := regionKey{pr.Param("environment"), pr.Param("sector"), pr.Param("region")}
+		if key.environment == "" || key.sector == "" || key.region == "" {
+			continue
+		}
+		if opts.Environment != "" && key.environment != opts.Environment {
+			continue
+		}
+		if opts.Sector != "" && key.sector != opts.Sector {
+			continue
+		}
+		// Creation timestamps are RFC 3339 in UTC, so they sort as strings
+		if prev, ok := latest[key]; !ok || pr.Metadata.CreationTimestamp > prev.Metadata.CreationTimestamp {
+			latest[key] = pr
+		}
+	}
+
+	regions := make([]api.RegionStatus, 0, len(latest))
+	for key, pr := range latest {
+		status := convertPipelineRunToStatus(pr)
+		region := api.RegionStatus{
+			Environment:    key.environment,
+			Sector:         key.sector,
+			Region:         key.region,
+			Action:         api.RegionActionAdd,
+			PipelineRun:    status.Name,
+			Namespace:      status.Namespace,
+			Status:         status.Status,
+			StartTime:      status.StartTime,
+			CompletionTime: status.CompletionTime,
+		}
+		if status.Action != "" {
+			region.Action = status.Action
+		}
+		if region.State() == "Deleted" && !opts.IncludeDeleted {
+			continue
+		}
+		regions = append(regions, region)
+	}
+
+	sort.Slice(regions, func(i, j int) bool {
+		a, b := regions[i], regions[j]
+		if a.Environment != b.Environment {
+			return a.Environment < b.Environment
+		}
+		if a.Sector != b.Sector {
+			return a.Sector < b.Sector
+		}
+		return a.Region < b.Region
+	})
+
+	return regions
+}
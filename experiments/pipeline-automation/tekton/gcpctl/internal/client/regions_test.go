@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// regionRun builds a pipeline run of the region pipeline
+func regionRun(name, created, environment, sector, region, action, reason string) TektonPipelineRun {
+	var pr TektonPipelineRun
+	pr.Metadata.Name = name
+	pr.Metadata.Namespace = "default"
+	pr.Metadata.CreationTimestamp = created
+	pr.Status.StartTime = created
+
+	params := map[string]string{"environment": environment, "sector": sector, "region": region}
+	if action != "" {
+		params["action"] = action
+	}
+	for _, n := range []string{"environment", "sector", "region", "action"} {
+		if v, ok := params[n]; ok {
+			pr.Spec.Params = append(pr.Spec.Params, struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			}{n, v})
+		}
+	}
+
+	status := "Unknown"
+	switch reason {
+	case "Succeeded":
+		status = "True"
+	case "Failed":
+		status = "False"
+	}
+	pr.Status.Conditions = append(pr.Status.Conditions, struct {
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}{Type: "Succeeded", Status: status, Reason: reason})
+	return pr
+}
+
+func testRegionRuns() []TektonPipelineRun {
+	return []TektonPipelineRun{
+		// Provisioned, then deleted
+		regionRun("gcp-region-provision-aaaaa", "2025-10-14T09:00:00Z", "integration", "test", "asia-east1", "", "Succeeded"),
+		regionRun("gcp-region-provision-bbbbb", "2025-10-15T09:00:00Z", "integration", "test", "asia-east1", "delete", "Succeeded"),
+		// Failed, then provisioned on retry; runs are not ordered by creation
+		regionRun("gcp-region-provision-ddddd", "2025-10-15T18:03:44Z", "production", "main", "us-central1", "add", "Succeeded"),
+		regionRun("gcp-region-provision-ccccc", "2025-10-15T17:00:00Z", "production", "main", "us-central1", "add", "Failed"),
+		// Deletion in progress
+		regionRun("gcp-region-provision-eeeee", "2025-10-15T18:08:31Z", "production", "canary", "europe-west1", "delete", "Running"),
+		// Not a region run
+		regionRun("gcp-region-provision-fffff", "2025-10-15T18:10:00Z", "", "", "", "", "Running"),
+	}
+}
+
+func TestSummarizeRegions(t *testing.T) {
+	regions := SummarizeRegions(testRegionRuns(), api.RegionListOptions{})
+
+	want := []api.RegionStatus{
+		{Environment: "production", Sector: "canary", Region: "europe-west1", Action: "delete", PipelineRun: "gcp-region-provision-eeeee", Status: "Running"},
+		{Environment: "production", Sector: "main", Region: "us-central1", Action: "add", PipelineRun: "gcp-region-provision-ddddd", Status: "Succeeded"},
+	}
+	if len(regions) != len(want) {
+		t.Fatalf("SummarizeRegions() = %+v, want %d regions", regions, len(want))
+	}
+	for i, w := range want {
+		got := regions[i]
+		if got.Environment != w.Environment || got.Sector != w.Sector || got.Region != w.Region ||
+			got.Action != w.Action || got.PipelineRun != w.PipelineRun || got.Status != w.Status {
+			t.Errorf("regions[%d] = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestSummarizeRegions_Options(t *testing.T) {
+	all := SummarizeRegions(testRegionRuns(), api.RegionListOptions{IncludeDeleted: true})
+	if len(all) != 3 {
+		t.Fatalf("SummarizeRegions(IncludeDeleted) = %+v, want 3 regions", all)
+	}
+	if all[0].Region != "asia-east1" || all[0].State() != "Deleted" {
+		t.Errorf("regions[0] = %+v, want deleted asia-east1", all[0])
+	}
+	// Runs from before the action parameter provision the region
+	if all[0].PipelineRun != "gcp-region-provision-bbbbb" {
+		t.Errorf("regions[0].PipelineRun = %v, want the latest run", all[0].PipelineRun)
+	}
+
+	main := SummarizeRegions(testRegionRuns(), api.RegionListOptions{Environment: "production", Sector: "main"})
+	if len(main) != 1 || main[0].Region != "us-central1" {
+		t.Errorf("SummarizeRegions(production/main) = %+v, want us-central1", main)
+	}
+}
+
+func TestTektonAPIClient_ListPipelineRuns(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/tekton.dev/v1/namespaces/default/pipelineruns" {
+			t.Errorf("Path = %v", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("labelSelector"); got != RegionPipelineSelector {
+			t.Errorf("labelSelector = %v, want %v", got, RegionPipelineSelector)
+		}
+		json.NewEncoder(w).Encode(TektonPipelineRunList{Items: testRegionRuns()})
+	}))
+	defer server.Close()
+
+	client := NewTektonAPIClient(server.URL)
+	runs, err := client.ListPipelineRuns(context.Background(), "", RegionPipelineSelector)
+	if err != nil {
+		t.Fatalf("ListPipelineRuns() error = %v", err)
+	}
+	if len(runs) != len(testRegionRuns()) {
+		t.Errorf("ListPipelineRuns() returned %d runs, want %d", len(runs), len(testRegionRuns()))
+	}
+	if runs[1].Param("action") != "delete" {
+		t.Errorf("Param(action) = %v, want delete", runs[1].Param("action"))
+	}
+}
+
+func TestTektonAPIClient_ListPipelineRunsSelectorEscaped(t *testing.T) {
+	for _, selector := range []string{
+		"tekton.dev/pipeline=gcp-region-provision,environment in (production, staging),!gcpctl.io/hidden",
+		"region=us-central1&limit=1",
+		"",
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if got := query.Get("labelSelector"); got != selector || len(query) > 1 || (selector == "") != !query.Has("labelSelector") {
+				t.Errorf("query = %v, want only labelSelector %q", query, selector)
+			}
+			json.NewEncoder(w).Encode(TektonPipelineRunList{})
+		}))
+		if _, err := NewTektonAPIClient(server.URL).ListPipelineRuns(context.Background(), "", selector); err != nil {
+			t.Errorf("ListPipelineRuns(%q) error = %v", selector, err)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,298 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Labels linking a retried pipeline run and its retry
+const (
+	// LabelRetryOf is set on a retry to the name of the run it retries
+	LabelRetryOf = "gcpctl.openshift.io/retry-of"
+	// LabelRetriedBy is set on a retried run to the name of its latest retry
+	LabelRetriedBy = "gcpctl.openshift.io/retried-by"
+)
+
+// StartFromTaskParam is the pipeline parameter naming the first task to run.
+// Pipelines that support starting from a task declare it and skip the tasks
+// before it, e.g. with when expressions.
+const StartFromTaskParam = "start-from-task"
+
+// PipelineRunWriter reads pipeline runs as raw objects, creates them and
+// labels them, for retrying pipeline runs
+type PipelineRunWriter interface {
+	GetPipelineRunObject(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error)
+	CreatePipelineRun(ctx context.Context, namespace string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error)
+	LabelPipelineRun(ctx context.Context, namespace, name string, labels map[string]string) error
+}
+
+// RetryOptions configure RetryPipelineRun
+type RetryOptions struct {
+	// FromTask starts the retry at this pipeline task, for pipelines that
+	// declare StartFromTaskParam
+	FromTask string
+}
+
+// RetryPipelineRun re-submits a failed or cancelled pipeline run with its
+// original spec and parameters as a new run, and links both runs with
+// LabelRetryOf and LabelRetriedBy. If only labelling the original run fails,
+// the result is returned together with the error.
+func RetryPipelineRun(ctx context.Context, c PipelineRunWriter, namespace, name string, opts RetryOptions) (*api.RetryResult, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	orig, err := c.GetPipelineRunObject(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	retry, err := NewRetryRun(orig, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := c.CreatePipelineRun(ctx, namespace, retry)
+	if err != nil {
+		return nil, err
+	}
+
+	var pr TektonPipelineRun
+	if err := decodeUnstructured(created, &pr); err != nil {
+		return nil, err
+	}
+	result := &api.RetryResult{
+		Original:    name,
+		PipelineRun: created.GetName(),
+		Namespace:   namespace,
+		FromTask:    opts.FromTask,
+		Params:      make(map[string]string, len(pr.Spec.Params)),
+	}
+	for _, p := range pr.Spec.Params {
+		result.Params[p.Name] = p.Value
+	}
+
+	if err := c.LabelPipelineRun(ctx, namespace, name, map[string]string{LabelRetriedBy: created.GetName()}); err != nil {
+		return result, fmt.Errorf("created %s but failed to label %s: %w", created.GetName(), name, err)
+	}
+	return result, nil
+}
+
+// NewRetryRun builds a new pipeline run with the spec of a failed or
+// cancelled one. Labels set by Tekton and Tekton Triggers are dropped, so the
+// retry is not found by the event ID of the original run.
+func NewRetryRun(orig *unstructured.Unstructured, opts RetryOptions) (*unstructured.Unstructured, error) {
+	var pr TektonPipelineRun
+	if err := decodeUnstructured(orig, &pr); err != nil {
+		return nil, err
+	}
+	status := convertPipelineRunToStatus(&pr)
+	if status.Status != "Failed" && status.Status != "Cancelled" {
+		return nil, fmt.Errorf("pipeline run %s is %s, only failed or cancelled runs can be retried", orig.GetName(), status.Status)
+	}
+
+	spec, ok, err := unstructured.NestedMap(orig.Object, "spec")
+	if err != nil || !ok {
+		return nil, fmt.Errorf("pipeline run %s has no spec", orig.GetName())
+	}
+	// spec.status cancels or pends a run
+	delete(spec, "status")
+
+	if opts.FromTask != "" {
+		if err := checkStartFromTask(orig, opts.FromTask); err != nil {
+			return nil, err
+		}
+		spec["params"] = setParam(spec["params"], StartFromTaskParam, opts.FromTask)
+	}
+
+	labels := map[string]string{}
+	for k, v := range orig.GetLabels() {
+		if strings.HasPrefix(k, "tekton.dev/") || strings.HasPrefix(k, "triggers.tekton.dev/") ||
+			k == LabelRetryOf || k == LabelRetriedBy {
+			continue
+		}
+		labels[k] = v
+	}
+	labels[LabelRetryOf] = orig.GetName()
+
+	generateName := orig.GetGenerateName()
+	if generateName == "" {
+		generateName = orig.GetName() + "-retry-"
+	}
+
+	retry := &unstructured.Unstructured{Object: map[string]any{"spec": spec}}
+	retry.SetAPIVersion(orig.GetAPIVersion())
+	retry.SetKind(orig.GetKind())
+	retry.SetGenerateName(generateName)
+	retry.SetNamespace(orig.GetNamespace())
+	retry.SetLabels(labels)
+	return retry, nil
+}
+
+// checkStartFromTask checks that the pipeline of a run, as resolved by Tekton
+// in status.pipelineSpec, supports starting from task
+func checkStartFromTask(orig *unstructured.Unstructured, task string) error {
+	pipelineSpec, _, _ := unstructured.NestedMap(orig.Object, "status", "pipelineSpec")
+	if !slices.Contains(specNames(pipelineSpec["params"]), StartFromTaskParam) {
+		return fmt.Errorf("the pipeline of %s does not support starting from a task: it has no %s parameter",
+			orig.GetName(), StartFromTaskParam)
+	}
+	if tasks := specNames(pipelineSpec["tasks"]); !slices.Contains(tasks, task) {
+		return fmt.Errorf("the pipeline of %s has no task %q, tasks: %s", orig.GetName(), task, strings.Join(tasks, ", "))
+	}
+	return nil
+}
+
+// specNames returns the names of a list of params or tasks
+func specNames(list any) []string {
+	items, _ := list.([]any)
+	var names []string
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			if name, ok := m["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// setParam sets a string parameter in a list of params, adding it if needed
+func setParam(params any, name, value string) []any {
+	items, _ := params.([]any)
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok && m["name"] == name {
+			m["value"] = value
+			return items
+		}
+	}
+	return append(items, map[string]any{"name": name, "value": value})
+}
+
+// GetPipelineRunObject queries for a pipeline run as a raw object
+func (c *TektonAPIClient) GetPipelineRunObject(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := c.doTekton(ctx, http.MethodGet, namespace, "pipelineruns", name, "", "", nil, &obj.Object); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// CreatePipelineRun creates a pipeline run and returns it as created
+func (c *TektonAPIClient) CreatePipelineRun(ctx context.Context, namespace string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	created := &unstructured.Unstructured{}
+	if err := c.doTekton(ctx, http.MethodPost, namespace, "pipelineruns", "", "", "application/json", obj.Object, &created.Object); err != nil {
+		return nil, fmt.Errorf("failed to create pipeline run: %w", err)
+	}
+	return created, nil
+}
+
+// LabelPipelineRun adds labels to a pipeline run
+func (c *TektonAPIClient) LabelPipelineRun(ctx context.Context, namespace, name string, labels map[string]string) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	patch := map[string]any{"metadata": map[string]any{"labels": labels}}
+	if err := c.doTekton(ctx, http.MethodPatch, namespace, "pipelineruns", name, "", "application/merge-patch+json", patch, nil); err != nil {
+		return fmt.Errorf("failed to label pipeline run: %w", err)
+	}
+	return nil
+}
+
+// labelPatch is a JSON merge patch adding labels
+func labelPatch(labels map[string]string) ([]byte, error) {
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"labels": labels}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode labels: %w", err)
+	}
+	return patch, nil
+}
+
+// GetPipelineRunObject queries for a pipeline run as a raw object using kubectl
+func (c *KubectlClient) GetPipelineRunObject(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	output, err := runKubectl(ctx, nil, "get", "pipelinerun", name, "-n", namespace, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(output); err != nil {
+		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
+	}
+	return obj, nil
+}
+
+// CreatePipelineRun creates a pipeline run using kubectl and returns it as created
+func (c *KubectlClient) CreatePipelineRun(ctx context.Context, namespace string, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	body, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode pipeline run: %w", err)
+	}
+
+	output, err := runKubectl(ctx, body, "create", "-n", namespace, "-f", "-", "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+
+	created := &unstructured.Unstructured{}
+	if err := created.UnmarshalJSON(output); err != nil {
+		return nil, fmt.Errorf("failed to parse kubectl output: %w", err)
+	}
+	return created, nil
+}
+
+// LabelPipelineRun adds labels to a pipeline run using kubectl
+func (c *KubectlClient) LabelPipelineRun(ctx context.Context, namespace, name string, labels map[string]string) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	args := []string{"label", "pipelinerun", name, "-n", namespace, "--overwrite"}
+	for k, v := range labels {
+		args = append(args, k+"="+v)
+	}
+	_, err := runKubectl(ctx, nil, args...)
+	return err
+}
+
+// runKubectl runs kubectl with stdin and returns its output
+func runKubectl(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("kubectl command failed: %s", string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("failed to execute kubectl: %w", err)
+	}
+	return output, nil
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// failedRun is a failed region pipeline run as returned by the Tekton API,
+// with the pipeline spec Tekton resolved into its status
+func failedRun(reason string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "tekton.dev/v1",
+		"kind":       "PipelineRun",
+		"metadata": map[string]any{
+			"name":         "gcp-region-provision-jf8v5",
+			"generateName": "gcp-region-provision-",
+			"namespace":    "default",
+			"labels": map[string]any{
+				"tekton.dev/pipeline":                  RegionPipelineName,
+				"triggers.tekton.dev/triggers-eventid": "63950e1f-7ffe-4d14-bc0e-121cee88942e",
+				"team":                                 "hcp",
+			},
+		},
+		"spec": map[string]any{
+			"pipelineRef": map[string]any{"name": RegionPipelineName},
+			"params": []any{
+				map[string]any{"name": "environment", "value": "production"},
+				map[string]any{"name": "region", "value": "us-central1"},
+				map[string]any{"name": "sector", "value": "main"},
+			},
+			"workspaces": []any{
+				map[string]any{"name": "shared-data", "persistentVolumeClaim": map[string]any{"claimName": "tekton-workspace-pvc"}},
+			},
+			"status": "Cancelled",
+		},
+		"status": map[string]any{
+			"conditions": []any{
+				map[string]any{"type": "Succeeded", "status": "False", "reason": reason},
+			},
+			"pipelineSpec": map[string]any{
+				"params": []any{
+					map[string]any{"name": "environment"},
+					map[string]any{"name": StartFromTaskParam},
+				},
+				"tasks": []any{
+					map[string]any{"name": "validate-inputs"},
+					map[string]any{"name": "terraform-init"},
+				},
+			},
+		},
+	}}
+}
+
+func TestNewRetryRun(t *testing.T) {
+	retry, err := NewRetryRun(failedRun("Failed"), RetryOptions{})
+	if err != nil {
+		t.Fatalf("NewRetryRun() error = %v", err)
+	}
+
+	if retry.GetName() != "" || retry.GetGenerateName() != "gcp-region-provision-" {
+		t.Errorf("name = %q, generateName = %q, want a generated name", retry.GetName(), retry.GetGenerateName())
+	}
+	wantLabels := map[string]string{"team": "hcp", LabelRetryOf: "gcp-region-provision-jf8v5"}
+	if got := retry.GetLabels(); len(got) != len(wantLabels) || got["team"] != "hcp" || got[LabelRetryOf] != wantLabels[LabelRetryOf] {
+		t.Errorf("labels = %v, want %v", got, wantLabels)
+	}
+	if _, ok := retry.Object["status"]; ok {
+		t.Error("retry should not have a status")
+	}
+	if _, ok, _ := unstructured.NestedString(retry.Object, "spec", "status"); ok {
+		t.Error("retry should not be cancelled")
+	}
+	if ws, _, _ := unstructured.NestedSlice(retry.Object, "spec", "workspaces"); len(ws) != 1 {
+		t.Errorf("workspaces = %v, want the original workspace", ws)
+	}
+
+	var pr TektonPipelineRun
+	if err := decodeUnstructured(retry, &pr); err != nil {
+		t.Fatal(err)
+	}
+	if len(pr.Spec.Params) != 3 || pr.Param("region") != "us-central1" || pr.Param(StartFromTaskParam) != "" {
+		t.Errorf("params = %+v, want the original params", pr.Spec.Params)
+	}
+}
+
+func TestNewRetryRun_FromTask(t *testing.T) {
+	retry, err := NewRetryRun(failedRun("PipelineRunCancelled"), RetryOptions{FromTask: "terraform-init"})
+	if err != nil {
+		t.Fatalf("NewRetryRun() error = %v", err)
+	}
+	var pr TektonPipelineRun
+	if err := decodeUnstructured(retry, &pr); err != nil {
+		t.Fatal(err)
+	}
+	if pr.Param(StartFromTaskParam) != "terraform-init" || len(pr.Spec.Params) != 4 {
+		t.Errorf("params = %+v, want start-from-task added", pr.Spec.Params)
+	}
+
+	_, err = NewRetryRun(failedRun("Failed"), RetryOptions{FromTask: "terraform-apply"})
+	if err == nil || !strings.Contains(err.Error(), `no task "terraform-apply"`) {
+		t.Errorf("NewRetryRun() for an unknown task error = %v", err)
+	}
+
+	unsupported := failedRun("Failed")
+	unstructured.RemoveNestedField(unsupported.Object, "status", "pipelineSpec", "params")
+	_, err = NewRetryRun(unsupported, RetryOptions{FromTask: "terraform-init"})
+	if err == nil || !strings.Contains(err.Error(), "does not support starting from a task") {
+		t.Errorf("NewRetryRun() for a pipeline without %s error = %v", StartFromTaskParam, err)
+	}
+}
+
+func TestNewRetryRun_NotFailed(t *testing.T) {
+	running := failedRun("Running")
+	unstructured.SetNestedSlice(running.Object, []any{
+		map[string]any{"type": "Succeeded", "status": "Unknown", "reason": "Running"},
+	}, "status", "conditions")
+
+	_, err := NewRetryRun(running, RetryOptions{})
+	if err == nil || !strings.Contains(err.Error(), "is Running") {
+		t.Errorf("NewRetryRun() for a running run error = %v", err)
+	}
+}
+
+func TestRetryPipelineRun(t *testing.T) {
+	var created map[string]any
+	var patch map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const runs = "/apis/tekton.dev/v1/namespaces/default/pipelineruns"
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == runs+"/gcp-region-provision-jf8v5":
+			json.NewEncoder(w).Encode(failedRun("Failed").Object)
+		case r.Method == http.MethodPost && r.URL.Path == runs:
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &created)
+			unstructured.SetNestedField(created, "gcp-region-provision-k2m9x", "metadata", "name")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(created)
+		case r.Method == http.MethodPatch && r.URL.Path == runs+"/gcp-region-provision-jf8v5":
+			if ct := r.Header.Get("Content-Type"); ct != "application/merge-patch+json" {
+				t.Errorf("Content-Type = %v", ct)
+			}
+			json.NewDecoder(r.Body).Decode(&patch)
+			w.Write([]byte(`{}`))
+		default:
+			http.Error(w, r.Method+" "+r.URL.Path, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	result, err := RetryPipelineRun(context.Background(), NewTektonAPIClient(server.URL), "", "gcp-region-provision-jf8v5", RetryOptions{})
+	if err != nil {
+		t.Fatalf("RetryPipelineRun() error = %v", err)
+	}
+
+	if result.Original != "gcp-region-provision-jf8v5" || result.PipelineRun != "gcp-region-provision-k2m9x" || result.Namespace != "default" {
+		t.Errorf("result = %+v", result)
+	}
+	if result.Params["environment"] != "production" || len(result.Params) != 3 {
+		t.Errorf("Params = %v, want the original params", result.Params)
+	}
+	if created == nil {
+		t.Fatal("no pipeline run created")
+	}
+	labels, _, _ := unstructured.NestedStringMap(patch, "metadata", "labels")
+	if labels[LabelRetriedBy] != "gcp-region-provision-k2m9x" {
+		t.Errorf("patch labels = %v, want %s", labels, LabelRetriedBy)
+	}
+}
+
+func TestRetryPipelineRun_LabelFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(failedRun("Failed").Object)
+		case http.MethodPost:
+			obj := failedRun("Failed").Object
+			unstructured.SetNestedField(obj, "gcp-region-provision-k2m9x", "metadata", "name")
+			json.NewEncoder(w).Encode(obj)
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	result, err := RetryPipelineRun(context.Background(), NewTektonAPIClient(server.URL), "default", "gcp-region-provision-jf8v5", RetryOptions{})
+	if err == nil || !strings.Contains(err.Error(), "failed to label") {
+		t.Errorf("RetryPipelineRun() error = %v, want a label error", err)
+	}
+	if result == nil || result.PipelineRun != "gcp-region-provision-k2m9x" {
+		t.Errorf("result = %+v, want the created run", result)
+	}
+}
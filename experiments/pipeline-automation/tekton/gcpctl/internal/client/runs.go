@@ -0,0 +1,194 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// EventIDLabel is the label Tekton Triggers sets on the pipeline runs an event
+// listener creates to the ID of the event
+const EventIDLabel = "triggers.tekton.dev/triggers-eventid"
+
+// PipelineSelector returns the label selector of the runs of a pipeline, or
+// an empty selector matching every run if pipeline is empty
+func PipelineSelector(pipeline string) string {
+	if pipeline == "" {
+		return ""
+	}
+	return "tekton.dev/pipeline=" + pipeline
+}
+
+// Pipeline returns the name of the pipeline a run belongs to
+func (pr *TektonPipelineRun) Pipeline() string {
+	if name := pr.Metadata.Labels["tekton.dev/pipeline"]; name != "" {
+		return name
+	}
+	return pr.Spec.PipelineRef.Name
+}
+
+// PipelineRunFromObject decodes a pipeline run read as a raw object, e.g. by
+// GetPipelineRunObject
+func PipelineRunFromObject(obj *unstructured.Unstructured) (*TektonPipelineRun, error) {
+	var pr TektonPipelineRun
+	if err := decodeUnstructured(obj, &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// ListRuns filters, sorts and pages pipeline runs for a run listing. now is
+// the reference for the age filter and the duration of unfinished runs.
+func ListRuns(runs []TektonPipelineRun, opts api.RunListOptions, now time.Time) (*api.RunList, error) {
+	less, err := runLess(opts.SortBy)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limit < 0 {
+		return nil, fmt.Errorf("limit must not be negative")
+	}
+	page := opts.Page
+	if page == 0 {
+		page = 1
+	}
+	if page < 0 {
+		return nil, fmt.Errorf("page must be positive")
+	}
+
+	items := make([]api.PipelineRunSummary, 0, len(runs))
+	for i := range runs {
+		pr := &runs[i]
+		if opts.Pipeline != "" && pr.Pipeline() != opts.Pipeline {
+			continue
+		}
+		if opts.Environment != "" && pr.Param("environment") != opts.Environment {
+			continue
+		}
+		if opts.Sector != "" && pr.Param("sector") != opts.Sector {
+			continue
+		}
+		if opts.Region != "" && pr.Param("region") != opts.Region {
+			continue
+		}
+
+		status := convertPipelineRunToStatus(pr)
+		if opts.Status != "" && !strings.EqualFold(status.Status, opts.Status) {
+			continue
+		}
+
+		started := status.StartTime
+		if started == "" {
+			started = pr.Metadata.CreationTimestamp
+		}
+		if opts.Since > 0 {
+			start, err := time.Parse(time.RFC3339, started)
+			if err != nil || now.Sub(start) > opts.Since {
+				continue
+			}
+		}
+
+		items = append(items, runSummary(pr, status, now))
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if opts.Reverse {
+			return less(items[j], items[i])
+		}
+		return less(items[i], items[j])
+	})
+
+	list := &api.RunList{Total: len(items), Page: page, Limit: opts.Limit}
+	if opts.Limit == 0 {
+		list.Items = items
+		return list, nil
+	}
+	from := min((page-1)*opts.Limit, len(items))
+	to := min(from+opts.Limit, len(items))
+	list.Items = items[from:to]
+	return list, nil
+}
+
+// runSummary is the listing entry of a pipeline run of the given status
+func runSummary(pr *TektonPipelineRun, status *api.PipelineRunStatus, now time.Time) api.PipelineRunSummary {
+	return api.PipelineRunSummary{
+		Name:            status.Name,
+		Namespace:       status.Namespace,
+		Pipeline:        pr.Pipeline(),
+		Environment:     pr.Param("environment"),
+		Sector:          pr.Param("sector"),
+		Region:          pr.Param("region"),
+		Action:          status.Action,
+		Status:          status.Status,
+		StartTime:       status.StartTime,
+		CompletionTime:  status.CompletionTime,
+		DurationSeconds: int64(runDuration(status.StartTime, status.CompletionTime, now).Seconds()),
+	}
+}
+
+// DescribeRun returns the description of a pipeline run for 'runs describe'.
+// now is the reference for the duration of an unfinished run.
+func DescribeRun(pr *TektonPipelineRun, now time.Time) *api.RunDescription {
+	status := convertPipelineRunToStatus(pr)
+	desc := &api.RunDescription{
+		PipelineRunSummary: runSummary(pr, status, now),
+		EventID:            pr.Metadata.Labels[EventIDLabel],
+		BundleVersion:      pr.Metadata.Labels[BundleVersionLabel],
+		Params:             make(map[string]string, len(pr.Spec.Params)),
+	}
+	for _, p := range pr.Spec.Params {
+		desc.Params[p.Name] = p.Value
+	}
+	return desc
+}
+
+// runLess returns the order of a sort column; ties are broken by start time,
+// newest first, then by name
+func runLess(sortBy string) (func(a, b api.PipelineRunSummary) bool, error) {
+	newest := func(a, b api.PipelineRunSummary) bool {
+		// Start times are RFC 3339 in UTC, so they sort as strings
+		if a.StartTime != b.StartTime {
+			return a.StartTime > b.StartTime
+		}
+		return a.Name < b.Name
+	}
+
+	switch sortBy {
+	case "", api.RunSortStart:
+		return newest, nil
+	case api.RunSortDuration:
+		return func(a, b api.PipelineRunSummary) bool {
+			if a.DurationSeconds != b.DurationSeconds {
+				return a.DurationSeconds > b.DurationSeconds
+			}
+			return newest(a, b)
+		}, nil
+	case api.RunSortStatus:
+		return func(a, b api.PipelineRunSummary) bool {
+			if a.Status != b.Status {
+				return a.Status < b.Status
+			}
+			return newest(a, b)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown sort column %q, must be %s, %s or %s",
+			sortBy, api.RunSortStart, api.RunSortDuration, api.RunSortStatus)
+	}
+}
+
+// runDuration is the time between start and completion, or until now for an
+// unfinished run; zero if the run has not started
+func runDuration(startTime, completionTime string, now time.Time) time.Duration {
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		return 0
+	}
+	end, err := time.Parse(time.RFC3339, completionTime)
+	if err != nil {
+		end = now
+	}
+	return end.Sub(start)
+}
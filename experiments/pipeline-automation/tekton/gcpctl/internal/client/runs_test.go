@@ -0,0 +1,129 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// testRuns are region runs plus a run of another pipeline; the
+// reference time is 2025-10-15T18:10:00Z
+func testRuns() []TektonPipelineRun {
+	runs := testRegionRuns()
+	for i := range runs {
+		runs[i].Metadata.Labels = map[string]string{"tekton.dev/pipeline": RegionPipelineName}
+	}
+	// The finished region runs take 1, 2, 4 and 3 minutes
+	for i, minutes := range []int{1, 2, 4, 3} {
+		start, _ := time.Parse(time.RFC3339, runs[i].Status.StartTime)
+		runs[i].Status.CompletionTime = start.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339)
+	}
+
+	e2e := regionRun("gcp-region-e2e-zzzzz", "2025-10-15T18:05:00Z", "integration", "test", "asia-east1", "", "Succeeded")
+	e2e.Status.CompletionTime = "2025-10-15T18:06:00Z"
+	e2e.Spec.PipelineRef.Name = "gcp-region-e2e"
+	return append(runs, e2e)
+}
+
+var runsNow = time.Date(2025, 10, 15, 18, 10, 0, 0, time.UTC)
+
+func runNames(list *api.RunList) string {
+	var names []string
+	for _, r := range list.Items {
+		names = append(names, strings.TrimPrefix(strings.TrimPrefix(r.Name, "gcp-region-provision-"), "gcp-region-e2e-"))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestListRuns(t *testing.T) {
+	tests := []struct {
+		name string
+		opts api.RunListOptions
+		want string
+	}{
+		{"newest first", api.RunListOptions{}, "fffff,eeeee,zzzzz,ddddd,ccccc,bbbbb,aaaaa"},
+		{"pipeline", api.RunListOptions{Pipeline: "gcp-region-e2e"}, "zzzzz"},
+		{"params", api.RunListOptions{Environment: "production", Region: "us-central1"}, "ddddd,ccccc"},
+		{"sector", api.RunListOptions{Sector: "test", Pipeline: RegionPipelineName}, "bbbbb,aaaaa"},
+		{"status", api.RunListOptions{Status: "failed"}, "ccccc"},
+		{"since", api.RunListOptions{Since: 10 * time.Minute}, "fffff,eeeee,zzzzz,ddddd"},
+		{"duration", api.RunListOptions{SortBy: api.RunSortDuration, Pipeline: RegionPipelineName}, "ddddd,ccccc,bbbbb,eeeee,aaaaa,fffff"},
+		{"status column", api.RunListOptions{SortBy: api.RunSortStatus, Since: 2 * time.Hour}, "ccccc,fffff,eeeee,zzzzz,ddddd"},
+		{"reverse", api.RunListOptions{Reverse: true, Environment: "production"}, "ccccc,ddddd,eeeee"},
+		{"first page", api.RunListOptions{Limit: 3}, "fffff,eeeee,zzzzz"},
+		{"last page", api.RunListOptions{Limit: 3, Page: 3}, "aaaaa"},
+		{"past the last page", api.RunListOptions{Limit: 3, Page: 4}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := ListRuns(testRuns(), tt.opts, runsNow)
+			if err != nil {
+				t.Fatalf("ListRuns() error = %v", err)
+			}
+			if got := runNames(list); got != tt.want {
+				t.Errorf("ListRuns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListRuns_Summary(t *testing.T) {
+	list, err := ListRuns(testRuns(), api.RunListOptions{Limit: 2, Page: 2}, runsNow)
+	if err != nil {
+		t.Fatalf("ListRuns() error = %v", err)
+	}
+	if list.Total != 7 || list.Page != 2 || list.Limit != 2 {
+		t.Errorf("ListRuns() = total %d, page %d, limit %d, want 7, 2, 2", list.Total, list.Page, list.Limit)
+	}
+
+	e2e := list.Items[0]
+	if e2e.Pipeline != "gcp-region-e2e" || e2e.Environment != "integration" || e2e.DurationSeconds != 60 {
+		t.Errorf("Items[0] = %+v, want the finished e2e run", e2e)
+	}
+	// Unfinished runs last until now
+	running, _ := ListRuns(testRuns(), api.RunListOptions{Limit: 1}, runsNow)
+	if running.Items[0].DurationSeconds != 0 || running.Items[0].Status != "Running" {
+		t.Errorf("Items[0] = %+v, want the running run started now", running.Items[0])
+	}
+}
+
+func TestListRuns_InvalidOptions(t *testing.T) {
+	for _, opts := range []api.RunListOptions{
+		{SortBy: "name"},
+		{Limit: -1},
+		{Page: -1},
+	} {
+		if _, err := ListRuns(testRuns(), opts, runsNow); err == nil {
+			t.Errorf("ListRuns(%+v) should return error", opts)
+		}
+	}
+}
+
+func TestPipelineSelector(t *testing.T) {
+	if got := PipelineSelector(RegionPipelineName); got != RegionPipelineSelector {
+		t.Errorf("PipelineSelector() = %v, want %v", got, RegionPipelineSelector)
+	}
+	if got := PipelineSelector(""); got != "" {
+		t.Errorf("PipelineSelector(\"\") = %v, want empty", got)
+	}
+}
+
+func TestDescribeRun(t *testing.T) {
+	run := testRuns()[3]
+	run.Metadata.Labels[EventIDLabel] = "f4c1a2b3"
+	run.Metadata.Labels[BundleVersionLabel] = "1.3.0"
+
+	desc := DescribeRun(&run, runsNow)
+	if desc.EventID != "f4c1a2b3" || desc.BundleVersion != "1.3.0" || desc.Pipeline != RegionPipelineName {
+		t.Errorf("DescribeRun() = %+v", desc)
+	}
+	if desc.Params["region"] != run.Param("region") || len(desc.Params) != len(run.Spec.Params) {
+		t.Errorf("DescribeRun() params = %v", desc.Params)
+	}
+	if desc.DurationSeconds != 180 {
+		t.Errorf("DescribeRun() duration = %d, want 180", desc.DurationSeconds)
+	}
+}
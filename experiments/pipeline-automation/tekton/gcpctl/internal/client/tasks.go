@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// stepSkipped is the termination reason of steps Tekton did not run because
+// an earlier step of the task failed
+const stepSkipped = "Skipped"
+
+// tektonConditions are the conditions of a TaskRun
+type tektonConditions = []struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// TektonStep represents the state of a step container of a TaskRun
+type TektonStep struct {
+	Name      string `json:"name"`
+	Container string `json:"container"`
+	Waiting   *struct {
+		Reason string `json:"reason,omitempty"`
+	} `json:"waiting,omitempty"`
+	Running *struct {
+		StartedAt string `json:"startedAt,omitempty"`
+	} `json:"running,omitempty"`
+	Terminated *struct {
+		ExitCode   int32  `json:"exitCode"`
+		Reason     string `json:"reason,omitempty"`
+		StartedAt  string `json:"startedAt,omitempty"`
+		FinishedAt string `json:"finishedAt,omitempty"`
+	} `json:"terminated,omitempty"`
+	// TerminationReason is Skipped for steps that did not run
+	TerminationReason string `json:"terminationReason,omitempty"`
+}
+
+// taskStatus returns the status of a TaskRun from its Succeeded condition:
+// Succeeded, Failed, Running or Unknown
+func taskStatus(conditions tektonConditions) string {
+	for _, cond := range conditions {
+		if cond.Type == "Succeeded" {
+			switch cond.Status {
+			case "True":
+				return "Succeeded"
+			case "False":
+				return "Failed"
+			case "Unknown":
+				return "Running"
+			}
+			break
+		}
+	}
+	return "Unknown"
+}
+
+// TaskStatus converts a TaskRun into the status of its pipeline task
+func (tr *TektonTaskRun) TaskStatus() api.TaskRunStatus {
+	return newTaskRunStatus(tr.PipelineTask(), taskStatus(tr.Status.Conditions),
+		tr.Status.StartTime, tr.Status.CompletionTime, tr.Status.Steps)
+}
+
+// newTaskRunStatus builds the status of a task, with its duration once it
+// completed and the status of its steps
+func newTaskRunStatus(name, status, start, completion string, steps []TektonStep) api.TaskRunStatus {
+	task := api.TaskRunStatus{
+		Name:            name,
+		Status:          status,
+		StartTime:       start,
+		CompletionTime:  completion,
+		DurationSeconds: durationSeconds(start, completion),
+	}
+	for _, step := range steps {
+		task.Steps = append(task.Steps, stepStatus(step))
+	}
+	return task
+}
+
+// stepStatus converts the state of a step container
+func stepStatus(step TektonStep) api.StepStatus {
+	s := api.StepStatus{Name: step.Name, Status: "Pending"}
+	switch {
+	case step.Terminated != nil:
+		t := step.Terminated
+		exitCode := t.ExitCode
+		s.ExitCode = &exitCode
+		s.StartTime = t.StartedAt
+		s.CompletionTime = t.FinishedAt
+		switch {
+		case step.TerminationReason == stepSkipped || t.Reason == stepSkipped:
+			s.Status = "Skipped"
+			s.ExitCode = nil
+			s.StartTime, s.CompletionTime = "", ""
+		case t.ExitCode == 0:
+			s.Status = "Succeeded"
+		default:
+			s.Status = "Failed"
+			s.Reason = t.Reason
+		}
+		s.DurationSeconds = durationSeconds(s.StartTime, s.CompletionTime)
+	case step.Running != nil:
+		s.Status = "Running"
+		s.StartTime = step.Running.StartedAt
+	case step.Waiting != nil:
+		s.Reason = step.Waiting.Reason
+	}
+	return s
+}
+
+// durationSeconds returns the seconds between two RFC 3339 times, or 0 if
+// either is missing
+func durationSeconds(start, completion string) int64 {
+	from, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return 0
+	}
+	to, err := time.Parse(time.RFC3339, completion)
+	if err != nil {
+		return 0
+	}
+	return int64(to.Sub(from).Seconds())
+}
+
+// AddTaskRunDetails replaces the tasks of a pipeline run status with its
+// TaskRuns, which carry the state of every step. Tekton v1 pipeline runs only
+// reference their TaskRuns, so their status has no tasks until then. The
+// tasks are kept if no TaskRun is found.
+func AddTaskRunDetails(ctx context.Context, src LogSource, status *api.PipelineRunStatus) error {
+	taskRuns, err := src.ListTaskRuns(ctx, status.Namespace, status.Name)
+	if err != nil {
+		return err
+	}
+	if len(taskRuns) == 0 {
+		return nil
+	}
+
+	sortTaskRuns(taskRuns)
+	status.Tasks = make([]api.TaskRunStatus, 0, len(taskRuns))
+	for i := range taskRuns {
+		status.Tasks = append(status.Tasks, taskRuns[i].TaskStatus())
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// failedTaskRun is the status of a TaskRun whose second step failed, in the
+// format of the Tekton API
+const failedTaskRun = `{
+  "metadata": {
+    "name": "gcp-region-provision-jf8v5-terraform-apply",
+    "labels": {"tekton.dev/pipelineTask": "terraform-apply"}
+  },
+  "status": {
+    "conditions": [{"type": "Succeeded", "status": "False", "reason": "Failed"}],
+    "podName": "gcp-region-provision-jf8v5-terraform-apply-pod",
+    "startTime": "2025-01-15T10:00:00Z",
+    "completionTime": "2025-01-15T10:02:13Z",
+    "steps": [
+      {"name": "init", "container": "step-init",
+       "terminated": {"exitCode": 0, "reason": "Completed", "startedAt": "2025-01-15T10:00:03Z", "finishedAt": "2025-01-15T10:00:23Z"}},
+      {"name": "apply", "container": "step-apply",
+       "terminated": {"exitCode": 1, "reason": "Error", "startedAt": "2025-01-15T10:00:23Z", "finishedAt": "2025-01-15T10:02:13Z"}},
+      {"name": "cleanup", "container": "step-cleanup", "terminationReason": "Skipped",
+       "terminated": {"exitCode": 1, "reason": "Error", "startedAt": "2025-01-15T10:02:13Z", "finishedAt": "2025-01-15T10:02:13Z"}}
+    ]
+  }
+}`
+
+func TestTektonTaskRun_TaskStatus(t *testing.T) {
+	var tr TektonTaskRun
+	if err := json.Unmarshal([]byte(failedTaskRun), &tr); err != nil {
+		t.Fatal(err)
+	}
+
+	task := tr.TaskStatus()
+	if task.Name != "terraform-apply" || task.Status != "Failed" || task.DurationSeconds != 133 {
+		t.Errorf("TaskStatus() = %+v, want failed terraform-apply of 133s", task)
+	}
+	if len(task.Steps) != 3 {
+		t.Fatalf("Steps = %+v, want 3", task.Steps)
+	}
+
+	tests := []struct {
+		status   string
+		exitCode *int32
+		reason   string
+		duration int64
+	}{
+		{status: "Succeeded", exitCode: ptr(int32(0)), duration: 20},
+		{status: "Failed", exitCode: ptr(int32(1)), reason: "Error", duration: 110},
+		{status: "Skipped"},
+	}
+	for i, tt := range tests {
+		step := task.Steps[i]
+		if step.Status != tt.status || step.Reason != tt.reason || step.DurationSeconds != tt.duration {
+			t.Errorf("step %s = %+v, want %s (%s) of %ds", step.Name, step, tt.status, tt.reason, tt.duration)
+		}
+		if (step.ExitCode == nil) != (tt.exitCode == nil) || (step.ExitCode != nil && *step.ExitCode != *tt.exitCode) {
+			t.Errorf("step %s exit code = %v, want %v", step.Name, step.ExitCode, tt.exitCode)
+		}
+	}
+}
+
+func TestStepStatus_NotTerminated(t *testing.T) {
+	var steps []TektonStep
+	if err := json.Unmarshal([]byte(`[
+		{"name": "apply", "running": {"startedAt": "2025-01-15T10:00:23Z"}},
+		{"name": "plan", "waiting": {"reason": "ImagePullBackOff"}},
+		{"name": "init"}
+	]`), &steps); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []api.StepStatus{
+		{Name: "apply", Status: "Running", StartTime: "2025-01-15T10:00:23Z"},
+		{Name: "plan", Status: "Pending", Reason: "ImagePullBackOff"},
+		{Name: "init", Status: "Pending"},
+	}
+	for i, step := range steps {
+		if got := stepStatus(step); got != want[i] {
+			t.Errorf("stepStatus(%s) = %+v, want %+v", step.Name, got, want[i])
+		}
+	}
+}
+
+func TestConvertPipelineRunToStatus_EmbeddedSteps(t *testing.T) {
+	// v1beta1 pipeline runs embed the status of their TaskRuns
+	var pr TektonPipelineRun
+	if err := json.Unmarshal([]byte(`{
+		"metadata": {"name": "gcp-region-provision-jf8v5"},
+		"status": {"taskRuns": {"gcp-region-provision-jf8v5-terraform-apply": {
+			"pipelineTaskName": "terraform-apply",
+			"status": {
+				"conditions": [{"type": "Succeeded", "status": "True"}],
+				"startTime": "2025-01-15T10:00:00Z",
+				"completionTime": "2025-01-15T10:01:00Z",
+				"steps": [{"name": "apply", "terminated": {"exitCode": 0, "startedAt": "2025-01-15T10:00:01Z", "finishedAt": "2025-01-15T10:01:00Z"}}]
+			}
+		}}}
+	}`), &pr); err != nil {
+		t.Fatal(err)
+	}
+
+	status := convertPipelineRunToStatus(&pr)
+	if len(status.Tasks) != 1 {
+		t.Fatalf("Tasks = %+v, want 1", status.Tasks)
+	}
+	task := status.Tasks[0]
+	if task.DurationSeconds != 60 || len(task.Steps) != 1 || task.Steps[0].Status != "Succeeded" || task.Steps[0].DurationSeconds != 59 {
+		t.Errorf("task = %+v", task)
+	}
+}
+
+func TestAddTaskRunDetails(t *testing.T) {
+	var failed TektonTaskRun
+	if err := json.Unmarshal([]byte(failedTaskRun), &failed); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeLogServer{taskRuns: []TektonTaskRun{
+		failed,
+		taskRun("validate-inputs", "pod-validate", "2025-01-15T09:59:00Z", "validate"),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	status := &api.PipelineRunStatus{Name: "gcp-region-provision-jf8v5", Namespace: "default"}
+	if err := AddTaskRunDetails(context.Background(), NewTektonAPIClient(server.URL), status); err != nil {
+		t.Fatalf("AddTaskRunDetails() error = %v", err)
+	}
+
+	if len(status.Tasks) != 2 || status.Tasks[0].Name != "validate-inputs" || status.Tasks[1].Name != "terraform-apply" {
+		t.Fatalf("Tasks = %+v, want validate-inputs then terraform-apply", status.Tasks)
+	}
+	if len(status.Tasks[1].Steps) != 3 {
+		t.Errorf("terraform-apply steps = %+v", status.Tasks[1].Steps)
+	}
+}
+
+func TestAddTaskRunDetails_KeepsTasksWithoutTaskRuns(t *testing.T) {
+	server := httptest.NewServer(&fakeLogServer{})
+	defer server.Close()
+
+	tasks := []api.TaskRunStatus{{Name: "validate-inputs", Status: "Succeeded"}}
+	status := &api.PipelineRunStatus{Name: "gcp-region-provision-jf8v5", Namespace: "default", Tasks: tasks}
+	if err := AddTaskRunDetails(context.Background(), NewTektonAPIClient(server.URL), status); err != nil {
+		t.Fatalf("AddTaskRunDetails() error = %v", err)
+	}
+	if len(status.Tasks) != 1 || status.Tasks[0].Name != "validate-inputs" {
+		t.Errorf("Tasks = %+v, want them kept", status.Tasks)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -3,10 +3,14 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
@@ -17,10 +21,20 @@ const (
 	contentType    = "application/json"
 )
 
+// SignatureHeader carries the HMAC-SHA256 signature of a signed payload, in
+// the format of GitHub webhooks checked by the Tekton GitHub interceptor
+const SignatureHeader = "X-Hub-Signature-256"
+
 // TektonClient handles communication with Tekton webhook
 type TektonClient struct {
 	baseURL    string
 	httpClient *http.Client
+	// secret signs payloads when set
+	secret []byte
+	// customHeaders are set on every request, see SetHeaders
+	customHeaders http.Header
+	retry         RetryPolicy
+	stats         retryStats
 }
 
 // NewTektonClient creates a new Tekton webhook client
@@ -30,6 +44,7 @@ func NewTektonClient(baseURL string) *TektonClient {
 		httpClient: &http.Client{
 			Timeout: defaultTimeout,
 		},
+		retry: DefaultRetryPolicy(),
 	}
 }
 
@@ -40,33 +55,118 @@ func NewTektonClientWithTimeout(baseURL string, timeout time.Duration) *TektonCl
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
+		retry: DefaultRetryPolicy(),
 	}
 }
 
 // AddRegion sends a region add request to the Tekton webhook
 func (c *TektonClient) AddRegion(ctx context.Context, req *api.RegionRequest) (*api.TektonResponse, error) {
+	return c.sendRegionRequest(ctx, req, "Region added successfully")
+}
+
+// DeleteRegion sends a region delete request to the Tekton webhook. The
+// pipeline destroys the region's resources with Terraform.
+func (c *TektonClient) DeleteRegion(ctx context.Context, req *api.RegionRequest) (*api.TektonResponse, error) {
+	deleteReq := *req
+	deleteReq.Action = api.RegionActionDelete
+	return c.sendRegionRequest(ctx, &deleteReq, "Region deletion requested")
+}
+
+// sendRegionRequest posts req to the Tekton webhook. defaultMessage is used
+// when the webhook answers with an empty body.
+func (c *TektonClient) sendRegionRequest(ctx context.Context, req *api.RegionRequest, defaultMessage string) (*api.TektonResponse, error) {
 	// Validate request
 	if err := req.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid request: %w", err)
 	}
 
-	// Marshal request body
-	body, err := json.Marshal(req)
+	return c.send(ctx, c.baseURL, req, defaultMessage)
+}
+
+// Trigger posts the payload of an operation to a route of the Tekton
+// webhook, a path relative to the webhook URL. An empty route posts to the
+// webhook URL itself.
+func (c *TektonClient) Trigger(ctx context.Context, route string, payload any) (*api.TektonResponse, error) {
+	return c.send(ctx, c.routeURL(route), payload, "Request accepted")
+}
+
+// Prepare returns the request Trigger would send for the payload of an
+// operation, without sending it
+func (c *TektonClient) Prepare(route string, payload any) (*api.WebhookRequest, error) {
+	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL, bytes.NewBuffer(body))
+	headers := map[string]string{}
+	for name, values := range c.headers(body) {
+		headers[name] = strings.Join(values, ", ")
+	}
+	return &api.WebhookRequest{
+		Method:  http.MethodPost,
+		URL:     c.routeURL(route),
+		Headers: headers,
+		Body:    body,
+	}, nil
+}
+
+// ProbeRoute sends a HEAD request to a route of the webhook and returns the
+// status code of the response. The request has no payload, so the
+// interceptors of the event listener do not let it start a pipeline; it only
+// shows whether the route is reachable with the configured proxy and headers.
+func (c *TektonClient) ProbeRoute(ctx context.Context, route string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.routeURL(route), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	setHeaders(req.Header, c.customHeaders)
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// routeURL returns the URL of a route of the webhook
+func (c *TektonClient) routeURL(route string) string {
+	if route == "" {
+		return c.baseURL
+	}
+	return strings.TrimSuffix(c.baseURL, "/") + "/" + strings.TrimPrefix(route, "/")
+}
+
+// headers returns the headers of a request posting body to the webhook
+func (c *TektonClient) headers(body []byte) http.Header {
+	h := http.Header{}
+	setHeaders(h, c.customHeaders)
+	h.Set("Content-Type", contentType)
+	h.Set("Accept", contentType)
+	if len(c.secret) > 0 {
+		h.Set(SignatureHeader, SignPayload(c.secret, body))
 	}
+	return h
+}
 
-	httpReq.Header.Set("Content-Type", contentType)
-	httpReq.Header.Set("Accept", contentType)
+// send posts payload as JSON to url. defaultMessage is used when the webhook
+// answers with an empty body.
+func (c *TektonClient) send(ctx context.Context, url string, payload any, defaultMessage string) (*api.TektonResponse, error) {
+	// Marshal request body
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
 
-	// Send request
-	resp, err := c.httpClient.Do(httpReq)
+	// Send request, retrying transient errors
+	resp, err := doWithRetry(ctx, c.httpClient, c.retry, &c.stats, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header = c.headers(body)
+		return httpReq, nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -79,6 +179,13 @@ func (c *TektonClient) AddRegion(ctx context.Context, req *api.RegionRequest) (*
 	}
 
 	// Check status code
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		hint := "the webhook may require signed payloads, set a webhook secret"
+		if len(c.secret) > 0 {
+			hint = "check that the webhook secret matches the one of the EventListener"
+		}
+		return nil, fmt.Errorf("webhook rejected the request with status %d (%s): %s", resp.StatusCode, hint, string(respBody))
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(respBody))
 	}
@@ -96,14 +203,51 @@ func (c *TektonClient) AddRegion(ctx context.Context, req *api.RegionRequest) (*
 	} else {
 		tektonResp = api.TektonResponse{
 			Status:  "success",
-			Message: "Region added successfully",
+			Message: defaultMessage,
 		}
 	}
 
 	return &tektonResp, nil
 }
 
+// SetWebhookSecret makes the client sign payloads with secret in the
+// SignatureHeader. An empty secret turns signing off.
+func (c *TektonClient) SetWebhookSecret(secret string) {
+	c.secret = []byte(secret)
+}
+
+// SignPayload returns the SignatureHeader value of a payload:
+// "sha256=" followed by the hex HMAC-SHA256 of the payload
+func SignPayload(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetHeaders sets headers sent with every request, e.g. the credentials of a
+// proxy in front of the webhook. Content-Type, Accept and the signature are
+// always set by the client.
+func (c *TektonClient) SetHeaders(headers map[string]string) {
+	c.customHeaders = customHeaders(headers)
+}
+
+// SetRetryPolicy replaces the policy retrying requests that failed with a
+// transient error; NoRetry sends every request once
+func (c *TektonClient) SetRetryPolicy(p RetryPolicy) {
+	c.retry = p
+}
+
+// RetryStats returns how many requests the client sent and retried
+func (c *TektonClient) RetryStats() RetryStats {
+	return c.stats.snapshot()
+}
+
 // SetTimeout updates the HTTP client timeout
 func (c *TektonClient) SetTimeout(timeout time.Duration) {
 	c.httpClient.Timeout = timeout
 }
+
+// SetTransport replaces the HTTP transport, e.g. to record or replay interactions in tests
+func (c *TektonClient) SetTransport(rt http.RoundTripper) {
+	c.httpClient.Transport = rt
+}
@@ -1,12 +1,16 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
@@ -16,6 +20,16 @@ import (
 type TektonAPIClient struct {
 	baseURL    string
 	httpClient *http.Client
+	retry      RetryPolicy
+	stats      retryStats
+	// customHeaders are set on every request, see SetHeaders
+	customHeaders http.Header
+
+	// version is the tekton.dev version found by API discovery, once a
+	// request showed that the cluster does not serve v1
+	versionMu  sync.Mutex
+	version    string
+	negotiated bool
 }
 
 // NewTektonAPIClient creates a new Tekton API client
@@ -25,9 +39,32 @@ func NewTektonAPIClient(baseURL string) *TektonAPIClient {
 		httpClient: &http.Client{
 			Timeout: defaultTimeout,
 		},
+		retry: DefaultRetryPolicy(),
 	}
 }
 
+// SetTransport replaces the HTTP transport, e.g. to record or replay interactions in tests
+func (c *TektonAPIClient) SetTransport(rt http.RoundTripper) {
+	c.httpClient.Transport = rt
+}
+
+// SetHeaders sets headers sent with every request, e.g. the credentials of a
+// proxy in front of the API server
+func (c *TektonAPIClient) SetHeaders(headers map[string]string) {
+	c.customHeaders = customHeaders(headers)
+}
+
+// SetRetryPolicy replaces the policy retrying requests that failed with a
+// transient error; NoRetry sends every request once
+func (c *TektonAPIClient) SetRetryPolicy(p RetryPolicy) {
+	c.retry = p
+}
+
+// RetryStats returns how many requests the client sent and retried
+func (c *TektonAPIClient) RetryStats() RetryStats {
+	return c.stats.snapshot()
+}
+
 // TektonPipelineRun represents a Tekton PipelineRun from the API
 type TektonPipelineRun struct {
 	APIVersion string `json:"apiVersion"`
@@ -59,13 +96,10 @@ type TektonPipelineRun struct {
 		TaskRuns       map[string]struct {
 			PipelineTaskName string `json:"pipelineTaskName"`
 			Status           struct {
-				Conditions []struct {
-					Type   string `json:"type"`
-					Status string `json:"status"`
-					Reason string `json:"reason"`
-				} `json:"conditions"`
-				StartTime      string `json:"startTime,omitempty"`
-				CompletionTime string `json:"completionTime,omitempty"`
+				Conditions     tektonConditions `json:"conditions"`
+				StartTime      string           `json:"startTime,omitempty"`
+				CompletionTime string           `json:"completionTime,omitempty"`
+				Steps          []TektonStep     `json:"steps,omitempty"`
 			} `json:"status"`
 		} `json:"taskRuns,omitempty"`
 	} `json:"status"`
@@ -80,53 +114,42 @@ type TektonPipelineRunList struct {
 
 // GetPipelineRunsByEventID queries Tekton API for pipeline runs matching an event ID
 func (c *TektonAPIClient) GetPipelineRunsByEventID(ctx context.Context, namespace, eventID string) (*api.PipelineRunStatus, error) {
-	if namespace == "" {
-		namespace = "default"
-	}
-
 	// Query for pipeline runs with the event ID label
 	// Tekton labels pipeline runs created by event listeners with triggers.tekton.dev/triggers-eventid
-	url := fmt.Sprintf("%s/apis/tekton.dev/v1/namespaces/%s/pipelineruns?labelSelector=triggers.tekton.dev/triggers-eventid=%s",
-		c.baseURL, namespace, eventID)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	runs, err := c.ListPipelineRuns(ctx, namespace, "triggers.tekton.dev/triggers-eventid="+eventID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query Tekton API: %w", err)
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%w for event ID: %s", ErrPipelineRunNotFound, eventID)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
+	// Get the most recent pipeline run (should only be one, but just in case)
+	pr := runs[0]
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Tekton API returned status %d: %s", resp.StatusCode, string(body))
-	}
+	// Convert to our status type
+	status := convertPipelineRunToStatus(&pr)
 
-	var pipelineList TektonPipelineRunList
-	if err := json.Unmarshal(body, &pipelineList); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
-	}
+	return status, nil
+}
 
-	if len(pipelineList.Items) == 0 {
-		return nil, fmt.Errorf("no pipeline runs found for event ID: %s", eventID)
+// ListPipelineRuns queries Tekton API for the pipeline runs matching a label selector
+func (c *TektonAPIClient) ListPipelineRuns(ctx context.Context, namespace, labelSelector string) ([]TektonPipelineRun, error) {
+	if namespace == "" {
+		namespace = "default"
 	}
 
-	// Get the most recent pipeline run (should only be one, but just in case)
-	pr := pipelineList.Items[0]
-
-	// Convert to our status type
-	status := c.convertPipelineRunToStatus(&pr)
+	query := ""
+	if labelSelector != "" {
+		query = url.Values{"labelSelector": {labelSelector}}.Encode()
+	}
+	var pipelineList TektonPipelineRunList
+	if err := c.doTekton(ctx, http.MethodGet, namespace, "pipelineruns", "", query, "", nil, &pipelineList); err != nil {
+		return nil, err
+	}
 
-	return status, nil
+	return pipelineList.Items, nil
 }
 
 // GetPipelineRun queries for a specific pipeline run by name
@@ -135,49 +158,81 @@ func (c *TektonAPIClient) GetPipelineRun(ctx context.Context, namespace, name st
 		namespace = "default"
 	}
 
-	url := fmt.Sprintf("%s/apis/tekton.dev/v1/namespaces/%s/pipelineruns/%s",
-		c.baseURL, namespace, name)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	var pr TektonPipelineRun
+	if err := c.doTekton(ctx, http.MethodGet, namespace, "pipelineruns", name, "", "", nil, &pr); err != nil {
+		return nil, err
 	}
 
-	req.Header.Set("Accept", "application/json")
+	status := convertPipelineRunToStatus(&pr)
+
+	return status, nil
+}
+
+// do sends a request with an optional body of the given content type to url
+// and decodes the JSON response into out, if out is not nil. Transient errors
+// are retried according to the retry policy of the client. Other status codes
+// than 2xx are returned as a *StatusError.
+func (c *TektonAPIClient) do(ctx context.Context, method, url, contentType string, body []byte, out any) error {
+	resp, err := doWithRetry(ctx, c.httpClient, c.retry, &c.stats, func() (*http.Request, error) {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
 
-	resp, err := c.httpClient.Do(req)
+		setHeaders(req.Header, c.customHeaders)
+		req.Header.Set("Accept", "application/json")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		return req, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to query Tekton API: %w", err)
+		return fmt.Errorf("failed to query Tekton API: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return fmt.Errorf("failed to read response: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Tekton API returned status %d: %s", resp.StatusCode, string(body))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
 
-	var pr TektonPipelineRun
-	if err := json.Unmarshal(body, &pr); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	status := c.convertPipelineRunToStatus(&pr)
+	return nil
+}
 
-	return status, nil
+// Param returns the value of a pipeline run parameter, empty if it is not set
+func (pr *TektonPipelineRun) Param(name string) string {
+	for _, p := range pr.Spec.Params {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return ""
 }
 
 // convertPipelineRunToStatus converts Tekton API response to our status type
-func (c *TektonAPIClient) convertPipelineRunToStatus(pr *TektonPipelineRun) *api.PipelineRunStatus {
+func convertPipelineRunToStatus(pr *TektonPipelineRun) *api.PipelineRunStatus {
 	status := &api.PipelineRunStatus{
 		Name:           pr.Metadata.Name,
 		Namespace:      pr.Metadata.Namespace,
 		Status:         "Unknown",
 		StartTime:      pr.Status.StartTime,
 		CompletionTime: pr.Status.CompletionTime,
+		Action:         pr.Param("action"),
 	}
 
 	// Determine overall status from conditions
@@ -187,7 +242,8 @@ func (c *TektonAPIClient) convertPipelineRunToStatus(pr *TektonPipelineRun) *api
 			case "True":
 				status.Status = "Succeeded"
 			case "False":
-				if cond.Reason == "PipelineRunCancelled" {
+				// v1beta1 and v1 name the reason differently
+				if cond.Reason == "PipelineRunCancelled" || cond.Reason == "Cancelled" {
 					status.Status = "Cancelled"
 				} else {
 					status.Status = "Failed"
@@ -208,27 +264,26 @@ func (c *TektonAPIClient) convertPipelineRunToStatus(pr *TektonPipelineRun) *api
 
 	// Extract task statuses
 	for _, taskRun := range pr.Status.TaskRuns {
-		taskStatus := "Unknown"
-		for _, cond := range taskRun.Status.Conditions {
-			if cond.Type == "Succeeded" {
-				switch cond.Status {
-				case "True":
-					taskStatus = "Succeeded"
-				case "False":
-					taskStatus = "Failed"
-				case "Unknown":
-					taskStatus = "Running"
-				}
-				break
-			}
-		}
-
-		status.Tasks = append(status.Tasks, api.TaskRunStatus{
-			Name:      taskRun.PipelineTaskName,
-			Status:    taskStatus,
-			StartTime: taskRun.Status.StartTime,
-		})
+		status.Tasks = append(status.Tasks, newTaskRunStatus(
+			taskRun.PipelineTaskName,
+			taskStatus(taskRun.Status.Conditions),
+			taskRun.Status.StartTime,
+			taskRun.Status.CompletionTime,
+			taskRun.Status.Steps,
+		))
 	}
+	// The embedded statuses are keyed by TaskRun name, list them in the
+	// order the tasks started, those not started yet last
+	sort.SliceStable(status.Tasks, func(i, j int) bool {
+		a, b := status.Tasks[i], status.Tasks[j]
+		if (a.StartTime == "") != (b.StartTime == "") {
+			return b.StartTime == ""
+		}
+		if a.StartTime != b.StartTime {
+			return a.StartTime < b.StartTime
+		}
+		return a.Name < b.Name
+	})
 
 	// Add conditions
 	for _, cond := range pr.Status.Conditions {
@@ -300,6 +355,8 @@ func GetStatusEmoji(status string) string {
 		return "⏸"
 	case "cancelled":
 		return "⊘"
+	case "skipped":
+		return "↷"
 	default:
 		return "?"
 	}
@@ -2,9 +2,12 @@ package client
 
 import (
 	"context"
+	"crypto/hmac"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -159,6 +162,117 @@ func TestTektonClient_AddRegion_Timeout(t *testing.T) {
 	}
 }
 
+func TestTektonClient_DeleteRegion_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req api.RegionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Failed to decode request: %v", err)
+		}
+
+		if req.Action != api.RegionActionDelete {
+			t.Errorf("Action = %v, want %v", req.Action, api.RegionActionDelete)
+		}
+		if req.Region != "asia-east1" {
+			t.Errorf("Region = %v, want %v", req.Region, "asia-east1")
+		}
+
+		// Tekton answers 202 with the event ID
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(api.TektonResponse{
+			EventID:   "0c4ee4b6-0c8b-4a4c-a7cf-3b1d8c55d0a2",
+			Namespace: "default",
+		})
+	}))
+	defer server.Close()
+
+	client := NewTektonClient(server.URL)
+	req := &api.RegionRequest{
+		Environment: "integration",
+		Region:      "asia-east1",
+		Sector:      "test",
+	}
+
+	resp, err := client.DeleteRegion(context.Background(), req)
+	if err != nil {
+		t.Fatalf("DeleteRegion() error = %v", err)
+	}
+
+	if resp.EventID != "0c4ee4b6-0c8b-4a4c-a7cf-3b1d8c55d0a2" {
+		t.Errorf("EventID = %v, want %v", resp.EventID, "0c4ee4b6-0c8b-4a4c-a7cf-3b1d8c55d0a2")
+	}
+	if req.Action != "" {
+		t.Errorf("DeleteRegion() modified the caller's request: Action = %v", req.Action)
+	}
+}
+
+func TestTektonClient_AddRegion_OmitsAction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode request: %v", err)
+		}
+		if _, ok := body["action"]; ok {
+			t.Errorf("add payload has an action: %v", body)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := NewTektonClient(server.URL)
+	_, err := client.AddRegion(context.Background(), &api.RegionRequest{
+		Environment: "integration",
+		Region:      "us-central1",
+		Sector:      "main",
+	})
+	if err != nil {
+		t.Fatalf("AddRegion() error = %v", err)
+	}
+}
+
+func TestTektonClient_Trigger_Route(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseURL string
+		route   string
+		want    string
+	}{
+		{name: "route", route: "sector", want: "/sector"},
+		{name: "route with leading slash", route: "/sector", want: "/sector"},
+		{name: "base URL with trailing slash", baseURL: "/", route: "sector", want: "/sector"},
+		{name: "no route", want: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			var gotBody map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+					t.Fatalf("Failed to decode request: %v", err)
+				}
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer server.Close()
+
+			client := NewTektonClient(server.URL + tt.baseURL)
+			resp, err := client.Trigger(context.Background(), tt.route, map[string]string{"sector": "canary"})
+			if err != nil {
+				t.Fatalf("Trigger() error = %v", err)
+			}
+			if gotPath != tt.want {
+				t.Errorf("path = %q, want %q", gotPath, tt.want)
+			}
+			if gotBody["sector"] != "canary" {
+				t.Errorf("body = %v, want the payload", gotBody)
+			}
+			if resp.Message != "Request accepted" {
+				t.Errorf("Message = %q, want %q", resp.Message, "Request accepted")
+			}
+		})
+	}
+}
+
 func TestTektonClient_SetTimeout(t *testing.T) {
 	client := NewTektonClient("http://localhost:8080")
 	newTimeout := 60 * time.Second
@@ -169,3 +283,151 @@ func TestTektonClient_SetTimeout(t *testing.T) {
 		t.Errorf("timeout = %v, want %v", client.httpClient.Timeout, newTimeout)
 	}
 }
+
+// signatureCheckingServer accepts only payloads signed with secret, like an
+// EventListener with the GitHub interceptor
+func signatureCheckingServer(t *testing.T, secret string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("Failed to read request: %v", err)
+		}
+		got := r.Header.Get(SignatureHeader)
+		if got == "" {
+			http.Error(w, "no X-Hub-Signature-256 set", http.StatusForbidden)
+			return
+		}
+		if !hmac.Equal([]byte(got), []byte(SignPayload([]byte(secret), body))) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"eventID":"63950e1f-7ffe-4d14-bc0e-121cee88942e"}`))
+	}))
+}
+
+func TestSignPayload(t *testing.T) {
+	// Example of the GitHub webhook documentation
+	got := SignPayload([]byte("It's a Secret to Everybody"), []byte("Hello, World!"))
+	want := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if got != want {
+		t.Errorf("SignPayload() = %v, want %v", got, want)
+	}
+}
+
+func TestTektonClient_AddRegion_Signed(t *testing.T) {
+	server := signatureCheckingServer(t, "s3cret")
+	defer server.Close()
+
+	client := NewTektonClient(server.URL)
+	client.SetWebhookSecret("s3cret")
+
+	resp, err := client.AddRegion(context.Background(), &api.RegionRequest{
+		Environment: "production",
+		Region:      "us-central1",
+		Sector:      "main",
+	})
+	if err != nil {
+		t.Fatalf("AddRegion() error = %v", err)
+	}
+	if resp.EventID != "63950e1f-7ffe-4d14-bc0e-121cee88942e" {
+		t.Errorf("EventID = %v", resp.EventID)
+	}
+}
+
+func TestTektonClient_AddRegion_SignatureMismatch(t *testing.T) {
+	req := &api.RegionRequest{Environment: "production", Region: "us-central1", Sector: "main"}
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr string
+	}{
+		{"wrong secret", "not-the-secret", "check that the webhook secret matches"},
+		{"unsigned", "", "set a webhook secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := signatureCheckingServer(t, "s3cret")
+			defer server.Close()
+
+			client := NewTektonClient(server.URL)
+			client.SetWebhookSecret(tt.secret)
+
+			_, err := client.AddRegion(context.Background(), req)
+			if err == nil {
+				t.Fatal("AddRegion() should return error for a rejected signature")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "status 403") {
+				t.Errorf("AddRegion() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTektonClient_UnsignedByDefault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sig := r.Header.Get(SignatureHeader); sig != "" {
+			t.Errorf("%s = %v, want none without a secret", SignatureHeader, sig)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := NewTektonClient(server.URL)
+	_, err := client.DeleteRegion(context.Background(), &api.RegionRequest{Environment: "integration", Region: "asia-east1", Sector: "test"})
+	if err != nil {
+		t.Fatalf("DeleteRegion() error = %v", err)
+	}
+}
+
+func TestTektonClient_SignatureCoversSentBody(t *testing.T) {
+	// The signature must be of the exact bytes sent, including the action
+	// DeleteRegion adds
+	server := signatureCheckingServer(t, "s3cret")
+	defer server.Close()
+
+	client := NewTektonClient(server.URL)
+	client.SetWebhookSecret("s3cret")
+	_, err := client.DeleteRegion(context.Background(), &api.RegionRequest{Environment: "integration", Region: "asia-east1", Sector: "test"})
+	if err != nil {
+		t.Fatalf("DeleteRegion() error = %v", err)
+	}
+}
+
+func TestTektonClient_PrepareMatchesTrigger(t *testing.T) {
+	var sent *http.Request
+	var sentBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r
+		sentBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := NewTektonClient(server.URL)
+	client.SetWebhookSecret("s3cret")
+	payload := map[string]string{"environment": "integration", "sector": "canary"}
+
+	prepared, err := client.Prepare("sector", payload)
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	if _, err := client.Trigger(context.Background(), "sector", payload); err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+
+	if prepared.Method != sent.Method || prepared.URL != server.URL+sent.URL.Path {
+		t.Errorf("Prepare() = %s %s, sent %s %s", prepared.Method, prepared.URL, sent.Method, sent.URL.Path)
+	}
+	if string(prepared.Body) != string(sentBody) {
+		t.Errorf("Prepare() body = %s, sent %s", prepared.Body, sentBody)
+	}
+	for _, name := range []string{"Content-Type", "Accept", SignatureHeader} {
+		if prepared.Headers[name] == "" || prepared.Headers[name] != sent.Header.Get(name) {
+			t.Errorf("Prepare() header %s = %q, sent %q", name, prepared.Headers[name], sent.Header.Get(name))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxySchemes are the schemes of the proxy URLs NewTransport accepts
+var ProxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// NewTransport returns an HTTP transport sending requests through a proxy.
+// proxy is an http, https or socks5 URL and noProxy lists the hosts reached
+// directly, in the format of NO_PROXY. Without a proxy, HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY of the environment are used, like the default
+// transport does.
+func NewTransport(proxy, noProxy string) (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy == "" {
+		t.Proxy = http.ProxyFromEnvironment
+		return t, nil
+	}
+
+	if err := ValidateProxy(proxy); err != nil {
+		return nil, err
+	}
+	proxyFunc := (&httpproxy.Config{HTTPProxy: proxy, HTTPSProxy: proxy, NoProxy: noProxy}).ProxyFunc()
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+	return t, nil
+}
+
+// ValidateProxy checks that a proxy URL has a scheme of ProxySchemes and a
+// host
+func ValidateProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		// The error of url.Parse quotes the URL, which may hold a password
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if !slices.Contains(ProxySchemes, u.Scheme) {
+		return fmt.Errorf("invalid proxy URL %q: the scheme must be one of %v", u.Redacted(), ProxySchemes)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid proxy URL %q: no host", u.Redacted())
+	}
+	return nil
+}
+
+// customHeaders converts configured headers into an http.Header
+func customHeaders(headers map[string]string) http.Header {
+	h := http.Header{}
+	for name, value := range headers {
+		h.Set(name, value)
+	}
+	return h
+}
+
+// setHeaders sets the custom headers of a client on a request header, before
+// the headers the client sets itself, so these cannot be overridden
+func setHeaders(dst, custom http.Header) {
+	for name, values := range custom {
+		dst[name] = append([]string(nil), values...)
+	}
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// InFlightRuns returns the runs that have not finished, newest first, with
+// the pipeline tasks they are running. Running runs whose status does not
+// embed their TaskRuns, as with the Tekton v1 API, have them listed with src.
+func InFlightRuns(ctx context.Context, src LogSource, runs []TektonPipelineRun, now time.Time) ([]api.InFlightRun, error) {
+	items := make([]api.InFlightRun, 0, len(runs))
+	for i := range runs {
+		pr := &runs[i]
+		status := convertPipelineRunToStatus(pr)
+		if status.IsDone() {
+			continue
+		}
+
+		if len(status.Tasks) == 0 && status.Status == "Running" {
+			if err := AddTaskRunDetails(ctx, src, status); err != nil {
+				return nil, fmt.Errorf("failed to list the TaskRuns of %s: %w", status.Name, err)
+			}
+		}
+
+		run := api.InFlightRun{PipelineRunSummary: runSummary(pr, status, now)}
+		for _, task := range status.Tasks {
+			switch task.Status {
+			case "Running":
+				run.CurrentTasks = append(run.CurrentTasks, task.Name)
+			case "Succeeded", "Failed":
+				run.CompletedTasks++
+			}
+		}
+		sort.Strings(run.CurrentTasks)
+		items = append(items, run)
+	}
+
+	newest, _ := runLess(api.RunSortStart)
+	sort.SliceStable(items, func(i, j int) bool {
+		return newest(items[i].PipelineRunSummary, items[j].PipelineRunSummary)
+	})
+	return items, nil
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestInFlightRuns(t *testing.T) {
+	var running TektonTaskRun
+	if err := json.Unmarshal([]byte(`{
+		"metadata": {"labels": {"tekton.dev/pipelineTask": "terraform-apply"}},
+		"status": {"conditions": [{"type": "Succeeded", "status": "Unknown", "reason": "Running"}]}
+	}`), &running); err != nil {
+		t.Fatal(err)
+	}
+	var done TektonTaskRun
+	if err := json.Unmarshal([]byte(`{
+		"metadata": {"labels": {"tekton.dev/pipelineTask": "terraform-init"}},
+		"status": {"conditions": [{"type": "Succeeded", "status": "True"}]}
+	}`), &done); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeLogServer{taskRuns: []TektonTaskRun{
+		running,
+		taskRun("validate-inputs", "pod-validate", "2025-10-15T18:00:00Z", "validate"),
+		done,
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	runs := []TektonPipelineRun{
+		regionRun("gcp-region-provision-aaaaa", "2025-10-15T17:00:00Z", "production", "main", "us-central1", "", "Succeeded"),
+		regionRun("gcp-region-provision-jf8v5", "2025-10-15T18:00:00Z", "production", "main", "us-central1", "add", "Running"),
+		regionRun("gcp-region-provision-ppppp", "2025-10-15T18:09:00Z", "integration", "test", "asia-east1", "", "PipelineRunPending"),
+	}
+
+	got, err := InFlightRuns(context.Background(), NewTektonAPIClient(server.URL), runs, runsNow)
+	if err != nil {
+		t.Fatalf("InFlightRuns() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("InFlightRuns() = %+v, want the running and pending runs", got)
+	}
+
+	pending, run := got[0], got[1]
+	if pending.Name != "gcp-region-provision-ppppp" || pending.Status != "Pending" || len(pending.CurrentTasks) != 0 {
+		t.Errorf("got[0] = %+v, want the newest, pending run", pending)
+	}
+	if run.Name != "gcp-region-provision-jf8v5" || run.Action != "add" || run.DurationSeconds != 600 {
+		t.Errorf("got[1] = %+v, want the running run", run)
+	}
+	if !reflect.DeepEqual(run.CurrentTasks, []string{"terraform-apply"}) || run.CompletedTasks != 1 {
+		t.Errorf("tasks = %q, %d completed, want terraform-apply and 1 completed", run.CurrentTasks, run.CompletedTasks)
+	}
+}
+
+func TestInFlightRuns_TaskRunError(t *testing.T) {
+	server := httptest.NewServer(&fakeLogServer{})
+	defer server.Close()
+
+	runs := []TektonPipelineRun{
+		regionRun("gcp-region-provision-other", "2025-10-15T18:00:00Z", "production", "main", "us-central1", "", "Running"),
+	}
+	if _, err := InFlightRuns(context.Background(), NewTektonAPIClient(server.URL), runs, runsNow); err == nil {
+		t.Error("InFlightRuns() should return the TaskRun listing error")
+	}
+}
@@ -1,34 +1,141 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/history"
 	"github.com/spf13/viper"
 )
 
 // Config holds the application configuration
 type Config struct {
+	// Profile is the name of the active profile, empty without profiles
+	Profile            string
 	TektonURL          string
 	TektonDashboardURL string
 	TektonAPIURL       string
 	Verbose            bool
+	// Backend selects how Tekton resources are read: auto, kubeconfig, kubectl or api
+	Backend     string
+	Kubeconfig  string
+	KubeContext string
+	// Output is the output format of commands: table, json or yaml
+	Output string
+	// CatalogURL is a URL or file to read the region catalog from instead of
+	// the one built into gcpctl
+	CatalogURL string
+	// VersionURL is an endpoint returning the version of the pipeline bundle
+	// of the management cluster, read from its pipeline runs if empty
+	VersionURL string
+	// WatchNamespaces are the namespaces 'runs watch' shows the in-flight
+	// pipeline runs of
+	WatchNamespaces []string
+	// RegionNamespaces are the namespaces 'region status' and 'region list'
+	// query concurrently when --namespace is not given, with those matching
+	// RegionNamespaceSelector, e.g. one namespace per sector
+	RegionNamespaces        []string
+	RegionNamespaceSelector string
+	// WebhookSecret signs webhook payloads; it can also be read from
+	// WebhookSecretFile or the OS keychain, see GetWebhookSecret
+	WebhookSecret         string
+	WebhookSecretFile     string
+	WebhookSecretKeychain bool
+	// WebhookSecretKeychainAccount is the keychain account of the secret,
+	// KeychainAccount by default, e.g. one account per profile
+	WebhookSecretKeychainAccount string
+	// RetryAttempts, RetryInitialBackoff and RetryMaxBackoff configure the
+	// retries of HTTP requests that failed with a transient error
+	RetryAttempts       int
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	// Notify lists where the outcome of a pipeline run is posted when --wait
+	// completes
+	Notify []NotifyTarget
+	// EventsSink receives CloudEvents about submissions and the pipeline
+	// runs gcpctl waits for: an http(s) URL or pubsub://<project>/<topic>
+	EventsSink string
+	// History records the requests the webhook accepted in HistoryFile,
+	// ~/.gcpctl/history.jsonl by default
+	History     bool
+	HistoryFile string
+	// Proxy is the http, https or socks5 proxy of the HTTP clients, which
+	// use HTTPS_PROXY and NO_PROXY of the environment if it is empty.
+	// NoProxy lists the hosts reached without it.
+	Proxy   string
+	NoProxy string
+	// Headers are sent with every request to the webhook and the Tekton API,
+	// e.g. the credentials of an IAP proxy. $VAR and ${VAR} in values are
+	// replaced by environment variables.
+	Headers map[string]string
+}
+
+// NotifyTarget is a webhook notifications are posted to
+type NotifyTarget struct {
+	// Type is slack, google-chat or webhook
+	Type string `mapstructure:"type"`
+	URL  string `mapstructure:"url"`
 }
 
 var globalConfig *Config
 
 // Init initializes the configuration
 func Init() error {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath("$HOME/.gcpctl")
-	viper.AddConfigPath(".")
+	return InitFromFile("")
+}
+
+// InitFromFile initializes the configuration from the given config file, or
+// from config.yaml in ~/.gcpctl or the working directory if path is empty
+func InitFromFile(path string) error {
+	return Load(path, "")
+}
+
+// Load initializes the configuration from the given config file, as
+// InitFromFile does, with the settings of a profile of the file on top. The
+// profile is, in order, the given one, GCPCTL_PROFILE or current_profile of
+// the file. If the profile does not exist, the configuration is initialized
+// without it and the error wraps ErrUnknownProfile.
+func Load(path, profile string) error {
+	viper.Reset()
+	if path != "" {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName("config")
+		viper.SetConfigType("yaml")
+		viper.AddConfigPath("$HOME/.gcpctl")
+		viper.AddConfigPath(".")
+	}
 
 	// Set defaults
 	viper.SetDefault("tekton_url", "http://localhost:8080")
 	viper.SetDefault("tekton_dashboard_url", "")
 	viper.SetDefault("tekton_api_url", "http://localhost:8080")
 	viper.SetDefault("verbose", false)
+	viper.SetDefault("backend", "auto")
+	viper.SetDefault("kubeconfig", "")
+	viper.SetDefault("kube_context", "")
+	viper.SetDefault("output", "table")
+	viper.SetDefault("catalog_url", "")
+	viper.SetDefault("version_url", "")
+	viper.SetDefault("watch_namespaces", []string{"default"})
+	viper.SetDefault("region_namespaces", []string{})
+	viper.SetDefault("region_namespace_selector", "")
+	viper.SetDefault("webhook_secret", "")
+	viper.SetDefault("webhook_secret_file", "")
+	viper.SetDefault("webhook_secret_keychain", false)
+	viper.SetDefault("webhook_secret_keychain_account", KeychainAccount)
+	viper.SetDefault("retry_attempts", 4)
+	viper.SetDefault("retry_initial_backoff", 500*time.Millisecond)
+	viper.SetDefault("retry_max_backoff", 10*time.Second)
+	viper.SetDefault("events_sink", "")
+	viper.SetDefault("history", true)
+	viper.SetDefault("history_file", "")
+	viper.SetDefault("proxy", "")
+	viper.SetDefault("no_proxy", "")
 
 	// Environment variables
 	viper.SetEnvPrefix("GCPCTL")
@@ -42,14 +149,51 @@ func Init() error {
 		// Config file not found; using defaults
 	}
 
+	profile, profileErr := selectProfile(profile)
+
+	var notify []NotifyTarget
+	var notifyErr error
+	if err := viper.UnmarshalKey("notify", &notify); err != nil {
+		notifyErr = fmt.Errorf("invalid notify setting, must be a list of type and url: %w", err)
+	}
+
 	globalConfig = &Config{
+		Profile:            profile,
 		TektonURL:          viper.GetString("tekton_url"),
 		TektonDashboardURL: viper.GetString("tekton_dashboard_url"),
 		TektonAPIURL:       viper.GetString("tekton_api_url"),
 		Verbose:            viper.GetBool("verbose"),
+		Backend:            viper.GetString("backend"),
+		Kubeconfig:         viper.GetString("kubeconfig"),
+		KubeContext:        viper.GetString("kube_context"),
+		Output:             viper.GetString("output"),
+		CatalogURL:         viper.GetString("catalog_url"),
+		VersionURL:         viper.GetString("version_url"),
+		WatchNamespaces:    viper.GetStringSlice("watch_namespaces"),
+
+		RegionNamespaces:        viper.GetStringSlice("region_namespaces"),
+		RegionNamespaceSelector: viper.GetString("region_namespace_selector"),
+
+		WebhookSecret:         viper.GetString("webhook_secret"),
+		WebhookSecretFile:     viper.GetString("webhook_secret_file"),
+		WebhookSecretKeychain: viper.GetBool("webhook_secret_keychain"),
+
+		WebhookSecretKeychainAccount: viper.GetString("webhook_secret_keychain_account"),
+
+		RetryAttempts:       viper.GetInt("retry_attempts"),
+		RetryInitialBackoff: viper.GetDuration("retry_initial_backoff"),
+		RetryMaxBackoff:     viper.GetDuration("retry_max_backoff"),
+
+		Notify:      notify,
+		EventsSink:  viper.GetString("events_sink"),
+		History:     viper.GetBool("history"),
+		HistoryFile: viper.GetString("history_file"),
+		Proxy:       viper.GetString("proxy"),
+		NoProxy:     viper.GetString("no_proxy"),
+		Headers:     viper.GetStringMapString("headers"),
 	}
 
-	return nil
+	return errors.Join(profileErr, notifyErr)
 }
 
 // Get returns the global configuration
@@ -59,10 +203,19 @@ func Get() *Config {
 		if err := Init(); err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: failed to initialize config: %v\n", err)
 			globalConfig = &Config{
-				TektonURL:          "http://localhost:8080",
-				TektonDashboardURL: "",
-				TektonAPIURL:       "http://localhost:8080",
-				Verbose:            false,
+				TektonURL:           "http://localhost:8080",
+				TektonDashboardURL:  "",
+				TektonAPIURL:        "http://localhost:8080",
+				Verbose:             false,
+				Backend:             "auto",
+				Output:              "table",
+				RetryAttempts:       4,
+				RetryInitialBackoff: 500 * time.Millisecond,
+				RetryMaxBackoff:     10 * time.Second,
+				History:             true,
+				WatchNamespaces:     []string{"default"},
+
+				WebhookSecretKeychainAccount: KeychainAccount,
 			}
 		}
 	}
@@ -113,3 +266,142 @@ func GetTektonAPIURL() string {
 func SetTektonAPIURL(url string) {
 	Get().TektonAPIURL = url
 }
+
+// GetBackend returns the backend reading Tekton resources
+func GetBackend() string {
+	return Get().Backend
+}
+
+// SetBackend sets the backend reading Tekton resources
+func SetBackend(backend string) {
+	Get().Backend = backend
+}
+
+// GetKubeconfig returns the kubeconfig path of the kubeconfig backend
+func GetKubeconfig() string {
+	return Get().Kubeconfig
+}
+
+// SetKubeconfig sets the kubeconfig path of the kubeconfig backend
+func SetKubeconfig(path string) {
+	Get().Kubeconfig = path
+}
+
+// GetKubeContext returns the kubeconfig context of the kubeconfig backend
+func GetKubeContext() string {
+	return Get().KubeContext
+}
+
+// SetKubeContext sets the kubeconfig context of the kubeconfig backend
+func SetKubeContext(context string) {
+	Get().KubeContext = context
+}
+
+// GetOutput returns the output format of commands
+func GetOutput() string {
+	return Get().Output
+}
+
+// SetOutput sets the output format of commands
+func SetOutput(output string) {
+	Get().Output = output
+}
+
+// GetCatalogURL returns the URL or file of the region catalog
+func GetCatalogURL() string {
+	return Get().CatalogURL
+}
+
+// SetCatalogURL sets the URL or file of the region catalog
+func SetCatalogURL(url string) {
+	Get().CatalogURL = url
+}
+
+// GetVersionURL returns the version endpoint of the pipeline bundle
+func GetVersionURL() string {
+	return Get().VersionURL
+}
+
+// GetWatchNamespaces returns the namespaces watched by 'runs watch'. Entries
+// may be comma-separated, as in GCPCTL_WATCH_NAMESPACES.
+func GetWatchNamespaces() []string {
+	return splitNamespaces(Get().WatchNamespaces)
+}
+
+// GetRegionNamespaces returns the namespaces and the namespace label selector
+// the region commands query when no namespace is given. Entries may be
+// comma-separated, as in GCPCTL_REGION_NAMESPACES.
+func GetRegionNamespaces() (namespaces []string, selector string) {
+	cfg := Get()
+	return splitNamespaces(cfg.RegionNamespaces), cfg.RegionNamespaceSelector
+}
+
+// splitNamespaces splits comma-separated entries of a namespace list
+func splitNamespaces(entries []string) []string {
+	var namespaces []string
+	for _, entry := range entries {
+		for _, ns := range strings.Split(entry, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+	return namespaces
+}
+
+// GetRetry returns the number of attempts of HTTP requests that failed with a
+// transient error and the initial and maximum delay between them
+func GetRetry() (attempts int, initialBackoff, maxBackoff time.Duration) {
+	cfg := Get()
+	return cfg.RetryAttempts, cfg.RetryInitialBackoff, cfg.RetryMaxBackoff
+}
+
+// SetRetryAttempts sets the number of attempts of HTTP requests that failed
+// with a transient error
+func SetRetryAttempts(attempts int) {
+	Get().RetryAttempts = attempts
+}
+
+// GetNotify returns the targets the outcome of pipeline runs is posted to
+func GetNotify() []NotifyTarget {
+	return Get().Notify
+}
+
+// GetEventsSink returns where CloudEvents are published, empty if they are not
+func GetEventsSink() string {
+	return Get().EventsSink
+}
+
+// GetProxy returns the proxy of the HTTP clients, empty to use the one of
+// the environment, and the hosts reached without it
+func GetProxy() (proxy, noProxy string) {
+	cfg := Get()
+	return cfg.Proxy, cfg.NoProxy
+}
+
+// GetHeaders returns the headers sent to the webhook and the Tekton API, with
+// environment variables in their values expanded
+func GetHeaders() map[string]string {
+	headers := make(map[string]string, len(Get().Headers))
+	for name, value := range Get().Headers {
+		headers[name] = os.ExpandEnv(value)
+	}
+	return headers
+}
+
+// GetHistoryFile returns the file submissions are recorded in, empty if the
+// history is disabled
+func GetHistoryFile() string {
+	cfg := Get()
+	if !cfg.History {
+		return ""
+	}
+	if cfg.HistoryFile != "" {
+		return expandHome(cfg.HistoryFile)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gcpctl", history.FileName)
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGetHistoryFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{name: "default", config: "", want: filepath.Join(home, ".gcpctl", "history.jsonl")},
+		{name: "file", config: "history_file: ~/ops/gcpctl-history.jsonl\n", want: filepath.Join(home, "ops", "gcpctl-history.jsonl")},
+		{name: "disabled", config: "history: false\nhistory_file: /tmp/history.jsonl\n", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Load(writeConfig(t, tt.config), ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := GetHistoryFile(); got != tt.want {
+				t.Errorf("GetHistoryFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
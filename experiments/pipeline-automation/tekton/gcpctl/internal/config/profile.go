@@ -0,0 +1,208 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file keys of profiles
+const (
+	profilesKey       = "profiles"
+	currentProfileKey = "current_profile"
+)
+
+// ErrUnknownProfile is returned for a profile that is not in the config file
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// ProfileSettings are the settings a profile can set: where the management
+// cluster of an environment is, how to reach and authenticate to it and where
+// the outcome of its pipeline runs is posted. Other settings are shared by all
+// profiles.
+var ProfileSettings = []string{
+	"tekton_url",
+	"tekton_api_url",
+	"tekton_dashboard_url",
+	"backend",
+	"kubeconfig",
+	"kube_context",
+	"catalog_url",
+	"version_url",
+	"watch_namespaces",
+	"region_namespaces",
+	"region_namespace_selector",
+	"webhook_secret",
+	"webhook_secret_file",
+	"webhook_secret_keychain",
+	"webhook_secret_keychain_account",
+	"notify",
+	"events_sink",
+	"proxy",
+	"no_proxy",
+	"headers",
+}
+
+// selectProfile applies the settings of the selected profile over those of
+// the config file and returns its name. Environment variables keep
+// precedence over the profile. Profile names are case-insensitive.
+func selectProfile(name string) (string, error) {
+	if name == "" {
+		name = os.Getenv("GCPCTL_PROFILE")
+	}
+	if name == "" {
+		name = viper.GetString(currentProfileKey)
+	}
+	if name == "" {
+		return "", nil
+	}
+	name = strings.ToLower(name)
+
+	settings, err := profileSettings(name)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range settings {
+		if _, ok := os.LookupEnv("GCPCTL_" + strings.ToUpper(key)); ok {
+			continue
+		}
+		viper.Set(key, value)
+	}
+	return name, nil
+}
+
+// profileSettings returns the settings of a profile of the config file
+func profileSettings(name string) (map[string]any, error) {
+	profiles := viper.GetStringMap(profilesKey)
+	value, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, known profiles: %s", ErrUnknownProfile, name, listOrNone(Profiles()))
+	}
+
+	settings, ok := value.(map[string]any)
+	if !ok {
+		if value == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("profile %q must be a map of settings", name)
+	}
+	settings = maps.Clone(settings)
+	for key := range settings {
+		if !slices.Contains(ProfileSettings, key) {
+			return nil, fmt.Errorf("unknown setting %q in profile %q, must be one of %s", key, name, strings.Join(ProfileSettings, ", "))
+		}
+	}
+	return settings, nil
+}
+
+// Profiles returns the names of the profiles of the config file, sorted
+func Profiles() []string {
+	var names []string
+	for name := range viper.GetStringMap(profilesKey) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetProfileSettings returns the settings a profile of the config file sets
+func GetProfileSettings(name string) (map[string]any, error) {
+	return profileSettings(strings.ToLower(name))
+}
+
+// GetProfile returns the name of the active profile, empty without profiles
+func GetProfile() string {
+	return Get().Profile
+}
+
+// ConfigFile returns the config file that was read, empty if there was none
+func ConfigFile() string {
+	return viper.ConfigFileUsed()
+}
+
+// UseProfile makes a profile of the config file the current one by setting
+// current_profile in the file. Comments and the other settings of the file
+// are kept. It returns the path of the file.
+func UseProfile(name string) (string, error) {
+	path := ConfigFile()
+	if path == "" {
+		return "", fmt.Errorf("no config file found, create %s with a %s section", filepath.Join("~", ".gcpctl", "config.yaml"), profilesKey)
+	}
+	name = strings.ToLower(name)
+	if !slices.Contains(Profiles(), name) {
+		return "", fmt.Errorf("%w %q, known profiles: %s", ErrUnknownProfile, name, listOrNone(Profiles()))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	data, err = setTopLevelKey(data, currentProfileKey, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to update config file %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data, info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("failed to write config file: %w", err)
+	}
+	return path, nil
+}
+
+// setTopLevelKey sets a string key of a YAML mapping document, adding it at
+// the end if it is missing
+func setTopLevelKey(data []byte, key, value string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("the config file is not a YAML mapping")
+	}
+
+	valueNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == key {
+			valueNode.LineComment = root.Content[i+1].LineComment
+			root.Content[i+1] = valueNode
+			return marshalYAML(&doc)
+		}
+	}
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	root.Content = append(root.Content, keyNode, valueNode)
+	return marshalYAML(&doc)
+}
+
+func marshalYAML(doc *yaml.Node) ([]byte, error) {
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// listOrNone lists names, or "none" for an empty list
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const profilesConfig = `# Management clusters
+tekton_url: http://localhost:8080 # local webhook
+output: json
+
+profiles:
+  int:
+    tekton_url: https://el-int.example.com
+    tekton_api_url: https://api.int.example.com:6443
+  prod:
+    tekton_url: https://el-prod.example.com
+    kube_context: prod
+    webhook_secret_keychain: true
+    webhook_secret_keychain_account: webhook-secret-prod
+`
+
+// writeConfig writes a config file and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Profile(t *testing.T) {
+	path := writeConfig(t, profilesConfig+"current_profile: int\n")
+
+	tests := []struct {
+		name        string
+		profile     string
+		env         string
+		wantProfile string
+		wantURL     string
+	}{
+		{name: "current profile", wantProfile: "int", wantURL: "https://el-int.example.com"},
+		{name: "flag", profile: "prod", wantProfile: "prod", wantURL: "https://el-prod.example.com"},
+		{name: "case-insensitive", profile: "PROD", wantProfile: "prod", wantURL: "https://el-prod.example.com"},
+		{name: "environment", env: "prod", wantProfile: "prod", wantURL: "https://el-prod.example.com"},
+		{name: "flag over environment", profile: "int", env: "prod", wantProfile: "int", wantURL: "https://el-int.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GCPCTL_PROFILE", tt.env)
+			if err := Load(path, tt.profile); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			cfg := Get()
+			if cfg.Profile != tt.wantProfile || cfg.TektonURL != tt.wantURL {
+				t.Errorf("profile = %q, tekton_url = %q, want %q, %q", cfg.Profile, cfg.TektonURL, tt.wantProfile, tt.wantURL)
+			}
+			// Settings the profile does not set come from the file
+			if cfg.Output != "json" {
+				t.Errorf("output = %q, want json from the file", cfg.Output)
+			}
+		})
+	}
+}
+
+func TestLoad_ProfileAuth(t *testing.T) {
+	path := writeConfig(t, profilesConfig)
+	if err := Load(path, "prod"); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	cfg := Get()
+	if cfg.KubeContext != "prod" || !cfg.WebhookSecretKeychain || cfg.WebhookSecretKeychainAccount != "webhook-secret-prod" {
+		t.Errorf("config = %+v, want the auth settings of prod", cfg)
+	}
+}
+
+func TestLoad_EnvironmentOverridesProfile(t *testing.T) {
+	path := writeConfig(t, profilesConfig)
+	t.Setenv("GCPCTL_TEKTON_URL", "http://override:8080")
+	if err := Load(path, "int"); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg := Get(); cfg.TektonURL != "http://override:8080" || cfg.TektonAPIURL != "https://api.int.example.com:6443" {
+		t.Errorf("config = %+v, want the environment over the profile", cfg)
+	}
+}
+
+func TestLoad_WithoutProfiles(t *testing.T) {
+	path := writeConfig(t, "tekton_url: http://el.example.com\n")
+	if err := Load(path, ""); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg := Get(); cfg.Profile != "" || cfg.TektonURL != "http://el.example.com" {
+		t.Errorf("config = %+v", cfg)
+	}
+	if got := Profiles(); len(got) != 0 {
+		t.Errorf("Profiles() = %v, want none", got)
+	}
+}
+
+func TestLoad_UnknownProfile(t *testing.T) {
+	path := writeConfig(t, profilesConfig)
+	err := Load(path, "stage")
+	if !errors.Is(err, ErrUnknownProfile) || !strings.Contains(err.Error(), "int, prod") {
+		t.Fatalf("Load() error = %v, want ErrUnknownProfile listing the profiles", err)
+	}
+	// The configuration is still loaded, without a profile
+	if cfg := Get(); cfg.Profile != "" || cfg.TektonURL != "http://localhost:8080" {
+		t.Errorf("config = %+v, want the file without a profile", cfg)
+	}
+}
+
+func TestLoad_UnknownProfileSetting(t *testing.T) {
+	path := writeConfig(t, "profiles:\n  int:\n    tekton_ulr: https://el-int.example.com\n")
+	if err := Load(path, "int"); err == nil || !strings.Contains(err.Error(), `unknown setting "tekton_ulr"`) {
+		t.Errorf("Load() error = %v, want the unknown setting", err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	if err := Load(writeConfig(t, profilesConfig), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Profiles(), []string{"int", "prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Profiles() = %v, want %v", got, want)
+	}
+
+	settings, err := GetProfileSettings("int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings["tekton_url"] = "changed"
+	if again, _ := GetProfileSettings("int"); again["tekton_url"] != "https://el-int.example.com" {
+		t.Error("GetProfileSettings() returned the settings of the config, not a copy")
+	}
+}
+
+func TestUseProfile(t *testing.T) {
+	path := writeConfig(t, profilesConfig)
+	if err := Load(path, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UseProfile("prod"); err != nil {
+		t.Fatalf("UseProfile() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Management clusters", "# local webhook", "current_profile: prod"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config file does not contain %q:\n%s", want, data)
+		}
+	}
+
+	// Switching again replaces the current profile
+	if err := Load(path, ""); err != nil || GetProfile() != "prod" {
+		t.Fatalf("Load() = %v, profile %q, want prod", err, GetProfile())
+	}
+	if _, err := UseProfile("int"); err != nil {
+		t.Fatalf("UseProfile() error = %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	if strings.Count(string(data), "current_profile") != 1 || !strings.Contains(string(data), "current_profile: int") {
+		t.Errorf("config file:\n%s\nwant a single current_profile: int", data)
+	}
+
+	if _, err := UseProfile("stage"); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("UseProfile() error = %v, want ErrUnknownProfile", err)
+	}
+}
+
+func TestLoad_ProfileNotify(t *testing.T) {
+	path := writeConfig(t, `notify:
+  - type: webhook
+    url: https://ops.example.com/hooks/gcpctl
+profiles:
+  prod:
+    notify:
+      - type: slack
+        url: https://hooks.slack.com/services/T0/B0/x
+      - type: google-chat
+        url: https://chat.googleapis.com/v1/spaces/s/messages
+`)
+	if err := Load(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetNotify(); len(got) != 1 || got[0].Type != "webhook" {
+		t.Errorf("GetNotify() = %+v, want the webhook of the file", got)
+	}
+
+	if err := Load(path, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	want := []NotifyTarget{
+		{Type: "slack", URL: "https://hooks.slack.com/services/T0/B0/x"},
+		{Type: "google-chat", URL: "https://chat.googleapis.com/v1/spaces/s/messages"},
+	}
+	if got := GetNotify(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetNotify() = %+v, want the targets of prod %+v", got, want)
+	}
+}
+
+func TestLoad_ProfileEventsSink(t *testing.T) {
+	path := writeConfig(t, `events_sink: https://events.example.com/gcpctl
+profiles:
+  prod:
+    events_sink: pubsub://prod-project/region-rollouts
+`)
+	if err := Load(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetEventsSink(); got != "https://events.example.com/gcpctl" {
+		t.Errorf("GetEventsSink() = %q, want the sink of the file", got)
+	}
+
+	if err := Load(path, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if got := GetEventsSink(); got != "pubsub://prod-project/region-rollouts" {
+		t.Errorf("GetEventsSink() = %q, want the topic of prod", got)
+	}
+}
+
+func TestLoad_InvalidNotify(t *testing.T) {
+	path := writeConfig(t, "notify: https://hooks.slack.com/services/T0/B0/x\n")
+	if err := Load(path, ""); err == nil || !strings.Contains(err.Error(), "invalid notify setting") {
+		t.Errorf("Load() error = %v, want the invalid notify setting", err)
+	}
+}
+
+func TestLoad_ProfileTransport(t *testing.T) {
+	t.Setenv("IAP_TOKEN", "t0ken")
+	path := writeConfig(t, `proxy: http://proxy.corp.example.com:3128
+no_proxy: .internal.example.com
+profiles:
+  prod:
+    proxy: socks5://localhost:1080
+    headers:
+      Proxy-Authorization: Bearer ${IAP_TOKEN}
+      X-Team: hcp
+`)
+	if err := Load(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if proxy, noProxy := GetProxy(); proxy != "http://proxy.corp.example.com:3128" || noProxy != ".internal.example.com" {
+		t.Errorf("GetProxy() = %q, %q, want the proxy of the file", proxy, noProxy)
+	}
+	if got := GetHeaders(); len(got) != 0 {
+		t.Errorf("GetHeaders() = %v, want none", got)
+	}
+
+	if err := Load(path, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if proxy, _ := GetProxy(); proxy != "socks5://localhost:1080" {
+		t.Errorf("GetProxy() = %q, want the proxy of prod", proxy)
+	}
+	want := map[string]string{"proxy-authorization": "Bearer t0ken", "x-team": "hcp"}
+	if got := GetHeaders(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetHeaders() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Keychain entry of the webhook secret
+const (
+	KeychainService = "gcpctl"
+	KeychainAccount = "webhook-secret"
+)
+
+// keychainLookup reads a secret from the OS keychain with the keychain CLI
+// of the platform
+func keychainLookup(service, account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	default:
+		return "", fmt.Errorf("no keychain support on %s", runtime.GOOS)
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%s failed: %s", cmd.Args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("failed to run %s: %w", cmd.Args[0], err)
+	}
+	return string(output), nil
+}
+
+// GetWebhookSecret returns the secret signing webhook payloads, empty if
+// payloads are not signed. It is read, in order, from webhook_secret
+// (GCPCTL_WEBHOOK_SECRET), the file webhook_secret_file, or the OS keychain
+// if webhook_secret_keychain is set: the macOS login keychain or the Secret
+// Service on Linux, under service KeychainService and account
+// webhook_secret_keychain_account, KeychainAccount by default.
+// Surrounding whitespace is trimmed.
+func GetWebhookSecret() (string, error) {
+	cfg := Get()
+
+	switch {
+	case cfg.WebhookSecret != "":
+		return strings.TrimSpace(cfg.WebhookSecret), nil
+	case cfg.WebhookSecretFile != "":
+		data, err := os.ReadFile(expandHome(cfg.WebhookSecretFile))
+		if err != nil {
+			return "", fmt.Errorf("failed to read webhook secret: %w", err)
+		}
+		return nonEmptySecret(string(data), cfg.WebhookSecretFile)
+	case cfg.WebhookSecretKeychain:
+		account := cfg.WebhookSecretKeychainAccount
+		if account == "" {
+			account = KeychainAccount
+		}
+		secret, err := keychainLookup(KeychainService, account)
+		if err != nil {
+			return "", fmt.Errorf("failed to read webhook secret from the keychain: %w", err)
+		}
+		return nonEmptySecret(secret, "the keychain")
+	default:
+		return "", nil
+	}
+}
+
+// expandHome replaces a leading ~/ of path with the home directory
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// nonEmptySecret trims a secret read from source and rejects an empty one,
+// which would silently turn signing off
+func nonEmptySecret(secret, source string) (string, error) {
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return "", fmt.Errorf("webhook secret in %s is empty", source)
+	}
+	return secret, nil
+}
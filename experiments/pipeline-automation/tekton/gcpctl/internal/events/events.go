@@ -0,0 +1,231 @@
+// Package events publishes CloudEvents about the requests gcpctl submits and
+// the pipeline runs it waits for, so other automation, e.g. an inventory or
+// change management, can follow region rollouts without polling Tekton.
+//
+// Events are sent in the structured content mode of CloudEvents 1.0: the
+// event is the JSON body of an HTTP POST, or the data of a Pub/Sub message
+// with a content-type attribute.
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// Event types
+const (
+	typePrefix = "io.openshift.gcp-hcp.gcpctl."
+	// TypeSubmissionCreated is sent when the webhook accepted a request
+	TypeSubmissionCreated = typePrefix + "submission.created"
+	// TypeRunStarted is sent when the pipeline run of a request is first seen
+	TypeRunStarted = typePrefix + "pipelinerun.started"
+	// TypeRunFinished is sent when the pipeline run of a request finished
+	TypeRunFinished = typePrefix + "pipelinerun.finished"
+)
+
+const (
+	// SpecVersion is the CloudEvents version of the events
+	SpecVersion = "1.0"
+	// ContentType is the media type of an event in the structured mode
+	ContentType = "application/cloudevents+json"
+)
+
+// pubsubScheme starts a Pub/Sub sink, pubsub://<project>/<topic>
+const pubsubScheme = "pubsub://"
+
+// sendTimeout bounds publishing one event
+const sendTimeout = 10 * time.Second
+
+// CloudEvent is an event in the JSON format of CloudEvents 1.0
+type CloudEvent struct {
+	SpecVersion string `json:"specversion"`
+	// ID is unique for the source: the webhook event ID and the type, so a
+	// subscriber can drop the duplicates of retried deliveries
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	// Subject names what the request is about, e.g.
+	// production/us-central1/main
+	Subject         string `json:"subject,omitempty"`
+	Time            string `json:"time"`
+	DataContentType string `json:"datacontenttype"`
+	Data            Data   `json:"data"`
+}
+
+// Data is the payload of an event
+type Data struct {
+	// Operation is the operation of the request, e.g. region add
+	Operation string            `json:"operation"`
+	Request   map[string]string `json:"request,omitempty"`
+	Profile   string            `json:"profile,omitempty"`
+	// EventID is the event ID of the webhook response, which the pipeline
+	// run is labeled with
+	EventID   string `json:"eventID,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+
+	PipelineRun string `json:"pipelineRun,omitempty"`
+	// Status is the status of the pipeline run, e.g. Running or Succeeded
+	Status string `json:"status,omitempty"`
+	// State is the outcome of a finished run for the operation, e.g.
+	// Provisioned
+	State           string `json:"state,omitempty"`
+	DurationSeconds int64  `json:"durationSeconds,omitempty"`
+	Message         string `json:"message,omitempty"`
+	DashboardURL    string `json:"dashboardURL,omitempty"`
+}
+
+// SetRun fills in the pipeline run of the data
+func (d *Data) SetRun(run *api.PipelineRunStatus) {
+	d.PipelineRun = run.Name
+	if run.Namespace != "" {
+		d.Namespace = run.Namespace
+	}
+	d.Status = run.Status
+	d.Message = run.Message
+	d.DashboardURL = run.DashboardURL
+	if start, err := time.Parse(time.RFC3339, run.StartTime); err == nil {
+		if end, err := time.Parse(time.RFC3339, run.CompletionTime); err == nil {
+			d.DurationSeconds = int64(end.Sub(start).Seconds())
+		}
+	}
+}
+
+// New builds an event of the given type. Events of a request without a
+// webhook event ID get a random ID.
+func New(typ, source, subject string, data Data, now time.Time) CloudEvent {
+	id := data.EventID
+	if id == "" {
+		id = randomID()
+	}
+	return CloudEvent{
+		SpecVersion:     SpecVersion,
+		ID:              id + "/" + strings.TrimPrefix(typ, typePrefix),
+		Source:          source,
+		Type:            typ,
+		Subject:         subject,
+		Time:            now.UTC().Format(time.RFC3339),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Publisher sends events to a sink
+type Publisher struct {
+	// Sink is an http(s) URL the events are posted to, or a Pub/Sub topic
+	// as pubsub://<project>/<topic>
+	Sink string
+	// Source is the source of the events, e.g. the webhook URL
+	Source string
+
+	hc *http.Client
+	// publish sends a Pub/Sub message. Tests replace it.
+	publish func(ctx context.Context, project, topic string, message []byte) error
+}
+
+// NewPublisher returns a publisher to a sink. hc sends the events of an
+// HTTP sink, http.DefaultClient if nil.
+func NewPublisher(sink, source string, hc *http.Client) (*Publisher, error) {
+	if err := Validate(sink); err != nil {
+		return nil, err
+	}
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Publisher{Sink: sink, Source: source, hc: hc, publish: gcloudPublish}, nil
+}
+
+// Validate checks an events sink
+func Validate(sink string) error {
+	if topic, ok := strings.CutPrefix(sink, pubsubScheme); ok {
+		project, name, _ := strings.Cut(topic, "/")
+		if project == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid events sink %q: want pubsub://<project>/<topic>", sink)
+		}
+		return nil
+	}
+	if !strings.HasPrefix(sink, "http://") && !strings.HasPrefix(sink, "https://") {
+		return errors.New("invalid events sink: must be an http(s) URL or pubsub://<project>/<topic>")
+	}
+	return nil
+}
+
+// Publish sends an event to the sink. The URL of an HTTP sink is left out of
+// errors as it may carry a token.
+func (p *Publisher) Publish(ctx context.Context, e CloudEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	if topic, ok := strings.CutPrefix(p.Sink, pubsubScheme); ok {
+		project, name, _ := strings.Cut(topic, "/")
+		if err := p.publish(ctx, project, name, body); err != nil {
+			return fmt.Errorf("failed to publish %s to %s: %w", e.Type, p.Sink, err)
+		}
+		return nil
+	}
+	if err := p.post(ctx, body); err != nil {
+		return fmt.Errorf("failed to post %s: %w", e.Type, err)
+	}
+	return nil
+}
+
+func (p *Publisher) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Sink, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid url")
+	}
+	req.Header.Set("Content-Type", ContentType)
+
+	resp, err := p.hc.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// gcloudPublish publishes a structured event to a Pub/Sub topic with gcloud
+// pubsub topics publish
+func gcloudPublish(ctx context.Context, project, topic string, message []byte) error {
+	cmd := exec.CommandContext(ctx, "gcloud", "pubsub", "topics", "publish", topic,
+		"--project", project,
+		"--message", string(message),
+		"--attribute", "content-type="+ContentType)
+	if _, err := cmd.Output(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("gcloud command failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return fmt.Errorf("failed to execute gcloud: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+var now = time.Date(2025, 1, 15, 10, 3, 40, 0, time.UTC)
+
+func finishedEvent() CloudEvent {
+	data := Data{
+		Operation: "region add",
+		Request:   map[string]string{"environment": "production", "region": "us-central1", "sector": "main"},
+		Profile:   "prod",
+		EventID:   "5f2c9a",
+		Namespace: "default",
+	}
+	data.SetRun(&api.PipelineRunStatus{
+		Name:           "gcp-region-provision-jf8v5",
+		Status:         "Succeeded",
+		StartTime:      "2025-01-15T10:00:00Z",
+		CompletionTime: "2025-01-15T10:03:32Z",
+	})
+	data.State = "Provisioned"
+	return New(TypeRunFinished, "https://el-gcp-hcp.example.com", "production/us-central1/main", data, now)
+}
+
+func TestNew(t *testing.T) {
+	e := finishedEvent()
+	if e.ID != "5f2c9a/pipelinerun.finished" || e.SpecVersion != "1.0" || e.Time != "2025-01-15T10:03:40Z" {
+		t.Errorf("New() = %+v", e)
+	}
+	if e.Data.DurationSeconds != 212 || e.Data.PipelineRun != "gcp-region-provision-jf8v5" || e.Data.Namespace != "default" {
+		t.Errorf("New() data = %+v", e.Data)
+	}
+
+	a := New(TypeSubmissionCreated, "gcpctl", "", Data{Operation: "region add"}, now)
+	b := New(TypeSubmissionCreated, "gcpctl", "", Data{Operation: "region add"}, now)
+	if a.ID == b.ID {
+		t.Errorf("events without an event ID share the ID %s", a.ID)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		sink    string
+		wantErr bool
+	}{
+		{sink: "https://events.example.com/gcpctl"},
+		{sink: "http://localhost:8080"},
+		{sink: "pubsub://my-project/region-rollouts"},
+		{sink: "pubsub://my-project", wantErr: true},
+		{sink: "pubsub://my-project/topics/region-rollouts", wantErr: true},
+		{sink: "events.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.sink); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q) error = %v, wantErr %v", tt.sink, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPublisher_HTTP(t *testing.T) {
+	var got map[string]any
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "no such sink", http.StatusNotFound)
+			return
+		}
+		contentType = r.Header.Get("Content-Type")
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Errorf("invalid JSON %s", data)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewPublisher(server.URL+"/events", "gcpctl", server.Client())
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	if err := p.Publish(context.Background(), finishedEvent()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if contentType != ContentType {
+		t.Errorf("Content-Type = %q, want %q", contentType, ContentType)
+	}
+	data, _ := got["data"].(map[string]any)
+	if got["type"] != TypeRunFinished || got["subject"] != "production/us-central1/main" || data["state"] != "Provisioned" {
+		t.Errorf("posted event = %v", got)
+	}
+
+	broken, _ := NewPublisher(server.URL+"/broken", "gcpctl", server.Client())
+	err = broken.Publish(context.Background(), finishedEvent())
+	if err == nil || !strings.Contains(err.Error(), "unexpected status code 404") {
+		t.Errorf("Publish() error = %v, want the status code", err)
+	}
+	if strings.Contains(err.Error(), server.URL) {
+		t.Errorf("Publish() error = %v contains the sink URL", err)
+	}
+}
+
+func TestPublisher_PubSub(t *testing.T) {
+	p, err := NewPublisher("pubsub://my-project/region-rollouts", "gcpctl", nil)
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	var project, topic string
+	var message CloudEvent
+	p.publish = func(ctx context.Context, pr, tp string, data []byte) error {
+		project, topic = pr, tp
+		return json.Unmarshal(data, &message)
+	}
+	if err := p.Publish(context.Background(), finishedEvent()); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if project != "my-project" || topic != "region-rollouts" || message.Type != TypeRunFinished {
+		t.Errorf("published %+v to %s/%s", message, project, topic)
+	}
+
+	p.publish = func(context.Context, string, string, []byte) error { return errors.New("NOT_FOUND: topic") }
+	if err := p.Publish(context.Background(), finishedEvent()); err == nil || !strings.Contains(err.Error(), "NOT_FOUND") {
+		t.Errorf("Publish() error = %v, want the gcloud error", err)
+	}
+}
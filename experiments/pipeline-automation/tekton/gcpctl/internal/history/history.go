@@ -0,0 +1,163 @@
+// Package history is the local ledger of the requests gcpctl submitted, so
+// the event ID of a submission can be found again without copying it around.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the ledger file in the gcpctl config directory
+const FileName = "history.jsonl"
+
+// ErrNoEntries is returned when the ledger has no matching submission
+var ErrNoEntries = errors.New("no submissions in the history")
+
+// Entry is a request the webhook accepted
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Operation is the operation of the request, e.g. region add
+	Operation string `json:"operation"`
+	// Subject names what the request is about, e.g.
+	// production/us-central1/main
+	Subject string            `json:"subject,omitempty"`
+	Request map[string]string `json:"request,omitempty"`
+	// Params are the extra pipeline parameters sent with the request
+	Params map[string]any `json:"params,omitempty"`
+	// Profile is the profile the request was sent with, empty without
+	// profiles
+	Profile       string `json:"profile,omitempty"`
+	EventID       string `json:"eventID,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	EventListener string `json:"eventListener,omitempty"`
+}
+
+// Filter selects entries of the ledger
+type Filter struct {
+	// Profile only selects entries of this profile if set
+	Profile string
+	// AnyProfile selects entries of every profile, ignoring Profile
+	AnyProfile bool
+	// Operation only selects entries of this operation if set
+	Operation string
+	// Limit is the number of entries returned, 0 for all
+	Limit int
+}
+
+func (f Filter) match(e Entry) bool {
+	if !f.AnyProfile && e.Profile != f.Profile {
+		return false
+	}
+	return f.Operation == "" || e.Operation == f.Operation
+}
+
+// Ledger is a file of entries, one JSON object per line, oldest first.
+// Appending a line keeps earlier entries intact if gcpctl is interrupted.
+type Ledger struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open returns the ledger of a file; the file is created by the first Append
+func Open(path string) *Ledger {
+	return &Ledger{path: path}
+}
+
+// Path returns the file of the ledger
+func (l *Ledger) Path() string {
+	return l.path
+}
+
+// Append adds an entry to the ledger. It is safe for concurrent use.
+func (l *Ledger) Append(e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// List returns the entries matching a filter, newest first. Lines that cannot
+// be parsed, e.g. one cut short by a full disk, are skipped.
+func (l *Ledger) List(filter Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if filter.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", l.path, err)
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// Latest returns the newest entry matching a filter with an event ID
+func (l *Ledger) Latest(filter Filter) (*Entry, error) {
+	filter.Limit = 0
+	entries, err := l.List(filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.EventID != "" {
+			return &e, nil
+		}
+	}
+	return nil, ErrNoEntries
+}
+
+// Clear removes every entry of the ledger
+func (l *Ledger) Clear() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func entry(i int, profile, operation string) Entry {
+	return Entry{
+		Time:      time.Date(2025, 1, 15, 10, i, 0, 0, time.UTC),
+		Operation: operation,
+		Profile:   profile,
+		EventID:   fmt.Sprintf("event-%d", i),
+		Namespace: "default",
+	}
+}
+
+func TestLedger_AppendList(t *testing.T) {
+	l := Open(filepath.Join(t.TempDir(), "gcpctl", FileName))
+
+	if entries, err := l.List(Filter{}); err != nil || len(entries) != 0 {
+		t.Fatalf("List() of a missing file = %v, %v, want none", entries, err)
+	}
+
+	for i, e := range []Entry{
+		entry(0, "int", "region add"),
+		entry(1, "prod", "region add"),
+		entry(2, "int", "sector add"),
+		entry(3, "int", "region add"),
+	} {
+		if err := l.Append(e); err != nil {
+			t.Fatalf("Append(%d) error = %v", i, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "profile", filter: Filter{Profile: "int"}, want: []string{"event-3", "event-2", "event-0"}},
+		{name: "any profile", filter: Filter{AnyProfile: true}, want: []string{"event-3", "event-2", "event-1", "event-0"}},
+		{name: "operation", filter: Filter{Profile: "int", Operation: "region add"}, want: []string{"event-3", "event-0"}},
+		{name: "limit", filter: Filter{AnyProfile: true, Limit: 2}, want: []string{"event-3", "event-2"}},
+		{name: "no profile", filter: Filter{}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := l.List(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.EventID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLedger_SkipsCorruptLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	l := Open(path)
+	if err := l.Append(entry(0, "", "region add")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time": "2025-01-15T10:01:00Z", "operat` + "\n")
+	f.Close()
+	if err := l.Append(entry(2, "", "region add")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := l.List(Filter{})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("List() = %v, %v, want the 2 valid entries", entries, err)
+	}
+}
+
+func TestLedger_Latest(t *testing.T) {
+	l := Open(filepath.Join(t.TempDir(), FileName))
+	if _, err := l.Latest(Filter{}); !errors.Is(err, ErrNoEntries) {
+		t.Errorf("Latest() of an empty ledger error = %v, want ErrNoEntries", err)
+	}
+
+	l.Append(entry(0, "", "region add"))
+	noEvent := entry(1, "", "region add")
+	noEvent.EventID = ""
+	l.Append(noEvent)
+
+	latest, err := l.Latest(Filter{})
+	if err != nil || latest.EventID != "event-0" {
+		t.Errorf("Latest() = %+v, %v, want the newest entry with an event ID", latest, err)
+	}
+
+	if err := l.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Latest(Filter{}); !errors.Is(err, ErrNoEntries) {
+		t.Errorf("Latest() after Clear() error = %v, want ErrNoEntries", err)
+	}
+}
+
+func TestLedger_ConcurrentAppend(t *testing.T) {
+	l := Open(filepath.Join(t.TempDir(), FileName))
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Append(entry(i, "", "region add")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := l.List(Filter{})
+	if err != nil || len(entries) != 20 {
+		t.Errorf("List() = %d entries, %v, want 20", len(entries), err)
+	}
+}
@@ -0,0 +1,759 @@
+// Package mockserver is a fake Tekton event listener and Tekton API for demos
+// and tests of gcpctl without a cluster. Requests posted to the event listener
+// create pipeline runs that progress through the scenarios of a Script, and
+// the pipeline runs, their TaskRuns and the logs of their pods are served as
+// the Kubernetes API server does, see 'gcpctl mock-server'.
+package mockserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Defaults of Options
+const (
+	DefaultNamespace     = "default"
+	DefaultEventListener = "gcpctl-mock"
+)
+
+// logPollInterval is how often followed logs look for new lines
+const logPollInterval = 200 * time.Millisecond
+
+// cancelStatuses are the values of spec.status cancelling a pipeline run
+var cancelStatuses = []string{"Cancelled", "CancelledRunFinally", "StoppedRunFinally"}
+
+// Options configure a Server
+type Options struct {
+	// Script drives the pipeline runs, DefaultScript if nil
+	Script *Script
+	// Namespace is the namespace of the event listener and of the pipeline
+	// runs it creates, DefaultNamespace if empty
+	Namespace string
+	// EventListener is the name the event listener answers with,
+	// DefaultEventListener if empty
+	EventListener string
+	// Pipelines are the pipelines the event listener starts by route, the
+	// path a payload is posted to without its slashes; "" is the root.
+	// Payloads posted to other routes are rejected.
+	Pipelines map[string]string
+	// APIVersion is the tekton.dev version served, client.TektonV1 if empty
+	APIVersion string
+	// WebhookSecret makes the event listener reject payloads that are not
+	// signed with it; empty accepts every payload
+	WebhookSecret string
+	// Denied are the actions the access reviews deny, as "verb resource",
+	// e.g. "delete pipelineruns"; every other action is allowed
+	Denied []string
+	// Log receives a line for every pipeline run created, cancelled or
+	// deleted; nil logs nothing
+	Log io.Writer
+}
+
+// Server is the mock event listener and Tekton API, an http.Handler
+type Server struct {
+	opts   Options
+	script *Script
+	mux    *http.ServeMux
+	uid    string
+	// now is the clock of the pipeline runs, replaced in tests
+	now func() time.Time
+
+	mu   sync.Mutex
+	runs []*run
+}
+
+// New creates a mock server
+func New(opts Options) (*Server, error) {
+	if opts.Script == nil {
+		opts.Script = DefaultScript()
+	}
+	if err := opts.Script.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultNamespace
+	}
+	if opts.EventListener == "" {
+		opts.EventListener = DefaultEventListener
+	}
+	switch opts.APIVersion {
+	case "":
+		opts.APIVersion = client.TektonV1
+	case client.TektonV1, client.TektonV1beta1:
+	default:
+		return nil, fmt.Errorf("unknown tekton.dev version %q, must be %s or %s", opts.APIVersion, client.TektonV1, client.TektonV1beta1)
+	}
+
+	s := &Server{opts: opts, script: opts.Script, uid: randomUID(), now: time.Now}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST /", s.handleEvent)
+	s.mux.HandleFunc("GET /apis/tekton.dev", s.handleDiscovery)
+	s.mux.HandleFunc("GET /apis/tekton.dev/{version}/namespaces/{namespace}/pipelineruns", s.handleListPipelineRuns)
+	s.mux.HandleFunc("POST /apis/tekton.dev/{version}/namespaces/{namespace}/pipelineruns", s.handleCreatePipelineRun)
+	s.mux.HandleFunc("GET /apis/tekton.dev/{version}/namespaces/{namespace}/pipelineruns/{name}", s.handleGetPipelineRun)
+	s.mux.HandleFunc("PATCH /apis/tekton.dev/{version}/namespaces/{namespace}/pipelineruns/{name}", s.handlePatchPipelineRun)
+	s.mux.HandleFunc("DELETE /apis/tekton.dev/{version}/namespaces/{namespace}/pipelineruns/{name}", s.handleDeletePipelineRun)
+	s.mux.HandleFunc("GET /apis/tekton.dev/{version}/namespaces/{namespace}/taskruns", s.handleListTaskRuns)
+	s.mux.HandleFunc("GET /api/v1/namespaces", s.handleListNamespaces)
+	s.mux.HandleFunc("GET /api/v1/namespaces/{namespace}/pods/{pod}/log", s.handlePodLogs)
+	s.mux.HandleFunc("POST /apis/authorization.k8s.io/v1/selfsubjectaccessreviews", s.handleAccessReview)
+	return s, nil
+}
+
+// ServeHTTP serves the event listener on every path, except the API paths of
+// Kubernetes, /api and /apis
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// GET patterns match HEAD requests too, so the probes of routes are not
+	// left to the mux
+	if r.Method == http.MethodHead {
+		s.handleProbe(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// handleEvent creates a pipeline run of the pipeline of the route, with the
+// fields of the payload as parameters, and answers as a Tekton Triggers
+// event listener does
+func (s *Server) handleEvent(w http.ResponseWriter, r *http.Request) {
+	route := strings.Trim(r.URL.Path, "/")
+	pipeline, ok := s.opts.Pipelines[route]
+	if !ok {
+		http.Error(w, fmt.Sprintf("no trigger of event listener %s handles route %q", s.opts.EventListener, route), http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read payload", http.StatusBadRequest)
+		return
+	}
+	if s.opts.WebhookSecret != "" {
+		want := client.SignPayload([]byte(s.opts.WebhookSecret), body)
+		if !hmac.Equal([]byte(r.Header.Get(client.SignatureHeader)), []byte(want)) {
+			http.Error(w, "payload signature does not match", http.StatusForbidden)
+			return
+		}
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "payload must be a JSON object: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	eventID := randomUID()
+	trigger := route
+	if trigger == "" {
+		trigger = "default"
+	}
+	obj := map[string]any{
+		"metadata": map[string]any{
+			"generateName": strings.TrimSuffix(pipeline, "-pipeline") + "-run-",
+			"labels": map[string]any{
+				"triggers.tekton.dev/eventlistener":    s.opts.EventListener,
+				"triggers.tekton.dev/trigger":          trigger,
+				"triggers.tekton.dev/triggers-eventid": eventID,
+			},
+		},
+		"spec": map[string]any{
+			"pipelineRef": map[string]any{"name": pipeline},
+			"params":      payloadParams(payload),
+		},
+	}
+
+	s.mu.Lock()
+	created := s.addRun(s.opts.Namespace, obj)
+	s.mu.Unlock()
+	s.logf("event %s: created pipeline run %s/%s of %s, scenario %s", eventID, created.namespace(), created.name(), pipeline, s.script.scenarioName(created.scenario))
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"eventListener":    s.opts.EventListener,
+		"namespace":        s.opts.Namespace,
+		"eventListenerUID": s.uid,
+		"eventID":          eventID,
+	})
+}
+
+// handleProbe answers a request without payload to a route, as sent by
+// 'gcpctl preflight': 404 for routes no trigger handles
+func (s *Server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.opts.Pipelines[strings.Trim(r.URL.Path, "/")]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleAccessReview answers a SelfSubjectAccessReview, allowing every
+// action but the Denied ones
+func (s *Server) handleAccessReview(w http.ResponseWriter, r *http.Request) {
+	var review map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", fmt.Sprintf("failed to parse access review: %v", err), nil)
+		return
+	}
+	spec, _ := review["spec"].(map[string]any)
+	attrs, _ := spec["resourceAttributes"].(map[string]any)
+	verb, _ := attrs["verb"].(string)
+	resource, _ := attrs["resource"].(string)
+	if sub, _ := attrs["subresource"].(string); sub != "" {
+		resource += "/" + sub
+	}
+
+	status := map[string]any{"allowed": true, "reason": "the mock server allows " + verb + " " + resource}
+	if slices.Contains(s.opts.Denied, verb+" "+resource) {
+		status = map[string]any{"allowed": false, "reason": "the mock server denies " + verb + " " + resource}
+	}
+	review["status"] = status
+	writeJSON(w, http.StatusCreated, review)
+}
+
+// handleDiscovery lists the served version of the tekton.dev API group
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	groupVersion := map[string]any{"groupVersion": "tekton.dev/" + s.opts.APIVersion, "version": s.opts.APIVersion}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"kind":             "APIGroup",
+		"apiVersion":       "v1",
+		"name":             "tekton.dev",
+		"versions":         []any{groupVersion},
+		"preferredVersion": groupVersion,
+	})
+}
+
+func (s *Server) handleListPipelineRuns(w http.ResponseWriter, r *http.Request) {
+	selector, ok := s.checkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	items := []any{}
+	for _, run := range s.runs {
+		if run.namespace() == r.PathValue("namespace") && selector.Matches(objectLabels(run.obj)) {
+			items = append(items, s.renderPipelineRun(run, now))
+		}
+	}
+	s.writeList(w, "PipelineRunList", items)
+}
+
+func (s *Server) handleGetPipelineRun(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.checkRequest(w, r); !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run := s.lookup(w, r)
+	if run == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.renderPipelineRun(run, s.now()))
+}
+
+// handleCreatePipelineRun creates a pipeline run from an object, as 'gcpctl
+// runs retry' does. It follows the scenario of its parameters.
+func (s *Server) handleCreatePipelineRun(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.checkRequest(w, r); !ok {
+		return
+	}
+	var obj map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", "invalid pipeline run: "+err.Error(), nil)
+		return
+	}
+	metadata, _ := obj["metadata"].(map[string]any)
+	if metadata == nil || (metadata["name"] == nil && metadata["generateName"] == nil) {
+		writeStatus(w, http.StatusUnprocessableEntity, "Invalid", "metadata.name or metadata.generateName is required", nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name, _ := metadata["name"].(string); name != "" && s.find(r.PathValue("namespace"), name) != nil {
+		writeStatus(w, http.StatusConflict, "AlreadyExists", fmt.Sprintf("pipelineruns.tekton.dev %q already exists", name), nil)
+		return
+	}
+	created := s.addRun(r.PathValue("namespace"), obj)
+	s.logf("created pipeline run %s/%s, scenario %s", created.namespace(), created.name(), s.script.scenarioName(created.scenario))
+	writeJSON(w, http.StatusCreated, s.renderPipelineRun(created, s.now()))
+}
+
+// handlePatchPipelineRun applies a JSON merge patch to the metadata and spec
+// of a pipeline run. Setting spec.status to Cancelled, CancelledRunFinally or
+// StoppedRunFinally cancels it.
+func (s *Server) handlePatchPipelineRun(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.checkRequest(w, r); !ok {
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/merge-patch+json" {
+		writeStatus(w, http.StatusUnsupportedMediaType, "UnsupportedMediaType", fmt.Sprintf("the mock server only supports merge patches, not %q", ct), nil)
+		return
+	}
+	var patch map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", "invalid patch: "+err.Error(), nil)
+		return
+	}
+	// The status is a subresource, and the identity of a run is immutable
+	delete(patch, "status")
+	if metadata, ok := patch["metadata"].(map[string]any); ok {
+		delete(metadata, "name")
+		delete(metadata, "namespace")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run := s.lookup(w, r)
+	if run == nil {
+		return
+	}
+	run.obj = mergePatch(run.obj, patch).(map[string]any)
+
+	now := s.now()
+	if slices.Contains(cancelStatuses, nestedString(run.obj, "spec", "status")) && run.cancelled.IsZero() && !run.state(now).done() {
+		run.cancelled = now
+		s.logf("cancelled pipeline run %s/%s", run.namespace(), run.name())
+	}
+	writeJSON(w, http.StatusOK, s.renderPipelineRun(run, now))
+}
+
+func (s *Server) handleDeletePipelineRun(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.checkRequest(w, r); !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := s.lookup(w, r)
+	if deleted == nil {
+		return
+	}
+	s.runs = slices.DeleteFunc(s.runs, func(other *run) bool { return other == deleted })
+	s.logf("deleted pipeline run %s/%s", deleted.namespace(), deleted.name())
+	writeJSON(w, http.StatusOK, s.renderPipelineRun(deleted, s.now()))
+}
+
+// handleListTaskRuns lists the TaskRuns of the tasks that started
+func (s *Server) handleListTaskRuns(w http.ResponseWriter, r *http.Request) {
+	selector, ok := s.checkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	items := []any{}
+	for _, run := range s.runs {
+		if run.namespace() != r.PathValue("namespace") {
+			continue
+		}
+		for _, ts := range run.state(now).tasks {
+			tr := s.renderTaskRun(run, ts)
+			if selector.Matches(objectLabels(tr)) {
+				items = append(items, tr)
+			}
+		}
+	}
+	s.writeList(w, "TaskRunList", items)
+}
+
+// handleListNamespaces lists the namespace of the event listener and those
+// of the pipeline runs, with the name label Kubernetes sets
+func (s *Server) handleListNamespaces(w http.ResponseWriter, r *http.Request) {
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	names := []string{s.opts.Namespace}
+	for _, run := range s.runs {
+		if !slices.Contains(names, run.namespace()) {
+			names = append(names, run.namespace())
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	items := []any{}
+	for _, name := range names {
+		nsLabels := map[string]any{"kubernetes.io/metadata.name": name}
+		if selector.Matches(labelSet(nsLabels)) {
+			items = append(items, map[string]any{"metadata": map[string]any{"name": name, "labels": nsLabels}})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"kind": "NamespaceList", "apiVersion": "v1", "metadata": map[string]any{}, "items": items})
+}
+
+// handlePodLogs writes the log lines of the task of a pod. With follow=true
+// the request stays open until the task finished.
+func (s *Server) handlePodLogs(w http.ResponseWriter, r *http.Request) {
+	namespace, pod := r.PathValue("namespace"), r.PathValue("pod")
+	follow := r.URL.Query().Get("follow") == "true"
+
+	written := 0
+	for {
+		s.mu.Lock()
+		ts, found := s.podTask(namespace, pod, s.now())
+		var lines []string
+		if found {
+			lines = ts.logs(s.now())
+		}
+		s.mu.Unlock()
+
+		if !found {
+			if written == 0 {
+				writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("pods %q not found", pod), map[string]any{"name": pod, "kind": "pods"})
+			}
+			return
+		}
+		if written == 0 {
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		for _, line := range lines[written:] {
+			fmt.Fprintln(w, line)
+		}
+		written = len(lines)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		if !follow || !ts.end.IsZero() {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// checkRequest answers with the error of a request for a tekton.dev version
+// the server does not serve, or with an invalid label selector, and returns
+// the label selector of the request
+func (s *Server) checkRequest(w http.ResponseWriter, r *http.Request) (labels.Selector, bool) {
+	if r.PathValue("version") != s.opts.APIVersion {
+		// The API server does not name an object for versions it does not serve
+		writeStatus(w, http.StatusNotFound, "NotFound", "the server could not find the requested resource", nil)
+		return nil, false
+	}
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error(), nil)
+		return nil, false
+	}
+	return selector, true
+}
+
+// addRun stores a new pipeline run in a namespace, with a name from its
+// generateName if it has none. s.mu must be held.
+func (s *Server) addRun(namespace string, obj map[string]any) *run {
+	delete(obj, "status")
+	delete(obj, "apiVersion")
+	delete(obj, "kind")
+	metadata, _ := obj["metadata"].(map[string]any)
+	if metadata == nil {
+		metadata = map[string]any{}
+		obj["metadata"] = metadata
+	}
+	if name, _ := metadata["name"].(string); name == "" {
+		generateName, _ := metadata["generateName"].(string)
+		for name = generateName + randomSuffix(); s.find(namespace, name) != nil; {
+			name = generateName + randomSuffix()
+		}
+		metadata["name"] = name
+	}
+
+	now := s.now()
+	metadata["namespace"] = namespace
+	metadata["uid"] = randomUID()
+	metadata["creationTimestamp"] = timestamp(now)
+	objLabels, _ := metadata["labels"].(map[string]any)
+	if objLabels == nil {
+		objLabels = map[string]any{}
+		metadata["labels"] = objLabels
+	}
+	if pipeline := nestedString(obj, "spec", "pipelineRef", "name"); pipeline != "" {
+		objLabels["tekton.dev/pipeline"] = pipeline
+	}
+	if s.script.BundleVersion != "" {
+		objLabels[client.BundleVersionLabel] = s.script.BundleVersion
+	}
+
+	p := params(obj)
+	sc := s.script.scenario(p)
+	created := &run{obj: obj, scenario: sc, created: now, first: firstTask(sc, p)}
+	s.runs = append(s.runs, created)
+	return created
+}
+
+// find returns a pipeline run by namespace and name, nil if there is none.
+// s.mu must be held.
+func (s *Server) find(namespace, name string) *run {
+	for _, run := range s.runs {
+		if run.namespace() == namespace && run.name() == name {
+			return run
+		}
+	}
+	return nil
+}
+
+// lookup returns the pipeline run of a request, answering with the not
+// found status of the API server if there is none. s.mu must be held.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) *run {
+	name := r.PathValue("name")
+	run := s.find(r.PathValue("namespace"), name)
+	if run == nil {
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("pipelineruns.tekton.dev %q not found", name),
+			map[string]any{"name": name, "group": "tekton.dev", "kind": "pipelineruns"})
+	}
+	return run
+}
+
+// podTask returns the state of the task running in a pod. s.mu must be held.
+func (s *Server) podTask(namespace, pod string, now time.Time) (taskState, bool) {
+	for _, run := range s.runs {
+		if run.namespace() != namespace {
+			continue
+		}
+		for _, ts := range run.state(now).tasks {
+			if podName(taskRunName(run.name(), ts.task.Name)) == pod {
+				return ts, true
+			}
+		}
+	}
+	return taskState{}, false
+}
+
+// renderPipelineRun returns a pipeline run as the API server does, with its
+// status at now
+func (s *Server) renderPipelineRun(r *run, now time.Time) map[string]any {
+	obj := deepCopy(r.obj)
+	obj["apiVersion"] = "tekton.dev/" + s.opts.APIVersion
+	obj["kind"] = "PipelineRun"
+
+	st := r.state(now)
+	status := map[string]any{
+		"conditions":   []any{st.condition(r.name())},
+		"pipelineSpec": pipelineSpec(r),
+	}
+	if !st.start.IsZero() {
+		status["startTime"] = timestamp(st.start)
+	}
+	if st.done() {
+		status["completionTime"] = timestamp(st.end)
+	}
+
+	if s.opts.APIVersion == client.TektonV1beta1 {
+		taskRuns := map[string]any{}
+		for _, ts := range st.tasks {
+			name := taskRunName(r.name(), ts.task.Name)
+			taskRuns[name] = map[string]any{"pipelineTaskName": ts.task.Name, "status": ts.taskRunStatus(name)}
+		}
+		status["taskRuns"] = taskRuns
+	} else {
+		children := []any{}
+		for _, ts := range st.tasks {
+			children = append(children, map[string]any{
+				"apiVersion":       "tekton.dev/" + s.opts.APIVersion,
+				"kind":             "TaskRun",
+				"name":             taskRunName(r.name(), ts.task.Name),
+				"pipelineTaskName": ts.task.Name,
+			})
+		}
+		status["childReferences"] = children
+	}
+	obj["status"] = status
+	return obj
+}
+
+// renderTaskRun returns the TaskRun of a task of a pipeline run
+func (s *Server) renderTaskRun(r *run, ts taskState) map[string]any {
+	name := taskRunName(r.name(), ts.task.Name)
+	trLabels := map[string]any{
+		"tekton.dev/pipelineRun":  r.name(),
+		"tekton.dev/pipelineTask": ts.task.Name,
+	}
+	if pipeline := nestedString(r.obj, "spec", "pipelineRef", "name"); pipeline != "" {
+		trLabels["tekton.dev/pipeline"] = pipeline
+	}
+	return map[string]any{
+		"apiVersion": "tekton.dev/" + s.opts.APIVersion,
+		"kind":       "TaskRun",
+		"metadata": map[string]any{
+			"name":              name,
+			"namespace":         r.namespace(),
+			"creationTimestamp": timestamp(ts.start),
+			"labels":            trLabels,
+		},
+		"status": ts.taskRunStatus(name),
+	}
+}
+
+// pipelineSpec is the pipeline of a run as Tekton resolves it in its status:
+// its parameters, including start-from-task, and every task of the scenario
+func pipelineSpec(r *run) map[string]any {
+	specParams := []any{map[string]any{"name": client.StartFromTaskParam, "type": "string", "default": ""}}
+	names := make([]string, 0)
+	for name := range params(r.obj) {
+		if name != client.StartFromTaskParam {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		specParams = append(specParams, map[string]any{"name": name, "type": "string"})
+	}
+
+	tasks := []any{}
+	for _, t := range r.scenario.Tasks {
+		tasks = append(tasks, map[string]any{"name": t.Name})
+	}
+	return map[string]any{"params": specParams, "tasks": tasks}
+}
+
+// writeList answers with a list of tekton.dev objects
+func (s *Server) writeList(w http.ResponseWriter, kind string, items []any) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"apiVersion": "tekton.dev/" + s.opts.APIVersion,
+		"kind":       kind,
+		"metadata":   map[string]any{},
+		"items":      items,
+	})
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.opts.Log != nil {
+		fmt.Fprintf(s.opts.Log, "%s %s\n", s.now().Format(time.TimeOnly), fmt.Sprintf(format, args...))
+	}
+}
+
+// payloadParams converts the fields of a payload into pipeline run
+// parameters, sorted by name. Strings are kept, other values are JSON.
+func payloadParams(payload map[string]any) []any {
+	names := make([]string, 0, len(payload))
+	for name := range payload {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := make([]any, 0, len(names))
+	for _, name := range names {
+		value, ok := payload[name].(string)
+		if !ok {
+			data, _ := json.Marshal(payload[name])
+			value = string(data)
+		}
+		items = append(items, map[string]any{"name": name, "value": value})
+	}
+	return items
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to a value
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// objectLabels returns the labels of an object as a label set
+func objectLabels(obj map[string]any) labels.Set {
+	metadata, _ := obj["metadata"].(map[string]any)
+	m, _ := metadata["labels"].(map[string]any)
+	return labelSet(m)
+}
+
+func labelSet(m map[string]any) labels.Set {
+	set := labels.Set{}
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			set[k] = s
+		}
+	}
+	return set
+}
+
+// writeStatus answers with a Kubernetes Status, naming the missing object in
+// its details as the API server does
+func writeStatus(w http.ResponseWriter, code int, reason, message string, details map[string]any) {
+	status := map[string]any{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"metadata":   map[string]any{},
+		"status":     "Failure",
+		"message":    message,
+		"reason":     reason,
+		"code":       code,
+	}
+	if details != nil {
+		status["details"] = details
+	}
+	writeJSON(w, code, status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// deepCopy copies a JSON object
+func deepCopy(obj map[string]any) map[string]any {
+	data, _ := json.Marshal(obj)
+	var c map[string]any
+	json.Unmarshal(data, &c)
+	return c
+}
+
+// randomUID returns a random UUID, as event IDs and object UIDs are
+func randomUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// randomSuffix returns the five random characters the API server appends to
+// a generateName
+func randomSuffix() string {
+	const chars = "bcdfghjklmnpqrstvwxz2456789"
+	b := make([]byte, 5)
+	rand.Read(b)
+	for i := range b {
+		b[i] = chars[int(b[i])%len(chars)]
+	}
+	return string(b)
+}
@@ -0,0 +1,404 @@
+package mockserver
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+const testPipeline = "gcp-region-provisioning-pipeline"
+
+// testScript fails deletions in terraform-apply and succeeds everything else
+const testScript = `
+bundleVersion: 1.2.0
+scenarios:
+  - name: failed-delete
+    match:
+      action: delete
+    pending: 10s
+    tasks:
+      - name: validate
+        duration: 10s
+      - name: terraform-apply
+        duration: 20s
+        fail: true
+      - name: notify
+        duration: 5s
+  - name: success
+    pending: 10s
+    tasks:
+      - name: validate
+        duration: 10s
+      - name: terraform-apply
+        duration: 20s
+        logs: [planning, applying, done]
+`
+
+// fakeClock is the clock of a test server
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestServer(t *testing.T, opts Options) (*httptest.Server, *fakeClock) {
+	t.Helper()
+
+	if opts.Script == nil {
+		script, err := ParseScript([]byte(testScript))
+		if err != nil {
+			t.Fatalf("ParseScript() error = %v", err)
+		}
+		opts.Script = script
+	}
+	if opts.Pipelines == nil {
+		opts.Pipelines = map[string]string{"": testPipeline, "sector": "gcp-sector-provisioning-pipeline"}
+	}
+	s, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	clock := &fakeClock{now: time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)}
+	s.now = clock.Now
+
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return server, clock
+}
+
+func trigger(t *testing.T, url string, req *api.RegionRequest) *api.TektonResponse {
+	t.Helper()
+	resp, err := client.NewTektonClient(url).Trigger(context.Background(), "", req)
+	if err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+	if resp.EventID == "" || resp.Namespace != DefaultNamespace || resp.EventListener != DefaultEventListener {
+		t.Fatalf("Trigger() = %+v, want an event ID in the default event listener", resp)
+	}
+	return resp
+}
+
+func runStatus(t *testing.T, c *client.TektonAPIClient, eventID string) *api.PipelineRunStatus {
+	t.Helper()
+	status, err := c.GetPipelineRunsByEventID(context.Background(), DefaultNamespace, eventID)
+	if err != nil {
+		t.Fatalf("GetPipelineRunsByEventID() error = %v", err)
+	}
+	return status
+}
+
+func TestServer_SucceedingRun(t *testing.T) {
+	for _, version := range []string{client.TektonV1, client.TektonV1beta1} {
+		t.Run(version, func(t *testing.T) {
+			server, clock := newTestServer(t, Options{APIVersion: version})
+			apiClient := client.NewTektonAPIClient(server.URL)
+			ctx := context.Background()
+
+			resp := trigger(t, server.URL, &api.RegionRequest{Environment: "dev", Sector: "main", Region: "us-central1", Action: api.RegionActionAdd})
+
+			steps := []struct {
+				advance time.Duration
+				status  string
+				tasks   map[string]string
+			}{
+				{0, "Pending", nil},
+				{15 * time.Second, "Running", map[string]string{"validate": "Running"}},
+				{10 * time.Second, "Running", map[string]string{"validate": "Succeeded", "terraform-apply": "Running"}},
+				{20 * time.Second, "Succeeded", map[string]string{"validate": "Succeeded", "terraform-apply": "Succeeded"}},
+			}
+			for _, step := range steps {
+				clock.Advance(step.advance)
+				status := runStatus(t, apiClient, resp.EventID)
+				if err := client.AddTaskRunDetails(ctx, apiClient, status); err != nil {
+					t.Fatalf("AddTaskRunDetails() error = %v", err)
+				}
+				if status.Status != step.status || status.Action != api.RegionActionAdd {
+					t.Errorf("after %s: status = %s, action %s, want %s of add", step.advance, status.Status, status.Action, step.status)
+				}
+				tasks := make(map[string]string)
+				for _, task := range status.Tasks {
+					tasks[task.Name] = task.Status
+				}
+				if len(tasks) != len(step.tasks) {
+					t.Errorf("after %s: tasks = %v, want %v", step.advance, tasks, step.tasks)
+				}
+				for name, want := range step.tasks {
+					if tasks[name] != want {
+						t.Errorf("after %s: task %s = %s, want %s", step.advance, name, tasks[name], want)
+					}
+				}
+			}
+
+			runs, err := apiClient.ListPipelineRuns(ctx, DefaultNamespace, client.PipelineSelector(testPipeline))
+			if err != nil || len(runs) != 1 {
+				t.Fatalf("ListPipelineRuns() = %d runs, %v, want 1", len(runs), err)
+			}
+			if got := runs[0].Param("region"); got != "us-central1" {
+				t.Errorf("region param = %q, want us-central1", got)
+			}
+			if version, _ := client.LatestBundleVersion(runs); version != "1.2.0" {
+				t.Errorf("bundle version = %q, want 1.2.0", version)
+			}
+		})
+	}
+}
+
+func TestServer_FailingRun(t *testing.T) {
+	server, clock := newTestServer(t, Options{})
+	apiClient := client.NewTektonAPIClient(server.URL)
+
+	resp := trigger(t, server.URL, &api.RegionRequest{Environment: "dev", Sector: "main", Region: "us-central1", Action: api.RegionActionDelete})
+	clock.Advance(time.Minute)
+
+	status := runStatus(t, apiClient, resp.EventID)
+	if status.Status != "Failed" || !strings.Contains(status.Message, "Skipped: 1") {
+		t.Errorf("status = %s %q, want Failed with notify skipped", status.Status, status.Message)
+	}
+	if status.CompletionTime != "2025-01-02T10:00:40Z" {
+		t.Errorf("completion time = %s, want the end of terraform-apply", status.CompletionTime)
+	}
+
+	taskRuns, err := apiClient.ListTaskRuns(context.Background(), DefaultNamespace, status.Name)
+	if err != nil {
+		t.Fatalf("ListTaskRuns() error = %v", err)
+	}
+	if len(taskRuns) != 2 {
+		t.Fatalf("TaskRuns = %d, want validate and terraform-apply", len(taskRuns))
+	}
+	for _, tr := range taskRuns {
+		if tr.PipelineTask() == "terraform-apply" {
+			task := tr.TaskStatus()
+			if task.Status != "Failed" || task.Steps[0].ExitCode == nil || *task.Steps[0].ExitCode != 1 {
+				t.Errorf("terraform-apply = %+v, want failed with exit code 1", task)
+			}
+		}
+	}
+}
+
+func TestServer_Cancel(t *testing.T) {
+	server, clock := newTestServer(t, Options{})
+	apiClient := client.NewTektonAPIClient(server.URL)
+
+	resp := trigger(t, server.URL, &api.RegionRequest{Environment: "dev", Sector: "main", Region: "us-central1", Action: api.RegionActionAdd})
+	clock.Advance(25 * time.Second)
+	name := runStatus(t, apiClient, resp.EventID).Name
+
+	req, _ := http.NewRequest(http.MethodPatch, server.URL+"/apis/tekton.dev/v1/namespaces/default/pipelineruns/"+name,
+		strings.NewReader(`{"spec":{"status":"Cancelled"}}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	patchResp, err := http.DefaultClient.Do(req)
+	if err != nil || patchResp.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH = %v, %v, want 200", patchResp, err)
+	}
+	patchResp.Body.Close()
+
+	clock.Advance(time.Hour)
+	status := runStatus(t, apiClient, resp.EventID)
+	if status.Status != "Cancelled" || status.CompletionTime != "2025-01-02T10:00:25Z" {
+		t.Errorf("status = %s completed %s, want Cancelled at 10:00:25", status.Status, status.CompletionTime)
+	}
+}
+
+func TestServer_RetryFromTask(t *testing.T) {
+	server, clock := newTestServer(t, Options{})
+	apiClient := client.NewTektonAPIClient(server.URL)
+	ctx := context.Background()
+
+	resp := trigger(t, server.URL, &api.RegionRequest{Environment: "dev", Sector: "main", Region: "us-central1", Action: api.RegionActionDelete})
+	clock.Advance(time.Minute)
+	failed := runStatus(t, apiClient, resp.EventID)
+
+	result, err := client.RetryPipelineRun(ctx, apiClient, DefaultNamespace, failed.Name, client.RetryOptions{FromTask: "terraform-apply"})
+	if err != nil {
+		t.Fatalf("RetryPipelineRun() error = %v", err)
+	}
+
+	// The retry starts from terraform-apply, which fails again
+	clock.Advance(time.Minute)
+	retry, err := apiClient.GetPipelineRun(ctx, DefaultNamespace, result.PipelineRun)
+	if err != nil {
+		t.Fatalf("GetPipelineRun() error = %v", err)
+	}
+	if err := client.AddTaskRunDetails(ctx, apiClient, retry); err != nil {
+		t.Fatalf("AddTaskRunDetails() error = %v", err)
+	}
+	if retry.Status != "Failed" || len(retry.Tasks) != 1 || retry.Tasks[0].Name != "terraform-apply" {
+		t.Errorf("retry = %s with tasks %+v, want failed in terraform-apply only", retry.Status, retry.Tasks)
+	}
+
+	// The retry is not found by the event ID of the original run
+	if got := runStatus(t, apiClient, resp.EventID); got.Name != failed.Name {
+		t.Errorf("run of the event = %s, want %s", got.Name, failed.Name)
+	}
+}
+
+func TestServer_Delete(t *testing.T) {
+	server, clock := newTestServer(t, Options{})
+	apiClient := client.NewTektonAPIClient(server.URL)
+	ctx := context.Background()
+
+	resp := trigger(t, server.URL, &api.RegionRequest{Environment: "dev", Sector: "main", Region: "us-central1", Action: api.RegionActionAdd})
+	clock.Advance(time.Minute)
+	name := runStatus(t, apiClient, resp.EventID).Name
+
+	if err := apiClient.DeletePipelineRun(ctx, DefaultNamespace, name); err != nil {
+		t.Fatalf("DeletePipelineRun() error = %v", err)
+	}
+	if _, err := apiClient.GetPipelineRun(ctx, DefaultNamespace, name); err == nil {
+		t.Error("GetPipelineRun() of a deleted run succeeded")
+	}
+	if _, err := apiClient.GetPipelineRunsByEventID(ctx, DefaultNamespace, resp.EventID); err == nil {
+		t.Error("GetPipelineRunsByEventID() of a deleted run succeeded")
+	}
+}
+
+func TestServer_Logs(t *testing.T) {
+	server, clock := newTestServer(t, Options{})
+	apiClient := client.NewTektonAPIClient(server.URL)
+	ctx := context.Background()
+
+	resp := trigger(t, server.URL, &api.RegionRequest{Environment: "dev", Sector: "main", Region: "us-central1", Action: api.RegionActionAdd})
+	name := runStatus(t, apiClient, resp.EventID).Name
+
+	// terraform-apply started 10s ago, half-way through its logs
+	clock.Advance(30 * time.Second)
+	var out bytes.Buffer
+	if err := apiClient.StreamPodLogs(ctx, DefaultNamespace, name+"-terraform-apply-pod", "step-main", false, &out); err != nil {
+		t.Fatalf("StreamPodLogs() error = %v", err)
+	}
+	if out.String() != "planning\napplying\n" {
+		t.Errorf("logs = %q, want the first two lines", out.String())
+	}
+
+	clock.Advance(time.Minute)
+	out.Reset()
+	err := client.StreamPipelineRunLogs(ctx, apiClient, DefaultNamespace, name, client.LogOptions{Follow: true, Interval: time.Millisecond}, &out)
+	if err != nil {
+		t.Fatalf("StreamPipelineRunLogs() error = %v", err)
+	}
+	for _, want := range []string{"==> validate [main] <==\nRunning task validate\nTask validate completed\n", "==> terraform-apply [main] <==\nplanning\napplying\ndone\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("logs do not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestServer_Routes(t *testing.T) {
+	server, _ := newTestServer(t, Options{})
+	apiClient := client.NewTektonAPIClient(server.URL)
+	ctx := context.Background()
+
+	resp, err := client.NewTektonClient(server.URL).Trigger(ctx, "sector", map[string]any{"environment": "dev", "sector": "canary", "replicas": 3})
+	if err != nil {
+		t.Fatalf("Trigger() error = %v", err)
+	}
+	runs, err := apiClient.ListPipelineRuns(ctx, DefaultNamespace, "triggers.tekton.dev/triggers-eventid="+resp.EventID)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("ListPipelineRuns() = %d runs, %v, want 1", len(runs), err)
+	}
+	if runs[0].Pipeline() != "gcp-sector-provisioning-pipeline" || runs[0].Param("replicas") != "3" {
+		t.Errorf("run = %s with replicas %q, want a sector run with replicas 3", runs[0].Pipeline(), runs[0].Param("replicas"))
+	}
+
+	if _, err := client.NewTektonClient(server.URL).Trigger(ctx, "unknown", map[string]any{}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Trigger() of an unknown route error = %v, want 404", err)
+	}
+}
+
+func TestServer_WebhookSecret(t *testing.T) {
+	server, _ := newTestServer(t, Options{WebhookSecret: "s3cret"})
+	req := &api.RegionRequest{Environment: "dev", Sector: "main", Region: "us-central1", Action: api.RegionActionAdd}
+
+	unsigned := client.NewTektonClient(server.URL)
+	unsigned.SetRetryPolicy(client.NoRetry)
+	if _, err := unsigned.AddRegion(context.Background(), req); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("unsigned AddRegion() error = %v, want 403", err)
+	}
+
+	signed := client.NewTektonClient(server.URL)
+	signed.SetWebhookSecret("s3cret")
+	if _, err := signed.AddRegion(context.Background(), req); err != nil {
+		t.Errorf("signed AddRegion() error = %v", err)
+	}
+}
+
+func TestServer_Namespaces(t *testing.T) {
+	server, _ := newTestServer(t, Options{Namespace: "pipelines"})
+	apiClient := client.NewTektonAPIClient(server.URL)
+
+	namespaces, err := apiClient.ListNamespaces(context.Background(), "kubernetes.io/metadata.name=pipelines")
+	if err != nil || len(namespaces) != 1 || namespaces[0] != "pipelines" {
+		t.Errorf("ListNamespaces() = %v, %v, want pipelines", namespaces, err)
+	}
+}
+
+func TestServer_Preflight(t *testing.T) {
+	server, _ := newTestServer(t, Options{Denied: []string{"get pods/log"}})
+	apiClient := client.NewTektonAPIClient(server.URL)
+	ctx := context.Background()
+
+	for check, want := range map[client.AccessCheck]bool{
+		{Verb: "list", Group: "tekton.dev", Resource: "pipelineruns", Namespace: DefaultNamespace}: true,
+		{Verb: "get", Resource: "pods", Subresource: "log", Namespace: DefaultNamespace}:           false,
+	} {
+		review, err := apiClient.ReviewAccess(ctx, check)
+		if err != nil {
+			t.Fatalf("ReviewAccess(%s) error = %v", check, err)
+		}
+		if review.Allowed != want {
+			t.Errorf("ReviewAccess(%s) = %+v, want allowed %v", check, review, want)
+		}
+	}
+
+	webhook := client.NewTektonClient(server.URL)
+	for route, want := range map[string]int{"": http.StatusOK, "sector": http.StatusOK, "unknown": http.StatusNotFound} {
+		if code, err := webhook.ProbeRoute(ctx, route); err != nil || code != want {
+			t.Errorf("ProbeRoute(%q) = %d, %v, want %d", route, code, err, want)
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		errMsg string
+	}{
+		{"no scenarios", "scenarios: []", "no scenarios"},
+		{"no tasks", "scenarios: [{name: a, tasks: []}]", "scenario a has no tasks"},
+		{"duplicate task", "scenarios: [{tasks: [{name: t, duration: 1s}, {name: t, duration: 1s}]}]", "scenario #1 has task t twice"},
+		{"negative duration", "scenarios: [{tasks: [{name: t, duration: -1s}]}]", "must not be negative"},
+		{"unknown field", "scenarios: [{tasks: [{name: t, duration: 1s, retries: 2}]}]", "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseScript([]byte(tt.script))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("ParseScript() error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+
+	if err := DefaultScript().Validate(); err != nil {
+		t.Errorf("DefaultScript() is invalid: %v", err)
+	}
+}
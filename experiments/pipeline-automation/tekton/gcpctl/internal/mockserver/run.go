@@ -0,0 +1,285 @@
+package mockserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+)
+
+// States of pipeline runs and their tasks
+const (
+	statePending   = "Pending"
+	stateRunning   = "Running"
+	stateSucceeded = "Succeeded"
+	stateFailed    = "Failed"
+	stateCancelled = "Cancelled"
+)
+
+// stepName is the only step of every mock task
+const stepName = "main"
+
+// run is a pipeline run of the mock server. Its status is not stored: it is
+// computed from the scenario and the time elapsed since it was created.
+type run struct {
+	// obj holds the metadata and spec of the run, as created or patched
+	obj      map[string]any
+	scenario *Scenario
+	created  time.Time
+	// first is the index of the first task that runs, after start-from-task
+	first int
+	// cancelled is when the run was cancelled, zero if it was not
+	cancelled time.Time
+}
+
+// runState is the status of a run at a point in time
+type runState struct {
+	status string
+	// start is zero while the run is pending, end until it finished
+	start, end time.Time
+	// tasks are the tasks that started, in order
+	tasks []taskState
+	// skipped counts the tasks that will never run
+	skipped int
+}
+
+// taskState is the status of a task of a run
+type taskState struct {
+	task   *Task
+	status string
+	// end is zero while the task runs
+	start, end time.Time
+}
+
+func (r *run) name() string {
+	return nestedString(r.obj, "metadata", "name")
+}
+
+func (r *run) namespace() string {
+	return nestedString(r.obj, "metadata", "namespace")
+}
+
+// tasks returns the tasks the run goes through
+func (r *run) tasks() []Task {
+	return r.scenario.Tasks[r.first:]
+}
+
+// state returns the status of the run at now. A cancelled run stops where it
+// was when it was cancelled.
+func (r *run) state(now time.Time) runState {
+	at := now
+	cancelled := !r.cancelled.IsZero() && !r.cancelled.After(now)
+	if cancelled {
+		at = r.cancelled
+	}
+
+	st := runState{status: statePending}
+	start := r.created.Add(r.scenario.Pending.Duration)
+	if at.Before(start) {
+		if cancelled {
+			st.status, st.end = stateCancelled, at
+		}
+		return st
+	}
+
+	st.status, st.start = stateRunning, start
+	tasks := r.tasks()
+	t := start
+	for i := range tasks {
+		task := &tasks[i]
+		end := t.Add(task.Duration.Duration)
+		if at.Before(end) {
+			ts := taskState{task: task, status: stateRunning, start: t}
+			if cancelled {
+				ts.status, ts.end = stateCancelled, at
+				st.status, st.end = stateCancelled, at
+				st.skipped = len(tasks) - i - 1
+			}
+			st.tasks = append(st.tasks, ts)
+			return st
+		}
+
+		ts := taskState{task: task, status: stateSucceeded, start: t, end: end}
+		if task.Fail {
+			ts.status = stateFailed
+		}
+		st.tasks = append(st.tasks, ts)
+		if task.Fail {
+			st.status, st.end = stateFailed, end
+			st.skipped = len(tasks) - i - 1
+			return st
+		}
+		t = end
+	}
+	st.status, st.end = stateSucceeded, t
+	return st
+}
+
+// done reports whether the state is final
+func (st runState) done() bool {
+	return !st.end.IsZero()
+}
+
+// condition returns the Succeeded condition of a run in the state, with the
+// reasons and messages Tekton sets
+func (st runState) condition(name string) map[string]any {
+	completed, failed := 0, 0
+	for _, ts := range st.tasks {
+		switch ts.status {
+		case stateSucceeded:
+			completed++
+		case stateFailed:
+			completed++
+			failed++
+		}
+	}
+
+	var status, reason, message string
+	switch st.status {
+	case statePending:
+		status, reason, message = "Unknown", "Pending", "PipelineRun is waiting to start"
+	case stateRunning:
+		status, reason = "Unknown", "Running"
+		message = fmt.Sprintf("Tasks Completed: %d (Failed: 0, Cancelled 0), Incomplete: %d, Skipped: 0", completed, len(st.tasks)-completed)
+	case stateSucceeded:
+		status, reason = "True", "Succeeded"
+		message = fmt.Sprintf("Tasks Completed: %d (Failed: 0, Cancelled 0), Skipped: 0", completed)
+	case stateFailed:
+		status, reason = "False", "Failed"
+		message = fmt.Sprintf("Tasks Completed: %d (Failed: %d, Cancelled 0), Skipped: %d", completed, failed, st.skipped)
+	case stateCancelled:
+		status, reason = "False", "Cancelled"
+		message = fmt.Sprintf("PipelineRun %q was cancelled", name)
+	}
+	return map[string]any{
+		"type":    "Succeeded",
+		"status":  status,
+		"reason":  reason,
+		"message": message,
+	}
+}
+
+// taskRunName is the name of the TaskRun of a task of a pipeline run
+func taskRunName(pipelineRun, task string) string {
+	return pipelineRun + "-" + task
+}
+
+// podName is the name of the pod of a TaskRun
+func podName(taskRun string) string {
+	return taskRun + "-pod"
+}
+
+// taskRunStatus renders the status of a TaskRun, with its single step
+func (ts taskState) taskRunStatus(taskRun string) map[string]any {
+	status, reason := "Unknown", "Running"
+	step := map[string]any{"name": stepName, "container": "step-" + stepName}
+	terminated := map[string]any{"startedAt": timestamp(ts.start), "finishedAt": timestamp(ts.end)}
+	switch ts.status {
+	case stateRunning:
+		step["running"] = map[string]any{"startedAt": timestamp(ts.start)}
+	case stateSucceeded:
+		status, reason = "True", "Succeeded"
+		terminated["exitCode"], terminated["reason"] = 0, "Completed"
+		step["terminated"] = terminated
+	case stateFailed:
+		status, reason = "False", "Failed"
+		terminated["exitCode"], terminated["reason"] = 1, "Error"
+		step["terminated"] = terminated
+	case stateCancelled:
+		status, reason = "False", "TaskRunCancelled"
+		terminated["exitCode"], terminated["reason"] = 1, "TaskRunCancelled"
+		step["terminated"] = terminated
+	}
+
+	s := map[string]any{
+		"conditions": []any{map[string]any{"type": "Succeeded", "status": status, "reason": reason}},
+		"podName":    podName(taskRun),
+		"startTime":  timestamp(ts.start),
+		"steps":      []any{step},
+	}
+	if !ts.end.IsZero() {
+		s["completionTime"] = timestamp(ts.end)
+	}
+	return s
+}
+
+// logs returns the log lines the task printed by now. They are spread evenly
+// over the duration of the task, the last one printed when it ends.
+func (ts taskState) logs(now time.Time) []string {
+	lines := ts.task.Logs
+	if len(lines) == 0 {
+		last := fmt.Sprintf("Task %s completed", ts.task.Name)
+		if ts.task.Fail {
+			last = fmt.Sprintf("Task %s failed", ts.task.Name)
+		}
+		lines = []string{fmt.Sprintf("Running task %s", ts.task.Name), last}
+	}
+
+	if ts.status == stateSucceeded || ts.status == stateFailed {
+		return lines
+	}
+
+	at := now
+	if !ts.end.IsZero() && ts.end.Before(now) {
+		at = ts.end
+	}
+	elapsed := at.Sub(ts.start)
+	var printed []string
+	for i, line := range lines {
+		if i > 0 && elapsed < ts.task.Duration.Duration*time.Duration(i)/time.Duration(len(lines)-1) {
+			break
+		}
+		printed = append(printed, line)
+	}
+	return printed
+}
+
+// params returns the parameters of a run by name
+func params(obj map[string]any) map[string]string {
+	values := make(map[string]string)
+	spec, _ := obj["spec"].(map[string]any)
+	items, _ := spec["params"].([]any)
+	for _, item := range items {
+		p, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := p["name"].(string)
+		if value, ok := p["value"].(string); ok && name != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// firstTask returns the index of the task a run starts from: the task of its
+// start-from-task parameter, see 'gcpctl runs retry --from-task', or the
+// first one
+func firstTask(sc *Scenario, params map[string]string) int {
+	from := params[client.StartFromTaskParam]
+	for i, t := range sc.Tasks {
+		if t.Name == from {
+			return i
+		}
+	}
+	return 0
+}
+
+// timestamp formats a time as Kubernetes does
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// nestedString returns the string at a path of maps, empty if there is none
+func nestedString(obj map[string]any, fields ...string) string {
+	var cur any = obj
+	for _, f := range fields {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return ""
+		}
+		cur = m[f]
+	}
+	s, _ := cur.(string)
+	return s
+}
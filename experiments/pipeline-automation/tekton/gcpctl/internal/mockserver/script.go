@@ -0,0 +1,175 @@
+package mockserver
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Script is how the pipeline runs of the mock server progress. Every run
+// follows the first scenario whose match it satisfies, the last scenario if
+// it satisfies none.
+type Script struct {
+	// BundleVersion is set as the bundle version label of every run, see
+	// 'gcpctl version'; optional
+	BundleVersion string     `json:"bundleVersion,omitempty"`
+	Scenarios     []Scenario `json:"scenarios"`
+}
+
+// Scenario is the timeline of a pipeline run: it waits Pending, then runs its
+// tasks one after the other. The run fails with the first failing task, the
+// tasks after it are skipped.
+type Scenario struct {
+	Name string `json:"name,omitempty"`
+	// Match selects the runs of the scenario by parameter value, e.g.
+	// action: delete; empty matches every run
+	Match   map[string]string `json:"match,omitempty"`
+	Pending metav1.Duration   `json:"pending,omitempty"`
+	Tasks   []Task            `json:"tasks"`
+}
+
+// Task is a pipeline task of a scenario
+type Task struct {
+	Name     string          `json:"name"`
+	Duration metav1.Duration `json:"duration"`
+	// Fail makes the task, and so the run, fail at its end
+	Fail bool `json:"fail,omitempty"`
+	// Logs are printed by the task, spread over its duration; by default a
+	// line when it starts and one when it ends
+	Logs []string `json:"logs,omitempty"`
+}
+
+// DefaultScript is the script of the mock server without --script: every run
+// succeeds after a few seconds, except region deletions in the test sector,
+// which fail in terraform-destroy to demo failures
+func DefaultScript() *Script {
+	return &Script{
+		BundleVersion: "0.0.0-mock",
+		Scenarios: []Scenario{
+			{
+				Name:    "failed-delete",
+				Match:   map[string]string{"action": "delete", "sector": "test"},
+				Pending: duration(time.Second),
+				Tasks: []Task{
+					{Name: "validate", Duration: duration(2 * time.Second)},
+					{Name: "terraform-destroy", Duration: duration(4 * time.Second), Fail: true, Logs: []string{
+						"Destroying the region resources",
+						"Error: error waiting for the deletion of the subnetwork: resource is in use",
+					}},
+				},
+			},
+			{
+				Name:    "success",
+				Pending: duration(time.Second),
+				Tasks: []Task{
+					{Name: "validate", Duration: duration(2 * time.Second)},
+					{Name: "terraform-plan", Duration: duration(4 * time.Second), Logs: []string{
+						"Planning the region resources",
+						"Plan: 12 to add, 0 to change, 0 to destroy.",
+					}},
+					{Name: "terraform-apply", Duration: duration(8 * time.Second), Logs: []string{
+						"Applying the plan",
+						"Apply complete! Resources: 12 added, 0 changed, 0 destroyed.",
+					}},
+				},
+			},
+		},
+	}
+}
+
+// ParseScript reads a script in YAML or JSON
+func ParseScript(data []byte) (*Script, error) {
+	var s Script
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// LoadScript reads a script file
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+	s, err := ParseScript(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Validate checks that the script has scenarios and that their tasks are
+// named uniquely, with durations that are not negative
+func (s *Script) Validate() error {
+	if len(s.Scenarios) == 0 {
+		return fmt.Errorf("script has no scenarios")
+	}
+	for i, sc := range s.Scenarios {
+		name := s.scenarioName(&s.Scenarios[i])
+		if len(sc.Tasks) == 0 {
+			return fmt.Errorf("scenario %s has no tasks", name)
+		}
+		if sc.Pending.Duration < 0 {
+			return fmt.Errorf("scenario %s: pending must not be negative", name)
+		}
+		seen := make(map[string]bool)
+		for _, t := range sc.Tasks {
+			if t.Name == "" {
+				return fmt.Errorf("scenario %s has a task without name", name)
+			}
+			if seen[t.Name] {
+				return fmt.Errorf("scenario %s has task %s twice", name, t.Name)
+			}
+			seen[t.Name] = true
+			if t.Duration.Duration < 0 {
+				return fmt.Errorf("scenario %s: task %s duration must not be negative", name, t.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// scenario returns the scenario of a run with the given parameters
+func (s *Script) scenario(params map[string]string) *Scenario {
+	for i := range s.Scenarios {
+		if matches(s.Scenarios[i].Match, params) {
+			return &s.Scenarios[i]
+		}
+	}
+	return &s.Scenarios[len(s.Scenarios)-1]
+}
+
+// scenarioName names a scenario of the script in messages, by its position
+// if it has no name
+func (s *Script) scenarioName(sc *Scenario) string {
+	if sc.Name != "" {
+		return sc.Name
+	}
+	for i := range s.Scenarios {
+		if &s.Scenarios[i] == sc {
+			return fmt.Sprintf("#%d", i+1)
+		}
+	}
+	return "?"
+}
+
+// matches reports whether params have every value of match
+func matches(match, params map[string]string) bool {
+	for k, v := range match {
+		if params[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func duration(d time.Duration) metav1.Duration {
+	return metav1.Duration{Duration: d}
+}
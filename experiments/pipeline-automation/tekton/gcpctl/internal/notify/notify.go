@@ -0,0 +1,196 @@
+// Package notify posts the outcome of pipeline runs gcpctl waited for to chat
+// webhooks and HTTP endpoints, so long rollouts do not need someone watching
+// the terminal.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// Target types
+const (
+	// TypeSlack posts a message to a Slack incoming webhook
+	TypeSlack = "slack"
+	// TypeGoogleChat posts a message to a Google Chat space webhook
+	TypeGoogleChat = "google-chat"
+	// TypeWebhook posts the Event as JSON to any HTTP endpoint
+	TypeWebhook = "webhook"
+)
+
+// sendTimeout bounds posting a notification to one target
+const sendTimeout = 10 * time.Second
+
+// Target is a webhook notifications are posted to
+type Target struct {
+	Type string
+	URL  string
+}
+
+// Event is the outcome of a pipeline run triggered by an operation
+type Event struct {
+	// Operation is the operation that triggered the run, e.g. region add
+	Operation string `json:"operation"`
+	// Subject names what the request is about, e.g.
+	// production/us-central1/main
+	Subject string            `json:"subject,omitempty"`
+	Request map[string]string `json:"request,omitempty"`
+	Profile string            `json:"profile,omitempty"`
+
+	PipelineRun string `json:"pipelineRun,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	// Status is the status of the pipeline run, Unknown if gcpctl stopped
+	// waiting before it finished
+	Status string `json:"status"`
+	// State is the outcome for the operation, e.g. Provisioned
+	State           string `json:"state,omitempty"`
+	DurationSeconds int64  `json:"durationSeconds,omitempty"`
+	Message         string `json:"message,omitempty"`
+	DashboardURL    string `json:"dashboardURL,omitempty"`
+}
+
+// NewEvent builds the event of a pipeline run. run is the last status seen,
+// nil if the run was never found; waitErr is why gcpctl stopped waiting
+// before the run finished, if it did.
+func NewEvent(operation string, request map[string]string, run *api.PipelineRunStatus, state string, waitErr error) Event {
+	e := Event{Operation: operation, Request: request, Status: "Unknown", State: state}
+	if run != nil {
+		e.PipelineRun = run.Name
+		e.Namespace = run.Namespace
+		e.Status = run.Status
+		e.Message = run.Message
+		e.DashboardURL = run.DashboardURL
+		if start, err := time.Parse(time.RFC3339, run.StartTime); err == nil {
+			if end, err := time.Parse(time.RFC3339, run.CompletionTime); err == nil {
+				e.DurationSeconds = int64(end.Sub(start).Seconds())
+			}
+		}
+	}
+	if waitErr != nil {
+		e.Message = waitErr.Error()
+	}
+	return e
+}
+
+// Text summarizes the event for a chat message, e.g. "✓ region add
+// production/us-central1/main: Provisioned (gcp-region-provision-jf8v5
+// Succeeded in 3m32s)", followed by the message of a failed run
+func (e Event) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", client.GetStatusEmoji(e.Status), e.Operation)
+	if e.Subject != "" {
+		fmt.Fprintf(&b, " %s", e.Subject)
+	}
+	outcome := e.State
+	if outcome == "" {
+		outcome = e.Status
+	}
+	fmt.Fprintf(&b, ": %s", outcome)
+
+	var details []string
+	if e.PipelineRun != "" {
+		details = append(details, e.PipelineRun+" "+e.Status)
+	}
+	if e.DurationSeconds > 0 {
+		details = append(details, "in "+client.FormatDuration(time.Duration(e.DurationSeconds)*time.Second))
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(details, " "))
+	}
+	if e.Profile != "" {
+		fmt.Fprintf(&b, " [%s]", e.Profile)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, "\n%s", e.Message)
+	}
+	return b.String()
+}
+
+// Validate checks the type and URL of notification targets
+func Validate(targets []Target) error {
+	for i, t := range targets {
+		switch t.Type {
+		case TypeSlack, TypeGoogleChat, TypeWebhook:
+		default:
+			return fmt.Errorf("notify target %d: unknown type %q, must be %s, %s or %s", i+1, t.Type, TypeSlack, TypeGoogleChat, TypeWebhook)
+		}
+		if !strings.HasPrefix(t.URL, "http://") && !strings.HasPrefix(t.URL, "https://") {
+			return fmt.Errorf("notify target %d: url must be an http(s) URL", i+1)
+		}
+	}
+	return nil
+}
+
+// Send posts an event to every target. A target that fails does not stop the
+// others; the errors of all failed targets are returned. Target URLs are left
+// out of errors as chat webhook URLs are secrets.
+func Send(ctx context.Context, hc *http.Client, targets []Target, e Event) error {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	var errs []error
+	for i, t := range targets {
+		if err := send(ctx, hc, t, e); err != nil {
+			errs = append(errs, fmt.Errorf("notify target %d (%s): %w", i+1, t.Type, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func send(ctx context.Context, hc *http.Client, t Target, e Event) error {
+	body, err := payload(t.Type, e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid url")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// payload returns the body posted to a target of the given type
+func payload(typ string, e Event) ([]byte, error) {
+	switch typ {
+	case TypeSlack, TypeGoogleChat:
+		// Both render <url|text> as a link
+		text := e.Text()
+		if e.DashboardURL != "" {
+			text += fmt.Sprintf("\n<%s|Open in the Tekton dashboard>", e.DashboardURL)
+		}
+		return json.Marshal(map[string]string{"text": text})
+	case TypeWebhook:
+		return json.Marshal(e)
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+}
@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+func succeededEvent() Event {
+	e := NewEvent("region add", map[string]string{"environment": "production", "region": "us-central1", "sector": "main"},
+		&api.PipelineRunStatus{
+			Name:           "gcp-region-provision-jf8v5",
+			Namespace:      "default",
+			Status:         "Succeeded",
+			StartTime:      "2025-01-15T10:00:00Z",
+			CompletionTime: "2025-01-15T10:03:32Z",
+			DashboardURL:   "http://dashboard.example.com/#/namespaces/default/pipelineruns/gcp-region-provision-jf8v5",
+		}, "Provisioned", nil)
+	e.Subject = "production/us-central1/main"
+	e.Profile = "prod"
+	return e
+}
+
+func TestNewEvent(t *testing.T) {
+	e := succeededEvent()
+	if e.DurationSeconds != 212 || e.PipelineRun != "gcp-region-provision-jf8v5" || e.Status != "Succeeded" {
+		t.Errorf("NewEvent() = %+v", e)
+	}
+
+	timedOut := NewEvent("region add", nil, nil, "", errors.New("timed out waiting"))
+	if timedOut.Status != "Unknown" || timedOut.Message != "timed out waiting" {
+		t.Errorf("NewEvent() without a run = %+v", timedOut)
+	}
+}
+
+func TestEvent_Text(t *testing.T) {
+	want := "✓ region add production/us-central1/main: Provisioned (gcp-region-provision-jf8v5 Succeeded in 3m32s) [prod]"
+	if got := succeededEvent().Text(); got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+
+	failed := Event{Operation: "sector add", Status: "Failed", PipelineRun: "gcp-sector-x", Message: "Tasks Completed: 2 (Failed: 1)"}
+	if got := failed.Text(); got != "✗ sector add: Failed (gcp-sector-x Failed)\nTasks Completed: 2 (Failed: 1)" {
+		t.Errorf("Text() = %q", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []Target
+		wantErr string
+	}{
+		{name: "valid", targets: []Target{{Type: TypeSlack, URL: "https://hooks.slack.com/services/x"}, {Type: TypeWebhook, URL: "http://ops:8080"}}},
+		{name: "unknown type", targets: []Target{{Type: "teams", URL: "https://example.com"}}, wantErr: `unknown type "teams"`},
+		{name: "no url", targets: []Target{{Type: TypeGoogleChat}}, wantErr: "http(s) URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.targets)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	bodies := map[string]map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "no such hook", http.StatusNotFound)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("%s: invalid JSON %s", r.URL.Path, data)
+		}
+		bodies[r.URL.Path] = body
+	}))
+	defer server.Close()
+
+	targets := []Target{
+		{Type: TypeSlack, URL: server.URL + "/slack"},
+		{Type: TypeWebhook, URL: server.URL + "/broken"},
+		{Type: TypeGoogleChat, URL: server.URL + "/chat"},
+		{Type: TypeWebhook, URL: server.URL + "/hook"},
+	}
+	err := Send(context.Background(), server.Client(), targets, succeededEvent())
+	if err == nil || !strings.Contains(err.Error(), "notify target 2 (webhook): unexpected status code 404") {
+		t.Errorf("Send() error = %v, want the broken target", err)
+	}
+	if strings.Contains(err.Error(), server.URL) {
+		t.Errorf("Send() error = %v contains the target URL", err)
+	}
+
+	for _, path := range []string{"/slack", "/chat"} {
+		text, _ := bodies[path]["text"].(string)
+		if !strings.HasPrefix(text, "✓ region add") || !strings.Contains(text, "<http://dashboard.example.com/") {
+			t.Errorf("%s text = %q, want the summary and the dashboard link", path, text)
+		}
+	}
+	if hook := bodies["/hook"]; hook["state"] != "Provisioned" || hook["durationSeconds"] != float64(212) || hook["profile"] != "prod" {
+		t.Errorf("webhook body = %v", hook)
+	}
+}
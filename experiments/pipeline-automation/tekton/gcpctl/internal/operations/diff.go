@@ -0,0 +1,163 @@
+package operations
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// ExtraParamsName is the pipeline parameter the event listeners forward the
+// extra parameters of a payload to, as a JSON object
+const ExtraParamsName = "extra-params"
+
+// DiffRun compares a request of the operation and its extra parameters with
+// the parameters of the pipeline run it started. The trigger bindings map
+// every top-level key of the webhook payload to the parameter of the same
+// name and the extra parameters to ExtraParamsName, so the requested
+// parameters are those of the payload the request was sent as. Parameters of
+// the run that were not requested are reported as defaulted.
+//
+// Drift counts the changed and missing parameters, and a run of another
+// pipeline than the one of the operation. The source and the version
+// warnings of the diff are left to the caller.
+func (o *Operation) DiffRun(v Values, params Params, run *client.TektonPipelineRun) (*api.RunDiff, error) {
+	requested, err := payloadParams(o.BuildPayload(v))
+	if err != nil {
+		return nil, err
+	}
+	applied := map[string]string{}
+	for _, p := range run.Spec.Params {
+		applied[p.Name] = p.Value
+	}
+
+	diff := &api.RunDiff{
+		Operation:        o.Name(),
+		ExpectedPipeline: o.Pipeline,
+		Params:           []api.ParamDiff{},
+	}
+	for _, name := range unionKeys(requested, applied) {
+		if name == ExtraParamsName {
+			continue
+		}
+		diff.Params = append(diff.Params, compareParam(name, requested, applied))
+	}
+
+	extra, err := extraParams(applied)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline run %s: %w", run.Metadata.Name, err)
+	}
+	wanted := make(map[string]string, len(params))
+	for name, value := range params {
+		wanted[name] = paramString(value)
+	}
+	for _, name := range unionKeys(wanted, extra) {
+		p := compareParam(name, wanted, extra)
+		p.Name = ParamsKey + "." + name
+		diff.Params = append(diff.Params, p)
+	}
+
+	for _, p := range diff.Params {
+		if p.Status == api.ParamChanged || p.Status == api.ParamMissing {
+			diff.Drift++
+		}
+	}
+	if run.Pipeline() != o.Pipeline {
+		diff.Drift++
+	}
+	return diff, nil
+}
+
+// compareParam compares a requested parameter with the applied one
+func compareParam(name string, requested, applied map[string]string) api.ParamDiff {
+	want, isRequested := requested[name]
+	got, isApplied := applied[name]
+	p := api.ParamDiff{Name: name, Requested: want, Applied: got}
+	switch {
+	case !isRequested:
+		p.Status = api.ParamDefaulted
+	case !isApplied:
+		p.Status = api.ParamMissing
+	case want != got:
+		p.Status = api.ParamChanged
+	default:
+		p.Status = api.ParamMatch
+	}
+	return p
+}
+
+// payloadParams returns the top-level scalar values of a webhook payload as
+// the string parameters they are bound to. Keys of empty values are omitted,
+// as the event listener treats them as unset.
+func payloadParams(payload any) (map[string]string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("the payload of the operation is not an object: %w", err)
+	}
+	params := map[string]string{}
+	for key, value := range object {
+		if key == ParamsKey || value == nil || value == "" {
+			continue
+		}
+		params[key] = paramString(value)
+	}
+	return params, nil
+}
+
+// extraParams decodes the ExtraParamsName parameter of a run, empty if the
+// run has none
+func extraParams(applied map[string]string) (map[string]string, error) {
+	raw, ok := applied[ExtraParamsName]
+	if !ok || raw == "" {
+		return map[string]string{}, nil
+	}
+	var object map[string]any
+	if err := json.Unmarshal([]byte(raw), &object); err != nil {
+		return nil, fmt.Errorf("parameter %s is not a JSON object: %w", ExtraParamsName, err)
+	}
+	extra := make(map[string]string, len(object))
+	for name, value := range object {
+		extra[name] = paramString(value)
+	}
+	return extra, nil
+}
+
+// paramString formats a parameter value as it appears in a pipeline run, so
+// that 3, int64(3) and "3" compare equal
+func paramString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// unionKeys returns the keys of both maps, sorted
+func unionKeys(a, b map[string]string) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,114 @@
+package operations
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+func diffTestRun(t *testing.T, pipeline string, params map[string]string) *client.TektonPipelineRun {
+	t.Helper()
+	var list []map[string]string
+	for name, value := range params {
+		list = append(list, map[string]string{"name": name, "value": value})
+	}
+	data, _ := json.Marshal(map[string]any{
+		"metadata": map[string]any{"name": "gcp-region-provision-jf8v5", "labels": map[string]string{"tekton.dev/pipeline": pipeline}},
+		"spec":     map[string]any{"params": list},
+	})
+	var run client.TektonPipelineRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		t.Fatal(err)
+	}
+	return &run
+}
+
+func TestOperation_DiffRun(t *testing.T) {
+	add, _ := Lookup("region add")
+	values := Values{"environment": "integration", "region": "us-central1", "sector": "main"}
+
+	tests := []struct {
+		name      string
+		params    Params
+		pipeline  string
+		runParams map[string]string
+		want      []api.ParamDiff
+		wantDrift int
+	}{
+		{
+			name:     "defaults",
+			pipeline: client.RegionPipelineName,
+			runParams: map[string]string{
+				"environment": "integration", "region": "us-central1", "sector": "main", "action": "add", "extra-params": "{}",
+			},
+			want: []api.ParamDiff{
+				{Name: "action", Applied: "add", Status: api.ParamDefaulted},
+				{Name: "environment", Requested: "integration", Applied: "integration", Status: api.ParamMatch},
+				{Name: "region", Requested: "us-central1", Applied: "us-central1", Status: api.ParamMatch},
+				{Name: "sector", Requested: "main", Applied: "main", Status: api.ParamMatch},
+			},
+		},
+		{
+			name:     "rewritten and extra params",
+			params:   Params{"capacity": int64(3), "machine-cidr": "10.0.0.0/16"},
+			pipeline: client.RegionPipelineName,
+			runParams: map[string]string{
+				"environment": "integration", "region": "us-east1", "sector": "main", "action": "add",
+				"extra-params": `{"capacity":3,"machine-cidr":"10.1.0.0/16","zones":2}`,
+			},
+			want: []api.ParamDiff{
+				{Name: "action", Applied: "add", Status: api.ParamDefaulted},
+				{Name: "environment", Requested: "integration", Applied: "integration", Status: api.ParamMatch},
+				{Name: "region", Requested: "us-central1", Applied: "us-east1", Status: api.ParamChanged},
+				{Name: "sector", Requested: "main", Applied: "main", Status: api.ParamMatch},
+				{Name: "params.capacity", Requested: "3", Applied: "3", Status: api.ParamMatch},
+				{Name: "params.machine-cidr", Requested: "10.0.0.0/16", Applied: "10.1.0.0/16", Status: api.ParamChanged},
+				{Name: "params.zones", Applied: "2", Status: api.ParamDefaulted},
+			},
+			wantDrift: 2,
+		},
+		{
+			name:      "pipeline without extra params",
+			params:    Params{"capacity": int64(3)},
+			pipeline:  "gcp-region-e2e",
+			runParams: map[string]string{"environment": "integration", "region": "us-central1", "sector": "main"},
+			want: []api.ParamDiff{
+				{Name: "environment", Requested: "integration", Applied: "integration", Status: api.ParamMatch},
+				{Name: "region", Requested: "us-central1", Applied: "us-central1", Status: api.ParamMatch},
+				{Name: "sector", Requested: "main", Applied: "main", Status: api.ParamMatch},
+				{Name: "params.capacity", Requested: "3", Status: api.ParamMissing},
+			},
+			// The missing parameter and the pipeline
+			wantDrift: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := add.DiffRun(values, tt.params, diffTestRun(t, tt.pipeline, tt.runParams))
+			if err != nil {
+				t.Fatalf("DiffRun() error = %v", err)
+			}
+			if !reflect.DeepEqual(diff.Params, tt.want) {
+				t.Errorf("DiffRun() params = %+v, want %+v", diff.Params, tt.want)
+			}
+			if diff.Drift != tt.wantDrift {
+				t.Errorf("DiffRun() drift = %d, want %d", diff.Drift, tt.wantDrift)
+			}
+			if diff.Operation != "region add" || diff.ExpectedPipeline != client.RegionPipelineName {
+				t.Errorf("DiffRun() = %+v", diff)
+			}
+		})
+	}
+}
+
+func TestOperation_DiffRunInvalidExtraParams(t *testing.T) {
+	add, _ := Lookup("region add")
+	run := diffTestRun(t, client.RegionPipelineName, map[string]string{"extra-params": "not json"})
+	if _, err := add.DiffRun(Values{"region": "us-central1"}, nil, run); err == nil {
+		t.Error("DiffRun() error = nil, want the extra-params parameter rejected")
+	}
+}
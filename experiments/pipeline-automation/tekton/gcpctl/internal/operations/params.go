@@ -0,0 +1,362 @@
+package operations
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+	"sigs.k8s.io/yaml"
+)
+
+// ParamsKey is the key of the extra parameters in the webhook payload
+const ParamsKey = "params"
+
+// Parameter types of a Schema, as in JSON Schema
+const (
+	ParamString  = "string"
+	ParamInteger = "integer"
+	ParamNumber  = "number"
+	ParamBoolean = "boolean"
+)
+
+// schemaFiles are the parameter schemas of the pipelines, one
+// <pipeline>.json file per pipeline accepting extra parameters
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// Schema is the JSON schema of the extra parameters a pipeline accepts
+// besides the fields of its operations, e.g. the machine CIDR of a region.
+// Only a subset of JSON Schema is supported: an object of string, integer,
+// number and boolean properties, constrained with enum, pattern, minLength,
+// maxLength, minimum and maximum. Other keywords are rejected rather than
+// ignored.
+type Schema struct {
+	Schema      string               `json:"$schema,omitempty"`
+	Title       string               `json:"title,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Type        string               `json:"type"`
+	Properties  map[string]*Property `json:"properties"`
+	Required    []string             `json:"required,omitempty"`
+	// AdditionalProperties allows parameters that are not properties when
+	// true; unset is false, so a typo is not forwarded to the pipeline
+	AdditionalProperties *bool `json:"additionalProperties,omitempty"`
+
+	patterns map[string]*regexp.Regexp
+}
+
+// Property is a parameter of a Schema
+type Property struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Enum        []any    `json:"enum,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	MinLength   *int     `json:"minLength,omitempty"`
+	MaxLength   *int     `json:"maxLength,omitempty"`
+	Minimum     *float64 `json:"minimum,omitempty"`
+	Maximum     *float64 `json:"maximum,omitempty"`
+}
+
+// Params are the extra parameters of a request, with the types of their
+// schema: string, int64, float64 or bool
+type Params map[string]any
+
+// schemas are the parsed schemas of schemaFiles, by pipeline
+var schemas = loadSchemas()
+
+// loadSchemas parses the embedded schemas; an invalid schema is a bug of
+// gcpctl, so it panics
+func loadSchemas() map[string]*Schema {
+	loaded := map[string]*Schema{}
+	files, err := fs.Glob(schemaFiles, "schemas/*.json")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := schemaFiles.ReadFile(file)
+		if err != nil {
+			panic(err)
+		}
+		s, err := ParseSchema(data)
+		if err != nil {
+			panic(fmt.Sprintf("operations: invalid parameter schema %s: %v", file, err))
+		}
+		loaded[strings.TrimSuffix(strings.TrimPrefix(file, "schemas/"), ".json")] = s
+	}
+	return loaded
+}
+
+// ParseSchema reads a parameter schema and checks that it only uses the
+// supported keywords
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	if s.Type != "object" {
+		return nil, fmt.Errorf("schema type must be object, got %q", s.Type)
+	}
+
+	s.patterns = map[string]*regexp.Regexp{}
+	for name, p := range s.Properties {
+		if p == nil {
+			return nil, fmt.Errorf("property %q has no schema", name)
+		}
+		switch p.Type {
+		case ParamString, ParamInteger, ParamNumber, ParamBoolean:
+		default:
+			return nil, fmt.Errorf("property %q has unsupported type %q", name, p.Type)
+		}
+		if p.Pattern != "" {
+			re, err := regexp.Compile(p.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("property %q: invalid pattern: %w", name, err)
+			}
+			s.patterns[name] = re
+		}
+	}
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			return nil, fmt.Errorf("required parameter %q is not a property", name)
+		}
+	}
+	return &s, nil
+}
+
+// ParamSchema returns the schema of the extra parameters of the pipeline of
+// the operation, false if the pipeline takes none
+func (o *Operation) ParamSchema() (*Schema, bool) {
+	s, ok := schemas[o.Pipeline]
+	return s, ok
+}
+
+// Names returns the names of the parameters of the schema, sorted
+func (s *Schema) Names() []string {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseParamArgs parses parameters given as key=value, e.g. by --param.
+// Values are strings until Check converts them to the type of their schema.
+func ParseParamArgs(args []string) (map[string]any, error) {
+	params := make(map[string]any, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid parameter %q, must be key=value", arg)
+		}
+		params[strings.TrimSpace(key)] = value
+	}
+	return params, nil
+}
+
+// ParseParamSet parses a set of parameters, a YAML or JSON map of parameter
+// names to values, e.g. the machine CIDR and capacity shared by the regions of
+// an environment
+func ParseParamSet(data []byte) (map[string]any, error) {
+	var params map[string]any
+	if err := yaml.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("parameters must be a map of names to values: %w", err)
+	}
+	for name, value := range params {
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return nil, fmt.Errorf("parameter %q must be a string, a number or a boolean", name)
+		}
+	}
+	return params, nil
+}
+
+// Check validates raw parameters against the schema and converts them to
+// the types of their properties. String values, as given on the command
+// line, are parsed into integers, numbers and booleans. Errors are
+// *api.ValidationError.
+func (s *Schema) Check(raw map[string]any) (Params, error) {
+	params := make(Params, len(raw))
+	for _, name := range sortedKeys(raw) {
+		p, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && *s.AdditionalProperties {
+				params[name] = raw[name]
+				continue
+			}
+			return nil, paramError(name, "unknown parameter %q, must be one of %s", name, strings.Join(s.Names(), ", "))
+		}
+		value, err := convertParam(p.Type, raw[name])
+		if err != nil {
+			return nil, paramError(name, "parameter %s %v", name, err)
+		}
+		if err := s.checkProperty(name, p, value); err != nil {
+			return nil, err
+		}
+		params[name] = value
+	}
+	for _, name := range s.Required {
+		if _, ok := params[name]; !ok {
+			return nil, paramError(name, "parameter %s is required", name)
+		}
+	}
+	return params, nil
+}
+
+// checkProperty checks the constraints of a property on a converted value
+func (s *Schema) checkProperty(name string, p *Property, value any) error {
+	if len(p.Enum) > 0 && !slices.ContainsFunc(p.Enum, func(e any) bool { return sameValue(e, value) }) {
+		allowed := make([]string, 0, len(p.Enum))
+		for _, e := range p.Enum {
+			allowed = append(allowed, fmt.Sprint(e))
+		}
+		return paramError(name, "parameter %s must be one of %s, got %v", name, strings.Join(allowed, ", "), value)
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if p.MinLength != nil && length < *p.MinLength {
+			return paramError(name, "parameter %s must be at least %d characters", name, *p.MinLength)
+		}
+		if p.MaxLength != nil && length > *p.MaxLength {
+			return paramError(name, "parameter %s must be at most %d characters", name, *p.MaxLength)
+		}
+		if re := s.patterns[name]; re != nil && !re.MatchString(v) {
+			return paramError(name, "parameter %s must match %s, got %q", name, p.Pattern, v)
+		}
+	case int64, float64:
+		n := toFloat(v)
+		if p.Minimum != nil && n < *p.Minimum {
+			return paramError(name, "parameter %s must be at least %v, got %v", name, *p.Minimum, v)
+		}
+		if p.Maximum != nil && n > *p.Maximum {
+			return paramError(name, "parameter %s must be at most %v, got %v", name, *p.Maximum, v)
+		}
+	}
+	return nil
+}
+
+// BuildPayloadWithParams returns the webhook payload of request values with
+// the extra parameters under ParamsKey. Without parameters the payload is
+// that of BuildPayload, so listeners that predate parameters accept it.
+func (o *Operation) BuildPayloadWithParams(v Values, params Params) (any, error) {
+	payload := o.BuildPayload(v)
+	if len(params) == 0 {
+		return payload, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, errors.New("the payload of the operation is not an object and cannot carry parameters")
+	}
+	if _, ok := object[ParamsKey]; ok {
+		return nil, fmt.Errorf("the payload of the operation already has a %s key", ParamsKey)
+	}
+	object[ParamsKey] = params
+	return object, nil
+}
+
+// convertParam converts a raw value into a value of a parameter type
+func convertParam(typ string, value any) (any, error) {
+	switch typ {
+	case ParamString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case ParamInteger:
+		switch v := value.(type) {
+		case string:
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("must be an integer, got %q", v)
+			}
+			return n, nil
+		case float64:
+			if v != math.Trunc(v) {
+				return nil, fmt.Errorf("must be an integer, got %v", v)
+			}
+			return int64(v), nil
+		}
+		return nil, fmt.Errorf("must be an integer")
+	case ParamNumber:
+		switch v := value.(type) {
+		case string:
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("must be a number, got %q", v)
+			}
+			return n, nil
+		case float64:
+			return v, nil
+		}
+		return nil, fmt.Errorf("must be a number")
+	case ParamBoolean:
+		switch v := value.(type) {
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("must be true or false, got %q", v)
+			}
+			return b, nil
+		case bool:
+			return v, nil
+		}
+		return nil, fmt.Errorf("must be true or false")
+	}
+	return nil, fmt.Errorf("must be a %s", typ)
+}
+
+// sameValue compares an enum value of a schema, decoded from JSON, with a
+// converted parameter value
+func sameValue(enum, value any) bool {
+	switch v := value.(type) {
+	case int64, float64:
+		e, ok := enum.(float64)
+		return ok && e == toFloat(v)
+	default:
+		return enum == value
+	}
+}
+
+func toFloat(v any) float64 {
+	if n, ok := v.(int64); ok {
+		return float64(n)
+	}
+	return v.(float64)
+}
+
+func paramError(name, format string, args ...any) error {
+	return &api.ValidationError{Field: ParamsKey + "." + name, Message: fmt.Sprintf(format, args...)}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,162 @@
+package operations
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+	s, err := ParseSchema([]byte(`{
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["cidr"],
+		"properties": {
+			"cidr": {"type": "string", "pattern": "^[0-9./]+$", "maxLength": 18},
+			"capacity": {"type": "integer", "minimum": 1, "maximum": 10},
+			"ratio": {"type": "number", "enum": [0.5, 1]},
+			"spot": {"type": "boolean"},
+			"tier": {"type": "string", "enum": ["small", "large"]}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSchema_Check(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       map[string]any
+		want      Params
+		wantField string
+	}{
+		{
+			name: "flags are converted",
+			raw:  map[string]any{"cidr": "10.0.0.0/16", "capacity": "3", "ratio": "0.5", "spot": "true"},
+			want: Params{"cidr": "10.0.0.0/16", "capacity": int64(3), "ratio": 0.5, "spot": true},
+		},
+		{
+			name: "file values keep their type",
+			raw:  map[string]any{"cidr": "10.0.0.0/16", "capacity": float64(10), "ratio": float64(1), "tier": "large"},
+			want: Params{"cidr": "10.0.0.0/16", "capacity": int64(10), "ratio": 1.0, "tier": "large"},
+		},
+		{name: "unknown", raw: map[string]any{"cidr": "10.0.0.0/16", "cidrs": "x"}, wantField: "params.cidrs"},
+		{name: "required", raw: map[string]any{"capacity": "3"}, wantField: "params.cidr"},
+		{name: "pattern", raw: map[string]any{"cidr": "ten"}, wantField: "params.cidr"},
+		{name: "max length", raw: map[string]any{"cidr": "10.100.100.100/16/1"}, wantField: "params.cidr"},
+		{name: "not an integer", raw: map[string]any{"cidr": "10.0.0.0/16", "capacity": "3.5"}, wantField: "params.capacity"},
+		{name: "fraction", raw: map[string]any{"cidr": "10.0.0.0/16", "capacity": 3.5}, wantField: "params.capacity"},
+		{name: "maximum", raw: map[string]any{"cidr": "10.0.0.0/16", "capacity": "11"}, wantField: "params.capacity"},
+		{name: "number enum", raw: map[string]any{"cidr": "10.0.0.0/16", "ratio": "0.75"}, wantField: "params.ratio"},
+		{name: "string enum", raw: map[string]any{"cidr": "10.0.0.0/16", "tier": "medium"}, wantField: "params.tier"},
+		{name: "boolean", raw: map[string]any{"cidr": "10.0.0.0/16", "spot": "maybe"}, wantField: "params.spot"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testSchema(t).Check(tt.raw)
+			if tt.wantField == "" {
+				if err != nil || !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Check() = %#v, %v, want %#v", got, err, tt.want)
+				}
+				return
+			}
+			var verr *api.ValidationError
+			if !errors.As(err, &verr) || verr.Field != tt.wantField {
+				t.Errorf("Check() error = %v, want a validation error of %s", err, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestParseSchema_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"not an object":       `{"type": "array"}`,
+		"unsupported type":    `{"type": "object", "properties": {"tags": {"type": "array"}}}`,
+		"unsupported keyword": `{"type": "object", "properties": {"cidr": {"type": "string", "format": "cidr"}}}`,
+		"invalid pattern":     `{"type": "object", "properties": {"cidr": {"type": "string", "pattern": "("}}}`,
+		"unknown required":    `{"type": "object", "required": ["cidr"], "properties": {}}`,
+	} {
+		if _, err := ParseSchema([]byte(data)); err == nil {
+			t.Errorf("%s: ParseSchema() succeeded", name)
+		}
+	}
+}
+
+func TestParseParamArgs(t *testing.T) {
+	got, err := ParseParamArgs([]string{"machine_cidr=10.0.0.0/16", "note=a=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"machine_cidr": "10.0.0.0/16", "note": "a=b", "empty": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseParamArgs() = %v, want %v", got, want)
+	}
+
+	for _, arg := range []string{"machine_cidr", "=10.0.0.0/16"} {
+		if _, err := ParseParamArgs([]string{arg}); err == nil {
+			t.Errorf("ParseParamArgs(%q) succeeded", arg)
+		}
+	}
+}
+
+func TestParseParamSet(t *testing.T) {
+	got, err := ParseParamSet([]byte("machine_cidr: 10.0.0.0/16\nnode_capacity: 50\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["machine_cidr"] != "10.0.0.0/16" || got["node_capacity"] != float64(50) {
+		t.Errorf("ParseParamSet() = %v", got)
+	}
+
+	for _, data := range []string{"- machine_cidr\n", "tags: [a, b]\n"} {
+		if _, err := ParseParamSet([]byte(data)); err == nil {
+			t.Errorf("ParseParamSet(%q) succeeded", data)
+		}
+	}
+}
+
+func TestBuildPayloadWithParams(t *testing.T) {
+	op, _ := Lookup("region add")
+	v := Values{"environment": "production", "region": "us-central1", "sector": "main"}
+
+	payload, err := op.BuildPayloadWithParams(v, nil)
+	if err != nil || !reflect.DeepEqual(payload, op.BuildPayload(v)) {
+		t.Errorf("BuildPayloadWithParams() without params = %v, %v, want the payload of the fields", payload, err)
+	}
+
+	payload, err = op.BuildPayloadWithParams(v, Params{"node_capacity": int64(50)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(payload)
+	want := `{"environment":"production","params":{"node_capacity":50},"region":"us-central1","sector":"main"}`
+	if string(data) != want {
+		t.Errorf("payload = %s, want %s", data, want)
+	}
+}
+
+func TestRegionParamSchema(t *testing.T) {
+	op, _ := Lookup("region add")
+	s, ok := op.ParamSchema()
+	if !ok {
+		t.Fatal("the region pipeline has no parameter schema")
+	}
+	if _, err := s.Check(map[string]any{"machine_cidr": "10.0.0.0/16", "maintenance_window": "SUN-02:00", "node_capacity": "50"}); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	if _, err := s.Check(map[string]any{"maintenance_window": "sunday"}); err == nil {
+		t.Error("Check() accepted an invalid maintenance window")
+	}
+
+	sector, _ := Lookup("sector add")
+	if _, ok := sector.ParamSchema(); ok {
+		t.Error("the sector pipeline should take no parameters")
+	}
+}
@@ -0,0 +1,90 @@
+package operations
+
+import (
+	"fmt"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// regionFields identify a region in an environment and sector
+var regionFields = []Field{
+	{Name: "environment", Shorthand: "e", Description: "target environment", Required: true, Catalog: true},
+	{Name: "region", Shorthand: "r", Description: "GCP region", Required: true, Catalog: true},
+	{Name: "sector", Shorthand: "s", Description: "deployment sector", Required: true, Catalog: true},
+}
+
+// regionRequest converts request values into the payload of the region
+// provisioning webhook
+func regionRequest(v Values, action string) *api.RegionRequest {
+	return &api.RegionRequest{
+		Environment: v["environment"],
+		Region:      v["region"],
+		Sector:      v["sector"],
+		Action:      action,
+	}
+}
+
+// regionState describes a region pipeline run as the region lifecycle state,
+// e.g. Provisioned or Deleting
+func regionState(action string) func(v Values, run *api.PipelineRunStatus) string {
+	return func(v Values, run *api.PipelineRunStatus) string {
+		status := api.RegionStatus{Action: action, Status: run.Status}
+		return status.State()
+	}
+}
+
+func init() {
+	Register(&Operation{
+		Group: "region",
+		Verb:  "add",
+		Short: "Trigger provisioning of a region",
+		Long: `Trigger the Tekton pipeline that provisions a region in an environment and sector.
+
+The command returns as soon as the pipeline is triggered. Use the event ID it
+prints with 'gcpctl region status' to follow the pipeline.
+
+With --file, the regions of a YAML or JSON list are validated and submitted
+together, e.g. to bootstrap an environment.`,
+		Example: `  gcpctl region add --environment production --region us-central1 --sector main
+  gcpctl region add -e integration -r asia-east1 -s test --timeout 60s
+  gcpctl region add -f regions.yaml --concurrency 8`,
+		Fields: regionFields,
+		Validate: func(v Values) error {
+			return regionRequest(v, "").Validate()
+		},
+		// Payloads without an action provision the region
+		Payload: func(v Values) any {
+			return regionRequest(v, "")
+		},
+		Pipeline:  client.RegionPipelineName,
+		State:     regionState(api.RegionActionAdd),
+		Triggered: "Region provisioning initiated",
+	})
+
+	Register(&Operation{
+		Group: "region",
+		Verb:  "delete",
+		Short: "Trigger deletion of a region",
+		Long: `Trigger the Tekton pipeline that destroys the resources of a region in an
+environment and sector.
+
+The command asks for confirmation unless --yes is given. Like 'region add', it
+returns as soon as the pipeline is triggered.`,
+		Example: `  gcpctl region delete --environment integration --region asia-east1 --sector test
+  gcpctl region delete -e integration -r asia-east1 -s test --yes`,
+		Fields: regionFields,
+		Validate: func(v Values) error {
+			return regionRequest(v, api.RegionActionDelete).Validate()
+		},
+		Payload: func(v Values) any {
+			return regionRequest(v, api.RegionActionDelete)
+		},
+		Pipeline: client.RegionPipelineName,
+		State:    regionState(api.RegionActionDelete),
+		Confirm: func(v Values) string {
+			return fmt.Sprintf("Delete region %s in %s/%s? This destroys its resources.", v["region"], v["environment"], v["sector"])
+		},
+		Triggered: "Region deletion initiated",
+	})
+}
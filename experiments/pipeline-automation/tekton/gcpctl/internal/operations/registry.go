@@ -0,0 +1,215 @@
+// Package operations is the registry of pipeline-backed operations gcpctl can
+// trigger. Every operation describes its request fields, how they are
+// validated, the webhook route its payload is posted to, and what the status
+// of its pipeline run means. gcpctl builds a command for every operation
+// registered here, so a new operation is added by registering it from an
+// init function in a new file of this package.
+package operations
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// Field types
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+)
+
+// Field is a request field of an operation. It becomes a command flag of the
+// same name and a key of the webhook payload.
+type Field struct {
+	// Name is the payload key and flag name, e.g. environment
+	Name string
+	// Shorthand is the one-letter flag, optional
+	Shorthand string
+	// Description is the flag usage
+	Description string
+	// Type is TypeString (the default) or TypeInt
+	Type string
+	// Required fields must not be empty
+	Required bool
+	// Default is used when the flag is not given
+	Default string
+	// Allowed lists the accepted values; empty accepts any value
+	Allowed []string
+	// Catalog checks environment, sector and region fields against the
+	// catalog list of the same name, see 'gcpctl catalog'
+	Catalog bool
+}
+
+// Values are the field values of a request, by field name
+type Values map[string]string
+
+// Operation is a pipeline-backed operation, e.g. region add
+type Operation struct {
+	// Group and Verb name the command: gcpctl <group> <verb>
+	Group string
+	Verb  string
+	// Short, Long and Example document the command
+	Short   string
+	Long    string
+	Example string
+
+	// Fields is the request schema
+	Fields []Field
+	// Validate checks rules across fields, after every field was checked
+	// on its own; optional
+	Validate func(v Values) error
+
+	// Route is the webhook path the payload is posted to, relative to the
+	// Tekton webhook URL; empty posts to the webhook URL itself
+	Route string
+	// Payload builds the webhook payload; by default the payload is a JSON
+	// object of the non-empty field values. Numbers are sent as strings.
+	Payload func(v Values) any
+
+	// Pipeline is the name of the pipeline the webhook starts
+	Pipeline string
+	// State describes the outcome of a pipeline run of the operation, e.g.
+	// Provisioned, from its status; by default the pipeline run status
+	State func(v Values, run *api.PipelineRunStatus) string
+	// Confirm returns the question asked before triggering the operation,
+	// e.g. for destructive operations; nil triggers without asking
+	Confirm func(v Values) string
+	// Triggered is printed when the webhook accepted the request; by default
+	// "<name> triggered"
+	Triggered string
+}
+
+// Name returns the name of the operation, e.g. "region add"
+func (o *Operation) Name() string {
+	return o.Group + " " + o.Verb
+}
+
+// Check validates request values against the fields of the operation and its
+// Validate rule. Errors are *api.ValidationError.
+func (o *Operation) Check(v Values) error {
+	for _, f := range o.Fields {
+		value := v[f.Name]
+		if value == "" {
+			if f.Required {
+				return &api.ValidationError{Field: f.Name, Message: f.Name + " is required"}
+			}
+			continue
+		}
+		if f.Type == TypeInt {
+			if _, err := strconv.Atoi(value); err != nil {
+				return &api.ValidationError{Field: f.Name, Message: fmt.Sprintf("%s must be a number, got %q", f.Name, value)}
+			}
+		}
+		if len(f.Allowed) > 0 && !slices.Contains(f.Allowed, value) {
+			return &api.ValidationError{
+				Field:   f.Name,
+				Message: fmt.Sprintf("%s must be one of %s, got %q", f.Name, strings.Join(f.Allowed, ", "), value),
+			}
+		}
+	}
+	if o.Validate != nil {
+		return o.Validate(v)
+	}
+	return nil
+}
+
+// BuildPayload returns the webhook payload of request values
+func (o *Operation) BuildPayload(v Values) any {
+	if o.Payload != nil {
+		return o.Payload(v)
+	}
+	payload := make(map[string]string, len(v))
+	for name, value := range v {
+		if value != "" {
+			payload[name] = value
+		}
+	}
+	return payload
+}
+
+// TriggeredMessage returns the message printed when the webhook accepted a
+// request of the operation
+func (o *Operation) TriggeredMessage() string {
+	if o.Triggered != "" {
+		return o.Triggered
+	}
+	return strings.ToUpper(o.Name()[:1]) + o.Name()[1:] + " triggered"
+}
+
+// DescribeState returns the outcome of a pipeline run of the operation
+func (o *Operation) DescribeState(v Values, run *api.PipelineRunStatus) string {
+	if o.State != nil {
+		return o.State(v, run)
+	}
+	return run.Status
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]*Operation{}
+)
+
+// Register adds an operation to the registry. It panics if the operation is
+// incomplete or already registered, as registrations are made from init
+// functions.
+func Register(op *Operation) {
+	if err := op.check(); err != nil {
+		panic(fmt.Sprintf("operations: invalid operation %q: %v", op.Name(), err))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[op.Name()]; ok {
+		panic(fmt.Sprintf("operations: operation %q registered twice", op.Name()))
+	}
+	registry[op.Name()] = op
+}
+
+// Lookup returns the operation with the given name, e.g. "region add"
+func Lookup(name string) (*Operation, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	op, ok := registry[name]
+	return op, ok
+}
+
+// All returns the registered operations sorted by name
+func All() []*Operation {
+	mu.RLock()
+	defer mu.RUnlock()
+	ops := make([]*Operation, 0, len(registry))
+	for _, op := range registry {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Name() < ops[j].Name() })
+	return ops
+}
+
+// check validates the definition of an operation
+func (o *Operation) check() error {
+	if o.Group == "" || o.Verb == "" {
+		return fmt.Errorf("group and verb are required")
+	}
+	if o.Pipeline == "" {
+		return fmt.Errorf("pipeline is required")
+	}
+	seen := map[string]bool{}
+	for _, f := range o.Fields {
+		if f.Name == "" {
+			return fmt.Errorf("field without a name")
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("field %q defined twice", f.Name)
+		}
+		seen[f.Name] = true
+		if f.Type != "" && f.Type != TypeString && f.Type != TypeInt {
+			return fmt.Errorf("field %q has unknown type %q", f.Name, f.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package operations
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+func testOperation() *Operation {
+	return &Operation{
+		Group:    "pool",
+		Verb:     "resize",
+		Pipeline: "gcp-pool-resize-pipeline",
+		Fields: []Field{
+			{Name: "pool", Required: true},
+			{Name: "size", Type: TypeInt, Required: true},
+			{Name: "mode", Allowed: []string{"surge", "drain"}},
+		},
+	}
+}
+
+func TestOperation_Check(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    Values
+		wantField string
+	}{
+		{name: "valid", values: Values{"pool": "workers", "size": "3", "mode": "surge"}},
+		{name: "optional field empty", values: Values{"pool": "workers", "size": "3"}},
+		{name: "required field missing", values: Values{"size": "3"}, wantField: "pool"},
+		{name: "not a number", values: Values{"pool": "workers", "size": "three"}, wantField: "size"},
+		{name: "value not allowed", values: Values{"pool": "workers", "size": "3", "mode": "replace"}, wantField: "mode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testOperation().Check(tt.values)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("Check() error = %v", err)
+				}
+				return
+			}
+			var verr *api.ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Check() error = %v, want a ValidationError", err)
+			}
+			if verr.Field != tt.wantField {
+				t.Errorf("Field = %q, want %q", verr.Field, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestOperation_CheckRunsValidate(t *testing.T) {
+	op := testOperation()
+	op.Validate = func(v Values) error {
+		return &api.ValidationError{Field: "size", Message: "size must be positive"}
+	}
+
+	if err := op.Check(Values{"pool": "workers", "size": "-1"}); err == nil || !strings.Contains(err.Error(), "positive") {
+		t.Errorf("Check() error = %v, want the Validate error", err)
+	}
+	if err := op.Check(Values{"size": "-1"}); err == nil || !strings.Contains(err.Error(), "pool is required") {
+		t.Errorf("Check() error = %v, want the field error first", err)
+	}
+}
+
+func TestOperation_BuildPayload(t *testing.T) {
+	op := testOperation()
+	got := op.BuildPayload(Values{"pool": "workers", "size": "3", "mode": ""})
+	want := map[string]string{"pool": "workers", "size": "3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildPayload() = %v, want %v", got, want)
+	}
+
+	op.Payload = func(v Values) any { return v["pool"] }
+	if got := op.BuildPayload(Values{"pool": "workers"}); got != "workers" {
+		t.Errorf("BuildPayload() = %v, want the custom payload", got)
+	}
+}
+
+func TestOperation_Defaults(t *testing.T) {
+	op := testOperation()
+	if got := op.TriggeredMessage(); got != "Pool resize triggered" {
+		t.Errorf("TriggeredMessage() = %q", got)
+	}
+	if got := op.DescribeState(nil, &api.PipelineRunStatus{Status: "Failed"}); got != "Failed" {
+		t.Errorf("DescribeState() = %q, want the run status", got)
+	}
+}
+
+func TestRegister_Panics(t *testing.T) {
+	tests := []struct {
+		name string
+		op   *Operation
+	}{
+		{name: "duplicate", op: &Operation{Group: "region", Verb: "add", Pipeline: "p"}},
+		{name: "no pipeline", op: &Operation{Group: "pool", Verb: "resize"}},
+		{name: "no verb", op: &Operation{Group: "pool", Pipeline: "p"}},
+		{name: "field defined twice", op: &Operation{Group: "pool", Verb: "resize", Pipeline: "p",
+			Fields: []Field{{Name: "pool"}, {Name: "pool"}}}},
+		{name: "unknown type", op: &Operation{Group: "pool", Verb: "resize", Pipeline: "p",
+			Fields: []Field{{Name: "size", Type: "float"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Register() did not panic")
+				}
+			}()
+			Register(tt.op)
+		})
+	}
+}
+
+func TestBuiltinOperations(t *testing.T) {
+	var names []string
+	for _, op := range All() {
+		names = append(names, op.Name())
+	}
+	want := []string{"region add", "region delete", "sector add"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("All() = %v, want %v", names, want)
+	}
+}
+
+func TestRegionOperations(t *testing.T) {
+	values := Values{"environment": "integration", "region": "us-central1", "sector": "main"}
+
+	add, _ := Lookup("region add")
+	if err := add.Check(values); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if req := add.BuildPayload(values).(*api.RegionRequest); req.Action != "" || req.Region != "us-central1" {
+		t.Errorf("add payload = %+v", req)
+	}
+	if got := add.DescribeState(values, &api.PipelineRunStatus{Status: "Succeeded"}); got != "Provisioned" {
+		t.Errorf("add state = %q, want Provisioned", got)
+	}
+
+	del, _ := Lookup("region delete")
+	if req := del.BuildPayload(values).(*api.RegionRequest); req.Action != api.RegionActionDelete {
+		t.Errorf("delete payload = %+v", req)
+	}
+	if del.Confirm == nil || !strings.Contains(del.Confirm(values), "us-central1") {
+		t.Error("delete does not ask for confirmation")
+	}
+}
+
+func TestSectorAdd(t *testing.T) {
+	op, ok := Lookup("sector add")
+	if !ok {
+		t.Fatal("sector add is not registered")
+	}
+
+	tests := []struct {
+		name    string
+		values  Values
+		wantErr bool
+	}{
+		{name: "valid", values: Values{"environment": "integration", "sector": "canary"}},
+		{name: "copy", values: Values{"environment": "integration", "sector": "canary", "copy-from": "main"}},
+		{name: "copy from itself", values: Values{"environment": "integration", "sector": "main", "copy-from": "main"}, wantErr: true},
+		{name: "too long", values: Values{"environment": "integration", "sector": strings.Repeat("s", maxSectorLength+1)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := op.Check(tt.values); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	payload := op.BuildPayload(Values{"environment": "integration", "sector": "canary", "copy-from": ""})
+	if want := map[string]string{"environment": "integration", "sector": "canary"}; !reflect.DeepEqual(payload, want) {
+		t.Errorf("BuildPayload() = %v, want %v", payload, want)
+	}
+}
@@ -0,0 +1,83 @@
+package operations
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ParseRequests parses a list of requests of the operation, in YAML or JSON,
+// as given to --file. Every request is a map of field names to values, e.g.
+// {"environment": "production", "region": "us-central1", "sector": "main"}.
+// Fields a request does not set are empty. Keys that are not fields of the
+// operation are rejected, so a typo does not silently drop a value.
+func (o *Operation) ParseRequests(data []byte) ([]Values, error) {
+	var entries []map[string]any
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("requests must be a list of maps of field values: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no requests found")
+	}
+
+	names := make([]string, 0, len(o.Fields))
+	for _, f := range o.Fields {
+		names = append(names, f.Name)
+	}
+
+	requests := make([]Values, 0, len(entries))
+	for i, entry := range entries {
+		v := Values{}
+		for key, value := range entry {
+			if !o.hasField(key) {
+				return nil, fmt.Errorf("request %d: unknown field %q, must be one of %s", i+1, key, strings.Join(names, ", "))
+			}
+			s, err := scalarString(value)
+			if err != nil {
+				return nil, fmt.Errorf("request %d: field %q: %w", i+1, key, err)
+			}
+			v[key] = s
+		}
+		requests = append(requests, v)
+	}
+	return requests, nil
+}
+
+// Describe returns the non-empty field values of a request in the order of
+// the fields, e.g. "production/us-central1/main"
+func (o *Operation) Describe(v Values) string {
+	var parts []string
+	for _, f := range o.Fields {
+		if v[f.Name] != "" {
+			parts = append(parts, v[f.Name])
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (o *Operation) hasField(name string) bool {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scalarString converts a YAML scalar into a field value
+func scalarString(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("must be a string or a number")
+	}
+}
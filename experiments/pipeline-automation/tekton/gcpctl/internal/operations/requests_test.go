@@ -0,0 +1,59 @@
+package operations
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOperation_ParseRequests(t *testing.T) {
+	op := testOperation()
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []Values
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			data: "- pool: workers\n  size: 3\n  mode: surge\n- pool: infra\n  size: \"2\"\n",
+			want: []Values{{"pool": "workers", "size": "3", "mode": "surge"}, {"pool": "infra", "size": "2"}},
+		},
+		{
+			name: "json",
+			data: `[{"pool": "workers", "size": 3}]`,
+			want: []Values{{"pool": "workers", "size": "3"}},
+		},
+		{name: "empty value", data: "- pool: workers\n  mode:\n", want: []Values{{"pool": "workers", "mode": ""}}},
+		{name: "unknown field", data: "- pool: workers\n- pol: infra\n", wantErr: `request 2: unknown field "pol"`},
+		{name: "not a scalar", data: "- pool: [workers]\n", wantErr: `request 1: field "pool"`},
+		{name: "not a list", data: "pool: workers\n", wantErr: "must be a list"},
+		{name: "empty", data: "", wantErr: "no requests"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := op.ParseRequests([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseRequests() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRequests() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRequests() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperation_Describe(t *testing.T) {
+	op := testOperation()
+	if got := op.Describe(Values{"size": "3", "pool": "workers"}); got != "workers/3" {
+		t.Errorf("Describe() = %q, want workers/3", got)
+	}
+}
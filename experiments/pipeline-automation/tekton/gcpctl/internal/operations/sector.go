@@ -0,0 +1,55 @@
+package operations
+
+import (
+	"fmt"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// maxSectorLength is the longest sector name the pipelines accept
+const maxSectorLength = 40
+
+func init() {
+	Register(&Operation{
+		Group: "sector",
+		Verb:  "add",
+		Short: "Trigger creation of a sector",
+		Long: `Trigger the Tekton pipeline that creates a deployment sector in an environment.
+
+The sector can then be used by 'region add'. The payload is posted to the
+sector route of the Tekton webhook URL, which must be served by an
+EventListener starting the sector provisioning pipeline.
+
+With --regions, the regions of the sector definition of the catalog are
+provisioned once the sector is created, in the order of their dependencies.`,
+		Example: `  gcpctl sector add --environment integration --sector canary
+  gcpctl sector add -e production -s canary --copy-from main
+  gcpctl sector add -e production -s main --regions`,
+		Fields: []Field{
+			{Name: "environment", Shorthand: "e", Description: "target environment", Required: true, Catalog: true},
+			{Name: "sector", Shorthand: "s", Description: "name of the new sector", Required: true},
+			{Name: "copy-from", Description: "existing sector to copy the configuration from"},
+		},
+		Validate: func(v Values) error {
+			if len(v["sector"]) > maxSectorLength {
+				return &api.ValidationError{
+					Field:   "sector",
+					Message: fmt.Sprintf("sector %q is longer than %d characters", v["sector"], maxSectorLength),
+				}
+			}
+			if v["copy-from"] == v["sector"] {
+				return &api.ValidationError{Field: "copy-from", Message: "a sector cannot be copied from itself"}
+			}
+			return nil
+		},
+		Route:    "sector",
+		Pipeline: "gcp-sector-provisioning-pipeline",
+		State: func(v Values, run *api.PipelineRunStatus) string {
+			if run.Status == "Succeeded" {
+				return "Created"
+			}
+			return run.Status
+		},
+		Triggered: "Sector creation initiated",
+	})
+}
@@ -0,0 +1,594 @@
+// Package payloadgen generates the webhook payload types of gcpctl from the
+// JSON schemas committed in pkg/api/schemas. Each schema becomes a Go type
+// with a Validate method checking the schema constraints, and a section of
+// the payload documentation listing the TriggerBinding parameter every field
+// is bound to. The TriggerBinding of a schema is checked against it, so the
+// CLI and the pipelines cannot drift on field names.
+//
+// Only a subset of JSON Schema is supported: an object of string and object
+// properties, constrained with required, enum, pattern, minLength and
+// maxLength, plus these extensions:
+//
+//   - x-go-type: the name of the generated type
+//   - x-tekton-binding: the name of the TriggerBinding reading the payload
+//     and its path, relative to the root of the Tekton resources
+//   - x-tekton-param: on a property, the TriggerBinding parameter bound to
+//     it and its value
+//
+// Other keywords are rejected rather than ignored.
+package payloadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Property types of a Schema
+const (
+	TypeString = "string"
+	TypeObject = "object"
+)
+
+// Schema is the JSON schema of a webhook payload
+type Schema struct {
+	// Path is the file the schema was loaded from
+	Path string `json:"-"`
+
+	Schema      string   `json:"$schema,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Type        string   `json:"type"`
+	Required    []string `json:"required,omitempty"`
+	GoType      string   `json:"x-go-type"`
+	Binding     *Binding `json:"x-tekton-binding,omitempty"`
+	// Properties are in the order of the schema file
+	Properties []*Property `json:"-"`
+}
+
+// Binding is the TriggerBinding reading a payload
+type Binding struct {
+	Name string `json:"name"`
+	// Path is the TriggerBinding file, relative to the root of the Tekton
+	// resources
+	Path string `json:"path"`
+}
+
+// Property is a field of a payload
+type Property struct {
+	Name string `json:"-"`
+
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	MinLength   *int     `json:"minLength,omitempty"`
+	MaxLength   *int     `json:"maxLength,omitempty"`
+	// GoName is the name of the Go field, the camel-cased property name by
+	// default
+	GoName string `json:"x-go-name,omitempty"`
+	// Param is the TriggerBinding parameter bound to the property, if any
+	Param *Param `json:"x-tekton-param,omitempty"`
+}
+
+// Param is a TriggerBinding parameter
+type Param struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// required reports whether a property is required by the schema
+func (s *Schema) required(p *Property) bool {
+	return slices.Contains(s.Required, p.Name)
+}
+
+// ParseSchema reads a payload schema and checks that it only uses the
+// supported keywords
+func ParseSchema(data []byte) (*Schema, error) {
+	var raw struct {
+		Schema
+		Properties json.RawMessage `json:"properties"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	s := raw.Schema
+	if s.Type != TypeObject {
+		return nil, fmt.Errorf("schema type must be object, got %q", s.Type)
+	}
+	if s.Title == "" {
+		return nil, fmt.Errorf("schema has no title")
+	}
+	if !token.IsExported(s.GoType) || !token.IsIdentifier(s.GoType) {
+		return nil, fmt.Errorf("x-go-type must be an exported Go identifier, got %q", s.GoType)
+	}
+	if s.Binding != nil && (s.Binding.Name == "" || s.Binding.Path == "") {
+		return nil, fmt.Errorf("x-tekton-binding needs a name and a path")
+	}
+
+	properties, err := parseProperties(raw.Properties)
+	if err != nil {
+		return nil, err
+	}
+	s.Properties = properties
+
+	names := map[string]bool{}
+	goNames := map[string]bool{}
+	params := map[string]bool{}
+	for _, p := range s.Properties {
+		if err := checkProperty(p); err != nil {
+			return nil, fmt.Errorf("property %q: %w", p.Name, err)
+		}
+		if goNames[p.GoName] {
+			return nil, fmt.Errorf("property %q: Go field %s is used twice", p.Name, p.GoName)
+		}
+		if p.Param != nil {
+			if params[p.Param.Name] {
+				return nil, fmt.Errorf("property %q: parameter %s is bound twice", p.Name, p.Param.Name)
+			}
+			params[p.Param.Name] = true
+		}
+		names[p.Name] = true
+		goNames[p.GoName] = true
+	}
+	for _, name := range s.Required {
+		if !names[name] {
+			return nil, fmt.Errorf("required property %q is not defined", name)
+		}
+	}
+	return &s, nil
+}
+
+// parseProperties reads the properties object in the order of the file, the
+// order of the fields of the generated type and of their validation
+func parseProperties(data json.RawMessage) ([]*Property, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("schema has no properties")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("properties must be an object")
+	}
+
+	var properties []*Property
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse properties: %w", err)
+		}
+		name := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("failed to parse property %q: %w", name, err)
+		}
+
+		p := &Property{Name: name}
+		pdec := json.NewDecoder(bytes.NewReader(value))
+		pdec.DisallowUnknownFields()
+		if err := pdec.Decode(p); err != nil {
+			return nil, fmt.Errorf("failed to parse property %q: %w", name, err)
+		}
+		if p.GoName == "" {
+			p.GoName = goName(name)
+		}
+		properties = append(properties, p)
+	}
+	return properties, nil
+}
+
+// checkProperty checks that the keywords of a property suit its type
+func checkProperty(p *Property) error {
+	if !token.IsExported(p.GoName) || !token.IsIdentifier(p.GoName) {
+		return fmt.Errorf("Go field name %q is not an exported identifier", p.GoName)
+	}
+	if p.Param != nil && (p.Param.Name == "" || p.Param.Value == "") {
+		return fmt.Errorf("x-tekton-param needs a name and a value")
+	}
+	switch p.Type {
+	case TypeString:
+		if p.Pattern != "" {
+			if _, err := regexp.Compile(p.Pattern); err != nil {
+				return fmt.Errorf("invalid pattern: %w", err)
+			}
+		}
+		if p.MinLength != nil && p.MaxLength != nil && *p.MinLength > *p.MaxLength {
+			return fmt.Errorf("minLength is greater than maxLength")
+		}
+	case TypeObject:
+		if len(p.Enum) > 0 || p.Pattern != "" || p.MinLength != nil || p.MaxLength != nil {
+			return fmt.Errorf("object properties support no constraints")
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", p.Type)
+	}
+	return nil
+}
+
+// goName camel-cases a property name, e.g. copy-from to CopyFrom
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// LoadDir reads every *.json schema of a directory, sorted by file name
+func LoadDir(dir string) ([]*Schema, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no schemas in %s", dir)
+	}
+
+	var schemas []*Schema
+	types := map[string]string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		s, err := ParseSchema(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if other, ok := types[s.GoType]; ok {
+			return nil, fmt.Errorf("%s: type %s is also generated from %s", file, s.GoType, other)
+		}
+		types[s.GoType] = file
+		s.Path = file
+		schemas = append(schemas, s)
+	}
+	return schemas, nil
+}
+
+// triggerBinding is the part of a TriggerBinding checked against a schema
+type triggerBinding struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Params []Param `json:"params"`
+	} `json:"spec"`
+}
+
+// CheckBinding checks that a TriggerBinding binds exactly the parameters of
+// the properties of a schema, with the same values
+func CheckBinding(s *Schema, data []byte) error {
+	var binding triggerBinding
+	if err := yaml.Unmarshal(data, &binding); err != nil {
+		return fmt.Errorf("failed to parse TriggerBinding: %w", err)
+	}
+	if binding.Kind != "TriggerBinding" || binding.Metadata.Name != s.Binding.Name {
+		return fmt.Errorf("%s is not TriggerBinding %s", s.Binding.Path, s.Binding.Name)
+	}
+
+	bound := map[string]string{}
+	for _, p := range binding.Spec.Params {
+		bound[p.Name] = p.Value
+	}
+	var errs []error
+	for _, p := range s.Properties {
+		if p.Param == nil {
+			continue
+		}
+		value, ok := bound[p.Param.Name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("parameter %s of property %s is not bound", p.Param.Name, p.Name))
+		case value != p.Param.Value:
+			errs = append(errs, fmt.Errorf("parameter %s is bound to %s, the schema says %s", p.Param.Name, value, p.Param.Value))
+		}
+		delete(bound, p.Param.Name)
+	}
+	for _, p := range binding.Spec.Params {
+		if _, ok := bound[p.Name]; ok {
+			errs = append(errs, fmt.Errorf("parameter %s is not bound to a property of the schema", p.Name))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("TriggerBinding %s does not match %s: %w", s.Binding.Name, filepath.Base(s.Path), err)
+	}
+	return nil
+}
+
+// GoSource returns the Go source of the types of schemas and their Validate
+// methods, in package pkg
+func GoSource(pkg string, schemas []*Schema) ([]byte, error) {
+	var b bytes.Buffer
+	imports := map[string]bool{}
+	var body bytes.Buffer
+	for _, s := range schemas {
+		writeType(&body, s, imports)
+	}
+
+	b.WriteString("// Code generated by payloadgen from schemas/*.json. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	if len(imports) > 0 {
+		b.WriteString("import (\n")
+		for _, imp := range []string{"fmt", "regexp"} {
+			if imports[imp] {
+				fmt.Fprintf(&b, "\t%q\n", imp)
+			}
+		}
+		b.WriteString(")\n\n")
+	}
+	b.Write(body.Bytes())
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// writeType writes the type of a schema, its Validate method and the
+// patterns it uses
+func writeType(w *bytes.Buffer, s *Schema, imports map[string]bool) {
+	file := filepath.Base(s.Path)
+	writeComment(w, "", fmt.Sprintf("%s is %s, generated from schemas/%s", s.GoType, lowerFirst(strings.TrimSuffix(s.Description, ".")), file))
+	fmt.Fprintf(w, "type %s struct {\n", s.GoType)
+	for _, p := range s.Properties {
+		if p.Description != "" {
+			writeComment(w, "\t", strings.TrimSuffix(p.Description, "."))
+		}
+		tag := p.Name
+		if !s.required(p) {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(w, "\t%s %s `json:%q`\n", p.GoName, goType(p), tag)
+	}
+	w.WriteString("}\n\n")
+
+	var patterns []string
+	recv := strings.ToLower(s.GoType[:1])
+	fmt.Fprintf(w, "// Validate checks the %s against schemas/%s\n", strings.ToLower(s.Title), file)
+	fmt.Fprintf(w, "func (%s *%s) Validate() error {\n", recv, s.GoType)
+	for _, p := range s.Properties {
+		field := recv + "." + p.GoName
+		if p.Type == TypeObject {
+			if s.required(p) {
+				fmt.Fprintf(w, "if len(%s) == 0 {\n", field)
+				writeError(w, p.Name, strconv.Quote(p.Name+" is required"))
+			}
+			continue
+		}
+
+		if s.required(p) {
+			fmt.Fprintf(w, "if %s == \"\" {\n", field)
+			writeError(w, p.Name, strconv.Quote(p.Name+" is required"))
+		}
+		if p.MinLength != nil && *p.MinLength > 1 {
+			imports["fmt"] = true
+			fmt.Fprintf(w, "if %s != \"\" && len(%s) < %d {\n", field, field, *p.MinLength)
+			writeError(w, p.Name, fmt.Sprintf("fmt.Sprintf(%q, %s)", fmt.Sprintf("%s %%q is shorter than %d characters", p.Name, *p.MinLength), field))
+		}
+		if p.MaxLength != nil {
+			imports["fmt"] = true
+			fmt.Fprintf(w, "if len(%s) > %d {\n", field, *p.MaxLength)
+			writeError(w, p.Name, fmt.Sprintf("fmt.Sprintf(%q, %s)", fmt.Sprintf("%s %%q is longer than %d characters", p.Name, *p.MaxLength), field))
+		}
+		if len(p.Enum) > 0 {
+			imports["fmt"] = true
+			values := make([]string, 0, len(p.Enum)+1)
+			if !s.required(p) {
+				values = append(values, `""`)
+			}
+			for _, v := range p.Enum {
+				values = append(values, strconv.Quote(v))
+			}
+			fmt.Fprintf(w, "switch %s {\ncase %s:\ndefault:\n", field, strings.Join(values, ", "))
+			writeError(w, p.Name, fmt.Sprintf("fmt.Sprintf(%q, %s)", fmt.Sprintf("unknown %s %%q, must be %s", p.Name, orList(p.Enum)), field))
+		}
+		if p.Pattern != "" {
+			imports["fmt"], imports["regexp"] = true, true
+			pattern := lowerFirst(s.GoType) + p.GoName + "Pattern"
+			patterns = append(patterns, fmt.Sprintf("%s = regexp.MustCompile(%s)", pattern, strconv.Quote(p.Pattern)))
+			fmt.Fprintf(w, "if %s != \"\" && !%s.MatchString(%s) {\n", field, pattern, field)
+			writeError(w, p.Name, fmt.Sprintf("fmt.Sprintf(%q, %s)", fmt.Sprintf("%s %%q does not match %s", p.Name, p.Pattern), field))
+		}
+	}
+	w.WriteString("return nil\n}\n\n")
+
+	if len(patterns) > 0 {
+		fmt.Fprintf(w, "// Patterns of the string properties of schemas/%s\nvar (\n", file)
+		for _, p := range patterns {
+			fmt.Fprintf(w, "%s\n", p)
+		}
+		w.WriteString(")\n\n")
+	}
+}
+
+// writeError writes the return of a validation error and closes its block
+func writeError(w *bytes.Buffer, field, message string) {
+	fmt.Fprintf(w, "return &ValidationError{Field: %q, Message: %s}\n}\n", field, message)
+}
+
+// writeComment writes text as a Go comment wrapped at 80 columns
+func writeComment(w *bytes.Buffer, indent, text string) {
+	line := indent + "//"
+	for _, word := range strings.Fields(text) {
+		if len(line)+1+len(word) > 80 && line != indent+"//" {
+			fmt.Fprintln(w, line)
+			line = indent + "//"
+		}
+		line += " " + word
+	}
+	fmt.Fprintln(w, line)
+}
+
+func goType(p *Property) string {
+	if p.Type == TypeObject {
+		return "map[string]any"
+	}
+	return "string"
+}
+
+// orList joins values as "a, b or c"
+func orList(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// Docs returns the Markdown documentation of the payloads of schemas, for a
+// file in docsDir. root is the root of the Tekton resources the binding paths
+// are relative to.
+func Docs(schemas []*Schema, docsDir, root string) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("<!-- Code generated by payloadgen from gcpctl/pkg/api/schemas. DO NOT EDIT. -->\n\n")
+	b.WriteString("# Webhook Payloads\n\n")
+	b.WriteString("The JSON payloads gcpctl posts to the Tekton EventListeners and the\n")
+	b.WriteString("TriggerBinding parameters they are bound to. This page, the Go types of the\n")
+	b.WriteString("payloads and their validation in gcpctl are generated from the JSON schemas in\n")
+	b.WriteString("`gcpctl/pkg/api/schemas`; change a schema, then run `make generate` in\n")
+	b.WriteString("gcpctl. The tests of gcpctl fail if a TriggerBinding does not bind the\n")
+	b.WriteString("parameters of its schema.\n")
+
+	for _, s := range schemas {
+		schemaLink, err := relLink(docsDir, s.Path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", s.Title)
+		if s.Description != "" {
+			fmt.Fprintf(&b, "%s\n\n", s.Description)
+		}
+		fmt.Fprintf(&b, "- Schema: [%s](%s)\n", filepath.Base(s.Path), schemaLink)
+		fmt.Fprintf(&b, "- Go type: `api.%s`\n", s.GoType)
+		if s.Binding != nil {
+			bindingLink, err := relLink(docsDir, filepath.Join(root, s.Binding.Path))
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, "- TriggerBinding: [%s](%s)\n", s.Binding.Name, bindingLink)
+		}
+
+		b.WriteString("\n| Field | Type | Required | Constraints | Pipeline Parameter | Binding Value | Description |\n")
+		b.WriteString("|-------|------|----------|-------------|--------------------|---------------|-------------|\n")
+		for _, p := range s.Properties {
+			required := "no"
+			if s.required(p) {
+				required = "yes"
+			}
+			param, value := "-", "-"
+			if p.Param != nil {
+				param, value = "`"+p.Param.Name+"`", "`"+p.Param.Value+"`"
+			}
+			fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s | %s | %s |\n",
+				p.Name, p.Type, required, constraints(p), param, value, strings.ReplaceAll(p.Description, "|", `\|`))
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// constraints describes the constraints of a property for the docs
+func constraints(p *Property) string {
+	var c []string
+	if len(p.Enum) > 0 {
+		values := make([]string, len(p.Enum))
+		for i, v := range p.Enum {
+			values[i] = "`" + v + "`"
+		}
+		c = append(c, "one of "+strings.Join(values, ", "))
+	}
+	if p.MinLength != nil {
+		c = append(c, fmt.Sprintf("at least %d characters", *p.MinLength))
+	}
+	if p.MaxLength != nil {
+		c = append(c, fmt.Sprintf("at most %d characters", *p.MaxLength))
+	}
+	if p.Pattern != "" {
+		c = append(c, "matches `"+strings.ReplaceAll(p.Pattern, "|", `\|`)+"`")
+	}
+	if len(c) == 0 {
+		return "-"
+	}
+	return strings.Join(c, ", ")
+}
+
+// relLink returns the link from a file in dir to path
+func relLink(dir, path string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Options locate the schemas and the generated files
+type Options struct {
+	// SchemaDir holds the *.json schemas
+	SchemaDir string
+	// GoFile is the generated Go file, of package Package
+	GoFile  string
+	Package string
+	// DocsFile is the generated Markdown documentation
+	DocsFile string
+	// Root is the root of the Tekton resources, the directory binding paths
+	// are relative to
+	Root string
+}
+
+// Generate loads the schemas, checks their TriggerBindings and returns the
+// content of the generated files by path
+func Generate(opts Options) (map[string][]byte, error) {
+	schemas, err := LoadDir(opts.SchemaDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range schemas {
+		if s.Binding == nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(opts.Root, s.Binding.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TriggerBinding %s: %w", s.Binding.Name, err)
+		}
+		if err := CheckBinding(s, data); err != nil {
+			return nil, err
+		}
+	}
+
+	src, err := GoSource(opts.Package, schemas)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := Docs(schemas, filepath.Dir(opts.DocsFile), opts.Root)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{opts.GoFile: src, opts.DocsFile: docs}, nil
+}
@@ -0,0 +1,165 @@
+package payloadgen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The committed schemas and the files generated from them
+var committed = Options{
+	SchemaDir: "../../pkg/api/schemas",
+	GoFile:    "../../pkg/api/payloads_gen.go",
+	Package:   "api",
+	DocsFile:  "../../../tekton/PAYLOADS.md",
+	Root:      "../../..",
+}
+
+func TestGenerate_UpToDate(t *testing.T) {
+	files, err := Generate(committed)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	for path, want := range files {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read generated file: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date with the schemas, run 'make generate'", filepath.Clean(path))
+		}
+	}
+}
+
+const testSchema = `{
+  "title": "Sector request",
+  "description": "The payload of the sector webhook.",
+  "type": "object",
+  "x-go-type": "SectorRequest",
+  "x-tekton-binding": {"name": "gcp-sector-binding", "path": "triggerbinding.yaml"},
+  "required": ["sector", "tier"],
+  "properties": {
+    "sector": {
+      "type": "string",
+      "minLength": 3,
+      "pattern": "^[a-z0-9-]+$",
+      "x-tekton-param": {"name": "sector", "value": "$(body.sector)"}
+    },
+    "tier": {
+      "type": "string",
+      "enum": ["gold", "silver"],
+      "x-tekton-param": {"name": "tier", "value": "$(body.tier)"}
+    },
+    "copy-from": {"type": "string", "description": "Existing sector to copy."}
+  }
+}`
+
+func TestParseSchema(t *testing.T) {
+	s, err := ParseSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("ParseSchema() error = %v", err)
+	}
+	var names []string
+	for _, p := range s.Properties {
+		names = append(names, p.Name+"/"+p.GoName)
+	}
+	if got := strings.Join(names, ","); got != "sector/Sector,tier/Tier,copy-from/CopyFrom" {
+		t.Errorf("properties = %v, want them in file order", got)
+	}
+}
+
+func TestParseSchema_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown keyword":   `"oneOf": []`,
+		"unsupported type":  `"properties": {"n": {"type": "integer"}}`,
+		"no properties":     `"properties": {}, "required": ["n"]`,
+		"bad pattern":       `"properties": {"n": {"type": "string", "pattern": "("}}`,
+		"object constraint": `"properties": {"n": {"type": "object", "maxLength": 3}}`,
+		"param bound twice": `"properties": {"a": {"type": "string", "x-tekton-param": {"name": "p", "value": "$(body.a)"}}, "b": {"type": "string", "x-tekton-param": {"name": "p", "value": "$(body.b)"}}}`,
+	}
+	for name, fields := range tests {
+		t.Run(name, func(t *testing.T) {
+			schema := `{"title": "T", "type": "object", "x-go-type": "T", ` + fields + `}`
+			if _, err := ParseSchema([]byte(schema)); err == nil {
+				t.Errorf("ParseSchema(%s) should return error", schema)
+			}
+		})
+	}
+
+	if _, err := ParseSchema([]byte(`{"title": "T", "type": "object", "x-go-type": "lower", "properties": {}}`)); err == nil {
+		t.Error("ParseSchema() should reject an unexported type")
+	}
+}
+
+func TestGoSource(t *testing.T) {
+	s, err := ParseSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("ParseSchema() error = %v", err)
+	}
+	s.Path = "schemas/sector-request.json"
+
+	src, err := GoSource("api", []*Schema{s})
+	if err != nil {
+		t.Fatalf("GoSource() error = %v", err)
+	}
+	for _, want := range []string{
+		"// Code generated by payloadgen",
+		`"regexp"`,
+		"Sector string `json:\"sector\"`",
+		"CopyFrom string `json:\"copy-from,omitempty\"`",
+		"// Existing sector to copy\n",
+		`if s.Sector != "" && len(s.Sector) < 3 {`,
+		`sectorRequestSectorPattern = regexp.MustCompile("^[a-z0-9-]+$")`,
+		`case "gold", "silver":`,
+		`"unknown tier %q, must be gold or silver"`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code does not contain %q:\n%s", want, src)
+		}
+	}
+	// A required enum does not accept the empty string
+	if strings.Contains(string(src), `case "", "gold"`) {
+		t.Errorf("required enum accepts an empty value:\n%s", src)
+	}
+}
+
+func TestCheckBinding(t *testing.T) {
+	s, err := ParseSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("ParseSchema() error = %v", err)
+	}
+	s.Path = "schemas/sector-request.json"
+
+	binding := `apiVersion: triggers.tekton.dev/v1beta1
+kind: TriggerBinding
+metadata:
+  name: gcp-sector-binding
+spec:
+  params:
+    - name: sector
+      value: $(body.sector)
+    - name: tier
+      value: $(body.tier)
+`
+	if err := CheckBinding(s, []byte(binding)); err != nil {
+		t.Errorf("CheckBinding() error = %v", err)
+	}
+
+	drifted := strings.Replace(binding, "$(body.tier)", "$(body.level)", 1) + "    - name: owner\n      value: $(body.owner)\n"
+	err = CheckBinding(s, []byte(drifted))
+	if err == nil {
+		t.Fatal("CheckBinding() should reject a drifted binding")
+	}
+	for _, want := range []string{"tier is bound to $(body.level)", "owner is not bound to a property"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("CheckBinding() error = %v, want %q", err, want)
+		}
+	}
+
+	other := strings.Replace(binding, "gcp-sector-binding", "gcp-region-binding", 1)
+	if err := CheckBinding(s, []byte(other)); err == nil {
+		t.Error("CheckBinding() should reject another binding")
+	}
+}
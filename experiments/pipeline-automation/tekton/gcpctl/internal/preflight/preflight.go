@@ -0,0 +1,157 @@
+// Package preflight checks that the user of a profile has the access gcpctl
+// needs before a rollout: the actions it takes on the Tekton resources of the
+// management cluster, checked with SelfSubjectAccessReviews, and the routes of
+// the webhook the operations post to, checked with HEAD requests.
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// Access is an action on the management cluster gcpctl needs
+type Access struct {
+	Check client.AccessCheck
+	// Required actions are needed to roll out and follow a request
+	Required bool
+	// UsedBy names the commands needing the action
+	UsedBy string
+}
+
+// ClusterAccess returns the actions gcpctl takes on the Tekton resources of
+// a namespace
+func ClusterAccess(namespace string) []Access {
+	tekton := func(verb, resource string) client.AccessCheck {
+		return client.AccessCheck{Verb: verb, Group: "tekton.dev", Resource: resource, Namespace: namespace}
+	}
+	return []Access{
+		{Check: tekton("get", "pipelineruns"), Required: true, UsedBy: "status, --wait, runs describe"},
+		{Check: tekton("list", "pipelineruns"), Required: true, UsedBy: "status, --wait, region list, runs"},
+		{Check: tekton("list", "taskruns"), UsedBy: "status, logs, runs watch"},
+		{Check: client.AccessCheck{Verb: "get", Resource: "pods", Subresource: "log", Namespace: namespace}, UsedBy: "logs"},
+		{Check: tekton("create", "pipelineruns"), UsedBy: "runs retry"},
+		{Check: tekton("patch", "pipelineruns"), UsedBy: "runs retry"},
+		{Check: tekton("delete", "pipelineruns"), UsedBy: "runs prune"},
+		{Check: client.AccessCheck{Verb: "patch", Group: "openshift-pipelines.org", Resource: "approvaltasks", Namespace: namespace}, UsedBy: "approve"},
+	}
+}
+
+// Route is a route of the webhook and the operations posting to it
+type Route struct {
+	Path       string
+	Operations []string
+}
+
+// Routes returns the distinct webhook routes of ops, sorted by path
+func Routes(ops []*operations.Operation) []Route {
+	byPath := make(map[string][]string)
+	for _, op := range ops {
+		byPath[op.Route] = append(byPath[op.Route], op.Name())
+	}
+
+	routes := make([]Route, 0, len(byPath))
+	for path, names := range byPath {
+		routes = append(routes, Route{Path: path, Operations: names})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+	return routes
+}
+
+// RouteProber sends a request without payload to a route of the webhook and
+// returns the status code of the response, see client.TektonClient.ProbeRoute
+type RouteProber interface {
+	ProbeRoute(ctx context.Context, route string) (int, error)
+}
+
+// Checker runs the preflight checks of a profile
+type Checker struct {
+	// Reviewer reviews the cluster access; nil when no backend is
+	// available, then ReviewerErr says why
+	Reviewer    client.AccessReviewer
+	ReviewerErr error
+	// Namespace is the namespace of the pipeline runs
+	Namespace string
+
+	// Webhook probes the routes, reached at WebhookURL
+	Webhook    RouteProber
+	WebhookURL string
+	Routes     []Route
+}
+
+// Run checks every cluster action and webhook route, in that order
+func (c *Checker) Run(ctx context.Context) []api.PreflightCheck {
+	var checks []api.PreflightCheck
+	for _, access := range ClusterAccess(c.Namespace) {
+		check := api.PreflightCheck{Access: access.Check.String(), Required: access.Required, UsedBy: access.UsedBy}
+		check.Status, check.Reason = c.reviewAccess(ctx, access.Check)
+		checks = append(checks, check)
+	}
+
+	for _, route := range c.Routes {
+		check := api.PreflightCheck{
+			Access:   "POST " + routeURL(c.WebhookURL, route.Path),
+			Required: true,
+			UsedBy:   strings.Join(route.Operations, ", "),
+		}
+		code, err := c.Webhook.ProbeRoute(ctx, route.Path)
+		if err != nil {
+			check.Status, check.Reason = api.PreflightError, fmt.Sprintf("webhook not reachable: %v", err)
+		} else {
+			check.Status, check.Reason = RouteStatus(code)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// reviewAccess returns the status of a cluster action and its reason
+func (c *Checker) reviewAccess(ctx context.Context, check client.AccessCheck) (string, string) {
+	if c.Reviewer == nil {
+		return api.PreflightError, fmt.Sprintf("cluster not reachable: %v", c.ReviewerErr)
+	}
+	review, err := c.Reviewer.ReviewAccess(ctx, check)
+	if err != nil {
+		return api.PreflightError, err.Error()
+	}
+	if !review.Allowed {
+		reason := review.Reason
+		if reason == "" {
+			reason = "no role grants it"
+		}
+		return api.PreflightDenied, reason
+	}
+	return api.PreflightAllowed, review.Reason
+}
+
+// RouteStatus interprets the status code of a HEAD request to a webhook
+// route. Event listeners and the ingress in front of them answer requests
+// without payload differently, so any answer but an authentication failure,
+// a missing route or an unavailable listener means the route is reachable.
+func RouteStatus(code int) (string, string) {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden || code == http.StatusProxyAuthRequired:
+		return api.PreflightDenied, fmt.Sprintf("status %d, the webhook or a proxy in front of it rejected the credentials; check the headers and proxy of the profile", code)
+	case code == http.StatusNotFound:
+		return api.PreflightError, "status 404, no trigger of the event listener handles the route"
+	case code >= 500:
+		return api.PreflightError, fmt.Sprintf("status %d, the event listener is not available", code)
+	default:
+		return api.PreflightAllowed, fmt.Sprintf("status %d", code)
+	}
+}
+
+// routeURL returns the URL of a route of the webhook, as the webhook client
+// posts to it
+func routeURL(webhookURL, route string) string {
+	if route == "" {
+		return webhookURL
+	}
+	return strings.TrimSuffix(webhookURL, "/") + "/" + strings.TrimPrefix(route, "/")
+}
@@ -0,0 +1,126 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/client"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/operations"
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/pkg/api"
+)
+
+// fakeReviewer denies the actions of denied and fails those of broken
+type fakeReviewer struct {
+	denied, broken []string
+}
+
+func (f *fakeReviewer) ReviewAccess(ctx context.Context, check client.AccessCheck) (*client.AccessReview, error) {
+	switch {
+	case slices.Contains(f.broken, check.Verb+" "+check.Resource):
+		return nil, errors.New("connection refused")
+	case slices.Contains(f.denied, check.Verb+" "+check.Resource):
+		return &client.AccessReview{}, nil
+	}
+	return &client.AccessReview{Allowed: true}, nil
+}
+
+// fakeProber answers each route with its status code, 202 by default
+type fakeProber map[string]int
+
+func (f fakeProber) ProbeRoute(ctx context.Context, route string) (int, error) {
+	if code, ok := f[route]; ok {
+		return code, nil
+	}
+	return http.StatusAccepted, nil
+}
+
+func checkByAccess(checks []api.PreflightCheck, access string) (api.PreflightCheck, bool) {
+	for _, c := range checks {
+		if c.Access == access {
+			return c, true
+		}
+	}
+	return api.PreflightCheck{}, false
+}
+
+func TestChecker_Run(t *testing.T) {
+	c := &Checker{
+		Reviewer:   &fakeReviewer{denied: []string{"delete pipelineruns"}, broken: []string{"get pods"}},
+		Namespace:  "regions",
+		Webhook:    fakeProber{"sector": http.StatusForbidden},
+		WebhookURL: "https://tekton.example.com/",
+		Routes:     []Route{{Path: "", Operations: []string{"region add"}}, {Path: "sector", Operations: []string{"sector add"}}},
+	}
+	report := &api.PreflightReport{Checks: c.Run(context.Background())}
+
+	want := map[string]string{
+		"list pipelineruns.tekton.dev -n regions":   api.PreflightAllowed,
+		"delete pipelineruns.tekton.dev -n regions": api.PreflightDenied,
+		"get pods/log -n regions":                   api.PreflightError,
+		"POST https://tekton.example.com/":          api.PreflightAllowed,
+		"POST https://tekton.example.com/sector":    api.PreflightDenied,
+	}
+	for access, status := range want {
+		check, ok := checkByAccess(report.Checks, access)
+		if !ok {
+			t.Errorf("no check of %s in %+v", access, report.Checks)
+			continue
+		}
+		if check.Status != status {
+			t.Errorf("%s = %s (%s), want %s", access, check.Status, check.Reason, status)
+		}
+	}
+	if report.OK() {
+		t.Error("OK() = true with a denied webhook route")
+	}
+
+	c.Webhook = fakeProber{}
+	if report := (&api.PreflightReport{Checks: c.Run(context.Background())}); !report.OK() {
+		t.Errorf("OK() = false with only optional actions failing: %+v", report.Checks)
+	}
+}
+
+func TestChecker_RunWithoutBackend(t *testing.T) {
+	c := &Checker{ReviewerErr: errors.New("no backend available"), Namespace: "default", Webhook: fakeProber{}}
+	for _, check := range c.Run(context.Background()) {
+		if check.Status != api.PreflightError || !strings.Contains(check.Reason, "no backend available") {
+			t.Errorf("%s = %s (%s), want an error naming why no backend is available", check.Access, check.Status, check.Reason)
+		}
+	}
+}
+
+func TestRouteStatus(t *testing.T) {
+	tests := map[int]string{
+		http.StatusAccepted:          api.PreflightAllowed,
+		http.StatusBadRequest:        api.PreflightAllowed,
+		http.StatusMethodNotAllowed:  api.PreflightAllowed,
+		http.StatusUnauthorized:      api.PreflightDenied,
+		http.StatusProxyAuthRequired: api.PreflightDenied,
+		http.StatusNotFound:          api.PreflightError,
+		http.StatusBadGateway:        api.PreflightError,
+	}
+	for code, want := range tests {
+		if got, reason := RouteStatus(code); got != want {
+			t.Errorf("RouteStatus(%d) = %s (%s), want %s", code, got, reason, want)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	ops := []*operations.Operation{
+		{Group: "sector", Verb: "add", Route: "sector"},
+		{Group: "region", Verb: "add"},
+		{Group: "region", Verb: "delete"},
+	}
+	routes := Routes(ops)
+	if len(routes) != 2 || routes[0].Path != "" || routes[1].Path != "sector" {
+		t.Fatalf("Routes() = %+v, want the root and sector routes", routes)
+	}
+	if !slices.Equal(routes[0].Operations, []string{"region add", "region delete"}) {
+		t.Errorf("root route operations = %v, want region add and region delete", routes[0].Operations)
+	}
+}
@@ -0,0 +1,122 @@
+// Package rollout provisions the regions of a sector in the order of their
+// dependencies: a region is submitted as soon as the regions it comes after
+// succeeded, and skipped if one of them did not.
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/catalog"
+)
+
+// States of a region of a rollout
+const (
+	// StateWaiting regions wait for the regions they come after
+	StateWaiting = "Waiting"
+	// StateRunning regions were submitted and are being provisioned
+	StateRunning   = "Running"
+	StateSucceeded = "Succeeded"
+	StateFailed    = "Failed"
+	// StateSkipped regions were not submitted: a region they come after did
+	// not succeed, or the rollout was cancelled
+	StateSkipped = "Skipped"
+)
+
+// Result is the state of a region of a rollout. Err tells why it failed or
+// was skipped.
+type Result struct {
+	Region string
+	After  []string
+	State  string
+	Err    error
+}
+
+// Runner provisions the regions of a sector definition
+type Runner struct {
+	// Concurrency is how many regions are provisioned at once, at least 1
+	Concurrency int
+	// Provision submits the request of a region and follows it until it
+	// finishes; it returns nil if the region was provisioned. It is called
+	// concurrently for independent regions.
+	Provision func(ctx context.Context, region string) error
+	// OnChange, if not nil, is called with every state change of a region,
+	// one call at a time. Regions with dependencies start Waiting.
+	OnChange func(Result)
+}
+
+// Run provisions regions and returns their final state, in the order of
+// regions. The dependencies must have been checked, see catalog.Sector.
+func (r *Runner) Run(ctx context.Context, regions []catalog.SectorRegion) []Result {
+	results := make([]Result, len(regions))
+	done := make(map[string]chan struct{}, len(regions))
+	index := make(map[string]int, len(regions))
+	for i, region := range regions {
+		results[i] = Result{Region: region.Region, After: region.After, State: StateWaiting}
+		done[region.Region] = make(chan struct{})
+		index[region.Region] = i
+	}
+
+	var mu sync.Mutex
+	set := func(i int, state string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[i].State, results[i].Err = state, err
+		if r.OnChange != nil {
+			r.OnChange(results[i])
+		}
+	}
+	stateOf := func(region string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return results[index[region]].State
+	}
+	if r.OnChange != nil {
+		for _, result := range results {
+			if len(result.After) > 0 {
+				r.OnChange(result)
+			}
+		}
+	}
+
+	sem := make(chan struct{}, max(1, r.Concurrency))
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[region.Region])
+
+			for _, after := range region.After {
+				select {
+				case <-done[after]:
+				case <-ctx.Done():
+					set(i, StateSkipped, ctx.Err())
+					return
+				}
+				if state := stateOf(after); state != StateSucceeded {
+					set(i, StateSkipped, fmt.Errorf("%s %s", after, strings.ToLower(state)))
+					return
+				}
+			}
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				set(i, StateSkipped, ctx.Err())
+				return
+			}
+			set(i, StateRunning, nil)
+			if err := r.Provision(ctx, region.Region); err != nil {
+				set(i, StateFailed, err)
+				return
+			}
+			set(i, StateSucceeded, nil)
+		}()
+	}
+	wg.Wait()
+	return results
+}
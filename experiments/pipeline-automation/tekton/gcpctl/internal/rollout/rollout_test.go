@@ -0,0 +1,116 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/pipeline-automation/tekton/gcpctl/internal/catalog"
+)
+
+func TestRun(t *testing.T) {
+	regions := []catalog.SectorRegion{
+		{Region: "us-central1"},
+		{Region: "us-east1", After: []string{"us-central1"}},
+		{Region: "europe-west1", After: []string{"us-central1"}},
+		{Region: "asia-east1", After: []string{"us-east1", "europe-west1"}},
+	}
+
+	var mu sync.Mutex
+	var provisioned []string
+	var changes []string
+	r := &Runner{
+		Concurrency: 2,
+		Provision: func(ctx context.Context, region string) error {
+			mu.Lock()
+			provisioned = append(provisioned, region)
+			mu.Unlock()
+			return nil
+		},
+		OnChange: func(result Result) {
+			changes = append(changes, result.Region+" "+result.State)
+		},
+	}
+	results := r.Run(context.Background(), regions)
+
+	for _, result := range results {
+		if result.State != StateSucceeded || result.Err != nil {
+			t.Errorf("%s = %s, %v, want it succeeded", result.Region, result.State, result.Err)
+		}
+	}
+	if provisioned[0] != "us-central1" || provisioned[3] != "asia-east1" {
+		t.Errorf("provisioned %v, want us-central1 first and asia-east1 last", provisioned)
+	}
+	for _, want := range []string{"us-east1 Waiting", "asia-east1 Waiting", "us-central1 Running", "asia-east1 Succeeded"} {
+		if !slices.Contains(changes, want) {
+			t.Errorf("changes %v do not contain %q", changes, want)
+		}
+	}
+	if slices.Contains(changes, "us-central1 Waiting") {
+		t.Errorf("changes %v, want regions without dependencies to start right away", changes)
+	}
+}
+
+func TestRun_FailureSkipsDependents(t *testing.T) {
+	regions := []catalog.SectorRegion{
+		{Region: "us-central1"},
+		{Region: "us-east1", After: []string{"us-central1"}},
+		{Region: "europe-west1"},
+		{Region: "asia-east1", After: []string{"us-east1"}},
+	}
+	r := &Runner{
+		Concurrency: 1,
+		Provision: func(ctx context.Context, region string) error {
+			if region == "us-central1" {
+				return errors.New("pipeline run failed")
+			}
+			return nil
+		},
+	}
+	results := r.Run(context.Background(), regions)
+
+	want := map[string]string{
+		"us-central1":  StateFailed,
+		"us-east1":     StateSkipped,
+		"europe-west1": StateSucceeded,
+		"asia-east1":   StateSkipped,
+	}
+	for _, result := range results {
+		if result.State != want[result.Region] {
+			t.Errorf("%s = %s, want %s", result.Region, result.State, want[result.Region])
+		}
+	}
+	if err := results[1].Err; err == nil || err.Error() != "us-central1 failed" {
+		t.Errorf("us-east1 error = %v, want us-central1 failed", err)
+	}
+	if err := results[3].Err; err == nil || err.Error() != "us-east1 skipped" {
+		t.Errorf("asia-east1 error = %v, want us-east1 skipped", err)
+	}
+}
+
+func TestRun_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	regions := []catalog.SectorRegion{
+		{Region: "us-central1"},
+		{Region: "us-east1", After: []string{"us-central1"}},
+	}
+	r := &Runner{
+		Concurrency: 1,
+		Provision: func(ctx context.Context, region string) error {
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+	results := r.Run(ctx, regions)
+
+	if results[0].State != StateFailed || results[1].State != StateSkipped {
+		t.Errorf("results = %+v, want us-central1 failed and us-east1 skipped", results)
+	}
+	// Either the cancellation or the failure is seen first
+	if err := results[1].Err; err == nil || !errors.Is(err, context.Canceled) && err.Error() != "us-central1 failed" {
+		t.Errorf("us-east1 error = %v, want the rollout cancelled", err)
+	}
+}
@@ -0,0 +1,288 @@
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Mode controls whether a Recorder captures live traffic or replays a cassette
+type Mode string
+
+const (
+	// ModeReplay serves responses from an existing cassette and never touches the network
+	ModeReplay Mode = "replay"
+	// ModeRecord forwards requests to the real server and saves every interaction
+	ModeRecord Mode = "record"
+
+	// ModeEnvVar selects the recorder mode for tests (defaults to replay)
+	ModeEnvVar = "GCPCTL_VCR_MODE"
+)
+
+// Interaction is a single captured request/response pair
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest holds the parts of a request used for matching during replay
+type RecordedRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	Body   string `json:"body,omitempty"`
+}
+
+// RecordedResponse holds the captured response returned during replay
+type RecordedResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+	RawBody    string            `json:"rawBody,omitempty"`
+}
+
+// Cassette is the on-disk collection of interactions for one test scenario
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Recorder is an http.RoundTripper that records or replays HTTP interactions
+type Recorder struct {
+	mode     Mode
+	path     string
+	next     http.RoundTripper
+	cassette *Cassette
+	used     []bool
+	mu       sync.Mutex
+}
+
+// ModeFromEnv returns the recorder mode requested via GCPCTL_VCR_MODE
+func ModeFromEnv() Mode {
+	if Mode(os.Getenv(ModeEnvVar)) == ModeRecord {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+// New creates a recorder backed by the cassette file at path
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		mode:     mode,
+		path:     path,
+		next:     http.DefaultTransport,
+		cassette: &Cassette{},
+	}
+
+	if mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette %s: %w", path, err)
+		}
+		if err := json.Unmarshal(data, r.cassette); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+
+	return r, nil
+}
+
+// SetTransport sets the transport used to reach the real server in record mode
+func (r *Recorder) SetTransport(rt http.RoundTripper) {
+	r.next = rt
+}
+
+// Mode returns the recorder mode
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	recorded := RecordedRequest{
+		Method: req.Method,
+		Path:   path,
+		Query:  req.URL.RawQuery,
+		Body:   string(body),
+	}
+
+	if r.mode == ModeRecord {
+		return r.record(req, recorded)
+	}
+	return r.replay(req, recorded)
+}
+
+// Save writes recorded interactions to the cassette file (no-op in replay mode)
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+
+	if err := os.WriteFile(r.path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette %s: %w", r.path, err)
+	}
+	return nil
+}
+
+func (r *Recorder) record(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	recordedResp := RecordedResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    map[string]string{},
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		recordedResp.Headers["Content-Type"] = ct
+	}
+	if json.Valid(respBody) {
+		recordedResp.Body = json.RawMessage(respBody)
+	} else {
+		recordedResp.RawBody = string(respBody)
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request:  recorded,
+		Response: recordedResp,
+	})
+	r.mu.Unlock()
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || !matches(interaction.Request, recorded) {
+			continue
+		}
+		r.used[i] = true
+		return buildResponse(req, interaction.Response), nil
+	}
+
+	return nil, fmt.Errorf("vcr: no recorded interaction for %s %s?%s in %s",
+		recorded.Method, recorded.Path, recorded.Query, r.path)
+}
+
+// Len returns the number of interactions of the cassette, those recorded so
+// far in record mode
+func (r *Recorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.cassette.Interactions)
+}
+
+// Unused returns the interactions that were never replayed, useful to catch stale cassettes
+func (r *Recorder) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, interaction := range r.cassette.Interactions {
+		if !r.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+// matches compares requests by method, path, query, and body. The scheme and
+// host are ignored so cassettes recorded against a lab cluster replay anywhere.
+func matches(recorded, actual RecordedRequest) bool {
+	if recorded.Method != actual.Method || recorded.Path != actual.Path || recorded.Query != actual.Query {
+		return false
+	}
+	if recorded.Body == "" {
+		return true
+	}
+	return jsonEqual(recorded.Body, actual.Body)
+}
+
+func jsonEqual(a, b string) bool {
+	var va, vb interface{}
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		return a == b
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		return false
+	}
+	na, _ := json.Marshal(va)
+	nb, _ := json.Marshal(vb)
+	return bytes.Equal(na, nb)
+}
+
+func buildResponse(req *http.Request, recorded RecordedResponse) *http.Response {
+	body := []byte(recorded.Body)
+	if recorded.RawBody != "" {
+		body = []byte(recorded.RawBody)
+	}
+
+	header := http.Header{}
+	for k, v := range recorded.Headers {
+		header.Set(k, v)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,94 @@
+package vcr
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder_RecordThenReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"eventID":"abc"}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	rec, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	client := &http.Client{Transport: rec}
+	resp, err := client.Post(server.URL+"/hook", "application/json", strings.NewReader(`{"region":"us-central1"}`))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != `{"eventID":"abc"}` {
+		t.Errorf("recorded body = %s", body)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	replay, err := New(path, ModeReplay)
+	if err != nil {
+		t.Fatalf("New() replay error = %v", err)
+	}
+
+	client = &http.Client{Transport: replay}
+	// Different host and key order must still match the recorded interaction
+	resp, err = client.Post("http://elsewhere.invalid/hook", "application/json", bytes.NewReader([]byte(`{ "region": "us-central1" }`)))
+	if err != nil {
+		t.Fatalf("replay Post() error = %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("StatusCode = %v, want %v", resp.StatusCode, http.StatusAccepted)
+	}
+	if !jsonEqual(string(body), `{"eventID":"abc"}`) {
+		t.Errorf("replayed body = %s", body)
+	}
+	if len(replay.Unused()) != 0 {
+		t.Errorf("Unused() = %v, want none", replay.Unused())
+	}
+}
+
+func TestRecorder_ReplayMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	rec, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	replay, err := New(path, ModeReplay)
+	if err != nil {
+		t.Fatalf("New() replay error = %v", err)
+	}
+
+	client := &http.Client{Transport: replay}
+	if _, err := client.Get("http://tekton.invalid/apis/tekton.dev/v1/namespaces/default/pipelineruns"); err == nil {
+		t.Fatal("Get() should fail when no interaction matches")
+	}
+}
+
+func TestNew_MissingCassette(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "missing.json"), ModeReplay); err == nil {
+		t.Fatal("New() should fail for a missing cassette in replay mode")
+	}
+}
@@ -0,0 +1,136 @@
+package version
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed compat.yaml
+var embeddedMatrix []byte
+
+// Matrix lists the combinations of gcpctl and pipeline bundle versions known
+// not to work together
+type Matrix struct {
+	Incompatible []Rule `json:"incompatible"`
+}
+
+// Rule is a range of gcpctl versions that does not work with a range of
+// bundle versions. Ranges are comma-separated constraints such as
+// ">=1.0.0, <1.2.0"; an empty range matches every version.
+type Rule struct {
+	CLI    string `json:"cli"`
+	Bundle string `json:"bundle"`
+	Reason string `json:"reason"`
+}
+
+// DefaultMatrix returns the compatibility matrix built into gcpctl
+func DefaultMatrix() *Matrix {
+	m, err := ParseMatrix(embeddedMatrix)
+	if err != nil {
+		panic(fmt.Sprintf("embedded compatibility matrix is invalid: %v", err))
+	}
+	return m
+}
+
+// ParseMatrix reads a compatibility matrix in YAML or JSON and checks its ranges
+func ParseMatrix(data []byte) (*Matrix, error) {
+	var m Matrix
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse compatibility matrix: %w", err)
+	}
+	for i, r := range m.Incompatible {
+		for _, rng := range []string{r.CLI, r.Bundle} {
+			if _, err := parseRange(rng); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+		}
+		if r.Reason == "" {
+			return nil, fmt.Errorf("rule %d: no reason", i+1)
+		}
+	}
+	return &m, nil
+}
+
+// Check returns the reasons gcpctl cli does not work with bundle, none if the
+// combination is not known to be broken. Versions that do not parse, such as
+// dev builds, cannot be checked and return an error.
+func (m *Matrix) Check(cli, bundle string) ([]string, error) {
+	cliVersion, err := Parse(cli)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check gcpctl %s: %w", cli, err)
+	}
+	bundleVersion, err := Parse(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check bundle %s: %w", bundle, err)
+	}
+
+	var reasons []string
+	for _, r := range m.Incompatible {
+		cliRange, _ := parseRange(r.CLI)
+		bundleRange, _ := parseRange(r.Bundle)
+		if cliRange.matches(cliVersion) && bundleRange.matches(bundleVersion) {
+			reasons = append(reasons, r.Reason)
+		}
+	}
+	return reasons, nil
+}
+
+// constraint is a comparison with a version, e.g. >=1.2.0
+type constraint struct {
+	op      string
+	version Semver
+}
+
+type versionRange []constraint
+
+// parseRange parses comma-separated constraints
+func parseRange(s string) (versionRange, error) {
+	var r versionRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		op := "="
+		for _, candidate := range []string{">=", "<=", "!=", ">", "<", "="} {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				break
+			}
+		}
+		v, err := Parse(strings.TrimPrefix(part, op))
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint %q: %w", part, err)
+		}
+		r = append(r, constraint{op: op, version: v})
+	}
+	return r, nil
+}
+
+func (r versionRange) matches(v Semver) bool {
+	for _, c := range r {
+		cmp := v.Compare(c.version)
+		ok := false
+		switch c.op {
+		case ">=":
+			ok = cmp >= 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case "<":
+			ok = cmp < 0
+		case "!=":
+			ok = cmp != 0
+		default:
+			ok = cmp == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
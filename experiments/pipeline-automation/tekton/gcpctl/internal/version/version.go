@@ -0,0 +1,116 @@
+// Package version describes the gcpctl build and the pipeline bundle versions
+// it is known not to work with.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Build information, set with -ldflags "-X <package>.Version=..." by the
+// Makefile. Builds without ldflags report the module version and VCS
+// information Go records, if any.
+var (
+	Version   = ""
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Dev is the version of builds without version information
+const Dev = "dev"
+
+// Info describes a gcpctl build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the information of the running build
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		fillFromBuildInfo(&info, bi)
+	}
+	if info.Version == "" {
+		info.Version = Dev
+	}
+	return info
+}
+
+// fillFromBuildInfo completes info with what go build recorded: the module
+// version of go install builds and the VCS revision and time of builds in a
+// checkout
+func fillFromBuildInfo(info *Info, bi *debug.BuildInfo) {
+	if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = s.Value
+			}
+		}
+	}
+}
+
+// Semver is a parsed major.minor.patch version
+type Semver [3]int
+
+// Parse reads versions like 1.2.3, v1.2 or 1.2.3-rc.1; missing parts are 0
+// and pre-release and build suffixes are ignored
+func Parse(s string) (Semver, error) {
+	var v Semver
+	core := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+	parts := strings.Split(core, ".")
+	if core == "" || len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 when v is lower than, equal to or higher than o
+func (v Semver) Compare(o Semver) int {
+	for i := range v {
+		switch {
+		case v[i] < o[i]:
+			return -1
+		case v[i] > o[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v Semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
@@ -0,0 +1,130 @@
+package version
+
+import (
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for in, want := range map[string]Semver{
+		"1.2.3":          {1, 2, 3},
+		"v1.2":           {1, 2, 0},
+		"2":              {2, 0, 0},
+		"1.2.3-rc.1":     {1, 2, 3},
+		"v0.4.0+abcdef0": {0, 4, 0},
+	} {
+		got, err := Parse(in)
+		if err != nil || got != want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "dev", "1.2.3.4", "1.x", "1.-2"} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) succeeded", in)
+		}
+	}
+}
+
+func TestFillFromBuildInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Version: "v1.3.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+			{Key: "vcs.time", Value: "2025-01-02T03:04:05Z"},
+		},
+	}
+
+	var info Info
+	fillFromBuildInfo(&info, bi)
+	want := Info{Version: "v1.3.0", Commit: "0123456789ab", BuildDate: "2025-01-02T03:04:05Z"}
+	if info != want {
+		t.Errorf("fillFromBuildInfo() = %+v, want %+v", info, want)
+	}
+
+	// ldflags take precedence
+	info = Info{Version: "1.4.0", Commit: "fedcba"}
+	fillFromBuildInfo(&info, bi)
+	if info.Version != "1.4.0" || info.Commit != "fedcba" {
+		t.Errorf("fillFromBuildInfo() overrode the ldflags: %+v", info)
+	}
+}
+
+func TestGet_Dev(t *testing.T) {
+	// Test binaries have no module version nor ldflags
+	if got := Get(); got.Version != Dev || got.GoVersion == "" || got.Platform == "" {
+		t.Errorf("Get() = %+v, want a dev build", got)
+	}
+}
+
+func TestDefaultMatrix(t *testing.T) {
+	m := DefaultMatrix()
+	if len(m.Incompatible) == 0 {
+		t.Fatal("embedded matrix has no rules")
+	}
+
+	reasons, err := m.Check("1.0.0", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reasons) != 3 || !strings.Contains(reasons[0], "action") {
+		t.Errorf("Check(1.0.0, 1.0.0) = %q, want the action, start-from-task and extra-params rules", reasons)
+	}
+
+	if reasons, err := m.Check("1.0.0", "1.3.0"); err != nil || len(reasons) != 0 {
+		t.Errorf("Check(1.0.0, 1.3.0) = %q, %v, want none", reasons, err)
+	}
+}
+
+func TestMatrix_Check(t *testing.T) {
+	m, err := ParseMatrix([]byte(`
+incompatible:
+  - cli: ">=2.0.0"
+    bundle: "<1.5"
+    reason: new CLI, old bundle
+  - cli: "<2, !=1.9.1"
+    bundle: ">=3.0.0"
+    reason: old CLI, new bundle
+  - bundle: "=2.7.0"
+    reason: broken bundle
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		cli, bundle string
+		want        []string
+	}{
+		{"2.0.0", "1.4.9", []string{"new CLI, old bundle"}},
+		{"2.0.0", "1.5.0", nil},
+		{"1.9.0", "3.1.0", []string{"old CLI, new bundle"}},
+		{"1.9.1", "3.1.0", nil},
+		{"v1.0.0", "v2.7.0", []string{"broken bundle"}},
+	} {
+		got, err := m.Check(tc.cli, tc.bundle)
+		if err != nil {
+			t.Fatalf("Check(%s, %s) error = %v", tc.cli, tc.bundle, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Check(%s, %s) = %q, want %q", tc.cli, tc.bundle, got, tc.want)
+		}
+	}
+
+	if _, err := m.Check(Dev, "1.0.0"); err == nil {
+		t.Error("Check() of a dev build succeeded")
+	}
+}
+
+func TestParseMatrix_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"bad constraint": "incompatible:\n  - cli: '>=one'\n    reason: r\n",
+		"no reason":      "incompatible:\n  - cli: '>=1.0.0'\n",
+		"unknown field":  "incompatible:\n  - client: '>=1.0.0'\n    reason: r\n",
+	} {
+		if _, err := ParseMatrix([]byte(data)); err == nil {
+			t.Errorf("%s: ParseMatrix() succeeded", name)
+		}
+	}
+}
@@ -1,24 +1,367 @@
 package api
 
-// RegionRequest represents the payload for Tekton webhook region operations
-type RegionRequest struct {
-	Environment string `json:"environment"`
-	Region      string `json:"region"`
-	Sector      string `json:"sector"`
-}
+import (
+	"encoding/json"
+	"time"
+)
+
+//go:generate go run ../../cmd/payloadgen -schemas schemas -go payloads_gen.go -docs ../../../tekton/PAYLOADS.md -root ../../..
+
+// Region actions understood by the region provisioning pipeline
+const (
+	RegionActionAdd    = "add"
+	RegionActionDelete = "delete"
+)
 
-// Validate checks if all required fields are present and valid
-func (r *RegionRequest) Validate() error {
-	if r.Environment == "" {
-		return &ValidationError{Field: "environment", Message: "environment is required"}
+// GetAction returns the action of the request, add if none is set
+func (r *RegionRequest) GetAction() string {
+	if r.Action == "" {
+		return RegionActionAdd
 	}
-	if r.Region == "" {
-		return &ValidationError{Field: "region", Message: "region is required"}
+	return r.Action
+}
+
+// RegionListOptions filters the regions returned by a region list
+type RegionListOptions struct {
+	Environment string
+	Sector      string
+	// IncludeDeleted also returns regions whose last run deleted them
+	IncludeDeleted bool
+}
+
+// RegionStatus summarizes the latest pipeline run for a region
+type RegionStatus struct {
+	Environment    string `json:"environment"`
+	Sector         string `json:"sector"`
+	Region         string `json:"region"`
+	Action         string `json:"action"`
+	PipelineRun    string `json:"pipelineRun"`
+	Namespace      string `json:"namespace,omitempty"`
+	Status         string `json:"status"`
+	StartTime      string `json:"startTime,omitempty"`
+	CompletionTime string `json:"completionTime,omitempty"`
+}
+
+// MarshalJSON adds the State of the region to its JSON and YAML output
+func (s RegionStatus) MarshalJSON() ([]byte, error) {
+	type plain RegionStatus
+	return json.Marshal(struct {
+		plain
+		State string `json:"state"`
+	}{plain(s), s.State()})
+}
+
+// State describes the region lifecycle from its latest run: Provisioning,
+// Provisioned, Deleting, Deleted, or the run status when it did not succeed
+func (s *RegionStatus) State() string {
+	switch s.Status {
+	case "Succeeded":
+		if s.Action == RegionActionDelete {
+			return "Deleted"
+		}
+		return "Provisioned"
+	case "Running", "Pending", "Unknown":
+		if s.Action == RegionActionDelete {
+			return "Deleting"
+		}
+		return "Provisioning"
+	default:
+		return s.Status
 	}
-	if r.Sector == "" {
-		return &ValidationError{Field: "sector", Message: "sector is required"}
+}
+
+// Sort orders of a pipeline run listing
+const (
+	RunSortStart    = "start"
+	RunSortDuration = "duration"
+	RunSortStatus   = "status"
+)
+
+// RunListOptions filters, sorts and pages the pipeline runs of a run listing
+type RunListOptions struct {
+	// Pipeline only returns runs of this pipeline
+	Pipeline    string
+	Environment string
+	Sector      string
+	Region      string
+	// Status only returns runs in this status, e.g. Failed; case-insensitive
+	Status string
+	// Since only returns runs started, or created if not started, within this
+	// duration; zero for any age
+	Since time.Duration
+	// SortBy is RunSortStart (newest first, the default), RunSortDuration
+	// (longest first) or RunSortStatus
+	SortBy string
+	// Reverse inverts the sort order
+	Reverse bool
+	// Limit is the page size, zero for all runs; Page starts at 1
+	Limit int
+	Page  int
+}
+
+// PipelineRunSummary is a pipeline run in a run listing
+type PipelineRunSummary struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	Pipeline        string `json:"pipeline,omitempty"`
+	Environment     string `json:"environment,omitempty"`
+	Sector          string `json:"sector,omitempty"`
+	Region          string `json:"region,omitempty"`
+	Action          string `json:"action,omitempty"`
+	Status          string `json:"status"`
+	StartTime       string `json:"startTime,omitempty"`
+	CompletionTime  string `json:"completionTime,omitempty"`
+	DurationSeconds int64  `json:"durationSeconds"`
+}
+
+// RunList is a page of a run listing
+type RunList struct {
+	Items []PipelineRunSummary `json:"items"`
+	// Total is the number of runs matching the filters, on all pages
+	Total int `json:"total"`
+	Page  int `json:"page"`
+	Limit int `json:"limit,omitempty"`
+}
+
+// InFlightRun is a pipeline run that has not finished, in 'runs watch'
+type InFlightRun struct {
+	PipelineRunSummary
+	// CurrentTasks are the pipeline tasks running, several when tasks run
+	// in parallel
+	CurrentTasks []string `json:"currentTasks,omitempty"`
+	// CompletedTasks is the number of pipeline tasks that finished
+	CompletedTasks int `json:"completedTasks"`
+}
+
+// RunWatch is a refresh of 'runs watch'
+type RunWatch struct {
+	Time       string        `json:"time"`
+	Namespaces []string      `json:"namespaces"`
+	Items      []InFlightRun `json:"items"`
+	// Errors are why namespaces could not be read, by namespace
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// PruneOptions select the completed pipeline runs 'runs prune' removes
+type PruneOptions struct {
+	// Pipeline only prunes runs of this pipeline
+	Pipeline    string
+	Environment string
+	Sector      string
+	Region      string
+	// Status only prunes runs in this status: Succeeded, Failed or
+	// Cancelled; any of them if empty; case-insensitive
+	Status string
+	// OlderThan only prunes runs that completed longer ago than this
+	OlderThan time.Duration
+	// Limit is the most runs pruned at once, oldest first; zero for all
+	Limit int
+}
+
+// PrunedRun is a pipeline run removed, or to be removed, by 'runs prune'
+type PrunedRun struct {
+	PipelineRunSummary
+	// Archive is the object the run was archived to before it was deleted
+	Archive string `json:"archive,omitempty"`
+	Deleted bool   `json:"deleted"`
+	// Error is why the run could not be archived or deleted
+	Error string `json:"error,omitempty"`
+}
+
+// PruneResult is the outcome of 'runs prune'
+type PruneResult struct {
+	Namespace string `json:"namespace"`
+	DryRun    bool   `json:"dryRun,omitempty"`
+	// Matched is the number of runs matching the filters, of which at most
+	// the limit are in Items
+	Matched int         `json:"matched"`
+	Items   []PrunedRun `json:"items"`
+	Deleted int         `json:"deleted"`
+	Failed  int         `json:"failed"`
+}
+
+// RetryResult is a pipeline run re-submitted by a retry
+type RetryResult struct {
+	// Original is the retried pipeline run
+	Original string `json:"original"`
+	// PipelineRun is the new pipeline run
+	PipelineRun string            `json:"pipelineRun"`
+	Namespace   string            `json:"namespace"`
+	FromTask    string            `json:"fromTask,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	// DashboardURL is the page of the new pipeline run in the Tekton
+	// dashboard, when one is configured
+	DashboardURL string `json:"dashboardURL,omitempty"`
+}
+
+// States of a manual approval gate, and the answers of its approvers
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// ApprovalStatus is a manual approval gate of a pipeline run, an
+// ApprovalTask of the OpenShift Pipelines manual approval gate
+type ApprovalStatus struct {
+	// Task is the pipeline task of the gate, Name its ApprovalTask
+	Task        string `json:"task"`
+	Name        string `json:"name"`
+	State       string `json:"state"` // pending, approved or rejected
+	Description string `json:"description,omitempty"`
+	// Approvals is the number of approvals given, of Required
+	Approvals int `json:"approvals"`
+	Required  int `json:"required"`
+	// Approvers are the users and groups, as group:<name>, who may answer
+	Approvers []string           `json:"approvers,omitempty"`
+	Responses []ApprovalResponse `json:"responses,omitempty"`
+	StartTime string             `json:"startTime,omitempty"`
+}
+
+// ApprovalResponse is the answer of an approver to a gate
+type ApprovalResponse struct {
+	Name     string `json:"name"`
+	Response string `json:"response"` // approved or rejected
+	Message  string `json:"message,omitempty"`
+}
+
+// ApprovalResult is an answer recorded by 'approve'
+type ApprovalResult struct {
+	PipelineRun  string `json:"pipelineRun"`
+	Namespace    string `json:"namespace"`
+	Task         string `json:"task"`
+	ApprovalTask string `json:"approvalTask"`
+	// Approver is the user the API server authenticated, Group the approver
+	// group they answered for when they are not an approver by name
+	Approver string `json:"approver"`
+	Group    string `json:"group,omitempty"`
+	Response string `json:"response"` // approved or rejected
+	Message  string `json:"message,omitempty"`
+	// Approvals counts this answer, the gate opens once it reaches Required
+	Approvals int `json:"approvals"`
+	Required  int `json:"required"`
+}
+
+// Outcomes of a parameter of a RunDiff
+const (
+	// ParamMatch parameters have the requested value
+	ParamMatch = "match"
+	// ParamChanged parameters have another value than requested, e.g.
+	// rewritten by the trigger template
+	ParamChanged = "changed"
+	// ParamMissing parameters were requested but are not parameters of the
+	// run, e.g. extra parameters of a pipeline that predates them
+	ParamMissing = "missing"
+	// ParamDefaulted parameters were not requested and were set by the
+	// event listener or the trigger template
+	ParamDefaulted = "defaulted"
+)
+
+// ParamDiff compares a requested parameter with the one of the pipeline run
+type ParamDiff struct {
+	Name      string `json:"name"`
+	Requested string `json:"requested,omitempty"`
+	Applied   string `json:"applied,omitempty"`
+	Status    string `json:"status"`
+}
+
+// RunDiff compares a submitted request with the pipeline run it started, in
+// 'runs describe --diff-request'
+type RunDiff struct {
+	Operation string `json:"operation"`
+	// Source is where the request was read from: history or a file name
+	Source string `json:"source"`
+	// ExpectedPipeline is the pipeline of the operation
+	ExpectedPipeline string `json:"expectedPipeline"`
+	// VersionWarnings are the known problems of this gcpctl with the bundle
+	// version of the run
+	VersionWarnings []string    `json:"versionWarnings,omitempty"`
+	Params          []ParamDiff `json:"params"`
+	// Drift counts the changed and missing parameters, another pipeline
+	// than expected and an incompatible bundle version
+	Drift int `json:"drift"`
+}
+
+// RunDescription is a pipeline run as shown by 'runs describe'
+type RunDescription struct {
+	PipelineRunSummary
+	EventID string `json:"eventID,omitempty"`
+	// BundleVersion is the bundle version label of the run, empty if it has
+	// none
+	BundleVersion string            `json:"bundleVersion,omitempty"`
+	Params        map[string]string `json:"params,omitempty"`
+	// Diff compares the run with the request given to --diff-request
+	Diff *RunDiff `json:"diff,omitempty"`
+}
+
+// VersionInfo is the version of gcpctl and of the pipeline bundle of the
+// management cluster
+type VersionInfo struct {
+	Client ClientVersion  `json:"client"`
+	Bundle *BundleVersion `json:"bundle,omitempty"`
+	// BundleError is why the bundle version could not be determined
+	BundleError string `json:"bundleError,omitempty"`
+	// Warnings are the known problems of this client and bundle combination
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ClientVersion describes the gcpctl build
+type ClientVersion struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// BundleVersion is the version of the pipeline bundle and where it was read
+type BundleVersion struct {
+	Version string `json:"version"`
+	// Source is the version endpoint or the pipeline run the version was
+	// read from
+	Source string `json:"source"`
+}
+
+// Outcomes of a preflight check
+const (
+	PreflightAllowed = "Allowed"
+	PreflightDenied  = "Denied"
+	// PreflightError checks could not tell, e.g. the cluster or the webhook
+	// could not be reached
+	PreflightError = "Error"
+)
+
+// PreflightReport is the outcome of 'gcpctl preflight' for a profile
+type PreflightReport struct {
+	Profile string `json:"profile,omitempty"`
+	// Backend reads the cluster, empty if none is available
+	Backend string           `json:"backend,omitempty"`
+	Checks  []PreflightCheck `json:"checks"`
+}
+
+// OK reports whether every required check is allowed
+func (r *PreflightReport) OK() bool {
+	for _, c := range r.Checks {
+		if c.Required && c.Status != PreflightAllowed {
+			return false
+		}
 	}
-	return nil
+	return true
+}
+
+// PreflightCheck is an access 'gcpctl preflight' checked: an action on the
+// management cluster, or a route of the webhook. Rollouts fail without the
+// required ones; the others are needed by the commands of UsedBy.
+type PreflightCheck struct {
+	// Access is e.g. "list pipelineruns.tekton.dev -n default" or
+	// "POST https://tekton.example.com/region"
+	Access   string `json:"access"`
+	Required bool   `json:"required"`
+	UsedBy   string `json:"usedBy"`
+	// Status is PreflightAllowed, PreflightDenied or PreflightError
+	Status string `json:"status"`
+	// Reason explains the status, e.g. the authorizer's reason
+	Reason string `json:"reason,omitempty"`
 }
 
 // ValidationError represents a validation error for a specific field
@@ -41,23 +384,125 @@ type TektonResponse struct {
 	EventListenerUID string `json:"eventListenerUID,omitempty"`
 }
 
+// TriggerResult is the outcome of a webhook request, with the final status of
+// the pipeline run when the command waited for it
+type TriggerResult struct {
+	Event       *TektonResponse    `json:"event"`
+	PipelineRun *PipelineRunStatus `json:"pipelineRun,omitempty"`
+	// State is the outcome of the finished pipeline run for the operation,
+	// e.g. Provisioned
+	State string `json:"state,omitempty"`
+}
+
+// BulkResult is the outcome of the requests of a file submitted with --file
+type BulkResult struct {
+	Items     []BulkItem `json:"items"`
+	Succeeded int        `json:"succeeded"`
+	Failed    int        `json:"failed"`
+}
+
+// BulkItem is the outcome of one request of a file. Error is set if the
+// webhook rejected the request or, with --wait, its pipeline run failed.
+type BulkItem struct {
+	Request map[string]string `json:"request"`
+	TriggerResult
+	Error string `json:"error,omitempty"`
+}
+
+// SectorRollout is the outcome of 'sector add --regions': the request of the
+// sector, unless it existed already, then those of the regions of its
+// definition
+type SectorRollout struct {
+	Environment string        `json:"environment"`
+	Sector      string        `json:"sector"`
+	SectorItem  *BulkItem     `json:"sectorRequest,omitempty"`
+	Regions     []RolloutItem `json:"regions"`
+	Succeeded   int           `json:"succeeded"`
+	Failed      int           `json:"failed"`
+	Skipped     int           `json:"skipped"`
+}
+
+// RolloutItem is a region of a sector rollout. Progress is Succeeded, Failed
+// or Skipped; skipped regions were not submitted and have no event.
+type RolloutItem struct {
+	Region   string   `json:"region"`
+	After    []string `json:"after,omitempty"`
+	Progress string   `json:"progress"`
+	BulkItem
+}
+
+// WebhookRequest is a request to the Tekton webhook as it would be sent,
+// printed by --dry-run
+type WebhookRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	// Body is the exact payload; the signature header, if any, is computed
+	// over it
+	Body json.RawMessage `json:"body"`
+}
+
 // PipelineRunStatus represents the status of a Tekton PipelineRun
 type PipelineRunStatus struct {
-	Name           string                   `json:"name"`
-	Namespace      string                   `json:"namespace,omitempty"`
-	Status         string                   `json:"status"` // Unknown, Pending, Running, Succeeded, Failed, Cancelled
-	StartTime      string                   `json:"startTime,omitempty"`
-	CompletionTime string                   `json:"completionTime,omitempty"`
-	Tasks          []TaskRunStatus          `json:"taskRuns,omitempty"`
-	Conditions     []PipelineRunCondition   `json:"conditions,omitempty"`
-	Message        string                   `json:"message,omitempty"`
+	Name           string                 `json:"name"`
+	Namespace      string                 `json:"namespace,omitempty"`
+	Status         string                 `json:"status"` // Unknown, Pending, Running, Succeeded, Failed, Cancelled
+	Action         string                 `json:"action,omitempty"`
+	StartTime      string                 `json:"startTime,omitempty"`
+	CompletionTime string                 `json:"completionTime,omitempty"`
+	Tasks          []TaskRunStatus        `json:"taskRuns,omitempty"`
+	Conditions     []PipelineRunCondition `json:"conditions,omitempty"`
+	Message        string                 `json:"message,omitempty"`
+	// Approvals are the manual approval gates the pipeline run reached
+	Approvals []ApprovalStatus `json:"approvals,omitempty"`
+	// DashboardURL is the page of the pipeline run in the Tekton dashboard,
+	// when one is configured
+	DashboardURL string `json:"dashboardURL,omitempty"`
+}
+
+// PendingApprovals returns the gates the pipeline run waits on
+func (s *PipelineRunStatus) PendingApprovals() []ApprovalStatus {
+	var pending []ApprovalStatus
+	for _, a := range s.Approvals {
+		if a.State == ApprovalPending {
+			pending = append(pending, a)
+		}
+	}
+	return pending
+}
+
+// IsDone reports whether the pipeline run reached a terminal state
+func (s *PipelineRunStatus) IsDone() bool {
+	switch s.Status {
+	case "Succeeded", "Failed", "Cancelled":
+		return true
+	default:
+		return false
+	}
 }
 
 // TaskRunStatus represents the status of a single task in a pipeline
 type TaskRunStatus struct {
-	Name      string `json:"name"`
-	Status    string `json:"status"`
-	StartTime string `json:"startTime,omitempty"`
+	Name           string `json:"name"`
+	Status         string `json:"status"`
+	StartTime      string `json:"startTime,omitempty"`
+	CompletionTime string `json:"completionTime,omitempty"`
+	// DurationSeconds is the run time of a completed task
+	DurationSeconds int64        `json:"durationSeconds,omitempty"`
+	Steps           []StepStatus `json:"steps,omitempty"`
+}
+
+// StepStatus represents the status of a step, a container of a task
+type StepStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // Pending, Running, Succeeded, Failed, Skipped
+	// ExitCode is set once the step terminated
+	ExitCode *int32 `json:"exitCode,omitempty"`
+	// Reason explains a waiting or failed step, e.g. ImagePullBackOff or OOMKilled
+	Reason          string `json:"reason,omitempty"`
+	StartTime       string `json:"startTime,omitempty"`
+	CompletionTime  string `json:"completionTime,omitempty"`
+	DurationSeconds int64  `json:"durationSeconds,omitempty"`
 }
 
 // PipelineRunCondition represents a condition of the pipeline run
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -47,6 +48,27 @@ func TestRegionRequest_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "sector is required",
 		},
+		{
+			name: "delete action",
+			req: RegionRequest{
+				Environment: "production",
+				Region:      "us-central1",
+				Sector:      "main",
+				Action:      RegionActionDelete,
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown action",
+			req: RegionRequest{
+				Environment: "production",
+				Region:      "us-central1",
+				Sector:      "main",
+				Action:      "update",
+			},
+			wantErr: true,
+			errMsg:  `unknown action "update", must be add or delete`,
+		},
 		{
 			name:    "all fields empty",
 			req:     RegionRequest{},
@@ -71,6 +93,41 @@ func TestRegionRequest_Validate(t *testing.T) {
 	}
 }
 
+func TestRegionRequest_GetAction(t *testing.T) {
+	req := RegionRequest{}
+	if got := req.GetAction(); got != RegionActionAdd {
+		t.Errorf("GetAction() = %v, want %v", got, RegionActionAdd)
+	}
+
+	req.Action = RegionActionDelete
+	if got := req.GetAction(); got != RegionActionDelete {
+		t.Errorf("GetAction() = %v, want %v", got, RegionActionDelete)
+	}
+}
+
+func TestRegionStatus_State(t *testing.T) {
+	tests := []struct {
+		action string
+		status string
+		want   string
+	}{
+		{RegionActionAdd, "Succeeded", "Provisioned"},
+		{RegionActionAdd, "Running", "Provisioning"},
+		{RegionActionAdd, "Pending", "Provisioning"},
+		{RegionActionAdd, "Failed", "Failed"},
+		{RegionActionDelete, "Succeeded", "Deleted"},
+		{RegionActionDelete, "Running", "Deleting"},
+		{RegionActionDelete, "Cancelled", "Cancelled"},
+	}
+
+	for _, tt := range tests {
+		s := RegionStatus{Action: tt.action, Status: tt.status}
+		if got := s.State(); got != tt.want {
+			t.Errorf("State() for %s/%s = %v, want %v", tt.action, tt.status, got, tt.want)
+		}
+	}
+}
+
 func TestValidationError_Error(t *testing.T) {
 	err := &ValidationError{
 		Field:   "test_field",
@@ -81,3 +138,30 @@ func TestValidationError_Error(t *testing.T) {
 		t.Errorf("ValidationError.Error() = %v, want %v", err.Error(), "test message")
 	}
 }
+
+func TestRegionStatus_MarshalJSON(t *testing.T) {
+	s := RegionStatus{
+		Environment: "production",
+		Sector:      "main",
+		Region:      "us-central1",
+		Action:      RegionActionAdd,
+		PipelineRun: "gcp-region-provision-6kjs6",
+		Status:      "Succeeded",
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if got["state"] != "Provisioned" {
+		t.Errorf("state = %v, want %v", got["state"], "Provisioned")
+	}
+	if got["region"] != "us-central1" || got["pipelineRun"] != "gcp-region-provision-6kjs6" {
+		t.Errorf("JSON = %s, want the region fields", data)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/flows"
+	"gcp-psc-demo/pkg/state"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on the flag set of config.LoadWithOptions
+var (
+	since    time.Duration
+	start    string
+	end      string
+	natRange string
+	limit    int
+)
+
+func bindAnalyzeFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&since, "since", time.Hour, "Analyze the logs of this long before --end")
+	fs.StringVar(&start, "start", "", "Start of the test window, RFC 3339 (overrides --since)")
+	fs.StringVar(&end, "end", "", "End of the test window, RFC 3339 (default now)")
+	fs.StringVar(&natRange, "nat-range", "", "PSC NAT range to match (default the range of the PSC NAT subnet)")
+	fs.IntVar(&limit, "limit", 1000, "Maximum number of log entries to read")
+}
+
+// window resolves the test window from --start, --end and --since
+func window() (flows.Window, error) {
+	w := flows.Window{End: time.Now()}
+	if end != "" {
+		t, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return w, fmt.Errorf("invalid --end: %v", err)
+		}
+		w.End = t
+	}
+	w.Start = w.End.Add(-since)
+	if start != "" {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return w, fmt.Errorf("invalid --start: %v", err)
+		}
+		w.Start = t
+	}
+	return w, nil
+}
+
+func main() {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.LoadWithOptions("analyze-flows", os.Args[1:], config.Options{Bind: bindAnalyzeFlags})
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
+		fmt.Println("export PROJECT_ID=your-project-id")
+		os.Exit(1)
+	}
+
+	// Pick up the subnets discovered in existing VPCs
+	if st, err := state.Load(cfg.StateFile); err != nil {
+		color.Yellow("⚠ Warning: %v", err)
+	} else if st != nil {
+		st.ApplyExistingVPCs(cfg)
+	}
+
+	w, err := window()
+	var analyzer *flows.Analyzer
+	if err == nil {
+		analyzer, err = flows.NewAnalyzer(cfg, flows.Options{Window: w, NATRange: natRange, Limit: limit})
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		os.Exit(1)
+	}
+
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - Flow Analysis")
+	color.Blue("==================================================")
+
+	fmt.Printf("Project ID: %s\n", cfg.ProjectID)
+	fmt.Printf("Run ID: %s\n", cfg.RunID)
+	fmt.Printf("\n")
+
+	report, err := analyzer.Run()
+	if err != nil {
+		color.Red("Analysis failed: %v", err)
+		os.Exit(1)
+	}
+
+	report.Write(os.Stdout)
+
+	for _, f := range report.Flows {
+		if f.Denied() {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gcp-psc-demo/pkg/apiserver"
+)
+
+// apiserver is deployed to the provider VM and emulates the hosted cluster's
+// kube-apiserver endpoint behind the internal load balancer
+func main() {
+	port := flag.Int("port", apiserver.DefaultPort, "TLS port to listen on")
+	certFile := flag.String("tls-cert-file", "", "Serving certificate (a self-signed one is generated when empty)")
+	keyFile := flag.String("tls-private-key-file", "", "Serving certificate key")
+	sans := flag.String("tls-san", "", "Comma-separated extra DNS names or IPs for the self-signed certificate")
+	gitVersion := flag.String("git-version", "v1.30.0", "Version reported by /version")
+	flag.Parse()
+
+	logger := log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
+
+	var cert tls.Certificate
+	var err error
+	if *certFile != "" || *keyFile != "" {
+		cert, err = tls.LoadX509KeyPair(*certFile, *keyFile)
+	} else {
+		var hosts []string
+		if *sans != "" {
+			hosts = strings.Split(*sans, ",")
+		}
+		cert, err = apiserver.SelfSignedCertificate(hosts)
+	}
+	if err != nil {
+		logger.Fatalf("Failed to load serving certificate: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:              ":" + strconv.Itoa(*port),
+		Handler:           apiserver.NewHandler(apiserver.NewVersionInfo(*gitVersion), logger),
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Printf("Emulated kube-apiserver %s listening on https://0.0.0.0:%d", *gitVersion, *port)
+	if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		logger.Fatalf("Server failed: %v", err)
+	}
+	logger.Printf("Server stopped")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gcp-psc-demo/pkg/capture"
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/state"
+	"gcp-psc-demo/pkg/vm"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on the flag set of config.LoadWithOptions
+var (
+	vms       string
+	duration  time.Duration
+	filter    string
+	outputDir string
+	requests  int
+)
+
+func bindCaptureFlags(fs *flag.FlagSet) {
+	fs.StringVar(&vms, "vms", "provider,consumer", "Comma-separated VMs to capture on: provider, consumer or VM names")
+	fs.DurationVar(&duration, "duration", 30*time.Second, "How long tcpdump runs on each VM")
+	fs.StringVar(&filter, "filter", "", "tcpdump filter expression (default \"tcp port <service port> or icmp\")")
+	fs.StringVar(&outputDir, "output", "", "Directory for the pcap files (default captures/<run id>-<time>)")
+	fs.IntVar(&requests, "requests", 5, "Requests sent from the consumer VM to the PSC endpoint during the capture, 0 to only observe")
+}
+
+func main() {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.LoadWithOptions("capture", os.Args[1:], config.Options{Bind: bindCaptureFlags})
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
+		fmt.Println("export PROJECT_ID=your-project-id")
+		os.Exit(1)
+	}
+
+	// Pick up the subnets discovered in existing VPCs
+	if st, err := state.Load(cfg.StateFile); err != nil {
+		color.Yellow("⚠ Warning: %v", err)
+	} else if st != nil {
+		st.ApplyExistingVPCs(cfg)
+	}
+
+	capturer, err := capture.NewCapturer(cfg, capture.Options{
+		Targets:   capture.ParseTargets(cfg, strings.Split(vms, ",")),
+		Duration:  duration,
+		Filter:    filter,
+		OutputDir: outputDir,
+		Requests:  requests,
+	})
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		os.Exit(1)
+	}
+
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - Packet Capture")
+	color.Blue("==================================================")
+
+	fmt.Printf("Project ID: %s\n", cfg.ProjectID)
+	fmt.Printf("Zone: %s\n", cfg.Zone)
+	fmt.Printf("Run ID: %s\n", cfg.RunID)
+	fmt.Printf("Output: %s\n", capturer.OutputDir())
+	fmt.Printf("\n")
+
+	// SSH access to the VMs, revoked once the captures are downloaded
+	ctx := context.Background()
+	sshSession, err := vm.SetupSSH(ctx, cfg)
+	if err != nil {
+		color.Red("SSH setup failed: %v", err)
+		os.Exit(1)
+	}
+	report, err := capturer.Run()
+	sshSession.Close(ctx)
+	if err != nil {
+		color.Red("Capture failed: %v", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n")
+	report.Write(os.Stdout)
+	fmt.Printf("\nCaptures and annotations.txt written to %s\n", capturer.OutputDir())
+
+	for _, f := range report.Files {
+		if f.Err != nil {
+			os.Exit(1)
+		}
+	}
+}
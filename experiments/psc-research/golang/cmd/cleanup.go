@@ -1,20 +1,42 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 
 	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/iamaudit"
+	"gcp-psc-demo/pkg/state"
+	"gcp-psc-demo/pkg/teardown"
+	"gcp-psc-demo/pkg/verify"
 	"github.com/fatih/color"
+	"google.golang.org/api/option"
 )
 
+// deleteFailures records the error for every deletion that failed,
+// keyed by kind/name, so the verification sweep can explain leftovers
+var deleteFailures = map[string]string{}
+
+// clientOptions are passed to every Compute client of the cleanup, they
+// record the API calls when an IAM role file is configured
+var clientOptions []option.ClientOption
+
 func main() {
-	// Create configuration
-	cfg := config.NewConfig()
-	if err := cfg.Validate(); err != nil {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.Load("cleanup", os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
 		color.Red("Configuration error: %v", err)
-		fmt.Println("Please set the PROJECT_ID environment variable:")
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
 		fmt.Println("export PROJECT_ID=your-project-id")
 		os.Exit(1)
 	}
@@ -26,8 +48,35 @@ func main() {
 	fmt.Printf("Project ID: %s\n", cfg.ProjectID)
 	fmt.Printf("Region: %s\n", cfg.Region)
 	fmt.Printf("Zone: %s\n", cfg.Zone)
+	fmt.Printf("Run ID: %s\n", cfg.RunID)
+	if cfg.NamePrefix != "" {
+		fmt.Printf("Name Prefix: %s\n", cfg.NamePrefix)
+	}
 	fmt.Printf("\n")
 
+	extraConsumers := 0
+	st, err := state.Load(cfg.StateFile)
+	if err != nil {
+		color.Yellow("⚠ Warning: %v", err)
+	} else if st == nil {
+		color.Yellow("⚠ No state file %s found for run %s; deleting resources by configured names", cfg.StateFile, cfg.RunID)
+	} else {
+		if st.NamePrefix != cfg.NamePrefix || st.ProjectID != cfg.ProjectID {
+			color.Yellow("⚠ State file %s was written for project %s with prefix %q; current config uses project %s with prefix %q",
+				cfg.StateFile, st.ProjectID, st.NamePrefix, cfg.ProjectID, cfg.NamePrefix)
+		}
+		// Never delete networks the run was deployed into, even without the flags
+		if st.ApplyExistingVPCs(cfg) {
+			color.Yellow("⚠ Run %s used existing VPCs from the state file, they will be kept", cfg.RunID)
+		}
+		extraConsumers = st.ExtraConsumers
+		st.ApplySecondaryRegion(cfg)
+		// Peering and VPN resources are only deleted for runs that created them
+		if st.ApplyConnectivityBackend(cfg) {
+			color.Yellow("⚠ Run %s used the %s connectivity backend from the state file", cfg.RunID, cfg.ConnectivityBackend)
+		}
+	}
+
 	color.Yellow("⚠ This will delete all demo resources. This action cannot be undone.")
 	fmt.Print("Do you want to proceed with cleanup? (y/N): ")
 
@@ -39,114 +88,183 @@ func main() {
 		os.Exit(0)
 	}
 
-	runCleanup(cfg)
+	// Record the Compute API calls for the least-privilege role
+	var recorder *iamaudit.Recorder
+	if cfg.IAMRoleFile != "" {
+		recorder = iamaudit.NewRecorder()
+		if clientOptions, err = recorder.ClientOptions(context.Background()); err != nil {
+			color.Red("IAM recording setup failed: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	ok := runCleanup(cfg, extraConsumers)
+	writeIAMRole(cfg, recorder)
+	if !ok {
+		os.Exit(1)
+	}
 }
 
-func runCleanup(cfg *config.Config) {
-	color.Blue("=== Starting cleanup process ===")
+// writeIAMRole adds the permissions of the recorded API calls to the custom
+// role in the IAM role file, whether the cleanup succeeded or not
+func writeIAMRole(cfg *config.Config, recorder *iamaudit.Recorder) {
+	if recorder == nil {
+		return
+	}
+	role, err := recorder.WriteRole(cfg.IAMRoleFile, "PSC demo provisioner",
+		"Permissions of the Compute API calls of the PSC demo and its cleanup")
+	if err != nil {
+		color.Yellow("⚠ Warning: %v", err)
+		return
+	}
+	fmt.Printf("\n%d Compute API methods called, %d permissions in %s\n",
+		len(recorder.Calls()), len(role.IncludedPermissions), cfg.IAMRoleFile)
+	for _, call := range recorder.Unknown() {
+		color.Yellow("⚠ No permissions known for %s (%d calls)", call.Method, call.Count)
+	}
+}
 
-	// Set the project
-	runCommand("gcloud", "config", "set", "project", cfg.ProjectID)
+func runCleanup(cfg *config.Config, extraConsumers int) bool {
+	color.Blue("=== Starting cleanup process ===")
 
-	// Delete PSC components
-	cleanupPSCComponents(cfg)
+	ctx := context.Background()
+
+	// Delete PSC, load balancer, VM and network resources, dependents first.
+	// Resources still in use by another resource are retried for a while.
+	// Extra consumers of a multi-consumer scenario go first, they share the provider.
+	for n := 2; n <= extraConsumers+1; n++ {
+		extra, err := cfg.ExtraConsumer(n)
+		if err != nil {
+			color.Red("✗ Teardown of consumer %d failed: %v", n, err)
+			continue
+		}
+		teardownResources(ctx, extra, consumerOnly)
+	}
+	// The secondary region of a multi-region run uses the VPCs of the primary one
+	var secondary *config.Config
+	if cfg.SecondaryRegion != "" {
+		var err error
+		if secondary, err = cfg.Secondary(); err != nil {
+			color.Red("✗ Teardown of secondary region %s failed: %v", cfg.SecondaryRegion, err)
+		} else {
+			teardownResources(ctx, secondary, regionOnly)
+		}
+	}
+	teardownResources(ctx, cfg, nil)
 
-	// Delete load balancer components
-	cleanupLoadBalancerComponents(cfg)
+	// Delete the uploaded API server binary
+	cleanupArtifacts(cfg)
 
-	// Delete VMs
-	cleanupVMs(cfg)
+	// Re-list everything and make sure nothing was left behind, in both
+	// regions of a multi-region run
+	clean := verifyCleanup(ctx, cfg)
+	if secondary != nil {
+		clean = verifyCleanup(ctx, secondary) && clean
+	}
+	if !clean {
+		color.Red("✗ Cleanup incomplete. Fix the issues above and re-run cleanup with the same NAME_PREFIX/RUN_ID.")
+		return false
+	}
 
-	// Delete VPCs and associated resources
-	cleanupVPCs(cfg)
+	if err := state.Remove(cfg.StateFile); err != nil {
+		color.Yellow("⚠ Warning: %v", err)
+	}
 
 	color.Green("✓ Cleanup completed successfully!")
 	fmt.Println("All demo resources have been deleted.")
+	return true
 }
 
-func cleanupPSCComponents(cfg *config.Config) {
-	color.Blue("=== Cleaning up PSC components ===")
-
-	// Delete PSC forwarding rule
-	deleteResource("forwarding-rules", cfg.PSCForwardingRule, "--region", cfg.Region)
-
-	// Delete PSC endpoint address
-	deleteResource("addresses", cfg.PSCEndpoint+"-ip", "--region", cfg.Region)
-
-	// Delete service attachment
-	deleteResource("service-attachments", cfg.ServiceAttachment, "--region", cfg.Region)
-}
-
-func cleanupLoadBalancerComponents(cfg *config.Config) {
-	color.Blue("=== Cleaning up load balancer components ===")
-
-	// Delete forwarding rule
-	deleteResource("forwarding-rules", cfg.ForwardingRule, "--region", cfg.Region)
+// verifyCleanup lists all demo resources still present and reports why they
+// are likely still there. It returns false if anything is left or the sweep failed.
+func verifyCleanup(ctx context.Context, cfg *config.Config) bool {
+	color.Blue("=== Verifying cleanup ===")
 
-	// Delete backend service
-	deleteResource("backend-services", cfg.BackendService, "--region", cfg.Region)
-
-	// Delete instance group
-	deleteResource("instance-groups", "redhat-service-group", "--zone", cfg.Zone)
+	verifier, err := verify.NewVerifier(cfg, clientOptions...)
+	if err != nil {
+		color.Red("✗ Verification failed: %v", err)
+		return false
+	}
+	defer verifier.Close()
 
-	// Delete health check
-	deleteResource("health-checks", cfg.HealthCheck)
-}
+	leftovers, err := verifier.Sweep(ctx)
+	if err != nil {
+		color.Red("✗ Verification failed: %v", err)
+		return false
+	}
 
-func cleanupVMs(cfg *config.Config) {
-	color.Blue("=== Cleaning up VMs ===")
+	for i := range leftovers {
+		failure, ok := deleteFailures[leftovers[i].ID()]
+		switch {
+		case ok && leftovers[i].Reason != "":
+			leftovers[i].Reason += "; delete failed: " + failure
+		case ok:
+			leftovers[i].Reason = "delete failed: " + failure
+		case leftovers[i].Reason == "":
+			leftovers[i].Reason = "not deleted by cleanup (created outside the configured names?)"
+		}
+	}
 
-	// Delete VMs
-	deleteResource("instances", cfg.ProviderVM, "--zone", cfg.Zone)
-	deleteResource("instances", cfg.ConsumerVM, "--zone", cfg.Zone)
+	verify.PrintReport(leftovers)
+	return len(leftovers) == 0
 }
 
-func cleanupVPCs(cfg *config.Config) {
-	color.Blue("=== Cleaning up VPCs and networking ===")
-
-	// Delete firewall rules
-	firewallRules := []string{
-		cfg.ProviderVPC + "-allow-health-checks",
-		cfg.ProviderVPC + "-allow-http",
-		cfg.ProviderVPC + "-allow-ssh",
-		cfg.ProviderVPC + "-allow-egress",
-		cfg.ProviderVPC + "-allow-psc-nat",
-		cfg.ConsumerVPC + "-allow-internal",
-		cfg.ConsumerVPC + "-allow-ssh",
-		cfg.ConsumerVPC + "-allow-egress",
+// teardownResources deletes the Compute resources in dependency order and
+// records every failure for the verification report. scope, if set, limits
+// the teardown to part of the resources.
+func teardownResources(ctx context.Context, cfg *config.Config, scope func(*teardown.Teardown)) {
+	td, err := teardown.NewTeardown(cfg, clientOptions...)
+	if err != nil {
+		color.Red("✗ Teardown failed: %v", err)
+		return
 	}
-
-	for _, rule := range firewallRules {
-		deleteResource("firewall-rules", rule)
+	defer td.Close()
+	if scope != nil {
+		scope(td)
 	}
 
-	// Delete subnets
-	deleteSubnet(cfg.ProviderSubnet, cfg.Region)
-	deleteSubnet(cfg.PSCNATSubnet, cfg.Region)
-	deleteSubnet(cfg.ConsumerSubnet, cfg.Region)
-
-	// Delete VPCs
-	deleteResource("networks", cfg.ProviderVPC)
-	deleteResource("networks", cfg.ConsumerVPC)
+	for _, r := range td.Run(ctx) {
+		if r.Outcome == teardown.Failed {
+			deleteFailures[r.ID()] = r.Err.Error()
+		}
+	}
 }
 
-func deleteResource(resourceType, resourceName string, extraArgs ...string) {
-	args := []string{"compute", resourceType, "delete", resourceName, "--quiet"}
-	args = append(args, extraArgs...)
+// consumerOnly keeps the provider side, for the extra consumers of a
+// multi-consumer scenario
+func consumerOnly(td *teardown.Teardown) { td.ConsumerOnly = true }
 
-	fmt.Printf("Deleting %s: %s\n", resourceType, resourceName)
-	runCommand("gcloud", args...)
-}
+// regionOnly keeps the VPCs and firewall rules, for the secondary region of a
+// multi-region run
+func regionOnly(td *teardown.Teardown) { td.RegionOnly = true }
+
+// cleanupArtifacts removes this run's API server binary. The bucket itself is
+// shared by all runs in the project and is left in place.
+func cleanupArtifacts(cfg *config.Config) {
+	color.Blue("=== Cleaning up API server artifacts ===")
 
-func deleteSubnet(subnetName, region string) {
-	fmt.Printf("Deleting subnet: %s\n", subnetName)
-	runCommand("gcloud", "compute", "networks", "subnets", "delete", subnetName, "--region", region, "--quiet")
+	object := fmt.Sprintf("gs://%s/%s", cfg.ArtifactBucketName(), cfg.APIServerObject())
+	fmt.Printf("Deleting object: %s\n", object)
+	runCommand("gcloud", "storage", "rm", object, "--quiet")
 }
 
-func runCommand(command string, args ...string) {
+func runCommand(command string, args ...string) error {
 	cmd := exec.Command(command, args...)
-	if err := cmd.Run(); err != nil {
-		// Don't fail on individual resource deletion errors
-		color.Yellow("⚠ Warning: %v", err)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		// Don't fail on individual deletion errors, the verification sweep decides
+		msg := lastLine(string(output))
+		if msg == "" {
+			msg = err.Error()
+		}
+		color.Yellow("⚠ Warning: %s", msg)
+		return fmt.Errorf("%s", msg)
 	}
+	return nil
+}
+
+// lastLine returns the last non-empty line of gcloud output, which holds the error summary
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
 }
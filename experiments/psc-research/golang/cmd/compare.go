@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/hybrid"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on the flag set of config.LoadWithOptions
+var allRegions bool
+
+func bindCompareFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&allRegions, "all-regions", false, "Compare the runs of every region instead of only --region")
+}
+
+func main() {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.LoadWithOptions("compare", os.Args[1:], config.Options{Bind: bindCompareFlags})
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil && cfg.ComparisonLog == "" {
+		err = fmt.Errorf("no comparison log (COMPARISON_LOG or --comparison-log)")
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		os.Exit(1)
+	}
+
+	// Reading the log needs no project
+	records, err := hybrid.Load(cfg.ComparisonLog)
+	if err != nil {
+		color.Red("%v", err)
+		os.Exit(1)
+	}
+	scope := "all regions"
+	if !allRegions {
+		scope = cfg.Region
+		var inRegion []hybrid.Record
+		for _, r := range records {
+			if r.Region == cfg.Region {
+				inRegion = append(inRegion, r)
+			}
+		}
+		records = inRegion
+	}
+	if len(records) == 0 {
+		fmt.Printf("No runs of %s in %s yet, every `make demo` adds one for its CONNECTIVITY_BACKEND\n", scope, cfg.ComparisonLog)
+		return
+	}
+
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - Connectivity Comparison")
+	color.Blue("==================================================")
+
+	fmt.Printf("%d runs in %s from %s\n\n", len(records), scope, cfg.ComparisonLog)
+	stats := hybrid.Summarize(records)
+	hybrid.WriteSummary(os.Stdout, stats)
+	if len(stats) < 3 {
+		fmt.Println()
+		color.Yellow("⚠ Not every backend has runs yet, run the demo with CONNECTIVITY_BACKEND=psc, peering and vpn")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/diagram"
+	"gcp-psc-demo/pkg/state"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on every flag set config.LoadWithOptions creates
+var (
+	diagramFormat string
+	diagramOutput string
+)
+
+func bindDiagramFlags(fs *flag.FlagSet) {
+	fs.StringVar(&diagramFormat, "format", diagram.FormatDOT, "Diagram source format: dot (Graphviz) or d2")
+	fs.StringVar(&diagramOutput, "output", "", "File to write the diagram to, - for stdout (default psc-architecture.<format>)")
+}
+
+// diagram draws the architecture of a run as Graphviz or D2 source, from the
+// resources that exist in the project rather than the intended design
+func main() {
+	cfg, err := config.LoadWithOptions("diagram", os.Args[1:], config.Options{Bind: bindDiagramFlags})
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err == nil && diagramFormat != diagram.FormatDOT && diagramFormat != diagram.FormatD2 {
+		err = fmt.Errorf("--format must be %s or %s", diagram.FormatDOT, diagram.FormatD2)
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
+		fmt.Println("export PROJECT_ID=your-project-id")
+		os.Exit(1)
+	}
+	if diagramOutput == "" {
+		diagramOutput = "psc-architecture." + diagramFormat
+	}
+	// With the diagram on stdout, the banner and hints are left out and
+	// warnings go to stderr
+	toStdout := diagramOutput == "-"
+
+	if !toStdout {
+		color.Blue("==================================================")
+		color.Blue("  GCP Private Service Connect Demo - Diagram")
+		color.Blue("==================================================")
+
+		fmt.Printf("Project ID: %s\n", cfg.ProjectID)
+		fmt.Printf("Region: %s\n", cfg.Region)
+		fmt.Printf("Run ID: %s\n", cfg.RunID)
+	}
+
+	st, err := state.Load(cfg.StateFile)
+	switch {
+	case err != nil && toStdout:
+		fmt.Fprintf(os.Stderr, "⚠ Warning: %v\n", err)
+	case err != nil:
+		color.Yellow("⚠ Warning: %v", err)
+	case st == nil:
+		if !toStdout {
+			fmt.Printf("State File: %s (not found, drawing the configured run)\n", cfg.StateFile)
+		}
+	default:
+		if !toStdout {
+			fmt.Printf("State File: %s (run started %s)\n", cfg.StateFile, st.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		}
+		st.ApplyExistingVPCs(cfg)
+		st.ApplySecondaryRegion(cfg)
+		st.ApplyConnectivityBackend(cfg)
+	}
+
+	builder, err := diagram.NewBuilder(cfg, st)
+	if err != nil {
+		color.Red("Failed to create diagram builder: %v", err)
+		os.Exit(1)
+	}
+	defer builder.Close()
+
+	d, err := builder.Build(context.Background())
+	if err != nil {
+		color.Red("Failed to read the resources of the run: %v", err)
+		os.Exit(1)
+	}
+
+	var w io.Writer = os.Stdout
+	if !toStdout {
+		f, err := os.Create(diagramOutput)
+		if err != nil {
+			color.Red("Failed to create %s: %v", diagramOutput, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := diagram.Write(w, d, diagramFormat); err != nil {
+		color.Red("Failed to write the diagram: %v", err)
+		os.Exit(1)
+	}
+	if toStdout {
+		return
+	}
+
+	color.Green("\n✓ Diagram written to %s", diagramOutput)
+	if diagramFormat == diagram.FormatD2 {
+		fmt.Printf("Render it with: d2 %s psc-architecture.svg\n", diagramOutput)
+	} else {
+		fmt.Printf("Render it with: dot -Tsvg %s -o psc-architecture.svg\n", diagramOutput)
+	}
+}
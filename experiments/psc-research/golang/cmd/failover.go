@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/failover"
+	"gcp-psc-demo/pkg/state"
+	"gcp-psc-demo/pkg/vm"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on the flag set of config.LoadWithOptions
+var (
+	interval time.Duration
+	baseline time.Duration
+	outage   time.Duration
+	recovery time.Duration
+)
+
+func bindFailoverFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&interval, "interval", time.Second, "Time between probes of the endpoints, and the timeout of each probe")
+	fs.DurationVar(&baseline, "baseline", 30*time.Second, "How long both regions are probed before the primary region is disabled")
+	fs.DurationVar(&outage, "outage", 2*time.Minute, "How long the primary region stays disabled")
+	fs.DurationVar(&recovery, "recovery", 2*time.Minute, "How long both regions are probed after the primary region is enabled again")
+}
+
+func main() {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.LoadWithOptions("failover", os.Args[1:], config.Options{Bind: bindFailoverFlags})
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+
+	// Pick up the secondary region the demo was deployed to
+	if err == nil {
+		if st, stErr := state.Load(cfg.StateFile); stErr != nil {
+			color.Yellow("⚠ Warning: %v", stErr)
+		} else if st != nil {
+			st.ApplySecondaryRegion(cfg)
+		}
+		err = cfg.Validate()
+	}
+	var secondary *config.Config
+	if err == nil {
+		secondary, err = cfg.Secondary()
+	}
+	var tester *failover.Tester
+	if err == nil {
+		tester, err = failover.NewTester(cfg, secondary, failover.Options{
+			Interval: interval,
+			Baseline: baseline,
+			Outage:   outage,
+			Recovery: recovery,
+		})
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		fmt.Println("Deploy the demo with a secondary region first:")
+		fmt.Println("export SECONDARY_REGION=us-east1 SECONDARY_ZONE=us-east1-b")
+		os.Exit(1)
+	}
+
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - Region Failover")
+	color.Blue("==================================================")
+
+	fmt.Printf("Project ID: %s\n", cfg.ProjectID)
+	fmt.Printf("Primary Region: %s (provider VM %s is stopped)\n", cfg.Region, cfg.ProviderVM)
+	fmt.Printf("Secondary Region: %s\n", secondary.Region)
+	fmt.Printf("Run ID: %s\n", cfg.RunID)
+	fmt.Printf("\n")
+
+	// SSH access to the VMs, revoked once the test is done
+	ctx := context.Background()
+	sshSession, err := vm.SetupSSH(ctx, cfg)
+	if err != nil {
+		color.Red("SSH setup failed: %v", err)
+		os.Exit(1)
+	}
+	report, err := tester.Run()
+	sshSession.Close(ctx)
+	if err != nil {
+		color.Red("Failover test failed: %v", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n")
+	report.Write(os.Stdout)
+
+	if !report.Passed() {
+		os.Exit(1)
+	}
+}
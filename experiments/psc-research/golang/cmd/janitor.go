@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/janitor"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on every flag set config.LoadWithOptions creates
+var (
+	sweepInterval time.Duration
+	sweepOnce     bool
+	sweepDryRun   bool
+	webhookURL    string
+)
+
+func bindJanitorFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&sweepInterval, "interval", time.Hour, "Delay between sweeps of the project")
+	fs.BoolVar(&sweepOnce, "once", false, "Sweep once and exit, non-zero if a run could not be deleted (for cron or Cloud Scheduler)")
+	fs.BoolVar(&sweepDryRun, "dry-run", false, "Report the expired runs without deleting them")
+	fs.StringVar(&webhookURL, "webhook", os.Getenv("JANITOR_WEBHOOK"), "URL to POST the JSON summary of each sweep to")
+}
+
+// janitor deletes the demo runs of a project whose psc-demo-expires label has
+// passed, finding them by label so that no state file is needed
+func main() {
+	cfg, err := config.LoadWithOptions("janitor", os.Args[1:], config.Options{Bind: bindJanitorFlags})
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err == nil && !sweepOnce && sweepInterval <= 0 {
+		err = fmt.Errorf("--interval must be positive")
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
+		fmt.Println("export PROJECT_ID=your-project-id")
+		os.Exit(1)
+	}
+
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - Janitor")
+	color.Blue("==================================================")
+
+	fmt.Printf("Project ID: %s\n", cfg.ProjectID)
+	if sweepOnce {
+		fmt.Printf("Mode: single sweep\n")
+	} else {
+		fmt.Printf("Mode: sweep every %s\n", sweepInterval)
+	}
+	if sweepDryRun {
+		color.Yellow("Dry run: expired runs are reported, not deleted")
+	}
+	fmt.Printf("\n")
+
+	j, err := janitor.NewJanitor(cfg)
+	if err != nil {
+		color.Red("Failed to create janitor: %v", err)
+		os.Exit(1)
+	}
+	defer j.Close()
+	j.DryRun = sweepDryRun
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if sweepOnce {
+		if !sweep(ctx, j) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		sweep(ctx, j)
+		select {
+		case <-ctx.Done():
+			fmt.Println("Janitor stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep runs one sweep, prints and posts its summary, and reports whether
+// every expired run was deleted
+func sweep(ctx context.Context, j *janitor.Janitor) bool {
+	summary, err := j.Sweep(ctx)
+	if err != nil {
+		color.Red("Sweep failed: %v", err)
+		return false
+	}
+	fmt.Printf("\n")
+	summary.Write(os.Stdout)
+
+	if webhookURL != "" {
+		if err := summary.Post(ctx, webhookURL); err != nil {
+			color.Yellow("⚠ Warning: %v", err)
+		}
+	}
+	return summary.Count(janitor.ActionFailed) == 0
+}
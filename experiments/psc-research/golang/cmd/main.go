@@ -3,29 +3,80 @@ package main
 import (
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
 	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/failover"
+	"gcp-psc-demo/pkg/gcpops"
+	"gcp-psc-demo/pkg/hybrid"
+	"gcp-psc-demo/pkg/iamaudit"
+	"gcp-psc-demo/pkg/propagation"
 	"gcp-psc-demo/pkg/psc"
+	"gcp-psc-demo/pkg/readiness"
+	"gcp-psc-demo/pkg/results"
+	"gcp-psc-demo/pkg/state"
 	"gcp-psc-demo/pkg/testing"
 	"gcp-psc-demo/pkg/vm"
 	"gcp-psc-demo/pkg/vpc"
 	"github.com/fatih/color"
+	"google.golang.org/api/option"
 )
 
+// clientOptions are passed to every Compute client of the run, they record
+// the API calls when an IAM role file is configured
+var clientOptions []option.ClientOption
+
+// setupDuration is how long step 4 took to connect the VPCs, for the
+// comparison of the connectivity backends
+var setupDuration time.Duration
+
+// comparisonRequests is how many requests the consumer VM sends through the
+// backend for its latency in the comparison log
+const comparisonRequests = 50
+
 func main() {
-	// Create configuration
-	cfg := config.NewConfig()
-	if err := cfg.Validate(); err != nil {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.Load("demo", os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
 		printError(fmt.Sprintf("Configuration error: %v", err))
-		fmt.Println("Please set the PROJECT_ID environment variable:")
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
 		fmt.Println("export PROJECT_ID=your-project-id")
 		os.Exit(1)
 	}
 
+	// The provider VM runs the API server emulator, fail before creating anything
+	// without it, unless a published image of it is configured
+	if _, err := os.Stat(cfg.APIServerBinary); err != nil && cfg.APIServerImage == "" {
+		printError(fmt.Sprintf("API server binary %s not found: %v", cfg.APIServerBinary, err))
+		fmt.Println("Build it with `make build` or point --apiserver-binary at a linux/amd64 build of cmd/apiserver.go")
+		os.Exit(1)
+	}
+
+	// Record the Compute API calls for the least-privilege role
+	var recorder *iamaudit.Recorder
+	if cfg.IAMRoleFile != "" {
+		recorder = iamaudit.NewRecorder()
+		if clientOptions, err = recorder.ClientOptions(context.Background()); err != nil {
+			printError(fmt.Sprintf("IAM recording setup failed: %v", err))
+			os.Exit(1)
+		}
+	}
+
+	if err := vm.CheckMachineType(context.Background(), cfg, clientOptions...); err != nil {
+		printError(fmt.Sprintf("Configuration error: %v", err))
+		os.Exit(1)
+	}
+
 	// Print banner
 	printBanner(cfg)
 
@@ -37,13 +88,50 @@ func main() {
 
 	ctx := context.Background()
 
+	// Record the run before creating anything so cleanup can find partial runs
+	if err := state.Save(cfg.StateFile, state.FromConfig(cfg)); err != nil {
+		printError(fmt.Sprintf("Failed to save run state: %v", err))
+		os.Exit(1)
+	}
+
+	// SSH access to the VMs, revoked when the demo is done. In metadata mode
+	// the VMs get the key when they are created.
+	sshSession, err := vm.SetupSSH(ctx, cfg, clientOptions...)
+	if err != nil {
+		printError(fmt.Sprintf("SSH setup failed: %v", err))
+		os.Exit(1)
+	}
+
 	// Run the demo
-	if err := runDemo(ctx, cfg); err != nil {
+	err = runDemo(ctx, cfg)
+	sshSession.Close(ctx)
+	writeIAMRole(cfg, recorder)
+	if err != nil {
 		printError(fmt.Sprintf("Demo failed: %v", err))
 		os.Exit(1)
 	}
 
-	printSuccess()
+	printSuccess(cfg)
+}
+
+// writeIAMRole adds the permissions of the recorded API calls to the custom
+// role in the IAM role file, whether the run succeeded or not
+func writeIAMRole(cfg *config.Config, recorder *iamaudit.Recorder) {
+	if recorder == nil {
+		return
+	}
+	role, err := recorder.WriteRole(cfg.IAMRoleFile, "PSC demo provisioner",
+		"Permissions of the Compute API calls of the PSC demo and its cleanup")
+	if err != nil {
+		color.Yellow("⚠ Warning: %v", err)
+		return
+	}
+	fmt.Printf("\n%d Compute API methods called, %d permissions in %s\n",
+		len(recorder.Calls()), len(role.IncludedPermissions), cfg.IAMRoleFile)
+	for _, call := range recorder.Unknown() {
+		color.Yellow("⚠ No permissions known for %s (%d calls)", call.Method, call.Count)
+	}
+	fmt.Printf("Create the role with: gcloud iam roles create <role-id> --project %s --file %s\n", cfg.ProjectID, cfg.IAMRoleFile)
 }
 
 func printBanner(cfg *config.Config) {
@@ -56,6 +144,40 @@ func printBanner(cfg *config.Config) {
 	fmt.Printf("  Project ID: %s\n", cfg.ProjectID)
 	fmt.Printf("  Region: %s\n", cfg.Region)
 	fmt.Printf("  Zone: %s\n", cfg.Zone)
+	fmt.Printf("  Run ID: %s\n", cfg.RunID)
+	if cfg.NamePrefix != "" {
+		fmt.Printf("  Name Prefix: %s\n", cfg.NamePrefix)
+	}
+	fmt.Printf("  State File: %s\n", cfg.StateFile)
+	if cfg.ConnectivityBackend != config.BackendPSC {
+		fmt.Printf("  Connectivity Backend: %s (instead of Private Service Connect)\n", cfg.ConnectivityBackend)
+	}
+	if cfg.SecondaryRegion != "" {
+		fmt.Printf("  Secondary Region: %s (zone %s)\n", cfg.SecondaryRegion, cfg.SecondaryZone)
+	}
+	if cfg.SSHMode != config.SSHModeGcloud {
+		fmt.Printf("  SSH: %s, ephemeral key valid for %s\n", cfg.SSHMode, cfg.SSHKeyTTL)
+	}
+	if cfg.ExistingProviderVPC != "" {
+		fmt.Printf("  Existing Provider VPC: %s (not created or deleted)\n", cfg.ExistingProviderVPC)
+	}
+	if cfg.ExistingConsumerVPC != "" {
+		fmt.Printf("  Existing Consumer VPC: %s (not created or deleted)\n", cfg.ExistingConsumerVPC)
+	}
+	if cfg.Verbose {
+		fmt.Printf("  Provider VPC: %s (subnet %s %s, PSC NAT %s %s)\n",
+			cfg.ProviderVPC, cfg.ProviderSubnet, cfg.ProviderSubnetRange, cfg.PSCNATSubnet, cfg.PSCNATSubnetRange)
+		fmt.Printf("  Consumer VPC: %s (subnet %s %s)\n", cfg.ConsumerVPC, cfg.ConsumerSubnet, cfg.ConsumerSubnetRange)
+		fmt.Printf("  VMs: %s (%s/%s), %s (%s/%s), %s\n", cfg.ProviderVM, cfg.ProviderImageProject, cfg.ProviderImageFamily,
+			cfg.ConsumerVM, cfg.ImageProject, cfg.ImageFamily, cfg.MachineType)
+		fmt.Printf("  Service Port: %d\n", cfg.ServicePort)
+		fmt.Printf("  Web Image: %s\n", cfg.WebImage)
+		if cfg.APIServerImage != "" {
+			fmt.Printf("  API Server Image: %s\n", cfg.APIServerImage)
+		} else {
+			fmt.Printf("  API Server Binary: %s -> gs://%s/%s\n", cfg.APIServerBinary, cfg.ArtifactBucketName(), cfg.APIServerObject())
+		}
+	}
 	fmt.Printf("\n")
 }
 
@@ -73,6 +195,9 @@ func askForConfirmation() bool {
 }
 
 func runDemo(ctx context.Context, cfg *config.Config) error {
+	// Operation clients are shared by all managers for the whole run
+	defer gcpops.Shared().Close()
+
 	// Step 1: Setup Provider VPC
 	if err := runStep(ctx, cfg, "1", "Setup hypershift-redhat VPC (Service Provider)", setupProviderVPC); err != nil {
 		return err
@@ -98,19 +223,39 @@ func runDemo(ctx context.Context, cfg *config.Config) error {
 		return err
 	}
 
-	// Step 4: Setup Private Service Connect
-	if err := runStep(ctx, cfg, "4", "Setup Private Service Connect", setupPSC); err != nil {
+	// Step 4: Setup Private Service Connect, measuring how long after their
+	// operations complete the endpoint is accepted and serves requests, or
+	// the backend it is compared with
+	if err := runStep(ctx, cfg, "4", connectivityStepName(cfg), setupConnectivity); err != nil {
 		return err
 	}
 
-	// PSC operations complete when API returns - no additional wait needed
-	// Resource readiness is validated during connectivity testing
-
 	// Step 5: Test connectivity
 	if err := runStep(ctx, cfg, "5", "Test Connectivity", testConnectivity); err != nil {
 		return err
 	}
 
+	// Step 5b: Latency, setup effort and isolation of the backend, see `make compare`
+	if cfg.ComparisonLog != "" {
+		if err := runStep(ctx, cfg, "5b", "Compare Connectivity Backend", compareBackend); err != nil {
+			return err
+		}
+	}
+
+	if cfg.SecondaryRegion == "" {
+		return nil
+	}
+
+	// Step 6: Provider service and endpoint in the secondary region
+	if err := runStep(ctx, cfg, "6", "Setup Secondary Region "+cfg.SecondaryRegion, setupSecondaryRegion); err != nil {
+		return err
+	}
+
+	// Step 7: The consumer reaches both regions, ready for the failover test
+	if err := runStep(ctx, cfg, "7", "Test Secondary Region Connectivity", testSecondaryRegion); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -123,42 +268,59 @@ func runStep(ctx context.Context, cfg *config.Config, stepNum, stepName string,
 	}
 
 	printStepSuccess(stepNum)
-	waitBetweenSteps()
-	return nil
+	return waitForStep(ctx, cfg, stepNum)
 }
 
 func setupProviderVPC(ctx context.Context, cfg *config.Config) error {
-	vpcManager, err := vpc.NewVPCManager(cfg)
+	vpcManager, err := vpc.NewVPCManager(cfg, clientOptions...)
 	if err != nil {
 		return err
 	}
 	defer vpcManager.Close()
 
+	if cfg.ExistingProviderVPC != "" {
+		if err := vpcManager.UseExistingProviderVPC(ctx); err != nil {
+			return err
+		}
+		// Record the discovered subnet names for later commands
+		return state.Save(cfg.StateFile, state.FromConfig(cfg))
+	}
 	return vpcManager.CreateProviderVPC(ctx)
 }
 
 func setupConsumerVPC(ctx context.Context, cfg *config.Config) error {
-	vpcManager, err := vpc.NewVPCManager(cfg)
+	vpcManager, err := vpc.NewVPCManager(cfg, clientOptions...)
 	if err != nil {
 		return err
 	}
 	defer vpcManager.Close()
 
+	if cfg.ExistingConsumerVPC != "" {
+		if err := vpcManager.UseExistingConsumerVPC(ctx); err != nil {
+			return err
+		}
+		return state.Save(cfg.StateFile, state.FromConfig(cfg))
+	}
 	return vpcManager.CreateConsumerVPC(ctx)
 }
 
 func deployVMs(ctx context.Context, cfg *config.Config) error {
-	vmManager, err := vm.NewVMManager(cfg)
+	vmManager, err := vm.NewVMManager(cfg, clientOptions...)
 	if err != nil {
 		return err
 	}
 	defer vmManager.Close()
 
+	// The provider VM downloads the API server emulator when its container starts
+	if err := vmManager.UploadAPIServer(); err != nil {
+		return err
+	}
+
 	return vmManager.DeployVMs(ctx)
 }
 
 func waitForVMs(ctx context.Context, cfg *config.Config) error {
-	vmManager, err := vm.NewVMManager(cfg)
+	vmManager, err := vm.NewVMManager(cfg, clientOptions...)
 	if err != nil {
 		return err
 	}
@@ -168,7 +330,7 @@ func waitForVMs(ctx context.Context, cfg *config.Config) error {
 }
 
 func setupPSC(ctx context.Context, cfg *config.Config) error {
-	pscManager, err := psc.NewPSCManager(cfg)
+	pscManager, err := psc.NewPSCManager(cfg, clientOptions...)
 	if err != nil {
 		return err
 	}
@@ -177,6 +339,144 @@ func setupPSC(ctx context.Context, cfg *config.Config) error {
 	return pscManager.SetupPrivateServiceConnect(ctx)
 }
 
+// connectivityStepName names step 4 after the connectivity backend
+func connectivityStepName(cfg *config.Config) string {
+	switch cfg.ConnectivityBackend {
+	case config.BackendPeering:
+		return "Setup VPC Peering"
+	case config.BackendVPN:
+		return "Setup HA VPN"
+	default:
+		return "Setup Private Service Connect"
+	}
+}
+
+// setupConnectivity connects the consumer to the provider service with the
+// configured backend and records how long it took
+func setupConnectivity(ctx context.Context, cfg *config.Config) error {
+	start := time.Now()
+	defer func() { setupDuration = time.Since(start) }()
+
+	if cfg.ConnectivityBackend == config.BackendPSC {
+		return setupMeasuredPSC(ctx, cfg)
+	}
+	return setupHybrid(ctx, cfg)
+}
+
+// setupHybrid puts the same internal load balancer in front of the provider
+// VM as PSC does, lets the consumer subnet reach it and connects the VPCs
+// with peering or HA VPN
+func setupHybrid(ctx context.Context, cfg *config.Config) error {
+	pscManager, err := psc.NewPSCManager(cfg, clientOptions...)
+	if err != nil {
+		return err
+	}
+	defer pscManager.Close()
+	if err := pscManager.SetupLoadBalancer(ctx); err != nil {
+		return err
+	}
+
+	vpcManager, err := vpc.NewVPCManager(cfg, clientOptions...)
+	if err != nil {
+		return err
+	}
+	defer vpcManager.Close()
+	if err := vpcManager.CreateConsumerAccessRule(ctx); err != nil {
+		return err
+	}
+
+	hybridManager, err := hybrid.NewManager(cfg, clientOptions...)
+	if err != nil {
+		return err
+	}
+	defer hybridManager.Close()
+	return hybridManager.Setup(ctx)
+}
+
+// setupMeasuredPSC sets up PSC while the consumer VM waits for the endpoint to
+// serve, and appends the propagation delays to the log shared by all runs
+func setupMeasuredPSC(ctx context.Context, cfg *config.Config) error {
+	if cfg.PropagationLog == "" {
+		return setupPSC(ctx, cfg)
+	}
+
+	measurer, err := propagation.NewMeasurer(cfg, propagation.DefaultOptions)
+	if err != nil {
+		return err
+	}
+	pscManager, err := psc.NewPSCManager(cfg, clientOptions...)
+	if err != nil {
+		return err
+	}
+	defer pscManager.Close()
+	pscManager.Timeline = measurer.Timeline
+
+	measurer.Start(ctx)
+	if err := pscManager.SetupPrivateServiceConnect(ctx); err != nil {
+		measurer.Stop()
+		return err
+	}
+
+	fmt.Println("Waiting for the first request through the PSC endpoint...")
+	measurement := measurer.Finish()
+	measurement.Write(os.Stdout)
+	if err := propagation.Append(cfg.PropagationLog, measurement); err != nil {
+		color.Yellow("⚠ Warning: %v", err)
+	}
+	return nil
+}
+
+// setupSecondaryRegion creates the subnets, provider VM, load balancer,
+// service attachment and PSC endpoint of the secondary region in the VPCs of
+// the primary one
+func setupSecondaryRegion(ctx context.Context, cfg *config.Config) error {
+	secondary, err := cfg.Secondary()
+	if err != nil {
+		return err
+	}
+
+	vpcManager, err := vpc.NewVPCManager(secondary, clientOptions...)
+	if err != nil {
+		return err
+	}
+	defer vpcManager.Close()
+	if err := vpcManager.CreateSecondaryRegionSubnets(ctx); err != nil {
+		return err
+	}
+
+	// The provider VM downloads the API server emulator uploaded in step 3
+	vmManager, err := vm.NewVMManager(secondary, clientOptions...)
+	if err != nil {
+		return err
+	}
+	defer vmManager.Close()
+	if err := vmManager.DeployProviderVM(ctx); err != nil {
+		return err
+	}
+	if err := vmManager.WaitForProviderVMReady(ctx); err != nil {
+		return err
+	}
+
+	return setupPSC(ctx, secondary)
+}
+
+func testSecondaryRegion(ctx context.Context, cfg *config.Config) error {
+	secondary, err := cfg.Secondary()
+	if err != nil {
+		return err
+	}
+	tester, err := failover.NewTester(cfg, secondary, failover.Options{
+		Interval: 5 * time.Second,
+		Baseline: 5 * time.Second,
+		Outage:   5 * time.Second,
+		Recovery: 5 * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	return tester.CheckEndpoints()
+}
+
 func printStep(stepNum, stepName string) {
 	color.Blue("=== Step %s: %s ===", stepNum, stepName)
 }
@@ -189,7 +489,7 @@ func printError(message string) {
 	color.Red("✗ %s", message)
 }
 
-func printSuccess() {
+func printSuccess(cfg *config.Config) {
 	printStep("", "Demo Completed Successfully!")
 	fmt.Println("")
 	color.Green("🎉 Private Service Connect demo is now running!")
@@ -205,33 +505,113 @@ func printSuccess() {
 	fmt.Println("• Review the connectivity test results above")
 	fmt.Println("• Explore the GCP Console to see the created resources")
 	fmt.Println("• Run additional tests if needed")
-	fmt.Println("• When finished, run the cleanup script")
+	if cfg.ComparisonLog != "" {
+		fmt.Println("• Compare PSC with VPC peering and HA VPN with `make compare` once runs of each backend are logged")
+	}
+	if cfg.SecondaryRegion != "" {
+		fmt.Println("• Measure a region failover with `make failover`")
+	}
+	fmt.Println("• When finished, run the cleanup script with the same NAME_PREFIX/RUN_ID")
 	fmt.Println("")
 	color.Yellow("⚠ Remember to clean up resources when done to avoid charges!")
 }
 
-func waitBetweenSteps() {
-	// Reduced wait time - GCP operations are completed when API calls return
-	fmt.Println("Waiting 5 seconds for resource propagation...")
-	time.Sleep(5 * time.Second)
+// waitForStep probes the resources of a step the next steps depend on until
+// they are ready, see stepWaiters. Step 4 also measures the propagation
+// delays of PSC, see `make propagation`.
+func waitForStep(ctx context.Context, cfg *config.Config, stepNum string) error {
+	probes, err := readiness.NewProbes(cfg.ProjectID, clientOptions...)
+	if err != nil {
+		return err
+	}
+	defer probes.Close()
+
+	waiters, err := stepWaiters(cfg, probes, stepNum)
+	if err != nil {
+		return err
+	}
+	if len(waiters) == 0 {
+		return nil
+	}
+	fmt.Printf("Waiting up to %v for %d resources of step %s to be ready...\n", cfg.ReadinessTimeout, len(waiters), stepNum)
+	if err := readiness.Wait(ctx, waiters, readiness.Options{Timeout: cfg.ReadinessTimeout, Interval: cfg.ReadinessInterval}); err != nil {
+		printError(fmt.Sprintf("Step %s resources %v", stepNum, err))
+		return err
+	}
+	return nil
+}
+
+// stepWaiters returns the waiters of the resources a step creates: the
+// cloud-init of the VMs, then the load balancer and, with PSC, the service
+// attachment and endpoint. VPCs and firewall rules are usable once their
+// operations are done, and test steps create nothing.
+func stepWaiters(cfg *config.Config, probes *readiness.Probes, stepNum string) ([]readiness.Waiter, error) {
+	switch stepNum {
+	case "3":
+		return probes.VMs(cfg), nil
+	case "4":
+		if cfg.ConnectivityBackend == config.BackendPSC {
+			return probes.PSC(cfg), nil
+		}
+		return probes.LoadBalancer(cfg), nil
+	case "6":
+		secondary, err := cfg.Secondary()
+		if err != nil {
+			return nil, err
+		}
+		return append([]readiness.Waiter{probes.CloudInitDone(secondary.Zone, secondary.ProviderVM)}, probes.PSC(secondary)...), nil
+	}
+	return nil, nil
 }
 
 func testIsolation(ctx context.Context, cfg *config.Config) error {
-	testManager, err := testing.NewTestManager(cfg)
+	testManager, err := testing.NewTestManager(cfg, clientOptions...)
 	if err != nil {
 		return err
 	}
 	defer testManager.Close()
 
-	return testManager.TestIsolation(ctx)
+	err = testManager.TestIsolation(ctx)
+	if exportErr := results.Export(ctx, cfg, "demo", testManager.Results()); exportErr != nil {
+		color.Yellow("⚠ Warning: %v", exportErr)
+	}
+	return err
 }
 
 func testConnectivity(ctx context.Context, cfg *config.Config) error {
-	testManager, err := testing.NewTestManager(cfg)
+	testManager, err := testing.NewTestManager(cfg, clientOptions...)
 	if err != nil {
 		return err
 	}
 	defer testManager.Close()
 
-	return testManager.TestConnectivity(ctx)
+	err = testManager.TestConnectivity(ctx)
+	if exportErr := results.Export(ctx, cfg, "demo", testManager.Results()); exportErr != nil {
+		color.Yellow("⚠ Warning: %v", exportErr)
+	}
+	return err
+}
+
+// compareBackend measures the backend of the run and appends it to the
+// comparison log shared by all runs
+func compareBackend(ctx context.Context, cfg *config.Config) error {
+	testManager, err := testing.NewTestManager(cfg, clientOptions...)
+	if err != nil {
+		return err
+	}
+	defer testManager.Close()
+
+	record, err := testManager.Compare(ctx, comparisonRequests)
+	if exportErr := results.Export(ctx, cfg, "demo", testManager.Results()); exportErr != nil {
+		color.Yellow("⚠ Warning: %v", exportErr)
+	}
+	if err != nil {
+		return err
+	}
+	record.SetupSeconds = setupDuration.Round(time.Millisecond).Seconds()
+	record.Write(os.Stdout)
+	if err := hybrid.Append(cfg.ComparisonLog, record); err != nil {
+		color.Yellow("⚠ Warning: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/natcapacity"
+	"gcp-psc-demo/pkg/state"
+	"gcp-psc-demo/pkg/vm"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on the flag set of config.LoadWithOptions
+var (
+	steps          string
+	connectTimeout time.Duration
+	hold           time.Duration
+	sizeFor        int
+	endpoints      int
+)
+
+func bindNATCapacityFlags(fs *flag.FlagSet) {
+	fs.StringVar(&steps, "steps", "100,500,1000,2000,4000", "Comma-separated concurrent connection counts to ramp through")
+	fs.DurationVar(&connectTimeout, "connect-timeout", 10*time.Second, "How long each step waits for its connections")
+	fs.DurationVar(&hold, "hold", 15*time.Second, "How long each step holds its connections open")
+	fs.IntVar(&sizeFor, "size-for", 0, "Only print the NAT subnet size needed for this many connections per endpoint")
+	fs.IntVar(&endpoints, "endpoints", 1, "Number of consumer endpoints for --size-for")
+}
+
+func main() {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.LoadWithOptions("nat-capacity", os.Args[1:], config.Options{Bind: bindNATCapacityFlags})
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+
+	// Sizing needs no project
+	if err == nil && sizeFor > 0 {
+		sizing, err := natcapacity.Required(sizeFor, endpoints)
+		if err != nil {
+			color.Red("Sizing failed: %v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("NAT subnet: %s\n", sizing)
+		fmt.Printf("Capacity: %d concurrent connections per endpoint\n", sizing.Capacity())
+		return
+	}
+
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
+		fmt.Println("export PROJECT_ID=your-project-id")
+		os.Exit(1)
+	}
+
+	// Pick up the subnets discovered in existing VPCs
+	if st, err := state.Load(cfg.StateFile); err != nil {
+		color.Yellow("⚠ Warning: %v", err)
+	} else if st != nil {
+		st.ApplyExistingVPCs(cfg)
+	}
+
+	parsed, err := natcapacity.ParseSteps(steps)
+	var tester *natcapacity.Tester
+	if err == nil {
+		tester, err = natcapacity.NewTester(cfg, natcapacity.Options{
+			Steps:          parsed,
+			ConnectTimeout: connectTimeout,
+			Hold:           hold,
+		})
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		os.Exit(1)
+	}
+
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - NAT Capacity")
+	color.Blue("==================================================")
+
+	fmt.Printf("Project ID: %s\n", cfg.ProjectID)
+	fmt.Printf("Zone: %s\n", cfg.Zone)
+	fmt.Printf("Run ID: %s\n", cfg.RunID)
+	fmt.Printf("\n")
+
+	// SSH access to the VMs, revoked once the test is done
+	ctx := context.Background()
+	sshSession, err := vm.SetupSSH(ctx, cfg)
+	if err != nil {
+		color.Red("SSH setup failed: %v", err)
+		os.Exit(1)
+	}
+	report, err := tester.Run()
+	sshSession.Close(ctx)
+	if err != nil {
+		color.Red("NAT capacity test failed: %v", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n")
+	report.Write(os.Stdout)
+
+	if _, exhausted := report.Exhausted(); exhausted {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/propagation"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on the flag set of config.LoadWithOptions
+var allRegions bool
+
+func bindPropagationFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&allRegions, "all-regions", false, "Summarize the runs of every region instead of only --region")
+}
+
+func main() {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.LoadWithOptions("propagation", os.Args[1:], config.Options{Bind: bindPropagationFlags})
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil && cfg.PropagationLog == "" {
+		err = fmt.Errorf("no propagation log (PROPAGATION_LOG or --propagation-log)")
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		os.Exit(1)
+	}
+
+	// Reading the log needs no project
+	measurements, err := propagation.Load(cfg.PropagationLog)
+	if err != nil {
+		color.Red("%v", err)
+		os.Exit(1)
+	}
+	scope := "all regions"
+	if !allRegions {
+		scope = cfg.Region
+		var inRegion []propagation.Measurement
+		for _, m := range measurements {
+			if m.Region == cfg.Region {
+				inRegion = append(inRegion, m)
+			}
+		}
+		measurements = inRegion
+	}
+	if len(measurements) == 0 {
+		fmt.Printf("No measurements of %s in %s yet, every `make demo` adds one\n", scope, cfg.PropagationLog)
+		return
+	}
+
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - Propagation Delays")
+	color.Blue("==================================================")
+
+	fmt.Printf("%d runs in %s from %s\n\n", len(measurements), scope, cfg.PropagationLog)
+	propagation.WriteSummary(os.Stdout, propagation.Summarize(measurements))
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcpops"
+	"gcp-psc-demo/pkg/scenario"
+	"gcp-psc-demo/pkg/vm"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on every flag set config.LoadWithOptions creates
+var (
+	scenarioFile string
+	only         string
+	resultsFile  string
+	assumeYes    bool
+)
+
+func bindScenarioFlags(fs *flag.FlagSet) {
+	fs.StringVar(&scenarioFile, "scenarios", os.Getenv("SCENARIO_FILE"), "Path to the YAML scenario file")
+	fs.StringVar(&only, "only", "", "Comma-separated scenario names to run (default all)")
+	fs.StringVar(&resultsFile, "results", "", "Path of the JSON results file (default psc-scenarios-<time>.json)")
+	fs.BoolVar(&assumeYes, "yes", false, "Do not ask for confirmation")
+}
+
+func main() {
+	args := os.Args[1:]
+	opts := config.Options{Bind: bindScenarioFlags}
+
+	// The base configuration, before the overrides of each scenario
+	base, err := config.LoadWithOptions("scenario", args, opts)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil && scenarioFile == "" {
+		err = fmt.Errorf("a scenario file is required (--scenarios or SCENARIO_FILE)")
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		os.Exit(1)
+	}
+
+	file, err := scenario.Load(scenarioFile)
+	var scenarios []scenario.Scenario
+	if err == nil {
+		scenarios, err = file.Select(splitNames(only))
+	}
+	if err != nil {
+		color.Red("Scenario error: %v", err)
+		os.Exit(1)
+	}
+
+	// Resolve and validate every scenario before creating anything
+	runs := make([]*scenario.Run, len(scenarios))
+	runIDs := map[string]string{}
+	for i, s := range scenarios {
+		runs[i], err = newRun(args, opts, s)
+		if err != nil {
+			color.Red("Scenario %s: %v", s.Name, err)
+			os.Exit(1)
+		}
+		// Scenarios sharing a run ID work on the same resources, which is only
+		// intended when a scenario builds on an earlier one
+		runID := runs[i].Config.RunID
+		if other, ok := runIDs[runID]; ok {
+			color.Yellow("⚠ Scenario %s uses the resources of scenario %s (run ID %s)", s.Name, other, runID)
+		} else {
+			runIDs[runID] = s.Name
+		}
+	}
+
+	if resultsFile == "" {
+		resultsFile = fmt.Sprintf("psc-scenarios-%s.json", time.Now().Format("20060102-150405"))
+	}
+
+	printPlan(base, scenarios, runs)
+	if !assumeYes && !askForConfirmation() {
+		fmt.Println("Scenarios cancelled.")
+		os.Exit(0)
+	}
+
+	// Operation clients are shared by all managers for the whole matrix
+	defer gcpops.Shared().Close()
+
+	ctx := context.Background()
+	runner := scenario.NewRunner()
+	var results []scenario.Result
+	for i, s := range scenarios {
+		color.Blue("==================================================")
+		color.Blue("  Scenario %d/%d: %s", i+1, len(scenarios), s.Name)
+		color.Blue("==================================================")
+
+		// Record the run before creating anything so cleanup can find partial runs
+		if err := scenario.SaveState(runs[i]); err != nil {
+			color.Red("✗ Failed to save run state: %v", err)
+			os.Exit(1)
+		}
+
+		// SSH access to the VMs of the run, revoked once the scenario is done
+		sshSession, err := vm.SetupSSH(ctx, runs[i].Config)
+		if err != nil {
+			color.Red("✗ SSH setup failed: %v", err)
+			os.Exit(1)
+		}
+		for _, extra := range runs[i].ExtraConsumers {
+			extra.UseSSH(runs[i].Config)
+		}
+
+		results = append(results, runner.Execute(ctx, s, runs[i]))
+		sshSession.Close(ctx)
+
+		// Write after every scenario so an interrupted matrix keeps its results
+		if err := scenario.WriteResults(resultsFile, results); err != nil {
+			color.Yellow("⚠ Warning: %v", err)
+		}
+	}
+
+	color.Blue("=== Scenario results ===")
+	scenario.PrintSummary(os.Stdout, results)
+	fmt.Printf("\nResults written to %s\n", resultsFile)
+
+	for _, r := range results {
+		if r.Outcome != scenario.Passed {
+			os.Exit(1)
+		}
+	}
+}
+
+// newRun loads the configuration of a scenario: the base configuration with
+// the scenario's name prefix and config keys applied, flags still winning
+func newRun(args []string, opts config.Options, s scenario.Scenario) (*scenario.Run, error) {
+	overrides, err := s.Overrides()
+	if err != nil {
+		return nil, err
+	}
+	opts.Overrides = overrides
+
+	cfg, err := config.LoadWithOptions("scenario", args, opts)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The provider VM runs the API server emulator
+	if s.Includes(scenario.StepVMs) {
+		if _, err := os.Stat(cfg.APIServerBinary); err != nil && cfg.APIServerImage == "" {
+			return nil, fmt.Errorf("API server binary %s not found, build it with `make build`: %v", cfg.APIServerBinary, err)
+		}
+		if err := vm.CheckMachineType(context.Background(), cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return scenario.NewRun(cfg, s.Consumers)
+}
+
+func printPlan(base *config.Config, scenarios []scenario.Scenario, runs []*scenario.Run) {
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - Scenarios")
+	color.Blue("==================================================")
+
+	fmt.Printf("Project ID: %s\n", base.ProjectID)
+	fmt.Printf("Region: %s\n", base.Region)
+	fmt.Printf("Zone: %s\n", base.Zone)
+	fmt.Printf("Scenario File: %s\n", scenarioFile)
+	fmt.Printf("Results File: %s\n", resultsFile)
+	fmt.Printf("\n")
+
+	for i, s := range scenarios {
+		plan, _ := s.Plan()
+		cfg := runs[i].Config
+		fmt.Printf("  %-20s run %-20s consumers %d  steps %s\n", s.Name, cfg.RunID, s.Consumers, strings.Join(plan, ","))
+		if s.Description != "" {
+			fmt.Printf("  %-20s %s\n", "", s.Description)
+		}
+	}
+	fmt.Printf("\n")
+}
+
+func askForConfirmation() bool {
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Do you want to run these scenarios? (y/N): ")
+
+	response, err := reader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	response = strings.TrimSpace(strings.ToLower(response))
+	return response == "y" || response == "yes"
+}
+
+func splitNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
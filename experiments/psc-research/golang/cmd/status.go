@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/state"
+	"gcp-psc-demo/pkg/status"
+	"github.com/fatih/color"
+)
+
+// Command flags, bound on every flag set config.LoadWithOptions creates
+var (
+	watch         time.Duration
+	watchInterval time.Duration
+)
+
+func bindStatusFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&watch, "watch", 0, "Then watch the PSC connection state for this long, recording its transitions (e.g. 10m)")
+	fs.DurationVar(&watchInterval, "watch-interval", 5*time.Second, "Delay between polls of the PSC connection state")
+}
+
+func main() {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.LoadWithOptions("status", os.Args[1:], config.Options{Bind: bindStatusFlags})
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err == nil && watch > 0 && watchInterval <= 0 {
+		err = fmt.Errorf("--watch-interval must be positive")
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
+		fmt.Println("export PROJECT_ID=your-project-id")
+		os.Exit(1)
+	}
+
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - Status")
+	color.Blue("==================================================")
+
+	fmt.Printf("Project ID: %s\n", cfg.ProjectID)
+	fmt.Printf("Region: %s\n", cfg.Region)
+	fmt.Printf("Zone: %s\n", cfg.Zone)
+	fmt.Printf("Run ID: %s\n", cfg.RunID)
+
+	st, err := state.Load(cfg.StateFile)
+	switch {
+	case err != nil:
+		color.Yellow("⚠ Warning: %v", err)
+	case st == nil:
+		fmt.Printf("State File: %s (not found, no run recorded)\n", cfg.StateFile)
+	default:
+		fmt.Printf("State File: %s (run started %s)\n", cfg.StateFile, st.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		st.ApplyExistingVPCs(cfg)
+	}
+	fmt.Printf("\n")
+
+	reporter, err := status.NewReporter(cfg)
+	if err != nil {
+		color.Red("Failed to create status reporter: %v", err)
+		os.Exit(1)
+	}
+	defer reporter.Close()
+
+	rows := reporter.Collect(context.Background())
+	status.Print(os.Stdout, rows)
+
+	counts := status.Summarize(rows)
+	fmt.Printf("\n%d of %d resources exist, %d not found, %d errors\n",
+		counts[status.Exists], len(rows), counts[status.NotFound], counts[status.Error])
+
+	if st != nil && len(st.Connections) > 0 {
+		color.Blue("\n=== PSC connection history ===")
+		for _, t := range st.Connections {
+			status.PrintTransition(os.Stdout, t)
+		}
+	}
+
+	if watch > 0 {
+		if err := watchConnections(reporter, cfg.StateFile); err != nil {
+			color.Red("Watching PSC connections failed: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if counts[status.Error] > 0 {
+		os.Exit(1)
+	}
+}
+
+// watchConnections prints the PSC connection state transitions until --watch
+// elapses or the command is interrupted, and records them in the state of
+// the run, if one is recorded
+func watchConnections(reporter *status.Reporter, stateFile string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, watch)
+	defer cancel()
+
+	color.Blue("\n=== Watching PSC connections for %s (Ctrl-C to stop) ===", watch)
+	return reporter.WatchConnections(ctx, watchInterval, func(t state.Transition) {
+		status.PrintTransition(os.Stdout, t)
+
+		// Reload the state, which a running demo may have updated meanwhile
+		st, err := state.Load(stateFile)
+		if err == nil && st != nil {
+			st.Connections = append(st.Connections, t)
+			err = state.Save(stateFile, st)
+		}
+		if err != nil {
+			color.Yellow("⚠ Warning: %v", err)
+		}
+	})
+}
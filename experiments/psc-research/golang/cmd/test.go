@@ -2,20 +2,29 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 
 	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/results"
 	"gcp-psc-demo/pkg/testing"
+	"gcp-psc-demo/pkg/vm"
 	"github.com/fatih/color"
 )
 
 func main() {
-	// Create configuration
-	cfg := config.NewConfig()
-	if err := cfg.Validate(); err != nil {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.Load("test", os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
 		color.Red("Configuration error: %v", err)
-		fmt.Println("Please set the PROJECT_ID environment variable:")
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
 		fmt.Println("export PROJECT_ID=your-project-id")
 		os.Exit(1)
 	}
@@ -27,20 +36,34 @@ func main() {
 	fmt.Printf("Project ID: %s\n", cfg.ProjectID)
 	fmt.Printf("Region: %s\n", cfg.Region)
 	fmt.Printf("Zone: %s\n", cfg.Zone)
+	fmt.Printf("Run ID: %s\n", cfg.RunID)
 	fmt.Printf("\n")
 
 	ctx := context.Background()
 
+	// SSH access to the VMs, revoked when the tests are done
+	sshSession, err := vm.SetupSSH(ctx, cfg)
+	if err != nil {
+		color.Red("SSH setup failed: %v", err)
+		os.Exit(1)
+	}
+
 	// Create test manager
 	testManager, err := testing.NewTestManager(cfg)
 	if err != nil {
+		sshSession.Close(ctx)
 		color.Red("Failed to create test manager: %v", err)
 		os.Exit(1)
 	}
 	defer testManager.Close()
 
 	// Run connectivity tests
-	if err := testManager.TestConnectivity(ctx); err != nil {
+	err = testManager.TestConnectivity(ctx)
+	sshSession.Close(ctx)
+	if exportErr := results.Export(ctx, cfg, "test", testManager.Results()); exportErr != nil {
+		color.Yellow("⚠ Warning: %v", exportErr)
+	}
+	if err != nil {
 		color.Red("Connectivity test failed: %v", err)
 		os.Exit(1)
 	}
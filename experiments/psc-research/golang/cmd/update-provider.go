@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/state"
+	"gcp-psc-demo/pkg/vm"
+	"github.com/fatih/color"
+)
+
+func main() {
+	// Create configuration from defaults, environment, --config file and flags
+	cfg, err := config.Load("update-provider", os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+
+	// Pick up the secondary region the demo was deployed to
+	if err == nil {
+		if st, stErr := state.Load(cfg.StateFile); stErr != nil {
+			color.Yellow("⚠ Warning: %v", stErr)
+		} else if st != nil {
+			st.ApplySecondaryRegion(cfg)
+		}
+		err = cfg.Validate()
+	}
+	if err != nil {
+		color.Red("Configuration error: %v", err)
+		fmt.Println("Set PROJECT_ID (or pass --project / --config) and check the other settings:")
+		fmt.Println("export PROJECT_ID=your-project-id")
+		os.Exit(1)
+	}
+
+	color.Blue("==================================================")
+	color.Blue("  GCP Private Service Connect Demo - Update Provider")
+	color.Blue("==================================================")
+
+	fmt.Printf("Project ID: %s\n", cfg.ProjectID)
+	fmt.Printf("Run ID: %s\n", cfg.RunID)
+	fmt.Printf("Web Image: %s\n", cfg.WebImage)
+	if cfg.APIServerImage != "" {
+		fmt.Printf("API Server Image: %s\n", cfg.APIServerImage)
+	} else {
+		fmt.Printf("API Server Binary: %s\n", cfg.APIServerBinary)
+	}
+	fmt.Printf("\n")
+
+	// The provider VM of every region of the run
+	providers := []*config.Config{cfg}
+	if cfg.SecondaryRegion != "" {
+		secondary, err := cfg.Secondary()
+		if err != nil {
+			color.Red("Configuration error: %v", err)
+			os.Exit(1)
+		}
+		providers = append(providers, secondary)
+	}
+
+	ctx := context.Background()
+	sshSession, err := vm.SetupSSH(ctx, cfg)
+	if err != nil {
+		color.Red("SSH setup failed: %v", err)
+		os.Exit(1)
+	}
+	err = updateProviders(ctx, providers)
+	sshSession.Close(ctx)
+	if err != nil {
+		color.Red("✗ Update failed: %v", err)
+		os.Exit(1)
+	}
+
+	color.Green("✓ Provider services updated, check them with `make test`")
+}
+
+// updateProviders uploads the API server binary once, then updates the
+// containers of the provider VM of each region
+func updateProviders(ctx context.Context, providers []*config.Config) error {
+	for i, cfg := range providers {
+		if i > 0 {
+			// SetupSSH recorded the key of the session in the primary configuration
+			cfg.UseSSH(providers[0])
+		}
+		vmManager, err := vm.NewVMManager(cfg)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			err = vmManager.UploadAPIServer()
+		}
+		if err == nil {
+			err = vmManager.UpdateProviderContainers(ctx)
+		}
+		vmManager.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", cfg.ProviderVM, err)
+		}
+	}
+	return nil
+}
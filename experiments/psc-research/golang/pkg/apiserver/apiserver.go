@@ -0,0 +1,212 @@
+// Package apiserver emulates the parts of a kube-apiserver endpoint that a
+// hosted control plane exposes through PSC: TLS on 6443, the health probes and
+// /version, plus a gRPC echo service on the same port. It is enough to prove that API-server traffic flows end to end
+// without running a real control plane on the provider VM.
+package apiserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the port kube-apiserver listens on in hosted control planes
+const DefaultPort = 6443
+
+// VersionInfo is the /version response, with the same fields as
+// k8s.io/apimachinery/pkg/version.Info so kubectl and curl clients parse it
+type VersionInfo struct {
+	Major        string `json:"major"`
+	Minor        string `json:"minor"`
+	GitVersion   string `json:"gitVersion"`
+	GitCommit    string `json:"gitCommit"`
+	GitTreeState string `json:"gitTreeState"`
+	BuildDate    string `json:"buildDate"`
+	GoVersion    string `json:"goVersion"`
+	Compiler     string `json:"compiler"`
+	Platform     string `json:"platform"`
+}
+
+// NewVersionInfo builds the /version response for a "vMAJOR.MINOR.PATCH" git version
+func NewVersionInfo(gitVersion string) VersionInfo {
+	major, minor := "", ""
+	parts := strings.SplitN(strings.TrimPrefix(gitVersion, "v"), ".", 3)
+	if len(parts) >= 2 {
+		major, minor = parts[0], parts[1]
+	}
+
+	return VersionInfo{
+		Major:        major,
+		Minor:        minor,
+		GitVersion:   gitVersion,
+		GitCommit:    "psc-demo",
+		GitTreeState: "clean",
+		BuildDate:    "1970-01-01T00:00:00Z",
+		GoVersion:    runtime.Version(),
+		Compiler:     runtime.Compiler,
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// status mirrors metav1.Status for error responses
+type status struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	Reason     string `json:"reason"`
+	Code       int    `json:"code"`
+}
+
+// NewHandler returns the emulated API-server routes wrapped in request logging.
+// gRPC calls are routed to EchoService, the rest to the API-server routes.
+func NewHandler(info VersionInfo, logger *log.Logger) http.Handler {
+	mux := http.NewServeMux()
+	grpcServer := newGRPCServer()
+
+	probe := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fmt.Fprint(w, "ok")
+	}
+	probes := []string{"/healthz", "/livez", "/readyz"}
+	for _, path := range probes {
+		mux.HandleFunc(path, probe)
+	}
+
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	paths := append([]string{"/version"}, probes...)
+	sort.Strings(paths)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			writeJSON(w, http.StatusNotFound, status{
+				Kind:       "Status",
+				APIVersion: "v1",
+				Status:     "Failure",
+				Message:    fmt.Sprintf("the server could not find the requested resource (%s %s)", r.Method, r.URL.Path),
+				Reason:     "NotFound",
+				Code:       http.StatusNotFound,
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string][]string{"paths": paths})
+	})
+
+	return logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isGRPC(r) {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}), logger)
+}
+
+// statusRecorder captures the response code for the request log
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets gRPC stream responses through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logRequests logs one line per request with the client address and TLS
+// server name, which shows whether traffic arrived through the PSC NAT subnet
+func logRequests(next http.Handler, logger *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		serverName := "-"
+		if r.TLS != nil && r.TLS.ServerName != "" {
+			serverName = r.TLS.ServerName
+		}
+		logger.Printf("%s %s %s %d %v remote=%s sni=%s ua=%q",
+			r.Proto, r.Method, r.URL.Path, rec.code, time.Since(start).Round(time.Microsecond),
+			r.RemoteAddr, serverName, r.UserAgent())
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// SelfSignedCertificate generates a throwaway serving certificate for hosts,
+// which may be DNS names or IP addresses. The hostname and every local
+// interface address are always included so clients can use any VM IP.
+func SelfSignedCertificate(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "kube-apiserver", Organization: []string{"psc-demo"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost", "kubernetes", "kubernetes.default", "kubernetes.default.svc"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
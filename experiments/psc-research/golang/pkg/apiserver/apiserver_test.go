@@ -0,0 +1,111 @@
+package apiserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T, logs *bytes.Buffer) *httptest.Server {
+	t.Helper()
+
+	cert, err := SelfSignedCertificate([]string{"api.psc-demo.internal", "10.2.0.100"})
+	if err != nil {
+		t.Fatalf("SelfSignedCertificate() error = %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(NewHandler(NewVersionInfo("v1.30.2"), log.New(logs, "", 0)))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHandler_Probes(t *testing.T) {
+	var logs bytes.Buffer
+	srv := newTestServer(t, &logs)
+	client := insecureClient()
+
+	for _, path := range []string{"/healthz", "/livez", "/readyz"} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+			t.Errorf("GET %s = %d %q, want 200 \"ok\"", path, resp.StatusCode, body)
+		}
+	}
+
+	if !strings.Contains(logs.String(), "GET /healthz 200") {
+		t.Errorf("request log = %q, want a line for GET /healthz", logs.String())
+	}
+}
+
+func TestHandler_Version(t *testing.T) {
+	srv := newTestServer(t, &bytes.Buffer{})
+
+	resp, err := insecureClient().Get(srv.URL + "/version")
+	if err != nil {
+		t.Fatalf("GET /version error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	var info VersionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("decoding /version: %v", err)
+	}
+	if info.Major != "1" || info.Minor != "30" || info.GitVersion != "v1.30.2" {
+		t.Errorf("/version = %+v, want major 1, minor 30, gitVersion v1.30.2", info)
+	}
+}
+
+func TestHandler_NotFound(t *testing.T) {
+	srv := newTestServer(t, &bytes.Buffer{})
+
+	resp, err := insecureClient().Get(srv.URL + "/api/v1/namespaces")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	var st status
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatalf("decoding status: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound || st.Kind != "Status" || st.Reason != "NotFound" {
+		t.Errorf("GET unknown path = %d %+v, want a 404 NotFound Status", resp.StatusCode, st)
+	}
+}
+
+func TestSelfSignedCertificate_SANs(t *testing.T) {
+	cert, err := SelfSignedCertificate([]string{"api.psc-demo.internal", "10.2.0.100"})
+	if err != nil {
+		t.Fatalf("SelfSignedCertificate() error = %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+
+	for _, host := range []string{"api.psc-demo.internal", "10.2.0.100", "127.0.0.1", "kubernetes.default.svc"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Errorf("certificate does not cover %s: %v", host, err)
+		}
+	}
+}
+
+func insecureClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+}
@@ -0,0 +1,207 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// EchoService is the gRPC service served next to the API-server routes on the
+// same TLS port. Konnectivity and aggregated API servers depend on long-lived
+// HTTP/2 streams, which this service exercises end to end through PSC without
+// generated code: the messages are well-known wrapper types.
+const EchoService = "psc.demo.Echo"
+
+// TickInterval spaces the server-streamed messages so that each arrives in
+// its own HTTP/2 DATA frame instead of being coalesced into one response
+const TickInterval = 100 * time.Millisecond
+
+// isGRPC reports whether r is a gRPC call, which is only valid over HTTP/2
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// echoServer implements EchoService
+type echoServer struct{}
+
+// Echo returns the request unchanged
+func (echoServer) Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	return wrapperspb.String(in.GetValue()), nil
+}
+
+// Ticks streams count messages, TickInterval apart
+func (echoServer) Ticks(in *wrapperspb.UInt32Value, stream grpc.ServerStream) error {
+	ticker := time.NewTicker(TickInterval)
+	defer ticker.Stop()
+	for i := uint32(1); i <= in.GetValue(); i++ {
+		if err := stream.SendMsg(wrapperspb.String(fmt.Sprintf("tick %d", i))); err != nil {
+			return err
+		}
+		if i == in.GetValue() {
+			break
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Chat answers every message of the client stream in upper case as soon as it
+// arrives, so a reply is only received if both directions of the stream are
+// open at once
+func (echoServer) Chat(stream grpc.ServerStream) error {
+	for {
+		in := new(wrapperspb.StringValue)
+		if err := stream.RecvMsg(in); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := stream.SendMsg(wrapperspb.String(strings.ToUpper(in.GetValue()))); err != nil {
+			return err
+		}
+	}
+}
+
+// echoServiceDesc is what protoc-gen-go-grpc would generate for
+//
+//	service Echo {
+//	  rpc Echo(google.protobuf.StringValue) returns (google.protobuf.StringValue);
+//	  rpc Ticks(google.protobuf.UInt32Value) returns (stream google.protobuf.StringValue);
+//	  rpc Chat(stream google.protobuf.StringValue) returns (stream google.protobuf.StringValue);
+//	}
+var echoServiceDesc = grpc.ServiceDesc{
+	ServiceName: EchoService,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := new(wrapperspb.StringValue)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			return srv.(echoServer).Echo(ctx, in)
+		},
+	}},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Ticks",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				in := new(wrapperspb.UInt32Value)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(echoServer).Ticks(in, stream)
+			},
+		},
+		{
+			StreamName:    "Chat",
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				return srv.(echoServer).Chat(stream)
+			},
+		},
+	},
+}
+
+// newGRPCServer returns a gRPC server with EchoService registered
+func newGRPCServer() *grpc.Server {
+	server := grpc.NewServer()
+	server.RegisterService(&echoServiceDesc, echoServer{})
+	return server
+}
+
+// EchoClient calls EchoService
+type EchoClient struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewEchoClient returns a client of the EchoService served on conn
+func NewEchoClient(conn grpc.ClientConnInterface) *EchoClient {
+	return &EchoClient{conn: conn}
+}
+
+// Echo makes a unary call and returns the echoed message
+func (c *EchoClient) Echo(ctx context.Context, message string) (string, error) {
+	out := new(wrapperspb.StringValue)
+	if err := c.conn.Invoke(ctx, "/"+EchoService+"/Echo", wrapperspb.String(message), out); err != nil {
+		return "", err
+	}
+	return out.GetValue(), nil
+}
+
+// Ticks asks the server to stream count messages and returns them together
+// with the time between the first and the last one
+func (c *EchoClient) Ticks(ctx context.Context, count uint32) ([]string, time.Duration, error) {
+	stream, err := c.conn.NewStream(ctx, &echoServiceDesc.Streams[0], "/"+EchoService+"/Ticks")
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := stream.SendMsg(wrapperspb.UInt32(count)); err != nil {
+		return nil, 0, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, 0, err
+	}
+
+	var ticks []string
+	var first time.Time
+	for {
+		out := new(wrapperspb.StringValue)
+		if err := stream.RecvMsg(out); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return ticks, 0, err
+		}
+		if first.IsZero() {
+			first = time.Now()
+		}
+		ticks = append(ticks, out.GetValue())
+	}
+	if first.IsZero() {
+		return ticks, 0, nil
+	}
+	return ticks, time.Since(first), nil
+}
+
+// Chat sends messages one at a time over a bidirectional stream, waiting for
+// each reply before sending the next, and returns the replies
+func (c *EchoClient) Chat(ctx context.Context, messages []string) ([]string, error) {
+	stream, err := c.conn.NewStream(ctx, &echoServiceDesc.Streams[1], "/"+EchoService+"/Chat")
+	if err != nil {
+		return nil, err
+	}
+
+	var replies []string
+	for _, message := range messages {
+		if err := stream.SendMsg(wrapperspb.String(message)); err != nil {
+			return replies, err
+		}
+		out := new(wrapperspb.StringValue)
+		if err := stream.RecvMsg(out); err != nil {
+			return replies, fmt.Errorf("no reply to %q: %w", message, err)
+		}
+		replies = append(replies, out.GetValue())
+	}
+	if err := stream.CloseSend(); err != nil {
+		return replies, err
+	}
+	if err := stream.RecvMsg(new(wrapperspb.StringValue)); !errors.Is(err, io.EOF) {
+		return replies, fmt.Errorf("stream did not end cleanly: %v", err)
+	}
+	return replies, nil
+}
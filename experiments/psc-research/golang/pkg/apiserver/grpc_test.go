@@ -0,0 +1,95 @@
+package apiserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func newEchoClient(t *testing.T, logs *bytes.Buffer) *EchoClient {
+	t.Helper()
+
+	srv := newTestServer(t, logs)
+	conn, err := grpc.NewClient("passthrough:///"+srv.Listener.Addr().String(),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewEchoClient(conn)
+}
+
+func TestEcho_Unary(t *testing.T) {
+	var logs bytes.Buffer
+	client := newEchoClient(t, &logs)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	got, err := client.Echo(ctx, "hello through PSC")
+	if err != nil {
+		t.Fatalf("Echo() error = %v", err)
+	}
+	if got != "hello through PSC" {
+		t.Errorf("Echo() = %q, want the request back", got)
+	}
+
+	if !strings.Contains(logs.String(), "HTTP/2.0 POST /psc.demo.Echo/Echo 200") {
+		t.Errorf("request log = %q, want an HTTP/2 line for the call", logs.String())
+	}
+}
+
+func TestEcho_Ticks(t *testing.T) {
+	client := newEchoClient(t, &bytes.Buffer{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ticks, spread, err := client.Ticks(ctx, 3)
+	if err != nil {
+		t.Fatalf("Ticks() error = %v", err)
+	}
+	if want := []string{"tick 1", "tick 2", "tick 3"}; !slices.Equal(ticks, want) {
+		t.Errorf("Ticks() = %v, want %v", ticks, want)
+	}
+	if spread < TickInterval {
+		t.Errorf("Ticks() arrived within %v, want them streamed at least %v apart", spread, TickInterval)
+	}
+}
+
+func TestEcho_Chat(t *testing.T) {
+	client := newEchoClient(t, &bytes.Buffer{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	replies, err := client.Chat(ctx, []string{"konnectivity", "aggregation"})
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if want := []string{"KONNECTIVITY", "AGGREGATION"}; !slices.Equal(replies, want) {
+		t.Errorf("Chat() = %v, want %v", replies, want)
+	}
+}
+
+func TestHandler_GRPCOverHTTP1(t *testing.T) {
+	srv := newTestServer(t, &bytes.Buffer{})
+
+	// Without HTTP/2 a gRPC request falls through to the API-server routes
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/psc.demo.Echo/Echo", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := insecureClient().Do(req)
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("POST over %s = %d, want a 404 over HTTP/1.1", resp.Proto, resp.StatusCode)
+	}
+}
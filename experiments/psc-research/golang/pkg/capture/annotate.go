@@ -0,0 +1,180 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Google ranges that show up in captures on the demo VMs
+var (
+	healthCheckRanges = []string{"130.211.0.0/22", "35.191.0.0/16"}
+	iapRanges         = []string{"35.235.240.0/20"}
+)
+
+// Range is a named address range or host of the demo
+type Range struct {
+	Name string
+	Net  *net.IPNet
+}
+
+// Annotator names the addresses seen in a capture after the demo subnets,
+// VMs and PSC endpoints they belong to
+type Annotator struct {
+	Ranges []Range
+}
+
+// NewAnnotator returns an annotator knowing the Google health check and IAP ranges
+func NewAnnotator() *Annotator {
+	a := &Annotator{}
+	for _, cidr := range healthCheckRanges {
+		a.Add("Google health checks", cidr)
+	}
+	for _, cidr := range iapRanges {
+		a.Add("IAP SSH", cidr)
+	}
+	return a
+}
+
+// Add names an address range, or a single host when value is an IP address.
+// Empty or invalid values are ignored, since lookups of resources that do
+// not exist yield nothing.
+func (a *Annotator) Add(name, value string) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		value += "/32"
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return
+	}
+	a.Ranges = append(a.Ranges, Range{Name: name, Net: ipNet})
+}
+
+// Label returns the name of the most specific range containing ip, or "" if none does
+func (a *Annotator) Label(ip string) string {
+	addr := net.ParseIP(ip)
+	best, bestSize := "", -1
+	for _, r := range a.Ranges {
+		if !r.Net.Contains(addr) {
+			continue
+		}
+		if size, _ := r.Net.Mask.Size(); size > bestSize {
+			best, bestSize = r.Name, size
+		}
+	}
+	return best
+}
+
+// labeled formats an address with its label
+func (a *Annotator) labeled(ip string) string {
+	if label := a.Label(ip); label != "" {
+		return fmt.Sprintf("%s (%s)", ip, label)
+	}
+	return ip
+}
+
+// File is a capture downloaded from one VM
+type File struct {
+	Role    string
+	VM      string
+	Path    string
+	Summary *Summary
+	Err     error
+}
+
+// Report describes a capture session for the annotation file
+type Report struct {
+	RunID     string
+	Started   time.Time
+	Duration  time.Duration
+	Filter    string
+	Annotator *Annotator
+	Files     []File
+
+	// NATRange is the PSC NAT subnet consumer traffic arrives from on the
+	// provider side, Endpoint the PSC endpoint address consumers connect to
+	NATRange string
+	Endpoint string
+}
+
+// Write prints the address ranges of the demo and, for every capture, its
+// flows with both ends named, followed by hints when PSC traffic is missing
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "PSC packet capture of run %s\n", r.RunID)
+	fmt.Fprintf(w, "Started %s for %s, filter %q\n\n", r.Started.UTC().Format(time.RFC3339), r.Duration, r.Filter)
+
+	fmt.Fprintln(w, "Address ranges:")
+	for _, rng := range r.Annotator.Ranges {
+		fmt.Fprintf(w, "  %-20s %s\n", rng.Net, rng.Name)
+	}
+
+	for _, f := range r.Files {
+		fmt.Fprintf(w, "\n%s VM %s: ", f.Role, f.VM)
+		if f.Err != nil {
+			fmt.Fprintf(w, "capture failed: %v\n", f.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%s, %d packets", f.Path, f.Summary.Packets)
+		if f.Summary.Other > 0 {
+			fmt.Fprintf(w, " (%d not IPv4)", f.Summary.Other)
+		}
+		fmt.Fprintln(w)
+
+		flows := append([]Flow(nil), f.Summary.Flows...)
+		sort.SliceStable(flows, func(i, j int) bool { return flows[i].Packets > flows[j].Packets })
+		for _, flow := range flows {
+			fmt.Fprintf(w, "  %-45s -> %-45s %-5s %6d\n",
+				r.Annotator.labeled(flow.Src), r.Annotator.labeled(flow.Dst), flow.Proto, flow.Packets)
+		}
+		for _, hint := range r.hints(f) {
+			fmt.Fprintf(w, "  ! %s\n", hint)
+		}
+	}
+}
+
+// hints explains missing PSC traffic: consumers reach the provider VM from
+// the PSC NAT range, and reach the service through the PSC endpoint
+func (r *Report) hints(f File) []string {
+	var hints []string
+	switch f.Role {
+	case RoleProvider:
+		if r.NATRange == "" {
+			break
+		}
+		_, nat, err := net.ParseCIDR(r.NATRange)
+		if err != nil {
+			break
+		}
+		found := false
+		for _, flow := range f.Summary.Flows {
+			if nat.Contains(net.ParseIP(flow.Src)) {
+				found = true
+			}
+		}
+		if !found {
+			hints = append(hints, fmt.Sprintf("no packets from the PSC NAT range %s: consumer traffic did not reach the VM "+
+				"(check the service attachment, the load balancer and the firewall rule for %s)", r.NATRange, r.NATRange))
+		}
+	case RoleConsumer:
+		if r.Endpoint == "" {
+			break
+		}
+		sent, answered := false, false
+		for _, flow := range f.Summary.Flows {
+			sent = sent || flow.Dst == r.Endpoint
+			answered = answered || flow.Src == r.Endpoint
+		}
+		switch {
+		case !sent:
+			hints = append(hints, fmt.Sprintf("no packets to the PSC endpoint %s", r.Endpoint))
+		case !answered:
+			hints = append(hints, fmt.Sprintf("packets to the PSC endpoint %s were never answered "+
+				"(check the endpoint's connection status and the backend health)", r.Endpoint))
+		}
+	}
+	return hints
+}
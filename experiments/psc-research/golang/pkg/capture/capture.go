@@ -0,0 +1,256 @@
+// Package capture runs tcpdump on the demo VMs, downloads the captures and
+// annotates them with the PSC NAT range, subnets and endpoints of the run, so
+// a dropped PSC flow can be followed from the client to the service without
+// SSHing into each VM.
+package capture
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcloud"
+	"github.com/fatih/color"
+)
+
+// Roles of the VMs a capture runs on
+const (
+	RoleProvider = "provider"
+	RoleConsumer = "consumer"
+)
+
+// startDelay is how long SSH and tcpdump take to start on the VMs before
+// test traffic is sent
+const startDelay = 10 * time.Second
+
+// Target is a VM to capture on
+type Target struct {
+	Role string
+	VM   string
+}
+
+// Options controls a capture session
+type Options struct {
+	// Targets are the VMs to capture on, concurrently
+	Targets []Target
+	// Duration is how long tcpdump runs on each VM
+	Duration time.Duration
+	// Filter is the tcpdump filter expression
+	Filter string
+	// OutputDir receives the pcap files and the annotation file
+	OutputDir string
+	// Requests is the number of /version requests the consumer VM sends to
+	// the PSC endpoint during the capture, 0 to only observe
+	Requests int
+}
+
+// DefaultFilter captures the service port, where health checks and PSC
+// traffic arrive, and ICMP
+func DefaultFilter(cfg *config.Config) string {
+	return fmt.Sprintf("tcp port %d or icmp", cfg.ServicePort)
+}
+
+// ParseTargets turns "provider", "consumer" or VM names into targets
+func ParseTargets(cfg *config.Config, values []string) []Target {
+	var targets []Target
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		switch value {
+		case "":
+			continue
+		case RoleProvider:
+			targets = append(targets, Target{Role: RoleProvider, VM: cfg.ProviderVM})
+		case RoleConsumer:
+			targets = append(targets, Target{Role: RoleConsumer, VM: cfg.ConsumerVM})
+		default:
+			role := "other"
+			if value == cfg.ProviderVM {
+				role = RoleProvider
+			} else if value == cfg.ConsumerVM {
+				role = RoleConsumer
+			}
+			targets = append(targets, Target{Role: role, VM: value})
+		}
+	}
+	return targets
+}
+
+// Capturer captures on the VMs of one run
+type Capturer struct {
+	config *config.Config
+	opts   Options
+}
+
+// NewCapturer validates the options and returns a capturer
+func NewCapturer(cfg *config.Config, opts Options) (*Capturer, error) {
+	if len(opts.Targets) == 0 {
+		return nil, fmt.Errorf("no VMs to capture on")
+	}
+	if opts.Duration < time.Second {
+		return nil, fmt.Errorf("capture duration must be at least 1s")
+	}
+	if opts.Requests > 0 && opts.Duration <= startDelay {
+		return nil, fmt.Errorf("capture duration must be longer than %s to send test requests", startDelay)
+	}
+	if strings.TrimSpace(opts.Filter) == "" {
+		opts.Filter = DefaultFilter(cfg)
+	}
+	if opts.OutputDir == "" {
+		opts.OutputDir = fmt.Sprintf("captures/%s-%s", cfg.RunID, time.Now().Format("20060102-150405"))
+	}
+	return &Capturer{config: cfg, opts: opts}, nil
+}
+
+// OutputDir returns the directory the captures are written to
+func (c *Capturer) OutputDir() string {
+	return c.opts.OutputDir
+}
+
+// Run installs tcpdump where it is missing, captures on every target for the
+// configured duration, optionally sends test requests through PSC meanwhile,
+// downloads the captures and writes annotations.txt next to them
+func (c *Capturer) Run() (*Report, error) {
+	if err := os.MkdirAll(c.opts.OutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	color.Blue("=== Installing tcpdump ===")
+	for _, t := range c.opts.Targets {
+		if _, err := gcloud.SSH(context.Background(), c.config, t.VM, c.installCommand()); err != nil {
+			return nil, fmt.Errorf("failed to install tcpdump on %s: %v", t.VM, err)
+		}
+		fmt.Printf("tcpdump ready on %s\n", t.VM)
+	}
+
+	report := &Report{
+		RunID:     c.config.RunID,
+		Started:   time.Now(),
+		Duration:  c.opts.Duration,
+		Filter:    c.opts.Filter,
+		Annotator: c.annotator(),
+		NATRange:  c.lookupSubnetRange(c.config.PSCNATSubnet, c.config.PSCNATSubnetRange),
+		Endpoint:  c.lookupForwardingRuleIP(c.config.PSCForwardingRule),
+	}
+
+	color.Blue("=== Capturing for %s (filter %q) ===", c.opts.Duration, c.opts.Filter)
+	errs := make([]error, len(c.opts.Targets))
+	var wg sync.WaitGroup
+	for i, t := range c.opts.Targets {
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			_, errs[i] = gcloud.SSH(context.Background(), c.config, t.VM, c.captureCommand(t))
+		}(i, t)
+	}
+
+	if c.opts.Requests > 0 {
+		time.Sleep(startDelay)
+		c.sendRequests(report.Endpoint)
+	}
+	wg.Wait()
+
+	color.Blue("=== Downloading captures ===")
+	for i, t := range c.opts.Targets {
+		f := File{Role: t.Role, VM: t.VM, Path: filepath.Join(c.opts.OutputDir, fmt.Sprintf("%s-%s.pcap", t.Role, t.VM))}
+		f.Err = errs[i]
+		if f.Err == nil {
+			f.Err = c.scp(t.VM, c.remotePath(t), f.Path)
+		}
+		if f.Err == nil {
+			f.Summary, f.Err = ReadSummary(f.Path)
+		}
+		if f.Err != nil {
+			color.Yellow("⚠ Capture on %s failed: %v", t.VM, f.Err)
+		} else {
+			fmt.Printf("%s: %d packets\n", f.Path, f.Summary.Packets)
+		}
+		report.Files = append(report.Files, f)
+	}
+
+	path := filepath.Join(c.opts.OutputDir, "annotations.txt")
+	out, err := os.Create(path)
+	if err != nil {
+		return report, fmt.Errorf("failed to write annotations: %v", err)
+	}
+	defer out.Close()
+	report.Write(out)
+	return report, nil
+}
+
+// installCommand installs tcpdump on the Ubuntu consumer VM. Container-
+// Optimized OS has no package manager: the provider VM pulls the tools image.
+func (c *Capturer) installCommand() string {
+	return "command -v tcpdump >/dev/null || " +
+		"if command -v apt-get >/dev/null; then " +
+		"(sudo apt-get update -qq && sudo DEBIAN_FRONTEND=noninteractive apt-get install -y -qq tcpdump >/dev/null); " +
+		"else sudo docker pull -q " + gcloud.ShellQuote(c.config.ToolsImage) + " >/dev/null; fi"
+}
+
+// tcpdumpCommand selects the installed tcpdump, or that of the tools image
+// on the host network, writing to the /tmp of the VM
+func (c *Capturer) tcpdumpCommand() string {
+	return "if command -v tcpdump >/dev/null; then TCPDUMP=tcpdump; " +
+		"else TCPDUMP='docker run --rm --net=host -v /tmp:/tmp " + c.config.ToolsImage + " tcpdump'; fi"
+}
+
+func (c *Capturer) remotePath(t Target) string {
+	return fmt.Sprintf("/tmp/psc-capture-%s.pcap", t.VM)
+}
+
+// captureCommand runs tcpdump for the duration; SIGINT makes it flush and exit 0
+func (c *Capturer) captureCommand(t Target) string {
+	path := c.remotePath(t)
+	return fmt.Sprintf("%[4]s; sudo rm -f %[1]s && sudo timeout --preserve-status -s INT %[2]d $TCPDUMP -n -U -s 0 -w %[1]s %[3]s && sudo chmod 644 %[1]s",
+		path, int(c.opts.Duration.Seconds()), gcloud.ShellQuote(c.opts.Filter), c.tcpdumpCommand())
+}
+
+// sendRequests sends test requests to the PSC endpoint from the consumer VM
+func (c *Capturer) sendRequests(endpoint string) {
+	if endpoint == "" {
+		color.Yellow("⚠ PSC endpoint %s not found, not sending test requests", c.config.PSCForwardingRule)
+		return
+	}
+	fmt.Printf("Sending %d requests to https://%s:%d/version from %s\n", c.opts.Requests, endpoint, c.config.ServicePort, c.config.ConsumerVM)
+	command := fmt.Sprintf("for i in $(seq %d); do curl -sk --connect-timeout 5 -o /dev/null -w '%%{http_code}\\n' https://%s:%d/version; sleep 1; done",
+		c.opts.Requests, endpoint, c.config.ServicePort)
+	if _, err := gcloud.SSH(context.Background(), c.config, c.config.ConsumerVM, command); err != nil {
+		color.Yellow("⚠ Test requests failed: %v", err)
+	}
+}
+
+// annotator names the subnets, VMs and load balancer addresses of the run,
+// looked up from the project with the configured values as fallback
+func (c *Capturer) annotator() *Annotator {
+	cfg := c.config
+	a := NewAnnotator()
+	a.Add("PSC NAT subnet "+cfg.PSCNATSubnet, c.lookupSubnetRange(cfg.PSCNATSubnet, cfg.PSCNATSubnetRange))
+	a.Add("provider subnet "+cfg.ProviderSubnet, c.lookupSubnetRange(cfg.ProviderSubnet, cfg.ProviderSubnetRange))
+	a.Add("consumer subnet "+cfg.ConsumerSubnet, c.lookupSubnetRange(cfg.ConsumerSubnet, cfg.ConsumerSubnetRange))
+	a.Add("provider VM "+cfg.ProviderVM, gcloud.Lookup(context.Background(), cfg.ProjectID, "instances", "describe", cfg.ProviderVM, "--zone", cfg.Zone, "--format", "value(networkInterfaces[0].networkIP)"))
+	a.Add("consumer VM "+cfg.ConsumerVM, gcloud.Lookup(context.Background(), cfg.ProjectID, "instances", "describe", cfg.ConsumerVM, "--zone", cfg.Zone, "--format", "value(networkInterfaces[0].networkIP)"))
+	a.Add("load balancer "+cfg.ForwardingRule, c.lookupForwardingRuleIP(cfg.ForwardingRule))
+	a.Add("PSC endpoint "+cfg.PSCEndpoint, c.lookupForwardingRuleIP(cfg.PSCForwardingRule))
+	return a
+}
+
+func (c *Capturer) lookupSubnetRange(name, fallback string) string {
+	if r := gcloud.Lookup(context.Background(), c.config.ProjectID, "networks", "subnets", "describe", name, "--region", c.config.Region, "--format", "value(ipCidrRange)"); r != "" {
+		return r
+	}
+	return fallback
+}
+
+func (c *Capturer) lookupForwardingRuleIP(name string) string {
+	return gcloud.Lookup(context.Background(), c.config.ProjectID, "forwarding-rules", "describe", name, "--region", c.config.Region, "--format", "value(IPAddress)")
+}
+
+func (c *Capturer) scp(vmName, remote, local string) error {
+	return gcloud.Run(context.Background(), "compute", "scp", vmName+":"+remote, local,
+		"--zone", c.config.Zone,
+		"--project", c.config.ProjectID)
+}
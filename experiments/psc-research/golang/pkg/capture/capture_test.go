@@ -0,0 +1,165 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+)
+
+// pcapFile builds a little-endian pcap file with the given link type and packets
+func pcapFile(linkType uint32, packets ...[]byte) []byte {
+	var b bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], linkType)
+	b.Write(header)
+	for _, p := range packets {
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[8:], uint32(len(p)))
+		binary.LittleEndian.PutUint32(record[12:], uint32(len(p)))
+		b.Write(record)
+		b.Write(p)
+	}
+	return b.Bytes()
+}
+
+// ipv4 returns a minimal IPv4 header
+func ipv4(src, dst string, proto byte) []byte {
+	h := make([]byte, 20)
+	h[0] = 0x45
+	h[9] = proto
+	copy(h[12:], net.ParseIP(src).To4())
+	copy(h[16:], net.ParseIP(dst).To4())
+	return h
+}
+
+func ethernet(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14)
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	return append(frame, payload...)
+}
+
+func TestSummarize_Ethernet(t *testing.T) {
+	data := pcapFile(linkTypeEthernet,
+		ethernet(0x0800, ipv4("10.1.1.5", "10.1.0.2", 6)),
+		ethernet(0x0800, ipv4("10.1.0.2", "10.1.1.5", 6)),
+		ethernet(0x0800, ipv4("10.1.1.5", "10.1.0.2", 6)),
+		ethernet(0x0806, make([]byte, 28)),
+	)
+
+	summary, err := summarize(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Summary{
+		Packets: 4,
+		Other:   1,
+		Flows: []Flow{
+			{Src: "10.1.1.5", Dst: "10.1.0.2", Proto: "tcp", Packets: 2},
+			{Src: "10.1.0.2", Dst: "10.1.1.5", Proto: "tcp", Packets: 1},
+		},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+}
+
+func TestSummarize_LinuxSLL(t *testing.T) {
+	sll := make([]byte, 16)
+	binary.BigEndian.PutUint16(sll[14:], 0x0800)
+
+	summary, err := summarize(bytes.NewReader(pcapFile(linkTypeLinuxSLL, append(sll, ipv4("10.2.0.2", "10.2.0.3", 1)...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Flows) != 1 || summary.Flows[0] != (Flow{Src: "10.2.0.2", Dst: "10.2.0.3", Proto: "icmp", Packets: 1}) {
+		t.Errorf("flows = %+v, want one icmp flow", summary.Flows)
+	}
+}
+
+func TestSummarize_Invalid(t *testing.T) {
+	if _, err := summarize(bytes.NewReader([]byte("not a capture file at all"))); err == nil {
+		t.Error("summarize() of a non-pcap file succeeded")
+	}
+	truncated := pcapFile(linkTypeEthernet, ethernet(0x0800, ipv4("10.1.1.5", "10.1.0.2", 6)))
+	if _, err := summarize(bytes.NewReader(truncated[:len(truncated)-5])); err == nil {
+		t.Error("summarize() of a truncated file succeeded")
+	}
+}
+
+func TestAnnotator_MostSpecificLabel(t *testing.T) {
+	a := NewAnnotator()
+	a.Add("provider subnet", "10.1.0.0/24")
+	a.Add("provider VM", "10.1.0.2")
+	a.Add("missing", "")
+
+	for ip, want := range map[string]string{
+		"10.1.0.2":    "provider VM",
+		"10.1.0.9":    "provider subnet",
+		"35.191.10.1": "Google health checks",
+		"8.8.8.8":     "",
+	} {
+		if got := a.Label(ip); got != want {
+			t.Errorf("Label(%s) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestReport_Hints(t *testing.T) {
+	a := NewAnnotator()
+	a.Add("PSC NAT subnet", "10.1.1.0/24")
+	report := &Report{
+		RunID:     "test",
+		Started:   time.Unix(0, 0),
+		Duration:  30 * time.Second,
+		Filter:    "tcp port 6443",
+		Annotator: a,
+		NATRange:  "10.1.1.0/24",
+		Endpoint:  "10.2.0.10",
+		Files: []File{
+			{Role: RoleProvider, VM: "redhat-service-vm", Path: "provider.pcap", Summary: &Summary{Packets: 1, Flows: []Flow{
+				{Src: "35.191.0.1", Dst: "10.1.0.2", Proto: "tcp", Packets: 1},
+			}}},
+			{Role: RoleConsumer, VM: "customer-client-vm", Path: "consumer.pcap", Summary: &Summary{Packets: 3, Flows: []Flow{
+				{Src: "10.2.0.2", Dst: "10.2.0.10", Proto: "tcp", Packets: 3},
+			}}},
+		},
+	}
+
+	var out strings.Builder
+	report.Write(&out)
+
+	for _, want := range []string{
+		"10.1.1.0/24          PSC NAT subnet",
+		"35.191.0.1 (Google health checks)",
+		"! no packets from the PSC NAT range 10.1.1.0/24",
+		"! packets to the PSC endpoint 10.2.0.10 were never answered",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	cfg := config.NewConfig()
+
+	got := ParseTargets(cfg, []string{"provider", "consumer", cfg.ConsumerVM + "-c2"})
+	want := []Target{
+		{Role: RoleProvider, VM: cfg.ProviderVM},
+		{Role: RoleConsumer, VM: cfg.ConsumerVM},
+		{Role: "other", VM: cfg.ConsumerVM + "-c2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseTargets() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// Link types of the captures tcpdump writes on the demo VMs
+const (
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeSLL2     = 276
+)
+
+// Flow counts the IPv4 packets between two addresses of a capture
+type Flow struct {
+	Src     string
+	Dst     string
+	Proto   string
+	Packets int
+}
+
+// Summary is what a capture file contains, per flow
+type Summary struct {
+	Packets int
+	Other   int
+	Flows   []Flow
+}
+
+// ReadSummary reads a pcap file and counts its IPv4 packets per source,
+// destination and protocol
+func ReadSummary(path string) (*Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	summary, err := summarize(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture %s: %v", path, err)
+	}
+	return summary, nil
+}
+
+func summarize(r io.Reader) (*Summary, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("no pcap header: %v", err)
+	}
+
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(header) {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a pcap file (pcapng is not supported)")
+	}
+	linkType := order.Uint32(header[20:]) & 0x0fffffff
+
+	summary := &Summary{}
+	index := map[Flow]int{}
+	record := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, record); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("truncated record header after %d packets", summary.Packets)
+		}
+		data := make([]byte, order.Uint32(record[8:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("truncated packet %d", summary.Packets+1)
+		}
+		summary.Packets++
+
+		src, dst, proto, ok := ipv4Addresses(linkType, data)
+		if !ok {
+			summary.Other++
+			continue
+		}
+		key := Flow{Src: src, Dst: dst, Proto: proto}
+		i, seen := index[key]
+		if !seen {
+			i = len(summary.Flows)
+			index[key] = i
+			summary.Flows = append(summary.Flows, key)
+		}
+		summary.Flows[i].Packets++
+	}
+	return summary, nil
+}
+
+// ipv4Addresses returns the addresses and protocol of an IPv4 packet
+func ipv4Addresses(linkType uint32, data []byte) (src, dst, proto string, ok bool) {
+	var etherType uint16
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return "", "", "", false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[12:]), data[14:]
+		if etherType == 0x8100 && len(data) >= 4 {
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return "", "", "", false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[14:]), data[16:]
+	case linkTypeSLL2:
+		if len(data) < 20 {
+			return "", "", "", false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[0:]), data[20:]
+	case linkTypeRaw, linkTypeIPv4:
+		etherType = 0x0800
+	default:
+		return "", "", "", false
+	}
+
+	if etherType != 0x0800 || len(data) < 20 || data[0]>>4 != 4 {
+		return "", "", "", false
+	}
+	return net.IP(data[12:16]).String(), net.IP(data[16:20]).String(), protocolName(data[9]), true
+}
+
+func protocolName(p byte) string {
+	switch p {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	}
+	return fmt.Sprintf("ip-proto-%d", p)
+}
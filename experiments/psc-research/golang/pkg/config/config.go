@@ -1,53 +1,253 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Labels applied to every labelable demo resource. Besides the run, they
+// describe its topology, so the janitor can tear down a run it finds in the
+// project without its state file, see RunFromLabels.
+const (
+	LabelDemo  = "psc-demo"
+	LabelRunID = "psc-demo-run"
+	// LabelExpires is the Unix time after which the janitor deletes the run
+	LabelExpires    = "psc-demo-expires"
+	LabelNamePrefix = "psc-demo-prefix"
+	LabelBackend    = "psc-demo-backend"
+	LabelZone       = "psc-demo-zone"
+	// LabelSecondary marks the resources of the secondary region
+	LabelSecondary           = "psc-demo-secondary"
+	LabelExistingProviderVPC = "psc-demo-existing-provider-vpc"
+	LabelExistingConsumerVPC = "psc-demo-existing-consumer-vpc"
+)
+
+// SSH access modes of the demo VMs
+const (
+	// SSHModeGcloud relies on the ambient gcloud SSH configuration: its
+	// google_compute_engine key, added to project metadata or OS Login
+	SSHModeGcloud = "gcloud"
+	// SSHModeOSLogin registers an ephemeral key with the OS Login profile of
+	// the active account, which needs roles/compute.osAdminLogin on the VMs
+	SSHModeOSLogin = "oslogin"
+	// SSHModeMetadata injects an ephemeral key into the metadata of the VMs,
+	// which needs roles/compute.instanceAdmin.v1
+	SSHModeMetadata = "metadata"
+)
+
+// Connectivity backends between the consumer and provider VPCs. The demo
+// sets up one of them, so the same tests can compare PSC with the usual
+// alternatives.
+const (
+	// BackendPSC publishes the load balancer through a service attachment
+	// and a PSC endpoint in the consumer VPC
+	BackendPSC = "psc"
+	// BackendPeering peers the two VPCs, the consumer reaches the load
+	// balancer over the exchanged subnet routes
+	BackendPeering = "peering"
+	// BackendVPN connects the two VPCs with HA VPN gateways and Cloud
+	// Routers exchanging their subnet routes over BGP
+	BackendVPN = "vpn"
+)
+
+// namePrefixPattern follows the GCP resource naming rules, leaving room for the base names
+var namePrefixPattern = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,18}[a-z0-9])?$`)
+
+// resourceNamePattern is the GCP resource naming rule (RFC 1035): 1-63
+// lowercase letters, digits or hyphens, starting with a letter and not ending
+// with a hyphen
+var resourceNamePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// regionPattern matches GCP region names such as us-central1 or
+// northamerica-northeast2, zonePattern the zones of a region, such as us-central1-a
+var (
+	regionPattern = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+	zonePattern   = regexp.MustCompile(`^([a-z]+-[a-z]+[0-9]+)-[a-z]$`)
 )
 
-// Config holds the configuration for the GCP PSC demo
+// machineTypePattern matches predefined and custom machine type names such as
+// e2-micro, n2-standard-4 or n2-custom-2-4096. Whether the zone offers the
+// type is checked against the Compute API by vm.CheckMachineType.
+var machineTypePattern = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)+$`)
+
+// Config holds the configuration for the GCP PSC demo. The yaml tags are the
+// keys accepted in the --config file.
 type Config struct {
-	ProjectID string
-	Region    string
-	Zone      string
+	ProjectID string `yaml:"projectId"`
+	Region    string `yaml:"region"`
+	Zone      string `yaml:"zone"`
+
+	// Run isolation: NamePrefix is prepended to every resource name so that
+	// several demo instances can share a project, RunID labels the resources
+	NamePrefix string `yaml:"namePrefix"`
+	RunID      string `yaml:"runId"`
+	StateFile  string `yaml:"stateFile"`
+
+	// RunTTL is how long the resources of the run may live: they are labeled
+	// to expire RunTTL after StartedAt, and the janitor deletes them once
+	// they have. Zero never expires them.
+	RunTTL    time.Duration `yaml:"runTtl"`
+	StartedAt time.Time     `yaml:"-"`
 
 	// Provider VPC Configuration
-	ProviderVPC         string
-	ProviderSubnet      string
-	ProviderSubnetRange string
-	PSCNATSubnet        string
-	PSCNATSubnetRange   string
+	ProviderVPC         string `yaml:"providerVpc"`
+	ProviderSubnet      string `yaml:"providerSubnet"`
+	ProviderSubnetRange string `yaml:"providerSubnetRange"`
+	PSCNATSubnet        string `yaml:"pscNatSubnet"`
+	PSCNATSubnetRange   string `yaml:"pscNatSubnetRange"`
 
 	// Consumer VPC Configuration
-	ConsumerVPC         string
-	ConsumerSubnet      string
-	ConsumerSubnetRange string
+	ConsumerVPC         string `yaml:"consumerVpc"`
+	ConsumerSubnet      string `yaml:"consumerSubnet"`
+	ConsumerSubnetRange string `yaml:"consumerSubnetRange"`
+
+	// Bring-your-own networks: when set, the named VPC is used as is instead
+	// of creating one. Its subnets are discovered, it is never prefixed and
+	// neither it nor its subnets and firewall rules are ever deleted.
+	ExistingProviderVPC string `yaml:"existingProviderVpc"`
+	ExistingConsumerVPC string `yaml:"existingConsumerVpc"`
+
+	// VM Configuration: ImageFamily and ImageProject are those of the
+	// consumer VM, which runs the test clients
+	ProviderVM   string `yaml:"providerVm"`
+	ConsumerVM   string `yaml:"consumerVm"`
+	ImageFamily  string `yaml:"imageFamily"`
+	ImageProject string `yaml:"imageProject"`
+	MachineType  string `yaml:"machineType"`
+
+	// Provider VM: Container-Optimized OS running the demo services as
+	// containers. Their images are pinned here and passed in the instance
+	// metadata, so update-provider changes them without rebuilding the VM.
+	// The VMs have no external IP: images are pulled over Private Google
+	// Access, from Artifact Registry, gcr.io or the mirror.gcr.io cache.
+	ProviderImageFamily  string `yaml:"providerImageFamily"`
+	ProviderImageProject string `yaml:"providerImageProject"`
+	// WebImage serves the demo page on port 80
+	WebImage string `yaml:"webImage"`
+	// ToolsImage provides tcpdump for packet captures on the provider VM
+	ToolsImage string `yaml:"toolsImage"`
 
-	// VM Configuration
-	ProviderVM   string
-	ConsumerVM   string
-	ImageFamily  string
-	ImageProject string
-	MachineType  string
+	// API server emulator: the linux/amd64 binary built by `make build` is
+	// uploaded to ArtifactBucket and packaged into an image on the provider
+	// VM, unless APIServerImage names an image of it to run instead
+	APIServerBinary string `yaml:"apiserverBinary"`
+	ArtifactBucket  string `yaml:"artifactBucket"`
+	APIServerImage  string `yaml:"apiserverImage"`
 
 	// Load Balancer Configuration
-	HealthCheck       string
-	BackendService    string
-	ForwardingRule    string
-	ServiceAttachment string
+	HealthCheck       string `yaml:"healthCheck"`
+	InstanceGroup     string `yaml:"instanceGroup"`
+	BackendService    string `yaml:"backendService"`
+	ForwardingRule    string `yaml:"forwardingRule"`
+	ServiceAttachment string `yaml:"serviceAttachment"`
+	ServicePort       int    `yaml:"servicePort"`
 
 	// PSC Configuration
-	PSCEndpoint       string
-	PSCForwardingRule string
+	PSCEndpoint       string `yaml:"pscEndpoint"`
+	PSCForwardingRule string `yaml:"pscForwardingRule"`
+
+	// ConnectivityBackend is how the consumer reaches the provider service,
+	// one of the Backend constants. The peering backend names each peering
+	// after the VPC it peers with, the VPN backend creates an HA VPN gateway
+	// and a Cloud Router in each VPC, with two tunnels named after the
+	// gateway, see VPNTunnels.
+	ConnectivityBackend string `yaml:"connectivityBackend"`
+	ProviderVPNGateway  string `yaml:"providerVpnGateway"`
+	ConsumerVPNGateway  string `yaml:"consumerVpnGateway"`
+	ProviderRouter      string `yaml:"providerRouter"`
+	ConsumerRouter      string `yaml:"consumerRouter"`
+
+	// Multi-region: a second provider region with its own provider VM, load
+	// balancer and service attachment, and a consumer endpoint to it, for
+	// region failover tests. The VPCs are shared, the region gets subnets of
+	// its own. Empty SecondaryRegion deploys a single region.
+	SecondaryRegion              string `yaml:"secondaryRegion"`
+	SecondaryZone                string `yaml:"secondaryZone"`
+	SecondaryProviderSubnetRange string `yaml:"secondaryProviderSubnetRange"`
+	SecondaryPSCNATSubnetRange   string `yaml:"secondaryPscNatSubnetRange"`
+	SecondaryConsumerSubnetRange string `yaml:"secondaryConsumerSubnetRange"`
+
+	// PSCGlobalAccess lets consumers in other regions reach the PSC endpoint.
+	// It is set on the secondary region, whose endpoint the consumer VM of
+	// the primary region connects to.
+	PSCGlobalAccess bool `yaml:"-"`
+
+	// Backend health verification: how long setup waits for a HEALTHY
+	// backend and how often it polls GetHealth in the meantime
+	BackendHealthTimeout  time.Duration `yaml:"backendHealthTimeout"`
+	BackendHealthInterval time.Duration `yaml:"backendHealthInterval"`
+
+	// Readiness gate between demo steps: how long the resources of a step
+	// may take to be usable and how often they are probed in the meantime
+	ReadinessTimeout  time.Duration `yaml:"readinessTimeout"`
+	ReadinessInterval time.Duration `yaml:"readinessInterval"`
+
+	// PropagationLog is the JSON lines file every demo run appends its
+	// propagation delay measurement to, shared by all runs. Empty disables
+	// the measurement.
+	PropagationLog string `yaml:"propagationLog"`
+
+	// ComparisonLog is the JSON lines file every demo run appends the setup
+	// effort, latency and isolation of its connectivity backend to, shared
+	// by all runs. Empty disables the comparison.
+	ComparisonLog string `yaml:"comparisonLog"`
+
+	// BigQueryTable receives the structured results of every connectivity
+	// test run, as "project.dataset.table" or "dataset.table" in ProjectID.
+	// Empty disables the export.
+	BigQueryTable string `yaml:"bigqueryTable"`
+
+	// IAMRoleFile is the custom role definition the IAM permissions of the
+	// Compute API calls of demo and cleanup runs are added to. Empty disables
+	// the recording.
+	IAMRoleFile string `yaml:"iamRoleFile"`
+
+	// SSH access to the VMs, one of the SSHMode constants. The OS Login and
+	// metadata modes generate a key for each command, which expires after
+	// SSHKeyTTL if the command cannot remove it.
+	SSHMode   string        `yaml:"sshMode"`
+	SSHKeyTTL time.Duration `yaml:"sshKeyTtl"`
+
+	// Set by vm.SetupSSH in the ephemeral key modes: the private key, the
+	// user of metadata mode and the ssh-keys metadata entry of the key
+	SSHKeyFile     string `yaml:"-"`
+	SSHUser        string `yaml:"-"`
+	SSHMetadataKey string `yaml:"-"`
+
+	// Verbose prints the full effective configuration at startup
+	Verbose bool `yaml:"-"`
 }
 
-// NewConfig creates a new configuration with default values
+// NewConfig creates a new configuration from defaults and environment variables
 func NewConfig() *Config {
+	cfg := defaultConfig()
+	cfg.applyNamePrefix()
+	return cfg
+}
+
+// defaultConfig returns the built-in defaults overridden by environment
+// variables, before resource names are prefixed
+func defaultConfig() *Config {
 	return &Config{
 		ProjectID: getEnvWithDefault("PROJECT_ID", ""),
 		Region:    getEnvWithDefault("REGION", "us-central1"),
 		Zone:      getEnvWithDefault("ZONE", "us-central1-a"),
 
+		NamePrefix: getEnvWithDefault("NAME_PREFIX", ""),
+		RunID:      getEnvWithDefault("RUN_ID", ""),
+		StateFile:  getEnvWithDefault("STATE_FILE", ""),
+
+		RunTTL:    getEnvDurationWithDefault("RUN_TTL", 24*time.Hour),
+		StartedAt: time.Now(),
+
 		// Provider VPC Configuration
 		ProviderVPC:         "hypershift-redhat",
 		ProviderSubnet:      "hypershift-redhat-subnet",
@@ -60,6 +260,9 @@ func NewConfig() *Config {
 		ConsumerSubnet:      "hypershift-customer-subnet",
 		ConsumerSubnetRange: "10.2.0.0/24",
 
+		ExistingProviderVPC: getEnvWithDefault("EXISTING_PROVIDER_VPC", ""),
+		ExistingConsumerVPC: getEnvWithDefault("EXISTING_CONSUMER_VPC", ""),
+
 		// VM Configuration
 		ProviderVM:   "redhat-service-vm",
 		ConsumerVM:   "customer-client-vm",
@@ -67,22 +270,580 @@ func NewConfig() *Config {
 		ImageProject: "ubuntu-os-cloud",
 		MachineType:  "e2-micro",
 
+		ProviderImageFamily:  "cos-121-lts",
+		ProviderImageProject: "cos-cloud",
+		WebImage:             getEnvWithDefault("WEB_IMAGE", "mirror.gcr.io/library/nginx:1.27.4-alpine"),
+		ToolsImage:           getEnvWithDefault("TOOLS_IMAGE", "mirror.gcr.io/nicolaka/netshoot:v0.13"),
+
+		APIServerBinary: getEnvWithDefault("APISERVER_BINARY", "bin/apiserver-linux-amd64"),
+		ArtifactBucket:  getEnvWithDefault("ARTIFACT_BUCKET", ""),
+		APIServerImage:  getEnvWithDefault("APISERVER_IMAGE", ""),
+
 		// Load Balancer Configuration
 		HealthCheck:       "redhat-service-health-check",
+		InstanceGroup:     "redhat-service-group",
 		BackendService:    "redhat-backend-service",
 		ForwardingRule:    "redhat-forwarding-rule",
 		ServiceAttachment: "redhat-service-attachment",
+		ServicePort:       6443,
 
 		// PSC Configuration
 		PSCEndpoint:       "customer-psc-endpoint",
 		PSCForwardingRule: "customer-psc-forwarding-rule",
+
+		ConnectivityBackend: getEnvWithDefault("CONNECTIVITY_BACKEND", BackendPSC),
+		ProviderVPNGateway:  "redhat-vpn-gateway",
+		ConsumerVPNGateway:  "customer-vpn-gateway",
+		ProviderRouter:      "redhat-vpn-router",
+		ConsumerRouter:      "customer-vpn-router",
+
+		SecondaryRegion:              getEnvWithDefault("SECONDARY_REGION", ""),
+		SecondaryZone:                getEnvWithDefault("SECONDARY_ZONE", ""),
+		SecondaryProviderSubnetRange: "10.1.2.0/24",
+		SecondaryPSCNATSubnetRange:   "10.1.3.0/24",
+		SecondaryConsumerSubnetRange: "10.2.1.0/24",
+
+		// Backend health verification
+		BackendHealthTimeout:  getEnvDurationWithDefault("BACKEND_HEALTH_TIMEOUT", 5*time.Minute),
+		BackendHealthInterval: getEnvDurationWithDefault("BACKEND_HEALTH_INTERVAL", 10*time.Second),
+
+		// Readiness gate between steps
+		ReadinessTimeout:  getEnvDurationWithDefault("READINESS_TIMEOUT", 5*time.Minute),
+		ReadinessInterval: getEnvDurationWithDefault("READINESS_INTERVAL", 5*time.Second),
+
+		PropagationLog: getEnvWithDefault("PROPAGATION_LOG", "psc-propagation.jsonl"),
+		ComparisonLog:  getEnvWithDefault("COMPARISON_LOG", "psc-comparison.jsonl"),
+		BigQueryTable:  getEnvWithDefault("BIGQUERY_TABLE", ""),
+		IAMRoleFile:    getEnvWithDefault("IAM_ROLE_FILE", ""),
+
+		SSHMode:   getEnvWithDefault("SSH_MODE", SSHModeGcloud),
+		SSHKeyTTL: getEnvDurationWithDefault("SSH_KEY_TTL", time.Hour),
+	}
+}
+
+// applyNamePrefix prefixes every resource name and derives the run ID and state file
+func (c *Config) applyNamePrefix() {
+	c.UseExistingVPCs(c.ExistingProviderVPC, c.ExistingConsumerVPC)
+
+	if c.RunID == "" {
+		c.RunID = c.NamePrefix
+		if c.RunID == "" {
+			c.RunID = "default"
+		}
+	}
+
+	if c.StateFile == "" {
+		c.StateFile = fmt.Sprintf(".psc-demo-%s.json", c.RunID)
+	}
+
+	if c.NamePrefix == "" {
+		return
+	}
+
+	for _, name := range c.resourceNames() {
+		*name = c.NamePrefix + "-" + *name
+	}
+}
+
+// UseExistingVPCs switches the provider and/or consumer network to an existing
+// VPC. Empty names leave the corresponding network unchanged.
+func (c *Config) UseExistingVPCs(provider, consumer string) {
+	if provider != "" {
+		c.ExistingProviderVPC = provider
+		c.ProviderVPC = provider
+	}
+	if consumer != "" {
+		c.ExistingConsumerVPC = consumer
+		c.ConsumerVPC = consumer
+	}
+}
+
+// ExtraConsumer returns the configuration of additional consumer n (2, 3, ...)
+// of a multi-consumer run: its own VPC, subnet, client VM and PSC endpoint,
+// named after the primary consumer with a "-c<n>" suffix, connected to the
+// same service attachment. The consumer VPCs are never peered, so they all
+// reuse the primary consumer subnet range.
+func (c *Config) ExtraConsumer(n int) (*Config, error) {
+	if c.ConnectivityBackend != BackendPSC {
+		return nil, fmt.Errorf("extra consumers connect through PSC, not the %s backend", c.ConnectivityBackend)
+	}
+	if c.ExistingConsumerVPC != "" {
+		return nil, fmt.Errorf("extra consumers need a demo-created consumer VPC, not existing VPC %s", c.ExistingConsumerVPC)
+	}
+	extra := *c
+	suffix := fmt.Sprintf("-c%d", n)
+	for _, name := range []*string{
+		&extra.ConsumerVPC,
+		&extra.ConsumerSubnet,
+		&extra.ConsumerVM,
+		&extra.PSCEndpoint,
+		&extra.PSCForwardingRule,
+	} {
+		*name += suffix
+	}
+	return &extra, nil
+}
+
+// Secondary returns the configuration of the secondary region of a
+// multi-region run: its own subnets in the shared VPCs, provider VM, load
+// balancer, service attachment and PSC endpoint, named after the primary ones
+// with a "-r2" suffix. The consumer VM stays in the primary region and
+// reaches the secondary endpoint through PSC global access.
+func (c *Config) Secondary() (*Config, error) {
+	if c.SecondaryRegion == "" {
+		return nil, fmt.Errorf("no secondary region configured (SECONDARY_REGION or --secondary-region)")
+	}
+	if c.ExistingProviderVPC != "" || c.ExistingConsumerVPC != "" {
+		return nil, fmt.Errorf("a secondary region needs demo-created VPCs, not existing ones")
+	}
+	secondary := *c
+	secondary.Region = c.SecondaryRegion
+	secondary.Zone = c.SecondaryZone
+	secondary.ProviderSubnetRange = c.SecondaryProviderSubnetRange
+	secondary.PSCNATSubnetRange = c.SecondaryPSCNATSubnetRange
+	secondary.ConsumerSubnetRange = c.SecondaryConsumerSubnetRange
+	secondary.SecondaryRegion = ""
+	secondary.SecondaryZone = ""
+	secondary.PSCGlobalAccess = true
+	for _, name := range []*string{
+		&secondary.ProviderSubnet,
+		&secondary.PSCNATSubnet,
+		&secondary.ConsumerSubnet,
+		&secondary.ProviderVM,
+		&secondary.HealthCheck,
+		&secondary.InstanceGroup,
+		&secondary.BackendService,
+		&secondary.ForwardingRule,
+		&secondary.ServiceAttachment,
+		&secondary.PSCEndpoint,
+		&secondary.PSCForwardingRule,
+	} {
+		*name += "-r2"
+	}
+	return &secondary, nil
+}
+
+// PSCNATRanges returns the PSC NAT ranges the provider VMs accept traffic
+// from, those of both regions of a multi-region run
+func (c *Config) PSCNATRanges() []string {
+	if c.SecondaryRegion == "" {
+		return []string{c.PSCNATSubnetRange}
+	}
+	return []string{c.PSCNATSubnetRange, c.SecondaryPSCNATSubnetRange}
+}
+
+// VPNTunnels returns the names of the two tunnels of an HA VPN gateway of the
+// VPN backend, one for each of its interfaces
+func (c *Config) VPNTunnels(gateway string) []string {
+	return []string{gateway + "-tunnel0", gateway + "-tunnel1"}
+}
+
+// resourceNames returns pointers to every configurable GCP resource name
+// created by the demo, leaving out the networks of existing VPCs
+func (c *Config) resourceNames() []*string {
+	var names []*string
+	if c.ExistingProviderVPC == "" {
+		names = append(names, &c.ProviderVPC, &c.ProviderSubnet, &c.PSCNATSubnet)
+	}
+	if c.ExistingConsumerVPC == "" {
+		names = append(names, &c.ConsumerVPC, &c.ConsumerSubnet)
+	}
+	return append(names,
+		&c.ProviderVM,
+		&c.ConsumerVM,
+		&c.HealthCheck,
+		&c.InstanceGroup,
+		&c.BackendService,
+		&c.ForwardingRule,
+		&c.ServiceAttachment,
+		&c.PSCEndpoint,
+		&c.PSCForwardingRule,
+		&c.ProviderVPNGateway,
+		&c.ConsumerVPNGateway,
+		&c.ProviderRouter,
+		&c.ConsumerRouter,
+	)
+}
+
+// Hash identifies the tested topology: a digest of the configuration without
+// the settings that differ between runs of the same experiment, the project,
+// run identity, name prefix, local files, result sinks and SSH access, so
+// results can be compared across projects and runs
+func (c *Config) Hash() string {
+	topology := *c
+	for _, name := range topology.resourceNames() {
+		*name = strings.TrimPrefix(*name, c.NamePrefix+"-")
+	}
+	topology.ProjectID = ""
+	topology.NamePrefix = ""
+	topology.RunID = ""
+	topology.StateFile = ""
+	topology.RunTTL = 0
+	topology.APIServerBinary = ""
+	topology.ArtifactBucket = ""
+	topology.PropagationLog = ""
+	topology.ComparisonLog = ""
+	topology.BigQueryTable = ""
+	topology.IAMRoleFile = ""
+	topology.SSHMode = ""
+	topology.SSHKeyTTL = 0
+
+	data, err := yaml.Marshal(&topology)
+	if err != nil {
+		// A struct of strings, numbers and durations always marshals
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ArtifactBucketName returns the GCS bucket holding the API server binary,
+// defaulting to one bucket per project shared by all runs
+func (c *Config) ArtifactBucketName() string {
+	if c.ArtifactBucket != "" {
+		return c.ArtifactBucket
+	}
+	return c.ProjectID + "-psc-demo-artifacts"
+}
+
+// APIServerObject returns the object name the API server binary is uploaded
+// to in the artifact bucket, one per run
+func (c *Config) APIServerObject() string {
+	return c.RunID + "/psc-apiserver"
+}
+
+// SSHArgs returns the gcloud arguments running ssh on a VM of the run in zone,
+// followed by args such as --command. The VMs have no external IP, so gcloud
+// tunnels through IAP.
+func (c *Config) SSHArgs(vmName, zone string, args ...string) []string {
+	target := vmName
+	if c.SSHUser != "" {
+		target = c.SSHUser + "@" + vmName
+	}
+	sshArgs := []string{"compute", "ssh", target, "--zone", zone, "--project", c.ProjectID}
+	if c.SSHKeyFile != "" {
+		// The ephemeral key is authorized already, and each command sees the
+		// VMs for the first time with it
+		sshArgs = append(sshArgs, "--ssh-key-file", c.SSHKeyFile, "--strict-host-key-checking", "no")
+	}
+	return append(sshArgs, args...)
+}
+
+// UseSSH shares the SSH access vm.SetupSSH prepared for another
+// configuration of the run, such as the one of an extra consumer
+func (c *Config) UseSSH(from *Config) {
+	c.SSHKeyFile = from.SSHKeyFile
+	c.SSHUser = from.SSHUser
+	c.SSHMetadataKey = from.SSHMetadataKey
+}
+
+// OwnsName reports whether a resource name belongs to this run: either one of
+// the configured names or a name derived from them (firewall rules are named
+// after their VPC, the PSC address after the endpoint, the VPN tunnels after
+// their gateway). Existing VPCs, their
+// subnets and firewall rules are never owned.
+func (c *Config) OwnsName(name string) bool {
+	for _, owned := range c.resourceNames() {
+		if name == *owned {
+			return true
+		}
+	}
+	parents := []string{c.PSCEndpoint, c.ProviderVPNGateway, c.ConsumerVPNGateway}
+	if c.ExistingProviderVPC == "" {
+		parents = append(parents, c.ProviderVPC)
+	}
+	if c.ExistingConsumerVPC == "" {
+		parents = append(parents, c.ConsumerVPC)
+	}
+	for _, parent := range parents {
+		if strings.HasPrefix(name, parent+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnsLabels reports whether a resource carries this run's labels
+func (c *Config) OwnsLabels(labels map[string]string) bool {
+	return labels[LabelDemo] == "true" && labels[LabelRunID] == c.RunID
+}
+
+// Labels returns the labels stamped on labelable resources created by this run
+func (c *Config) Labels() map[string]string {
+	labels := map[string]string{
+		LabelDemo:    "true",
+		LabelRunID:   c.RunID,
+		LabelBackend: c.ConnectivityBackend,
+		LabelZone:    c.Zone,
+	}
+	if c.RunTTL > 0 {
+		labels[LabelExpires] = strconv.FormatInt(c.StartedAt.Add(c.RunTTL).Unix(), 10)
+	}
+	if c.NamePrefix != "" {
+		labels[LabelNamePrefix] = c.NamePrefix
+	}
+	// Set on the configuration of the secondary region only, see Secondary
+	if c.PSCGlobalAccess {
+		labels[LabelSecondary] = "true"
+	}
+	if c.ExistingProviderVPC != "" {
+		labels[LabelExistingProviderVPC] = c.ExistingProviderVPC
+	}
+	if c.ExistingConsumerVPC != "" {
+		labels[LabelExistingConsumerVPC] = c.ExistingConsumerVPC
+	}
+	return labels
+}
+
+// ExpiresAt returns the expiry of a resource labeled by Labels, false if it
+// has none
+func ExpiresAt(labels map[string]string) (time.Time, bool) {
+	unix, err := strconv.ParseInt(labels[LabelExpires], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// RunFromLabels returns the configuration of the run in the project of c
+// whose resources carry labels: the default resource names with the name
+// prefix of the run, its connectivity backend and existing VPCs, in zone, and
+// with a secondary region in secondaryZone unless it is empty. The subnets of
+// existing VPCs are not known, which only matters to commands other than the
+// teardown since it never deletes them.
+func (c *Config) RunFromLabels(labels map[string]string, zone, secondaryZone string) (*Config, error) {
+	run := defaultConfig()
+	run.ProjectID = c.ProjectID
+	run.NamePrefix = labels[LabelNamePrefix]
+	run.RunID = labels[LabelRunID]
+	run.StateFile = ""
+	run.ConnectivityBackend = labels[LabelBackend]
+	if run.ConnectivityBackend == "" {
+		run.ConnectivityBackend = BackendPSC
+	}
+	run.ExistingProviderVPC = labels[LabelExistingProviderVPC]
+	run.ExistingConsumerVPC = labels[LabelExistingConsumerVPC]
+	run.SecondaryRegion, run.SecondaryZone = "", ""
+
+	m := zonePattern.FindStringSubmatch(zone)
+	if m == nil {
+		return nil, fmt.Errorf("run %s: zone %q is not a GCP zone name", run.RunID, zone)
+	}
+	run.Region, run.Zone = m[1], zone
+	if secondaryZone != "" {
+		m := zonePattern.FindStringSubmatch(secondaryZone)
+		if m == nil {
+			return nil, fmt.Errorf("run %s: secondary zone %q is not a GCP zone name", run.RunID, secondaryZone)
+		}
+		run.SecondaryRegion, run.SecondaryZone = m[1], secondaryZone
+	}
+
+	// The labels name the resources to delete, so they get the same
+	// checks as the flags they came from
+	if !namePrefixPattern.MatchString(run.RunID) {
+		return nil, fmt.Errorf("run ID label %q is not a valid run ID", run.RunID)
+	}
+	if run.NamePrefix != "" && !namePrefixPattern.MatchString(run.NamePrefix) {
+		return nil, fmt.Errorf("run %s: name prefix label %q is not a valid name prefix", run.RunID, run.NamePrefix)
+	}
+	if err := run.validateConnectivityBackend(); err != nil {
+		return nil, fmt.Errorf("run %s: %v", run.RunID, err)
 	}
+	run.applyNamePrefix()
+	return run, nil
 }
 
 // Validate checks if all required configuration values are set
 func (c *Config) Validate() error {
 	if c.ProjectID == "" {
-		return fmt.Errorf("PROJECT_ID environment variable is required")
+		return fmt.Errorf("project ID is required (PROJECT_ID, --project or projectId in the config file)")
+	}
+	if c.NamePrefix != "" && !namePrefixPattern.MatchString(c.NamePrefix) {
+		return fmt.Errorf("NAME_PREFIX %q must be 1-20 lowercase letters, digits or hyphens, starting with a letter", c.NamePrefix)
+	}
+	if !namePrefixPattern.MatchString(c.RunID) {
+		return fmt.Errorf("RUN_ID %q must be 1-20 lowercase letters, digits or hyphens, starting with a letter", c.RunID)
+	}
+	if c.BackendHealthTimeout <= 0 || c.BackendHealthInterval <= 0 {
+		return fmt.Errorf("BACKEND_HEALTH_TIMEOUT and BACKEND_HEALTH_INTERVAL must be positive durations (e.g. 5m, 10s)")
+	}
+	if c.ReadinessTimeout <= 0 || c.ReadinessInterval <= 0 {
+		return fmt.Errorf("READINESS_TIMEOUT and READINESS_INTERVAL must be positive durations (e.g. 5m, 5s)")
+	}
+	if c.ServicePort < 1 || c.ServicePort > 65535 {
+		return fmt.Errorf("service port %d must be between 1 and 65535", c.ServicePort)
+	}
+	if c.MachineType == "" || c.ImageFamily == "" || c.ImageProject == "" || c.ProviderImageFamily == "" || c.ProviderImageProject == "" {
+		return fmt.Errorf("machine type, image families and image projects must not be empty")
+	}
+	if !machineTypePattern.MatchString(c.MachineType) {
+		return fmt.Errorf("machine type %q is not a valid machine type name such as e2-micro or n2-standard-4 (--machine-type)", c.MachineType)
+	}
+	if c.APIServerBinary == "" {
+		return fmt.Errorf("API server binary path must not be empty (APISERVER_BINARY or --apiserver-binary)")
+	}
+	for _, image := range []struct{ name, value, flag string }{
+		{"web image", c.WebImage, "--web-image"},
+		{"tools image", c.ToolsImage, "--tools-image"},
+		{"API server image", c.APIServerImage, "--apiserver-image"},
+	} {
+		if image.value == "" && image.flag == "--apiserver-image" {
+			continue
+		}
+		if !pinnedImage(image.value) {
+			return fmt.Errorf("%s %q must be pinned to a tag other than latest or to a digest (%s)", image.name, image.value, image.flag)
+		}
+	}
+	switch c.SSHMode {
+	case SSHModeGcloud, SSHModeOSLogin, SSHModeMetadata:
+	default:
+		return fmt.Errorf("SSH mode %q must be %s, %s or %s (SSH_MODE or --ssh-mode)",
+			c.SSHMode, SSHModeGcloud, SSHModeOSLogin, SSHModeMetadata)
+	}
+	if c.RunTTL < 0 {
+		return fmt.Errorf("run TTL must not be negative, 0 never expires the run (RUN_TTL or --run-ttl)")
+	}
+	if c.SSHKeyTTL < time.Minute {
+		return fmt.Errorf("SSH key TTL must be at least 1m (SSH_KEY_TTL or --ssh-key-ttl)")
+	}
+	if err := c.validateZone(); err != nil {
+		return err
+	}
+	if err := c.validateSecondaryRegion(); err != nil {
+		return err
+	}
+	if err := c.validateConnectivityBackend(); err != nil {
+		return err
+	}
+	if err := c.validateNames(); err != nil {
+		return err
+	}
+	return c.validateRanges()
+}
+
+// pinnedImage reports whether a container image reference names a tag other
+// than latest or a digest, so every run of the demo starts the same services
+func pinnedImage(image string) bool {
+	if strings.Contains(image, "@sha256:") {
+		return true
+	}
+	// A colon before the last slash is the port of the registry
+	name := image[strings.LastIndex(image, "/")+1:]
+	_, tag, ok := strings.Cut(name, ":")
+	return ok && tag != "" && tag != "latest"
+}
+
+// validateZone checks that the region is a GCP region name and the zone one
+// of its zones
+func (c *Config) validateZone() error {
+	if !regionPattern.MatchString(c.Region) {
+		return fmt.Errorf("region %q is not a GCP region name such as us-central1 (REGION or --region)", c.Region)
+	}
+	if !zoneOfRegion(c.Zone, c.Region) {
+		return fmt.Errorf("zone %q must be a zone of region %s, such as %s-a (ZONE or --zone)", c.Zone, c.Region, c.Region)
+	}
+	return nil
+}
+
+// zoneOfRegion reports whether zone is a zone name of region
+func zoneOfRegion(zone, region string) bool {
+	m := zonePattern.FindStringSubmatch(zone)
+	return m != nil && m[1] == region
+}
+
+// validateNames checks that the name of every resource the run creates,
+// including the names derived from the configured ones, follows the GCP
+// naming rules. Existing VPCs are named already.
+func (c *Config) validateNames() error {
+	type derived struct {
+		name   string
+		source string
+	}
+	var names []derived
+	for _, name := range c.resourceNames() {
+		names = append(names, derived{*name, ""})
+	}
+	// Firewall rules are named after their VPC, the longest suffix being
+	// that of the health check rule
+	if c.ExistingProviderVPC == "" {
+		names = append(names, derived{c.ProviderVPC + "-allow-health-checks", "provider VPC " + c.ProviderVPC})
+	}
+	if c.ExistingConsumerVPC == "" {
+		names = append(names, derived{c.ConsumerVPC + "-allow-health-checks", "consumer VPC " + c.ConsumerVPC})
+	}
+	names = append(names, derived{c.PSCEndpoint + "-ip", "PSC endpoint " + c.PSCEndpoint})
+	if c.ConnectivityBackend == BackendVPN {
+		for _, gateway := range []string{c.ProviderVPNGateway, c.ConsumerVPNGateway} {
+			for _, tunnel := range c.VPNTunnels(gateway) {
+				names = append(names, derived{tunnel, "VPN gateway " + gateway})
+			}
+		}
+	}
+	if c.SecondaryRegion != "" {
+		if secondary, err := c.Secondary(); err == nil {
+			for _, name := range secondary.resourceNames() {
+				if base, ok := strings.CutSuffix(*name, "-r2"); ok {
+					names = append(names, derived{*name, base + " for secondary region " + c.SecondaryRegion})
+				}
+			}
+		}
+	}
+
+	for _, n := range names {
+		if resourceNamePattern.MatchString(n.name) {
+			continue
+		}
+		if n.source != "" {
+			return fmt.Errorf("resource name %q derived from %s must be 1-63 lowercase letters, digits or hyphens, starting with a letter and not ending with a hyphen",
+				n.name, n.source)
+		}
+		return fmt.Errorf("resource name %q must be 1-63 lowercase letters, digits or hyphens, starting with a letter and not ending with a hyphen",
+			n.name)
+	}
+	return nil
+}
+
+// validateSecondaryRegion checks the region and zone of a multi-region run
+func (c *Config) validateSecondaryRegion() error {
+	if c.SecondaryRegion == "" {
+		if c.SecondaryZone != "" {
+			return fmt.Errorf("SECONDARY_ZONE %s needs SECONDARY_REGION", c.SecondaryZone)
+		}
+		return nil
+	}
+	if c.SecondaryRegion == c.Region {
+		return fmt.Errorf("secondary region %s must differ from region %s", c.SecondaryRegion, c.Region)
+	}
+	if !regionPattern.MatchString(c.SecondaryRegion) {
+		return fmt.Errorf("secondary region %q is not a GCP region name such as us-east1 (SECONDARY_REGION or --secondary-region)", c.SecondaryRegion)
+	}
+	if !zoneOfRegion(c.SecondaryZone, c.SecondaryRegion) {
+		return fmt.Errorf("secondary zone %q must be a zone of secondary region %s (SECONDARY_ZONE or --secondary-zone)",
+			c.SecondaryZone, c.SecondaryRegion)
+	}
+	if c.ExistingProviderVPC != "" || c.ExistingConsumerVPC != "" {
+		return fmt.Errorf("a secondary region cannot be used with existing VPCs")
+	}
+	return nil
+}
+
+// validateConnectivityBackend checks the backend and that the alternatives to
+// PSC get the topology they are compared in: a single region between two
+// demo-created VPCs
+func (c *Config) validateConnectivityBackend() error {
+	switch c.ConnectivityBackend {
+	case BackendPSC:
+		return nil
+	case BackendPeering, BackendVPN:
+	default:
+		return fmt.Errorf("connectivity backend %q must be %s, %s or %s (CONNECTIVITY_BACKEND or --connectivity-backend)",
+			c.ConnectivityBackend, BackendPSC, BackendPeering, BackendVPN)
+	}
+	if c.SecondaryRegion != "" {
+		return fmt.Errorf("the %s connectivity backend cannot be used with a secondary region", c.ConnectivityBackend)
+	}
+	if c.ExistingProviderVPC != "" || c.ExistingConsumerVPC != "" {
+		return fmt.Errorf("the %s connectivity backend needs demo-created VPCs, not existing ones", c.ConnectivityBackend)
 	}
 	return nil
 }
@@ -94,3 +855,17 @@ func getEnvWithDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvDurationWithDefault parses a duration environment variable such as "90s".
+// Unparseable values yield zero so Validate can report them.
+func getEnvDurationWithDefault(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"defaults", nil, ""},
+		{"zone of another region", []string{"--zone", "us-east1-b"}, `zone "us-east1-b" must be a zone of region us-central1`},
+		{"region name", []string{"--region", "central", "--zone", "central-a"}, `region "central" is not a GCP region name`},
+		{"secondary zone", []string{"--secondary-region", "us-east1", "--secondary-zone", "us-east1"}, `secondary zone "us-east1" must be a zone of secondary region us-east1`},
+		{"overlapping ranges", []string{"--consumer-subnet-range", "10.1.0.128/25"}, "provider subnet range 10.1.0.0/24 overlaps consumer subnet range 10.1.0.128/25"},
+		{"link-local range", []string{"--psc-nat-subnet-range", "169.254.10.0/24"}, "PSC NAT subnet range 169.254.10.0/24 overlaps reserved range 169.254.0.0/16"},
+		{"docker bridge range", []string{"--consumer-subnet-range", "172.16.0.0/12"}, "consumer subnet range 172.16.0.0/12 overlaps reserved range 172.17.0.0/16"},
+		{"resource name", []string{"--provider-vm", "Provider_VM"}, `resource name "Provider_VM" must be 1-63`},
+		{"derived name", []string{"--consumer-vpc", strings.Repeat("c", 50)}, "derived from consumer VPC " + strings.Repeat("c", 50)},
+		{"secondary derived name", []string{"--secondary-region", "us-east1", "--secondary-zone", "us-east1-b", "--provider-vm", strings.Repeat("p", 61)},
+			"derived from " + strings.Repeat("p", 61) + " for secondary region us-east1"},
+		{"machine type", []string{"--machine-type", "E2 Micro"}, `machine type "E2 Micro" is not a valid machine type name`},
+		{"pinned image digest", []string{"--web-image", "nginx@sha256:0123456789abcdef"}, ""},
+		{"registry port", []string{"--apiserver-image", "registry.local:5000/psc-apiserver:v1"}, ""},
+		{"untagged image", []string{"--web-image", "registry.local:5000/nginx"}, `web image "registry.local:5000/nginx" must be pinned`},
+		{"peering backend", []string{"--connectivity-backend", "peering"}, ""},
+		{"unknown backend", []string{"--connectivity-backend", "interconnect"}, `connectivity backend "interconnect" must be psc, peering or vpn`},
+		{"vpn with secondary region", []string{"--connectivity-backend", "vpn", "--secondary-region", "us-east1", "--secondary-zone", "us-east1-b"},
+			"the vpn connectivity backend cannot be used with a secondary region"},
+		{"vpn tunnel name", []string{"--connectivity-backend", "vpn", "--provider-vpn-gateway", strings.Repeat("g", 60)}, "derived from VPN gateway " + strings.Repeat("g", 60)},
+		{"negative run TTL", []string{"--run-ttl", "-1h"}, "run TTL must not be negative"},
+		{"latest image", []string{"--apiserver-image", "us-docker.pkg.dev/p/r/psc-apiserver:latest"}, "API server image"},
+	} {
+		cfg, err := Load("test", append([]string{"--project", "demo-project"}, tc.args...))
+		if err != nil {
+			t.Fatalf("%s: Load() error = %v", tc.name, err)
+		}
+		err = cfg.Validate()
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: Validate() error = %v", tc.name, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%s: Validate() error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
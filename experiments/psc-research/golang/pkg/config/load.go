@@ -0,0 +1,223 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration for a command. Values are applied in order of
+// increasing precedence: built-in defaults, environment variables, the YAML
+// file given by --config (or CONFIG_FILE), then command-line flags. Resource
+// names are prefixed with NamePrefix only after all sources have been applied.
+func Load(name string, args []string) (*Config, error) {
+	return LoadWithOptions(name, args, Options{})
+}
+
+// Options extends Load for commands with flags of their own or extra
+// configuration layers
+type Options struct {
+	// Bind registers the command's own flags. It is called for every flag set
+	// Load creates, so it must bind the same variables each time.
+	Bind func(fs *flag.FlagSet)
+
+	// Overrides are YAML documents with the same keys as the --config file,
+	// applied in order after it and before the command-line flags
+	Overrides [][]byte
+}
+
+// LoadWithOptions is Load with the command flags and overrides of opts
+func LoadWithOptions(name string, args []string, opts Options) (*Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "Path to a YAML configuration file")
+	cfg.bindFlags(fs)
+	if opts.Bind != nil {
+		opts.Bind(fs)
+	}
+
+	// Parse once to find --config, then again after loading the files so flags win
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *configFile != "" || len(opts.Overrides) > 0 {
+		if *configFile != "" {
+			if err := cfg.loadFile(*configFile); err != nil {
+				return nil, err
+			}
+		}
+		for _, data := range opts.Overrides {
+			if err := cfg.loadYAML(data, "configuration overrides"); err != nil {
+				return nil, err
+			}
+		}
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg.applyNamePrefix()
+	return cfg, nil
+}
+
+// bindFlags registers the command-line overrides on fs, defaulting to the current values
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ProjectID, "project", c.ProjectID, "GCP project ID")
+	fs.StringVar(&c.Region, "region", c.Region, "GCP region")
+	fs.StringVar(&c.Zone, "zone", c.Zone, "GCP zone")
+	fs.StringVar(&c.NamePrefix, "name-prefix", c.NamePrefix, "Prefix applied to every resource name")
+	fs.StringVar(&c.RunID, "run-id", c.RunID, "Run ID label value (defaults to the name prefix)")
+	fs.StringVar(&c.StateFile, "state-file", c.StateFile, "Path of the local run state file")
+	fs.DurationVar(&c.RunTTL, "run-ttl", c.RunTTL, "How long the resources of the run may live before the janitor deletes them (0 never expires them)")
+
+	fs.StringVar(&c.ProviderVPC, "provider-vpc", c.ProviderVPC, "Provider VPC name")
+	fs.StringVar(&c.ProviderSubnet, "provider-subnet", c.ProviderSubnet, "Provider subnet name")
+	fs.StringVar(&c.ProviderSubnetRange, "provider-subnet-range", c.ProviderSubnetRange, "Provider subnet CIDR")
+	fs.StringVar(&c.PSCNATSubnet, "psc-nat-subnet", c.PSCNATSubnet, "PSC NAT subnet name")
+	fs.StringVar(&c.PSCNATSubnetRange, "psc-nat-subnet-range", c.PSCNATSubnetRange, "PSC NAT subnet CIDR")
+	fs.StringVar(&c.ConsumerVPC, "consumer-vpc", c.ConsumerVPC, "Consumer VPC name")
+	fs.StringVar(&c.ConsumerSubnet, "consumer-subnet", c.ConsumerSubnet, "Consumer subnet name")
+	fs.StringVar(&c.ConsumerSubnetRange, "consumer-subnet-range", c.ConsumerSubnetRange, "Consumer subnet CIDR")
+	fs.StringVar(&c.ExistingProviderVPC, "existing-provider-vpc", c.ExistingProviderVPC, "Use this existing VPC as the provider network instead of creating one")
+	fs.StringVar(&c.ExistingConsumerVPC, "existing-consumer-vpc", c.ExistingConsumerVPC, "Use this existing VPC as the consumer network instead of creating one")
+
+	fs.StringVar(&c.ProviderVM, "provider-vm", c.ProviderVM, "Provider (service) VM name")
+	fs.StringVar(&c.ConsumerVM, "consumer-vm", c.ConsumerVM, "Consumer (client) VM name")
+	fs.StringVar(&c.MachineType, "machine-type", c.MachineType, "Machine type for both VMs")
+	fs.StringVar(&c.ImageFamily, "image-family", c.ImageFamily, "Image family for the consumer VM")
+	fs.StringVar(&c.ImageProject, "image-project", c.ImageProject, "Project hosting the image family")
+	fs.StringVar(&c.ProviderImageFamily, "provider-image-family", c.ProviderImageFamily, "Container-Optimized OS image family for the provider VM")
+	fs.StringVar(&c.ProviderImageProject, "provider-image-project", c.ProviderImageProject, "Project hosting the provider image family")
+	fs.StringVar(&c.WebImage, "web-image", c.WebImage, "Pinned container image serving the demo page on the provider VM")
+	fs.StringVar(&c.ToolsImage, "tools-image", c.ToolsImage, "Pinned container image providing tcpdump on the provider VM")
+	fs.StringVar(&c.APIServerBinary, "apiserver-binary", c.APIServerBinary, "linux/amd64 API server emulator binary deployed to the provider VM")
+	fs.StringVar(&c.ArtifactBucket, "artifact-bucket", c.ArtifactBucket, "GCS bucket for the API server binary (default <project>-psc-demo-artifacts)")
+	fs.StringVar(&c.APIServerImage, "apiserver-image", c.APIServerImage, "Pinned container image of the API server emulator to run instead of the uploaded binary")
+
+	fs.StringVar(&c.SecondaryRegion, "secondary-region", c.SecondaryRegion, "Also deploy the provider service and an endpoint to it in this region, for failover tests")
+	fs.StringVar(&c.SecondaryZone, "secondary-zone", c.SecondaryZone, "Zone of the secondary region's provider VM")
+	fs.StringVar(&c.SecondaryProviderSubnetRange, "secondary-provider-subnet-range", c.SecondaryProviderSubnetRange, "Provider subnet CIDR in the secondary region")
+	fs.StringVar(&c.SecondaryPSCNATSubnetRange, "secondary-psc-nat-subnet-range", c.SecondaryPSCNATSubnetRange, "PSC NAT subnet CIDR in the secondary region")
+	fs.StringVar(&c.SecondaryConsumerSubnetRange, "secondary-consumer-subnet-range", c.SecondaryConsumerSubnetRange, "Consumer subnet CIDR in the secondary region")
+
+	fs.StringVar(&c.ConnectivityBackend, "connectivity-backend", c.ConnectivityBackend, "How the consumer reaches the provider service: psc, peering (VPC peering) or vpn (HA VPN)")
+	fs.StringVar(&c.ProviderVPNGateway, "provider-vpn-gateway", c.ProviderVPNGateway, "HA VPN gateway name in the provider VPC (vpn backend)")
+	fs.StringVar(&c.ConsumerVPNGateway, "consumer-vpn-gateway", c.ConsumerVPNGateway, "HA VPN gateway name in the consumer VPC (vpn backend)")
+	fs.StringVar(&c.ProviderRouter, "provider-router", c.ProviderRouter, "Cloud Router name in the provider VPC (vpn backend)")
+	fs.StringVar(&c.ConsumerRouter, "consumer-router", c.ConsumerRouter, "Cloud Router name in the consumer VPC (vpn backend)")
+
+	fs.IntVar(&c.ServicePort, "service-port", c.ServicePort, "TLS port the emulated API server listens on behind the load balancer")
+	fs.DurationVar(&c.BackendHealthTimeout, "backend-health-timeout", c.BackendHealthTimeout, "How long setup waits for a HEALTHY backend")
+	fs.DurationVar(&c.BackendHealthInterval, "backend-health-interval", c.BackendHealthInterval, "Delay between backend health polls")
+	fs.DurationVar(&c.ReadinessTimeout, "readiness-timeout", c.ReadinessTimeout, "How long the demo waits for the resources of a step to be ready before the next step")
+	fs.DurationVar(&c.ReadinessInterval, "readiness-interval", c.ReadinessInterval, "Delay between readiness probes of a resource")
+	fs.StringVar(&c.PropagationLog, "propagation-log", c.PropagationLog, "JSON lines file propagation delay measurements are appended to (empty disables them)")
+	fs.StringVar(&c.ComparisonLog, "comparison-log", c.ComparisonLog, "JSON lines file connectivity backend comparisons are appended to (empty disables them)")
+	fs.StringVar(&c.BigQueryTable, "bigquery-table", c.BigQueryTable, "BigQuery table connectivity test results are exported to, [project.]dataset.table (empty disables the export)")
+	fs.StringVar(&c.IAMRoleFile, "iam-role-file", c.IAMRoleFile, "Custom role file the IAM permissions of the Compute API calls are added to (empty disables the recording)")
+	fs.StringVar(&c.SSHMode, "ssh-mode", c.SSHMode, "SSH access to the VMs: gcloud (ambient configuration), oslogin or metadata (ephemeral keys)")
+	fs.DurationVar(&c.SSHKeyTTL, "ssh-key-ttl", c.SSHKeyTTL, "Expiry of the ephemeral SSH key of the oslogin and metadata modes")
+
+	fs.BoolVar(&c.Verbose, "v", c.Verbose, "Print the full effective configuration")
+}
+
+// loadFile overlays the values present in a YAML file onto the configuration
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	return c.loadYAML(data, "config file "+path)
+}
+
+// loadYAML overlays the values present in a YAML document, rejecting unknown keys
+func (c *Config) loadYAML(data []byte, source string) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse %s: %v", source, err)
+	}
+	return nil
+}
+
+// reservedRanges are the IPv4 ranges a subnet range must not overlap: those
+// reserved by the IETF that GCP rejects or routes specially, and the Docker
+// bridge range of GKE nodes
+var reservedRanges = []struct {
+	cidr   string
+	reason string
+}{
+	{"0.0.0.0/8", "\"this network\" (RFC 1122)"},
+	{"127.0.0.0/8", "loopback (RFC 1122)"},
+	{"169.254.0.0/16", "link-local, used by the metadata server (RFC 3927)"},
+	{"172.17.0.0/16", "the Docker bridge of GKE nodes"},
+	{"224.0.0.0/4", "multicast (RFC 5771)"},
+	{"255.255.255.255/32", "limited broadcast (RFC 919)"},
+}
+
+// validateRanges checks that every subnet range is a valid CIDR, outside the
+// reserved ranges, and that the provider, PSC NAT and consumer ranges do not
+// overlap
+func (c *Config) validateRanges() error {
+	ranges := []struct {
+		name  string
+		value string
+	}{
+		{"provider subnet range", c.ProviderSubnetRange},
+		{"PSC NAT subnet range", c.PSCNATSubnetRange},
+		{"consumer subnet range", c.ConsumerSubnetRange},
+	}
+	// The regions share the VPCs, so the ranges of both must be disjoint
+	if c.SecondaryRegion != "" {
+		ranges = append(ranges, []struct {
+			name  string
+			value string
+		}{
+			{"secondary provider subnet range", c.SecondaryProviderSubnetRange},
+			{"secondary PSC NAT subnet range", c.SecondaryPSCNATSubnetRange},
+			{"secondary consumer subnet range", c.SecondaryConsumerSubnetRange},
+		}...)
+	}
+
+	nets := make([]*net.IPNet, len(ranges))
+	for i, r := range ranges {
+		_, ipNet, err := net.ParseCIDR(r.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %v", r.name, r.value, err)
+		}
+		if ipNet.IP.To4() == nil {
+			return fmt.Errorf("%s %q must be an IPv4 range", r.name, r.value)
+		}
+		for _, reserved := range reservedRanges {
+			_, reservedNet, _ := net.ParseCIDR(reserved.cidr)
+			if overlaps(ipNet, reservedNet) {
+				return fmt.Errorf("%s %s overlaps reserved range %s, %s", r.name, r.value, reserved.cidr, reserved.reason)
+			}
+		}
+		nets[i] = ipNet
+	}
+
+	for i := 0; i < len(nets); i++ {
+		for j := i + 1; j < len(nets); j++ {
+			if overlaps(nets[i], nets[j]) {
+				return fmt.Errorf("%s %s overlaps %s %s",
+					ranges[i].name, ranges[i].value, ranges[j].name, ranges[j].value)
+			}
+		}
+	}
+	return nil
+}
+
+// overlaps reports whether two CIDR ranges share addresses
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
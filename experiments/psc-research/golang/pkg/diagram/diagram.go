@@ -0,0 +1,576 @@
+// Package diagram draws the architecture of a demo run from what exists in
+// the project, so the diagrams of the experiment documentation show what was
+// actually built rather than what was meant to be
+package diagram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/state"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Diagram is the architecture of a run: VPCs holding subnets holding
+// resources, and the edges between resources
+type Diagram struct {
+	Title string
+	// Notes describe where the diagram comes from, e.g. the project and
+	// the state file of the run
+	Notes  []string
+	Groups []*Group
+	Edges  []Edge
+}
+
+// Group is a VPC or a subnet
+type Group struct {
+	ID    string
+	Label string
+	// Missing groups were not found in the project
+	Missing bool
+	Nodes   []*Node
+	Groups  []*Group
+}
+
+// Node is one resource
+type Node struct {
+	ID string
+	// Kind is what the resource is, e.g. "service attachment"
+	Kind string
+	Name string
+	// Details are the key attributes of the resource as it exists, e.g.
+	// its IP address
+	Details []string
+	// Missing resources were not found in the project
+	Missing bool
+}
+
+// Edge connects two resources
+type Edge struct {
+	From, To string
+	Label    string
+	// Flow edges are the path of the consumer's requests to the provider
+	// service, the others associate resources
+	Flow bool
+}
+
+// Builder reads the resources of a run from the Compute API
+type Builder struct {
+	networkClient           *compute.NetworksClient
+	subnetClient            *compute.SubnetworksClient
+	instancesClient         *compute.InstancesClient
+	instanceGroupClient     *compute.InstanceGroupsClient
+	backendServiceClient    *compute.RegionBackendServicesClient
+	forwardingRuleClient    *compute.ForwardingRulesClient
+	serviceAttachmentClient *compute.ServiceAttachmentsClient
+	vpnGatewayClient        *compute.VpnGatewaysClient
+	vpnTunnelClient         *compute.VpnTunnelsClient
+	config                  *config.Config
+	// state is the state file of the run, nil without one
+	state *state.State
+	now   func() time.Time
+}
+
+// NewBuilder creates a builder for the run of cfg, recorded in st if not nil
+func NewBuilder(cfg *config.Config, st *state.State, opts ...option.ClientOption) (*Builder, error) {
+	ctx := context.Background()
+	b := &Builder{config: cfg, state: st, now: time.Now}
+
+	var err error
+	if b.networkClient, err = compute.NewNetworksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create networks client: %v", err)
+	}
+	if b.subnetClient, err = compute.NewSubnetworksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create subnetworks client: %v", err)
+	}
+	if b.instancesClient, err = compute.NewInstancesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create instances client: %v", err)
+	}
+	if b.instanceGroupClient, err = compute.NewInstanceGroupsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create instance groups client: %v", err)
+	}
+	if b.backendServiceClient, err = compute.NewRegionBackendServicesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create backend services client: %v", err)
+	}
+	if b.forwardingRuleClient, err = compute.NewForwardingRulesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create forwarding rules client: %v", err)
+	}
+	if b.serviceAttachmentClient, err = compute.NewServiceAttachmentsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create service attachments client: %v", err)
+	}
+	if b.vpnGatewayClient, err = compute.NewVpnGatewaysRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN gateways client: %v", err)
+	}
+	if b.vpnTunnelClient, err = compute.NewVpnTunnelsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN tunnels client: %v", err)
+	}
+
+	return b, nil
+}
+
+// Close closes all clients
+func (b *Builder) Close() {
+	for _, c := range []interface{ Close() error }{
+		b.networkClient,
+		b.subnetClient,
+		b.instancesClient,
+		b.instanceGroupClient,
+		b.backendServiceClient,
+		b.forwardingRuleClient,
+		b.serviceAttachmentClient,
+		b.vpnGatewayClient,
+		b.vpnTunnelClient,
+	} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// Build reads the resources of the run and draws them. Resources that do not
+// exist are drawn as missing, so a partial run shows what is left; any other
+// error fails the build rather than drawing a wrong architecture.
+func (b *Builder) Build(ctx context.Context) (*Diagram, error) {
+	cfg := b.config
+	d := &Diagram{Title: fmt.Sprintf("PSC demo run %s (%s backend)", cfg.RunID, cfg.ConnectivityBackend)}
+	d.Notes = append(d.Notes, fmt.Sprintf("project %s, region %s", cfg.ProjectID, cfg.Region))
+	if cfg.SecondaryRegion != "" {
+		d.Notes[0] += ", secondary region " + cfg.SecondaryRegion
+	}
+	if b.state != nil {
+		d.Notes = append(d.Notes, "run started "+b.state.CreatedAt.UTC().Format(time.RFC3339))
+	}
+	d.Notes = append(d.Notes, "drawn from the live Compute API at "+b.now().UTC().Format(time.RFC3339))
+
+	provider, err := b.network(ctx, "provider", cfg.ProviderVPC, cfg.ExistingProviderVPC != "")
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := b.network(ctx, "consumer", cfg.ConsumerVPC, cfg.ExistingConsumerVPC != "")
+	if err != nil {
+		return nil, err
+	}
+	d.Groups = append(d.Groups, provider, consumer)
+
+	if err := b.buildRegion(ctx, d, cfg, provider, consumer, ""); err != nil {
+		return nil, err
+	}
+	if cfg.SecondaryRegion != "" {
+		secondary, err := cfg.Secondary()
+		if err != nil {
+			return nil, err
+		}
+		if err := b.buildRegion(ctx, d, secondary, provider, consumer, "r2-"); err != nil {
+			return nil, err
+		}
+		// The consumer VM of the primary region reaches the secondary endpoint
+		d.Edges = append(d.Edges, Edge{From: "consumer-vm", To: "r2-psc-endpoint", Label: "failover, PSC global access", Flow: true})
+	}
+
+	switch cfg.ConnectivityBackend {
+	case config.BackendPeering:
+		if err := b.buildPeering(ctx, d); err != nil {
+			return nil, err
+		}
+	case config.BackendVPN:
+		if err := b.buildVPN(ctx, d, provider, consumer); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.state != nil {
+		for n := 2; n <= b.state.ExtraConsumers+1; n++ {
+			extra, err := cfg.ExtraConsumer(n)
+			if err != nil {
+				return nil, err
+			}
+			if err := b.buildExtraConsumer(ctx, d, extra, n); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return d, nil
+}
+
+// buildRegion draws the provider service of a region and, with the PSC
+// backend, its service attachment and the consumer endpoint. IDs are
+// prefixed with prefix, empty for the primary region.
+func (b *Builder) buildRegion(ctx context.Context, d *Diagram, cfg *config.Config, provider, consumer *Group, prefix string) error {
+	providerSubnet, err := b.subnet(ctx, prefix+"provider-subnet", cfg, cfg.ProviderSubnet)
+	if err != nil {
+		return err
+	}
+	providerVM, err := b.instance(ctx, prefix+"provider-vm", cfg, cfg.ProviderVM)
+	if err != nil {
+		return err
+	}
+	ilb, err := b.forwardingRule(ctx, prefix+"ilb", "internal load balancer", cfg, cfg.ForwardingRule)
+	if err != nil {
+		return err
+	}
+	providerSubnet.Nodes = append(providerSubnet.Nodes, providerVM, ilb)
+
+	group, err := b.instanceGroup(ctx, prefix+"instance-group", cfg)
+	if err != nil {
+		return err
+	}
+	backend, err := b.backendService(ctx, prefix+"backend-service", cfg)
+	if err != nil {
+		return err
+	}
+	provider.Groups = append(provider.Groups, providerSubnet)
+	provider.Nodes = append(provider.Nodes, backend, group)
+	d.Edges = append(d.Edges,
+		Edge{From: ilb.ID, To: backend.ID, Flow: true},
+		Edge{From: backend.ID, To: group.ID, Flow: true},
+		Edge{From: group.ID, To: providerVM.ID, Label: fmt.Sprintf("TCP :%d", cfg.ServicePort), Flow: true},
+	)
+
+	// Peering and VPN runs have a single region, whose consumer reaches the
+	// load balancer directly, see buildPeering and buildVPN
+	if cfg.ConnectivityBackend != config.BackendPSC {
+		consumerSubnet, err := b.consumerSubnet(ctx, "", cfg, consumer)
+		if err != nil {
+			return err
+		}
+		consumerVM, err := b.instance(ctx, "consumer-vm", cfg, cfg.ConsumerVM)
+		if err != nil {
+			return err
+		}
+		consumerSubnet.Nodes = append(consumerSubnet.Nodes, consumerVM)
+		return nil
+	}
+
+	natSubnet, err := b.subnet(ctx, prefix+"psc-nat-subnet", cfg, cfg.PSCNATSubnet)
+	if err != nil {
+		return err
+	}
+	nat := &Node{ID: prefix + "psc-nat", Kind: "PSC NAT", Name: cfg.PSCNATSubnet, Missing: natSubnet.Missing}
+	natSubnet.Nodes = append(natSubnet.Nodes, nat)
+	attachment, err := b.serviceAttachment(ctx, prefix+"service-attachment", cfg)
+	if err != nil {
+		return err
+	}
+	provider.Groups = append(provider.Groups, natSubnet)
+	provider.Nodes = append(provider.Nodes, attachment)
+	d.Edges = append(d.Edges,
+		Edge{From: attachment.ID, To: nat.ID, Label: "source NAT", Flow: true},
+		Edge{From: nat.ID, To: ilb.ID, Flow: true},
+	)
+
+	consumerSubnet, err := b.consumerSubnet(ctx, prefix, cfg, consumer)
+	if err != nil {
+		return err
+	}
+	endpoint, err := b.endpoint(ctx, prefix+"psc-endpoint", cfg)
+	if err != nil {
+		return err
+	}
+	consumerSubnet.Nodes = append(consumerSubnet.Nodes, endpoint)
+	d.Edges = append(d.Edges, Edge{From: endpoint.ID, To: attachment.ID, Label: "Private Service Connect", Flow: true})
+	if prefix == "" {
+		consumerVM, err := b.instance(ctx, "consumer-vm", cfg, cfg.ConsumerVM)
+		if err != nil {
+			return err
+		}
+		consumerSubnet.Nodes = append([]*Node{consumerVM}, consumerSubnet.Nodes...)
+		d.Edges = append(d.Edges, Edge{From: consumerVM.ID, To: endpoint.ID, Label: fmt.Sprintf("TCP :%d", cfg.ServicePort), Flow: true})
+	}
+	return nil
+}
+
+// consumerSubnet adds the consumer subnet of a region to the consumer VPC
+func (b *Builder) consumerSubnet(ctx context.Context, prefix string, cfg *config.Config, consumer *Group) (*Group, error) {
+	subnet, err := b.subnet(ctx, prefix+"consumer-subnet", cfg, cfg.ConsumerSubnet)
+	if err != nil {
+		return nil, err
+	}
+	consumer.Groups = append(consumer.Groups, subnet)
+	return subnet, nil
+}
+
+// buildPeering draws the consumer reaching the load balancer over the
+// peering of the VPCs, with the state of the peering the consumer VPC reports
+func (b *Builder) buildPeering(ctx context.Context, d *Diagram) error {
+	cfg := b.config
+	label := "VPC peering"
+	network, err := b.networkClient.Get(ctx, &computepb.GetNetworkRequest{Project: cfg.ProjectID, Network: cfg.ConsumerVPC})
+	switch {
+	case err == nil:
+		label += " (no peering to " + cfg.ProviderVPC + ")"
+		for _, p := range network.GetPeerings() {
+			if lastSegment(p.GetNetwork()) == cfg.ProviderVPC {
+				label = "VPC peering " + p.GetState()
+			}
+		}
+	case !isNotFound(err):
+		return fmt.Errorf("failed to get network %s: %v", cfg.ConsumerVPC, err)
+	}
+	d.Edges = append(d.Edges, Edge{From: "consumer-vm", To: "ilb", Label: label, Flow: true})
+	return nil
+}
+
+// buildVPN draws the HA VPN gateways of the VPCs and their tunnels, and the
+// consumer reaching the load balancer through them
+func (b *Builder) buildVPN(ctx context.Context, d *Diagram, provider, consumer *Group) error {
+	cfg := b.config
+	providerGateway, err := b.vpnGateway(ctx, "provider-vpn-gateway", cfg.ProviderVPNGateway)
+	if err != nil {
+		return err
+	}
+	consumerGateway, err := b.vpnGateway(ctx, "consumer-vpn-gateway", cfg.ConsumerVPNGateway)
+	if err != nil {
+		return err
+	}
+	provider.Nodes = append(provider.Nodes, providerGateway)
+	consumer.Nodes = append(consumer.Nodes, consumerGateway)
+
+	tunnels, err := b.vpnTunnels(ctx, cfg.ConsumerVPNGateway)
+	if err != nil {
+		return err
+	}
+	d.Edges = append(d.Edges,
+		Edge{From: "consumer-vm", To: consumerGateway.ID, Flow: true},
+		Edge{From: consumerGateway.ID, To: providerGateway.ID, Label: tunnels, Flow: true},
+		Edge{From: providerGateway.ID, To: "ilb", Flow: true},
+	)
+	return nil
+}
+
+// buildExtraConsumer draws additional consumer n of a multi-consumer run, in
+// a VPC of its own with an endpoint to the service attachment
+func (b *Builder) buildExtraConsumer(ctx context.Context, d *Diagram, cfg *config.Config, n int) error {
+	prefix := fmt.Sprintf("c%d-", n)
+	vpc, err := b.network(ctx, prefix+"consumer", cfg.ConsumerVPC, false)
+	if err != nil {
+		return err
+	}
+	subnet, err := b.consumerSubnet(ctx, prefix, cfg, vpc)
+	if err != nil {
+		return err
+	}
+	vm, err := b.instance(ctx, prefix+"consumer-vm", cfg, cfg.ConsumerVM)
+	if err != nil {
+		return err
+	}
+	endpoint, err := b.endpoint(ctx, prefix+"psc-endpoint", cfg)
+	if err != nil {
+		return err
+	}
+	subnet.Nodes = append(subnet.Nodes, vm, endpoint)
+	d.Groups = append(d.Groups, vpc)
+	d.Edges = append(d.Edges,
+		Edge{From: vm.ID, To: endpoint.ID, Label: fmt.Sprintf("TCP :%d", cfg.ServicePort), Flow: true},
+		Edge{From: endpoint.ID, To: "service-attachment", Label: "Private Service Connect", Flow: true},
+	)
+	return nil
+}
+
+func (b *Builder) network(ctx context.Context, role, name string, existing bool) (*Group, error) {
+	network, err := b.networkClient.Get(ctx, &computepb.GetNetworkRequest{Project: b.config.ProjectID, Network: name})
+	label := fmt.Sprintf("%s VPC %s", role, name)
+	if existing {
+		label += " (existing)"
+	}
+	g := &Group{ID: role + "-vpc", Label: label}
+	switch {
+	case err == nil:
+		if mode := network.GetRoutingConfig().GetRoutingMode(); mode != "" {
+			g.Label += ", " + strings.ToLower(mode) + " routing"
+		}
+	case isNotFound(err):
+		g.Missing = true
+	default:
+		return nil, fmt.Errorf("failed to get network %s: %v", name, err)
+	}
+	return g, nil
+}
+
+func (b *Builder) subnet(ctx context.Context, id string, cfg *config.Config, name string) (*Group, error) {
+	subnet, err := b.subnetClient.Get(ctx, &computepb.GetSubnetworkRequest{
+		Project: cfg.ProjectID, Region: cfg.Region, Subnetwork: name,
+	})
+	g := &Group{ID: id, Label: fmt.Sprintf("subnet %s (%s)", name, cfg.Region)}
+	switch {
+	case err == nil:
+		g.Label = fmt.Sprintf("subnet %s %s (%s)", name, subnet.GetIpCidrRange(), cfg.Region)
+		if purpose := subnet.GetPurpose(); purpose != "" && purpose != "PRIVATE" {
+			g.Label += ", purpose " + purpose
+		}
+	case isNotFound(err):
+		g.Missing = true
+	default:
+		return nil, fmt.Errorf("failed to get subnet %s: %v", name, err)
+	}
+	return g, nil
+}
+
+// describe builds the node of a resource from the details its lookup returns
+func describe(id, kind, name string, lookup func() ([]string, error)) (*Node, error) {
+	n := &Node{ID: id, Kind: kind, Name: name}
+	details, err := lookup()
+	switch {
+	case err == nil:
+		n.Details = details
+	case isNotFound(err):
+		n.Missing = true
+	default:
+		return nil, fmt.Errorf("failed to get %s %s: %v", kind, name, err)
+	}
+	return n, nil
+}
+
+func (b *Builder) instance(ctx context.Context, id string, cfg *config.Config, name string) (*Node, error) {
+	return describe(id, "VM", name, func() ([]string, error) {
+		instance, err := b.instancesClient.Get(ctx, &computepb.GetInstanceRequest{
+			Project: cfg.ProjectID, Zone: cfg.Zone, Instance: name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		details := []string{instance.GetStatus() + " in " + cfg.Zone}
+		if nics := instance.GetNetworkInterfaces(); len(nics) > 0 && nics[0].GetNetworkIP() != "" {
+			details = append(details, "IP "+nics[0].GetNetworkIP())
+		}
+		return details, nil
+	})
+}
+
+func (b *Builder) forwardingRule(ctx context.Context, id, kind string, cfg *config.Config, name string) (*Node, error) {
+	return describe(id, kind, name, func() ([]string, error) {
+		rule, err := b.forwardingRuleClient.Get(ctx, &computepb.GetForwardingRuleRequest{
+			Project: cfg.ProjectID, Region: cfg.Region, ForwardingRule: name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		details := []string{"IP " + rule.GetIPAddress()}
+		if ports := rule.GetPorts(); len(ports) > 0 {
+			details[0] += ", ports " + strings.Join(ports, ",")
+		}
+		return details, nil
+	})
+}
+
+func (b *Builder) instanceGroup(ctx context.Context, id string, cfg *config.Config) (*Node, error) {
+	return describe(id, "instance group", cfg.InstanceGroup, func() ([]string, error) {
+		group, err := b.instanceGroupClient.Get(ctx, &computepb.GetInstanceGroupRequest{
+			Project: cfg.ProjectID, Zone: cfg.Zone, InstanceGroup: cfg.InstanceGroup,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("%d instances", group.GetSize())}, nil
+	})
+}
+
+func (b *Builder) backendService(ctx context.Context, id string, cfg *config.Config) (*Node, error) {
+	return describe(id, "backend service", cfg.BackendService, func() ([]string, error) {
+		bs, err := b.backendServiceClient.Get(ctx, &computepb.GetRegionBackendServiceRequest{
+			Project: cfg.ProjectID, Region: cfg.Region, BackendService: cfg.BackendService,
+		})
+		if err != nil {
+			return nil, err
+		}
+		details := []string{strings.ToLower(bs.GetLoadBalancingScheme())}
+		if hcs := bs.GetHealthChecks(); len(hcs) > 0 {
+			details = append(details, "health check "+lastSegment(hcs[0]))
+		}
+		return details, nil
+	})
+}
+
+func (b *Builder) serviceAttachment(ctx context.Context, id string, cfg *config.Config) (*Node, error) {
+	return describe(id, "service attachment", cfg.ServiceAttachment, func() ([]string, error) {
+		sa, err := b.serviceAttachmentClient.Get(ctx, &computepb.GetServiceAttachmentRequest{
+			Project: cfg.ProjectID, Region: cfg.Region, ServiceAttachment: cfg.ServiceAttachment,
+		})
+		if err != nil {
+			return nil, err
+		}
+		details := []string{strings.ToLower(sa.GetConnectionPreference())}
+		accepted := 0
+		for _, ep := range sa.GetConnectedEndpoints() {
+			if ep.GetStatus() == "ACCEPTED" {
+				accepted++
+			}
+		}
+		details = append(details, fmt.Sprintf("%d of %d endpoints accepted", accepted, len(sa.GetConnectedEndpoints())))
+		return details, nil
+	})
+}
+
+// endpoint describes the PSC endpoint by its address and forwarding rule,
+// missing when the forwarding rule is
+func (b *Builder) endpoint(ctx context.Context, id string, cfg *config.Config) (*Node, error) {
+	return describe(id, "PSC endpoint", cfg.PSCForwardingRule, func() ([]string, error) {
+		rule, err := b.forwardingRuleClient.Get(ctx, &computepb.GetForwardingRuleRequest{
+			Project: cfg.ProjectID, Region: cfg.Region, ForwardingRule: cfg.PSCForwardingRule,
+		})
+		if err != nil {
+			return nil, err
+		}
+		details := []string{"IP " + rule.GetIPAddress()}
+		if status := rule.GetPscConnectionStatus(); status != "" {
+			details = append(details, "connection "+status)
+		}
+		if rule.GetAllowPscGlobalAccess() {
+			details = append(details, "global access")
+		}
+		return details, nil
+	})
+}
+
+func (b *Builder) vpnGateway(ctx context.Context, id, name string) (*Node, error) {
+	return describe(id, "HA VPN gateway", name, func() ([]string, error) {
+		gateway, err := b.vpnGatewayClient.Get(ctx, &computepb.GetVpnGatewayRequest{
+			Project: b.config.ProjectID, Region: b.config.Region, VpnGateway: name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var ips []string
+		for _, i := range gateway.GetVpnInterfaces() {
+			ips = append(ips, i.GetIpAddress())
+		}
+		return []string{"interfaces " + strings.Join(ips, ", ")}, nil
+	})
+}
+
+// vpnTunnels summarizes the status of the tunnels of a gateway, e.g.
+// "HA VPN, 2/2 tunnels ESTABLISHED"
+func (b *Builder) vpnTunnels(ctx context.Context, gateway string) (string, error) {
+	names := b.config.VPNTunnels(gateway)
+	established := 0
+	for _, name := range names {
+		tunnel, err := b.vpnTunnelClient.Get(ctx, &computepb.GetVpnTunnelRequest{
+			Project: b.config.ProjectID, Region: b.config.Region, VpnTunnel: name,
+		})
+		switch {
+		case err == nil:
+			if tunnel.GetStatus() == "ESTABLISHED" {
+				established++
+			}
+		case !isNotFound(err):
+			return "", fmt.Errorf("failed to get VPN tunnel %s: %v", name, err)
+		}
+	}
+	return fmt.Sprintf("HA VPN, %d/%d tunnels ESTABLISHED", established, len(names)), nil
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func lastSegment(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
@@ -0,0 +1,345 @@
+package diagram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+	"gcp-psc-demo/pkg/state"
+)
+
+const testProject = "test-project"
+
+var testNow = time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+func testConfig(t *testing.T, args ...string) *config.Config {
+	t.Helper()
+	cfg, err := config.Load("test", append([]string{"--project", testProject}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// seedRun creates the resources of a run in the fake
+func seedRun(fake *fakecompute.Server, cfg *config.Config) {
+	regional := "regions/" + cfg.Region + "/"
+	zonal := "zones/" + cfg.Zone + "/"
+
+	fake.Put("global/networks", cfg.ProviderVPC, map[string]any{"routingConfig": map[string]any{"routingMode": "REGIONAL"}})
+	fake.Put("global/networks", cfg.ConsumerVPC, nil)
+	fake.Put(regional+"subnetworks", cfg.ProviderSubnet, map[string]any{"ipCidrRange": "10.0.1.0/24"})
+	fake.Put(regional+"subnetworks", cfg.ConsumerSubnet, map[string]any{"ipCidrRange": "10.1.1.0/24"})
+	fake.Put(zonal+"instances", cfg.ProviderVM, map[string]any{"networkInterfaces": []any{map[string]any{"networkIP": "10.0.1.2"}}})
+	fake.Put(zonal+"instances", cfg.ConsumerVM, nil)
+	fake.Put(zonal+"instanceGroups", cfg.InstanceGroup, map[string]any{"size": 1})
+	fake.Put(regional+"backendServices", cfg.BackendService, map[string]any{
+		"loadBalancingScheme": "INTERNAL",
+		"healthChecks":        []any{"https://compute.googleapis.com/compute/v1/projects/test-project/global/healthChecks/" + cfg.HealthCheck},
+	})
+	fake.Put(regional+"forwardingRules", cfg.ForwardingRule, map[string]any{"IPAddress": "10.0.1.10", "ports": []any{"80"}})
+	if cfg.ConnectivityBackend != config.BackendPSC {
+		return
+	}
+	fake.Put(regional+"subnetworks", cfg.PSCNATSubnet, map[string]any{"ipCidrRange": "10.0.2.0/24", "purpose": "PRIVATE_SERVICE_CONNECT"})
+	fake.Put(regional+"serviceAttachments", cfg.ServiceAttachment, map[string]any{
+		"connectionPreference": "ACCEPT_AUTOMATIC",
+		"connectedEndpoints":   []any{map[string]any{"status": "ACCEPTED"}},
+	})
+	fake.Put(regional+"forwardingRules", cfg.PSCForwardingRule, map[string]any{"pscConnectionStatus": "ACCEPTED"})
+}
+
+func newTestBuilder(t *testing.T, fake *fakecompute.Server, cfg *config.Config, st *state.State) *Builder {
+	t.Helper()
+	b, err := NewBuilder(cfg, st, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewBuilder() error = %v", err)
+	}
+	t.Cleanup(b.Close)
+	b.now = func() time.Time { return testNow }
+	return b
+}
+
+// index returns the nodes and groups of a diagram by ID
+func index(d *Diagram) (map[string]*Node, map[string]*Group) {
+	nodes, groups := map[string]*Node{}, map[string]*Group{}
+	var walk func(g *Group)
+	walk = func(g *Group) {
+		groups[g.ID] = g
+		for _, n := range g.Nodes {
+			nodes[n.ID] = n
+		}
+		for _, sub := range g.Groups {
+			walk(sub)
+		}
+	}
+	for _, g := range d.Groups {
+		walk(g)
+	}
+	return nodes, groups
+}
+
+func hasEdge(d *Diagram, from, to, label string) bool {
+	for _, e := range d.Edges {
+		if e.From == from && e.To == to && e.Label == label {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuild_PSC(t *testing.T) {
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+	cfg := testConfig(t)
+	seedRun(fake, cfg)
+	st := state.FromConfig(cfg)
+	st.CreatedAt = testNow.Add(-time.Hour)
+
+	d, err := newTestBuilder(t, fake, cfg, st).Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	nodes, groups := index(d)
+	for _, id := range []string{"provider-vm", "ilb", "backend-service", "instance-group", "service-attachment", "psc-nat", "psc-endpoint", "consumer-vm"} {
+		n, ok := nodes[id]
+		if !ok {
+			t.Errorf("no %s node", id)
+			continue
+		}
+		if n.Missing {
+			t.Errorf("%s is missing", id)
+		}
+	}
+	if got := groups["provider-vpc"].Label; !strings.Contains(got, "regional routing") {
+		t.Errorf("provider VPC label = %q, want its routing mode", got)
+	}
+	if got := groups["psc-nat-subnet"].Label; !strings.Contains(got, "10.0.2.0/24") || !strings.Contains(got, "PRIVATE_SERVICE_CONNECT") {
+		t.Errorf("NAT subnet label = %q, want its range and purpose", got)
+	}
+	if got := strings.Join(nodes["service-attachment"].Details, "; "); got != "accept_automatic; 1 of 1 endpoints accepted" {
+		t.Errorf("service attachment details = %q", got)
+	}
+	port := fmt.Sprintf("TCP :%d", cfg.ServicePort)
+	for _, e := range [][3]string{
+		{"consumer-vm", "psc-endpoint", port},
+		{"psc-endpoint", "service-attachment", "Private Service Connect"},
+		{"service-attachment", "psc-nat", "source NAT"},
+		{"psc-nat", "ilb", ""},
+		{"instance-group", "provider-vm", port},
+	} {
+		if !hasEdge(d, e[0], e[1], e[2]) {
+			t.Errorf("no edge %s -> %s %q", e[0], e[1], e[2])
+		}
+	}
+	if len(d.Notes) != 3 || d.Notes[1] != "run started 2026-03-02T11:00:00Z" || !strings.HasSuffix(d.Notes[2], "2026-03-02T12:00:00Z") {
+		t.Errorf("notes = %q", d.Notes)
+	}
+}
+
+func TestBuild_PartialRun(t *testing.T) {
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+	cfg := testConfig(t)
+	// Torn down up to the load balancer
+	regional := "regions/" + cfg.Region + "/"
+	fake.Put("global/networks", cfg.ProviderVPC, nil)
+	fake.Put("global/networks", cfg.ConsumerVPC, nil)
+	fake.Put(regional+"subnetworks", cfg.ProviderSubnet, nil)
+	fake.Put(regional+"subnetworks", cfg.PSCNATSubnet, nil)
+	fake.Put(regional+"subnetworks", cfg.ConsumerSubnet, nil)
+	fake.Put("zones/"+cfg.Zone+"/instances", cfg.ProviderVM, nil)
+	fake.Put("zones/"+cfg.Zone+"/instances", cfg.ConsumerVM, nil)
+
+	d, err := newTestBuilder(t, fake, cfg, nil).Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	nodes, _ := index(d)
+	for id, missing := range map[string]bool{
+		"provider-vm":        false,
+		"consumer-vm":        false,
+		"ilb":                true,
+		"service-attachment": true,
+		"psc-endpoint":       true,
+	} {
+		if nodes[id].Missing != missing {
+			t.Errorf("%s missing = %v, want %v", id, nodes[id].Missing, missing)
+		}
+	}
+	if len(d.Notes) != 2 {
+		t.Errorf("notes = %q, want no run start without a state file", d.Notes)
+	}
+
+	var out bytes.Buffer
+	if err := WriteDOT(&out, d); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"service-attachment" [label="service attachment\n`+cfg.ServiceAttachment+`\n(not found)", style="rounded,dashed"`) {
+		t.Errorf("missing service attachment not dashed:\n%s", out.String())
+	}
+}
+
+func TestBuild_Error(t *testing.T) {
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+	cfg := testConfig(t)
+	seedRun(fake, cfg)
+	fake.Fail("GET", "serviceAttachments", http.StatusForbidden, "forbidden", 1)
+
+	if _, err := newTestBuilder(t, fake, cfg, nil).Build(context.Background()); err == nil || !strings.Contains(err.Error(), "service attachment") {
+		t.Errorf("Build() error = %v, want the failed lookup rather than a missing resource", err)
+	}
+}
+
+func TestBuild_Peering(t *testing.T) {
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+	cfg := testConfig(t, "--connectivity-backend", config.BackendPeering)
+	seedRun(fake, cfg)
+	fake.Put("global/networks", cfg.ConsumerVPC, map[string]any{"peerings": []any{map[string]any{
+		"network": "https://compute.googleapis.com/compute/v1/projects/test-project/global/networks/" + cfg.ProviderVPC,
+		"state":   "ACTIVE",
+	}}})
+
+	d, err := newTestBuilder(t, fake, cfg, nil).Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	nodes, _ := index(d)
+	if _, ok := nodes["service-attachment"]; ok {
+		t.Error("peering run has a service attachment")
+	}
+	if !hasEdge(d, "consumer-vm", "ilb", "VPC peering ACTIVE") {
+		t.Errorf("edges = %+v, want the consumer reaching the load balancer over the peering", d.Edges)
+	}
+}
+
+func TestBuild_VPN(t *testing.T) {
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+	cfg := testConfig(t, "--connectivity-backend", config.BackendVPN)
+	seedRun(fake, cfg)
+	regional := "regions/" + cfg.Region + "/"
+	interfaces := map[string]any{"vpnInterfaces": []any{
+		map[string]any{"id": 0, "ipAddress": "203.0.113.10"},
+		map[string]any{"id": 1, "ipAddress": "203.0.113.11"},
+	}}
+	fake.Put(regional+"vpnGateways", cfg.ProviderVPNGateway, interfaces)
+	fake.Put(regional+"vpnGateways", cfg.ConsumerVPNGateway, interfaces)
+	// One tunnel up, one not created
+	tunnels := cfg.VPNTunnels(cfg.ConsumerVPNGateway)
+	fake.Put(regional+"vpnTunnels", tunnels[0], map[string]any{"status": "ESTABLISHED"})
+
+	d, err := newTestBuilder(t, fake, cfg, nil).Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	nodes, _ := index(d)
+	if got := nodes["provider-vpn-gateway"].Details; len(got) != 1 || got[0] != "interfaces 203.0.113.10, 203.0.113.11" {
+		t.Errorf("gateway details = %q", got)
+	}
+	label := fmt.Sprintf("HA VPN, 1/%d tunnels ESTABLISHED", len(tunnels))
+	if !hasEdge(d, "consumer-vpn-gateway", "provider-vpn-gateway", label) {
+		t.Errorf("edges = %+v, want the tunnels %q", d.Edges, label)
+	}
+}
+
+func TestBuild_SecondaryRegionAndExtraConsumers(t *testing.T) {
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+	cfg := testConfig(t, "--secondary-region", "us-east1")
+	seedRun(fake, cfg)
+	secondary, err := cfg.Secondary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedRun(fake, secondary)
+	st := state.FromConfig(cfg)
+	st.ExtraConsumers = 1
+
+	d, err := newTestBuilder(t, fake, cfg, st).Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	nodes, groups := index(d)
+	if n := nodes["r2-service-attachment"]; n == nil || n.Missing {
+		t.Errorf("secondary service attachment = %+v", n)
+	}
+	if !hasEdge(d, "consumer-vm", "r2-psc-endpoint", "failover, PSC global access") {
+		t.Error("no failover edge to the secondary endpoint")
+	}
+	// The extra consumer was not created in the fake
+	if g := groups["c2-consumer-vpc"]; g == nil || !g.Missing {
+		t.Errorf("extra consumer VPC = %+v, want it drawn as missing", g)
+	}
+	if !hasEdge(d, "c2-psc-endpoint", "service-attachment", "Private Service Connect") {
+		t.Error("no edge from the extra consumer endpoint")
+	}
+
+	// Every edge connects drawn resources
+	var out bytes.Buffer
+	if err := WriteD2(&out, d); err != nil {
+		t.Fatalf("WriteD2() error = %v", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	d := &Diagram{
+		Title: `run "a"`,
+		Notes: []string{"project p"},
+		Groups: []*Group{{
+			ID: "vpc", Label: "VPC",
+			Nodes:  []*Node{{ID: "vm", Kind: "VM", Name: "vm-1", Details: []string{"RUNNING"}}},
+			Groups: []*Group{{ID: "subnet", Label: "subnet", Missing: true, Nodes: []*Node{{ID: "ilb", Kind: "ILB", Name: "ilb-1", Missing: true}}}},
+		}},
+		Edges: []Edge{{From: "vm", To: "ilb", Label: "TCP :80", Flow: true}},
+	}
+
+	var dot bytes.Buffer
+	if err := Write(&dot, d, FormatDOT); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`label="run \"a\"\nproject p";`,
+		`subgraph "cluster_vpc" {`,
+		`    subgraph "cluster_subnet" {`,
+		`      label="subnet (not found)";`,
+		`"vm" [label="VM\nvm-1\nRUNNING"];`,
+		`"vm" -> "ilb" [label="TCP :80", color="#1a73e8", penwidth=2];`,
+	} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("DOT has no %s:\n%s", want, dot.String())
+		}
+	}
+
+	var d2 bytes.Buffer
+	if err := Write(&d2, d, FormatD2); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`title: "run \"a\"\nproject p" {`,
+		`"vpc": "VPC" {`,
+		`  "subnet": "subnet (not found)" {`,
+		`    "ilb": "ILB\nilb-1\n(not found)" {`,
+		`"vpc"."vm" -> "vpc"."subnet"."ilb": "TCP :80" {`,
+	} {
+		if !strings.Contains(d2.String(), want) {
+			t.Errorf("D2 has no %s:\n%s", want, d2.String())
+		}
+	}
+
+	if err := Write(&d2, d, "mermaid"); err == nil {
+		t.Error("Write() accepted an unknown format")
+	}
+	d.Edges = append(d.Edges, Edge{From: "vm", To: "nowhere"})
+	if err := WriteD2(&d2, d); err == nil {
+		t.Error("WriteD2() accepted an edge to a resource not in the diagram")
+	}
+}
@@ -0,0 +1,173 @@
+package diagram
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formats of the diagram source
+const (
+	// FormatDOT is Graphviz, rendered with e.g. `dot -Tsvg`
+	FormatDOT = "dot"
+	// FormatD2 is D2, rendered with e.g. `d2 diagram.d2 diagram.svg`
+	FormatD2 = "d2"
+)
+
+// flowColor draws the path of the consumer's requests
+const flowColor = "#1a73e8"
+
+// Write writes the source of the diagram in format
+func Write(w io.Writer, d *Diagram, format string) error {
+	switch format {
+	case FormatDOT:
+		return WriteDOT(w, d)
+	case FormatD2:
+		return WriteD2(w, d)
+	}
+	return fmt.Errorf("unknown diagram format %q, want %s or %s", format, FormatDOT, FormatD2)
+}
+
+// label is the text of a node: kind, name and details, one per line
+func (n *Node) label() []string {
+	lines := []string{n.Kind, n.Name}
+	if n.Missing {
+		return append(lines, "(not found)")
+	}
+	return append(lines, n.Details...)
+}
+
+func (g *Group) label() string {
+	if g.Missing {
+		return g.Label + " (not found)"
+	}
+	return g.Label
+}
+
+// WriteDOT writes the diagram as a Graphviz digraph, with the VPCs and
+// subnets as clusters, missing resources dashed and the request path in blue
+func WriteDOT(w io.Writer, d *Diagram) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph psc_demo {\n")
+	fmt.Fprintf(&b, "  label=%s;\n", dotQuote(append([]string{d.Title}, d.Notes...)...))
+	fmt.Fprintf(&b, "  labelloc=t;\n  rankdir=LR;\n  fontname=\"Helvetica\";\n")
+	fmt.Fprintf(&b, "  node [shape=box, style=rounded, fontname=\"Helvetica\", fontsize=10];\n")
+	fmt.Fprintf(&b, "  edge [fontname=\"Helvetica\", fontsize=9];\n")
+	for _, g := range d.Groups {
+		writeDOTGroup(&b, g, "  ")
+	}
+	for _, e := range d.Edges {
+		attrs := []string{}
+		if e.Label != "" {
+			attrs = append(attrs, "label="+dotQuote(e.Label))
+		}
+		if e.Flow {
+			attrs = append(attrs, fmt.Sprintf("color=%q", flowColor), "penwidth=2")
+		} else {
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(&b, "  %s -> %s [%s];\n", dotQuote(e.From), dotQuote(e.To), strings.Join(attrs, ", "))
+	}
+	fmt.Fprintf(&b, "}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeDOTGroup(b *strings.Builder, g *Group, indent string) {
+	fmt.Fprintf(b, "%ssubgraph %s {\n", indent, dotQuote("cluster_"+g.ID))
+	fmt.Fprintf(b, "%s  label=%s;\n", indent, dotQuote(g.label()))
+	if g.Missing {
+		fmt.Fprintf(b, "%s  style=\"rounded,dashed\";\n%s  color=gray;\n", indent, indent)
+	} else {
+		fmt.Fprintf(b, "%s  style=rounded;\n", indent)
+	}
+	for _, n := range g.Nodes {
+		attrs := "label=" + dotQuote(n.label()...)
+		if n.Missing {
+			attrs += `, style="rounded,dashed", color=gray, fontcolor=gray`
+		}
+		fmt.Fprintf(b, "%s  %s [%s];\n", indent, dotQuote(n.ID), attrs)
+	}
+	for _, sub := range g.Groups {
+		writeDOTGroup(b, sub, indent+"  ")
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// dotQuote quotes lines as one DOT string
+func dotQuote(lines ...string) string {
+	for i, line := range lines {
+		lines[i] = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(line)
+	}
+	return `"` + strings.Join(lines, `\n`) + `"`
+}
+
+// WriteD2 writes the diagram as D2, with the VPCs and subnets as containers,
+// missing resources dashed and the request path in blue
+func WriteD2(w io.Writer, d *Diagram) error {
+	// D2 edges name nodes by their path through the containers
+	paths := map[string]string{}
+	var collect func(g *Group, parent string)
+	collect = func(g *Group, parent string) {
+		path := parent + d2Quote(g.ID)
+		for _, n := range g.Nodes {
+			paths[n.ID] = path + "." + d2Quote(n.ID)
+		}
+		for _, sub := range g.Groups {
+			collect(sub, path+".")
+		}
+	}
+	for _, g := range d.Groups {
+		collect(g, "")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "direction: right\n")
+	fmt.Fprintf(&b, "title: %s {\n  near: top-center\n  shape: text\n}\n", d2Quote(append([]string{d.Title}, d.Notes...)...))
+	for _, g := range d.Groups {
+		writeD2Group(&b, g, "")
+	}
+	for _, e := range d.Edges {
+		from, to := paths[e.From], paths[e.To]
+		if from == "" || to == "" {
+			return fmt.Errorf("edge %s -> %s refers to a resource not in the diagram", e.From, e.To)
+		}
+		fmt.Fprintf(&b, "%s -> %s", from, to)
+		if e.Label != "" {
+			fmt.Fprintf(&b, ": %s", d2Quote(e.Label))
+		}
+		if e.Flow {
+			fmt.Fprintf(&b, " {\n  style.stroke: %q\n  style.stroke-width: 3\n}\n", flowColor)
+		} else {
+			fmt.Fprintf(&b, " {\n  style.stroke-dash: 3\n}\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeD2Group(b *strings.Builder, g *Group, indent string) {
+	fmt.Fprintf(b, "%s%s: %s {\n", indent, d2Quote(g.ID), d2Quote(g.label()))
+	if g.Missing {
+		fmt.Fprintf(b, "%s  style.stroke-dash: 3\n", indent)
+	}
+	for _, n := range g.Nodes {
+		fmt.Fprintf(b, "%s  %s: %s", indent, d2Quote(n.ID), d2Quote(n.label()...))
+		if n.Missing {
+			fmt.Fprintf(b, " {\n%s    style.stroke-dash: 3\n%s    style.font-color: gray\n%s  }", indent, indent, indent)
+		}
+		fmt.Fprintf(b, "\n")
+	}
+	for _, sub := range g.Groups {
+		writeD2Group(b, sub, indent+"  ")
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// d2Quote quotes lines as one D2 double-quoted string
+func d2Quote(lines ...string) string {
+	for i, line := range lines {
+		lines[i] = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(line)
+	}
+	return `"` + strings.Join(lines, `\n`) + `"`
+}
@@ -0,0 +1,294 @@
+// Package failover measures what a consumer sees when the primary region of
+// a multi-region PSC deployment fails. The consumer VM probes the PSC
+// endpoints of both regions once per interval while the provider VM of the
+// primary region is stopped and started again, and the report derives how
+// long a client pinned to the primary region and a client failing over to
+// the secondary one were without the API server.
+package failover
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcloud"
+	"github.com/fatih/color"
+)
+
+// Options controls a failover test
+type Options struct {
+	// Interval is the time between probes, and the timeout of each probe
+	Interval time.Duration
+	// Baseline is how long both regions are probed before the primary one
+	// is disabled
+	Baseline time.Duration
+	// Outage is how long the primary region stays disabled once its
+	// provider VM is stopped
+	Outage time.Duration
+	// Recovery is how long the regions are probed after the primary one is
+	// enabled again
+	Recovery time.Duration
+}
+
+// Events are the times the primary region was disabled and enabled again,
+// on the clock of the machine running the test
+type Events struct {
+	DisableStart time.Time
+	Disabled     time.Time
+	EnableStart  time.Time
+	Enabled      time.Time
+}
+
+// Sample is one probe of both endpoints, on the clock of the consumer VM
+type Sample struct {
+	Time      time.Time
+	Primary   bool
+	Secondary bool
+}
+
+// Tester disables the primary region while the consumer VM probes both
+type Tester struct {
+	primary   *config.Config
+	secondary *config.Config
+	opts      Options
+}
+
+// NewTester validates the options and returns a tester of the regions of
+// primary and its secondary configuration
+func NewTester(primary, secondary *config.Config, opts Options) (*Tester, error) {
+	if opts.Interval < 500*time.Millisecond {
+		return nil, fmt.Errorf("probe interval must be at least 500ms")
+	}
+	if opts.Baseline < opts.Interval || opts.Outage < opts.Interval || opts.Recovery < opts.Interval {
+		return nil, fmt.Errorf("baseline, outage and recovery must each be at least the probe interval")
+	}
+	return &Tester{primary: primary, secondary: secondary, opts: opts}, nil
+}
+
+// Endpoints returns the addresses of the PSC endpoints of both regions
+func (t *Tester) Endpoints() (primary, secondary string, err error) {
+	for _, e := range []struct {
+		cfg  *config.Config
+		addr *string
+	}{
+		{t.primary, &primary},
+		{t.secondary, &secondary},
+	} {
+		*e.addr = gcloud.Lookup(context.Background(), t.primary.ProjectID, "forwarding-rules", "describe", e.cfg.PSCForwardingRule,
+			"--region", e.cfg.Region, "--format", "value(IPAddress)")
+		if *e.addr == "" {
+			return "", "", fmt.Errorf("PSC endpoint %s not found in %s", e.cfg.PSCForwardingRule, e.cfg.Region)
+		}
+	}
+	return primary, secondary, nil
+}
+
+// CheckEndpoints probes both endpoints once from the consumer VM and fails
+// unless both answer
+func (t *Tester) CheckEndpoints() error {
+	primary, secondary, err := t.Endpoints()
+	if err != nil {
+		return err
+	}
+	return t.check(primary, secondary)
+}
+
+// check probes the endpoints at primary and secondary once
+func (t *Tester) check(primary, secondary string) error {
+	// A limit below the interval stops the probe after one sample
+	output, err := gcloud.SSH(context.Background(), t.primary, t.primary.ConsumerVM, t.probeCommand(primary, secondary, t.opts.Interval/2, "/nonexistent"))
+	if err != nil {
+		return fmt.Errorf("probe on %s failed: %v", t.primary.ConsumerVM, err)
+	}
+	samples, err := ParseSamples(output)
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("probe on %s returned no sample", t.primary.ConsumerVM)
+	}
+	s := samples[0]
+	if !s.Primary || !s.Secondary {
+		return fmt.Errorf("endpoints not answering: %s %s %s, %s %s %s",
+			t.primary.Region, primary, status(s.Primary), t.secondary.Region, secondary, status(s.Secondary))
+	}
+	fmt.Printf("Both endpoints answer: %s %s, %s %s\n", t.primary.Region, primary, t.secondary.Region, secondary)
+	return nil
+}
+
+// Run probes both endpoints through the baseline, stops the provider VM of
+// the primary region, keeps it stopped for the outage, starts it again and
+// probes through the recovery. The provider VM is started again even when
+// the test fails half way.
+func (t *Tester) Run() (*Report, error) {
+	primary, secondary, err := t.Endpoints()
+	if err != nil {
+		return nil, err
+	}
+	// Disabling the primary region only measures a total outage unless the
+	// secondary one serves
+	if err := t.check(primary, secondary); err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		RunID:             t.primary.RunID,
+		PrimaryRegion:     t.primary.Region,
+		SecondaryRegion:   t.secondary.Region,
+		PrimaryEndpoint:   primary,
+		SecondaryEndpoint: secondary,
+		Interval:          t.opts.Interval,
+	}
+
+	// The probe stops when the stop file appears, or after a limit leaving
+	// room for slow stops and starts
+	stopFile := fmt.Sprintf("/tmp/psc-failover-%d.stop", time.Now().UnixNano())
+	limit := t.opts.Baseline + t.opts.Outage + t.opts.Recovery + 10*time.Minute
+
+	var (
+		wg       sync.WaitGroup
+		output   string
+		probeErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		output, probeErr = gcloud.SSH(context.Background(), t.primary, t.primary.ConsumerVM, t.probeCommand(primary, secondary, limit, stopFile))
+	}()
+
+	vm := t.primary.ProviderVM
+	color.Blue("=== Baseline: probing both regions for %s ===", t.opts.Baseline)
+	time.Sleep(t.opts.Baseline)
+
+	color.Blue("=== Disabling %s: stopping provider VM %s ===", t.primary.Region, vm)
+	report.Events.DisableStart = time.Now()
+	if err := t.instances("stop", vm); err != nil {
+		color.Yellow("⚠ Stopping %s failed, starting it again: %v", vm, err)
+		if err := t.enable(report); err != nil {
+			color.Yellow("⚠ %v", err)
+		}
+		t.stopProbe(stopFile)
+		wg.Wait()
+		return nil, fmt.Errorf("failed to stop provider VM %s: %v", vm, err)
+	}
+	report.Events.Disabled = time.Now()
+	fmt.Printf("Provider VM %s stopped after %s\n", vm, report.Events.Disabled.Sub(report.Events.DisableStart).Round(time.Second))
+
+	color.Blue("=== Outage: %s stays disabled for %s ===", t.primary.Region, t.opts.Outage)
+	time.Sleep(t.opts.Outage)
+
+	color.Blue("=== Enabling %s: starting provider VM %s ===", t.primary.Region, vm)
+	if err := t.enable(report); err != nil {
+		color.Yellow("⚠ %v", err)
+	}
+
+	color.Blue("=== Recovery: probing both regions for %s ===", t.opts.Recovery)
+	time.Sleep(t.opts.Recovery)
+
+	t.stopProbe(stopFile)
+	wg.Wait()
+	if probeErr != nil {
+		return nil, fmt.Errorf("probe on %s failed: %v", t.primary.ConsumerVM, probeErr)
+	}
+	if report.Samples, err = ParseSamples(output); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// enable starts the provider VM of the primary region again, recording when
+func (t *Tester) enable(report *Report) error {
+	report.Events.EnableStart = time.Now()
+	if err := t.instances("start", t.primary.ProviderVM); err != nil {
+		return fmt.Errorf("failed to start provider VM %s, start it by hand: %v", t.primary.ProviderVM, err)
+	}
+	report.Events.Enabled = time.Now()
+	return nil
+}
+
+// stopProbe asks the probe on the consumer VM to exit
+func (t *Tester) stopProbe(stopFile string) {
+	if _, err := gcloud.SSH(context.Background(), t.primary, t.primary.ConsumerVM, "touch "+stopFile); err != nil {
+		color.Yellow("⚠ Could not stop the probe, it exits on its own after its limit: %v", err)
+	}
+}
+
+// probeCommand runs probeScript against both endpoints for up to limit
+func (t *Tester) probeCommand(primary, secondary string, limit time.Duration, stopFile string) string {
+	return fmt.Sprintf("python3 -c %s %s %s %d %.3f %.1f %s", gcloud.ShellQuote(probeScript),
+		primary, secondary, t.primary.ServicePort, t.opts.Interval.Seconds(), limit.Seconds(), stopFile)
+}
+
+// probeScript requests /healthz of both endpoints in parallel once per
+// interval, each request bounded by the interval, and prints one line per
+// sample: the epoch time, then 1 or 0 for each endpoint. It exits after
+// limit seconds or once the stop file exists.
+const probeScript = `
+import http.client, os, ssl, sys, threading, time
+hosts, port, interval, limit, stop = sys.argv[1:3], int(sys.argv[3]), float(sys.argv[4]), float(sys.argv[5]), sys.argv[6]
+ctx = ssl.create_default_context()
+ctx.check_hostname = False
+ctx.verify_mode = ssl.CERT_NONE
+def probe(host, out, i):
+    try:
+        c = http.client.HTTPSConnection(host, port, timeout=interval, context=ctx)
+        c.request("GET", "/healthz")
+        out[i] = 1 if c.getresponse().status == 200 else 0
+        c.close()
+    except Exception:
+        out[i] = 0
+start, n = time.time(), 0
+while time.time() - start < limit and not os.path.exists(stop):
+    t, out = time.time(), [0, 0]
+    threads = [threading.Thread(target=probe, args=(h, out, i)) for i, h in enumerate(hosts)]
+    for th in threads:
+        th.start()
+    for th in threads:
+        th.join()
+    print("%.3f %d %d" % (t, out[0], out[1]), flush=True)
+    n += 1
+    time.sleep(max(0, start + n * interval - time.time()))
+`
+
+// ParseSamples reads the lines the probe script prints
+func ParseSamples(output string) ([]Sample, error) {
+	var samples []Sample
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected probe output %q", line)
+		}
+		epoch, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected probe time %q", fields[0])
+		}
+		samples = append(samples, Sample{
+			Time:      time.UnixMilli(int64(epoch * 1000)),
+			Primary:   fields[1] == "1",
+			Secondary: fields[2] == "1",
+		})
+	}
+	return samples, nil
+}
+
+// instances runs gcloud compute instances stop or start on a VM of the
+// primary zone, which waits for the operation
+func (t *Tester) instances(action, vmName string) error {
+	return gcloud.Run(context.Background(), "compute", "instances", action, vmName,
+		"--zone", t.primary.Zone,
+		"--project", t.primary.ProjectID)
+}
+
+func status(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "down"
+}
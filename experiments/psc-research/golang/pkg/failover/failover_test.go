@@ -0,0 +1,143 @@
+package failover
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+)
+
+// testReport is a failover test probed every second: the primary endpoint
+// fails 3s after the stop begins at 10s and answers again 4s after the start
+// begins at 30s, the secondary endpoint misses one probe during the outage
+func testReport() *Report {
+	t0 := time.Unix(1700000000, 0)
+	r := &Report{
+		RunID:    "test",
+		Interval: time.Second,
+		Events: Events{
+			DisableStart: t0.Add(10 * time.Second),
+			Disabled:     t0.Add(12 * time.Second),
+			EnableStart:  t0.Add(30 * time.Second),
+			Enabled:      t0.Add(32 * time.Second),
+		},
+	}
+	for i := 0; i < 40; i++ {
+		at := t0.Add(time.Duration(i) * time.Second)
+		r.Samples = append(r.Samples, Sample{
+			Time:      at,
+			Primary:   i < 13 || i >= 34,
+			Secondary: i != 20,
+		})
+	}
+	return r
+}
+
+func TestParseSamples(t *testing.T) {
+	samples, err := ParseSamples("1700000000.250 1 1\n1700000001.250 0 1\n\n")
+	if err != nil {
+		t.Fatalf("ParseSamples() error = %v", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("len(samples) = %d, want 2", len(samples))
+	}
+	if got := samples[0].Time.UnixMilli(); got != 1700000000250 {
+		t.Errorf("samples[0].Time = %d ms, want 1700000000250", got)
+	}
+	if s := samples[1]; s.Primary || !s.Secondary {
+		t.Errorf("samples[1] = %+v, want primary down and secondary up", s)
+	}
+
+	for _, output := range []string{"1700000000 1", "now 1 1"} {
+		if _, err := ParseSamples(output); err == nil {
+			t.Errorf("ParseSamples(%q) succeeded", output)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := testReport()
+
+	primary, secondary, samples := r.Availability(PhaseBaseline)
+	if samples != 10 || primary != 1 || secondary != 1 {
+		t.Errorf("baseline availability = %v, %v over %d samples, want 1, 1 over 10", primary, secondary, samples)
+	}
+	primary, secondary, samples = r.Availability(PhaseOutage)
+	if samples != 20 || primary != 3.0/20 || secondary != 19.0/20 {
+		t.Errorf("outage availability = %v, %v over %d samples, want 0.15, 0.95 over 20", primary, secondary, samples)
+	}
+
+	if d, ok := r.Detection(); !ok || d != 3*time.Second {
+		t.Errorf("Detection() = %s, %v, want 3s", d, ok)
+	}
+	if d, ok := r.Recovery(); !ok || d != 4*time.Second {
+		t.Errorf("Recovery() = %s, %v, want 4s", d, ok)
+	}
+
+	pinned, failover := r.Downtime()
+	if pinned != 21*time.Second || failover != time.Second {
+		t.Errorf("Downtime() = %s, %s, want 21s pinned and 1s with failover", pinned, failover)
+	}
+	if got := r.SecondaryFailures(); got != 1 {
+		t.Errorf("SecondaryFailures() = %d, want 1", got)
+	}
+	if r.Passed() {
+		t.Error("Passed() with a secondary failure during the outage")
+	}
+
+	var out bytes.Buffer
+	r.Write(&out)
+	for _, want := range []string{"unavailable for 21s", "unavailable for 1s", "failed 1 probes"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestReport_SecondaryServes(t *testing.T) {
+	r := testReport()
+	for i := range r.Samples {
+		r.Samples[i].Secondary = true
+	}
+
+	if !r.Passed() {
+		t.Error("Passed() = false with the secondary serving through the outage")
+	}
+	if _, failover := r.Downtime(); failover != 0 {
+		t.Errorf("failover downtime = %s, want 0", failover)
+	}
+}
+
+func TestReport_OutageNotObserved(t *testing.T) {
+	r := testReport()
+	for i := range r.Samples {
+		r.Samples[i].Primary = true
+	}
+
+	if _, ok := r.Detection(); ok {
+		t.Error("Detection() found a failure of an endpoint that never failed")
+	}
+	if _, ok := r.Recovery(); ok {
+		t.Error("Recovery() found a recovery of an endpoint that never failed")
+	}
+}
+
+func TestNewTester(t *testing.T) {
+	cfg := config.NewConfig()
+	valid := Options{Interval: time.Second, Baseline: 30 * time.Second, Outage: time.Minute, Recovery: time.Minute}
+	if _, err := NewTester(cfg, cfg, valid); err != nil {
+		t.Errorf("NewTester() error = %v", err)
+	}
+
+	short := valid
+	short.Interval = 100 * time.Millisecond
+	noOutage := valid
+	noOutage.Outage = 0
+	for _, opts := range []Options{short, noOutage} {
+		if _, err := NewTester(cfg, cfg, opts); err == nil {
+			t.Errorf("NewTester(%+v) succeeded", opts)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package failover
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Phases of a failover test
+const (
+	PhaseBaseline = "baseline"
+	PhaseOutage   = "outage"
+	PhaseRecovery = "recovery"
+)
+
+// Report is the outcome of a failover test
+type Report struct {
+	RunID             string
+	PrimaryRegion     string
+	SecondaryRegion   string
+	PrimaryEndpoint   string
+	SecondaryEndpoint string
+	Interval          time.Duration
+	Events            Events
+	Samples           []Sample
+}
+
+// Phase returns the phase a sample was taken in: the outage lasts from the
+// stop of the primary provider VM until its start
+func (r *Report) Phase(s Sample) string {
+	switch {
+	case s.Time.Before(r.Events.DisableStart):
+		return PhaseBaseline
+	case r.Events.EnableStart.IsZero() || s.Time.Before(r.Events.EnableStart):
+		return PhaseOutage
+	default:
+		return PhaseRecovery
+	}
+}
+
+// Availability returns the share of the samples of a phase each endpoint
+// answered, and the number of samples
+func (r *Report) Availability(phase string) (primary, secondary float64, samples int) {
+	var up [2]int
+	for _, s := range r.Samples {
+		if r.Phase(s) != phase {
+			continue
+		}
+		samples++
+		if s.Primary {
+			up[0]++
+		}
+		if s.Secondary {
+			up[1]++
+		}
+	}
+	if samples == 0 {
+		return 0, 0, 0
+	}
+	return float64(up[0]) / float64(samples), float64(up[1]) / float64(samples), samples
+}
+
+// Detection returns how long after the stop of its provider VM the primary
+// endpoint first failed, the time a client needs to notice the outage
+func (r *Report) Detection() (time.Duration, bool) {
+	for _, s := range r.Samples {
+		if r.Phase(s) != PhaseBaseline && !s.Primary {
+			return s.Time.Sub(r.Events.DisableStart), true
+		}
+	}
+	return 0, false
+}
+
+// Recovery returns how long after the start of its provider VM the primary
+// endpoint answered again
+func (r *Report) Recovery() (time.Duration, bool) {
+	failed := false
+	for _, s := range r.Samples {
+		switch {
+		case r.Phase(s) == PhaseBaseline:
+		case !s.Primary:
+			failed = true
+		case failed && r.Phase(s) == PhaseRecovery:
+			return s.Time.Sub(r.Events.EnableStart), true
+		}
+	}
+	return 0, false
+}
+
+// Downtime returns how long a client pinned to the primary endpoint and a
+// client failing over to the secondary one were without the API server. Each
+// failed sample counts until the next sample.
+func (r *Report) Downtime() (pinned, failover time.Duration) {
+	for i, s := range r.Samples {
+		span := r.Interval
+		if i+1 < len(r.Samples) {
+			span = r.Samples[i+1].Time.Sub(s.Time)
+		}
+		if !s.Primary {
+			pinned += span
+			if !s.Secondary {
+				failover += span
+			}
+		}
+	}
+	return pinned, failover
+}
+
+// SecondaryFailures returns the samples of the outage the secondary endpoint
+// did not answer, which a client could not fail over to
+func (r *Report) SecondaryFailures() int {
+	failures := 0
+	for _, s := range r.Samples {
+		if r.Phase(s) == PhaseOutage && !s.Secondary {
+			failures++
+		}
+	}
+	return failures
+}
+
+// Passed reports whether the secondary region served through the whole
+// outage of the primary one
+func (r *Report) Passed() bool {
+	_, _, samples := r.Availability(PhaseOutage)
+	return samples > 0 && r.SecondaryFailures() == 0
+}
+
+// Write prints the availability of both endpoints per phase and the impact
+// on consumers
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "PSC region failover of run %s\n", r.RunID)
+	fmt.Fprintf(w, "Primary %s endpoint %s, secondary %s endpoint %s, probed every %s\n\n",
+		r.PrimaryRegion, r.PrimaryEndpoint, r.SecondaryRegion, r.SecondaryEndpoint, r.Interval)
+
+	fmt.Fprintf(w, "  %-9s %8s %9s %10s\n", "phase", "samples", "primary", "secondary")
+	for _, phase := range []string{PhaseBaseline, PhaseOutage, PhaseRecovery} {
+		primary, secondary, samples := r.Availability(phase)
+		if samples == 0 {
+			fmt.Fprintf(w, "  %-9s %8d %9s %10s\n", phase, 0, "-", "-")
+			continue
+		}
+		fmt.Fprintf(w, "  %-9s %8d %8.1f%% %9.1f%%\n", phase, samples, 100*primary, 100*secondary)
+	}
+	fmt.Fprintln(w)
+
+	if !r.Events.Disabled.IsZero() {
+		fmt.Fprintf(w, "Provider VM stop took %s", r.Events.Disabled.Sub(r.Events.DisableStart).Round(time.Second))
+		if !r.Events.Enabled.IsZero() {
+			fmt.Fprintf(w, ", start took %s", r.Events.Enabled.Sub(r.Events.EnableStart).Round(time.Second))
+		}
+		fmt.Fprintln(w)
+	}
+	if d, ok := r.Detection(); ok {
+		fmt.Fprintf(w, "Primary endpoint failed %s after the stop began\n", d.Round(time.Second))
+	} else {
+		fmt.Fprintln(w, "Primary endpoint never failed: the outage was not observed")
+	}
+	if d, ok := r.Recovery(); ok {
+		fmt.Fprintf(w, "Primary endpoint answered again %s after the start began\n", d.Round(time.Second))
+	} else if _, failed := r.Detection(); failed {
+		fmt.Fprintln(w, "! Primary endpoint did not answer again before the end of the test")
+	}
+
+	pinned, failover := r.Downtime()
+	fmt.Fprintf(w, "Consumer pinned to %s: unavailable for %s\n", r.PrimaryRegion, pinned.Round(time.Second))
+	fmt.Fprintf(w, "Consumer failing over to %s: unavailable for %s\n", r.SecondaryRegion, failover.Round(time.Second))
+	if n := r.SecondaryFailures(); n > 0 {
+		fmt.Fprintf(w, "! Secondary endpoint failed %d probes during the outage\n", n)
+	}
+}
@@ -0,0 +1,555 @@
+// Package fakecompute is an in-memory stand-in for the subset of the Compute
+// Engine REST API the demo managers use. It lets the create / exists / wait
+// logic be tested hermetically by pointing the REST clients at an httptest
+// server with option.WithEndpoint.
+package fakecompute
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/option"
+)
+
+const (
+	apiPrefix  = "/compute/v1/projects/"
+	selfPrefix = "https://www.googleapis.com/compute/v1/projects/"
+)
+
+// Request records one call made against the fake
+type Request struct {
+	Method string
+	// Collection is the scoped collection, e.g. "global/networks" or "regions/us-central1/subnetworks"
+	Collection string
+	Name       string
+	// Action is the custom verb, e.g. "addInstances", or empty for plain CRUD
+	Action string
+}
+
+// failure is an injected error for requests matching method and collection kind
+type failure struct {
+	method string
+	kind   string
+	code   int
+	reason string
+	count  int
+}
+
+// Server is a fake Compute API backed by an httptest.Server
+type Server struct {
+	*httptest.Server
+
+	// PendingPolls is how many times an operation reports RUNNING before DONE
+	PendingPolls int
+
+	mu         sync.Mutex
+	project    string
+	resources  map[string]map[string]map[string]any
+	operations map[string]*operation
+	opErrors   map[string]string
+	failures   []*failure
+	health     []string
+	serial     map[string]string
+	requests   []Request
+	nextOp     int
+}
+
+type operation struct {
+	body    map[string]any
+	pending int
+}
+
+// New starts a fake for project. Call Close when done.
+func New(project string) *Server {
+	s := &Server{
+		project:    project,
+		resources:  map[string]map[string]map[string]any{},
+		operations: map[string]*operation{},
+		opErrors:   map[string]string{},
+		health:     []string{"HEALTHY"},
+		serial:     map[string]string{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// ClientOptions returns the options that point a Compute REST client at the fake
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.URL),
+		option.WithoutAuthentication(),
+		option.WithHTTPClient(s.Client()),
+	}
+}
+
+// Fail makes the next count requests with method against the kind of
+// collection (e.g. "networks") return an HTTP error with the given reason
+func (s *Server) Fail(method, kind string, code int, reason string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &failure{method: method, kind: kind, code: code, reason: reason, count: count})
+}
+
+// FailOperation makes operations that insert into the kind of collection
+// finish DONE with an error carrying code
+func (s *Server) FailOperation(kind, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opErrors[kind] = code
+}
+
+// SetBackendHealth sets the health states getHealth reports, one per backend
+// instance. With no states getHealth reports no status at all.
+func (s *Server) SetBackendHealth(states ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health = states
+}
+
+// SetSerialPortOutput sets what the serial console of an instance printed so
+// far, returned by getSerialPortOutput from the requested start offset
+func (s *Server) SetSerialPortOutput(instance, output string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serial[instance] = output
+}
+
+// Put stores a resource directly, as if it had been created earlier
+func (s *Server) Put(collection, name string, resource map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resource == nil {
+		resource = map[string]any{}
+	}
+	resource["name"] = name
+	resource["selfLink"] = s.selfLink(collection, name)
+	s.collection(collection)[name] = resource
+}
+
+// Get returns a stored resource, or nil when it does not exist
+func (s *Server) Get(collection, name string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resources[collection][name]
+}
+
+// Names returns the sorted names of the resources in a collection
+func (s *Server) Names(collection string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.resources[collection]))
+	for name := range s.resources[collection] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Requests returns every request received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Count returns how many requests with method hit the kind of collection
+// (e.g. "networks"). action narrows it to a custom verb when non-empty.
+func (s *Server) Count(method, kind, action string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if r.Method == method && collectionKind(r.Collection) == kind && r.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	req, ok := parsePath(s.project, r.Method, r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("unsupported path %s", r.URL.Path))
+		return
+	}
+
+	var body map[string]any
+	if r.Body != nil {
+		data, _ := io.ReadAll(r.Body)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &body); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid", err.Error())
+				return
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req)
+
+	if f := s.takeFailure(req); f != nil {
+		writeError(w, f.code, f.reason, fmt.Sprintf("injected %s error for %s %s", f.reason, req.Method, req.Collection))
+		return
+	}
+
+	kind := collectionKind(req.Collection)
+	switch {
+	case kind == "operations" && req.Method == http.MethodGet && req.Name != "":
+		s.getOperation(w, req)
+	case req.Action == "serialPort":
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		s.serialPort(w, req, start)
+	case req.Action != "":
+		s.action(w, req, body)
+	case req.Method == http.MethodGet && strings.HasPrefix(req.Collection, "aggregated/"):
+		s.aggregatedList(w, req)
+	case req.Method == http.MethodGet && req.Name == "":
+		s.list(w, req)
+	case req.Method == http.MethodGet:
+		s.get(w, req)
+	case req.Method == http.MethodPost && req.Name == "":
+		s.insert(w, req, body)
+	case req.Method == http.MethodPut || req.Method == http.MethodPatch:
+		s.update(w, req, body)
+	case req.Method == http.MethodDelete:
+		s.delete(w, req)
+	default:
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("unsupported %s %s", req.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) takeFailure(req Request) *failure {
+	for i, f := range s.failures {
+		if f.method == req.Method && f.kind == collectionKind(req.Collection) {
+			f.count--
+			if f.count <= 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+func (s *Server) get(w http.ResponseWriter, req Request) {
+	resource, ok := s.resources[req.Collection][req.Name]
+	if !ok {
+		writeNotFound(w, s.project, req)
+		return
+	}
+	writeJSON(w, resource)
+}
+
+func (s *Server) list(w http.ResponseWriter, req Request) {
+	names := make([]string, 0, len(s.resources[req.Collection]))
+	for name := range s.resources[req.Collection] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		items = append(items, s.resources[req.Collection][name])
+	}
+	writeJSON(w, map[string]any{"items": items})
+}
+
+// aggregatedList lists a kind of resource in every scope, keyed by scope
+// such as "zones/us-central1-a". Filters are ignored.
+func (s *Server) aggregatedList(w http.ResponseWriter, req Request) {
+	kind := collectionKind(req.Collection)
+	items := map[string]map[string][]map[string]any{}
+	for collection, resources := range s.resources {
+		if collectionKind(collection) != kind || len(resources) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(resources))
+		for name := range resources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		scope := strings.TrimSuffix(collection, "/"+kind)
+		for _, name := range names {
+			items[scope] = map[string][]map[string]any{kind: append(items[scope][kind], resources[name])}
+		}
+	}
+	writeJSON(w, map[string]any{"items": items})
+}
+
+func (s *Server) insert(w http.ResponseWriter, req Request, body map[string]any) {
+	name, _ := body["name"].(string)
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "required", "Required field 'resource.name' not specified")
+		return
+	}
+	if _, exists := s.resources[req.Collection][name]; exists {
+		writeError(w, http.StatusConflict, "alreadyExists",
+			fmt.Sprintf("The resource 'projects/%s/%s/%s' already exists", s.project, req.Collection, name))
+		return
+	}
+
+	body["selfLink"] = s.selfLink(req.Collection, name)
+	fillDefaults(collectionKind(req.Collection), body)
+	s.collection(req.Collection)[name] = body
+
+	writeJSON(w, s.newOperation(req, "insert", name))
+}
+
+func (s *Server) update(w http.ResponseWriter, req Request, body map[string]any) {
+	if _, ok := s.resources[req.Collection][req.Name]; !ok {
+		writeNotFound(w, s.project, req)
+		return
+	}
+	// PATCH only replaces the fields it sets, PUT the whole resource
+	if req.Method == http.MethodPatch {
+		for field, value := range body {
+			s.resources[req.Collection][req.Name][field] = value
+		}
+		writeJSON(w, s.newOperation(req, "patch", req.Name))
+		return
+	}
+	body["name"] = req.Name
+	body["selfLink"] = s.selfLink(req.Collection, req.Name)
+	s.resources[req.Collection][req.Name] = body
+
+	writeJSON(w, s.newOperation(req, "update", req.Name))
+}
+
+func (s *Server) delete(w http.ResponseWriter, req Request) {
+	if _, ok := s.resources[req.Collection][req.Name]; !ok {
+		writeNotFound(w, s.project, req)
+		return
+	}
+	delete(s.resources[req.Collection], req.Name)
+
+	writeJSON(w, s.newOperation(req, "delete", req.Name))
+}
+
+func (s *Server) action(w http.ResponseWriter, req Request, body map[string]any) {
+	resource, ok := s.resources[req.Collection][req.Name]
+	if !ok {
+		writeNotFound(w, s.project, req)
+		return
+	}
+
+	switch req.Action {
+	case "addInstances":
+		members, _ := resource["instances"].([]any)
+		if refs, ok := body["instances"].([]any); ok {
+			members = append(members, refs...)
+		}
+		resource["instances"] = members
+		resource["size"] = len(members)
+	case "listInstances":
+		items := []map[string]any{}
+		members, _ := resource["instances"].([]any)
+		for _, m := range members {
+			if ref, ok := m.(map[string]any); ok {
+				items = append(items, map[string]any{"instance": ref["instance"], "status": "RUNNING"})
+			}
+		}
+		writeJSON(w, map[string]any{"items": items})
+		return
+	case "setNamedPorts":
+		resource["namedPorts"] = body["namedPorts"]
+	case "setMetadata":
+		resource["metadata"] = body
+	case "addPeering":
+		// Both sides of a peering are added, so it is ACTIVE right away
+		peering, _ := body["networkPeering"].(map[string]any)
+		if peering == nil {
+			writeError(w, http.StatusBadRequest, "required", "Required field 'networkPeering' not specified")
+			return
+		}
+		peering["state"] = "ACTIVE"
+		peerings, _ := resource["peerings"].([]any)
+		resource["peerings"] = append(peerings, peering)
+	case "removePeering":
+		peerings, _ := resource["peerings"].([]any)
+		kept := []any{}
+		for _, p := range peerings {
+			if peering, ok := p.(map[string]any); !ok || peering["name"] != body["name"] {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == len(peerings) {
+			writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("There is no peering named '%v' in network %s", body["name"], req.Name))
+			return
+		}
+		resource["peerings"] = kept
+	case "getRouterStatus":
+		// Every configured BGP session is UP
+		statuses := []map[string]any{}
+		peers, _ := resource["bgpPeers"].([]any)
+		for _, p := range peers {
+			if peer, ok := p.(map[string]any); ok {
+				statuses = append(statuses, map[string]any{"name": peer["name"], "status": "UP"})
+			}
+		}
+		writeJSON(w, map[string]any{"result": map[string]any{"bgpPeerStatus": statuses}})
+		return
+	case "getHealth":
+		statuses := make([]map[string]any, 0, len(s.health))
+		for i, state := range s.health {
+			statuses = append(statuses, map[string]any{
+				"instance":    s.selfLink("zones/fake/instances", fmt.Sprintf("backend-%d", i)),
+				"healthState": state,
+			})
+		}
+		writeJSON(w, map[string]any{"healthStatus": statuses})
+		return
+	}
+
+	writeJSON(w, s.newOperation(req, req.Action, req.Name))
+}
+
+func (s *Server) serialPort(w http.ResponseWriter, req Request, start int) {
+	if _, ok := s.resources[req.Collection][req.Name]; !ok {
+		writeNotFound(w, s.project, req)
+		return
+	}
+	output := s.serial[req.Name]
+	start = min(max(start, 0), len(output))
+	writeJSON(w, map[string]any{"contents": output[start:], "start": start, "next": len(output)})
+}
+
+func (s *Server) getOperation(w http.ResponseWriter, req Request) {
+	op, ok := s.operations[req.Name]
+	if !ok {
+		writeNotFound(w, s.project, req)
+		return
+	}
+	if op.pending > 0 {
+		op.pending--
+		op.body["status"] = "RUNNING"
+	} else {
+		op.body["status"] = "DONE"
+	}
+	writeJSON(w, op.body)
+}
+
+// newOperation records an operation for req. The operation lives in the same
+// scope as the resource so the global/regional/zonal waiters find it.
+func (s *Server) newOperation(req Request, opType, name string) map[string]any {
+	s.nextOp++
+	opName := fmt.Sprintf("operation-%d", s.nextOp)
+	scope := strings.TrimSuffix(req.Collection, "/"+collectionKind(req.Collection))
+
+	body := map[string]any{
+		"name":          opName,
+		"operationType": opType,
+		"targetLink":    s.selfLink(req.Collection, name),
+		"selfLink":      s.selfLink(scope+"/operations", opName),
+		"status":        "RUNNING",
+	}
+	if code, ok := s.opErrors[collectionKind(req.Collection)]; ok && opType == "insert" {
+		body["error"] = map[string]any{
+			"errors": []map[string]any{{"code": code, "message": fmt.Sprintf("injected %s for %s", code, name)}},
+		}
+	}
+
+	s.operations[opName] = &operation{body: body, pending: s.PendingPolls}
+	return body
+}
+
+func (s *Server) collection(collection string) map[string]map[string]any {
+	if s.resources[collection] == nil {
+		s.resources[collection] = map[string]map[string]any{}
+	}
+	return s.resources[collection]
+}
+
+func (s *Server) selfLink(collection, name string) string {
+	return fmt.Sprintf("%s%s/%s/%s", selfPrefix, s.project, collection, name)
+}
+
+// fillDefaults sets the output-only fields the managers read back after an insert
+func fillDefaults(kind string, body map[string]any) {
+	switch kind {
+	case "forwardingRules":
+		if _, ok := body["IPAddress"]; !ok {
+			body["IPAddress"] = "10.0.0.100"
+		}
+	case "addresses":
+		if _, ok := body["address"]; !ok {
+			body["address"] = "10.2.0.100"
+		}
+	case "instances":
+		body["status"] = "RUNNING"
+	case "vpnGateways":
+		body["vpnInterfaces"] = []map[string]any{
+			{"id": 0, "ipAddress": "203.0.113.10"},
+			{"id": 1, "ipAddress": "203.0.113.11"},
+		}
+	case "vpnTunnels":
+		body["status"] = "ESTABLISHED"
+	}
+}
+
+// parsePath splits /compute/v1/projects/{project}/{scope}/{collection}[/{name}[/{action}]]
+// where scope is "global", "aggregated", "regions/{region}" or "zones/{zone}"
+func parsePath(project, method, path string) (Request, bool) {
+	rest, ok := strings.CutPrefix(path, apiPrefix+project+"/")
+	if !ok {
+		return Request{}, false
+	}
+
+	parts := strings.Split(rest, "/")
+	var scope string
+	switch {
+	case parts[0] == "global" || parts[0] == "aggregated":
+		scope, parts = parts[0], parts[1:]
+	case (parts[0] == "regions" || parts[0] == "zones") && len(parts) > 1:
+		scope, parts = parts[0]+"/"+parts[1], parts[2:]
+	default:
+		return Request{}, false
+	}
+	if len(parts) == 0 || len(parts) > 3 {
+		return Request{}, false
+	}
+
+	req := Request{Method: method, Collection: scope + "/" + parts[0]}
+	if len(parts) > 1 {
+		req.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		req.Action = parts[2]
+	}
+	return req, true
+}
+
+// collectionKind returns the last segment of a scoped collection, e.g. "subnetworks"
+func collectionKind(collection string) string {
+	return collection[strings.LastIndex(collection, "/")+1:]
+}
+
+func writeNotFound(w http.ResponseWriter, project string, req Request) {
+	writeError(w, http.StatusNotFound, "notFound",
+		fmt.Sprintf("The resource 'projects/%s/%s/%s' was not found", project, req.Collection, req.Name))
+}
+
+// writeError writes an error in the shape googleapi.CheckResponse parses
+func writeError(w http.ResponseWriter, code int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"code":    code,
+			"message": message,
+			"errors":  []map[string]any{{"domain": "global", "reason": reason, "message": message}},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
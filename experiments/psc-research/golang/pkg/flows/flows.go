@@ -0,0 +1,326 @@
+// Package flows reads the firewall rule logs and VPC flow logs of the demo
+// networks from Cloud Logging and tells, for every flow coming from the PSC
+// NAT subnet, which firewall rule allowed or denied it.
+package flows
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+)
+
+// Log names of the records, URL-encoded as in the logName field
+const (
+	firewallLog = "compute.googleapis.com%2Ffirewall"
+	flowLog     = "compute.googleapis.com%2Fvpc_flows"
+)
+
+// Kinds of records
+const (
+	KindFirewall = "firewall"
+	KindFlow     = "flow"
+)
+
+// Window is the time range the logs are read for
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Connection is the 5-tuple of a firewall or flow log record
+type Connection struct {
+	SrcIP    string `json:"src_ip"`
+	SrcPort  int    `json:"src_port"`
+	DestIP   string `json:"dest_ip"`
+	DestPort int    `json:"dest_port"`
+	Protocol int    `json:"protocol"`
+}
+
+// Record is a firewall log or flow log entry
+type Record struct {
+	Time time.Time
+	Kind string
+	Conn Connection
+
+	// Firewall records: the rule that matched, its action and what happened
+	// to the connection (ALLOWED or DENIED)
+	Rule        string
+	Action      string
+	Disposition string
+
+	// Flow records: traffic counted by the reporting VM
+	Packets int64
+	Bytes   int64
+
+	VM string
+}
+
+// entry is the part of a Cloud Logging entry the analysis reads
+type entry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	LogName     string    `json:"logName"`
+	JSONPayload struct {
+		Connection  Connection `json:"connection"`
+		Disposition string     `json:"disposition"`
+		RuleDetails struct {
+			Reference string `json:"reference"`
+			Action    string `json:"action"`
+		} `json:"rule_details"`
+		Instance struct {
+			VMName string `json:"vm_name"`
+		} `json:"instance"`
+		SrcInstance struct {
+			VMName string `json:"vm_name"`
+		} `json:"src_instance"`
+		DestInstance struct {
+			VMName string `json:"vm_name"`
+		} `json:"dest_instance"`
+		Reporter    string `json:"reporter"`
+		PacketsSent count  `json:"packets_sent"`
+		BytesSent   count  `json:"bytes_sent"`
+	} `json:"jsonPayload"`
+}
+
+// count is an int64 that Cloud Logging writes as a JSON string
+type count int64
+
+func (c *count) UnmarshalJSON(data []byte) error {
+	n, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid count %s", data)
+	}
+	*c = count(n)
+	return nil
+}
+
+// Filter returns the Cloud Logging filter selecting the firewall and flow log
+// records of connections from natRange during the window
+func Filter(projectID, natRange string, w Window) string {
+	return fmt.Sprintf(`(logName="projects/%[1]s/logs/%[2]s" OR logName="projects/%[1]s/logs/%[3]s") `+
+		`AND ip_in_net(jsonPayload.connection.src_ip, "%[4]s") `+
+		`AND timestamp>="%[5]s" AND timestamp<="%[6]s"`,
+		projectID, firewallLog, flowLog, natRange,
+		w.Start.UTC().Format(time.RFC3339), w.End.UTC().Format(time.RFC3339))
+}
+
+// parse turns the JSON output of gcloud logging read into records, skipping
+// entries of other logs
+func parse(data []byte) ([]Record, error) {
+	var entries []entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse log entries: %v", err)
+	}
+
+	var records []Record
+	for _, e := range entries {
+		p := e.JSONPayload
+		r := Record{Time: e.Timestamp, Conn: p.Connection}
+		switch {
+		case strings.HasSuffix(e.LogName, "/"+firewallLog):
+			r.Kind = KindFirewall
+			r.Rule = ruleName(p.RuleDetails.Reference)
+			r.Action = p.RuleDetails.Action
+			r.Disposition = p.Disposition
+			r.VM = p.Instance.VMName
+		case strings.HasSuffix(e.LogName, "/"+flowLog):
+			r.Kind = KindFlow
+			r.Packets = int64(p.PacketsSent)
+			r.Bytes = int64(p.BytesSent)
+			r.VM = p.DestInstance.VMName
+			if p.Reporter == "SRC" {
+				r.VM = p.SrcInstance.VMName
+			}
+		default:
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// ruleName extracts the rule from a reference like
+// "network:hypershift-redhat/firewall:hypershift-redhat-allow-psc-nat"
+func ruleName(reference string) string {
+	if i := strings.LastIndex(reference, "firewall:"); i >= 0 {
+		return reference[i+len("firewall:"):]
+	}
+	return reference
+}
+
+// RuleHit counts the connections of a flow a firewall rule handled
+type RuleHit struct {
+	Rule        string
+	Action      string
+	Disposition string
+	Hits        int
+}
+
+// Flow aggregates the records of the connections between a PSC NAT address
+// and a destination port, whatever their source port
+type Flow struct {
+	Src   string
+	Dst   string
+	Port  int
+	Proto string
+
+	First time.Time
+	Last  time.Time
+
+	// Packets and Bytes come from the flow logs, Rules from the firewall logs
+	Packets int64
+	Bytes   int64
+	Rules   []RuleHit
+}
+
+// Denied reports whether a firewall rule denied connections of the flow
+func (f *Flow) Denied() bool {
+	for _, hit := range f.Rules {
+		if hit.Disposition == "DENIED" {
+			return true
+		}
+	}
+	return false
+}
+
+// Summarize groups the records per flow, most recently seen first
+func Summarize(records []Record) []Flow {
+	type key struct {
+		src, dst    string
+		port, proto int
+	}
+	index := map[key]int{}
+	var flows []Flow
+	for _, r := range records {
+		k := key{r.Conn.SrcIP, r.Conn.DestIP, r.Conn.DestPort, r.Conn.Protocol}
+		i, seen := index[k]
+		if !seen {
+			i = len(flows)
+			index[k] = i
+			flows = append(flows, Flow{
+				Src: k.src, Dst: k.dst, Port: k.port, Proto: protocolName(k.proto),
+				First: r.Time, Last: r.Time,
+			})
+		}
+		f := &flows[i]
+		if r.Time.Before(f.First) {
+			f.First = r.Time
+		}
+		if r.Time.After(f.Last) {
+			f.Last = r.Time
+		}
+
+		switch r.Kind {
+		case KindFlow:
+			f.Packets += r.Packets
+			f.Bytes += r.Bytes
+		case KindFirewall:
+			f.addHit(r)
+		}
+	}
+
+	sort.SliceStable(flows, func(i, j int) bool { return flows[i].Last.After(flows[j].Last) })
+	return flows
+}
+
+func (f *Flow) addHit(r Record) {
+	for i := range f.Rules {
+		if f.Rules[i].Rule == r.Rule && f.Rules[i].Disposition == r.Disposition {
+			f.Rules[i].Hits++
+			return
+		}
+	}
+	f.Rules = append(f.Rules, RuleHit{Rule: r.Rule, Action: r.Action, Disposition: r.Disposition, Hits: 1})
+}
+
+func protocolName(p int) string {
+	switch p {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	}
+	return fmt.Sprintf("ip-proto-%d", p)
+}
+
+// Options controls an analysis
+type Options struct {
+	Window Window
+	// NATRange overrides the PSC NAT subnet range looked up in the project
+	NATRange string
+	// Limit caps the number of log entries read
+	Limit int
+}
+
+// Analyzer reads the logs of one run
+type Analyzer struct {
+	config *config.Config
+	opts   Options
+}
+
+// NewAnalyzer validates the options and returns an analyzer
+func NewAnalyzer(cfg *config.Config, opts Options) (*Analyzer, error) {
+	if !opts.Window.End.After(opts.Window.Start) {
+		return nil, fmt.Errorf("window end %s is not after its start %s",
+			opts.Window.End.Format(time.RFC3339), opts.Window.Start.Format(time.RFC3339))
+	}
+	if opts.Limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+	return &Analyzer{config: cfg, opts: opts}, nil
+}
+
+// Run reads the firewall and flow log records of the PSC NAT range and
+// summarizes them per flow
+func (a *Analyzer) Run() (*Report, error) {
+	natRange := a.opts.NATRange
+	if natRange == "" {
+		natRange = a.lookupSubnetRange(a.config.PSCNATSubnet, a.config.PSCNATSubnetRange)
+	}
+
+	filter := Filter(a.config.ProjectID, natRange, a.opts.Window)
+	output, err := exec.Command("gcloud", "logging", "read", filter,
+		"--project", a.config.ProjectID,
+		"--limit", strconv.Itoa(a.opts.Limit),
+		"--order", "asc",
+		"--format", "json").Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("gcloud logging read failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("gcloud logging read failed: %v", err)
+	}
+
+	records, err := parse(output)
+	if err != nil {
+		return nil, err
+	}
+	return &Report{
+		RunID:     a.config.RunID,
+		NATRange:  natRange,
+		Window:    a.opts.Window,
+		Records:   len(records),
+		Truncated: len(records) >= a.opts.Limit,
+		Flows:     Summarize(records),
+	}, nil
+}
+
+// lookupSubnetRange reads the range of a subnet from the project, returning
+// fallback when it cannot be described
+func (a *Analyzer) lookupSubnetRange(name, fallback string) string {
+	output, err := exec.Command("gcloud", "compute", "networks", "subnets", "describe", name,
+		"--region", a.config.Region,
+		"--project", a.config.ProjectID,
+		"--format", "value(ipCidrRange)").Output()
+	if r := strings.TrimSpace(string(output)); err == nil && r != "" {
+		return r
+	}
+	return fallback
+}
@@ -0,0 +1,167 @@
+package flows
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// logEntries is gcloud logging read --format json output with a firewall
+// log record per connection and flow log records reported by the provider VM
+const logEntries = `[
+  {
+    "logName": "projects/test-project/logs/compute.googleapis.com%2Ffirewall",
+    "timestamp": "2026-10-16T10:00:01Z",
+    "jsonPayload": {
+      "connection": {"src_ip": "10.1.1.3", "src_port": 40001, "dest_ip": "10.1.0.2", "dest_port": 6443, "protocol": 6},
+      "disposition": "ALLOWED",
+      "instance": {"vm_name": "redhat-service-vm"},
+      "rule_details": {"reference": "network:hypershift-redhat/firewall:hypershift-redhat-allow-psc-nat", "action": "ALLOW", "priority": 1000}
+    }
+  },
+  {
+    "logName": "projects/test-project/logs/compute.googleapis.com%2Ffirewall",
+    "timestamp": "2026-10-16T10:00:05Z",
+    "jsonPayload": {
+      "connection": {"src_ip": "10.1.1.3", "src_port": 40002, "dest_ip": "10.1.0.2", "dest_port": 6443, "protocol": 6},
+      "disposition": "ALLOWED",
+      "rule_details": {"reference": "network:hypershift-redhat/firewall:hypershift-redhat-allow-psc-nat", "action": "ALLOW"}
+    }
+  },
+  {
+    "logName": "projects/test-project/logs/compute.googleapis.com%2Fvpc_flows",
+    "timestamp": "2026-10-16T10:00:06Z",
+    "jsonPayload": {
+      "connection": {"src_ip": "10.1.1.3", "src_port": 40001, "dest_ip": "10.1.0.2", "dest_port": 6443, "protocol": 6},
+      "reporter": "DEST",
+      "dest_instance": {"vm_name": "redhat-service-vm"},
+      "packets_sent": "7",
+      "bytes_sent": "1200"
+    }
+  },
+  {
+    "logName": "projects/test-project/logs/compute.googleapis.com%2Fvpc_flows",
+    "timestamp": "2026-10-16T10:00:07Z",
+    "jsonPayload": {
+      "connection": {"src_ip": "10.1.1.3", "src_port": 40002, "dest_ip": "10.1.0.2", "dest_port": 6443, "protocol": 6},
+      "reporter": "DEST",
+      "packets_sent": "3",
+      "bytes_sent": "300"
+    }
+  },
+  {
+    "logName": "projects/test-project/logs/compute.googleapis.com%2Ffirewall",
+    "timestamp": "2026-10-16T10:00:09Z",
+    "jsonPayload": {
+      "connection": {"src_ip": "10.1.1.4", "src_port": 40003, "dest_ip": "10.1.0.2", "dest_port": 22, "protocol": 6},
+      "disposition": "DENIED",
+      "rule_details": {"reference": "network:hypershift-redhat/firewall:hypershift-redhat-deny-ingress", "action": "DENY"}
+    }
+  },
+  {
+    "logName": "projects/test-project/logs/cloudaudit.googleapis.com%2Factivity",
+    "timestamp": "2026-10-16T10:00:10Z",
+    "jsonPayload": {}
+  }
+]`
+
+func TestParse(t *testing.T) {
+	records, err := parse([]byte(logEntries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("parse() returned %d records, want 5 (the audit log entry skipped)", len(records))
+	}
+
+	fw := records[0]
+	if fw.Kind != KindFirewall || fw.Rule != "hypershift-redhat-allow-psc-nat" || fw.Disposition != "ALLOWED" || fw.VM != "redhat-service-vm" {
+		t.Errorf("firewall record = %+v", fw)
+	}
+	flow := records[2]
+	if flow.Kind != KindFlow || flow.Packets != 7 || flow.Bytes != 1200 || flow.VM != "redhat-service-vm" {
+		t.Errorf("flow record = %+v", flow)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	if _, err := parse([]byte("ERROR: not json")); err == nil {
+		t.Error("parse() of non-JSON output succeeded")
+	}
+	if _, err := parse([]byte(`[{"logName": "projects/p/logs/compute.googleapis.com%2Fvpc_flows", "jsonPayload": {"bytes_sent": "many"}}]`)); err == nil {
+		t.Error("parse() of an invalid byte count succeeded")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	records, err := parse([]byte(logEntries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows := Summarize(records)
+	if len(flows) != 2 {
+		t.Fatalf("Summarize() returned %d flows, want 2: %+v", len(flows), flows)
+	}
+
+	// Most recently seen first
+	denied, allowed := flows[0], flows[1]
+	if denied.Port != 22 || !denied.Denied() || denied.Rules[0].Rule != "hypershift-redhat-deny-ingress" {
+		t.Errorf("denied flow = %+v", denied)
+	}
+	if allowed.Port != 6443 || allowed.Proto != "tcp" || allowed.Denied() {
+		t.Errorf("allowed flow = %+v", allowed)
+	}
+	if allowed.Packets != 10 || allowed.Bytes != 1500 {
+		t.Errorf("allowed flow counted %d packets, %d bytes, want 10, 1500", allowed.Packets, allowed.Bytes)
+	}
+	if len(allowed.Rules) != 1 || allowed.Rules[0].Hits != 2 {
+		t.Errorf("allowed flow rules = %+v, want 2 hits of allow-psc-nat", allowed.Rules)
+	}
+	if want := time.Date(2026, 10, 16, 10, 0, 1, 0, time.UTC); !allowed.First.Equal(want) {
+		t.Errorf("allowed flow first seen %s, want %s", allowed.First, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	start := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	filter := Filter("test-project", "10.1.1.0/24", Window{Start: start, End: start.Add(time.Hour)})
+
+	for _, want := range []string{
+		`logName="projects/test-project/logs/compute.googleapis.com%2Ffirewall"`,
+		`logName="projects/test-project/logs/compute.googleapis.com%2Fvpc_flows"`,
+		`ip_in_net(jsonPayload.connection.src_ip, "10.1.1.0/24")`,
+		`timestamp>="2026-10-16T10:00:00Z" AND timestamp<="2026-10-16T11:00:00Z"`,
+	} {
+		if !strings.Contains(filter, want) {
+			t.Errorf("filter %s does not contain %s", filter, want)
+		}
+	}
+}
+
+func TestReport_Hints(t *testing.T) {
+	report := &Report{
+		RunID:    "test",
+		NATRange: "10.1.1.0/24",
+		Records:  3,
+		Flows: []Flow{
+			{Src: "10.1.1.4", Dst: "10.1.0.2", Port: 6443, Proto: "tcp", Rules: []RuleHit{
+				{Rule: "hypershift-redhat-deny-ingress", Action: "DENY", Disposition: "DENIED", Hits: 2},
+			}},
+			{Src: "10.1.1.5", Dst: "10.1.0.2", Port: 6443, Proto: "tcp", Packets: 4, Bytes: 400},
+		},
+	}
+
+	var out strings.Builder
+	report.Write(&out)
+
+	for _, want := range []string{
+		"DENIED  by hypershift-redhat-deny-ingress",
+		"! denied by hypershift-redhat-deny-ingress: an ingress rule must allow tcp 6443 from 10.1.1.0/24",
+		"flow logs: 4 packets, 400 bytes",
+		"! no firewall log",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
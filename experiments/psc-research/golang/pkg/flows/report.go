@@ -0,0 +1,69 @@
+package flows
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Report is the per-flow summary of an analysis
+type Report struct {
+	RunID     string
+	NATRange  string
+	Window    Window
+	Records   int
+	Truncated bool
+	Flows     []Flow
+}
+
+// Write prints every flow with the rules that handled it, followed by hints
+// for denied flows and flows no rule logged
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "Firewall and flow logs of run %s from the PSC NAT range %s\n", r.RunID, r.NATRange)
+	fmt.Fprintf(w, "Window %s to %s, %d records\n",
+		r.Window.Start.UTC().Format(time.RFC3339), r.Window.End.UTC().Format(time.RFC3339), r.Records)
+	if r.Truncated {
+		fmt.Fprintln(w, "! the record limit was reached, raise --limit or narrow the window for complete counts")
+	}
+
+	if len(r.Flows) == 0 {
+		fmt.Fprintln(w, "\nNo records. Logs are exported a few minutes after the traffic: send test traffic,")
+		fmt.Fprintln(w, "wait and widen the window. Resources created before logging was enabled have none.")
+		return
+	}
+
+	for _, f := range r.Flows {
+		fmt.Fprintf(w, "\n%s -> %s:%d %s, %s to %s\n", f.Src, f.Dst, f.Port, f.Proto,
+			f.First.UTC().Format(time.TimeOnly), f.Last.UTC().Format(time.TimeOnly))
+		if f.Packets > 0 {
+			fmt.Fprintf(w, "  flow logs: %d packets, %d bytes\n", f.Packets, f.Bytes)
+		}
+		for _, hit := range f.Rules {
+			fmt.Fprintf(w, "  %-7s by %-45s %5d connections\n", hit.Disposition, hit.Rule, hit.Hits)
+		}
+		for _, hint := range r.hints(f) {
+			fmt.Fprintf(w, "  ! %s\n", hint)
+		}
+	}
+}
+
+// hints explains flows that were denied or that no firewall rule logged
+func (r *Report) hints(f Flow) []string {
+	var hints []string
+	if f.Denied() {
+		var rules []string
+		for _, hit := range f.Rules {
+			if hit.Disposition == "DENIED" {
+				rules = append(rules, hit.Rule)
+			}
+		}
+		hints = append(hints, fmt.Sprintf("denied by %s: an ingress rule must allow %s %d from %s",
+			strings.Join(rules, ", "), f.Proto, f.Port, r.NATRange))
+	}
+	if len(f.Rules) == 0 {
+		hints = append(hints, "no firewall log: the rule that matched does not log, "+
+			"or its records have not been exported yet")
+	}
+	return hints
+}
@@ -0,0 +1,66 @@
+// Package gcloud runs the gcloud CLI for the experiments that drive the demo
+// VMs over SSH or read a single field of a resource, where the Compute client
+// of the managers would be more code than the command it replaces.
+package gcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"gcp-psc-demo/pkg/config"
+)
+
+// Run runs gcloud and returns the last line of its output as the error
+func Run(ctx context.Context, args ...string) error {
+	output, err := exec.CommandContext(ctx, "gcloud", args...).CombinedOutput()
+	if err != nil {
+		return lastLine(err, output)
+	}
+	return nil
+}
+
+// Lookup runs a gcloud compute describe command in project, returning "" on
+// failure
+func Lookup(ctx context.Context, project string, args ...string) string {
+	args = append([]string{"compute"}, args...)
+	output, err := exec.CommandContext(ctx, "gcloud", append(args, "--project", project)...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// Output runs gcloud and returns its standard output, with the last line of
+// its standard error as the error
+func Output(ctx context.Context, args ...string) (string, error) {
+	output, err := exec.CommandContext(ctx, "gcloud", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", lastLine(err, exitErr.Stderr)
+		}
+		return "", err
+	}
+	return string(output), nil
+}
+
+// SSH runs command on a VM of the run in the zone of cfg and returns its
+// standard output
+func SSH(ctx context.Context, cfg *config.Config, vmName, command string) (string, error) {
+	return Output(ctx, cfg.SSHArgs(vmName, cfg.Zone, "--command", command)...)
+}
+
+// ShellQuote quotes s for the remote shell
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// lastLine wraps err with the last line gcloud printed, which holds the
+// reason of the failure
+func lastLine(err error, output []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return fmt.Errorf("%v: %s", err, lines[len(lines)-1])
+}
@@ -0,0 +1,92 @@
+package gcloud
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gcp-psc-demo/pkg/config"
+)
+
+// fakeGcloud puts a gcloud script running body first on the PATH. The
+// script records its arguments in the returned file.
+func fakeGcloud(t *testing.T, body string) string {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "gcloud"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func recordedArgs(t *testing.T, argsFile string) string {
+	t.Helper()
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestRun(t *testing.T) {
+	fakeGcloud(t, "exit 0")
+	if err := Run(context.Background(), "storage", "cp", "a", "b"); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+
+	fakeGcloud(t, "echo 'Copying a'; echo 'ERROR: (gcloud.storage.cp) bucket not found' >&2; exit 1")
+	err := Run(context.Background(), "storage", "cp", "a", "b")
+	if err == nil || !strings.HasSuffix(err.Error(), ": ERROR: (gcloud.storage.cp) bucket not found") {
+		t.Errorf("Run() error = %v, want the last line gcloud printed", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	argsFile := fakeGcloud(t, "echo ' 10.0.2.0/24 '")
+	got := Lookup(context.Background(), "demo-project", "networks", "subnets", "describe", "psc-nat", "--format", "value(ipCidrRange)")
+	if got != "10.0.2.0/24" {
+		t.Errorf("Lookup() = %q, want 10.0.2.0/24", got)
+	}
+	want := "compute networks subnets describe psc-nat --format value(ipCidrRange) --project demo-project"
+	if args := recordedArgs(t, argsFile); args != want {
+		t.Errorf("gcloud %s, want gcloud %s", args, want)
+	}
+
+	fakeGcloud(t, "echo partial; exit 1")
+	if got := Lookup(context.Background(), "demo-project", "addresses", "describe", "missing"); got != "" {
+		t.Errorf("Lookup() = %q on failure, want empty", got)
+	}
+}
+
+func TestSSH(t *testing.T) {
+	cfg := &config.Config{ProjectID: "demo-project", Zone: "us-central1-a"}
+	argsFile := fakeGcloud(t, "echo ok; echo 'Connection via Cloud IAP' >&2")
+	got, err := SSH(context.Background(), cfg, "consumer-vm", "uptime")
+	if err != nil || got != "ok\n" {
+		t.Errorf("SSH() = %q, %v, want the standard output only", got, err)
+	}
+	want := "compute ssh consumer-vm --zone us-central1-a --project demo-project --command uptime"
+	if args := recordedArgs(t, argsFile); args != want {
+		t.Errorf("gcloud %s, want gcloud %s", args, want)
+	}
+
+	fakeGcloud(t, "echo partial; echo 'Connection via Cloud IAP' >&2; echo 'ERROR: connection refused' >&2; exit 255")
+	_, err = SSH(context.Background(), cfg, "consumer-vm", "uptime")
+	if err == nil || !strings.HasSuffix(err.Error(), ": ERROR: connection refused") {
+		t.Errorf("SSH() error = %v, want the last line of standard error", err)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got, want := ShellQuote("host 10.1.1.5 and port 6443"), "'host 10.1.1.5 and port 6443'"; got != want {
+		t.Errorf("ShellQuote() = %s, want %s", got, want)
+	}
+	if got, want := ShellQuote("it's"), `'it'\''s'`; got != want {
+		t.Errorf("ShellQuote() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,280 @@
+package gcpops
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/option"
+)
+
+// Backoff controls how often an operation is polled
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Jitter spreads each delay by up to ±Jitter (0.2 = ±20%) so parallel
+	// waiters don't poll the API in lockstep
+	Jitter float64
+}
+
+// DefaultBackoff matches the polling the managers used before: start at 1s,
+// double up to 10s, with ±20% jitter
+var DefaultBackoff = Backoff{
+	Initial:    1 * time.Second,
+	Max:        10 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// next returns the un-jittered delay that follows d
+func (b Backoff) next(d time.Duration) time.Duration {
+	n := time.Duration(float64(d) * b.Multiplier)
+	if n > b.Max || n <= 0 {
+		n = b.Max
+	}
+	return n
+}
+
+// jitter spreads d by ±Jitter using r, a random value in [0, 1)
+func (b Backoff) jitter(d time.Duration, r float64) time.Duration {
+	if b.Jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + b.Jitter*(2*r-1)))
+}
+
+// OperationError is returned when an operation completes with errors
+type OperationError struct {
+	Operation string
+	Errors    []*computepb.Errors
+}
+
+func (e *OperationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", err.GetCode(), err.GetMessage()))
+	}
+	return fmt.Sprintf("operation %s failed: %s", e.Operation, strings.Join(msgs, "; "))
+}
+
+// HasCode reports whether any of the operation errors has the given code, e.g. "RESOURCE_IN_USE_BY_ANOTHER_RESOURCE"
+func (e *OperationError) HasCode(code string) bool {
+	for _, err := range e.Errors {
+		if err.GetCode() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFunc fetches the current state of an operation
+type GetFunc func(ctx context.Context) (*computepb.Operation, error)
+
+// Wait polls get until the operation is DONE, the context is cancelled, or a
+// poll fails. An operation that finishes with errors yields an *OperationError.
+func Wait(ctx context.Context, b Backoff, get GetFunc) error {
+	delay := b.Initial
+	for {
+		op, err := get(ctx)
+		if err != nil {
+			return err
+		}
+
+		if op.GetStatus() == computepb.Operation_DONE {
+			if errs := op.GetError().GetErrors(); len(errs) > 0 {
+				return &OperationError{Operation: op.GetName(), Errors: errs}
+			}
+			return nil
+		}
+
+		timer := time.NewTimer(b.jitter(delay, rand.Float64()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("waiting for operation %s: %w", op.GetName(), ctx.Err())
+		case <-timer.C:
+		}
+
+		delay = b.next(delay)
+	}
+}
+
+// Pool lazily creates one operations client per scope and shares it between
+// all managers, instead of dialing a new client for every wait
+type Pool struct {
+	opts []option.ClientOption
+
+	mu       sync.Mutex
+	global   *compute.GlobalOperationsClient
+	regional *compute.RegionOperationsClient
+	zonal    *compute.ZoneOperationsClient
+}
+
+// NewPool creates a pool whose clients are built with opts
+func NewPool(opts ...option.ClientOption) *Pool {
+	return &Pool{opts: opts}
+}
+
+var (
+	sharedPool     *Pool
+	sharedPoolOnce sync.Once
+)
+
+// Shared returns the process-wide pool used by the managers
+func Shared() *Pool {
+	sharedPoolOnce.Do(func() {
+		sharedPool = NewPool()
+	})
+	return sharedPool
+}
+
+// PoolFor returns the shared pool, or a dedicated one when client options are
+// given, e.g. to point a manager at a fake Compute endpoint in tests
+func PoolFor(opts ...option.ClientOption) *Pool {
+	if len(opts) == 0 {
+		return Shared()
+	}
+	return NewPool(opts...)
+}
+
+func (p *Pool) globalClient(ctx context.Context) (*compute.GlobalOperationsClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.global == nil {
+		client, err := compute.NewGlobalOperationsRESTClient(ctx, p.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create global operations client: %v", err)
+		}
+		p.global = client
+	}
+	return p.global, nil
+}
+
+func (p *Pool) regionalClient(ctx context.Context) (*compute.RegionOperationsClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.regional == nil {
+		client, err := compute.NewRegionOperationsRESTClient(ctx, p.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create region operations client: %v", err)
+		}
+		p.regional = client
+	}
+	return p.regional, nil
+}
+
+func (p *Pool) zonalClient(ctx context.Context) (*compute.ZoneOperationsClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.zonal == nil {
+		client, err := compute.NewZoneOperationsRESTClient(ctx, p.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zone operations client: %v", err)
+		}
+		p.zonal = client
+	}
+	return p.zonal, nil
+}
+
+// Close closes every client the pool has created
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.global != nil {
+		p.global.Close()
+		p.global = nil
+	}
+	if p.regional != nil {
+		p.regional.Close()
+		p.regional = nil
+	}
+	if p.zonal != nil {
+		p.zonal.Close()
+		p.zonal = nil
+	}
+}
+
+// Release closes a pool obtained from PoolFor. The shared pool is left open,
+// it is closed once when the process exits.
+func (p *Pool) Release() {
+	if p != Shared() {
+		p.Close()
+	}
+}
+
+// Waiter waits for operations in one project, region and zone
+type Waiter struct {
+	Pool    *Pool
+	Project string
+	Region  string
+	Zone    string
+	Backoff Backoff
+}
+
+// NewWaiter creates a waiter backed by pool using DefaultBackoff
+func NewWaiter(pool *Pool, project, region, zone string) *Waiter {
+	return &Waiter{
+		Pool:    pool,
+		Project: project,
+		Region:  region,
+		Zone:    zone,
+		Backoff: DefaultBackoff,
+	}
+}
+
+// WaitGlobal waits for a global operation to complete
+func (w *Waiter) WaitGlobal(ctx context.Context, operationName string) error {
+	client, err := w.Pool.globalClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	return Wait(ctx, w.Backoff, func(ctx context.Context) (*computepb.Operation, error) {
+		return client.Get(ctx, &computepb.GetGlobalOperationRequest{
+			Project:   w.Project,
+			Operation: operationName,
+		})
+	})
+}
+
+// WaitRegional waits for a regional operation to complete
+func (w *Waiter) WaitRegional(ctx context.Context, operationName string) error {
+	client, err := w.Pool.regionalClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	return Wait(ctx, w.Backoff, func(ctx context.Context) (*computepb.Operation, error) {
+		return client.Get(ctx, &computepb.GetRegionOperationRequest{
+			Project:   w.Project,
+			Region:    w.Region,
+			Operation: operationName,
+		})
+	})
+}
+
+// WaitZonal waits for a zonal operation to complete
+func (w *Waiter) WaitZonal(ctx context.Context, operationName string) error {
+	client, err := w.Pool.zonalClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	return Wait(ctx, w.Backoff, func(ctx context.Context) (*computepb.Operation, error) {
+		return client.Get(ctx, &computepb.GetZoneOperationRequest{
+			Project:   w.Project,
+			Zone:      w.Zone,
+			Operation: operationName,
+		})
+	})
+}
@@ -0,0 +1,126 @@
+package gcpops
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+)
+
+var fastBackoff = Backoff{
+	Initial:    time.Millisecond,
+	Max:        4 * time.Millisecond,
+	Multiplier: 2,
+}
+
+func operation(status computepb.Operation_Status, errs ...*computepb.Errors) *computepb.Operation {
+	name := "operation-123"
+	op := &computepb.Operation{Name: &name, Status: &status}
+	if len(errs) > 0 {
+		op.Error = &computepb.Error{Errors: errs}
+	}
+	return op
+}
+
+func TestWait_DoneAfterPolling(t *testing.T) {
+	polls := 0
+	err := Wait(context.Background(), fastBackoff, func(ctx context.Context) (*computepb.Operation, error) {
+		polls++
+		if polls < 3 {
+			return operation(computepb.Operation_RUNNING), nil
+		}
+		return operation(computepb.Operation_DONE), nil
+	})
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if polls != 3 {
+		t.Errorf("polls = %d, want 3", polls)
+	}
+}
+
+func TestWait_OperationError(t *testing.T) {
+	code := "RESOURCE_IN_USE_BY_ANOTHER_RESOURCE"
+	message := "The network resource is already being used by a subnetwork"
+
+	err := Wait(context.Background(), fastBackoff, func(ctx context.Context) (*computepb.Operation, error) {
+		return operation(computepb.Operation_DONE, &computepb.Errors{Code: &code, Message: &message}), nil
+	})
+
+	var opErr *OperationError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("Wait() error = %v, want *OperationError", err)
+	}
+	if !opErr.HasCode(code) {
+		t.Errorf("HasCode(%q) = false, error = %v", code, opErr)
+	}
+	if opErr.HasCode("QUOTA_EXCEEDED") {
+		t.Errorf("HasCode(QUOTA_EXCEEDED) = true, want false")
+	}
+}
+
+func TestWait_GetError(t *testing.T) {
+	want := errors.New("googleapi: Error 403: forbidden")
+
+	err := Wait(context.Background(), fastBackoff, func(ctx context.Context) (*computepb.Operation, error) {
+		return nil, want
+	})
+	if !errors.Is(err, want) {
+		t.Errorf("Wait() error = %v, want %v", err, want)
+	}
+}
+
+func TestWait_ContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := Wait(ctx, fastBackoff, func(ctx context.Context) (*computepb.Operation, error) {
+		return operation(computepb.Operation_RUNNING), nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestBackoff_Next(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+
+	delays := []time.Duration{b.Initial}
+	for i := 0; i < 5; i++ {
+		delays = append(delays, b.next(delays[len(delays)-1]))
+	}
+
+	want := []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Errorf("delay[%d] = %v, want %v", i, delays[i], want[i])
+		}
+	}
+}
+
+func TestBackoff_Jitter(t *testing.T) {
+	b := Backoff{Jitter: 0.2}
+	d := 10 * time.Second
+
+	tests := []struct {
+		r    float64
+		want time.Duration
+	}{
+		{0, 8 * time.Second},
+		{0.5, 10 * time.Second},
+		{0.999999, 12 * time.Second},
+	}
+
+	for _, tt := range tests {
+		got := b.jitter(d, tt.r)
+		if diff := got - tt.want; diff < -time.Millisecond || diff > time.Millisecond {
+			t.Errorf("jitter(%v, %v) = %v, want %v", d, tt.r, got, tt.want)
+		}
+	}
+
+	if got := (Backoff{}).jitter(d, 0); got != d {
+		t.Errorf("jitter without Jitter = %v, want %v", got, d)
+	}
+}
@@ -0,0 +1,544 @@
+// Package hybrid sets up the connectivity backends the PSC demo is compared
+// with, VPC peering and HA VPN between the consumer and provider VPCs, and
+// keeps the log of the comparison of the three backends across runs.
+package hybrid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcpops"
+	"gcp-psc-demo/pkg/vpc"
+	"github.com/fatih/color"
+	"google.golang.org/api/option"
+)
+
+// Private ASNs of the Cloud Routers of the VPN backend
+const (
+	ProviderASN uint32 = 64512
+	ConsumerASN uint32 = 64513
+)
+
+// Manager connects the consumer and provider VPCs with the peering or VPN
+// backend of its configuration
+type Manager struct {
+	networkClient    *compute.NetworksClient
+	vpnGatewayClient *compute.VpnGatewaysClient
+	vpnTunnelClient  *compute.VpnTunnelsClient
+	routerClient     *compute.RoutersClient
+	ops              *gcpops.Waiter
+	config           *config.Config
+}
+
+// NewManager creates a new hybrid connectivity manager
+func NewManager(cfg *config.Config, opts ...option.ClientOption) (*Manager, error) {
+	ctx := context.Background()
+	m := &Manager{
+		ops:    gcpops.NewWaiter(gcpops.PoolFor(opts...), cfg.ProjectID, cfg.Region, cfg.Zone),
+		config: cfg,
+	}
+
+	var err error
+	if m.networkClient, err = compute.NewNetworksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create networks client: %v", err)
+	}
+	if m.vpnGatewayClient, err = compute.NewVpnGatewaysRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN gateways client: %v", err)
+	}
+	if m.vpnTunnelClient, err = compute.NewVpnTunnelsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN tunnels client: %v", err)
+	}
+	if m.routerClient, err = compute.NewRoutersRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create routers client: %v", err)
+	}
+	return m, nil
+}
+
+// Close closes all clients
+func (m *Manager) Close() {
+	m.networkClient.Close()
+	m.vpnGatewayClient.Close()
+	m.vpnTunnelClient.Close()
+	m.routerClient.Close()
+	m.ops.Pool.Release()
+}
+
+// Resources returns the resources the backend of cfg adds to the topology
+// all backends share (VPCs, VMs and the internal load balancer), in
+// kind/name form. Their number is the setup effort the comparison reports.
+func Resources(cfg *config.Config) []string {
+	switch cfg.ConnectivityBackend {
+	case config.BackendPeering:
+		return []string{
+			"network-peerings/" + cfg.ProviderVPC + "/" + cfg.ConsumerVPC,
+			"network-peerings/" + cfg.ConsumerVPC + "/" + cfg.ProviderVPC,
+			"firewall-rules/" + vpc.ConsumerAccessRule(cfg),
+		}
+	case config.BackendVPN:
+		resources := []string{
+			"vpn-gateways/" + cfg.ProviderVPNGateway,
+			"vpn-gateways/" + cfg.ConsumerVPNGateway,
+			"routers/" + cfg.ProviderRouter,
+			"routers/" + cfg.ConsumerRouter,
+		}
+		for _, side := range vpnSides(cfg) {
+			for i, tunnel := range cfg.VPNTunnels(side.gateway) {
+				resources = append(resources,
+					"vpn-tunnels/"+tunnel,
+					"bgp-peers/"+side.router+"/"+bgpPeerName(i))
+			}
+		}
+		return append(resources, "firewall-rules/"+vpc.ConsumerAccessRule(cfg))
+	default:
+		return []string{
+			"subnets/" + cfg.PSCNATSubnet,
+			"firewall-rules/" + cfg.ProviderVPC + "-allow-psc-nat",
+			"service-attachments/" + cfg.ServiceAttachment,
+			"addresses/" + cfg.PSCEndpoint + "-ip",
+			"forwarding-rules/" + cfg.PSCForwardingRule,
+		}
+	}
+}
+
+// Setup connects the VPCs with the backend of the configuration and waits
+// until traffic can flow: the peerings ACTIVE, or the tunnels ESTABLISHED and
+// their BGP sessions UP
+func (m *Manager) Setup(ctx context.Context) error {
+	switch m.config.ConnectivityBackend {
+	case config.BackendPeering:
+		return m.SetupPeering(ctx)
+	case config.BackendVPN:
+		return m.SetupVPN(ctx)
+	default:
+		return fmt.Errorf("connectivity backend %s is not set up by the hybrid manager", m.config.ConnectivityBackend)
+	}
+}
+
+// SetupPeering peers the provider and consumer VPCs in both directions,
+// exchanging their subnet routes. Each peering is named after the VPC it
+// peers with.
+func (m *Manager) SetupPeering(ctx context.Context) error {
+	color.Blue("=== Setting up VPC Peering ===")
+
+	cfg := m.config
+	for _, p := range []struct{ network, peer string }{
+		{cfg.ProviderVPC, cfg.ConsumerVPC},
+		{cfg.ConsumerVPC, cfg.ProviderVPC},
+	} {
+		if err := m.addPeering(ctx, p.network, p.peer); err != nil {
+			return err
+		}
+	}
+
+	if err := m.poll(ctx, "VPC peerings to become ACTIVE", m.peeringsActive); err != nil {
+		return err
+	}
+	color.Green("✓ VPC peering setup completed successfully!")
+	return nil
+}
+
+func (m *Manager) addPeering(ctx context.Context, network, peer string) error {
+	state, err := m.peeringState(ctx, network, peer)
+	if err != nil {
+		return err
+	}
+	if state != "" {
+		fmt.Printf("Peering %s of VPC %s already exists, skipping\n", peer, network)
+		return nil
+	}
+
+	fmt.Printf("Creating peering %s of VPC %s\n", peer, network)
+	op, err := m.networkClient.AddPeering(ctx, &computepb.AddPeeringNetworkRequest{
+		Project: m.config.ProjectID,
+		Network: network,
+		NetworksAddPeeringRequestResource: &computepb.NetworksAddPeeringRequest{
+			NetworkPeering: &computepb.NetworkPeering{
+				Name:                 stringPtr(peer),
+				Network:              stringPtr(m.networkURL(peer)),
+				ExchangeSubnetRoutes: boolPtr(true),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add peering %s to VPC %s: %v", peer, network, err)
+	}
+	if err := m.ops.WaitGlobal(ctx, op.Name()); err != nil {
+		return fmt.Errorf("failed to wait for peering %s of VPC %s: %v", peer, network, err)
+	}
+	fmt.Printf("Peering %s of VPC %s created\n", peer, network)
+	return nil
+}
+
+// peeringState returns the state of the peering named peer of network,
+// empty when there is none
+func (m *Manager) peeringState(ctx context.Context, network, peer string) (string, error) {
+	n, err := m.networkClient.Get(ctx, &computepb.GetNetworkRequest{Project: m.config.ProjectID, Network: network})
+	if err != nil {
+		return "", fmt.Errorf("failed to get VPC %s: %v", network, err)
+	}
+	for _, p := range n.GetPeerings() {
+		if p.GetName() == peer {
+			return p.GetState(), nil
+		}
+	}
+	return "", nil
+}
+
+// peeringsActive reports whether both peerings are ACTIVE, which they only
+// become once both sides exist
+func (m *Manager) peeringsActive(ctx context.Context) (bool, string, error) {
+	cfg := m.config
+	var states []string
+	for _, p := range [][2]string{{cfg.ProviderVPC, cfg.ConsumerVPC}, {cfg.ConsumerVPC, cfg.ProviderVPC}} {
+		state, err := m.peeringState(ctx, p[0], p[1])
+		if err != nil {
+			return false, "", err
+		}
+		states = append(states, fmt.Sprintf("%s→%s %s", p[0], p[1], state))
+		if state != "ACTIVE" {
+			return false, fmt.Sprint(states), nil
+		}
+	}
+	return true, fmt.Sprint(states), nil
+}
+
+// vpnSide is one VPC of the VPN backend
+type vpnSide struct {
+	network, gateway, router string
+	asn, peerASN             uint32
+	// peerGateway is the HA VPN gateway of the other VPC
+	peerGateway string
+	// host is the last octet of the BGP address of this side in the link
+	// local /30 of every tunnel, the other side has the other one
+	host, peerHost int
+}
+
+func vpnSides(cfg *config.Config) []vpnSide {
+	return []vpnSide{
+		{cfg.ProviderVPC, cfg.ProviderVPNGateway, cfg.ProviderRouter, ProviderASN, ConsumerASN, cfg.ConsumerVPNGateway, 1, 2},
+		{cfg.ConsumerVPC, cfg.ConsumerVPNGateway, cfg.ConsumerRouter, ConsumerASN, ProviderASN, cfg.ProviderVPNGateway, 2, 1},
+	}
+}
+
+// bgpPeerName and routerInterfaceName name the BGP session and router
+// interface of tunnel i of a gateway
+func bgpPeerName(i int) string         { return fmt.Sprintf("bgp-tunnel%d", i) }
+func routerInterfaceName(i int) string { return fmt.Sprintf("if-tunnel%d", i) }
+
+// SetupVPN connects the VPCs with an HA VPN gateway in each, two tunnels
+// between the matching interfaces of the gateways, and a Cloud Router on
+// each side with a BGP session over each tunnel. The routers advertise their
+// subnets, so each VPC learns the routes of the other one.
+func (m *Manager) SetupVPN(ctx context.Context) error {
+	color.Blue("=== Setting up HA VPN ===")
+
+	sides := vpnSides(m.config)
+	for _, side := range sides {
+		if err := m.createVPNGateway(ctx, side); err != nil {
+			return err
+		}
+		if err := m.createRouter(ctx, side); err != nil {
+			return err
+		}
+	}
+
+	// Both tunnels of a pair need the same secret, so a pair is only ever
+	// created as a whole
+	for i := range 2 {
+		provider, consumer := sides[0].tunnel(m.config, i), sides[1].tunnel(m.config, i)
+		providerExists, err := m.tunnelExists(ctx, provider)
+		if err != nil {
+			return err
+		}
+		consumerExists, err := m.tunnelExists(ctx, consumer)
+		if err != nil {
+			return err
+		}
+		switch {
+		case providerExists && consumerExists:
+			fmt.Printf("VPN tunnels %s and %s already exist, skipping\n", provider, consumer)
+			continue
+		case providerExists || consumerExists:
+			return fmt.Errorf("only one of VPN tunnels %s and %s exists, delete it and run again", provider, consumer)
+		}
+
+		secret, err := sharedSecret()
+		if err != nil {
+			return err
+		}
+		for _, side := range sides {
+			if err := m.createTunnel(ctx, side, i, secret); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, side := range sides {
+		if err := m.configureBGP(ctx, side); err != nil {
+			return err
+		}
+	}
+
+	if err := m.poll(ctx, "VPN tunnels to be ESTABLISHED and BGP sessions UP", m.vpnUp); err != nil {
+		return err
+	}
+	color.Green("✓ HA VPN setup completed successfully!")
+	return nil
+}
+
+func (s vpnSide) tunnel(cfg *config.Config, i int) string {
+	return cfg.VPNTunnels(s.gateway)[i]
+}
+
+func (m *Manager) createVPNGateway(ctx context.Context, side vpnSide) error {
+	_, err := m.vpnGatewayClient.Get(ctx, &computepb.GetVpnGatewayRequest{
+		Project: m.config.ProjectID, Region: m.config.Region, VpnGateway: side.gateway,
+	})
+	if err == nil {
+		fmt.Printf("VPN gateway %s already exists, skipping\n", side.gateway)
+		return nil
+	}
+	if !isNotFoundError(err) {
+		return fmt.Errorf("failed to get VPN gateway %s: %v", side.gateway, err)
+	}
+
+	fmt.Printf("Creating HA VPN gateway: %s\n", side.gateway)
+	op, err := m.vpnGatewayClient.Insert(ctx, &computepb.InsertVpnGatewayRequest{
+		Project: m.config.ProjectID,
+		Region:  m.config.Region,
+		VpnGatewayResource: &computepb.VpnGateway{
+			Name:    stringPtr(side.gateway),
+			Network: stringPtr(m.networkURL(side.network)),
+			Labels:  m.config.Labels(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create VPN gateway %s: %v", side.gateway, err)
+	}
+	if err := m.ops.WaitRegional(ctx, op.Name()); err != nil {
+		return fmt.Errorf("failed to wait for VPN gateway creation: %v", err)
+	}
+	fmt.Printf("VPN gateway %s created\n", side.gateway)
+	return nil
+}
+
+func (m *Manager) createRouter(ctx context.Context, side vpnSide) error {
+	_, err := m.routerClient.Get(ctx, &computepb.GetRouterRequest{
+		Project: m.config.ProjectID, Region: m.config.Region, Router: side.router,
+	})
+	if err == nil {
+		fmt.Printf("Cloud Router %s already exists, skipping\n", side.router)
+		return nil
+	}
+	if !isNotFoundError(err) {
+		return fmt.Errorf("failed to get Cloud Router %s: %v", side.router, err)
+	}
+
+	fmt.Printf("Creating Cloud Router: %s (ASN %d)\n", side.router, side.asn)
+	asn := side.asn
+	op, err := m.routerClient.Insert(ctx, &computepb.InsertRouterRequest{
+		Project: m.config.ProjectID,
+		Region:  m.config.Region,
+		RouterResource: &computepb.Router{
+			Name:    stringPtr(side.router),
+			Network: stringPtr(m.networkURL(side.network)),
+			Bgp:     &computepb.RouterBgp{Asn: &asn},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Router %s: %v", side.router, err)
+	}
+	if err := m.ops.WaitRegional(ctx, op.Name()); err != nil {
+		return fmt.Errorf("failed to wait for Cloud Router creation: %v", err)
+	}
+	fmt.Printf("Cloud Router %s created\n", side.router)
+	return nil
+}
+
+func (m *Manager) tunnelExists(ctx context.Context, name string) (bool, error) {
+	_, err := m.vpnTunnelClient.Get(ctx, &computepb.GetVpnTunnelRequest{
+		Project: m.config.ProjectID, Region: m.config.Region, VpnTunnel: name,
+	})
+	if err == nil {
+		return true, nil
+	}
+	if isNotFoundError(err) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to get VPN tunnel %s: %v", name, err)
+}
+
+// createTunnel creates tunnel i of a side, from interface i of its gateway
+// to interface i of the gateway of the other side
+func (m *Manager) createTunnel(ctx context.Context, side vpnSide, i int, secret string) error {
+	name := side.tunnel(m.config, i)
+	fmt.Printf("Creating VPN tunnel: %s\n", name)
+	op, err := m.vpnTunnelClient.Insert(ctx, &computepb.InsertVpnTunnelRequest{
+		Project: m.config.ProjectID,
+		Region:  m.config.Region,
+		VpnTunnelResource: &computepb.VpnTunnel{
+			Name:                stringPtr(name),
+			VpnGateway:          stringPtr(m.regionalURL("vpnGateways", side.gateway)),
+			VpnGatewayInterface: int32Ptr(int32(i)),
+			PeerGcpGateway:      stringPtr(m.regionalURL("vpnGateways", side.peerGateway)),
+			Router:              stringPtr(m.regionalURL("routers", side.router)),
+			SharedSecret:        stringPtr(secret),
+			IkeVersion:          int32Ptr(2),
+			Labels:              m.config.Labels(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create VPN tunnel %s: %v", name, err)
+	}
+	if err := m.ops.WaitRegional(ctx, op.Name()); err != nil {
+		return fmt.Errorf("failed to wait for VPN tunnel creation: %v", err)
+	}
+	fmt.Printf("VPN tunnel %s created\n", name)
+	return nil
+}
+
+// configureBGP adds an interface and a BGP session for each tunnel to the
+// router of a side. Tunnel i uses the link local range 169.254.i.0/30.
+func (m *Manager) configureBGP(ctx context.Context, side vpnSide) error {
+	router, err := m.routerClient.Get(ctx, &computepb.GetRouterRequest{
+		Project: m.config.ProjectID, Region: m.config.Region, Router: side.router,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get Cloud Router %s: %v", side.router, err)
+	}
+	if len(router.GetBgpPeers()) > 0 {
+		fmt.Printf("Cloud Router %s already has BGP sessions, skipping\n", side.router)
+		return nil
+	}
+
+	fmt.Printf("Configuring BGP sessions of Cloud Router %s\n", side.router)
+	patch := &computepb.Router{}
+	for i, tunnel := range m.config.VPNTunnels(side.gateway) {
+		peerASN := side.peerASN
+		patch.Interfaces = append(patch.Interfaces, &computepb.RouterInterface{
+			Name:            stringPtr(routerInterfaceName(i)),
+			LinkedVpnTunnel: stringPtr(m.regionalURL("vpnTunnels", tunnel)),
+			IpRange:         stringPtr(fmt.Sprintf("169.254.%d.%d/30", i, side.host)),
+		})
+		patch.BgpPeers = append(patch.BgpPeers, &computepb.RouterBgpPeer{
+			Name:          stringPtr(bgpPeerName(i)),
+			InterfaceName: stringPtr(routerInterfaceName(i)),
+			PeerIpAddress: stringPtr(fmt.Sprintf("169.254.%d.%d", i, side.peerHost)),
+			PeerAsn:       &peerASN,
+		})
+	}
+
+	op, err := m.routerClient.Patch(ctx, &computepb.PatchRouterRequest{
+		Project:        m.config.ProjectID,
+		Region:         m.config.Region,
+		Router:         side.router,
+		RouterResource: patch,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure BGP sessions of Cloud Router %s: %v", side.router, err)
+	}
+	if err := m.ops.WaitRegional(ctx, op.Name()); err != nil {
+		return fmt.Errorf("failed to wait for Cloud Router update: %v", err)
+	}
+	fmt.Printf("BGP sessions of Cloud Router %s configured\n", side.router)
+	return nil
+}
+
+// vpnUp reports whether every tunnel is ESTABLISHED and every BGP session UP
+func (m *Manager) vpnUp(ctx context.Context) (bool, string, error) {
+	for _, side := range vpnSides(m.config) {
+		for _, name := range m.config.VPNTunnels(side.gateway) {
+			tunnel, err := m.vpnTunnelClient.Get(ctx, &computepb.GetVpnTunnelRequest{
+				Project: m.config.ProjectID, Region: m.config.Region, VpnTunnel: name,
+			})
+			if err != nil {
+				return false, "", fmt.Errorf("failed to get VPN tunnel %s: %v", name, err)
+			}
+			if tunnel.GetStatus() != "ESTABLISHED" {
+				return false, fmt.Sprintf("tunnel %s %s: %s", name, tunnel.GetStatus(), tunnel.GetDetailedStatus()), nil
+			}
+		}
+
+		status, err := m.routerClient.GetRouterStatus(ctx, &computepb.GetRouterStatusRouterRequest{
+			Project: m.config.ProjectID, Region: m.config.Region, Router: side.router,
+		})
+		if err != nil {
+			return false, "", fmt.Errorf("failed to get status of Cloud Router %s: %v", side.router, err)
+		}
+		peers := status.GetResult().GetBgpPeerStatus()
+		if len(peers) < 2 {
+			return false, fmt.Sprintf("router %s has %d BGP sessions", side.router, len(peers)), nil
+		}
+		for _, peer := range peers {
+			if peer.GetStatus() != "UP" {
+				return false, fmt.Sprintf("router %s session %s %s", side.router, peer.GetName(), peer.GetStatus()), nil
+			}
+		}
+	}
+	return true, "", nil
+}
+
+// poll calls ready every BackendHealthInterval until it reports true, for at
+// most BackendHealthTimeout
+func (m *Manager) poll(ctx context.Context, what string, ready func(context.Context) (bool, string, error)) error {
+	fmt.Printf("Waiting for %s...\n", what)
+	deadline := time.Now().Add(m.config.BackendHealthTimeout)
+	for {
+		ok, status, err := ready(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().Add(m.config.BackendHealthInterval).After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %s: %s", m.config.BackendHealthTimeout, what, status)
+		}
+		fmt.Printf("  not ready yet: %s\n", status)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.config.BackendHealthInterval):
+		}
+	}
+}
+
+// sharedSecret returns a random pre-shared key for a pair of tunnels
+func sharedSecret() (string, error) {
+	key := make([]byte, 24)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate VPN shared secret: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+func (m *Manager) networkURL(name string) string {
+	return fmt.Sprintf("projects/%s/global/networks/%s", m.config.ProjectID, name)
+}
+
+func (m *Manager) regionalURL(collection, name string) string {
+	return fmt.Sprintf("projects/%s/regions/%s/%s/%s", m.config.ProjectID, m.config.Region, collection, name)
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func isNotFoundError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "notFound") || strings.Contains(err.Error(), "not found"))
+}
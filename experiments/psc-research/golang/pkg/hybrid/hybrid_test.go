@@ -0,0 +1,224 @@
+package hybrid
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+	"gcp-psc-demo/pkg/gcpops"
+)
+
+const testProject = "test-project"
+
+func newTestManager(t *testing.T, backend string) (*Manager, *fakecompute.Server) {
+	t.Helper()
+
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+	cfg.ConnectivityBackend = backend
+	cfg.BackendHealthTimeout = time.Second
+	cfg.BackendHealthInterval = time.Millisecond
+
+	// The VPCs come from the vpc package in the demo
+	for _, name := range []string{cfg.ProviderVPC, cfg.ConsumerVPC} {
+		fake.Put("global/networks", name, map[string]any{})
+	}
+
+	manager, err := NewManager(cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	t.Cleanup(manager.Close)
+
+	manager.ops.Backoff = gcpops.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	return manager, fake
+}
+
+func TestSetupPeering(t *testing.T) {
+	manager, fake := newTestManager(t, config.BackendPeering)
+	cfg := manager.config
+	ctx := context.Background()
+
+	if err := manager.Setup(ctx); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	for _, p := range [][2]string{{cfg.ProviderVPC, cfg.ConsumerVPC}, {cfg.ConsumerVPC, cfg.ProviderVPC}} {
+		peerings, _ := fake.Get("global/networks", p[0])["peerings"].([]any)
+		if len(peerings) != 1 {
+			t.Fatalf("peerings of %s = %v, want one", p[0], peerings)
+		}
+		peering := peerings[0].(map[string]any)
+		if peering["name"] != p[1] || !strings.HasSuffix(peering["network"].(string), "/networks/"+p[1]) || peering["exchangeSubnetRoutes"] != true {
+			t.Errorf("peering of %s = %v, want %s exchanging subnet routes", p[0], peering, p[1])
+		}
+	}
+
+	// Peerings are only added once
+	if err := manager.Setup(ctx); err != nil {
+		t.Fatalf("second Setup() error = %v", err)
+	}
+	if got := fake.Count(http.MethodPost, "networks", "addPeering"); got != 2 {
+		t.Errorf("addPeering calls = %d, want 2", got)
+	}
+}
+
+func TestSetupVPN(t *testing.T) {
+	manager, fake := newTestManager(t, config.BackendVPN)
+	cfg := manager.config
+	ctx := context.Background()
+	regional := "regions/" + cfg.Region + "/"
+
+	if err := manager.Setup(ctx); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	if got := fake.Names(regional + "vpnGateways"); len(got) != 2 {
+		t.Errorf("VPN gateways = %v, want 2", got)
+	}
+	if asn := fake.Get(regional+"routers", cfg.ConsumerRouter)["bgp"].(map[string]any)["asn"]; asn != float64(ConsumerASN) {
+		t.Errorf("consumer router ASN = %v, want %d", asn, ConsumerASN)
+	}
+
+	// Tunnel i of both gateways shares its secret and points at the other gateway
+	for i := range 2 {
+		provider := fake.Get(regional+"vpnTunnels", cfg.VPNTunnels(cfg.ProviderVPNGateway)[i])
+		consumer := fake.Get(regional+"vpnTunnels", cfg.VPNTunnels(cfg.ConsumerVPNGateway)[i])
+		if provider == nil || consumer == nil {
+			t.Fatalf("tunnel pair %d = %v, %v, want both", i, provider, consumer)
+		}
+		if provider["sharedSecret"] == "" || provider["sharedSecret"] != consumer["sharedSecret"] {
+			t.Errorf("tunnel pair %d secrets differ", i)
+		}
+		if !strings.HasSuffix(provider["peerGcpGateway"].(string), "/"+cfg.ConsumerVPNGateway) {
+			t.Errorf("provider tunnel %d peer = %v, want %s", i, provider["peerGcpGateway"], cfg.ConsumerVPNGateway)
+		}
+	}
+
+	router := fake.Get(regional+"routers", cfg.ProviderRouter)
+	peers, _ := router["bgpPeers"].([]any)
+	interfaces, _ := router["interfaces"].([]any)
+	if len(peers) != 2 || len(interfaces) != 2 {
+		t.Fatalf("provider router = %v, want 2 interfaces and BGP peers", router)
+	}
+	if peer := peers[1].(map[string]any); peer["peerIpAddress"] != "169.254.1.2" || peer["peerAsn"] != float64(ConsumerASN) {
+		t.Errorf("provider BGP peer 1 = %v, want 169.254.1.2 in AS %d", peer, ConsumerASN)
+	}
+	if router["network"] == nil {
+		t.Error("configuring BGP dropped the router network")
+	}
+
+	// A second run creates nothing
+	requests := len(fake.Requests())
+	if err := manager.Setup(ctx); err != nil {
+		t.Fatalf("second Setup() error = %v", err)
+	}
+	for _, r := range fake.Requests()[requests:] {
+		if r.Method != http.MethodGet {
+			t.Errorf("second run issued %s %s/%s", r.Method, r.Collection, r.Name)
+		}
+	}
+}
+
+func TestSetupVPN_HalfTunnelPair(t *testing.T) {
+	manager, fake := newTestManager(t, config.BackendVPN)
+	cfg := manager.config
+	fake.Put("regions/"+cfg.Region+"/vpnTunnels", cfg.VPNTunnels(cfg.ProviderVPNGateway)[0], map[string]any{})
+
+	err := manager.Setup(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "only one of VPN tunnels") {
+		t.Errorf("Setup() error = %v, want the half pair reported", err)
+	}
+}
+
+func TestResources(t *testing.T) {
+	cfg := config.NewConfig()
+	for backend, want := range map[string]int{config.BackendPSC: 5, config.BackendPeering: 3, config.BackendVPN: 13} {
+		cfg.ConnectivityBackend = backend
+		if got := Resources(cfg); len(got) != want {
+			t.Errorf("Resources(%s) = %v, want %d", backend, got, want)
+		}
+	}
+}
+
+func TestParseTimings(t *testing.T) {
+	samples, failures := ParseTimings("0.012\nfail\n\nWarning: something\n0.0205\nfail\n")
+	if len(samples) != 2 || samples[0] != 12 || samples[1] != 20.5 {
+		t.Errorf("samples = %v, want [12 20.5]", samples)
+	}
+	if failures != 2 {
+		t.Errorf("failures = %d, want 2", failures)
+	}
+}
+
+func testRecord(runID, backend string, samples ...float64) *Record {
+	return &Record{
+		RunID:        runID,
+		Backend:      backend,
+		SetupSeconds: 60,
+		Resources:    []string{"a", "b"},
+		Requests:     len(samples) + 1,
+		Failures:     1,
+		Samples:      samples,
+		Isolation:    map[string]bool{"lb-direct": backend != config.BackendPSC, "consumer-ssh": false},
+	}
+}
+
+func TestAppendLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "comparison.jsonl")
+
+	if records, err := Load(path); err != nil || records != nil {
+		t.Fatalf("Load() of a missing log = %v, %v, want none", records, err)
+	}
+	for _, runID := range []string{"a", "b"} {
+		if err := Append(path, testRecord(runID, config.BackendVPN, 1, 2, 3)); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	records, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(records) != 2 || records[0].RunID != "a" || records[1].RunID != "b" {
+		t.Fatalf("Load() = %+v, want runs a and b", records)
+	}
+	if got := records[1].Samples; len(got) != 3 || !records[1].Isolation["lb-direct"] {
+		t.Errorf("loaded record = %+v, want its samples and isolation", records[1])
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	records := []Record{
+		*testRecord("vpn", config.BackendVPN, 5, 6),
+		*testRecord("psc-1", config.BackendPSC, 1, 2, 3, 4, 5),
+		*testRecord("psc-2", config.BackendPSC, 6, 7, 8, 9, 10),
+	}
+
+	stats := Summarize(records)
+	if len(stats) != 2 || stats[0].Backend != config.BackendPSC || stats[1].Backend != config.BackendVPN {
+		t.Fatalf("Summarize() = %+v, want psc then vpn", stats)
+	}
+	psc := stats[0]
+	if psc.Runs != 2 || psc.Median != 5 || psc.P90 != 9 || psc.Requests != 12 || psc.Failures != 2 {
+		t.Errorf("psc stats = %+v, want 2 runs, median 5, p90 9, 2 of 12 failed", psc)
+	}
+	if psc.Reachable["lb-direct"] != 0 || stats[1].Reachable["lb-direct"] != 1 {
+		t.Errorf("lb-direct reached = %d, %d, want 0 for psc and 1 for vpn", psc.Reachable["lb-direct"], stats[1].Reachable["lb-direct"])
+	}
+
+	var out bytes.Buffer
+	WriteSummary(&out, stats)
+	if !strings.Contains(out.String(), "5.0ms") || !strings.Contains(out.String(), "consumer-ssh") {
+		t.Errorf("summary does not show the psc median and the probes:\n%s", out.String())
+	}
+}
@@ -0,0 +1,267 @@
+package hybrid
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/stats"
+)
+
+// Probe is a connection the isolation part of the comparison attempts.
+// Reaching it means the backend exposes more than the service.
+type Probe struct {
+	Name        string
+	Description string
+}
+
+// Probes are the connections every comparison attempts
+var Probes = []Probe{
+	{Name: "lb-direct", Description: "Consumer VM reaches the provider load balancer by its internal IP"},
+	{Name: "provider-vm", Description: "Consumer VM reaches the service port of the provider VM, bypassing the load balancer"},
+	{Name: "provider-ssh", Description: "Consumer VM reaches SSH on the provider VM"},
+	{Name: "consumer-ssh", Description: "Provider VM reaches SSH on the consumer VM"},
+}
+
+// Record is the comparison of one demo run, one line of the log
+type Record struct {
+	RunID      string    `json:"runId"`
+	ProjectID  string    `json:"projectId"`
+	Region     string    `json:"region"`
+	Backend    string    `json:"backend"`
+	MeasuredAt time.Time `json:"measuredAt"`
+	// SetupSeconds is the time from the first resource of the backend until
+	// the service was reachable through it
+	SetupSeconds float64 `json:"setupSeconds"`
+	// Resources are the resources the backend needs on top of the shared
+	// topology, see Resources
+	Resources []string `json:"resources"`
+	Requests  int      `json:"requests"`
+	Failures  int      `json:"failures"`
+	// Samples are the total times of the successful requests in milliseconds
+	Samples []float64 `json:"samples"`
+	// Isolation reports by Probe.Name whether the connection succeeded
+	Isolation map[string]bool `json:"isolation"`
+	// ConfigHash groups the runs of the same configuration
+	ConfigHash string `json:"configHash"`
+}
+
+// NewRecord returns the record of the current run of cfg, without results
+func NewRecord(cfg *config.Config) *Record {
+	return &Record{
+		RunID:      cfg.RunID,
+		ProjectID:  cfg.ProjectID,
+		Region:     cfg.Region,
+		Backend:    cfg.ConnectivityBackend,
+		MeasuredAt: time.Now().UTC(),
+		Resources:  Resources(cfg),
+		Isolation:  make(map[string]bool),
+		ConfigHash: cfg.Hash(),
+	}
+}
+
+// ParseTimings reads the output of the request loop on the consumer VM, one
+// line per request holding either the curl time_total in seconds or "fail".
+// It returns the times of the successful requests in milliseconds and the
+// number of failed ones; other lines are ignored.
+func ParseTimings(output string) ([]float64, int) {
+	var samples []float64
+	failures := 0
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "fail" {
+			failures++
+			continue
+		}
+		if seconds, err := strconv.ParseFloat(line, 64); err == nil {
+			samples = append(samples, seconds*1000)
+		}
+	}
+	return samples, failures
+}
+
+// Write prints the results of the record
+func (r *Record) Write(w io.Writer) {
+	fmt.Fprintf(w, "Connectivity comparison of run %s in %s, backend %s:\n", r.RunID, r.Region, r.Backend)
+	fmt.Fprintf(w, "  %-14s %.1fs\n", "setup", r.SetupSeconds)
+	fmt.Fprintf(w, "  %-14s %d\n", "resources", len(r.Resources))
+	if len(r.Samples) > 0 {
+		sorted := append([]float64(nil), r.Samples...)
+		sort.Float64s(sorted)
+		fmt.Fprintf(w, "  %-14s %s median, %s p90 over %d requests\n", "latency",
+			millis(stats.Percentile(sorted, 50)), millis(stats.Percentile(sorted, 90)), len(sorted))
+	}
+	fmt.Fprintf(w, "  %-14s %d of %d\n", "failures", r.Failures, r.Requests)
+	for _, p := range Probes {
+		fmt.Fprintf(w, "  %-14s %s\n", p.Name, reachability(r.Isolation, p.Name))
+	}
+}
+
+// Append adds a record as one JSON line to the log at path
+func Append(path string, r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal comparison record: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open comparison log %s: %v", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write comparison log %s: %v", path, err)
+	}
+	return nil
+}
+
+// Load reads every record of the log at path, returning none without error
+// if it does not exist
+func Load(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read comparison log %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	// Each record carries its latency samples, lines outgrow the default buffer
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal([]byte(text), &r); err != nil {
+			return nil, fmt.Errorf("failed to parse comparison log %s line %d: %v", path, line, err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read comparison log %s: %v", path, err)
+	}
+	return records, nil
+}
+
+// Stats summarizes the runs of one backend
+type Stats struct {
+	Backend string
+	Runs    int
+	// Setup is the median setup time
+	Setup     time.Duration
+	Resources int
+	// Median and P90 are over the samples of all runs, in milliseconds
+	Median   float64
+	P90      float64
+	Requests int
+	Failures int
+	// Reachable counts by Probe.Name the runs in which the probe succeeded
+	Reachable map[string]int
+}
+
+// Summarize returns the statistics of every backend with runs, in the order
+// PSC, peering, VPN
+func Summarize(records []Record) []Stats {
+	var summary []Stats
+	for _, backend := range []string{config.BackendPSC, config.BackendPeering, config.BackendVPN} {
+		s := Stats{Backend: backend, Reachable: make(map[string]int)}
+		var setups []time.Duration
+		var samples []float64
+		for _, r := range records {
+			if r.Backend != backend {
+				continue
+			}
+			s.Runs++
+			setups = append(setups, time.Duration(r.SetupSeconds*float64(time.Second)))
+			s.Resources = max(s.Resources, len(r.Resources))
+			samples = append(samples, r.Samples...)
+			s.Requests += r.Requests
+			s.Failures += r.Failures
+			for name, reached := range r.Isolation {
+				if reached {
+					s.Reachable[name]++
+				}
+			}
+		}
+		if s.Runs == 0 {
+			continue
+		}
+		sort.Slice(setups, func(i, j int) bool { return setups[i] < setups[j] })
+		s.Setup = setups[(len(setups)+1)/2-1]
+		if len(samples) > 0 {
+			sort.Float64s(samples)
+			s.Median = stats.Percentile(samples, 50)
+			s.P90 = stats.Percentile(samples, 90)
+		}
+		summary = append(summary, s)
+	}
+	return summary
+}
+
+// WriteSummary prints the statistics of every backend as a table, followed
+// by the runs in which each isolation probe got through
+func WriteSummary(w io.Writer, stats []Stats) {
+	fmt.Fprintf(w, "%-8s %5s %8s %9s %8s %8s %9s\n", "backend", "runs", "setup", "resources", "median", "p90", "failures")
+	for _, s := range stats {
+		median, p90 := "-", "-"
+		if s.Requests > s.Failures {
+			median, p90 = millis(s.Median), millis(s.P90)
+		}
+		fmt.Fprintf(w, "%-8s %5d %8s %9d %8s %8s %9s\n", s.Backend, s.Runs,
+			fmt.Sprintf("%.0fs", s.Setup.Seconds()), s.Resources, median, p90,
+			fmt.Sprintf("%d/%d", s.Failures, s.Requests))
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-8s", "reached")
+	for _, p := range Probes {
+		fmt.Fprintf(w, " %13s", p.Name)
+	}
+	fmt.Fprintln(w)
+	for _, s := range stats {
+		fmt.Fprintf(w, "%-8s", s.Backend)
+		for _, p := range Probes {
+			fmt.Fprintf(w, " %13s", fmt.Sprintf("%d/%d", s.Reachable[p.Name], s.Runs))
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-13s %s\n", "setup", "Median time until the service was reachable through the backend")
+	fmt.Fprintf(w, "%-13s %s\n", "resources", "Resources the backend adds to the VPCs, VMs and load balancer")
+	fmt.Fprintf(w, "%-13s %s\n", "median, p90", "Request latency from the consumer VM over all runs")
+	for _, p := range Probes {
+		fmt.Fprintf(w, "%-13s %s\n", p.Name, p.Description)
+	}
+}
+
+// reachability renders the result of a probe, "-" when it was not run
+func reachability(isolation map[string]bool, probe string) string {
+	reached, ok := isolation[probe]
+	switch {
+	case !ok:
+		return "-"
+	case reached:
+		return "reachable"
+	default:
+		return "blocked"
+	}
+}
+
+func millis(ms float64) string {
+	return fmt.Sprintf("%.1fms", ms)
+}
@@ -0,0 +1,189 @@
+// Package iamaudit records the Compute Engine API methods a run calls,
+// through an interceptor on the HTTP transport of the REST clients, and maps
+// them to the IAM permissions they need. The permissions make up the custom
+// role of least privilege a provisioning service account needs to do what
+// the demo does.
+package iamaudit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"gopkg.in/yaml.v3"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// Call is an API method a run invoked
+type Call struct {
+	// Method is the API method, e.g. compute.instances.insert, or the HTTP
+	// method and path of a request that is not a Compute API method
+	Method string `json:"method"`
+	// Permissions are what the method needs with the request it was called
+	// with, none for an unknown method
+	Permissions []string `json:"permissions"`
+	Count       int      `json:"count"`
+}
+
+// Recorder records the API calls made through its transport. It is safe
+// for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	calls map[string]*Call
+}
+
+// NewRecorder creates an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{calls: map[string]*Call{}}
+}
+
+// ClientOptions returns the options that make a Compute REST client send
+// its requests through the recorder, authenticated with the application
+// default credentials like a client built without options. opts are the
+// options of the credentials, if any.
+func (r *Recorder) ClientOptions(ctx context.Context, opts ...option.ClientOption) ([]option.ClientOption, error) {
+	opts = append([]option.ClientOption{option.WithScopes(cloudPlatformScope)}, opts...)
+	transport, err := htransport.NewTransport(ctx, r.Transport(http.DefaultTransport), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the recording transport: %v", err)
+	}
+	return []option.ClientOption{option.WithHTTPClient(&http.Client{Transport: transport})}, nil
+}
+
+// Transport returns a transport recording every request before sending it
+// with base
+func (r *Recorder) Transport(base http.RoundTripper) http.RoundTripper {
+	return roundTripper{recorder: r, base: base}
+}
+
+type roundTripper struct {
+	recorder *Recorder
+	base     http.RoundTripper
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body map[string]any
+	if req.Body != nil && req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			json.Unmarshal(data, &body)
+		}
+	} else if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		json.Unmarshal(data, &body)
+	}
+	t.recorder.record(req, body)
+	return t.base.RoundTrip(req)
+}
+
+func (r *Recorder) record(req *http.Request, body map[string]any) {
+	method, permissions := methodOf(req.Method, req.URL.Path, body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	call, ok := r.calls[method]
+	if !ok {
+		call = &Call{Method: method}
+		r.calls[method] = call
+	}
+	call.Count++
+	call.Permissions = union(call.Permissions, permissions)
+}
+
+// Calls returns the methods called so far, sorted by name
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := make([]Call, 0, len(r.calls))
+	for _, call := range r.calls {
+		c := *call
+		c.Permissions = append([]string(nil), call.Permissions...)
+		calls = append(calls, c)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Method < calls[j].Method })
+	return calls
+}
+
+// Permissions returns the sorted permissions of every method called so far
+func (r *Recorder) Permissions() []string {
+	var permissions []string
+	for _, call := range r.Calls() {
+		permissions = union(permissions, call.Permissions)
+	}
+	return permissions
+}
+
+// Unknown returns the calls no permission is known for
+func (r *Recorder) Unknown() []Call {
+	var unknown []Call
+	for _, call := range r.Calls() {
+		if len(call.Permissions) == 0 {
+			unknown = append(unknown, call)
+		}
+	}
+	return unknown
+}
+
+// Role is a custom role definition in the format of
+// `gcloud iam roles create --file`
+type Role struct {
+	Title               string   `yaml:"title"`
+	Description         string   `yaml:"description"`
+	Stage               string   `yaml:"stage"`
+	IncludedPermissions []string `yaml:"includedPermissions"`
+}
+
+// WriteRole writes the custom role of the recorded permissions to path. The
+// permissions of a role already in the file are kept, so the runs of several
+// commands, e.g. the demo and its cleanup, add up to one role.
+func (r *Recorder) WriteRole(path, title, description string) (*Role, error) {
+	role := &Role{Title: title, Description: description, Stage: "GA"}
+	if data, err := os.ReadFile(path); err == nil {
+		var existing Role
+		if err := yaml.Unmarshal(data, &existing); err != nil {
+			return nil, fmt.Errorf("failed to parse role file %s: %v", path, err)
+		}
+		role.IncludedPermissions = existing.IncludedPermissions
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read role file %s: %v", path, err)
+	}
+	role.IncludedPermissions = union(role.IncludedPermissions, r.Permissions())
+
+	data, err := yaml.Marshal(role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode role: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write role file %s: %v", path, err)
+	}
+	return role, nil
+}
+
+// union returns the sorted, distinct strings of a and b
+func union(a, b []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range append(append([]string(nil), a...), b...) {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,216 @@
+package iamaudit
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/option"
+	"gopkg.in/yaml.v3"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+	"gcp-psc-demo/pkg/vpc"
+)
+
+func TestMethodOf(t *testing.T) {
+	const p = "/compute/v1/projects/test-project"
+	tests := []struct {
+		name       string
+		httpMethod string
+		path       string
+		body       map[string]any
+		wantMethod string
+		wantPerms  []string
+	}{
+		{
+			name: "get network", httpMethod: "GET", path: p + "/global/networks/provider-vpc",
+			wantMethod: "compute.networks.get", wantPerms: []string{"compute.networks.get"},
+		},
+		{
+			name: "list zone instances", httpMethod: "GET", path: p + "/zones/us-central1-a/instances",
+			wantMethod: "compute.instances.list", wantPerms: []string{"compute.instances.list"},
+		},
+		{
+			name: "wait on region operation", httpMethod: "POST", path: p + "/regions/us-central1/operations/op-1/wait",
+			wantMethod: "compute.regionOperations.wait", wantPerms: []string{"compute.regionOperations.get"},
+		},
+		{
+			name: "regional backend service health", httpMethod: "POST", path: p + "/regions/us-central1/backendServices/be/getHealth",
+			wantMethod: "compute.regionBackendServices.getHealth", wantPerms: []string{"compute.regionBackendServices.get"},
+		},
+		{
+			name: "insert firewall", httpMethod: "POST", path: p + "/global/firewalls",
+			wantMethod: "compute.firewalls.insert",
+			wantPerms:  []string{"compute.firewalls.create", "compute.networks.updatePolicy"},
+		},
+		{
+			name: "insert instance", httpMethod: "POST", path: p + "/zones/us-central1-a/instances",
+			body: map[string]any{
+				"disks": []any{map[string]any{"initializeParams": map[string]any{"sourceImage": "projects/debian-cloud/global/images/family/debian-12"}}},
+				"networkInterfaces": []any{map[string]any{
+					"subnetwork":    "regions/us-central1/subnetworks/provider-subnet",
+					"accessConfigs": []any{map[string]any{"type": "ONE_TO_ONE_NAT"}},
+				}},
+				"metadata": map[string]any{"items": []any{map[string]any{"key": "startup-script"}}},
+				"tags":     map[string]any{"items": []any{"provider"}},
+			},
+			wantMethod: "compute.instances.insert",
+			wantPerms: []string{
+				"compute.disks.create", "compute.images.useReadOnly", "compute.instances.create",
+				"compute.instances.setMetadata", "compute.instances.setTags",
+				"compute.subnetworks.use", "compute.subnetworks.useExternalIp",
+			},
+		},
+		{
+			name: "insert PSC endpoint", httpMethod: "POST", path: p + "/regions/us-central1/forwardingRules",
+			body: map[string]any{
+				"target":    "projects/test-project/regions/us-central1/serviceAttachments/sa",
+				"network":   "global/networks/consumer-vpc",
+				"IPAddress": "regions/us-central1/addresses/endpoint-ip",
+			},
+			wantMethod: "compute.forwardingRules.insert",
+			wantPerms: []string{
+				"compute.addresses.use", "compute.forwardingRules.create",
+				"compute.forwardingRules.pscCreate", "compute.networks.use",
+			},
+		},
+		{
+			name: "insert regional backend service", httpMethod: "POST", path: p + "/regions/us-central1/backendServices",
+			body: map[string]any{
+				"healthChecks": []any{"regions/us-central1/healthChecks/hc"},
+				"backends":     []any{map[string]any{"group": "zones/us-central1-a/instanceGroups/ig"}},
+			},
+			wantMethod: "compute.regionBackendServices.insert",
+			wantPerms: []string{
+				"compute.instanceGroups.use", "compute.regionBackendServices.create",
+				"compute.regionHealthChecks.useReadOnly",
+			},
+		},
+		{
+			name: "add instances to group", httpMethod: "POST", path: p + "/zones/us-central1-a/instanceGroups/ig/addInstances",
+			wantMethod: "compute.instanceGroups.addInstances",
+			wantPerms:  []string{"compute.instanceGroups.update", "compute.instances.use"},
+		},
+		{
+			name: "serial port output", httpMethod: "GET", path: p + "/zones/us-central1-a/instances/vm/serialPort",
+			wantMethod: "compute.instances.getSerialPortOutput", wantPerms: []string{"compute.instances.getSerialPortOutput"},
+		},
+		{
+			name: "router status", httpMethod: "GET", path: p + "/regions/us-central1/routers/r/getRouterStatus",
+			wantMethod: "compute.routers.getRouterStatus", wantPerms: []string{"compute.routers.get"},
+		},
+		{
+			name: "VPN tunnel", httpMethod: "POST", path: p + "/regions/us-central1/vpnTunnels",
+			body: map[string]any{
+				"vpnGateway":     "projects/p/regions/us-central1/vpnGateways/gw",
+				"peerGcpGateway": "projects/p/regions/us-central1/vpnGateways/peer",
+				"router":         "projects/p/regions/us-central1/routers/r",
+			},
+			wantMethod: "compute.vpnTunnels.insert",
+			wantPerms:  []string{"compute.routers.use", "compute.vpnGateways.use", "compute.vpnTunnels.create"},
+		},
+		{
+			name: "aggregated addresses", httpMethod: "GET", path: p + "/aggregated/addresses",
+			wantMethod: "compute.addresses.aggregatedList", wantPerms: []string{"compute.addresses.list"},
+		},
+		{
+			name: "project metadata", httpMethod: "POST", path: p + "/setCommonInstanceMetadata",
+			wantMethod: "compute.projects.setCommonInstanceMetadata", wantPerms: []string{"compute.projects.setCommonInstanceMetadata"},
+		},
+		{
+			name: "not a compute method", httpMethod: "GET", path: "/storage/v1/b/bucket",
+			wantMethod: "GET /storage/v1/b/bucket",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, perms := methodOf(tt.httpMethod, tt.path, tt.body)
+			if method != tt.wantMethod {
+				t.Errorf("methodOf() method = %q, want %q", method, tt.wantMethod)
+			}
+			if !reflect.DeepEqual(perms, tt.wantPerms) {
+				t.Errorf("methodOf() permissions = %v, want %v", perms, tt.wantPerms)
+			}
+		})
+	}
+}
+
+func TestRecorder_VPCManager(t *testing.T) {
+	fake := fakecompute.New("test-project")
+	defer fake.Close()
+
+	recorder := NewRecorder()
+	opts := []option.ClientOption{
+		option.WithEndpoint(fake.URL),
+		option.WithoutAuthentication(),
+		option.WithHTTPClient(&http.Client{Transport: recorder.Transport(fake.Client().Transport)}),
+	}
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = "test-project"
+	manager, err := vpc.NewVPCManager(cfg, opts...)
+	if err != nil {
+		t.Fatalf("NewVPCManager() error = %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateProviderVPC(context.Background()); err != nil {
+		t.Fatalf("CreateProviderVPC() error = %v", err)
+	}
+
+	permissions := map[string]bool{}
+	for _, p := range recorder.Permissions() {
+		permissions[p] = true
+	}
+	for _, want := range []string{
+		"compute.networks.create", "compute.subnetworks.create",
+		"compute.firewalls.create", "compute.networks.updatePolicy",
+	} {
+		if !permissions[want] {
+			t.Errorf("Permissions() = %v, missing %s", recorder.Permissions(), want)
+		}
+	}
+	if unknown := recorder.Unknown(); len(unknown) != 0 {
+		t.Errorf("Unknown() = %v, want none", unknown)
+	}
+}
+
+func TestRecorder_WriteRole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "role.yaml")
+
+	demo := NewRecorder()
+	demo.record(&http.Request{Method: "POST", URL: &url.URL{Path: "/compute/v1/projects/p/global/networks"}}, nil)
+	if _, err := demo.WriteRole(path, "PSC demo", "demo"); err != nil {
+		t.Fatalf("WriteRole() error = %v", err)
+	}
+
+	// A cleanup run adds its permissions to the demo's
+	cleanup := NewRecorder()
+	cleanup.record(&http.Request{Method: "DELETE", URL: &url.URL{Path: "/compute/v1/projects/p/global/networks/vpc"}}, nil)
+	role, err := cleanup.WriteRole(path, "PSC demo", "demo")
+	if err != nil {
+		t.Fatalf("WriteRole() error = %v", err)
+	}
+	want := []string{"compute.networks.create", "compute.networks.delete"}
+	if !reflect.DeepEqual(role.IncludedPermissions, want) {
+		t.Errorf("IncludedPermissions = %v, want %v", role.IncludedPermissions, want)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written Role
+	if err := yaml.Unmarshal(data, &written); err != nil {
+		t.Fatalf("role file is not YAML: %v", err)
+	}
+	if written.Stage != "GA" || !reflect.DeepEqual(written.IncludedPermissions, want) {
+		t.Errorf("role file = %+v, want stage GA and permissions %v", written, want)
+	}
+}
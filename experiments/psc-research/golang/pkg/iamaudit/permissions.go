@@ -0,0 +1,254 @@
+package iamaudit
+
+import (
+	"net"
+	"strings"
+)
+
+// computePrefix starts the path of every Compute API request
+const computePrefix = "/compute/v1/projects/"
+
+// scopedResources names the resources whose global and regional variants
+// have their own permissions, by scope and collection
+var scopedResources = map[string]string{
+	"global/addresses":        "globalAddresses",
+	"regions/addresses":       "addresses",
+	"global/backendServices":  "backendServices",
+	"regions/backendServices": "regionBackendServices",
+	"global/forwardingRules":  "globalForwardingRules",
+	"regions/forwardingRules": "forwardingRules",
+	"global/healthChecks":     "healthChecks",
+	"regions/healthChecks":    "regionHealthChecks",
+	"global/operations":       "globalOperations",
+	"regions/operations":      "regionOperations",
+	"zones/operations":        "zoneOperations",
+}
+
+// actionPermissions are the permissions of the custom methods not named
+// after their permission
+var actionPermissions = map[string]string{
+	"addInstances":    "update",
+	"getHealth":       "get",
+	"getRouterStatus": "get",
+	"listInstances":   "list",
+	"removeInstances": "update",
+	"setNamedPorts":   "update",
+	"wait":            "get",
+}
+
+// getActions are the custom methods read with GET, by path segment
+var getActions = map[string]string{
+	"serialPort":      "getSerialPortOutput",
+	"guestAttributes": "getGuestAttributes",
+}
+
+// methodOf returns the API method of a request and the permissions it
+// needs. Requests outside the Compute API are returned as their HTTP method
+// and path, without permissions.
+func methodOf(httpMethod, path string, body map[string]any) (string, []string) {
+	i := strings.Index(path, computePrefix)
+	if i < 0 {
+		return httpMethod + " " + path, nil
+	}
+	segments := strings.Split(strings.Trim(path[i+len(computePrefix):], "/"), "/")[1:]
+
+	// Methods of the project itself, e.g. setCommonInstanceMetadata
+	if len(segments) <= 1 {
+		verb := "get"
+		if len(segments) == 1 {
+			verb = segments[0]
+		}
+		return "compute.projects." + verb, []string{"compute.projects." + verb}
+	}
+
+	var scope string
+	switch segments[0] {
+	case "global", "aggregated":
+		scope, segments = segments[0], segments[1:]
+	case "regions", "zones":
+		if len(segments) < 3 {
+			return httpMethod + " " + path, nil
+		}
+		scope, segments = segments[0], segments[2:]
+	default:
+		return httpMethod + " " + path, nil
+	}
+
+	collection := segments[0]
+	resource, ok := scopedResources[scope+"/"+collection]
+	if !ok {
+		resource = collection
+	}
+	if scope == "aggregated" {
+		return "compute." + resource + ".aggregatedList", []string{"compute." + resource + ".list"}
+	}
+
+	var name, action string
+	if len(segments) > 1 {
+		name = segments[1]
+	}
+	if len(segments) > 2 {
+		action = segments[2]
+	}
+
+	var verb, permission string
+	switch {
+	case action != "" && httpMethod == "GET":
+		verb = getActions[action]
+		if verb == "" {
+			verb = action
+		}
+		permission = verb
+		if p, ok := actionPermissions[action]; ok {
+			permission = p
+		}
+	case action != "":
+		verb, permission = action, action
+		if p, ok := actionPermissions[action]; ok {
+			permission = p
+		}
+	case httpMethod == "GET" && name != "":
+		verb, permission = "get", "get"
+	case httpMethod == "GET":
+		verb, permission = "list", "list"
+	case httpMethod == "POST":
+		verb, permission = "insert", "create"
+	case httpMethod == "DELETE":
+		verb, permission = "delete", "delete"
+	case httpMethod == "PATCH":
+		verb, permission = "patch", "update"
+	case httpMethod == "PUT":
+		verb, permission = "update", "update"
+	default:
+		return httpMethod + " " + path, nil
+	}
+
+	permissions := []string{"compute." + resource + "." + permission}
+	return "compute." + resource + "." + verb, union(permissions, impliedPermissions(resource, verb, body))
+}
+
+// impliedPermissions are what a method needs on the other resources its
+// request uses, e.g. the subnet and image of a new instance
+func impliedPermissions(resource, verb string, body map[string]any) []string {
+	var permissions []string
+	add := func(p ...string) { permissions = append(permissions, p...) }
+
+	switch resource + "." + verb {
+	case "instances.insert":
+		add("compute.disks.create")
+		for _, disk := range list(body, "disks") {
+			if params, ok := disk["initializeParams"].(map[string]any); ok && str(params, "sourceImage") != "" {
+				add("compute.images.useReadOnly")
+			}
+		}
+		for _, nic := range list(body, "networkInterfaces") {
+			if str(nic, "subnetwork") != "" {
+				add("compute.subnetworks.use")
+			} else if str(nic, "network") != "" {
+				add("compute.networks.use")
+			}
+			if len(list(nic, "accessConfigs")) > 0 {
+				add("compute.subnetworks.useExternalIp")
+			}
+		}
+		if metadata, ok := body["metadata"].(map[string]any); ok && len(list(metadata, "items")) > 0 {
+			add("compute.instances.setMetadata")
+		}
+		if tags, ok := body["tags"].(map[string]any); ok && tags["items"] != nil {
+			add("compute.instances.setTags")
+		}
+		if body["labels"] != nil {
+			add("compute.instances.setLabels")
+		}
+		if len(list(body, "serviceAccounts")) > 0 {
+			add("compute.instances.setServiceAccount", "iam.serviceAccounts.actAs")
+		}
+
+	case "firewalls.insert", "firewalls.patch", "firewalls.update", "firewalls.delete":
+		add("compute.networks.updatePolicy")
+
+	case "addresses.insert":
+		if str(body, "addressType") == "INTERNAL" {
+			add("compute.addresses.createInternal")
+		}
+		if str(body, "subnetwork") != "" {
+			add("compute.subnetworks.use")
+		}
+
+	case "forwardingRules.insert":
+		if strings.Contains(str(body, "target"), "/serviceAttachments/") {
+			add("compute.forwardingRules.pscCreate")
+		}
+		if str(body, "backendService") != "" {
+			add("compute.regionBackendServices.use")
+		}
+		if str(body, "subnetwork") != "" {
+			add("compute.subnetworks.use")
+		}
+		if str(body, "network") != "" {
+			add("compute.networks.use")
+		}
+		// A reserved address is referred to by name or URL, an ephemeral one
+		// is a literal IP
+		if address := str(body, "IPAddress"); address != "" && net.ParseIP(address) == nil {
+			add("compute.addresses.use")
+		}
+
+	case "serviceAttachments.insert", "serviceAttachments.patch":
+		if str(body, "producerForwardingRule") != "" {
+			add("compute.forwardingRules.use")
+		}
+		if body["natSubnets"] != nil {
+			add("compute.subnetworks.use")
+		}
+
+	case "regionBackendServices.insert", "regionBackendServices.patch", "regionBackendServices.update":
+		healthChecks, _ := body["healthChecks"].([]any)
+		for _, hc := range healthChecks {
+			if s, _ := hc.(string); strings.Contains(s, "regions/") {
+				add("compute.regionHealthChecks.useReadOnly")
+			} else {
+				add("compute.healthChecks.useReadOnly")
+			}
+		}
+		if len(list(body, "backends")) > 0 {
+			add("compute.instanceGroups.use")
+		}
+
+	case "instanceGroups.addInstances", "instanceGroups.removeInstances":
+		add("compute.instances.use")
+
+	case "vpnGateways.insert":
+		add("compute.networks.use")
+
+	case "routers.insert":
+		add("compute.networks.updatePolicy")
+
+	case "vpnTunnels.insert":
+		if str(body, "vpnGateway") != "" || str(body, "peerGcpGateway") != "" {
+			add("compute.vpnGateways.use")
+		}
+		if str(body, "router") != "" {
+			add("compute.routers.use")
+		}
+	}
+	return permissions
+}
+
+// str returns a string field of a JSON object
+func str(obj map[string]any, key string) string {
+	s, _ := obj[key].(string)
+	return s
+}
+
+// list returns the objects of an array field of a JSON object
+func list(obj map[string]any, key string) []map[string]any {
+	items, _ := obj[key].([]any)
+	var objects []map[string]any
+	for _, item := range items {
+		if o, ok := item.(map[string]any); ok {
+			objects = append(objects, o)
+		}
+	}
+	return objects
+}
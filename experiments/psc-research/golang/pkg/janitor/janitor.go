@@ -0,0 +1,375 @@
+// Package janitor finds the demo runs left in a project by the labels of
+// their resources and tears down those past the expiry they were labeled
+// with, so abandoned runs stop accumulating cost
+package janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/teardown"
+	"github.com/fatih/color"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// labelFilter selects the demo resources in the aggregated lists
+var labelFilter = fmt.Sprintf(`labels.%s = "true"`, config.LabelDemo)
+
+// Run is a demo run found in the project by the labels of its resources
+type Run struct {
+	RunID string
+	// ExpiresAt is the latest expiry of the resources of the run, zero if
+	// none has one. The run expires once all of its resources have.
+	ExpiresAt time.Time
+	// Zone and SecondaryZone are those of the primary and secondary regions
+	Zone          string
+	SecondaryZone string
+	// Resources are the labeled resources in kind/name form, the
+	// unlabelable ones are found by name during the teardown
+	Resources []string
+
+	labels map[string]string
+}
+
+// Expired reports whether every resource of the run is past its expiry
+func (r *Run) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
+}
+
+// Janitor tears down the expired demo runs of a project
+type Janitor struct {
+	instancesClient      *compute.InstancesClient
+	forwardingRuleClient *compute.ForwardingRulesClient
+	addressClient        *compute.AddressesClient
+	vpnGatewayClient     *compute.VpnGatewaysClient
+	vpnTunnelClient      *compute.VpnTunnelsClient
+	config               *config.Config
+	opts                 []option.ClientOption
+	now                  func() time.Time
+
+	// DryRun reports the expired runs without deleting them
+	DryRun bool
+
+	// RetryTimeout bounds how long the teardown retries a resource still in
+	// use, see teardown.Teardown
+	RetryTimeout time.Duration
+}
+
+// NewJanitor creates a janitor for the project of cfg
+func NewJanitor(cfg *config.Config, opts ...option.ClientOption) (*Janitor, error) {
+	ctx := context.Background()
+	j := &Janitor{config: cfg, opts: opts, now: time.Now, RetryTimeout: 3 * time.Minute}
+
+	var err error
+	if j.instancesClient, err = compute.NewInstancesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create instances client: %v", err)
+	}
+	if j.forwardingRuleClient, err = compute.NewForwardingRulesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create forwarding rules client: %v", err)
+	}
+	if j.addressClient, err = compute.NewAddressesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create addresses client: %v", err)
+	}
+	if j.vpnGatewayClient, err = compute.NewVpnGatewaysRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN gateways client: %v", err)
+	}
+	if j.vpnTunnelClient, err = compute.NewVpnTunnelsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN tunnels client: %v", err)
+	}
+	return j, nil
+}
+
+// Close closes all clients
+func (j *Janitor) Close() {
+	for _, c := range []interface{ Close() error }{
+		j.instancesClient,
+		j.forwardingRuleClient,
+		j.addressClient,
+		j.vpnGatewayClient,
+		j.vpnTunnelClient,
+	} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// Sweep finds the demo runs of the project and tears down the expired ones,
+// or only reports them with DryRun. It fails only when the runs cannot be
+// listed; failed teardowns are in the summary and retried by the next sweep.
+func (j *Janitor) Sweep(ctx context.Context) (*Summary, error) {
+	runs, err := j.Runs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := j.now()
+	summary := &Summary{Project: j.config.ProjectID, Time: now.UTC(), DryRun: j.DryRun}
+	for _, run := range runs {
+		outcome := RunOutcome{RunID: run.RunID, Resources: len(run.Resources)}
+		if !run.ExpiresAt.IsZero() {
+			expiresAt := run.ExpiresAt.UTC()
+			outcome.ExpiresAt = &expiresAt
+		}
+
+		switch {
+		case run.ExpiresAt.IsZero():
+			outcome.Action = ActionKept
+			outcome.Reason = "no expiry label"
+		case !run.Expired(now):
+			outcome.Action = ActionKept
+			outcome.Reason = "expires in " + run.ExpiresAt.Sub(now).Round(time.Minute).String()
+		case j.DryRun:
+			outcome.Action = ActionExpired
+		default:
+			color.Blue("=== Janitor: tearing down run %s, expired %s ago ===", run.RunID, now.Sub(run.ExpiresAt).Round(time.Minute))
+			j.tearDown(ctx, run, &outcome)
+		}
+		summary.Runs = append(summary.Runs, outcome)
+	}
+	return summary, nil
+}
+
+// tearDown deletes the resources of an expired run the way cleanup does:
+// the extra consumers first, then the secondary region, then the rest
+func (j *Janitor) tearDown(ctx context.Context, run *Run, outcome *RunOutcome) {
+	cfg, err := j.config.RunFromLabels(run.labels, run.Zone, run.SecondaryZone)
+	if err != nil {
+		outcome.Action = ActionFailed
+		outcome.Failures = append(outcome.Failures, err.Error())
+		return
+	}
+
+	var results []teardown.Result
+	for n := 2; n <= lastConsumer(cfg, run); n++ {
+		extra, err := cfg.ExtraConsumer(n)
+		if err != nil {
+			outcome.Failures = append(outcome.Failures, fmt.Sprintf("consumer %d: %v", n, err))
+			continue
+		}
+		results = append(results, j.runTeardown(ctx, extra, func(td *teardown.Teardown) { td.ConsumerOnly = true }, outcome)...)
+	}
+	if cfg.SecondaryRegion != "" {
+		if secondary, err := cfg.Secondary(); err != nil {
+			outcome.Failures = append(outcome.Failures, fmt.Sprintf("secondary region %s: %v", cfg.SecondaryRegion, err))
+		} else {
+			results = append(results, j.runTeardown(ctx, secondary, func(td *teardown.Teardown) { td.RegionOnly = true }, outcome)...)
+		}
+	}
+	results = append(results, j.runTeardown(ctx, cfg, nil, outcome)...)
+
+	for _, r := range results {
+		switch r.Outcome {
+		case teardown.Deleted:
+			outcome.Deleted++
+		case teardown.Failed:
+			outcome.Failures = append(outcome.Failures, fmt.Sprintf("%s: %v", r.ID(), r.Err))
+		}
+	}
+	outcome.Action = ActionDeleted
+	if len(outcome.Failures) > 0 {
+		outcome.Action = ActionFailed
+	}
+}
+
+// runTeardown tears down the resources of one configuration of a run
+func (j *Janitor) runTeardown(ctx context.Context, cfg *config.Config, scope func(*teardown.Teardown), outcome *RunOutcome) []teardown.Result {
+	td, err := teardown.NewTeardown(cfg, j.opts...)
+	if err != nil {
+		outcome.Failures = append(outcome.Failures, err.Error())
+		return nil
+	}
+	defer td.Close()
+	td.RetryTimeout = j.RetryTimeout
+	if scope != nil {
+		scope(td)
+	}
+	return td.Run(ctx)
+}
+
+// lastConsumer returns the number of the last consumer of a multi-consumer
+// run, 1 for a single consumer, from the names of its labeled client VMs and
+// PSC endpoints, see config.Config.ExtraConsumer
+func lastConsumer(cfg *config.Config, run *Run) int {
+	last := 1
+	for _, id := range run.Resources {
+		kind, name, _ := strings.Cut(id, "/")
+		var base string
+		switch kind {
+		case "instances":
+			base = cfg.ConsumerVM
+		case "forwarding-rules":
+			base = cfg.PSCForwardingRule
+		default:
+			continue
+		}
+		suffix, ok := strings.CutPrefix(name, base+"-c")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(suffix); err == nil && n > last {
+			last = n
+		}
+	}
+	return last
+}
+
+// labeled is a demo resource found by its labels, in a scope such as
+// "zones/us-central1-a" or "regions/us-central1"
+type labeled struct {
+	kind   string
+	name   string
+	scope  string
+	labels map[string]string
+}
+
+// Runs lists the labeled resources of the project grouped by run, sorted by
+// run ID
+func (j *Janitor) Runs(ctx context.Context) ([]*Run, error) {
+	resources, err := j.listLabeled(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return groupRuns(resources), nil
+}
+
+// groupRuns groups labeled resources by run. The zones come from the zone
+// labels, or the zone of the VMs for runs labeled before the zone was.
+func groupRuns(resources []labeled) []*Run {
+	byID := map[string]*Run{}
+	for _, r := range resources {
+		id := r.labels[config.LabelRunID]
+		if r.labels[config.LabelDemo] != "true" || id == "" {
+			continue
+		}
+		run := byID[id]
+		if run == nil {
+			run = &Run{RunID: id, labels: map[string]string{}}
+			byID[id] = run
+		}
+		run.Resources = append(run.Resources, r.kind+"/"+r.name)
+
+		if expiresAt, ok := config.ExpiresAt(r.labels); ok && expiresAt.After(run.ExpiresAt) {
+			run.ExpiresAt = expiresAt
+		}
+		// The settings of the run are the same on all its resources, only
+		// the zone differs between the regions
+		for key, value := range r.labels {
+			if _, ok := run.labels[key]; !ok && key != config.LabelZone && key != config.LabelExpires && key != config.LabelSecondary {
+				run.labels[key] = value
+			}
+		}
+		zone := r.labels[config.LabelZone]
+		if zone == "" {
+			zone, _ = strings.CutPrefix(r.scope, "zones/")
+			if zone == r.scope {
+				zone = ""
+			}
+		}
+		switch {
+		case zone == "":
+		case r.labels[config.LabelSecondary] == "true":
+			run.SecondaryZone = zone
+		case run.Zone == "":
+			run.Zone = zone
+		}
+	}
+
+	runs := make([]*Run, 0, len(byID))
+	for _, run := range byID {
+		sort.Strings(run.Resources)
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(a, b int) bool { return runs[a].RunID < runs[b].RunID })
+	return runs
+}
+
+// listLabeled lists the labeled demo resources of every region and zone
+func (j *Janitor) listLabeled(ctx context.Context) ([]labeled, error) {
+	project := j.config.ProjectID
+	var resources []labeled
+	add := func(kind, scope, name string, labels map[string]string) {
+		resources = append(resources, labeled{kind: kind, name: name, scope: scope, labels: labels})
+	}
+
+	instances := j.instancesClient.AggregatedList(ctx, &computepb.AggregatedListInstancesRequest{Project: project, Filter: &labelFilter})
+	for {
+		pair, err := instances.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances: %v", err)
+		}
+		for _, r := range pair.Value.GetInstances() {
+			add("instances", pair.Key, r.GetName(), r.GetLabels())
+		}
+	}
+
+	rules := j.forwardingRuleClient.AggregatedList(ctx, &computepb.AggregatedListForwardingRulesRequest{Project: project, Filter: &labelFilter})
+	for {
+		pair, err := rules.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list forwarding rules: %v", err)
+		}
+		for _, r := range pair.Value.GetForwardingRules() {
+			add("forwarding-rules", pair.Key, r.GetName(), r.GetLabels())
+		}
+	}
+
+	addresses := j.addressClient.AggregatedList(ctx, &computepb.AggregatedListAddressesRequest{Project: project, Filter: &labelFilter})
+	for {
+		pair, err := addresses.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses: %v", err)
+		}
+		for _, r := range pair.Value.GetAddresses() {
+			add("addresses", pair.Key, r.GetName(), r.GetLabels())
+		}
+	}
+
+	gateways := j.vpnGatewayClient.AggregatedList(ctx, &computepb.AggregatedListVpnGatewaysRequest{Project: project, Filter: &labelFilter})
+	for {
+		pair, err := gateways.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VPN gateways: %v", err)
+		}
+		for _, r := range pair.Value.GetVpnGateways() {
+			add("vpn-gateways", pair.Key, r.GetName(), r.GetLabels())
+		}
+	}
+
+	tunnels := j.vpnTunnelClient.AggregatedList(ctx, &computepb.AggregatedListVpnTunnelsRequest{Project: project, Filter: &labelFilter})
+	for {
+		pair, err := tunnels.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VPN tunnels: %v", err)
+		}
+		for _, r := range pair.Value.GetVpnTunnels() {
+			add("vpn-tunnels", pair.Key, r.GetName(), r.GetLabels())
+		}
+	}
+
+	return resources, nil
+}
@@ -0,0 +1,214 @@
+package janitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+)
+
+const testProject = "test-project"
+
+var testNow = time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+// runConfig returns the configuration of a run with a name prefix, started
+// at startedAt
+func runConfig(t *testing.T, prefix string, startedAt time.Time, args ...string) *config.Config {
+	t.Helper()
+	cfg, err := config.Load("test", append([]string{"--project", testProject, "--name-prefix", prefix}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.StartedAt = startedAt
+	return cfg
+}
+
+// seedRun creates the resources of a run in the fake, the labelable ones
+// with labels
+func seedRun(fake *fakecompute.Server, cfg *config.Config, labels map[string]string) {
+	regional := "regions/" + cfg.Region + "/"
+	zonal := "zones/" + cfg.Zone + "/"
+	labeled := map[string]any{"labels": labels}
+
+	fake.Put(regional+"forwardingRules", cfg.PSCForwardingRule, labeled)
+	fake.Put(regional+"addresses", cfg.PSCEndpoint+"-ip", labeled)
+	fake.Put(regional+"serviceAttachments", cfg.ServiceAttachment, nil)
+	fake.Put(regional+"forwardingRules", cfg.ForwardingRule, labeled)
+	fake.Put(regional+"backendServices", cfg.BackendService, nil)
+	fake.Put(zonal+"instanceGroups", cfg.InstanceGroup, nil)
+	fake.Put("global/healthChecks", cfg.HealthCheck, nil)
+	fake.Put(zonal+"instances", cfg.ProviderVM, labeled)
+	fake.Put(zonal+"instances", cfg.ConsumerVM, labeled)
+	fake.Put(regional+"subnetworks", cfg.ProviderSubnet, nil)
+	fake.Put(regional+"subnetworks", cfg.PSCNATSubnet, nil)
+	fake.Put(regional+"subnetworks", cfg.ConsumerSubnet, nil)
+	fake.Put("global/networks", cfg.ProviderVPC, nil)
+	fake.Put("global/networks", cfg.ConsumerVPC, nil)
+}
+
+func newTestJanitor(t *testing.T) (*Janitor, *fakecompute.Server) {
+	t.Helper()
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+	j, err := NewJanitor(cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewJanitor() error = %v", err)
+	}
+	t.Cleanup(j.Close)
+	j.now = func() time.Time { return testNow }
+	j.RetryTimeout = time.Second
+	return j, fake
+}
+
+func TestSweep(t *testing.T) {
+	j, fake := newTestJanitor(t)
+
+	// Expired a day ago, with a second consumer
+	old := runConfig(t, "old", testNow.Add(-48*time.Hour))
+	seedRun(fake, old, old.Labels())
+	extra, _ := old.ExtraConsumer(2)
+	zonal := "zones/" + extra.Zone + "/"
+	fake.Put(zonal+"instances", extra.ConsumerVM, map[string]any{"labels": extra.Labels()})
+	fake.Put("regions/"+extra.Region+"/subnetworks", extra.ConsumerSubnet, nil)
+	fake.Put("global/networks", extra.ConsumerVPC, nil)
+
+	// Expires in an hour
+	fresh := runConfig(t, "fresh", testNow.Add(-23*time.Hour))
+	seedRun(fake, fresh, fresh.Labels())
+
+	// Labeled before runs expired
+	legacy := runConfig(t, "legacy", testNow.Add(-72*time.Hour))
+	seedRun(fake, legacy, map[string]string{config.LabelDemo: "true", config.LabelRunID: legacy.RunID})
+
+	summary, err := j.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+
+	want := map[string]string{"fresh": ActionKept, "legacy": ActionKept, "old": ActionDeleted}
+	if len(summary.Runs) != len(want) {
+		t.Fatalf("Sweep() runs = %+v, want %d", summary.Runs, len(want))
+	}
+	for _, r := range summary.Runs {
+		if r.Action != want[r.RunID] {
+			t.Errorf("run %s: action = %s (%v), want %s", r.RunID, r.Action, r.Failures, want[r.RunID])
+		}
+	}
+	if r := summary.Runs[2]; r.Resources != 6 || r.Deleted != 17 {
+		t.Errorf("run old: %d resources, %d deleted, want 6 and 17", r.Resources, r.Deleted)
+	}
+	if r := summary.Runs[0]; r.Reason != "expires in 1h0m0s" {
+		t.Errorf("run fresh: reason = %q", r.Reason)
+	}
+
+	networks := strings.Join(fake.Names("global/networks"), ",")
+	if networks != "fresh-hypershift-customer,fresh-hypershift-redhat,legacy-hypershift-customer,legacy-hypershift-redhat" {
+		t.Errorf("networks left = %s", networks)
+	}
+	if vms := fake.Names("zones/" + old.Zone + "/instances"); len(vms) != 4 {
+		t.Errorf("instances left = %v, want those of fresh and legacy", vms)
+	}
+
+	var out strings.Builder
+	summary.Write(&out)
+	if !strings.Contains(out.String(), "3 runs: 1 deleted, 0 failed, 0 expired, 2 kept") {
+		t.Errorf("Write() =\n%s", out.String())
+	}
+}
+
+func TestSweep_DryRun(t *testing.T) {
+	j, fake := newTestJanitor(t)
+	j.DryRun = true
+	old := runConfig(t, "old", testNow.Add(-48*time.Hour))
+	seedRun(fake, old, old.Labels())
+
+	summary, err := j.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if len(summary.Runs) != 1 || summary.Runs[0].Action != ActionExpired {
+		t.Fatalf("Sweep() = %+v, want run old expired", summary.Runs)
+	}
+	if n := fake.Count(http.MethodDelete, "", ""); n != 0 {
+		t.Errorf("dry run made %d deletes", n)
+	}
+}
+
+func TestGroupRuns(t *testing.T) {
+	cfg := runConfig(t, "mr", testNow, "--secondary-region", "us-east1", "--secondary-zone", "us-east1-b", "--run-ttl", "2h")
+	secondary, err := cfg.Secondary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := *cfg
+	later.StartedAt = testNow.Add(time.Hour)
+
+	runs := groupRuns([]labeled{
+		{kind: "instances", name: cfg.ProviderVM, scope: "zones/us-central1-a", labels: cfg.Labels()},
+		{kind: "instances", name: secondary.ProviderVM, scope: "zones/us-east1-b", labels: secondary.Labels()},
+		{kind: "forwarding-rules", name: cfg.PSCForwardingRule, scope: "regions/us-central1", labels: later.Labels()},
+		{kind: "instances", name: "unlabeled-vm", scope: "zones/us-central1-a", labels: map[string]string{config.LabelDemo: "true"}},
+		{kind: "instances", name: "legacy-vm", scope: "zones/europe-west1-b", labels: map[string]string{config.LabelDemo: "true", config.LabelRunID: "legacy"}},
+	})
+
+	if len(runs) != 2 {
+		t.Fatalf("groupRuns() = %d runs, want 2", len(runs))
+	}
+	legacy, mr := runs[0], runs[1]
+	if legacy.Zone != "europe-west1-b" || !legacy.ExpiresAt.IsZero() {
+		t.Errorf("legacy run = %+v, want zone europe-west1-b and no expiry", legacy)
+	}
+	if mr.Zone != "us-central1-a" || mr.SecondaryZone != "us-east1-b" {
+		t.Errorf("run mr zones = %s and %s, want us-central1-a and us-east1-b", mr.Zone, mr.SecondaryZone)
+	}
+	// The latest expiry of the run's resources
+	if want := testNow.Add(3 * time.Hour); !mr.ExpiresAt.Equal(want) {
+		t.Errorf("run mr expires at %s, want %s", mr.ExpiresAt, want)
+	}
+
+	run, err := config.NewConfig().RunFromLabels(mr.labels, mr.Zone, mr.SecondaryZone)
+	if err != nil {
+		t.Fatalf("RunFromLabels() error = %v", err)
+	}
+	if run.ProviderVM != cfg.ProviderVM || run.SecondaryRegion != "us-east1" || run.Region != "us-central1" {
+		t.Errorf("RunFromLabels() = provider VM %s in %s and %s, want %s in us-central1 and us-east1",
+			run.ProviderVM, run.Region, run.SecondaryRegion, cfg.ProviderVM)
+	}
+}
+
+func TestSummaryPost(t *testing.T) {
+	var got Summary
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "not JSON", http.StatusUnsupportedMediaType)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	summary := &Summary{Project: testProject, Time: testNow, Runs: []RunOutcome{{RunID: "old", Action: ActionDeleted, Deleted: 17}}}
+	if err := summary.Post(context.Background(), srv.URL); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if got.Project != testProject || len(got.Runs) != 1 || got.Runs[0].Deleted != 17 {
+		t.Errorf("webhook got %+v", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such channel", http.StatusNotFound)
+	}))
+	defer failing.Close()
+	if err := summary.Post(context.Background(), failing.URL); err == nil || !strings.Contains(err.Error(), "no such channel") {
+		t.Errorf("Post() error = %v, want the webhook's answer", err)
+	}
+}
@@ -0,0 +1,110 @@
+package janitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Actions the janitor took on a run
+const (
+	// ActionKept runs have not expired, or have no expiry label
+	ActionKept = "kept"
+	// ActionExpired runs would have been deleted without DryRun
+	ActionExpired = "expired"
+	ActionDeleted = "deleted"
+	// ActionFailed runs have resources left, the next sweep retries them
+	ActionFailed = "failed"
+)
+
+// Summary is the outcome of one sweep, posted to the webhook as JSON
+type Summary struct {
+	Project string       `json:"project"`
+	Time    time.Time    `json:"time"`
+	DryRun  bool         `json:"dryRun,omitempty"`
+	Runs    []RunOutcome `json:"runs"`
+}
+
+// RunOutcome is what a sweep did with one run
+type RunOutcome struct {
+	RunID     string     `json:"runId"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Action    string     `json:"action"`
+	Reason    string     `json:"reason,omitempty"`
+	// Resources is the number of labeled resources found, Deleted the
+	// number of resources the teardown deleted, unlabelable ones included
+	Resources int      `json:"resources"`
+	Deleted   int      `json:"deleted"`
+	Failures  []string `json:"failures,omitempty"`
+}
+
+// Count returns the number of runs the sweep took action on
+func (s *Summary) Count(action string) int {
+	n := 0
+	for _, r := range s.Runs {
+		if r.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Write prints one line per run and the totals
+func (s *Summary) Write(w io.Writer) {
+	mode := ""
+	if s.DryRun {
+		mode = " (dry run)"
+	}
+	fmt.Fprintf(w, "Janitor sweep of project %s at %s%s\n", s.Project, s.Time.Local().Format("2006-01-02 15:04:05"), mode)
+	if len(s.Runs) == 0 {
+		fmt.Fprintln(w, "  no demo runs found")
+		return
+	}
+
+	fmt.Fprintf(w, "  %-20s %-8s %-17s %9s %7s  %s\n", "run", "action", "expires", "resources", "deleted", "details")
+	for _, r := range s.Runs {
+		expires := "-"
+		if r.ExpiresAt != nil {
+			expires = r.ExpiresAt.Local().Format("2006-01-02 15:04")
+		}
+		details := r.Reason
+		if len(r.Failures) > 0 {
+			details = strings.Join(r.Failures, "; ")
+		}
+		fmt.Fprintf(w, "  %-20s %-8s %-17s %9d %7d  %s\n", r.RunID, r.Action, expires, r.Resources, r.Deleted, details)
+	}
+	fmt.Fprintf(w, "%d runs: %d deleted, %d failed, %d expired, %d kept\n", len(s.Runs),
+		s.Count(ActionDeleted), s.Count(ActionFailed), s.Count(ActionExpired), s.Count(ActionKept))
+}
+
+// Post sends the summary as JSON to a webhook, such as a Cloud Function or a
+// chat integration accepting arbitrary JSON
+func (s *Summary) Post(ctx context.Context, url string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post the summary: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package natcapacity
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequired(t *testing.T) {
+	for _, tc := range []struct {
+		connections, endpoints int
+		natIPs, prefix         int
+	}{
+		{connections: 1000, endpoints: 1, natIPs: 1, prefix: 29},
+		{connections: PortsPerNATIP, endpoints: 4, natIPs: 4, prefix: 29},
+		{connections: PortsPerNATIP + 1, endpoints: 4, natIPs: 8, prefix: 28},
+		{connections: 100000, endpoints: 100, natIPs: 200, prefix: 24},
+		{connections: 10, endpoints: 253, natIPs: 253, prefix: 23},
+	} {
+		s, err := Required(tc.connections, tc.endpoints)
+		if err != nil {
+			t.Fatalf("Required(%d, %d) error = %v", tc.connections, tc.endpoints, err)
+		}
+		if s.NATIPs != tc.natIPs || s.Prefix != tc.prefix {
+			t.Errorf("Required(%d, %d) = %d addresses in a /%d, want %d in a /%d",
+				tc.connections, tc.endpoints, s.NATIPs, s.Prefix, tc.natIPs, tc.prefix)
+		}
+		if s.Capacity() < tc.connections {
+			t.Errorf("Required(%d, %d) capacity %d is below the target", tc.connections, tc.endpoints, s.Capacity())
+		}
+	}
+
+	if _, err := Required(0, 1); err == nil {
+		t.Error("Required(0, 1) succeeded")
+	}
+	if _, err := Required(PortsPerNATIP, 1<<24); err == nil {
+		t.Error("Required() of more addresses than a /8 holds succeeded")
+	}
+}
+
+func TestRangeUsableIPs(t *testing.T) {
+	if got, err := RangeUsableIPs("10.1.1.0/24"); err != nil || got != 252 {
+		t.Errorf("RangeUsableIPs(/24) = %d, %v, want 252", got, err)
+	}
+	for _, cidr := range []string{"10.1.1.0/30", "fd00::/64", "nope"} {
+		if _, err := RangeUsableIPs(cidr); err == nil {
+			t.Errorf("RangeUsableIPs(%s) succeeded", cidr)
+		}
+	}
+}
+
+func TestParseSteps(t *testing.T) {
+	steps, err := ParseSteps("100, 500,,2000")
+	if err != nil || !reflect.DeepEqual(steps, []int{100, 500, 2000}) {
+		t.Errorf("ParseSteps() = %v, %v, want [100 500 2000]", steps, err)
+	}
+	for _, value := range []string{"", "100,x", "500,100", "0"} {
+		if _, err := ParseSteps(value); err == nil {
+			t.Errorf("ParseSteps(%q) succeeded", value)
+		}
+	}
+}
+
+func TestParseClientResult(t *testing.T) {
+	output := "Warning: Permanently added 'compute.123' to the list of known hosts.\n" +
+		`{"connected": 990, "refused": 0, "timeout": 10, "other": 0, "errors": {"ETIMEDOUT": 10}}` + "\n"
+	result, err := parseClientResult(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Connected != 990 || result.Failed() != 10 || result.Errors["ETIMEDOUT"] != 10 {
+		t.Errorf("parseClientResult() = %+v", result)
+	}
+
+	if _, err := parseClientResult("python3: command not found"); err == nil {
+		t.Error("parseClientResult() of an error message succeeded")
+	}
+}
+
+func TestParseSamples(t *testing.T) {
+	output := `--
+      3 [::ffff:10.1.1.3]
+     40 [::ffff:35.191.0.9]
+--
+    700 [::ffff:10.1.1.3]
+    300 10.1.1.4
+      2 [::ffff:10.1.0.2]
+--
+    400 [::ffff:10.1.1.3]
+`
+	usage, err := parseSamples(output, "10.1.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ProviderUsage{Connections: 1000, NATIPs: 2, MaxPerNATIP: 700}
+	if usage != want {
+		t.Errorf("parseSamples() = %+v, want %+v", usage, want)
+	}
+}
+
+func TestDiagnosis(t *testing.T) {
+	r := &Report{NATRange: "10.1.1.0/29", NATUsableIPs: 4}
+
+	for _, tc := range []struct {
+		name string
+		step Step
+		want string
+	}{
+		{"ok", Step{Client: ClientResult{Connected: 100}}, "ok"},
+		{"all NAT addresses", Step{
+			Client:   ClientResult{Connected: 100, TimedOut: 5},
+			Provider: ProviderUsage{Connections: 100, NATIPs: 4, MaxPerNATIP: 25},
+		}, "PSC NAT exhaustion"},
+		{"ports of one address", Step{
+			Client:   ClientResult{Connected: 60000, TimedOut: 5},
+			Provider: ProviderUsage{Connections: 60000, NATIPs: 1, MaxPerNATIP: 60000},
+		}, "PSC NAT exhaustion"},
+		{"client ports", Step{
+			Client:   ClientResult{Connected: 28000, Other: 100, Errors: map[string]int{"EADDRNOTAVAIL": 100}},
+			Provider: ProviderUsage{Connections: 28000, NATIPs: 1, MaxPerNATIP: 28000},
+		}, "consumer VM limit"},
+		{"headroom", Step{
+			Client:   ClientResult{Connected: 90, Refused: 10},
+			Provider: ProviderUsage{Connections: 90, NATIPs: 1, MaxPerNATIP: 90},
+		}, "failures with NAT headroom"},
+	} {
+		if got := r.Diagnosis(tc.step); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("%s: Diagnosis() = %q, want %q...", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReport_Write(t *testing.T) {
+	r := &Report{
+		RunID:        "test",
+		Endpoint:     "10.2.0.10",
+		NATRange:     "10.1.1.0/29",
+		NATUsableIPs: 4,
+		Steps: []Step{
+			{Target: 100, Client: ClientResult{Connected: 100}, Provider: ProviderUsage{Connections: 100, NATIPs: 1, MaxPerNATIP: 100}},
+			{Target: 1000, Client: ClientResult{Connected: 900, TimedOut: 100, Errors: map[string]int{"ETIMEDOUT": 100}},
+				Provider: ProviderUsage{Connections: 900, NATIPs: 4, MaxPerNATIP: 300}},
+		},
+	}
+
+	var out strings.Builder
+	r.Write(&out)
+
+	for _, want := range []string{
+		"NAT range 10.1.1.0/29: 4 usable addresses",
+		"errors: ETIMEDOUT 100",
+		"1000 connections on one endpoint need a NAT subnet of /29",
+		"! NAT exhaustion from 1000 connections",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
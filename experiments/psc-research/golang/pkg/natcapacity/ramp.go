@@ -0,0 +1,286 @@
+package natcapacity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcloud"
+	"github.com/fatih/color"
+)
+
+// Options controls a ramp
+type Options struct {
+	// Steps are the concurrent connection counts opened in turn
+	Steps []int
+	// ConnectTimeout bounds how long the client waits for each step's
+	// connections to be established
+	ConnectTimeout time.Duration
+	// Hold is how long the established connections are kept open while the
+	// provider VM counts them
+	Hold time.Duration
+}
+
+// ParseSteps parses a comma-separated list of increasing connection counts
+func ParseSteps(value string) ([]int, error) {
+	var steps []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid step %q: want a positive connection count", field)
+		}
+		if len(steps) > 0 && n <= steps[len(steps)-1] {
+			return nil, fmt.Errorf("steps must increase, got %d after %d", n, steps[len(steps)-1])
+		}
+		steps = append(steps, n)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no steps")
+	}
+	return steps, nil
+}
+
+// ClientResult is what the consumer VM reports for one step
+type ClientResult struct {
+	Connected int            `json:"connected"`
+	Refused   int            `json:"refused"`
+	TimedOut  int            `json:"timeout"`
+	Other     int            `json:"other"`
+	Errors    map[string]int `json:"errors"`
+}
+
+// Failed returns the connections that could not be established
+func (r ClientResult) Failed() int {
+	return r.Refused + r.TimedOut + r.Other
+}
+
+// ProviderUsage is the peak use of NAT addresses the provider VM observed
+type ProviderUsage struct {
+	// Connections is the largest number of connections from the NAT range
+	// established at once
+	Connections int
+	// NATIPs is the largest number of NAT addresses seen at once, and
+	// MaxPerNATIP the most connections seen from a single one
+	NATIPs      int
+	MaxPerNATIP int
+}
+
+// Step is the outcome of one ramp step
+type Step struct {
+	Target   int
+	Client   ClientResult
+	Provider ProviderUsage
+	Err      error
+}
+
+// Tester ramps connections from the consumer VM through the PSC endpoint
+type Tester struct {
+	config *config.Config
+	opts   Options
+}
+
+// NewTester validates the options and returns a tester
+func NewTester(cfg *config.Config, opts Options) (*Tester, error) {
+	if len(opts.Steps) == 0 {
+		return nil, fmt.Errorf("no steps")
+	}
+	if opts.ConnectTimeout < time.Second || opts.Hold < time.Second {
+		return nil, fmt.Errorf("connect timeout and hold must be at least 1s")
+	}
+	return &Tester{config: cfg, opts: opts}, nil
+}
+
+// Run opens the connections of every step from the consumer VM and holds
+// them while the provider VM samples its established connections, once per
+// second, per NAT address
+func (t *Tester) Run() (*Report, error) {
+	cfg := t.config
+	endpoint := gcloud.Lookup(context.Background(), cfg.ProjectID, "forwarding-rules", "describe", cfg.PSCForwardingRule, "--region", cfg.Region, "--format", "value(IPAddress)")
+	if endpoint == "" {
+		return nil, fmt.Errorf("PSC endpoint %s not found", cfg.PSCForwardingRule)
+	}
+	natRange := gcloud.Lookup(context.Background(), cfg.ProjectID, "networks", "subnets", "describe", cfg.PSCNATSubnet, "--region", cfg.Region, "--format", "value(ipCidrRange)")
+	if natRange == "" {
+		natRange = cfg.PSCNATSubnetRange
+	}
+	usable, err := RangeUsableIPs(natRange)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		RunID:        cfg.RunID,
+		Endpoint:     endpoint,
+		NATRange:     natRange,
+		NATUsableIPs: usable,
+	}
+	for i, target := range t.opts.Steps {
+		color.Blue("=== Step %d/%d: %d concurrent connections ===", i+1, len(t.opts.Steps), target)
+		step := t.runStep(endpoint, natRange, target)
+		report.Steps = append(report.Steps, step)
+		if step.Err != nil {
+			color.Yellow("⚠ %v", step.Err)
+			continue
+		}
+		fmt.Printf("connected %d, failed %d; provider saw %d connections from %d NAT addresses\n",
+			step.Client.Connected, step.Client.Failed(), step.Provider.Connections, step.Provider.NATIPs)
+	}
+	return report, nil
+}
+
+// runStep runs the client and the provider sampler concurrently
+func (t *Tester) runStep(endpoint, natRange string, target int) Step {
+	step := Step{Target: target}
+	seconds := int((t.opts.ConnectTimeout + t.opts.Hold).Seconds())
+
+	var (
+		wg                     sync.WaitGroup
+		clientOut, providerOut string
+		clientErr, providerErr error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		clientOut, clientErr = gcloud.SSH(context.Background(), t.config, t.config.ConsumerVM, fmt.Sprintf("python3 -c %s %s %d %d %.1f %.1f",
+			gcloud.ShellQuote(clientScript), endpoint, t.config.ServicePort, target,
+			t.opts.ConnectTimeout.Seconds(), t.opts.Hold.Seconds()))
+	}()
+	go func() {
+		defer wg.Done()
+		providerOut, providerErr = gcloud.SSH(context.Background(), t.config, t.config.ProviderVM, fmt.Sprintf(samplerCommand, seconds, t.config.ServicePort))
+	}()
+	wg.Wait()
+
+	switch {
+	case clientErr != nil:
+		step.Err = fmt.Errorf("client on %s failed: %v", t.config.ConsumerVM, clientErr)
+	case providerErr != nil:
+		step.Err = fmt.Errorf("sampling on %s failed: %v", t.config.ProviderVM, providerErr)
+	}
+	if step.Err != nil {
+		return step
+	}
+	if step.Client, step.Err = parseClientResult(clientOut); step.Err != nil {
+		return step
+	}
+	step.Provider, step.Err = parseSamples(providerOut, natRange)
+	return step
+}
+
+// clientScript opens connections to host:port without blocking, waits up to
+// the connect timeout for them, prints the outcome as JSON and holds the
+// established connections. The soft file descriptor limit is raised to the
+// hard one so that thousands of sockets fit.
+const clientScript = `
+import errno, json, resource, select, socket, sys, time
+host, port, n, timeout, hold = sys.argv[1], int(sys.argv[2]), int(sys.argv[3]), float(sys.argv[4]), float(sys.argv[5])
+soft, hard = resource.getrlimit(resource.RLIMIT_NOFILE)
+resource.setrlimit(resource.RLIMIT_NOFILE, (hard, hard))
+result = {"connected": 0, "refused": 0, "timeout": 0, "other": 0, "errors": {}}
+def fail(kind, code):
+    result[kind] += 1
+    name = errno.errorcode.get(code, str(code))
+    result["errors"][name] = result["errors"].get(name, 0) + 1
+pending, established = {}, []
+for _ in range(n):
+    try:
+        s = socket.socket()
+    except OSError as e:
+        fail("other", e.errno)
+        continue
+    s.setblocking(False)
+    code = s.connect_ex((host, port))
+    if code in (0, errno.EINPROGRESS):
+        pending[s.fileno()] = s
+    else:
+        s.close()
+        fail("refused" if code == errno.ECONNREFUSED else "other", code)
+poller = select.epoll()
+for fd in pending:
+    poller.register(fd, select.EPOLLOUT)
+deadline = time.time() + timeout
+while pending and time.time() < deadline:
+    for fd, _ in poller.poll(max(0, deadline - time.time())):
+        s = pending.pop(fd)
+        poller.unregister(fd)
+        code = s.getsockopt(socket.SOL_SOCKET, socket.SO_ERROR)
+        if code == 0:
+            result["connected"] += 1
+            established.append(s)
+        else:
+            s.close()
+            fail("refused" if code == errno.ECONNREFUSED else "other", code)
+for s in pending.values():
+    s.close()
+    fail("timeout", errno.ETIMEDOUT)
+print(json.dumps(result))
+sys.stdout.flush()
+time.sleep(hold)
+`
+
+// samplerCommand prints, once per second, the established connections to
+// the service port counted per peer address, each sample after a "--" line
+const samplerCommand = `for i in $(seq %d); do echo --; ` +
+	`ss -Htn state established '( sport = :%d )' | awk '{print $4}' | sed -E 's/:[0-9]+$//' | sort | uniq -c; ` +
+	`sleep 1; done`
+
+// parseClientResult reads the JSON line the client script prints
+func parseClientResult(output string) (ClientResult, error) {
+	var result ClientResult
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil {
+		return result, fmt.Errorf("unexpected client output %q: %v", output, err)
+	}
+	return result, nil
+}
+
+// parseSamples returns the peak NAT usage over the samples of the sampler,
+// counting only peers in natRange
+func parseSamples(output, natRange string) (ProviderUsage, error) {
+	_, nat, err := net.ParseCIDR(natRange)
+	if err != nil {
+		return ProviderUsage{}, fmt.Errorf("invalid NAT range %q: %v", natRange, err)
+	}
+
+	var peak, sample ProviderUsage
+	flush := func() {
+		peak.Connections = max(peak.Connections, sample.Connections)
+		peak.NATIPs = max(peak.NATIPs, sample.NATIPs)
+		peak.MaxPerNATIP = max(peak.MaxPerNATIP, sample.MaxPerNATIP)
+		sample = ProviderUsage{}
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 1 && fields[0] == "--" {
+			flush()
+			continue
+		}
+		if len(fields) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		// ss prints IPv4 peers of dual-stack listeners as [::ffff:a.b.c.d]
+		peer := strings.TrimPrefix(strings.Trim(fields[1], "[]"), "::ffff:")
+		if ip := net.ParseIP(peer); ip == nil || !nat.Contains(ip) {
+			continue
+		}
+		sample.Connections += n
+		sample.NATIPs++
+		sample.MaxPerNATIP = max(sample.MaxPerNATIP, n)
+	}
+	flush()
+	return peak, nil
+}
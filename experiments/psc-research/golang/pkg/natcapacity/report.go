@@ -0,0 +1,99 @@
+package natcapacity
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Report is the outcome of a ramp
+type Report struct {
+	RunID        string
+	Endpoint     string
+	NATRange     string
+	NATUsableIPs int
+	Steps        []Step
+}
+
+// Diagnosis explains the failed connections of a step: NAT exhaustion when
+// the provider saw every NAT address, or one nearly out of ports, in use
+func (r *Report) Diagnosis(s Step) string {
+	switch {
+	case s.Err != nil:
+		return "step failed: " + s.Err.Error()
+	case s.Client.Failed() == 0:
+		return "ok"
+	case s.Provider.NATIPs >= r.NATUsableIPs || s.Provider.MaxPerNATIP >= PortsPerNATIP*9/10:
+		return fmt.Sprintf("PSC NAT exhaustion: %d of %d NAT addresses in use, up to %d connections on one",
+			s.Provider.NATIPs, r.NATUsableIPs, s.Provider.MaxPerNATIP)
+	case s.Client.Errors["EADDRNOTAVAIL"] > 0 || s.Client.Errors["EMFILE"] > 0 || s.Client.Errors["ENFILE"] > 0:
+		return "consumer VM limit: out of local ports or file descriptors, not a NAT limit"
+	default:
+		return fmt.Sprintf("failures with NAT headroom (%d of %d addresses used): check the backend, "+
+			"the firewall rule for %s and the connection limits of the service attachment",
+			s.Provider.NATIPs, r.NATUsableIPs, r.NATRange)
+	}
+}
+
+// Exhausted returns the first step the diagnosis blames on NAT exhaustion
+func (r *Report) Exhausted() (Step, bool) {
+	for _, s := range r.Steps {
+		if strings.HasPrefix(r.Diagnosis(s), "PSC NAT exhaustion") {
+			return s, true
+		}
+	}
+	return Step{}, false
+}
+
+// Write prints the steps with the client and provider view of each, and the
+// NAT subnet needed for the largest step
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "PSC NAT capacity of run %s through endpoint %s\n", r.RunID, r.Endpoint)
+	fmt.Fprintf(w, "NAT range %s: %d usable addresses, %d ports each\n\n", r.NATRange, r.NATUsableIPs, PortsPerNATIP)
+
+	fmt.Fprintf(w, "  %8s %9s %7s %9s %8s %11s\n", "target", "connected", "failed", "provider", "NAT IPs", "max per IP")
+	largest := 0
+	for _, s := range r.Steps {
+		largest = max(largest, s.Target)
+		if s.Err != nil {
+			fmt.Fprintf(w, "  %8d  %s\n", s.Target, r.Diagnosis(s))
+			continue
+		}
+		fmt.Fprintf(w, "  %8d %9d %7d %9d %8d %11d  %s\n", s.Target, s.Client.Connected, s.Client.Failed(),
+			s.Provider.Connections, s.Provider.NATIPs, s.Provider.MaxPerNATIP, r.Diagnosis(s))
+		if len(s.Client.Errors) > 0 {
+			fmt.Fprintf(w, "  %8s errors: %s\n", "", formatErrors(s.Client.Errors))
+		}
+	}
+
+	if largest == 0 {
+		return
+	}
+	if sizing, err := Required(largest, 1); err == nil {
+		fmt.Fprintf(w, "\n%d connections on one endpoint need a NAT subnet of %s\n", largest, sizing)
+	}
+	if s, ok := r.Exhausted(); ok {
+		fmt.Fprintf(w, "! NAT exhaustion from %d connections: grow %s or add a NAT subnet to the service attachment\n",
+			s.Target, r.NATRange)
+	}
+}
+
+// formatErrors lists error counts, most frequent first
+func formatErrors(errors map[string]int) string {
+	names := make([]string, 0, len(errors))
+	for name := range errors {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if errors[names[i]] != errors[names[j]] {
+			return errors[names[i]] > errors[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, errors[name])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,82 @@
+// Package natcapacity measures how many concurrent consumer connections the
+// PSC NAT subnet of the service attachment carries, and sizes NAT subnets for
+// a target connection count.
+//
+// On the provider side every consumer connection arrives from an address of
+// the PSC NAT subnet. Each NAT address offers PortsPerNATIP source ports, so
+// an endpoint needs one NAT address per PortsPerNATIP concurrent connections,
+// and at least one address whatever its traffic.
+package natcapacity
+
+import (
+	"fmt"
+	"net"
+)
+
+// PortsPerNATIP is the number of source ports PSC uses on each NAT address
+const PortsPerNATIP = 64512
+
+// reservedAddresses are the addresses GCP reserves in every subnet
+const reservedAddresses = 4
+
+// Prefix lengths accepted for a PSC NAT subnet
+const (
+	minPrefix = 8
+	maxPrefix = 29
+)
+
+// Sizing is the NAT subnet needed for a number of endpoints, each carrying
+// up to Connections concurrent connections
+type Sizing struct {
+	Connections int
+	Endpoints   int
+	NATIPs      int
+	Prefix      int
+	UsableIPs   int
+}
+
+// Capacity returns the concurrent connections per endpoint the subnet of the
+// sizing carries when every endpoint uses the same number of NAT addresses
+func (s Sizing) Capacity() int {
+	return s.UsableIPs / s.Endpoints * PortsPerNATIP
+}
+
+func (s Sizing) String() string {
+	return fmt.Sprintf("/%d (%d usable addresses) for %d NAT addresses: %d endpoints x %d connections",
+		s.Prefix, s.UsableIPs, s.NATIPs, s.Endpoints, s.Connections)
+}
+
+// Required returns the smallest NAT subnet carrying connections concurrent
+// connections on each of endpoints PSC endpoints
+func Required(connections, endpoints int) (Sizing, error) {
+	if connections < 1 || endpoints < 1 {
+		return Sizing{}, fmt.Errorf("connections and endpoints must be positive")
+	}
+	perEndpoint := (connections + PortsPerNATIP - 1) / PortsPerNATIP
+	s := Sizing{Connections: connections, Endpoints: endpoints, NATIPs: perEndpoint * endpoints}
+	for prefix := maxPrefix; prefix >= minPrefix; prefix-- {
+		if UsableIPs(prefix) >= s.NATIPs {
+			s.Prefix, s.UsableIPs = prefix, UsableIPs(prefix)
+			return s, nil
+		}
+	}
+	return s, fmt.Errorf("%d NAT addresses do not fit in a /%d subnet", s.NATIPs, minPrefix)
+}
+
+// UsableIPs returns the addresses of a subnet PSC can use for NAT
+func UsableIPs(prefix int) int {
+	return 1<<(32-prefix) - reservedAddresses
+}
+
+// RangeUsableIPs returns the usable addresses of a NAT subnet range
+func RangeUsableIPs(cidr string) (int, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid NAT range %q: %v", cidr, err)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits != 32 || ones > maxPrefix {
+		return 0, fmt.Errorf("NAT range %s is not an IPv4 range of /%d or larger", cidr, maxPrefix)
+	}
+	return UsableIPs(ones), nil
+}
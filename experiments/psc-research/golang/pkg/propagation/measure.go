@@ -0,0 +1,242 @@
+package propagation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcloud"
+)
+
+// Options controls a measurement
+type Options struct {
+	// Interval is the time between probes of the endpoint and polls of its
+	// connection status, and the timeout of each probe
+	Interval time.Duration
+	// Limit bounds how long the endpoint is watched once it is created
+	Limit time.Duration
+}
+
+// DefaultOptions probe every second for up to 10 minutes
+var DefaultOptions = Options{
+	Interval: time.Second,
+	Limit:    10 * time.Minute,
+}
+
+// Measurer watches the consumer side of the PSC resources the PSC manager
+// creates. Once the endpoint address is reserved, the consumer VM requests
+// /healthz through it every interval until the first success; once the
+// endpoint is created, its connection status is polled until ACCEPTED. The
+// first success is timed on the consumer VM clock, every other event on the
+// local clock, so both must be synchronized.
+type Measurer struct {
+	// Timeline is marked by the PSC manager, see psc.PSCManager.Timeline
+	Timeline *Timeline
+
+	cfg  *config.Config
+	opts Options
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	notes []string
+}
+
+// NewMeasurer validates the options and returns a measurer of the endpoint
+// of cfg, probed from its consumer VM
+func NewMeasurer(cfg *config.Config, opts Options) (*Measurer, error) {
+	if opts.Interval < 500*time.Millisecond {
+		return nil, fmt.Errorf("probe interval must be at least 500ms")
+	}
+	if opts.Limit < opts.Interval {
+		return nil, fmt.Errorf("measurement limit must be at least the probe interval")
+	}
+	return &Measurer{
+		Timeline: NewTimeline(),
+		cfg:      cfg,
+		opts:     opts,
+		finished: make(chan struct{}),
+	}, nil
+}
+
+// Start begins watching for the endpoint in the background
+func (m *Measurer) Start(ctx context.Context) {
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	m.wg.Add(2)
+	go m.probe()
+	go m.watchConnectionStatus()
+}
+
+// Finish waits until the watchers have observed the endpoint in use, or given
+// up, and returns the measurement. Watchers whose resource was not created by
+// this run stop right away.
+func (m *Measurer) Finish() *Measurement {
+	m.once.Do(func() { close(m.finished) })
+	m.wg.Wait()
+	m.cancel()
+
+	measurement := &Measurement{
+		RunID:      m.cfg.RunID,
+		ProjectID:  m.cfg.ProjectID,
+		Region:     m.cfg.Region,
+		Zone:       m.cfg.Zone,
+		MeasuredAt: time.Now().UTC(),
+		Events:     m.Timeline.Events(),
+		Notes:      m.notes,
+	}
+	measurement.computeLatencies()
+	return measurement
+}
+
+// Stop abandons the measurement, e.g. after PSC setup failed
+func (m *Measurer) Stop() {
+	m.once.Do(func() { close(m.finished) })
+	m.cancel()
+	m.wg.Wait()
+}
+
+// await waits until event is marked, reporting false if the measurement is
+// finished or stopped first
+func (m *Measurer) await(event string) bool {
+	select {
+	case <-m.Timeline.Reached(event):
+		return true
+	case <-m.finished:
+		_, ok := m.Timeline.Get(event)
+		return ok
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+func (m *Measurer) note(format string, args ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notes = append(m.notes, fmt.Sprintf(format, args...))
+}
+
+// probe requests /healthz through the endpoint from the consumer VM until the
+// first success and marks it
+func (m *Measurer) probe() {
+	defer m.wg.Done()
+
+	addressName := m.cfg.PSCEndpoint + "-ip"
+	if !m.await(EventAddressReserved) {
+		m.note("address %s was not reserved by this run, first use not measured", addressName)
+		return
+	}
+	address := gcloud.Lookup(m.ctx, m.cfg.ProjectID, "addresses", "describe", addressName,
+		"--region", m.cfg.Region, "--format", "value(address)")
+	if address == "" {
+		m.note("address %s not found, first use not measured", addressName)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, m.opts.Limit+time.Minute)
+	defer cancel()
+	command := fmt.Sprintf("python3 -c %s %s %d %.3f %.1f", gcloud.ShellQuote(probeScript),
+		address, m.cfg.ServicePort, m.opts.Interval.Seconds(), m.opts.Limit.Seconds())
+	output, err := gcloud.SSH(ctx, m.cfg, m.cfg.ConsumerVM, command)
+	if err != nil {
+		m.note("probe on %s failed: %v", m.cfg.ConsumerVM, err)
+		return
+	}
+
+	at, attempts, err := ParseProbe(output)
+	switch {
+	case err != nil:
+		m.note("%v", err)
+	case at.IsZero():
+		m.note("no request through %s succeeded in %s (%d attempts)", address, m.opts.Limit, attempts)
+	default:
+		m.Timeline.MarkAt(EventFirstConnection, at)
+	}
+}
+
+// watchConnectionStatus polls the connection status of the endpoint until
+// the service attachment accepts it and marks it
+func (m *Measurer) watchConnectionStatus() {
+	defer m.wg.Done()
+
+	rule := m.cfg.PSCForwardingRule
+	if !m.await(EventEndpointCreated) {
+		m.note("endpoint %s was not created by this run, acceptance not measured", rule)
+		return
+	}
+
+	deadline := time.Now().Add(m.opts.Limit)
+	status := ""
+	for time.Now().Before(deadline) {
+		status = gcloud.Lookup(m.ctx, m.cfg.ProjectID, "forwarding-rules", "describe", rule,
+			"--region", m.cfg.Region, "--format", "value(pscConnectionStatus)")
+		switch status {
+		case "ACCEPTED":
+			m.Timeline.Mark(EventEndpointAccepted)
+			return
+		case "REJECTED", "CLOSED", "NEEDS_ATTENTION":
+			m.note("endpoint %s connection is %s", rule, status)
+			return
+		}
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(m.opts.Interval):
+		}
+	}
+	m.note("endpoint %s not ACCEPTED in %s (last status %q)", rule, m.opts.Limit, status)
+}
+
+// probeScript requests /healthz of the endpoint once per interval, each
+// request bounded by the interval, until one succeeds or limit seconds pass.
+// It prints the epoch time the first success completed and the number of
+// attempts, or "none" and the number of attempts.
+const probeScript = `
+import http.client, ssl, sys, time
+host, port, interval, limit = sys.argv[1], int(sys.argv[2]), float(sys.argv[3]), float(sys.argv[4])
+ctx = ssl.create_default_context()
+ctx.check_hostname = False
+ctx.verify_mode = ssl.CERT_NONE
+start, n = time.time(), 0
+while time.time() - start < limit:
+    n += 1
+    try:
+        c = http.client.HTTPSConnection(host, port, timeout=interval, context=ctx)
+        c.request("GET", "/healthz")
+        if c.getresponse().status == 200:
+            print("%.3f %d" % (time.time(), n), flush=True)
+            sys.exit(0)
+    except Exception:
+        pass
+    time.sleep(max(0, start + n * interval - time.time()))
+print("none %d" % n)
+`
+
+// ParseProbe reads the line the probe script prints: the time of the first
+// success, zero if none succeeded, and the number of attempts
+func ParseProbe(output string) (time.Time, int, error) {
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return time.Time{}, 0, fmt.Errorf("unexpected probe output %q", strings.TrimSpace(output))
+	}
+	attempts, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("unexpected probe attempts %q", fields[1])
+	}
+	if fields[0] == "none" {
+		return time.Time{}, attempts, nil
+	}
+	epoch, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("unexpected probe time %q", fields[0])
+	}
+	return time.UnixMilli(int64(epoch * 1000)), attempts, nil
+}
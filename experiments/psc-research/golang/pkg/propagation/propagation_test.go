@@ -0,0 +1,182 @@
+package propagation
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+)
+
+func TestTimeline(t *testing.T) {
+	tl := NewTimeline()
+	reached := tl.Reached(EventEndpointCreated)
+
+	t0 := time.Unix(1700000000, 0)
+	tl.MarkAt(EventEndpointCreated, t0)
+	tl.MarkAt(EventEndpointCreated, t0.Add(time.Minute))
+
+	select {
+	case <-reached:
+	default:
+		t.Error("Reached() channel not closed after Mark")
+	}
+	if at, ok := tl.Get(EventEndpointCreated); !ok || !at.Equal(t0) {
+		t.Errorf("Get() = %v, %v, want the first mark %v", at, ok, t0)
+	}
+	if _, ok := tl.Get(EventFirstConnection); ok {
+		t.Error("Get() found an event that was never marked")
+	}
+
+	// Managers mark unconditionally, a nil timeline ignores them
+	var none *Timeline
+	none.Mark(EventEndpointCreated)
+	if _, ok := none.Get(EventEndpointCreated); ok {
+		t.Error("nil timeline recorded an event")
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	at, attempts, err := ParseProbe("1700000012.250 13\n")
+	if err != nil {
+		t.Fatalf("ParseProbe() error = %v", err)
+	}
+	if at.UnixMilli() != 1700000012250 || attempts != 13 {
+		t.Errorf("ParseProbe() = %d ms, %d attempts, want 1700000012250 ms after 13", at.UnixMilli(), attempts)
+	}
+
+	at, attempts, err = ParseProbe("none 600\n")
+	if err != nil || !at.IsZero() || attempts != 600 {
+		t.Errorf("ParseProbe(none) = %v, %d, %v, want no success after 600 attempts", at, attempts, err)
+	}
+
+	for _, output := range []string{"", "1700000012.250", "soon 3", "1700000012.250 many"} {
+		if _, _, err := ParseProbe(output); err == nil {
+			t.Errorf("ParseProbe(%q) succeeded", output)
+		}
+	}
+}
+
+// testMeasurement is a run whose endpoint was usable 12s after its operation
+// completed, with the service attachment created 20s earlier
+func testMeasurement(runID string, offset time.Duration) *Measurement {
+	t0 := time.Unix(1700000000, 0)
+	m := &Measurement{
+		RunID: runID,
+		Events: map[string]time.Time{
+			EventBackendAttached:          t0,
+			EventServiceAttachmentCreated: t0.Add(10 * time.Second),
+			EventEndpointCreated:          t0.Add(30 * time.Second),
+			EventEndpointAccepted:         t0.Add(33*time.Second + offset),
+			EventBackendHealthy:           t0.Add(50 * time.Second),
+			EventFirstConnection:          t0.Add(42*time.Second + offset),
+		},
+	}
+	m.computeLatencies()
+	return m
+}
+
+func TestMeasurement(t *testing.T) {
+	m := testMeasurement("a", 0)
+	want := map[string]float64{
+		"endpoint-accepted":    3,
+		"endpoint-first-use":   12,
+		"attachment-first-use": 32,
+		"backend-healthy":      50,
+		// The endpoint served before the backend was reported HEALTHY
+		"healthy-first-use": -8,
+	}
+	for name, seconds := range want {
+		if got, ok := m.Latencies[name]; !ok || got != seconds {
+			t.Errorf("latency %s = %v, %v, want %v", name, got, ok, seconds)
+		}
+	}
+
+	delete(m.Events, EventFirstConnection)
+	m.Notes = []string{"no request succeeded"}
+	m.computeLatencies()
+	if _, ok := m.Latencies["endpoint-first-use"]; ok {
+		t.Error("latency computed without its end event")
+	}
+
+	var out bytes.Buffer
+	m.Write(&out)
+	for _, want := range []string{"endpoint-accepted", "3.0s", "! no request succeeded"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("measurement does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestAppendLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "propagation.jsonl")
+
+	if measurements, err := Load(path); err != nil || measurements != nil {
+		t.Fatalf("Load() of a missing log = %v, %v, want none", measurements, err)
+	}
+	for _, runID := range []string{"a", "b"} {
+		if err := Append(path, testMeasurement(runID, 0)); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	measurements, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(measurements) != 2 || measurements[0].RunID != "a" || measurements[1].RunID != "b" {
+		t.Fatalf("Load() = %+v, want runs a and b", measurements)
+	}
+	if got := measurements[1].Latencies["endpoint-first-use"]; got != 12 {
+		t.Errorf("loaded endpoint-first-use = %v, want 12", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var measurements []Measurement
+	for i := 0; i < 10; i++ {
+		measurements = append(measurements, *testMeasurement("run", time.Duration(i)*time.Second))
+	}
+	// A run that never saw its endpoint in use only counts where it has data
+	partial := testMeasurement("partial", 0)
+	delete(partial.Events, EventFirstConnection)
+	partial.computeLatencies()
+	measurements = append(measurements, *partial)
+
+	stats := Summarize(measurements)
+	byName := make(map[string]Stats)
+	for _, s := range stats {
+		byName[s.Latency.Name] = s
+	}
+
+	s := byName["endpoint-first-use"]
+	if s.Runs != 10 || s.Min != 12*time.Second || s.Median != 16*time.Second || s.P90 != 20*time.Second || s.Max != 21*time.Second {
+		t.Errorf("endpoint-first-use stats = %+v, want 10 runs from 12s, median 16s, p90 20s, to 21s", s)
+	}
+	if got := byName["backend-healthy"].Runs; got != 11 {
+		t.Errorf("backend-healthy runs = %d, want 11", got)
+	}
+
+	var out bytes.Buffer
+	WriteSummary(&out, stats)
+	if !strings.Contains(out.String(), "endpoint-first-use") || !strings.Contains(out.String(), "16.0s") {
+		t.Errorf("summary does not show the endpoint-first-use median:\n%s", out.String())
+	}
+}
+
+func TestNewMeasurer(t *testing.T) {
+	cfg := config.NewConfig()
+	if _, err := NewMeasurer(cfg, DefaultOptions); err != nil {
+		t.Errorf("NewMeasurer() error = %v", err)
+	}
+	for _, opts := range []Options{
+		{Interval: 100 * time.Millisecond, Limit: time.Minute},
+		{Interval: time.Second, Limit: 0},
+	} {
+		if _, err := NewMeasurer(cfg, opts); err == nil {
+			t.Errorf("NewMeasurer(%+v) succeeded", opts)
+		}
+	}
+}
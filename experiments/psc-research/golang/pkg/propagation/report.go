@@ -0,0 +1,210 @@
+package propagation
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gcp-psc-demo/pkg/stats"
+)
+
+// Latency is the delay between two events of a timeline
+type Latency struct {
+	Name        string
+	From        string
+	To          string
+	Description string
+}
+
+// Latencies are the delays every measurement reports. A negative latency
+// means the resource was usable before its create operation was reported
+// done.
+var Latencies = []Latency{
+	{
+		Name:        "endpoint-accepted",
+		From:        EventEndpointCreated,
+		To:          EventEndpointAccepted,
+		Description: "PSC endpoint created until its connection is ACCEPTED",
+	},
+	{
+		Name:        "endpoint-first-use",
+		From:        EventEndpointCreated,
+		To:          EventFirstConnection,
+		Description: "PSC endpoint created until the first request through it succeeds",
+	},
+	{
+		Name:        "attachment-first-use",
+		From:        EventServiceAttachmentCreated,
+		To:          EventFirstConnection,
+		Description: "Service attachment created until the first request through PSC succeeds",
+	},
+	{
+		Name:        "backend-healthy",
+		From:        EventBackendAttached,
+		To:          EventBackendHealthy,
+		Description: "Instance group added to the backend service until it is HEALTHY",
+	},
+	{
+		Name:        "healthy-first-use",
+		From:        EventBackendHealthy,
+		To:          EventFirstConnection,
+		Description: "Backend HEALTHY until the first request through PSC succeeds",
+	},
+}
+
+// Measurement is the timeline of one demo run, one line of the log
+type Measurement struct {
+	RunID      string               `json:"runId"`
+	ProjectID  string               `json:"projectId"`
+	Region     string               `json:"region"`
+	Zone       string               `json:"zone"`
+	MeasuredAt time.Time            `json:"measuredAt"`
+	Events     map[string]time.Time `json:"events"`
+	// Latencies in seconds by Latency.Name, for the events that were both
+	// observed
+	Latencies map[string]float64 `json:"latencies"`
+	// Notes explain events that were not observed
+	Notes []string `json:"notes,omitempty"`
+}
+
+// computeLatencies fills m.Latencies from m.Events
+func (m *Measurement) computeLatencies() {
+	m.Latencies = make(map[string]float64)
+	for _, l := range Latencies {
+		from, ok := m.Events[l.From]
+		if !ok {
+			continue
+		}
+		to, ok := m.Events[l.To]
+		if !ok {
+			continue
+		}
+		m.Latencies[l.Name] = to.Sub(from).Round(time.Millisecond).Seconds()
+	}
+}
+
+// Write prints the latencies of the measurement
+func (m *Measurement) Write(w io.Writer) {
+	fmt.Fprintf(w, "Propagation delays of run %s in %s:\n", m.RunID, m.Region)
+	for _, l := range Latencies {
+		if seconds, ok := m.Latencies[l.Name]; ok {
+			fmt.Fprintf(w, "  %-22s %8.1fs  %s\n", l.Name, seconds, l.Description)
+		} else {
+			fmt.Fprintf(w, "  %-22s %9s  %s\n", l.Name, "-", l.Description)
+		}
+	}
+	for _, note := range m.Notes {
+		fmt.Fprintf(w, "  ! %s\n", note)
+	}
+}
+
+// Append adds a measurement as one JSON line to the log at path
+func Append(path string, m *Measurement) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to marshal measurement: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open propagation log %s: %v", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write propagation log %s: %v", path, err)
+	}
+	return nil
+}
+
+// Load reads every measurement of the log at path, returning none without
+// error if it does not exist
+func Load(path string) ([]Measurement, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read propagation log %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var measurements []Measurement
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var m Measurement
+		if err := json.Unmarshal([]byte(text), &m); err != nil {
+			return nil, fmt.Errorf("failed to parse propagation log %s line %d: %v", path, line, err)
+		}
+		measurements = append(measurements, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read propagation log %s: %v", path, err)
+	}
+	return measurements, nil
+}
+
+// Stats summarizes one latency across runs
+type Stats struct {
+	Latency Latency
+	Runs    int
+	Min     time.Duration
+	Median  time.Duration
+	P90     time.Duration
+	Max     time.Duration
+}
+
+// Summarize returns the statistics of every latency over the measurements
+// that observed it
+func Summarize(measurements []Measurement) []Stats {
+	summary := make([]Stats, 0, len(Latencies))
+	for _, l := range Latencies {
+		var values []time.Duration
+		for _, m := range measurements {
+			if seconds, ok := m.Latencies[l.Name]; ok {
+				values = append(values, time.Duration(seconds*float64(time.Second)))
+			}
+		}
+		s := Stats{Latency: l, Runs: len(values)}
+		if len(values) > 0 {
+			sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+			s.Min = values[0]
+			s.Median = stats.Percentile(values, 50)
+			s.P90 = stats.Percentile(values, 90)
+			s.Max = values[len(values)-1]
+		}
+		summary = append(summary, s)
+	}
+	return summary
+}
+
+// WriteSummary prints the statistics of every latency as a table
+func WriteSummary(w io.Writer, stats []Stats) {
+	fmt.Fprintf(w, "%-22s %5s %8s %8s %8s %8s\n", "latency", "runs", "min", "median", "p90", "max")
+	for _, s := range stats {
+		if s.Runs == 0 {
+			fmt.Fprintf(w, "%-22s %5d %8s %8s %8s %8s\n", s.Latency.Name, 0, "-", "-", "-", "-")
+			continue
+		}
+		fmt.Fprintf(w, "%-22s %5d %8s %8s %8s %8s\n", s.Latency.Name, s.Runs,
+			seconds(s.Min), seconds(s.Median), seconds(s.P90), seconds(s.Max))
+	}
+	fmt.Fprintln(w)
+	for _, l := range Latencies {
+		fmt.Fprintf(w, "%-22s %s\n", l.Name, l.Description)
+	}
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
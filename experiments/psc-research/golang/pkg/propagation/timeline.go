@@ -0,0 +1,108 @@
+// Package propagation measures how long PSC resources take to become usable
+// after the Compute API reports them created. The PSC manager marks each
+// resource on a Timeline when its create operation completes, while a
+// Measurer watches the consumer side for the first accepted connection and
+// the first successful request through the endpoint. Every measurement is
+// appended to a log shared by all runs, so latencies can be compared across
+// runs instead of assuming a resource is usable once its operation is done.
+package propagation
+
+import (
+	"sync"
+	"time"
+)
+
+// Events marked on a timeline. The values are the keys of Measurement.Events.
+const (
+	// Create operations of the PSC manager completed
+	EventHealthCheckCreated       = "healthCheckCreated"
+	EventInstanceGroupCreated     = "instanceGroupCreated"
+	EventBackendAttached          = "backendAttached"
+	EventForwardingRuleCreated    = "forwardingRuleCreated"
+	EventServiceAttachmentCreated = "serviceAttachmentCreated"
+	EventAddressReserved          = "addressReserved"
+	EventEndpointCreated          = "endpointCreated"
+
+	// First observations of the resources in use
+	EventBackendHealthy   = "backendHealthy"
+	EventEndpointAccepted = "endpointAccepted"
+	EventFirstConnection  = "firstConnection"
+)
+
+// Timeline records when each event first happened. A nil timeline records
+// nothing, so managers can mark events unconditionally.
+type Timeline struct {
+	mu      sync.Mutex
+	events  map[string]time.Time
+	reached map[string]chan struct{}
+}
+
+// NewTimeline returns an empty timeline
+func NewTimeline() *Timeline {
+	return &Timeline{
+		events:  make(map[string]time.Time),
+		reached: make(map[string]chan struct{}),
+	}
+}
+
+// Mark records that event happened now
+func (t *Timeline) Mark(event string) {
+	t.MarkAt(event, time.Now())
+}
+
+// MarkAt records that event happened at the given time. Only the first mark
+// of an event counts.
+func (t *Timeline) MarkAt(event string, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.events[event]; ok {
+		return
+	}
+	t.events[event] = at
+	close(t.channel(event))
+}
+
+// Get returns when event happened, if it has
+func (t *Timeline) Get(event string) (time.Time, bool) {
+	if t == nil {
+		return time.Time{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at, ok := t.events[event]
+	return at, ok
+}
+
+// Reached returns a channel closed once event is marked
+func (t *Timeline) Reached(event string) <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.channel(event)
+}
+
+// Events returns a copy of every event marked so far
+func (t *Timeline) Events() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	events := make(map[string]time.Time, len(t.events))
+	for event, at := range t.events {
+		events[event] = at
+	}
+	return events
+}
+
+// channel returns the channel of event, creating it. t.mu must be held.
+func (t *Timeline) channel(event string) chan struct{} {
+	ch, ok := t.reached[event]
+	if !ok {
+		ch = make(chan struct{})
+		t.reached[event] = ch
+	}
+	return ch
+}
@@ -3,13 +3,17 @@ package psc
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
 	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcpops"
+	"gcp-psc-demo/pkg/propagation"
 	"github.com/fatih/color"
+	"google.golang.org/api/option"
 )
 
 // PSCManager handles Private Service Connect operations
@@ -21,44 +25,50 @@ type PSCManager struct {
 	serviceAttachmentClient *compute.ServiceAttachmentsClient
 	addressClient           *compute.AddressesClient
 	instancesClient         *compute.InstancesClient
+	ops                     *gcpops.Waiter
 	config                  *config.Config
+
+	// Timeline records when each resource created by this manager was
+	// reported done and when the backend first turned HEALTHY. Resources that
+	// already exist are not marked. Nil records nothing.
+	Timeline *propagation.Timeline
 }
 
 // NewPSCManager creates a new PSC manager
-func NewPSCManager(cfg *config.Config) (*PSCManager, error) {
+func NewPSCManager(cfg *config.Config, opts ...option.ClientOption) (*PSCManager, error) {
 	ctx := context.Background()
 
-	healthCheckClient, err := compute.NewHealthChecksRESTClient(ctx)
+	healthCheckClient, err := compute.NewHealthChecksRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create health checks client: %v", err)
 	}
 
-	instanceGroupClient, err := compute.NewInstanceGroupsRESTClient(ctx)
+	instanceGroupClient, err := compute.NewInstanceGroupsRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create instance groups client: %v", err)
 	}
 
-	backendServiceClient, err := compute.NewRegionBackendServicesRESTClient(ctx)
+	backendServiceClient, err := compute.NewRegionBackendServicesRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create backend services client: %v", err)
 	}
 
-	forwardingRuleClient, err := compute.NewForwardingRulesRESTClient(ctx)
+	forwardingRuleClient, err := compute.NewForwardingRulesRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create forwarding rules client: %v", err)
 	}
 
-	serviceAttachmentClient, err := compute.NewServiceAttachmentsRESTClient(ctx)
+	serviceAttachmentClient, err := compute.NewServiceAttachmentsRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create service attachments client: %v", err)
 	}
 
-	addressClient, err := compute.NewAddressesRESTClient(ctx)
+	addressClient, err := compute.NewAddressesRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create addresses client: %v", err)
 	}
 
-	instancesClient, err := compute.NewInstancesRESTClient(ctx)
+	instancesClient, err := compute.NewInstancesRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create instances client: %v", err)
 	}
@@ -71,6 +81,7 @@ func NewPSCManager(cfg *config.Config) (*PSCManager, error) {
 		serviceAttachmentClient: serviceAttachmentClient,
 		addressClient:           addressClient,
 		instancesClient:         instancesClient,
+		ops:                     gcpops.NewWaiter(gcpops.PoolFor(opts...), cfg.ProjectID, cfg.Region, cfg.Zone),
 		config:                  cfg,
 	}, nil
 }
@@ -84,46 +95,76 @@ func (psc *PSCManager) Close() {
 	psc.serviceAttachmentClient.Close()
 	psc.addressClient.Close()
 	psc.instancesClient.Close()
+	psc.ops.Pool.Release()
 }
 
 // SetupPrivateServiceConnect sets up all PSC components
 func (psc *PSCManager) SetupPrivateServiceConnect(ctx context.Context) error {
 	color.Blue("=== Setting up Private Service Connect ===")
 
-	// Step 1: Create health check
-	if err := psc.createHealthCheck(ctx); err != nil {
+	// Steps 1-4: Internal load balancer in front of the provider VM
+	if err := psc.createLoadBalancer(ctx); err != nil {
 		return err
 	}
 
-	// Step 2: Create instance group and add VM
-	if err := psc.createInstanceGroup(ctx); err != nil {
+	// Step 5: Create service attachment
+	if err := psc.createServiceAttachment(ctx); err != nil {
 		return err
 	}
 
-	// Step 3: Create backend service
-	if err := psc.createBackendService(ctx); err != nil {
+	// Step 6: Create PSC endpoint in consumer VPC
+	if err := psc.createPSCEndpoint(ctx); err != nil {
 		return err
 	}
 
-	// Step 4: Create internal load balancer forwarding rule
-	if err := psc.createForwardingRule(ctx); err != nil {
+	// Step 7: Backend health is eventually consistent, wait until it reports HEALTHY
+	if err := psc.WaitForHealthyBackend(ctx); err != nil {
 		return err
 	}
 
-	// Step 5: Create service attachment
-	if err := psc.createServiceAttachment(ctx); err != nil {
+	color.Green("✓ Private Service Connect setup completed successfully!")
+	return nil
+}
+
+// SetupLoadBalancer sets up only the internal load balancer in front of the
+// provider VM, which the peering and VPN backends reach directly instead of
+// through a service attachment
+func (psc *PSCManager) SetupLoadBalancer(ctx context.Context) error {
+	color.Blue("=== Setting up Internal Load Balancer ===")
+
+	if err := psc.createLoadBalancer(ctx); err != nil {
 		return err
 	}
-
-	// Step 6: Create PSC endpoint in consumer VPC
-	if err := psc.createPSCEndpoint(ctx); err != nil {
+	if err := psc.WaitForHealthyBackend(ctx); err != nil {
 		return err
 	}
 
-	color.Green("✓ Private Service Connect setup completed successfully!")
+	color.Green("✓ Internal load balancer setup completed successfully!")
 	return nil
 }
 
+// createLoadBalancer creates the health check, instance group, backend
+// service and forwarding rule of the internal load balancer
+func (psc *PSCManager) createLoadBalancer(ctx context.Context) error {
+	// Step 1: Create health check
+	if err := psc.createHealthCheck(ctx); err != nil {
+		return err
+	}
+
+	// Step 2: Create instance group and add VM
+	if err := psc.createInstanceGroup(ctx); err != nil {
+		return err
+	}
+
+	// Step 3: Create backend service
+	if err := psc.createBackendService(ctx); err != nil {
+		return err
+	}
+
+	// Step 4: Create internal load balancer forwarding rule
+	return psc.createForwardingRule(ctx)
+}
+
 // createHealthCheck creates a health check for the internal load balancer
 func (psc *PSCManager) createHealthCheck(ctx context.Context) error {
 	fmt.Println("Step 1: Creating health check for internal load balancer")
@@ -142,9 +183,12 @@ func (psc *PSCManager) createHealthCheck(ctx context.Context) error {
 		Project: psc.config.ProjectID,
 		HealthCheckResource: &computepb.HealthCheck{
 			Name: &healthCheckName,
-			Type: stringPtr("TCP"),
-			TcpHealthCheck: &computepb.TCPHealthCheck{
-				Port: int32Ptr(8080),
+			// Probe the emulated API server the same way a hosted control
+			// plane's load balancer would; HTTPS checks don't verify the certificate
+			Type: stringPtr("HTTPS"),
+			HttpsHealthCheck: &computepb.HTTPSHealthCheck{
+				Port:        int32Ptr(int32(psc.config.ServicePort)),
+				RequestPath: stringPtr("/healthz"),
 			},
 			CheckIntervalSec:   int32Ptr(10),
 			TimeoutSec:         int32Ptr(5),
@@ -158,9 +202,10 @@ func (psc *PSCManager) createHealthCheck(ctx context.Context) error {
 		return fmt.Errorf("failed to create health check: %v", err)
 	}
 
-	if err := psc.waitForGlobalOperation(ctx, op.Name()); err != nil {
+	if err := psc.ops.WaitGlobal(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for health check creation: %v", err)
 	}
+	psc.Timeline.Mark(propagation.EventHealthCheckCreated)
 
 	fmt.Printf("Health check %s created\n", healthCheckName)
 	return nil
@@ -170,7 +215,7 @@ func (psc *PSCManager) createHealthCheck(ctx context.Context) error {
 func (psc *PSCManager) createInstanceGroup(ctx context.Context) error {
 	fmt.Println("Step 2: Creating instance group for the service VM")
 
-	groupName := "redhat-service-group"
+	groupName := psc.config.InstanceGroup
 
 	// Check if instance group already exists
 	if exists, err := psc.instanceGroupExists(ctx, groupName); err != nil {
@@ -192,9 +237,10 @@ func (psc *PSCManager) createInstanceGroup(ctx context.Context) error {
 			return fmt.Errorf("failed to create instance group: %v", err)
 		}
 
-		if err := psc.waitForZonalOperation(ctx, op.Name()); err != nil {
+		if err := psc.ops.WaitZonal(ctx, op.Name()); err != nil {
 			return fmt.Errorf("failed to wait for instance group creation: %v", err)
 		}
+		psc.Timeline.Mark(propagation.EventInstanceGroupCreated)
 
 		fmt.Printf("Instance group %s created\n", groupName)
 	}
@@ -260,7 +306,7 @@ func (psc *PSCManager) addVMToInstanceGroup(ctx context.Context, groupName strin
 		return fmt.Errorf("failed to add VM to instance group: %v", err)
 	}
 
-	if err := psc.waitForZonalOperation(ctx, op.Name()); err != nil {
+	if err := psc.ops.WaitZonal(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for VM addition: %v", err)
 	}
 
@@ -278,7 +324,7 @@ func (psc *PSCManager) setNamedPorts(ctx context.Context, groupName string) erro
 			NamedPorts: []*computepb.NamedPort{
 				{
 					Name: stringPtr("http"),
-					Port: int32Ptr(8080),
+					Port: int32Ptr(int32(psc.config.ServicePort)),
 				},
 			},
 		},
@@ -289,7 +335,7 @@ func (psc *PSCManager) setNamedPorts(ctx context.Context, groupName string) erro
 		return fmt.Errorf("failed to set named ports: %v", err)
 	}
 
-	if err := psc.waitForZonalOperation(ctx, op.Name()); err != nil {
+	if err := psc.ops.WaitZonal(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for named ports update: %v", err)
 	}
 
@@ -328,7 +374,7 @@ func (psc *PSCManager) createBackendService(ctx context.Context) error {
 			return fmt.Errorf("failed to create backend service: %v", err)
 		}
 
-		if err := psc.waitForRegionalOperation(ctx, op.Name()); err != nil {
+		if err := psc.ops.WaitRegional(ctx, op.Name()); err != nil {
 			return fmt.Errorf("failed to wait for backend service creation: %v", err)
 		}
 
@@ -345,7 +391,7 @@ func (psc *PSCManager) createBackendService(ctx context.Context) error {
 
 // addBackendToService adds the instance group as a backend to the service
 func (psc *PSCManager) addBackendToService(ctx context.Context, backendServiceName string) error {
-	groupName := "redhat-service-group"
+	groupName := psc.config.InstanceGroup
 	groupURL := fmt.Sprintf("projects/%s/zones/%s/instanceGroups/%s", psc.config.ProjectID, psc.config.Zone, groupName)
 
 	// Check if backend is already added
@@ -404,9 +450,10 @@ func (psc *PSCManager) addBackendToService(ctx context.Context, backendServiceNa
 		return fmt.Errorf("failed to add backend to service: %v", err)
 	}
 
-	if err := psc.waitForRegionalOperation(ctx, op.Name()); err != nil {
+	if err := psc.ops.WaitRegional(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for backend addition: %v", err)
 	}
+	psc.Timeline.Mark(propagation.EventBackendAttached)
 
 	fmt.Printf("Instance group %s added to backend service\n", groupName)
 	return nil
@@ -436,9 +483,10 @@ func (psc *PSCManager) createForwardingRule(ctx context.Context) error {
 			Name:                &forwardingRuleName,
 			LoadBalancingScheme: stringPtr("INTERNAL"),
 			BackendService:      &backendServiceURL,
+			Labels:              psc.config.Labels(),
 			Subnetwork: stringPtr(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s",
 				psc.config.ProjectID, psc.config.Region, psc.config.ProviderSubnet)),
-			Ports: []string{"8080"},
+			Ports: []string{strconv.Itoa(psc.config.ServicePort)},
 		},
 	}
 
@@ -447,9 +495,10 @@ func (psc *PSCManager) createForwardingRule(ctx context.Context) error {
 		return fmt.Errorf("failed to create forwarding rule: %v", err)
 	}
 
-	if err := psc.waitForRegionalOperation(ctx, op.Name()); err != nil {
+	if err := psc.ops.WaitRegional(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for forwarding rule creation: %v", err)
 	}
+	psc.Timeline.Mark(propagation.EventForwardingRuleCreated)
 
 	// Get the load balancer IP
 	getReq := &computepb.GetForwardingRuleRequest{
@@ -504,9 +553,10 @@ func (psc *PSCManager) createServiceAttachment(ctx context.Context) error {
 		return fmt.Errorf("failed to create service attachment: %v", err)
 	}
 
-	if err := psc.waitForRegionalOperation(ctx, op.Name()); err != nil {
+	if err := psc.ops.WaitRegional(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for service attachment creation: %v", err)
 	}
+	psc.Timeline.Mark(propagation.EventServiceAttachmentCreated)
 
 	fmt.Printf("Service attachment %s created\n", serviceAttachmentName)
 	return nil
@@ -547,6 +597,7 @@ func (psc *PSCManager) createPSCAddress(ctx context.Context) error {
 		AddressResource: &computepb.Address{
 			Name:        &addressName,
 			AddressType: stringPtr("INTERNAL"), // Required when specifying Subnetwork
+			Labels:      psc.config.Labels(),
 			Subnetwork: stringPtr(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s",
 				psc.config.ProjectID, psc.config.Region, psc.config.ConsumerSubnet)),
 		},
@@ -557,9 +608,10 @@ func (psc *PSCManager) createPSCAddress(ctx context.Context) error {
 		return fmt.Errorf("failed to create PSC address: %v", err)
 	}
 
-	if err := psc.waitForRegionalOperation(ctx, op.Name()); err != nil {
+	if err := psc.ops.WaitRegional(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for PSC address creation: %v", err)
 	}
+	psc.Timeline.Mark(propagation.EventAddressReserved)
 
 	fmt.Printf("PSC address %s created\n", addressName)
 	return nil
@@ -585,7 +637,8 @@ func (psc *PSCManager) createPSCForwardingRule(ctx context.Context) error {
 		Project: psc.config.ProjectID,
 		Region:  psc.config.Region,
 		ForwardingRuleResource: &computepb.ForwardingRule{
-			Name: &forwardingRuleName,
+			Name:   &forwardingRuleName,
+			Labels: psc.config.Labels(),
 			IPAddress: stringPtr(fmt.Sprintf("projects/%s/regions/%s/addresses/%s",
 				psc.config.ProjectID, psc.config.Region, addressName)),
 			Target: &serviceAttachmentURL,
@@ -595,15 +648,20 @@ func (psc *PSCManager) createPSCForwardingRule(ctx context.Context) error {
 				psc.config.ProjectID, psc.config.Region, psc.config.ConsumerSubnet)),
 		},
 	}
+	if psc.config.PSCGlobalAccess {
+		// The consumer VM of another region connects to this endpoint
+		req.ForwardingRuleResource.AllowPscGlobalAccess = boolPtr(true)
+	}
 
 	op, err := psc.forwardingRuleClient.Insert(ctx, req)
 	if err != nil {
 		return fmt.Errorf("failed to create PSC forwarding rule: %v", err)
 	}
 
-	if err := psc.waitForRegionalOperation(ctx, op.Name()); err != nil {
+	if err := psc.ops.WaitRegional(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for PSC forwarding rule creation: %v", err)
 	}
+	psc.Timeline.Mark(propagation.EventEndpointCreated)
 
 	// Get the PSC endpoint IP
 	getReq := &computepb.GetForwardingRuleRequest{
@@ -725,125 +783,84 @@ func (psc *PSCManager) addressExists(ctx context.Context, name string) (bool, er
 	return true, nil
 }
 
-// Wait for operations
+// WaitForHealthyBackend polls the backend service until at least one backend
+// reports HEALTHY or the configured deadline passes. GetHealth usually returns
+// no status at all for the first minute after the load balancer is created.
+func (psc *PSCManager) WaitForHealthyBackend(ctx context.Context) error {
+	fmt.Printf("Step 7: Waiting up to %v for a HEALTHY backend\n", psc.config.BackendHealthTimeout)
 
-func (psc *PSCManager) waitForGlobalOperation(ctx context.Context, operationName string) error {
-	operationsClient, err := compute.NewGlobalOperationsRESTClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer operationsClient.Close()
+	ctx, cancel := context.WithTimeout(ctx, psc.config.BackendHealthTimeout)
+	defer cancel()
 
-	// Smart polling with exponential backoff
-	pollInterval := 1 * time.Second
-	maxInterval := 10 * time.Second
+	instanceGroupURL := fmt.Sprintf("projects/%s/zones/%s/instanceGroups/%s",
+		psc.config.ProjectID, psc.config.Zone, psc.config.InstanceGroup)
 
-	for {
-		req := &computepb.GetGlobalOperationRequest{
-			Project:   psc.config.ProjectID,
-			Operation: operationName,
-		}
+	startTime := time.Now()
+	lastState := "no health status reported"
 
-		op, err := operationsClient.Get(ctx, req)
-		if err != nil {
-			return err
+	for attempt := 1; ; attempt++ {
+		req := &computepb.GetHealthRegionBackendServiceRequest{
+			Project:        psc.config.ProjectID,
+			Region:         psc.config.Region,
+			BackendService: psc.config.BackendService,
+			ResourceGroupReferenceResource: &computepb.ResourceGroupReference{
+				Group: &instanceGroupURL,
+			},
 		}
 
-		if op.GetStatus() == computepb.Operation_DONE {
-			if op.Error != nil {
-				return fmt.Errorf("operation failed: %v", op.Error)
+		health, err := psc.backendServiceClient.GetHealth(ctx, req)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			lastState = fmt.Sprintf("error: %v", err)
+		case err == nil:
+			healthy, summary := summarizeBackendHealth(health)
+			if healthy > 0 {
+				psc.Timeline.Mark(propagation.EventBackendHealthy)
+				color.Green("✓ %d backend(s) HEALTHY after %d attempt(s) (%v): %s",
+					healthy, attempt, time.Since(startTime).Round(time.Second), summary)
+				return nil
 			}
-			return nil
+			lastState = summary
 		}
 
-		time.Sleep(pollInterval)
-
-		// Exponential backoff capped at maxInterval
-		pollInterval = pollInterval * 2
-		if pollInterval > maxInterval {
-			pollInterval = maxInterval
+		if ctx.Err() != nil {
+			break
 		}
-	}
-}
-
-func (psc *PSCManager) waitForRegionalOperation(ctx context.Context, operationName string) error {
-	operationsClient, err := compute.NewRegionOperationsRESTClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer operationsClient.Close()
 
-	// Smart polling with exponential backoff
-	pollInterval := 1 * time.Second
-	maxInterval := 10 * time.Second
-
-	for {
-		req := &computepb.GetRegionOperationRequest{
-			Project:   psc.config.ProjectID,
-			Region:    psc.config.Region,
-			Operation: operationName,
-		}
-
-		op, err := operationsClient.Get(ctx, req)
-		if err != nil {
-			return err
-		}
+		fmt.Printf("  Attempt %d: %s (%v elapsed)\n", attempt, lastState, time.Since(startTime).Round(time.Second))
 
-		if op.GetStatus() == computepb.Operation_DONE {
-			if op.Error != nil {
-				return fmt.Errorf("operation failed: %v", op.Error)
-			}
-			return nil
+		select {
+		case <-ctx.Done():
+		case <-time.After(psc.config.BackendHealthInterval):
 		}
-
-		time.Sleep(pollInterval)
-
-		// Exponential backoff capped at maxInterval
-		pollInterval = pollInterval * 2
-		if pollInterval > maxInterval {
-			pollInterval = maxInterval
+		if ctx.Err() != nil {
+			break
 		}
 	}
+
+	return fmt.Errorf("no HEALTHY backend for %s after %v (last state: %s)",
+		psc.config.BackendService, time.Since(startTime).Round(time.Second), lastState)
 }
 
-func (psc *PSCManager) waitForZonalOperation(ctx context.Context, operationName string) error {
-	operationsClient, err := compute.NewZoneOperationsRESTClient(ctx)
-	if err != nil {
-		return err
+// summarizeBackendHealth counts HEALTHY backends and renders all states for logging
+func summarizeBackendHealth(health *computepb.BackendServiceGroupHealth) (int, string) {
+	if len(health.GetHealthStatus()) == 0 {
+		return 0, "no health status reported"
 	}
-	defer operationsClient.Close()
-
-	// Smart polling with exponential backoff
-	pollInterval := 1 * time.Second
-	maxInterval := 10 * time.Second
 
-	for {
-		req := &computepb.GetZoneOperationRequest{
-			Project:   psc.config.ProjectID,
-			Zone:      psc.config.Zone,
-			Operation: operationName,
+	healthy := 0
+	states := make([]string, 0, len(health.GetHealthStatus()))
+	for _, status := range health.GetHealthStatus() {
+		if status.GetHealthState() == "HEALTHY" {
+			healthy++
 		}
-
-		op, err := operationsClient.Get(ctx, req)
-		if err != nil {
-			return err
-		}
-
-		if op.GetStatus() == computepb.Operation_DONE {
-			if op.Error != nil {
-				return fmt.Errorf("operation failed: %v", op.Error)
-			}
-			return nil
-		}
-
-		time.Sleep(pollInterval)
-
-		// Exponential backoff capped at maxInterval
-		pollInterval = pollInterval * 2
-		if pollInterval > maxInterval {
-			pollInterval = maxInterval
+		instance := status.GetInstance()
+		if idx := strings.LastIndex(instance, "/"); idx >= 0 {
+			instance = instance[idx+1:]
 		}
+		states = append(states, fmt.Sprintf("%s=%s", instance, status.GetHealthState()))
 	}
+	return healthy, strings.Join(states, ", ")
 }
 
 // Helper utility functions
@@ -851,6 +868,10 @@ func stringPtr(s string) *string {
 	return &s
 }
 
+func boolPtr(b bool) *bool {
+	return &b
+}
+
 func int32Ptr(i int32) *int32 {
 	return &i
 }
@@ -0,0 +1,267 @@
+package psc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+	"gcp-psc-demo/pkg/gcpops"
+	"gcp-psc-demo/pkg/propagation"
+)
+
+const testProject = "test-project"
+
+func newTestPSCManager(t *testing.T) (*PSCManager, *fakecompute.Server) {
+	t.Helper()
+
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+	cfg.BackendHealthTimeout = time.Second
+	cfg.BackendHealthInterval = time.Millisecond
+
+	manager, err := NewPSCManager(cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewPSCManager() error = %v", err)
+	}
+	t.Cleanup(manager.Close)
+
+	manager.ops.Backoff = gcpops.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	return manager, fake
+}
+
+func TestSetupPrivateServiceConnect(t *testing.T) {
+	manager, fake := newTestPSCManager(t)
+	fake.PendingPolls = 1
+	cfg := manager.config
+	regional := "regions/" + cfg.Region + "/"
+
+	if err := manager.SetupPrivateServiceConnect(context.Background()); err != nil {
+		t.Fatalf("SetupPrivateServiceConnect() error = %v", err)
+	}
+
+	created := []struct {
+		collection string
+		name       string
+	}{
+		{"global/healthChecks", cfg.HealthCheck},
+		{"zones/" + cfg.Zone + "/instanceGroups", cfg.InstanceGroup},
+		{regional + "backendServices", cfg.BackendService},
+		{regional + "forwardingRules", cfg.ForwardingRule},
+		{regional + "serviceAttachments", cfg.ServiceAttachment},
+		{regional + "addresses", cfg.PSCEndpoint + "-ip"},
+		{regional + "forwardingRules", cfg.PSCForwardingRule},
+	}
+	for _, c := range created {
+		if fake.Get(c.collection, c.name) == nil {
+			t.Errorf("%s/%s was not created", c.collection, c.name)
+		}
+	}
+
+	healthCheck := fake.Get("global/healthChecks", cfg.HealthCheck)
+	if https, _ := healthCheck["httpsHealthCheck"].(map[string]any); healthCheck["type"] != "HTTPS" || https["requestPath"] != "/healthz" {
+		t.Errorf("health check = %v, want an HTTPS probe of /healthz", healthCheck)
+	}
+
+	group := fake.Get("zones/"+cfg.Zone+"/instanceGroups", cfg.InstanceGroup)
+	if members, _ := group["instances"].([]any); len(members) != 1 {
+		t.Errorf("instance group members = %v, want the provider VM", group["instances"])
+	}
+
+	service := fake.Get(regional+"backendServices", cfg.BackendService)
+	if backends, _ := service["backends"].([]any); len(backends) != 1 {
+		t.Errorf("backend service backends = %v, want the instance group", service["backends"])
+	}
+
+	endpoint := fake.Get(regional+"forwardingRules", cfg.PSCForwardingRule)
+	if target, _ := endpoint["target"].(string); !strings.HasSuffix(target, "/serviceAttachments/"+cfg.ServiceAttachment) {
+		t.Errorf("PSC forwarding rule target = %s, want the service attachment", target)
+	}
+	if _, ok := endpoint["allowPscGlobalAccess"]; ok {
+		t.Error("single-region PSC forwarding rule has global access")
+	}
+
+	if got := fake.Count(http.MethodPost, "backendServices", "getHealth"); got == 0 {
+		t.Error("backend health was never checked")
+	}
+}
+
+func TestSetupLoadBalancer(t *testing.T) {
+	manager, fake := newTestPSCManager(t)
+	cfg := manager.config
+	regional := "regions/" + cfg.Region + "/"
+
+	if err := manager.SetupLoadBalancer(context.Background()); err != nil {
+		t.Fatalf("SetupLoadBalancer() error = %v", err)
+	}
+
+	if fake.Get(regional+"forwardingRules", cfg.ForwardingRule) == nil {
+		t.Errorf("load balancer forwarding rule %s was not created", cfg.ForwardingRule)
+	}
+	if got := fake.Names(regional + "serviceAttachments"); len(got) != 0 {
+		t.Errorf("service attachments = %v, want none", got)
+	}
+	if fake.Get(regional+"forwardingRules", cfg.PSCForwardingRule) != nil {
+		t.Error("PSC endpoint was created for a load balancer only setup")
+	}
+	if got := fake.Count(http.MethodPost, "backendServices", "getHealth"); got == 0 {
+		t.Error("backend health was never checked")
+	}
+}
+
+func TestSetupPrivateServiceConnect_SecondaryRegion(t *testing.T) {
+	manager, fake := newTestPSCManager(t)
+	primary := manager.config
+	primary.SecondaryRegion, primary.SecondaryZone = "us-east1", "us-east1-b"
+	cfg, err := primary.Secondary()
+	if err != nil {
+		t.Fatalf("Secondary() error = %v", err)
+	}
+	manager.config = cfg
+	regional := "regions/us-east1/"
+
+	if err := manager.SetupPrivateServiceConnect(context.Background()); err != nil {
+		t.Fatalf("SetupPrivateServiceConnect() error = %v", err)
+	}
+
+	attachment := fake.Get(regional+"serviceAttachments", primary.ServiceAttachment+"-r2")
+	if nats, _ := attachment["natSubnets"].([]any); len(nats) != 1 || !strings.HasSuffix(nats[0].(string), "/"+regional+"subnetworks/"+cfg.PSCNATSubnet) {
+		t.Errorf("secondary service attachment NAT subnets = %v, want %s", attachment["natSubnets"], cfg.PSCNATSubnet)
+	}
+	if fake.Get("zones/us-east1-b/instanceGroups", cfg.InstanceGroup) == nil {
+		t.Errorf("instance group %s was not created in the secondary zone", cfg.InstanceGroup)
+	}
+
+	// The consumer VM of the primary region reaches the endpoint through global access
+	endpoint := fake.Get(regional+"forwardingRules", primary.PSCForwardingRule+"-r2")
+	if endpoint["allowPscGlobalAccess"] != true {
+		t.Errorf("secondary PSC forwarding rule = %v, want global access", endpoint)
+	}
+}
+
+func TestSetupPrivateServiceConnect_Idempotent(t *testing.T) {
+	manager, fake := newTestPSCManager(t)
+	ctx := context.Background()
+
+	if err := manager.SetupPrivateServiceConnect(ctx); err != nil {
+		t.Fatalf("first SetupPrivateServiceConnect() error = %v", err)
+	}
+	if err := manager.SetupPrivateServiceConnect(ctx); err != nil {
+		t.Fatalf("second SetupPrivateServiceConnect() error = %v", err)
+	}
+
+	checks := []struct {
+		method string
+		kind   string
+		action string
+		want   int
+	}{
+		{http.MethodPost, "healthChecks", "", 1},
+		{http.MethodPost, "instanceGroups", "", 1},
+		{http.MethodPost, "instanceGroups", "addInstances", 1},
+		{http.MethodPost, "backendServices", "", 1},
+		{http.MethodPut, "backendServices", "", 1},
+		{http.MethodPost, "forwardingRules", "", 2},
+		{http.MethodPost, "serviceAttachments", "", 1},
+		{http.MethodPost, "addresses", "", 1},
+	}
+	for _, c := range checks {
+		if got := fake.Count(c.method, c.kind, c.action); got != c.want {
+			t.Errorf("%s %s %s = %d calls, want %d", c.method, c.kind, c.action, got, c.want)
+		}
+	}
+}
+
+func TestSetupPrivateServiceConnect_Timeline(t *testing.T) {
+	manager, _ := newTestPSCManager(t)
+	manager.Timeline = propagation.NewTimeline()
+	ctx := context.Background()
+
+	if err := manager.SetupPrivateServiceConnect(ctx); err != nil {
+		t.Fatalf("SetupPrivateServiceConnect() error = %v", err)
+	}
+
+	// Every resource is marked once its operation is done, in creation order
+	order := []string{
+		propagation.EventHealthCheckCreated,
+		propagation.EventInstanceGroupCreated,
+		propagation.EventBackendAttached,
+		propagation.EventForwardingRuleCreated,
+		propagation.EventServiceAttachmentCreated,
+		propagation.EventAddressReserved,
+		propagation.EventEndpointCreated,
+		propagation.EventBackendHealthy,
+	}
+	var previous time.Time
+	for _, event := range order {
+		at, ok := manager.Timeline.Get(event)
+		if !ok {
+			t.Errorf("%s was not marked", event)
+			continue
+		}
+		if at.Before(previous) {
+			t.Errorf("%s marked at %v, before the previous event at %v", event, at, previous)
+		}
+		previous = at
+	}
+
+	// Resources found on a second run were not created by it
+	manager.Timeline = propagation.NewTimeline()
+	if err := manager.SetupPrivateServiceConnect(ctx); err != nil {
+		t.Fatalf("second SetupPrivateServiceConnect() error = %v", err)
+	}
+	if events := manager.Timeline.Events(); len(events) != 1 {
+		t.Errorf("second run marked %v, want only %s", events, propagation.EventBackendHealthy)
+	}
+}
+
+func TestSetupPrivateServiceConnect_OperationError(t *testing.T) {
+	manager, fake := newTestPSCManager(t)
+	fake.FailOperation("serviceAttachments", "RESOURCE_NOT_READY")
+
+	err := manager.SetupPrivateServiceConnect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "RESOURCE_NOT_READY") {
+		t.Fatalf("SetupPrivateServiceConnect() error = %v, want RESOURCE_NOT_READY", err)
+	}
+	if got := fake.Count(http.MethodPost, "addresses", ""); got != 0 {
+		t.Errorf("created %d PSC addresses after the service attachment failed, want 0", got)
+	}
+}
+
+func TestWaitForHealthyBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		states  []string
+		wantErr string
+	}{
+		{name: "one healthy backend", states: []string{"UNHEALTHY", "HEALTHY"}},
+		{name: "unhealthy", states: []string{"UNHEALTHY"}, wantErr: "UNHEALTHY"},
+		{name: "no status yet", states: nil, wantErr: "no health status reported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, fake := newTestPSCManager(t)
+			manager.config.BackendHealthTimeout = 20 * time.Millisecond
+			fake.Put("regions/"+manager.config.Region+"/backendServices", manager.config.BackendService, nil)
+			fake.SetBackendHealth(tt.states...)
+
+			err := manager.WaitForHealthyBackend(context.Background())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("WaitForHealthyBackend() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("WaitForHealthyBackend() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"gcp-psc-demo/pkg/config"
+	"google.golang.org/api/option"
+)
+
+// cloudInitFinished is the line cloud-init prints on the serial console once
+// its final stage, with the runcmd of the demo VMs, is done, e.g.
+// "Cloud-init v. 24.4-0ubuntu1 finished at Tue, 01 Jul 2025 10:00:00 +0000"
+var cloudInitFinished = regexp.MustCompile(`Cloud-init v\. \S+ finished at`)
+
+// Probes creates the Waiters of the demo resources, sharing Compute clients
+type Probes struct {
+	forwardingRules    *compute.ForwardingRulesClient
+	serviceAttachments *compute.ServiceAttachmentsClient
+	backendServices    *compute.RegionBackendServicesClient
+	instances          *compute.InstancesClient
+	project            string
+}
+
+// NewProbes creates the Compute clients of the probes of project
+func NewProbes(project string, opts ...option.ClientOption) (*Probes, error) {
+	ctx := context.Background()
+
+	forwardingRules, err := compute.NewForwardingRulesRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create forwarding rules client: %v", err)
+	}
+	serviceAttachments, err := compute.NewServiceAttachmentsRESTClient(ctx, opts...)
+	if err != nil {
+		forwardingRules.Close()
+		return nil, fmt.Errorf("failed to create service attachments client: %v", err)
+	}
+	backendServices, err := compute.NewRegionBackendServicesRESTClient(ctx, opts...)
+	if err != nil {
+		forwardingRules.Close()
+		serviceAttachments.Close()
+		return nil, fmt.Errorf("failed to create backend services client: %v", err)
+	}
+	instances, err := compute.NewInstancesRESTClient(ctx, opts...)
+	if err != nil {
+		forwardingRules.Close()
+		serviceAttachments.Close()
+		backendServices.Close()
+		return nil, fmt.Errorf("failed to create instances client: %v", err)
+	}
+
+	return &Probes{
+		forwardingRules:    forwardingRules,
+		serviceAttachments: serviceAttachments,
+		backendServices:    backendServices,
+		instances:          instances,
+		project:            project,
+	}, nil
+}
+
+// Close closes all clients
+func (p *Probes) Close() {
+	p.forwardingRules.Close()
+	p.serviceAttachments.Close()
+	p.backendServices.Close()
+	p.instances.Close()
+}
+
+// VMs returns the waiters of the provider and consumer VMs of cfg
+func (p *Probes) VMs(cfg *config.Config) []Waiter {
+	return []Waiter{p.CloudInitDone(cfg.Zone, cfg.ProviderVM), p.CloudInitDone(cfg.Zone, cfg.ConsumerVM)}
+}
+
+// LoadBalancer returns the waiters of the internal load balancer in front of
+// the provider VM of cfg
+func (p *Probes) LoadBalancer(cfg *config.Config) []Waiter {
+	groupURL := fmt.Sprintf("projects/%s/zones/%s/instanceGroups/%s", cfg.ProjectID, cfg.Zone, cfg.InstanceGroup)
+	return []Waiter{
+		p.ForwardingRuleHasIP(cfg.Region, cfg.ForwardingRule),
+		p.BackendHealthy(cfg.Region, cfg.BackendService, groupURL),
+	}
+}
+
+// PSC returns the waiters of the load balancer, service attachment and PSC
+// endpoint of cfg
+func (p *Probes) PSC(cfg *config.Config) []Waiter {
+	return append(p.LoadBalancer(cfg),
+		p.ServiceAttachmentConnectable(cfg.Region, cfg.ServiceAttachment),
+		p.ForwardingRuleHasIP(cfg.Region, cfg.PSCForwardingRule))
+}
+
+// ForwardingRuleHasIP waits for a forwarding rule to be assigned its IP
+// address. A PSC endpoint, which targets a service attachment, must also
+// have its connection accepted by the producer.
+func (p *Probes) ForwardingRuleHasIP(region, name string) Waiter {
+	return &forwardingRuleWaiter{probes: p, region: region, name: name}
+}
+
+type forwardingRuleWaiter struct {
+	probes       *Probes
+	region, name string
+}
+
+func (w *forwardingRuleWaiter) Name() string {
+	return "forwarding rule " + w.name
+}
+
+func (w *forwardingRuleWaiter) Ready(ctx context.Context) (bool, string, error) {
+	rule, err := w.probes.forwardingRules.Get(ctx, &computepb.GetForwardingRuleRequest{
+		Project:        w.probes.project,
+		Region:         w.region,
+		ForwardingRule: w.name,
+	})
+	if err != nil {
+		return false, "", err
+	}
+	if rule.GetIPAddress() == "" {
+		return false, "no IP address assigned", nil
+	}
+	if strings.Contains(rule.GetTarget(), "/serviceAttachments/") {
+		status := rule.GetPscConnectionStatus()
+		if status != "ACCEPTED" {
+			return false, fmt.Sprintf("IP %s, PSC connection %s", rule.GetIPAddress(), stateOrUnknown(status)), nil
+		}
+		return true, fmt.Sprintf("IP %s, PSC connection ACCEPTED", rule.GetIPAddress()), nil
+	}
+	return true, "IP " + rule.GetIPAddress(), nil
+}
+
+// ServiceAttachmentConnectable waits for a service attachment to accept a
+// consumer endpoint, i.e. to list a connected endpoint ACCEPTED
+func (p *Probes) ServiceAttachmentConnectable(region, name string) Waiter {
+	return &serviceAttachmentWaiter{probes: p, region: region, name: name}
+}
+
+type serviceAttachmentWaiter struct {
+	probes       *Probes
+	region, name string
+}
+
+func (w *serviceAttachmentWaiter) Name() string {
+	return "service attachment " + w.name
+}
+
+func (w *serviceAttachmentWaiter) Ready(ctx context.Context) (bool, string, error) {
+	attachment, err := w.probes.serviceAttachments.Get(ctx, &computepb.GetServiceAttachmentRequest{
+		Project:           w.probes.project,
+		Region:            w.region,
+		ServiceAttachment: w.name,
+	})
+	if err != nil {
+		return false, "", err
+	}
+	endpoints := attachment.GetConnectedEndpoints()
+	if len(endpoints) == 0 {
+		return false, "no connected endpoint", nil
+	}
+	states := make([]string, 0, len(endpoints))
+	accepted := false
+	for _, endpoint := range endpoints {
+		if endpoint.GetStatus() == "ACCEPTED" {
+			accepted = true
+		}
+		states = append(states, fmt.Sprintf("%s=%s", lastSegment(endpoint.GetEndpoint()), stateOrUnknown(endpoint.GetStatus())))
+	}
+	return accepted, strings.Join(states, ", "), nil
+}
+
+// BackendHealthy waits for a regional backend service to report at least one
+// HEALTHY backend in the instance group at groupURL
+func (p *Probes) BackendHealthy(region, backendService, groupURL string) Waiter {
+	return &backendWaiter{probes: p, region: region, name: backendService, group: groupURL}
+}
+
+type backendWaiter struct {
+	probes              *Probes
+	region, name, group string
+}
+
+func (w *backendWaiter) Name() string {
+	return "backend service " + w.name
+}
+
+func (w *backendWaiter) Ready(ctx context.Context) (bool, string, error) {
+	health, err := w.probes.backendServices.GetHealth(ctx, &computepb.GetHealthRegionBackendServiceRequest{
+		Project:        w.probes.project,
+		Region:         w.region,
+		BackendService: w.name,
+		ResourceGroupReferenceResource: &computepb.ResourceGroupReference{
+			Group: &w.group,
+		},
+	})
+	if err != nil {
+		return false, "", err
+	}
+	if len(health.GetHealthStatus()) == 0 {
+		return false, "no health status reported", nil
+	}
+	states := make([]string, 0, len(health.GetHealthStatus()))
+	healthy := false
+	for _, status := range health.GetHealthStatus() {
+		if status.GetHealthState() == "HEALTHY" {
+			healthy = true
+		}
+		states = append(states, fmt.Sprintf("%s=%s", lastSegment(status.GetInstance()), status.GetHealthState()))
+	}
+	return healthy, strings.Join(states, ", "), nil
+}
+
+// CloudInitDone waits for the serial console of a VM to show that cloud-init
+// finished, so the containers and tools its runcmd sets up are in place
+func (p *Probes) CloudInitDone(zone, instance string) Waiter {
+	return &cloudInitWaiter{probes: p, zone: zone, name: instance}
+}
+
+type cloudInitWaiter struct {
+	probes     *Probes
+	zone, name string
+	// next is the offset of the console output not read yet, and tail the
+	// end of what was, so a line split between two reads still matches
+	next int64
+	tail string
+}
+
+func (w *cloudInitWaiter) Name() string {
+	return "VM " + w.name
+}
+
+func (w *cloudInitWaiter) Ready(ctx context.Context) (bool, string, error) {
+	output, err := w.probes.instances.GetSerialPortOutput(ctx, &computepb.GetSerialPortOutputInstanceRequest{
+		Project:  w.probes.project,
+		Zone:     w.zone,
+		Instance: w.name,
+		Start:    &w.next,
+	})
+	if err != nil {
+		return false, "", err
+	}
+	contents := w.tail + output.GetContents()
+	w.next = output.GetNext()
+	if cloudInitFinished.MatchString(contents) {
+		return true, "cloud-init finished", nil
+	}
+	if len(contents) > 256 {
+		contents = contents[len(contents)-256:]
+	}
+	w.tail = contents
+	return false, fmt.Sprintf("cloud-init running, %d bytes of console output", w.next), nil
+}
+
+// lastSegment returns the name at the end of a resource URL
+func lastSegment(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
+func stateOrUnknown(state string) string {
+	if state == "" {
+		return "STATUS_UNSPECIFIED"
+	}
+	return state
+}
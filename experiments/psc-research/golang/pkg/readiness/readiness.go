@@ -0,0 +1,92 @@
+// Package readiness waits for the resources of a demo step to be usable
+// before the next step starts. The Compute API reports an operation done
+// before the resource it created serves traffic: the endpoint of a service
+// attachment is accepted later, backends turn HEALTHY after their first
+// health checks and VMs run their cloud-init after they are RUNNING. Each
+// resource has a Waiter probing the state the next step depends on, instead
+// of sleeping a fixed time.
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Waiter probes a resource a step depends on
+type Waiter interface {
+	// Name describes the resource, e.g. "forwarding rule psc-endpoint-rule"
+	Name() string
+	// Ready probes the resource once. It returns whether the resource is
+	// ready and its state, printed while waiting. Errors, e.g. a resource
+	// not found yet, are retried until the timeout.
+	Ready(ctx context.Context) (bool, string, error)
+}
+
+// Options bound the wait
+type Options struct {
+	// Timeout is how long Wait probes before failing
+	Timeout time.Duration
+	// Interval is the delay between two probes of a resource
+	Interval time.Duration
+}
+
+// Wait probes the waiters every Interval until all of them are ready. It
+// fails after Timeout with the last state of those that are not.
+func Wait(ctx context.Context, waiters []Waiter, opts Options) error {
+	if len(waiters) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	pending := append([]Waiter(nil), waiters...)
+	states := make(map[Waiter]string, len(waiters))
+	for {
+		var next []Waiter
+		for _, w := range pending {
+			ready, state, err := w.Ready(ctx)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				// Keep the state of the last completed probe
+			case err != nil:
+				states[w] = fmt.Sprintf("error: %v", err)
+			default:
+				states[w] = state
+			}
+			if err == nil && ready {
+				color.Green("✓ %s ready after %v: %s", w.Name(), time.Since(start).Round(time.Second), state)
+				continue
+			}
+			next = append(next, w)
+		}
+		pending = next
+		if len(pending) == 0 {
+			return nil
+		}
+
+		fmt.Printf("  Waiting for %s (%v elapsed)\n", describe(pending, states), time.Since(start).Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not ready after %v: %s", opts.Timeout, describe(pending, states))
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+// describe lists waiters with their last state
+func describe(waiters []Waiter, states map[Waiter]string) string {
+	parts := make([]string, 0, len(waiters))
+	for _, w := range waiters {
+		state := states[w]
+		if state == "" {
+			state = "not probed yet"
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s)", w.Name(), state))
+	}
+	return strings.Join(parts, ", ")
+}
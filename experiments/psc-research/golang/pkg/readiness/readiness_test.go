@@ -0,0 +1,141 @@
+package readiness
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+)
+
+const testProject = "test-project"
+
+var testOptions = Options{Timeout: 200 * time.Millisecond, Interval: 5 * time.Millisecond}
+
+func newTestProbes(t *testing.T) (*Probes, *fakecompute.Server, *config.Config) {
+	t.Helper()
+
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	probes, err := NewProbes(testProject, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewProbes() error = %v", err)
+	}
+	t.Cleanup(probes.Close)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+	return probes, fake, cfg
+}
+
+// fakeWaiter is ready after a number of probes
+type fakeWaiter struct {
+	name   string
+	after  int
+	probes int
+}
+
+func (w *fakeWaiter) Name() string { return w.name }
+
+func (w *fakeWaiter) Ready(ctx context.Context) (bool, string, error) {
+	w.probes++
+	if w.probes < w.after {
+		return false, "pending", nil
+	}
+	return true, "done", nil
+}
+
+func TestWait(t *testing.T) {
+	fast := &fakeWaiter{name: "fast", after: 1}
+	slow := &fakeWaiter{name: "slow", after: 3}
+	if err := Wait(context.Background(), []Waiter{fast, slow}, testOptions); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	// A ready resource is not probed again
+	if fast.probes != 1 || slow.probes != 3 {
+		t.Errorf("probes = %d, %d, want 1, 3", fast.probes, slow.probes)
+	}
+
+	never := &fakeWaiter{name: "never", after: 1 << 30}
+	err := Wait(context.Background(), []Waiter{&fakeWaiter{name: "fast", after: 1}, never}, testOptions)
+	if err == nil || !strings.Contains(err.Error(), "never (pending)") || strings.Contains(err.Error(), "fast") {
+		t.Errorf("Wait() error = %v, want the state of the resource not ready only", err)
+	}
+}
+
+func TestPSCWaiters(t *testing.T) {
+	probes, fake, cfg := newTestProbes(t)
+	regional := "regions/" + cfg.Region + "/"
+	attachmentURL := "projects/" + testProject + "/" + regional + "serviceAttachments/" + cfg.ServiceAttachment
+
+	fake.Put(regional+"forwardingRules", cfg.ForwardingRule, map[string]any{"IPAddress": "10.1.0.10"})
+	fake.Put(regional+"forwardingRules", cfg.PSCForwardingRule, map[string]any{
+		"IPAddress":           "10.2.0.100",
+		"target":              attachmentURL,
+		"pscConnectionStatus": "PENDING",
+	})
+	fake.Put(regional+"serviceAttachments", cfg.ServiceAttachment, map[string]any{
+		"connectedEndpoints": []map[string]any{{"endpoint": cfg.PSCForwardingRule, "status": "PENDING"}},
+	})
+	fake.Put(regional+"backendServices", cfg.BackendService, nil)
+	fake.SetBackendHealth("UNHEALTHY")
+
+	err := Wait(context.Background(), probes.PSC(cfg), testOptions)
+	for _, want := range []string{
+		"backend service " + cfg.BackendService + " (backend-0=UNHEALTHY)",
+		"service attachment " + cfg.ServiceAttachment + " (" + cfg.PSCForwardingRule + "=PENDING)",
+		"forwarding rule " + cfg.PSCForwardingRule + " (IP 10.2.0.100, PSC connection PENDING)",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Wait() error = %v, want it to contain %q", err, want)
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), "forwarding rule "+cfg.ForwardingRule+" ") {
+		t.Errorf("Wait() error = %v, the load balancer rule has its IP", err)
+	}
+
+	fake.SetBackendHealth("UNHEALTHY", "HEALTHY")
+	fake.Put(regional+"serviceAttachments", cfg.ServiceAttachment, map[string]any{
+		"connectedEndpoints": []map[string]any{{"endpoint": cfg.PSCForwardingRule, "status": "ACCEPTED"}},
+	})
+	fake.Put(regional+"forwardingRules", cfg.PSCForwardingRule, map[string]any{
+		"IPAddress":           "10.2.0.100",
+		"target":              attachmentURL,
+		"pscConnectionStatus": "ACCEPTED",
+	})
+	if err := Wait(context.Background(), probes.PSC(cfg), testOptions); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+}
+
+func TestForwardingRuleNotFound(t *testing.T) {
+	probes, _, cfg := newTestProbes(t)
+
+	err := Wait(context.Background(), []Waiter{probes.ForwardingRuleHasIP(cfg.Region, "missing")}, testOptions)
+	if err == nil || !strings.Contains(err.Error(), "forwarding rule missing (error:") {
+		t.Errorf("Wait() error = %v, want the lookup error", err)
+	}
+}
+
+func TestCloudInitDone(t *testing.T) {
+	probes, fake, cfg := newTestProbes(t)
+	instances := "zones/" + cfg.Zone + "/instances"
+	fake.Put(instances, cfg.ProviderVM, nil)
+
+	waiter := probes.CloudInitDone(cfg.Zone, cfg.ProviderVM)
+	fake.SetSerialPortOutput(cfg.ProviderVM, "Booting...\nCloud-init v. 24.4-0ubuntu1 running 'modules:final'\nCloud-init v. 24.4-0ubuntu1 fin")
+	ready, state, err := waiter.Ready(context.Background())
+	if err != nil || ready {
+		t.Fatalf("Ready() = %v, %q, %v, want cloud-init still running", ready, state, err)
+	}
+
+	// The marker is split between two reads of the console
+	fake.SetSerialPortOutput(cfg.ProviderVM, "Booting...\nCloud-init v. 24.4-0ubuntu1 running 'modules:final'\nCloud-init v. 24.4-0ubuntu1 finished at Tue, 01 Jul 2025 10:00:00 +0000\n")
+	ready, state, err = waiter.Ready(context.Background())
+	if err != nil || !ready {
+		t.Errorf("Ready() = %v, %q, %v, want cloud-init finished", ready, state, err)
+	}
+}
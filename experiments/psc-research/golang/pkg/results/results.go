@@ -0,0 +1,220 @@
+// Package results exports the structured results of connectivity test runs
+// to a BigQuery table, so PSC behavior can be compared across regions, runs
+// and weeks with SQL instead of scrolling back through terminal output.
+package results
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// Outcome of a test
+type Outcome string
+
+const (
+	Passed Outcome = "passed"
+	Failed Outcome = "failed"
+)
+
+// Result records how one test of a run went
+type Result struct {
+	Test      string
+	Outcome   Outcome
+	StartedAt time.Time
+	Latency   time.Duration
+	Error     string
+}
+
+// schema is the schema of the table created when it does not exist yet. The
+// table is partitioned by day on started_at.
+var schema = []*bigquery.TableFieldSchema{
+	{Name: "run_id", Type: "STRING", Mode: "REQUIRED", Description: "psc-demo-run label of the resources tested"},
+	{Name: "invocation_id", Type: "STRING", Mode: "REQUIRED", Description: "One test command execution"},
+	{Name: "command", Type: "STRING", Mode: "REQUIRED", Description: "Command that ran the tests: demo, test or scenario"},
+	{Name: "test", Type: "STRING", Mode: "REQUIRED"},
+	{Name: "outcome", Type: "STRING", Mode: "REQUIRED"},
+	{Name: "latency_ms", Type: "FLOAT", Mode: "REQUIRED"},
+	{Name: "error", Type: "STRING", Mode: "NULLABLE"},
+	{Name: "config_hash", Type: "STRING", Mode: "REQUIRED", Description: "Digest of the tested topology, see config.Hash"},
+	{Name: "project", Type: "STRING", Mode: "REQUIRED"},
+	{Name: "region", Type: "STRING", Mode: "REQUIRED"},
+	{Name: "zone", Type: "STRING", Mode: "REQUIRED"},
+	{Name: "started_at", Type: "TIMESTAMP", Mode: "REQUIRED"},
+}
+
+// Table is a BigQuery table reference
+type Table struct {
+	Project string
+	Dataset string
+	Table   string
+}
+
+func (t Table) String() string {
+	return t.Project + "." + t.Dataset + "." + t.Table
+}
+
+// ParseTable parses "project.dataset.table", or "dataset.table" in the
+// default project
+func ParseTable(ref, defaultProject string) (Table, error) {
+	parts := strings.Split(ref, ".")
+	switch {
+	case len(parts) == 2:
+		parts = append([]string{defaultProject}, parts...)
+	case len(parts) != 3:
+		return Table{}, fmt.Errorf("invalid BigQuery table %q, want project.dataset.table or dataset.table", ref)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return Table{}, fmt.Errorf("invalid BigQuery table %q, want project.dataset.table or dataset.table", ref)
+		}
+	}
+	return Table{Project: parts[0], Dataset: parts[1], Table: parts[2]}, nil
+}
+
+// Sink writes results to a BigQuery table, creating it in an existing
+// dataset on first use
+type Sink struct {
+	service *bigquery.Service
+	table   Table
+	config  *config.Config
+	// invocationID tells apart the runs of the same run ID
+	invocationID string
+	created      bool
+}
+
+// NewSink returns the sink of the table of cfg.BigQueryTable
+func NewSink(ctx context.Context, cfg *config.Config, opts ...option.ClientOption) (*Sink, error) {
+	table, err := ParseTable(cfg.BigQueryTable, cfg.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	service, err := bigquery.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %v", err)
+	}
+	return &Sink{
+		service:      service,
+		table:        table,
+		config:       cfg,
+		invocationID: newInvocationID(),
+	}, nil
+}
+
+// newInvocationID returns a time-ordered ID unique to this execution
+func newInvocationID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// Write streams the results of one test command into the table. Each row
+// carries an insert ID, so a retried write does not duplicate it.
+func (s *Sink) Write(ctx context.Context, command string, results []Result) error {
+	if len(results) == 0 {
+		return nil
+	}
+	if err := s.ensureTable(ctx); err != nil {
+		return err
+	}
+
+	hash := s.config.Hash()
+	req := &bigquery.TableDataInsertAllRequest{}
+	for _, r := range results {
+		row := map[string]bigquery.JsonValue{
+			"run_id":        s.config.RunID,
+			"invocation_id": s.invocationID,
+			"command":       command,
+			"test":          r.Test,
+			"outcome":       string(r.Outcome),
+			"latency_ms":    float64(r.Latency.Microseconds()) / 1000,
+			"config_hash":   hash,
+			"project":       s.config.ProjectID,
+			"region":        s.config.Region,
+			"zone":          s.config.Zone,
+			"started_at":    r.StartedAt.UTC().Format(time.RFC3339Nano),
+		}
+		if r.Error != "" {
+			row["error"] = r.Error
+		}
+		req.Rows = append(req.Rows, &bigquery.TableDataInsertAllRequestRows{
+			InsertId: fmt.Sprintf("%s/%s/%s", s.invocationID, command, r.Test),
+			Json:     row,
+		})
+	}
+
+	resp, err := s.service.Tabledata.InsertAll(s.table.Project, s.table.Dataset, s.table.Table, req).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to write results to %s: %v", s.table, err)
+	}
+	if len(resp.InsertErrors) > 0 {
+		first := resp.InsertErrors[0]
+		reason := "unknown error"
+		if len(first.Errors) > 0 {
+			reason = first.Errors[0].Message
+		}
+		return fmt.Errorf("%d of %d results rejected by %s, row %d: %s", len(resp.InsertErrors), len(results), s.table, first.Index, reason)
+	}
+	return nil
+}
+
+// ensureTable creates the table with the results schema if it does not exist
+func (s *Sink) ensureTable(ctx context.Context) error {
+	if s.created {
+		return nil
+	}
+	_, err := s.service.Tables.Get(s.table.Project, s.table.Dataset, s.table.Table).Context(ctx).Do()
+	if err != nil && !hasCode(err, http.StatusNotFound) {
+		return fmt.Errorf("failed to get table %s: %v", s.table, err)
+	}
+	if err != nil {
+		table := &bigquery.Table{
+			TableReference: &bigquery.TableReference{
+				ProjectId: s.table.Project,
+				DatasetId: s.table.Dataset,
+				TableId:   s.table.Table,
+			},
+			Description:      "GCP PSC demo connectivity test results",
+			Schema:           &bigquery.TableSchema{Fields: schema},
+			TimePartitioning: &bigquery.TimePartitioning{Type: "DAY", Field: "started_at"},
+			Clustering:       &bigquery.Clustering{Fields: []string{"region", "test"}},
+		}
+		// Another run may have created it in the meantime
+		_, err := s.service.Tables.Insert(s.table.Project, s.table.Dataset, table).Context(ctx).Do()
+		if err != nil && !hasCode(err, http.StatusConflict) {
+			return fmt.Errorf("failed to create table %s (its dataset must exist): %v", s.table, err)
+		}
+	}
+	s.created = true
+	return nil
+}
+
+func hasCode(err error, code int) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == code
+}
+
+// Export writes the results of a test command to cfg.BigQueryTable, if set
+func Export(ctx context.Context, cfg *config.Config, command string, results []Result, opts ...option.ClientOption) error {
+	if cfg.BigQueryTable == "" {
+		return nil
+	}
+	sink, err := NewSink(ctx, cfg, opts...)
+	if err != nil {
+		return err
+	}
+	if err := sink.Write(ctx, command, results); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d test results to BigQuery table %s\n", len(results), sink.table)
+	return nil
+}
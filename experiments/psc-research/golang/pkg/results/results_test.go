@@ -0,0 +1,164 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+// fakeBigQuery serves the table get, insert and insertAll calls of a sink
+type fakeBigQuery struct {
+	mu      sync.Mutex
+	created *bigquery.Table
+	rows    []*bigquery.TableDataInsertAllRequestRows
+}
+
+func (f *fakeBigQuery) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/datasets/psc/tables/results"):
+		if f.created == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"Not found: Table"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(f.created)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/datasets/psc/tables"):
+		f.created = &bigquery.Table{}
+		json.NewDecoder(r.Body).Decode(f.created)
+		json.NewEncoder(w).Encode(f.created)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/tables/results/insertAll"):
+		var req bigquery.TableDataInsertAllRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.rows = append(f.rows, req.Rows...)
+		w.Write([]byte(`{}`))
+	default:
+		http.Error(w, r.Method+" "+r.URL.Path, http.StatusNotImplemented)
+	}
+}
+
+func loadConfig(t *testing.T, args ...string) *config.Config {
+	t.Helper()
+	cfg, err := config.Load("test", append([]string{"--project", "demo-project", "--bigquery-table", "psc.results"}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestParseTable(t *testing.T) {
+	for _, tc := range []struct {
+		ref  string
+		want string
+	}{
+		{"psc.results", "demo-project.psc.results"},
+		{"other.psc.results", "other.psc.results"},
+		{"results", ""},
+		{"a..b", ""},
+		{"a.b.c.d", ""},
+	} {
+		table, err := ParseTable(tc.ref, "demo-project")
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("ParseTable(%q) = %s, want an error", tc.ref, table)
+			}
+			continue
+		}
+		if err != nil || table.String() != tc.want {
+			t.Errorf("ParseTable(%q) = %s, %v, want %s", tc.ref, table, err, tc.want)
+		}
+	}
+}
+
+func TestSinkWrite(t *testing.T) {
+	fake := &fakeBigQuery{}
+	server := httptest.NewServer(http.HandlerFunc(fake.handle))
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := loadConfig(t, "--name-prefix", "alice")
+	sink, err := NewSink(ctx, cfg, option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+	results := []Result{
+		{Test: "psc-tcp-port", Outcome: Passed, StartedAt: started, Latency: 1500 * time.Millisecond},
+		{Test: "psc-https-healthz", Outcome: Failed, StartedAt: started.Add(2 * time.Second), Latency: 30 * time.Second, Error: "exit status 28"},
+	}
+	if err := sink.Write(ctx, "test", results); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(ctx, "test", results[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.created == nil {
+		t.Fatal("table was not created")
+	}
+	if p := fake.created.TimePartitioning; p == nil || p.Field != "started_at" {
+		t.Errorf("table partitioning = %+v, want daily on started_at", p)
+	}
+	if len(fake.rows) != 3 {
+		t.Fatalf("inserted %d rows, want 3", len(fake.rows))
+	}
+
+	row := fake.rows[1].Json
+	for column, want := range map[string]any{
+		"run_id":      "alice",
+		"command":     "test",
+		"test":        "psc-https-healthz",
+		"outcome":     "failed",
+		"latency_ms":  30000.0,
+		"error":       "exit status 28",
+		"config_hash": cfg.Hash(),
+		"region":      "us-central1",
+		"started_at":  "2025-06-02T10:00:02Z",
+	} {
+		if row[column] != want {
+			t.Errorf("%s = %v, want %v", column, row[column], want)
+		}
+	}
+	if _, ok := fake.rows[0].Json["error"]; ok {
+		t.Error("passed test has an error column")
+	}
+	if fake.rows[0].InsertId == fake.rows[1].InsertId {
+		t.Errorf("rows share insert ID %s", fake.rows[0].InsertId)
+	}
+	if fake.rows[0].InsertId != fake.rows[2].InsertId {
+		t.Errorf("retried row has insert ID %s, want %s", fake.rows[2].InsertId, fake.rows[0].InsertId)
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	alice := loadConfig(t, "--name-prefix", "alice", "--project", "alice-project")
+	bob := loadConfig(t, "--name-prefix", "bob", "--ssh-mode", "oslogin")
+	if alice.Hash() != bob.Hash() {
+		t.Errorf("runs of the same topology hash to %s and %s", alice.Hash(), bob.Hash())
+	}
+
+	larger := loadConfig(t, "--machine-type", "e2-small")
+	if larger.Hash() == bob.Hash() {
+		t.Error("a different machine type has the same hash")
+	}
+}
+
+func TestExportDisabled(t *testing.T) {
+	cfg := loadConfig(t, "--bigquery-table", "")
+	// No endpoint: a disabled export must not create a client
+	if err := Export(context.Background(), cfg, "test", []Result{{Test: "psc-tcp-port"}}); err != nil {
+		t.Errorf("Export() = %v with no table", err)
+	}
+}
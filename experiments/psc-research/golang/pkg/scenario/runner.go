@@ -0,0 +1,192 @@
+package scenario
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"github.com/fatih/color"
+)
+
+// Run is one scenario being executed: the configuration of the provider and
+// first consumer, plus one configuration per extra consumer
+type Run struct {
+	Config         *config.Config
+	ExtraConsumers []*config.Config
+}
+
+// Consumers returns the configuration of every consumer, the first one included
+func (r *Run) Consumers() []*config.Config {
+	return append([]*config.Config{r.Config}, r.ExtraConsumers...)
+}
+
+// NewRun builds the run of a scenario with the given number of consumers
+func NewRun(cfg *config.Config, consumers int) (*Run, error) {
+	run := &Run{Config: cfg}
+	for n := 2; n <= consumers; n++ {
+		extra, err := cfg.ExtraConsumer(n)
+		if err != nil {
+			return nil, err
+		}
+		run.ExtraConsumers = append(run.ExtraConsumers, extra)
+	}
+	return run, nil
+}
+
+// StepFunc executes one step of a scenario
+type StepFunc func(ctx context.Context, run *Run) error
+
+// Outcome of a step
+type Outcome string
+
+const (
+	Passed  Outcome = "passed"
+	Failed  Outcome = "failed"
+	Skipped Outcome = "skipped"
+)
+
+// StepResult records how one step went
+type StepResult struct {
+	Step    string  `json:"step"`
+	Outcome Outcome `json:"outcome"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Result records how one scenario went
+type Result struct {
+	Scenario    string       `json:"scenario"`
+	Description string       `json:"description,omitempty"`
+	RunID       string       `json:"runId"`
+	NamePrefix  string       `json:"namePrefix"`
+	ProjectID   string       `json:"projectId"`
+	Region      string       `json:"region"`
+	Zone        string       `json:"zone"`
+	Consumers   int          `json:"consumers"`
+	StartedAt   time.Time    `json:"startedAt"`
+	Seconds     float64      `json:"seconds"`
+	Outcome     Outcome      `json:"outcome"`
+	Error       string       `json:"error,omitempty"`
+	Steps       []StepResult `json:"steps"`
+}
+
+// Runner executes scenarios step by step
+type Runner struct {
+	// Steps implements every step name of AllSteps
+	Steps map[string]StepFunc
+
+	// Settle is the pause after each successful step for resources to propagate
+	Settle time.Duration
+}
+
+// NewRunner returns a runner executing the steps with the demo managers
+func NewRunner() *Runner {
+	return &Runner{
+		Steps:  DefaultSteps(),
+		Settle: 5 * time.Second,
+	}
+}
+
+// Execute runs the planned steps of a scenario. After a failed step the
+// remaining steps are skipped, except cleanup, which always runs when planned
+// so failed experiments do not leave resources behind.
+func (r *Runner) Execute(ctx context.Context, s Scenario, run *Run) Result {
+	cfg := run.Config
+	result := Result{
+		Scenario:    s.Name,
+		Description: s.Description,
+		RunID:       cfg.RunID,
+		NamePrefix:  cfg.NamePrefix,
+		ProjectID:   cfg.ProjectID,
+		Region:      cfg.Region,
+		Zone:        cfg.Zone,
+		Consumers:   len(run.ExtraConsumers) + 1,
+		StartedAt:   time.Now().UTC(),
+		Outcome:     Passed,
+	}
+
+	plan, err := s.Plan()
+	if err != nil {
+		result.Outcome = Failed
+		result.Error = err.Error()
+		return result
+	}
+
+	failed := false
+	for _, name := range plan {
+		if failed && name != StepCleanup {
+			result.Steps = append(result.Steps, StepResult{Step: name, Outcome: Skipped})
+			continue
+		}
+
+		color.Blue("=== Scenario %s: %s ===", s.Name, name)
+		step := StepResult{Step: name, Outcome: Passed}
+		start := time.Now()
+
+		fn, ok := r.Steps[name]
+		if !ok {
+			err = fmt.Errorf("step %s is not implemented", name)
+		} else {
+			err = fn(ctx, run)
+		}
+
+		step.Seconds = time.Since(start).Round(time.Millisecond).Seconds()
+		if err != nil {
+			step.Outcome = Failed
+			step.Error = err.Error()
+			color.Red("✗ Scenario %s: %s failed: %v", s.Name, name, err)
+			if !failed {
+				result.Outcome = Failed
+				result.Error = fmt.Sprintf("%s: %v", name, err)
+			}
+			failed = true
+		} else {
+			color.Green("✓ Scenario %s: %s passed", s.Name, name)
+			if r.Settle > 0 && name != StepCleanup {
+				time.Sleep(r.Settle)
+			}
+		}
+		result.Steps = append(result.Steps, step)
+	}
+
+	result.Seconds = time.Since(result.StartedAt).Round(time.Millisecond).Seconds()
+	return result
+}
+
+// WriteResults writes the results of a scenario matrix as JSON
+func WriteResults(path string, results []Result) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal results: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write results file %s: %v", path, err)
+	}
+	return nil
+}
+
+// PrintSummary prints one line per scenario with the outcome of each step
+func PrintSummary(w io.Writer, results []Result) {
+	for _, r := range results {
+		var steps []string
+		for _, st := range r.Steps {
+			mark := "✓"
+			switch st.Outcome {
+			case Failed:
+				mark = "✗"
+			case Skipped:
+				mark = "-"
+			}
+			steps = append(steps, mark+st.Step)
+		}
+		fmt.Fprintf(w, "%-20s %-7s %7.0fs  %s\n", r.Scenario, r.Outcome, r.Seconds, strings.Join(steps, " "))
+		if r.Error != "" {
+			fmt.Fprintf(w, "%-20s %s\n", "", r.Error)
+		}
+	}
+}
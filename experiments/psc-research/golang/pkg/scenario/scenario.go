@@ -0,0 +1,204 @@
+// Package scenario runs the PSC demo as a matrix of reproducible experiments.
+// A scenario file lists scenarios, each choosing which demo steps to run and
+// with which configuration (existing VPCs, several consumers, other machine
+// types, ...). The runner executes them one after another with the same
+// managers as the demo and records the outcome of every step.
+package scenario
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Steps of the demo in the order they run. Every step is idempotent, so a
+// scenario may skip steps whose resources an earlier run already created.
+const (
+	StepProviderVPC  = "provider-vpc"
+	StepConsumerVPC  = "consumer-vpc"
+	StepVMs          = "vms"
+	StepIsolation    = "isolation"
+	StepPSC          = "psc"
+	StepConnectivity = "connectivity"
+	StepCleanup      = "cleanup"
+)
+
+// AllSteps lists the steps in execution order. Cleanup only runs when a
+// scenario asks for it.
+var AllSteps = []string{
+	StepProviderVPC,
+	StepConsumerVPC,
+	StepVMs,
+	StepIsolation,
+	StepPSC,
+	StepConnectivity,
+	StepCleanup,
+}
+
+// File is a scenario file
+type File struct {
+	Scenarios []Scenario `yaml:"scenarios"`
+}
+
+// Scenario describes one experiment
+type Scenario struct {
+	// Name identifies the scenario and is its name prefix and run ID unless
+	// the config sets them, so it follows the NAME_PREFIX rules
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+
+	// Config holds configuration keys, as in the --config file, applied on
+	// top of the base configuration of the runner
+	Config yaml.Node `yaml:"config"`
+
+	// Steps to run, all but cleanup by default, minus Skip
+	Steps []string `yaml:"steps"`
+	Skip  []string `yaml:"skip"`
+
+	// Consumers is the number of consumer networks connected to the service
+	// attachment, each with its own VPC, client VM and PSC endpoint (default 1)
+	Consumers int `yaml:"consumers"`
+
+	// Cleanup deletes the scenario's resources at the end, even when a step failed
+	Cleanup bool `yaml:"cleanup"`
+}
+
+// Load reads and validates a scenario file
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %v", err)
+	}
+
+	var f File
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario file %s: %v", path, err)
+	}
+	if len(f.Scenarios) == 0 {
+		return nil, fmt.Errorf("scenario file %s defines no scenarios", path)
+	}
+
+	seen := map[string]bool{}
+	for i := range f.Scenarios {
+		s := &f.Scenarios[i]
+		if s.Name == "" {
+			return nil, fmt.Errorf("scenario %d has no name", i+1)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("scenario %s is defined twice", s.Name)
+		}
+		seen[s.Name] = true
+		if s.Consumers < 0 {
+			return nil, fmt.Errorf("scenario %s: consumers must not be negative", s.Name)
+		}
+		if s.Consumers == 0 {
+			s.Consumers = 1
+		}
+		if _, err := s.Plan(); err != nil {
+			return nil, fmt.Errorf("scenario %s: %v", s.Name, err)
+		}
+	}
+	return &f, nil
+}
+
+// Select returns the scenarios with the given names, in file order, or all
+// of them when names is empty
+func (f *File) Select(names []string) ([]Scenario, error) {
+	if len(names) == 0 {
+		return f.Scenarios, nil
+	}
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var selected []Scenario
+	for _, s := range f.Scenarios {
+		if wanted[s.Name] {
+			selected = append(selected, s)
+			delete(wanted, s.Name)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("no scenario named %s", name)
+	}
+	return selected, nil
+}
+
+// Plan returns the steps the scenario runs, in execution order
+func (s *Scenario) Plan() ([]string, error) {
+	include := map[string]bool{}
+	if len(s.Steps) == 0 {
+		for _, step := range AllSteps {
+			include[step] = step != StepCleanup
+		}
+	}
+	for _, step := range s.Steps {
+		if !isStep(step) {
+			return nil, fmt.Errorf("unknown step %q (valid steps: %v)", step, AllSteps)
+		}
+		include[step] = true
+	}
+	for _, step := range s.Skip {
+		if !isStep(step) {
+			return nil, fmt.Errorf("unknown step %q in skip (valid steps: %v)", step, AllSteps)
+		}
+		include[step] = false
+	}
+	if s.Cleanup {
+		include[StepCleanup] = true
+	}
+
+	var plan []string
+	for _, step := range AllSteps {
+		if include[step] {
+			plan = append(plan, step)
+		}
+	}
+	if len(plan) == 0 {
+		return nil, fmt.Errorf("no steps to run")
+	}
+	return plan, nil
+}
+
+// Includes reports whether the scenario runs a step
+func (s *Scenario) Includes(step string) bool {
+	plan, _ := s.Plan()
+	for _, name := range plan {
+		if name == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Overrides returns the configuration layers of the scenario: its name as
+// name prefix, then its config keys
+func (s *Scenario) Overrides() ([][]byte, error) {
+	name, err := yaml.Marshal(map[string]string{"namePrefix": s.Name})
+	if err != nil {
+		return nil, err
+	}
+	overrides := [][]byte{name}
+	if s.Config.Kind != 0 {
+		data, err := yaml.Marshal(&s.Config)
+		if err != nil {
+			return nil, fmt.Errorf("scenario %s: invalid config: %v", s.Name, err)
+		}
+		overrides = append(overrides, data)
+	}
+	return overrides, nil
+}
+
+func isStep(name string) bool {
+	for _, step := range AllSteps {
+		if step == name {
+			return true
+		}
+	}
+	return false
+}
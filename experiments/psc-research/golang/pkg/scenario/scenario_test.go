@@ -0,0 +1,227 @@
+package scenario
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gcp-psc-demo/pkg/config"
+)
+
+const testFile = `
+scenarios:
+  - name: baseline
+    description: Full demo in two new VPCs
+    cleanup: true
+  - name: byo-provider
+    config:
+      existingProviderVpc: shared-svc
+      machineType: e2-small
+    skip: [isolation]
+  - name: multi
+    consumers: 3
+  - name: networks
+    steps: [consumer-vpc, provider-vpc]
+`
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scenarios.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadTestFile(t *testing.T) *File {
+	t.Helper()
+	f, err := Load(writeFile(t, testFile))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return f
+}
+
+func TestLoad_Plans(t *testing.T) {
+	f := loadTestFile(t)
+
+	want := map[string][]string{
+		"baseline":     {"provider-vpc", "consumer-vpc", "vms", "isolation", "psc", "connectivity", "cleanup"},
+		"byo-provider": {"provider-vpc", "consumer-vpc", "vms", "psc", "connectivity"},
+		"multi":        {"provider-vpc", "consumer-vpc", "vms", "isolation", "psc", "connectivity"},
+		"networks":     {"provider-vpc", "consumer-vpc"},
+	}
+	for _, s := range f.Scenarios {
+		plan, err := s.Plan()
+		if err != nil {
+			t.Fatalf("%s: Plan() error = %v", s.Name, err)
+		}
+		if !reflect.DeepEqual(plan, want[s.Name]) {
+			t.Errorf("%s: plan = %v, want %v", s.Name, plan, want[s.Name])
+		}
+	}
+	if f.Scenarios[0].Consumers != 1 || f.Scenarios[2].Consumers != 3 {
+		t.Errorf("consumers = %d, %d, want 1 by default and 3", f.Scenarios[0].Consumers, f.Scenarios[2].Consumers)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"empty":        "scenarios: []",
+		"no name":      "scenarios: [{steps: [vms]}]",
+		"duplicate":    "scenarios: [{name: a}, {name: a}]",
+		"unknown step": "scenarios: [{name: a, steps: [vm]}]",
+		"unknown skip": "scenarios: [{name: a, skip: [tls]}]",
+		"unknown key":  "scenarios: [{name: a, tls: true}]",
+		"nothing left": "scenarios: [{name: a, steps: [vms], skip: [vms]}]",
+		"negative":     "scenarios: [{name: a, consumers: -1}]",
+	} {
+		if _, err := Load(writeFile(t, content)); err == nil {
+			t.Errorf("%s: Load() succeeded, want an error", name)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	f := loadTestFile(t)
+
+	selected, err := f.Select([]string{"networks", "baseline"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 2 || selected[0].Name != "baseline" || selected[1].Name != "networks" {
+		t.Errorf("selected = %v, want baseline and networks in file order", selected)
+	}
+
+	if _, err := f.Select([]string{"missing"}); err == nil {
+		t.Error("Select() of an unknown scenario succeeded")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	t.Setenv("PROJECT_ID", "test-project")
+	t.Setenv("NAME_PREFIX", "")
+	t.Setenv("RUN_ID", "")
+	f := loadTestFile(t)
+
+	overrides, err := f.Scenarios[1].Overrides()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flags win over the scenario config
+	cfg, err := config.LoadWithOptions("scenario", []string{"--machine-type", "e2-medium"}, config.Options{Overrides: overrides})
+	if err != nil {
+		t.Fatalf("LoadWithOptions() error = %v", err)
+	}
+
+	if cfg.RunID != "byo-provider" || cfg.ConsumerVPC != "byo-provider-hypershift-customer" {
+		t.Errorf("run ID %s, consumer VPC %s, want them named after the scenario", cfg.RunID, cfg.ConsumerVPC)
+	}
+	if cfg.ProviderVPC != "shared-svc" || cfg.ExistingProviderVPC != "shared-svc" {
+		t.Errorf("provider VPC = %s, want the existing shared-svc", cfg.ProviderVPC)
+	}
+	if cfg.MachineType != "e2-medium" {
+		t.Errorf("machine type = %s, want the flag value e2-medium", cfg.MachineType)
+	}
+}
+
+func TestNewRun_ExtraConsumers(t *testing.T) {
+	cfg := config.NewConfig()
+
+	run, err := NewRun(cfg, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumers := run.Consumers()
+	if len(consumers) != 3 {
+		t.Fatalf("len(consumers) = %d, want 3", len(consumers))
+	}
+	third := consumers[2]
+	if third.ConsumerVPC != cfg.ConsumerVPC+"-c3" || third.ConsumerVM != cfg.ConsumerVM+"-c3" ||
+		third.PSCForwardingRule != cfg.PSCForwardingRule+"-c3" {
+		t.Errorf("third consumer = %s, %s, %s, want the first consumer's names with -c3", third.ConsumerVPC, third.ConsumerVM, third.PSCForwardingRule)
+	}
+	if third.ProviderVM != cfg.ProviderVM || third.ServiceAttachment != cfg.ServiceAttachment {
+		t.Errorf("third consumer does not share the provider")
+	}
+
+	cfg.UseExistingVPCs("", "shared-apps")
+	if _, err := NewRun(cfg, 2); err == nil {
+		t.Error("NewRun() with an existing consumer VPC and 2 consumers succeeded")
+	}
+}
+
+func TestExecute(t *testing.T) {
+	var ran []string
+	step := func(name string, err error) StepFunc {
+		return func(context.Context, *Run) error {
+			ran = append(ran, name)
+			return err
+		}
+	}
+	runner := &Runner{Steps: map[string]StepFunc{
+		StepProviderVPC:  step(StepProviderVPC, nil),
+		StepConsumerVPC:  step(StepConsumerVPC, nil),
+		StepVMs:          step(StepVMs, errors.New("quota exceeded")),
+		StepIsolation:    step(StepIsolation, nil),
+		StepPSC:          step(StepPSC, nil),
+		StepConnectivity: step(StepConnectivity, nil),
+		StepCleanup:      step(StepCleanup, nil),
+	}}
+	run, err := NewRun(config.NewConfig(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runner.Execute(context.Background(), Scenario{Name: "a", Cleanup: true}, run)
+
+	// Cleanup runs even though vms failed
+	if want := []string{"provider-vpc", "consumer-vpc", "vms", "cleanup"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	if result.Outcome != Failed || result.Error != "vms: quota exceeded" {
+		t.Errorf("result = %s (%s), want failed in vms", result.Outcome, result.Error)
+	}
+	outcomes := map[string]Outcome{}
+	for _, st := range result.Steps {
+		outcomes[st.Step] = st.Outcome
+	}
+	want := map[string]Outcome{
+		"provider-vpc": Passed, "consumer-vpc": Passed, "vms": Failed,
+		"isolation": Skipped, "psc": Skipped, "connectivity": Skipped, "cleanup": Passed,
+	}
+	if !reflect.DeepEqual(outcomes, want) {
+		t.Errorf("outcomes = %v, want %v", outcomes, want)
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	results := []Result{{Scenario: "a", Outcome: Passed, Steps: []StepResult{{Step: StepVMs, Outcome: Passed, Seconds: 1.5}}}}
+
+	if err := WriteResults(path, results); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Result
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, results) {
+		t.Errorf("results = %+v, want %+v", got, results)
+	}
+
+	var summary strings.Builder
+	PrintSummary(&summary, results)
+	if !strings.Contains(summary.String(), "✓vms") {
+		t.Errorf("summary = %q, want the vms step marked passed", summary.String())
+	}
+}
@@ -0,0 +1,224 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/psc"
+	"gcp-psc-demo/pkg/results"
+	"gcp-psc-demo/pkg/state"
+	"gcp-psc-demo/pkg/teardown"
+	pscTesting "gcp-psc-demo/pkg/testing"
+	"gcp-psc-demo/pkg/verify"
+	"gcp-psc-demo/pkg/vm"
+	"gcp-psc-demo/pkg/vpc"
+	"github.com/fatih/color"
+)
+
+// DefaultSteps returns the steps of the demo, run with the same managers as
+// cmd/main.go. Consumer steps run once per consumer of the scenario.
+func DefaultSteps() map[string]StepFunc {
+	return map[string]StepFunc{
+		StepProviderVPC:  setupProviderVPC,
+		StepConsumerVPC:  setupConsumerVPCs,
+		StepVMs:          deployVMs,
+		StepIsolation:    forEachConsumer(testIsolation),
+		StepPSC:          forEachConsumer(setupPSC),
+		StepConnectivity: forEachConsumer(testConnectivity),
+		StepCleanup:      cleanup,
+	}
+}
+
+// forEachConsumer runs a step for the first consumer, then every extra one
+func forEachConsumer(step func(context.Context, *config.Config) error) StepFunc {
+	return func(ctx context.Context, run *Run) error {
+		for i, cfg := range run.Consumers() {
+			if len(run.ExtraConsumers) > 0 {
+				color.Blue("--- Consumer %d: %s ---", i+1, cfg.ConsumerVPC)
+			}
+			if err := step(ctx, cfg); err != nil {
+				if len(run.ExtraConsumers) > 0 {
+					return fmt.Errorf("consumer %d: %v", i+1, err)
+				}
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func setupProviderVPC(ctx context.Context, run *Run) error {
+	cfg := run.Config
+	vpcManager, err := vpc.NewVPCManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer vpcManager.Close()
+
+	if cfg.ExistingProviderVPC != "" {
+		if err := vpcManager.UseExistingProviderVPC(ctx); err != nil {
+			return err
+		}
+		// Record the discovered subnet names for later commands
+		return SaveState(run)
+	}
+	return vpcManager.CreateProviderVPC(ctx)
+}
+
+func setupConsumerVPCs(ctx context.Context, run *Run) error {
+	if err := forEachConsumer(setupConsumerVPC)(ctx, run); err != nil {
+		return err
+	}
+	if run.Config.ExistingConsumerVPC != "" {
+		return SaveState(run)
+	}
+	return nil
+}
+
+func setupConsumerVPC(ctx context.Context, cfg *config.Config) error {
+	vpcManager, err := vpc.NewVPCManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer vpcManager.Close()
+
+	if cfg.ExistingConsumerVPC != "" {
+		return vpcManager.UseExistingConsumerVPC(ctx)
+	}
+	return vpcManager.CreateConsumerVPC(ctx)
+}
+
+// deployVMs uploads the API server emulator, deploys the provider VM and
+// every client VM, then waits until they are ready
+func deployVMs(ctx context.Context, run *Run) error {
+	vmManager, err := vm.NewVMManager(run.Config)
+	if err != nil {
+		return err
+	}
+	defer vmManager.Close()
+
+	// The provider VM downloads the API server emulator on boot
+	if err := vmManager.UploadAPIServer(); err != nil {
+		return err
+	}
+
+	// The provider VM already exists for extra consumers and is skipped
+	for _, cfg := range run.Consumers() {
+		consumerVMs, err := vm.NewVMManager(cfg)
+		if err != nil {
+			return err
+		}
+		err = consumerVMs.DeployVMs(ctx)
+		if err == nil {
+			err = consumerVMs.WaitForVMsReady(ctx)
+		}
+		consumerVMs.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testIsolation(ctx context.Context, cfg *config.Config) error {
+	testManager, err := pscTesting.NewTestManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer testManager.Close()
+
+	err = testManager.TestIsolation(ctx)
+	if exportErr := results.Export(ctx, cfg, "scenario", testManager.Results()); exportErr != nil {
+		color.Yellow("⚠ Warning: %v", exportErr)
+	}
+	return err
+}
+
+// setupPSC creates the load balancer and service attachment with the first
+// consumer; later consumers find them and only add their endpoint
+func setupPSC(ctx context.Context, cfg *config.Config) error {
+	pscManager, err := psc.NewPSCManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer pscManager.Close()
+
+	return pscManager.SetupPrivateServiceConnect(ctx)
+}
+
+func testConnectivity(ctx context.Context, cfg *config.Config) error {
+	testManager, err := pscTesting.NewTestManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer testManager.Close()
+
+	err = testManager.TestConnectivity(ctx)
+	if exportErr := results.Export(ctx, cfg, "scenario", testManager.Results()); exportErr != nil {
+		color.Yellow("⚠ Warning: %v", exportErr)
+	}
+	return err
+}
+
+// cleanup deletes the extra consumers, then everything else as cmd/cleanup.go
+// does, and fails if the verification sweep finds leftovers
+func cleanup(ctx context.Context, run *Run) error {
+	for _, extra := range run.ExtraConsumers {
+		if err := runTeardown(ctx, extra, true); err != nil {
+			return err
+		}
+	}
+	if err := runTeardown(ctx, run.Config, false); err != nil {
+		return err
+	}
+
+	cfg := run.Config
+	object := fmt.Sprintf("gs://%s/%s", cfg.ArtifactBucketName(), cfg.APIServerObject())
+	if output, err := exec.Command("gcloud", "storage", "rm", object, "--quiet").CombinedOutput(); err != nil {
+		color.Yellow("⚠ Warning: failed to delete %s: %s", object, strings.TrimSpace(string(output)))
+	}
+
+	verifier, err := verify.NewVerifier(cfg)
+	if err != nil {
+		return err
+	}
+	defer verifier.Close()
+
+	leftovers, err := verifier.Sweep(ctx)
+	if err != nil {
+		return err
+	}
+	verify.PrintReport(leftovers)
+	if len(leftovers) > 0 {
+		return fmt.Errorf("%d resource(s) left after cleanup", len(leftovers))
+	}
+	return state.Remove(cfg.StateFile)
+}
+
+func runTeardown(ctx context.Context, cfg *config.Config, consumerOnly bool) error {
+	td, err := teardown.NewTeardown(cfg)
+	if err != nil {
+		return err
+	}
+	defer td.Close()
+	td.ConsumerOnly = consumerOnly
+
+	// Failures are retried by the teardown and reported by the sweep
+	for _, r := range td.Run(ctx) {
+		if r.Outcome == teardown.Failed {
+			color.Yellow("⚠ Failed to delete %s: %v", r.ID(), r.Err)
+		}
+	}
+	return nil
+}
+
+// SaveState records the run with its extra consumers in the state file, so
+// the cleanup command can find them
+func SaveState(run *Run) error {
+	st := state.FromConfig(run.Config)
+	st.ExtraConsumers = len(run.ExtraConsumers)
+	return state.Save(run.Config.StateFile, st)
+}
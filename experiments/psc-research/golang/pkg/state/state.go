@@ -0,0 +1,178 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+)
+
+// State records which demo run owns a set of resources so that cleanup can
+// target the same names and parallel runs in one project stay isolated
+type State struct {
+	RunID      string            `json:"runId"`
+	NamePrefix string            `json:"namePrefix,omitempty"`
+	ProjectID  string            `json:"projectId"`
+	Region     string            `json:"region"`
+	Zone       string            `json:"zone"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	Resources  map[string]string `json:"resources"`
+
+	// Existing VPCs the run was deployed into, which cleanup must not delete
+	ExistingProviderVPC string `json:"existingProviderVpc,omitempty"`
+	ExistingConsumerVPC string `json:"existingConsumerVpc,omitempty"`
+
+	// ExtraConsumers is the number of additional consumers of a
+	// multi-consumer scenario, see config.Config.ExtraConsumer
+	ExtraConsumers int `json:"extraConsumers,omitempty"`
+
+	// Secondary region of a multi-region run, see config.Config.Secondary
+	SecondaryRegion string `json:"secondaryRegion,omitempty"`
+	SecondaryZone   string `json:"secondaryZone,omitempty"`
+
+	// ConnectivityBackend the run was deployed with, see
+	// config.Config.ConnectivityBackend
+	ConnectivityBackend string `json:"connectivityBackend,omitempty"`
+
+	// Connections are the PSC connection state transitions observed by
+	// `status --watch`, oldest first
+	Connections []Transition `json:"connections,omitempty"`
+}
+
+// Transition is a change of the connection state of a PSC resource, e.g.
+// the consumer forwarding rule going from PENDING to ACCEPTED. From is empty
+// for the state first observed.
+type Transition struct {
+	At       time.Time `json:"at"`
+	Resource string    `json:"resource"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to"`
+}
+
+// FromConfig builds the state for the run described by cfg
+func FromConfig(cfg *config.Config) *State {
+	return &State{
+		RunID:      cfg.RunID,
+		NamePrefix: cfg.NamePrefix,
+		ProjectID:  cfg.ProjectID,
+		Region:     cfg.Region,
+		Zone:       cfg.Zone,
+		CreatedAt:  time.Now().UTC(),
+		Resources: map[string]string{
+			"providerVpc":        cfg.ProviderVPC,
+			"providerSubnet":     cfg.ProviderSubnet,
+			"pscNatSubnet":       cfg.PSCNATSubnet,
+			"consumerVpc":        cfg.ConsumerVPC,
+			"consumerSubnet":     cfg.ConsumerSubnet,
+			"providerVm":         cfg.ProviderVM,
+			"consumerVm":         cfg.ConsumerVM,
+			"healthCheck":        cfg.HealthCheck,
+			"instanceGroup":      cfg.InstanceGroup,
+			"backendService":     cfg.BackendService,
+			"forwardingRule":     cfg.ForwardingRule,
+			"serviceAttachment":  cfg.ServiceAttachment,
+			"pscEndpoint":        cfg.PSCEndpoint,
+			"pscForwardingRule":  cfg.PSCForwardingRule,
+			"providerVpnGateway": cfg.ProviderVPNGateway,
+			"consumerVpnGateway": cfg.ConsumerVPNGateway,
+			"providerRouter":     cfg.ProviderRouter,
+			"consumerRouter":     cfg.ConsumerRouter,
+		},
+		ExistingProviderVPC: cfg.ExistingProviderVPC,
+		ExistingConsumerVPC: cfg.ExistingConsumerVPC,
+		SecondaryRegion:     cfg.SecondaryRegion,
+		SecondaryZone:       cfg.SecondaryZone,
+		ConnectivityBackend: cfg.ConnectivityBackend,
+	}
+}
+
+// ApplyConnectivityBackend switches cfg to the connectivity backend the run
+// was deployed with, so cleanup deletes its peerings or VPN resources without
+// repeating the flag. It reports whether cfg changed.
+func (st *State) ApplyConnectivityBackend(cfg *config.Config) bool {
+	if st.ConnectivityBackend == "" || st.ConnectivityBackend == cfg.ConnectivityBackend {
+		return false
+	}
+	cfg.ConnectivityBackend = st.ConnectivityBackend
+	return true
+}
+
+// ApplySecondaryRegion switches cfg to the secondary region the run was
+// deployed to, so cleanup and the failover test find it without repeating
+// the flags. It reports whether cfg changed.
+func (st *State) ApplySecondaryRegion(cfg *config.Config) bool {
+	if st.SecondaryRegion == "" || cfg.SecondaryRegion != "" {
+		return false
+	}
+	cfg.SecondaryRegion = st.SecondaryRegion
+	cfg.SecondaryZone = st.SecondaryZone
+	return true
+}
+
+// ApplyExistingVPCs switches cfg to the existing VPCs the run was deployed
+// into, along with the subnets discovered in them, so later commands look at
+// the same networks without repeating the flags. It reports whether cfg changed.
+func (st *State) ApplyExistingVPCs(cfg *config.Config) bool {
+	changed := false
+	if st.ExistingProviderVPC != "" && cfg.ExistingProviderVPC == "" {
+		cfg.UseExistingVPCs(st.ExistingProviderVPC, "")
+		cfg.ProviderSubnet = st.Resources["providerSubnet"]
+		cfg.PSCNATSubnet = st.Resources["pscNatSubnet"]
+		changed = true
+	}
+	if st.ExistingConsumerVPC != "" && cfg.ExistingConsumerVPC == "" {
+		cfg.UseExistingVPCs("", st.ExistingConsumerVPC)
+		cfg.ConsumerSubnet = st.Resources["consumerSubnet"]
+		changed = true
+	}
+	return changed
+}
+
+// Save writes the state file, keeping the original creation time and the
+// connection history if one exists
+func Save(path string, st *State) error {
+	if existing, err := Load(path); err == nil && existing != nil {
+		st.CreatedAt = existing.CreatedAt
+		if st.Connections == nil {
+			st.Connections = existing.Connections
+		}
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Load reads the state file, returning nil without error if it does not exist
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read state file %s: %v", path, err)
+	}
+
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", path, err)
+	}
+	return &st, nil
+}
+
+// Remove deletes the state file once the run's resources are gone
+func Remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove state file %s: %v", path, err)
+	}
+	return nil
+}
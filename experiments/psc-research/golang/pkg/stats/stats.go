@@ -0,0 +1,15 @@
+// Package stats holds the summary statistics shared by the reports of the
+// experiments that repeat a measurement over several runs.
+package stats
+
+import "cmp"
+
+// Percentile returns the nearest-rank percentile p of sorted values, which
+// must not be empty
+func Percentile[T cmp.Ordered](sorted []T, p int) T {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
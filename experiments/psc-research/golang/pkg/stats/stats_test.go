@@ -0,0 +1,35 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for _, tc := range []struct {
+		p    int
+		want float64
+	}{
+		{0, 1},
+		{1, 1},
+		{50, 5},
+		{51, 6},
+		{90, 9},
+		{91, 10},
+		{100, 10},
+	} {
+		if got := Percentile(values, tc.p); got != tc.want {
+			t.Errorf("Percentile(1..10, %d) = %v, want %v", tc.p, got, tc.want)
+		}
+	}
+
+	// A single value is every percentile
+	if got := Percentile([]time.Duration{time.Second}, 90); got != time.Second {
+		t.Errorf("Percentile([1s], 90) = %s, want 1s", got)
+	}
+	durations := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if got := Percentile(durations, 50); got != 2*time.Second {
+		t.Errorf("Percentile([1s 2s 3s], 50) = %s, want 2s", got)
+	}
+}
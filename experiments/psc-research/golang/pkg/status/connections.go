@@ -0,0 +1,149 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"gcp-psc-demo/pkg/state"
+	"github.com/fatih/color"
+)
+
+// Absent is the connection state of a resource that does not exist, or of an
+// endpoint the service attachment does not list
+const Absent = "ABSENT"
+
+// Connections is the connection state of the PSC resources of a run, by
+// resource: the pscConnectionStatus of the consumer forwarding rule, and the
+// status of every endpoint in the connectedEndpoints of the service
+// attachment. Resources without a state are absent.
+type Connections map[string]string
+
+// Connections polls the connection state of the PSC resources once
+func (r *Reporter) Connections(ctx context.Context) (Connections, error) {
+	cfg := r.config
+	conns := Connections{}
+
+	rule, err := r.forwardingRuleClient.Get(ctx, &computepb.GetForwardingRuleRequest{
+		Project: cfg.ProjectID, Region: cfg.Region, ForwardingRule: cfg.PSCForwardingRule,
+	})
+	switch {
+	case err == nil:
+		// The status is empty until the endpoint is created against an attachment
+		if status := rule.GetPscConnectionStatus(); status != "" {
+			conns["forwarding-rules/"+cfg.PSCForwardingRule] = status
+		}
+	case !isNotFound(err):
+		return nil, fmt.Errorf("failed to get PSC forwarding rule: %v", err)
+	}
+
+	sa, err := r.serviceAttachmentClient.Get(ctx, &computepb.GetServiceAttachmentRequest{
+		Project: cfg.ProjectID, Region: cfg.Region, ServiceAttachment: cfg.ServiceAttachment,
+	})
+	switch {
+	case err == nil:
+		for _, ep := range sa.GetConnectedEndpoints() {
+			endpoint := fmt.Sprintf("%d", ep.GetPscConnectionId())
+			if ep.GetEndpoint() != "" {
+				endpoint = lastSegment(ep.GetEndpoint())
+			}
+			conns["service-attachments/"+cfg.ServiceAttachment+"/endpoints/"+endpoint] = ep.GetStatus()
+		}
+	case !isNotFound(err):
+		return nil, fmt.Errorf("failed to get service attachment: %v", err)
+	}
+
+	return conns, nil
+}
+
+// DiffConnections returns the transitions from prev to next at the given
+// time, sorted by resource. A nil prev is the first poll: every resource
+// with a state is reported as first observed.
+func DiffConnections(prev, next Connections, at time.Time) []state.Transition {
+	resources := map[string]bool{}
+	for resource := range prev {
+		resources[resource] = true
+	}
+	for resource := range next {
+		resources[resource] = true
+	}
+
+	var transitions []state.Transition
+	for resource := range resources {
+		from, to := connectionState(prev, resource), connectionState(next, resource)
+		if prev == nil {
+			if to != Absent {
+				transitions = append(transitions, state.Transition{At: at, Resource: resource, To: to})
+			}
+			continue
+		}
+		if from != to {
+			transitions = append(transitions, state.Transition{At: at, Resource: resource, From: from, To: to})
+		}
+	}
+	sort.Slice(transitions, func(i, j int) bool { return transitions[i].Resource < transitions[j].Resource })
+	return transitions
+}
+
+func connectionState(conns Connections, resource string) string {
+	if s, ok := conns[resource]; ok {
+		return s
+	}
+	return Absent
+}
+
+// WatchConnections polls the connection state every interval until ctx is
+// done and calls report with every transition, starting with the state first
+// observed. A failed poll is retried at the next interval; only a failure of
+// the first one is returned.
+func (r *Reporter) WatchConnections(ctx context.Context, interval time.Duration, report func(state.Transition)) error {
+	var prev Connections
+	for {
+		conns, err := r.Connections(ctx)
+		switch {
+		case err == nil:
+			for _, t := range DiffConnections(prev, conns, time.Now().UTC()) {
+				report(t)
+			}
+			prev = conns
+		case ctx.Err() != nil:
+			return nil
+		case prev == nil:
+			return err
+		default:
+			color.Yellow("⚠ Warning: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// PrintTransition writes one transition as a timestamped line
+func PrintTransition(w io.Writer, t state.Transition) {
+	change := "observed " + colorConnectionState(t.To)
+	if t.From != "" {
+		change = colorConnectionState(t.From) + " → " + colorConnectionState(t.To)
+	}
+	fmt.Fprintf(w, "%s  %s  %s\n", t.At.Local().Format("2006-01-02 15:04:05"), t.Resource, change)
+}
+
+// colorConnectionState highlights the states that need attention
+func colorConnectionState(s string) string {
+	switch strings.ToUpper(s) {
+	case "ACCEPTED":
+		return color.GreenString(s)
+	case "PENDING":
+		return color.YellowString(s)
+	case "REJECTED", "CLOSED", "NEEDS_ATTENTION", Absent:
+		return color.RedString(s)
+	}
+	return s
+}
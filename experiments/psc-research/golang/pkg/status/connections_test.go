@@ -0,0 +1,144 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/state"
+	"github.com/fatih/color"
+)
+
+func TestDiffConnections(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rule := "forwarding-rules/customer-psc-forwarding-rule"
+	endpoint := "service-attachments/redhat-service-attachment/endpoints/customer-psc-forwarding-rule"
+
+	tests := []struct {
+		name       string
+		prev, next Connections
+		want       []state.Transition
+	}{
+		{
+			name: "first poll",
+			prev: nil,
+			next: Connections{rule: "PENDING"},
+			want: []state.Transition{{At: at, Resource: rule, To: "PENDING"}},
+		},
+		{
+			name: "first poll of nothing",
+			prev: nil,
+			next: Connections{},
+		},
+		{
+			name: "accepted",
+			prev: Connections{rule: "PENDING"},
+			next: Connections{rule: "ACCEPTED", endpoint: "ACCEPTED"},
+			want: []state.Transition{
+				{At: at, Resource: rule, From: "PENDING", To: "ACCEPTED"},
+				{At: at, Resource: endpoint, From: Absent, To: "ACCEPTED"},
+			},
+		},
+		{
+			name: "endpoint removed",
+			prev: Connections{rule: "ACCEPTED", endpoint: "ACCEPTED"},
+			next: Connections{rule: "ACCEPTED"},
+			want: []state.Transition{{At: at, Resource: endpoint, From: "ACCEPTED", To: Absent}},
+		},
+		{
+			name: "unchanged",
+			prev: Connections{rule: "ACCEPTED"},
+			next: Connections{rule: "ACCEPTED"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffConnections(tt.prev, tt.next, at); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffConnections() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnections(t *testing.T) {
+	reporter, fake := newTestReporter(t)
+	cfg := reporter.config
+	regional := "regions/" + cfg.Region + "/"
+
+	conns, err := reporter.Connections(context.Background())
+	if err != nil || len(conns) != 0 {
+		t.Fatalf("Connections() = %v, %v, want none before the run", conns, err)
+	}
+
+	fake.Put(regional+"forwardingRules", cfg.PSCForwardingRule, map[string]any{"pscConnectionStatus": "ACCEPTED"})
+	fake.Put(regional+"serviceAttachments", cfg.ServiceAttachment, map[string]any{
+		"connectedEndpoints": []map[string]any{
+			{"pscConnectionId": "42", "status": "ACCEPTED", "endpoint": "projects/p/regions/r/forwardingRules/" + cfg.PSCForwardingRule},
+			{"pscConnectionId": "43", "status": "PENDING"},
+		},
+	})
+
+	conns, err = reporter.Connections(context.Background())
+	if err != nil {
+		t.Fatalf("Connections() error = %v", err)
+	}
+	want := Connections{
+		"forwarding-rules/" + cfg.PSCForwardingRule:                                            "ACCEPTED",
+		"service-attachments/" + cfg.ServiceAttachment + "/endpoints/" + cfg.PSCForwardingRule: "ACCEPTED",
+		"service-attachments/" + cfg.ServiceAttachment + "/endpoints/43":                       "PENDING",
+	}
+	if !reflect.DeepEqual(conns, want) {
+		t.Errorf("Connections() = %v, want %v", conns, want)
+	}
+
+	fake.Fail(http.MethodGet, "serviceAttachments", http.StatusForbidden, "forbidden", 1)
+	if _, err := reporter.Connections(context.Background()); err == nil {
+		t.Error("Connections() error = nil, want the service attachment failure")
+	}
+}
+
+func TestWatchConnections(t *testing.T) {
+	reporter, fake := newTestReporter(t)
+	cfg := reporter.config
+	rules := "regions/" + cfg.Region + "/forwardingRules"
+	fake.Put(rules, cfg.PSCForwardingRule, map[string]any{"pscConnectionStatus": "PENDING"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var seen []string
+	err := reporter.WatchConnections(ctx, time.Millisecond, func(tr state.Transition) {
+		seen = append(seen, tr.From+">"+tr.To)
+		switch tr.To {
+		case "PENDING":
+			fake.Put(rules, cfg.PSCForwardingRule, map[string]any{"pscConnectionStatus": "ACCEPTED"})
+		case "ACCEPTED":
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("WatchConnections() error = %v", err)
+	}
+	if got := strings.Join(seen, ","); got != ">PENDING,PENDING>ACCEPTED" {
+		t.Errorf("transitions = %s, want >PENDING,PENDING>ACCEPTED", got)
+	}
+}
+
+func TestPrintTransition(t *testing.T) {
+	color.NoColor = true
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+
+	var out bytes.Buffer
+	PrintTransition(&out, state.Transition{At: at, Resource: "forwarding-rules/ep", To: "PENDING"})
+	PrintTransition(&out, state.Transition{At: at, Resource: "forwarding-rules/ep", From: "PENDING", To: "ACCEPTED"})
+
+	want := "2026-01-02 03:04:05  forwarding-rules/ep  observed PENDING\n" +
+		"2026-01-02 03:04:05  forwarding-rules/ep  PENDING → ACCEPTED\n"
+	if out.String() != want {
+		t.Errorf("PrintTransition() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,416 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"gcp-psc-demo/pkg/config"
+	"github.com/fatih/color"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// State is what a lookup found for one resource
+type State string
+
+const (
+	Exists   State = "exists"
+	NotFound State = "not found"
+	Error    State = "error"
+)
+
+// Row is one resource in the status table
+type Row struct {
+	// Kind is the gcloud resource type, e.g. "forwarding-rules"
+	Kind  string
+	Name  string
+	State State
+	// Details holds the key attributes of an existing resource, or the error
+	Details string
+}
+
+// Reporter looks up every demo resource of a run by its configured name
+type Reporter struct {
+	networkClient           *compute.NetworksClient
+	subnetClient            *compute.SubnetworksClient
+	firewallClient          *compute.FirewallsClient
+	instancesClient         *compute.InstancesClient
+	instanceGroupClient     *compute.InstanceGroupsClient
+	backendServiceClient    *compute.RegionBackendServicesClient
+	healthCheckClient       *compute.HealthChecksClient
+	forwardingRuleClient    *compute.ForwardingRulesClient
+	serviceAttachmentClient *compute.ServiceAttachmentsClient
+	addressClient           *compute.AddressesClient
+	config                  *config.Config
+}
+
+// NewReporter creates a new status reporter
+func NewReporter(cfg *config.Config, opts ...option.ClientOption) (*Reporter, error) {
+	ctx := context.Background()
+	r := &Reporter{config: cfg}
+
+	var err error
+	if r.networkClient, err = compute.NewNetworksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create networks client: %v", err)
+	}
+	if r.subnetClient, err = compute.NewSubnetworksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create subnetworks client: %v", err)
+	}
+	if r.firewallClient, err = compute.NewFirewallsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create firewalls client: %v", err)
+	}
+	if r.instancesClient, err = compute.NewInstancesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create instances client: %v", err)
+	}
+	if r.instanceGroupClient, err = compute.NewInstanceGroupsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create instance groups client: %v", err)
+	}
+	if r.backendServiceClient, err = compute.NewRegionBackendServicesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create backend services client: %v", err)
+	}
+	if r.healthCheckClient, err = compute.NewHealthChecksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create health checks client: %v", err)
+	}
+	if r.forwardingRuleClient, err = compute.NewForwardingRulesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create forwarding rules client: %v", err)
+	}
+	if r.serviceAttachmentClient, err = compute.NewServiceAttachmentsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create service attachments client: %v", err)
+	}
+	if r.addressClient, err = compute.NewAddressesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create addresses client: %v", err)
+	}
+
+	return r, nil
+}
+
+// Close closes all clients
+func (r *Reporter) Close() {
+	for _, c := range []interface{ Close() error }{
+		r.networkClient,
+		r.subnetClient,
+		r.firewallClient,
+		r.instancesClient,
+		r.instanceGroupClient,
+		r.backendServiceClient,
+		r.healthCheckClient,
+		r.forwardingRuleClient,
+		r.serviceAttachmentClient,
+		r.addressClient,
+	} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// lookup fetches one resource and summarizes its key attributes
+type lookup struct {
+	kind, name string
+	describe   func(ctx context.Context) (string, error)
+}
+
+// Collect looks up every resource of the run in creation order. Lookups never
+// stop early, so a partially created or partially deleted run shows exactly
+// which resources are there.
+func (r *Reporter) Collect(ctx context.Context) []Row {
+	var rows []Row
+	for _, l := range r.lookups(ctx) {
+		row := Row{Kind: l.kind, Name: l.name}
+		details, err := l.describe(ctx)
+		switch {
+		case err == nil:
+			row.State = Exists
+			row.Details = details
+		case isNotFound(err):
+			row.State = NotFound
+		default:
+			row.State = Error
+			row.Details = err.Error()
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func (r *Reporter) lookups(ctx context.Context) []lookup {
+	cfg := r.config
+	lookups := []lookup{
+		{"networks", cfg.ProviderVPC, r.network(cfg.ProviderVPC, cfg.ExistingProviderVPC != "")},
+		{"subnets", cfg.ProviderSubnet, r.subnet(cfg.ProviderSubnet)},
+		{"subnets", cfg.PSCNATSubnet, r.subnet(cfg.PSCNATSubnet)},
+		{"networks", cfg.ConsumerVPC, r.network(cfg.ConsumerVPC, cfg.ExistingConsumerVPC != "")},
+		{"subnets", cfg.ConsumerSubnet, r.subnet(cfg.ConsumerSubnet)},
+	}
+	lookups = append(lookups, r.firewalls(ctx)...)
+	return append(lookups,
+		lookup{"instances", cfg.ProviderVM, r.instance(cfg.ProviderVM)},
+		lookup{"instances", cfg.ConsumerVM, r.instance(cfg.ConsumerVM)},
+		lookup{"health-checks", cfg.HealthCheck, r.healthCheck},
+		lookup{"instance-groups", cfg.InstanceGroup, r.instanceGroup},
+		lookup{"backend-services", cfg.BackendService, r.backendService},
+		lookup{"forwarding-rules", cfg.ForwardingRule, r.forwardingRule(cfg.ForwardingRule)},
+		lookup{"service-attachments", cfg.ServiceAttachment, r.serviceAttachment},
+		lookup{"addresses", cfg.PSCEndpoint + "-ip", r.address},
+		lookup{"forwarding-rules", cfg.PSCForwardingRule, r.forwardingRule(cfg.PSCForwardingRule)},
+	)
+}
+
+func (r *Reporter) network(name string, existing bool) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		network, err := r.networkClient.Get(ctx, &computepb.GetNetworkRequest{
+			Project: r.config.ProjectID, Network: name,
+		})
+		if err != nil {
+			return "", err
+		}
+		details := fmt.Sprintf("%d subnets", len(network.GetSubnetworks()))
+		if existing {
+			details += ", existing VPC (kept on cleanup)"
+		}
+		return details, nil
+	}
+}
+
+func (r *Reporter) subnet(name string) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		subnet, err := r.subnetClient.Get(ctx, &computepb.GetSubnetworkRequest{
+			Project: r.config.ProjectID, Region: r.config.Region, Subnetwork: name,
+		})
+		if err != nil {
+			return "", err
+		}
+		details := subnet.GetIpCidrRange()
+		if purpose := subnet.GetPurpose(); purpose != "" && purpose != "PRIVATE" {
+			details += ", purpose " + purpose
+		}
+		return details, nil
+	}
+}
+
+// firewalls lists the rules created by the demo. A failed listing becomes a
+// single error row.
+func (r *Reporter) firewalls(ctx context.Context) []lookup {
+	it := r.firewallClient.List(ctx, &computepb.ListFirewallsRequest{Project: r.config.ProjectID})
+
+	var lookups []lookup
+	for {
+		fw, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return []lookup{{"firewall-rules", "*", func(context.Context) (string, error) {
+				return "", fmt.Errorf("failed to list firewall rules: %v", err)
+			}}}
+		}
+		if !r.config.OwnsName(fw.GetName()) {
+			continue
+		}
+		details := describeFirewall(fw)
+		lookups = append(lookups, lookup{"firewall-rules", fw.GetName(), func(context.Context) (string, error) {
+			return details, nil
+		}})
+	}
+	return lookups
+}
+
+func (r *Reporter) instance(name string) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		instance, err := r.instancesClient.Get(ctx, &computepb.GetInstanceRequest{
+			Project: r.config.ProjectID, Zone: r.config.Zone, Instance: name,
+		})
+		if err != nil {
+			return "", err
+		}
+		details := instance.GetStatus()
+		if nics := instance.GetNetworkInterfaces(); len(nics) > 0 && nics[0].GetNetworkIP() != "" {
+			details += ", IP " + nics[0].GetNetworkIP()
+		}
+		return details, nil
+	}
+}
+
+func (r *Reporter) healthCheck(ctx context.Context) (string, error) {
+	hc, err := r.healthCheckClient.Get(ctx, &computepb.GetHealthCheckRequest{
+		Project: r.config.ProjectID, HealthCheck: r.config.HealthCheck,
+	})
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case hc.GetHttpsHealthCheck() != nil:
+		return fmt.Sprintf("HTTPS :%d%s", hc.GetHttpsHealthCheck().GetPort(), hc.GetHttpsHealthCheck().GetRequestPath()), nil
+	case hc.GetHttpHealthCheck() != nil:
+		return fmt.Sprintf("HTTP :%d%s", hc.GetHttpHealthCheck().GetPort(), hc.GetHttpHealthCheck().GetRequestPath()), nil
+	case hc.GetTcpHealthCheck() != nil:
+		return fmt.Sprintf("TCP :%d", hc.GetTcpHealthCheck().GetPort()), nil
+	}
+	return hc.GetType(), nil
+}
+
+func (r *Reporter) instanceGroup(ctx context.Context) (string, error) {
+	group, err := r.instanceGroupClient.Get(ctx, &computepb.GetInstanceGroupRequest{
+		Project: r.config.ProjectID, Zone: r.config.Zone, InstanceGroup: r.config.InstanceGroup,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d instances", group.GetSize()), nil
+}
+
+// backendService reports the health of every backend, which is what usually
+// explains a PSC endpoint that accepts connections but gets no answer
+func (r *Reporter) backendService(ctx context.Context) (string, error) {
+	bs, err := r.backendServiceClient.Get(ctx, &computepb.GetRegionBackendServiceRequest{
+		Project: r.config.ProjectID, Region: r.config.Region, BackendService: r.config.BackendService,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(bs.GetBackends()) == 0 {
+		return "no backends", nil
+	}
+
+	var states []string
+	for _, backend := range bs.GetBackends() {
+		group := backend.GetGroup()
+		health, err := r.backendServiceClient.GetHealth(ctx, &computepb.GetHealthRegionBackendServiceRequest{
+			Project:        r.config.ProjectID,
+			Region:         r.config.Region,
+			BackendService: r.config.BackendService,
+			ResourceGroupReferenceResource: &computepb.ResourceGroupReference{
+				Group: &group,
+			},
+		})
+		if err != nil {
+			states = append(states, fmt.Sprintf("%s health unknown: %v", lastSegment(group), err))
+			continue
+		}
+		if len(health.GetHealthStatus()) == 0 {
+			states = append(states, lastSegment(group)+" no health status yet")
+			continue
+		}
+		for _, s := range health.GetHealthStatus() {
+			states = append(states, lastSegment(s.GetInstance())+"="+s.GetHealthState())
+		}
+	}
+	return strings.Join(states, ", "), nil
+}
+
+func (r *Reporter) forwardingRule(name string) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		rule, err := r.forwardingRuleClient.Get(ctx, &computepb.GetForwardingRuleRequest{
+			Project: r.config.ProjectID, Region: r.config.Region, ForwardingRule: name,
+		})
+		if err != nil {
+			return "", err
+		}
+		details := "IP " + rule.GetIPAddress()
+		if ports := rule.GetPorts(); len(ports) > 0 {
+			details += ", ports " + strings.Join(ports, ",")
+		}
+		if status := rule.GetPscConnectionStatus(); status != "" {
+			details += ", PSC " + status
+		}
+		return details, nil
+	}
+}
+
+func (r *Reporter) serviceAttachment(ctx context.Context) (string, error) {
+	sa, err := r.serviceAttachmentClient.Get(ctx, &computepb.GetServiceAttachmentRequest{
+		Project: r.config.ProjectID, Region: r.config.Region, ServiceAttachment: r.config.ServiceAttachment,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	details := strings.ToLower(sa.GetConnectionPreference())
+	endpoints := sa.GetConnectedEndpoints()
+	if len(endpoints) == 0 {
+		return details + ", no connected endpoints", nil
+	}
+	for _, ep := range endpoints {
+		details += fmt.Sprintf(", endpoint %d %s", ep.GetPscConnectionId(), ep.GetStatus())
+	}
+	return details, nil
+}
+
+func (r *Reporter) address(ctx context.Context) (string, error) {
+	address, err := r.addressClient.Get(ctx, &computepb.GetAddressRequest{
+		Project: r.config.ProjectID, Region: r.config.Region, Address: r.config.PSCEndpoint + "-ip",
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s, %s", address.GetAddress(), address.GetStatus()), nil
+}
+
+// describeFirewall summarizes a rule as e.g. "INGRESS tcp:22 from 0.0.0.0/0"
+func describeFirewall(fw *computepb.Firewall) string {
+	var allowed []string
+	for _, a := range fw.GetAllowed() {
+		if len(a.GetPorts()) == 0 {
+			allowed = append(allowed, a.GetIPProtocol())
+			continue
+		}
+		for _, p := range a.GetPorts() {
+			allowed = append(allowed, a.GetIPProtocol()+":"+p)
+		}
+	}
+
+	details := fw.GetDirection() + " " + strings.Join(allowed, ",")
+	if ranges := fw.GetSourceRanges(); len(ranges) > 0 {
+		details += " from " + strings.Join(ranges, ",")
+	}
+	if ranges := fw.GetDestinationRanges(); len(ranges) > 0 {
+		details += " to " + strings.Join(ranges, ",")
+	}
+	return details
+}
+
+// Print writes rows as an aligned table, coloring the state column
+func Print(w io.Writer, rows []Row) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tSTATE\tDETAILS")
+	for _, row := range rows {
+		// Pad before coloring so the escape codes do not skew the alignment
+		state := fmt.Sprintf("%-9s", row.State)
+		switch row.State {
+		case Exists:
+			state = color.GreenString(state)
+		case NotFound:
+			state = color.YellowString(state)
+		case Error:
+			state = color.RedString(state)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", row.Kind, row.Name, state, row.Details)
+	}
+	tw.Flush()
+}
+
+// Summarize counts rows per state
+func Summarize(rows []Row) map[State]int {
+	counts := map[State]int{}
+	for _, row := range rows {
+		counts[row.State]++
+	}
+	return counts
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+func lastSegment(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
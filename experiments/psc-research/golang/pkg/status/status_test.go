@@ -0,0 +1,141 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+	"github.com/fatih/color"
+)
+
+const testProject = "test-project"
+
+func newTestReporter(t *testing.T) (*Reporter, *fakecompute.Server) {
+	t.Helper()
+
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+
+	reporter, err := NewReporter(cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewReporter() error = %v", err)
+	}
+	t.Cleanup(reporter.Close)
+	return reporter, fake
+}
+
+func TestCollect_NothingCreated(t *testing.T) {
+	reporter, _ := newTestReporter(t)
+
+	rows := reporter.Collect(context.Background())
+
+	if got := len(rows); got != 14 {
+		t.Errorf("len(rows) = %d, want 14", got)
+	}
+	for _, row := range rows {
+		if row.State != NotFound {
+			t.Errorf("%s/%s: state = %s (%s), want not found", row.Kind, row.Name, row.State, row.Details)
+		}
+	}
+}
+
+func TestCollect_PartialRun(t *testing.T) {
+	reporter, fake := newTestReporter(t)
+	cfg := reporter.config
+	regional := "regions/" + cfg.Region + "/"
+	zonal := "zones/" + cfg.Zone + "/"
+
+	fake.Put("global/networks", cfg.ProviderVPC, nil)
+	fake.Put(regional+"subnetworks", cfg.PSCNATSubnet, map[string]any{
+		"ipCidrRange": cfg.PSCNATSubnetRange,
+		"purpose":     "PRIVATE_SERVICE_CONNECT",
+	})
+	fake.Put("global/firewalls", cfg.ProviderVPC+"-allow-ssh", map[string]any{
+		"direction":    "INGRESS",
+		"sourceRanges": []string{"0.0.0.0/0"},
+		"allowed":      []map[string]any{{"IPProtocol": "tcp", "ports": []string{"22"}}},
+	})
+	fake.Put("global/firewalls", "default-allow-ssh", nil)
+	fake.Put(zonal+"instances", cfg.ProviderVM, map[string]any{
+		"status":            "RUNNING",
+		"networkInterfaces": []map[string]any{{"networkIP": "10.1.0.2"}},
+	})
+	fake.Put(regional+"backendServices", cfg.BackendService, map[string]any{
+		"backends": []map[string]any{{"group": "zones/" + cfg.Zone + "/instanceGroups/" + cfg.InstanceGroup}},
+	})
+	fake.SetBackendHealth("UNHEALTHY")
+	fake.Put(regional+"serviceAttachments", cfg.ServiceAttachment, map[string]any{
+		"connectionPreference": "ACCEPT_AUTOMATIC",
+		"connectedEndpoints":   []map[string]any{{"pscConnectionId": "42", "status": "ACCEPTED"}},
+	})
+	fake.Put(regional+"forwardingRules", cfg.PSCForwardingRule, map[string]any{
+		"IPAddress":           "10.2.0.100",
+		"pscConnectionStatus": "ACCEPTED",
+	})
+	fake.Fail(http.MethodGet, "instanceGroups", http.StatusForbidden, "forbidden", 1)
+
+	rows := reporter.Collect(context.Background())
+
+	want := map[string]struct {
+		state   State
+		details string
+	}{
+		"networks/" + cfg.ProviderVPC:                      {Exists, "0 subnets"},
+		"networks/" + cfg.ConsumerVPC:                      {NotFound, ""},
+		"subnets/" + cfg.PSCNATSubnet:                      {Exists, "10.1.1.0/24, purpose PRIVATE_SERVICE_CONNECT"},
+		"firewall-rules/" + cfg.ProviderVPC + "-allow-ssh": {Exists, "INGRESS tcp:22 from 0.0.0.0/0"},
+		"instances/" + cfg.ProviderVM:                      {Exists, "RUNNING, IP 10.1.0.2"},
+		"instance-groups/" + cfg.InstanceGroup:             {Error, "forbidden"},
+		"backend-services/" + cfg.BackendService:           {Exists, "backend-0=UNHEALTHY"},
+		"service-attachments/" + cfg.ServiceAttachment:     {Exists, "accept_automatic, endpoint 42 ACCEPTED"},
+		"forwarding-rules/" + cfg.PSCForwardingRule:        {Exists, "IP 10.2.0.100, PSC ACCEPTED"},
+	}
+
+	got := map[string]Row{}
+	for _, row := range rows {
+		got[row.Kind+"/"+row.Name] = row
+	}
+	if _, ok := got["firewall-rules/default-allow-ssh"]; ok {
+		t.Errorf("rows include a firewall rule of another network")
+	}
+	for id, w := range want {
+		row, ok := got[id]
+		if !ok {
+			t.Errorf("%s: missing row", id)
+			continue
+		}
+		if row.State != w.state || !strings.Contains(row.Details, w.details) {
+			t.Errorf("%s = %s %q, want %s %q", id, row.State, row.Details, w.state, w.details)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	color.NoColor = true
+	rows := []Row{
+		{Kind: "networks", Name: "hypershift-redhat", State: Exists, Details: "2 subnets"},
+		{Kind: "forwarding-rules", Name: "customer-psc-forwarding-rule", State: NotFound},
+	}
+
+	var out bytes.Buffer
+	Print(&out, rows)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "KIND") {
+		t.Fatalf("Print() output = %q, want a header and 2 rows", out.String())
+	}
+	if col := strings.Index(lines[0], "STATE"); strings.Index(lines[1], "exists") != col || strings.Index(lines[2], "not found") != col {
+		t.Errorf("state column is not aligned:\n%s", out.String())
+	}
+
+	if counts := Summarize(rows); counts[Exists] != 1 || counts[NotFound] != 1 {
+		t.Errorf("Summarize() = %v, want 1 exists and 1 not found", counts)
+	}
+}
@@ -0,0 +1,550 @@
+package teardown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcpops"
+	"github.com/fatih/color"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// Outcome is what happened to one resource during teardown
+type Outcome string
+
+const (
+	Deleted  Outcome = "deleted"
+	NotFound Outcome = "not found"
+	Failed   Outcome = "failed"
+)
+
+// Result records the teardown of one resource
+type Result struct {
+	// Kind is the gcloud resource type, matching verify.Resource.Kind
+	Kind     string
+	Name     string
+	Outcome  Outcome
+	Attempts int
+	Err      error
+}
+
+// ID returns the resource in kind/name form, the same key verify uses
+func (r Result) ID() string {
+	return r.Kind + "/" + r.Name
+}
+
+// step deletes one resource and waits for the operation
+type step struct {
+	kind   string
+	name   string
+	delete func(ctx context.Context) (*compute.Operation, error)
+	wait   func(ctx context.Context, operation string) error
+}
+
+// stage is a group of resources that only depend on resources in later stages
+type stage struct {
+	name  string
+	steps func(ctx context.Context) ([]step, error)
+}
+
+// Teardown deletes the demo resources in dependency order: PSC endpoint →
+// service attachment → ILB forwarding rule → backend service → instance group
+// and health check → VMs → VPN tunnels, gateways and routers or VPC peerings →
+// firewall rules → subnets → VPCs
+type Teardown struct {
+	networkClient           *compute.NetworksClient
+	subnetClient            *compute.SubnetworksClient
+	firewallClient          *compute.FirewallsClient
+	instancesClient         *compute.InstancesClient
+	instanceGroupClient     *compute.InstanceGroupsClient
+	backendServiceClient    *compute.RegionBackendServicesClient
+	healthCheckClient       *compute.HealthChecksClient
+	forwardingRuleClient    *compute.ForwardingRulesClient
+	serviceAttachmentClient *compute.ServiceAttachmentsClient
+	addressClient           *compute.AddressesClient
+	vpnTunnelClient         *compute.VpnTunnelsClient
+	vpnGatewayClient        *compute.VpnGatewaysClient
+	routerClient            *compute.RoutersClient
+	ops                     *gcpops.Waiter
+	config                  *config.Config
+
+	// RetryTimeout bounds how long a resource that is still in use by another
+	// resource is retried, RetryInterval is the delay between attempts
+	RetryTimeout  time.Duration
+	RetryInterval time.Duration
+
+	// ConsumerOnly limits the teardown to the consumer side: the PSC
+	// endpoint, client VM, consumer firewall rules, subnet and VPC. Extra
+	// consumers of a multi-consumer run are deleted this way before the
+	// provider they share.
+	ConsumerOnly bool
+
+	// RegionOnly limits the teardown to the regional resources of the
+	// secondary region of a multi-region run: its PSC endpoint, load
+	// balancer, provider VM and subnets. The VPCs and firewall rules it
+	// shares with the primary region are deleted with the primary region.
+	RegionOnly bool
+}
+
+// NewTeardown creates a new teardown
+func NewTeardown(cfg *config.Config, opts ...option.ClientOption) (*Teardown, error) {
+	ctx := context.Background()
+	t := &Teardown{
+		ops:           gcpops.NewWaiter(gcpops.PoolFor(opts...), cfg.ProjectID, cfg.Region, cfg.Zone),
+		config:        cfg,
+		RetryTimeout:  3 * time.Minute,
+		RetryInterval: 10 * time.Second,
+	}
+
+	var err error
+	if t.networkClient, err = compute.NewNetworksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create networks client: %v", err)
+	}
+	if t.subnetClient, err = compute.NewSubnetworksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create subnetworks client: %v", err)
+	}
+	if t.firewallClient, err = compute.NewFirewallsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create firewalls client: %v", err)
+	}
+	if t.instancesClient, err = compute.NewInstancesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create instances client: %v", err)
+	}
+	if t.instanceGroupClient, err = compute.NewInstanceGroupsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create instance groups client: %v", err)
+	}
+	if t.backendServiceClient, err = compute.NewRegionBackendServicesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create backend services client: %v", err)
+	}
+	if t.healthCheckClient, err = compute.NewHealthChecksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create health checks client: %v", err)
+	}
+	if t.forwardingRuleClient, err = compute.NewForwardingRulesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create forwarding rules client: %v", err)
+	}
+	if t.serviceAttachmentClient, err = compute.NewServiceAttachmentsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create service attachments client: %v", err)
+	}
+	if t.addressClient, err = compute.NewAddressesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create addresses client: %v", err)
+	}
+	if t.vpnTunnelClient, err = compute.NewVpnTunnelsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN tunnels client: %v", err)
+	}
+	if t.vpnGatewayClient, err = compute.NewVpnGatewaysRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN gateways client: %v", err)
+	}
+	if t.routerClient, err = compute.NewRoutersRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create routers client: %v", err)
+	}
+
+	return t, nil
+}
+
+// Close closes all clients
+func (t *Teardown) Close() {
+	for _, c := range []interface{ Close() error }{
+		t.networkClient,
+		t.subnetClient,
+		t.firewallClient,
+		t.instancesClient,
+		t.instanceGroupClient,
+		t.backendServiceClient,
+		t.healthCheckClient,
+		t.forwardingRuleClient,
+		t.serviceAttachmentClient,
+		t.addressClient,
+		t.vpnTunnelClient,
+		t.vpnGatewayClient,
+		t.routerClient,
+	} {
+		if c != nil {
+			c.Close()
+		}
+	}
+	t.ops.Pool.Release()
+}
+
+// Run deletes every demo resource, stage by stage. A resource still in use by
+// another resource is retried until RetryTimeout; once a stage has failures,
+// later stages get a single attempt each since their dependents are known to
+// remain. Run never stops early: every resource gets a Result.
+func (t *Teardown) Run(ctx context.Context) []Result {
+	var results []Result
+	blocked := false
+
+	for i, s := range t.stages() {
+		color.Blue("=== Teardown %d: %s ===", i+1, s.name)
+
+		steps, err := s.steps(ctx)
+		if err != nil {
+			color.Yellow("⚠ Warning: %v", err)
+			blocked = true
+			continue
+		}
+
+		for _, st := range steps {
+			r := t.deleteWithRetry(ctx, st, !blocked)
+			results = append(results, r)
+			if r.Outcome == Failed {
+				blocked = true
+			}
+		}
+	}
+
+	return results
+}
+
+// deleteWithRetry deletes one resource, retrying in-use errors when retry is set
+func (t *Teardown) deleteWithRetry(ctx context.Context, st step, retry bool) Result {
+	r := Result{Kind: st.kind, Name: st.name}
+	deadline := time.Now().Add(t.RetryTimeout)
+
+	fmt.Printf("Deleting %s: %s\n", st.kind, st.name)
+	for {
+		r.Attempts++
+		err := t.deleteOnce(ctx, st)
+
+		switch {
+		case err == nil:
+			r.Outcome = Deleted
+			fmt.Printf("  ✓ deleted\n")
+			return r
+		case IsNotFound(err):
+			r.Outcome = NotFound
+			fmt.Printf("  already gone\n")
+			return r
+		case !IsResourceInUse(err) || !retry || time.Now().Add(t.RetryInterval).After(deadline):
+			r.Outcome = Failed
+			r.Err = err
+			color.Yellow("  ⚠ %v", err)
+			return r
+		}
+
+		fmt.Printf("  still in use, retrying in %v (attempt %d): %v\n", t.RetryInterval, r.Attempts, err)
+		select {
+		case <-ctx.Done():
+			r.Outcome = Failed
+			r.Err = ctx.Err()
+			return r
+		case <-time.After(t.RetryInterval):
+		}
+	}
+}
+
+func (t *Teardown) deleteOnce(ctx context.Context, st step) error {
+	op, err := st.delete(ctx)
+	if err != nil {
+		return err
+	}
+	return st.wait(ctx, op.Name())
+}
+
+// IsNotFound reports whether err is a 404 from the Compute API
+func IsNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// IsResourceInUse reports whether a delete failed because another resource
+// still references the target, either when the call was made or when the
+// operation ran
+func IsResourceInUse(err error) bool {
+	var opErr *gcpops.OperationError
+	if errors.As(err, &opErr) {
+		return opErr.HasCode("RESOURCE_IN_USE_BY_ANOTHER_RESOURCE")
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		for _, e := range apiErr.Errors {
+			if e.Reason == "resourceInUseByAnotherResource" {
+				return true
+			}
+		}
+		return strings.Contains(apiErr.Message, "is already being used by")
+	}
+	return false
+}
+
+// stages returns the teardown plan, dependents first
+func (t *Teardown) stages() []stage {
+	cfg := t.config
+	fixed := func(steps ...step) func(context.Context) ([]step, error) {
+		return func(context.Context) ([]step, error) { return steps, nil }
+	}
+
+	// Existing VPCs and their subnets belong to the user and are left alone
+	var subnets, networks []step
+	if cfg.ExistingProviderVPC == "" && !t.ConsumerOnly {
+		subnets = append(subnets, t.subnet(cfg.ProviderSubnet), t.subnet(cfg.PSCNATSubnet))
+		networks = append(networks, t.network(cfg.ProviderVPC))
+	}
+	if cfg.ExistingConsumerVPC == "" {
+		subnets = append(subnets, t.subnet(cfg.ConsumerSubnet))
+		networks = append(networks, t.network(cfg.ConsumerVPC))
+	}
+
+	endpoint := stage{"PSC endpoint", fixed(
+		t.forwardingRule(cfg.PSCForwardingRule),
+		t.address(cfg.PSCEndpoint+"-ip"),
+	)}
+	if t.RegionOnly {
+		return []stage{
+			endpoint,
+			{"Service attachment", fixed(t.serviceAttachment(cfg.ServiceAttachment))},
+			{"Load balancer forwarding rule", fixed(t.forwardingRule(cfg.ForwardingRule))},
+			{"Backend service", fixed(t.backendService(cfg.BackendService))},
+			{"Instance group and health check", fixed(
+				t.instanceGroup(cfg.InstanceGroup),
+				t.healthCheck(cfg.HealthCheck),
+			)},
+			{"VMs", fixed(t.instance(cfg.ProviderVM))},
+			{"Subnets", fixed(subnets...)},
+		}
+	}
+	if t.ConsumerOnly {
+		return []stage{
+			endpoint,
+			{"VMs", fixed(t.instance(cfg.ConsumerVM))},
+			{"Firewall rules", t.firewallSteps},
+			{"Subnets", fixed(subnets...)},
+			{"VPCs", fixed(networks...)},
+		}
+	}
+
+	stages := []stage{
+		endpoint,
+		{"Service attachment", fixed(t.serviceAttachment(cfg.ServiceAttachment))},
+		{"Load balancer forwarding rule", fixed(t.forwardingRule(cfg.ForwardingRule))},
+		{"Backend service", fixed(t.backendService(cfg.BackendService))},
+		{"Instance group and health check", fixed(
+			t.instanceGroup(cfg.InstanceGroup),
+			t.healthCheck(cfg.HealthCheck),
+		)},
+		{"VMs", fixed(
+			t.instance(cfg.ProviderVM),
+			t.instance(cfg.ConsumerVM),
+		)},
+	}
+
+	// The peering and VPN backends connect the VPCs, which cannot be
+	// deleted while still connected
+	switch cfg.ConnectivityBackend {
+	case config.BackendPeering:
+		stages = append(stages, stage{"VPC peerings", fixed(
+			t.peering(cfg.ProviderVPC, cfg.ConsumerVPC),
+			t.peering(cfg.ConsumerVPC, cfg.ProviderVPC),
+		)})
+	case config.BackendVPN:
+		var tunnels []step
+		for _, gateway := range []string{cfg.ProviderVPNGateway, cfg.ConsumerVPNGateway} {
+			for _, name := range cfg.VPNTunnels(gateway) {
+				tunnels = append(tunnels, t.vpnTunnel(name))
+			}
+		}
+		stages = append(stages,
+			stage{"VPN tunnels", fixed(tunnels...)},
+			stage{"VPN gateways and Cloud Routers", fixed(
+				t.vpnGateway(cfg.ProviderVPNGateway),
+				t.vpnGateway(cfg.ConsumerVPNGateway),
+				t.router(cfg.ProviderRouter),
+				t.router(cfg.ConsumerRouter),
+			)})
+	}
+
+	return append(stages,
+		stage{"Firewall rules", t.firewallSteps},
+		stage{"Subnets", fixed(subnets...)},
+		stage{"VPCs", fixed(networks...)},
+	)
+}
+
+// ownedNetworks returns the VPCs created by the demo, leaving out existing ones
+func (t *Teardown) ownedNetworks() map[string]bool {
+	networks := map[string]bool{}
+	if t.config.ExistingProviderVPC == "" && !t.ConsumerOnly {
+		networks[t.config.ProviderVPC] = true
+	}
+	if t.config.ExistingConsumerVPC == "" {
+		networks[t.config.ConsumerVPC] = true
+	}
+	return networks
+}
+
+// firewallSteps discovers every firewall rule attached to the demo VPCs, since
+// any rule left on a network blocks its deletion
+func (t *Teardown) firewallSteps(ctx context.Context) ([]step, error) {
+	networks := t.ownedNetworks()
+	if len(networks) == 0 {
+		return nil, nil
+	}
+
+	it := t.firewallClient.List(ctx, &computepb.ListFirewallsRequest{Project: t.config.ProjectID})
+
+	var steps []step
+	for {
+		fw, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list firewall rules: %v", err)
+		}
+		network := fw.GetNetwork()
+		if networks[network[strings.LastIndex(network, "/")+1:]] {
+			steps = append(steps, t.firewall(fw.GetName()))
+		}
+	}
+	return steps, nil
+}
+
+func (t *Teardown) forwardingRule(name string) step {
+	return step{kind: "forwarding-rules", name: name, wait: t.ops.WaitRegional,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.forwardingRuleClient.Delete(ctx, &computepb.DeleteForwardingRuleRequest{
+				Project: t.config.ProjectID, Region: t.config.Region, ForwardingRule: name,
+			})
+		}}
+}
+
+func (t *Teardown) address(name string) step {
+	return step{kind: "addresses", name: name, wait: t.ops.WaitRegional,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.addressClient.Delete(ctx, &computepb.DeleteAddressRequest{
+				Project: t.config.ProjectID, Region: t.config.Region, Address: name,
+			})
+		}}
+}
+
+func (t *Teardown) serviceAttachment(name string) step {
+	return step{kind: "service-attachments", name: name, wait: t.ops.WaitRegional,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.serviceAttachmentClient.Delete(ctx, &computepb.DeleteServiceAttachmentRequest{
+				Project: t.config.ProjectID, Region: t.config.Region, ServiceAttachment: name,
+			})
+		}}
+}
+
+func (t *Teardown) backendService(name string) step {
+	return step{kind: "backend-services", name: name, wait: t.ops.WaitRegional,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.backendServiceClient.Delete(ctx, &computepb.DeleteRegionBackendServiceRequest{
+				Project: t.config.ProjectID, Region: t.config.Region, BackendService: name,
+			})
+		}}
+}
+
+func (t *Teardown) instanceGroup(name string) step {
+	return step{kind: "instance-groups", name: name, wait: t.ops.WaitZonal,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.instanceGroupClient.Delete(ctx, &computepb.DeleteInstanceGroupRequest{
+				Project: t.config.ProjectID, Zone: t.config.Zone, InstanceGroup: name,
+			})
+		}}
+}
+
+func (t *Teardown) healthCheck(name string) step {
+	return step{kind: "health-checks", name: name, wait: t.ops.WaitGlobal,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.healthCheckClient.Delete(ctx, &computepb.DeleteHealthCheckRequest{
+				Project: t.config.ProjectID, HealthCheck: name,
+			})
+		}}
+}
+
+func (t *Teardown) instance(name string) step {
+	return step{kind: "instances", name: name, wait: t.ops.WaitZonal,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.instancesClient.Delete(ctx, &computepb.DeleteInstanceRequest{
+				Project: t.config.ProjectID, Zone: t.config.Zone, Instance: name,
+			})
+		}}
+}
+
+func (t *Teardown) firewall(name string) step {
+	return step{kind: "firewall-rules", name: name, wait: t.ops.WaitGlobal,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.firewallClient.Delete(ctx, &computepb.DeleteFirewallRequest{
+				Project: t.config.ProjectID, Firewall: name,
+			})
+		}}
+}
+
+func (t *Teardown) vpnTunnel(name string) step {
+	return step{kind: "vpn-tunnels", name: name, wait: t.ops.WaitRegional,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.vpnTunnelClient.Delete(ctx, &computepb.DeleteVpnTunnelRequest{
+				Project: t.config.ProjectID, Region: t.config.Region, VpnTunnel: name,
+			})
+		}}
+}
+
+func (t *Teardown) vpnGateway(name string) step {
+	return step{kind: "vpn-gateways", name: name, wait: t.ops.WaitRegional,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.vpnGatewayClient.Delete(ctx, &computepb.DeleteVpnGatewayRequest{
+				Project: t.config.ProjectID, Region: t.config.Region, VpnGateway: name,
+			})
+		}}
+}
+
+func (t *Teardown) router(name string) step {
+	return step{kind: "routers", name: name, wait: t.ops.WaitRegional,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.routerClient.Delete(ctx, &computepb.DeleteRouterRequest{
+				Project: t.config.ProjectID, Region: t.config.Region, Router: name,
+			})
+		}}
+}
+
+// peering removes the peering named peer from network. Removing a missing
+// peering is a 400, so it is looked up first and reported as a 404 when gone.
+func (t *Teardown) peering(network, peer string) step {
+	return step{kind: "network-peerings", name: network + "/" + peer, wait: t.ops.WaitGlobal,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			n, err := t.networkClient.Get(ctx, &computepb.GetNetworkRequest{
+				Project: t.config.ProjectID, Network: network,
+			})
+			if err != nil {
+				return nil, err
+			}
+			found := false
+			for _, p := range n.GetPeerings() {
+				found = found || p.GetName() == peer
+			}
+			if !found {
+				return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("no peering %s in network %s", peer, network)}
+			}
+			return t.networkClient.RemovePeering(ctx, &computepb.RemovePeeringNetworkRequest{
+				Project: t.config.ProjectID, Network: network,
+				NetworksRemovePeeringRequestResource: &computepb.NetworksRemovePeeringRequest{Name: &peer},
+			})
+		}}
+}
+
+func (t *Teardown) subnet(name string) step {
+	return step{kind: "subnets", name: name, wait: t.ops.WaitRegional,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.subnetClient.Delete(ctx, &computepb.DeleteSubnetworkRequest{
+				Project: t.config.ProjectID, Region: t.config.Region, Subnetwork: name,
+			})
+		}}
+}
+
+func (t *Teardown) network(name string) step {
+	return step{kind: "networks", name: name, wait: t.ops.WaitGlobal,
+		delete: func(ctx context.Context) (*compute.Operation, error) {
+			return t.networkClient.Delete(ctx, &computepb.DeleteNetworkRequest{
+				Project: t.config.ProjectID, Network: name,
+			})
+		}}
+}
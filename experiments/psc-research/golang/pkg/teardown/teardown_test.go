@@ -0,0 +1,326 @@
+package teardown
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+	"gcp-psc-demo/pkg/gcpops"
+)
+
+const testProject = "test-project"
+
+func newTestTeardown(t *testing.T) (*Teardown, *fakecompute.Server) {
+	t.Helper()
+
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+
+	td, err := NewTeardown(cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewTeardown() error = %v", err)
+	}
+	t.Cleanup(td.Close)
+
+	td.ops.Backoff = gcpops.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	td.RetryInterval = time.Millisecond
+	td.RetryTimeout = time.Second
+	return td, fake
+}
+
+// seed creates every demo resource in the fake, plus a firewall rule on an unrelated network
+func seed(fake *fakecompute.Server, cfg *config.Config) {
+	regional := "regions/" + cfg.Region + "/"
+	zonal := "zones/" + cfg.Zone + "/"
+	network := func(name string) map[string]any {
+		return map[string]any{"network": "https://www.googleapis.com/compute/v1/projects/" + testProject + "/global/networks/" + name}
+	}
+
+	fake.Put(regional+"forwardingRules", cfg.PSCForwardingRule, nil)
+	fake.Put(regional+"addresses", cfg.PSCEndpoint+"-ip", nil)
+	fake.Put(regional+"serviceAttachments", cfg.ServiceAttachment, nil)
+	fake.Put(regional+"forwardingRules", cfg.ForwardingRule, nil)
+	fake.Put(regional+"backendServices", cfg.BackendService, nil)
+	fake.Put(zonal+"instanceGroups", cfg.InstanceGroup, nil)
+	fake.Put("global/healthChecks", cfg.HealthCheck, nil)
+	fake.Put(zonal+"instances", cfg.ProviderVM, nil)
+	fake.Put(zonal+"instances", cfg.ConsumerVM, nil)
+	fake.Put("global/firewalls", cfg.ProviderVPC+"-allow-ssh", network(cfg.ProviderVPC))
+	fake.Put("global/firewalls", cfg.ConsumerVPC+"-allow-ssh", network(cfg.ConsumerVPC))
+	fake.Put("global/firewalls", "manually-added-rule", network(cfg.ConsumerVPC))
+	fake.Put("global/firewalls", "default-allow-ssh", network("default"))
+	fake.Put(regional+"subnetworks", cfg.ProviderSubnet, nil)
+	fake.Put(regional+"subnetworks", cfg.PSCNATSubnet, nil)
+	fake.Put(regional+"subnetworks", cfg.ConsumerSubnet, nil)
+	fake.Put("global/networks", cfg.ProviderVPC, nil)
+	fake.Put("global/networks", cfg.ConsumerVPC, nil)
+}
+
+func TestRun_DependencyOrder(t *testing.T) {
+	td, fake := newTestTeardown(t)
+	seed(fake, td.config)
+
+	results := td.Run(context.Background())
+
+	for _, r := range results {
+		if r.Outcome != Deleted {
+			t.Errorf("%s: outcome = %s (%v), want deleted", r.ID(), r.Outcome, r.Err)
+		}
+	}
+	if got := len(results); got != 17 {
+		t.Errorf("len(results) = %d, want 17", got)
+	}
+
+	if got := fake.Names("global/firewalls"); len(got) != 1 || got[0] != "default-allow-ssh" {
+		t.Errorf("remaining firewall rules = %v, want only the rule on the default network", got)
+	}
+
+	order := []string{
+		"forwardingRules", "addresses", "serviceAttachments", "forwardingRules", "backendServices",
+		"instanceGroups", "healthChecks", "instances", "instances",
+		"firewalls", "firewalls", "firewalls", "subnetworks", "subnetworks", "subnetworks", "networks", "networks",
+	}
+	var deletes []string
+	for _, req := range fake.Requests() {
+		if req.Method == http.MethodDelete {
+			deletes = append(deletes, req.Collection[strings.LastIndex(req.Collection, "/")+1:])
+		}
+	}
+	if len(deletes) != len(order) {
+		t.Fatalf("deletes = %v, want %v", deletes, order)
+	}
+	for i := range order {
+		if deletes[i] != order[i] {
+			t.Fatalf("delete %d = %s, want %s (all deletes: %v)", i, deletes[i], order[i], deletes)
+		}
+	}
+}
+
+func TestRun_KeepsExistingVPCs(t *testing.T) {
+	td, fake := newTestTeardown(t)
+	seed(fake, td.config)
+	td.config.UseExistingVPCs(td.config.ProviderVPC, "")
+
+	results := td.Run(context.Background())
+
+	for _, r := range results {
+		if r.Name == td.config.ProviderVPC || r.Name == td.config.ProviderSubnet || r.Name == td.config.PSCNATSubnet ||
+			strings.HasPrefix(r.Name, td.config.ProviderVPC+"-") {
+			t.Errorf("%s was deleted, want resources of the existing provider VPC kept", r.ID())
+		}
+	}
+	if got := fake.Names("global/networks"); len(got) != 1 || got[0] != td.config.ProviderVPC {
+		t.Errorf("remaining networks = %v, want only %s", got, td.config.ProviderVPC)
+	}
+	if got := fake.Names("global/firewalls"); len(got) != 2 {
+		t.Errorf("remaining firewall rules = %v, want the provider VPC rule and the default network rule", got)
+	}
+	if find(t, results, "networks", td.config.ConsumerVPC).Outcome != Deleted {
+		t.Errorf("consumer VPC not deleted")
+	}
+}
+
+func TestRun_ConsumerOnly(t *testing.T) {
+	td, fake := newTestTeardown(t)
+	seed(fake, td.config)
+	td.ConsumerOnly = true
+
+	results := td.Run(context.Background())
+
+	for _, r := range results {
+		if r.Outcome != Deleted {
+			t.Errorf("%s: outcome = %s (%v), want deleted", r.ID(), r.Outcome, r.Err)
+		}
+	}
+	if got := len(results); got != 7 {
+		t.Errorf("len(results) = %d, want 7 consumer resources", got)
+	}
+	if got := fake.Names("global/networks"); len(got) != 1 || got[0] != td.config.ProviderVPC {
+		t.Errorf("remaining networks = %v, want only %s", got, td.config.ProviderVPC)
+	}
+	if got := fake.Names("regions/" + td.config.Region + "/serviceAttachments"); len(got) != 1 {
+		t.Errorf("remaining service attachments = %v, want the provider's kept", got)
+	}
+}
+
+func TestRun_RegionOnly(t *testing.T) {
+	td, fake := newTestTeardown(t)
+	primary := td.config
+	primary.SecondaryRegion, primary.SecondaryZone = "us-east1", "us-east1-b"
+	secondary, err := primary.Secondary()
+	if err != nil {
+		t.Fatalf("Secondary() error = %v", err)
+	}
+	seed(fake, primary)
+	seed(fake, secondary)
+	td.config = secondary
+	td.RegionOnly = true
+
+	results := td.Run(context.Background())
+
+	for _, r := range results {
+		if r.Outcome != Deleted {
+			t.Errorf("%s: outcome = %s (%v), want deleted", r.ID(), r.Outcome, r.Err)
+		}
+	}
+	if got := len(results); got != 11 {
+		t.Errorf("len(results) = %d, want 11 regional resources", got)
+	}
+	if got := fake.Names("zones/us-east1-b/instances"); len(got) != 1 || got[0] != primary.ConsumerVM {
+		t.Errorf("remaining secondary zone instances = %v, want only the consumer VM the seed put there", got)
+	}
+	if got := fake.Names("global/networks"); len(got) != 2 {
+		t.Errorf("remaining networks = %v, want the shared VPCs kept", got)
+	}
+	if got := fake.Names("regions/" + primary.Region + "/serviceAttachments"); len(got) != 1 {
+		t.Errorf("remaining primary service attachments = %v, want the primary region kept", got)
+	}
+}
+
+func TestRun_VPNBackend(t *testing.T) {
+	td, fake := newTestTeardown(t)
+	cfg := td.config
+	cfg.ConnectivityBackend = config.BackendVPN
+	seed(fake, cfg)
+	regional := "regions/" + cfg.Region + "/"
+	for _, gateway := range []string{cfg.ProviderVPNGateway, cfg.ConsumerVPNGateway} {
+		fake.Put(regional+"vpnGateways", gateway, nil)
+		for _, tunnel := range cfg.VPNTunnels(gateway) {
+			fake.Put(regional+"vpnTunnels", tunnel, nil)
+		}
+	}
+	fake.Put(regional+"routers", cfg.ProviderRouter, nil)
+	fake.Put(regional+"routers", cfg.ConsumerRouter, nil)
+
+	results := td.Run(context.Background())
+
+	for _, r := range results {
+		if r.Outcome != Deleted {
+			t.Errorf("%s: outcome = %s (%v), want deleted", r.ID(), r.Outcome, r.Err)
+		}
+	}
+	if got := len(results); got != 25 {
+		t.Errorf("len(results) = %d, want 25", got)
+	}
+
+	// Tunnels go before the gateways and routers they use, and those before the VPCs
+	var deletes []string
+	for _, req := range fake.Requests() {
+		if req.Method == http.MethodDelete {
+			deletes = append(deletes, req.Collection[strings.LastIndex(req.Collection, "/")+1:])
+		}
+	}
+	first := func(kind string) int {
+		for i, d := range deletes {
+			if d == kind {
+				return i
+			}
+		}
+		return -1
+	}
+	if !(first("vpnTunnels") < first("vpnGateways") && first("vpnGateways") < first("routers") && first("routers") < first("firewalls")) {
+		t.Errorf("deletes = %v, want tunnels, gateways and routers before the firewall rules", deletes)
+	}
+}
+
+func TestRun_PeeringBackend(t *testing.T) {
+	td, fake := newTestTeardown(t)
+	cfg := td.config
+	cfg.ConnectivityBackend = config.BackendPeering
+	seed(fake, cfg)
+	// Only the provider side of the peering is left
+	fake.Put("global/networks", cfg.ProviderVPC, map[string]any{
+		"peerings": []any{map[string]any{"name": cfg.ConsumerVPC, "state": "INACTIVE"}},
+	})
+
+	results := td.Run(context.Background())
+
+	if r := find(t, results, "network-peerings", cfg.ProviderVPC+"/"+cfg.ConsumerVPC); r.Outcome != Deleted {
+		t.Errorf("provider peering outcome = %s (%v), want deleted", r.Outcome, r.Err)
+	}
+	if r := find(t, results, "network-peerings", cfg.ConsumerVPC+"/"+cfg.ProviderVPC); r.Outcome != NotFound {
+		t.Errorf("consumer peering outcome = %s (%v), want not found", r.Outcome, r.Err)
+	}
+	if got := fake.Count(http.MethodPost, "networks", "removePeering"); got != 1 {
+		t.Errorf("removePeering calls = %d, want 1", got)
+	}
+	if got := fake.Names("global/networks"); len(got) != 0 {
+		t.Errorf("remaining networks = %v, want none", got)
+	}
+}
+
+func TestRun_NothingToDelete(t *testing.T) {
+	td, _ := newTestTeardown(t)
+
+	for _, r := range td.Run(context.Background()) {
+		if r.Outcome != NotFound {
+			t.Errorf("%s: outcome = %s (%v), want not found", r.ID(), r.Outcome, r.Err)
+		}
+	}
+}
+
+func TestRun_RetriesResourceInUse(t *testing.T) {
+	td, fake := newTestTeardown(t)
+	seed(fake, td.config)
+	fake.Fail(http.MethodDelete, "subnetworks", http.StatusBadRequest, "resourceInUseByAnotherResource", 2)
+
+	results := td.Run(context.Background())
+
+	r := find(t, results, "subnets", td.config.ProviderSubnet)
+	if r.Outcome != Deleted || r.Attempts != 3 {
+		t.Errorf("provider subnet = %s after %d attempts (%v), want deleted after 3", r.Outcome, r.Attempts, r.Err)
+	}
+}
+
+func TestRun_GivesUpAndSkipsRetriesDownstream(t *testing.T) {
+	td, fake := newTestTeardown(t)
+	td.RetryTimeout = 20 * time.Millisecond
+	seed(fake, td.config)
+	fake.Fail(http.MethodDelete, "serviceAttachments", http.StatusBadRequest, "resourceInUseByAnotherResource", 1000)
+	fake.Fail(http.MethodDelete, "networks", http.StatusBadRequest, "resourceInUseByAnotherResource", 1000)
+
+	results := td.Run(context.Background())
+
+	sa := find(t, results, "service-attachments", td.config.ServiceAttachment)
+	if sa.Outcome != Failed || sa.Attempts < 2 || !IsResourceInUse(sa.Err) {
+		t.Errorf("service attachment = %s after %d attempts (%v), want failed after retrying", sa.Outcome, sa.Attempts, sa.Err)
+	}
+
+	network := find(t, results, "networks", td.config.ProviderVPC)
+	if network.Outcome != Failed || network.Attempts != 1 {
+		t.Errorf("provider VPC = %s after %d attempts, want failed after a single attempt", network.Outcome, network.Attempts)
+	}
+
+	if vm := find(t, results, "instances", td.config.ProviderVM); vm.Outcome != Deleted {
+		t.Errorf("provider VM = %s (%v), want deleted despite the earlier failure", vm.Outcome, vm.Err)
+	}
+}
+
+func TestRun_OtherErrorsAreNotRetried(t *testing.T) {
+	td, fake := newTestTeardown(t)
+	seed(fake, td.config)
+	fake.Fail(http.MethodDelete, "backendServices", http.StatusForbidden, "forbidden", 1)
+
+	r := find(t, td.Run(context.Background()), "backend-services", td.config.BackendService)
+	if r.Outcome != Failed || r.Attempts != 1 {
+		t.Errorf("backend service = %s after %d attempts, want failed after 1", r.Outcome, r.Attempts)
+	}
+}
+
+func find(t *testing.T, results []Result, kind, name string) Result {
+	t.Helper()
+	for _, r := range results {
+		if r.Kind == kind && r.Name == name {
+			return r
+		}
+	}
+	t.Fatalf("no result for %s/%s", kind, name)
+	return Result{}
+}
@@ -0,0 +1,184 @@
+package testing
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"gcp-psc-demo/pkg/apiserver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// tunnelTimeout bounds how long the SSH port forward takes to come up
+	tunnelTimeout = 60 * time.Second
+	// grpcTimeout bounds each gRPC call through the tunnel
+	grpcTimeout = 30 * time.Second
+	// grpcTicks is how many messages the server-streaming test asks for
+	grpcTicks = 5
+)
+
+// testPSCHTTP2 checks from the consumer VM that HTTP/2 is negotiated with the
+// API server through the endpoint. The passthrough load balancer leaves TLS,
+// and with it ALPN, to the backend, so anything but HTTP/2 means something on
+// the path terminates the connection.
+func (tm *TestManager) testPSCHTTP2(serviceIP string) error {
+	fmt.Printf("Test 10: HTTP/2 negotiation with the API server\n")
+
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("curl -sk --http2 -o /dev/null --connect-timeout 15 --max-time 30 -w '%%{http_version}' https://%s:%d/version", serviceIP, tm.config.ServicePort))
+
+	output, err := cmd.Output()
+	version := strings.TrimSpace(string(output))
+	switch {
+	case err != nil:
+		fmt.Printf("HTTP/2 test failed: %v\n", err)
+		tm.record("psc-http2", start, err.Error())
+	case version != "2":
+		fmt.Printf("HTTP/2 not negotiated, the request used HTTP/%s\n", version)
+		tm.record("psc-http2", start, fmt.Sprintf("negotiated HTTP/%s instead of HTTP/2", version))
+	default:
+		fmt.Printf("HTTP/2 negotiated through the endpoint\n")
+		tm.record("psc-http2", start, "")
+	}
+	fmt.Println()
+	return nil
+}
+
+// testPSCGRPC calls the API server's gRPC echo service through an SSH port
+// forward from the consumer VM, so the connection enters the endpoint from
+// the consumer VPC the way konnectivity agents and aggregated API clients do.
+// It checks a unary call, a server stream and a bidirectional stream.
+func (tm *TestManager) testPSCGRPC(ctx context.Context, serviceIP string) error {
+	fmt.Printf("Test 11: gRPC calls and streams through the endpoint\n")
+
+	tests := []string{"psc-grpc-unary", "psc-grpc-server-stream", "psc-grpc-bidi-stream"}
+	start := time.Now()
+	addr, stop, err := tm.forwardPort(ctx, tm.config.ConsumerVM, serviceIP, tm.config.ServicePort)
+	if err != nil {
+		fmt.Printf("gRPC tests skipped: %v\n", err)
+		for _, test := range tests {
+			tm.record(test, start, err.Error())
+		}
+		fmt.Println()
+		return nil
+	}
+	defer stop()
+	fmt.Printf("Forwarding %s to %s:%d through %s\n", addr, serviceIP, tm.config.ServicePort, tm.config.ConsumerVM)
+
+	// The emulator serves a self-signed certificate, like curl -k elsewhere
+	conn, err := grpc.NewClient("passthrough:///"+addr,
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})))
+	if err != nil {
+		return fmt.Errorf("failed to create gRPC client: %v", err)
+	}
+	defer conn.Close()
+	client := apiserver.NewEchoClient(conn)
+
+	start = time.Now()
+	callCtx, cancel := context.WithTimeout(ctx, grpcTimeout)
+	reply, err := client.Echo(callCtx, "hello through "+serviceIP)
+	cancel()
+	switch {
+	case err != nil:
+		fmt.Printf("gRPC unary call failed: %v\n", err)
+		tm.record(tests[0], start, err.Error())
+	case reply != "hello through "+serviceIP:
+		fmt.Printf("gRPC unary call returned %q\n", reply)
+		tm.record(tests[0], start, fmt.Sprintf("unexpected reply %q", reply))
+	default:
+		fmt.Printf("gRPC unary call successful: %q\n", reply)
+		tm.record(tests[0], start, "")
+	}
+
+	// A proxy buffering the response would deliver the ticks all at once
+	start = time.Now()
+	callCtx, cancel = context.WithTimeout(ctx, grpcTimeout)
+	ticks, spread, err := client.Ticks(callCtx, grpcTicks)
+	cancel()
+	minSpread := (grpcTicks - 1) * apiserver.TickInterval / 2
+	switch {
+	case err != nil:
+		fmt.Printf("gRPC server stream failed after %d messages: %v\n", len(ticks), err)
+		tm.record(tests[1], start, err.Error())
+	case len(ticks) != grpcTicks:
+		fmt.Printf("gRPC server stream returned %d of %d messages\n", len(ticks), grpcTicks)
+		tm.record(tests[1], start, fmt.Sprintf("received %d of %d messages", len(ticks), grpcTicks))
+	case spread < minSpread:
+		fmt.Printf("gRPC server stream messages arrived together within %v, the response was buffered\n", spread)
+		tm.record(tests[1], start, fmt.Sprintf("messages arrived within %v, want them spread over at least %v", spread, minSpread))
+	default:
+		fmt.Printf("gRPC server stream successful: %d messages over %v\n", len(ticks), spread.Round(time.Millisecond))
+		tm.record(tests[1], start, "")
+	}
+
+	// Each reply is awaited before the next message is sent, which only
+	// works while both directions of the stream are open at once
+	start = time.Now()
+	messages := []string{"konnectivity", "aggregation", "watch"}
+	want := []string{"KONNECTIVITY", "AGGREGATION", "WATCH"}
+	callCtx, cancel = context.WithTimeout(ctx, grpcTimeout)
+	replies, err := client.Chat(callCtx, messages)
+	cancel()
+	switch {
+	case err != nil:
+		fmt.Printf("gRPC bidirectional stream failed after %d replies: %v\n", len(replies), err)
+		tm.record(tests[2], start, err.Error())
+	case !slices.Equal(replies, want):
+		fmt.Printf("gRPC bidirectional stream returned %v\n", replies)
+		tm.record(tests[2], start, fmt.Sprintf("unexpected replies %v", replies))
+	default:
+		fmt.Printf("gRPC bidirectional stream successful: %d round trips\n", len(replies))
+		tm.record(tests[2], start, "")
+	}
+	fmt.Println()
+	return nil
+}
+
+// forwardPort forwards a free local port to host:port through vmName with
+// gcloud compute ssh. It returns the local address and a function stopping
+// the forward.
+func (tm *TestManager) forwardPort(ctx context.Context, vmName, host string, port int) (string, func(), error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find a free local port: %v", err)
+	}
+	addr := listener.Addr().String()
+	localPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	cmd := exec.CommandContext(ctx, "gcloud", tm.config.SSHArgs(vmName, tm.config.Zone, "--",
+		"-N", "-o", "ExitOnForwardFailure=yes", "-L", fmt.Sprintf("%d:%s:%d", localPort, host, port))...)
+	if err := cmd.Start(); err != nil {
+		return "", nil, fmt.Errorf("failed to start SSH port forward: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	stop := func() {
+		cmd.Process.Kill()
+		<-exited
+	}
+
+	deadline := time.Now().Add(tunnelTimeout)
+	for {
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			return addr, stop, nil
+		}
+		select {
+		case err := <-exited:
+			return "", nil, fmt.Errorf("SSH port forward through %s exited: %v", vmName, err)
+		case <-time.After(500 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return "", nil, fmt.Errorf("SSH port forward through %s not ready after %v", vmName, tunnelTimeout)
+		}
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+	"time"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
 	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/hybrid"
+	"gcp-psc-demo/pkg/results"
 	"github.com/fatih/color"
+	"google.golang.org/api/option"
 )
 
 // TestManager handles connectivity and isolation testing
@@ -18,23 +22,24 @@ type TestManager struct {
 	backendServiceClient    *compute.RegionBackendServicesClient
 	serviceAttachmentClient *compute.ServiceAttachmentsClient
 	config                  *config.Config
+	results                 []results.Result
 }
 
 // NewTestManager creates a new test manager
-func NewTestManager(cfg *config.Config) (*TestManager, error) {
+func NewTestManager(cfg *config.Config, opts ...option.ClientOption) (*TestManager, error) {
 	ctx := context.Background()
 
-	forwardingRuleClient, err := compute.NewForwardingRulesRESTClient(ctx)
+	forwardingRuleClient, err := compute.NewForwardingRulesRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create forwarding rules client: %v", err)
 	}
 
-	backendServiceClient, err := compute.NewRegionBackendServicesRESTClient(ctx)
+	backendServiceClient, err := compute.NewRegionBackendServicesRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create backend services client: %v", err)
 	}
 
-	serviceAttachmentClient, err := compute.NewServiceAttachmentsRESTClient(ctx)
+	serviceAttachmentClient, err := compute.NewServiceAttachmentsRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create service attachments client: %v", err)
 	}
@@ -54,6 +59,27 @@ func (tm *TestManager) Close() {
 	tm.serviceAttachmentClient.Close()
 }
 
+// Results returns the result of every test run so far, in order
+func (tm *TestManager) Results() []results.Result {
+	return tm.results
+}
+
+// record adds the result of a test started at start. failure says why it
+// failed, empty when it passed.
+func (tm *TestManager) record(test string, start time.Time, failure string) {
+	result := results.Result{
+		Test:      test,
+		Outcome:   results.Passed,
+		StartedAt: start,
+		Latency:   time.Since(start),
+	}
+	if failure != "" {
+		result.Outcome = results.Failed
+		result.Error = failure
+	}
+	tm.results = append(tm.results, result)
+}
+
 // TestIsolation tests that VPCs are isolated before PSC setup
 func (tm *TestManager) TestIsolation(ctx context.Context) error {
 	color.Blue("=== Testing VPC Isolation (Before PSC) ===")
@@ -142,14 +168,15 @@ func (tm *TestManager) TestIsolation(ctx context.Context) error {
 	return nil
 }
 
-// TestConnectivity tests PSC connectivity
+// TestConnectivity tests PSC connectivity. With the peering and VPN
+// backends there is no PSC endpoint, the same tests then run against the
+// load balancer the consumer reaches directly.
 func (tm *TestManager) TestConnectivity(ctx context.Context) error {
-	color.Blue("=== Testing Private Service Connect Connectivity ===")
-
-	// Get PSC endpoint IP
-	pscIP, err := tm.getPSCEndpointIP(ctx)
-	if err != nil {
-		return err
+	usePSC := tm.config.ConnectivityBackend == config.BackendPSC
+	if usePSC {
+		color.Blue("=== Testing Private Service Connect Connectivity ===")
+	} else {
+		color.Blue("=== Testing %s Connectivity ===", tm.config.ConnectivityBackend)
 	}
 
 	// Get internal load balancer IP for diagnostic purposes
@@ -158,6 +185,14 @@ func (tm *TestManager) TestConnectivity(ctx context.Context) error {
 		return err
 	}
 
+	// Get PSC endpoint IP
+	pscIP := lbIP
+	if usePSC {
+		if pscIP, err = tm.getPSCEndpointIP(ctx); err != nil {
+			return err
+		}
+	}
+
 	fmt.Printf("PSC Endpoint IP: %s\n", pscIP)
 
 	color.Blue("=== DIAGNOSTIC TESTS ===")
@@ -166,14 +201,24 @@ func (tm *TestManager) TestConnectivity(ctx context.Context) error {
 	fmt.Println()
 
 	color.Blue("=== BACKEND HEALTH CHECK ===")
+	start := time.Now()
 	if err := tm.checkBackendHealth(ctx); err != nil {
+		tm.record("backend-health", start, err.Error())
 		color.Red("⚠ Backend health check failed: %v", err)
-	}
-
-	fmt.Println()
-	color.Blue("=== PSC INFRASTRUCTURE STATUS ===")
-	if err := tm.checkPSCInfrastructure(ctx); err != nil {
-		color.Red("⚠ PSC infrastructure check failed: %v", err)
+	} else {
+		tm.record("backend-health", start, "")
+	}
+
+	if usePSC {
+		fmt.Println()
+		color.Blue("=== PSC INFRASTRUCTURE STATUS ===")
+		start = time.Now()
+		if err := tm.checkPSCInfrastructure(ctx); err != nil {
+			tm.record("psc-infrastructure", start, err.Error())
+			color.Red("⚠ PSC infrastructure check failed: %v", err)
+		} else {
+			tm.record("psc-infrastructure", start, "")
+		}
 	}
 
 	fmt.Println()
@@ -189,9 +234,12 @@ func (tm *TestManager) TestConnectivity(ctx context.Context) error {
 		return err
 	}
 
-	// Test 3: Direct load balancer connectivity (should fail)
-	if err := tm.testDirectLBConnectivity(lbIP); err != nil {
-		return err
+	// Test 3: Direct load balancer connectivity (should fail), which is how
+	// the other backends connect
+	if usePSC {
+		if err := tm.testDirectLBConnectivity(lbIP); err != nil {
+			return err
+		}
 	}
 
 	// Test 4: PSC HTTP connectivity with verbose output
@@ -233,7 +281,21 @@ func (tm *TestManager) TestConnectivity(ctx context.Context) error {
 		return err
 	}
 
+	color.Blue("=== HTTP/2 AND gRPC STREAMING ===")
+	if err := tm.testPSCHTTP2(pscIP); err != nil {
+		return err
+	}
+
+	if err := tm.testPSCGRPC(ctx, pscIP); err != nil {
+		return err
+	}
+
 	color.Blue("=== TEST SUMMARY ===")
+	if !usePSC {
+		fmt.Printf("Load balancer reached over %s: %s\n", tm.config.ConnectivityBackend, lbIP)
+		color.Green("✓ %s connectivity tests completed, see `make compare` for how it compares to PSC", tm.config.ConnectivityBackend)
+		return nil
+	}
 	fmt.Printf("Private Service Connect endpoint: %s\n", pscIP)
 	fmt.Println("All tests completed. Check the output above for any failures.")
 	fmt.Println()
@@ -242,6 +304,7 @@ func (tm *TestManager) TestConnectivity(ctx context.Context) error {
 	fmt.Println("✓ Service isolation (no direct VPC peering required)")
 	fmt.Println("✓ Load balancing and health checking")
 	fmt.Println("✓ Service discovery through PSC endpoint")
+	fmt.Println("✓ HTTP/2 and gRPC streams through the endpoint and passthrough load balancer")
 
 	color.Green("✓ Private Service Connect connectivity tests completed successfully!")
 	return nil
@@ -253,15 +316,16 @@ func (tm *TestManager) TestConnectivity(ctx context.Context) error {
 func (tm *TestManager) testPingIsolation(providerIP string) error {
 	fmt.Println("Test 1: Attempting to ping provider VM from consumer VM (should FAIL)")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("ping -c 3 -W 5 %s", providerIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("ping -c 3 -W 5 %s", providerIP))
 
 	_, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("✅ EXPECTED: Ping failed - VPCs are isolated\n")
+		tm.record("ping-isolation", start, "")
 	} else {
 		fmt.Printf("❌ UNEXPECTED: Ping succeeded!\n")
+		tm.record("ping-isolation", start, "ping succeeded across VPCs")
 	}
 	fmt.Println()
 	return nil
@@ -271,15 +335,16 @@ func (tm *TestManager) testPingIsolation(providerIP string) error {
 func (tm *TestManager) testHTTPIsolation(providerIP string) error {
 	fmt.Println("Test 2: Attempting to connect to HTTP service (should FAIL)")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("curl --connect-timeout 10 http://%s/", providerIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("curl --connect-timeout 10 http://%s/", providerIP))
 
 	_, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("✅ EXPECTED: HTTP connection failed - no network route\n")
+		tm.record("http-isolation", start, "")
 	} else {
 		fmt.Printf("❌ UNEXPECTED: HTTP connection succeeded!\n")
+		tm.record("http-isolation", start, "HTTP connection succeeded across VPCs")
 	}
 	fmt.Println()
 	return nil
@@ -287,17 +352,18 @@ func (tm *TestManager) testHTTPIsolation(providerIP string) error {
 
 // testAPIIsolation tests API connectivity between VPCs (should fail)
 func (tm *TestManager) testAPIIsolation(providerIP string) error {
-	fmt.Println("Test 3: Attempting to connect to API service on port 8080 (should FAIL)")
+	fmt.Printf("Test 3: Attempting to connect to the API server on port %d (should FAIL)\n", tm.config.ServicePort)
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("curl --connect-timeout 10 http://%s:8080/", providerIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("curl -k --connect-timeout 10 https://%s:%d/healthz", providerIP, tm.config.ServicePort))
 
 	_, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("✅ EXPECTED: API connection failed - no network route\n")
+		tm.record("api-isolation", start, "")
 	} else {
 		fmt.Printf("❌ UNEXPECTED: API connection succeeded!\n")
+		tm.record("api-isolation", start, "API connection succeeded across VPCs")
 	}
 	fmt.Println()
 	return nil
@@ -307,15 +373,16 @@ func (tm *TestManager) testAPIIsolation(providerIP string) error {
 func (tm *TestManager) testNetcatIsolation(providerIP string) error {
 	fmt.Println("Test 4: Testing netcat connectivity (should FAIL)")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("timeout 10 nc -zv %s 80", providerIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("timeout 10 nc -zv %s 80", providerIP))
 
 	_, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("✅ EXPECTED: Netcat failed - port unreachable\n")
+		tm.record("netcat-isolation", start, "")
 	} else {
 		fmt.Printf("❌ UNEXPECTED: Netcat succeeded!\n")
+		tm.record("netcat-isolation", start, "netcat connection succeeded across VPCs")
 	}
 	fmt.Println()
 	return nil
@@ -325,9 +392,8 @@ func (tm *TestManager) testNetcatIsolation(providerIP string) error {
 func (tm *TestManager) testRoutingTable(providerIP string) error {
 	fmt.Println("Test 5: Checking routing table from consumer VM")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf(`
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf(`
 echo 'Consumer VM routing table:'
 ip route
 echo ''
@@ -338,8 +404,10 @@ ip route get %s || echo 'No route to provider VM (expected)'
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("⚠ Could not check routing table: %v\n", err)
+		tm.record("routing-table", start, err.Error())
 	} else {
 		fmt.Printf("%s\n", string(output))
+		tm.record("routing-table", start, "")
 	}
 	fmt.Println()
 	return nil
@@ -349,15 +417,16 @@ ip route get %s || echo 'No route to provider VM (expected)'
 func (tm *TestManager) testReverseConnectivity(consumerIP string) error {
 	fmt.Println("Test 6: Testing reverse connectivity (provider to consumer)")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ProviderVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("ping -c 3 -W 5 %s", consumerIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ProviderVM, fmt.Sprintf("ping -c 3 -W 5 %s", consumerIP))
 
 	_, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("✅ EXPECTED: Reverse ping failed - VPCs are isolated\n")
+		tm.record("reverse-isolation", start, "")
 	} else {
 		fmt.Printf("❌ UNEXPECTED: Reverse ping succeeded!\n")
+		tm.record("reverse-isolation", start, "reverse ping succeeded across VPCs")
 	}
 	fmt.Println()
 	return nil
@@ -367,18 +436,19 @@ func (tm *TestManager) testReverseConnectivity(consumerIP string) error {
 func (tm *TestManager) testProviderServiceLocal() error {
 	fmt.Println("Test 7: Verifying service is running on provider VM (should SUCCEED)")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ProviderVM,
-		"--zone", tm.config.Zone,
-		"--command", "curl -s http://localhost/")
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ProviderVM, "curl -s http://localhost/")
 
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("❌ Service not running on provider VM\n")
+		tm.record("provider-service-local", start, err.Error())
 	} else {
 		fmt.Printf("✅ Service is running locally on provider VM\n")
 		if len(output) > 0 {
 			fmt.Printf("Response: %s\n", strings.TrimSpace(string(output)))
 		}
+		tm.record("provider-service-local", start, "")
 	}
 	fmt.Println()
 	return nil
@@ -388,18 +458,19 @@ func (tm *TestManager) testProviderServiceLocal() error {
 func (tm *TestManager) testProviderAPILocal() error {
 	fmt.Println("Test 8: Verifying API is running on provider VM (should SUCCEED)")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ProviderVM,
-		"--zone", tm.config.Zone,
-		"--command", "curl -s http://localhost:8080/")
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ProviderVM, fmt.Sprintf("curl -sk https://localhost:%d/version", tm.config.ServicePort))
 
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("❌ API not running on provider VM\n")
+		tm.record("provider-api-local", start, err.Error())
 	} else {
 		fmt.Printf("✅ API is running locally on provider VM\n")
 		if len(output) > 0 {
 			fmt.Printf("Response: %s\n", strings.TrimSpace(string(output)))
 		}
+		tm.record("provider-api-local", start, "")
 	}
 	fmt.Println()
 	return nil
@@ -409,12 +480,10 @@ func (tm *TestManager) testProviderAPILocal() error {
 func (tm *TestManager) showProviderNetworkDetails(providerIP string) error {
 	fmt.Println("Provider VM Network Details:")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ProviderVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf(`
+	cmd := tm.sshCommand(tm.config.ProviderVM, fmt.Sprintf(`
 echo 'IP Address: %s'
 echo 'Network Interface:'
-ip addr show ens4 | grep inet
+ip -4 addr show scope global | grep inet
 echo 'Default Gateway:'
 ip route | grep default
 `, providerIP))
@@ -432,12 +501,10 @@ ip route | grep default
 func (tm *TestManager) showConsumerNetworkDetails(consumerIP string) error {
 	fmt.Println("Consumer VM Network Details:")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf(`
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf(`
 echo 'IP Address: %s'
 echo 'Network Interface:'
-ip addr show ens4 | grep inet
+ip -4 addr show scope global | grep inet
 echo 'Default Gateway:'
 ip route | grep default
 `, consumerIP))
@@ -488,8 +555,8 @@ func (tm *TestManager) getLoadBalancerIP(ctx context.Context) (string, error) {
 // checkBackendHealth checks the health of backend services
 func (tm *TestManager) checkBackendHealth(ctx context.Context) error {
 	// Instance group URL for health check
-	instanceGroupURL := fmt.Sprintf("projects/%s/zones/%s/instanceGroups/redhat-service-group",
-		tm.config.ProjectID, tm.config.Zone)
+	instanceGroupURL := fmt.Sprintf("projects/%s/zones/%s/instanceGroups/%s",
+		tm.config.ProjectID, tm.config.Zone, tm.config.InstanceGroup)
 
 	req := &computepb.GetHealthRegionBackendServiceRequest{
 		Project:        tm.config.ProjectID,
@@ -567,15 +634,16 @@ func (tm *TestManager) checkPSCInfrastructure(ctx context.Context) error {
 func (tm *TestManager) testPSCPing(pscIP string) error {
 	fmt.Printf("Test 1: Network reachability to PSC endpoint (ICMP test - expected to fail)\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("ping -c 3 -W 5 %s", pscIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("ping -c 3 -W 5 %s", pscIP))
 
 	_, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("PSC IP is not reachable via ICMP (expected - PSC endpoints do not respond to ping)\n")
+		tm.record("psc-icmp", start, "")
 	} else {
 		fmt.Printf("PSC IP is reachable via ICMP (unexpected)\n")
+		tm.record("psc-icmp", start, "PSC endpoint answered ICMP")
 	}
 	fmt.Println()
 	return nil
@@ -585,15 +653,16 @@ func (tm *TestManager) testPSCPing(pscIP string) error {
 func (tm *TestManager) testPSCPort(pscIP string) error {
 	fmt.Printf("Test 2: TCP port connectivity to PSC endpoint\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("timeout 10 nc -zv %s 8080", pscIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("timeout 10 nc -zv %s %d", pscIP, tm.config.ServicePort))
 
 	_, err := cmd.Output()
 	if err != nil {
-		fmt.Printf("PSC port 8080 is CLOSED or filtered\n")
+		fmt.Printf("PSC port %d is CLOSED or filtered\n", tm.config.ServicePort)
+		tm.record("psc-tcp-port", start, fmt.Sprintf("port %d closed or filtered: %v", tm.config.ServicePort, err))
 	} else {
-		fmt.Printf("PSC port 8080 is OPEN\n")
+		fmt.Printf("PSC port %d is OPEN\n", tm.config.ServicePort)
+		tm.record("psc-tcp-port", start, "")
 	}
 	fmt.Println()
 	return nil
@@ -603,33 +672,35 @@ func (tm *TestManager) testPSCPort(pscIP string) error {
 func (tm *TestManager) testDirectLBConnectivity(lbIP string) error {
 	fmt.Printf("Test 3: Direct Load Balancer connectivity (cross-VPC should fail)\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("timeout 5 nc -zv %s 8080", lbIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("timeout 5 nc -zv %s %d", lbIP, tm.config.ServicePort))
 
 	_, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("Direct LB not accessible (expected - different VPC)\n")
+		tm.record("direct-lb-isolation", start, "")
 	} else {
 		fmt.Printf("Direct LB accessible (unexpected!)\n")
+		tm.record("direct-lb-isolation", start, "load balancer reachable across VPCs")
 	}
 	fmt.Println()
 	return nil
 }
 
-// testPSCHTTPVerbose tests PSC HTTP connectivity with verbose output
+// testPSCHTTPVerbose tests PSC HTTPS connectivity to the API server with verbose output
 func (tm *TestManager) testPSCHTTPVerbose(pscIP string) error {
-	fmt.Printf("Test 4: PSC HTTP connectivity with verbose output\n")
+	fmt.Printf("Test 4: PSC HTTPS connectivity to the API server with verbose output\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("curl -v --connect-timeout 15 --max-time 30 http://%s:8080/", pscIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("curl -vk --connect-timeout 15 --max-time 30 https://%s:%d/version", pscIP, tm.config.ServicePort))
 
 	output, err := cmd.Output()
 	if err != nil {
-		fmt.Printf("PSC HTTP test failed: %v\n", err)
+		fmt.Printf("PSC HTTPS test failed: %v\n", err)
+		tm.record("psc-https-version", start, err.Error())
 	} else {
-		fmt.Printf("PSC HTTP test successful:\n%s\n", string(output))
+		fmt.Printf("PSC HTTPS test successful:\n%s\n", string(output))
+		tm.record("psc-https-version", start, "")
 	}
 	fmt.Println()
 	return nil
@@ -639,15 +710,16 @@ func (tm *TestManager) testPSCHTTPVerbose(pscIP string) error {
 func (tm *TestManager) testPSCHealth(pscIP string) error {
 	fmt.Printf("Test 5: PSC Health endpoint\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf("curl -s --connect-timeout 15 --max-time 30 http://%s:8080/health", pscIP))
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf("curl -sk --connect-timeout 15 --max-time 30 https://%s:%d/healthz", pscIP, tm.config.ServicePort))
 
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("PSC health check failed: %v\n", err)
+		tm.record("psc-https-healthz", start, err.Error())
 	} else {
 		fmt.Printf("PSC health check successful: %s\n", strings.TrimSpace(string(output)))
+		tm.record("psc-https-healthz", start, "")
 	}
 	fmt.Println()
 	return nil
@@ -657,9 +729,8 @@ func (tm *TestManager) testPSCHealth(pscIP string) error {
 func (tm *TestManager) testNetworkRouting(pscIP, lbIP string) error {
 	fmt.Printf("Test 6: Network routing analysis\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf(`
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf(`
 echo 'Route to PSC endpoint:'
 ip route get %s 2>/dev/null || echo 'No route to PSC endpoint found'
 echo ''
@@ -676,8 +747,10 @@ ip addr show | grep 'inet 10.2'
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("Network routing analysis failed: %v\n", err)
+		tm.record("psc-routing", start, err.Error())
 	} else {
 		fmt.Printf("%s\n", string(output))
+		tm.record("psc-routing", start, "")
 	}
 	return nil
 }
@@ -686,25 +759,26 @@ ip addr show | grep 'inet 10.2'
 func (tm *TestManager) testPSCEndpointSpecific(pscIP string) error {
 	fmt.Printf("Test 7: PSC Endpoint specific checks\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf(`
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf(`
 echo 'Testing PSC endpoint connectivity:'
 echo '- Telnet connection test:'
-timeout 5 telnet %s 8080 < /dev/null 2>&1 | head -5
+timeout 5 telnet %[1]s %[2]d < /dev/null 2>&1 | head -5
 echo ''
 echo '- Netcat port scan:'
-timeout 3 nc -w1 %s 8080 < /dev/null && echo 'Connection successful' || echo 'Connection failed'
+timeout 3 nc -w1 %[1]s %[2]d < /dev/null && echo 'Connection successful' || echo 'Connection failed'
 echo ''
-echo '- HTTP response test:'
-timeout 10 wget -qO- --timeout=5 http://%s:8080/ 2>&1 | head -3 || echo 'wget failed'
-`, pscIP, pscIP, pscIP))
+echo '- HTTPS response test:'
+timeout 10 wget -qO- --timeout=5 --no-check-certificate https://%[1]s:%[2]d/version 2>&1 | head -3 || echo 'wget failed'
+`, pscIP, tm.config.ServicePort))
 
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("PSC endpoint specific checks failed: %v\n", err)
+		tm.record("psc-endpoint-checks", start, err.Error())
 	} else {
 		fmt.Printf("%s\n", string(output))
+		tm.record("psc-endpoint-checks", start, "")
 	}
 	return nil
 }
@@ -713,27 +787,28 @@ timeout 10 wget -qO- --timeout=5 http://%s:8080/ 2>&1 | head -3 || echo 'wget fa
 func (tm *TestManager) checkProviderServiceStatus() error {
 	fmt.Printf("Provider VM service verification:\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ProviderVM,
-		"--zone", tm.config.Zone,
-		"--command", `
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ProviderVM, fmt.Sprintf(`
 echo 'Service status:'
-systemctl is-active demo-api || echo 'demo-api service not active'
+systemctl is-active psc-apiserver || echo 'psc-apiserver service not active'
 echo ''
 echo 'Service listening on ports:'
-ss -tlnp | grep :8080 || echo 'No service listening on port 8080'
+ss -tlnp | grep :%[1]d || echo 'No service listening on port %[1]d'
 echo ''
 echo 'Service logs (last 10 lines):'
-journalctl -u demo-api --no-pager -n 10 || echo 'No logs available'
+journalctl -u psc-apiserver --no-pager -n 10 || echo 'No logs available'
 echo ''
 echo 'Test local connectivity:'
-curl -s --connect-timeout 5 http://localhost:8080/health || echo 'Local health check failed'
-`)
+curl -sk --connect-timeout 5 https://localhost:%[1]d/healthz || echo 'Local health check failed'
+`, tm.config.ServicePort))
 
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("Provider service status check failed: %v\n", err)
+		tm.record("provider-service-status", start, err.Error())
 	} else {
 		fmt.Printf("%s\n", string(output))
+		tm.record("provider-service-status", start, "")
 	}
 	return nil
 }
@@ -742,21 +817,22 @@ curl -s --connect-timeout 5 http://localhost:8080/health || echo 'Local health c
 func (tm *TestManager) verifyLoadBalancer(lbIP string) error {
 	fmt.Printf("Testing direct access to Load Balancer from Provider VPC:\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ProviderVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf(`
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ProviderVM, fmt.Sprintf(`
 echo 'Testing Load Balancer from same VPC:'
-curl -s --connect-timeout 10 http://%s:8080/ || echo 'Load Balancer not accessible from provider VPC'
+curl -sk --connect-timeout 10 https://%[1]s:%[2]d/version || echo 'Load Balancer not accessible from provider VPC'
 echo ''
 echo 'Load Balancer health:'
-curl -s --connect-timeout 10 http://%s:8080/health || echo 'Load Balancer health check failed'
-`, lbIP, lbIP))
+curl -sk --connect-timeout 10 https://%[1]s:%[2]d/healthz || echo 'Load Balancer health check failed'
+`, lbIP, tm.config.ServicePort))
 
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("Load balancer verification failed: %v\n", err)
+		tm.record("provider-lb", start, err.Error())
 	} else {
 		fmt.Printf("%s\n", string(output))
+		tm.record("provider-lb", start, "")
 	}
 	return nil
 }
@@ -765,14 +841,13 @@ curl -s --connect-timeout 10 http://%s:8080/health || echo 'Load Balancer health
 func (tm *TestManager) testMultipleRequests(pscIP string) error {
 	fmt.Printf("Test 8: Multiple requests to verify consistent connectivity\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf(`
-if curl -s --connect-timeout 5 http://%s:8080/health >/dev/null 2>&1; then
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf(`
+if curl -sk --connect-timeout 5 https://%[1]s:%[2]d/healthz >/dev/null 2>&1; then
   echo 'PSC is responding, testing multiple requests:'
   for i in {1..3}; do
     echo "Request $i:"
-    if curl -s --connect-timeout 5 http://%s:8080/health; then
+    if curl -sk --connect-timeout 5 https://%[1]s:%[2]d/healthz; then
       echo ' - SUCCESS'
     else
       echo ' - FAILED'
@@ -782,13 +857,15 @@ if curl -s --connect-timeout 5 http://%s:8080/health >/dev/null 2>&1; then
 else
   echo 'PSC endpoint not responding, skipping multiple request test'
 fi
-`, pscIP, pscIP))
+`, pscIP, tm.config.ServicePort))
 
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("Multiple requests test failed: %v\n", err)
+		tm.record("psc-multiple-requests", start, err.Error())
 	} else {
 		fmt.Printf("%s\n", string(output))
+		tm.record("psc-multiple-requests", start, "")
 	}
 	return nil
 }
@@ -797,26 +874,107 @@ fi
 func (tm *TestManager) testServiceDiscovery(pscIP string) error {
 	fmt.Printf("Test 9: Service discovery and metadata (if PSC works)\n")
 
-	cmd := exec.Command("gcloud", "compute", "ssh", tm.config.ConsumerVM,
-		"--zone", tm.config.Zone,
-		"--command", fmt.Sprintf(`
-if curl -s --connect-timeout 5 http://%s:8080/health >/dev/null 2>&1; then
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf(`
+if curl -sk --connect-timeout 5 https://%[1]s:%[2]d/healthz >/dev/null 2>&1; then
   echo 'Testing service discovery:'
-  curl -s --connect-timeout 10 http://%s:8080/ | python3 -c 'import sys, json; data=json.load(sys.stdin); print(f"Service: {data.get(\"message\", \"N/A\")}"); print(f"Hostname: {data.get(\"hostname\", \"N/A\")}"); print(f"Timestamp: {data.get(\"timestamp\", \"N/A\")}")'
+  curl -sk --connect-timeout 10 https://%[1]s:%[2]d/version | python3 -c 'import sys, json; data=json.load(sys.stdin); print(f"API server version: {data.get(\"gitVersion\", \"N/A\")}"); print(f"Platform: {data.get(\"platform\", \"N/A\")}")'
 else
   echo 'PSC endpoint not responding, skipping service discovery test'
 fi
-`, pscIP, pscIP))
+`, pscIP, tm.config.ServicePort))
 
 	output, err := cmd.Output()
 	if err != nil {
 		fmt.Printf("Service discovery test failed: %v\n", err)
+		tm.record("psc-service-discovery", start, err.Error())
 	} else {
 		fmt.Printf("%s\n", string(output))
+		tm.record("psc-service-discovery", start, "")
 	}
 	return nil
 }
 
+// Compare measures the connectivity backend of the configuration for the
+// comparison log: the latency of requests from the consumer VM to the
+// service through the backend, and which of the isolation probes get
+// through. The caller fills in the setup time.
+func (tm *TestManager) Compare(ctx context.Context, requests int) (*hybrid.Record, error) {
+	color.Blue("=== Comparing connectivity backend %s ===", tm.config.ConnectivityBackend)
+
+	lbIP, err := tm.getLoadBalancerIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	serviceIP := lbIP
+	if tm.config.ConnectivityBackend == config.BackendPSC {
+		if serviceIP, err = tm.getPSCEndpointIP(ctx); err != nil {
+			return nil, err
+		}
+	}
+	providerIP, err := tm.getVMInternalIP(tm.config.ProviderVM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider VM IP: %v", err)
+	}
+	consumerIP, err := tm.getVMInternalIP(tm.config.ConsumerVM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consumer VM IP: %v", err)
+	}
+
+	record := hybrid.NewRecord(tm.config)
+	record.Requests = requests
+
+	// One line per request: its total time in seconds, or fail
+	fmt.Printf("Sending %d requests to https://%s:%d/healthz from the consumer VM\n", requests, serviceIP, tm.config.ServicePort)
+	start := time.Now()
+	cmd := tm.sshCommand(tm.config.ConsumerVM, fmt.Sprintf(`
+for i in $(seq 1 %[3]d); do
+  if t=$(curl -sk -o /dev/null --connect-timeout 5 --max-time 10 -w '%%{time_total}' https://%[1]s:%[2]d/healthz); then
+    echo "$t"
+  else
+    echo fail
+  fi
+done
+`, serviceIP, tm.config.ServicePort, requests))
+	output, err := cmd.Output()
+	if err != nil {
+		tm.record("compare-latency", start, err.Error())
+		return nil, fmt.Errorf("failed to run requests from the consumer VM: %v", err)
+	}
+	record.Samples, record.Failures = hybrid.ParseTimings(string(output))
+	failure := ""
+	if record.Failures > 0 {
+		failure = fmt.Sprintf("%d of %d requests failed", record.Failures, requests)
+	}
+	tm.record("compare-latency", start, failure)
+
+	probes := map[string]struct {
+		vm, ip string
+		port   int
+	}{
+		"lb-direct":    {tm.config.ConsumerVM, lbIP, tm.config.ServicePort},
+		"provider-vm":  {tm.config.ConsumerVM, providerIP, tm.config.ServicePort},
+		"provider-ssh": {tm.config.ConsumerVM, providerIP, 22},
+		"consumer-ssh": {tm.config.ProviderVM, consumerIP, 22},
+	}
+	for _, probe := range hybrid.Probes {
+		p := probes[probe.Name]
+		start := time.Now()
+		cmd := tm.sshCommand(p.vm, fmt.Sprintf(
+			"if timeout 5 nc -z -w3 %s %d; then echo reachable; else echo blocked; fi", p.ip, p.port))
+		output, err := cmd.Output()
+		if err != nil {
+			tm.record("compare-"+probe.Name, start, err.Error())
+			color.Yellow("⚠ Probe %s could not run: %v", probe.Name, err)
+			continue
+		}
+		record.Isolation[probe.Name] = strings.TrimSpace(string(output)) == "reachable"
+		tm.record("compare-"+probe.Name, start, "")
+	}
+
+	return record, nil
+}
+
 // getVMInternalIP gets the internal IP address of a VM
 func (tm *TestManager) getVMInternalIP(vmName string) (string, error) {
 	cmd := exec.Command("gcloud", "compute", "instances", "describe", vmName,
@@ -830,3 +988,8 @@ func (tm *TestManager) getVMInternalIP(vmName string) (string, error) {
 
 	return strings.TrimSpace(string(output)), nil
 }
+
+// sshCommand returns the gcloud command running command on a VM of the zone
+func (tm *TestManager) sshCommand(vmName, command string) *exec.Cmd {
+	return exec.Command("gcloud", tm.config.SSHArgs(vmName, tm.config.Zone, "--command", command)...)
+}
@@ -0,0 +1,641 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"gcp-psc-demo/pkg/config"
+	"github.com/fatih/color"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// Resource is a demo resource that still exists in the project
+type Resource struct {
+	// Kind is the gcloud resource type, e.g. "forwarding-rules"
+	Kind     string
+	Name     string
+	Location string
+	// Reason explains why the resource is likely still present, if known
+	Reason string
+
+	collection string
+	refs       []string
+	users      []string
+}
+
+// ID returns the resource in kind/name form as used in reports
+func (r Resource) ID() string {
+	return r.Kind + "/" + r.Name
+}
+
+// Verifier re-lists every resource type the demo creates and reports leftovers
+type Verifier struct {
+	networkClient           *compute.NetworksClient
+	subnetClient            *compute.SubnetworksClient
+	firewallClient          *compute.FirewallsClient
+	instancesClient         *compute.InstancesClient
+	instanceGroupClient     *compute.InstanceGroupsClient
+	backendServiceClient    *compute.RegionBackendServicesClient
+	healthCheckClient       *compute.HealthChecksClient
+	forwardingRuleClient    *compute.ForwardingRulesClient
+	serviceAttachmentClient *compute.ServiceAttachmentsClient
+	addressClient           *compute.AddressesClient
+	vpnTunnelClient         *compute.VpnTunnelsClient
+	vpnGatewayClient        *compute.VpnGatewaysClient
+	routerClient            *compute.RoutersClient
+	config                  *config.Config
+}
+
+// NewVerifier creates a new verifier
+func NewVerifier(cfg *config.Config, opts ...option.ClientOption) (*Verifier, error) {
+	ctx := context.Background()
+	v := &Verifier{config: cfg}
+
+	var err error
+	if v.networkClient, err = compute.NewNetworksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create networks client: %v", err)
+	}
+	if v.subnetClient, err = compute.NewSubnetworksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create subnetworks client: %v", err)
+	}
+	if v.firewallClient, err = compute.NewFirewallsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create firewalls client: %v", err)
+	}
+	if v.instancesClient, err = compute.NewInstancesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create instances client: %v", err)
+	}
+	if v.instanceGroupClient, err = compute.NewInstanceGroupsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create instance groups client: %v", err)
+	}
+	if v.backendServiceClient, err = compute.NewRegionBackendServicesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create backend services client: %v", err)
+	}
+	if v.healthCheckClient, err = compute.NewHealthChecksRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create health checks client: %v", err)
+	}
+	if v.forwardingRuleClient, err = compute.NewForwardingRulesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create forwarding rules client: %v", err)
+	}
+	if v.serviceAttachmentClient, err = compute.NewServiceAttachmentsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create service attachments client: %v", err)
+	}
+	if v.addressClient, err = compute.NewAddressesRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create addresses client: %v", err)
+	}
+	if v.vpnTunnelClient, err = compute.NewVpnTunnelsRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN tunnels client: %v", err)
+	}
+	if v.vpnGatewayClient, err = compute.NewVpnGatewaysRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create VPN gateways client: %v", err)
+	}
+	if v.routerClient, err = compute.NewRoutersRESTClient(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create routers client: %v", err)
+	}
+
+	return v, nil
+}
+
+// Close closes all clients
+func (v *Verifier) Close() {
+	for _, c := range []interface{ Close() error }{
+		v.networkClient,
+		v.subnetClient,
+		v.firewallClient,
+		v.instancesClient,
+		v.instanceGroupClient,
+		v.backendServiceClient,
+		v.healthCheckClient,
+		v.forwardingRuleClient,
+		v.serviceAttachmentClient,
+		v.addressClient,
+		v.vpnTunnelClient,
+		v.vpnGatewayClient,
+		v.routerClient,
+	} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// Sweep lists every resource type the demo creates and returns the ones that
+// belong to this run, matched by name or by the run label. Each leftover is
+// annotated with the other leftovers that reference it, since those are what
+// usually block its deletion.
+func (v *Verifier) Sweep(ctx context.Context) ([]Resource, error) {
+	listers := []func(context.Context) ([]Resource, error){
+		v.listForwardingRules,
+		v.listAddresses,
+		v.listServiceAttachments,
+		v.listBackendServices,
+		v.listInstanceGroups,
+		v.listHealthChecks,
+		v.listInstances,
+	}
+	// Only runs of the VPN backend create VPN resources, so other runs need
+	// neither the calls nor the permissions
+	if v.config.ConnectivityBackend == config.BackendVPN {
+		listers = append(listers, v.listVPNTunnels, v.listVPNGateways, v.listRouters)
+	}
+	listers = append(listers,
+		v.listFirewalls,
+		v.listSubnets,
+		v.listNetworks,
+	)
+
+	var leftovers []Resource
+	for _, list := range listers {
+		found, err := list(ctx)
+		if err != nil {
+			return nil, err
+		}
+		leftovers = append(leftovers, found...)
+	}
+
+	annotateBlockers(leftovers)
+	return leftovers, nil
+}
+
+// PrintReport prints leftovers as a table
+func PrintReport(leftovers []Resource) {
+	if len(leftovers) == 0 {
+		color.Green("✓ No demo resources left in the project")
+		return
+	}
+
+	color.Red("✗ %d demo resource(s) still present:", len(leftovers))
+	fmt.Printf("  %-20s %-40s %-15s %s\n", "TYPE", "NAME", "LOCATION", "REASON")
+	for _, r := range leftovers {
+		fmt.Printf("  %-20s %-40s %-15s %s\n", r.Kind, r.Name, r.Location, r.Reason)
+	}
+}
+
+// annotateBlockers fills Reason with the leftovers that still reference each resource
+func annotateBlockers(leftovers []Resource) {
+	byKey := make(map[string]*Resource, len(leftovers))
+	for i := range leftovers {
+		byKey[leftovers[i].collection+"/"+leftovers[i].Name] = &leftovers[i]
+	}
+
+	blockers := make(map[*Resource][]string)
+	for i := range leftovers {
+		r := &leftovers[i]
+		for _, ref := range r.refs {
+			if target, ok := byKey[resourceKey(ref)]; ok && target != r {
+				blockers[target] = append(blockers[target], r.ID())
+			}
+		}
+	}
+
+	for i := range leftovers {
+		r := &leftovers[i]
+		var reasons []string
+		if b := blockers[r]; len(b) > 0 {
+			sort.Strings(b)
+			reasons = append(reasons, "in use by "+strings.Join(b, ", "))
+		}
+		if len(r.users) > 0 {
+			users := make([]string, 0, len(r.users))
+			for _, u := range r.users {
+				users = append(users, lastSegment(u))
+			}
+			reasons = append(reasons, "used by "+strings.Join(users, ", "))
+		}
+		if r.Reason != "" {
+			reasons = append(reasons, r.Reason)
+		}
+		r.Reason = strings.Join(reasons, "; ")
+	}
+}
+
+func (v *Verifier) listForwardingRules(ctx context.Context) ([]Resource, error) {
+	it := v.forwardingRuleClient.List(ctx, &computepb.ListForwardingRulesRequest{
+		Project: v.config.ProjectID,
+		Region:  v.config.Region,
+	})
+
+	var found []Resource
+	for {
+		rule, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list forwarding rules: %v", err)
+		}
+		if !v.owns(rule.GetName(), rule.GetLabels()) {
+			continue
+		}
+		found = append(found, Resource{
+			Kind:       "forwarding-rules",
+			Name:       rule.GetName(),
+			Location:   v.config.Region,
+			collection: "forwardingRules",
+			refs:       nonEmpty(rule.GetBackendService(), rule.GetTarget(), rule.GetNetwork(), rule.GetSubnetwork()),
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listAddresses(ctx context.Context) ([]Resource, error) {
+	it := v.addressClient.List(ctx, &computepb.ListAddressesRequest{
+		Project: v.config.ProjectID,
+		Region:  v.config.Region,
+	})
+
+	var found []Resource
+	for {
+		address, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses: %v", err)
+		}
+		if !v.owns(address.GetName(), address.GetLabels()) {
+			continue
+		}
+		found = append(found, Resource{
+			Kind:       "addresses",
+			Name:       address.GetName(),
+			Location:   v.config.Region,
+			collection: "addresses",
+			refs:       nonEmpty(address.GetSubnetwork(), address.GetNetwork()),
+			users:      address.GetUsers(),
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listServiceAttachments(ctx context.Context) ([]Resource, error) {
+	it := v.serviceAttachmentClient.List(ctx, &computepb.ListServiceAttachmentsRequest{
+		Project: v.config.ProjectID,
+		Region:  v.config.Region,
+	})
+
+	var found []Resource
+	for {
+		attachment, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list service attachments: %v", err)
+		}
+		if !v.owns(attachment.GetName(), nil) {
+			continue
+		}
+
+		r := Resource{
+			Kind:       "service-attachments",
+			Name:       attachment.GetName(),
+			Location:   v.config.Region,
+			collection: "serviceAttachments",
+			refs:       append(nonEmpty(attachment.GetTargetService()), attachment.GetNatSubnets()...),
+		}
+		if n := len(attachment.GetConnectedEndpoints()); n > 0 {
+			r.Reason = fmt.Sprintf("%d PSC endpoint(s) still connected", n)
+		}
+		found = append(found, r)
+	}
+	return found, nil
+}
+
+func (v *Verifier) listBackendServices(ctx context.Context) ([]Resource, error) {
+	it := v.backendServiceClient.List(ctx, &computepb.ListRegionBackendServicesRequest{
+		Project: v.config.ProjectID,
+		Region:  v.config.Region,
+	})
+
+	var found []Resource
+	for {
+		service, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backend services: %v", err)
+		}
+		if !v.owns(service.GetName(), nil) {
+			continue
+		}
+
+		refs := append([]string{}, service.GetHealthChecks()...)
+		for _, backend := range service.GetBackends() {
+			refs = append(refs, nonEmpty(backend.GetGroup())...)
+		}
+		found = append(found, Resource{
+			Kind:       "backend-services",
+			Name:       service.GetName(),
+			Location:   v.config.Region,
+			collection: "backendServices",
+			refs:       refs,
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listInstanceGroups(ctx context.Context) ([]Resource, error) {
+	it := v.instanceGroupClient.List(ctx, &computepb.ListInstanceGroupsRequest{
+		Project: v.config.ProjectID,
+		Zone:    v.config.Zone,
+	})
+
+	var found []Resource
+	for {
+		group, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instance groups: %v", err)
+		}
+		if !v.owns(group.GetName(), nil) {
+			continue
+		}
+		found = append(found, Resource{
+			Kind:       "instance-groups",
+			Name:       group.GetName(),
+			Location:   v.config.Zone,
+			collection: "instanceGroups",
+			refs:       nonEmpty(group.GetNetwork(), group.GetSubnetwork()),
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listHealthChecks(ctx context.Context) ([]Resource, error) {
+	it := v.healthCheckClient.List(ctx, &computepb.ListHealthChecksRequest{
+		Project: v.config.ProjectID,
+	})
+
+	var found []Resource
+	for {
+		check, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list health checks: %v", err)
+		}
+		if !v.owns(check.GetName(), nil) {
+			continue
+		}
+		found = append(found, Resource{
+			Kind:       "health-checks",
+			Name:       check.GetName(),
+			Location:   "global",
+			collection: "healthChecks",
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listInstances(ctx context.Context) ([]Resource, error) {
+	it := v.instancesClient.List(ctx, &computepb.ListInstancesRequest{
+		Project: v.config.ProjectID,
+		Zone:    v.config.Zone,
+	})
+
+	var found []Resource
+	for {
+		instance, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list instances: %v", err)
+		}
+		if !v.owns(instance.GetName(), instance.GetLabels()) {
+			continue
+		}
+
+		var refs []string
+		for _, nic := range instance.GetNetworkInterfaces() {
+			refs = append(refs, nonEmpty(nic.GetNetwork(), nic.GetSubnetwork())...)
+		}
+		found = append(found, Resource{
+			Kind:       "instances",
+			Name:       instance.GetName(),
+			Location:   v.config.Zone,
+			collection: "instances",
+			refs:       refs,
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listVPNTunnels(ctx context.Context) ([]Resource, error) {
+	it := v.vpnTunnelClient.List(ctx, &computepb.ListVpnTunnelsRequest{
+		Project: v.config.ProjectID,
+		Region:  v.config.Region,
+	})
+
+	var found []Resource
+	for {
+		tunnel, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VPN tunnels: %v", err)
+		}
+		if !v.owns(tunnel.GetName(), tunnel.GetLabels()) {
+			continue
+		}
+		found = append(found, Resource{
+			Kind:       "vpn-tunnels",
+			Name:       tunnel.GetName(),
+			Location:   v.config.Region,
+			collection: "vpnTunnels",
+			refs:       nonEmpty(tunnel.GetVpnGateway(), tunnel.GetRouter()),
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listVPNGateways(ctx context.Context) ([]Resource, error) {
+	it := v.vpnGatewayClient.List(ctx, &computepb.ListVpnGatewaysRequest{
+		Project: v.config.ProjectID,
+		Region:  v.config.Region,
+	})
+
+	var found []Resource
+	for {
+		gateway, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VPN gateways: %v", err)
+		}
+		if !v.owns(gateway.GetName(), gateway.GetLabels()) {
+			continue
+		}
+		found = append(found, Resource{
+			Kind:       "vpn-gateways",
+			Name:       gateway.GetName(),
+			Location:   v.config.Region,
+			collection: "vpnGateways",
+			refs:       nonEmpty(gateway.GetNetwork()),
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listRouters(ctx context.Context) ([]Resource, error) {
+	it := v.routerClient.List(ctx, &computepb.ListRoutersRequest{
+		Project: v.config.ProjectID,
+		Region:  v.config.Region,
+	})
+
+	var found []Resource
+	for {
+		router, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list routers: %v", err)
+		}
+		if !v.owns(router.GetName(), nil) {
+			continue
+		}
+		found = append(found, Resource{
+			Kind:       "routers",
+			Name:       router.GetName(),
+			Location:   v.config.Region,
+			collection: "routers",
+			refs:       nonEmpty(router.GetNetwork()),
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listFirewalls(ctx context.Context) ([]Resource, error) {
+	it := v.firewallClient.List(ctx, &computepb.ListFirewallsRequest{
+		Project: v.config.ProjectID,
+	})
+
+	var found []Resource
+	for {
+		rule, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list firewall rules: %v", err)
+		}
+		if !v.owns(rule.GetName(), nil) {
+			continue
+		}
+		found = append(found, Resource{
+			Kind:       "firewall-rules",
+			Name:       rule.GetName(),
+			Location:   "global",
+			collection: "firewalls",
+			refs:       nonEmpty(rule.GetNetwork()),
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listSubnets(ctx context.Context) ([]Resource, error) {
+	it := v.subnetClient.List(ctx, &computepb.ListSubnetworksRequest{
+		Project: v.config.ProjectID,
+		Region:  v.config.Region,
+	})
+
+	var found []Resource
+	for {
+		subnet, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list subnets: %v", err)
+		}
+		if !v.owns(subnet.GetName(), nil) {
+			continue
+		}
+		found = append(found, Resource{
+			Kind:       "subnets",
+			Name:       subnet.GetName(),
+			Location:   v.config.Region,
+			collection: "subnetworks",
+			refs:       nonEmpty(subnet.GetNetwork()),
+		})
+	}
+	return found, nil
+}
+
+func (v *Verifier) listNetworks(ctx context.Context) ([]Resource, error) {
+	it := v.networkClient.List(ctx, &computepb.ListNetworksRequest{
+		Project: v.config.ProjectID,
+	})
+
+	var found []Resource
+	for {
+		network, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list networks: %v", err)
+		}
+		if !v.owns(network.GetName(), nil) {
+			continue
+		}
+		r := Resource{
+			Kind:       "networks",
+			Name:       network.GetName(),
+			Location:   "global",
+			collection: "networks",
+		}
+		var peers []string
+		for _, peering := range network.GetPeerings() {
+			peers = append(peers, peering.GetName())
+		}
+		if len(peers) > 0 {
+			r.Reason = "still peered with " + strings.Join(peers, ", ")
+		}
+		found = append(found, r)
+	}
+	return found, nil
+}
+
+func (v *Verifier) owns(name string, labels map[string]string) bool {
+	return v.config.OwnsName(name) || v.config.OwnsLabels(labels)
+}
+
+// resourceKey turns a resource URL into collection/name, e.g. "subnetworks/foo"
+func resourceKey(url string) string {
+	parts := strings.Split(strings.TrimSuffix(url, "/"), "/")
+	if len(parts) < 2 {
+		return url
+	}
+	return parts[len(parts)-2] + "/" + parts[len(parts)-1]
+}
+
+func lastSegment(url string) string {
+	if idx := strings.LastIndex(url, "/"); idx >= 0 {
+		return url[idx+1:]
+	}
+	return url
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
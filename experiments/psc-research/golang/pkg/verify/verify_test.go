@@ -0,0 +1,166 @@
+package verify
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+)
+
+const testProject = "test-project"
+
+func newTestVerifier(t *testing.T, args ...string) (*Verifier, *fakecompute.Server, *config.Config) {
+	t.Helper()
+	cfg, err := config.Load("test", append([]string{"--project", testProject, "--run-id", "run-1"}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	v, err := NewVerifier(cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	t.Cleanup(v.Close)
+	return v, fake, cfg
+}
+
+// selfLink returns the URL other resources reference a resource by
+func selfLink(collection, name string) string {
+	return "https://www.googleapis.com/compute/v1/projects/" + testProject + "/" + collection + "/" + name
+}
+
+func TestSweep_Clean(t *testing.T) {
+	v, fake, cfg := newTestVerifier(t)
+	// Resources of other runs and of the project are not reported
+	regional := "regions/" + cfg.Region + "/"
+	fake.Put("global/networks", "default", nil)
+	fake.Put(regional+"subnetworks", "default", nil)
+	fake.Put(regional+"forwardingRules", "other-run-rule", map[string]any{
+		"labels": map[string]any{config.LabelDemo: "true", config.LabelRunID: "run-2"},
+	})
+
+	leftovers, err := v.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if len(leftovers) != 0 {
+		t.Errorf("Sweep() = %+v, want no leftovers", leftovers)
+	}
+	// Only runs of the VPN backend list VPN resources
+	for _, kind := range []string{"vpnTunnels", "vpnGateways", "routers"} {
+		if n := fake.Count(http.MethodGet, kind, ""); n != 0 {
+			t.Errorf("%d requests listing %s for a %s run", n, kind, cfg.ConnectivityBackend)
+		}
+	}
+}
+
+func TestSweep_Leftovers(t *testing.T) {
+	v, fake, cfg := newTestVerifier(t)
+	regional := "regions/" + cfg.Region + "/"
+	zonal := "zones/" + cfg.Zone + "/"
+
+	fake.Put(regional+"forwardingRules", cfg.ForwardingRule, map[string]any{
+		"backendService": selfLink(regional+"backendServices", cfg.BackendService),
+	})
+	fake.Put(regional+"backendServices", cfg.BackendService, map[string]any{
+		"healthChecks": []any{selfLink("global/healthChecks", cfg.HealthCheck)},
+		"backends":     []any{map[string]any{"group": selfLink(zonal+"instanceGroups", cfg.InstanceGroup)}},
+	})
+	fake.Put("global/healthChecks", cfg.HealthCheck, nil)
+	// Owned by the labels of the run whatever its name
+	fake.Put(zonal+"instances", "renamed-vm", map[string]any{
+		"labels":            cfg.Labels(),
+		"networkInterfaces": []any{map[string]any{"subnetwork": selfLink(regional+"subnetworks", cfg.ConsumerSubnet)}},
+	})
+	fake.Put(regional+"addresses", cfg.PSCEndpoint+"-ip", map[string]any{
+		"users": []any{selfLink(regional+"forwardingRules", cfg.PSCForwardingRule)},
+	})
+	fake.Put(regional+"serviceAttachments", cfg.ServiceAttachment, map[string]any{
+		"connectedEndpoints": []any{map[string]any{"status": "ACCEPTED"}},
+	})
+	fake.Put(regional+"subnetworks", cfg.ConsumerSubnet, map[string]any{
+		"network": selfLink("global/networks", cfg.ConsumerVPC),
+	})
+	fake.Put("global/networks", cfg.ConsumerVPC, map[string]any{
+		"peerings": []any{map[string]any{"name": "to-provider"}},
+	})
+
+	leftovers, err := v.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	got := map[string]string{}
+	for _, r := range leftovers {
+		got[r.ID()] = r.Reason
+	}
+	want := map[string]string{
+		"forwarding-rules/" + cfg.ForwardingRule:       "",
+		"addresses/" + cfg.PSCEndpoint + "-ip":         "used by " + cfg.PSCForwardingRule,
+		"service-attachments/" + cfg.ServiceAttachment: "1 PSC endpoint(s) still connected",
+		"backend-services/" + cfg.BackendService:       "in use by forwarding-rules/" + cfg.ForwardingRule,
+		"health-checks/" + cfg.HealthCheck:             "in use by backend-services/" + cfg.BackendService,
+		"instances/renamed-vm":                         "",
+		"subnets/" + cfg.ConsumerSubnet:                "in use by instances/renamed-vm",
+		"networks/" + cfg.ConsumerVPC:                  "in use by subnets/" + cfg.ConsumerSubnet + "; still peered with to-provider",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sweep() reasons = %v, want %v", got, want)
+	}
+	// Leftovers are listed in deletion order
+	if leftovers[0].Kind != "forwarding-rules" || leftovers[len(leftovers)-1].Kind != "networks" {
+		t.Errorf("Sweep() order = %+v", leftovers)
+	}
+}
+
+func TestSweep_VPN(t *testing.T) {
+	v, fake, cfg := newTestVerifier(t, "--connectivity-backend", "vpn")
+	regional := "regions/" + cfg.Region + "/"
+	gateway := selfLink(regional+"vpnGateways", cfg.ProviderVPNGateway)
+	fake.Put(regional+"vpnGateways", cfg.ProviderVPNGateway, nil)
+	fake.Put(regional+"vpnTunnels", cfg.VPNTunnels(cfg.ProviderVPNGateway)[0], map[string]any{"vpnGateway": gateway})
+	fake.Put(regional+"routers", cfg.ProviderRouter, nil)
+
+	leftovers, err := v.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	var ids []string
+	for _, r := range leftovers {
+		ids = append(ids, r.ID())
+	}
+	want := []string{
+		"vpn-tunnels/" + cfg.VPNTunnels(cfg.ProviderVPNGateway)[0],
+		"vpn-gateways/" + cfg.ProviderVPNGateway,
+		"routers/" + cfg.ProviderRouter,
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("Sweep() = %v, want %v", ids, want)
+	}
+	if !strings.HasPrefix(leftovers[1].Reason, "in use by vpn-tunnels/") {
+		t.Errorf("VPN gateway reason = %q, want in use by its tunnel", leftovers[1].Reason)
+	}
+}
+
+func TestSweep_ListError(t *testing.T) {
+	v, fake, _ := newTestVerifier(t)
+	fake.Put("global/networks", "default", nil)
+	fake.Fail(http.MethodGet, "subnetworks", http.StatusForbidden, "forbidden", 1)
+
+	leftovers, err := v.Sweep(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to list subnets") {
+		t.Errorf("Sweep() error = %v, want the failed list", err)
+	}
+	if leftovers != nil {
+		t.Errorf("Sweep() = %+v with an error, want nil", leftovers)
+	}
+	// The sweep stops at the failure
+	if n := fake.Count(http.MethodGet, "networks", ""); n != 0 {
+		t.Errorf("%d requests listing networks after the failure", n)
+	}
+}
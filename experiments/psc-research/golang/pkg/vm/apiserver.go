@@ -0,0 +1,55 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+
+	"gcp-psc-demo/pkg/gcloud"
+)
+
+// storageReadScope lets the provider VM download the API server binary from
+// the artifact bucket over Private Google Access
+const storageReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// UploadAPIServer copies the API server emulator binary to the artifact
+// bucket, creating the bucket in the demo region if it does not exist yet.
+// Nothing is uploaded when the provider VM runs a published image of it.
+func (vm *VMManager) UploadAPIServer() error {
+	if vm.config.APIServerImage != "" {
+		fmt.Printf("Using API server image %s, no binary to upload\n", vm.config.APIServerImage)
+		return nil
+	}
+
+	binary := vm.config.APIServerBinary
+	if _, err := os.Stat(binary); err != nil {
+		return fmt.Errorf("API server binary %s not found (run `make build` first): %v", binary, err)
+	}
+
+	bucket := "gs://" + vm.config.ArtifactBucketName()
+	if err := exec.Command("gcloud", "storage", "buckets", "describe", bucket,
+		"--project", vm.config.ProjectID).Run(); err != nil {
+		fmt.Printf("Creating artifact bucket %s\n", bucket)
+		if err := gcloud.Run(context.Background(), "storage", "buckets", "create", bucket,
+			"--project", vm.config.ProjectID,
+			"--location", vm.config.Region,
+			"--uniform-bucket-level-access"); err != nil {
+			return fmt.Errorf("failed to create artifact bucket %s: %v", bucket, err)
+		}
+	}
+
+	object := bucket + "/" + vm.config.APIServerObject()
+	fmt.Printf("Uploading API server emulator %s to %s\n", binary, object)
+	if err := gcloud.Run(context.Background(), "storage", "cp", binary, object, "--project", vm.config.ProjectID); err != nil {
+		return fmt.Errorf("failed to upload API server binary: %v", err)
+	}
+	return nil
+}
+
+// apiServerDownloadURL returns the GCS JSON API media URL of the uploaded binary
+func (vm *VMManager) apiServerDownloadURL() string {
+	return fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media",
+		vm.config.ArtifactBucketName(), url.PathEscape(vm.config.APIServerObject()))
+}
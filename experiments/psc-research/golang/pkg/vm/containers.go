@@ -0,0 +1,132 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/fatih/color"
+)
+
+// Instance metadata keys naming the images of the provider VM containers,
+// read by their systemd units on every start
+const (
+	webImageKey       = "psc-web-image"
+	apiServerImageKey = "psc-apiserver-image"
+)
+
+// localAPIServerImage is the image the provider VM builds from the uploaded
+// API server emulator binary when config.APIServerImage is empty
+const localAPIServerImage = "psc-apiserver:local"
+
+// providerUnits are the systemd units running the provider containers
+var providerUnits = []string{"psc-web", "psc-apiserver"}
+
+// apiServerImage returns the image the provider VM runs the API server
+// emulator from
+func (vm *VMManager) apiServerImage() string {
+	if vm.config.APIServerImage != "" {
+		return vm.config.APIServerImage
+	}
+	return localAPIServerImage
+}
+
+// containerMetadata sets the images of the configuration in the metadata
+// items of the provider VM
+func (vm *VMManager) containerMetadata(items []*computepb.Items) []*computepb.Items {
+	items = setMetadata(items, webImageKey, vm.config.WebImage)
+	return setMetadata(items, apiServerImageKey, vm.apiServerImage())
+}
+
+// UpdateProviderContainers points the existing provider VM at the images of
+// the configuration and restarts its containers, without rebuilding the VM.
+// Without an API server image the uploaded binary is downloaded again, so a
+// new build is deployed by uploading it first. It needs the SSH access of
+// SetupSSH.
+func (vm *VMManager) UpdateProviderContainers(ctx context.Context) error {
+	name := vm.config.ProviderVM
+	color.Blue("=== Updating the containers of provider VM %s ===", name)
+
+	if exists, err := vm.vmExists(ctx, name); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("provider VM %s does not exist, run the demo first", name)
+	}
+
+	changed, err := vm.updateMetadata(ctx, name, vm.containerMetadata)
+	if err != nil {
+		return fmt.Errorf("failed to set the container images of %s: %v", name, err)
+	}
+	if changed {
+		fmt.Printf("Container images set: %s=%s, %s=%s\n", webImageKey, vm.config.WebImage, apiServerImageKey, vm.apiServerImage())
+	} else {
+		fmt.Println("Container images unchanged, restarting the containers")
+	}
+
+	restart := "sudo systemctl restart " + strings.Join(providerUnits, " ")
+	output, err := exec.CommandContext(ctx, "gcloud", vm.config.SSHArgs(name, vm.config.Zone, "--command", restart)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to restart the containers of %s: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+
+	return vm.waitForContainers(ctx, name)
+}
+
+// waitForContainers waits for the provider containers to run the images of
+// the configuration, which they pull after the restart
+func (vm *VMManager) waitForContainers(ctx context.Context, name string) error {
+	want := map[string]string{
+		"psc-web":       vm.config.WebImage,
+		"psc-apiserver": vm.apiServerImage(),
+	}
+
+	maxWaitTime := 2 * time.Minute
+	checkInterval := 5 * time.Second
+	startTime := time.Now()
+
+	var running map[string]string
+	for time.Since(startTime) < maxWaitTime {
+		output, err := exec.CommandContext(ctx, "gcloud", vm.config.SSHArgs(name, vm.config.Zone,
+			"--command", "sudo docker ps --format '{{.Names}}={{.Image}}'")...).Output()
+		if err == nil {
+			running = parseContainers(string(output))
+			if sameContainers(running, want) {
+				color.Green("✓ Provider containers running %s and %s", want["psc-web"], want["psc-apiserver"])
+				return nil
+			}
+		}
+		fmt.Printf("Waiting for the provider containers... (%v elapsed)\n", time.Since(startTime).Round(time.Second))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(checkInterval):
+		}
+	}
+	return fmt.Errorf("provider containers did not start the configured images within %v (running: %v), see journalctl -u psc-web -u psc-apiserver on %s",
+		maxWaitTime, running, name)
+}
+
+// parseContainers parses the name=image lines of docker ps
+func parseContainers(output string) map[string]string {
+	containers := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if name, image, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			containers[name] = image
+		}
+	}
+	return containers
+}
+
+// sameContainers reports whether every wanted container runs its image
+func sameContainers(running, want map[string]string) bool {
+	for name, image := range want {
+		if running[name] != image {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,324 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcloud"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/fatih/color"
+	"google.golang.org/api/option"
+)
+
+// sshUser is the account the guest agent creates for the ephemeral key of
+// metadata mode
+const sshUser = "psc-demo"
+
+// Metadata keys controlling SSH access to a VM
+const (
+	metadataSSHKeys       = "ssh-keys"
+	metadataEnableOSLogin = "enable-oslogin"
+)
+
+// SSHSession is the SSH access of one command to the VMs of a run, see
+// config.SSHMode. In the OS Login and metadata modes it owns a key generated
+// for the command, authorized until Close or until it expires.
+type SSHSession struct {
+	cfg  *config.Config
+	opts []option.ClientOption
+
+	// dir holds the generated key pair
+	dir       string
+	publicKey string
+}
+
+// gcloudOutput runs the OS Login commands and returns their output. Tests
+// replace it.
+var gcloudOutput = gcloud.Output
+
+// SetupSSH prepares the SSH access of cfg.SSHMode and records it in cfg for
+// config.SSHArgs. In metadata mode the key is injected into the VMs of the
+// run that exist; VMs created later get it from DeployVMs. In OS Login mode
+// the key is added to the login profile of the active gcloud account and OS
+// Login is enabled on the existing VMs.
+func SetupSSH(ctx context.Context, cfg *config.Config, opts ...option.ClientOption) (*SSHSession, error) {
+	s := &SSHSession{cfg: cfg, opts: opts}
+	if cfg.SSHMode == config.SSHModeGcloud {
+		return s, nil
+	}
+
+	if err := s.generateKey(ctx); err != nil {
+		return nil, err
+	}
+	cfg.SSHKeyFile = filepath.Join(s.dir, "id_ed25519")
+
+	switch cfg.SSHMode {
+	case config.SSHModeOSLogin:
+		if _, err := gcloudOutput(ctx, "compute", "os-login", "ssh-keys", "add",
+			"--key-file", cfg.SSHKeyFile+".pub",
+			"--ttl", fmt.Sprintf("%ds", int(cfg.SSHKeyTTL.Seconds())),
+			"--project", cfg.ProjectID); err != nil {
+			s.removeKey()
+			return nil, fmt.Errorf("failed to add SSH key to the OS Login profile: %v", err)
+		}
+		color.Green("✓ Ephemeral SSH key added to the OS Login profile (expires in %s)", cfg.SSHKeyTTL)
+	case config.SSHModeMetadata:
+		cfg.SSHUser = sshUser
+		cfg.SSHMetadataKey = metadataKey(s.publicKey, time.Now().Add(cfg.SSHKeyTTL))
+	}
+
+	if err := s.eachVM(ctx, s.authorize); err != nil {
+		s.Close(ctx)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close revokes the ephemeral key: it is removed from the metadata of the VMs
+// or from the OS Login profile, then deleted. Failures are only reported, the
+// key expires anyway.
+func (s *SSHSession) Close(ctx context.Context) {
+	if s.dir == "" {
+		return
+	}
+
+	switch s.cfg.SSHMode {
+	case config.SSHModeOSLogin:
+		if _, err := gcloudOutput(ctx, "compute", "os-login", "ssh-keys", "remove",
+			"--key-file", s.cfg.SSHKeyFile+".pub",
+			"--project", s.cfg.ProjectID); err != nil {
+			color.Yellow("⚠ Warning: failed to remove the SSH key from the OS Login profile: %v", err)
+		}
+	case config.SSHModeMetadata:
+		if err := s.eachVM(ctx, s.revoke); err != nil {
+			color.Yellow("⚠ Warning: %v", err)
+		}
+	}
+	s.removeKey()
+}
+
+// generateKey creates the ephemeral key pair with ssh-keygen
+func (s *SSHSession) generateKey(ctx context.Context) error {
+	dir, err := os.MkdirTemp("", "psc-demo-ssh-")
+	if err != nil {
+		return fmt.Errorf("failed to create SSH key directory: %v", err)
+	}
+	s.dir = dir
+
+	path := filepath.Join(dir, "id_ed25519")
+	if output, err := exec.CommandContext(ctx, "ssh-keygen", "-t", "ed25519", "-N", "", "-q",
+		"-C", sshUser+"-"+s.cfg.RunID, "-f", path).CombinedOutput(); err != nil {
+		s.removeKey()
+		return fmt.Errorf("failed to generate SSH key: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	publicKey, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		s.removeKey()
+		return fmt.Errorf("failed to read SSH public key: %v", err)
+	}
+	s.publicKey = strings.TrimSpace(string(publicKey))
+	return nil
+}
+
+func (s *SSHSession) removeKey() {
+	os.RemoveAll(s.dir)
+	s.dir = ""
+}
+
+// metadataKey returns the ssh-keys entry of a public key, which the guest
+// agent removes from the VM once expireOn has passed
+func metadataKey(publicKey string, expireOn time.Time) string {
+	// Drop the comment of "<type> <key> <comment>", the entry carries its own
+	fields := strings.Fields(publicKey)
+	if len(fields) > 2 {
+		fields = fields[:2]
+	}
+	return fmt.Sprintf(`%s:%s google-ssh {"userName":"%s","expireOn":"%s"}`,
+		sshUser, strings.Join(fields, " "), sshUser, expireOn.UTC().Format("2006-01-02T15:04:05-0700"))
+}
+
+// eachVM calls update with the manager of the zone of every VM of the run:
+// both VMs of the primary zone, and the provider VM of a secondary region
+func (s *SSHSession) eachVM(ctx context.Context, update func(context.Context, *VMManager, string) error) error {
+	configs := []*config.Config{s.cfg}
+	if s.cfg.SecondaryRegion != "" {
+		secondary, err := s.cfg.Secondary()
+		if err != nil {
+			return err
+		}
+		configs = append(configs, secondary)
+	}
+
+	for i, cfg := range configs {
+		manager, err := NewVMManager(cfg, s.opts...)
+		if err != nil {
+			return err
+		}
+		names := []string{cfg.ProviderVM}
+		if i == 0 {
+			names = append(names, cfg.ConsumerVM)
+		}
+		for _, name := range names {
+			if err := update(ctx, manager, name); err != nil {
+				manager.Close()
+				return err
+			}
+		}
+		manager.Close()
+	}
+	return nil
+}
+
+// authorize lets the ephemeral key in on an existing VM
+func (s *SSHSession) authorize(ctx context.Context, vm *VMManager, name string) error {
+	updated, err := vm.updateMetadata(ctx, name, func(items []*computepb.Items) []*computepb.Items {
+		return vm.sshMetadata(items)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to authorize SSH key on %s: %v", name, err)
+	}
+	if updated {
+		fmt.Printf("SSH key authorized on VM %s\n", name)
+	}
+	return nil
+}
+
+// revoke removes the ephemeral key from the metadata of a VM
+func (s *SSHSession) revoke(ctx context.Context, vm *VMManager, name string) error {
+	_, err := vm.updateMetadata(ctx, name, func(items []*computepb.Items) []*computepb.Items {
+		keys := removeLine(metadataValue(items, metadataSSHKeys), s.cfg.SSHMetadataKey)
+		if keys == "" {
+			return deleteMetadata(items, metadataSSHKeys)
+		}
+		return setMetadata(items, metadataSSHKeys, keys)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove SSH key from %s: %v", name, err)
+	}
+	return nil
+}
+
+// sshMetadata adds the metadata of the SSH mode to the items of a VM: OS
+// Login enabled, or disabled so that the ephemeral key of the ssh-keys
+// entries is used
+func (vm *VMManager) sshMetadata(items []*computepb.Items) []*computepb.Items {
+	switch vm.config.SSHMode {
+	case config.SSHModeOSLogin:
+		items = setMetadata(items, metadataEnableOSLogin, "TRUE")
+	case config.SSHModeMetadata:
+		if vm.config.SSHMetadataKey != "" {
+			items = setMetadata(items, metadataEnableOSLogin, "FALSE")
+			items = setMetadata(items, metadataSSHKeys,
+				addLine(metadataValue(items, metadataSSHKeys), vm.config.SSHMetadataKey))
+		}
+	}
+	return items
+}
+
+// updateMetadata rewrites the metadata of a VM with update, if the VM exists
+// and the items change. It reports whether they were.
+func (vm *VMManager) updateMetadata(ctx context.Context, name string, update func([]*computepb.Items) []*computepb.Items) (bool, error) {
+	instance, err := vm.client.Get(ctx, &computepb.GetInstanceRequest{
+		Project:  vm.config.ProjectID,
+		Zone:     vm.config.Zone,
+		Instance: name,
+	})
+	if err != nil {
+		if isNotFoundError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	metadata := instance.GetMetadata()
+	items := update(append([]*computepb.Items(nil), metadata.GetItems()...))
+	if sameMetadata(metadata.GetItems(), items) {
+		return false, nil
+	}
+
+	// The fingerprint makes the update fail if the metadata changed since
+	op, err := vm.client.SetMetadata(ctx, &computepb.SetMetadataInstanceRequest{
+		Project:  vm.config.ProjectID,
+		Zone:     vm.config.Zone,
+		Instance: name,
+		MetadataResource: &computepb.Metadata{
+			Fingerprint: metadata.Fingerprint,
+			Items:       items,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := vm.ops.WaitZonal(ctx, op.Name()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func metadataValue(items []*computepb.Items, key string) string {
+	for _, item := range items {
+		if item.GetKey() == key {
+			return item.GetValue()
+		}
+	}
+	return ""
+}
+
+// setMetadata sets the value of key, without changing the items it is given
+func setMetadata(items []*computepb.Items, key, value string) []*computepb.Items {
+	items = deleteMetadata(items, key)
+	return append(items, &computepb.Items{Key: stringPtr(key), Value: stringPtr(value)})
+}
+
+func deleteMetadata(items []*computepb.Items, key string) []*computepb.Items {
+	kept := make([]*computepb.Items, 0, len(items))
+	for _, item := range items {
+		if item.GetKey() != key {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+func sameMetadata(a, b []*computepb.Items) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, item := range a {
+		if metadataValue(b, item.GetKey()) != item.GetValue() {
+			return false
+		}
+	}
+	return true
+}
+
+// addLine appends line to the lines of value unless it is there already
+func addLine(value, line string) string {
+	for _, existing := range strings.Split(value, "\n") {
+		if strings.TrimSpace(existing) == line {
+			return value
+		}
+	}
+	if strings.TrimSpace(value) == "" {
+		return line
+	}
+	return strings.TrimRight(value, "\n") + "\n" + line
+}
+
+// removeLine removes line from the lines of value
+func removeLine(value, line string) string {
+	var kept []string
+	for _, existing := range strings.Split(value, "\n") {
+		if trimmed := strings.TrimSpace(existing); trimmed != "" && trimmed != line {
+			kept = append(kept, existing)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+	"gcp-psc-demo/pkg/gcloud"
+)
+
+func TestMetadataKey(t *testing.T) {
+	expireOn := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	got := metadataKey("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIExample psc-demo-alice", expireOn)
+	want := `psc-demo:ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIExample google-ssh {"userName":"psc-demo","expireOn":"2026-03-01T12:30:00+0000"}`
+	if got != want {
+		t.Errorf("metadataKey() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAddRemoveLine(t *testing.T) {
+	existing := "alice:ssh-rsa AAAA alice"
+	added := addLine(existing, "psc-demo:key")
+	if added != existing+"\npsc-demo:key" {
+		t.Errorf("addLine() = %q", added)
+	}
+	if again := addLine(added, "psc-demo:key"); again != added {
+		t.Errorf("addLine() added the line twice: %q", again)
+	}
+	if removed := removeLine(added, "psc-demo:key"); removed != existing {
+		t.Errorf("removeLine() = %q, want %q", removed, existing)
+	}
+	if removed := removeLine("psc-demo:key\n", "psc-demo:key"); removed != "" {
+		t.Errorf("removeLine() = %q, want empty", removed)
+	}
+}
+
+// newSSHTestConfig returns the config of a run whose consumer VM exists with
+// a key of its own in its metadata
+func newSSHTestConfig(t *testing.T, mode string) (*config.Config, *fakecompute.Server) {
+	t.Helper()
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+	cfg.SSHMode = mode
+	fake.Put("zones/"+cfg.Zone+"/instances", cfg.ConsumerVM, map[string]any{
+		"name":     cfg.ConsumerVM,
+		"metadata": map[string]any{"items": []any{map[string]any{"key": "ssh-keys", "value": "alice:ssh-rsa AAAA alice"}}},
+	})
+	return cfg, fake
+}
+
+func instanceMetadata(fake *fakecompute.Server, zone, name string) map[string]string {
+	values := map[string]string{}
+	instance := fake.Get("zones/"+zone+"/instances", name)
+	metadata, _ := instance["metadata"].(map[string]any)
+	items, _ := metadata["items"].([]any)
+	for _, item := range items {
+		if kv, ok := item.(map[string]any); ok {
+			values[kv["key"].(string)], _ = kv["value"].(string)
+		}
+	}
+	return values
+}
+
+func TestSetupSSH_Metadata(t *testing.T) {
+	cfg, fake := newSSHTestConfig(t, config.SSHModeMetadata)
+	ctx := context.Background()
+
+	session, err := SetupSSH(ctx, cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("SetupSSH() error = %v", err)
+	}
+	if _, err := os.Stat(cfg.SSHKeyFile); err != nil {
+		t.Fatalf("private key not written: %v", err)
+	}
+	args := strings.Join(cfg.SSHArgs(cfg.ConsumerVM, cfg.Zone, "--command", "true"), " ")
+	if !strings.Contains(args, "ssh psc-demo@"+cfg.ConsumerVM) || !strings.Contains(args, "--ssh-key-file "+cfg.SSHKeyFile) {
+		t.Errorf("SSHArgs() = %s, want the ephemeral key and user", args)
+	}
+
+	// The existing VM gets the key next to its own, the missing one is skipped
+	consumer := instanceMetadata(fake, cfg.Zone, cfg.ConsumerVM)
+	if want := "alice:ssh-rsa AAAA alice\n" + cfg.SSHMetadataKey; consumer["ssh-keys"] != want {
+		t.Errorf("consumer ssh-keys = %q, want %q", consumer["ssh-keys"], want)
+	}
+	if consumer["enable-oslogin"] != "FALSE" {
+		t.Errorf("consumer enable-oslogin = %q, want FALSE", consumer["enable-oslogin"])
+	}
+
+	// VMs created during the command get the key at creation
+	manager, err := NewVMManager(cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+	if err := manager.deployProviderVM(ctx); err != nil {
+		t.Fatalf("deployProviderVM() error = %v", err)
+	}
+	provider := instanceMetadata(fake, cfg.Zone, cfg.ProviderVM)
+	if provider["ssh-keys"] != cfg.SSHMetadataKey || provider["user-data"] == "" {
+		t.Errorf("provider metadata = %v, want the cloud-init and the key", provider)
+	}
+
+	keyFile := cfg.SSHKeyFile
+	session.Close(ctx)
+	if got := instanceMetadata(fake, cfg.Zone, cfg.ConsumerVM)["ssh-keys"]; got != "alice:ssh-rsa AAAA alice" {
+		t.Errorf("consumer ssh-keys after Close = %q, want only its own key", got)
+	}
+	if _, ok := instanceMetadata(fake, cfg.Zone, cfg.ProviderVM)["ssh-keys"]; ok {
+		t.Error("provider ssh-keys still set after Close")
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("private key still on disk after Close: %v", err)
+	}
+}
+
+func TestSetupSSH_OSLogin(t *testing.T) {
+	cfg, fake := newSSHTestConfig(t, config.SSHModeOSLogin)
+	ctx := context.Background()
+
+	var commands []string
+	gcloudOutput = func(ctx context.Context, args ...string) (string, error) {
+		commands = append(commands, strings.Join(args, " "))
+		return "", nil
+	}
+	t.Cleanup(func() { gcloudOutput = gcloud.Output })
+
+	session, err := SetupSSH(ctx, cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("SetupSSH() error = %v", err)
+	}
+	if cfg.SSHUser != "" {
+		t.Errorf("SSHUser = %q, want the OS Login user picked by gcloud", cfg.SSHUser)
+	}
+	if got := instanceMetadata(fake, cfg.Zone, cfg.ConsumerVM)["enable-oslogin"]; got != "TRUE" {
+		t.Errorf("consumer enable-oslogin = %q, want TRUE", got)
+	}
+	session.Close(ctx)
+
+	want := []string{
+		"compute os-login ssh-keys add --key-file " + cfg.SSHKeyFile + ".pub --ttl 3600s --project " + testProject,
+		"compute os-login ssh-keys remove --key-file " + cfg.SSHKeyFile + ".pub --project " + testProject,
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("gcloud commands =\n%s\nwant\n%s", strings.Join(commands, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSetupSSH_Gcloud(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+
+	session, err := SetupSSH(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("SetupSSH() error = %v", err)
+	}
+	session.Close(context.Background())
+
+	want := "compute ssh " + cfg.ConsumerVM + " --zone " + cfg.Zone + " --project " + testProject + " --command true"
+	if got := strings.Join(cfg.SSHArgs(cfg.ConsumerVM, cfg.Zone, "--command", "true"), " "); got != want {
+		t.Errorf("SSHArgs() = %s, want %s", got, want)
+	}
+}
@@ -4,40 +4,75 @@ import (
 	"context"
 	"fmt"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
 	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcpops"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
 	"github.com/fatih/color"
+	"google.golang.org/api/option"
 )
 
 // VMManager handles VM operations
 type VMManager struct {
 	client *compute.InstancesClient
+	ops    *gcpops.Waiter
 	config *config.Config
 }
 
 // NewVMManager creates a new VM manager
-func NewVMManager(cfg *config.Config) (*VMManager, error) {
+func NewVMManager(cfg *config.Config, opts ...option.ClientOption) (*VMManager, error) {
 	ctx := context.Background()
 
-	client, err := compute.NewInstancesRESTClient(ctx)
+	client, err := compute.NewInstancesRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create instances client: %v", err)
 	}
 
 	return &VMManager{
 		client: client,
+		ops:    gcpops.NewWaiter(gcpops.PoolFor(opts...), cfg.ProjectID, cfg.Region, cfg.Zone),
 		config: cfg,
 	}, nil
 }
 
-// Close closes the client
+// CheckMachineType fails when the configured machine type is not offered in
+// the zone of a VM of the run, before anything is created
+func CheckMachineType(ctx context.Context, cfg *config.Config, opts ...option.ClientOption) error {
+	client, err := compute.NewMachineTypesRESTClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create machine types client: %v", err)
+	}
+	defer client.Close()
+
+	zones := []string{cfg.Zone}
+	if cfg.SecondaryZone != "" {
+		zones = append(zones, cfg.SecondaryZone)
+	}
+	for _, zone := range zones {
+		_, err := client.Get(ctx, &computepb.GetMachineTypeRequest{
+			Project:     cfg.ProjectID,
+			Zone:        zone,
+			MachineType: cfg.MachineType,
+		})
+		if isNotFoundError(err) {
+			return fmt.Errorf("machine type %s is not available in zone %s (--machine-type or --zone)", cfg.MachineType, zone)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check machine type %s in zone %s: %v", cfg.MachineType, zone, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the client and any dedicated operations pool
 func (vm *VMManager) Close() {
 	vm.client.Close()
+	vm.ops.Pool.Release()
 }
 
 // DeployVMs deploys both the service provider and consumer VMs
@@ -58,6 +93,19 @@ func (vm *VMManager) DeployVMs(ctx context.Context) error {
 	return nil
 }
 
+// DeployProviderVM deploys only the service provider VM, for the secondary
+// region of a multi-region run whose consumer VM stays in the primary region
+func (vm *VMManager) DeployProviderVM(ctx context.Context) error {
+	color.Blue("=== Deploying provider VM in %s ===", vm.config.Zone)
+
+	if err := vm.deployProviderVM(ctx); err != nil {
+		return err
+	}
+
+	color.Green("✓ Provider VM deployment completed successfully!")
+	return nil
+}
+
 // deployProviderVM deploys the service provider VM
 func (vm *VMManager) deployProviderVM(ctx context.Context) error {
 	vmName := vm.config.ProviderVM
@@ -79,6 +127,7 @@ func (vm *VMManager) deployProviderVM(ctx context.Context) error {
 		Zone:    vm.config.Zone,
 		InstanceResource: &computepb.Instance{
 			Name:        &vmName,
+			Labels:      vm.config.Labels(),
 			MachineType: stringPtr(fmt.Sprintf("zones/%s/machineTypes/%s", vm.config.Zone, vm.config.MachineType)),
 			NetworkInterfaces: []*computepb.NetworkInterface{
 				{
@@ -94,22 +143,28 @@ func (vm *VMManager) deployProviderVM(ctx context.Context) error {
 					AutoDelete: boolPtr(true),
 					InitializeParams: &computepb.AttachedDiskInitializeParams{
 						SourceImage: stringPtr(fmt.Sprintf("projects/%s/global/images/family/%s",
-							vm.config.ImageProject, vm.config.ImageFamily)),
+							vm.config.ProviderImageProject, vm.config.ProviderImageFamily)),
 						DiskSizeGb: int64Ptr(20),
 					},
 				},
 			},
 			Metadata: &computepb.Metadata{
-				Items: []*computepb.Items{
+				Items: vm.sshMetadata(vm.containerMetadata([]*computepb.Items{
 					{
 						Key:   stringPtr("user-data"),
 						Value: &cloudInit,
 					},
-				},
+				})),
 			},
 			Tags: &computepb.Tags{
 				Items: []string{"service-vm"},
 			},
+			ServiceAccounts: []*computepb.ServiceAccount{
+				{
+					Email:  stringPtr("default"),
+					Scopes: []string{storageReadScope},
+				},
+			},
 		},
 	}
 
@@ -118,7 +173,7 @@ func (vm *VMManager) deployProviderVM(ctx context.Context) error {
 		return fmt.Errorf("failed to create service provider VM: %v", err)
 	}
 
-	if err := vm.waitForZonalOperation(ctx, op.Name()); err != nil {
+	if err := vm.ops.WaitZonal(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for service provider VM creation: %v", err)
 	}
 
@@ -147,6 +202,7 @@ func (vm *VMManager) deployConsumerVM(ctx context.Context) error {
 		Zone:    vm.config.Zone,
 		InstanceResource: &computepb.Instance{
 			Name:        &vmName,
+			Labels:      vm.config.Labels(),
 			MachineType: stringPtr(fmt.Sprintf("zones/%s/machineTypes/%s", vm.config.Zone, vm.config.MachineType)),
 			NetworkInterfaces: []*computepb.NetworkInterface{
 				{
@@ -168,12 +224,12 @@ func (vm *VMManager) deployConsumerVM(ctx context.Context) error {
 				},
 			},
 			Metadata: &computepb.Metadata{
-				Items: []*computepb.Items{
+				Items: vm.sshMetadata([]*computepb.Items{
 					{
 						Key:   stringPtr("user-data"),
 						Value: &cloudInit,
 					},
-				},
+				}),
 			},
 			Tags: &computepb.Tags{
 				Items: []string{"client-vm"},
@@ -186,7 +242,7 @@ func (vm *VMManager) deployConsumerVM(ctx context.Context) error {
 		return fmt.Errorf("failed to create consumer VM: %v", err)
 	}
 
-	if err := vm.waitForZonalOperation(ctx, op.Name()); err != nil {
+	if err := vm.ops.WaitZonal(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for consumer VM creation: %v", err)
 	}
 
@@ -194,16 +250,14 @@ func (vm *VMManager) deployConsumerVM(ctx context.Context) error {
 	return nil
 }
 
-// getServiceCloudInit returns the cloud-init configuration for the service VM
+// getServiceCloudInit returns the cloud-init configuration for the service VM.
+// Container-Optimized OS runs it on every boot: its /etc is not persistent.
+// The services are containers supervised by systemd, whose images are read
+// from the instance metadata when they start, see containerMetadata.
 func (vm *VMManager) getServiceCloudInit() string {
 	return `#cloud-config
-package_update: true
-packages:
-  - nginx
-  - python3
-
 write_files:
-  - path: /var/www/html/index.html
+  - path: /var/lib/psc-demo/www/index.html
     content: |
       <!DOCTYPE html>
       <html>
@@ -213,68 +267,101 @@ write_files:
       <body>
           <h1>Hello from hypershift-redhat!</h1>
           <p>This service is running in the provider VPC and accessible via Private Service Connect.</p>
-          <p>Server: $(hostname)</p>
-          <p>Time: $(date)</p>
       </body>
       </html>
     owner: root:root
     permissions: '0644'
 
-  - path: /home/demo-api.py
+  - path: /etc/psc-demo/run-container.sh
     content: |
-      #!/usr/bin/env python3
-      import http.server
-      import socketserver
-      import json
-      import socket
-      import datetime
-
-      class MyHTTPRequestHandler(http.server.SimpleHTTPRequestHandler):
-          def do_GET(self):
-              if self.path == '/':
-                  self.send_response(200)
-                  self.send_header('Content-type', 'application/json')
-                  self.end_headers()
-                  response = {
-                      "message": "Hello from hypershift-redhat Private Service Connect Demo!",
-                      "hostname": socket.gethostname(),
-                      "timestamp": datetime.datetime.now().isoformat()
-                  }
-                  self.wfile.write(json.dumps(response).encode())
-              elif self.path == '/health':
-                  self.send_response(200)
-                  self.send_header('Content-type', 'application/json')
-                  self.end_headers()
-                  response = {"status": "healthy"}
-                  self.wfile.write(json.dumps(response).encode())
-              else:
-                  self.send_response(404)
-                  self.end_headers()
-
-      if __name__ == "__main__":
-          PORT = 8080
-          with socketserver.TCPServer(("0.0.0.0", PORT), MyHTTPRequestHandler) as httpd:
-              print(f"Starting server on 0.0.0.0:{PORT}")
-              httpd.serve_forever()
+      #!/bin/bash
+      # run-container.sh NAME KEY [docker run options] [-- container args]
+      # Runs the image named by the instance metadata attribute KEY in the
+      # foreground, on the host network, so that systemd supervises it
+      set -euo pipefail
+      NAME=$1 KEY=$2
+      shift 2
+      IMAGE=$(curl -sf -H 'Metadata-Flavor: Google' \
+        "http://metadata.google.internal/computeMetadata/v1/instance/attributes/${KEY}")
+      OPTS=()
+      while [ $# -gt 0 ] && [ "$1" != "--" ]; do OPTS+=("$1"); shift; done
+      [ $# -gt 0 ] && shift
+      docker rm -f "${NAME}" >/dev/null 2>&1 || true
+      if [ "${IMAGE}" != "` + localAPIServerImage + `" ]; then
+        # Private registries authenticate with the service account of the VM
+        docker-credential-gcr configure-docker --registries "${IMAGE%%/*}" >/dev/null 2>&1 || true
+        docker pull "${IMAGE}"
+      fi
+      exec docker run --rm --name "${NAME}" --network host "${OPTS[@]}" "${IMAGE}" "$@"
+    owner: root:root
+    permissions: '0644'
+
+  - path: /etc/psc-demo/fetch-psc-apiserver
+    content: |
+      #!/bin/bash
+      # Packages the API server emulator uploaded by the demo into the image
+      # ` + localAPIServerImage + `, unless a published image of it is configured.
+      # The VM has no external IP, GCS is reached through Private Google
+      # Access. The previous download is used if this one fails.
+      set -euo pipefail
+      MD=http://metadata.google.internal/computeMetadata/v1/instance
+      IMAGE=$(curl -sf -H 'Metadata-Flavor: Google' "${MD}/attributes/` + apiServerImageKey + `")
+      [ "${IMAGE}" = "` + localAPIServerImage + `" ] || exit 0
+      DIR=/var/lib/psc-demo/apiserver
+      mkdir -p "${DIR}"
+      TOKEN=$(curl -sf -H 'Metadata-Flavor: Google' "${MD}/service-accounts/default/token" \
+        | sed -E 's/.*"access_token" *: *"([^"]+)".*/\1/')
+      if curl -sf --retry 5 -H "Authorization: Bearer ${TOKEN}" -o "${DIR}/psc-apiserver.tmp" \
+        "` + vm.apiServerDownloadURL() + `"; then
+        chmod 0755 "${DIR}/psc-apiserver.tmp"
+        mv "${DIR}/psc-apiserver.tmp" "${DIR}/psc-apiserver"
+      elif [ ! -x "${DIR}/psc-apiserver" ]; then
+        echo "failed to download the API server emulator" >&2
+        exit 1
+      fi
+      # The binary is static: it is the only file of the image
+      tar -C "${DIR}" -c psc-apiserver \
+        | docker import -c 'ENTRYPOINT ["/psc-apiserver"]' - ` + localAPIServerImage + `
+    owner: root:root
+    permissions: '0644'
+
+  - path: /etc/systemd/system/psc-web.service
+    content: |
+      [Unit]
+      Description=Demo web page served through Private Service Connect
+      After=docker.service network-online.target
+      Requires=docker.service
+      Wants=network-online.target
+
+      [Service]
+      Environment=HOME=/var/lib/psc-demo
+      ExecStart=/bin/bash /etc/psc-demo/run-container.sh psc-web ` + webImageKey + ` -v /var/lib/psc-demo/www:/usr/share/nginx/html:ro
+      ExecStop=/usr/bin/docker stop psc-web
+      Restart=always
+      RestartSec=5
+      SyslogIdentifier=psc-web
+
+      [Install]
+      WantedBy=multi-user.target
     owner: root:root
-    permissions: '0755'
+    permissions: '0644'
 
-  - path: /etc/systemd/system/demo-api.service
+  - path: /etc/systemd/system/psc-apiserver.service
     content: |
       [Unit]
-      Description=Demo API Service
-      After=network.target
+      Description=Emulated hosted cluster kube-apiserver
+      After=docker.service network-online.target
+      Requires=docker.service
+      Wants=network-online.target
 
       [Service]
-      Type=simple
-      User=root
-      WorkingDirectory=/home
-      ExecStart=/usr/bin/python3 /home/demo-api.py
+      Environment=HOME=/var/lib/psc-demo
+      ExecStartPre=/bin/bash /etc/psc-demo/fetch-psc-apiserver
+      ExecStart=/bin/bash /etc/psc-demo/run-container.sh psc-apiserver ` + apiServerImageKey + ` -- --port ` + strconv.Itoa(vm.config.ServicePort) + `
+      ExecStop=/usr/bin/docker stop psc-apiserver
       Restart=always
       RestartSec=5
-      StandardOutput=journal
-      StandardError=journal
-      SyslogIdentifier=demo-api
+      SyslogIdentifier=psc-apiserver
 
       [Install]
       WantedBy=multi-user.target
@@ -282,15 +369,10 @@ write_files:
     permissions: '0644'
 
 runcmd:
-  - systemctl enable nginx
-  - systemctl start nginx
-  - systemctl enable demo-api
-  - systemctl start demo-api
-  - echo "Service VM setup completed" > /var/log/startup-complete.log
-
-power_state:
-  mode: reboot
-  condition: true`
+  - systemctl daemon-reload
+  - systemctl start psc-web
+  - systemctl start psc-apiserver
+  - echo "Service VM setup completed" > /var/log/startup-complete.log`
 }
 
 // getClientCloudInit returns the cloud-init configuration for the client VM
@@ -373,12 +455,39 @@ func (vm *VMManager) WaitForVMsReady(ctx context.Context) error {
 	return nil
 }
 
+// WaitForProviderVMReady waits for the provider VM alone to run the API
+// server emulator, the counterpart of DeployProviderVM
+func (vm *VMManager) WaitForProviderVMReady(ctx context.Context) error {
+	color.Blue("=== Waiting for provider VM %s to be ready ===", vm.config.ProviderVM)
+
+	maxWaitTime := 5 * time.Minute
+	checkInterval := 10 * time.Second
+	startTime := time.Now()
+
+	for time.Since(startTime) < maxWaitTime {
+		status, err := vm.getVMStatus(ctx, vm.config.ProviderVM)
+		if err != nil {
+			fmt.Printf("⚠ Error checking provider VM status: %v\n", err)
+		}
+
+		if status == "RUNNING" && vm.checkStartupCompletion(vm.config.ProviderVM) {
+			color.Green("✓ Provider VM is ready and its startup script completed")
+			return nil
+		}
+		fmt.Printf("Waiting for provider VM (%s)... (%v elapsed)\n", status, time.Since(startTime).Round(time.Second))
+
+		time.Sleep(checkInterval)
+	}
+
+	color.Yellow("⚠ Provider VM took longer than expected to be ready. Continuing anyway...")
+	return nil
+}
+
 // checkStartupCompletion checks if VM startup script has completed
 func (vm *VMManager) checkStartupCompletion(vmName string) bool {
 	// Use gcloud to check for startup completion file
-	cmd := exec.Command("gcloud", "compute", "ssh", vmName,
-		"--zone", vm.config.Zone,
-		"--command", "test -f /var/log/startup-complete.log && echo 'COMPLETE' || echo 'PENDING'")
+	cmd := exec.Command("gcloud", vm.config.SSHArgs(vmName, vm.config.Zone,
+		"--command", "test -f /var/log/startup-complete.log && echo 'COMPLETE' || echo 'PENDING'")...)
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -407,47 +516,6 @@ func (vm *VMManager) getVMStatus(ctx context.Context, name string) (string, erro
 	return instance.GetStatus(), nil
 }
 
-// waitForZonalOperation waits for a zonal operation to complete
-func (vm *VMManager) waitForZonalOperation(ctx context.Context, operationName string) error {
-	operationsClient, err := compute.NewZoneOperationsRESTClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer operationsClient.Close()
-
-	// Smart polling with exponential backoff
-	pollInterval := 1 * time.Second
-	maxInterval := 10 * time.Second
-
-	for {
-		req := &computepb.GetZoneOperationRequest{
-			Project:   vm.config.ProjectID,
-			Zone:      vm.config.Zone,
-			Operation: operationName,
-		}
-
-		op, err := operationsClient.Get(ctx, req)
-		if err != nil {
-			return err
-		}
-
-		if op.GetStatus() == computepb.Operation_DONE {
-			if op.Error != nil {
-				return fmt.Errorf("operation failed: %v", op.Error)
-			}
-			return nil
-		}
-
-		time.Sleep(pollInterval)
-
-		// Exponential backoff capped at maxInterval
-		pollInterval = pollInterval * 2
-		if pollInterval > maxInterval {
-			pollInterval = maxInterval
-		}
-	}
-}
-
 // Helper utility functions
 func stringPtr(s string) *string {
 	return &s
@@ -0,0 +1,231 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+	"gcp-psc-demo/pkg/gcpops"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testProject = "test-project"
+
+func newTestVMManager(t *testing.T) (*VMManager, *fakecompute.Server) {
+	t.Helper()
+
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+
+	manager, err := NewVMManager(cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewVMManager() error = %v", err)
+	}
+	t.Cleanup(manager.Close)
+
+	manager.ops.Backoff = gcpops.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	return manager, fake
+}
+
+func TestDeployVMs(t *testing.T) {
+	manager, fake := newTestVMManager(t)
+	fake.PendingPolls = 1
+	cfg := manager.config
+
+	if err := manager.DeployVMs(context.Background()); err != nil {
+		t.Fatalf("DeployVMs() error = %v", err)
+	}
+
+	instances := "zones/" + cfg.Zone + "/instances"
+	tests := []struct {
+		name   string
+		subnet string
+		tag    string
+	}{
+		{cfg.ProviderVM, cfg.ProviderSubnet, "service-vm"},
+		{cfg.ConsumerVM, cfg.ConsumerSubnet, "client-vm"},
+	}
+
+	for _, tt := range tests {
+		instance := fake.Get(instances, tt.name)
+		if instance == nil {
+			t.Errorf("instance %s was not created", tt.name)
+			continue
+		}
+
+		labels, _ := instance["labels"].(map[string]any)
+		for key, value := range cfg.Labels() {
+			if labels[key] != value {
+				t.Errorf("%s label %s = %v, want %s", tt.name, key, labels[key], value)
+			}
+		}
+
+		nics, _ := instance["networkInterfaces"].([]any)
+		if len(nics) != 1 {
+			t.Fatalf("%s has %d network interfaces, want 1", tt.name, len(nics))
+		}
+		if subnet, _ := nics[0].(map[string]any)["subnetwork"].(string); !strings.HasSuffix(subnet, "/subnetworks/"+tt.subnet) {
+			t.Errorf("%s subnetwork = %s, want %s", tt.name, subnet, tt.subnet)
+		}
+
+		tags, _ := instance["tags"].(map[string]any)
+		if items, _ := tags["items"].([]any); len(items) != 1 || items[0] != tt.tag {
+			t.Errorf("%s tags = %v, want [%s]", tt.name, tags["items"], tt.tag)
+		}
+	}
+}
+
+func TestDeployVMs_ProviderCanReadArtifacts(t *testing.T) {
+	manager, fake := newTestVMManager(t)
+
+	if err := manager.DeployVMs(context.Background()); err != nil {
+		t.Fatalf("DeployVMs() error = %v", err)
+	}
+
+	instance := fake.Get("zones/"+manager.config.Zone+"/instances", manager.config.ProviderVM)
+	accounts, _ := instance["serviceAccounts"].([]any)
+	if len(accounts) != 1 {
+		t.Fatalf("provider VM service accounts = %v, want the default account", instance["serviceAccounts"])
+	}
+	scopes, _ := accounts[0].(map[string]any)["scopes"].([]any)
+	if len(scopes) != 1 || scopes[0] != storageReadScope {
+		t.Errorf("provider VM scopes = %v, want [%s]", scopes, storageReadScope)
+	}
+}
+
+func TestServiceCloudInit(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+	cfg.RunID = "alice"
+	manager := &VMManager{config: cfg}
+
+	cloudInit := manager.getServiceCloudInit()
+
+	var parsed struct {
+		WriteFiles []struct {
+			Path    string `yaml:"path"`
+			Content string `yaml:"content"`
+		} `yaml:"write_files"`
+		RunCmd []string `yaml:"runcmd"`
+	}
+	if err := yaml.Unmarshal([]byte(cloudInit), &parsed); err != nil {
+		t.Fatalf("cloud-init is not valid YAML: %v", err)
+	}
+
+	files := map[string]string{}
+	for _, f := range parsed.WriteFiles {
+		files[f.Path] = f.Content
+	}
+
+	fetch := files["/etc/psc-demo/fetch-psc-apiserver"]
+	wantURL := "https://storage.googleapis.com/storage/v1/b/test-project-psc-demo-artifacts/o/alice%2Fpsc-apiserver?alt=media"
+	if !strings.Contains(fetch, wantURL) {
+		t.Errorf("fetch script does not download %s:\n%s", wantURL, fetch)
+	}
+	if !strings.Contains(fetch, "docker import") || strings.Contains(fetch, "python3") {
+		t.Errorf("fetch script does not package the binary with the tools of Container-Optimized OS:\n%s", fetch)
+	}
+
+	unit := files["/etc/systemd/system/psc-apiserver.service"]
+	if !strings.Contains(unit, "run-container.sh psc-apiserver psc-apiserver-image -- --port 6443") {
+		t.Errorf("systemd unit does not run the API server container on 6443:\n%s", unit)
+	}
+	if web := files["/etc/systemd/system/psc-web.service"]; !strings.Contains(web, "run-container.sh psc-web psc-web-image") {
+		t.Errorf("systemd unit does not run the web container:\n%s", web)
+	}
+	if _, ok := files["/etc/psc-demo/run-container.sh"]; !ok {
+		t.Error("run-container.sh is missing")
+	}
+
+	if !strings.Contains(strings.Join(parsed.RunCmd, "\n"), "systemctl start psc-apiserver") {
+		t.Errorf("runcmd = %v, want the API server started", parsed.RunCmd)
+	}
+}
+
+func TestDeployVMs_ProviderContainers(t *testing.T) {
+	manager, fake := newTestVMManager(t)
+	cfg := manager.config
+
+	if err := manager.DeployVMs(context.Background()); err != nil {
+		t.Fatalf("DeployVMs() error = %v", err)
+	}
+
+	instances := "zones/" + cfg.Zone + "/instances"
+	provider := fake.Get(instances, cfg.ProviderVM)
+	disks, _ := provider["disks"].([]any)
+	params, _ := disks[0].(map[string]any)["initializeParams"].(map[string]any)
+	if image, _ := params["sourceImage"].(string); image != "projects/cos-cloud/global/images/family/cos-121-lts" {
+		t.Errorf("provider VM image = %s, want Container-Optimized OS", image)
+	}
+
+	metadata, _ := provider["metadata"].(map[string]any)
+	items := map[string]any{}
+	list, _ := metadata["items"].([]any)
+	for _, item := range list {
+		item, _ := item.(map[string]any)
+		items[item["key"].(string)] = item["value"]
+	}
+	if items[webImageKey] != cfg.WebImage || items[apiServerImageKey] != localAPIServerImage {
+		t.Errorf("provider VM metadata = %v, want the web image and the local API server image", items)
+	}
+
+	if consumer := fake.Get(instances, cfg.ConsumerVM); strings.Contains(fmt.Sprint(consumer["metadata"]), webImageKey) {
+		t.Error("consumer VM metadata names container images")
+	}
+}
+
+func TestDeployVMs_Idempotent(t *testing.T) {
+	manager, fake := newTestVMManager(t)
+	ctx := context.Background()
+
+	if err := manager.DeployVMs(ctx); err != nil {
+		t.Fatalf("first DeployVMs() error = %v", err)
+	}
+	if err := manager.DeployVMs(ctx); err != nil {
+		t.Fatalf("second DeployVMs() error = %v", err)
+	}
+
+	if got := fake.Count(http.MethodPost, "instances", ""); got != 2 {
+		t.Errorf("instance inserts = %d, want 2", got)
+	}
+}
+
+func TestDeployVMs_InsertError(t *testing.T) {
+	manager, fake := newTestVMManager(t)
+	fake.Fail(http.MethodPost, "instances", http.StatusBadRequest, "invalid", 1)
+
+	err := manager.DeployVMs(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to create service provider VM") {
+		t.Fatalf("DeployVMs() error = %v, want a provider VM insert failure", err)
+	}
+	if got := fake.Names("zones/" + manager.config.Zone + "/instances"); len(got) != 0 {
+		t.Errorf("instances = %v, want none after the first insert failed", got)
+	}
+}
+
+func TestCheckMachineType(t *testing.T) {
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+	fake.Put("zones/us-central1-a/machineTypes", "e2-micro", nil)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+	if err := CheckMachineType(context.Background(), cfg, fake.ClientOptions()...); err != nil {
+		t.Errorf("CheckMachineType() error = %v", err)
+	}
+
+	cfg.SecondaryRegion, cfg.SecondaryZone = "us-east1", "us-east1-b"
+	err := CheckMachineType(context.Background(), cfg, fake.ClientOptions()...)
+	if err == nil || !strings.Contains(err.Error(), "machine type e2-micro is not available in zone us-east1-b") {
+		t.Errorf("CheckMachineType() error = %v, want e2-micro missing from the secondary zone", err)
+	}
+}
@@ -0,0 +1,316 @@
+package vpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/fatih/color"
+	"google.golang.org/api/iterator"
+)
+
+// Source ranges the demo needs to reach the VMs in an existing VPC
+var (
+	healthCheckRanges = []string{"130.211.0.0/22", "35.191.0.0/16"}
+	iapRanges         = []string{"35.235.240.0/20"}
+)
+
+// Network tags the VMs are created with, used to match firewall target tags
+const (
+	providerVMTag = "service-vm"
+	consumerVMTag = "client-vm"
+)
+
+// firewallRequirement describes traffic an existing VPC must let through to the demo VMs
+type firewallRequirement struct {
+	description string
+	sources     []string
+	port        int
+	tag         string
+	required    bool
+}
+
+// UseExistingProviderVPC discovers the subnets of the existing provider VPC and
+// checks that it can host the service: a PSC NAT subnet must exist and the
+// firewall must let health checks and PSC NAT traffic reach the service port.
+// The discovered subnet names and ranges are written back to the configuration.
+func (vm *VPCManager) UseExistingProviderVPC(ctx context.Context) error {
+	network := vm.config.ExistingProviderVPC
+	color.Blue("=== Using existing VPC %s (Service Provider) ===", network)
+
+	subnets, err := vm.existingSubnets(ctx, network)
+	if err != nil {
+		return err
+	}
+
+	var regular, nat []*computepb.Subnetwork
+	for _, subnet := range subnets {
+		switch subnet.GetPurpose() {
+		case "", "PRIVATE":
+			regular = append(regular, subnet)
+		case "PRIVATE_SERVICE_CONNECT":
+			nat = append(nat, subnet)
+		}
+	}
+
+	natSubnet, err := chooseSubnet(nat, vm.config.PSCNATSubnet, "PSC NAT", network, "--psc-nat-subnet")
+	if err != nil {
+		if len(nat) == 0 {
+			return fmt.Errorf("VPC %s has no subnet with purpose PRIVATE_SERVICE_CONNECT in %s; create one with "+
+				"`gcloud compute networks subnets create NAME --network %s --region %s --range CIDR --purpose PRIVATE_SERVICE_CONNECT`",
+				network, vm.config.Region, network, vm.config.Region)
+		}
+		return err
+	}
+	subnet, err := chooseSubnet(regular, vm.config.ProviderSubnet, "provider", network, "--provider-subnet")
+	if err != nil {
+		return err
+	}
+
+	vm.config.ProviderSubnet = subnet.GetName()
+	vm.config.ProviderSubnetRange = subnet.GetIpCidrRange()
+	vm.config.PSCNATSubnet = natSubnet.GetName()
+	vm.config.PSCNATSubnetRange = natSubnet.GetIpCidrRange()
+	fmt.Printf("Provider subnet: %s (%s)\n", vm.config.ProviderSubnet, vm.config.ProviderSubnetRange)
+	fmt.Printf("PSC NAT subnet: %s (%s)\n", vm.config.PSCNATSubnet, vm.config.PSCNATSubnetRange)
+
+	if err := vm.checkFirewall(ctx, network, []firewallRequirement{
+		{"Google health checks", healthCheckRanges, vm.config.ServicePort, providerVMTag, true},
+		{"PSC NAT subnet", []string{vm.config.PSCNATSubnetRange}, vm.config.ServicePort, providerVMTag, true},
+		{"SSH through IAP", iapRanges, 22, providerVMTag, false},
+	}); err != nil {
+		return err
+	}
+
+	color.Green("✓ Existing VPC %s is ready for the service!", network)
+	return nil
+}
+
+// UseExistingConsumerVPC discovers the subnet of the existing consumer VPC the
+// client VM and PSC endpoint are placed in and writes it back to the configuration
+func (vm *VPCManager) UseExistingConsumerVPC(ctx context.Context) error {
+	network := vm.config.ExistingConsumerVPC
+	color.Blue("=== Using existing VPC %s (Service Consumer) ===", network)
+
+	subnets, err := vm.existingSubnets(ctx, network)
+	if err != nil {
+		return err
+	}
+
+	var regular []*computepb.Subnetwork
+	for _, subnet := range subnets {
+		if purpose := subnet.GetPurpose(); purpose == "" || purpose == "PRIVATE" {
+			regular = append(regular, subnet)
+		}
+	}
+
+	subnet, err := chooseSubnet(regular, vm.config.ConsumerSubnet, "consumer", network, "--consumer-subnet")
+	if err != nil {
+		return err
+	}
+
+	vm.config.ConsumerSubnet = subnet.GetName()
+	vm.config.ConsumerSubnetRange = subnet.GetIpCidrRange()
+	fmt.Printf("Consumer subnet: %s (%s)\n", vm.config.ConsumerSubnet, vm.config.ConsumerSubnetRange)
+
+	if err := vm.checkFirewall(ctx, network, []firewallRequirement{
+		{"SSH through IAP", iapRanges, 22, consumerVMTag, false},
+	}); err != nil {
+		return err
+	}
+
+	color.Green("✓ Existing VPC %s is ready for the client!", network)
+	return nil
+}
+
+// existingSubnets checks that the network exists and returns its subnets in the demo region
+func (vm *VPCManager) existingSubnets(ctx context.Context, network string) ([]*computepb.Subnetwork, error) {
+	if exists, err := vm.vpcExists(ctx, network); err != nil {
+		return nil, fmt.Errorf("failed to get VPC %s: %v", network, err)
+	} else if !exists {
+		return nil, fmt.Errorf("existing VPC %s not found in project %s", network, vm.config.ProjectID)
+	}
+
+	it := vm.subnetClient.List(ctx, &computepb.ListSubnetworksRequest{
+		Project: vm.config.ProjectID,
+		Region:  vm.config.Region,
+	})
+
+	var subnets []*computepb.Subnetwork
+	for {
+		subnet, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list subnets: %v", err)
+		}
+		if lastSegment(subnet.GetNetwork()) == network {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets, nil
+}
+
+// chooseSubnet picks the subnet named preferred, or the only candidate
+func chooseSubnet(candidates []*computepb.Subnetwork, preferred, kind, network, flagName string) (*computepb.Subnetwork, error) {
+	var names []string
+	for _, subnet := range candidates {
+		if subnet.GetName() == preferred {
+			return subnet, nil
+		}
+		names = append(names, subnet.GetName())
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("VPC %s has no %s subnet in this region", network, kind)
+	case 1:
+		return candidates[0], nil
+	default:
+		return nil, fmt.Errorf("VPC %s has several candidate %s subnets (%s), choose one with %s",
+			network, kind, strings.Join(names, ", "), flagName)
+	}
+}
+
+// checkFirewall verifies that the network's firewall rules allow each
+// requirement. Missing required traffic is an error, anything else a warning.
+func (vm *VPCManager) checkFirewall(ctx context.Context, network string, requirements []firewallRequirement) error {
+	it := vm.firewallClient.List(ctx, &computepb.ListFirewallsRequest{Project: vm.config.ProjectID})
+
+	var rules []*computepb.Firewall
+	for {
+		fw, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list firewall rules: %v", err)
+		}
+		if lastSegment(fw.GetNetwork()) == network {
+			rules = append(rules, fw)
+		}
+	}
+
+	var missing []string
+	for _, req := range requirements {
+		var uncovered []string
+		for _, source := range req.sources {
+			if !anyFirewallAllows(rules, source, req.port, req.tag) {
+				uncovered = append(uncovered, source)
+			}
+		}
+
+		switch {
+		case len(uncovered) == 0:
+			fmt.Printf("Firewall allows %s on tcp:%d\n", req.description, req.port)
+		case req.required:
+			missing = append(missing, fmt.Sprintf("%s (tcp:%d from %s)", req.description, req.port, strings.Join(uncovered, ", ")))
+		default:
+			color.Yellow("⚠ Warning: no firewall rule on %s allows %s (tcp:%d from %s), gcloud compute ssh may not work",
+				network, req.description, req.port, strings.Join(uncovered, ", "))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("firewall rules on VPC %s do not allow %s to instances tagged %s",
+			network, strings.Join(missing, "; "), requirements[0].tag)
+	}
+	return nil
+}
+
+func anyFirewallAllows(rules []*computepb.Firewall, source string, port int, tag string) bool {
+	for _, fw := range rules {
+		if firewallAllows(fw, source, port, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// firewallAllows reports whether an enabled ingress allow rule lets the whole
+// source range reach tcp port on instances with the given network tag. Deny
+// rules and priorities are not evaluated.
+func firewallAllows(fw *computepb.Firewall, source string, port int, tag string) bool {
+	if fw.GetDisabled() || (fw.GetDirection() != "" && fw.GetDirection() != "INGRESS") {
+		return false
+	}
+	if len(fw.GetTargetServiceAccounts()) > 0 {
+		return false
+	}
+	if tags := fw.GetTargetTags(); len(tags) > 0 && !containsTag(tags, tag) {
+		return false
+	}
+
+	_, want, err := net.ParseCIDR(source)
+	if err != nil {
+		return false
+	}
+	covered := false
+	for _, r := range fw.GetSourceRanges() {
+		if _, have, err := net.ParseCIDR(r); err == nil && rangeContains(have, want) {
+			covered = true
+			break
+		}
+	}
+	if !covered {
+		return false
+	}
+
+	for _, allowed := range fw.GetAllowed() {
+		protocol := allowed.GetIPProtocol()
+		if protocol != "tcp" && protocol != "all" && protocol != "6" {
+			continue
+		}
+		if len(allowed.GetPorts()) == 0 {
+			return true
+		}
+		for _, ports := range allowed.GetPorts() {
+			if portInRange(ports, port) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rangeContains reports whether outer covers every address of inner
+func rangeContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// portInRange matches a firewall port spec, either "443" or "8000-9000"
+func portInRange(spec string, port int) bool {
+	low, high, found := strings.Cut(spec, "-")
+	if !found {
+		high = low
+	}
+	from, err := strconv.Atoi(low)
+	if err != nil {
+		return false
+	}
+	to, err := strconv.Atoi(high)
+	if err != nil {
+		return false
+	}
+	return port >= from && port <= to
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func lastSegment(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
@@ -0,0 +1,184 @@
+package vpc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"gcp-psc-demo/pkg/fakecompute"
+)
+
+const existingVPC = "shared-vpc"
+
+// existingVPCFixture is a customer VPC with a regular subnet, a PSC NAT subnet
+// and the firewall rules the provider side needs
+type existingVPCFixture struct {
+	manager *VPCManager
+	fake    *fakecompute.Server
+}
+
+func newExistingVPCFixture(t *testing.T) *existingVPCFixture {
+	t.Helper()
+	manager, fake := newTestVPCManager(t)
+	manager.config.UseExistingVPCs(existingVPC, existingVPC)
+
+	f := &existingVPCFixture{manager: manager, fake: fake}
+	fake.Put("global/networks", existingVPC, nil)
+	f.putSubnet("app-subnet", "10.10.0.0/20", "PRIVATE")
+	f.putSubnet("psc-nat", "10.20.0.0/24", "PRIVATE_SERVICE_CONNECT")
+	f.putFirewall("allow-hc", []string{"130.211.0.0/22", "35.191.0.0/16"}, "6443", nil)
+	f.putFirewall("allow-psc", []string{"10.20.0.0/16"}, "443-8443", []string{"service-vm"})
+	return f
+}
+
+func (f *existingVPCFixture) putSubnet(name, cidr, purpose string) {
+	f.fake.Put("regions/"+f.manager.config.Region+"/subnetworks", name, map[string]any{
+		"network":     networkURL(existingVPC),
+		"ipCidrRange": cidr,
+		"purpose":     purpose,
+	})
+}
+
+func (f *existingVPCFixture) putFirewall(name string, sources []string, ports string, tags []string) {
+	rule := map[string]any{
+		"network":      networkURL(existingVPC),
+		"direction":    "INGRESS",
+		"sourceRanges": sources,
+		"allowed":      []map[string]any{{"IPProtocol": "tcp", "ports": []string{ports}}},
+	}
+	if tags != nil {
+		rule["targetTags"] = tags
+	}
+	f.fake.Put("global/firewalls", name, rule)
+}
+
+func networkURL(name string) string {
+	return "https://www.googleapis.com/compute/v1/projects/" + testProject + "/global/networks/" + name
+}
+
+func TestUseExistingProviderVPC(t *testing.T) {
+	f := newExistingVPCFixture(t)
+	cfg := f.manager.config
+
+	if err := f.manager.UseExistingProviderVPC(context.Background()); err != nil {
+		t.Fatalf("UseExistingProviderVPC() error = %v", err)
+	}
+
+	if cfg.ProviderSubnet != "app-subnet" || cfg.ProviderSubnetRange != "10.10.0.0/20" {
+		t.Errorf("provider subnet = %s %s, want app-subnet 10.10.0.0/20", cfg.ProviderSubnet, cfg.ProviderSubnetRange)
+	}
+	if cfg.PSCNATSubnet != "psc-nat" || cfg.PSCNATSubnetRange != "10.20.0.0/24" {
+		t.Errorf("PSC NAT subnet = %s %s, want psc-nat 10.20.0.0/24", cfg.PSCNATSubnet, cfg.PSCNATSubnetRange)
+	}
+
+	for _, kind := range []string{"networks", "subnetworks", "firewalls"} {
+		if n := f.fake.Count(http.MethodPost, kind, ""); n != 0 {
+			t.Errorf("%d %s inserted, want none", n, kind)
+		}
+	}
+}
+
+func TestUseExistingProviderVPC_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(f *existingVPCFixture)
+		want  string
+	}{
+		{
+			name:  "missing network",
+			setup: func(f *existingVPCFixture) { f.manager.config.UseExistingVPCs("missing-vpc", "") },
+			want:  "missing-vpc not found",
+		},
+		{
+			name:  "no PSC NAT subnet",
+			setup: func(f *existingVPCFixture) { f.putSubnet("psc-nat", "10.20.0.0/24", "PRIVATE") },
+			want:  "purpose PRIVATE_SERVICE_CONNECT",
+		},
+		{
+			name:  "ambiguous provider subnet",
+			setup: func(f *existingVPCFixture) { f.putSubnet("db-subnet", "10.30.0.0/24", "") },
+			want:  "--provider-subnet",
+		},
+		{
+			name:  "health checks blocked",
+			setup: func(f *existingVPCFixture) { f.putFirewall("allow-hc", []string{"130.211.0.0/22"}, "22", nil) },
+			want:  "Google health checks (tcp:6443 from 130.211.0.0/22, 35.191.0.0/16)",
+		},
+		{
+			name: "PSC NAT rule targets other instances",
+			setup: func(f *existingVPCFixture) {
+				f.putFirewall("allow-psc", []string{"10.20.0.0/16"}, "6443", []string{"web"})
+			},
+			want: "PSC NAT subnet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newExistingVPCFixture(t)
+			tt.setup(f)
+
+			err := f.manager.UseExistingProviderVPC(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("UseExistingProviderVPC() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUseExistingConsumerVPC_PrefersConfiguredSubnet(t *testing.T) {
+	f := newExistingVPCFixture(t)
+	f.putSubnet("clients", "10.40.0.0/24", "")
+	cfg := f.manager.config
+	cfg.ConsumerSubnet = "clients"
+
+	if err := f.manager.UseExistingConsumerVPC(context.Background()); err != nil {
+		t.Fatalf("UseExistingConsumerVPC() error = %v", err)
+	}
+	if cfg.ConsumerSubnet != "clients" || cfg.ConsumerSubnetRange != "10.40.0.0/24" {
+		t.Errorf("consumer subnet = %s %s, want clients 10.40.0.0/24", cfg.ConsumerSubnet, cfg.ConsumerSubnetRange)
+	}
+}
+
+func TestFirewallAllows(t *testing.T) {
+	rule := func(mutate func(*computepb.Firewall)) *computepb.Firewall {
+		fw := &computepb.Firewall{
+			Direction:    stringPtr("INGRESS"),
+			SourceRanges: []string{"10.0.0.0/8"},
+			Allowed:      []*computepb.Allowed{{IPProtocol: stringPtr("tcp"), Ports: []string{"6000-7000"}}},
+		}
+		if mutate != nil {
+			mutate(fw)
+		}
+		return fw
+	}
+
+	tests := []struct {
+		name   string
+		fw     *computepb.Firewall
+		source string
+		want   bool
+	}{
+		{"port in range", rule(nil), "10.1.0.0/24", true},
+		{"source not covered", rule(nil), "192.168.0.0/24", false},
+		{"source wider than rule", rule(nil), "10.0.0.0/7", false},
+		{"port outside range", rule(func(fw *computepb.Firewall) { fw.Allowed[0].Ports = []string{"443"} }), "10.1.0.0/24", false},
+		{"all ports", rule(func(fw *computepb.Firewall) { fw.Allowed[0].Ports = nil }), "10.1.0.0/24", true},
+		{"all protocols", rule(func(fw *computepb.Firewall) { fw.Allowed[0].IPProtocol = stringPtr("all") }), "10.1.0.0/24", true},
+		{"udp only", rule(func(fw *computepb.Firewall) { fw.Allowed[0].IPProtocol = stringPtr("udp") }), "10.1.0.0/24", false},
+		{"disabled", rule(func(fw *computepb.Firewall) { fw.Disabled = boolPtr(true) }), "10.1.0.0/24", false},
+		{"egress", rule(func(fw *computepb.Firewall) { fw.Direction = stringPtr("EGRESS") }), "10.1.0.0/24", false},
+		{"matching tag", rule(func(fw *computepb.Firewall) { fw.TargetTags = []string{"web", "service-vm"} }), "10.1.0.0/24", true},
+		{"other tag", rule(func(fw *computepb.Firewall) { fw.TargetTags = []string{"web"} }), "10.1.0.0/24", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firewallAllows(tt.fw, tt.source, 6443, "service-vm"); got != tt.want {
+				t.Errorf("firewallAllows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,12 +3,14 @@ package vpc
 import (
 	"context"
 	"fmt"
-	"time"
+	"strconv"
 
 	compute "cloud.google.com/go/compute/apiv1"
 	"cloud.google.com/go/compute/apiv1/computepb"
 	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/gcpops"
 	"github.com/fatih/color"
+	"google.golang.org/api/option"
 )
 
 // VPCManager handles VPC operations
@@ -16,24 +18,25 @@ type VPCManager struct {
 	client         *compute.NetworksClient
 	subnetClient   *compute.SubnetworksClient
 	firewallClient *compute.FirewallsClient
+	ops            *gcpops.Waiter
 	config         *config.Config
 }
 
 // NewVPCManager creates a new VPC manager
-func NewVPCManager(cfg *config.Config) (*VPCManager, error) {
+func NewVPCManager(cfg *config.Config, opts ...option.ClientOption) (*VPCManager, error) {
 	ctx := context.Background()
 
-	client, err := compute.NewNetworksRESTClient(ctx)
+	client, err := compute.NewNetworksRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create networks client: %v", err)
 	}
 
-	subnetClient, err := compute.NewSubnetworksRESTClient(ctx)
+	subnetClient, err := compute.NewSubnetworksRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create subnetworks client: %v", err)
 	}
 
-	firewallClient, err := compute.NewFirewallsRESTClient(ctx)
+	firewallClient, err := compute.NewFirewallsRESTClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create firewall client: %v", err)
 	}
@@ -42,6 +45,7 @@ func NewVPCManager(cfg *config.Config) (*VPCManager, error) {
 		client:         client,
 		subnetClient:   subnetClient,
 		firewallClient: firewallClient,
+		ops:            gcpops.NewWaiter(gcpops.PoolFor(opts...), cfg.ProjectID, cfg.Region, cfg.Zone),
 		config:         cfg,
 	}, nil
 }
@@ -51,6 +55,7 @@ func (vm *VPCManager) Close() {
 	vm.client.Close()
 	vm.subnetClient.Close()
 	vm.firewallClient.Close()
+	vm.ops.Pool.Release()
 }
 
 // CreateProviderVPC creates the hypershift-redhat VPC (service provider)
@@ -104,6 +109,27 @@ func (vm *VPCManager) CreateConsumerVPC(ctx context.Context) error {
 	return nil
 }
 
+// CreateSecondaryRegionSubnets creates the provider, PSC NAT and consumer
+// subnets of the secondary region of a multi-region run in the VPCs of the
+// primary region, whose firewall rules already admit its PSC NAT range. The
+// manager must have been created with config.Config.Secondary.
+func (vm *VPCManager) CreateSecondaryRegionSubnets(ctx context.Context) error {
+	color.Blue("=== Setting up subnets in %s ===", vm.config.Region)
+
+	if err := vm.createSubnet(ctx, vm.config.ProviderVPC, vm.config.ProviderSubnet, vm.config.ProviderSubnetRange, ""); err != nil {
+		return err
+	}
+	if err := vm.createSubnet(ctx, vm.config.ProviderVPC, vm.config.PSCNATSubnet, vm.config.PSCNATSubnetRange, "PRIVATE_SERVICE_CONNECT"); err != nil {
+		return err
+	}
+	if err := vm.createSubnet(ctx, vm.config.ConsumerVPC, vm.config.ConsumerSubnet, vm.config.ConsumerSubnetRange, ""); err != nil {
+		return err
+	}
+
+	color.Green("✓ Subnets in %s created successfully!", vm.config.Region)
+	return nil
+}
+
 // createVPC creates a VPC network
 func (vm *VPCManager) createVPC(ctx context.Context, name string) error {
 	// Check if VPC already exists
@@ -132,7 +158,7 @@ func (vm *VPCManager) createVPC(ctx context.Context, name string) error {
 		return fmt.Errorf("failed to create VPC %s: %v", name, err)
 	}
 
-	if err := vm.waitForOperation(ctx, op.Name(), "global"); err != nil {
+	if err := vm.ops.WaitGlobal(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for VPC creation: %v", err)
 	}
 
@@ -161,6 +187,15 @@ func (vm *VPCManager) createSubnet(ctx context.Context, vpcName, subnetName, ipR
 
 	if purpose != "" {
 		subnet.Purpose = &purpose
+	} else {
+		// Flow logs record the PSC NAT traffic reaching the VMs; they are not
+		// supported on special-purpose subnets
+		subnet.LogConfig = &computepb.SubnetworkLogConfig{
+			Enable:              boolPtr(true),
+			AggregationInterval: stringPtr("INTERVAL_5_SEC"),
+			FlowSampling:        float32Ptr(1.0),
+			Metadata:            stringPtr("INCLUDE_ALL_METADATA"),
+		}
 	}
 
 	req := &computepb.InsertSubnetworkRequest{
@@ -174,7 +209,7 @@ func (vm *VPCManager) createSubnet(ctx context.Context, vpcName, subnetName, ipR
 		return fmt.Errorf("failed to create subnet %s: %v", subnetName, err)
 	}
 
-	if err := vm.waitForRegionalOperation(ctx, op.Name()); err != nil {
+	if err := vm.ops.WaitRegional(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for subnet creation: %v", err)
 	}
 
@@ -206,7 +241,7 @@ func (vm *VPCManager) createProviderFirewallRules(ctx context.Context) error {
 			allowed: []*computepb.Allowed{
 				{
 					IPProtocol: stringPtr("tcp"),
-					Ports:      []string{"80", "8080"},
+					Ports:      []string{"80", strconv.Itoa(vm.config.ServicePort)},
 				},
 			},
 		},
@@ -232,11 +267,11 @@ func (vm *VPCManager) createProviderFirewallRules(ctx context.Context) error {
 		{
 			name:         vm.config.ProviderVPC + "-allow-psc-nat",
 			description:  "Allow PSC NAT subnet traffic to reach service",
-			sourceRanges: []string{vm.config.PSCNATSubnetRange},
+			sourceRanges: vm.config.PSCNATRanges(),
 			allowed: []*computepb.Allowed{
 				{
 					IPProtocol: stringPtr("tcp"),
-					Ports:      []string{"8080"},
+					Ports:      []string{strconv.Itoa(vm.config.ServicePort)},
 				},
 			},
 		},
@@ -253,6 +288,12 @@ func (vm *VPCManager) createProviderFirewallRules(ctx context.Context) error {
 		return err
 	}
 
+	// Log what the implied deny rule would drop silently, e.g. PSC NAT traffic
+	// once the allow-psc-nat rule is missing
+	if err := vm.createDenyIngressRule(ctx, vm.config.ProviderVPC); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -299,6 +340,24 @@ func (vm *VPCManager) createConsumerFirewallRules(ctx context.Context) error {
 	return nil
 }
 
+// ConsumerAccessRule returns the name of the provider firewall rule that lets
+// the consumer subnet reach the service over VPC peering or HA VPN
+func ConsumerAccessRule(cfg *config.Config) string {
+	return cfg.ProviderVPC + "-allow-consumer"
+}
+
+// CreateConsumerAccessRule allows the consumer subnet to reach the service
+// port in the provider VPC. The peering and VPN backends route the consumer
+// addresses into the provider VPC unchanged, where PSC translates them into
+// the PSC NAT subnet.
+func (vm *VPCManager) CreateConsumerAccessRule(ctx context.Context) error {
+	return vm.createFirewallRule(ctx, ConsumerAccessRule(vm.config),
+		"Allow the consumer subnet to reach the service over peering or VPN",
+		vm.config.ProviderVPC, []string{vm.config.ConsumerSubnetRange}, []string{},
+		[]*computepb.Allowed{{IPProtocol: stringPtr("tcp"), Ports: []string{strconv.Itoa(vm.config.ServicePort)}}},
+		"INGRESS")
+}
+
 // createFirewallRule creates a firewall rule
 func (vm *VPCManager) createFirewallRule(ctx context.Context, name, description, vpcName string, sourceRanges, targetTags []string, allowed []*computepb.Allowed, direction string) error {
 	// Check if firewall rule already exists
@@ -331,6 +390,42 @@ func (vm *VPCManager) createFirewallRule(ctx context.Context, name, description,
 		firewall.TargetTags = targetTags
 	}
 
+	return vm.insertFirewall(ctx, firewall)
+}
+
+// createDenyIngressRule creates a logged deny-all ingress rule just above the
+// implied deny rule, which is never logged
+func (vm *VPCManager) createDenyIngressRule(ctx context.Context, vpcName string) error {
+	name := vpcName + "-deny-ingress"
+	if exists, err := vm.firewallRuleExists(ctx, name); err != nil {
+		return err
+	} else if exists {
+		fmt.Printf("Firewall rule %s already exists, skipping\n", name)
+		return nil
+	}
+
+	fmt.Printf("Creating firewall rule: %s\n", name)
+
+	return vm.insertFirewall(ctx, &computepb.Firewall{
+		Name:         &name,
+		Description:  stringPtr("Log ingress traffic no other rule allows"),
+		Network:      stringPtr(fmt.Sprintf("projects/%s/global/networks/%s", vm.config.ProjectID, vpcName)),
+		Direction:    stringPtr("INGRESS"),
+		Priority:     int32Ptr(65534),
+		SourceRanges: []string{"0.0.0.0/0"},
+		Denied:       []*computepb.Denied{{IPProtocol: stringPtr("all")}},
+	})
+}
+
+// insertFirewall creates a firewall rule with logging enabled, so that
+// analyze-flows can tell which rule handled a connection
+func (vm *VPCManager) insertFirewall(ctx context.Context, firewall *computepb.Firewall) error {
+	name := firewall.GetName()
+	firewall.LogConfig = &computepb.FirewallLogConfig{
+		Enable:   boolPtr(true),
+		Metadata: stringPtr("INCLUDE_ALL_METADATA"),
+	}
+
 	req := &computepb.InsertFirewallRequest{
 		Project:          vm.config.ProjectID,
 		FirewallResource: firewall,
@@ -341,7 +436,7 @@ func (vm *VPCManager) createFirewallRule(ctx context.Context, name, description,
 		return fmt.Errorf("failed to create firewall rule %s: %v", name, err)
 	}
 
-	if err := vm.waitForOperation(ctx, op.Name(), "global"); err != nil {
+	if err := vm.ops.WaitGlobal(ctx, op.Name()); err != nil {
 		return fmt.Errorf("failed to wait for firewall rule creation: %v", err)
 	}
 
@@ -404,87 +499,6 @@ func (vm *VPCManager) firewallRuleExists(ctx context.Context, name string) (bool
 	return true, nil
 }
 
-// waitForOperation waits for a global operation to complete
-func (vm *VPCManager) waitForOperation(ctx context.Context, operationName, operationType string) error {
-	operationsClient, err := compute.NewGlobalOperationsRESTClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer operationsClient.Close()
-
-	// Smart polling with exponential backoff
-	pollInterval := 1 * time.Second
-	maxInterval := 10 * time.Second
-
-	for {
-		req := &computepb.GetGlobalOperationRequest{
-			Project:   vm.config.ProjectID,
-			Operation: operationName,
-		}
-
-		op, err := operationsClient.Get(ctx, req)
-		if err != nil {
-			return err
-		}
-
-		if op.GetStatus() == computepb.Operation_DONE {
-			if op.Error != nil {
-				return fmt.Errorf("operation failed: %v", op.Error)
-			}
-			return nil
-		}
-
-		time.Sleep(pollInterval)
-
-		// Exponential backoff capped at maxInterval
-		pollInterval = pollInterval * 2
-		if pollInterval > maxInterval {
-			pollInterval = maxInterval
-		}
-	}
-}
-
-// waitForRegionalOperation waits for a regional operation to complete
-func (vm *VPCManager) waitForRegionalOperation(ctx context.Context, operationName string) error {
-	operationsClient, err := compute.NewRegionOperationsRESTClient(ctx)
-	if err != nil {
-		return err
-	}
-	defer operationsClient.Close()
-
-	// Smart polling with exponential backoff
-	pollInterval := 1 * time.Second
-	maxInterval := 10 * time.Second
-
-	for {
-		req := &computepb.GetRegionOperationRequest{
-			Project:   vm.config.ProjectID,
-			Region:    vm.config.Region,
-			Operation: operationName,
-		}
-
-		op, err := operationsClient.Get(ctx, req)
-		if err != nil {
-			return err
-		}
-
-		if op.GetStatus() == computepb.Operation_DONE {
-			if op.Error != nil {
-				return fmt.Errorf("operation failed: %v", op.Error)
-			}
-			return nil
-		}
-
-		time.Sleep(pollInterval)
-
-		// Exponential backoff capped at maxInterval
-		pollInterval = pollInterval * 2
-		if pollInterval > maxInterval {
-			pollInterval = maxInterval
-		}
-	}
-}
-
 // Helper utility functions
 func stringPtr(s string) *string {
 	return &s
@@ -494,6 +508,14 @@ func boolPtr(b bool) *bool {
 	return &b
 }
 
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func float32Ptr(f float32) *float32 {
+	return &f
+}
+
 func isNotFoundError(err error) bool {
 	// Simple check - in a real implementation you'd want more robust error checking
 	return err != nil && (containsString(err.Error(), "notFound") || containsString(err.Error(), "not found"))
@@ -0,0 +1,206 @@
+package vpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-psc-demo/pkg/config"
+	"gcp-psc-demo/pkg/fakecompute"
+	"gcp-psc-demo/pkg/gcpops"
+)
+
+const testProject = "test-project"
+
+func newTestVPCManager(t *testing.T) (*VPCManager, *fakecompute.Server) {
+	t.Helper()
+
+	fake := fakecompute.New(testProject)
+	t.Cleanup(fake.Close)
+
+	cfg := config.NewConfig()
+	cfg.ProjectID = testProject
+
+	manager, err := NewVPCManager(cfg, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewVPCManager() error = %v", err)
+	}
+	t.Cleanup(manager.Close)
+
+	manager.ops.Backoff = gcpops.Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	return manager, fake
+}
+
+func TestCreateProviderVPC(t *testing.T) {
+	manager, fake := newTestVPCManager(t)
+	fake.PendingPolls = 2
+	cfg := manager.config
+
+	if err := manager.CreateProviderVPC(context.Background()); err != nil {
+		t.Fatalf("CreateProviderVPC() error = %v", err)
+	}
+
+	if got := fake.Names("global/networks"); len(got) != 1 || got[0] != cfg.ProviderVPC {
+		t.Errorf("networks = %v, want [%s]", got, cfg.ProviderVPC)
+	}
+
+	subnets := "regions/" + cfg.Region + "/subnetworks"
+	if got := fake.Names(subnets); len(got) != 2 {
+		t.Errorf("subnetworks = %v, want 2", got)
+	}
+	if nat := fake.Get(subnets, cfg.PSCNATSubnet); nat["purpose"] != "PRIVATE_SERVICE_CONNECT" {
+		t.Errorf("PSC NAT subnet purpose = %v, want PRIVATE_SERVICE_CONNECT", nat["purpose"])
+	}
+	if subnet := fake.Get(subnets, cfg.ProviderSubnet); subnet["ipCidrRange"] != cfg.ProviderSubnetRange {
+		t.Errorf("provider subnet range = %v, want %s", subnet["ipCidrRange"], cfg.ProviderSubnetRange)
+	}
+
+	for _, name := range fake.Names("global/firewalls") {
+		if !strings.HasPrefix(name, cfg.ProviderVPC+"-") {
+			t.Errorf("firewall rule %s is not prefixed with the VPC name", name)
+		}
+	}
+	if got := len(fake.Names("global/firewalls")); got == 0 {
+		t.Error("no firewall rules created")
+	}
+
+	// Firewall logging and flow logs feed analyze-flows
+	for _, name := range fake.Names("global/firewalls") {
+		if logConfig, _ := fake.Get("global/firewalls", name)["logConfig"].(map[string]any); logConfig["enable"] != true {
+			t.Errorf("firewall rule %s logConfig = %v, want enabled", name, logConfig)
+		}
+	}
+	if deny := fake.Get("global/firewalls", cfg.ProviderVPC+"-deny-ingress"); deny == nil || deny["denied"] == nil {
+		t.Errorf("deny-ingress rule = %v, want a deny rule", deny)
+	}
+	if logConfig, _ := fake.Get(subnets, cfg.ProviderSubnet)["logConfig"].(map[string]any); logConfig["enable"] != true {
+		t.Errorf("provider subnet logConfig = %v, want flow logs enabled", logConfig)
+	}
+	if _, ok := fake.Get(subnets, cfg.PSCNATSubnet)["logConfig"]; ok {
+		t.Error("PSC NAT subnet has a logConfig, flow logs are not supported there")
+	}
+}
+
+func TestCreateProviderVPC_Idempotent(t *testing.T) {
+	manager, fake := newTestVPCManager(t)
+	ctx := context.Background()
+
+	if err := manager.CreateProviderVPC(ctx); err != nil {
+		t.Fatalf("first CreateProviderVPC() error = %v", err)
+	}
+	inserts := fake.Count(http.MethodPost, "networks", "") +
+		fake.Count(http.MethodPost, "subnetworks", "") +
+		fake.Count(http.MethodPost, "firewalls", "")
+
+	if err := manager.CreateProviderVPC(ctx); err != nil {
+		t.Fatalf("second CreateProviderVPC() error = %v", err)
+	}
+	again := fake.Count(http.MethodPost, "networks", "") +
+		fake.Count(http.MethodPost, "subnetworks", "") +
+		fake.Count(http.MethodPost, "firewalls", "")
+
+	if again != inserts {
+		t.Errorf("second run issued %d inserts, want 0", again-inserts)
+	}
+}
+
+func TestCreateConsumerVPC_OperationError(t *testing.T) {
+	manager, fake := newTestVPCManager(t)
+	fake.FailOperation("subnetworks", "QUOTA_EXCEEDED")
+
+	err := manager.CreateConsumerVPC(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "QUOTA_EXCEEDED") {
+		t.Fatalf("CreateConsumerVPC() error = %v, want QUOTA_EXCEEDED", err)
+	}
+	if got := fake.Count(http.MethodPost, "firewalls", ""); got != 0 {
+		t.Errorf("created %d firewall rules after the subnet failed, want 0", got)
+	}
+}
+
+func TestCreateConsumerVPC_ExistsCheckError(t *testing.T) {
+	manager, fake := newTestVPCManager(t)
+	fake.Fail(http.MethodGet, "networks", http.StatusForbidden, "forbidden", 1)
+
+	err := manager.CreateConsumerVPC(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("CreateConsumerVPC() error = %v, want a 403", err)
+	}
+	if got := fake.Count(http.MethodPost, "networks", ""); got != 0 {
+		t.Errorf("inserted %d networks after the exists check failed, want 0", got)
+	}
+}
+
+func TestCreateSecondaryRegionSubnets(t *testing.T) {
+	manager, fake := newTestVPCManager(t)
+	ctx := context.Background()
+	cfg := manager.config
+	cfg.SecondaryRegion, cfg.SecondaryZone = "us-east1", "us-east1-b"
+
+	if err := manager.CreateProviderVPC(ctx); err != nil {
+		t.Fatalf("CreateProviderVPC() error = %v", err)
+	}
+	if err := manager.CreateConsumerVPC(ctx); err != nil {
+		t.Fatalf("CreateConsumerVPC() error = %v", err)
+	}
+
+	// Provider VMs of both regions accept traffic from both PSC NAT ranges
+	rule := fake.Get("global/firewalls", cfg.ProviderVPC+"-allow-psc-nat")
+	if ranges, _ := rule["sourceRanges"].([]any); len(ranges) != 2 || ranges[1] != cfg.SecondaryPSCNATSubnetRange {
+		t.Errorf("allow-psc-nat source ranges = %v, want both PSC NAT ranges", rule["sourceRanges"])
+	}
+
+	secondary, err := cfg.Secondary()
+	if err != nil {
+		t.Fatalf("Secondary() error = %v", err)
+	}
+	regional, err := NewVPCManager(secondary, fake.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewVPCManager() error = %v", err)
+	}
+	defer regional.Close()
+	regional.ops.Backoff = manager.ops.Backoff
+
+	firewalls := fake.Count(http.MethodPost, "firewalls", "")
+	if err := regional.CreateSecondaryRegionSubnets(ctx); err != nil {
+		t.Fatalf("CreateSecondaryRegionSubnets() error = %v", err)
+	}
+
+	subnets := "regions/us-east1/subnetworks"
+	if got := fake.Names(subnets); len(got) != 3 {
+		t.Errorf("secondary region subnetworks = %v, want 3", got)
+	}
+	nat := fake.Get(subnets, secondary.PSCNATSubnet)
+	if nat["purpose"] != "PRIVATE_SERVICE_CONNECT" || nat["ipCidrRange"] != cfg.SecondaryPSCNATSubnetRange {
+		t.Errorf("secondary PSC NAT subnet = %v, want a PSC subnet of %s", nat, cfg.SecondaryPSCNATSubnetRange)
+	}
+	if consumer := fake.Get(subnets, secondary.ConsumerSubnet); !strings.HasSuffix(fmt.Sprint(consumer["network"]), "/"+cfg.ConsumerVPC) {
+		t.Errorf("secondary consumer subnet network = %v, want %s", consumer["network"], cfg.ConsumerVPC)
+	}
+	if got := fake.Count(http.MethodPost, "firewalls", ""); got != firewalls {
+		t.Errorf("created %d firewall rules for the secondary region, want none", got-firewalls)
+	}
+}
+
+func TestCreateConsumerAccessRule(t *testing.T) {
+	manager, fake := newTestVPCManager(t)
+	cfg := manager.config
+	cfg.ConnectivityBackend = config.BackendPeering
+
+	if err := manager.CreateConsumerAccessRule(context.Background()); err != nil {
+		t.Fatalf("CreateConsumerAccessRule() error = %v", err)
+	}
+
+	rule := fake.Get("global/firewalls", ConsumerAccessRule(cfg))
+	if rule == nil {
+		t.Fatalf("firewall rule %s not created", ConsumerAccessRule(cfg))
+	}
+	if ranges, _ := rule["sourceRanges"].([]any); len(ranges) != 1 || ranges[0] != cfg.ConsumerSubnetRange {
+		t.Errorf("source ranges = %v, want [%s]", rule["sourceRanges"], cfg.ConsumerSubnetRange)
+	}
+	if !strings.HasSuffix(fmt.Sprint(rule["network"]), "/"+cfg.ProviderVPC) {
+		t.Errorf("network = %v, want %s", rule["network"], cfg.ProviderVPC)
+	}
+}
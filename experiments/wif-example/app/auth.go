@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/credconfig"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/federation"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/telemetry"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+)
+
+// Authentication modes selectable with AUTH_MODE / -auth-mode
+const (
+	// authModeCredentialsFile lets the client libraries read the external-account
+	// configuration in GOOGLE_APPLICATION_CREDENTIALS
+	authModeCredentialsFile = "credentials-file"
+	// authModeSTS exchanges the token file at the STS in-process
+	authModeSTS = "sts"
+)
+
+// newTokenSource returns a token source that mints a new access token on
+// every call. The token manager decides when to call it. chain is the
+// credential chain of the chain auth mode, nil in the other modes.
+func newTokenSource(ctx context.Context, cfg *Config) (ts oauth2.TokenSource, chain *credentialChain, err error) {
+	ts, err = federatedTokenSource(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, _ = ts.(*credentialChain)
+	if cfg.ImpersonateServiceAccount == "" {
+		return ts, chain, nil
+	}
+
+	// Production setups have the federated identity impersonate a per-tenant
+	// service account instead of granting it project roles directly
+	ts, err = federation.NewImpersonator(ctx, ts, federation.ImpersonationConfig{
+		TargetServiceAccount: cfg.ImpersonateServiceAccount,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	component("auth").Info("Impersonating service account", "serviceAccount", cfg.ImpersonateServiceAccount)
+	return ts, chain, nil
+}
+
+// clientOptions authenticate GCP clients with tokens from ts and record
+// their requests in metrics. The token source is passed as well for checks
+// that mint a token themselves, e.g. tokeninfo.
+func clientOptions(ts oauth2.TokenSource, metrics *telemetry.Metrics) []option.ClientOption {
+	client := &http.Client{Transport: metrics.Transport(&oauth2.Transport{Source: ts})}
+	return []option.ClientOption{option.WithTokenSource(ts), option.WithHTTPClient(client)}
+}
+
+// federatedTokenSource returns the token source of the selected auth mode
+func federatedTokenSource(ctx context.Context, cfg *Config) (oauth2.TokenSource, error) {
+	switch cfg.AuthMode {
+	case authModeCredentialsFile:
+		credentialsFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if credentialsFile == "" {
+			return nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS not set (or use -auth-mode=%s)", authModeSTS)
+		}
+		component("auth").Info("Authenticating with credential configuration", "mode", cfg.AuthMode, "credentialsFile", credentialsFile)
+		return &credentialsFileSource{ctx: ctx, path: credentialsFile}, nil
+
+	case authModeSTS:
+		ts, err := federation.NewExchanger(ctx, federation.Config{
+			Audience:  cfg.WorkloadIdentityProvider,
+			TokenFile: cfg.TokenFile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		component("auth").Info("Authenticating with in-process STS exchange", "mode", cfg.AuthMode, "provider", cfg.WorkloadIdentityProvider)
+		return ts, nil
+
+	case authModeChain:
+		chain, err := newCredentialChain(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return chain, nil
+
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q (use %s, %s or %s)", cfg.AuthMode, authModeCredentialsFile, authModeSTS, authModeChain)
+	}
+}
+
+// credentialsFileSource mints access tokens from an external-account
+// credential configuration. The credentials are loaded anew on every call,
+// since the client library would otherwise cache the token itself.
+type credentialsFileSource struct {
+	ctx  context.Context
+	path string
+}
+
+// Token implements oauth2.TokenSource
+func (s *credentialsFileSource) Token() (*oauth2.Token, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file %s: %w", s.path, err)
+	}
+	creds, err := google.CredentialsFromJSON(s.ctx, data, federation.CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credentials: %w", err)
+	}
+	return creds.TokenSource.Token()
+}
+
+func readCredentialConfig() (map[string]any, *credconfig.Config, error) {
+	credentialsFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if credentialsFile == "" {
+		return nil, nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS not set")
+	}
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read credentials file %s: %w", credentialsFile, err)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse credentials file %s: %w", credentialsFile, err)
+	}
+	cc, err := credconfig.Parse(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse credentials file %s: %w", credentialsFile, err)
+	}
+	return raw, cc, nil
+}
+
+// providerAudience returns the workload identity provider of the selected auth mode
+func providerAudience(cfg *Config) (string, error) {
+	switch cfg.AuthMode {
+	case authModeSTS:
+		return cfg.WorkloadIdentityProvider, nil
+	case authModeChain:
+		return chainProvider(cfg)
+	}
+	_, cc, err := readCredentialConfig()
+	if err != nil {
+		return "", err
+	}
+	return cc.Audience, nil
+}
+
+// unimpersonatedTokenSource returns the federated identity's own token,
+// skipping any service account impersonation. ok is false when no
+// impersonation is configured, i.e. the federated identity is the one
+// supposed to hold the roles.
+func unimpersonatedTokenSource(ctx context.Context, cfg *Config) (ts oauth2.TokenSource, ok bool, err error) {
+	if cfg.AuthMode == authModeSTS || cfg.AuthMode == authModeChain {
+		if cfg.ImpersonateServiceAccount == "" {
+			return nil, false, nil
+		}
+		ts, err := federatedTokenSource(ctx, cfg)
+		return ts, err == nil, err
+	}
+
+	raw, cc, err := readCredentialConfig()
+	if err != nil {
+		return nil, false, err
+	}
+	if cc.ServiceAccountImpersonationURL == "" && cfg.ImpersonateServiceAccount == "" {
+		return nil, false, nil
+	}
+
+	delete(raw, "service_account_impersonation_url")
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	creds, err := google.CredentialsFromJSON(ctx, data, federation.CloudPlatformScope)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load credentials: %w", err)
+	}
+	return creds.TokenSource, true, nil
+}
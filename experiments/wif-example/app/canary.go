@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// canaryResult is the outcome of a run of the canary mode
+type canaryResult struct {
+	// Cycles is the number of cycles requested, Passed and Failed those run
+	Cycles int
+	Passed int
+	Failed int
+	// Budget is the number of failed cycles tolerated
+	Budget int
+	// Interrupted is set when the run stopped before its last cycle for
+	// another reason than an exhausted budget
+	Interrupted bool
+}
+
+// Succeeded reports whether the run proves WIF works: every cycle ran and
+// the failures stayed within the budget
+func (r canaryResult) Succeeded() bool {
+	return !r.Interrupted && r.Failed <= r.Budget && r.Passed+r.Failed == r.Cycles
+}
+
+// parseFailureBudget resolves FAILURE_BUDGET for a run of cycles: either a
+// number of failed cycles, or a percentage of the cycles rounded down, e.g.
+// 10% of 25 cycles tolerates 2 failures
+func parseFailureBudget(budget string, cycles int) (int, error) {
+	budget = strings.TrimSpace(budget)
+	if pct, ok := strings.CutSuffix(budget, "%"); ok {
+		p, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil || p < 0 || p > 100 {
+			return 0, fmt.Errorf("invalid failure budget %q, the percentage must be between 0%% and 100%%", budget)
+		}
+		return int(float64(cycles) * p / 100), nil
+	}
+	n, err := strconv.Atoi(budget)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid failure budget %q, must be a number of cycles or a percentage", budget)
+	}
+	return n, nil
+}
+
+// runCanary runs cycle up to cycles times, every interval, and counts the
+// cycles that returned an error. It stops early once the failures exceed
+// the budget, since the remaining cycles cannot change the outcome.
+func runCanary(ctx context.Context, cycles, budget int, interval time.Duration, cycle func(context.Context) error) canaryResult {
+	logger := component("canary")
+	result := canaryResult{Cycles: cycles, Budget: budget}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 1; i <= cycles; i++ {
+		err := cycle(ctx)
+		if ctx.Err() != nil {
+			// A cycle cut short by shutdown proves nothing either way
+			result.Interrupted = true
+			return result
+		}
+		if err != nil {
+			result.Failed++
+			logger.Warn("Canary cycle failed", "cycle", i, "cycles", cycles, "failed", result.Failed, "budget", budget, errorAttr(err))
+		} else {
+			result.Passed++
+			logger.Info("Canary cycle passed", "cycle", i, "cycles", cycles, "failed", result.Failed, "budget", budget)
+		}
+
+		if result.Failed > budget {
+			logger.Error("Failure budget exhausted, stopping", "cycle", i, "cycles", cycles, "failed", result.Failed, "budget", budget)
+			return result
+		}
+		if i == cycles {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			result.Interrupted = true
+			return result
+		case <-ticker.C:
+		}
+	}
+	return result
+}
+
+// logCanaryResult logs the summary of a canary run and returns its exit
+// code: 0 when WIF was proven to work, 1 otherwise
+func logCanaryResult(r canaryResult) int {
+	logger := component("canary")
+	attrs := []any{"cycles", r.Cycles, "passed", r.Passed, "failed", r.Failed, "budget", r.Budget}
+	switch {
+	case r.Succeeded():
+		logger.Info("Canary passed", append(attrs, "result", "PASS")...)
+		return 0
+	case r.Interrupted:
+		logger.Error("Canary interrupted before its last cycle", append(attrs, "result", "FAIL")...)
+	default:
+		logger.Error("Canary failed, more cycles failed than the budget allows", append(attrs, "result", "FAIL")...)
+	}
+	return 1
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseFailureBudget(t *testing.T) {
+	tests := []struct {
+		budget string
+		cycles int
+		want   int
+	}{
+		{"0", 10, 0},
+		{"2", 10, 2},
+		{"10%", 25, 2},
+		{"50 %", 5, 2},
+		{"100%", 4, 4},
+	}
+	for _, tt := range tests {
+		got, err := parseFailureBudget(tt.budget, tt.cycles)
+		if err != nil || got != tt.want {
+			t.Errorf("parseFailureBudget(%q, %d) = %d, %v, want %d", tt.budget, tt.cycles, got, err, tt.want)
+		}
+	}
+
+	for _, budget := range []string{"", "-1", "two", "101%", "-5%"} {
+		if _, err := parseFailureBudget(budget, 10); err == nil {
+			t.Errorf("parseFailureBudget(%q) succeeded", budget)
+		}
+	}
+}
+
+func TestRunCanary(t *testing.T) {
+	errCycle := errors.New("check failed")
+	// failing returns a cycle failing on the given calls, counted from 1
+	failing := func(calls *int, on ...int) func(context.Context) error {
+		return func(context.Context) error {
+			*calls++
+			for _, n := range on {
+				if n == *calls {
+					return errCycle
+				}
+			}
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name      string
+		budget    int
+		failOn    []int
+		wantCalls int
+		want      canaryResult
+		succeeded bool
+	}{
+		{
+			name:      "all cycles pass",
+			wantCalls: 4,
+			want:      canaryResult{Cycles: 4, Passed: 4},
+			succeeded: true,
+		},
+		{
+			name:      "failures within budget",
+			budget:    2,
+			failOn:    []int{1, 3},
+			wantCalls: 4,
+			want:      canaryResult{Cycles: 4, Passed: 2, Failed: 2, Budget: 2},
+			succeeded: true,
+		},
+		{
+			name:      "budget exhausted stops early",
+			budget:    1,
+			failOn:    []int{1, 2},
+			wantCalls: 2,
+			want:      canaryResult{Cycles: 4, Failed: 2, Budget: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			got := runCanary(context.Background(), 4, tt.budget, time.Millisecond, failing(&calls, tt.failOn...))
+			if got != tt.want || calls != tt.wantCalls {
+				t.Errorf("runCanary() = %+v after %d cycles, want %+v after %d", got, calls, tt.want, tt.wantCalls)
+			}
+			if got.Succeeded() != tt.succeeded {
+				t.Errorf("Succeeded() = %v, want %v", got.Succeeded(), tt.succeeded)
+			}
+			if code := logCanaryResult(got); (code == 0) != tt.succeeded {
+				t.Errorf("exit code = %d, want %v success", code, tt.succeeded)
+			}
+		})
+	}
+}
+
+func TestRunCanary_Interrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	got := runCanary(ctx, 3, 3, time.Hour, func(context.Context) error {
+		calls++
+		cancel()
+		return nil
+	})
+	if !got.Interrupted || calls != 1 || got.Succeeded() {
+		t.Errorf("runCanary() = %+v after %d cycles, want an interrupted, failed run after 1", got, calls)
+	}
+	if code := logCanaryResult(got); code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+}
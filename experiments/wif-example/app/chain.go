@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/federation"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/token"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/tokenrequest"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// authModeChain tries the credential sources of CREDENTIAL_SOURCES in order
+// and uses the first one that returns an access token
+const authModeChain = "chain"
+
+// Credential sources of the chain auth mode, selectable with
+// CREDENTIAL_SOURCES / -credential-sources
+const (
+	// credentialSourceFile exchanges the projected token in TOKEN_FILE at the
+	// STS, e.g. written by the kubelet or the token-minter sidecar
+	credentialSourceFile = "file"
+	// credentialSourceTokenRequest mints the subject token with the
+	// TokenRequest API and exchanges it at the STS
+	credentialSourceTokenRequest = "tokenrequest"
+	// credentialSourceMetadata asks the metadata server for the token of the
+	// attached service account: GKE Workload Identity or a GCE VM
+	credentialSourceMetadata = "metadata"
+)
+
+// defaultCredentialSources is the order in which the chain tries the sources:
+// the projected token of OpenShift, then minting it, then the metadata server
+const defaultCredentialSources = "file,tokenrequest,metadata"
+
+// SourceHealth is the state of one source of the credential chain
+type SourceHealth struct {
+	Name string `json:"name"`
+	// Available is false when the source could not be set up, e.g. the
+	// TokenRequest API outside of a cluster. Error tells why.
+	Available bool `json:"available"`
+	// Active is set on the source of the last access token
+	Active      bool      `json:"active"`
+	Successes   int       `json:"successes"`
+	Failures    int       `json:"failures"`
+	LastAttempt time.Time `json:"lastAttempt,omitzero"`
+	LastSuccess time.Time `json:"lastSuccess,omitzero"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// credentialSource is one link of the chain
+type credentialSource struct {
+	name string
+	// ts is nil if the source could not be set up
+	ts oauth2.TokenSource
+	// subject returns the claims of the token exchanged by ts, nil for
+	// sources without a subject token
+	subject func() (*token.Claims, error)
+}
+
+// credentialChain is a token source trying its sources in order on every
+// call, so a refresh goes back to a preferred source once it works again. It
+// does not cache: the token manager decides when to call it. It is safe for
+// concurrent use.
+type credentialChain struct {
+	sources []credentialSource
+	now     func() time.Time
+
+	mu     sync.Mutex
+	health []SourceHealth
+	// active is the index of the source of the last token, -1 before the first
+	active int
+}
+
+// parseCredentialSources returns the source names of a comma-separated list
+func parseCredentialSources(list string) ([]string, error) {
+	known := []string{credentialSourceFile, credentialSourceTokenRequest, credentialSourceMetadata}
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(known, name) {
+			return nil, fmt.Errorf("unknown credential source %q (available: %s)", name, strings.Join(known, ", "))
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("credential source %q listed twice", name)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no credential source selected (available: %s)", strings.Join(known, ", "))
+	}
+	return names, nil
+}
+
+// newCredentialChain sets up the sources of cfg.CredentialSources. A source
+// that cannot be set up is reported unavailable instead of failing, as the
+// same deployment runs where only some of them exist.
+func newCredentialChain(ctx context.Context, cfg *Config) (*credentialChain, error) {
+	names, err := parseCredentialSources(cfg.CredentialSources)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]credentialSource, len(names))
+	setupErrs := make([]error, len(names))
+	for i, name := range names {
+		sources[i], setupErrs[i] = newCredentialSource(ctx, cfg, name)
+		sources[i].name = name
+	}
+
+	c := newChain(sources)
+	logger := component("auth")
+	for i, err := range setupErrs {
+		if err != nil {
+			c.health[i].Error = err.Error()
+			logger.Warn("Credential source unavailable", "source", names[i], errorAttr(err))
+		}
+	}
+	logger.Info("Authenticating with a chain of credential sources", "mode", cfg.AuthMode, "sources", names)
+	return c, nil
+}
+
+func newChain(sources []credentialSource) *credentialChain {
+	c := &credentialChain{sources: sources, now: time.Now, active: -1}
+	c.health = make([]SourceHealth, len(sources))
+	for i, s := range sources {
+		c.health[i] = SourceHealth{Name: s.name, Available: s.ts != nil}
+	}
+	return c
+}
+
+// newCredentialSource sets up the source called name
+func newCredentialSource(ctx context.Context, cfg *Config, name string) (credentialSource, error) {
+	switch name {
+	case credentialSourceFile:
+		return newExchangeSource(ctx, cfg, cfg.TokenFile, nil)
+
+	case credentialSourceTokenRequest:
+		// The minted token must not replace the projected one, which the
+		// file source may fall back to once the kubelet rotates it again
+		dir, err := os.MkdirTemp("", "wif-tokenrequest-")
+		if err != nil {
+			return credentialSource{}, err
+		}
+		tokenFile := filepath.Join(dir, "token")
+		minter, err := tokenrequest.NewMinter(tokenrequest.Config{
+			Kubeconfig:     cfg.Kubeconfig,
+			ServiceAccount: cfg.TokenServiceAccount,
+			Audience:       cfg.Audience,
+			Expiration:     cfg.TokenExpiration,
+			TokenFile:      tokenFile,
+		})
+		if err != nil {
+			os.RemoveAll(dir)
+			return credentialSource{}, err
+		}
+		// Mint when the last token is due for renewal, exchange it anyway if
+		// that fails: it may still be valid
+		mint := func() error {
+			stats := minter.Stats()
+			if !stats.LastMint.IsZero() && time.Now().Before(stats.NextMint) {
+				return nil
+			}
+			err := minter.Mint(ctx)
+			if err != nil && !stats.LastMint.IsZero() {
+				component("tokenrequest").Warn("TokenRequest failed, exchanging the previous token", "expiresAt", stats.ExpiresAt, errorAttr(err))
+				return nil
+			}
+			return err
+		}
+		return newExchangeSource(ctx, cfg, tokenFile, mint)
+
+	case credentialSourceMetadata:
+		return credentialSource{ts: metadataSource{}}, nil
+
+	default:
+		return credentialSource{}, fmt.Errorf("unknown credential source %q", name)
+	}
+}
+
+// newExchangeSource returns a source exchanging tokenFile at the STS. before,
+// if not nil, runs before every exchange, e.g. to mint the token.
+func newExchangeSource(ctx context.Context, cfg *Config, tokenFile string, before func() error) (credentialSource, error) {
+	provider, err := chainProvider(cfg)
+	if err != nil {
+		return credentialSource{}, err
+	}
+	exchanger, err := federation.NewExchanger(ctx, federation.Config{Audience: provider, TokenFile: tokenFile})
+	if err != nil {
+		return credentialSource{}, err
+	}
+
+	subject := func() (*token.Claims, error) {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token file %s: %w", tokenFile, err)
+		}
+		return token.Parse(string(data))
+	}
+	ts := tokenSourceFunc(func() (*oauth2.Token, error) {
+		if before != nil {
+			if err := before(); err != nil {
+				return nil, err
+			}
+		}
+		// Skip a missing or expired token rather than wait for the STS to reject it
+		claims, err := subject()
+		if err != nil {
+			return nil, err
+		}
+		if err := claims.Validate(time.Now(), 0); err != nil {
+			return nil, fmt.Errorf("token file %s: %w", tokenFile, err)
+		}
+		return exchanger.Token()
+	})
+	return credentialSource{ts: ts, subject: subject}, nil
+}
+
+// chainProvider returns the workload identity provider the chain exchanges
+// subject tokens with: WIF_PROVIDER, or the audience of the credential
+// configuration the deployment mounts for the credentials-file auth mode
+func chainProvider(cfg *Config) (string, error) {
+	if cfg.WorkloadIdentityProvider != "" {
+		return cfg.WorkloadIdentityProvider, nil
+	}
+	_, cc, err := readCredentialConfig()
+	if err != nil {
+		return "", fmt.Errorf("WIF_PROVIDER not set and no credential configuration to read it from: %w", err)
+	}
+	return cc.Audience, nil
+}
+
+// tokenSourceFunc adapts a function to oauth2.TokenSource
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+// Token implements oauth2.TokenSource
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
+
+// metadataSource asks the metadata server for an access token of the
+// attached service account. A new source is created on every call, since
+// google.ComputeTokenSource caches the token itself.
+type metadataSource struct{}
+
+// Token implements oauth2.TokenSource
+func (metadataSource) Token() (*oauth2.Token, error) {
+	tok, err := google.ComputeTokenSource("", federation.CloudPlatformScope).Token()
+	if err != nil {
+		return nil, fmt.Errorf("metadata server: %w", err)
+	}
+	return tok, nil
+}
+
+// Token returns an access token of the first available source that has one
+func (c *credentialChain) Token() (*oauth2.Token, error) {
+	var errs []error
+	for i, s := range c.sources {
+		if s.ts == nil {
+			continue
+		}
+		tok, err := s.ts.Token()
+		c.record(i, err)
+		if err == nil {
+			return tok, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no credential source available: %s", c.setupErrors())
+	}
+	return nil, fmt.Errorf("every credential source failed: %w", errors.Join(errs...))
+}
+
+// record stores the outcome of a call to source i and logs when the chain
+// switches to another source
+func (c *credentialChain) record(i int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := &c.health[i]
+	h.LastAttempt = c.now()
+	if err != nil {
+		h.Failures++
+		h.Error = err.Error()
+		component("auth").Warn("Credential source failed", "source", h.Name, errorAttr(err))
+		return
+	}
+	h.Successes++
+	h.LastSuccess = h.LastAttempt
+	h.Error = ""
+
+	if c.active == i {
+		return
+	}
+	logger := component("auth")
+	switch {
+	case c.active == -1:
+		logger.Info("Using credential source", "source", h.Name)
+	case i > c.active:
+		logger.Warn("Falling back to credential source", "source", h.Name, "previous", c.health[c.active].Name)
+	default:
+		logger.Info("Credential source recovered", "source", h.Name, "previous", c.health[c.active].Name)
+	}
+	if c.active >= 0 {
+		c.health[c.active].Active = false
+	}
+	h.Active = true
+	c.active = i
+}
+
+// setupErrors lists why the sources could not be set up
+func (c *credentialChain) setupErrors() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msgs := make([]string, len(c.health))
+	for i, h := range c.health {
+		msgs[i] = h.Name + ": " + h.Error
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Health returns a snapshot of the state of every source, in chain order
+func (c *credentialChain) Health() []SourceHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.health)
+}
+
+// Subject returns the claims of the subject token of the active source. It
+// returns nil without error when the active source has no subject token, or
+// before the first access token was requested.
+func (c *credentialChain) Subject() (*token.Claims, error) {
+	c.mu.Lock()
+	active := c.active
+	c.mu.Unlock()
+
+	if active == -1 || c.sources[active].subject == nil {
+		return nil, nil
+	}
+	return c.sources[active].subject()
+}
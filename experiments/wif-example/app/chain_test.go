@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/token"
+	"golang.org/x/oauth2"
+)
+
+// flakySource returns an access token named after it, or err
+type flakySource struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (f *flakySource) Token() (*oauth2.Token, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &oauth2.Token{AccessToken: f.name, Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestParseCredentialSources(t *testing.T) {
+	for _, tc := range []struct {
+		list    string
+		want    string
+		wantErr string
+	}{
+		{list: defaultCredentialSources, want: "file,tokenrequest,metadata"},
+		{list: " metadata , file ", want: "metadata,file"},
+		{list: "file,,tokenrequest", want: "file,tokenrequest"},
+		{list: "file,adc", wantErr: `unknown credential source "adc"`},
+		{list: "file,file", wantErr: "listed twice"},
+		{list: " , ", wantErr: "no credential source selected"},
+	} {
+		names, err := parseCredentialSources(tc.list)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("parseCredentialSources(%q) error = %v, want %q", tc.list, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || strings.Join(names, ",") != tc.want {
+			t.Errorf("parseCredentialSources(%q) = %v, %v, want %s", tc.list, names, err, tc.want)
+		}
+	}
+}
+
+func TestCredentialChain(t *testing.T) {
+	file := &flakySource{name: "file", err: errors.New("token file /token: token is expired")}
+	metadata := &flakySource{name: "metadata"}
+	claims := &token.Claims{Subject: "system:serviceaccount:default:wif-app-workload-sa"}
+	chain := newChain([]credentialSource{
+		{name: credentialSourceFile, ts: file, subject: func() (*token.Claims, error) { return claims, nil }},
+		{name: credentialSourceTokenRequest},
+		{name: credentialSourceMetadata, ts: metadata},
+	})
+
+	if got, err := chain.Subject(); got != nil || err != nil {
+		t.Errorf("Subject() before the first token = %v, %v, want nothing", got, err)
+	}
+
+	tok, err := chain.Token()
+	if err != nil || tok.AccessToken != "metadata" {
+		t.Fatalf("Token() = %v, %v, want the token of the metadata source", tok, err)
+	}
+	health := chain.Health()
+	if h := health[0]; h.Active || h.Failures != 1 || !strings.Contains(h.Error, "expired") || !h.Available {
+		t.Errorf("file health = %+v, want one failure", h)
+	}
+	if h := health[1]; h.Available || !h.LastAttempt.IsZero() {
+		t.Errorf("tokenrequest health = %+v, want unavailable and never tried", h)
+	}
+	if h := health[2]; !h.Active || h.Successes != 1 || h.LastSuccess.IsZero() || h.Error != "" {
+		t.Errorf("metadata health = %+v, want active", h)
+	}
+	if got, err := chain.Subject(); got != nil || err != nil {
+		t.Errorf("Subject() of the metadata source = %v, %v, want no subject token", got, err)
+	}
+
+	// The next refresh goes back to the preferred source
+	file.err = nil
+	if tok, err := chain.Token(); err != nil || tok.AccessToken != "file" {
+		t.Fatalf("Token() = %v, %v, want the token of the recovered file source", tok, err)
+	}
+	health = chain.Health()
+	if !health[0].Active || health[0].Error != "" || health[2].Active {
+		t.Errorf("health = %+v, want the file source active only", health)
+	}
+	if metadata.calls != 1 {
+		t.Errorf("metadata source called %d times, want once", metadata.calls)
+	}
+	if got, err := chain.Subject(); got != claims || err != nil {
+		t.Errorf("Subject() = %v, %v, want the claims of the file source", got, err)
+	}
+
+	file.err = errors.New("file down")
+	metadata.err = errors.New("metadata down")
+	_, err = chain.Token()
+	if err == nil || !strings.Contains(err.Error(), "file: file down") || !strings.Contains(err.Error(), "metadata: metadata down") {
+		t.Errorf("Token() with every source failing error = %v, want the error of each", err)
+	}
+	if !chain.Health()[0].Active {
+		t.Error("a failed refresh should not change the active source")
+	}
+}
+
+func TestCredentialChain_Setup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || !strings.HasSuffix(r.URL.Path, "/instance/service-accounts/default/token") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"from-metadata","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	dir := t.TempDir()
+	cfg := &Config{
+		AuthMode:                 authModeChain,
+		CredentialSources:        defaultCredentialSources,
+		TokenFile:                filepath.Join(dir, "token"),
+		Audience:                 "openshift",
+		WorkloadIdentityProvider: testProvider,
+		// Outside of a cluster the TokenRequest API is not available
+		Kubeconfig:          filepath.Join(dir, "missing-kubeconfig"),
+		TokenServiceAccount: "default/wif-app-workload-sa",
+	}
+	chain, err := newCredentialChain(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("newCredentialChain() error = %v", err)
+	}
+
+	health := chain.Health()
+	if len(health) != 3 || !health[0].Available || health[1].Available || health[1].Error == "" || !health[2].Available {
+		t.Fatalf("health = %+v, want tokenrequest unavailable", health)
+	}
+
+	tok, err := chain.Token()
+	if err != nil || tok.AccessToken != "from-metadata" {
+		t.Fatalf("Token() = %v, %v, want the metadata token", tok, err)
+	}
+	if h := chain.Health()[0]; h.Failures != 1 || !strings.Contains(h.Error, "failed to read token file") {
+		t.Errorf("file health = %+v, want the missing token file", h)
+	}
+
+	cfg.WorkloadIdentityProvider = ""
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	chain, err = newCredentialChain(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("newCredentialChain() error = %v", err)
+	}
+	if h := chain.Health()[0]; h.Available || !strings.Contains(h.Error, "WIF_PROVIDER not set") {
+		t.Errorf("file health without a provider = %+v, want unavailable", h)
+	}
+}
+
+func TestStatus_CredentialSources(t *testing.T) {
+	chain := newChain([]credentialSource{
+		{name: credentialSourceFile, ts: &flakySource{err: errors.New("no token")}},
+		{name: credentialSourceMetadata, ts: &flakySource{name: "metadata"}},
+	})
+	if _, err := chain.Token(); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	s := newTestStatus()
+	s.registerChain(chain)
+
+	_, body := get(t, s.Handler(), "/metrics")
+	for _, want := range []string{
+		`wif_credential_source_active{source="file"} 0`,
+		`wif_credential_source_active{source="metadata"} 1`,
+		`wif_credential_source_available{source="file"} 1`,
+		`wif_credential_source_failures_total{source="file"} 1`,
+		`wif_credential_source_successes_total{source="metadata"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics does not contain %s", want)
+		}
+	}
+
+	_, body = get(t, s.Handler(), "/status")
+	if !strings.Contains(body, `"credentialSources"`) || !strings.Contains(body, `"error": "no token"`) {
+		t.Errorf("/status does not report the credential sources:\n%s", body)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/federation"
+	oauth2api "google.golang.org/api/oauth2/v2"
+	"google.golang.org/api/option"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+	storage "google.golang.org/api/storage/v1"
+	"google.golang.org/api/transport"
+)
+
+// Check exercises one GCP API family with the federated credentials
+type Check struct {
+	Name        string
+	Description string
+	// Roles lists the IAM roles the GCP service account needs for the check
+	Roles []string
+	// ProjectScoped checks only touch cfg.ProjectID, so running them against
+	// another tenant's project probes tenant isolation
+	ProjectScoped bool
+	Run           func(ctx context.Context, cfg *Config, opts ...option.ClientOption) error
+}
+
+// availableChecks are the checks selectable with CHECKS / -checks
+var availableChecks = []Check{
+	{
+		Name:          "compute",
+		Description:   "List Compute Engine instances",
+		Roles:         []string{"roles/compute.viewer"},
+		ProjectScoped: true,
+		Run:           listComputeInstances,
+	},
+	{
+		Name:          "storage",
+		Description:   "List Cloud Storage buckets in the project",
+		Roles:         []string{"storage.buckets.list, e.g. roles/storage.bucketViewer"},
+		ProjectScoped: true,
+		Run:           listStorageBuckets,
+	},
+	{
+		Name:        "tokeninfo",
+		Description: "Mint an access token and inspect it with the OAuth2 tokeninfo endpoint",
+		Run:         inspectAccessToken,
+	},
+	{
+		Name:        "secretmanager",
+		Description: "Access the latest version of SECRET_ID",
+		Roles:       []string{"roles/secretmanager.secretAccessor"},
+		Run:         accessSecret,
+	},
+	{
+		Name:        "signedurl",
+		Description: "Sign a V4 URL of SIGNED_URL_OBJECT with IAM signBlob and fetch the object with it",
+		Roles:       []string{"roles/storage.objectViewer", "roles/iam.serviceAccountTokenCreator on the signing service account"},
+		Run:         verifySignedURL,
+	},
+}
+
+// CheckResult is the outcome of one check run
+type CheckResult struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// selectChecks resolves a comma-separated list of check names; "all" selects every check
+func selectChecks(names string) ([]Check, error) {
+	byName := make(map[string]Check, len(availableChecks))
+	known := make([]string, 0, len(availableChecks))
+	for _, c := range availableChecks {
+		byName[c.Name] = c
+		known = append(known, c.Name)
+	}
+	sort.Strings(known)
+
+	var selected []Check
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		switch {
+		case name == "":
+			continue
+		case name == "all":
+			return availableChecks, nil
+		case seen[name]:
+			continue
+		}
+
+		c, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown check %q (available: %s, all)", name, strings.Join(known, ", "))
+		}
+		seen[name] = true
+		selected = append(selected, c)
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no checks selected (available: %s, all)", strings.Join(known, ", "))
+	}
+	return selected, nil
+}
+
+// runChecks runs every selected check with the same credentials and logs a summary.
+// It returns an error if any check failed.
+func (a *App) runChecks(ctx context.Context) error {
+	logger := component("checks")
+	logger.Info("Starting GCP API checks")
+
+	// The token manager reloads the token file only when the token-minter rewrote it
+	subject := a.tokens.Subject
+	if a.chain != nil {
+		subject = a.chain.Subject
+	}
+	claims, err := subject()
+	if err != nil {
+		a.status.RecordToken(nil, err)
+		a.status.RecordCycle(nil)
+		return fmt.Errorf("failed to read token: %w", err)
+	}
+
+	// Log token metadata without exposing the full token. The metadata
+	// source of the chain has no token to log.
+	if claims != nil {
+		err = logTokenMetadata(claims, a.cfg.Audience)
+		if err != nil {
+			logger.Warn("Token will be rejected", "token", claims, errorAttr(err))
+		}
+	}
+	a.status.RecordToken(claims, err)
+
+	if stats := a.tokens.Stats(); !stats.AccessTokenExpiry.IsZero() {
+		logger.Info("Access token state",
+			"expiresAt", stats.AccessTokenExpiry,
+			"nextRefresh", stats.NextRefresh,
+			"refreshes", stats.Refreshes,
+			"refreshFailures", stats.RefreshFailures)
+	}
+
+	results := make([]CheckResult, 0, len(a.checks))
+	for _, c := range a.checks {
+		logger.Info("Running check", "check", c.Name, "description", c.Description)
+		start := time.Now()
+		err := c.Run(ctx, a.cfg, a.opts...)
+		result := CheckResult{Name: c.Name, Err: err, Duration: time.Since(start)}
+		results = append(results, result)
+
+		if err != nil {
+			// Token fields let alerts tell a stale token apart from missing roles
+			logger.Error("Check failed",
+				"check", c.Name,
+				"durationMs", result.Duration.Milliseconds(),
+				"requiredRoles", c.Roles,
+				"token", claims,
+				errorAttr(err))
+		} else {
+			logger.Info("Check passed", "check", c.Name, "durationMs", result.Duration.Milliseconds())
+		}
+	}
+
+	a.status.RecordCycle(results)
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	logger.Info("Check cycle complete", "passed", len(results)-failed, "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
+
+// listStorageBuckets lists the Cloud Storage buckets in the project
+func listStorageBuckets(ctx context.Context, cfg *Config, opts ...option.ClientOption) error {
+	svc, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	count := 0
+	err = svc.Buckets.List(cfg.ProjectID).Fields("items(name,location)", "nextPageToken").Pages(ctx,
+		func(page *storage.Buckets) error {
+			for _, b := range page.Items {
+				count++
+				component("storage").Info("Bucket", "bucket", b.Name, "location", b.Location)
+			}
+			return nil
+		})
+	if err != nil {
+		return fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	component("storage").Info("Listed buckets", "count", count)
+	return nil
+}
+
+// inspectAccessToken mints an access token with the configured credentials
+// and asks the tokeninfo endpoint who it belongs to and what it may do
+func inspectAccessToken(ctx context.Context, cfg *Config, opts ...option.ClientOption) error {
+	creds, err := transport.Creds(ctx, append(opts, option.WithScopes(federation.CloudPlatformScope))...)
+	if err != nil {
+		return fmt.Errorf("failed to load credentials: %w", err)
+	}
+	tok, err := creds.TokenSource.Token()
+	if err != nil {
+		return fmt.Errorf("failed to exchange the federated token for an access token: %w", err)
+	}
+	logger := component("tokeninfo")
+	logger.Info("Access token minted", "type", tok.Type(), "expiresAt", tok.Expiry)
+
+	svc, err := oauth2api.NewService(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create oauth2 client: %w", err)
+	}
+	info, err := svc.Tokeninfo().AccessToken(tok.AccessToken).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("tokeninfo rejected the access token: %w", err)
+	}
+
+	email := info.Email
+	if email == "" {
+		// Federated tokens without impersonation carry no service account email
+		email = "(federated principal, no service account)"
+	}
+	logger.Info("Access token info", "email", email, "scopes", info.Scope, "expiresInSeconds", info.ExpiresIn)
+	return nil
+}
+
+// accessSecret reads the latest version of a Secret Manager secret. Only the
+// payload size is logged, never its content.
+func accessSecret(ctx context.Context, cfg *Config, opts ...option.ClientOption) error {
+	if cfg.SecretID == "" {
+		return fmt.Errorf("SECRET_ID (or -secret-id) must name the secret to access")
+	}
+
+	svc, err := secretmanager.NewService(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create secret manager client: %w", err)
+	}
+
+	name := cfg.SecretID
+	if !strings.HasPrefix(name, "projects/") {
+		name = fmt.Sprintf("projects/%s/secrets/%s/versions/latest", cfg.ProjectID, name)
+	}
+
+	resp, err := svc.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to access %s: %w", name, err)
+	}
+
+	component("secretmanager").Info("Accessed secret", "version", resp.Name, "payloadBase64Bytes", len(resp.Payload.Data))
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"google.golang.org/api/option"
+)
+
+// maxZoneListers bounds the concurrent per-zone instance listings
+const maxZoneListers = 8
+
+// zoneInstances is the outcome of listing one zone
+type zoneInstances struct {
+	zone      string
+	region    string
+	instances []*computepb.Instance
+	err       error
+}
+
+// listComputeInstances demonstrates using the Compute API with the WIF token.
+// Zones are discovered in the configured regions and listed concurrently.
+func listComputeInstances(ctx context.Context, cfg *Config, opts ...option.ClientOption) error {
+	zones, err := discoverZones(ctx, cfg, opts...)
+	if err != nil {
+		return err
+	}
+	if len(zones) == 0 {
+		return fmt.Errorf("no zones found in regions %s", cfg.Regions)
+	}
+
+	client, err := compute.NewInstancesRESTClient(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create compute client: %w", err)
+	}
+	defer client.Close()
+
+	logger := component("compute")
+	logger.Info("Listing instances", "zones", len(zones), "regions", cfg.Regions)
+
+	results := make([]zoneInstances, len(zones))
+	sem := make(chan struct{}, maxZoneListers)
+	var wg sync.WaitGroup
+	for i, zone := range zones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = listZoneInstances(ctx, client, cfg.ProjectID, zone)
+		}()
+	}
+	wg.Wait()
+
+	// Log in zone order once everything is in, so the output does not interleave
+	perRegion := map[string]int{}
+	var errs []error
+	total := 0
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		perRegion[r.region] += len(r.instances)
+		total += len(r.instances)
+		for _, instance := range r.instances {
+			logger.Info("Instance",
+				"instance", instance.GetName(),
+				"zone", r.zone,
+				"status", instance.GetStatus(),
+				"machineType", path.Base(instance.GetMachineType()))
+		}
+	}
+
+	regions := make([]string, 0, len(perRegion))
+	for region := range perRegion {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		logger.Info("Region summary", "region", region, "instances", perRegion[region])
+	}
+	logger.Info("Listed instances", "instances", total, "zones", len(zones)-len(errs))
+
+	return errors.Join(errs...)
+}
+
+// discoverZones returns the zones that are up in cfg.Regions, or in every
+// region when no regions are configured
+func discoverZones(ctx context.Context, cfg *Config, opts ...option.ClientOption) ([]*computepb.Zone, error) {
+	client, err := compute.NewZonesRESTClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zones client: %w", err)
+	}
+	defer client.Close()
+
+	regions := map[string]bool{}
+	for _, region := range strings.Split(cfg.Regions, ",") {
+		if region = strings.TrimSpace(region); region != "" && region != "all" {
+			regions[region] = true
+		}
+	}
+
+	var zones []*computepb.Zone
+	it := client.List(ctx, &computepb.ListZonesRequest{Project: cfg.ProjectID})
+	for {
+		zone, err := it.Next()
+		if err != nil {
+			if err.Error() == "no more items in iterator" {
+				break
+			}
+			return nil, fmt.Errorf("failed to list zones: %w", err)
+		}
+
+		if len(regions) > 0 && !regions[path.Base(zone.GetRegion())] {
+			continue
+		}
+		if zone.GetStatus() != "UP" {
+			component("compute").Info("Skipping zone", "zone", zone.GetName(), "status", zone.GetStatus())
+			continue
+		}
+		zones = append(zones, zone)
+	}
+
+	sort.Slice(zones, func(i, j int) bool { return zones[i].GetName() < zones[j].GetName() })
+	return zones, nil
+}
+
+// listZoneInstances lists the instances of one zone
+func listZoneInstances(ctx context.Context, client *compute.InstancesClient, project string, zone *computepb.Zone) zoneInstances {
+	result := zoneInstances{zone: zone.GetName(), region: path.Base(zone.GetRegion())}
+
+	it := client.List(ctx, &computepb.ListInstancesRequest{
+		Project: project,
+		Zone:    zone.GetName(),
+	})
+	for {
+		instance, err := it.Next()
+		if err != nil {
+			// End of list or error
+			if err.Error() != "no more items in iterator" {
+				result.err = fmt.Errorf("failed to list instances in %s: %w", result.zone, err)
+			}
+			return result
+		}
+		result.instances = append(result.instances, instance)
+	}
+}
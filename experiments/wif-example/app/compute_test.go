@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/api/option"
+)
+
+// fakeCompute serves the zones and instances list calls of the Compute REST API
+func fakeCompute(t *testing.T, failZone string) (*httptest.Server, *[]string) {
+	t.Helper()
+	zones := []map[string]string{
+		{"name": "us-central1-a", "region": "https://www.googleapis.com/compute/v1/projects/p/regions/us-central1", "status": "UP"},
+		{"name": "us-central1-b", "region": "https://www.googleapis.com/compute/v1/projects/p/regions/us-central1", "status": "UP"},
+		{"name": "us-central1-c", "region": "https://www.googleapis.com/compute/v1/projects/p/regions/us-central1", "status": "DOWN"},
+		{"name": "europe-west1-b", "region": "https://www.googleapis.com/compute/v1/projects/p/regions/europe-west1", "status": "UP"},
+	}
+	instances := map[string][]map[string]string{
+		"us-central1-a":  {{"name": "vm-1", "status": "RUNNING", "machineType": "zones/us-central1-a/machineTypes/n1-standard-1"}, {"name": "vm-2", "status": "STOPPED", "machineType": "zones/us-central1-a/machineTypes/n1-standard-2"}},
+		"europe-west1-b": {{"name": "vm-3", "status": "RUNNING", "machineType": "zones/europe-west1-b/machineTypes/e2-medium"}},
+	}
+
+	var mu sync.Mutex
+	listed := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/compute/v1/projects/p/"), "/")
+		switch {
+		case len(parts) == 1 && parts[0] == "zones":
+			json.NewEncoder(w).Encode(map[string]any{"items": zones})
+		case len(parts) == 3 && parts[0] == "zones" && parts[2] == "instances":
+			mu.Lock()
+			listed = append(listed, parts[1])
+			mu.Unlock()
+			if parts[1] == failZone {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":{"code":403,"message":"Required 'compute.instances.list' permission"}}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"items": instances[parts[1]]})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &listed
+}
+
+func TestListComputeInstances(t *testing.T) {
+	tests := []struct {
+		name       string
+		regions    string
+		failZone   string
+		wantListed []string
+		wantErr    string
+	}{
+		{name: "one region", regions: "us-central1", wantListed: []string{"us-central1-a", "us-central1-b"}},
+		{name: "all regions", regions: "all", wantListed: []string{"europe-west1-b", "us-central1-a", "us-central1-b"}},
+		{name: "several regions", regions: "europe-west1, us-central1", wantListed: []string{"europe-west1-b", "us-central1-a", "us-central1-b"}},
+		{name: "unknown region", regions: "asia-east1", wantErr: "no zones found"},
+		{name: "zone failure", regions: "us-central1", failZone: "us-central1-b", wantListed: []string{"us-central1-a", "us-central1-b"}, wantErr: "failed to list instances in us-central1-b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, listed := fakeCompute(t, tt.failZone)
+			cfg := &Config{ProjectID: "p", Regions: tt.regions}
+
+			err := listComputeInstances(context.Background(), cfg, option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("listComputeInstances() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("listComputeInstances() error = %v, want %q", err, tt.wantErr)
+			}
+
+			got := append([]string{}, *listed...)
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.wantListed, ",") {
+				t.Errorf("listed zones %v, want %v", got, tt.wantListed)
+			}
+		})
+	}
+}
+
+func TestListComputeInstances_Telemetry(t *testing.T) {
+	srv, _ := fakeCompute(t, "us-central1-b")
+	metrics := telemetry.NewMetrics()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics.Collectors()...)
+
+	opts := append(clientOptions(staticSource{}, metrics), option.WithEndpoint(srv.URL))
+	if err := listComputeInstances(context.Background(), &Config{ProjectID: "p", Regions: "us-central1"}, opts...); err == nil {
+		t.Fatal("listComputeInstances() succeeded despite the failing zone")
+	}
+
+	// One zones list and two instance lists, one of them denied
+	host := strings.TrimPrefix(srv.URL, "http://")
+	host, _, _ = strings.Cut(host, ":")
+	want := `
+# HELP wif_gcp_requests_total GCP API requests by service, HTTP method and response code (error when no response was received).
+# TYPE wif_gcp_requests_total counter
+wif_gcp_requests_total{code="200",method="GET",service="` + host + `"} 2
+wif_gcp_requests_total{code="403",method="GET",service="` + host + `"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "wif_gcp_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/api/option"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+	storage "google.golang.org/api/storage/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Schemes of CONFIG_SOURCE / -config-source
+const (
+	// configSourceSecret reads a Secret Manager secret version
+	configSourceSecret = "sm://"
+	// configSourceObject reads a Cloud Storage object
+	configSourceObject = "gs://"
+)
+
+// maxRemoteConfigBytes bounds the configuration read from CONFIG_SOURCE
+const maxRemoteConfigBytes = 64 << 10
+
+// RemoteConfig is the non-sensitive configuration the app reads from
+// CONFIG_SOURCE at startup, the way an operator reads tenant configuration.
+// Unset fields keep the value of the environment or flags.
+type RemoteConfig struct {
+	ProjectID string   `json:"projectID,omitempty"`
+	Regions   []string `json:"regions,omitempty"`
+	// Audience is the expected audience of the subject token
+	Audience string   `json:"audience,omitempty"`
+	Checks   []string `json:"checks,omitempty"`
+	SecretID string   `json:"secretID,omitempty"`
+}
+
+// bootstrapSettings are the settings the federation flow needs before it can
+// read CONFIG_SOURCE, so they can only come from the environment or flags
+var bootstrapSettings = map[string]string{
+	"tokenFile":                 "TOKEN_FILE",
+	"authMode":                  "AUTH_MODE",
+	"credentialSources":         "CREDENTIAL_SOURCES",
+	"wifProvider":               "WIF_PROVIDER",
+	"impersonateServiceAccount": "IMPERSONATE_SERVICE_ACCOUNT",
+	"subjectTokenSource":        "SUBJECT_TOKEN_SOURCE",
+	"kubeconfig":                "KUBECONFIG",
+	"tokenServiceAccount":       "TOKEN_SERVICE_ACCOUNT",
+	"configSource":              "CONFIG_SOURCE",
+}
+
+// parseRemoteConfig parses a YAML or JSON configuration. Unknown fields are
+// rejected, with a hint for the bootstrap settings.
+func parseRemoteConfig(data []byte) (*RemoteConfig, error) {
+	var fields map[string]any
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	for field := range fields {
+		if env, ok := bootstrapSettings[field]; ok {
+			return nil, fmt.Errorf("%s is needed to read the configuration, set it with %s instead", field, env)
+		}
+	}
+
+	rc := &RemoteConfig{}
+	if err := yaml.UnmarshalStrict(data, rc); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return rc, nil
+}
+
+// apply overrides the settings of cfg that rc sets. It fails when a setting
+// was already used to obtain the identity that read rc.
+func (rc *RemoteConfig) apply(cfg *Config) error {
+	if rc.Audience != "" && rc.Audience != cfg.Audience && mintsSubjectToken(cfg) {
+		// The first token was minted for TOKEN_AUDIENCE before the configuration could be read
+		return fmt.Errorf("audience %q differs from TOKEN_AUDIENCE %q the subject token was minted for, set TOKEN_AUDIENCE instead", rc.Audience, cfg.Audience)
+	}
+
+	if rc.ProjectID != "" {
+		cfg.ProjectID = rc.ProjectID
+	}
+	if len(rc.Regions) > 0 {
+		cfg.Regions = strings.Join(rc.Regions, ",")
+	}
+	if rc.Audience != "" {
+		cfg.Audience = rc.Audience
+	}
+	if len(rc.Checks) > 0 {
+		cfg.Checks = strings.Join(rc.Checks, ",")
+	}
+	if rc.SecretID != "" {
+		cfg.SecretID = rc.SecretID
+	}
+	return nil
+}
+
+// secretVersion returns the Secret Manager version of an sm:// source: a
+// secret name in GCP_PROJECT_ID, or a full secret or version resource name
+func secretVersion(name, projectID string) (string, error) {
+	if !strings.HasPrefix(name, "projects/") {
+		if projectID == "" {
+			// The project can come from the secret itself, so it cannot locate it
+			return "", fmt.Errorf("secret %q needs GCP_PROJECT_ID, or use its full resource name projects/<project>/secrets/<secret>", name)
+		}
+		name = fmt.Sprintf("projects/%s/secrets/%s", projectID, name)
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	return name, nil
+}
+
+// fetchRemoteConfig reads the configuration of source with the federated
+// identity of opts and returns it with the secret version or object
+// generation read
+func fetchRemoteConfig(ctx context.Context, source, projectID string, opts ...option.ClientOption) ([]byte, string, error) {
+	switch {
+	case strings.HasPrefix(source, configSourceSecret):
+		name, err := secretVersion(strings.TrimPrefix(source, configSourceSecret), projectID)
+		if err != nil {
+			return nil, "", err
+		}
+		svc, err := secretmanager.NewService(ctx, opts...)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create secret manager client: %w", err)
+		}
+		resp, err := svc.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to access %s: %w", name, err)
+		}
+		data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode %s: %w", resp.Name, err)
+		}
+		return data, resp.Name, nil
+
+	case strings.HasPrefix(source, configSourceObject):
+		bucket, object, ok := strings.Cut(strings.TrimPrefix(source, configSourceObject), "/")
+		if !ok || bucket == "" || object == "" {
+			return nil, "", fmt.Errorf("invalid object %q, want gs://<bucket>/<object>", source)
+		}
+		svc, err := storage.NewService(ctx, opts...)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create storage client: %w", err)
+		}
+		resp, err := svc.Objects.Get(bucket, object).Context(ctx).Download()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read %s: %w", source, err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigBytes+1))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read %s: %w", source, err)
+		}
+		if len(data) > maxRemoteConfigBytes {
+			return nil, "", fmt.Errorf("%s is larger than %d bytes", source, maxRemoteConfigBytes)
+		}
+		version := source
+		if generation := resp.Header.Get("X-Goog-Generation"); generation != "" {
+			version += "#" + generation
+		}
+		return data, version, nil
+
+	default:
+		return nil, "", fmt.Errorf("unknown config source %q, want %s<secret> or %s<bucket>/<object>", source, configSourceSecret, configSourceObject)
+	}
+}
+
+// loadRemoteConfig reads CONFIG_SOURCE with the federated identity and
+// applies it to cfg. The configuration is logged, it must not hold secrets.
+func loadRemoteConfig(ctx context.Context, cfg *Config, opts ...option.ClientOption) error {
+	data, version, err := fetchRemoteConfig(ctx, cfg.ConfigSource, cfg.ProjectID, opts...)
+	if err != nil {
+		return err
+	}
+	rc, err := parseRemoteConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", version, err)
+	}
+	if err := rc.apply(cfg); err != nil {
+		return fmt.Errorf("%s: %w", version, err)
+	}
+	component("config").Info("Loaded configuration", "source", cfg.ConfigSource, "version", version, "config", rc)
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+func TestParseRemoteConfig(t *testing.T) {
+	rc, err := parseRemoteConfig([]byte("projectID: tenant-a\nregions: [us-central1, europe-west1]\naudience: tenant-a\nchecks: [compute, storage]\n"))
+	if err != nil {
+		t.Fatalf("parseRemoteConfig() error = %v", err)
+	}
+	cfg := &Config{ProjectID: "bootstrap", Regions: "us-central1", Audience: "openshift", Checks: "compute", SecretID: "s", SubjectTokenSource: subjectTokenSourceFile}
+	if err := rc.apply(cfg); err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if cfg.ProjectID != "tenant-a" || cfg.Regions != "us-central1,europe-west1" || cfg.Audience != "tenant-a" || cfg.Checks != "compute,storage" || cfg.SecretID != "s" {
+		t.Errorf("apply() = %+v", cfg)
+	}
+
+	tests := []struct {
+		config  string
+		wantErr string
+	}{
+		{config: `{"projectID": "p", "wifProvider": "//iam.googleapis.com/x"}`, wantErr: "set it with WIF_PROVIDER"},
+		{config: "tokenFile: /tmp/token", wantErr: "set it with TOKEN_FILE"},
+		{config: "zones: [us-central1-a]", wantErr: "unknown field"},
+		{config: "regions: us-central1", wantErr: "invalid configuration"},
+	}
+	for _, tt := range tests {
+		if _, err := parseRemoteConfig([]byte(tt.config)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseRemoteConfig(%q) error = %v, want %q", tt.config, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRemoteConfigApply_MintedAudience(t *testing.T) {
+	cfg := &Config{Audience: "openshift", SubjectTokenSource: subjectTokenSourceTokenRequest}
+	if err := (&RemoteConfig{Audience: "openshift"}).apply(cfg); err != nil {
+		t.Errorf("apply() with the minted audience error = %v", err)
+	}
+	if err := (&RemoteConfig{Audience: "tenant-a"}).apply(cfg); err == nil || !strings.Contains(err.Error(), "minted for") {
+		t.Errorf("apply() with another audience error = %v, want the token to have been minted already", err)
+	}
+	if cfg.Audience != "openshift" {
+		t.Errorf("audience = %q after a failed apply", cfg.Audience)
+	}
+
+	chain := &Config{Audience: "openshift", SubjectTokenSource: subjectTokenSourceFile, AuthMode: authModeChain, CredentialSources: "file,tokenrequest"}
+	if err := (&RemoteConfig{Audience: "tenant-a"}).apply(chain); err == nil {
+		t.Error("apply() with another audience should fail when the credential chain mints tokens")
+	}
+	chain.CredentialSources = "file,metadata"
+	if err := (&RemoteConfig{Audience: "tenant-a"}).apply(chain); err != nil || chain.Audience != "tenant-a" {
+		t.Errorf("apply() = %v with audience %q, want tenant-a when nothing is minted", err, chain.Audience)
+	}
+}
+
+func TestSecretVersion(t *testing.T) {
+	tests := []struct {
+		name, project, want string
+	}{
+		{"wif-config", "p", "projects/p/secrets/wif-config/versions/latest"},
+		{"projects/q/secrets/wif-config", "", "projects/q/secrets/wif-config/versions/latest"},
+		{"projects/q/secrets/wif-config/versions/3", "p", "projects/q/secrets/wif-config/versions/3"},
+	}
+	for _, tt := range tests {
+		if got, err := secretVersion(tt.name, tt.project); err != nil || got != tt.want {
+			t.Errorf("secretVersion(%q, %q) = %q, %v, want %q", tt.name, tt.project, got, err, tt.want)
+		}
+	}
+
+	// Without a project only the secret itself could say where it is
+	if _, err := secretVersion("wif-config", ""); err == nil {
+		t.Error("secretVersion() succeeded without a project")
+	}
+}
+
+func TestLoadRemoteConfig(t *testing.T) {
+	config := "projectID: tenant-a\nregions: [europe-west1]\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/projects/bootstrap/secrets/wif-config/versions/latest:access":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"name":    "projects/123/secrets/wif-config/versions/2",
+				"payload": map[string]string{"data": base64.StdEncoding.EncodeToString([]byte(config))},
+			})
+		case strings.HasSuffix(r.URL.Path, "/b/configs/o/wif.yaml") && r.URL.Query().Get("alt") == "media":
+			w.Header().Set("X-Goog-Generation", "7")
+			w.Write([]byte(config))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"code":403,"message":"Permission denied"}}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	opts := []option.ClientOption{option.WithEndpoint(srv.URL), option.WithoutAuthentication()}
+
+	tests := []struct {
+		source      string
+		wantVersion string
+		wantErr     string
+	}{
+		{source: "sm://wif-config", wantVersion: "projects/123/secrets/wif-config/versions/2"},
+		{source: "gs://configs/wif.yaml", wantVersion: "gs://configs/wif.yaml#7"},
+		{source: "sm://other", wantErr: "failed to access projects/bootstrap/secrets/other/versions/latest"},
+		{source: "gs://configs", wantErr: "invalid object"},
+		{source: "https://example.com/wif.yaml", wantErr: "unknown config source"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			data, version, err := fetchRemoteConfig(context.Background(), tt.source, "bootstrap", opts...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("fetchRemoteConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchRemoteConfig() error = %v", err)
+			}
+			if string(data) != config || version != tt.wantVersion {
+				t.Errorf("fetchRemoteConfig() = %q, %q, want %q", data, version, tt.wantVersion)
+			}
+		})
+	}
+
+	cfg := &Config{ProjectID: "bootstrap", Regions: "us-central1", ConfigSource: "sm://wif-config"}
+	if err := loadRemoteConfig(context.Background(), cfg, opts...); err != nil {
+		t.Fatalf("loadRemoteConfig() error = %v", err)
+	}
+	if cfg.ProjectID != "tenant-a" || cfg.Regions != "europe-west1" {
+		t.Errorf("loadRemoteConfig() = %+v", cfg)
+	}
+}
@@ -0,0 +1,130 @@
+// Package credconfig reads the external-account credential configuration
+// that GOOGLE_APPLICATION_CREDENTIALS points at, checks that it still matches
+// what the app was deployed with, and reports what changed between two reads.
+// The Google client libraries re-read the file on every exchange, so a
+// ConfigMap edited under a running pod silently switches its identity.
+package credconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Values of the configuration accepted by Validate
+const (
+	TypeExternalAccount = "external_account"
+	TokenTypeJWT        = "urn:ietf:params:oauth:token-type:jwt"
+	TokenTypeIDToken    = "urn:ietf:params:oauth:token-type:id_token"
+)
+
+// Config holds the fields of an external-account credential configuration
+// the app depends on
+type Config struct {
+	Type                           string           `json:"type"`
+	Audience                       string           `json:"audience"`
+	SubjectTokenType               string           `json:"subject_token_type"`
+	TokenURL                       string           `json:"token_url"`
+	CredentialSource               CredentialSource `json:"credential_source"`
+	ServiceAccountImpersonationURL string           `json:"service_account_impersonation_url,omitempty"`
+	QuotaProjectID                 string           `json:"quota_project_id,omitempty"`
+}
+
+// CredentialSource is where the client libraries read the subject token
+type CredentialSource struct {
+	File string `json:"file,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// Parse decodes a credential configuration
+func Parse(data []byte) (*Config, error) {
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Load reads and decodes the credential configuration at path
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file %s: %w", path, err)
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Expectations are what the configuration must reference for the app to
+// authenticate as deployed
+type Expectations struct {
+	// TokenFile is the projected service account token mounted into the pod
+	TokenFile string
+	// Audience is the workload identity provider, skipped if empty
+	Audience string
+}
+
+// Validate checks c against exp and returns every mismatch found
+func (c *Config) Validate(exp Expectations) error {
+	var errs []error
+	if c.Type != TypeExternalAccount {
+		errs = append(errs, fmt.Errorf("type is %q, want %q", c.Type, TypeExternalAccount))
+	}
+	if c.SubjectTokenType != TokenTypeJWT && c.SubjectTokenType != TokenTypeIDToken {
+		errs = append(errs, fmt.Errorf("subject_token_type %q is not a JWT token type", c.SubjectTokenType))
+	}
+
+	switch {
+	case c.CredentialSource.File == "":
+		errs = append(errs, fmt.Errorf("credential_source.file is not set, the token is not read from the mounted volume"))
+	case exp.TokenFile != "" && filepath.Clean(c.CredentialSource.File) != filepath.Clean(exp.TokenFile):
+		errs = append(errs, fmt.Errorf("credential_source.file is %s, but the token is mounted at %s", c.CredentialSource.File, exp.TokenFile))
+	}
+
+	if exp.Audience != "" && c.Audience != exp.Audience {
+		errs = append(errs, fmt.Errorf("audience is %q, want provider %q", c.Audience, exp.Audience))
+	}
+	return errors.Join(errs...)
+}
+
+// Change is one field that differs between two reads of the configuration
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// String formats the change for logs
+func (c Change) String() string {
+	return fmt.Sprintf("%s changed from %q to %q", c.Field, c.Old, c.New)
+}
+
+// Diff returns the fields of new that differ from old, in file order
+func Diff(old, new *Config) []Change {
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"type", old.Type, new.Type},
+		{"audience", old.Audience, new.Audience},
+		{"subject_token_type", old.SubjectTokenType, new.SubjectTokenType},
+		{"token_url", old.TokenURL, new.TokenURL},
+		{"credential_source.file", old.CredentialSource.File, new.CredentialSource.File},
+		{"credential_source.url", old.CredentialSource.URL, new.CredentialSource.URL},
+		{"service_account_impersonation_url", old.ServiceAccountImpersonationURL, new.ServiceAccountImpersonationURL},
+		{"quota_project_id", old.QuotaProjectID, new.QuotaProjectID},
+	}
+
+	var changes []Change
+	for _, f := range fields {
+		if f.old != f.new {
+			changes = append(changes, Change{Field: f.name, Old: f.old, New: f.new})
+		}
+	}
+	return changes
+}
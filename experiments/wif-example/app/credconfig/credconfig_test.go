@@ -0,0 +1,166 @@
+package credconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	provider  = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider"
+	tokenFile = "/var/run/secrets/openshift/serviceaccount/token"
+)
+
+// generated matches the file written by infra/setup-wif-example-gcp.sh
+const generated = `{
+  "type": "external_account",
+  "audience": "` + provider + `",
+  "subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+  "token_url": "https://sts.googleapis.com/v1/token",
+  "credential_source": {
+    "file": "` + tokenFile + `"
+  },
+  "service_account_impersonation_url": "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/wif@project.iam.gserviceaccount.com:generateAccessToken"
+}`
+
+func parse(t *testing.T, data string) *Config {
+	t.Helper()
+	c, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return c
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, []byte(generated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.Audience != provider || c.CredentialSource.File != tokenFile {
+		t.Errorf("Load = %+v", c)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Load of a missing file succeeded")
+	}
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load of invalid JSON succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	exp := Expectations{TokenFile: tokenFile, Audience: provider}
+
+	if err := parse(t, generated).Validate(exp); err != nil {
+		t.Errorf("Validate of the generated file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(c *Config)
+		exp  Expectations
+		want []string
+	}{
+		{
+			name: "service account key",
+			edit: func(c *Config) { c.Type = "service_account" },
+			exp:  exp,
+			want: []string{`type is "service_account"`},
+		},
+		{
+			name: "moved token mount",
+			edit: func(c *Config) { c.CredentialSource.File = "/var/run/secrets/tokens/gcp" },
+			exp:  exp,
+			want: []string{"credential_source.file is /var/run/secrets/tokens/gcp", "mounted at " + tokenFile},
+		},
+		{
+			name: "url source",
+			edit: func(c *Config) {
+				c.CredentialSource = CredentialSource{URL: "http://169.254.169.254/token"}
+			},
+			exp:  exp,
+			want: []string{"credential_source.file is not set"},
+		},
+		{
+			name: "other provider",
+			edit: func(c *Config) { c.Audience = provider + "-2" },
+			exp:  exp,
+			want: []string{"audience is", "want provider"},
+		},
+		{
+			name: "other provider without expectation",
+			edit: func(c *Config) { c.Audience = provider + "-2" },
+			exp:  Expectations{TokenFile: tokenFile},
+		},
+		{
+			name: "equivalent token path",
+			edit: func(c *Config) { c.CredentialSource.File = tokenFile + "/" },
+			exp:  exp,
+		},
+		{
+			name: "access token subject",
+			edit: func(c *Config) {
+				c.SubjectTokenType = "urn:ietf:params:oauth:token-type:access_token"
+				c.Audience = ""
+			},
+			exp:  exp,
+			want: []string{"subject_token_type", "audience is \"\""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := parse(t, generated)
+			tt.edit(c)
+			err := c.Validate(tt.exp)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate succeeded")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("Validate = %q, want it to contain %q", err, w)
+				}
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := parse(t, generated)
+	if changes := Diff(old, parse(t, generated)); len(changes) != 0 {
+		t.Errorf("Diff of identical configs = %v", changes)
+	}
+
+	changed := parse(t, generated)
+	changed.Audience = provider + "-2"
+	changed.CredentialSource.File = "/tmp/token"
+	changed.ServiceAccountImpersonationURL = ""
+
+	changes := Diff(old, changed)
+	fields := make([]string, len(changes))
+	for i, c := range changes {
+		fields[i] = c.Field
+	}
+	want := "audience,credential_source.file,service_account_impersonation_url"
+	if got := strings.Join(fields, ","); got != want {
+		t.Errorf("Diff fields = %s, want %s", got, want)
+	}
+	if got := changes[1].String(); got != `credential_source.file changed from "`+tokenFile+`" to "/tmp/token"` {
+		t.Errorf("Change.String = %s", got)
+	}
+}
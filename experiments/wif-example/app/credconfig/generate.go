@@ -0,0 +1,76 @@
+package credconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// STSTokenURL is the token endpoint of the GCP Security Token Service
+const STSTokenURL = "https://sts.googleapis.com/v1/token"
+
+// ProviderName returns the full resource name of a workload identity
+// provider, the audience of the credential configurations using it
+func ProviderName(projectNumber, pool, provider string) string {
+	return fmt.Sprintf("//iam.googleapis.com/projects/%s/locations/global/workloadIdentityPools/%s/providers/%s",
+		projectNumber, pool, provider)
+}
+
+// ImpersonationURL returns the generateAccessToken endpoint of a GCP
+// service account
+func ImpersonationURL(email string) string {
+	return "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/" + email + ":generateAccessToken"
+}
+
+// ImpersonatedServiceAccount returns the email of the service account an
+// impersonation URL of ImpersonationURL is for
+func ImpersonatedServiceAccount(url string) (string, bool) {
+	_, rest, ok := strings.Cut(url, "/serviceAccounts/")
+	if !ok {
+		return "", false
+	}
+	email, ok := strings.CutSuffix(rest, ":generateAccessToken")
+	if !ok || !strings.Contains(email, "@") {
+		return "", false
+	}
+	return email, true
+}
+
+// Options describe the credential configuration of one workload
+type Options struct {
+	// Provider is the full resource name of the workload identity provider
+	Provider string
+	// TokenFile is where the workload's subject token is mounted
+	TokenFile string
+	// ServiceAccount is the email of the GCP service account to impersonate,
+	// empty to run as the federated principal
+	ServiceAccount string
+	// QuotaProjectID is the project billed for the API calls, optional
+	QuotaProjectID string
+}
+
+// New returns the external-account credential configuration of opts, the
+// file GOOGLE_APPLICATION_CREDENTIALS points at
+func New(opts Options) (*Config, error) {
+	if !strings.HasPrefix(opts.Provider, "//iam.googleapis.com/") || !strings.Contains(opts.Provider, "/workloadIdentityPools/") {
+		return nil, fmt.Errorf("workload identity provider %q must look like //iam.googleapis.com/projects/NUMBER/locations/global/workloadIdentityPools/POOL/providers/PROVIDER", opts.Provider)
+	}
+	if opts.ServiceAccount != "" && !strings.Contains(opts.ServiceAccount, "@") {
+		return nil, fmt.Errorf("service account %q must be an email", opts.ServiceAccount)
+	}
+
+	c := &Config{
+		Type:             TypeExternalAccount,
+		Audience:         opts.Provider,
+		SubjectTokenType: TokenTypeJWT,
+		TokenURL:         STSTokenURL,
+		CredentialSource: CredentialSource{File: opts.TokenFile},
+		QuotaProjectID:   opts.QuotaProjectID,
+	}
+	if opts.ServiceAccount != "" {
+		c.ServiceAccountImpersonationURL = ImpersonationURL(opts.ServiceAccount)
+	}
+	if err := c.Validate(Expectations{TokenFile: opts.TokenFile, Audience: opts.Provider}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
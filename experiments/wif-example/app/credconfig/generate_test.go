@@ -0,0 +1,51 @@
+package credconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	if got := ProviderName("123", "pool", "provider"); got != provider {
+		t.Errorf("ProviderName() = %q, want %q", got, provider)
+	}
+
+	c, err := New(Options{Provider: provider, TokenFile: tokenFile, ServiceAccount: "wif@project.iam.gserviceaccount.com"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// The configuration of the setup script is what New generates
+	if want := parse(t, generated); !reflect.DeepEqual(c, want) {
+		t.Errorf("New() = %+v, want %+v", c, want)
+	}
+
+	c, err = New(Options{Provider: provider, TokenFile: tokenFile, QuotaProjectID: "tenant-a"})
+	if err != nil {
+		t.Fatalf("New without impersonation: %v", err)
+	}
+	if c.ServiceAccountImpersonationURL != "" || c.QuotaProjectID != "tenant-a" {
+		t.Errorf("New() = %+v, want no impersonation and quota project tenant-a", c)
+	}
+
+	for name, opts := range map[string]Options{
+		"invalid provider":          {Provider: "projects/123/providers/provider", TokenFile: tokenFile},
+		"no token file":             {Provider: provider},
+		"service account not email": {Provider: provider, TokenFile: tokenFile, ServiceAccount: "wif"},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("%s: New() succeeded", name)
+		}
+	}
+}
+
+func TestImpersonatedServiceAccount(t *testing.T) {
+	email := "wif@project.iam.gserviceaccount.com"
+	if got, ok := ImpersonatedServiceAccount(ImpersonationURL(email)); !ok || got != email {
+		t.Errorf("ImpersonatedServiceAccount() = %q, %v, want %q", got, ok, email)
+	}
+	for _, url := range []string{"", "https://sts.googleapis.com/v1/token", "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/wif:generateAccessToken"} {
+		if got, ok := ImpersonatedServiceAccount(url); ok {
+			t.Errorf("ImpersonatedServiceAccount(%q) = %q, want none", url, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/credconfig"
+)
+
+// exitCredentialDrift is the exit code used by -exit-on-credential-drift, so
+// restarts caused by a changed credential configuration stand out
+const exitCredentialDrift = 3
+
+// credentialWatcher re-validates the external-account credential configuration
+// of the credentials-file auth mode. The client libraries re-read it on every
+// token exchange, so an edited ConfigMap or a moved token mount changes the
+// identity of the running app without a restart.
+type credentialWatcher struct {
+	path     string
+	expected credconfig.Expectations
+	// baseline is the configuration read at startup, drift is reported
+	// against it until the app restarts
+	baseline *credconfig.Config
+	interval time.Duration
+	exit     bool
+	status   *Status
+	// last is the outcome logged last, to log changes of state only
+	last string
+}
+
+// newCredentialWatcher reads the baseline configuration. The expected
+// provider is WIF_PROVIDER when set, the audience read at startup otherwise.
+func newCredentialWatcher(cfg *Config, status *Status) (*credentialWatcher, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		return nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS not set")
+	}
+	baseline, err := credconfig.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := credconfig.Expectations{TokenFile: cfg.TokenFile, Audience: cfg.WorkloadIdentityProvider}
+	if expected.Audience == "" {
+		expected.Audience = baseline.Audience
+	}
+	return &credentialWatcher{
+		path:     path,
+		expected: expected,
+		baseline: baseline,
+		interval: cfg.CredentialsCheckInterval,
+		exit:     cfg.ExitOnCredentialDrift,
+		status:   status,
+	}, nil
+}
+
+// check reads the configuration again and records how it differs from the
+// baseline and the expectations. It returns false if it does not match.
+func (w *credentialWatcher) check() bool {
+	logger := component("credentials").With("credentialsFile", w.path)
+
+	current, err := credconfig.Load(w.path)
+	var changes []credconfig.Change
+	if err == nil {
+		changes = credconfig.Diff(w.baseline, current)
+		err = current.Validate(w.expected)
+	}
+	w.status.RecordCredentials(w.path, w.expected, changes, err)
+
+	outcome := fmt.Sprint(changes, err)
+	if outcome != w.last {
+		w.last = outcome
+		for _, c := range changes {
+			logger.Error("Credential configuration changed since startup", "field", c.Field, "old", c.Old, "new", c.New)
+		}
+		if err != nil {
+			logger.Error("Credential configuration does not match the deployment",
+				"expectedTokenFile", w.expected.TokenFile, "expectedProvider", w.expected.Audience, errorAttr(err))
+		}
+		if err == nil && len(changes) == 0 {
+			logger.Info("Credential configuration matches the deployment",
+				"tokenFile", w.expected.TokenFile, "provider", w.expected.Audience)
+		}
+	}
+	return err == nil && len(changes) == 0
+}
+
+// Run checks the configuration every interval until ctx is done. With
+// exit set, the app exits with exitCredentialDrift on the first mismatch
+// so Kubernetes restarts it and the restart count records the drift.
+func (w *credentialWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if !w.check() && w.exit {
+			component("credentials").Error("Exiting on credential drift", "exitCode", exitCredentialDrift)
+			os.Exit(exitCredentialDrift)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/credconfig"
+)
+
+const testProvider = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider"
+
+func writeCredentials(t *testing.T, path string, c credconfig.Config) {
+	t.Helper()
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCredentialWatcher(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "credentials.json")
+	tokenFile := filepath.Join(dir, "token")
+	creds := credconfig.Config{
+		Type:             credconfig.TypeExternalAccount,
+		Audience:         testProvider,
+		SubjectTokenType: credconfig.TokenTypeJWT,
+		TokenURL:         "https://sts.googleapis.com/v1/token",
+		CredentialSource: credconfig.CredentialSource{File: tokenFile},
+	}
+	writeCredentials(t, path, creds)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	cfg := &Config{AuthMode: authModeCredentialsFile, TokenFile: tokenFile, Interval: 30 * time.Second, CredentialsCheckInterval: time.Minute}
+	s := NewStatus(cfg, nil)
+	s.RecordCycle([]CheckResult{{Name: "compute"}})
+	h := s.Handler()
+
+	w, err := newCredentialWatcher(cfg, s)
+	if err != nil {
+		t.Fatalf("newCredentialWatcher: %v", err)
+	}
+	if w.expected.Audience != testProvider {
+		t.Errorf("expected provider = %q, want the audience read at startup", w.expected.Audience)
+	}
+	if !w.check() {
+		t.Fatal("check of the startup configuration failed")
+	}
+	if code, _ := get(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("healthz with matching credentials: %d, want 200", code)
+	}
+	if _, body := get(t, h, "/metrics"); !strings.Contains(body, "wif_credentials_valid 1") {
+		t.Errorf("metrics with matching credentials:\n%s", body)
+	}
+
+	// The token mount moved, e.g. an edited ConfigMap
+	creds.CredentialSource.File = "/var/run/secrets/tokens/gcp"
+	writeCredentials(t, path, creds)
+	if w.check() {
+		t.Fatal("check succeeded after the token path changed")
+	}
+	code, body := get(t, h, "/healthz")
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "credential_source.file") {
+		t.Errorf("healthz after drift: %d %q, want 503 naming the field", code, body)
+	}
+	_, body = get(t, h, "/metrics")
+	for _, want := range []string{"wif_credentials_valid 0", "wif_credentials_changed_fields 1"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics after drift lack %q", want)
+		}
+	}
+
+	var resp struct {
+		Credentials CredentialsStatus `json:"credentials"`
+	}
+	_, body = get(t, h, "/status")
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("decoding /status: %v", err)
+	}
+	if len(resp.Credentials.Changes) != 1 || resp.Credentials.Changes[0].New != "/var/run/secrets/tokens/gcp" || resp.Credentials.Error == "" {
+		t.Errorf("status credentials = %+v", resp.Credentials)
+	}
+
+	// A deleted configuration fails the check as well
+	os.Remove(path)
+	if w.check() {
+		t.Error("check succeeded with the credentials file gone")
+	}
+}
+
+func TestCredentialWatcher_ExpectedProvider(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "credentials.json")
+	writeCredentials(t, path, credconfig.Config{
+		Type:             credconfig.TypeExternalAccount,
+		Audience:         testProvider + "-old",
+		SubjectTokenType: credconfig.TokenTypeJWT,
+		CredentialSource: credconfig.CredentialSource{File: "/token"},
+	})
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	cfg := &Config{AuthMode: authModeCredentialsFile, TokenFile: "/token", WorkloadIdentityProvider: testProvider, Interval: 30 * time.Second}
+	s := NewStatus(cfg, nil)
+	w, err := newCredentialWatcher(cfg, s)
+	if err != nil {
+		t.Fatalf("newCredentialWatcher: %v", err)
+	}
+	if w.check() {
+		t.Error("check succeeded with an audience other than WIF_PROVIDER")
+	}
+	if s.credentials == nil || !strings.Contains(s.credentials.Error, "want provider") {
+		t.Errorf("recorded credentials = %+v", s.credentials)
+	}
+}
@@ -0,0 +1,226 @@
+// Package diagnose turns the errors GCP returns for a misconfigured Workload
+// Identity Federation setup into actionable messages. The STS, IAM and API
+// errors are matched on their text, since most of them arrive as a bare 400
+// or 403 with the explanation only in the message.
+package diagnose
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Kind classifies a federation failure
+type Kind string
+
+const (
+	Unknown                    Kind = "unknown"
+	AudienceMismatch           Kind = "audience-mismatch"
+	IssuerMismatch             Kind = "issuer-mismatch"
+	TokenExpired               Kind = "token-expired"
+	InvalidSignature           Kind = "invalid-signature"
+	IssuerUnreachable          Kind = "issuer-unreachable"
+	AttributeConditionRejected Kind = "attribute-condition-rejected"
+	AttributeMappingEmpty      Kind = "attribute-mapping-empty"
+	ProviderNotFound           Kind = "provider-not-found"
+	ProviderDisabled           Kind = "provider-disabled"
+	APIDisabled                Kind = "api-disabled"
+	ImpersonationDenied        Kind = "impersonation-denied"
+	PermissionDenied           Kind = "permission-denied"
+	Unauthenticated            Kind = "unauthenticated"
+	QuotaExceeded              Kind = "quota-exceeded"
+)
+
+// Diagnosis explains one error
+type Diagnosis struct {
+	Kind    Kind
+	Summary string
+	Hint    string
+	// Permission and Role are set for PermissionDenied when GCP named the permission
+	Permission string
+	Role       string
+}
+
+// String formats the diagnosis for logs
+func (d Diagnosis) String() string {
+	if d.Hint == "" {
+		return fmt.Sprintf("[%s] %s", d.Kind, d.Summary)
+	}
+	return fmt.Sprintf("[%s] %s. %s", d.Kind, d.Summary, d.Hint)
+}
+
+// rule matches the lowercased error text of one kind of failure
+type rule struct {
+	kind    Kind
+	match   func(text string) bool
+	summary string
+	hint    string
+}
+
+func containsAll(words ...string) func(string) bool {
+	return func(text string) bool {
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func containsAny(words ...string) func(string) bool {
+	return func(text string) bool {
+		for _, w := range words {
+			if strings.Contains(text, w) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// rules are tried in order, the more specific ones first
+var rules = []rule{
+	{
+		kind:    QuotaExceeded,
+		match:   containsAny("ratelimitexceeded", "quotaexceeded", "resource_exhausted", "quota exceeded", "rate limit exceeded"),
+		summary: "the request was rejected for quota or rate limits",
+		hint:    "Check the API's quotas in the project and back off; this is not a federation problem",
+	},
+	{
+		kind:    APIDisabled,
+		match:   containsAny("service_disabled", "has not been used in project", "api has not been used"),
+		summary: "the API is not enabled in the project",
+		hint:    "Enable it with gcloud services enable",
+	},
+	{
+		kind:    ImpersonationDenied,
+		match:   containsAny("iam.serviceaccounts.getaccesstoken"),
+		summary: "the federated identity may not impersonate the service account",
+		hint:    "Grant roles/iam.workloadIdentityUser on the service account to the principal of the Kubernetes service account",
+	},
+	{
+		kind:    AttributeConditionRejected,
+		match:   containsAny("attribute condition"),
+		summary: "the provider's attribute condition rejected the subject",
+		hint:    "Check the token's sub and namespace against the provider's --attribute-condition",
+	},
+	{
+		kind:    AttributeMappingEmpty,
+		match:   containsAny("mapped attribute", "attribute mapping"),
+		summary: "the attribute mapping produced no google.subject",
+		hint:    "Check the provider's --attribute-mapping, e.g. google.subject=assertion.sub, against the token's claims",
+	},
+	{
+		kind:    AudienceMismatch,
+		match:   containsAll("audience", "match"),
+		summary: "the token audience is not accepted by the provider",
+		hint:    "Mint the token with one of the provider's --allowed-audiences (TOKEN_AUDIENCE)",
+	},
+	{
+		kind:    IssuerMismatch,
+		match:   containsAll("issuer", "match"),
+		summary: "the token issuer does not match the provider's issuer URI",
+		hint:    "Check the provider's --issuer-uri against the token's iss",
+	},
+	{
+		kind:    TokenExpired,
+		match:   containsAny("expired", "is stale", "token is too old"),
+		summary: "the subject token expired before the exchange",
+		hint:    "Check that the token-minter keeps refreshing the token and that the clocks agree",
+	},
+	{
+		kind:    InvalidSignature,
+		match:   containsAny("signature", "jwk", "no matching key"),
+		summary: "the token signature does not verify against the provider's JWKS",
+		hint:    "Upload the current service account signing keys to the provider (--jwk-json-path)",
+	},
+	{
+		kind: ProviderDisabled,
+		match: func(text string) bool {
+			return strings.Contains(text, "disabled") && containsAny("provider", "pool")(text)
+		},
+		summary: "the workload identity pool or provider is disabled",
+		hint:    "Enable it with gcloud iam workload-identity-pools (providers) undelete or update --no-disabled",
+	},
+	{
+		kind:    ProviderNotFound,
+		match:   containsAny("invalid_target", "does not exist", "not_found"),
+		summary: "the workload identity pool or provider does not exist",
+		hint:    "Check the provider resource name, including the project number",
+	},
+}
+
+// permissionPatterns extract the missing permission from GCP 403 messages
+var permissionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`required '([a-z0-9.]+)' permission`),
+	regexp.MustCompile(`permission '([a-z0-9.]+)' denied`),
+	regexp.MustCompile(`does not have ([a-z0-9.]+) access`),
+}
+
+// roles maps the permissions used by the example to the narrowest predefined role granting them
+var roles = map[string]string{
+	"compute.instances.list":             "roles/compute.viewer",
+	"compute.zones.list":                 "roles/compute.viewer",
+	"storage.buckets.list":               "roles/storage.bucketViewer",
+	"secretmanager.versions.access":      "roles/secretmanager.secretAccessor",
+	"iam.serviceaccounts.getaccesstoken": "roles/iam.workloadIdentityUser",
+}
+
+// RoleFor returns the predefined role granting permission, or "" if unknown
+func RoleFor(permission string) string {
+	return roles[strings.ToLower(permission)]
+}
+
+// Classify explains err. Errors matching no known failure are returned as
+// Unknown with the original message as summary.
+func Classify(err error) Diagnosis {
+	if err == nil {
+		return Diagnosis{}
+	}
+
+	text := err.Error()
+	code := 0
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		code = gerr.Code
+		if gerr.Body != "" && !strings.Contains(text, gerr.Body) {
+			text += " " + gerr.Body
+		}
+	}
+	text = strings.ToLower(text)
+
+	for _, r := range rules {
+		if r.match(text) {
+			return Diagnosis{Kind: r.kind, Summary: r.summary, Hint: r.hint}
+		}
+	}
+
+	for _, p := range permissionPatterns {
+		if m := p.FindStringSubmatch(text); m != nil {
+			d := Diagnosis{
+				Kind:       PermissionDenied,
+				Permission: m[1],
+				Role:       RoleFor(m[1]),
+				Summary:    fmt.Sprintf("the GCP identity lacks %s", m[1]),
+			}
+			if d.Role != "" {
+				d.Hint = fmt.Sprintf("Grant %s to the GCP service account", d.Role)
+			}
+			return d
+		}
+	}
+
+	switch {
+	case code == 429:
+		return Diagnosis{Kind: QuotaExceeded, Summary: "the request was rejected for quota or rate limits", Hint: "Back off and retry"}
+	case code == 403 || strings.Contains(text, "permission_denied") || strings.Contains(text, "permission denied"):
+		return Diagnosis{Kind: PermissionDenied, Summary: "the GCP identity lacks a permission", Hint: "Check the roles granted to the GCP service account"}
+	case code == 401 || strings.Contains(text, "unauthenticated") || strings.Contains(text, "invalid_grant"):
+		return Diagnosis{Kind: Unauthenticated, Summary: "GCP rejected the credentials", Hint: "Check the workload identity provider configuration"}
+	}
+	return Diagnosis{Kind: Unknown, Summary: err.Error()}
+}
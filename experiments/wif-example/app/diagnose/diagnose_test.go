@@ -0,0 +1,136 @@
+package diagnose
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+// stsError builds the OAuth-style error the STS returns, which has no message
+// outside of its body
+func stsError(description string) error {
+	return &googleapi.Error{
+		Code: 400,
+		Body: fmt.Sprintf(`{"error":"invalid_grant","error_description":%q}`, description),
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		want     Kind
+		wantRole string
+	}{
+		{
+			name: "audience mismatch",
+			err:  stsError("The audience in ID Token [openshift] does not match the expected audience."),
+			want: AudienceMismatch,
+		},
+		{
+			name: "issuer mismatch",
+			err:  stsError("The issuer in ID Token https://other-oidc does not match the expected ones."),
+			want: IssuerMismatch,
+		},
+		{
+			name: "expired token",
+			err:  fmt.Errorf("STS token exchange failed: %w", stsError("ID Token issued at 1762686000 is stale to sign-in.")),
+			want: TokenExpired,
+		},
+		{
+			name: "attribute condition",
+			err:  stsError("The given credential is rejected by the attribute condition."),
+			want: AttributeConditionRejected,
+		},
+		{
+			name: "attribute mapping",
+			err:  stsError("The mapped attribute google.subject must not be empty."),
+			want: AttributeMappingEmpty,
+		},
+		{
+			name: "signature",
+			err:  stsError("Invalid JWT signature."),
+			want: InvalidSignature,
+		},
+		{
+			name: "unknown provider",
+			err:  &googleapi.Error{Code: 400, Body: `{"error":"invalid_target","error_description":"The target service indicated by the \"audience\" parameters is invalid."}`},
+			want: ProviderNotFound,
+		},
+		{
+			name: "disabled provider",
+			err:  stsError("The workload identity pool provider is disabled."),
+			want: ProviderDisabled,
+		},
+		{
+			name: "credentials file mode",
+			err:  errors.New(`oauth2/google/externalaccount: status code 400: {"error":"invalid_grant","error_description":"The given credential is rejected by the attribute condition."}`),
+			want: AttributeConditionRejected,
+		},
+		{
+			name: "impersonation denied",
+			err:  &googleapi.Error{Code: 403, Message: "Permission 'iam.serviceAccounts.getAccessToken' denied on resource (or it may not exist)."},
+			want: ImpersonationDenied,
+		},
+		{
+			name:     "compute permission",
+			err:      fmt.Errorf("failed to list instances: %w", &googleapi.Error{Code: 403, Message: "Required 'compute.instances.list' permission for 'projects/my-project'"}),
+			want:     PermissionDenied,
+			wantRole: "roles/compute.viewer",
+		},
+		{
+			name:     "storage permission",
+			err:      &googleapi.Error{Code: 403, Message: "wif-app@my-project.iam.gserviceaccount.com does not have storage.buckets.list access to the Google Cloud project."},
+			want:     PermissionDenied,
+			wantRole: "roles/storage.bucketViewer",
+		},
+		{
+			name: "bare 403",
+			err:  &googleapi.Error{Code: 403, Message: "The caller does not have permission"},
+			want: PermissionDenied,
+		},
+		{
+			name: "api disabled",
+			err:  &googleapi.Error{Code: 403, Message: "Secret Manager API has not been used in project 123 before or it is disabled.", Errors: []googleapi.ErrorItem{{Reason: "SERVICE_DISABLED"}}},
+			want: APIDisabled,
+		},
+		{
+			name: "quota",
+			err:  &googleapi.Error{Code: 403, Message: "Quota exceeded for quota metric 'Read requests' of service 'compute.googleapis.com'", Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}},
+			want: QuotaExceeded,
+		},
+		{
+			name: "too many requests",
+			err:  &googleapi.Error{Code: 429, Message: "Too Many Requests"},
+			want: QuotaExceeded,
+		},
+		{
+			name: "unknown",
+			err:  errors.New("connection reset by peer"),
+			want: Unknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Classify(tt.err)
+			if d.Kind != tt.want {
+				t.Errorf("Classify() = %s, want %s", d, tt.want)
+			}
+			if d.Role != tt.wantRole {
+				t.Errorf("Role = %q, want %q", d.Role, tt.wantRole)
+			}
+			if d.Kind != Unknown && d.Summary == "" {
+				t.Errorf("Classify() has no summary")
+			}
+		})
+	}
+}
+
+func TestClassify_Nil(t *testing.T) {
+	if d := Classify(nil); d.Kind != "" {
+		t.Errorf("Classify(nil) = %v, want empty", d)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/diagnose"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/federation"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/token"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/proto"
+)
+
+// errSkipped is returned by diagnostic cases that lack their input
+var errSkipped = errors.New("skipped")
+
+// diagnosticCase deliberately breaks one part of the federation and checks
+// that GCP rejects it, and for the expected reason
+type diagnosticCase struct {
+	Name        string
+	Description string
+	// Expect lists the failure kinds that count as the expected rejection
+	Expect []diagnose.Kind
+	// Run returns the error GCP answered with, nil if the broken request was
+	// accepted, or an error wrapping errSkipped
+	Run func(ctx context.Context, d *diagnostics) error
+}
+
+// diagnostics is the input shared by the diagnostic cases
+type diagnostics struct {
+	cfg *Config
+	// provider is the workload identity provider of the selected auth mode
+	provider string
+	// subject is the token written by the token-minter
+	subject string
+}
+
+// diagnosticCases are run by -diagnose, in order
+var diagnosticCases = []diagnosticCase{
+	{
+		Name:        "forged-signature",
+		Description: "Exchange the current token with a corrupted signature",
+		Expect:      []diagnose.Kind{diagnose.InvalidSignature},
+		Run: func(ctx context.Context, d *diagnostics) error {
+			parts := strings.Split(strings.TrimSpace(d.subject), ".")
+			if len(parts) != 3 || len(parts[2]) < 8 {
+				return fmt.Errorf("%w: the current token is not a signed JWT", errSkipped)
+			}
+			parts[2] = strings.Repeat("A", 8) + parts[2][8:]
+			return d.exchange(ctx, d.provider, strings.Join(parts, "."))
+		},
+	},
+	{
+		Name:        "unknown-provider",
+		Description: "Exchange the current token at a provider that does not exist",
+		Expect:      []diagnose.Kind{diagnose.ProviderNotFound},
+		Run: func(ctx context.Context, d *diagnostics) error {
+			return d.exchange(ctx, d.provider+"-does-not-exist", d.subject)
+		},
+	},
+	{
+		Name:        "wrong-audience",
+		Description: "Exchange a token minted for an audience the provider does not allow",
+		Expect:      []diagnose.Kind{diagnose.AudienceMismatch},
+		Run: func(ctx context.Context, d *diagnostics) error {
+			raw, err := d.readToken("wrong-audience")
+			if err != nil {
+				return err
+			}
+			return d.exchange(ctx, d.provider, raw)
+		},
+	},
+	{
+		Name:        "expired-token",
+		Description: "Exchange a token past its exp",
+		Expect:      []diagnose.Kind{diagnose.TokenExpired},
+		Run: func(ctx context.Context, d *diagnostics) error {
+			raw, err := d.readToken("expired")
+			if err != nil {
+				return err
+			}
+			claims, err := token.Parse(raw)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errSkipped, err)
+			}
+			if err := claims.Validate(time.Now(), 0); !errors.Is(err, token.ErrExpired) {
+				return fmt.Errorf("%w: token does not expire until %s", errSkipped, claims.ExpiresAt.Format(time.RFC3339))
+			}
+			return d.exchange(ctx, d.provider, raw)
+		},
+	},
+	{
+		Name:        "unmapped-subject",
+		Description: "Exchange a token of a subject the attribute mapping or condition does not admit",
+		Expect:      []diagnose.Kind{diagnose.AttributeConditionRejected, diagnose.AttributeMappingEmpty},
+		Run: func(ctx context.Context, d *diagnostics) error {
+			raw, err := d.readToken("unmapped-subject")
+			if err != nil {
+				return err
+			}
+			return d.exchange(ctx, d.provider, raw)
+		},
+	},
+	{
+		Name:        "sa-without-roles",
+		Description: "List instances with the federated identity itself instead of the impersonated service account",
+		Expect:      []diagnose.Kind{diagnose.PermissionDenied},
+		Run: func(ctx context.Context, d *diagnostics) error {
+			ts, ok, err := unimpersonatedTokenSource(ctx, d.cfg)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%w: no service account is impersonated, the federated identity holds the roles", errSkipped)
+			}
+
+			client, err := compute.NewInstancesRESTClient(ctx, option.WithTokenSource(ts))
+			if err != nil {
+				return fmt.Errorf("failed to create compute client: %w", err)
+			}
+			defer client.Close()
+
+			_, err = client.AggregatedList(ctx, &computepb.AggregatedListInstancesRequest{
+				Project:    d.cfg.ProjectID,
+				MaxResults: proto.Uint32(1),
+			}).Next()
+			if err != nil && err.Error() == "no more items in iterator" {
+				return nil
+			}
+			return err
+		},
+	},
+}
+
+// exchange runs one STS exchange and returns its error
+func (d *diagnostics) exchange(ctx context.Context, provider, subject string) error {
+	_, err := federation.Exchange(ctx, federation.Config{Audience: provider}, subject)
+	return err
+}
+
+// readToken reads a deliberately broken token from DIAG_TOKENS_DIR
+func (d *diagnostics) readToken(name string) (string, error) {
+	if d.cfg.DiagnosticTokensDir == "" {
+		return "", fmt.Errorf("%w: set DIAG_TOKENS_DIR to a directory containing %q", errSkipped, name)
+	}
+	path := filepath.Join(d.cfg.DiagnosticTokensDir, name)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s does not exist", errSkipped, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// runDiagnostics runs every diagnostic case once and logs how GCP rejected it.
+// It returns an error if a broken request was accepted.
+func runDiagnostics(ctx context.Context, cfg *Config) error {
+	logger := component("diagnostics")
+	logger.Info("Starting federation diagnostics")
+
+	provider, err := providerAudience(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to determine the workload identity provider: %w", err)
+	}
+	subject, err := os.ReadFile(cfg.TokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read token file %s: %w", cfg.TokenFile, err)
+	}
+	d := &diagnostics{cfg: cfg, provider: provider, subject: string(subject)}
+	logger.Info("Diagnosing provider", "provider", provider)
+
+	counts := map[string]int{}
+	for _, c := range diagnosticCases {
+		caseLogger := logger.With("case", c.Name, "expected", joinKinds(c.Expect))
+		caseLogger.Info("Running case", "description", c.Description)
+		err := c.Run(ctx, d)
+
+		var result string
+		switch {
+		case errors.Is(err, errSkipped):
+			result = "SKIP"
+			caseLogger.Info("Case skipped", "result", result, "reason", err.Error())
+		case err == nil:
+			result = "FAIL"
+			caseLogger.Error("GCP accepted the broken request", "result", result)
+		default:
+			if slices.Contains(c.Expect, diagnose.Classify(err).Kind) {
+				result = "PASS"
+				caseLogger.Info("Rejected as expected", "result", result, errorAttr(err))
+			} else {
+				result = "WARN"
+				caseLogger.Warn("Rejected for another reason, the setup may be broken in another way", "result", result, errorAttr(err))
+			}
+		}
+		counts[result]++
+	}
+
+	logger.Info("Diagnostics complete",
+		"passed", counts["PASS"], "warned", counts["WARN"], "failed", counts["FAIL"], "skipped", counts["SKIP"])
+	if counts["FAIL"] > 0 {
+		return fmt.Errorf("%d broken requests were accepted", counts["FAIL"])
+	}
+	return nil
+}
+
+func joinKinds(kinds []diagnose.Kind) string {
+	names := make([]string, len(kinds))
+	for i, k := range kinds {
+		names[i] = string(k)
+	}
+	return strings.Join(names, " or ")
+}
@@ -0,0 +1,157 @@
+// Package federation performs the Workload Identity Federation token exchange
+// in-process: the projected service account token is traded for a federated
+// GCP access token at the Security Token Service, without an external-account
+// credential configuration file. The federated token can then impersonate a
+// GCP service account, see NewImpersonator.
+package federation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	sts "google.golang.org/api/sts/v1"
+)
+
+// Token types and grant defined by RFC 8693 and accepted by the GCP STS
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// CloudPlatformScope is the scope requested when Config.Scopes is empty
+const CloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// Config describes one token exchange
+type Config struct {
+	// Audience is the full resource name of the workload identity provider,
+	// "//iam.googleapis.com/projects/NUMBER/locations/global/workloadIdentityPools/POOL/providers/PROVIDER"
+	Audience string
+	// TokenFile holds the subject token. It is re-read on every exchange
+	// since the token-minter rotates it.
+	TokenFile string
+	Scopes    []string
+	// ClientOptions are passed to the STS client, e.g. to point it at a test server
+	ClientOptions []option.ClientOption
+}
+
+// Validate checks the configuration without contacting GCP
+func (c Config) Validate() error {
+	if err := c.validateAudience(); err != nil {
+		return err
+	}
+	if c.TokenFile == "" {
+		return fmt.Errorf("token file is required for the STS exchange")
+	}
+	return nil
+}
+
+func (c Config) validateAudience() error {
+	if c.Audience == "" {
+		return fmt.Errorf("workload identity provider is required for the STS exchange")
+	}
+	if !strings.HasPrefix(c.Audience, "//iam.googleapis.com/") || !strings.Contains(c.Audience, "/workloadIdentityPools/") {
+		return fmt.Errorf("workload identity provider %q must look like //iam.googleapis.com/projects/NUMBER/locations/global/workloadIdentityPools/POOL/providers/PROVIDER", c.Audience)
+	}
+	return nil
+}
+
+// tokenSource exchanges the subject token for a new access token on every call
+type tokenSource struct {
+	ctx    context.Context
+	config Config
+	sts    *sts.Service
+}
+
+// NewTokenSource returns a token source that performs the STS exchange. The
+// result is cached until shortly before expiry, so it can be shared by all clients.
+func NewTokenSource(ctx context.Context, cfg Config) (oauth2.TokenSource, error) {
+	ts, err := NewExchanger(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.ReuseTokenSource(nil, ts), nil
+}
+
+// NewExchanger returns a token source that performs a new STS exchange on
+// every call. Callers that schedule refreshes themselves use it instead of
+// NewTokenSource.
+func NewExchanger(ctx context.Context, cfg Config) (oauth2.TokenSource, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	svc, err := newService(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenSource{ctx: ctx, config: cfg, sts: svc}, nil
+}
+
+// Exchange performs a single exchange of subjectToken instead of the content
+// of cfg.TokenFile, e.g. to try deliberately broken tokens
+func Exchange(ctx context.Context, cfg Config, subjectToken string) (*oauth2.Token, error) {
+	if err := cfg.validateAudience(); err != nil {
+		return nil, err
+	}
+	svc, err := newService(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return exchange(ctx, svc, cfg, subjectToken)
+}
+
+func newService(ctx context.Context, cfg Config) (*sts.Service, error) {
+	// The exchange itself is unauthenticated: the subject token is the credential
+	opts := append([]option.ClientOption{option.WithoutAuthentication()}, cfg.ClientOptions...)
+	svc, err := sts.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create STS client: %w", err)
+	}
+	return svc, nil
+}
+
+// Token implements oauth2.TokenSource
+func (ts *tokenSource) Token() (*oauth2.Token, error) {
+	subject, err := os.ReadFile(ts.config.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subject token %s: %w", ts.config.TokenFile, err)
+	}
+	return exchange(ts.ctx, ts.sts, ts.config, string(subject))
+}
+
+func exchange(ctx context.Context, svc *sts.Service, cfg Config, subject string) (*oauth2.Token, error) {
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{CloudPlatformScope}
+	}
+
+	resp, err := svc.V1.Token(&sts.GoogleIdentityStsV1ExchangeTokenRequest{
+		GrantType:          grantTypeTokenExchange,
+		Audience:           cfg.Audience,
+		Scope:              strings.Join(scopes, " "),
+		RequestedTokenType: tokenTypeAccessToken,
+		SubjectToken:       strings.TrimSpace(subject),
+		SubjectTokenType:   tokenTypeJWT,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("STS token exchange failed: %w", err)
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("STS token exchange returned no access token")
+	}
+
+	tok := &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   resp.TokenType,
+	}
+	if resp.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
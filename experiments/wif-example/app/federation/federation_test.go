@@ -0,0 +1,205 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+const testAudience = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/hypershift-pool/providers/hypershift-provider"
+
+// fakeSTS serves the v1/token endpoint and records the exchanges it sees
+type fakeSTS struct {
+	*httptest.Server
+	calls   atomic.Int32
+	last    map[string]string
+	status  int
+	expires int
+}
+
+func newFakeSTS(t *testing.T) *fakeSTS {
+	t.Helper()
+	f := &fakeSTS{status: http.StatusOK, expires: 3600}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.calls.Add(1)
+		if r.URL.Path != "/v1/token" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&f.last); err != nil {
+			t.Errorf("decoding exchange request: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(f.status)
+		if f.status != http.StatusOK {
+			json.NewEncoder(w).Encode(map[string]any{"error": "invalid_grant", "error_description": "audience mismatch"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":      "federated-token",
+			"issued_token_type": tokenTypeAccessToken,
+			"token_type":        "Bearer",
+			"expires_in":        f.expires,
+		})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func writeToken(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing token file: %v", err)
+	}
+	return path
+}
+
+func newTestSource(t *testing.T, f *fakeSTS, tokenFile string) Config {
+	t.Helper()
+	return Config{
+		Audience:      testAudience,
+		TokenFile:     tokenFile,
+		ClientOptions: []option.ClientOption{option.WithEndpoint(f.URL + "/"), option.WithHTTPClient(f.Client())},
+	}
+}
+
+func TestTokenSource_Exchange(t *testing.T) {
+	f := newFakeSTS(t)
+	ts, err := NewTokenSource(context.Background(), newTestSource(t, f, writeToken(t, "subject-jwt\n")))
+	if err != nil {
+		t.Fatalf("NewTokenSource() error = %v", err)
+	}
+
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if tok.AccessToken != "federated-token" || tok.Type() != "Bearer" {
+		t.Errorf("token = %q/%q, want federated-token/Bearer", tok.AccessToken, tok.Type())
+	}
+	if left := time.Until(tok.Expiry); left < 59*time.Minute || left > time.Hour {
+		t.Errorf("expiry in %v, want about 1h", left)
+	}
+
+	want := map[string]string{
+		"grantType":          grantTypeTokenExchange,
+		"audience":           testAudience,
+		"scope":              CloudPlatformScope,
+		"requestedTokenType": tokenTypeAccessToken,
+		"subjectToken":       "subject-jwt",
+		"subjectTokenType":   tokenTypeJWT,
+	}
+	for field, value := range want {
+		if got := f.last[field]; got != value {
+			t.Errorf("request %s = %q, want %q", field, got, value)
+		}
+	}
+}
+
+func TestTokenSource_ReusesValidToken(t *testing.T) {
+	f := newFakeSTS(t)
+	ts, err := NewTokenSource(context.Background(), newTestSource(t, f, writeToken(t, "subject-jwt")))
+	if err != nil {
+		t.Fatalf("NewTokenSource() error = %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := ts.Token(); err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+	}
+	if got := f.calls.Load(); got != 1 {
+		t.Errorf("STS called %d times, want 1", got)
+	}
+}
+
+func TestExchanger_ExchangesEveryCall(t *testing.T) {
+	f := newFakeSTS(t)
+	ts, err := NewExchanger(context.Background(), newTestSource(t, f, writeToken(t, "subject-jwt")))
+	if err != nil {
+		t.Fatalf("NewExchanger() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := ts.Token(); err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+	}
+	if got := f.calls.Load(); got != 2 {
+		t.Errorf("STS called %d times, want 2", got)
+	}
+}
+
+func TestTokenSource_ExchangeRejected(t *testing.T) {
+	f := newFakeSTS(t)
+	f.status = http.StatusBadRequest
+	ts, err := NewTokenSource(context.Background(), newTestSource(t, f, writeToken(t, "subject-jwt")))
+	if err != nil {
+		t.Fatalf("NewTokenSource() error = %v", err)
+	}
+
+	_, err = ts.Token()
+	if err == nil || !strings.Contains(err.Error(), "STS token exchange failed") {
+		t.Errorf("Token() error = %v, want an STS exchange failure", err)
+	}
+}
+
+func TestTokenSource_MissingTokenFile(t *testing.T) {
+	f := newFakeSTS(t)
+	ts, err := NewTokenSource(context.Background(), newTestSource(t, f, filepath.Join(t.TempDir(), "missing")))
+	if err != nil {
+		t.Fatalf("NewTokenSource() error = %v", err)
+	}
+
+	if _, err := ts.Token(); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Token() error = %v, want a missing file error", err)
+	}
+	if got := f.calls.Load(); got != 0 {
+		t.Errorf("STS called %d times without a subject token", got)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg     Config
+		wantErr bool
+	}{
+		"valid":            {cfg: Config{Audience: testAudience, TokenFile: "/var/run/secrets/token"}},
+		"missing audience": {cfg: Config{TokenFile: "/var/run/secrets/token"}, wantErr: true},
+		"bare pool name":   {cfg: Config{Audience: "hypershift-pool", TokenFile: "/var/run/secrets/token"}, wantErr: true},
+		"missing token":    {cfg: Config{Audience: testAudience}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExchange(t *testing.T) {
+	f := newFakeSTS(t)
+	cfg := newTestSource(t, f, "")
+
+	if _, err := Exchange(context.Background(), cfg, "other-subject-jwt"); err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if got := f.last["subjectToken"]; got != "other-subject-jwt" {
+		t.Errorf("subjectToken = %q, want the token passed in", got)
+	}
+}
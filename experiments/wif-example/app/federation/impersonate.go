@@ -0,0 +1,115 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+// DefaultImpersonationLifetime is the lifetime requested for impersonated tokens
+const DefaultImpersonationLifetime = time.Hour
+
+// ImpersonationConfig describes how the federated identity impersonates a
+// GCP service account
+type ImpersonationConfig struct {
+	// TargetServiceAccount is the email of the service account to impersonate.
+	// The federated principal needs roles/iam.workloadIdentityUser (or
+	// roles/iam.serviceAccountTokenCreator) on it.
+	TargetServiceAccount string
+	// Delegates are intermediate service accounts, each allowed to impersonate
+	// the next, ending with TargetServiceAccount
+	Delegates []string
+	Scopes    []string
+	Lifetime  time.Duration
+	// ClientOptions are passed to the IAM Credentials client, e.g. to point it at a test server
+	ClientOptions []option.ClientOption
+}
+
+// Validate checks the configuration without contacting GCP
+func (c ImpersonationConfig) Validate() error {
+	if c.TargetServiceAccount == "" {
+		return fmt.Errorf("target service account is required for impersonation")
+	}
+	for _, sa := range append([]string{c.TargetServiceAccount}, c.Delegates...) {
+		if !strings.Contains(sa, "@") || !strings.HasSuffix(sa, ".gserviceaccount.com") {
+			return fmt.Errorf("%q is not a service account email", sa)
+		}
+	}
+	if c.Lifetime < 0 || c.Lifetime > 12*time.Hour {
+		return fmt.Errorf("impersonation lifetime %v must be at most 12h", c.Lifetime)
+	}
+	return nil
+}
+
+// impersonator mints a token for the target service account on every call,
+// authenticated with a token from base
+type impersonator struct {
+	ctx    context.Context
+	config ImpersonationConfig
+	iam    *iamcredentials.Service
+}
+
+// NewImpersonator returns a token source that calls generateAccessToken for
+// cfg.TargetServiceAccount with credentials from base, usually the federated
+// token of NewExchanger. Like NewExchanger it does not cache.
+func NewImpersonator(ctx context.Context, base oauth2.TokenSource, cfg ImpersonationConfig) (oauth2.TokenSource, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts := append([]option.ClientOption{option.WithTokenSource(base)}, cfg.ClientOptions...)
+	svc, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IAM credentials client: %w", err)
+	}
+
+	return &impersonator{ctx: ctx, config: cfg, iam: svc}, nil
+}
+
+// Token implements oauth2.TokenSource
+func (i *impersonator) Token() (*oauth2.Token, error) {
+	scopes := i.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{CloudPlatformScope}
+	}
+	lifetime := i.config.Lifetime
+	if lifetime == 0 {
+		lifetime = DefaultImpersonationLifetime
+	}
+
+	delegates := make([]string, 0, len(i.config.Delegates))
+	for _, d := range i.config.Delegates {
+		delegates = append(delegates, serviceAccountResource(d))
+	}
+
+	name := serviceAccountResource(i.config.TargetServiceAccount)
+	resp, err := i.iam.Projects.ServiceAccounts.GenerateAccessToken(name, &iamcredentials.GenerateAccessTokenRequest{
+		Delegates: delegates,
+		Scope:     scopes,
+		Lifetime:  fmt.Sprintf("%ds", int(lifetime.Seconds())),
+	}).Context(i.ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("impersonating %s failed: %w", i.config.TargetServiceAccount, err)
+	}
+
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("impersonating %s returned an invalid expiry %q: %w", i.config.TargetServiceAccount, resp.ExpireTime, err)
+	}
+	return &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
+
+// serviceAccountResource returns the resource name of a service account;
+// the "-" project wildcard lets IAM infer the project from the email
+func serviceAccountResource(email string) string {
+	return "projects/-/serviceAccounts/" + email
+}
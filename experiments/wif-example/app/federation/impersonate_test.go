@@ -0,0 +1,114 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+)
+
+const testServiceAccount = "wif-app@my-project.iam.gserviceaccount.com"
+
+func TestImpersonator(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	var (
+		path, auth string
+		req        struct {
+			Delegates []string `json:"delegates"`
+			Scope     []string `json:"scope"`
+			Lifetime  string   `json:"lifetime"`
+		}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"accessToken": "impersonated-token",
+			"expireTime":  expiry.Format(time.RFC3339),
+		})
+	}))
+	defer srv.Close()
+
+	ts, err := NewImpersonator(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "federated-token"}), ImpersonationConfig{
+		TargetServiceAccount: testServiceAccount,
+		Delegates:            []string{"delegate@my-project.iam.gserviceaccount.com"},
+		Lifetime:             30 * time.Minute,
+		ClientOptions:        []option.ClientOption{option.WithEndpoint(srv.URL + "/")},
+	})
+	if err != nil {
+		t.Fatalf("NewImpersonator() error = %v", err)
+	}
+
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if tok.AccessToken != "impersonated-token" || !tok.Expiry.Equal(expiry) {
+		t.Errorf("token = %q expiring %v, want impersonated-token expiring %v", tok.AccessToken, tok.Expiry, expiry)
+	}
+
+	if want := "/v1/projects/-/serviceAccounts/" + testServiceAccount + ":generateAccessToken"; path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+	if auth != "Bearer federated-token" {
+		t.Errorf("Authorization = %q, want the federated token", auth)
+	}
+	if req.Lifetime != "1800s" || len(req.Scope) != 1 || req.Scope[0] != CloudPlatformScope {
+		t.Errorf("lifetime/scope = %s/%v", req.Lifetime, req.Scope)
+	}
+	if len(req.Delegates) != 1 || req.Delegates[0] != "projects/-/serviceAccounts/delegate@my-project.iam.gserviceaccount.com" {
+		t.Errorf("delegates = %v", req.Delegates)
+	}
+}
+
+func TestImpersonator_Denied(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"code":403,"message":"Permission 'iam.serviceAccounts.getAccessToken' denied","status":"PERMISSION_DENIED"}}`))
+	}))
+	defer srv.Close()
+
+	ts, err := NewImpersonator(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "federated-token"}), ImpersonationConfig{
+		TargetServiceAccount: testServiceAccount,
+		ClientOptions:        []option.ClientOption{option.WithEndpoint(srv.URL + "/")},
+	})
+	if err != nil {
+		t.Fatalf("NewImpersonator() error = %v", err)
+	}
+
+	_, err = ts.Token()
+	if err == nil || !strings.Contains(err.Error(), "impersonating "+testServiceAccount) || !strings.Contains(err.Error(), "getAccessToken") {
+		t.Errorf("Token() error = %v, want the permission error", err)
+	}
+}
+
+func TestImpersonationConfig_Validate(t *testing.T) {
+	tests := map[string]struct {
+		cfg     ImpersonationConfig
+		wantErr bool
+	}{
+		"valid":             {cfg: ImpersonationConfig{TargetServiceAccount: testServiceAccount}},
+		"missing target":    {cfg: ImpersonationConfig{}, wantErr: true},
+		"not an email":      {cfg: ImpersonationConfig{TargetServiceAccount: "wif-app"}, wantErr: true},
+		"bad delegate":      {cfg: ImpersonationConfig{TargetServiceAccount: testServiceAccount, Delegates: []string{"user@example.com"}}, wantErr: true},
+		"lifetime too long": {cfg: ImpersonationConfig{TargetServiceAccount: testServiceAccount, Lifetime: 24 * time.Hour}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
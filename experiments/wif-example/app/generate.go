@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/credconfig"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// generateCommand is the subcommand writing the WIF plumbing of a workload
+const generateCommand = "generate-credentials"
+
+// credentialsKey is the key of the credential configuration in the Secret
+const credentialsKey = "credentials.json"
+
+// minProjectedTokenExpiration is the shortest expiration the kubelet accepts
+// for a projected service account token
+const minProjectedTokenExpiration = 10 * time.Minute
+
+// generateOptions are the flags of the generate-credentials subcommand
+type generateOptions struct {
+	ProjectNumber  string
+	Pool           string
+	Provider       string
+	ServiceAccount string
+	QuotaProject   string
+	Name           string
+	Namespace      string
+	Container      string
+	Audience       string
+	MountPath      string
+	// TokenFile is an existing token, e.g. written by the token-minter;
+	// empty projects a service account token next to the configuration
+	TokenFile       string
+	TokenExpiration time.Duration
+	OutputDir       string
+}
+
+// podPatch is a strategic merge patch adding the WIF volume, mount and
+// environment to the pod template of a Deployment, StatefulSet or Job
+type podPatch struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Volumes    []corev1.Volume  `json:"volumes"`
+				Containers []containerPatch `json:"containers"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+type containerPatch struct {
+	Name         string               `json:"name"`
+	Env          []corev1.EnvVar      `json:"env"`
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts"`
+}
+
+// runGenerate runs the generate-credentials subcommand with the arguments
+// following it
+func runGenerate(args []string, stdout io.Writer) error {
+	opts := generateOptions{}
+	fs := flag.NewFlagSet(generateCommand, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: wif-example %s -project-number NUMBER -pool POOL -provider PROVIDER [flags]\n\n", generateCommand)
+		fmt.Fprintln(fs.Output(), "Writes the external-account credential configuration of a workload, the Secret holding it and a patch mounting it with the workload's token.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.ProjectNumber, "project-number", "", "Number of the project of the workload identity pool")
+	fs.StringVar(&opts.Pool, "pool", "", "Workload identity pool ID")
+	fs.StringVar(&opts.Provider, "provider", "", "Workload identity provider ID, or its full resource name")
+	fs.StringVar(&opts.ServiceAccount, "service-account", "", "Email of the GCP service account to impersonate, empty to run as the federated principal")
+	fs.StringVar(&opts.QuotaProject, "quota-project", "", "Project billed for the API calls, the tenant project of -tenants-dir")
+	fs.StringVar(&opts.Name, "name", "gcp-wif-credentials", "Name of the Secret and prefix of the files written")
+	fs.StringVar(&opts.Namespace, "namespace", "default", "Namespace of the workload")
+	fs.StringVar(&opts.Container, "container", "wif-app", "Container of the workload to patch")
+	fs.StringVar(&opts.Audience, "audience", "openshift", "Audience of the projected token, one the provider allows")
+	fs.StringVar(&opts.MountPath, "mount-path", "/var/run/secrets/gcp", "Directory the configuration and projected token are mounted at")
+	fs.StringVar(&opts.TokenFile, "token-file", "", "Read an existing token, e.g. written by the token-minter sidecar, instead of projecting one")
+	fs.DurationVar(&opts.TokenExpiration, "token-expiration", time.Hour, "Expiration of the projected token")
+	fs.StringVar(&opts.OutputDir, "output-dir", ".", "Directory the files are written to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	files, err := generateCredentials(opts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.OutputDir, 0o755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		p := filepath.Join(opts.OutputDir, f.name)
+		if err := os.WriteFile(p, f.data, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Wrote %s\n", p)
+	}
+
+	fmt.Fprintf(stdout, "\nApply them to the workload with:\n")
+	fmt.Fprintf(stdout, "  kubectl apply -f %s\n", filepath.Join(opts.OutputDir, opts.Name+"-secret.yaml"))
+	fmt.Fprintf(stdout, "  kubectl patch -n %s deployment/<name> --patch-file %s\n", opts.Namespace, filepath.Join(opts.OutputDir, opts.Name+"-patch.yaml"))
+	return nil
+}
+
+// generatedFile is a file written by generate-credentials
+type generatedFile struct {
+	name string
+	data []byte
+}
+
+// generateCredentials returns the credential configuration, Secret and pod
+// patch of opts
+func generateCredentials(opts generateOptions) ([]generatedFile, error) {
+	provider := opts.Provider
+	if !strings.HasPrefix(provider, "//") {
+		if opts.ProjectNumber == "" || opts.Pool == "" || provider == "" {
+			return nil, errors.New("-project-number, -pool and -provider are required, or -provider as a full resource name")
+		}
+		provider = credconfig.ProviderName(opts.ProjectNumber, opts.Pool, provider)
+	}
+	if opts.Name == "" || opts.Namespace == "" || opts.Container == "" {
+		return nil, errors.New("-name, -namespace and -container must not be empty")
+	}
+	if !path.IsAbs(opts.MountPath) {
+		return nil, fmt.Errorf("-mount-path %q must be absolute", opts.MountPath)
+	}
+
+	projected := opts.TokenFile == ""
+	tokenFile := opts.TokenFile
+	if projected {
+		if opts.Audience == "" {
+			return nil, errors.New("-audience is required for the projected token")
+		}
+		if opts.TokenExpiration < minProjectedTokenExpiration {
+			return nil, fmt.Errorf("-token-expiration must be at least %s", minProjectedTokenExpiration)
+		}
+		tokenFile = path.Join(opts.MountPath, "token")
+	}
+
+	cc, err := credconfig.New(credconfig.Options{
+		Provider:       provider,
+		TokenFile:      tokenFile,
+		ServiceAccount: opts.ServiceAccount,
+		QuotaProjectID: opts.QuotaProject,
+	})
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := json.MarshalIndent(cc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	credentials = append(credentials, '\n')
+
+	secret, err := yaml.Marshal(&corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: opts.Name, Namespace: opts.Namespace},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: map[string]string{credentialsKey: string(credentials)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := yaml.Marshal(credentialsPatch(opts, projected, tokenFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return []generatedFile{
+		{name: opts.Name + ".json", data: credentials},
+		{name: opts.Name + "-secret.yaml", data: secret},
+		{name: opts.Name + "-patch.yaml", data: patch},
+	}, nil
+}
+
+// credentialsPatch mounts the Secret, and the projected token unless the
+// token is read from an existing file, into the container and points the
+// client libraries and the example app at them
+func credentialsPatch(opts generateOptions, projected bool, tokenFile string) *podPatch {
+	volume := corev1.Volume{Name: opts.Name}
+	if projected {
+		expiration := int64(opts.TokenExpiration.Seconds())
+		volume.Projected = &corev1.ProjectedVolumeSource{
+			Sources: []corev1.VolumeProjection{
+				{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+					Audience:          opts.Audience,
+					ExpirationSeconds: &expiration,
+					Path:              "token",
+				}},
+				{Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: opts.Name},
+					Items:                []corev1.KeyToPath{{Key: credentialsKey, Path: credentialsKey}},
+				}},
+			},
+		}
+	} else {
+		volume.Secret = &corev1.SecretVolumeSource{SecretName: opts.Name}
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "GOOGLE_APPLICATION_CREDENTIALS", Value: path.Join(opts.MountPath, credentialsKey)},
+		{Name: "TOKEN_FILE", Value: tokenFile},
+	}
+	if projected {
+		env = append(env, corev1.EnvVar{Name: "TOKEN_AUDIENCE", Value: opts.Audience})
+	}
+
+	p := &podPatch{}
+	p.Spec.Template.Spec.Volumes = []corev1.Volume{volume}
+	p.Spec.Template.Spec.Containers = []containerPatch{{
+		Name:         opts.Container,
+		Env:          env,
+		VolumeMounts: []corev1.VolumeMount{{Name: opts.Name, MountPath: opts.MountPath, ReadOnly: true}},
+	}}
+	return p
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/credconfig"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRunGenerate(t *testing.T) {
+	dir := t.TempDir()
+	var out strings.Builder
+	err := runGenerate([]string{
+		"-project-number", "123", "-pool", "pool", "-provider", "provider",
+		"-service-account", "wif@project.iam.gserviceaccount.com",
+		"-namespace", "tenant-a", "-output-dir", dir,
+	}, &out)
+	if err != nil {
+		t.Fatalf("runGenerate: %v", err)
+	}
+
+	cc, err := credconfig.Load(filepath.Join(dir, "gcp-wif-credentials.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Validate(credconfig.Expectations{TokenFile: "/var/run/secrets/gcp/token", Audience: testProvider}); err != nil {
+		t.Errorf("generated configuration: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "gcp-wif-credentials-secret.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secret corev1.Secret
+	if err := yaml.UnmarshalStrict(data, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Kind != "Secret" || secret.Namespace != "tenant-a" {
+		t.Errorf("secret = %s/%s in %s, want a Secret in tenant-a", secret.APIVersion, secret.Kind, secret.Namespace)
+	}
+	inSecret, err := credconfig.Parse([]byte(secret.StringData[credentialsKey]))
+	if err != nil || *inSecret != *cc {
+		t.Errorf("secret holds %+v, %v, want the generated configuration", inSecret, err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "gcp-wif-credentials-patch.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patch podPatch
+	if err := yaml.UnmarshalStrict(data, &patch); err != nil {
+		t.Fatal(err)
+	}
+	volumes := patch.Spec.Template.Spec.Volumes
+	if len(volumes) != 1 || volumes[0].Projected == nil || len(volumes[0].Projected.Sources) != 2 {
+		t.Fatalf("volumes = %+v, want one projected volume with the token and the Secret", volumes)
+	}
+	if sa := volumes[0].Projected.Sources[0].ServiceAccountToken; sa == nil || sa.Audience != "openshift" || *sa.ExpirationSeconds != 3600 {
+		t.Errorf("token projection = %+v, want audience openshift for 1h", sa)
+	}
+	if !strings.Contains(out.String(), "kubectl patch -n tenant-a") {
+		t.Errorf("output = %q, want the kubectl commands", out.String())
+	}
+}
+
+func TestGenerateCredentials_TokenFile(t *testing.T) {
+	opts := generateOptions{
+		Provider:  testProvider,
+		Name:      "gcp-wif-credentials",
+		Namespace: "default",
+		Container: "wif-app",
+		MountPath: "/var/run/secrets/gcp",
+		TokenFile: "/var/run/secrets/openshift/serviceaccount/token",
+	}
+	files, err := generateCredentials(opts)
+	if err != nil {
+		t.Fatalf("generateCredentials: %v", err)
+	}
+	if !strings.Contains(string(files[0].data), opts.TokenFile) {
+		t.Errorf("credentials = %s, want the token-minter's token file", files[0].data)
+	}
+	var patch podPatch
+	if err := yaml.Unmarshal(files[2].data, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if v := patch.Spec.Template.Spec.Volumes[0]; v.Secret == nil || v.Projected != nil {
+		t.Errorf("volume = %+v, want the Secret only", v)
+	}
+}
+
+func TestGenerateCredentials_Invalid(t *testing.T) {
+	valid := generateOptions{
+		ProjectNumber:   "123",
+		Pool:            "pool",
+		Provider:        "provider",
+		Name:            "gcp-wif-credentials",
+		Namespace:       "default",
+		Container:       "wif-app",
+		Audience:        "openshift",
+		MountPath:       "/var/run/secrets/gcp",
+		TokenExpiration: time.Hour,
+	}
+	for name, modify := range map[string]func(*generateOptions){
+		"no pool":                      func(o *generateOptions) { o.Pool = "" },
+		"relative mount":               func(o *generateOptions) { o.MountPath = "secrets/gcp" },
+		"short expiration":             func(o *generateOptions) { o.TokenExpiration = time.Minute },
+		"no audience":                  func(o *generateOptions) { o.Audience = "" },
+		"invalid provider":             func(o *generateOptions) { o.Provider = "//example.com/provider" },
+		"service account not an email": func(o *generateOptions) { o.ServiceAccount = "wif" },
+	} {
+		opts := valid
+		modify(&opts)
+		if _, err := generateCredentials(opts); err == nil {
+			t.Errorf("%s: generateCredentials() succeeded", name)
+		}
+	}
+	if _, err := generateCredentials(valid); err != nil {
+		t.Errorf("generateCredentials() error = %v", err)
+	}
+}
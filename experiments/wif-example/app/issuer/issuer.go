@@ -0,0 +1,333 @@
+// Package issuer checks a projected service account token against its issuer
+// and the workload identity pool provider before the token is exchanged:
+// it fetches the issuer's OIDC discovery document and JWKS, verifies the
+// token's signature and audience locally, and compares them with the
+// provider's configuration. Each mismatch is reported as the diagnosis the
+// STS would otherwise fail the exchange with, see package diagnose.
+package issuer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/diagnose"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/token"
+)
+
+// discoveryPath is where an OIDC issuer serves its discovery document
+const discoveryPath = "/.well-known/openid-configuration"
+
+// maxDocumentSize bounds the discovery documents and key sets read
+const maxDocumentSize = 1 << 20
+
+// Flavor is the kind of cluster that issued a token
+type Flavor string
+
+const (
+	// FlavorGKE issuers are public and rotate their keys themselves
+	FlavorGKE Flavor = "gke"
+	// FlavorOpenShift covers OpenShift, HyperShift and other Kubernetes
+	// issuers, public only if their discovery document is published
+	FlavorOpenShift Flavor = "openshift"
+)
+
+// FlavorOf returns the flavor of an issuer URL
+func FlavorOf(iss string) Flavor {
+	if strings.HasPrefix(iss, "https://container.googleapis.com/") {
+		return FlavorGKE
+	}
+	return FlavorOpenShift
+}
+
+// Discovery is the part of an OIDC discovery document GCP reads
+type Discovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// Verifier checks tokens against their issuer and provider
+type Verifier struct {
+	// Client fetches the discovery documents and key sets, http.DefaultClient if nil
+	Client *http.Client
+	// Now returns the current time, time.Now if nil
+	Now func() time.Time
+	// Leeway is the clock skew allowed for exp and nbf
+	Leeway time.Duration
+
+	// public reports whether GCP can reach a URL, isPublic if nil
+	public func(rawURL string) bool
+}
+
+// Input is what one verification checks
+type Input struct {
+	// Token is the raw projected service account token
+	Token string
+	// ProviderName is the provider the token is exchanged at, as in the
+	// credential configuration: "//iam.googleapis.com/projects/NUMBER/..."
+	ProviderName string
+	// Provider is the provider's configuration, nil when it could not be read.
+	// Without it, the token is checked against the issuer and the default
+	// audiences of ProviderName only.
+	Provider *Provider
+	// JWKS is a local key set, e.g. the jwks.json of 3-extract-jwks.sh. It
+	// stands for the uploaded keys when Provider is nil.
+	JWKS *JWKS
+}
+
+// Report is the outcome of a verification
+type Report struct {
+	Claims *token.Claims
+	Flavor Flavor
+	// Discovery is the issuer's discovery document, nil if it was not fetched
+	Discovery *Discovery
+	// DiscoveryErr is why the discovery document or the issuer's JWKS could
+	// not be fetched
+	DiscoveryErr error
+	// KeySource names the key set the signature was verified against, empty
+	// if none was available
+	KeySource string
+	// Verified is true once the signature verified against KeySource
+	Verified bool
+	// Findings are the mismatches found, in the order GCP checks them
+	Findings []diagnose.Diagnosis
+}
+
+// OK reports whether the verification found no mismatch
+func (r *Report) OK() bool {
+	return len(r.Findings) == 0
+}
+
+func (r *Report) add(kind diagnose.Kind, summary, hint string) {
+	r.Findings = append(r.Findings, diagnose.Diagnosis{Kind: kind, Summary: summary, Hint: hint})
+}
+
+// Verify checks the token the way the STS does when it is exchanged. It
+// returns an error only for tokens it cannot decode; mismatches are findings.
+func (v *Verifier) Verify(ctx context.Context, in Input) (*Report, error) {
+	claims, err := token.Parse(in.Token)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{Claims: claims, Flavor: FlavorOf(claims.Issuer)}
+
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	if err := claims.Validate(now(), v.Leeway); err != nil {
+		r.add(diagnose.TokenExpired, err.Error(), "Check that the token-minter is refreshing the token file")
+	}
+
+	p := in.Provider
+	if p != nil {
+		if p.Disabled || (p.State != "" && p.State != "ACTIVE") {
+			r.add(diagnose.ProviderDisabled, fmt.Sprintf("provider %s is disabled or deleted", p.Name),
+				"Re-enable or undelete the workload identity pool provider")
+		}
+		v.checkIssuer(r, p)
+	}
+	v.checkAudience(r, in)
+
+	uploaded, uploadedSource := in.JWKS, "local JWKS"
+	if p != nil {
+		uploaded, uploadedSource = p.JWKS, "provider JWKS"
+	}
+	issuerKeys := v.discover(ctx, r, uploaded != nil)
+	v.checkReachable(r, uploaded != nil)
+
+	switch {
+	case uploaded != nil:
+		r.KeySource = uploadedSource
+		v.checkSignature(r, in.Token, uploaded, issuerKeys)
+	case issuerKeys != nil:
+		r.KeySource = "issuer JWKS"
+		v.checkSignature(r, in.Token, issuerKeys, nil)
+	}
+	return r, nil
+}
+
+// checkIssuer compares the token's iss with the provider's issuer URI. The
+// STS compares them as strings, so a trailing slash is a mismatch.
+func (v *Verifier) checkIssuer(r *Report, p *Provider) {
+	iss := r.Claims.Issuer
+	if p.IssuerURI == iss {
+		return
+	}
+	hint := fmt.Sprintf("Set the provider's --issuer-uri to %s", iss)
+	if strings.TrimSuffix(p.IssuerURI, "/") == strings.TrimSuffix(iss, "/") {
+		hint = fmt.Sprintf("The URLs differ only by a trailing slash. %s", hint)
+	}
+	r.add(diagnose.IssuerMismatch, fmt.Sprintf("token issuer %s does not match the provider issuer %s", iss, p.IssuerURI), hint)
+}
+
+// checkAudience checks that one of the token's audiences is accepted by the provider
+func (v *Verifier) checkAudience(r *Report, in Input) {
+	var accepted []string
+	switch {
+	case in.Provider != nil:
+		accepted = in.Provider.Audiences()
+	case in.ProviderName != "":
+		accepted = DefaultAudiences(in.ProviderName)
+	default:
+		return
+	}
+	for _, aud := range r.Claims.Audience {
+		if slices.Contains(accepted, aud) {
+			return
+		}
+	}
+
+	hint := fmt.Sprintf("Mint the token with audience %s", accepted[0])
+	if in.Provider != nil && len(in.Provider.AllowedAudiences) == 0 && len(r.Claims.Audience) > 0 {
+		hint = fmt.Sprintf("Add %s to the provider's --allowed-audiences, or mint the token with audience %s", r.Claims.Audience[0], accepted[0])
+	}
+	r.add(diagnose.AudienceMismatch,
+		fmt.Sprintf("token audiences %s include none of the audiences the provider accepts: %s",
+			strings.Join(r.Claims.Audience, ", "), strings.Join(accepted, ", ")),
+		hint)
+}
+
+// discover fetches the issuer's discovery document and JWKS. It returns nil,
+// with r.DiscoveryErr set, if either cannot be fetched. The document is only
+// checked when GCP reads it, i.e. when no keys are uploaded.
+func (v *Verifier) discover(ctx context.Context, r *Report, uploaded bool) *JWKS {
+	iss := r.Claims.Issuer
+	var d Discovery
+	if err := v.getJSON(ctx, strings.TrimSuffix(iss, "/")+discoveryPath, &d); err != nil {
+		r.DiscoveryErr = fmt.Errorf("failed to fetch the discovery document: %w", err)
+		return nil
+	}
+	r.Discovery = &d
+	if d.Issuer != iss && !uploaded {
+		r.add(diagnose.IssuerMismatch,
+			fmt.Sprintf("the discovery document of %s names issuer %s", iss, d.Issuer),
+			"The issuer in the discovery document must match the iss claim exactly; fix the cluster's --service-account-issuer or the published document")
+	}
+	if d.JWKSURI == "" {
+		r.DiscoveryErr = fmt.Errorf("the discovery document has no jwks_uri")
+		return nil
+	}
+
+	var keys JWKS
+	if err := v.getJSON(ctx, d.JWKSURI, &keys); err != nil {
+		r.DiscoveryErr = fmt.Errorf("failed to fetch the JWKS: %w", err)
+		return nil
+	}
+	if len(keys.Keys) == 0 {
+		r.DiscoveryErr = fmt.Errorf("the JWKS at %s has no keys", d.JWKSURI)
+		return nil
+	}
+	return &keys
+}
+
+// checkReachable reports issuers GCP cannot fetch the keys of when none are
+// uploaded. The app may reach an in-cluster issuer that GCP cannot.
+func (v *Verifier) checkReachable(r *Report, uploaded bool) {
+	if uploaded {
+		return
+	}
+	public := isPublic
+	if v.public != nil {
+		public = v.public
+	}
+	hint := "Upload the cluster's JWKS to the provider with --jwk-json-path, see hosted-cluster-setup/3-extract-jwks.sh, or publish the discovery document at a public URL"
+	if r.Flavor == FlavorGKE {
+		hint = "Check that the issuer URL names an existing cluster"
+	}
+	switch {
+	case r.DiscoveryErr != nil:
+		r.add(diagnose.IssuerUnreachable, fmt.Sprintf("GCP cannot fetch the keys of %s: %v", r.Claims.Issuer, r.DiscoveryErr), hint)
+	case !public(r.Claims.Issuer):
+		r.add(diagnose.IssuerUnreachable, fmt.Sprintf("issuer %s is only reachable from within the cluster or network", r.Claims.Issuer), hint)
+	case r.Discovery != nil && !public(r.Discovery.JWKSURI):
+		r.add(diagnose.IssuerUnreachable, fmt.Sprintf("the JWKS of %s at %s is only reachable from within the cluster or network", r.Claims.Issuer, r.Discovery.JWKSURI), hint)
+	}
+}
+
+// checkSignature verifies the token against the keys GCP uses. issuerKeys,
+// when not nil, are the issuer's current keys, used to tell a stale
+// uploaded JWKS from a token signed by another cluster.
+func (v *Verifier) checkSignature(r *Report, raw string, keys, issuerKeys *JWKS) {
+	err := keys.Verify(raw)
+	if err == nil {
+		r.Verified = true
+		return
+	}
+
+	summary := fmt.Sprintf("the token does not verify against the %s: %v", r.KeySource, err)
+	var hint string
+	switch {
+	case errors.Is(err, ErrUnsupportedAlgorithm):
+		hint = "Configure the cluster to sign service account tokens with an RSA or P-256 key"
+	case issuerKeys != nil && issuerKeys.Verify(raw) == nil:
+		hint = "The issuer's current keys verify the token, so the uploaded JWKS is stale: re-upload it with --jwk-json-path"
+		if r.Flavor == FlavorGKE {
+			hint = "GKE rotates its signing keys: remove the uploaded JWKS so GCP fetches the current keys from the issuer"
+		}
+	case r.KeySource == "issuer JWKS":
+		hint = "The token was not signed by the issuer it names; check the cluster's service account signing key"
+	default:
+		hint = "Re-extract the JWKS from the cluster's current service account signing key and upload it with --jwk-json-path"
+	}
+	r.add(diagnose.InvalidSignature, summary, hint)
+}
+
+// getJSON fetches and decodes a JSON document
+func (v *Verifier) getJSON(ctx context.Context, rawURL string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize))
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", rawURL, err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("GET %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// isPublic reports whether GCP can plausibly reach a URL: over HTTPS, at a
+// host that is neither a cluster-internal name nor a private address
+func isPublic(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast()
+	}
+	if !strings.Contains(host, ".") {
+		return false
+	}
+	for _, suffix := range []string{".svc", ".cluster.local", ".local", ".internal"} {
+		if strings.HasSuffix(host, suffix) {
+			return false
+		}
+	}
+	return true
+}
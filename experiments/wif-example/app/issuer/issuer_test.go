@@ -0,0 +1,272 @@
+package issuer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/diagnose"
+)
+
+const providerName = "projects/123/locations/global/workloadIdentityPools/pool/providers/hcp"
+
+// fakeIssuer serves an OIDC discovery document and JWKS the way a published
+// HyperShift issuer does
+type fakeIssuer struct {
+	*httptest.Server
+	// issuer is the issuer named in the discovery document, the server URL by default
+	issuer string
+	keys   JWKS
+}
+
+func newFakeIssuer(t *testing.T, keys ...JWK) *fakeIssuer {
+	t.Helper()
+	f := &fakeIssuer{keys: JWKS{Keys: keys}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Discovery{Issuer: f.issuer, JWKSURI: f.URL + "/openid/v1/jwks"})
+	})
+	mux.HandleFunc("GET /openid/v1/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(f.keys)
+	})
+	f.Server = httptest.NewServer(mux)
+	f.issuer = f.URL
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeIssuer) verifier() *Verifier {
+	return &Verifier{
+		Client: f.Client(),
+		Now:    func() time.Time { return now },
+		public: func(string) bool { return true },
+	}
+}
+
+func (f *fakeIssuer) provider(keys ...JWK) *Provider {
+	p := &Provider{Name: providerName, IssuerURI: f.URL, AllowedAudiences: []string{"openshift"}, State: "ACTIVE"}
+	if len(keys) > 0 {
+		p.JWKS = &JWKS{Keys: keys}
+	}
+	return p
+}
+
+func kinds(r *Report) []diagnose.Kind {
+	var ks []diagnose.Kind
+	for _, f := range r.Findings {
+		ks = append(ks, f.Kind)
+	}
+	return ks
+}
+
+func TestVerify(t *testing.T) {
+	current, rotated := rsaKey(t), rsaKey(t)
+	f := newFakeIssuer(t, jwk("current", current))
+	raw := sign(t, jwt.SigningMethodRS256, "current", current, serviceAccountClaims(f.URL, "openshift"))
+
+	report, err := f.verifier().Verify(context.Background(), Input{Token: raw, ProviderName: "//iam.googleapis.com/" + providerName, Provider: f.provider()})
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !report.OK() || !report.Verified || report.KeySource != "issuer JWKS" {
+		t.Errorf("Verify() = verified %v against %q, findings %v", report.Verified, report.KeySource, report.Findings)
+	}
+	if report.Flavor != FlavorOpenShift || report.Discovery == nil || report.Discovery.Issuer != f.URL {
+		t.Errorf("Verify() flavor = %s, discovery = %+v", report.Flavor, report.Discovery)
+	}
+
+	t.Run("stale uploaded JWKS", func(t *testing.T) {
+		report, err := f.verifier().Verify(context.Background(), Input{Token: raw, Provider: f.provider(jwk("old", rotated))})
+		if err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		if got := kinds(report); !slices.Equal(got, []diagnose.Kind{diagnose.InvalidSignature}) {
+			t.Fatalf("findings = %v, want invalid-signature", report.Findings)
+		}
+		if report.KeySource != "provider JWKS" || !strings.Contains(report.Findings[0].Hint, "stale") {
+			t.Errorf("finding = %v against %q, want a stale JWKS hint", report.Findings[0], report.KeySource)
+		}
+	})
+}
+
+func TestVerifyFindings(t *testing.T) {
+	key, other := rsaKey(t), rsaKey(t)
+
+	tests := []struct {
+		name string
+		// setup adjusts the issuer and returns the input to verify
+		setup func(f *fakeIssuer) Input
+		want  []diagnose.Kind
+		// wantHint is part of the first finding's hint
+		wantHint string
+	}{
+		{
+			name: "issuer with trailing slash",
+			setup: func(f *fakeIssuer) Input {
+				p := f.provider()
+				p.IssuerURI += "/"
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "openshift")), Provider: p}
+			},
+			want:     []diagnose.Kind{diagnose.IssuerMismatch},
+			wantHint: "trailing slash",
+		},
+		{
+			name: "audience not allowed",
+			setup: func(f *fakeIssuer) Input {
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "sts.googleapis.com")), Provider: f.provider()}
+			},
+			want:     []diagnose.Kind{diagnose.AudienceMismatch},
+			wantHint: "audience openshift",
+		},
+		{
+			name: "default audiences",
+			setup: func(f *fakeIssuer) Input {
+				p := f.provider()
+				p.AllowedAudiences = nil
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "openshift")), Provider: p}
+			},
+			want:     []diagnose.Kind{diagnose.AudienceMismatch},
+			wantHint: "--allowed-audiences",
+		},
+		{
+			name: "default audience with https prefix",
+			setup: func(f *fakeIssuer) Input {
+				p := f.provider()
+				p.AllowedAudiences = nil
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "https://iam.googleapis.com/"+providerName)), Provider: p}
+			},
+		},
+		{
+			name: "audience without provider configuration",
+			setup: func(f *fakeIssuer) Input {
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "openshift")), ProviderName: "//iam.googleapis.com/" + providerName}
+			},
+			want:     []diagnose.Kind{diagnose.AudienceMismatch},
+			wantHint: "//iam.googleapis.com/" + providerName,
+		},
+		{
+			name: "token signed by another key",
+			setup: func(f *fakeIssuer) Input {
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", other, serviceAccountClaims(f.URL, "openshift")), Provider: f.provider()}
+			},
+			want:     []diagnose.Kind{diagnose.InvalidSignature},
+			wantHint: "not signed by the issuer",
+		},
+		{
+			name: "discovery names another issuer",
+			setup: func(f *fakeIssuer) Input {
+				f.issuer = "https://kubernetes.default.svc"
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "openshift")), Provider: f.provider()}
+			},
+			want: []diagnose.Kind{diagnose.IssuerMismatch},
+		},
+		{
+			name: "discovery ignored with uploaded keys",
+			setup: func(f *fakeIssuer) Input {
+				f.issuer = "https://kubernetes.default.svc"
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "openshift")), Provider: f.provider(jwk("k", key))}
+			},
+		},
+		{
+			name: "issuer unreachable",
+			setup: func(f *fakeIssuer) Input {
+				f.Close()
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "openshift")), Provider: f.provider()}
+			},
+			want:     []diagnose.Kind{diagnose.IssuerUnreachable},
+			wantHint: "--jwk-json-path",
+		},
+		{
+			name: "issuer unreachable with local JWKS",
+			setup: func(f *fakeIssuer) Input {
+				f.Close()
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "openshift")), JWKS: &JWKS{Keys: []JWK{jwk("k", key)}}}
+			},
+		},
+		{
+			name: "expired token of a disabled provider",
+			setup: func(f *fakeIssuer) Input {
+				claims := serviceAccountClaims(f.URL, "openshift")
+				claims["exp"] = now.Add(-time.Hour).Unix()
+				p := f.provider()
+				p.Disabled = true
+				return Input{Token: sign(t, jwt.SigningMethodRS256, "k", key, claims), Provider: p}
+			},
+			want: []diagnose.Kind{diagnose.TokenExpired, diagnose.ProviderDisabled},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeIssuer(t, jwk("k", key))
+			report, err := f.verifier().Verify(context.Background(), tt.setup(f))
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if got := kinds(report); !slices.Equal(got, tt.want) {
+				t.Fatalf("findings = %v, want %v", report.Findings, tt.want)
+			}
+			if tt.wantHint != "" && !strings.Contains(report.Findings[0].Hint, tt.wantHint) {
+				t.Errorf("hint = %q, want it to contain %q", report.Findings[0].Hint, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestVerifyPrivateIssuer(t *testing.T) {
+	key := rsaKey(t)
+	f := newFakeIssuer(t, jwk("k", key))
+	v := f.verifier()
+	v.public = nil
+	raw := sign(t, jwt.SigningMethodRS256, "k", key, serviceAccountClaims(f.URL, "openshift"))
+
+	report, err := v.Verify(context.Background(), Input{Token: raw, Provider: f.provider()})
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if got := kinds(report); !slices.Equal(got, []diagnose.Kind{diagnose.IssuerUnreachable}) || !report.Verified {
+		t.Errorf("findings = %v, verified = %v; want issuer-unreachable with a verified signature", report.Findings, report.Verified)
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	if _, err := (&Verifier{}).Verify(context.Background(), Input{Token: "not-a-jwt"}); err == nil {
+		t.Fatal("Verify() error = nil")
+	}
+}
+
+func TestFlavorOf(t *testing.T) {
+	tests := map[string]Flavor{
+		"https://container.googleapis.com/v1/projects/p/locations/us-central1/clusters/c": FlavorGKE,
+		"https://hypershift-oidc.storage.googleapis.com/cluster":                          FlavorOpenShift,
+		"https://kubernetes.default.svc":                                                  FlavorOpenShift,
+	}
+	for iss, want := range tests {
+		if got := FlavorOf(iss); got != want {
+			t.Errorf("FlavorOf(%q) = %s, want %s", iss, got, want)
+		}
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := map[string]bool{
+		"https://container.googleapis.com/v1/projects/p/locations/l/clusters/c": true,
+		"https://203.0.113.10/oidc":                    true,
+		"http://oidc.example.com":                      false,
+		"https://kubernetes.default.svc":               false,
+		"https://kubernetes.default.svc.cluster.local": false,
+		"https://kubernetes":                           false,
+		"https://10.0.0.1/oidc":                        false,
+		"https://127.0.0.1:6443":                       false,
+	}
+	for u, want := range tests {
+		if got := isPublic(u); got != want {
+			t.Errorf("isPublic(%q) = %v, want %v", u, got, want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package issuer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SupportedAlgorithms are the JWS algorithms GCP accepts for OIDC subject tokens
+var SupportedAlgorithms = []string{"RS256", "ES256"}
+
+var (
+	// ErrUnknownKey is returned by JWKS.Verify when the token's kid is not in the set
+	ErrUnknownKey = errors.New("signing key not in the JWKS")
+	// ErrSignature is returned by JWKS.Verify when the signature does not verify
+	ErrSignature = errors.New("signature does not verify")
+	// ErrUnsupportedAlgorithm is returned by JWKS.Verify for algorithms GCP rejects
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// JWKS is a JSON Web Key Set, as served at an issuer's jwks_uri or uploaded
+// to a provider with --jwk-json-path
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is one public key of a JWKS. Only RSA and EC keys are supported, as by GCP.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	// N and E are the RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv, X and Y are the EC curve and point
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// ParseJWKS decodes a JWKS and checks that it holds at least one key
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var s JWKS
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	if len(s.Keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no keys")
+	}
+	return &s, nil
+}
+
+// LoadJWKS reads a JWKS file, e.g. the jwks.json of 3-extract-jwks.sh
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	s, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// KeyIDs returns the kid of every key of the set
+func (s *JWKS) KeyIDs() []string {
+	ids := make([]string, len(s.Keys))
+	for i, k := range s.Keys {
+		ids[i] = k.Kid
+	}
+	return ids
+}
+
+// Key returns the key with the given kid. A token without kid matches the
+// only key of a set of one, as the Kubernetes token issuer omits it for
+// some key types.
+func (s *JWKS) Key(kid string) (*JWK, bool) {
+	for i := range s.Keys {
+		if s.Keys[i].Kid == kid {
+			return &s.Keys[i], true
+		}
+	}
+	if kid == "" && len(s.Keys) == 1 {
+		return &s.Keys[0], true
+	}
+	return nil, false
+}
+
+// Verify checks the signature of a raw token against the key of its kid.
+// The claims are not validated, see token.Claims.Validate.
+func (s *JWKS) Verify(raw string) error {
+	raw = strings.TrimSpace(raw)
+	parser := jwt.NewParser(jwt.WithValidMethods(SupportedAlgorithms), jwt.WithoutClaimsValidation())
+	unverified, _, err := parser.ParseUnverified(raw, jwt.MapClaims{})
+	if err != nil {
+		return err
+	}
+	if alg := unverified.Method.Alg(); !slices.Contains(SupportedAlgorithms, alg) {
+		return fmt.Errorf("%w %s (GCP accepts %s)", ErrUnsupportedAlgorithm, alg, strings.Join(SupportedAlgorithms, ", "))
+	}
+
+	_, err = parser.Parse(raw, func(tok *jwt.Token) (any, error) {
+		kid, _ := tok.Header["kid"].(string)
+		key, ok := s.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("%w: kid %q (the JWKS has %s)", ErrUnknownKey, kid, strings.Join(s.KeyIDs(), ", "))
+		}
+		return key.PublicKey()
+	})
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrUnknownKey):
+		return err
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return fmt.Errorf("%w: %v", ErrSignature, err)
+	}
+	return err
+}
+
+// PublicKey decodes the key for signature verification
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid n: %w", k.Kid, err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid e: %w", k.Kid, err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("key %q: exponent too large", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("key %q: unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid x: %w", k.Kid, err)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid y: %w", k.Kid, err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("key %q: point is not on %s", k.Kid, k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("key %q: unsupported key type %q", k.Kid, k.Kty)
+}
+
+// decodeInt decodes a base64url big-endian unsigned integer, as JWKs encode them
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
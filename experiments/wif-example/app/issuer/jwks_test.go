@@ -0,0 +1,164 @@
+package issuer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var now = time.Date(2025, 11, 9, 12, 0, 0, 0, time.UTC)
+
+// rsaKey generates an RSA key; 1024 bits keep the tests fast
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return key
+}
+
+func ecKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating EC key: %v", err)
+	}
+	return key
+}
+
+// jwk encodes the public half of key as 3-extract-jwks.sh does
+func jwk(kid string, key crypto.Signer) JWK {
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Kid: kid, Alg: "RS256", Use: "sig", N: enc(pub.N.Bytes()), E: enc(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return JWK{Kty: "EC", Kid: kid, Alg: "ES256", Use: "sig", Crv: "P-256", X: enc(pub.X.FillBytes(make([]byte, 32))), Y: enc(pub.Y.FillBytes(make([]byte, 32)))}
+	}
+	panic("unsupported key")
+}
+
+// sign builds a token the way the Kubernetes token issuer does
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	raw, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return raw
+}
+
+func serviceAccountClaims(iss string, aud ...string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": iss,
+		"sub": "system:serviceaccount:default:wif-app-workload-sa",
+		"aud": aud,
+		"exp": now.Add(time.Hour).Unix(),
+		"iat": now.Add(-time.Minute).Unix(),
+	}
+}
+
+func TestJWKSVerify(t *testing.T) {
+	rsaSigner, other, ecSigner := rsaKey(t), rsaKey(t), ecKey(t)
+	keys := &JWKS{Keys: []JWK{jwk("rsa", rsaSigner), jwk("ec", ecSigner)}}
+	claims := serviceAccountClaims("https://oidc.example.com")
+
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr error
+	}{
+		{name: "RS256", raw: sign(t, jwt.SigningMethodRS256, "rsa", rsaSigner, claims)},
+		{name: "ES256", raw: sign(t, jwt.SigningMethodES256, "ec", ecSigner, claims) + "\n"},
+		{name: "unknown kid", raw: sign(t, jwt.SigningMethodRS256, "rotated", rsaSigner, claims), wantErr: ErrUnknownKey},
+		{name: "wrong key", raw: sign(t, jwt.SigningMethodRS256, "rsa", other, claims), wantErr: ErrSignature},
+		{name: "key type mismatch", raw: sign(t, jwt.SigningMethodRS256, "ec", rsaSigner, claims), wantErr: ErrSignature},
+		{name: "unsupported algorithm", raw: sign(t, jwt.SigningMethodRS384, "rsa", rsaSigner, claims), wantErr: ErrUnsupportedAlgorithm},
+		{name: "HMAC", raw: sign(t, jwt.SigningMethodHS256, "rsa", []byte("secret"), claims), wantErr: ErrUnsupportedAlgorithm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := keys.Verify(tt.raw)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKSVerifyWithoutKid(t *testing.T) {
+	key := rsaKey(t)
+	raw := sign(t, jwt.SigningMethodRS256, "", key, serviceAccountClaims("https://oidc.example.com"))
+
+	if err := (&JWKS{Keys: []JWK{jwk("only", key)}}).Verify(raw); err != nil {
+		t.Errorf("Verify() with a single key error = %v", err)
+	}
+	two := &JWKS{Keys: []JWK{jwk("a", key), jwk("b", rsaKey(t))}}
+	if err := two.Verify(raw); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify() with two keys error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "valid", data: `{"keys":[{"kty":"RSA","kid":"a","n":"AQAB","e":"AQAB"}]}`},
+		{name: "empty", data: `{"keys":[]}`, wantErr: "no keys"},
+		{name: "not JSON", data: `keys`, wantErr: "failed to parse JWKS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseJWKS([]byte(tt.data))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ParseJWKS() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ParseJWKS() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKPublicKeyInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		key  JWK
+	}{
+		{name: "unsupported type", key: JWK{Kty: "oct", Kid: "a"}},
+		{name: "bad modulus", key: JWK{Kty: "RSA", Kid: "a", N: "!!", E: "AQAB"}},
+		{name: "missing exponent", key: JWK{Kty: "RSA", Kid: "a", N: "AQAB"}},
+		{name: "unsupported curve", key: JWK{Kty: "EC", Kid: "a", Crv: "secp256k1", X: "AQ", Y: "AQ"}},
+		{name: "point off curve", key: JWK{Kty: "EC", Kid: "a", Crv: "P-256", X: "AQ", Y: "AQ"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.key.PublicKey(); err == nil {
+				t.Fatal("PublicKey() error = nil")
+			}
+		})
+	}
+}
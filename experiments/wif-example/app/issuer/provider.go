@@ -0,0 +1,109 @@
+package issuer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	iam "google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+)
+
+// providerPrefix prefixes the provider resource name in STS audiences
+const providerPrefix = "//iam.googleapis.com/"
+
+// Provider is the OIDC configuration of a workload identity pool provider
+type Provider struct {
+	// Name is the resource name,
+	// "projects/NUMBER/locations/global/workloadIdentityPools/POOL/providers/PROVIDER"
+	Name      string
+	IssuerURI string
+	// AllowedAudiences are the token audiences accepted besides none, see Audiences
+	AllowedAudiences []string
+	// JWKS is the key set uploaded with --jwk-json-path, nil if GCP fetches
+	// the keys from the issuer
+	JWKS     *JWKS
+	Disabled bool
+	// State is ACTIVE, DELETED or empty when unknown
+	State string
+}
+
+// newProvider converts the IAM API resource
+func newProvider(p *iam.WorkloadIdentityPoolProvider) (*Provider, error) {
+	if p.Oidc == nil {
+		return nil, fmt.Errorf("provider %s is not an OIDC provider", p.Name)
+	}
+	provider := &Provider{
+		Name:             p.Name,
+		IssuerURI:        p.Oidc.IssuerUri,
+		AllowedAudiences: p.Oidc.AllowedAudiences,
+		Disabled:         p.Disabled,
+		State:            p.State,
+	}
+	if p.Oidc.JwksJson != "" {
+		keys, err := ParseJWKS([]byte(p.Oidc.JwksJson))
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: uploaded %w", p.Name, err)
+		}
+		provider.JWKS = keys
+	}
+	return provider, nil
+}
+
+// ParseProvider decodes a provider as printed by
+// 'gcloud iam workload-identity-pools providers describe --format=json'
+func ParseProvider(data []byte) (*Provider, error) {
+	var p iam.WorkloadIdentityPoolProvider
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse provider: %w", err)
+	}
+	return newProvider(&p)
+}
+
+// LoadProvider reads a provider file, see ParseProvider
+func LoadProvider(path string) (*Provider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provider: %w", err)
+	}
+	p, err := ParseProvider(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// GetProvider reads a provider with the IAM API, which takes
+// iam.workloadIdentityPoolProviders.get, e.g. roles/iam.workloadIdentityPoolViewer.
+// name is the resource name, with or without the //iam.googleapis.com/ prefix
+// of STS audiences.
+func GetProvider(ctx context.Context, name string, opts ...option.ClientOption) (*Provider, error) {
+	svc, err := iam.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IAM client: %w", err)
+	}
+	p, err := svc.Projects.Locations.WorkloadIdentityPools.Providers.Get(strings.TrimPrefix(name, providerPrefix)).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return newProvider(p)
+}
+
+// Audiences returns the token audiences the provider accepts: its allowed
+// audiences, or its own resource name with either prefix when it has none
+func (p *Provider) Audiences() []string {
+	if len(p.AllowedAudiences) > 0 {
+		return p.AllowedAudiences
+	}
+	return DefaultAudiences(p.Name)
+}
+
+// DefaultAudiences returns the audiences a provider without allowed audiences
+// accepts: its resource name prefixed with //iam.googleapis.com/ or
+// https://iam.googleapis.com/
+func DefaultAudiences(name string) []string {
+	name = strings.TrimPrefix(name, providerPrefix)
+	return []string{providerPrefix + name, "https://iam.googleapis.com/" + name}
+}
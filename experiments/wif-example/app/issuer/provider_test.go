@@ -0,0 +1,83 @@
+package issuer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+// describeOutput is what 'gcloud iam workload-identity-pools providers describe --format=json' prints
+const describeOutput = `{
+  "attributeMapping": {"google.subject": "assertion.sub"},
+  "name": "projects/123/locations/global/workloadIdentityPools/pool/providers/hcp",
+  "oidc": {
+    "issuerUri": "https://hypershift-oidc.example.com",
+    "jwksJson": "{\"keys\":[{\"kty\":\"RSA\",\"kid\":\"k\",\"n\":\"AQAB\",\"e\":\"AQAB\"}]}"
+  },
+  "state": "ACTIVE"
+}`
+
+func TestParseProvider(t *testing.T) {
+	p, err := ParseProvider([]byte(describeOutput))
+	if err != nil {
+		t.Fatalf("ParseProvider() error = %v", err)
+	}
+	if p.Name != providerName || p.IssuerURI != "https://hypershift-oidc.example.com" || p.State != "ACTIVE" {
+		t.Errorf("ParseProvider() = %+v", p)
+	}
+	if p.JWKS == nil || !slices.Equal(p.JWKS.KeyIDs(), []string{"k"}) {
+		t.Errorf("ParseProvider() JWKS = %+v, want key k", p.JWKS)
+	}
+
+	want := []string{"//iam.googleapis.com/" + providerName, "https://iam.googleapis.com/" + providerName}
+	if got := p.Audiences(); !slices.Equal(got, want) {
+		t.Errorf("Audiences() = %v, want %v", got, want)
+	}
+}
+
+func TestParseProviderInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "AWS provider", data: `{"name":"p","aws":{"accountId":"1"}}`, wantErr: "not an OIDC provider"},
+		{name: "bad uploaded JWKS", data: `{"name":"p","oidc":{"issuerUri":"https://i","jwksJson":"{}"}}`, wantErr: "uploaded JWKS has no keys"},
+		{name: "not JSON", data: `name: p`, wantErr: "failed to parse provider"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseProvider([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ParseProvider() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetProvider(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(describeOutput))
+	}))
+	defer srv.Close()
+
+	p, err := GetProvider(context.Background(), "//iam.googleapis.com/"+providerName,
+		option.WithEndpoint(srv.URL), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("GetProvider() error = %v", err)
+	}
+	if path != "/v1/"+providerName {
+		t.Errorf("GetProvider() requested %s, want /v1/%s", path, providerName)
+	}
+	if p.IssuerURI != "https://hypershift-oidc.example.com" {
+		t.Errorf("GetProvider() issuer = %s", p.IssuerURI)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/diagnose"
+	"google.golang.org/api/googleapi"
+)
+
+// Log formats selectable with LOG_FORMAT / -log-format
+const (
+	// logFormatJSON follows the Cloud Logging structured logging conventions
+	logFormatJSON = "json"
+	// logFormatText is easier to read when running locally
+	logFormatText = "text"
+)
+
+// setupLogging installs the default logger. The standard log package is
+// routed through it as well, so client library output is structured too.
+func setupLogging(w io.Writer, format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch format {
+	case logFormatJSON:
+		opts.ReplaceAttr = cloudLoggingAttr
+		handler = slog.NewJSONHandler(w, opts)
+	case logFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (use %s or %s)", format, logFormatJSON, logFormatText)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// cloudLoggingAttr renames the built-in attributes to the fields Cloud Logging
+// recognizes in structured payloads: severity and message
+func cloudLoggingAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.LevelKey:
+		level, _ := a.Value.Any().(slog.Level)
+		a.Key = "severity"
+		switch {
+		case level >= slog.LevelError:
+			a.Value = slog.StringValue("ERROR")
+		case level >= slog.LevelWarn:
+			a.Value = slog.StringValue("WARNING")
+		case level >= slog.LevelInfo:
+			a.Value = slog.StringValue("INFO")
+		default:
+			a.Value = slog.StringValue("DEBUG")
+		}
+	case slog.MessageKey:
+		a.Key = "message"
+	}
+	return a
+}
+
+// component returns a logger tagging entries with the part of the app that wrote them
+func component(name string) *slog.Logger {
+	return slog.With("component", name)
+}
+
+// errorAttr logs err with its GCP error code and diagnosis, so log-based
+// alerts can match on error.kind instead of on message text
+func errorAttr(err error) slog.Attr {
+	attrs := []any{slog.String("message", err.Error())}
+
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		attrs = append(attrs, slog.Int("code", gerr.Code))
+		if len(gerr.Errors) > 0 && gerr.Errors[0].Reason != "" {
+			attrs = append(attrs, slog.String("reason", gerr.Errors[0].Reason))
+		}
+	}
+
+	d := diagnose.Classify(err)
+	attrs = append(attrs, slog.String("kind", string(d.Kind)))
+	if d.Kind != diagnose.Unknown {
+		attrs = append(attrs, slog.String("summary", d.Summary))
+	}
+	if d.Hint != "" {
+		attrs = append(attrs, slog.String("hint", d.Hint))
+	}
+	if d.Role != "" {
+		attrs = append(attrs, slog.String("role", d.Role))
+	}
+	return slog.Group("error", attrs...)
+}
+
+// fatal logs an error and exits, like log.Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
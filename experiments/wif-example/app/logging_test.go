@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestSetupLogging_CloudLoggingFields(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	var buf bytes.Buffer
+	if err := setupLogging(&buf, logFormatJSON, "info"); err != nil {
+		t.Fatalf("setupLogging() error = %v", err)
+	}
+
+	err := fmt.Errorf("failed to list instances: %w", &googleapi.Error{
+		Code:    403,
+		Message: "Required 'compute.instances.list' permission for 'projects/my-project'",
+		Errors:  []googleapi.ErrorItem{{Reason: "forbidden"}},
+	})
+	component("checks").Warn("Check failed", "check", "compute", errorAttr(err))
+	component("checks").Debug("not logged at info")
+
+	var entry struct {
+		Severity  string
+		Message   string
+		Component string
+		Check     string
+		Error     struct {
+			Code   int
+			Reason string
+			Kind   string
+			Role   string
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding log entry %q: %v", buf.String(), err)
+	}
+
+	if entry.Severity != "WARNING" || entry.Message != "Check failed" || entry.Component != "checks" || entry.Check != "compute" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if entry.Error.Code != 403 || entry.Error.Reason != "forbidden" || entry.Error.Kind != "permission-denied" || entry.Error.Role != "roles/compute.viewer" {
+		t.Errorf("error = %+v", entry.Error)
+	}
+}
+
+func TestSetupLogging_Invalid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := setupLogging(&buf, "xml", "info"); err == nil {
+		t.Errorf("setupLogging() accepted format xml")
+	}
+	if err := setupLogging(&buf, logFormatJSON, "loud"); err == nil {
+		t.Errorf("setupLogging() accepted level loud")
+	}
+}
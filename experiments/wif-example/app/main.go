@@ -2,236 +2,438 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
-	compute "cloud.google.com/go/compute/apiv1"
-	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/telemetry"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/token"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/tokenrequest"
 	"google.golang.org/api/option"
 )
 
+// tokenClockSkew is the leeway allowed when checking token exp/nbf
+const tokenClockSkew = 30 * time.Second
+
 // Config holds the application configuration
 type Config struct {
 	ProjectID string
 	TokenFile string
 	Audience  string
+	// SubjectTokenSource selects how TokenFile is kept fresh, see startMinter
+	SubjectTokenSource string
+	// Kubeconfig, TokenServiceAccount and TokenExpiration configure the
+	// TokenRequest API calls of the tokenrequest subject token source
+	Kubeconfig          string
+	TokenServiceAccount string
+	TokenExpiration     time.Duration
+	// Checks is the comma-separated list of API checks to run, see availableChecks
+	Checks string
+	// Regions is the comma-separated list of regions whose zones the compute
+	// check lists, empty or "all" for every region
+	Regions string
+	// SecretID is the Secret Manager secret read by the secretmanager check
+	SecretID string
+	// SignedURLObject is the gs://<bucket>/<object> the signedurl check signs
+	// a URL for
+	SignedURLObject string
+	// SigningServiceAccount is the service account the signedurl check signs
+	// as, the impersonated one if empty
+	SigningServiceAccount string
+	// AuthMode selects how GCP credentials are obtained, see clientOptions
+	AuthMode string
+	// CredentialSources is the comma-separated list of sources the chain
+	// auth mode tries in order, see newCredentialChain
+	CredentialSources string
+	// WorkloadIdentityProvider is the STS audience used by the sts auth mode
+	WorkloadIdentityProvider string
+	// ImpersonateServiceAccount is the GCP service account the federated
+	// identity impersonates, if any
+	ImpersonateServiceAccount string
+	// ListenAddr is where /healthz, /status and /metrics are served
+	ListenAddr string
+	// Interval is the time between check cycles
+	Interval time.Duration
+	// RefreshBefore is how long before expiry the access token is refreshed
+	RefreshBefore time.Duration
+	// CredentialsCheckInterval is how often the credential configuration is re-validated
+	CredentialsCheckInterval time.Duration
+	// ExitOnCredentialDrift exits with exitCredentialDrift once the credential
+	// configuration stops matching, instead of only reporting it
+	ExitOnCredentialDrift bool
+	// Cycles switches to the canary mode: run that many check cycles, then
+	// exit 0 or 1 depending on FailureBudget. Zero runs the checks forever.
+	Cycles int
+	// FailureBudget is the number, or percentage, of failed cycles the
+	// canary mode tolerates, see parseFailureBudget
+	FailureBudget string
+	// Diagnose runs the diagnostic cases once instead of the checks
+	Diagnose bool
+	// DiagnosticTokensDir holds the broken tokens some diagnostic cases need
+	DiagnosticTokensDir string
+	// VerifyIssuer checks the token against its issuer and the provider once
+	// instead of running the checks, see runIssuerVerification
+	VerifyIssuer bool
+	// ProviderConfigFile is the provider as described by gcloud, read instead
+	// of calling the IAM API with the federated identity
+	ProviderConfigFile string
+	// JWKSFile is the cluster's JWKS, checked against when the issuer is not
+	// reachable and the provider configuration is unknown
+	JWKSFile string
+	// TenantsDir holds one credential configuration per tenant, see loadTenants
+	TenantsDir string
+	// ConfigSource is the Secret Manager secret (sm://) or Cloud Storage
+	// object (gs://) the non-sensitive settings are read from, see RemoteConfig
+	ConfigSource string
+	// LogFormat is json (Cloud Logging) or text
+	LogFormat string
+	// LogLevel is the minimum level logged: debug, info, warn or error
+	LogLevel string
+}
+
+// App holds what every check cycle shares
+type App struct {
+	cfg    *Config
+	checks []Check
+	// opts authenticate every GCP client with tokens from the token manager
+	opts   []option.ClientOption
+	tokens *token.Manager
+	// chain is the credential chain of the chain auth mode, nil otherwise
+	chain  *credentialChain
+	status *Status
 }
 
 func main() {
-	log.Println("Starting GCP WIF Example Application...")
+	// Subcommands run instead of the checks
+	if len(os.Args) > 1 && os.Args[1] == generateCommand {
+		if err := runGenerate(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Load configuration from environment
 	cfg := &Config{
 		ProjectID: getEnv("GCP_PROJECT_ID", ""),
 		TokenFile: getEnv("TOKEN_FILE", "/var/run/secrets/openshift/serviceaccount/token"),
 		Audience:  getEnv("TOKEN_AUDIENCE", "openshift"),
+		Checks:    getEnv("CHECKS", "compute"),
+		Regions:   getEnv("REGIONS", "us-central1"),
+		SecretID:  getEnv("SECRET_ID", ""),
+		AuthMode:  getEnv("AUTH_MODE", authModeCredentialsFile),
+
+		SignedURLObject:       getEnv("SIGNED_URL_OBJECT", ""),
+		SigningServiceAccount: getEnv("SIGNING_SERVICE_ACCOUNT", ""),
+
+		CredentialSources: getEnv("CREDENTIAL_SOURCES", defaultCredentialSources),
+
+		SubjectTokenSource:  getEnv("SUBJECT_TOKEN_SOURCE", subjectTokenSourceFile),
+		Kubeconfig:          getEnv("KUBECONFIG", ""),
+		TokenServiceAccount: getEnv("TOKEN_SERVICE_ACCOUNT", "default/wif-app-workload-sa"),
+
+		WorkloadIdentityProvider:  getEnv("WIF_PROVIDER", ""),
+		ImpersonateServiceAccount: getEnv("IMPERSONATE_SERVICE_ACCOUNT", ""),
+		ListenAddr:                getEnv("LISTEN_ADDR", ":8080"),
+		DiagnosticTokensDir:       getEnv("DIAG_TOKENS_DIR", ""),
+		ProviderConfigFile:        getEnv("WIF_PROVIDER_CONFIG", ""),
+		JWKSFile:                  getEnv("JWKS_FILE", ""),
+		TenantsDir:                getEnv("TENANTS_DIR", ""),
+		ConfigSource:              getEnv("CONFIG_SOURCE", ""),
+		LogFormat:                 getEnv("LOG_FORMAT", logFormatJSON),
+		LogLevel:                  getEnv("LOG_LEVEL", "info"),
+		FailureBudget:             getEnv("FAILURE_BUDGET", "0"),
 	}
 
-	if cfg.ProjectID == "" {
-		log.Fatal("GCP_PROJECT_ID environment variable is required")
+	interval, err := time.ParseDuration(getEnv("CHECK_INTERVAL", "30s"))
+	if err != nil {
+		fatal("Invalid CHECK_INTERVAL", errorAttr(err))
 	}
+	cfg.Interval = interval
 
-	log.Printf("Configuration: ProjectID=%s, TokenFile=%s, Audience=%s",
-		cfg.ProjectID, cfg.TokenFile, cfg.Audience)
-
-	ctx := context.Background()
-
-	// Run the main loop
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	// Run once immediately
-	if err := listComputeInstances(ctx, cfg); err != nil {
-		log.Printf("Error listing instances: %v", err)
+	refreshBefore, err := time.ParseDuration(getEnv("REFRESH_BEFORE", token.DefaultRefreshBefore.String()))
+	if err != nil {
+		fatal("Invalid REFRESH_BEFORE", errorAttr(err))
 	}
+	cfg.RefreshBefore = refreshBefore
 
-	// Then run periodically
-	for range ticker.C {
-		if err := listComputeInstances(ctx, cfg); err != nil {
-			log.Printf("Error listing instances: %v", err)
-		}
+	tokenExpiration, err := time.ParseDuration(getEnv("TOKEN_EXPIRATION", tokenrequest.DefaultExpiration.String()))
+	if err != nil {
+		fatal("Invalid TOKEN_EXPIRATION", errorAttr(err))
 	}
-}
-
-// listComputeInstances demonstrates using GCP API with WIF token
-func listComputeInstances(ctx context.Context, cfg *Config) error {
-	log.Println("=== Starting GCP API Call ===")
+	cfg.TokenExpiration = tokenExpiration
 
-	// Read the token from file (provided by token-minter sidecar)
-	token, err := readToken(cfg.TokenFile)
+	credentialsCheckInterval, err := time.ParseDuration(getEnv("CREDENTIALS_CHECK_INTERVAL", "1m"))
 	if err != nil {
-		return fmt.Errorf("failed to read token: %w", err)
+		fatal("Invalid CREDENTIALS_CHECK_INTERVAL", errorAttr(err))
 	}
+	cfg.CredentialsCheckInterval = credentialsCheckInterval
+	cfg.ExitOnCredentialDrift = getEnv("EXIT_ON_CREDENTIAL_DRIFT", "false") == "true"
 
-	log.Printf("Token read successfully (length: %d bytes)", len(token))
-
-	// Log token metadata without exposing the full token
-	if err := logTokenMetadata(token); err != nil {
-		log.Printf("Warning: Could not parse token metadata: %v", err)
+	cycles, err := strconv.Atoi(getEnv("CANARY_CYCLES", "0"))
+	if err != nil {
+		fatal("Invalid CANARY_CYCLES", errorAttr(err))
 	}
+	cfg.Cycles = cycles
+
+	// Flags override the environment
+	flag.StringVar(&cfg.Checks, "checks", cfg.Checks, "Comma-separated API checks to run (compute, storage, tokeninfo, secretmanager, signedurl or all)")
+	flag.StringVar(&cfg.Regions, "regions", cfg.Regions, "Comma-separated regions whose zones the compute check lists, or all")
+	flag.StringVar(&cfg.SecretID, "secret-id", cfg.SecretID, "Secret Manager secret name or full version resource for the secretmanager check")
+	flag.StringVar(&cfg.SignedURLObject, "signed-url-object", cfg.SignedURLObject, "Cloud Storage object (gs://<bucket>/<object>) the signedurl check signs a V4 URL for with IAM signBlob and fetches")
+	flag.StringVar(&cfg.SigningServiceAccount, "signing-service-account", cfg.SigningServiceAccount, "Service account the signedurl check signs as, the impersonated service account if empty")
+	flag.StringVar(&cfg.AuthMode, "auth-mode", cfg.AuthMode, "How to obtain GCP credentials: credentials-file (GOOGLE_APPLICATION_CREDENTIALS), sts (in-process token exchange) or chain (first working -credential-sources)")
+	flag.StringVar(&cfg.CredentialSources, "credential-sources", cfg.CredentialSources, "Comma-separated credential sources -auth-mode=chain tries in order: file (projected token), tokenrequest (TokenRequest API) and metadata (metadata server)")
+	flag.StringVar(&cfg.SubjectTokenSource, "subject-token-source", cfg.SubjectTokenSource, "How the token file is kept fresh: file (token-minter sidecar) or tokenrequest (in-process TokenRequest API calls)")
+	flag.StringVar(&cfg.Kubeconfig, "kubeconfig", cfg.Kubeconfig, "Kubeconfig of the cluster minting tokens for -subject-token-source=tokenrequest, in-cluster if empty")
+	flag.StringVar(&cfg.TokenServiceAccount, "token-service-account", cfg.TokenServiceAccount, "namespace/name of the service account -subject-token-source=tokenrequest mints tokens for")
+	flag.DurationVar(&cfg.TokenExpiration, "token-expiration", cfg.TokenExpiration, "Expiration requested for tokens minted with -subject-token-source=tokenrequest")
+	flag.StringVar(&cfg.WorkloadIdentityProvider, "wif-provider", cfg.WorkloadIdentityProvider, "Workload identity provider resource name, required for -auth-mode=sts")
+	flag.StringVar(&cfg.ImpersonateServiceAccount, "impersonate-service-account", cfg.ImpersonateServiceAccount, "GCP service account email to impersonate with the federated token")
+	flag.StringVar(&cfg.ListenAddr, "listen-addr", cfg.ListenAddr, "Address serving /healthz, /status and /metrics")
+	flag.DurationVar(&cfg.Interval, "interval", cfg.Interval, "Time between check cycles")
+	flag.DurationVar(&cfg.RefreshBefore, "refresh-before", cfg.RefreshBefore, "How long before expiry the access token is refreshed")
+	flag.DurationVar(&cfg.CredentialsCheckInterval, "credentials-check-interval", cfg.CredentialsCheckInterval, "How often the GOOGLE_APPLICATION_CREDENTIALS configuration is re-validated")
+	flag.BoolVar(&cfg.ExitOnCredentialDrift, "exit-on-credential-drift", cfg.ExitOnCredentialDrift, "Exit with code 3 when the credential configuration changes or stops matching the token mount and provider")
+	flag.StringVar(&cfg.TenantsDir, "tenants-dir", cfg.TenantsDir, "Directory with one external-account credential configuration per tenant; every tenant runs the checks concurrently with its own identity")
+	flag.StringVar(&cfg.ConfigSource, "config-source", cfg.ConfigSource, "Secret Manager secret (sm://<secret>) or Cloud Storage object (gs://<bucket>/<object>) to read the project, regions, audience and checks from at startup, with the federated identity")
+	flag.IntVar(&cfg.Cycles, "cycles", cfg.Cycles, "Run this many check cycles, then exit 0 if the failed ones are within -failure-budget and 1 otherwise; 0 runs forever")
+	flag.StringVar(&cfg.FailureBudget, "failure-budget", cfg.FailureBudget, "Failed cycles tolerated by -cycles, a number or a percentage of the cycles such as 10%")
+	flag.BoolVar(&cfg.Diagnose, "diagnose", false, "Run the negative-path federation diagnostics once and exit")
+	flag.StringVar(&cfg.DiagnosticTokensDir, "diagnose-tokens-dir", cfg.DiagnosticTokensDir, "Directory with the wrong-audience, expired and unmapped-subject tokens used by -diagnose")
+	flag.BoolVar(&cfg.VerifyIssuer, "verify-issuer", false, "Verify the token's signature, issuer and audience against its OIDC issuer and the workload identity provider once and exit")
+	flag.StringVar(&cfg.ProviderConfigFile, "provider-config", cfg.ProviderConfigFile, "Provider as printed by 'gcloud iam workload-identity-pools providers describe --format=json', used by -verify-issuer instead of reading it with the IAM API")
+	flag.StringVar(&cfg.JWKSFile, "jwks-file", cfg.JWKSFile, "JWKS of the cluster's signing key, used by -verify-issuer when the provider configuration cannot be read")
+	flag.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Log format: json (Cloud Logging structured logs) or text")
+	flag.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Minimum log level: debug, info, warn or error")
+	flag.Parse()
+
+	if err := setupLogging(os.Stderr, cfg.LogFormat, cfg.LogLevel); err != nil {
+		fatal("Invalid logging configuration", errorAttr(err))
+	}
+	logger := component("main")
+	logger.Info("Starting GCP WIF Example Application")
 
-	// Create credentials using the token file
-	// This uses GCP's credential file which should point to the token file
-	credentialsFile := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if credentialsFile == "" {
-		return fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS not set")
+	// CONFIG_SOURCE can provide the project, but only once the checks run
+	if cfg.ProjectID == "" && cfg.TenantsDir == "" && !cfg.VerifyIssuer && (cfg.ConfigSource == "" || cfg.Diagnose) {
+		fatal("GCP_PROJECT_ID environment variable is required")
+	}
+	if cfg.ConfigSource != "" && cfg.TenantsDir != "" {
+		fatal("CONFIG_SOURCE does not support TENANTS_DIR, every tenant has its own identity")
+	}
+	if cfg.Interval <= 0 {
+		fatal("Check interval must be positive", "interval", cfg.Interval)
+	}
+	if cfg.CredentialsCheckInterval <= 0 {
+		fatal("Credentials check interval must be positive", "interval", cfg.CredentialsCheckInterval)
 	}
 
-	// Create compute client
-	client, err := compute.NewInstancesRESTClient(ctx, option.WithCredentialsFile(credentialsFile))
+	if cfg.Cycles < 0 {
+		fatal("Canary cycles must not be negative", "cycles", cfg.Cycles)
+	}
+	failureBudget, err := parseFailureBudget(cfg.FailureBudget, cfg.Cycles)
 	if err != nil {
-		return fmt.Errorf("failed to create compute client: %w", err)
+		fatal("Invalid failure budget", errorAttr(err))
+	}
+	if cfg.Cycles > 0 && cfg.TenantsDir != "" {
+		fatal("The canary mode does not support TENANTS_DIR, run one canary per tenant instead")
 	}
-	defer client.Close()
-
-	log.Println("Successfully created GCP client")
 
-	// List compute instances across all zones
-	zones := []string{"us-central1-a", "us-central1-b", "us-central1-c"}
-	totalInstances := 0
+	checks, err := selectChecks(cfg.Checks)
+	if err != nil {
+		fatal("Invalid check selection", errorAttr(err))
+	}
 
-	for _, zone := range zones {
-		req := &computepb.ListInstancesRequest{
-			Project: cfg.ProjectID,
-			Zone:    zone,
+	logger.Info("Configuration",
+		"projectID", cfg.ProjectID,
+		"tokenFile", cfg.TokenFile,
+		"audience", cfg.Audience,
+		"checks", cfg.Checks,
+		"regions", cfg.Regions,
+		"authMode", cfg.AuthMode,
+		"credentialSources", cfg.CredentialSources,
+		"subjectTokenSource", cfg.SubjectTokenSource,
+		"configSource", cfg.ConfigSource,
+		"interval", cfg.Interval.String())
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Mint the token in-process instead of waiting for the token-minter sidecar
+	var minter *tokenrequest.Minter
+	switch cfg.SubjectTokenSource {
+	case subjectTokenSourceFile:
+	case subjectTokenSourceTokenRequest:
+		minter, err = startMinter(ctx, cfg)
+		if err != nil {
+			fatal("Failed to mint a token with the TokenRequest API", errorAttr(err))
 		}
+	default:
+		fatal("Unknown subject token source", "source", cfg.SubjectTokenSource)
+	}
 
-		log.Printf("Listing instances in zone: %s", zone)
-
-		it := client.List(ctx, req)
-		zoneCount := 0
-
-		for {
-			instance, err := it.Next()
-			if err != nil {
-				// End of list or error
-				if err.Error() == "no more items in iterator" {
-					break
-				}
-				log.Printf("Error iterating instances in %s: %v", zone, err)
-				break
-			}
-
-			zoneCount++
-			totalInstances++
-
-			log.Printf("  - Instance: %s (Status: %s, MachineType: %s)",
-				instance.GetName(),
-				instance.GetStatus(),
-				instance.GetMachineType())
+	if cfg.VerifyIssuer {
+		if err := runIssuerVerification(ctx, cfg); err != nil {
+			fatal("Issuer verification failed", errorAttr(err))
 		}
+		return
+	}
 
-		if zoneCount == 0 {
-			log.Printf("  No instances found in zone: %s", zone)
+	if cfg.Diagnose {
+		if err := runDiagnostics(ctx, cfg); err != nil {
+			fatal("Diagnostics failed", errorAttr(err))
 		}
+		return
 	}
 
-	log.Printf("=== API Call Complete: Found %d total instances ===\n", totalInstances)
-	return nil
-}
+	// Every GCP call and token exchange is measured
+	metrics := telemetry.NewMetrics()
 
-// readToken reads the service account token from the file
-func readToken(tokenFile string) (string, error) {
-	data, err := os.ReadFile(tokenFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to read token file %s: %w", tokenFile, err)
+	// Every tenant runs the checks with its own identity instead
+	if cfg.TenantsDir != "" {
+		status := NewStatus(cfg, nil)
+		status.registerTelemetry(metrics)
+		tenants, err := loadTenants(ctx, cfg, checks, metrics)
+		if err != nil {
+			fatal("Failed to load tenants", errorAttr(err))
+		}
+		server := startServer(cfg.ListenAddr, status)
+		runTenants(ctx, tenants, status)
+		logger.Info("Shutting down")
+		shutdownServer(server)
+		return
 	}
-	return string(data), nil
-}
 
-// logTokenMetadata logs metadata about the JWT token without exposing sensitive data
-func logTokenMetadata(token string) error {
-	// Simple JWT parsing to extract header and payload (not verifying signature)
-	parts := splitToken(token)
-	if len(parts) != 3 {
-		return fmt.Errorf("invalid JWT format")
+	// The token manager mints access tokens ahead of expiry for all clients
+	source, chain, err := newTokenSource(ctx, cfg)
+	if err != nil {
+		fatal("Failed to set up GCP credentials", errorAttr(err))
 	}
-
-	// Decode payload (index 1)
-	payload, err := decodeBase64(parts[1])
+	// The chain reads the token file of its sources itself, or none at all
+	tokenFile := cfg.TokenFile
+	if chain != nil {
+		tokenFile = ""
+	}
+	tokens, err := token.NewManager(token.ManagerConfig{
+		TokenFile:     tokenFile,
+		Source:        metrics.TokenSource(source),
+		RefreshBefore: cfg.RefreshBefore,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to decode payload: %w", err)
+		fatal("Failed to set up token manager", errorAttr(err))
 	}
-
-	var claims map[string]interface{}
-	if err := json.Unmarshal(payload, &claims); err != nil {
-		return fmt.Errorf("failed to unmarshal claims: %w", err)
+	go tokens.Run(ctx)
+	opts := clientOptions(tokens, metrics)
+
+	// The federated identity reads the rest of its configuration, so only
+	// the settings that obtain it have to be in the deployment
+	if cfg.ConfigSource != "" {
+		if err := loadRemoteConfig(ctx, cfg, opts...); err != nil {
+			fatal("Failed to load the configuration", "source", cfg.ConfigSource, errorAttr(err))
+		}
+		if cfg.ProjectID == "" {
+			fatal("Neither GCP_PROJECT_ID nor the configuration set the project", "source", cfg.ConfigSource)
+		}
+		if checks, err = selectChecks(cfg.Checks); err != nil {
+			fatal("Invalid check selection in the configuration", "source", cfg.ConfigSource, errorAttr(err))
+		}
 	}
 
-	// Log safe metadata
-	log.Printf("Token metadata - aud: %v, iss: %v, sub: %v",
-		claims["aud"],
-		claims["iss"],
-		claims["sub"])
-
-	if exp, ok := claims["exp"].(float64); ok {
-		expTime := time.Unix(int64(exp), 0)
-		log.Printf("Token expires at: %s (in %v)",
-			expTime.Format(time.RFC3339),
-			time.Until(expTime).Round(time.Second))
+	app := &App{
+		cfg:    cfg,
+		checks: checks,
+		opts:   opts,
+		tokens: tokens,
+		chain:  chain,
+		status: NewStatus(cfg, tokens),
+	}
+	app.status.registerTelemetry(metrics)
+	if minter != nil {
+		app.status.registerMinter(minter)
+	}
+	if chain != nil {
+		app.status.registerChain(chain)
 	}
 
-	return nil
-}
-
-// Helper functions
-func splitToken(token string) []string {
-	result := []string{}
-	start := 0
-	for i := 0; i < len(token); i++ {
-		if token[i] == '.' {
-			result = append(result, token[start:i])
-			start = i + 1
+	// Catch the credential configuration changing under the running app
+	if cfg.AuthMode == authModeCredentialsFile {
+		watcher, err := newCredentialWatcher(cfg, app.status)
+		if err != nil {
+			fatal("Failed to read the credential configuration", errorAttr(err))
 		}
+		go watcher.Run(ctx)
 	}
-	result = append(result, token[start:])
-	return result
-}
 
-func decodeBase64(s string) ([]byte, error) {
-	// Add padding if needed
-	for len(s)%4 != 0 {
-		s += "="
-	}
+	server := startServer(cfg.ListenAddr, app.status)
 
-	// Simple base64 decoding (using standard library would be better in production)
-	const base64Table = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	// Prove WIF works in a bounded number of cycles, e.g. as a Kubernetes Job
+	if cfg.Cycles > 0 {
+		logger.Info("Running as a canary", "cycles", cfg.Cycles, "failureBudget", failureBudget)
+		result := runCanary(ctx, cfg.Cycles, failureBudget, cfg.Interval, app.runChecks)
+		shutdownServer(server)
+		stop()
+		os.Exit(logCanaryResult(result))
+	}
 
-	result := make([]byte, 0, len(s)*3/4)
-	buf := uint32(0)
-	bits := 0
+	// Run the main loop
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
 
-	for _, c := range s {
-		if c == '=' {
-			break
+	for {
+		if err := app.runChecks(ctx); err != nil {
+			logger.Error("Check cycle failed", errorAttr(err))
 		}
 
-		val := -1
-		for i, b := range base64Table {
-			if byte(c) == byte(b) {
-				val = i
-				break
-			}
+		select {
+		case <-ctx.Done():
+			logger.Info("Shutting down")
+			shutdownServer(server)
+			return
+		case <-ticker.C:
 		}
+	}
+}
 
-		if val == -1 {
-			continue
+// startServer serves /healthz, /status and /metrics of status on addr
+func startServer(addr string, status *Status) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           status.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		component("server").Info("Serving /healthz, /status and /metrics", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server failed", "component", "server", errorAttr(err))
 		}
+	}()
+	return server
+}
 
-		buf = buf<<6 | uint32(val)
-		bits += 6
-
-		if bits >= 8 {
-			bits -= 8
-			result = append(result, byte(buf>>bits))
-			buf &= (1 << bits) - 1
-		}
+func shutdownServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		component("server").Warn("HTTP server shutdown failed", errorAttr(err))
 	}
+}
 
-	return result, nil
+// logTokenMetadata logs metadata about the JWT token without exposing sensitive data
+// and warns about tokens GCP is going to reject
+func logTokenMetadata(claims *token.Claims, audience string) error {
+	logger := component("token")
+	logger.Info("Token metadata", "token", claims, "expiresInSeconds", int(claims.ExpiresIn(time.Now()).Seconds()))
+
+	if !claims.HasAudience(audience) {
+		logger.Warn("Token audience does not include the expected audience", "token", claims, "expectedAudience", audience)
+	}
+	return claims.Validate(time.Now(), tokenClockSkew)
 }
 
 func getEnv(key, defaultValue string) string {
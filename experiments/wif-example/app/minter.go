@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"slices"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/tokenrequest"
+)
+
+// Subject token sources selectable with SUBJECT_TOKEN_SOURCE / -subject-token-source
+const (
+	// subjectTokenSourceFile reads TOKEN_FILE as written by the token-minter sidecar
+	subjectTokenSourceFile = "file"
+	// subjectTokenSourceTokenRequest mints TOKEN_FILE in-process with the TokenRequest API
+	subjectTokenSourceTokenRequest = "tokenrequest"
+)
+
+// startMinter mints the first token before returning, so TOKEN_FILE exists
+// when the federation flow starts, then keeps it fresh until ctx is done
+func startMinter(ctx context.Context, cfg *Config) (*tokenrequest.Minter, error) {
+	minter, err := tokenrequest.NewMinter(tokenrequest.Config{
+		Kubeconfig:     cfg.Kubeconfig,
+		ServiceAccount: cfg.TokenServiceAccount,
+		Audience:       cfg.Audience,
+		Expiration:     cfg.TokenExpiration,
+		TokenFile:      cfg.TokenFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := minter.Mint(ctx); err != nil {
+		return nil, err
+	}
+
+	logger := component("tokenrequest").With("serviceAccount", cfg.TokenServiceAccount, "tokenFile", cfg.TokenFile)
+	report := func(stats tokenrequest.Stats, err error) {
+		if err != nil {
+			logger.Error("TokenRequest failed", "nextMint", stats.NextMint, errorAttr(err))
+			return
+		}
+		// The latency is what minting in-process costs over reading a projected file
+		logger.Info("Minted token with the TokenRequest API",
+			"latencyMs", stats.LastLatency.Milliseconds(),
+			"expiresAt", stats.ExpiresAt,
+			"nextMint", stats.NextMint)
+	}
+	report(minter.Stats(), nil)
+
+	go minter.Run(ctx, report)
+	return minter, nil
+}
+
+// mintsSubjectToken reports whether the app mints subject tokens for
+// TOKEN_AUDIENCE with the TokenRequest API, as the subject token source or a
+// source of the credential chain
+func mintsSubjectToken(cfg *Config) bool {
+	if cfg.SubjectTokenSource == subjectTokenSourceTokenRequest {
+		return true
+	}
+	if cfg.AuthMode != authModeChain {
+		return false
+	}
+	names, _ := parseCredentialSources(cfg.CredentialSources)
+	return slices.Contains(names, credentialSourceTokenRequest)
+}
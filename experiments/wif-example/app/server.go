@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/credconfig"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/telemetry"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/token"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/tokenrequest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// staleCycles is how many check intervals may pass without a completed cycle
+// before /healthz reports the canary as unhealthy
+const staleCycles = 3
+
+// TokenStatus describes the projected token seen in the last cycle
+type TokenStatus struct {
+	Issuer           string   `json:"issuer,omitempty"`
+	Subject          string   `json:"subject,omitempty"`
+	Audience         []string `json:"audience,omitempty"`
+	ExpectedAudience string   `json:"expectedAudience"`
+	// IssuedAt is when the token-minter last refreshed the token
+	IssuedAt  time.Time `json:"issuedAt,omitzero"`
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+	ReadAt    time.Time `json:"readAt,omitzero"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// CredentialsStatus describes the last re-validation of the credential
+// configuration in the credentials-file auth mode
+type CredentialsStatus struct {
+	Path              string    `json:"path"`
+	ExpectedTokenFile string    `json:"expectedTokenFile"`
+	ExpectedProvider  string    `json:"expectedProvider"`
+	CheckedAt         time.Time `json:"checkedAt"`
+	// Changes are the fields that differ from the configuration read at startup
+	Changes []credconfig.Change `json:"changes,omitempty"`
+	Error   string              `json:"error,omitempty"`
+}
+
+// CheckStatus is the latest outcome of one API check
+type CheckStatus struct {
+	Name        string    `json:"name"`
+	Passed      bool      `json:"passed"`
+	Error       string    `json:"error,omitempty"`
+	Latency     string    `json:"latency"`
+	LastRun     time.Time `json:"lastRun"`
+	LastSuccess time.Time `json:"lastSuccess,omitzero"`
+}
+
+// TenantStatus is the latest cycle of one tenant in the multi-tenant mode.
+// Checks named check@other are isolation probes against another tenant's
+// project, passed when GCP denied them.
+type TenantStatus struct {
+	Name      string        `json:"name"`
+	ProjectID string        `json:"projectID"`
+	LastCycle time.Time     `json:"lastCycle"`
+	Checks    []CheckStatus `json:"checks"`
+}
+
+// Status collects the results of the check cycles for the HTTP endpoints.
+// It is safe for concurrent use.
+type Status struct {
+	mu        sync.RWMutex
+	interval  time.Duration
+	started   time.Time
+	lastCycle time.Time
+	token     TokenStatus
+	checks    map[string]*CheckStatus
+	order     []string
+	// tenants are the per-tenant results of the multi-tenant mode
+	tenants     map[string]*TenantStatus
+	tenantOrder []string
+	// credentials is nil unless the credential configuration is watched
+	credentials *CredentialsStatus
+	// tokens reports the access token refreshes, nil in tests
+	tokens *token.Manager
+	// minter reports the TokenRequest API calls, nil unless the app mints
+	// the subject token itself
+	minter *tokenrequest.Minter
+	// chain reports the health of the credential sources, nil unless the
+	// chain auth mode is used
+	chain *credentialChain
+
+	registry      *prometheus.Registry
+	checkUp       *prometheus.GaugeVec
+	checkDuration *prometheus.GaugeVec
+	checkLastRun  *prometheus.GaugeVec
+	tokenExpiry   prometheus.Gauge
+	tokenIssued   prometheus.Gauge
+	cycles        *prometheus.CounterVec
+	credsValid    prometheus.Gauge
+	credsChanges  prometheus.Gauge
+	tenantUp      *prometheus.GaugeVec
+}
+
+// NewStatus returns an empty status for checks run every cfg.Interval
+func NewStatus(cfg *Config, tokens *token.Manager) *Status {
+	s := &Status{
+		interval: cfg.Interval,
+		tokens:   tokens,
+		started:  time.Now(),
+		token:    TokenStatus{ExpectedAudience: cfg.Audience},
+		checks:   map[string]*CheckStatus{},
+		tenants:  map[string]*TenantStatus{},
+		registry: prometheus.NewRegistry(),
+		checkUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wif_check_success",
+			Help: "Whether the last run of the API check succeeded (1) or failed (0).",
+		}, []string{"check"}),
+		checkDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wif_check_duration_seconds",
+			Help: "Duration of the last run of the API check.",
+		}, []string{"check"}),
+		checkLastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wif_check_last_run_timestamp_seconds",
+			Help: "Unix time of the last run of the API check.",
+		}, []string{"check"}),
+		tokenExpiry: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "wif_token_expiry_timestamp_seconds",
+			Help: "Unix time at which the projected service account token expires.",
+		}),
+		tokenIssued: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "wif_token_issued_timestamp_seconds",
+			Help: "Unix time at which the projected service account token was issued.",
+		}),
+		cycles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wif_check_cycles_total",
+			Help: "Completed check cycles by result.",
+		}, []string{"result"}),
+		credsValid: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "wif_credentials_valid",
+			Help: "Whether the credential configuration matches the deployment and is unchanged since startup (1) or not (0).",
+		}),
+		credsChanges: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "wif_credentials_changed_fields",
+			Help: "Fields of the credential configuration that changed since startup.",
+		}),
+		tenantUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "wif_tenant_check_success",
+			Help: "Whether the last run of the tenant's check or isolation probe succeeded (1) or failed (0).",
+		}, []string{"tenant", "check"}),
+	}
+	s.registry.MustRegister(s.checkUp, s.checkDuration, s.checkLastRun, s.tokenExpiry, s.tokenIssued, s.cycles)
+	switch {
+	case cfg.TenantsDir != "":
+		s.registry.MustRegister(s.tenantUp)
+	case cfg.AuthMode == authModeCredentialsFile:
+		s.registry.MustRegister(s.credsValid, s.credsChanges)
+	}
+	if tokens != nil {
+		s.registerTokenMetrics(tokens)
+	}
+	return s
+}
+
+// registerTokenMetrics exposes the token manager's refresh activity, read at scrape time
+func (s *Status) registerTokenMetrics(tokens *token.Manager) {
+	s.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "wif_access_token_refreshes_total",
+			Help: "Successful access token refreshes.",
+		}, func() float64 { return float64(tokens.Stats().Refreshes) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "wif_access_token_refresh_failures_total",
+			Help: "Failed access token refreshes.",
+		}, func() float64 { return float64(tokens.Stats().RefreshFailures) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "wif_access_token_expiry_timestamp_seconds",
+			Help: "Unix time at which the cached access token expires.",
+		}, func() float64 { return unixSeconds(tokens.Stats().AccessTokenExpiry) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "wif_access_token_last_refresh_timestamp_seconds",
+			Help: "Unix time of the last successful access token refresh.",
+		}, func() float64 { return unixSeconds(tokens.Stats().LastRefresh) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "wif_token_file_reloads_total",
+			Help: "Changes of the token file picked up by the token manager.",
+		}, func() float64 { return float64(tokens.Stats().FileReloads) }),
+	)
+}
+
+// registerTelemetry exposes the latency and outcome of GCP calls and token exchanges
+func (s *Status) registerTelemetry(metrics *telemetry.Metrics) {
+	s.registry.MustRegister(metrics.Collectors()...)
+}
+
+// registerMinter exposes the TokenRequest API calls of the tokenrequest
+// subject token source
+func (s *Status) registerMinter(minter *tokenrequest.Minter) {
+	s.mu.Lock()
+	s.minter = minter
+	s.mu.Unlock()
+
+	s.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "wif_tokenrequest_mints_total",
+			Help: "Service account tokens minted with the TokenRequest API.",
+		}, func() float64 { return float64(minter.Stats().Mints) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "wif_tokenrequest_failures_total",
+			Help: "Failed TokenRequest API calls.",
+		}, func() float64 { return float64(minter.Stats().Failures) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "wif_tokenrequest_latency_seconds",
+			Help: "Duration of the last successful TokenRequest API call.",
+		}, func() float64 { return minter.Stats().LastLatency.Seconds() }),
+	)
+}
+
+// registerChain exposes the health of the sources of the chain auth mode
+func (s *Status) registerChain(chain *credentialChain) {
+	s.mu.Lock()
+	s.chain = chain
+	s.mu.Unlock()
+
+	// The sources do not change, so each gets its own series
+	for i, h := range chain.Health() {
+		labels := prometheus.Labels{"source": h.Name}
+		health := func() SourceHealth { return chain.Health()[i] }
+		s.registry.MustRegister(
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "wif_credential_source_available",
+				Help:        "Whether the credential source could be set up (1) or not (0).",
+				ConstLabels: labels,
+			}, func() float64 { return boolGauge(health().Available) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "wif_credential_source_active",
+				Help:        "Whether the last access token came from the credential source (1) or not (0).",
+				ConstLabels: labels,
+			}, func() float64 { return boolGauge(health().Active) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "wif_credential_source_successes_total",
+				Help:        "Access tokens obtained from the credential source.",
+				ConstLabels: labels,
+			}, func() float64 { return float64(health().Successes) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name:        "wif_credential_source_failures_total",
+				Help:        "Failed attempts to obtain an access token from the credential source.",
+				ConstLabels: labels,
+			}, func() float64 { return float64(health().Failures) }),
+		)
+	}
+}
+
+// boolGauge returns 1 for true and 0 for false
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unixSeconds returns t as a Unix timestamp, 0 for the zero time
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
+
+// RecordToken stores the claims of the token read at the start of a cycle.
+// err is the read, parse or validation error, if any.
+func (s *Status) RecordToken(claims *token.Claims, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := TokenStatus{ExpectedAudience: s.token.ExpectedAudience, ReadAt: time.Now()}
+	if claims != nil {
+		t.Issuer = claims.Issuer
+		t.Subject = claims.Subject
+		t.Audience = claims.Audience
+		t.IssuedAt = claims.IssuedAt
+		t.ExpiresAt = claims.ExpiresAt
+		s.tokenExpiry.Set(float64(claims.ExpiresAt.Unix()))
+		if !claims.IssuedAt.IsZero() {
+			s.tokenIssued.Set(float64(claims.IssuedAt.Unix()))
+		}
+	}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	s.token = t
+}
+
+// RecordCredentials stores the outcome of a credential configuration check.
+// err is the read error or the mismatches with expected, if any.
+func (s *Status) RecordCredentials(path string, expected credconfig.Expectations, changes []credconfig.Change, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &CredentialsStatus{
+		Path:              path,
+		ExpectedTokenFile: expected.TokenFile,
+		ExpectedProvider:  expected.Audience,
+		CheckedAt:         time.Now(),
+		Changes:           changes,
+	}
+	valid := 1.0
+	if err != nil {
+		c.Error = err.Error()
+		valid = 0
+	}
+	if len(changes) > 0 {
+		valid = 0
+	}
+	s.credsValid.Set(valid)
+	s.credsChanges.Set(float64(len(changes)))
+	s.credentials = c
+}
+
+// RecordCycle stores the results of a completed check cycle
+func (s *Status) RecordCycle(results []CheckResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	failed := false
+	for _, r := range results {
+		c, ok := s.checks[r.Name]
+		if !ok {
+			c = &CheckStatus{Name: r.Name}
+			s.checks[r.Name] = c
+			s.order = append(s.order, r.Name)
+		}
+		c.Passed = r.Err == nil
+		c.Error = ""
+		c.Latency = r.Duration.Round(time.Millisecond).String()
+		c.LastRun = now
+
+		up := 1.0
+		if r.Err != nil {
+			c.Error = r.Err.Error()
+			failed = true
+			up = 0
+		} else {
+			c.LastSuccess = now
+		}
+		s.checkUp.WithLabelValues(r.Name).Set(up)
+		s.checkDuration.WithLabelValues(r.Name).Set(r.Duration.Seconds())
+		s.checkLastRun.WithLabelValues(r.Name).Set(float64(now.Unix()))
+	}
+
+	if failed || len(results) == 0 {
+		s.cycles.WithLabelValues("failure").Inc()
+	} else {
+		s.cycles.WithLabelValues("success").Inc()
+	}
+	s.lastCycle = now
+}
+
+// RecordTenantCycle stores the results of a completed cycle of one tenant
+func (s *Status) RecordTenantCycle(name, projectID string, results []CheckResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	t, ok := s.tenants[name]
+	if !ok {
+		t = &TenantStatus{Name: name}
+		s.tenants[name] = t
+		s.tenantOrder = append(s.tenantOrder, name)
+	}
+	t.ProjectID = projectID
+	t.LastCycle = now
+	t.Checks = make([]CheckStatus, 0, len(results))
+
+	failed := false
+	for _, r := range results {
+		c := CheckStatus{
+			Name:    r.Name,
+			Passed:  r.Err == nil,
+			Latency: r.Duration.Round(time.Millisecond).String(),
+			LastRun: now,
+		}
+		up := 1.0
+		if r.Err != nil {
+			c.Error = r.Err.Error()
+			failed = true
+			up = 0
+		} else {
+			c.LastSuccess = now
+		}
+		t.Checks = append(t.Checks, c)
+		s.tenantUp.WithLabelValues(name, r.Name).Set(up)
+	}
+
+	if failed || len(results) == 0 {
+		s.cycles.WithLabelValues("failure").Inc()
+	} else {
+		s.cycles.WithLabelValues("success").Inc()
+	}
+	s.lastCycle = now
+}
+
+// healthy reports whether the last cycle ran recently and every check passed,
+// with the reason when it did not
+func (s *Status) healthy(now time.Time) (bool, string) {
+	if s.lastCycle.IsZero() {
+		return false, "no check cycle completed yet"
+	}
+	if now.Sub(s.lastCycle) > staleCycles*s.interval {
+		return false, "last check cycle is stale"
+	}
+	if c := s.credentials; c != nil {
+		if c.Error != "" {
+			return false, "credentials: " + c.Error
+		}
+		if len(c.Changes) > 0 {
+			return false, "credentials: " + c.Changes[0].String()
+		}
+	}
+	if s.token.Error != "" {
+		return false, "token: " + s.token.Error
+	}
+	for _, name := range s.tenantOrder {
+		t := s.tenants[name]
+		if now.Sub(t.LastCycle) > staleCycles*s.interval {
+			return false, "tenant " + name + ": last check cycle is stale"
+		}
+		for _, c := range t.Checks {
+			if !c.Passed {
+				return false, "tenant " + name + ": check " + c.Name + " failed"
+			}
+		}
+	}
+	if len(s.order) == 0 && len(s.tenantOrder) == 0 {
+		return false, "no checks ran"
+	}
+	for _, name := range s.order {
+		if c := s.checks[name]; !c.Passed {
+			return false, "check " + name + " failed"
+		}
+	}
+	return true, ""
+}
+
+// Handler serves /healthz, /status and /metrics
+func (s *Status) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.serveHealthz)
+	mux.HandleFunc("/status", s.serveStatus)
+	mux.Handle("/metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// serveHealthz returns 503 once WIF is broken so a readiness probe, and
+// anything watching the Deployment, can signal it
+func (s *Status) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	ok, reason := s.healthy(time.Now())
+	s.mu.RUnlock()
+
+	if !ok {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+func (s *Status) serveStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	ok, reason := s.healthy(time.Now())
+	resp := struct {
+		Healthy     bool                `json:"healthy"`
+		Reason      string              `json:"reason,omitempty"`
+		Started     time.Time           `json:"started"`
+		LastCycle   time.Time           `json:"lastCycle,omitzero"`
+		Token       TokenStatus         `json:"token"`
+		Refresh     *token.Stats        `json:"refresh,omitempty"`
+		Minter      *tokenrequest.Stats `json:"tokenRequest,omitempty"`
+		Sources     []SourceHealth      `json:"credentialSources,omitempty"`
+		Credentials *CredentialsStatus  `json:"credentials,omitempty"`
+		Checks      []CheckStatus       `json:"checks"`
+		Tenants     []TenantStatus      `json:"tenants,omitempty"`
+	}{
+		Healthy:     ok,
+		Reason:      reason,
+		Started:     s.started,
+		LastCycle:   s.lastCycle,
+		Token:       s.token,
+		Credentials: s.credentials,
+		Checks:      make([]CheckStatus, 0, len(s.order)),
+	}
+	for _, name := range s.order {
+		resp.Checks = append(resp.Checks, *s.checks[name])
+	}
+	for _, name := range s.tenantOrder {
+		resp.Tenants = append(resp.Tenants, *s.tenants[name])
+	}
+	minter, chain := s.minter, s.chain
+	s.mu.RUnlock()
+
+	if minter != nil {
+		stats := minter.Stats()
+		resp.Minter = &stats
+	}
+	if chain != nil {
+		resp.Sources = chain.Health()
+	}
+
+	if s.tokens != nil {
+		stats := s.tokens.Stats()
+		resp.Refresh = &stats
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resp); err != nil {
+		component("server").Warn("Failed to write status response", errorAttr(err))
+	}
+}
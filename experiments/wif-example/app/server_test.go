@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/token"
+	"golang.org/x/oauth2"
+)
+
+func newTestStatus() *Status {
+	return NewStatus(&Config{Audience: "openshift", Interval: 30 * time.Second}, nil)
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	body, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(body)
+}
+
+func TestHealthz(t *testing.T) {
+	s := newTestStatus()
+	h := s.Handler()
+
+	if code, body := get(t, h, "/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "no check cycle") {
+		t.Errorf("before first cycle: %d %q, want 503", code, body)
+	}
+
+	s.RecordToken(&token.Claims{ExpiresAt: time.Now().Add(time.Hour)}, nil)
+	s.RecordCycle([]CheckResult{{Name: "compute", Duration: time.Second}})
+	if code, _ := get(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("after passing cycle: %d, want 200", code)
+	}
+
+	s.RecordCycle([]CheckResult{{Name: "compute", Err: errors.New("permission denied")}})
+	if code, body := get(t, h, "/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "check compute failed") {
+		t.Errorf("after failing cycle: %d %q, want 503", code, body)
+	}
+
+	s.RecordCycle([]CheckResult{{Name: "compute"}})
+	s.lastCycle = time.Now().Add(-time.Hour)
+	if code, body := get(t, h, "/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "stale") {
+		t.Errorf("after stale cycle: %d %q, want 503", code, body)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	s := newTestStatus()
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	s.RecordToken(&token.Claims{Subject: "system:serviceaccount:default:wif-app-workload-sa", Audience: []string{"openshift"}, ExpiresAt: expires}, nil)
+	s.RecordCycle([]CheckResult{{Name: "compute", Duration: 1500 * time.Millisecond}, {Name: "storage", Err: errors.New("forbidden")}})
+
+	code, body := get(t, s.Handler(), "/status")
+	if code != http.StatusOK {
+		t.Fatalf("/status = %d", code)
+	}
+
+	var resp struct {
+		Healthy bool
+		Token   TokenStatus
+		Checks  []CheckStatus
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("decoding /status: %v\n%s", err, body)
+	}
+	if resp.Healthy {
+		t.Errorf("healthy = true with a failing check")
+	}
+	if !resp.Token.ExpiresAt.Equal(expires) || resp.Token.ExpectedAudience != "openshift" {
+		t.Errorf("token = %+v", resp.Token)
+	}
+	if len(resp.Checks) != 2 || resp.Checks[0].Name != "compute" || !resp.Checks[0].Passed || resp.Checks[0].Latency != "1.5s" {
+		t.Fatalf("checks = %+v", resp.Checks)
+	}
+	if resp.Checks[1].Passed || resp.Checks[1].Error != "forbidden" || !resp.Checks[1].LastSuccess.IsZero() {
+		t.Errorf("storage = %+v, want failed with no last success", resp.Checks[1])
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := newTestStatus()
+	s.RecordToken(&token.Claims{ExpiresAt: time.Unix(1762693200, 0)}, nil)
+	s.RecordCycle([]CheckResult{{Name: "compute"}, {Name: "storage", Err: errors.New("forbidden")}})
+
+	_, body := get(t, s.Handler(), "/metrics")
+	for _, want := range []string{
+		`wif_check_success{check="compute"} 1`,
+		`wif_check_success{check="storage"} 0`,
+		`wif_check_cycles_total{result="failure"} 1`,
+		`wif_token_expiry_timestamp_seconds 1.7626932e+09`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics does not contain %s", want)
+		}
+	}
+}
+
+// staticSource returns an access token valid for an hour
+type staticSource struct{}
+
+func (staticSource) Token() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestMetrics_TokenManager(t *testing.T) {
+	tokens, err := token.NewManager(token.ManagerConfig{TokenFile: "/nonexistent/token", Source: staticSource{}})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if _, err := tokens.Token(); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	s := NewStatus(&Config{Interval: 30 * time.Second}, tokens)
+
+	_, body := get(t, s.Handler(), "/metrics")
+	for _, want := range []string{
+		"wif_access_token_refreshes_total 1",
+		"wif_access_token_refresh_failures_total 0",
+		"wif_access_token_expiry_timestamp_seconds 1.",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics does not contain %s", want)
+		}
+	}
+
+	_, body = get(t, s.Handler(), "/status")
+	if !strings.Contains(body, `"refreshes": 1`) {
+		t.Errorf("/status does not report the refresh:\n%s", body)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/credconfig"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+const (
+	// storageDownloadEndpoint serves the signed URLs
+	storageDownloadEndpoint = "https://storage.googleapis.com"
+	// signedURLExpiry is how long a signed URL of the signedurl check is valid
+	signedURLExpiry = 5 * time.Minute
+	// signedURLAlgorithm is the V4 signing algorithm of RSA signatures, the
+	// only one IAM signBlob produces
+	signedURLAlgorithm = "GOOG4-RSA-SHA256"
+)
+
+// signFunc signs a payload with a service account key, e.g. with IAM signBlob
+type signFunc func(ctx context.Context, payload []byte) ([]byte, error)
+
+// verifySignedURL is the signedurl check: without a private key, a Cloud
+// Storage V4 signed URL can only be signed by IAM signBlob, the way
+// ignition payloads are handed to machines that have no credentials
+func verifySignedURL(ctx context.Context, cfg *Config, opts ...option.ClientOption) error {
+	return fetchSignedObject(ctx, cfg, storageDownloadEndpoint, opts...)
+}
+
+// fetchSignedObject reads the metadata of SIGNED_URL_OBJECT, signs a URL of
+// the object on endpoint with the signing service account and fetches it
+// without credentials, checking the content against the metadata
+func fetchSignedObject(ctx context.Context, cfg *Config, endpoint string, opts ...option.ClientOption) error {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(cfg.SignedURLObject, configSourceObject), "/")
+	if !strings.HasPrefix(cfg.SignedURLObject, configSourceObject) || !ok || bucket == "" || object == "" {
+		return fmt.Errorf("SIGNED_URL_OBJECT (or -signed-url-object) must name the object to sign a URL for as gs://<bucket>/<object>")
+	}
+	signer, err := signingServiceAccount(cfg)
+	if err != nil {
+		return err
+	}
+	logger := component("signedurl")
+
+	svc, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create storage client: %w", err)
+	}
+	attrs, err := svc.Objects.Get(bucket, object).Fields("size", "md5Hash", "generation").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to read the metadata of %s: %w", cfg.SignedURLObject, err)
+	}
+
+	iam, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create iamcredentials client: %w", err)
+	}
+	sign := func(ctx context.Context, payload []byte) ([]byte, error) {
+		name := "projects/-/serviceAccounts/" + signer
+		resp, err := iam.Projects.ServiceAccounts.SignBlob(name, &iamcredentials.SignBlobRequest{
+			Payload: base64.StdEncoding.EncodeToString(payload),
+		}).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("IAM signBlob as %s failed: %w", signer, err)
+		}
+		logger.Debug("Signed with IAM signBlob", "serviceAccount", signer, "keyID", resp.KeyId)
+		return base64.StdEncoding.DecodeString(resp.SignedBlob)
+	}
+
+	signed, err := signURL(ctx, endpoint, bucket, object, signer, time.Now(), signedURLExpiry, sign)
+	if err != nil {
+		return err
+	}
+	logger.Info("Signed URL", "object", cfg.SignedURLObject, "serviceAccount", signer, "expiresIn", signedURLExpiry)
+
+	// The signature is the only credential of the request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, signed, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the signed URL of %s: %w", cfg.SignedURLObject, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("signed URL of %s was rejected with %s: %s", cfg.SignedURLObject, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	hash := md5.New()
+	n, err := io.Copy(hash, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the signed URL of %s: %w", cfg.SignedURLObject, err)
+	}
+	if uint64(n) != attrs.Size {
+		return fmt.Errorf("signed URL of %s returned %d bytes, the object has %d", cfg.SignedURLObject, n, attrs.Size)
+	}
+	// Composite objects have no MD5 hash
+	if attrs.Md5Hash != "" && base64.StdEncoding.EncodeToString(hash.Sum(nil)) != attrs.Md5Hash {
+		return fmt.Errorf("signed URL of %s returned content that does not match the MD5 hash of the object", cfg.SignedURLObject)
+	}
+
+	logger.Info("Fetched object with the signed URL", "object", cfg.SignedURLObject, "generation", attrs.Generation, "bytes", n)
+	return nil
+}
+
+// signingServiceAccount returns the service account the signed URLs are
+// signed as: SIGNING_SERVICE_ACCOUNT, else the impersonated one. A federated
+// principal has no key to sign with, it can only ask IAM to sign as a
+// service account it may impersonate.
+func signingServiceAccount(cfg *Config) (string, error) {
+	if cfg.SigningServiceAccount != "" {
+		return cfg.SigningServiceAccount, nil
+	}
+	if cfg.ImpersonateServiceAccount != "" {
+		return cfg.ImpersonateServiceAccount, nil
+	}
+	if cfg.AuthMode == authModeCredentialsFile {
+		if _, cc, err := readCredentialConfig(); err == nil {
+			if email, ok := credconfig.ImpersonatedServiceAccount(cc.ServiceAccountImpersonationURL); ok {
+				return email, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no service account to sign as: the federated identity impersonates none, set SIGNING_SERVICE_ACCOUNT (or -signing-service-account)")
+}
+
+// signURL returns a V4 signed URL to GET an object on endpoint, valid for
+// expiry from now. sign signs the string to sign as serviceAccount.
+func signURL(ctx context.Context, endpoint, bucket, object, serviceAccount string, now time.Time, expiry time.Duration, sign signFunc) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid storage endpoint %q", endpoint)
+	}
+
+	path := "/" + bucket + "/" + uriEncode(object, false)
+	query := signedURLQuery(serviceAccount, now, expiry)
+	payload := signedURLStringToSign(u.Host, path, query, now)
+	signature, err := sign(ctx, []byte(payload))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s://%s%s?%s&X-Goog-Signature=%s", u.Scheme, u.Host, path, query, hex.EncodeToString(signature)), nil
+}
+
+// signedURLQuery returns the canonical query string of a V4 signed URL,
+// without the signature
+func signedURLQuery(serviceAccount string, now time.Time, expiry time.Duration) string {
+	now = now.UTC()
+	params := map[string]string{
+		"X-Goog-Algorithm":     signedURLAlgorithm,
+		"X-Goog-Credential":    serviceAccount + "/" + signedURLScope(now),
+		"X-Goog-Date":          now.Format("20060102T150405Z"),
+		"X-Goog-Expires":       fmt.Sprint(int(expiry.Seconds())),
+		"X-Goog-SignedHeaders": "host",
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(params[name], true))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// signedURLScope is the credential scope of a V4 signature
+func signedURLScope(now time.Time) string {
+	return now.UTC().Format("20060102") + "/auto/storage/goog4_request"
+}
+
+// signedURLStringToSign returns what is signed for a GET of path on host
+func signedURLStringToSign(host, path, query string, now time.Time) string {
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		path,
+		query,
+		"host:" + host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		signedURLAlgorithm,
+		now.UTC().Format("20060102T150405Z"),
+		signedURLScope(now),
+		hex.EncodeToString(digest[:]),
+	}, "\n")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters of
+// RFC 3986, and slashes unless encodeSlash is set, as V4 signing expects
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+const signingAccount = "ignition@project.iam.gserviceaccount.com"
+
+// verifySignature checks the V4 signature of a signed URL request
+func verifySignature(key *rsa.PublicKey, host string, u *url.URL) error {
+	query, signature, ok := strings.Cut(u.RawQuery, "&X-Goog-Signature=")
+	if !ok {
+		return errors.New("no signature")
+	}
+	date, err := time.Parse("20060102T150405Z", u.Query().Get("X-Goog-Date"))
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(signedURLStringToSign(host, u.EscapedPath(), query, date)))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+}
+
+func rsaSigner(t *testing.T) (*rsa.PrivateKey, signFunc) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, func(_ context.Context, payload []byte) ([]byte, error) {
+		digest := sha256.Sum256(payload)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	}
+}
+
+func TestSignURL(t *testing.T) {
+	key, sign := rsaSigner(t)
+	now := time.Date(2024, 3, 1, 9, 30, 0, 0, time.FixedZone("CET", 3600))
+
+	signed, err := signURL(context.Background(), storageDownloadEndpoint, "ignition", "workers/pool a+1.ign", signingAccount, now, 5*time.Minute, sign)
+	if err != nil {
+		t.Fatalf("signURL() error = %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := u.EscapedPath(), "/ignition/workers/pool%20a%2B1.ign"; got != want {
+		t.Errorf("path = %q, want %q", got, want)
+	}
+	q := u.Query()
+	for name, want := range map[string]string{
+		"X-Goog-Algorithm":     "GOOG4-RSA-SHA256",
+		"X-Goog-Credential":    signingAccount + "/20240301/auto/storage/goog4_request",
+		"X-Goog-Date":          "20240301T083000Z",
+		"X-Goog-Expires":       "300",
+		"X-Goog-SignedHeaders": "host",
+	} {
+		if got := q.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if !strings.Contains(u.RawQuery, "X-Goog-Credential=ignition%40project.iam.gserviceaccount.com%2F20240301%2F") {
+		t.Errorf("credential is not encoded: %s", u.RawQuery)
+	}
+	if err := verifySignature(&key.PublicKey, "storage.googleapis.com", u); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+
+	failing := func(context.Context, []byte) ([]byte, error) { return nil, errors.New("denied") }
+	if _, err := signURL(context.Background(), storageDownloadEndpoint, "ignition", "worker.ign", signingAccount, now, time.Minute, failing); err == nil {
+		t.Error("signURL() succeeded although signing failed")
+	}
+}
+
+// fakeSignedStorage serves object metadata, IAM signBlob and signed URL
+// downloads of worker.ign in bucket ignition
+func fakeSignedStorage(t *testing.T, content, served string) *httptest.Server {
+	t.Helper()
+	key, sign := rsaSigner(t)
+	sum := md5.Sum([]byte(content))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/b/ignition/o/worker.ign"):
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{
+				"size":       strconv.Itoa(len(content)),
+				"md5Hash":    base64.StdEncoding.EncodeToString(sum[:]),
+				"generation": "3",
+			})
+		case r.URL.Path == "/v1/projects/-/serviceAccounts/"+signingAccount+":signBlob":
+			var req struct{ Payload string }
+			json.NewDecoder(r.Body).Decode(&req)
+			payload, _ := base64.StdEncoding.DecodeString(req.Payload)
+			sig, _ := sign(r.Context(), payload)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"keyId": "k1", "signedBlob": base64.StdEncoding.EncodeToString(sig)})
+		case r.URL.Path == "/ignition/worker.ign":
+			if r.Header.Get("Authorization") != "" {
+				http.Error(w, "signed URLs carry no credentials", http.StatusBadRequest)
+				return
+			}
+			if err := verifySignature(&key.PublicKey, r.Host, r.URL); err != nil {
+				http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(served))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"code":403,"message":"Permission 'iam.serviceAccounts.signBlob' denied"}}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchSignedObject(t *testing.T) {
+	const content = `{"ignition":{"version":"3.4.0"}}`
+	tests := []struct {
+		name    string
+		cfg     Config
+		served  string
+		wantErr string
+	}{
+		{name: "signed and fetched", cfg: Config{SignedURLObject: "gs://ignition/worker.ign", SigningServiceAccount: signingAccount}, served: content},
+		{name: "impersonated service account", cfg: Config{SignedURLObject: "gs://ignition/worker.ign", ImpersonateServiceAccount: signingAccount}, served: content},
+		{name: "signBlob denied", cfg: Config{SignedURLObject: "gs://ignition/worker.ign", SigningServiceAccount: "other@project.iam.gserviceaccount.com"}, wantErr: "IAM signBlob as other@project.iam.gserviceaccount.com failed"},
+		{name: "content mismatch", cfg: Config{SignedURLObject: "gs://ignition/worker.ign", SigningServiceAccount: signingAccount}, served: `{"ignition":{"version":"3.2.0"}}`, wantErr: "does not match the MD5 hash"},
+		{name: "truncated", cfg: Config{SignedURLObject: "gs://ignition/worker.ign", SigningServiceAccount: signingAccount}, served: content[:10], wantErr: "returned 10 bytes"},
+		{name: "no object", cfg: Config{SigningServiceAccount: signingAccount}, wantErr: "SIGNED_URL_OBJECT"},
+		{name: "no bucket", cfg: Config{SignedURLObject: "ignition/worker.ign", SigningServiceAccount: signingAccount}, wantErr: "SIGNED_URL_OBJECT"},
+		{name: "no signer", cfg: Config{SignedURLObject: "gs://ignition/worker.ign", AuthMode: authModeSTS}, wantErr: "no service account to sign as"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fakeSignedStorage(t, content, tt.served)
+			opts := []option.ClientOption{option.WithEndpoint(srv.URL), option.WithoutAuthentication()}
+
+			err := fetchSignedObject(context.Background(), &tt.cfg, srv.URL, opts...)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("fetchSignedObject() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("fetchSignedObject() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
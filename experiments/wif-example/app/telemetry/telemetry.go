@@ -0,0 +1,166 @@
+// Package telemetry measures the GCP API calls of the example and the token
+// exchanges authenticating them, to show how much latency federation adds to
+// control-plane cloud calls and when they run into quota.
+package telemetry
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2"
+)
+
+// maxErrorBody bounds how much of an error response is read to look for quota errors
+const maxErrorBody = 64 << 10
+
+// quotaReasons appear in the bodies of 403 responses caused by quota or rate limits
+var quotaReasons = []string{"ratelimitexceeded", "quotaexceeded", "resource_exhausted", "quota exceeded"}
+
+// Metrics records GCP calls and token exchanges. It is safe for concurrent use.
+type Metrics struct {
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	quotaExceeded    *prometheus.CounterVec
+	exchanges        *prometheus.CounterVec
+	exchangeDuration prometheus.Histogram
+}
+
+// NewMetrics returns metrics not yet registered anywhere, see Collectors
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wif_gcp_requests_total",
+			Help: "GCP API requests by service, HTTP method and response code (error when no response was received).",
+		}, []string{"service", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wif_gcp_request_duration_seconds",
+			Help:    "Latency of GCP API requests, including waiting for an access token.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"service"}),
+		quotaExceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wif_gcp_quota_exceeded_total",
+			Help: "GCP API requests rejected for quota or rate limits.",
+		}, []string{"service"}),
+		exchanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wif_token_exchanges_total",
+			Help: "Exchanges of the subject token for an access token by result.",
+		}, []string{"result"}),
+		exchangeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "wif_token_exchange_duration_seconds",
+			Help:    "Latency of exchanging the subject token for an access token, including impersonation.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+}
+
+// Collectors returns the metrics to register with a Prometheus registry
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.requests, m.requestDuration, m.quotaExceeded, m.exchanges, m.exchangeDuration}
+}
+
+// Transport returns a round tripper recording every request sent through base
+func (m *Metrics) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{metrics: m, base: base}
+}
+
+type transport struct {
+	metrics *Metrics
+	base    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	service := Service(req)
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	t.metrics.requestDuration.WithLabelValues(service).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		t.metrics.requests.WithLabelValues(service, req.Method, "error").Inc()
+		return nil, err
+	}
+	t.metrics.requests.WithLabelValues(service, req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	if isQuotaExceeded(resp) {
+		t.metrics.quotaExceeded.WithLabelValues(service).Inc()
+	}
+	return resp, nil
+}
+
+// isQuotaExceeded reports whether resp is a quota or rate limit error. The
+// body of a 403 is read to tell quota apart from missing permissions, and
+// put back for the client library.
+func isQuotaExceeded(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+	default:
+		return false
+	}
+
+	head, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	if err != nil {
+		return false
+	}
+
+	text := strings.ToLower(string(head))
+	for _, r := range quotaReasons {
+		if strings.Contains(text, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Service names the GCP API a request goes to: the first label of
+// *.googleapis.com hosts, or the first path segment on www.googleapis.com.
+// Other hosts, e.g. test servers, are named by host.
+func Service(req *http.Request) string {
+	host := req.URL.Hostname()
+	name, ok := strings.CutSuffix(host, ".googleapis.com")
+	if !ok {
+		return host
+	}
+	if name == "www" {
+		if segment, _, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/"); segment != "" {
+			return segment
+		}
+	}
+	return name
+}
+
+// TokenSource returns a token source recording how long every call to ts takes
+func (m *Metrics) TokenSource(ts oauth2.TokenSource) oauth2.TokenSource {
+	return &tokenSource{metrics: m, base: ts}
+}
+
+type tokenSource struct {
+	metrics *Metrics
+	base    oauth2.TokenSource
+}
+
+// Token implements oauth2.TokenSource
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	start := time.Now()
+	tok, err := s.base.Token()
+	s.metrics.exchangeDuration.Observe(time.Since(start).Seconds())
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	s.metrics.exchanges.WithLabelValues(result).Inc()
+	return tok, err
+}
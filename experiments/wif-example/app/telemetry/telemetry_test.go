@@ -0,0 +1,115 @@
+package telemetry
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/oauth2"
+)
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte(`{}`))
+		case "/rate-limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/quota":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"code":403,"message":"Quota exceeded for quota metric 'Queries'","errors":[{"reason":"rateLimitExceeded"}]}}`))
+		case "/denied":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"code":403,"message":"Required 'compute.instances.list' permission"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	m := NewMetrics()
+	client := &http.Client{Transport: m.Transport(nil)}
+	service := Service(httptest.NewRequest(http.MethodGet, srv.URL, nil))
+
+	for _, path := range []string{"/ok", "/ok", "/rate-limited", "/quota", "/denied"} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		// The client library must still see the whole error body
+		if path == "/quota" && !strings.Contains(string(body), "rateLimitExceeded") {
+			t.Errorf("body of %s after inspection = %q", path, body)
+		}
+	}
+
+	counts := map[string]float64{
+		"200": testutil.ToFloat64(m.requests.WithLabelValues(service, http.MethodGet, "200")),
+		"429": testutil.ToFloat64(m.requests.WithLabelValues(service, http.MethodGet, "429")),
+		"403": testutil.ToFloat64(m.requests.WithLabelValues(service, http.MethodGet, "403")),
+	}
+	if counts["200"] != 2 || counts["429"] != 1 || counts["403"] != 2 {
+		t.Errorf("request counts = %v", counts)
+	}
+	if got := testutil.ToFloat64(m.quotaExceeded.WithLabelValues(service)); got != 2 {
+		t.Errorf("quota exceeded = %v, want 2 (429 and the quota 403)", got)
+	}
+	if got := testutil.CollectAndCount(m.requestDuration); got != 1 {
+		t.Errorf("%d duration series, want 1", got)
+	}
+
+	if _, err := client.Get("http://127.0.0.1:1/unreachable"); err == nil {
+		t.Fatal("GET of a closed port succeeded")
+	}
+	if got := testutil.ToFloat64(m.requests.WithLabelValues("127.0.0.1", http.MethodGet, "error")); got != 1 {
+		t.Errorf("transport errors = %v, want 1", got)
+	}
+}
+
+func TestService(t *testing.T) {
+	tests := map[string]string{
+		"https://compute.googleapis.com/compute/v1/projects/p/zones": "compute",
+		"https://storage.googleapis.com/storage/v1/b?project=p":      "storage",
+		"https://www.googleapis.com/oauth2/v2/tokeninfo":             "oauth2",
+		"https://sts.googleapis.com/v1/token":                        "sts",
+		"https://iamcredentials.googleapis.com/v1/projects/-/sa:gat": "iamcredentials",
+		"http://127.0.0.1:8080/compute/v1/projects/p/zones":          "127.0.0.1",
+	}
+	for url, want := range tests {
+		if got := Service(httptest.NewRequest(http.MethodGet, url, nil)); got != want {
+			t.Errorf("Service(%s) = %s, want %s", url, got, want)
+		}
+	}
+}
+
+type fakeSource struct{ err error }
+
+func (s fakeSource) Token() (*oauth2.Token, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &oauth2.Token{AccessToken: "access"}, nil
+}
+
+func TestTokenSource(t *testing.T) {
+	m := NewMetrics()
+	if tok, err := m.TokenSource(fakeSource{}).Token(); err != nil || tok.AccessToken != "access" {
+		t.Errorf("Token = %v, %v", tok, err)
+	}
+	if _, err := m.TokenSource(fakeSource{err: errors.New("invalid_grant")}).Token(); err == nil {
+		t.Error("Token of a failing source succeeded")
+	}
+
+	if got := testutil.ToFloat64(m.exchanges.WithLabelValues("success")); got != 1 {
+		t.Errorf("successful exchanges = %v", got)
+	}
+	if got := testutil.ToFloat64(m.exchanges.WithLabelValues("failure")); got != 1 {
+		t.Errorf("failed exchanges = %v", got)
+	}
+	if got := testutil.CollectAndCount(m.exchangeDuration); got != 1 {
+		t.Errorf("%d exchange duration series, want 1", got)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/credconfig"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/diagnose"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/telemetry"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/token"
+	"google.golang.org/api/option"
+)
+
+// errIsolationBreach is returned by an isolation probe that reached another
+// tenant's project
+var errIsolationBreach = errors.New("cross-tenant access was allowed")
+
+// tenant is one hosted cluster of the multi-tenant mode. It runs the checks
+// with its own credential configuration, against its own project.
+type tenant struct {
+	name string
+	// cfg is the shared configuration with the tenant's project and token file
+	cfg    *Config
+	checks []Check
+	opts   []option.ClientOption
+	tokens *token.Manager
+	// others are the tenants whose projects this tenant must not reach
+	others []*tenant
+}
+
+// loadTenants reads one external-account credential configuration per tenant
+// from cfg.TenantsDir, named <tenant>.json. A tenant's project is the
+// configuration's quota_project_id, GCP_PROJECT_ID if unset.
+func loadTenants(ctx context.Context, cfg *Config, checks []Check, metrics *telemetry.Metrics) ([]*tenant, error) {
+	paths, err := filepath.Glob(filepath.Join(cfg.TenantsDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no tenant credential configurations (*.json) in %s", cfg.TenantsDir)
+	}
+
+	tenants := make([]*tenant, 0, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		cc, err := credconfig.Load(path)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", name, err)
+		}
+		if err := cc.Validate(credconfig.Expectations{}); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", name, err)
+		}
+
+		tcfg := *cfg
+		tcfg.TokenFile = cc.CredentialSource.File
+		if cc.QuotaProjectID != "" {
+			tcfg.ProjectID = cc.QuotaProjectID
+		}
+		if tcfg.ProjectID == "" {
+			return nil, fmt.Errorf("tenant %s: set quota_project_id in %s or GCP_PROJECT_ID", name, path)
+		}
+
+		tokens, err := token.NewManager(token.ManagerConfig{
+			TokenFile:     tcfg.TokenFile,
+			Source:        metrics.TokenSource(&credentialsFileSource{ctx: ctx, path: path}),
+			RefreshBefore: cfg.RefreshBefore,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", name, err)
+		}
+
+		component("tenants").Info("Loaded tenant",
+			"tenant", name, "credentialsFile", path, "project", tcfg.ProjectID, "provider", cc.Audience, "tokenFile", tcfg.TokenFile)
+		tenants = append(tenants, &tenant{
+			name:   name,
+			cfg:    &tcfg,
+			checks: checks,
+			opts:   clientOptions(tokens, metrics),
+			tokens: tokens,
+		})
+	}
+
+	// Tenants sharing a project cannot tell isolation apart from a shared grant
+	for _, t := range tenants {
+		for _, o := range tenants {
+			if o != t && o.cfg.ProjectID != t.cfg.ProjectID {
+				t.others = append(t.others, o)
+			}
+		}
+		if len(t.others) < len(tenants)-1 {
+			component("tenants").Warn("Tenant shares its project with another tenant, isolation is not probed between them",
+				"tenant", t.name, "project", t.cfg.ProjectID)
+		}
+	}
+	return tenants, nil
+}
+
+// runTenants runs a check cycle for every tenant concurrently, every
+// cfg.Interval, until ctx is done
+func runTenants(ctx context.Context, tenants []*tenant, status *Status) {
+	var wg sync.WaitGroup
+	for _, t := range tenants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			go t.tokens.Run(ctx)
+
+			ticker := time.NewTicker(t.cfg.Interval)
+			defer ticker.Stop()
+			for {
+				results := t.runCycle(ctx)
+				status.RecordTenantCycle(t.name, t.cfg.ProjectID, results)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runCycle runs the tenant's checks against its own project, which must
+// pass, then the project-scoped ones against every other tenant's project,
+// which GCP must deny
+func (t *tenant) runCycle(ctx context.Context) []CheckResult {
+	logger := component("tenants").With("tenant", t.name, "project", t.cfg.ProjectID)
+	logger.Info("Starting tenant checks")
+
+	claims, err := t.tokens.Subject()
+	if err != nil {
+		logger.Error("Failed to read tenant token", errorAttr(err))
+		return []CheckResult{{Name: "token", Err: err}}
+	}
+	if err := claims.Validate(time.Now(), tokenClockSkew); err != nil {
+		logger.Warn("Tenant token will be rejected", "token", claims, errorAttr(err))
+	}
+
+	var results []CheckResult
+	for _, c := range t.checks {
+		start := time.Now()
+		err := c.Run(ctx, t.cfg, t.opts...)
+		result := CheckResult{Name: c.Name, Err: err, Duration: time.Since(start)}
+		results = append(results, result)
+
+		if err != nil {
+			logger.Error("Check failed",
+				"check", c.Name,
+				"durationMs", result.Duration.Milliseconds(),
+				"requiredRoles", c.Roles,
+				"token", claims,
+				errorAttr(err))
+		} else {
+			logger.Info("Check passed", "check", c.Name, "durationMs", result.Duration.Milliseconds())
+		}
+	}
+
+	for _, o := range t.others {
+		for _, c := range t.checks {
+			if !c.ProjectScoped {
+				continue
+			}
+			start := time.Now()
+			err := t.probe(ctx, c, o)
+			result := CheckResult{Name: c.Name + "@" + o.name, Err: err, Duration: time.Since(start)}
+			results = append(results, result)
+
+			switch {
+			case errors.Is(err, errIsolationBreach):
+				logger.Error("Tenant reached another tenant's project", "check", c.Name, "otherTenant", o.name, "otherProject", o.cfg.ProjectID, "token", claims)
+			case err != nil:
+				logger.Warn("Isolation probe inconclusive", "check", c.Name, "otherTenant", o.name, errorAttr(err))
+			default:
+				logger.Info("Isolation probe denied as expected", "check", c.Name, "otherTenant", o.name, "durationMs", result.Duration.Milliseconds())
+			}
+		}
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	logger.Info("Tenant check cycle complete", "passed", len(results)-failed, "failed", failed)
+	return results
+}
+
+// probe runs c with the tenant's identity against the project of other.
+// It returns nil if GCP denied it and errIsolationBreach if it succeeded.
+func (t *tenant) probe(ctx context.Context, c Check, other *tenant) error {
+	target := *t.cfg
+	target.ProjectID = other.cfg.ProjectID
+
+	err := c.Run(ctx, &target, t.opts...)
+	switch {
+	case err == nil:
+		return fmt.Errorf("%w: %s reached project %s of tenant %s", errIsolationBreach, t.name, other.cfg.ProjectID, other.name)
+	case diagnose.Classify(err).Kind == diagnose.PermissionDenied:
+		return nil
+	default:
+		return fmt.Errorf("isolation not verified, expected permission denied: %w", err)
+	}
+}
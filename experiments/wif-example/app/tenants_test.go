@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/credconfig"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/telemetry"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+func tenantCredentials(project string) credconfig.Config {
+	return credconfig.Config{
+		Type:             credconfig.TypeExternalAccount,
+		Audience:         testProvider,
+		SubjectTokenType: credconfig.TokenTypeJWT,
+		CredentialSource: credconfig.CredentialSource{File: "/var/run/secrets/tenants/" + project + "/token"},
+		QuotaProjectID:   project,
+	}
+}
+
+func TestLoadTenants(t *testing.T) {
+	dir := t.TempDir()
+	writeCredentials(t, filepath.Join(dir, "tenant-a.json"), tenantCredentials("project-a"))
+	writeCredentials(t, filepath.Join(dir, "tenant-b.json"), tenantCredentials("project-b"))
+	shared := tenantCredentials("")
+	writeCredentials(t, filepath.Join(dir, "tenant-c.json"), shared)
+
+	cfg := &Config{TenantsDir: dir, ProjectID: "project-a", Interval: 30 * time.Second}
+	tenants, err := loadTenants(context.Background(), cfg, nil, telemetry.NewMetrics())
+	if err != nil {
+		t.Fatalf("loadTenants: %v", err)
+	}
+
+	var got []string
+	for _, tn := range tenants {
+		others := make([]string, len(tn.others))
+		for i, o := range tn.others {
+			others[i] = o.name
+		}
+		got = append(got, tn.name+"="+tn.cfg.ProjectID+"!"+strings.Join(others, "+"))
+	}
+	// tenant-c falls back to GCP_PROJECT_ID and so shares project-a with tenant-a
+	want := "tenant-a=project-a!tenant-b tenant-b=project-b!tenant-a+tenant-c tenant-c=project-a!tenant-b"
+	if strings.Join(got, " ") != want {
+		t.Errorf("tenants = %s, want %s", strings.Join(got, " "), want)
+	}
+	if tenants[1].cfg.TokenFile != "/var/run/secrets/tenants/project-b/token" {
+		t.Errorf("tenant-b token file = %s", tenants[1].cfg.TokenFile)
+	}
+	if cfg.ProjectID != "project-a" || cfg.TokenFile != "" {
+		t.Error("loadTenants modified the shared configuration")
+	}
+
+	if _, err := loadTenants(context.Background(), &Config{TenantsDir: t.TempDir()}, nil, telemetry.NewMetrics()); err == nil {
+		t.Error("loadTenants of an empty directory succeeded")
+	}
+	cfg.ProjectID = ""
+	if _, err := loadTenants(context.Background(), cfg, nil, telemetry.NewMetrics()); err == nil || !strings.Contains(err.Error(), "tenant-c") {
+		t.Errorf("loadTenants without a project for tenant-c = %v", err)
+	}
+}
+
+func TestTenantProbe(t *testing.T) {
+	a := &tenant{name: "tenant-a", cfg: &Config{ProjectID: "project-a"}}
+	b := &tenant{name: "tenant-b", cfg: &Config{ProjectID: "project-b"}}
+
+	tests := []struct {
+		name    string
+		err     error
+		breach  bool
+		success bool
+	}{
+		{name: "denied", err: &googleapi.Error{Code: 403, Message: "Required 'compute.zones.list' permission for 'projects/project-b'"}, success: true},
+		{name: "allowed", breach: true},
+		{name: "api disabled", err: errors.New("Compute Engine API has not been used in project project-b")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var project string
+			check := Check{Name: "compute", ProjectScoped: true, Run: func(ctx context.Context, cfg *Config, opts ...option.ClientOption) error {
+				project = cfg.ProjectID
+				return tt.err
+			}}
+			err := a.probe(context.Background(), check, b)
+			if project != "project-b" {
+				t.Errorf("probe ran against %q, want project-b", project)
+			}
+			if (err == nil) != tt.success || errors.Is(err, errIsolationBreach) != tt.breach {
+				t.Errorf("probe = %v", err)
+			}
+		})
+	}
+	if a.cfg.ProjectID != "project-a" {
+		t.Error("probe modified the tenant's configuration")
+	}
+}
+
+func TestStatus_Tenants(t *testing.T) {
+	s := NewStatus(&Config{TenantsDir: "/tenants", Interval: 30 * time.Second}, nil)
+	h := s.Handler()
+
+	s.RecordTenantCycle("tenant-a", "project-a", []CheckResult{{Name: "compute"}, {Name: "compute@tenant-b"}})
+	s.RecordTenantCycle("tenant-b", "project-b", []CheckResult{{Name: "compute"}, {Name: "compute@tenant-a"}})
+	if code, _ := get(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("healthz with isolated tenants: %d, want 200", code)
+	}
+
+	s.RecordTenantCycle("tenant-b", "project-b", []CheckResult{{Name: "compute"}, {Name: "compute@tenant-a", Err: errIsolationBreach}})
+	code, body := get(t, h, "/healthz")
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, "tenant tenant-b: check compute@tenant-a failed") {
+		t.Errorf("healthz after breach: %d %q", code, body)
+	}
+	if _, body := get(t, h, "/metrics"); !strings.Contains(body, `wif_tenant_check_success{check="compute@tenant-a",tenant="tenant-b"} 0`) {
+		t.Errorf("metrics lack the failed probe:\n%s", body)
+	}
+	if _, body := get(t, h, "/status"); !strings.Contains(body, `"projectID": "project-b"`) {
+		t.Errorf("status lacks the tenants:\n%s", body)
+	}
+}
@@ -0,0 +1,253 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Defaults for ManagerConfig
+const (
+	DefaultRefreshBefore = 5 * time.Minute
+	DefaultPollInterval  = 10 * time.Second
+	DefaultRetryInterval = 30 * time.Second
+)
+
+// ErrNoTokenFile is returned by Subject when the manager has no token file
+var ErrNoTokenFile = errors.New("no token file, the token source reads its own credentials")
+
+// ManagerConfig configures a Manager
+type ManagerConfig struct {
+	// TokenFile is the projected service account token kept fresh by the
+	// token-minter. It may be empty when Source does not exchange a token
+	// file, e.g. a chain of credential sources.
+	TokenFile string
+	// Source exchanges the subject token for an access token. It must not
+	// cache, since the manager decides when to refresh.
+	Source oauth2.TokenSource
+	// RefreshBefore is how long before expiry the access token is refreshed
+	RefreshBefore time.Duration
+	// PollInterval is how often the token file is checked for changes
+	PollInterval time.Duration
+	// RetryInterval is the delay before retrying a failed refresh
+	RetryInterval time.Duration
+}
+
+// Stats describe the refresh activity of a Manager
+type Stats struct {
+	Refreshes       int       `json:"refreshes"`
+	RefreshFailures int       `json:"refreshFailures"`
+	LastRefresh     time.Time `json:"lastRefresh,omitzero"`
+	LastError       string    `json:"lastError,omitempty"`
+	// AccessTokenExpiry is the expiry of the cached access token
+	AccessTokenExpiry time.Time `json:"accessTokenExpiry,omitzero"`
+	NextRefresh       time.Time `json:"nextRefresh,omitzero"`
+	// FileReloads counts the changes of the token file seen so far
+	FileReloads int `json:"fileReloads"`
+}
+
+// fileStamp identifies one version of the token file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Manager consumes a minted token the way a long-running operator should:
+// it reloads the token file only when it changes, caches the exchanged access
+// token and refreshes it shortly before it expires, instead of exchanging on
+// a fixed schedule. Manager implements oauth2.TokenSource and is safe for
+// concurrent use.
+type Manager struct {
+	cfg ManagerConfig
+	now func() time.Time
+
+	mu         sync.Mutex
+	stamp      fileStamp
+	claims     *Claims
+	claimsErr  error
+	access     *oauth2.Token
+	refreshErr error
+	stats      Stats
+}
+
+// NewManager returns a manager for cfg. The token file does not need to exist
+// yet: the token-minter may still be writing it.
+func NewManager(cfg ManagerConfig) (*Manager, error) {
+	if cfg.Source == nil {
+		return nil, fmt.Errorf("token source is required")
+	}
+	if cfg.RefreshBefore <= 0 {
+		cfg.RefreshBefore = DefaultRefreshBefore
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultPollInterval
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultRetryInterval
+	}
+
+	m := &Manager{cfg: cfg, now: time.Now}
+	m.claimsErr = fmt.Errorf("token file %s not loaded yet", cfg.TokenFile)
+	if cfg.TokenFile == "" {
+		m.claimsErr = ErrNoTokenFile
+	}
+	return m, nil
+}
+
+// Subject returns the claims of the current token file, reloading it first
+// if it changed
+func (m *Manager) Subject() (*Claims, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reloadLocked()
+	return m.claims, m.claimsErr
+}
+
+// Token returns the cached access token, refreshing it first if it is
+// within RefreshBefore of expiry
+func (m *Manager) Token() (*oauth2.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reloadLocked()
+	if m.access != nil && !m.refreshDueLocked() {
+		return m.access, nil
+	}
+	if err := m.refreshLocked(); err != nil {
+		// Keep serving the old token while it is still valid
+		if m.access != nil && (m.access.Expiry.IsZero() || m.now().Before(m.access.Expiry)) {
+			return m.access, nil
+		}
+		return nil, err
+	}
+	return m.access, nil
+}
+
+// Stats returns a snapshot of the refresh activity
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// Run watches the token file and refreshes the access token ahead of expiry
+// until ctx is done. The file is polled rather than watched with inotify,
+// since Kubernetes volumes replace files through symlink swaps.
+func (m *Manager) Run(ctx context.Context) error {
+	for {
+		m.mu.Lock()
+		m.reloadLocked()
+		if m.refreshDueLocked() {
+			m.refreshLocked()
+		}
+		wait := m.cfg.PollInterval
+		if until := m.stats.NextRefresh.Sub(m.now()); until < wait {
+			wait = until
+		}
+		m.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reloadLocked parses the token file again if it changed since the last load
+func (m *Manager) reloadLocked() {
+	if m.cfg.TokenFile == "" {
+		return
+	}
+	info, err := os.Stat(m.cfg.TokenFile)
+	if err != nil {
+		m.claims, m.claimsErr = nil, fmt.Errorf("failed to read token file %s: %w", m.cfg.TokenFile, err)
+		m.stamp = fileStamp{}
+		return
+	}
+
+	stamp := fileStamp{modTime: info.ModTime(), size: info.Size()}
+	if stamp == m.stamp {
+		return
+	}
+
+	data, err := os.ReadFile(m.cfg.TokenFile)
+	if err != nil {
+		m.claims, m.claimsErr = nil, fmt.Errorf("failed to read token file %s: %w", m.cfg.TokenFile, err)
+		return
+	}
+	m.stamp = stamp
+	m.stats.FileReloads++
+	m.claims, m.claimsErr = Parse(string(data))
+
+	// A new subject token may fix a failed exchange, retry right away
+	if m.refreshErr != nil {
+		m.stats.NextRefresh = m.now()
+	}
+}
+
+func (m *Manager) refreshDueLocked() bool {
+	return m.access == nil || !m.now().Before(m.stats.NextRefresh)
+}
+
+// refreshLocked exchanges the subject token for a new access token and
+// schedules the next refresh
+func (m *Manager) refreshLocked() error {
+	now := m.now()
+
+	if m.claims != nil {
+		if err := m.claims.Validate(now, 0); errors.Is(err, ErrExpired) {
+			// Exchanging an expired token is bound to fail, wait for the token-minter
+			m.failLocked(now, err)
+			return err
+		}
+	}
+
+	tok, err := m.cfg.Source.Token()
+	if err != nil {
+		m.failLocked(now, err)
+		return err
+	}
+
+	m.access = tok
+	m.refreshErr = nil
+	m.stats.Refreshes++
+	m.stats.LastRefresh = now
+	m.stats.LastError = ""
+	m.stats.AccessTokenExpiry = tok.Expiry
+	m.stats.NextRefresh = m.refreshAt(now, tok.Expiry)
+	return nil
+}
+
+func (m *Manager) failLocked(now time.Time, err error) {
+	m.refreshErr = err
+	m.stats.RefreshFailures++
+	m.stats.LastError = err.Error()
+	m.stats.NextRefresh = now.Add(m.cfg.RetryInterval)
+}
+
+// refreshAt returns when a token expiring at expiry should be refreshed.
+// Tokens living shorter than RefreshBefore are refreshed at half their lifetime.
+func (m *Manager) refreshAt(now, expiry time.Time) time.Time {
+	if expiry.IsZero() {
+		// Tokens without expiry are still refreshed daily
+		return now.Add(24 * time.Hour)
+	}
+	if !expiry.After(now) {
+		// Already expired when received: back off instead of spinning
+		return now.Add(m.cfg.RetryInterval)
+	}
+	at := expiry.Add(-m.cfg.RefreshBefore)
+	if !at.After(now) {
+		at = now.Add(expiry.Sub(now) / 2)
+	}
+	return at
+}
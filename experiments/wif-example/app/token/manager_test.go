@@ -0,0 +1,181 @@
+package token
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// fakeSource hands out access tokens valid for lifetime from the manager's clock
+type fakeSource struct {
+	clock    *time.Time
+	lifetime time.Duration
+	calls    int
+	err      error
+}
+
+func (f *fakeSource) Token() (*oauth2.Token, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &oauth2.Token{AccessToken: "access", Expiry: f.clock.Add(f.lifetime)}, nil
+}
+
+func newTestManager(t *testing.T, lifetime time.Duration) (*Manager, *fakeSource, *time.Time, string) {
+	t.Helper()
+	clock := now
+	path := filepath.Join(t.TempDir(), "token")
+	writeTokenFile(t, path, sign(t, serviceAccountClaims()), clock)
+
+	src := &fakeSource{clock: &clock, lifetime: lifetime}
+	m, err := NewManager(ManagerConfig{TokenFile: path, Source: src})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	m.now = func() time.Time { return clock }
+	return m, src, &clock, path
+}
+
+// writeTokenFile writes the token with an explicit mtime, so rewrites within
+// the same filesystem timestamp tick are still noticed
+func writeTokenFile(t *testing.T, path, raw string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatalf("writing token file: %v", err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("setting token file mtime: %v", err)
+	}
+}
+
+func TestManager_CachesUntilRefreshWindow(t *testing.T) {
+	m, src, clock, _ := newTestManager(t, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, err := m.Token(); err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+	}
+	if src.calls != 1 {
+		t.Errorf("source called %d times, want 1", src.calls)
+	}
+	if got, want := m.Stats().NextRefresh, now.Add(time.Hour-DefaultRefreshBefore); !got.Equal(want) {
+		t.Errorf("NextRefresh = %v, want %v", got, want)
+	}
+
+	*clock = now.Add(56 * time.Minute)
+	if _, err := m.Token(); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if src.calls != 2 {
+		t.Errorf("source called %d times within the refresh window, want 2", src.calls)
+	}
+	if stats := m.Stats(); stats.Refreshes != 2 || !stats.LastRefresh.Equal(*clock) {
+		t.Errorf("stats = %+v, want 2 refreshes, the last at %v", stats, *clock)
+	}
+}
+
+func TestManager_ShortLivedToken(t *testing.T) {
+	m, _, _, _ := newTestManager(t, 4*time.Minute)
+
+	if _, err := m.Token(); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if got, want := m.Stats().NextRefresh, now.Add(2*time.Minute); !got.Equal(want) {
+		t.Errorf("NextRefresh = %v, want half the lifetime (%v)", got, want)
+	}
+}
+
+func TestManager_FailedRefresh(t *testing.T) {
+	m, src, clock, _ := newTestManager(t, time.Hour)
+	if _, err := m.Token(); err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	// Inside the refresh window the old token is still served on failure
+	src.err = errors.New("sts unavailable")
+	*clock = now.Add(56 * time.Minute)
+	if tok, err := m.Token(); err != nil || tok.AccessToken != "access" {
+		t.Errorf("Token() = %v, %v, want the cached token", tok, err)
+	}
+	stats := m.Stats()
+	if stats.RefreshFailures != 1 || stats.LastError != "sts unavailable" || !stats.NextRefresh.Equal(clock.Add(DefaultRetryInterval)) {
+		t.Errorf("stats = %+v, want 1 failure and a retry", stats)
+	}
+
+	// Once it expired the error surfaces
+	*clock = now.Add(2 * time.Hour)
+	if _, err := m.Token(); err == nil {
+		t.Errorf("Token() error = nil after the cached token expired")
+	}
+}
+
+func TestManager_ReloadsChangedFile(t *testing.T) {
+	m, src, clock, path := newTestManager(t, time.Hour)
+
+	claims, err := m.Subject()
+	if err != nil || claims.Subject != "system:serviceaccount:default:wif-app-workload-sa" {
+		t.Fatalf("Subject() = %v, %v", claims, err)
+	}
+
+	// The token-minter let the subject token expire: exchanging is skipped
+	*clock = now.Add(2 * time.Hour)
+	if _, err := m.Token(); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Token() error = %v, want ErrExpired", err)
+	}
+	if src.calls != 0 {
+		t.Errorf("source called %d times with an expired subject token", src.calls)
+	}
+
+	// A fresh token is picked up and exchanged without waiting for the retry
+	c := serviceAccountClaims()
+	c["sub"] = "system:serviceaccount:default:rotated"
+	c["exp"] = clock.Add(time.Hour).Unix()
+	writeTokenFile(t, path, sign(t, jwt.MapClaims(c)), *clock)
+
+	if _, err := m.Token(); err != nil {
+		t.Fatalf("Token() after rotation error = %v", err)
+	}
+	if claims, _ := m.Subject(); claims.Subject != "system:serviceaccount:default:rotated" {
+		t.Errorf("Subject() = %q, want the rotated token", claims.Subject)
+	}
+	if stats := m.Stats(); stats.FileReloads != 2 || stats.Refreshes != 1 {
+		t.Errorf("stats = %+v, want 2 reloads and 1 refresh", stats)
+	}
+}
+
+func TestManager_MissingFile(t *testing.T) {
+	m, err := NewManager(ManagerConfig{TokenFile: filepath.Join(t.TempDir(), "missing"), Source: &fakeSource{clock: &now}})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if _, err := m.Subject(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Subject() error = %v, want a missing file error", err)
+	}
+}
+
+func TestManager_NoTokenFile(t *testing.T) {
+	clock := now
+	src := &fakeSource{clock: &clock, lifetime: time.Hour}
+	m, err := NewManager(ManagerConfig{Source: src})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	m.now = func() time.Time { return clock }
+
+	if _, err := m.Subject(); !errors.Is(err, ErrNoTokenFile) {
+		t.Errorf("Subject() error = %v, want ErrNoTokenFile", err)
+	}
+	if _, err := m.Token(); err != nil || src.calls != 1 {
+		t.Errorf("Token() error = %v after %d calls, want one refresh", err, src.calls)
+	}
+	if stats := m.Stats(); stats.FileReloads != 0 {
+		t.Errorf("FileReloads = %d without a token file", stats.FileReloads)
+	}
+}
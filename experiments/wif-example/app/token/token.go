@@ -0,0 +1,147 @@
+// Package token inspects the projected service account tokens exchanged with
+// GCP Workload Identity Federation. Signatures are not verified here: GCP
+// verifies them against the provider's JWKS during the exchange. The package
+// only decodes the claims so the app can report and sanity-check them.
+package token
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrMalformed is returned for input that is not a decodable JWT
+	ErrMalformed = errors.New("malformed token")
+	// ErrExpired is returned by Validate once exp has passed
+	ErrExpired = errors.New("token expired")
+	// ErrNotYetValid is returned by Validate before nbf
+	ErrNotYetValid = errors.New("token not yet valid")
+	// ErrMissingExpiry is returned by Validate for tokens without exp
+	ErrMissingExpiry = errors.New("token has no exp claim")
+)
+
+// Claims are the claims of a Kubernetes service account token relevant to federation
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+
+	// Namespace and ServiceAccount come from the kubernetes.io claim, when present
+	Namespace      string
+	ServiceAccount string
+
+	// Algorithm and KeyID come from the JOSE header
+	Algorithm string
+	KeyID     string
+}
+
+// kubernetesClaims mirrors the private claims the Kubernetes token issuer adds
+type kubernetesClaims struct {
+	jwt.RegisteredClaims
+	Kubernetes *struct {
+		Namespace      string `json:"namespace"`
+		ServiceAccount struct {
+			Name string `json:"name"`
+		} `json:"serviceaccount"`
+	} `json:"kubernetes.io,omitempty"`
+}
+
+// Parse decodes a JWT without verifying its signature or validating its
+// claims. Surrounding whitespace, as left by files, is ignored.
+func Parse(raw string) (*Claims, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, fmt.Errorf("%w: empty", ErrMalformed)
+	}
+
+	var kc kubernetesClaims
+	tok, _, err := jwt.NewParser().ParseUnverified(raw, &kc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	c := &Claims{
+		Issuer:   kc.Issuer,
+		Subject:  kc.Subject,
+		Audience: kc.Audience,
+	}
+	if kc.ExpiresAt != nil {
+		c.ExpiresAt = kc.ExpiresAt.Time
+	}
+	if kc.NotBefore != nil {
+		c.NotBefore = kc.NotBefore.Time
+	}
+	if kc.IssuedAt != nil {
+		c.IssuedAt = kc.IssuedAt.Time
+	}
+	if kc.Kubernetes != nil {
+		c.Namespace = kc.Kubernetes.Namespace
+		c.ServiceAccount = kc.Kubernetes.ServiceAccount.Name
+	}
+	c.Algorithm, _ = tok.Header["alg"].(string)
+	c.KeyID, _ = tok.Header["kid"].(string)
+	return c, nil
+}
+
+// Validate checks exp and nbf against now, allowing leeway for clock skew
+func (c *Claims) Validate(now time.Time, leeway time.Duration) error {
+	if c.ExpiresAt.IsZero() {
+		return ErrMissingExpiry
+	}
+	if !now.Before(c.ExpiresAt.Add(leeway)) {
+		return fmt.Errorf("%w at %s", ErrExpired, c.ExpiresAt.Format(time.RFC3339))
+	}
+	if !c.NotBefore.IsZero() && now.Add(leeway).Before(c.NotBefore) {
+		return fmt.Errorf("%w until %s", ErrNotYetValid, c.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// HasAudience reports whether aud is one of the token's audiences
+func (c *Claims) HasAudience(aud string) bool {
+	for _, a := range c.Audience {
+		if a == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiresIn returns the time left until exp, negative once expired
+func (c *Claims) ExpiresIn(now time.Time) time.Duration {
+	return c.ExpiresAt.Sub(now)
+}
+
+// LogValue implements slog.LogValuer so the claims can be logged as a group.
+// Only identifying metadata is included, never the token itself.
+func (c *Claims) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("iss", c.Issuer),
+		slog.String("sub", c.Subject),
+		slog.Any("aud", c.Audience),
+	}
+	if !c.ExpiresAt.IsZero() {
+		attrs = append(attrs, slog.Time("exp", c.ExpiresAt))
+	}
+	if !c.NotBefore.IsZero() {
+		attrs = append(attrs, slog.Time("nbf", c.NotBefore))
+	}
+	if !c.IssuedAt.IsZero() {
+		attrs = append(attrs, slog.Time("iat", c.IssuedAt))
+	}
+	if c.Namespace != "" {
+		attrs = append(attrs, slog.String("namespace", c.Namespace), slog.String("serviceAccount", c.ServiceAccount))
+	}
+	if c.KeyID != "" {
+		attrs = append(attrs, slog.String("kid", c.KeyID))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,163 @@
+package token
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var now = time.Date(2025, 11, 9, 12, 0, 0, 0, time.UTC)
+
+// sign builds a token the way the Kubernetes token issuer does. The key is
+// irrelevant since Parse does not verify signatures.
+func sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tok.Header["kid"] = "test-key"
+	raw, err := tok.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return raw
+}
+
+func serviceAccountClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": "https://hypershift-test-oidc",
+		"sub": "system:serviceaccount:default:wif-app-workload-sa",
+		"aud": []string{"openshift"},
+		"exp": now.Add(time.Hour).Unix(),
+		"nbf": now.Add(-time.Minute).Unix(),
+		"iat": now.Add(-time.Minute).Unix(),
+		"kubernetes.io": map[string]any{
+			"namespace":      "default",
+			"serviceaccount": map[string]any{"name": "wif-app-workload-sa", "uid": "1234"},
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	claims, err := Parse(sign(t, serviceAccountClaims()) + "\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if claims.Issuer != "https://hypershift-test-oidc" || claims.Subject != "system:serviceaccount:default:wif-app-workload-sa" {
+		t.Errorf("iss/sub = %q/%q", claims.Issuer, claims.Subject)
+	}
+	if !claims.ExpiresAt.Equal(now.Add(time.Hour)) || !claims.NotBefore.Equal(now.Add(-time.Minute)) {
+		t.Errorf("exp/nbf = %v/%v", claims.ExpiresAt, claims.NotBefore)
+	}
+	if claims.Namespace != "default" || claims.ServiceAccount != "wif-app-workload-sa" {
+		t.Errorf("kubernetes.io = %q/%q, want default/wif-app-workload-sa", claims.Namespace, claims.ServiceAccount)
+	}
+	if claims.Algorithm != "HS256" || claims.KeyID != "test-key" {
+		t.Errorf("header alg/kid = %q/%q", claims.Algorithm, claims.KeyID)
+	}
+	if !claims.HasAudience("openshift") || claims.HasAudience("sts.googleapis.com") {
+		t.Errorf("HasAudience() wrong for aud %v", claims.Audience)
+	}
+}
+
+func TestParse_SingleStringAudience(t *testing.T) {
+	c := serviceAccountClaims()
+	c["aud"] = "openshift"
+
+	claims, err := Parse(sign(t, c))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !claims.HasAudience("openshift") {
+		t.Errorf("aud = %v, want [openshift]", claims.Audience)
+	}
+}
+
+func TestParse_Malformed(t *testing.T) {
+	valid := sign(t, serviceAccountClaims())
+	parts := strings.Split(valid, ".")
+	segment := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := map[string]string{
+		"empty":              "",
+		"whitespace":         " \n",
+		"not a jwt":          "not-a-token",
+		"two segments":       parts[0] + "." + parts[1],
+		"four segments":      valid + ".extra",
+		"payload not base64": parts[0] + ".!!!." + parts[2],
+		"payload not json":   parts[0] + "." + segment("not json") + "." + parts[2],
+		"header not json":    segment("{") + "." + parts[1] + "." + parts[2],
+		"exp not a number":   parts[0] + "." + segment(`{"exp":"tomorrow"}`) + "." + parts[2],
+	}
+
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(raw); !errors.Is(err, ErrMalformed) {
+				t.Errorf("Parse() error = %v, want ErrMalformed", err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(jwt.MapClaims)
+		leeway time.Duration
+		want   error
+	}{
+		{name: "valid"},
+		{name: "expired", mutate: func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }, want: ErrExpired},
+		{name: "expired within leeway", mutate: func(c jwt.MapClaims) { c["exp"] = now.Add(-10 * time.Second).Unix() }, leeway: 30 * time.Second},
+		{name: "not yet valid", mutate: func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Minute).Unix() }, want: ErrNotYetValid},
+		{name: "nbf within leeway", mutate: func(c jwt.MapClaims) { c["nbf"] = now.Add(10 * time.Second).Unix() }, leeway: 30 * time.Second},
+		{name: "no exp", mutate: func(c jwt.MapClaims) { delete(c, "exp") }, want: ErrMissingExpiry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := serviceAccountClaims()
+			if tt.mutate != nil {
+				tt.mutate(c)
+			}
+			claims, err := Parse(sign(t, c))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			err = claims.Validate(now, tt.leeway)
+			if tt.want == nil && err != nil {
+				t.Errorf("Validate() error = %v, want nil", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Validate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogValue(t *testing.T) {
+	raw := sign(t, serviceAccountClaims())
+	claims, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("Token metadata", "token", claims)
+	out := buf.String()
+
+	for _, want := range []string{`"sub":"system:serviceaccount:default:wif-app-workload-sa"`, `"aud":["openshift"]`, `"namespace":"default"`, `"exp":"2025-11-09T13:00:00Z"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output %s does not contain %s", out, want)
+		}
+	}
+	if strings.Contains(out, strings.Split(raw, ".")[2]) {
+		t.Errorf("log output contains the token signature")
+	}
+}
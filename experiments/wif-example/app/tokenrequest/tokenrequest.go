@@ -0,0 +1,219 @@
+// Package tokenrequest mints service account tokens with the Kubernetes
+// TokenRequest API, like the token-minter sidecar does, but in-process. The
+// token is written to a file so the rest of the federation flow, including the
+// external-account credential configuration, reads it unchanged.
+package tokenrequest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Defaults for Config
+const (
+	DefaultExpiration    = time.Hour
+	DefaultRetryInterval = 30 * time.Second
+	// MinExpiration is the shortest expiration the API server accepts
+	MinExpiration = 10 * time.Minute
+)
+
+// refreshFraction is how far into its lifetime a token is replaced, the same
+// as the kubelet uses for projected service account tokens
+const refreshFraction = 0.8
+
+// Config describes the tokens to mint
+type Config struct {
+	// Kubeconfig is the kubeconfig of the cluster issuing the tokens, the
+	// in-cluster configuration if empty
+	Kubeconfig string
+	// ServiceAccount is "namespace/name" of the service account to mint for
+	ServiceAccount string
+	// Audience is the audience of the minted tokens, the one the workload
+	// identity provider allows
+	Audience   string
+	Expiration time.Duration
+	// TokenFile is where the token is written
+	TokenFile string
+	// RetryInterval is the delay before retrying a failed request
+	RetryInterval time.Duration
+}
+
+// Validate checks the configuration without contacting the API server
+func (c Config) Validate() error {
+	if _, _, err := c.serviceAccount(); err != nil {
+		return err
+	}
+	if c.Audience == "" {
+		return fmt.Errorf("audience is required for the TokenRequest API")
+	}
+	if c.Expiration != 0 && c.Expiration < MinExpiration {
+		return fmt.Errorf("token expiration %v must be at least %v", c.Expiration, MinExpiration)
+	}
+	if c.TokenFile == "" {
+		return fmt.Errorf("token file is required for the TokenRequest API")
+	}
+	return nil
+}
+
+func (c Config) serviceAccount() (namespace, name string, err error) {
+	namespace, name, ok := strings.Cut(c.ServiceAccount, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("service account %q must be namespace/name", c.ServiceAccount)
+	}
+	return namespace, name, nil
+}
+
+// Stats describe the requests of a Minter
+type Stats struct {
+	Mints    int       `json:"mints"`
+	Failures int       `json:"failures"`
+	LastMint time.Time `json:"lastMint,omitzero"`
+	// LastLatency is how long the last successful TokenRequest took
+	LastLatency time.Duration `json:"lastLatency"`
+	LastError   string        `json:"lastError,omitempty"`
+	ExpiresAt   time.Time     `json:"expiresAt,omitzero"`
+	NextMint    time.Time     `json:"nextMint,omitzero"`
+}
+
+// Minter keeps a token file fresh with the TokenRequest API. It is safe for
+// concurrent use.
+type Minter struct {
+	cfg       Config
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	now       func() time.Time
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// NewMinter returns a minter talking to the cluster of cfg.Kubeconfig
+func NewMinter(cfg Config) (*Minter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	restConfig, err := clientcmd.BuildConfigFromFlags("", cfg.Kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return newMinter(cfg, client)
+}
+
+func newMinter(cfg Config, client kubernetes.Interface) (*Minter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Expiration == 0 {
+		cfg.Expiration = DefaultExpiration
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultRetryInterval
+	}
+	namespace, name, _ := cfg.serviceAccount()
+	return &Minter{cfg: cfg, client: client, namespace: namespace, name: name, now: time.Now}, nil
+}
+
+// Mint requests a new token and writes it to the token file
+func (m *Minter) Mint(ctx context.Context) error {
+	seconds := int64(m.cfg.Expiration.Seconds())
+	start := m.now()
+	resp, err := m.client.CoreV1().ServiceAccounts(m.namespace).CreateToken(ctx, m.name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         []string{m.cfg.Audience},
+			ExpirationSeconds: &seconds,
+		},
+	}, metav1.CreateOptions{})
+	latency := m.now().Sub(start)
+	if err == nil {
+		err = writeFile(m.cfg.TokenFile, resp.Status.Token)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if err != nil {
+		err = fmt.Errorf("failed to mint a token for %s: %w", m.cfg.ServiceAccount, err)
+		m.stats.Failures++
+		m.stats.LastError = err.Error()
+		m.stats.NextMint = now.Add(m.cfg.RetryInterval)
+		return err
+	}
+
+	expiresAt := resp.Status.ExpirationTimestamp.Time
+	m.stats.Mints++
+	m.stats.LastMint = now
+	m.stats.LastLatency = latency
+	m.stats.LastError = ""
+	m.stats.ExpiresAt = expiresAt
+	m.stats.NextMint = now.Add(time.Duration(float64(expiresAt.Sub(now)) * refreshFraction))
+	if !m.stats.NextMint.After(now) {
+		m.stats.NextMint = now.Add(m.cfg.RetryInterval)
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the requests made so far
+func (m *Minter) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// Run mints a new token at 80% of the previous one's lifetime, or after
+// RetryInterval when minting failed, until ctx is done. report, if not nil,
+// is called after every request with its error.
+func (m *Minter) Run(ctx context.Context, report func(Stats, error)) error {
+	for {
+		wait := m.Stats().NextMint.Sub(m.now())
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := m.Mint(ctx)
+		if report != nil {
+			report(m.Stats(), err)
+		}
+	}
+}
+
+// writeFile replaces path atomically, so readers never see a partial token
+func writeFile(path, token string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(token); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,126 @@
+package tokenrequest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var now = time.Date(2025, 11, 9, 12, 0, 0, 0, time.UTC)
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{ServiceAccount: "default/wif-app-workload-sa", Audience: "openshift", TokenFile: "/tmp/token"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(c *Config)
+		want string
+	}{
+		{"no namespace", func(c *Config) { c.ServiceAccount = "wif-app-workload-sa" }, "namespace/name"},
+		{"empty name", func(c *Config) { c.ServiceAccount = "default/" }, "namespace/name"},
+		{"no audience", func(c *Config) { c.Audience = "" }, "audience"},
+		{"short expiration", func(c *Config) { c.Expiration = time.Minute }, "at least 10m"},
+		{"no token file", func(c *Config) { c.TokenFile = "" }, "token file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.edit(&c)
+			if err := c.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate = %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
+
+// fakeClient answers TokenRequests with a token named after the request count
+func fakeClient(t *testing.T, requests *[]*authenticationv1.TokenRequest, fail *error) *fake.Clientset {
+	t.Helper()
+	client := fake.NewClientset()
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create, ok := action.(k8stesting.CreateAction)
+		if !ok || action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		if *fail != nil {
+			return true, nil, *fail
+		}
+		req := create.GetObject().(*authenticationv1.TokenRequest)
+		*requests = append(*requests, req)
+		if create.GetNamespace() != "default" {
+			t.Errorf("TokenRequest in namespace %s, want default", create.GetNamespace())
+		}
+		return true, &authenticationv1.TokenRequest{
+			Status: authenticationv1.TokenRequestStatus{
+				Token:               "token-" + string(rune('0'+len(*requests))),
+				ExpirationTimestamp: metav1.NewTime(now.Add(time.Duration(*req.Spec.ExpirationSeconds) * time.Second)),
+			},
+		}, nil
+	})
+	return client
+}
+
+func TestMint(t *testing.T) {
+	var requests []*authenticationv1.TokenRequest
+	var fail error
+	path := filepath.Join(t.TempDir(), "token")
+
+	m, err := newMinter(Config{
+		ServiceAccount: "default/wif-app-workload-sa",
+		Audience:       "openshift",
+		Expiration:     time.Hour,
+		TokenFile:      path,
+	}, fakeClient(t, &requests, &fail))
+	if err != nil {
+		t.Fatalf("newMinter: %v", err)
+	}
+	m.now = func() time.Time { return now }
+
+	if err := m.Mint(context.Background()); err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("%d TokenRequests, want 1", len(requests))
+	}
+	spec := requests[0].Spec
+	if len(spec.Audiences) != 1 || spec.Audiences[0] != "openshift" || *spec.ExpirationSeconds != 3600 {
+		t.Errorf("TokenRequest spec = %+v", spec)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "token-1" {
+		t.Errorf("token file = %q, want token-1", data)
+	}
+	stats := m.Stats()
+	if stats.Mints != 1 || !stats.ExpiresAt.Equal(now.Add(time.Hour)) || !stats.NextMint.Equal(now.Add(48*time.Minute)) {
+		t.Errorf("stats after mint = %+v", stats)
+	}
+
+	fail = errors.New(`serviceaccounts "wif-app-workload-sa" is forbidden`)
+	if err := m.Mint(context.Background()); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("Mint with a failing API = %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "token-1" {
+		t.Errorf("token file after a failure = %q, want the previous token", data)
+	}
+	stats = m.Stats()
+	if stats.Failures != 1 || stats.LastError == "" || !stats.NextMint.Equal(now.Add(DefaultRetryInterval)) {
+		t.Errorf("stats after failure = %+v", stats)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("token directory holds %d files, want only the token", len(entries))
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/diagnose"
+	"github.com/openshift-online/gcp-hcp/experiments/wif-example/issuer"
+	"google.golang.org/api/option"
+)
+
+// issuerFetchTimeout bounds each discovery document and JWKS fetch
+const issuerFetchTimeout = 10 * time.Second
+
+// runIssuerVerification checks the current token against its issuer and the
+// workload identity provider once, without exchanging it. It returns an
+// error if the STS is going to reject the token.
+func runIssuerVerification(ctx context.Context, cfg *Config) error {
+	logger := component("issuer")
+	logger.Info("Verifying the token against its issuer and the workload identity provider")
+
+	raw, err := os.ReadFile(cfg.TokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read token file %s: %w", cfg.TokenFile, err)
+	}
+	in := issuer.Input{Token: string(raw)}
+
+	in.ProviderName, err = providerAudience(cfg)
+	if err != nil {
+		logger.Warn("Could not determine the workload identity provider, its audiences are not checked", errorAttr(err))
+	}
+	switch {
+	case cfg.ProviderConfigFile != "":
+		if in.Provider, err = issuer.LoadProvider(cfg.ProviderConfigFile); err != nil {
+			return err
+		}
+	case in.ProviderName != "":
+		// Reading the provider takes iam.workloadIdentityPoolProviders.get,
+		// which the federated identity seldom holds
+		if in.Provider, err = readProvider(ctx, cfg, in.ProviderName); err != nil {
+			logger.Warn("Could not read the provider configuration, checking against the issuer only; set WIF_PROVIDER_CONFIG to the output of 'gcloud iam workload-identity-pools providers describe --format=json'",
+				"provider", in.ProviderName, errorAttr(err))
+		}
+	}
+	if cfg.JWKSFile != "" {
+		if in.JWKS, err = issuer.LoadJWKS(cfg.JWKSFile); err != nil {
+			return err
+		}
+	}
+	if in.Provider != nil {
+		logger.Info("Provider configuration",
+			"provider", in.Provider.Name,
+			"issuerURI", in.Provider.IssuerURI,
+			"audiences", in.Provider.Audiences(),
+			"uploadedJWKS", in.Provider.JWKS != nil)
+	}
+
+	v := &issuer.Verifier{Client: &http.Client{Timeout: issuerFetchTimeout}, Leeway: tokenClockSkew}
+	report, err := v.Verify(ctx, in)
+	if err != nil {
+		return fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	logger.Info("Token", "token", report.Claims, "flavor", report.Flavor)
+	if report.Discovery != nil {
+		logger.Info("Issuer discovery document", "issuer", report.Discovery.Issuer, "jwksURI", report.Discovery.JWKSURI)
+	}
+	if report.DiscoveryErr != nil {
+		logger.Warn("Could not fetch the issuer's keys", "issuer", report.Claims.Issuer, "reason", report.DiscoveryErr.Error())
+	}
+	if report.Verified {
+		logger.Info("Token signature verified", "keys", report.KeySource, "kid", report.Claims.KeyID)
+	} else if report.KeySource == "" {
+		logger.Warn("Token signature not verified, neither the issuer's nor uploaded keys are available; set JWKS_FILE to the cluster's JWKS")
+	}
+
+	for _, f := range report.Findings {
+		logger.Error("The STS is going to reject the token", findingAttr(f))
+	}
+	logger.Info("Issuer verification complete", "mismatches", len(report.Findings))
+	if !report.OK() {
+		return fmt.Errorf("found %d problems with the token, see the findings above", len(report.Findings))
+	}
+	return nil
+}
+
+// readProvider reads the provider's configuration with the federated identity
+func readProvider(ctx context.Context, cfg *Config, name string) (*issuer.Provider, error) {
+	ts, _, err := newTokenSource(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return issuer.GetProvider(ctx, name, option.WithTokenSource(ts))
+}
+
+// findingAttr logs a finding like errorAttr logs a diagnosed error
+func findingAttr(d diagnose.Diagnosis) slog.Attr {
+	return slog.Group("error",
+		slog.String("message", d.Summary),
+		slog.String("kind", string(d.Kind)),
+		slog.String("hint", d.Hint))
+}